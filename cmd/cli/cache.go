@@ -0,0 +1,147 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/runner"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// cacheAdmin talks to the running runner when there is one, so its in-flight
+// tasks stay protected, and otherwise opens the caches directly
+func cacheAdmin() (caches.Admin, error) {
+	log := gologger.WithComponent("cache")
+
+	cfg, err := utils.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := fmt.Sprintf("localhost:%d", cfg.Runner.WebhookPort)
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		return caches.NewClient("http://" + addr), nil
+	}
+
+	log.Debug().Str("addr", addr).Msg("Runner not reachable - administering caches directly")
+	return runner.OpenCacheRegistry()
+}
+
+// ExecuteCacheList prints every cache's usage, or the entries of one cache
+func ExecuteCacheList(name string) error {
+	admin, err := cacheAdmin()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	if name == "" {
+		summaries, err := admin.Summaries(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "CACHE\tENTRIES\tUSAGE\tPINNED\tQUOTA\tHIT RATE")
+		for _, s := range summaries {
+			if s.Error != "" {
+				fmt.Fprintf(w, "%s\t-\t-\t-\t-\t%s\n", s.Name, s.Error)
+				continue
+			}
+			quota := "-"
+			if s.QuotaBytes > 0 {
+				quota = formatBytes(s.QuotaBytes)
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%.0f%%\n",
+				s.Name, s.Entries, formatBytes(s.UsageBytes), formatBytes(s.PinnedBytes), quota, s.HitRate*100)
+		}
+		return nil
+	}
+
+	entries, err := admin.List(ctx, name)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "KEY\tSIZE\tLAST USED\tFLAGS")
+	for _, e := range entries {
+		flags := ""
+		if e.Pinned {
+			flags += "pinned "
+		}
+		if e.InUse {
+			flags += "in-use"
+		}
+		lastUsed := "-"
+		if !e.LastUsed.IsZero() {
+			lastUsed = e.LastUsed.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Key, formatBytes(e.Size), lastUsed, flags)
+	}
+	return nil
+}
+
+// ExecuteCachePin pins or unpins key in cache name
+func ExecuteCachePin(name, key string, pin bool) error {
+	log := gologger.WithComponent("cache")
+
+	admin, err := cacheAdmin()
+	if err != nil {
+		return err
+	}
+	if pin {
+		err = admin.Pin(context.Background(), name, key)
+	} else {
+		err = admin.Unpin(context.Background(), name, key)
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("cache", name).Str("key", key).Bool("pinned", pin).Msg("Cache entry updated")
+	return nil
+}
+
+// ExecuteCachePurge removes the entries of cache name selected by req
+func ExecuteCachePurge(name string, req caches.PurgeRequest) error {
+	log := gologger.WithComponent("cache")
+
+	admin, err := cacheAdmin()
+	if err != nil {
+		return err
+	}
+	result, err := admin.Purge(context.Background(), name, req)
+	if err != nil {
+		return err
+	}
+
+	for _, skipped := range result.Skipped {
+		log.Warn().Str("cache", name).Str("key", skipped.Key).Str("reason", skipped.Reason).Msg("Kept cache entry")
+	}
+	log.Info().
+		Str("cache", name).
+		Int("removed", len(result.Removed)).
+		Str("freed", formatBytes(result.FreedBytes)).
+		Msg("Cache purged")
+	return nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,20 @@
+package cli
+
+import (
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/runner"
+)
+
+// ExecuteRotateDataKey re-encrypts runner-local stores under a fresh data key
+func ExecuteRotateDataKey() error {
+	log := gologger.WithComponent("data_key")
+
+	keyID, err := runner.RotateDataKey()
+	if err != nil {
+		return err
+	}
+
+	log.Info().Str("active_key", keyID).Msg("Data key rotated and stores re-encrypted")
+	return nil
+}
@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// doctorProbeTimeout bounds each connection the doctor makes
+const doctorProbeTimeout = 5 * time.Second
+
+// ErrIPv6Unreachable is returned when some host the runner depends on
+// could not be reached over IPv6
+var ErrIPv6Unreachable = errors.New("some hosts are not reachable over IPv6")
+
+// DoctorCheck is how one host the runner depends on is reached
+type DoctorCheck struct {
+	Name string `json:"name"`
+	*dualstack.Reachability
+	Error string `json:"error,omitempty"`
+}
+
+// IPv6Only reports whether the host could be reached from an IPv6-only
+// network
+func (c DoctorCheck) IPv6Only() bool {
+	return c.Reachability != nil && c.IPv6.Reachable
+}
+
+// ExecuteDoctor checks whether the server, the IPFS gateway and the
+// registry mirrors are reachable over IPv4 and over IPv6, and prints which
+// of them an IPv6-only network could not reach
+func ExecuteDoctor(jsonOutput bool) error {
+	cfg, err := utils.GetConfig()
+	if err != nil {
+		return err
+	}
+
+	targets := [][2]string{
+		{"server", cfg.Runner.ServerURL},
+		{"ipfs gateway", inputs.DefaultIPFSGateway},
+	}
+	for _, mirror := range cfg.Runner.Registry.Mirrors {
+		registry, url, ok := strings.Cut(mirror, "=")
+		if !ok {
+			continue
+		}
+		targets = append(targets, [2]string{"mirror " + registry, url})
+	}
+
+	checks := make([]DoctorCheck, 0, len(targets))
+	for _, target := range targets {
+		check := DoctorCheck{Name: target[0]}
+		check.Reachability, err = dualstack.Probe(context.Background(), target[1], doctorProbeTimeout)
+		if err != nil {
+			check.Error = err.Error()
+		}
+		checks = append(checks, check)
+	}
+
+	unreachable := 0
+	for _, check := range checks {
+		if !check.IPv6Only() {
+			unreachable++
+		}
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(checks); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tTARGET\tIPV4\tIPV6")
+		for i, check := range checks {
+			if check.Reachability == nil {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", check.Name, targets[i][1], "-", check.Error)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", check.Name, check.Target, familyStatus(check.IPv4), familyStatus(check.IPv6))
+		}
+		w.Flush()
+		if unreachable > 0 {
+			fmt.Printf("%d of %d hosts cannot be reached from an IPv6-only network\n", unreachable, len(checks))
+		} else {
+			fmt.Println("every host can be reached from an IPv6-only network")
+		}
+	}
+
+	if unreachable > 0 {
+		return ErrIPv6Unreachable
+	}
+	return nil
+}
+
+// familyStatus describes how a host is reached over one address family
+func familyStatus(f dualstack.Family) string {
+	if f.Reachable {
+		return "ok"
+	}
+	return f.Error
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/theblitlabs/parity-runner/internal/runner"
+)
+
+// DefaultEscrowGateway is the IPFS gateway escrow envelopes are downloaded
+// from to prove them
+const DefaultEscrowGateway = "https://ipfs.io/ipfs/"
+
+// escrowProveTimeout bounds downloading and checking an envelope
+const escrowProveTimeout = 5 * time.Minute
+
+// ExecuteEscrowProve proves the escrowed result of the task with taskID:
+// it downloads the envelope the runner recorded from gateway, checks its
+// signature and prints the bundle hash it commits to. With keyFile, the
+// hex private key of the creator or the escrow holder, the bundle is also
+// decrypted and its hash re-derived.
+func ExecuteEscrowProve(taskID, gateway, keyFile string) error {
+	var key *ecdsa.PrivateKey
+	if keyFile != "" {
+		var err error
+		if key, err = crypto.LoadECDSA(keyFile); err != nil {
+			return fmt.Errorf("failed to load recipient key: %w", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), escrowProveTimeout)
+	defer cancel()
+	proof, err := runner.ProveEscrow(ctx, taskID, gateway, key)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("task %s\n", proof.Record.TaskID)
+	fmt.Printf("  envelope     %s\n", proof.Record.CID)
+	fmt.Printf("  sealed by    %s at %s\n", proof.Runner.Hex(), proof.Record.SealedAt.Format(time.RFC3339))
+	fmt.Printf("  recipients   %s\n", strings.Join(proof.Record.Recipients, ", "))
+	fmt.Printf("  bundle hash  %s\n", proof.BundleHash)
+	if proof.Opened {
+		fmt.Println("  bundle decrypted and its hash re-derived")
+	} else {
+		fmt.Println("  signature verified; pass --key to decrypt the bundle")
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/runner"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// ErrFsckIncomplete is returned when some stores could not be checked
+var ErrFsckIncomplete = errors.New("some stores could not be checked")
+
+// ExecuteFsck checks the runner's caches and stores, repairing indexes and
+// quarantining damaged content, and prints what it found. The runner must be
+// stopped, since it keeps the stores open.
+func ExecuteFsck(jsonOutput bool) error {
+	cfg, err := utils.GetConfig()
+	if err != nil {
+		return err
+	}
+	addr := fmt.Sprintf("localhost:%d", cfg.Runner.WebhookPort)
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("runner is running on %s - stop it first, it checks its stores on every start", addr)
+	}
+
+	report, err := runner.Fsck()
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		if len(report.Findings) > 0 {
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "STORE\tITEM\tPROBLEM\tACTION")
+			for _, f := range report.Findings {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", f.Store, f.Item, f.Problem, f.Action)
+			}
+			w.Flush()
+		}
+		for _, f := range report.Failures {
+			fmt.Printf("could not check %s: %s\n", f.Store, f.Error)
+		}
+		fmt.Printf("checked %d stores: %d problems fixed", len(report.Checked), len(report.Findings))
+		if report.Pruned > 0 {
+			fmt.Printf(", %d expired quarantined items pruned", report.Pruned)
+		}
+		fmt.Println()
+	}
+
+	if len(report.Failures) > 0 {
+		return ErrFsckIncomplete
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/runner"
+	"github.com/theblitlabs/parity-runner/internal/status"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// taskTimeline asks the running runner for the timeline of the task with
+// taskID when there is one, which knows the tasks it runs, and otherwise
+// reads the history directly
+func taskTimeline(taskID string) (*models.TaskTimeline, error) {
+	log := gologger.WithComponent("history")
+
+	cfg, err := utils.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	addr := fmt.Sprintf("localhost:%d", cfg.Runner.WebhookPort)
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		tl, err := status.NewClient("http://"+addr).TaskTimeline(ctx, taskID)
+		if err == nil || errors.Is(err, status.ErrNotFound) {
+			return tl, err
+		}
+		log.Debug().Err(err).Str("addr", addr).Msg("Runner did not answer - reading the history directly")
+	}
+	return runner.TaskTimeline(taskID)
+}
+
+// ExecuteHistoryShow prints the lifecycle timeline of a task: each attempt
+// the runner made at it and the phases it went through
+func ExecuteHistoryShow(taskID string, jsonOutput bool) error {
+	tl, err := taskTimeline(taskID)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tl)
+	}
+
+	fmt.Printf("task %s", tl.TaskID)
+	if tl.QueueWaitMs > 0 {
+		fmt.Printf(", queued %s before its first claim", formatMs(tl.QueueWaitMs))
+	}
+	fmt.Println()
+	for _, attempt := range tl.Attempts {
+		outcome := attempt.Outcome
+		if outcome == "" {
+			outcome = "running"
+		}
+		fmt.Printf("\nattempt %d at %s: %s after %s\n", attempt.Attempt, attempt.StartedAt.Local().Format(time.RFC3339), outcome, formatMs(attempt.DurationMs))
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "PHASE\tSTARTED\tDURATION\tPAUSED\tBYTES")
+		for _, phase := range attempt.Phases {
+			name := string(phase.Phase)
+			if phase.Open {
+				name += " (running)"
+			}
+			paused, bytes := "-", "-"
+			if phase.PausedMs > 0 {
+				reasons := make([]string, len(phase.Pauses))
+				for i, p := range phase.Pauses {
+					reasons[i] = p.Reason
+				}
+				paused = fmt.Sprintf("%s (%s)", formatMs(phase.PausedMs), strings.Join(reasons, ", "))
+			}
+			if phase.Bytes > 0 {
+				bytes = formatBytes(phase.Bytes)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", name, phase.StartedAt.Local().Format(time.TimeOnly), formatMs(phase.DurationMs), paused, bytes)
+		}
+		w.Flush()
+	}
+	return nil
+}
+
+func formatMs(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).String()
+}
@@ -0,0 +1,54 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/runner"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// ExecuteLLMUnload unloads models, or with all every model, from the Ollama
+// at ollamaURL and forgets them, so the runner starts with them cold. The
+// runner must be stopped, since it would keep its served models warm.
+func ExecuteLLMUnload(ollamaURL string, models []string, all bool) error {
+	log := gologger.WithComponent("llm")
+
+	if all == (len(models) > 0) {
+		return errors.New("name the models to unload, or pass --all")
+	}
+	cfg, err := utils.GetConfig()
+	if err != nil {
+		return err
+	}
+	addr := fmt.Sprintf("localhost:%d", cfg.Runner.WebhookPort)
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("runner is running on %s - stop it first, it keeps the models it serves loaded", addr)
+	}
+
+	warm, err := runner.OpenWarmModels(ollamaURL)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if all {
+		unloaded, err := warm.UnloadAll(ctx)
+		log.Info().Strs("models", unloaded).Msg("Unloaded models")
+		return err
+	}
+	for _, model := range models {
+		if err := warm.Unload(ctx, model); err != nil {
+			return fmt.Errorf("failed to unload %s: %w", model, err)
+		}
+		log.Info().Str("model", model).Msg("Unloaded model")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/runner"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// MigrationPassphraseEnv supplies the passphrase migration archives are
+// encrypted with
+const MigrationPassphraseEnv = "PARITY_MIGRATION_PASSPHRASE"
+
+// migrationPassphrase returns the archive passphrase, which is required:
+// the archive carries the runner's identity
+func migrationPassphrase() ([]byte, error) {
+	passphrase := os.Getenv(MigrationPassphraseEnv)
+	if passphrase == "" {
+		return nil, errors.New("set " + MigrationPassphraseEnv + " to the passphrase protecting the archive")
+	}
+	return []byte(passphrase), nil
+}
+
+// checkRunnerStopped fails when the runner is running on this host, since
+// it keeps the stores a migration reads or replaces open
+func checkRunnerStopped(port int) error {
+	addr := fmt.Sprintf("localhost:%d", port)
+	if conn, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		conn.Close()
+		return fmt.Errorf("runner is running on %s - stop it first", addr)
+	}
+	return nil
+}
+
+// ExecuteMigrateExport writes this runner's identity and state to the
+// archive at path, which must not exist yet
+func ExecuteMigrateExport(path string, opts runner.ExportOptions) error {
+	log := gologger.WithComponent("migrate")
+
+	cfg, err := utils.GetConfig()
+	if err != nil {
+		return err
+	}
+	if err := checkRunnerStopped(cfg.Runner.WebhookPort); err != nil {
+		return err
+	}
+	passphrase, err := migrationPassphrase()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	manifest, err := runner.ExportState(f, passphrase, opts)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	log.Info().
+		Str("archive", path).
+		Str("device_id", manifest.DeviceID).
+		Str("wallet", manifest.WalletAddress).
+		Bool("keys", manifest.Keys).
+		Bool("caches", manifest.Caches).
+		Msg("Runner exported - the server stops accepting this machine once the archive is imported")
+	return nil
+}
+
+// ExecuteMigrateImport restores the runner exported to the archive at path
+// onto this machine, binding its identity here with the server
+func ExecuteMigrateImport(path string) error {
+	log := gologger.WithComponent("migrate")
+
+	cfg, err := utils.GetConfig()
+	if err != nil {
+		return err
+	}
+	if err := checkRunnerStopped(cfg.Runner.WebhookPort); err != nil {
+		return err
+	}
+	passphrase, err := migrationPassphrase()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+	manifest, err := runner.ImportState(f, passphrase, cfg.Runner.ServerURL)
+	if err != nil {
+		return err
+	}
+
+	event := log.Info().
+		Str("device_id", manifest.DeviceID).
+		Time("exported_at", manifest.ExportedAt)
+	if manifest.Keys {
+		event.Msg("Runner imported with its wallet key")
+	} else {
+		event.Str("wallet", manifest.WalletAddress).Msg("Runner imported - authenticate with the wallet's key using 'parity auth'")
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/runner"
+	"github.com/theblitlabs/parity-runner/internal/status"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// statusClient returns a client of the runner running on this host, or nil
+// when none answers
+func statusClient() (*status.Client, error) {
+	cfg, err := utils.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	addr := fmt.Sprintf("localhost:%d", cfg.Runner.WebhookPort)
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return nil, nil
+	}
+	conn.Close()
+	return status.NewClient("http://" + addr), nil
+}
+
+// reputationReport asks the running runner for its reputation when there
+// is one, which knows the server's score, and otherwise scores the history
+// directly
+func reputationReport() (*models.ReputationReport, error) {
+	log := gologger.WithComponent("reputation")
+
+	client, err := statusClient()
+	if err != nil {
+		return nil, err
+	}
+	if client != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		st, err := client.Status(ctx)
+		if err == nil && st.Reputation != nil {
+			return st.Reputation, nil
+		}
+		log.Debug().Err(err).Msg("Runner did not report its reputation - reading the history directly")
+	}
+	return runner.Reputation("")
+}
+
+// ExecuteReputation prints the runner's reputation score as the server's
+// formula computes it from the runner's history, what each factor
+// contributes to it, and how it compares with the server's score
+func ExecuteReputation(jsonOutput bool) error {
+	report, err := reputationReport()
+	if err != nil {
+		return err
+	}
+	if report == nil {
+		return fmt.Errorf("reputation is unavailable: task history is disabled")
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	fmt.Printf("reputation %.1f/100 (formula v%d, %d executions)\n", report.Score, report.FormulaVersion, report.Executions)
+	if server := report.Server; server != nil {
+		fmt.Printf("server reports %.1f as of %s", server.Score, server.FetchedAt.Local().Format(time.RFC3339))
+		if server.Diverged {
+			fmt.Printf(" - diverges by %+.1f", server.Divergence)
+			if server.FormulaVersion != 0 && server.FormulaVersion != report.FormulaVersion {
+				fmt.Printf(", server uses formula v%d", server.FormulaVersion)
+			}
+		}
+		fmt.Println()
+		if server.Error != "" {
+			fmt.Printf("last fetch failed: %s\n", server.Error)
+		}
+	}
+	fmt.Println()
+	printFactors(report.Factors)
+	return nil
+}
+
+// ExecuteReputationWhatIf prints what the runner's score would become for
+// each way the running task with taskID could end
+func ExecuteReputationWhatIf(taskID string, jsonOutput bool) error {
+	client, err := statusClient()
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return fmt.Errorf("no runner is running on this host")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	whatIf, err := client.ReputationWhatIf(ctx, taskID)
+	if err != nil {
+		return err
+	}
+
+	if jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(whatIf)
+	}
+
+	fmt.Printf("task %s, reputation now %.1f\n\n", whatIf.TaskID, whatIf.Current)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SCENARIO\tSCORE\tCHANGE\tDESCRIPTION")
+	for _, scenario := range whatIf.Scenarios {
+		fmt.Fprintf(w, "%s\t%.1f\t%+.2f\t%s\n", scenario.Name, scenario.Score, scenario.Delta, scenario.Description)
+	}
+	return w.Flush()
+}
+
+func printFactors(factors []models.ReputationFactor) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FACTOR\tVALUE\tWEIGHT\tPOINTS\tLOST\tSAMPLES")
+	for _, f := range factors {
+		fmt.Fprintf(w, "%s\t%.3f\t%.2f\t%.1f\t%.1f\t%d\n", f.Name, f.Value, f.Weight, f.Contribution, f.Lost, f.Samples)
+	}
+	w.Flush()
+}
@@ -0,0 +1,43 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/runner"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// resultFetchTimeout bounds fetching a result and its offloaded output
+const resultFetchTimeout = 10 * time.Minute
+
+// ExecuteResultFetch writes the whole output of the task with taskID's
+// result to out, or to stdout when out is empty, fetching it from IPFS and
+// checking it against its CID when it was offloaded
+func ExecuteResultFetch(taskID, out string) error {
+	cfg, err := utils.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), resultFetchTimeout)
+	defer cancel()
+	output, truncated, err := runner.FetchResultOutput(ctx, cfg, taskID)
+	if err != nil {
+		return err
+	}
+
+	if out == "" {
+		if _, err := os.Stdout.Write(output); err != nil {
+			return err
+		}
+	} else if err := os.WriteFile(out, output, 0o600); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	if truncated {
+		fmt.Fprintf(os.Stderr, "the output of task %s was truncated when submitted; the rest was not kept\n", taskID)
+	}
+	return nil
+}
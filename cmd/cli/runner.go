@@ -13,7 +13,10 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/theblitlabs/gologger"
 
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
 	"github.com/theblitlabs/parity-runner/internal/runner"
+	"github.com/theblitlabs/parity-runner/internal/tenancy"
 	"github.com/theblitlabs/parity-runner/internal/utils"
 )
 
@@ -27,11 +30,11 @@ func checkPortAvailable(port int) error {
 }
 
 func checkServerConnectivity(serverURL string) error {
+	transport := dualstack.Transport()
+	transport.DisableKeepAlives = true
 	client := &http.Client{
-		Timeout: 5 * time.Second,
-		Transport: &http.Transport{
-			DisableKeepAlives: true,
-		},
+		Timeout:   5 * time.Second,
+		Transport: transport,
 	}
 
 	req, err := http.NewRequest("GET", serverURL, nil)
@@ -55,14 +58,14 @@ func RunRunner() {
 		Use:   "runner",
 		Short: "Start the task runner",
 		RunFunc: func(cmd *cobra.Command, args []string) error {
-			return executeRunner()
+			return executeRunner(false)
 		},
 	}, logger)
 
 	utils.ExecuteCommand(cmd, logger)
 }
 
-func executeRunner() error {
+func executeRunner(takeover bool) error {
 	logger := gologger.Get().With().Str("component", "cli").Logger()
 
 	cfg, err := utils.GetConfig()
@@ -76,14 +79,37 @@ func executeRunner() error {
 		return err
 	}
 
-	if err := checkPortAvailable(cfg.Runner.WebhookPort); err != nil {
-		logger.Fatal().Err(err).Int("port", cfg.Runner.WebhookPort).Msg("Webhook port is not available")
+	if cfg.Runner.InstancesFile != "" {
+		return executeInstances(cfg)
+	}
+
+	// A runner started by an upgrade inherits the webhook socket
+	handoff, err := runner.InheritedHandoff()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to take over from previous runner process")
+		return err
+	}
+
+	// Only one runner may use the data directory; a runner started by an
+	// upgrade inherits the lock too
+	dataLock, err := runner.LockDataDir(handoff, fmt.Sprintf("http://localhost:%d", cfg.Runner.WebhookPort), takeover)
+	if err != nil {
+		handoff.Ack(err)
+		logger.Fatal().Err(err).Msg("Failed to lock runner data directory")
 		return err
 	}
 
+	if handoff == nil {
+		if err := checkPortAvailable(cfg.Runner.WebhookPort); err != nil {
+			logger.Fatal().Err(err).Int("port", cfg.Runner.WebhookPort).Msg("Webhook port is not available")
+			return err
+		}
+	}
+
 	// Use a single signal channel to handle shutdown
 	signalChan := make(chan os.Signal, 2)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+	upgradeChan := notifyUpgrade()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -93,6 +119,10 @@ func executeRunner() error {
 		logger.Fatal().Err(err).Msg("Failed to create runner service")
 		return err
 	}
+	if handoff != nil {
+		runnerService.AdoptHandoff(handoff)
+	}
+	runnerService.SetDataLock(dataLock)
 
 	runnerService.SetHeartbeatInterval(cfg.Runner.HeartbeatInterval)
 	logger.Debug().Dur("interval", cfg.Runner.HeartbeatInterval).Msg("Configured heartbeat interval")
@@ -120,6 +150,7 @@ func executeRunner() error {
 	// Signal handling with force exit capability
 	signalCount := 0
 	shutdownInitiated := false
+	shutdownRequested := runnerService.ShutdownRequested()
 
 	for {
 		select {
@@ -137,7 +168,7 @@ func executeRunner() error {
 
 				// Start graceful shutdown in a goroutine
 				go func() {
-					shutdownCtx, shutdownCancel := utils.WithTimeout()
+					shutdownCtx, shutdownCancel := utils.WithCustomTimeout(runner.ShutdownTimeout(cfg))
 					defer shutdownCancel()
 
 					if err := runnerService.Stop(shutdownCtx); err != nil {
@@ -153,6 +184,18 @@ func executeRunner() error {
 				os.Exit(1)
 			}
 
+		case <-shutdownRequested:
+			// A runner taking over the data directory asked this one to
+			// exit, which it does as on SIGTERM
+			shutdownRequested = nil
+			signalChan <- syscall.SIGTERM
+
+		case <-upgradeChan:
+			if !shutdownInitiated {
+				logger.Info().Msg("Upgrade signal received, handing off to the new runner binary...")
+				upgradeRunner(logger, runnerService)
+			}
+
 		case <-ctx.Done():
 			if !shutdownInitiated {
 				logger.Info().Msg("Context cancelled, shutting down...")
@@ -162,6 +205,70 @@ func executeRunner() error {
 	}
 }
 
+// executeInstances runs the logical runners listed in the instances file
+// until the process is signalled. Upgrades hand over a single runner, so the
+// upgrade signal is not handled.
+func executeInstances(cfg *config.Config) error {
+	logger := gologger.Get().With().Str("component", "cli").Logger()
+
+	instances, err := tenancy.Load(cfg.Runner.InstancesFile)
+	if err != nil {
+		logger.Fatal().Err(err).Str("file", cfg.Runner.InstancesFile).Msg("Failed to load runner instances")
+		return err
+	}
+	// The instances share the data directory, which no other runner may use.
+	// The process serves no single status API, so it cannot be taken over.
+	dataLock, err := runner.LockDataDir(nil, "", false)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to lock runner data directory")
+		return err
+	}
+	defer dataLock.Release()
+
+	for _, instance := range instances {
+		if err := checkPortAvailable(instance.WebhookPort); err != nil {
+			logger.Fatal().Err(err).Str("instance", instance.Name).Int("port", instance.WebhookPort).Msg("Webhook port is not available")
+			return err
+		}
+	}
+
+	signalChan := make(chan os.Signal, 2)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+
+	multiService, err := runner.NewMultiService(cfg, instances)
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to create runner instances")
+		return err
+	}
+	multiService.SetHeartbeatInterval(cfg.Runner.HeartbeatInterval)
+
+	if err := multiService.Start(); err != nil {
+		logger.Fatal().Err(err).Msg("Failed to start runner instances")
+		return err
+	}
+	logger.Info().Int("instances", len(instances)).Msg("Runner instances started successfully")
+
+	sig := <-signalChan
+	logger.Info().
+		Str("signal", sig.String()).
+		Msg("Shutdown signal received, initiating graceful shutdown...")
+	logger.Info().Msg("Press Ctrl+C again to force exit if shutdown hangs")
+	go func() {
+		<-signalChan
+		logger.Info().Msg("Force exit signal received - terminating immediately")
+		os.Exit(1)
+	}()
+
+	shutdownCtx, shutdownCancel := utils.WithCustomTimeout(runner.ShutdownTimeout(cfg))
+	defer shutdownCancel()
+	if err := multiService.Stop(shutdownCtx); err != nil {
+		logger.Error().Err(err).Msg("Error during runner instances shutdown")
+		return err
+	}
+	logger.Info().Msg("Runner instances stopped successfully")
+	return nil
+}
+
 func RunRunnerWithLLM(models []string, ollamaURL string, autoInstall bool) {
 	logger := gologger.Get().With().Str("component", "cli").Logger()
 
@@ -169,14 +276,14 @@ func RunRunnerWithLLM(models []string, ollamaURL string, autoInstall bool) {
 		Use:   "runner",
 		Short: "Start the task runner with LLM capabilities",
 		RunFunc: func(cmd *cobra.Command, args []string) error {
-			return executeRunnerWithLLM(models, ollamaURL, autoInstall)
+			return executeRunnerWithLLM(models, ollamaURL, autoInstall, false)
 		},
 	}, logger)
 
 	utils.ExecuteCommand(cmd, logger)
 }
 
-func executeRunnerWithLLM(models []string, ollamaURL string, autoInstall bool) error {
+func executeRunnerWithLLM(models []string, ollamaURL string, autoInstall, takeover bool) error {
 	logger := gologger.Get().With().Str("component", "cli").Logger()
 
 	cfg, err := utils.GetConfig()
@@ -195,14 +302,33 @@ func executeRunnerWithLLM(models []string, ollamaURL string, autoInstall bool) e
 		return err
 	}
 
-	if err := checkPortAvailable(cfg.Runner.WebhookPort); err != nil {
-		logger.Fatal().Err(err).Int("port", cfg.Runner.WebhookPort).Msg("Webhook port is not available")
+	// A runner started by an upgrade inherits the webhook socket
+	handoff, err := runner.InheritedHandoff()
+	if err != nil {
+		logger.Fatal().Err(err).Msg("Failed to take over from previous runner process")
+		return err
+	}
+
+	// Only one runner may use the data directory; a runner started by an
+	// upgrade inherits the lock too
+	dataLock, err := runner.LockDataDir(handoff, fmt.Sprintf("http://localhost:%d", cfg.Runner.WebhookPort), takeover)
+	if err != nil {
+		handoff.Ack(err)
+		logger.Fatal().Err(err).Msg("Failed to lock runner data directory")
 		return err
 	}
 
+	if handoff == nil {
+		if err := checkPortAvailable(cfg.Runner.WebhookPort); err != nil {
+			logger.Fatal().Err(err).Int("port", cfg.Runner.WebhookPort).Msg("Webhook port is not available")
+			return err
+		}
+	}
+
 	// Use a single signal channel to handle shutdown
 	signalChan := make(chan os.Signal, 2)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+	upgradeChan := notifyUpgrade()
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -212,6 +338,10 @@ func executeRunnerWithLLM(models []string, ollamaURL string, autoInstall bool) e
 		logger.Fatal().Err(err).Msg("Failed to create runner service")
 		return err
 	}
+	if handoff != nil {
+		runnerService.AdoptHandoff(handoff)
+	}
+	runnerService.SetDataLock(dataLock)
 
 	// Initialize LLM handler with models
 	llmHandler := runner.NewLLMHandler(ollamaURL, cfg.Runner.ServerURL, models)
@@ -268,6 +398,7 @@ func executeRunnerWithLLM(models []string, ollamaURL string, autoInstall bool) e
 	// Signal handling with force exit capability
 	signalCount := 0
 	shutdownInitiated := false
+	shutdownRequested := runnerService.ShutdownRequested()
 
 	for {
 		select {
@@ -285,7 +416,7 @@ func executeRunnerWithLLM(models []string, ollamaURL string, autoInstall bool) e
 
 				// Start graceful shutdown in a goroutine
 				go func() {
-					shutdownCtx, shutdownCancel := utils.WithTimeout()
+					shutdownCtx, shutdownCancel := utils.WithCustomTimeout(runner.ShutdownTimeout(cfg))
 					defer shutdownCancel()
 
 					if err := runnerService.Stop(shutdownCtx); err != nil {
@@ -301,6 +432,18 @@ func executeRunnerWithLLM(models []string, ollamaURL string, autoInstall bool) e
 				os.Exit(1)
 			}
 
+		case <-shutdownRequested:
+			// A runner taking over the data directory asked this one to
+			// exit, which it does as on SIGTERM
+			shutdownRequested = nil
+			signalChan <- syscall.SIGTERM
+
+		case <-upgradeChan:
+			if !shutdownInitiated {
+				logger.Info().Msg("Upgrade signal received, handing off to the new runner binary...")
+				upgradeRunner(logger, runnerService)
+			}
+
 		case <-ctx.Done():
 			if !shutdownInitiated {
 				logger.Info().Msg("Context cancelled, shutting down...")
@@ -310,6 +453,6 @@ func executeRunnerWithLLM(models []string, ollamaURL string, autoInstall bool) e
 	}
 }
 
-func ExecuteRunnerWithLLMDirect(models []string, ollamaURL string, autoInstall bool) error {
-	return executeRunnerWithLLM(models, ollamaURL, autoInstall)
+func ExecuteRunnerWithLLMDirect(models []string, ollamaURL string, autoInstall, takeover bool) error {
+	return executeRunnerWithLLM(models, ollamaURL, autoInstall, takeover)
 }
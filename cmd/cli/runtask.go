@@ -0,0 +1,52 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/runner"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// ErrTaskFailed is returned when a task run on demand finishes unsuccessfully
+var ErrTaskFailed = errors.New("task failed")
+
+// ExecuteRunTask claims the task with taskID and runs it now, streaming its
+// output and submitting its result as the runner would. force bypasses the
+// runner's scheduling filters but never its safety policies.
+func ExecuteRunTask(taskID string, force bool) error {
+	cfg, err := utils.GetConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	svc, err := runner.NewService(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create runner service: %w", err)
+	}
+
+	status, result, err := svc.RunTask(taskID, force, os.Stdout)
+	switch {
+	case errors.Is(err, runner.ErrTaskClaimed):
+		return fmt.Errorf("task %s is already claimed by another runner", taskID)
+	case errors.Is(err, runner.ErrTaskFinished):
+		return fmt.Errorf("task %s has already %s; nothing to run", taskID, status)
+	case errors.Is(err, runner.ErrTaskNotFound):
+		return fmt.Errorf("task %s not found", taskID)
+	}
+	if result != nil {
+		fmt.Printf("task %s %s with exit code %d\n", taskID, status, result.ExitCode)
+		if result.Error != "" {
+			fmt.Printf("  %s\n", result.Error)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if status != models.TaskStatusCompleted {
+		return ErrTaskFailed
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/dashboard"
+	"github.com/theblitlabs/parity-runner/internal/status"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// ExecuteTop shows the dashboard of the runner at url, or of the runner on
+// this host when url is empty, refreshing every interval
+func ExecuteTop(url string, interval time.Duration) error {
+	if url == "" {
+		cfg, err := utils.GetConfig()
+		if err != nil {
+			return err
+		}
+		url = fmt.Sprintf("http://localhost:%d", cfg.Runner.WebhookPort)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return dashboard.Run(ctx, status.NewClient(url), os.Stdin, os.Stdout, interval)
+}
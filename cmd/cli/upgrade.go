@@ -0,0 +1,31 @@
+package cli
+
+import (
+	"os"
+
+	"github.com/rs/zerolog"
+
+	"github.com/theblitlabs/parity-runner/internal/runner"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// upgradeRunner hands the service over to the runner binary now installed
+// at this binary's path and exits once it has taken over. When the handoff
+// fails the service keeps running in this process.
+func upgradeRunner(logger zerolog.Logger, runnerService *runner.Service) {
+	binary, err := runner.UpgradeBinary()
+	if err != nil {
+		logger.Error().Err(err).Msg("Runner upgrade failed")
+		return
+	}
+
+	ctx, cancel := utils.WithTimeout()
+	defer cancel()
+
+	if err := runnerService.Upgrade(ctx, binary, os.Args[1:]); err != nil {
+		logger.Error().Err(err).Msg("Runner upgrade failed - continuing with the current binary")
+		return
+	}
+	logger.Info().Msg("Runner upgraded - exiting")
+	os.Exit(0)
+}
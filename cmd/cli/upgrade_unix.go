@@ -0,0 +1,17 @@
+//go:build !windows
+
+package cli
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// notifyUpgrade returns a channel that receives SIGUSR2, the signal asking
+// the runner to hand over to an upgraded binary
+func notifyUpgrade() <-chan os.Signal {
+	upgradeChan := make(chan os.Signal, 1)
+	signal.Notify(upgradeChan, syscall.SIGUSR2)
+	return upgradeChan
+}
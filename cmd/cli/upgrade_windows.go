@@ -0,0 +1,11 @@
+//go:build windows
+
+package cli
+
+import "os"
+
+// notifyUpgrade returns a nil channel; upgrades with handoff are not
+// supported on windows
+func notifyUpgrade() <-chan os.Signal {
+	return nil
+}
@@ -0,0 +1,75 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/taskschema"
+)
+
+// ErrInvalidTask is returned when a task fails schema validation
+var ErrInvalidTask = errors.New("task config does not match its schema")
+
+// ExecuteValidateTask checks the task JSON at path, or on stdin for "-",
+// against the schemas runners use before claiming it. The file holds either
+// a task with type and config, or a bare config whose type is taskType.
+func ExecuteValidateTask(path, taskType string) error {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read task: %w", err)
+	}
+
+	kind, config, err := taskDocument(data, models.TaskType(taskType))
+	if err != nil {
+		return err
+	}
+
+	err = taskschema.Validate(kind, config)
+	var invalid *taskschema.ValidationError
+	switch {
+	case err == nil:
+		fmt.Printf("%s task config is valid\n", kind)
+		return nil
+	case errors.As(err, &invalid):
+		fmt.Printf("%s task config does not match schema v%d:\n", kind, invalid.Version)
+		for _, v := range invalid.Violations {
+			fmt.Printf("  %s\n", v)
+		}
+		return ErrInvalidTask
+	default:
+		return err
+	}
+}
+
+// taskDocument returns the type and config of a task file, which is either a
+// task or a bare config of taskType
+func taskDocument(data []byte, taskType models.TaskType) (models.TaskType, json.RawMessage, error) {
+	var task struct {
+		Type   models.TaskType `json:"type"`
+		Config json.RawMessage `json:"config"`
+	}
+	if err := json.Unmarshal(data, &task); err != nil {
+		return "", nil, fmt.Errorf("failed to parse task: %w", err)
+	}
+
+	if task.Type != "" && task.Config != nil {
+		if taskType != "" && taskType != task.Type {
+			return "", nil, fmt.Errorf("task is of type %s, not %s", task.Type, taskType)
+		}
+		return task.Type, task.Config, nil
+	}
+	if taskType == "" {
+		return "", nil, errors.New("file is not a task with type and config; pass --type to validate a bare config")
+	}
+	return taskType, data, nil
+}
@@ -0,0 +1,21 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/theblitlabs/parity-runner/internal/provenance"
+)
+
+// ExecuteVersion prints the runner's version or, with withProvenance, how
+// its binary was built and its fingerprint as JSON
+func ExecuteVersion(withProvenance bool) error {
+	if !withProvenance {
+		fmt.Printf("parity-runner %s\n", provenance.Version())
+		return nil
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(provenance.Current())
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -10,6 +11,11 @@ import (
 	"github.com/theblitlabs/gologger"
 
 	"github.com/theblitlabs/parity-runner/cmd/cli"
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/dashboard"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/profiles"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/quota"
+	"github.com/theblitlabs/parity-runner/internal/runner"
 	"github.com/theblitlabs/parity-runner/internal/utils"
 )
 
@@ -44,10 +50,30 @@ var rootCmd = &cobra.Command{
 }
 
 func main() {
+	// A command task under a disk quota or confined under a security
+	// profile starts as this executable, which builds its capped root or
+	// confines itself and becomes the command
+	quota.Enter()
+	profiles.Enter()
+
 	rootCmd.AddCommand(authCmd)
 	rootCmd.AddCommand(stakeCmd)
 	rootCmd.AddCommand(runnerCmd)
 	rootCmd.AddCommand(balanceCmd)
+	rootCmd.AddCommand(dataKeyCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(fsckCmd)
+	rootCmd.AddCommand(doctorCmd)
+	rootCmd.AddCommand(historyCmd)
+	rootCmd.AddCommand(reputationCmd)
+	rootCmd.AddCommand(migrateCmd)
+	rootCmd.AddCommand(escrowCmd)
+	rootCmd.AddCommand(resultCmd)
+	rootCmd.AddCommand(llmCmd)
+	rootCmd.AddCommand(validateTaskCmd)
+	rootCmd.AddCommand(runTaskCmd)
+	rootCmd.AddCommand(topCmd)
+	rootCmd.AddCommand(versionCmd)
 
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -81,18 +107,355 @@ var runnerCmd = &cobra.Command{
   parity-runner runner --models llama2,mistral,codellama
   
   # Start runner with custom Ollama URL
-  parity-runner runner --ollama-url http://localhost:11434 --models llama2`,
+  parity-runner runner --ollama-url http://localhost:11434 --models llama2
+
+  # Replace the runner already running on this host once it has drained
+  parity-runner runner --takeover`,
 	Run: func(cmd *cobra.Command, args []string) {
 		models, _ := cmd.Flags().GetStringSlice("models")
 		ollamaURL, _ := cmd.Flags().GetString("ollama-url")
 		autoInstall, _ := cmd.Flags().GetBool("auto-install")
+		takeover, _ := cmd.Flags().GetBool("takeover")
 
-		if err := cli.ExecuteRunnerWithLLMDirect(models, ollamaURL, autoInstall); err != nil {
+		if err := cli.ExecuteRunnerWithLLMDirect(models, ollamaURL, autoInstall, takeover); err != nil {
 			log.Fatal().Err(err).Msg("Failed to start runner with LLM")
 		}
 	},
 }
 
+var dataKeyCmd = &cobra.Command{
+	Use:   "data-key",
+	Short: "Manage the key encrypting runner-local data",
+}
+
+var dataKeyRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Generate a new data key and re-encrypt local stores (stop the runner first)",
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cli.ExecuteRotateDataKey(); err != nil {
+			log.Fatal().Err(err).Msg("Failed to rotate data key")
+		}
+	},
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspect and manage the image, model, dataset and artifact caches",
+}
+
+var cacheListCmd = &cobra.Command{
+	Use:   "ls [cache]",
+	Short: "Show usage of every cache, or the entries of one",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		}
+		if err := cli.ExecuteCacheList(name); err != nil {
+			log.Fatal().Err(err).Msg("Failed to list caches")
+		}
+	},
+}
+
+var cachePinCmd = &cobra.Command{
+	Use:   "pin <cache> <key>",
+	Short: "Protect a cache entry from purges and budget eviction",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cli.ExecuteCachePin(args[0], args[1], true); err != nil {
+			log.Fatal().Err(err).Msg("Failed to pin cache entry")
+		}
+	},
+}
+
+var cacheUnpinCmd = &cobra.Command{
+	Use:   "unpin <cache> <key>",
+	Short: "Make a pinned cache entry evictable again",
+	Args:  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cli.ExecuteCachePin(args[0], args[1], false); err != nil {
+			log.Fatal().Err(err).Msg("Failed to unpin cache entry")
+		}
+	},
+}
+
+var cachePurgeCmd = &cobra.Command{
+	Use:   "purge <cache> [key...]",
+	Short: "Remove cache entries; pinned entries and those used by running tasks are kept",
+	Example: `  # Remove two images
+  parity-runner cache purge images python:3.11 node:20
+
+  # Remove datasets unused for a week
+  parity-runner cache purge datasets --older-than 168h`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		olderThan, _ := cmd.Flags().GetDuration("older-than")
+
+		req := caches.PurgeRequest{Keys: args[1:], All: all, OlderThan: olderThan}
+		if err := cli.ExecuteCachePurge(args[0], req); err != nil {
+			log.Fatal().Err(err).Msg("Failed to purge cache")
+		}
+	},
+}
+
+var fsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Check local caches and stores, repairing indexes and quarantining damaged content (stop the runner first)",
+	Long: `Check local caches and stores, repairing indexes and quarantining damaged content.
+The runner does the same on every start. Quarantined content is kept for a week.
+Exits 1 when some stores could not be checked.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		err := cli.ExecuteFsck(jsonOutput)
+		if errors.Is(err, cli.ErrFsckIncomplete) {
+			os.Exit(1)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to check local stores")
+		}
+	},
+}
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that the server, IPFS gateway and registry mirrors are reachable over IPv4 and IPv6",
+	Long: `Check that the server, IPFS gateway and registry mirrors are reachable over IPv4 and IPv6.
+Exits 1 when some of them could not be reached from an IPv6-only network.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		err := cli.ExecuteDoctor(jsonOutput)
+		if errors.Is(err, cli.ErrIPv6Unreachable) {
+			os.Exit(1)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to check network reachability")
+		}
+	},
+}
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Inspect the tasks this runner ran",
+}
+
+var historyShowCmd = &cobra.Command{
+	Use:   "show <task-id>",
+	Short: "Show where a task's time went: each attempt and the phases it went through",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		if err := cli.ExecuteHistoryShow(args[0], jsonOutput); err != nil {
+			log.Fatal().Err(err).Msg("Failed to show task timeline")
+		}
+	},
+}
+
+var reputationCmd = &cobra.Command{
+	Use:   "reputation",
+	Short: "Show the reputation score the server's formula gives this runner's history, factor by factor",
+	Example: `  # Score the runner and compare with the server's score
+  parity-runner reputation
+
+  # What abandoning a stuck task now would cost, against letting it time out
+  parity-runner reputation --what-if <task-id>`,
+	Run: func(cmd *cobra.Command, args []string) {
+		jsonOutput, _ := cmd.Flags().GetBool("json")
+		taskID, _ := cmd.Flags().GetString("what-if")
+		if taskID != "" {
+			if err := cli.ExecuteReputationWhatIf(taskID, jsonOutput); err != nil {
+				log.Fatal().Err(err).Msg("Failed to simulate reputation")
+			}
+			return
+		}
+		if err := cli.ExecuteReputation(jsonOutput); err != nil {
+			log.Fatal().Err(err).Msg("Failed to compute reputation")
+		}
+	},
+}
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move this runner's identity and state to new hardware",
+	Long: `Move this runner's identity and state to new hardware.
+Archives are encrypted with the passphrase in ` + cli.MigrationPassphraseEnv + `.
+Importing binds the identity to the new machine with the server, which then
+refuses the machine it was exported from.`,
+}
+
+var migrateExportCmd = &cobra.Command{
+	Use:   "export <archive>",
+	Short: "Export the device identity, wallet reference, task history, ledger and outbox (stop the runner first)",
+	Example: `  # Export with the wallet key and cache indexes
+  PARITY_MIGRATION_PASSPHRASE=... parity-runner migrate export runner.pmig --include-keys --include-caches`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		includeKeys, _ := cmd.Flags().GetBool("include-keys")
+		includeCaches, _ := cmd.Flags().GetBool("include-caches")
+
+		opts := runner.ExportOptions{IncludeKeys: includeKeys, IncludeCaches: includeCaches}
+		if err := cli.ExecuteMigrateExport(args[0], opts); err != nil {
+			log.Fatal().Err(err).Msg("Failed to export runner")
+		}
+	},
+}
+
+var migrateImportCmd = &cobra.Command{
+	Use:   "import <archive>",
+	Short: "Import an exported runner onto this machine and bind its identity here (stop the runner first)",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := cli.ExecuteMigrateImport(args[0]); err != nil {
+			log.Fatal().Err(err).Msg("Failed to import runner")
+		}
+	},
+}
+
+var escrowCmd = &cobra.Command{
+	Use:   "escrow",
+	Short: "Prove the results this runner escrowed",
+}
+
+var escrowProveCmd = &cobra.Command{
+	Use:   "prove <task-id>",
+	Short: "Download a task's escrow envelope and re-derive the hash of its result bundle",
+	Example: `  # Check the envelope's signature and print the bundle hash
+  parity-runner escrow prove 7f7a9c1e-5b1d-4c33-9d0e-2f1d6f0b8a11
+
+  # Decrypt the bundle with the creator's or the escrow holder's key
+  parity-runner escrow prove 7f7a9c1e-5b1d-4c33-9d0e-2f1d6f0b8a11 --key creator.key`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		gateway, _ := cmd.Flags().GetString("gateway")
+		keyFile, _ := cmd.Flags().GetString("key")
+		if err := cli.ExecuteEscrowProve(args[0], gateway, keyFile); err != nil {
+			log.Fatal().Err(err).Msg("Failed to prove escrowed result")
+		}
+	},
+}
+
+var llmCmd = &cobra.Command{
+	Use:   "llm",
+	Short: "Manage the models loaded in the LLM backend",
+}
+
+var llmUnloadCmd = &cobra.Command{
+	Use:   "unload [model...]",
+	Short: "Unload models from Ollama so the next runner starts with a cold backend (stop the runner first)",
+	Example: `  # Unload one model
+  parity-runner llm unload llama2:7b
+
+  # Unload every model Ollama holds, whoever loaded it
+  parity-runner llm unload --all`,
+	Run: func(cmd *cobra.Command, args []string) {
+		all, _ := cmd.Flags().GetBool("all")
+		ollamaURL, _ := cmd.Flags().GetString("ollama-url")
+		if err := cli.ExecuteLLMUnload(ollamaURL, args, all); err != nil {
+			log.Fatal().Err(err).Msg("Failed to unload models")
+		}
+	},
+}
+
+var validateTaskCmd = &cobra.Command{
+	Use:   "validate-task <file>",
+	Short: "Check a task against the config schemas runners enforce before claiming it",
+	Example: `  # Validate a task as it would be submitted
+  parity-runner validate-task task.json
+
+  # Validate a bare docker config read from stdin
+  parity-runner validate-task --type docker - < config.json`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		taskType, _ := cmd.Flags().GetString("type")
+		err := cli.ExecuteValidateTask(args[0], taskType)
+		if errors.Is(err, cli.ErrInvalidTask) {
+			os.Exit(1)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to validate task")
+		}
+	},
+}
+
+var resultCmd = &cobra.Command{
+	Use:   "result",
+	Short: "Inspect the results of tasks",
+}
+
+var resultFetchCmd = &cobra.Command{
+	Use:   "fetch <task-id>",
+	Short: "Print the whole output of a task's result, fetching it from IPFS when it was offloaded",
+	Example: `  # Print a task's output
+  parity-runner result fetch 3f1c2a9e-8b7d-4c55-9a1e-2f6b0d4c7e11
+
+  # Save it to a file
+  parity-runner result fetch 3f1c2a9e-8b7d-4c55-9a1e-2f6b0d4c7e11 --out output.log`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		out, _ := cmd.Flags().GetString("out")
+		if err := cli.ExecuteResultFetch(args[0], out); err != nil {
+			log.Fatal().Err(err).Msg("Failed to fetch result")
+		}
+	},
+}
+
+var runTaskCmd = &cobra.Command{
+	Use:   "run-task <task-id>",
+	Short: "Claim and run one task now, streaming its output and submitting its result",
+	Long: `Claim and run one task now, streaming its output and submitting its result.
+Exits 0 when the task completes, 1 when it fails and 2 when it could not be run.`,
+	Example: `  # Run a task a creator reports failing on this runner
+  parity-runner run-task 3f1c2a9e-8b7d-4c55-9a1e-2f6b0d4c7e11
+
+  # Run it even though it asks for hardware this runner lacks
+  parity-runner run-task --force 3f1c2a9e-8b7d-4c55-9a1e-2f6b0d4c7e11`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		force, _ := cmd.Flags().GetBool("force")
+		err := cli.ExecuteRunTask(args[0], force)
+		if errors.Is(err, cli.ErrTaskFailed) {
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	},
+}
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Show a live dashboard of a running runner's tasks, caches and connectivity",
+	Long: `Show a live dashboard of a running runner's tasks, caches and connectivity.
+Keys: d drains or undrains the runner, p pauses or resumes claiming,
+l moves to the next log level, r refreshes and q quits.`,
+	Example: `  # Watch the runner on this host
+  parity-runner top
+
+  # Watch a remote runner
+  parity-runner top --url http://10.0.0.5:8081`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		url, _ := cmd.Flags().GetString("url")
+		interval, _ := cmd.Flags().GetDuration("interval")
+		if err := cli.ExecuteTop(url, interval); err != nil {
+			log.Fatal().Err(err).Msg("Failed to show dashboard")
+		}
+	},
+}
+
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the runner's version, or with --provenance how it was built",
+	Run: func(cmd *cobra.Command, args []string) {
+		withProvenance, _ := cmd.Flags().GetBool("provenance")
+		if err := cli.ExecuteVersion(withProvenance); err != nil {
+			log.Fatal().Err(err).Msg("Failed to print version")
+		}
+	},
+}
+
 var stakeCmd = &cobra.Command{
 	Use:   "stake",
 	Short: "Stake tokens in the network",
@@ -115,8 +478,48 @@ func init() {
 		log.Error().Err(err).Msg("Failed to mark amount flag as required")
 	}
 
+	dataKeyCmd.AddCommand(dataKeyRotateCmd)
+
+	cacheCmd.AddCommand(cacheListCmd, cachePinCmd, cacheUnpinCmd, cachePurgeCmd)
+	cachePurgeCmd.Flags().Bool("all", false, "Remove every entry of the cache")
+	cachePurgeCmd.Flags().Duration("older-than", 0, "Remove entries unused for at least this long")
+
+	fsckCmd.Flags().Bool("json", false, "Print the report as JSON")
+	doctorCmd.Flags().Bool("json", false, "Print the report as JSON")
+
+	historyCmd.AddCommand(historyShowCmd)
+	historyShowCmd.Flags().Bool("json", false, "Print the timeline as JSON")
+
+	reputationCmd.Flags().String("what-if", "", "Score each way the running task with this ID could end")
+	reputationCmd.Flags().Bool("json", false, "Print the report as JSON")
+
+	migrateCmd.AddCommand(migrateExportCmd, migrateImportCmd)
+	migrateExportCmd.Flags().Bool("include-keys", false, "Include the wallet keystore rather than only its address")
+	migrateExportCmd.Flags().Bool("include-caches", false, "Include the cache indexes")
+
+	escrowCmd.AddCommand(escrowProveCmd)
+	escrowProveCmd.Flags().String("gateway", cli.DefaultEscrowGateway, "IPFS gateway the envelope is downloaded from")
+	escrowProveCmd.Flags().String("key", "", "File holding the hex private key of a recipient, to decrypt the bundle")
+
+	resultCmd.AddCommand(resultFetchCmd)
+	resultFetchCmd.Flags().String("out", "", "File to write the output to instead of stdout")
+
+	llmCmd.AddCommand(llmUnloadCmd)
+	llmUnloadCmd.Flags().Bool("all", false, "Unload every model Ollama holds")
+	llmUnloadCmd.Flags().String("ollama-url", "http://localhost:11434", "Ollama server URL")
+
+	runTaskCmd.Flags().Bool("force", false, "Bypass hardware, bandwidth and preflight filters; safety policies still apply")
+
+	topCmd.Flags().String("url", "", "Address of the runner's local port; the runner on this host when empty")
+	topCmd.Flags().Duration("interval", dashboard.DefaultInterval, "How often to refresh")
+
+	versionCmd.Flags().Bool("provenance", false, "Print the build provenance and fingerprint of the binary as JSON")
+
+	validateTaskCmd.Flags().String("type", "", "Task type of a bare config: docker, command, llm, federated_learning or embedding")
+
 	// LLM-related flags for runner command
 	runnerCmd.Flags().StringSlice("models", []string{"llama2"}, "Comma-separated list of models to load")
 	runnerCmd.Flags().String("ollama-url", "http://localhost:11434", "Ollama server URL")
 	runnerCmd.Flags().Bool("auto-install", true, "Automatically install Ollama if not found")
+	runnerCmd.Flags().Bool("takeover", false, "Ask the runner already using the data directory to drain and exit, then start in its place")
 }
@@ -0,0 +1,722 @@
+// Package accounting keeps the runner's local ledger of the tasks it ran.
+// Every task moves through a state machine persisted on disk,
+//
+//	claimed → executing → result_pending → reported → ledgered
+//
+// and is written to the ledger only on its way from reported to ledgered, so
+// a task is ledgered exactly once however often the runner stops between two
+// states. Reconcile, run at startup, completes or rolls back the tasks a
+// previous process left part way.
+package accounting
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/fsck"
+)
+
+// State is how far a task got through the accounting pipeline
+type State string
+
+const (
+	StateClaimed       State = "claimed"
+	StateExecuting     State = "executing"
+	StateResultPending State = "result_pending"
+	StateReported      State = "reported"
+	StateLedgered      State = "ledgered"
+)
+
+// states lists the states in the order tasks move through them
+var states = []State{StateClaimed, StateExecuting, StateResultPending, StateReported, StateLedgered}
+
+func (s State) index() int {
+	for i, state := range states {
+		if state == s {
+			return i
+		}
+	}
+	return -1
+}
+
+const (
+	recordDirName = "tasks"
+	// LedgerFileName is the ledger in a journal's directory, one entry per
+	// line
+	LedgerFileName = "ledger.jsonl"
+)
+
+var (
+	// ErrInvalidTransition is returned for a move that skips a state or
+	// rolls back a task whose result is already pending
+	ErrInvalidTransition = errors.New("invalid accounting transition")
+	// ErrUnknownTask is returned for a move of a task that was never begun
+	ErrUnknownTask = errors.New("task not being accounted")
+	// ErrResultLost is returned by a ReportFunc when the result of a task
+	// is no longer kept, so that the task can only be rolled back
+	ErrResultLost = errors.New("task result lost")
+)
+
+// Record is the persisted accounting state of one task
+type Record struct {
+	TaskID     string            `json:"task_id"`
+	Type       models.TaskType   `json:"type"`
+	State      State             `json:"state"`
+	Status     models.TaskStatus `json:"status,omitempty"`
+	Reward     float64           `json:"reward,omitempty"`
+	DurationMs int64             `json:"duration_ms,omitempty"`
+	Donated    bool              `json:"donated,omitempty"`
+	UpdatedAt  time.Time         `json:"updated_at"`
+}
+
+// Outcome is how a task's execution ended, recorded once its result is
+// pending
+type Outcome struct {
+	Status   models.TaskStatus
+	Reward   float64
+	Duration time.Duration
+	// Donated is set for rounds of community sessions run on idle time
+	Donated bool
+}
+
+// Entry is one task in the ledger
+type Entry struct {
+	TaskID     string            `json:"task_id"`
+	Type       models.TaskType   `json:"type"`
+	Status     models.TaskStatus `json:"status"`
+	Reward     float64           `json:"reward,omitempty"`
+	DurationMs int64             `json:"duration_ms"`
+	// Donated entries are idle time given to community sessions, not paid
+	// work
+	Donated    bool      `json:"donated,omitempty"`
+	LedgeredAt time.Time `json:"ledgered_at"`
+}
+
+// ReportFunc reports the pending result of a task to the server again. It
+// returns nil once the server has the result, and an error wrapping
+// ErrResultLost when the result cannot be reported any more.
+type ReportFunc func(record Record) error
+
+// Reconciliation counts what Reconcile did with the tasks it found
+type Reconciliation struct {
+	// Completed tasks were ledgered
+	Completed int
+	// RolledBack tasks were dropped without being ledgered
+	RolledBack int
+	// Pending tasks still wait for their result to be reported
+	Pending int
+}
+
+// Journal persists the accounting state of every task in flight, one file
+// per task, next to the ledger of the tasks that finished
+type Journal struct {
+	dir        string
+	ledgerPath string
+	clock      clock.Clock
+
+	mu    sync.Mutex
+	codec atrest.Codec
+	// ledgered holds the tasks in the ledger, read from it on first use
+	ledgered map[string]bool
+	// fault is called once each step is durable; an error stops the
+	// step there, as if the process had died. Only tests set it.
+	fault func(step string) error
+}
+
+// Open opens the journal kept in dir, creating it when missing
+func Open(dir string) (*Journal, error) {
+	if err := os.MkdirAll(filepath.Join(dir, recordDirName), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create accounting directory: %w", err)
+	}
+	return &Journal{
+		dir:        dir,
+		ledgerPath: filepath.Join(dir, LedgerFileName),
+		clock:      clock.Real(),
+	}, nil
+}
+
+// OpenAll opens the journal kept in dir and those of the instances kept in
+// its subdirectories, the journal in dir first
+func OpenAll(dir string) ([]*Journal, error) {
+	dirs := []string{dir}
+	entries, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read accounting directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != recordDirName {
+			dirs = append(dirs, filepath.Join(dir, entry.Name()))
+		}
+	}
+	journals := make([]*Journal, 0, len(dirs))
+	for _, dir := range dirs {
+		j, err := Open(dir)
+		if err != nil {
+			return nil, err
+		}
+		journals = append(journals, j)
+	}
+	return journals, nil
+}
+
+func (j *Journal) SetClock(c clock.Clock) {
+	j.clock = c
+}
+
+// SetCodec encrypts the records and ledger entries written from now on
+func (j *Journal) SetCodec(codec atrest.Codec) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.codec = codec
+}
+
+// Reseal rewrites the records and the ledger under the active data key when
+// any of them is plaintext or sealed with an older key. Plaintext is only
+// accepted until the stores have been migrated. It reports how many files
+// were rewritten.
+func (j *Journal) Reseal() (int, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.codec == nil {
+		return 0, nil
+	}
+
+	entries, err := os.ReadDir(filepath.Join(j.dir, recordDirName))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read accounting directory: %w", err)
+	}
+	resealed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		rewritten, err := atrest.ResealFile(j.codec, filepath.Join(j.dir, recordDirName, entry.Name()))
+		if err != nil {
+			return resealed, fmt.Errorf("failed to reseal accounting record %s: %w", entry.Name(), err)
+		}
+		if rewritten {
+			resealed++
+		}
+	}
+
+	ledger, needsReseal, err := readLedger(j.ledgerPath, j.codec, true)
+	if err != nil || !needsReseal {
+		return resealed, err
+	}
+	var buf bytes.Buffer
+	for _, entry := range ledger {
+		line, err := encodeEntry(j.codec, entry)
+		if err != nil {
+			return resealed, err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := atrest.WriteFileAtomic(j.ledgerPath, buf.Bytes(), 0o600); err != nil {
+		return resealed, fmt.Errorf("failed to rewrite ledger: %w", err)
+	}
+	return resealed + 1, nil
+}
+
+func (j *Journal) path(taskID string) string {
+	return filepath.Join(j.dir, recordDirName, taskID+".json")
+}
+
+// Begin records taskID as claimed. Tasks already being accounted keep their
+// state.
+func (j *Journal) Begin(taskID string, taskType models.TaskType) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err := j.read(taskID); !errors.Is(err, ErrUnknownTask) {
+		return err
+	}
+	record := &Record{TaskID: taskID, Type: taskType, State: StateClaimed}
+	return j.write(record)
+}
+
+// Executing records that taskID started executing
+func (j *Journal) Executing(taskID string) error {
+	return j.advance(taskID, StateExecuting, nil)
+}
+
+// ResultPending records how taskID's execution ended, ahead of reporting
+// its result to the server
+func (j *Journal) ResultPending(taskID string, outcome Outcome) error {
+	return j.advance(taskID, StateResultPending, func(record *Record) {
+		record.Status = outcome.Status
+		record.Reward = outcome.Reward
+		record.DurationMs = outcome.Duration.Milliseconds()
+		record.Donated = outcome.Donated
+	})
+}
+
+// Reported records that the server has taskID's result, and ledgers it
+func (j *Journal) Reported(taskID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	record, err := j.read(taskID)
+	if err != nil {
+		return err
+	}
+	if err := j.move(record, StateReported, nil); err != nil {
+		return err
+	}
+	return j.ledger(record)
+}
+
+// Abandon drops taskID without ledgering it, when its execution ended
+// without a result to report. Tasks whose result is pending cannot be
+// abandoned.
+func (j *Journal) Abandon(taskID string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	record, err := j.read(taskID)
+	if errors.Is(err, ErrUnknownTask) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if record.State.index() >= StateResultPending.index() {
+		return fmt.Errorf("%w: task %s is %s", ErrInvalidTransition, taskID, record.State)
+	}
+	return j.remove(taskID)
+}
+
+// Reconcile finishes the transitions a previous process left part way.
+// Tasks still claimed or executing lost their execution and are rolled back;
+// pending results are reported through report and ledgered; reported tasks
+// are ledgered.
+func (j *Journal) Reconcile(report ReportFunc) (Reconciliation, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	log := gologger.WithComponent("accounting")
+
+	var summary Reconciliation
+	records, err := j.records()
+	if err != nil {
+		return summary, err
+	}
+	for _, record := range records {
+		switch record.State {
+		case StateClaimed, StateExecuting:
+			if err := j.remove(record.TaskID); err != nil {
+				return summary, err
+			}
+			log.Info().
+				Str("task_id", record.TaskID).
+				Str("state", string(record.State)).
+				Msg("Rolled back task interrupted before its result")
+			summary.RolledBack++
+			continue
+		case StateResultPending:
+			if err := report(*record); errors.Is(err, ErrResultLost) {
+				if err := j.remove(record.TaskID); err != nil {
+					return summary, err
+				}
+				log.Warn().
+					Err(err).
+					Str("task_id", record.TaskID).
+					Msg("Rolled back task whose result can no longer be reported")
+				summary.RolledBack++
+				continue
+			} else if err != nil {
+				log.Warn().
+					Err(err).
+					Str("task_id", record.TaskID).
+					Msg("Failed to report pending task result")
+				summary.Pending++
+				continue
+			}
+			if err := j.move(record, StateReported, nil); err != nil {
+				return summary, err
+			}
+		}
+		if err := j.ledger(record); err != nil {
+			return summary, err
+		}
+		summary.Completed++
+	}
+	return summary, nil
+}
+
+// Ledger returns the ledger's entries in the order they were written
+func (j *Journal) Ledger() ([]Entry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entries, _, err := readLedger(j.ledgerPath, j.codec, false)
+	return entries, err
+}
+
+// ReadLedger returns the entries of the ledger at path, opened with codec
+func ReadLedger(path string, codec atrest.Codec) ([]Entry, error) {
+	entries, _, err := readLedger(path, codec, false)
+	return entries, err
+}
+
+// readLedger reads the ledger at path, reporting whether any entry is
+// plaintext or sealed with an older key
+func readLedger(path string, codec atrest.Codec, migrating bool) ([]Entry, bool, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open ledger: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	needsReseal := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry, sealed, ok, err := decodeEntry(codec, scanner.Bytes(), migrating)
+		if err != nil {
+			return nil, false, err
+		}
+		// A line a crash cut short holds no entry
+		if !ok {
+			continue
+		}
+		if codec != nil && (sealed == nil || codec.NeedsReseal(sealed)) {
+			needsReseal = true
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to read ledger: %w", err)
+	}
+	return entries, needsReseal, nil
+}
+
+// encodeEntry returns the ledger line of entry, sealed with codec when set
+func encodeEntry(codec atrest.Codec, entry Entry) ([]byte, error) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ledger entry: %w", err)
+	}
+	if codec == nil {
+		return data, nil
+	}
+	sealed, err := codec.Seal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt ledger entry: %w", err)
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// decodeEntry parses a plaintext or encrypted ledger line, returning the
+// sealed bytes for encrypted lines. ok is false for lines that hold no
+// entry. With a codec set, plaintext lines are only accepted while
+// migrating.
+func decodeEntry(codec atrest.Codec, line []byte, migrating bool) (Entry, []byte, bool, error) {
+	var entry Entry
+	var sealed []byte
+
+	if len(line) > 0 && line[0] == '{' && codec != nil {
+		open := codec.Open
+		if migrating {
+			open = codec.Migrate
+		}
+		if _, err := open(line); err != nil {
+			return Entry{}, nil, false, fmt.Errorf("failed to read ledger entry: %w", err)
+		}
+	}
+	if len(line) > 0 && line[0] != '{' {
+		if codec == nil {
+			return Entry{}, nil, false, errors.New("ledger is encrypted but no data key is configured")
+		}
+		raw, err := base64.StdEncoding.DecodeString(string(line))
+		if err != nil {
+			return Entry{}, nil, false, nil
+		}
+		plaintext, err := codec.Open(raw)
+		if err != nil {
+			return Entry{}, nil, false, fmt.Errorf("failed to decrypt ledger entry: %w", err)
+		}
+		line, sealed = plaintext, raw
+	}
+
+	if err := json.Unmarshal(line, &entry); err != nil || entry.TaskID == "" {
+		return Entry{}, nil, false, nil
+	}
+	return entry, sealed, true, nil
+}
+
+// Fsck checks the journal kept in dir and those of the instances below it.
+// Unreadable records are quarantined; ledger lines that hold no entry are
+// dropped, keeping the ledger as it was in q. Entries that cannot be opened
+// with codec, because it is missing or lacks their key, are kept.
+func Fsck(dir string, codec atrest.Codec, q *fsck.Quarantine) ([]fsck.Finding, error) {
+	const store = "accounting"
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	findings, err := fsck.CheckJSONDir(store, filepath.Join(dir, recordDirName), codec, q, false)
+	if err != nil {
+		return findings, err
+	}
+	found, err := fsckLedger(filepath.Join(dir, LedgerFileName), codec, q)
+	findings = append(findings, found...)
+	if err != nil {
+		return findings, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == recordDirName {
+			continue
+		}
+		found, err := Fsck(filepath.Join(dir, entry.Name()), codec, q)
+		findings = append(findings, found...)
+		if err != nil {
+			return findings, err
+		}
+	}
+	return findings, nil
+}
+
+func fsckLedger(path string, codec atrest.Codec, q *fsck.Quarantine) ([]fsck.Finding, error) {
+	const store = "accounting"
+	findings, err := fsck.RemoveLeftovers(store, filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return findings, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return findings, nil
+	}
+	if err != nil {
+		return findings, fmt.Errorf("failed to read ledger: %w", err)
+	}
+
+	var kept bytes.Buffer
+	dropped := 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !recoverable(codec, line) {
+			dropped++
+			continue
+		}
+		kept.Write(line)
+		kept.WriteByte('\n')
+	}
+	if dropped == 0 {
+		return findings, nil
+	}
+
+	damaged := path + ".damaged"
+	if err := os.WriteFile(damaged, data, 0o600); err != nil {
+		return findings, fmt.Errorf("failed to keep damaged ledger: %w", err)
+	}
+	if err := q.Move(store, damaged); err != nil {
+		os.Remove(damaged)
+		return findings, err
+	}
+	if err := atrest.WriteFileAtomic(path, kept.Bytes(), 0o600); err != nil {
+		return findings, fmt.Errorf("failed to rewrite ledger: %w", err)
+	}
+	return append(findings, fsck.Finding{
+		Store:   store,
+		Item:    filepath.Base(path),
+		Problem: fmt.Sprintf("%d unreadable lines", dropped),
+		Action:  fsck.ActionRepaired,
+	}), nil
+}
+
+// recoverable reports whether line is a ledger entry, or may be one that
+// only a data key at hand could open
+func recoverable(codec atrest.Codec, line []byte) bool {
+	if line[0] != '{' && codec == nil {
+		return true
+	}
+	_, _, ok, err := decodeEntry(codec, line, true)
+	if err != nil {
+		return !errors.Is(err, atrest.ErrTampered)
+	}
+	return ok
+}
+
+// advance moves taskID to state, applying change to its record
+func (j *Journal) advance(taskID string, to State, change func(record *Record)) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	record, err := j.read(taskID)
+	if err != nil {
+		return err
+	}
+	return j.move(record, to, change)
+}
+
+// move persists record in state to. Moving to a state the task already
+// reached does nothing, so that every transition can be repeated.
+func (j *Journal) move(record *Record, to State, change func(record *Record)) error {
+	from := record.State.index()
+	if from >= to.index() {
+		return nil
+	}
+	if from+1 != to.index() {
+		return fmt.Errorf("%w: task %s cannot move from %s to %s", ErrInvalidTransition, record.TaskID, record.State, to)
+	}
+	if change != nil {
+		change(record)
+	}
+	record.State = to
+	return j.write(record)
+}
+
+// ledger writes the reported record to the ledger, unless a previous attempt
+// already did, and then drops it
+func (j *Journal) ledger(record *Record) error {
+	if record.State == StateReported {
+		if j.ledgered == nil {
+			entries, _, err := readLedger(j.ledgerPath, j.codec, false)
+			if err != nil {
+				return err
+			}
+			j.ledgered = make(map[string]bool, len(entries))
+			for _, entry := range entries {
+				j.ledgered[entry.TaskID] = true
+			}
+		}
+		if !j.ledgered[record.TaskID] {
+			if err := j.append(Entry{
+				TaskID:     record.TaskID,
+				Type:       record.Type,
+				Status:     record.Status,
+				Reward:     record.Reward,
+				DurationMs: record.DurationMs,
+				Donated:    record.Donated,
+				LedgeredAt: j.clock.Now().UTC(),
+			}); err != nil {
+				return err
+			}
+			j.ledgered[record.TaskID] = true
+		}
+		if err := j.step("ledger_append"); err != nil {
+			return err
+		}
+		if err := j.move(record, StateLedgered, nil); err != nil {
+			return err
+		}
+	}
+	return j.remove(record.TaskID)
+}
+
+func (j *Journal) append(entry Entry) error {
+	data, err := encodeEntry(j.codec, entry)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(j.ledgerPath, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open ledger: %w", err)
+	}
+	defer f.Close()
+
+	// A line a crash cut short is ended first, so that it does not swallow
+	// this entry too
+	line := append(data, '\n')
+	if info, err := f.Stat(); err == nil && info.Size() > 0 {
+		last := make([]byte, 1)
+		if _, err := f.ReadAt(last, info.Size()-1); err == nil && last[0] != '\n' {
+			line = append([]byte{'\n'}, line...)
+		}
+	}
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("failed to append ledger entry: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync ledger: %w", err)
+	}
+	return nil
+}
+
+func (j *Journal) read(taskID string) (*Record, error) {
+	data, err := atrest.ReadFile(j.codec, j.path(taskID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTask, taskID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accounting record: %w", err)
+	}
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil || record.State.index() < 0 {
+		return nil, fmt.Errorf("accounting record of task %s is unreadable", taskID)
+	}
+	return &record, nil
+}
+
+func (j *Journal) write(record *Record) error {
+	record.UpdatedAt = j.clock.Now().UTC()
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal accounting record: %w", err)
+	}
+	if err := atrest.WriteFile(j.codec, j.path(record.TaskID), data, 0o600); err != nil {
+		return fmt.Errorf("failed to save accounting record: %w", err)
+	}
+	return j.step(string(record.State))
+}
+
+func (j *Journal) remove(taskID string) error {
+	if err := os.Remove(j.path(taskID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove accounting record: %w", err)
+	}
+	return nil
+}
+
+// records returns the records of every task in flight. Unreadable records
+// are skipped.
+func (j *Journal) records() ([]*Record, error) {
+	entries, err := os.ReadDir(filepath.Join(j.dir, recordDirName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read accounting directory: %w", err)
+	}
+	var records []*Record
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		record, err := j.read(strings.TrimSuffix(entry.Name(), ".json"))
+		if err != nil {
+			log := gologger.WithComponent("accounting")
+			log.Warn().Err(err).Str("file", entry.Name()).Msg("Ignoring unreadable accounting record")
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+func (j *Journal) step(name string) error {
+	if j.fault == nil {
+		return nil
+	}
+	return j.fault(name)
+}
@@ -0,0 +1,365 @@
+package accounting
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var errCrash = errors.New("simulated crash")
+
+// steps are the durable steps of a task that is ledgered, in order
+var steps = []string{"claimed", "executing", "result_pending", "reported", "ledger_append", "ledgered"}
+
+func openJournal(t *testing.T, dir string) *Journal {
+	t.Helper()
+	j, err := Open(dir)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return j
+}
+
+// crashAt makes j stop, as if the process died, once step is durable
+func crashAt(j *Journal, step string) {
+	j.fault = func(name string) error {
+		if name == step {
+			return errCrash
+		}
+		return nil
+	}
+}
+
+// complete drives taskID through the whole pipeline, stopping at the first error
+func complete(j *Journal, taskID string) error {
+	if err := j.Begin(taskID, models.TaskTypeDocker); err != nil {
+		return err
+	}
+	if err := j.Executing(taskID); err != nil {
+		return err
+	}
+	outcome := Outcome{Status: models.TaskStatusCompleted, Reward: 1.5, Duration: 3 * time.Second}
+	if err := j.ResultPending(taskID, outcome); err != nil {
+		return err
+	}
+	return j.Reported(taskID)
+}
+
+func ledgerCounts(t *testing.T, j *Journal) map[string]int {
+	t.Helper()
+	entries, err := j.Ledger()
+	if err != nil {
+		t.Fatalf("Ledger: %v", err)
+	}
+	counts := make(map[string]int)
+	for _, entry := range entries {
+		counts[entry.TaskID]++
+	}
+	return counts
+}
+
+func assertNoRecords(t *testing.T, dir string) {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Join(dir, recordDirName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("record %s left after reconciliation", entry.Name())
+	}
+}
+
+func TestPipelineLedgersOnce(t *testing.T) {
+	dir := t.TempDir()
+	j := openJournal(t, dir)
+	if err := complete(j, "task-1"); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	// Every transition can be repeated without ledgering the task again
+	if err := j.Reported("task-1"); !errors.Is(err, ErrUnknownTask) {
+		t.Errorf("Reported after ledgering = %v, want ErrUnknownTask", err)
+	}
+
+	entries, err := j.Ledger()
+	if err != nil {
+		t.Fatalf("Ledger: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("ledger holds %d entries, want 1", len(entries))
+	}
+	entry := entries[0]
+	if entry.TaskID != "task-1" || entry.Status != models.TaskStatusCompleted || entry.Reward != 1.5 || entry.DurationMs != 3000 {
+		t.Errorf("entry = %+v", entry)
+	}
+	assertNoRecords(t, dir)
+}
+
+func TestTransitions(t *testing.T) {
+	j := openJournal(t, t.TempDir())
+
+	if err := j.Executing("missing"); !errors.Is(err, ErrUnknownTask) {
+		t.Errorf("Executing unknown task = %v, want ErrUnknownTask", err)
+	}
+	if err := j.Begin("task-1", models.TaskTypeDocker); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.ResultPending("task-1", Outcome{Status: models.TaskStatusCompleted}); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("skipping executing = %v, want ErrInvalidTransition", err)
+	}
+	if err := j.Executing("task-1"); err != nil {
+		t.Fatal(err)
+	}
+	// Repeated and earlier transitions leave the state alone
+	if err := j.Executing("task-1"); err != nil {
+		t.Errorf("repeated Executing = %v", err)
+	}
+	if err := j.Begin("task-1", models.TaskTypeDocker); err != nil {
+		t.Errorf("repeated Begin = %v", err)
+	}
+	if err := j.ResultPending("task-1", Outcome{Status: models.TaskStatusFailed}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Abandon("task-1"); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("abandoning a pending result = %v, want ErrInvalidTransition", err)
+	}
+
+	if err := j.Begin("task-2", models.TaskTypeDocker); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Abandon("task-2"); err != nil {
+		t.Errorf("Abandon = %v", err)
+	}
+	if err := j.Abandon("task-2"); err != nil {
+		t.Errorf("repeated Abandon = %v", err)
+	}
+	if got := ledgerCounts(t, j); len(got) != 0 {
+		t.Errorf("ledger = %v, want empty", got)
+	}
+}
+
+// TestCrashBetweenTransitions stops the process after every step of the
+// pipeline and checks that reconciliation ledgers the task exactly once when
+// its result reached the server, and never otherwise
+func TestCrashBetweenTransitions(t *testing.T) {
+	reports := map[string]error{
+		"reported": nil,
+		"lost":     fmt.Errorf("outbox empty: %w", ErrResultLost),
+	}
+	for i, step := range steps {
+		for name, reportErr := range reports {
+			t.Run(step+"/"+name, func(t *testing.T) {
+				dir := t.TempDir()
+				j := openJournal(t, dir)
+				crashAt(j, step)
+				if err := complete(j, "task-1"); !errors.Is(err, errCrash) {
+					t.Fatalf("complete = %v, want crash at %s", err, step)
+				}
+
+				resent := 0
+				restarted := openJournal(t, dir)
+				for range 2 {
+					if _, err := restarted.Reconcile(func(Record) error {
+						resent++
+						return reportErr
+					}); err != nil {
+						t.Fatalf("Reconcile: %v", err)
+					}
+				}
+
+				want := 0
+				switch {
+				case i > 2:
+					want = 1
+				case i == 2:
+					if resent != 1 {
+						t.Errorf("pending result reported %d times, want 1", resent)
+					}
+					if reportErr == nil {
+						want = 1
+					}
+				case resent != 0:
+					t.Errorf("interrupted execution reported %d times", resent)
+				}
+				if got := ledgerCounts(t, restarted)["task-1"]; got != want {
+					t.Errorf("task ledgered %d times, want %d", got, want)
+				}
+				assertNoRecords(t, dir)
+			})
+		}
+	}
+}
+
+// TestLedgersEachTaskOnce runs batches of tasks through random sequences of
+// crashes, restarts and report outcomes, re-running the tasks rolled back,
+// and checks that every task ends up ledgered exactly once
+func TestLedgersEachTaskOnce(t *testing.T) {
+	for seed := int64(0); seed < 200; seed++ {
+		rng := rand.New(rand.NewSource(seed))
+		dir := t.TempDir()
+
+		var tasks []string
+		for i := range 1 + rng.Intn(6) {
+			tasks = append(tasks, fmt.Sprintf("task-%d", i))
+		}
+
+		for restart := 0; ; restart++ {
+			j := openJournal(t, dir)
+			clean := restart >= 8
+			if !clean {
+				// Crash after a random number of durable steps
+				remaining := 1 + rng.Intn(4*len(steps)*len(tasks))
+				j.fault = func(string) error {
+					if remaining--; remaining == 0 {
+						return errCrash
+					}
+					return nil
+				}
+			}
+
+			_, err := j.Reconcile(func(Record) error {
+				switch n := rng.Intn(10); {
+				case clean || n < 6:
+					return nil
+				case n < 8:
+					return ErrResultLost
+				default:
+					return errors.New("server unavailable")
+				}
+			})
+			if errors.Is(err, errCrash) {
+				continue
+			}
+			if err != nil {
+				t.Fatalf("seed %d: Reconcile: %v", seed, err)
+			}
+
+			ledgered := ledgerCounts(t, j)
+			crashed := false
+			for _, i := range rng.Perm(len(tasks)) {
+				taskID := tasks[i]
+				if ledgered[taskID] > 0 {
+					continue
+				}
+				if err := runOnce(j, rng, taskID, clean); errors.Is(err, errCrash) {
+					crashed = true
+					break
+				} else if err != nil {
+					t.Fatalf("seed %d: %s: %v", seed, taskID, err)
+				}
+			}
+			if crashed {
+				continue
+			}
+
+			ledgered = ledgerCounts(t, j)
+			done := true
+			for _, taskID := range tasks {
+				if ledgered[taskID] > 1 {
+					t.Fatalf("seed %d: %s ledgered %d times", seed, taskID, ledgered[taskID])
+				}
+				done = done && ledgered[taskID] == 1
+			}
+			if done {
+				assertNoRecords(t, dir)
+				break
+			}
+			if clean {
+				t.Fatalf("seed %d: tasks left unledgered after a clean run: %v", seed, ledgered)
+			}
+		}
+	}
+}
+
+// runOnce runs taskID as the task handler would: a run may be abandoned once
+// executing, and its report may fail, leaving the result pending
+func runOnce(j *Journal, rng *rand.Rand, taskID string, clean bool) error {
+	if err := j.Begin(taskID, models.TaskTypeDocker); err != nil {
+		return err
+	}
+	if err := j.Executing(taskID); err != nil {
+		return err
+	}
+	if !clean && rng.Intn(5) == 0 {
+		// A result left pending by an earlier run cannot be abandoned and
+		// is reported instead
+		if err := j.Abandon(taskID); !errors.Is(err, ErrInvalidTransition) {
+			return err
+		}
+	}
+	if err := j.ResultPending(taskID, Outcome{Status: models.TaskStatusCompleted, Reward: 1}); err != nil {
+		return err
+	}
+	if !clean && rng.Intn(5) == 0 {
+		// The report failed; the result stays pending for the reconciler
+		return nil
+	}
+	return j.Reported(taskID)
+}
+
+func TestJournalMigratesPlaintext(t *testing.T) {
+	dir := t.TempDir()
+	j := openJournal(t, dir)
+	if err := complete(j, "task-1"); err != nil {
+		t.Fatalf("complete: %v", err)
+	}
+	if err := j.Begin("task-2", models.TaskTypeDocker); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := atrest.OpenKeyring(filepath.Join(t.TempDir(), "datakeys.json"), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.SetCodec(keyring)
+	resealed, err := j.Reseal()
+	if err != nil || resealed != 2 {
+		t.Fatalf("Reseal() = %d, %v, want the record and the ledger", resealed, err)
+	}
+	if err := complete(j, "task-3"); err != nil {
+		t.Fatalf("complete sealed: %v", err)
+	}
+	if err := j.Executing("task-2"); err != nil {
+		t.Fatalf("Executing sealed record: %v", err)
+	}
+
+	for _, name := range []string{LedgerFileName, filepath.Join(recordDirName, "task-2.json")} {
+		raw, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(raw), "task_id") {
+			t.Errorf("%s holds plaintext after migration:\n%s", name, raw)
+		}
+	}
+
+	// A journal without the key cannot read the sealed ledger
+	if _, err := openJournal(t, dir).Ledger(); err == nil {
+		t.Error("sealed ledger read without a data key")
+	}
+	counts := ledgerCounts(t, j)
+	if len(counts) != 2 || counts["task-1"] != 1 || counts["task-3"] != 1 {
+		t.Errorf("ledger = %v, want task-1 and task-3 once", counts)
+	}
+
+	// Once migrated, plaintext entries are refused
+	if err := keyring.SetMigrated(true); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, LedgerFileName), os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintln(f, `{"task_id":"forged","reward":100}`)
+	f.Close()
+	if _, err := j.Ledger(); !errors.Is(err, atrest.ErrPlaintext) {
+		t.Errorf("Ledger with a plaintext entry = %v, want ErrPlaintext", err)
+	}
+}
@@ -0,0 +1,80 @@
+package anomaly
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/callback"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+// alertTimeout bounds delivering one alert
+const alertTimeout = 10 * time.Second
+
+// Webhook posts alerts to the operator's URL as JSON, signed with the
+// runner's key as creator callbacks are
+type Webhook struct {
+	url    string
+	signer signer.Signer
+	client *http.Client
+}
+
+// NewWebhook returns a webhook posting to rawURL
+func NewWebhook(rawURL string, s signer.Signer) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid anomaly alert URL %q", rawURL)
+	}
+	return &Webhook{url: rawURL, signer: s, client: &http.Client{Timeout: alertTimeout}}, nil
+}
+
+// Alert posts alert in the background, so the result it is about is never
+// kept waiting on the operator's endpoint; failures are logged
+func (w *Webhook) Alert(alert Alert) {
+	go func() {
+		if err := w.post(alert); err != nil {
+			log := gologger.WithComponent("anomaly")
+			log.Warn().Err(err).Str("task_id", alert.TaskID).Msg("Failed to deliver anomaly alert")
+		}
+	}()
+}
+
+func (w *Webhook) post(alert Alert) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("failed to encode anomaly alert: %w", err)
+	}
+	signature, err := callback.Sign(body, w.signer)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(callback.SignatureHeader, signature)
+	req.Header.Set(callback.RunnerHeader, w.signer.Address().Hex())
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("anomaly alert returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package anomaly
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/theblitlabs/parity-runner/internal/callback"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+func TestWebhookPostsSignedAlert(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := signer.FromECDSA(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	received := make(chan Alert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if signer, err := callback.VerifySignature(body, r.Header.Get(callback.SignatureHeader)); err != nil || signer != s.Address() {
+			t.Errorf("alert signed by %s, %v, want %s", signer.Hex(), err, s.Address().Hex())
+		}
+		var alert Alert
+		json.Unmarshal(body, &alert)
+		received <- alert
+	}))
+	defer server.Close()
+
+	webhook, err := NewWebhook(server.URL, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := webhook.post(Alert{TaskID: "task-1", Held: true, Anomaly: &models.ResultAnomaly{Severity: models.AnomalyCritical}}); err != nil {
+		t.Fatal(err)
+	}
+	if alert := <-received; alert.TaskID != "task-1" || !alert.Held || alert.Anomaly.Severity != models.AnomalyCritical {
+		t.Errorf("received %+v", alert)
+	}
+
+	if _, err := NewWebhook("ftp://example.com", s); err == nil {
+		t.Error("NewWebhook() accepted a non-HTTP URL")
+	}
+}
@@ -0,0 +1,466 @@
+// Package anomaly watches the results a runner submits for signs of a fault
+// of its own, such as a broken model backend answering every prompt with
+// nothing, before the network notices and slashes it. For each task type it
+// keeps the output sizes, durations, response tokens and exit codes of its
+// latest results, and compares each new result against them by z-score or
+// by quantile. Until a type has enough results for that, cold-start rules
+// catch the plainly broken: empty outputs and responses without tokens. An
+// anomalous result is flagged on submission; in hold mode the worst ones
+// are held for the operator to approve first.
+package anomaly
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ErrNotHeld is returned when deciding on a result that is not held
+var ErrNotHeld = errors.New("result is not held for review")
+
+// Metrics a result is compared on
+const (
+	MetricOutputBytes    = "output_bytes"
+	MetricDuration       = "duration"
+	MetricResponseTokens = "response_tokens"
+	MetricExitCode       = "exit_code"
+)
+
+// Rule is how a value is found anomalous
+type Rule string
+
+const (
+	// RuleZScore flags values more than Threshold standard deviations
+	// from the mean
+	RuleZScore Rule = "zscore"
+	// RuleQuantile flags values below the Quantile quantile or above the
+	// 1-Quantile quantile
+	RuleQuantile Rule = "quantile"
+)
+
+// Mode is what is done with an anomalous result
+type Mode string
+
+const (
+	// ModeFlag submits every anomalous result with its anomaly noted
+	ModeFlag Mode = "flag"
+	// ModeHold holds critical results until the operator approves or
+	// rejects them, or HoldTimeout passes
+	ModeHold Mode = "hold"
+)
+
+// rareExitShare is the share of a type's results below which a failing
+// exit code counts as unusual
+const rareExitShare = 0.02
+
+// relativeSpread is the least spread assumed of a metric, relative to its
+// mean, so that a type whose results never varied does not flag the
+// slightest change
+const relativeSpread = 0.05
+
+// Policy is how results are judged
+type Policy struct {
+	Rule Rule
+	// Threshold is the z-score from which a value is anomalous
+	Threshold float64
+	// Quantile is the share of results in each tail of the usual range
+	Quantile float64
+	// Window is how many of a type's latest results are compared against,
+	// and MinSamples how many it needs before they are
+	Window     int
+	MinSamples int
+	Mode       Mode
+	// CriticalScore is the score, 1 at the edge of the usual range, from
+	// which an anomaly is critical
+	CriticalScore float64
+	// HoldTimeout is how long a held result waits for the operator before
+	// it is submitted flagged
+	HoldTimeout time.Duration
+}
+
+// Sample is what is compared of a result
+type Sample struct {
+	Type        models.TaskType
+	ExitCode    int
+	OutputBytes int64
+	Duration    time.Duration
+	// ResponseTokens is compared for LLM tasks only
+	ResponseTokens int
+}
+
+// values returns the metrics of s compared by rule. Only successful
+// results are compared on more than their exit code.
+func (s Sample) values() map[string]float64 {
+	if s.ExitCode != 0 {
+		return nil
+	}
+	values := map[string]float64{
+		MetricOutputBytes: float64(s.OutputBytes),
+		MetricDuration:    float64(s.Duration.Milliseconds()),
+	}
+	if s.Type == models.TaskTypeLLM {
+		values[MetricResponseTokens] = float64(s.ResponseTokens)
+	}
+	return values
+}
+
+// history is the latest results of one task type
+type history struct {
+	values    map[string][]float64
+	exitCodes []int
+}
+
+func (h *history) add(s Sample, window int) {
+	h.exitCodes = push(h.exitCodes, s.ExitCode, window)
+	for metric, v := range s.values() {
+		h.values[metric] = push(h.values[metric], v, window)
+	}
+}
+
+func push[T any](values []T, v T, window int) []T {
+	values = append(values, v)
+	if len(values) > window {
+		values = append(values[:0], values[len(values)-window:]...)
+	}
+	return values
+}
+
+// held is a result awaiting the operator
+type held struct {
+	info   models.HeldResult
+	decide chan bool
+}
+
+// Detector reviews the results of a runner
+type Detector struct {
+	policy  Policy
+	clock   clock.Clock
+	onAlert func(Alert)
+
+	mu       sync.Mutex
+	types    map[models.TaskType]*history
+	held     map[string]*held
+	checked  uint64
+	flagged  uint64
+	rejected uint64
+}
+
+// Alert is an anomalous result, raised before it is held or submitted
+type Alert struct {
+	TaskID   string                `json:"task_id"`
+	Type     models.TaskType       `json:"type"`
+	Instance string                `json:"instance,omitempty"`
+	Time     time.Time             `json:"time"`
+	Held     bool                  `json:"held"`
+	Anomaly  *models.ResultAnomaly `json:"anomaly"`
+}
+
+// New returns a detector judging results by policy
+func New(policy Policy) (*Detector, error) {
+	switch policy.Rule {
+	case RuleZScore:
+		if policy.Threshold <= 0 {
+			return nil, fmt.Errorf("anomaly z-score threshold must be positive, got %g", policy.Threshold)
+		}
+	case RuleQuantile:
+		if policy.Quantile <= 0 || policy.Quantile >= 0.5 {
+			return nil, fmt.Errorf("anomaly quantile must be within (0, 0.5), got %g", policy.Quantile)
+		}
+	default:
+		return nil, fmt.Errorf("unknown anomaly rule %q (supported: %s, %s)", policy.Rule, RuleZScore, RuleQuantile)
+	}
+	switch policy.Mode {
+	case ModeFlag, ModeHold:
+	default:
+		return nil, fmt.Errorf("unknown anomaly mode %q (supported: %s, %s)", policy.Mode, ModeFlag, ModeHold)
+	}
+	if policy.MinSamples < 2 || policy.Window < policy.MinSamples {
+		return nil, fmt.Errorf("anomaly window of %d must hold at least the %d samples needed, and those at least 2", policy.Window, policy.MinSamples)
+	}
+	if policy.CriticalScore < 1 {
+		return nil, fmt.Errorf("anomaly critical score must be at least 1, got %g", policy.CriticalScore)
+	}
+	if policy.Mode == ModeHold && policy.HoldTimeout <= 0 {
+		return nil, fmt.Errorf("anomaly hold timeout must be positive, got %s", policy.HoldTimeout)
+	}
+	return &Detector{
+		policy: policy,
+		clock:  clock.Real(),
+		types:  make(map[models.TaskType]*history),
+		held:   make(map[string]*held),
+	}, nil
+}
+
+// SetClock replaces the clock held results time out by
+func (d *Detector) SetClock(c clock.Clock) {
+	d.clock = c
+}
+
+// OnAlert calls fn for every anomalous result, before it is held or
+// submitted. fn must not block.
+func (d *Detector) OnAlert(fn func(Alert)) {
+	d.onAlert = fn
+}
+
+// Seed records samples as earlier results, such as those of a previous run
+func (d *Detector) Seed(samples []Sample) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range samples {
+		d.history(s.Type).add(s, d.policy.Window)
+	}
+}
+
+func (d *Detector) history(taskType models.TaskType) *history {
+	h, ok := d.types[taskType]
+	if !ok {
+		h = &history{values: make(map[string][]float64)}
+		d.types[taskType] = h
+	}
+	return h
+}
+
+// Review judges the result of taskID, returning its anomaly or nil when it
+// is in line with the type's earlier results. A critical anomaly in hold
+// mode blocks until the operator decides, HoldTimeout passes or ctx ends;
+// only the results of that task wait. Every result but a rejected one
+// joins the baseline later results are compared against.
+func (d *Detector) Review(ctx context.Context, taskID, instance string, s Sample) *models.ResultAnomaly {
+	d.mu.Lock()
+	d.checked++
+	anomaly := d.check(s)
+	if anomaly == nil {
+		d.history(s.Type).add(s, d.policy.Window)
+		d.mu.Unlock()
+		return nil
+	}
+	hold := d.policy.Mode == ModeHold && anomaly.Severity == models.AnomalyCritical
+	var entry *held
+	if hold {
+		now := d.clock.Now()
+		entry = &held{
+			info: models.HeldResult{
+				TaskID:   taskID,
+				Type:     s.Type,
+				Instance: instance,
+				HeldAt:   now,
+				Deadline: now.Add(d.policy.HoldTimeout),
+				Anomaly:  anomaly,
+			},
+			decide: make(chan bool, 1),
+		}
+		d.held[taskID] = entry
+	}
+	d.mu.Unlock()
+
+	if d.onAlert != nil {
+		d.onAlert(Alert{TaskID: taskID, Type: s.Type, Instance: instance, Time: d.clock.Now(), Held: hold, Anomaly: anomaly})
+	}
+
+	anomaly.Decision = models.AnomalyFlagged
+	if hold {
+		anomaly.Decision = d.wait(ctx, entry)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.held, taskID)
+	if anomaly.Decision == models.AnomalyRejected {
+		d.rejected++
+		return anomaly
+	}
+	d.flagged++
+	d.history(s.Type).add(s, d.policy.Window)
+	return anomaly
+}
+
+// wait returns the operator's decision on entry, timed out when none comes
+func (d *Detector) wait(ctx context.Context, entry *held) string {
+	timer := d.clock.NewTimer(d.policy.HoldTimeout)
+	defer timer.Stop()
+	select {
+	case approve := <-entry.decide:
+		if approve {
+			return models.AnomalyApproved
+		}
+		return models.AnomalyRejected
+	case <-timer.C():
+	case <-ctx.Done():
+	}
+	return models.AnomalyTimedOut
+}
+
+// Decide approves or rejects the held result of taskID
+func (d *Detector) Decide(taskID string, approve bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.held[taskID]
+	if !ok {
+		return fmt.Errorf("%w: task %s", ErrNotHeld, taskID)
+	}
+	delete(d.held, taskID)
+	entry.decide <- approve
+	return nil
+}
+
+// Status reports the detector; it is safe on a nil detector
+func (d *Detector) Status() *models.AnomalyStatus {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := &models.AnomalyStatus{Checked: d.checked, Flagged: d.flagged, Rejected: d.rejected}
+	for _, entry := range d.held {
+		status.Held = append(status.Held, entry.info)
+	}
+	sort.Slice(status.Held, func(i, j int) bool { return status.Held[i].HeldAt.Before(status.Held[j].HeldAt) })
+	return status
+}
+
+// check returns the anomaly of s against its type's history, nil when
+// there is none. d.mu must be held.
+func (d *Detector) check(s Sample) *models.ResultAnomaly {
+	h := d.history(s.Type)
+	baseline := len(h.exitCodes)
+	var findings []models.AnomalyFinding
+
+	// A response without tokens is never a model working as it should
+	if s.Type == models.TaskTypeLLM && s.ExitCode == 0 && s.ResponseTokens == 0 {
+		findings = append(findings, models.AnomalyFinding{
+			Metric: MetricResponseTokens,
+			Score:  d.policy.CriticalScore,
+			Reason: "response has no tokens",
+		})
+	}
+
+	if baseline < d.policy.MinSamples {
+		if s.ExitCode == 0 && s.OutputBytes == 0 && s.Type != models.TaskTypeLLM {
+			findings = append(findings, models.AnomalyFinding{
+				Metric: MetricOutputBytes,
+				Score:  1,
+				Reason: "successful task produced no output",
+			})
+		}
+		baseline = 0
+	} else {
+		for metric, v := range s.values() {
+			if metric == MetricResponseTokens && v == 0 {
+				continue
+			}
+			values := h.values[metric]
+			if len(values) < d.policy.MinSamples {
+				continue
+			}
+			if finding, ok := d.judge(metric, v, values); ok {
+				findings = append(findings, finding)
+			}
+		}
+		if finding, ok := judgeExitCode(s.ExitCode, h.exitCodes); ok {
+			findings = append(findings, finding)
+		}
+	}
+	if len(findings) == 0 {
+		return nil
+	}
+
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Score > findings[j].Score })
+	severity := models.AnomalyWarning
+	if findings[0].Score >= d.policy.CriticalScore {
+		severity = models.AnomalyCritical
+	}
+	return &models.ResultAnomaly{Severity: severity, Findings: findings, Baseline: baseline}
+}
+
+// judge compares v with the earlier values of metric by the policy's rule
+func (d *Detector) judge(metric string, v float64, values []float64) (models.AnomalyFinding, bool) {
+	finding := models.AnomalyFinding{Metric: metric, Value: v}
+	switch d.policy.Rule {
+	case RuleQuantile:
+		sorted := append([]float64(nil), values...)
+		sort.Float64s(sorted)
+		lo, hi := quantile(sorted, d.policy.Quantile), quantile(sorted, 1-d.policy.Quantile)
+		finding.Expected = quantile(sorted, 0.5)
+		width := spread(hi-lo, finding.Expected)
+		switch {
+		case v < lo:
+			finding.Score = 1 + (lo-v)/width
+			finding.Reason = fmt.Sprintf("below the %g quantile of %g", d.policy.Quantile, lo)
+		case v > hi:
+			finding.Score = 1 + (v-hi)/width
+			finding.Reason = fmt.Sprintf("above the %g quantile of %g", 1-d.policy.Quantile, hi)
+		default:
+			return finding, false
+		}
+	default:
+		mean, std := meanStd(values)
+		finding.Expected = mean
+		z := (v - mean) / spread(std, mean)
+		finding.Score = math.Abs(z) / d.policy.Threshold
+		if finding.Score < 1 {
+			return finding, false
+		}
+		finding.Reason = fmt.Sprintf("z-score of %.1f", z)
+	}
+	return finding, true
+}
+
+// judgeExitCode flags a failing exit code the type rarely or never exited
+// with before
+func judgeExitCode(code int, codes []int) (models.AnomalyFinding, bool) {
+	if code == 0 || len(codes) == 0 {
+		return models.AnomalyFinding{}, false
+	}
+	seen := 0
+	for _, c := range codes {
+		if c == code {
+			seen++
+		}
+	}
+	share := float64(seen) / float64(len(codes))
+	if share >= rareExitShare {
+		return models.AnomalyFinding{}, false
+	}
+	return models.AnomalyFinding{
+		Metric:   MetricExitCode,
+		Value:    float64(code),
+		Expected: 0,
+		Score:    1,
+		Reason:   fmt.Sprintf("exit code seen in %d of the last %d results", seen, len(codes)),
+	}, true
+}
+
+func meanStd(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(squares / float64(len(values)))
+}
+
+// quantile returns the q quantile of sorted, interpolating between values
+func quantile(sorted []float64, q float64) float64 {
+	pos := q * float64(len(sorted)-1)
+	i := int(pos)
+	if i+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[i] + (sorted[i+1]-sorted[i])*(pos-float64(i))
+}
+
+// spread returns s, but at least relativeSpread of typical and at least 1
+func spread(s, typical float64) float64 {
+	return math.Max(s, math.Max(relativeSpread*math.Abs(typical), 1))
+}
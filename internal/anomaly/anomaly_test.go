@@ -0,0 +1,262 @@
+package anomaly
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func testPolicy(mode Mode) Policy {
+	return Policy{
+		Rule:          RuleZScore,
+		Threshold:     4,
+		Quantile:      0.01,
+		Window:        200,
+		MinSamples:    20,
+		Mode:          mode,
+		CriticalScore: 2,
+		HoldTimeout:   time.Minute,
+	}
+}
+
+// seed returns n results of taskType varying around 10 KB of output in
+// about 30 seconds, and for LLM tasks around 400 response tokens
+func seed(taskType models.TaskType, n int) []Sample {
+	rng := rand.New(rand.NewSource(1))
+	samples := make([]Sample, n)
+	for i := range samples {
+		samples[i] = Sample{
+			Type:        taskType,
+			OutputBytes: 10_000 + rng.Int63n(1_000),
+			Duration:    30*time.Second + time.Duration(rng.Int63n(int64(3*time.Second))),
+		}
+		if taskType == models.TaskTypeLLM {
+			samples[i].ResponseTokens = 400 + rng.Intn(40)
+		}
+		if i%20 == 0 {
+			samples[i].ExitCode = 1
+		}
+	}
+	return samples
+}
+
+type alerts struct {
+	mu     sync.Mutex
+	alerts []Alert
+}
+
+func (a *alerts) add(alert Alert) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.alerts = append(a.alerts, alert)
+}
+
+func (a *alerts) len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.alerts)
+}
+
+func newDetector(t *testing.T, policy Policy) (*Detector, *alerts) {
+	t.Helper()
+	d, err := New(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := &alerts{}
+	d.OnAlert(got.add)
+	return d, got
+}
+
+func TestFlagModeFlagsInjectedAnomalies(t *testing.T) {
+	d, alerts := newDetector(t, testPolicy(ModeFlag))
+	d.Seed(seed(models.TaskTypeDocker, 200))
+	d.Seed(seed(models.TaskTypeLLM, 200))
+	ctx := context.Background()
+
+	if a := d.Review(ctx, "normal", "", Sample{Type: models.TaskTypeDocker, OutputBytes: 10_500, Duration: 31 * time.Second}); a != nil {
+		t.Fatalf("Review() of a usual result = %+v", a)
+	}
+	// A task that used to fail with 1 now and then, failing with 1 again
+	if a := d.Review(ctx, "usual-failure", "", Sample{Type: models.TaskTypeDocker, ExitCode: 1}); a != nil {
+		t.Fatalf("Review() of a usual failure = %+v", a)
+	}
+
+	tests := []struct {
+		name     string
+		sample   Sample
+		metric   string
+		severity string
+	}{
+		{"empty output", Sample{Type: models.TaskTypeDocker, Duration: 31 * time.Second}, MetricOutputBytes, models.AnomalyCritical},
+		{"slow", Sample{Type: models.TaskTypeDocker, OutputBytes: 10_500, Duration: 40 * time.Second}, MetricDuration, models.AnomalyWarning},
+		{"new exit code", Sample{Type: models.TaskTypeDocker, ExitCode: 137}, MetricExitCode, models.AnomalyWarning},
+		{"no tokens", Sample{Type: models.TaskTypeLLM, OutputBytes: 10_500, Duration: 31 * time.Second}, MetricResponseTokens, models.AnomalyCritical},
+	}
+	for _, tt := range tests {
+		a := d.Review(ctx, tt.name, "", tt.sample)
+		if a == nil {
+			t.Errorf("%s: Review() found no anomaly", tt.name)
+			continue
+		}
+		if a.Findings[0].Metric != tt.metric || a.Severity != tt.severity || a.Decision != models.AnomalyFlagged {
+			t.Errorf("%s: Review() = %+v, want %s %s flagged", tt.name, a, tt.severity, tt.metric)
+		}
+		if a.Baseline != 200 {
+			t.Errorf("%s: baseline = %d, want the 200 seeded results", tt.name, a.Baseline)
+		}
+	}
+	if alerts.len() != len(tests) {
+		t.Errorf("%d alerts raised, want %d", alerts.len(), len(tests))
+	}
+	if status := d.Status(); status.Checked != 6 || status.Flagged != 4 || len(status.Held) != 0 {
+		t.Errorf("Status() = %+v", status)
+	}
+}
+
+func TestQuantileRule(t *testing.T) {
+	policy := testPolicy(ModeFlag)
+	policy.Rule = RuleQuantile
+	d, _ := newDetector(t, policy)
+	d.Seed(seed(models.TaskTypeCommand, 200))
+
+	if a := d.Review(context.Background(), "big", "", Sample{Type: models.TaskTypeCommand, OutputBytes: 50_000, Duration: 31 * time.Second}); a == nil || a.Findings[0].Metric != MetricOutputBytes || a.Severity != models.AnomalyCritical {
+		t.Fatalf("Review() = %+v, want a critical output size anomaly", a)
+	}
+	if a := d.Review(context.Background(), "usual", "", Sample{Type: models.TaskTypeCommand, OutputBytes: 10_500, Duration: 31 * time.Second}); a != nil {
+		t.Fatalf("Review() of a usual result = %+v", a)
+	}
+}
+
+func TestColdStart(t *testing.T) {
+	d, _ := newDetector(t, testPolicy(ModeFlag))
+	ctx := context.Background()
+
+	// Without a baseline, any size and duration passes
+	if a := d.Review(ctx, "first", "", Sample{Type: models.TaskTypeDocker, OutputBytes: 5, Duration: time.Hour}); a != nil {
+		t.Fatalf("Review() without a baseline = %+v", a)
+	}
+	a := d.Review(ctx, "empty", "", Sample{Type: models.TaskTypeDocker, Duration: time.Second})
+	if a == nil || a.Severity != models.AnomalyWarning || a.Baseline != 0 {
+		t.Fatalf("Review() of an empty output without a baseline = %+v, want a warning", a)
+	}
+	a = d.Review(ctx, "tokenless", "", Sample{Type: models.TaskTypeLLM, OutputBytes: 0})
+	if a == nil || a.Severity != models.AnomalyCritical || a.Findings[0].Metric != MetricResponseTokens {
+		t.Fatalf("Review() of a response without tokens = %+v, want critical", a)
+	}
+}
+
+func TestHoldModeAwaitsOperator(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC))
+	d, alerts := newDetector(t, testPolicy(ModeHold))
+	d.SetClock(clk)
+	d.Seed(seed(models.TaskTypeDocker, 200))
+	ctx := context.Background()
+
+	// Warnings are not held
+	if a := d.Review(ctx, "slow", "", Sample{Type: models.TaskTypeDocker, OutputBytes: 10_500, Duration: 40 * time.Second}); a == nil || a.Decision != models.AnomalyFlagged {
+		t.Fatalf("Review() of a warning = %+v, want flagged without holding", a)
+	}
+
+	review := func(taskID string) <-chan *models.ResultAnomaly {
+		done := make(chan *models.ResultAnomaly, 1)
+		go func() {
+			done <- d.Review(ctx, taskID, "", Sample{Type: models.TaskTypeDocker, Duration: 31 * time.Second})
+		}()
+		clk.BlockUntil(1)
+		return done
+	}
+
+	rejected := review("rejected")
+	if held := d.Status().Held; len(held) != 1 || held[0].TaskID != "rejected" || !held[0].Deadline.Equal(clk.Now().Add(time.Minute)) {
+		t.Fatalf("held = %+v, want the critical result until its deadline", held)
+	}
+	if err := d.Decide("rejected", false); err != nil {
+		t.Fatal(err)
+	}
+	if a := <-rejected; a.Decision != models.AnomalyRejected {
+		t.Fatalf("Review() = %+v, want rejected", a)
+	}
+
+	approved := review("approved")
+	if err := d.Decide("approved", true); err != nil {
+		t.Fatal(err)
+	}
+	if a := <-approved; a.Decision != models.AnomalyApproved {
+		t.Fatalf("Review() = %+v, want approved", a)
+	}
+
+	timedOut := review("timed-out")
+	clk.Advance(time.Minute)
+	if a := <-timedOut; a.Decision != models.AnomalyTimedOut {
+		t.Fatalf("Review() = %+v, want timed out", a)
+	}
+	if err := d.Decide("timed-out", true); !errors.Is(err, ErrNotHeld) {
+		t.Fatalf("Decide() after the timeout = %v, want ErrNotHeld", err)
+	}
+
+	status := d.Status()
+	if status.Rejected != 1 || status.Flagged != 3 || len(status.Held) != 0 {
+		t.Errorf("Status() = %+v", status)
+	}
+	if alerts.len() != 4 {
+		t.Errorf("%d alerts raised, want 4", alerts.len())
+	}
+}
+
+func TestHoldDoesNotBlockOtherResults(t *testing.T) {
+	d, _ := newDetector(t, testPolicy(ModeHold))
+	d.Seed(seed(models.TaskTypeDocker, 200))
+	ctx, cancel := context.WithCancel(context.Background())
+
+	held := make(chan *models.ResultAnomaly, 1)
+	go func() {
+		held <- d.Review(ctx, "held", "", Sample{Type: models.TaskTypeDocker, Duration: 31 * time.Second})
+	}()
+	for len(d.Status().Held) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if a := d.Review(context.Background(), "usual", "", Sample{Type: models.TaskTypeDocker, OutputBytes: 10_500, Duration: 31 * time.Second}); a != nil {
+				t.Errorf("Review() = %+v", a)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// A result whose task ends while held is submitted flagged
+	cancel()
+	if a := <-held; a.Decision != models.AnomalyTimedOut {
+		t.Fatalf("Review() = %+v once its context ended, want timed out", a)
+	}
+}
+
+func TestNewValidatesPolicy(t *testing.T) {
+	bad := []func(*Policy){
+		func(p *Policy) { p.Rule = "median" },
+		func(p *Policy) { p.Threshold = 0 },
+		func(p *Policy) { p.Rule = RuleQuantile; p.Quantile = 0.5 },
+		func(p *Policy) { p.Mode = "block" },
+		func(p *Policy) { p.Window = 10 },
+		func(p *Policy) { p.CriticalScore = 0.5 },
+		func(p *Policy) { p.HoldTimeout = 0 },
+	}
+	for i, mutate := range bad {
+		policy := testPolicy(ModeHold)
+		mutate(&policy)
+		if _, err := New(policy); err == nil {
+			t.Errorf("New() accepted invalid policy %d: %+v", i, policy)
+		}
+	}
+}
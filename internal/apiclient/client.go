@@ -0,0 +1,406 @@
+// Package apiclient is the typed client of the server API runners call. The
+// operations and their types in client.gen.go are generated from
+// openapi.json; this file holds what every operation shares: base URL
+// handling, authentication, retries, request tracing and error mapping.
+package apiclient
+
+//go:generate go run ./openapi/apigen -spec openapi.json -out client.gen.go -import models=github.com/theblitlabs/parity-runner/internal/core/models
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+)
+
+// Spec is the OpenAPI document the client is generated from
+//
+//go:embed openapi.json
+var Spec []byte
+
+const (
+	defaultTimeout = 10 * time.Second
+	// maxErrorMessage caps how much of an error response is kept
+	maxErrorMessage = 1024
+)
+
+// ErrDecode is returned when a successful response body does not decode
+// into the operation's result
+var ErrDecode = errors.New("invalid response body")
+
+// Operation describes one endpoint of the spec
+type Operation struct {
+	ID     string
+	Method string
+	// Path is the path template, such as /api/v1/runners/tasks/{taskId}
+	Path         string
+	SuccessCodes []int
+	// Idempotent operations are retried after transient failures
+	Idempotent bool
+	// Timeout overrides the client's default request timeout
+	Timeout time.Duration
+}
+
+func (op *Operation) succeeded(code int) bool {
+	for _, success := range op.SuccessCodes {
+		if code == success {
+			return true
+		}
+	}
+	return false
+}
+
+// Error is returned when the server answers with a status the operation
+// does not declare as successful
+type Error struct {
+	Operation  string
+	StatusCode int
+	// Message is the error the server reported, or the response body
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s: unexpected status code %d", e.Operation, e.StatusCode)
+	}
+	return fmt.Sprintf("%s: unexpected status code %d: %s", e.Operation, e.StatusCode, e.Message)
+}
+
+// StatusCode returns the status code of the *Error in err's chain, or 0 when
+// the request failed without a response
+func StatusCode(err error) int {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	return 0
+}
+
+// RetryPolicy is how idempotent operations are retried after transient
+// failures: up to Attempts attempts in all, the nth retry waiting BaseDelay
+// doubled n-1 times, at most MaxDelay, spread by up to Jitter of itself
+// either way so that runners that failed together do not retry together
+type RetryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Jitter    float64
+}
+
+// DefaultRetryPolicy is the policy clients start with
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:  4,
+	BaseDelay: 500 * time.Millisecond,
+	MaxDelay:  10 * time.Second,
+	Jitter:    0.2,
+}
+
+// Delay returns how long to wait before retry n, counting from 1
+func (p RetryPolicy) Delay(n int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < n && (p.MaxDelay <= 0 || delay < p.MaxDelay); i++ {
+		delay *= 2
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay += time.Duration(p.Jitter * float64(delay) * (2*rand.Float64() - 1))
+	}
+	return max(delay, 0)
+}
+
+// Client calls the server API
+type Client struct {
+	baseURL     string
+	httpClient  *http.Client
+	credentials func() (string, error)
+	retries     RetryPolicy
+	clock       clock.Clock
+}
+
+// New returns a client of the server at baseURL. credentials returns what
+// every request carries in the spec's auth header, the runner's device ID.
+// A baseURL ending in /api is accepted, as runner configs often name the
+// API root rather than the server.
+func New(baseURL string, credentials func() (string, error)) *Client {
+	baseURL = strings.TrimSuffix(strings.TrimRight(baseURL, "/"), "/api")
+	return &Client{
+		baseURL:     baseURL,
+		httpClient:  dualstack.Client(0),
+		credentials: credentials,
+		retries:     DefaultRetryPolicy,
+		clock:       clock.Real(),
+	}
+}
+
+// SetHTTPClient replaces the HTTP client requests are sent with. Request
+// timeouts are set per operation, so client's own timeout should be unset.
+func (c *Client) SetHTTPClient(client *http.Client) {
+	c.httpClient = client
+}
+
+// SetRetryPolicy sets how idempotent operations are retried after
+// transient failures
+func (c *Client) SetRetryPolicy(policy RetryPolicy) {
+	c.retries = policy
+}
+
+// SetClock replaces the clock retry backoff and response timeouts of
+// streamed requests wait on
+func (c *Client) SetClock(clk clock.Clock) {
+	c.clock = clk
+}
+
+// do sends a request for op to path, encoding body as JSON when it is set,
+// and decodes a successful response into out. It reports whether a response
+// body was decoded; servers may answer with an empty one.
+func (c *Client) do(ctx context.Context, op *Operation, path string, query url.Values, body, out interface{}) (bool, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return false, fmt.Errorf("%s: failed to encode request: %w", op.ID, err)
+		}
+	}
+
+	var decoded bool
+	err := c.retry(ctx, op, func(credential, requestID string, attempt int) (bool, error) {
+		var retry bool
+		var err error
+		decoded, retry, err = c.send(ctx, op, c.endpoint(path, query), payload, credential, requestID, attempt, out)
+		return retry, err
+	})
+	return decoded, err
+}
+
+// stream sends a request for op to path and returns the body of a
+// successful response unread, for the caller to close. The operation's
+// timeout bounds the wait for the response to start, not the reading of
+// its body, which ctx alone bounds.
+func (c *Client) stream(ctx context.Context, op *Operation, path string, query url.Values) (io.ReadCloser, error) {
+	var body io.ReadCloser
+	err := c.retry(ctx, op, func(credential, requestID string, attempt int) (bool, error) {
+		reqCtx, cancel := context.WithCancel(ctx)
+		timer := c.clock.NewTimer(c.timeout(op))
+		started := make(chan struct{})
+		go func() {
+			select {
+			case <-timer.C():
+				cancel()
+			case <-started:
+			}
+		}()
+		resp, retry, err := c.roundTrip(ctx, reqCtx, op, c.endpoint(path, query), nil, credential, requestID, attempt, "application/octet-stream")
+		late := !timer.Stop()
+		close(started)
+		if late && err == nil {
+			// The response started too late to be read
+			resp.Body.Close()
+			err = fmt.Errorf("%s: %w", op.ID, context.DeadlineExceeded)
+			retry = ctx.Err() == nil
+		}
+		if err != nil {
+			cancel()
+			return retry, err
+		}
+		body = &streamBody{ReadCloser: resp.Body, cancel: cancel}
+		return false, nil
+	})
+	return body, err
+}
+
+// streamBody releases a streamed request's context once its body is closed
+type streamBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *streamBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (c *Client) endpoint(path string, query url.Values) string {
+	endpoint := c.baseURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+	return endpoint
+}
+
+func (c *Client) timeout(op *Operation) time.Duration {
+	if op.Timeout > 0 {
+		return op.Timeout
+	}
+	return defaultTimeout
+}
+
+// retry makes attempts at a request for op until one succeeds or fails for
+// good. Idempotent operations are attempted again after failures attempt
+// reports worth retrying, unless ctx ends before the next attempt is due.
+func (c *Client) retry(ctx context.Context, op *Operation, attempt func(credential, requestID string, attempt int) (bool, error)) error {
+	var credential string
+	if authHeader != "" && c.credentials != nil {
+		var err error
+		if credential, err = c.credentials(); err != nil {
+			return fmt.Errorf("%s: failed to get credentials: %w", op.ID, err)
+		}
+	}
+
+	// Retries of one call share its request ID, so the server can tell them
+	// apart from new calls
+	requestID := uuid.NewString()
+	attempts := 1
+	if op.Idempotent {
+		attempts = max(c.retries.Attempts, 1)
+	}
+	for n := 1; ; n++ {
+		retry, err := attempt(credential, requestID, n)
+		if err == nil || !retry || n >= attempts {
+			return err
+		}
+		delay := c.retries.Delay(n)
+		if deadline, ok := ctx.Deadline(); ok && deadline.Sub(c.clock.Now()) < delay {
+			return err
+		}
+		log := gologger.WithComponent("api_client")
+		log.Info().Err(err).
+			Str("operation", op.ID).
+			Str("request_id", requestID).
+			Int("attempt", n).
+			Int("attempts", attempts).
+			Dur("backoff", delay).
+			Msg("Retrying API request")
+		timer := c.clock.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C():
+		}
+	}
+}
+
+// send makes one attempt at a request, reporting whether a failure is
+// worth retrying
+func (c *Client) send(ctx context.Context, op *Operation, endpoint string, payload []byte, credential, requestID string, attempt int, out interface{}) (decoded, retry bool, err error) {
+	reqCtx, cancel := context.WithTimeout(ctx, c.timeout(op))
+	defer cancel()
+
+	resp, retry, err := c.roundTrip(ctx, reqCtx, op, endpoint, payload, credential, requestID, attempt, "application/json")
+	if err != nil {
+		return false, retry, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, ctx.Err() == nil, fmt.Errorf("%s: failed to read response: %w", op.ID, err)
+	}
+	if out == nil || len(bytes.TrimSpace(data)) == 0 {
+		return false, false, nil
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return false, false, fmt.Errorf("%s: %w: %v", op.ID, ErrDecode, err)
+	}
+	return true, false, nil
+}
+
+// roundTrip sends one request under reqCtx and returns the response when
+// its status is one op succeeds with. Other responses are read and closed
+// here and returned as an *Error.
+func (c *Client) roundTrip(ctx, reqCtx context.Context, op *Operation, endpoint string, payload []byte, credential, requestID string, attempt int, accept string) (*http.Response, bool, error) {
+	log := gologger.WithComponent("api_client")
+
+	var reader io.Reader
+	if payload != nil {
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(reqCtx, op.Method, endpoint, reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: failed to create request: %w", op.ID, err)
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("X-Request-ID", requestID)
+	if credential != "" {
+		req.Header.Set(authHeader, credential)
+	}
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		log.Debug().Err(err).
+			Str("operation", op.ID).
+			Str("request_id", requestID).
+			Int("attempt", attempt).
+			Dur("duration", time.Since(start)).
+			Msg("API request failed")
+		return nil, ctx.Err() == nil, fmt.Errorf("%s: %s %s failed: %w", op.ID, op.Method, endpoint, err)
+	}
+	log.Debug().
+		Str("operation", op.ID).
+		Str("request_id", requestID).
+		Int("attempt", attempt).
+		Int("status", resp.StatusCode).
+		Dur("duration", time.Since(start)).
+		Msg("API request")
+
+	if !op.succeeded(resp.StatusCode) {
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*maxErrorMessage))
+		return nil, retryable(resp.StatusCode), &Error{
+			Operation:  op.ID,
+			StatusCode: resp.StatusCode,
+			Message:    errorMessage(data),
+		}
+	}
+	return resp, false, nil
+}
+
+// retryable reports whether a status code is a transient failure: too many
+// requests, or a server error other than one saying the server cannot
+// serve the request at all
+func retryable(code int) bool {
+	switch code {
+	case http.StatusTooManyRequests:
+		return true
+	case http.StatusNotImplemented, http.StatusHTTPVersionNotSupported:
+		return false
+	}
+	return code >= 500
+}
+
+// errorMessage extracts the error an error response reports, falling back
+// to the body itself
+func errorMessage(data []byte) string {
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data, &body); err == nil && body.Error != "" {
+		return body.Error
+	}
+	message := strings.TrimSpace(string(data))
+	if len(message) > maxErrorMessage {
+		message = message[:maxErrorMessage] + "..."
+	}
+	return message
+}
@@ -0,0 +1,306 @@
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/apiclient/openapi"
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+)
+
+func TestGeneratedClientIsCurrent(t *testing.T) {
+	doc, err := openapi.Parse(Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := openapi.Generate(doc, openapi.Options{
+		Package: "apiclient",
+		Source:  "openapi.json",
+		Imports: map[string]string{"models": "github.com/theblitlabs/parity-runner/internal/core/models"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile("client.gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatal("client.gen.go is out of date with openapi.json; run go generate ./internal/apiclient")
+	}
+}
+
+// flakyServer fails the first failures requests with status, recording the
+// headers of every request
+type flakyServer struct {
+	failures int
+	status   int
+
+	mu       sync.Mutex
+	requests []http.Header
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Header.Clone())
+	if len(s.requests) <= s.failures {
+		w.WriteHeader(s.status)
+		w.Write([]byte(`{"error":"try again"}`))
+		return
+	}
+	w.Write([]byte(`{"nonce":"abc"}`))
+}
+
+func newTestClient(t *testing.T, handler http.Handler, suffix string) *Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client := New(server.URL+suffix, func() (string, error) { return "device-1", nil })
+	client.SetRetryPolicy(RetryPolicy{Attempts: 3, BaseDelay: time.Millisecond})
+	return client
+}
+
+func TestIdempotentOperationsRetryTransientFailures(t *testing.T) {
+	server := &flakyServer{failures: 2, status: http.StatusServiceUnavailable}
+	client := newTestClient(t, server, "/api")
+
+	challenge, err := client.GetAttestationChallenge(context.Background(), "")
+	if err != nil {
+		t.Fatalf("GetAttestationChallenge() error = %v", err)
+	}
+	if challenge.Nonce != "abc" {
+		t.Errorf("nonce = %q, want abc", challenge.Nonce)
+	}
+	if len(server.requests) != 3 {
+		t.Fatalf("requests = %d, want 3", len(server.requests))
+	}
+	requestID := server.requests[0].Get("X-Request-ID")
+	for i, header := range server.requests {
+		if got := header.Get("X-Device-ID"); got != "device-1" {
+			t.Errorf("request %d X-Device-ID = %q, want device-1", i, got)
+		}
+		if got := header.Get("X-Request-ID"); requestID == "" || got != requestID {
+			t.Errorf("request %d X-Request-ID = %q, want the first request's %q", i, got, requestID)
+		}
+	}
+}
+
+func TestNonIdempotentOperationsAreNotRetried(t *testing.T) {
+	server := &flakyServer{failures: 1, status: http.StatusServiceUnavailable}
+	client := newTestClient(t, server, "")
+
+	_, err := client.StartTask(context.Background(), "task-1", nil)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("StartTask() error = %v, want *Error", err)
+	}
+	if apiErr.Operation != "startTask" || apiErr.StatusCode != http.StatusServiceUnavailable || apiErr.Message != "try again" {
+		t.Errorf("error = %+v", apiErr)
+	}
+	if len(server.requests) != 1 {
+		t.Errorf("requests = %d, want 1", len(server.requests))
+	}
+}
+
+func TestClientErrorsAreNotRetried(t *testing.T) {
+	server := &flakyServer{failures: 1, status: http.StatusNotFound}
+	client := newTestClient(t, server, "")
+
+	_, err := client.GetTask(context.Background(), "task-1")
+	if StatusCode(err) != http.StatusNotFound {
+		t.Fatalf("GetTask() error = %v, want a 404", err)
+	}
+	if len(server.requests) != 1 {
+		t.Errorf("requests = %d, want 1", len(server.requests))
+	}
+}
+
+func TestServerErrorsAreRetried(t *testing.T) {
+	for _, tt := range []struct {
+		status int
+		want   int
+	}{
+		{http.StatusInternalServerError, 3},
+		{http.StatusBadGateway, 3},
+		{http.StatusNotImplemented, 1},
+	} {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			server := &flakyServer{failures: 2, status: tt.status}
+			client := newTestClient(t, server, "")
+
+			err := client.SaveTaskResult(context.Background(), "task-1", nil)
+			if succeeded := err == nil; succeeded != (tt.want == 3) {
+				t.Errorf("SaveTaskResult() error = %v", err)
+			}
+			if len(server.requests) != tt.want {
+				t.Errorf("requests = %d, want %d", len(server.requests), tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyBacksOffExponentially(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for n, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		if got := policy.Delay(n + 1); got != want*time.Millisecond {
+			t.Errorf("Delay(%d) = %v, want %v", n+1, got, want*time.Millisecond)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := policy.Delay(2); got < 100*time.Millisecond || got > 300*time.Millisecond {
+			t.Fatalf("Delay(2) with jitter = %v, want within half of 200ms", got)
+		}
+	}
+}
+
+func TestRetriesEndWithContextDeadline(t *testing.T) {
+	server := &flakyServer{failures: 10, status: http.StatusServiceUnavailable}
+	client := newTestClient(t, server, "")
+	client.SetRetryPolicy(RetryPolicy{Attempts: 10, BaseDelay: time.Minute})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	if err := client.CompleteTask(ctx, "task-1"); StatusCode(err) != http.StatusServiceUnavailable {
+		t.Fatalf("CompleteTask() error = %v, want the last 503", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("CompleteTask() took %v, waiting for a retry past its deadline", elapsed)
+	}
+	if len(server.requests) != 1 {
+		t.Errorf("requests = %d, want 1", len(server.requests))
+	}
+}
+
+func TestRetryBackoffWaitsOnClock(t *testing.T) {
+	server := &flakyServer{failures: 1, status: http.StatusServiceUnavailable}
+	client := newTestClient(t, server, "")
+	client.SetRetryPolicy(RetryPolicy{Attempts: 2, BaseDelay: time.Hour})
+	clk := clocktest.NewFake(time.Now())
+	client.SetClock(clk)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.GetAttestationChallenge(context.Background(), "")
+		done <- err
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(time.Hour)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("GetAttestationChallenge() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry did not follow the clock's backoff")
+	}
+	if len(server.requests) != 2 {
+		t.Errorf("requests = %d, want 2", len(server.requests))
+	}
+}
+
+func TestRetryStopsAtDeadlineByClock(t *testing.T) {
+	server := &flakyServer{failures: 1, status: http.StatusServiceUnavailable}
+	client := newTestClient(t, server, "")
+	client.SetRetryPolicy(RetryPolicy{Attempts: 2, BaseDelay: 30 * time.Minute})
+	// The clock is already past the point where the backoff fits before
+	// the deadline, however much time the wall clock leaves
+	clk := clocktest.NewFake(time.Now().Add(45 * time.Minute))
+	client.SetClock(clk)
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(time.Hour))
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.GetAttestationChallenge(ctx, "")
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("GetAttestationChallenge() succeeded, want the failure kept")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("retry waited for a backoff past the deadline")
+	}
+	if len(server.requests) != 1 {
+		t.Errorf("requests = %d, want 1", len(server.requests))
+	}
+}
+
+func TestStreamTimesOutOnClock(t *testing.T) {
+	client := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}), "")
+	client.SetRetryPolicy(RetryPolicy{Attempts: 1})
+	clk := clocktest.NewFake(time.Now())
+	client.SetClock(clk)
+
+	done := make(chan error, 1)
+	go func() {
+		body, err := client.GetTaskArtifact(context.Background(), "task-1", "model.bin")
+		if body != nil {
+			body.Close()
+		}
+		done <- err
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(defaultTimeout)
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("GetTaskArtifact() succeeded, want the response timeout")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("streamed request did not time out on the clock")
+	}
+}
+
+func TestCredentialErrorsStopRequests(t *testing.T) {
+	server := &flakyServer{}
+	s := httptest.NewServer(server)
+	defer s.Close()
+	client := New(s.URL, func() (string, error) { return "", errors.New("no device ID") })
+
+	if err := client.CompleteTask(context.Background(), "task-1"); err == nil {
+		t.Fatal("CompleteTask() without credentials succeeded")
+	}
+	if len(server.requests) != 0 {
+		t.Errorf("requests = %d, want none", len(server.requests))
+	}
+}
+
+func TestClientReachesIPv6Server(t *testing.T) {
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	server := httptest.NewUnstartedServer(&flakyServer{})
+	server.Listener = ln
+	server.Start()
+	t.Cleanup(server.Close)
+	if !strings.HasPrefix(server.URL, "http://[::1]:") {
+		t.Fatalf("server URL %q, want a bracketed IPv6 literal", server.URL)
+	}
+
+	client := New(server.URL+"/api", func() (string, error) { return "device-1", nil })
+	challenge, err := client.GetAttestationChallenge(context.Background(), "")
+	if err != nil {
+		t.Fatalf("GetAttestationChallenge() error = %v", err)
+	}
+	if challenge.Nonce != "abc" {
+		t.Errorf("nonce = %q, want abc", challenge.Nonce)
+	}
+}
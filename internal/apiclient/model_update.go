@@ -0,0 +1,11 @@
+package apiclient
+
+import "github.com/theblitlabs/parity-runner/internal/flcanon"
+
+// MarshalJSON encodes the update canonically, as flcanon describes, so that
+// the coordinator hashes the update the runner trained whatever platform it
+// trained on
+func (u ModelUpdate) MarshalJSON() ([]byte, error) {
+	type plain ModelUpdate
+	return flcanon.Marshal(plain(u))
+}
@@ -0,0 +1,67 @@
+// Command apigen generates the typed runner API client from its OpenAPI
+// spec. It runs through go generate in the apiclient package.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/apiclient/openapi"
+)
+
+func main() {
+	spec := flag.String("spec", "openapi.json", "OpenAPI document to generate from")
+	out := flag.String("out", "client.gen.go", "file to write the client to")
+	pkg := flag.String("package", "apiclient", "package name of the generated client")
+	check := flag.Bool("check", false, "fail if out is not up to date instead of writing it")
+	imports := map[string]string{}
+	flag.Func("import", "prefix=path import for x-go-type names, repeatable", func(value string) error {
+		prefix, path, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("expected prefix=path, got %q", value)
+		}
+		imports[prefix] = path
+		return nil
+	})
+	flag.Parse()
+
+	if err := run(*spec, *out, *pkg, imports, *check); err != nil {
+		fmt.Fprintf(os.Stderr, "apigen: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(specPath, outPath, pkg string, imports map[string]string, check bool) error {
+	data, err := os.ReadFile(specPath)
+	if err != nil {
+		return fmt.Errorf("failed to read spec: %w", err)
+	}
+	doc, err := openapi.Parse(data)
+	if err != nil {
+		return err
+	}
+	code, err := openapi.Generate(doc, openapi.Options{
+		Package: pkg,
+		Source:  filepath.Base(specPath),
+		Imports: imports,
+	})
+	if err != nil {
+		return err
+	}
+
+	if check {
+		current, err := os.ReadFile(outPath)
+		if err != nil {
+			return fmt.Errorf("failed to read generated client: %w", err)
+		}
+		if !bytes.Equal(current, code) {
+			return fmt.Errorf("%s is out of date with %s; run go generate ./internal/apiclient", outPath, specPath)
+		}
+		return nil
+	}
+	return os.WriteFile(outPath, code, 0o644)
+}
@@ -0,0 +1,307 @@
+// Package openapi reads the subset of OpenAPI 3 that the runner API spec
+// uses, and generates the typed client in the apiclient package from it.
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Document is a parsed OpenAPI document
+type Document struct {
+	OpenAPI    string                           `json:"openapi"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Security   []map[string][]string            `json:"security"`
+	Components Components                       `json:"components"`
+}
+
+// Components holds the definitions operations refer to
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	Parameters      map[string]*Parameter      `json:"parameters"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes"`
+}
+
+// SecurityScheme describes how requests authenticate; the runner API only
+// uses API keys sent in a header
+type SecurityScheme struct {
+	Type string `json:"type"`
+	In   string `json:"in"`
+	Name string `json:"name"`
+}
+
+// Operation is one method on a path
+type Operation struct {
+	OperationID string               `json:"operationId"`
+	Summary     string               `json:"summary"`
+	Description string               `json:"description"`
+	Parameters  []*Parameter         `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+	// Idempotent operations are retried after transient failures
+	Idempotent bool `json:"x-idempotent"`
+	// TimeoutSeconds overrides the client's default request timeout
+	TimeoutSeconds int `json:"x-timeout-seconds"`
+}
+
+// Parameter is a path or query parameter, or a reference to one
+type Parameter struct {
+	Ref         string  `json:"$ref"`
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Required    bool    `json:"required"`
+	Description string  `json:"description"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes what an operation accepts
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// Response describes what an operation returns with one status code
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content"`
+}
+
+// MediaType holds the schema of a body
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is a JSON Schema. GoType names an existing Go type, such as
+// "models.Task", to use instead of generating one.
+type Schema struct {
+	Ref                  string             `json:"$ref"`
+	Type                 Types              `json:"type"`
+	Format               string             `json:"format"`
+	Description          string             `json:"description"`
+	Properties           map[string]*Schema `json:"properties"`
+	Required             []string           `json:"required"`
+	Items                *Schema            `json:"items"`
+	AdditionalProperties json.RawMessage    `json:"additionalProperties"`
+	GoType               string             `json:"x-go-type"`
+}
+
+// Types is a schema's type, which JSON Schema allows to be a single type
+// or a list of them
+type Types []string
+
+func (t *Types) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*t = Types{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("schema type must be a string or a list of strings: %w", err)
+	}
+	*t = list
+	return nil
+}
+
+// Is reports whether the schema allows values of type kind
+func (t Types) Is(kind string) bool {
+	for _, k := range t {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Additional returns the schema of an object's additional properties, an
+// empty schema when any are allowed, or nil when none are
+func (s *Schema) Additional() *Schema {
+	raw := strings.TrimSpace(string(s.AdditionalProperties))
+	switch raw {
+	case "", "false":
+		return nil
+	case "true":
+		return &Schema{}
+	}
+	var schema Schema
+	if err := json.Unmarshal(s.AdditionalProperties, &schema); err != nil {
+		return nil
+	}
+	return &schema
+}
+
+// IsRequired reports whether the object schema requires property name
+func (s *Schema) IsRequired(name string) bool {
+	for _, required := range s.Required {
+		if required == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Endpoint is an operation together with where it is served
+type Endpoint struct {
+	Method string
+	Path   string
+	*Operation
+}
+
+// Parse reads an OpenAPI document, resolving parameter references
+func Parse(data []byte) (*Document, error) {
+	var doc Document
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	for path, methods := range doc.Paths {
+		for method, op := range methods {
+			if op.OperationID == "" {
+				return nil, fmt.Errorf("%s %s has no operationId", strings.ToUpper(method), path)
+			}
+			for i, param := range op.Parameters {
+				if param.Ref == "" {
+					continue
+				}
+				name := strings.TrimPrefix(param.Ref, "#/components/parameters/")
+				resolved, ok := doc.Components.Parameters[name]
+				if !ok {
+					return nil, fmt.Errorf("%s: unresolvable parameter %q", op.OperationID, param.Ref)
+				}
+				op.Parameters[i] = resolved
+			}
+		}
+	}
+	return &doc, nil
+}
+
+// Endpoints returns every operation, ordered by path and method
+func (d *Document) Endpoints() []*Endpoint {
+	var endpoints []*Endpoint
+	for path, methods := range d.Paths {
+		for method, op := range methods {
+			endpoints = append(endpoints, &Endpoint{Method: strings.ToUpper(method), Path: path, Operation: op})
+		}
+	}
+	sort.Slice(endpoints, func(i, j int) bool {
+		if endpoints[i].Path != endpoints[j].Path {
+			return endpoints[i].Path < endpoints[j].Path
+		}
+		return endpoints[i].Method < endpoints[j].Method
+	})
+	return endpoints
+}
+
+// Match returns the endpoint serving method on path, with the values of
+// its path parameters, or nil when the document has none. Literal segments
+// take precedence over parameters.
+func (d *Document) Match(method, path string) (*Endpoint, map[string]string) {
+	var best *Endpoint
+	var bestParams map[string]string
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, endpoint := range d.Endpoints() {
+		if endpoint.Method != method {
+			continue
+		}
+		template := strings.Split(strings.Trim(endpoint.Path, "/"), "/")
+		if len(template) != len(segments) {
+			continue
+		}
+		params := make(map[string]string)
+		matched := true
+		for i, part := range template {
+			if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+				params[part[1:len(part)-1]] = segments[i]
+				continue
+			}
+			if part != segments[i] {
+				matched = false
+				break
+			}
+		}
+		if matched && (best == nil || len(params) < len(bestParams)) {
+			best, bestParams = endpoint, params
+		}
+	}
+	return best, bestParams
+}
+
+// Schema resolves a reference to a component schema, returning the
+// component's name, or "" for an inline schema
+func (d *Document) Schema(s *Schema) (string, *Schema) {
+	if s == nil || s.Ref == "" {
+		return "", s
+	}
+	name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+	return name, d.Components.Schemas[name]
+}
+
+// JSONBody returns the JSON schema of the request body, or nil when the
+// operation takes none
+func (o *Operation) JSONBody() *Schema {
+	if o.RequestBody == nil {
+		return nil
+	}
+	if media, ok := o.RequestBody.Content["application/json"]; ok {
+		return media.Schema
+	}
+	return nil
+}
+
+// SuccessCodes returns the 2xx status codes the operation declares, in
+// ascending order
+func (o *Operation) SuccessCodes() []int {
+	var codes []int
+	for code := range o.Responses {
+		n, err := strconv.Atoi(code)
+		if err == nil && n >= 200 && n < 300 {
+			codes = append(codes, n)
+		}
+	}
+	sort.Ints(codes)
+	return codes
+}
+
+// SuccessSchema returns the JSON schema of the operation's successful
+// response, or nil when it returns no body
+func (o *Operation) SuccessSchema() *Schema {
+	for _, code := range o.SuccessCodes() {
+		response := o.Responses[strconv.Itoa(code)]
+		if media, ok := response.Content["application/json"]; ok && media.Schema != nil {
+			return media.Schema
+		}
+	}
+	return nil
+}
+
+// Streams reports whether the operation's successful response is raw
+// bytes, read by the caller as they arrive rather than decoded
+func (o *Operation) Streams() bool {
+	for _, code := range o.SuccessCodes() {
+		if _, ok := o.Responses[strconv.Itoa(code)].Content["application/octet-stream"]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Responds reports whether the operation declares status code
+func (o *Operation) Responds(code int) bool {
+	_, ok := o.Responses[strconv.Itoa(code)]
+	return ok
+}
+
+// AuthHeader returns the header the document's security scheme sends
+// credentials in, or "" when requests are unauthenticated
+func (d *Document) AuthHeader() string {
+	for _, requirement := range d.Security {
+		for name := range requirement {
+			if scheme, ok := d.Components.SecuritySchemes[name]; ok && scheme.Type == "apiKey" && scheme.In == "header" {
+				return scheme.Name
+			}
+		}
+	}
+	return ""
+}
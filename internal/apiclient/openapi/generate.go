@@ -0,0 +1,400 @@
+package openapi
+
+import (
+	"errors"
+	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Options configures the generated client
+type Options struct {
+	// Package is the name of the generated package
+	Package string
+	// Source names the document in the generated header
+	Source string
+	// Imports maps the package prefix of x-go-type names to import paths
+	Imports map[string]string
+}
+
+// initialisms are the words Go names spell in capitals
+var initialisms = map[string]bool{
+	"api": true, "cpu": true, "gpu": true, "http": true, "id": true,
+	"json": true, "os": true, "ttl": true, "url": true, "uuid": true,
+}
+
+// goName converts an identifier such as "task_id" or "taskId" to a Go name,
+// exported or not
+func goName(s string, exported bool) string {
+	var words []string
+	var word []rune
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == ' ':
+			if len(word) > 0 {
+				words = append(words, string(word))
+			}
+			word = nil
+			continue
+		case unicode.IsUpper(r) && len(word) > 0 && (unicode.IsLower(word[len(word)-1]) || (i+1 < len(runes) && unicode.IsLower(runes[i+1]))):
+			words = append(words, string(word))
+			word = nil
+		}
+		word = append(word, r)
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+
+	var b strings.Builder
+	for i, w := range words {
+		lower := strings.ToLower(w)
+		switch {
+		case i == 0 && !exported:
+			b.WriteString(lower)
+		case initialisms[lower]:
+			b.WriteString(strings.ToUpper(lower))
+		default:
+			b.WriteString(strings.ToUpper(lower[:1]) + lower[1:])
+		}
+	}
+	return b.String()
+}
+
+// comment renders text as a Go comment wrapped at about 76 columns
+func comment(text string) string {
+	var b strings.Builder
+	line := "//"
+	for _, word := range strings.Fields(text) {
+		if len(line)+1+len(word) > 76 && line != "//" {
+			b.WriteString(line + "\n")
+			line = "//"
+		}
+		line += " " + word
+	}
+	b.WriteString(line + "\n")
+	return b.String()
+}
+
+type generator struct {
+	doc     *Document
+	options Options
+	imports map[string]bool
+}
+
+// Generate renders the Go client for doc: a struct for each component
+// schema without an x-go-type, an Operation for each endpoint, and a Client
+// method calling it. The package must define Client, Operation and
+// Client.do.
+func Generate(doc *Document, options Options) ([]byte, error) {
+	g := &generator{doc: doc, options: options, imports: map[string]bool{"context": true}}
+
+	var body strings.Builder
+	if err := g.types(&body); err != nil {
+		return nil, err
+	}
+	if err := g.operations(&body); err != nil {
+		return nil, err
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "// Code generated by apigen from %s. DO NOT EDIT.\n\n", options.Source)
+	fmt.Fprintf(&out, "package %s\n\n", options.Package)
+	imports := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		if isStdlib(imports[i]) != isStdlib(imports[j]) {
+			return isStdlib(imports[i])
+		}
+		return imports[i] < imports[j]
+	})
+	// Standard library imports come first, separated from the others
+	out.WriteString("import (\n")
+	for i, path := range imports {
+		if i > 0 && !isStdlib(path) && isStdlib(imports[i-1]) {
+			out.WriteString("\n")
+		}
+		fmt.Fprintf(&out, "\t%q\n", path)
+	}
+	out.WriteString(")\n\n")
+	if header := doc.AuthHeader(); header != "" {
+		out.WriteString("// authHeader carries the runner's credentials on every request\n")
+		fmt.Fprintf(&out, "const authHeader = %q\n\n", header)
+	} else {
+		out.WriteString("const authHeader = \"\"\n\n")
+	}
+	out.WriteString(body.String())
+
+	formatted, err := format.Source([]byte(out.String()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to format generated client: %w", err)
+	}
+	return formatted, nil
+}
+
+func isStdlib(path string) bool {
+	first, _, _ := strings.Cut(path, "/")
+	return !strings.Contains(first, ".")
+}
+
+// goType returns the Go type of values matching schema
+func (g *generator) goType(schema *Schema) (string, error) {
+	if schema == nil {
+		return "interface{}", nil
+	}
+	if schema.Ref != "" {
+		name, target := g.doc.Schema(schema)
+		if target == nil {
+			return "", fmt.Errorf("unresolvable schema %q", schema.Ref)
+		}
+		if target.GoType != "" {
+			prefix, _, found := strings.Cut(target.GoType, ".")
+			if found {
+				path, ok := g.options.Imports[prefix]
+				if !ok {
+					return "", fmt.Errorf("no import for x-go-type %q", target.GoType)
+				}
+				g.imports[path] = true
+			}
+			return "*" + target.GoType, nil
+		}
+		return "*" + name, nil
+	}
+
+	switch {
+	case schema.Type.Is("array"):
+		elem, err := g.goType(schema.Items)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + elem, nil
+	case schema.Type.Is("object"):
+		additional := schema.Additional()
+		if additional == nil && len(schema.Properties) > 0 {
+			return "", errors.New("inline object schemas with properties are not supported; declare a component")
+		}
+		elem := "interface{}"
+		if additional != nil && (additional.Ref != "" || len(additional.Type) > 0) {
+			var err error
+			if elem, err = g.goType(additional); err != nil {
+				return "", err
+			}
+		}
+		return "map[string]" + elem, nil
+	case schema.Type.Is("string"):
+		// Base64 strings decode into bytes, as encoding/json encodes them
+		if schema.Format == "byte" {
+			return "[]byte", nil
+		}
+		return "string", nil
+	case schema.Type.Is("integer"):
+		if schema.Format == "int64" {
+			return "int64", nil
+		}
+		return "int", nil
+	case schema.Type.Is("number"):
+		return "float64", nil
+	case schema.Type.Is("boolean"):
+		return "bool", nil
+	}
+	return "interface{}", nil
+}
+
+func (g *generator) types(b *strings.Builder) error {
+	names := make([]string, 0, len(g.doc.Components.Schemas))
+	for name, schema := range g.doc.Components.Schemas {
+		if schema.GoType == "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		schema := g.doc.Components.Schemas[name]
+		if !schema.Type.Is("object") {
+			return fmt.Errorf("schema %s: only object components are supported", name)
+		}
+		b.WriteString(comment(fmt.Sprintf("%s is generated from the %s schema. %s", name, name, schema.Description)))
+		fmt.Fprintf(b, "type %s struct {\n", name)
+
+		properties := make([]string, 0, len(schema.Properties))
+		for property := range schema.Properties {
+			properties = append(properties, property)
+		}
+		sort.Strings(properties)
+		for _, property := range properties {
+			fieldType, err := g.goType(schema.Properties[property])
+			if err != nil {
+				return fmt.Errorf("schema %s, property %s: %w", name, property, err)
+			}
+			tag := property
+			if !schema.IsRequired(property) {
+				tag += ",omitempty"
+			}
+			fmt.Fprintf(b, "\t%s %s `json:%q`\n", goName(property, true), fieldType, tag)
+		}
+		b.WriteString("}\n\n")
+	}
+	return nil
+}
+
+func (g *generator) operations(b *strings.Builder) error {
+	endpoints := g.doc.Endpoints()
+
+	b.WriteString("var (\n")
+	for _, endpoint := range endpoints {
+		codes := make([]string, 0)
+		for _, code := range endpoint.SuccessCodes() {
+			codes = append(codes, fmt.Sprint(code))
+		}
+		if len(codes) == 0 {
+			return fmt.Errorf("%s declares no successful response", endpoint.OperationID)
+		}
+		fmt.Fprintf(b, "\toperation%s = Operation{\n", goName(endpoint.OperationID, true))
+		fmt.Fprintf(b, "\t\tID: %q,\n\t\tMethod: %q,\n\t\tPath: %q,\n", endpoint.OperationID, endpoint.Method, endpoint.Path)
+		fmt.Fprintf(b, "\t\tSuccessCodes: []int{%s},\n", strings.Join(codes, ", "))
+		if endpoint.Idempotent {
+			b.WriteString("\t\tIdempotent: true,\n")
+		}
+		if endpoint.TimeoutSeconds > 0 {
+			g.imports["time"] = true
+			fmt.Fprintf(b, "\t\tTimeout: %d * time.Second,\n", endpoint.TimeoutSeconds)
+		}
+		b.WriteString("\t}\n")
+	}
+	b.WriteString(")\n\n")
+
+	for _, endpoint := range endpoints {
+		if err := g.method(b, endpoint); err != nil {
+			return fmt.Errorf("%s: %w", endpoint.OperationID, err)
+		}
+	}
+	return nil
+}
+
+func (g *generator) method(b *strings.Builder, endpoint *Endpoint) error {
+	name := goName(endpoint.OperationID, true)
+	args := []string{"ctx context.Context"}
+
+	// The path is built from its literal segments and escaped parameters
+	var pathExpr []string
+	for _, segment := range strings.Split(strings.TrimPrefix(endpoint.Path, "/"), "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			param := segment[1 : len(segment)-1]
+			found := false
+			for _, p := range endpoint.Parameters {
+				if p.In == "path" && p.Name == param {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("path parameter %s is not declared", param)
+			}
+			g.imports["net/url"] = true
+			arg := goName(param, false)
+			args = append(args, arg+" string")
+			pathExpr = append(pathExpr, `"/"`, "url.PathEscape("+arg+")")
+			continue
+		}
+		pathExpr = append(pathExpr, fmt.Sprintf("%q", "/"+segment))
+	}
+	path := strings.ReplaceAll(strings.Join(pathExpr, " + "), `" + "`, "")
+
+	var queryLines []string
+	for _, p := range endpoint.Parameters {
+		switch p.In {
+		case "path":
+		case "query":
+			t, err := g.goType(p.Schema)
+			if err != nil || (t != "string" && t != "int" && t != "int64") {
+				return fmt.Errorf("query parameter %s must be a string or an integer", p.Name)
+			}
+			g.imports["net/url"] = true
+			arg := goName(p.Name, false)
+			args = append(args, arg+" "+t)
+			value, zero := arg, `""`
+			if t != "string" {
+				// Integers are sent in decimal, and left out when zero
+				// unless required
+				g.imports["strconv"] = true
+				value, zero = "strconv.FormatInt("+arg+", 10)", "0"
+				if t == "int" {
+					value = "strconv.Itoa(" + arg + ")"
+				}
+			}
+			if p.Required {
+				queryLines = append(queryLines, fmt.Sprintf("query.Set(%q, %s)", p.Name, value))
+			} else {
+				queryLines = append(queryLines, fmt.Sprintf("if %s != %s {\nquery.Set(%q, %s)\n}", arg, zero, p.Name, value))
+			}
+		default:
+			return fmt.Errorf("parameters in %s are not supported", p.In)
+		}
+	}
+
+	if body := endpoint.JSONBody(); body != nil {
+		if endpoint.Streams() {
+			return fmt.Errorf("%s: streamed responses to requests with a body are not supported", endpoint.OperationID)
+		}
+		bodyType, err := g.goType(body)
+		if err != nil {
+			return err
+		}
+		args = append(args, "body "+bodyType)
+	}
+
+	result := "error"
+	response := endpoint.SuccessSchema()
+	var responseType string
+	if endpoint.Streams() {
+		g.imports["io"] = true
+		result = "(io.ReadCloser, error)"
+	} else if response != nil {
+		var err error
+		if responseType, err = g.goType(response); err != nil {
+			return err
+		}
+		result = "(" + responseType + ", error)"
+	}
+
+	b.WriteString(comment(fmt.Sprintf("%s calls %s %s. %s %s", name, endpoint.Method, endpoint.Path, endpoint.Summary, endpoint.Description)))
+	fmt.Fprintf(b, "func (c *Client) %s(%s) %s {\n", name, strings.Join(args, ", "), result)
+	fmt.Fprintf(b, "path := %s\n", path)
+
+	query := "nil"
+	if len(queryLines) > 0 {
+		query = "query"
+		b.WriteString("query := url.Values{}\n")
+		for _, line := range queryLines {
+			b.WriteString(line + "\n")
+		}
+	}
+
+	payload := "nil"
+	if endpoint.JSONBody() != nil {
+		payload = "payload"
+		b.WriteString("var payload interface{}\nif body != nil {\npayload = body\n}\n")
+	}
+
+	op := "&operation" + name
+	switch {
+	case endpoint.Streams():
+		fmt.Fprintf(b, "return c.stream(ctx, %s, path, %s)\n", op, query)
+	case response == nil:
+		fmt.Fprintf(b, "_, err := c.do(ctx, %s, path, %s, %s, nil)\nreturn err\n", op, query, payload)
+	case strings.HasPrefix(responseType, "*"):
+		fmt.Fprintf(b, "out := new(%s)\n", responseType[1:])
+		fmt.Fprintf(b, "decoded, err := c.do(ctx, %s, path, %s, %s, out)\n", op, query, payload)
+		b.WriteString("if err != nil || !decoded {\nreturn nil, err\n}\nreturn out, nil\n")
+	default:
+		fmt.Fprintf(b, "var out %s\n", responseType)
+		fmt.Fprintf(b, "if _, err := c.do(ctx, %s, path, %s, %s, &out); err != nil {\nreturn nil, err\n}\nreturn out, nil\n", op, query, payload)
+	}
+	b.WriteString("}\n\n")
+	return nil
+}
@@ -0,0 +1,77 @@
+package artifacts
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIPFSUploaderAdd(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/add" || r.URL.Query().Get("pin") != "true" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		fmt.Fprintf(w, "{\"Name\":%q,\"Hash\":\"bafy-%s\"}\n", header.Filename, data)
+	}))
+	defer server.Close()
+
+	cid, err := NewIPFSUploader(server.URL+"/").Add(context.Background(), "blob", strings.NewReader("content"))
+	if err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+	if cid != "bafy-content" {
+		t.Fatalf("expected bafy-content, got %s", cid)
+	}
+}
+
+func TestIPFSUploaderReportsErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "repo full", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	_, err := NewIPFSUploader(server.URL).Add(context.Background(), "blob", strings.NewReader("content"))
+	if err == nil || !strings.Contains(err.Error(), "repo full") {
+		t.Fatalf("expected daemon error, got %v", err)
+	}
+}
+
+func TestCachePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artifacts", "blobs.json")
+
+	cache, err := OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Lookup("sha256:abc"); ok {
+		t.Fatal("expected empty cache")
+	}
+	if err := cache.Record("sha256:abc", "bafy1"); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cid, ok := reopened.Lookup("sha256:abc"); !ok || cid != "bafy1" {
+		t.Fatalf("expected persisted entry, got %q %v", cid, ok)
+	}
+
+	var nilCache *Cache
+	if _, ok := nilCache.Lookup("sha256:abc"); ok {
+		t.Fatal("nil cache should hold nothing")
+	}
+}
@@ -0,0 +1,106 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/caches"
+)
+
+// Cache maps content digests to the CIDs they were uploaded under, so blobs
+// shared between artifacts, such as base image layers, are uploaded once
+type Cache struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]string
+}
+
+// OpenCache loads the cache at path, starting empty when it does not exist
+func OpenCache(path string) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create artifact cache directory: %w", err)
+	}
+
+	c := &Cache{path: path, entries: make(map[string]string)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifact cache: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("failed to decode artifact cache: %w", err)
+	}
+	return c, nil
+}
+
+// Lookup returns the CID previously recorded for digest
+func (c *Cache) Lookup(digest string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cid, ok := c.entries[digest]
+	return cid, ok
+}
+
+// Record remembers that digest was uploaded as cid and persists the cache
+func (c *Cache) Record(digest, cid string) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[digest] = cid
+	return c.save()
+}
+
+func (c *Cache) save() error {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal artifact cache: %w", err)
+	}
+	if err := atrest.WriteFileAtomic(c.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to persist artifact cache: %w", err)
+	}
+	return nil
+}
+
+// Entries lists the recorded digests. The blobs themselves live in IPFS, so
+// entries take no local space; purging one forces its next upload.
+func (c *Cache) Entries(ctx context.Context) ([]caches.Entry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]caches.Entry, 0, len(c.entries))
+	for digest := range c.entries {
+		entries = append(entries, caches.Entry{Key: digest})
+	}
+	return entries, nil
+}
+
+func (c *Cache) Has(ctx context.Context, digest string) (bool, error) {
+	_, ok := c.Lookup(digest)
+	return ok, nil
+}
+
+// Remove forgets digest so the blob is uploaded again when next exported
+func (c *Cache) Remove(ctx context.Context, digest string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[digest]; !ok {
+		return nil
+	}
+	delete(c.entries, digest)
+	return c.save()
+}
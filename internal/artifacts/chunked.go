@@ -0,0 +1,294 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ErrHaveUnsupported is returned by destinations that cannot say which
+// chunks they already hold
+var ErrHaveUnsupported = errors.New("destination does not report the chunks it holds")
+
+// maxManifestSize bounds the manifests read back, which list a chunk per
+// entry
+const maxManifestSize = 64 << 20
+
+// ChunkStore is a destination that stores chunks by their hash and can say
+// which of them it already holds, so that only the others are sent
+type ChunkStore interface {
+	// HaveChunks returns the references of the chunks among hashes the
+	// destination holds, by hash. It returns ErrHaveUnsupported when the
+	// destination cannot tell.
+	HaveChunks(ctx context.Context, hashes []string) (map[string]string, error)
+	// AddChunk stores the chunk hash and returns its reference
+	AddChunk(ctx context.Context, hash string, r io.Reader) (string, error)
+}
+
+// ChunkedAdder is an uploader that uploads artifacts as chunks. The
+// reference it returns names the artifact's manifest.
+type ChunkedAdder interface {
+	// AddChunked uploads r as Add does, and describes the chunks it was
+	// uploaded as, nil when it was uploaded whole
+	AddChunked(ctx context.Context, name string, r io.Reader) (string, *models.ChunkedArtifact, error)
+}
+
+// Upload uploads r through u, as chunks when u uploads artifacts as chunks
+func Upload(ctx context.Context, u Uploader, name string, r io.Reader) (string, *models.ChunkedArtifact, error) {
+	if chunked, ok := u.(ChunkedAdder); ok {
+		return chunked.AddChunked(ctx, name, r)
+	}
+	ref, err := u.Add(ctx, name, r)
+	return ref, nil, err
+}
+
+// ChunkedUploaders upload artifacts larger than an inline threshold as
+// content-addressed chunks under a manifest. Chunks the destination
+// already holds, say from an earlier checkpoint of the same task, are not
+// sent again. Smaller artifacts are uploaded whole.
+type ChunkedUploaders struct {
+	source   UploaderSource
+	cache    *Cache
+	chunking Chunking
+	inline   int64
+}
+
+// NewChunkedUploaders uploads through source. cache remembers the chunks
+// uploaded to destinations that cannot say which they hold; it may be nil.
+func NewChunkedUploaders(source UploaderSource, cache *Cache, chunking Chunking, inlineThreshold int64) (*ChunkedUploaders, error) {
+	if err := chunking.Validate(); err != nil {
+		return nil, err
+	}
+	return &ChunkedUploaders{source: source, cache: cache, chunking: chunking, inline: inlineThreshold}, nil
+}
+
+func (s *ChunkedUploaders) ForTask(taskID string) Uploader {
+	return &chunkedUploader{
+		taskID:   taskID,
+		inner:    s.source.ForTask(taskID),
+		cache:    s.cache,
+		chunking: s.chunking,
+		inline:   s.inline,
+	}
+}
+
+type chunkedUploader struct {
+	taskID   string
+	inner    Uploader
+	cache    *Cache
+	chunking Chunking
+	inline   int64
+}
+
+func (u *chunkedUploader) Add(ctx context.Context, name string, r io.Reader) (string, error) {
+	ref, _, err := u.AddChunked(ctx, name, r)
+	return ref, err
+}
+
+// spooledChunk is where a chunk lies in the spooled artifact
+type spooledChunk struct {
+	hash   string
+	offset int64
+	size   int64
+}
+
+// AddChunked uploads r whole when it is no larger than the inline
+// threshold. Otherwise it is cut into chunks, spooled to disk while they
+// are hashed, the destination is asked which chunks it lacks and only
+// those are sent, followed by the manifest.
+func (u *chunkedUploader) AddChunked(ctx context.Context, name string, r io.Reader) (string, *models.ChunkedArtifact, error) {
+	head, err := io.ReadAll(io.LimitReader(r, u.inline+1))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if int64(len(head)) <= u.inline {
+		ref, err := u.inner.Add(ctx, name, bytes.NewReader(head))
+		return ref, nil, err
+	}
+
+	spool, err := os.CreateTemp("", "parity-chunks-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to spool %s: %w", name, err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	var (
+		chunks []spooledChunk
+		offset int64
+	)
+	err = Split(io.MultiReader(bytes.NewReader(head), r), u.chunking, func(chunk []byte) error {
+		if _, err := spool.Write(chunk); err != nil {
+			return err
+		}
+		chunks = append(chunks, spooledChunk{hash: ChunkHash(chunk), offset: offset, size: int64(len(chunk))})
+		offset += int64(len(chunk))
+		return nil
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to chunk %s: %w", name, err)
+	}
+
+	hashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		hashes[i] = chunk.hash
+	}
+	tree, err := NewMerkleTree(hashes)
+	if err != nil {
+		return "", nil, err
+	}
+	have, err := u.have(ctx, hashes)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to ask which chunks of %s are held: %w", name, err)
+	}
+
+	manifest := &models.ArtifactManifest{
+		Version:  models.ArtifactManifestVersion,
+		Name:     name,
+		Size:     offset,
+		Chunking: u.chunking.Mode,
+		Root:     tree.Root(),
+		Chunks:   make([]models.ArtifactChunk, len(chunks)),
+	}
+	uploaded := 0
+	for i, chunk := range chunks {
+		ref, ok := have[chunk.hash]
+		if !ok {
+			ref, err = u.addChunk(ctx, chunk.hash, io.NewSectionReader(spool, chunk.offset, chunk.size))
+			if err != nil {
+				return "", nil, fmt.Errorf("failed to upload chunk %d of %s: %w", i, name, err)
+			}
+			have[chunk.hash] = ref
+			uploaded++
+		}
+		manifest.Chunks[i] = models.ArtifactChunk{Hash: chunk.hash, Size: chunk.size, CID: ref}
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal manifest of %s: %w", name, err)
+	}
+	ref, err := u.inner.Add(ctx, name+".manifest.json", bytes.NewReader(data))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to upload manifest of %s: %w", name, err)
+	}
+	return ref, &models.ChunkedArtifact{
+		Name:           name,
+		ManifestCID:    ref,
+		Root:           manifest.Root,
+		Size:           manifest.Size,
+		Chunks:         len(chunks),
+		UploadedChunks: uploaded,
+	}, nil
+}
+
+// have returns the chunks among hashes the destination holds. Those it
+// cannot ask about are looked up in the cache of chunks uploaded before.
+func (u *chunkedUploader) have(ctx context.Context, hashes []string) (map[string]string, error) {
+	if store, ok := u.inner.(ChunkStore); ok {
+		have, err := store.HaveChunks(ctx, hashes)
+		if err == nil {
+			if have == nil {
+				have = make(map[string]string)
+			}
+			return have, nil
+		}
+		if !errors.Is(err, ErrHaveUnsupported) {
+			return nil, err
+		}
+	}
+	have := make(map[string]string)
+	for _, hash := range hashes {
+		if ref, ok := u.cache.Lookup(chunkCacheKey(hash)); ok {
+			have[hash] = ref
+		}
+	}
+	return have, nil
+}
+
+func (u *chunkedUploader) addChunk(ctx context.Context, hash string, r io.Reader) (string, error) {
+	var (
+		ref string
+		err error
+	)
+	if store, ok := u.inner.(ChunkStore); ok {
+		ref, err = store.AddChunk(ctx, hash, r)
+	} else {
+		ref, err = u.inner.Add(ctx, hash, r)
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := u.cache.Record(chunkCacheKey(hash), ref); err != nil {
+		log := gologger.WithComponent("artifacts")
+		log.Warn().Err(err).Str("task_id", u.taskID).Str("chunk", hash).Msg("Failed to record uploaded chunk")
+	}
+	return ref, nil
+}
+
+// chunkCacheKey keeps chunk hashes apart from the blob digests the cache
+// also holds
+func chunkCacheKey(hash string) string {
+	return "chunk:sha256:" + hash
+}
+
+// ReadManifest decodes the manifest of a chunked artifact and checks that
+// its chunks hash to root, the root the artifact was reported with
+func ReadManifest(r io.Reader, root string) (*models.ArtifactManifest, error) {
+	var manifest models.ArtifactManifest
+	if err := json.NewDecoder(io.LimitReader(r, maxManifestSize)).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("failed to decode artifact manifest: %w", err)
+	}
+	if manifest.Version != models.ArtifactManifestVersion {
+		return nil, fmt.Errorf("unsupported artifact manifest version %d", manifest.Version)
+	}
+	hashes := make([]string, len(manifest.Chunks))
+	var size int64
+	for i, chunk := range manifest.Chunks {
+		hashes[i] = chunk.Hash
+		size += chunk.Size
+	}
+	tree, err := NewMerkleTree(hashes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid artifact manifest: %w", err)
+	}
+	if tree.Root() != root || manifest.Root != root {
+		return nil, fmt.Errorf("artifact manifest does not match root %s", root)
+	}
+	if size != manifest.Size {
+		return nil, fmt.Errorf("artifact manifest chunks add up to %d bytes, not %d", size, manifest.Size)
+	}
+	return &manifest, nil
+}
+
+// Assemble writes the chunks of manifest, read with ReadManifest, to w in
+// order, fetching each with fetch. A chunk that does not match the manifest
+// fails with ErrChunkCorrupt naming it.
+func Assemble(ctx context.Context, manifest *models.ArtifactManifest, fetch func(ctx context.Context, index int, chunk models.ArtifactChunk) (io.ReadCloser, error), w io.Writer) error {
+	for i, chunk := range manifest.Chunks {
+		rc, err := fetch(ctx, i, chunk)
+		if err != nil {
+			return fmt.Errorf("failed to fetch chunk %d: %w", i, err)
+		}
+		data, err := io.ReadAll(io.LimitReader(rc, chunk.Size+1))
+		rc.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read chunk %d: %w", i, err)
+		}
+		if int64(len(data)) != chunk.Size || ChunkHash(data) != chunk.Hash {
+			return fmt.Errorf("%w: chunk %d", ErrChunkCorrupt, i)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func randomBytes(n int, seed int64) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func splitHashes(t *testing.T, data []byte, c Chunking) []string {
+	t.Helper()
+	var (
+		hashes []string
+		joined []byte
+	)
+	minSize, maxSize := c.bounds()
+	err := Split(bytes.NewReader(data), c, func(chunk []byte) error {
+		if len(chunk) > maxSize || (len(chunk) < minSize && len(joined)+len(chunk) != len(data)) {
+			t.Errorf("chunk %d is %d bytes, outside %d-%d", len(hashes), len(chunk), minSize, maxSize)
+		}
+		hashes = append(hashes, ChunkHash(chunk))
+		joined = append(joined, chunk...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(joined, data) {
+		t.Fatal("chunks do not add up to the artifact")
+	}
+	return hashes
+}
+
+func TestSplitIsDeterministic(t *testing.T) {
+	data := randomBytes(3<<20, 1)
+	for _, mode := range []string{models.ChunkingFixed, models.ChunkingContentDefined} {
+		t.Run(mode, func(t *testing.T) {
+			c := Chunking{Mode: mode, Size: 64 << 10}
+			first, second := splitHashes(t, data, c), splitHashes(t, data, c)
+			if strings.Join(first, ",") != strings.Join(second, ",") {
+				t.Fatal("the same content was cut differently")
+			}
+			a, _ := NewMerkleTree(first)
+			b, _ := NewMerkleTree(second)
+			if a.Root() != b.Root() {
+				t.Fatal("the same content has different roots")
+			}
+		})
+	}
+}
+
+func TestContentDefinedChunksSurviveInsertion(t *testing.T) {
+	data := randomBytes(3<<20, 2)
+	edited := append(append(append([]byte(nil), data[:len(data)/2]...), "inserted bytes"...), data[len(data)/2:]...)
+	c := Chunking{Mode: models.ChunkingContentDefined, Size: 64 << 10}
+
+	before, after := splitHashes(t, data, c), splitHashes(t, edited, c)
+	held := make(map[string]bool)
+	for _, hash := range before {
+		held[hash] = true
+	}
+	changed := 0
+	for _, hash := range after {
+		if !held[hash] {
+			changed++
+		}
+	}
+	if changed == 0 || changed > 2 {
+		t.Fatalf("inserting into one chunk changed %d of %d chunks", changed, len(after))
+	}
+}
+
+// fakeChunkBackend is an IPFS endpoint addressing content by its SHA-256,
+// that answers which chunks it holds when have is set
+type fakeChunkBackend struct {
+	have bool
+
+	mu      sync.Mutex
+	content map[string][]byte
+	adds    int
+}
+
+func (b *fakeChunkBackend) serve(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		switch r.URL.Path {
+		case "/api/v0/add":
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data, _ := io.ReadAll(file)
+			cid := "bafy" + ChunkHash(data)
+			b.content[cid] = data
+			b.adds++
+			fmt.Fprintf(w, "{\"Name\":%q,\"Hash\":%q}\n", header.Filename, cid)
+		case "/api/v0/chunks/have":
+			if !b.have {
+				http.NotFound(w, r)
+				return
+			}
+			var req haveRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			resp := haveResponse{Have: make(map[string]string)}
+			for _, hash := range req.Hashes {
+				if _, ok := b.content["bafy"+hash]; ok {
+					resp.Have[hash] = "bafy" + hash
+				}
+			}
+			json.NewEncoder(w).Encode(resp)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (b *fakeChunkBackend) fetch(_ context.Context, _ int, chunk models.ArtifactChunk) (io.ReadCloser, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	data, ok := b.content[chunk.CID]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", chunk.CID)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func TestChunkedUploadSendsOnlyMissingChunks(t *testing.T) {
+	for _, have := range []bool{true, false} {
+		t.Run(fmt.Sprintf("have=%v", have), func(t *testing.T) {
+			backend := &fakeChunkBackend{have: have, content: make(map[string][]byte)}
+			server := backend.serve(t)
+			var cache *Cache
+			if !have {
+				// Without have/want the runner remembers what it sent
+				var err error
+				if cache, err = OpenCache(filepath.Join(t.TempDir(), "blobs.json")); err != nil {
+					t.Fatal(err)
+				}
+			}
+			uploaders, err := NewChunkedUploaders(NewIPFSUploader(server.URL), cache, Chunking{Mode: models.ChunkingContentDefined, Size: 16 << 10}, 1<<10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			uploader := uploaders.ForTask("task-1")
+			ctx := context.Background()
+
+			data := randomBytes(512<<10, 3)
+			_, first, err := Upload(ctx, uploader, "model.ckpt", bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if first == nil || first.UploadedChunks != first.Chunks {
+				t.Fatalf("first upload = %+v, want every chunk sent", first)
+			}
+
+			copy(data[200<<10:], "a later checkpoint")
+			ref, second, err := Upload(ctx, uploader, "model.ckpt", bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if second.UploadedChunks == 0 || second.UploadedChunks > 2 {
+				t.Fatalf("second upload sent %d of %d chunks, want only those changed", second.UploadedChunks, second.Chunks)
+			}
+
+			manifestData, _ := backend.fetch(ctx, 0, models.ArtifactChunk{CID: ref})
+			manifest, err := ReadManifest(manifestData, second.Root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var assembled bytes.Buffer
+			if err := Assemble(ctx, manifest, backend.fetch, &assembled); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(assembled.Bytes(), data) {
+				t.Fatal("assembled artifact differs from the one uploaded")
+			}
+		})
+	}
+}
+
+func TestSmallArtifactsAreUploadedInline(t *testing.T) {
+	backend := &fakeChunkBackend{have: true, content: make(map[string][]byte)}
+	server := backend.serve(t)
+	uploaders, err := NewChunkedUploaders(NewIPFSUploader(server.URL), nil, Chunking{Mode: models.ChunkingFixed, Size: 16 << 10}, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, chunked, err := Upload(context.Background(), uploaders.ForTask("task-1"), "small", strings.NewReader("tiny"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chunked != nil || ref != "bafy"+ChunkHash([]byte("tiny")) || backend.adds != 1 {
+		t.Fatalf("small artifact uploaded as %s, %+v in %d adds, want it whole", ref, chunked, backend.adds)
+	}
+}
+
+func TestCorruptChunkIsDetected(t *testing.T) {
+	var chunks [][]byte
+	err := Split(bytes.NewReader(randomBytes(100<<10, 4)), Chunking{Mode: models.ChunkingFixed, Size: 16 << 10}, func(chunk []byte) error {
+		chunks = append(chunks, append([]byte(nil), chunk...))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		hashes[i] = ChunkHash(chunk)
+	}
+	tree, err := NewMerkleTree(hashes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chunks[2][100] ^= 1
+	for i, chunk := range chunks {
+		proof, err := tree.Proof(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = VerifyChunk(tree.Root(), i, len(chunks), chunk, proof)
+		if corrupt := i == 2; corrupt != errors.Is(err, ErrChunkCorrupt) {
+			t.Errorf("VerifyChunk(%d) error = %v", i, err)
+		}
+	}
+
+	manifest := &models.ArtifactManifest{Root: tree.Root()}
+	for i, chunk := range chunks {
+		manifest.Chunks = append(manifest.Chunks, models.ArtifactChunk{Hash: hashes[i], Size: int64(len(chunk))})
+	}
+	err = Assemble(context.Background(), manifest, func(_ context.Context, i int, _ models.ArtifactChunk) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(chunks[i])), nil
+	}, io.Discard)
+	if !errors.Is(err, ErrChunkCorrupt) || !strings.Contains(err.Error(), "chunk 2") {
+		t.Fatalf("Assemble() error = %v, want chunk 2 reported corrupt", err)
+	}
+}
@@ -0,0 +1,111 @@
+package artifacts
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// DefaultChunkSize is the size of fixed chunks, and the average size of
+// content-defined ones, when none is configured
+const DefaultChunkSize = 1 << 20
+
+// minChunkSize keeps chunks from being so small their hashes outweigh them
+const minChunkSize = 4 << 10
+
+// Chunking is how artifacts are cut into chunks
+type Chunking struct {
+	// Mode is models.ChunkingFixed or models.ChunkingContentDefined
+	Mode string
+	// Size is the size of fixed chunks and the average size of
+	// content-defined ones
+	Size int
+}
+
+// Validate checks the chunking is one artifacts can be cut by
+func (c Chunking) Validate() error {
+	if c.Mode != models.ChunkingFixed && c.Mode != models.ChunkingContentDefined {
+		return fmt.Errorf("unknown chunking mode %q (supported: %s, %s)", c.Mode, models.ChunkingFixed, models.ChunkingContentDefined)
+	}
+	if c.Size < minChunkSize {
+		return fmt.Errorf("chunk size %d is below the minimum of %d", c.Size, minChunkSize)
+	}
+	return nil
+}
+
+// bounds returns the smallest and largest chunk the chunking cuts
+func (c Chunking) bounds() (int, int) {
+	if c.Mode == models.ChunkingFixed {
+		return c.Size, c.Size
+	}
+	return c.Size / 4, c.Size * 4
+}
+
+// gearTable drives the rolling hash of content-defined chunking. It is
+// derived from a fixed seed so that every runner cuts the same content at
+// the same places, which is what lets runners share chunks.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x9e3779b97f4a7c15)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Split cuts r into chunks and calls emit with each in order. The slice
+// emit is given is reused once it returns.
+func Split(r io.Reader, c Chunking, emit func(chunk []byte) error) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	minSize, maxSize := c.bounds()
+	// The hash's top bits decide cuts; as many as the average size has make
+	// a cut come every Size bytes on average
+	mask := ^uint64(0) << (64 - (bits.Len(uint(c.Size)) - 1))
+
+	buf := make([]byte, maxSize)
+	filled := 0
+	eof := false
+	for {
+		if !eof {
+			n, err := io.ReadFull(r, buf[filled:])
+			filled += n
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if filled == 0 {
+			return nil
+		}
+
+		cut := filled
+		if c.Mode == models.ChunkingContentDefined && filled > minSize {
+			var hash uint64
+			for i := minSize; i < filled; i++ {
+				hash = hash<<1 + gearTable[buf[i]]
+				if hash&mask == 0 {
+					cut = i + 1
+					break
+				}
+			}
+		}
+		if err := emit(buf[:cut]); err != nil {
+			return err
+		}
+		filled = copy(buf, buf[cut:filled])
+		if eof && filled == 0 {
+			return nil
+		}
+	}
+}
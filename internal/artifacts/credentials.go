@@ -0,0 +1,255 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// tokenRefreshMargin is how close to expiry a token is replaced before an
+// upload starts rather than risking its expiry mid-upload
+const tokenRefreshMargin = 30 * time.Second
+
+// UploaderSource hands out the uploader for one task's artifacts
+type UploaderSource interface {
+	ForTask(taskID string) Uploader
+}
+
+// UploadTokenIssuer issues short-lived upload tokens scoped to one task
+type UploadTokenIssuer interface {
+	IssueUploadToken(taskID string) (*models.UploadToken, error)
+}
+
+// ScopedUploaders gives each task an uploader authenticated by its own
+// short-lived token, so no long-lived credential is held by code that
+// handles task-controlled data
+type ScopedUploaders struct {
+	apiURL string
+	issue  func(taskID string) (*models.UploadToken, error)
+	client *http.Client
+	clock  clock.Clock
+}
+
+func NewScopedUploaders(apiURL string, issuer UploadTokenIssuer) *ScopedUploaders {
+	if apiURL == "" {
+		apiURL = DefaultIPFSAPIURL
+	}
+	return &ScopedUploaders{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		issue:  issuer.IssueUploadToken,
+		client: &http.Client{},
+		clock:  clock.Real(),
+	}
+}
+
+func (s *ScopedUploaders) SetClock(clk clock.Clock) {
+	s.clock = clk
+}
+
+// ForTask returns an uploader that only ever holds tokens issued for taskID.
+// It fetches its first token lazily, on the first upload.
+func (s *ScopedUploaders) ForTask(taskID string) Uploader {
+	issue := s.issue
+	return &taskUploader{
+		taskID: taskID,
+		apiURL: s.apiURL,
+		client: s.client,
+		clock:  s.clock,
+		issue:  func() (*models.UploadToken, error) { return issue(taskID) },
+	}
+}
+
+// taskUploader uploads one task's artifacts. It reaches the issuer only
+// through issue, which is bound to its task.
+type taskUploader struct {
+	taskID string
+	apiURL string
+	client *http.Client
+	clock  clock.Clock
+	issue  func() (*models.UploadToken, error)
+
+	mu    sync.Mutex
+	token *models.UploadToken
+}
+
+// currentToken returns the task's token, replacing it when it is about
+// to expire or when refresh is set
+func (u *taskUploader) currentToken(refresh bool) (*models.UploadToken, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if !refresh && !u.token.ExpiresWithin(u.clock.Now(), tokenRefreshMargin) {
+		return u.token, nil
+	}
+	token, err := u.issue()
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue upload token for task %s: %w", u.taskID, err)
+	}
+	if token == nil || token.Token == "" {
+		return nil, fmt.Errorf("upload token for task %s is empty", u.taskID)
+	}
+	if token.TaskID != "" && token.TaskID != u.taskID {
+		return nil, fmt.Errorf("upload token is scoped to task %s, not %s", token.TaskID, u.taskID)
+	}
+	u.token = token
+	return token, nil
+}
+
+// Add uploads r with the task's token. A token that expires mid-upload is
+// refreshed once and the upload resent from where r started, which needs r
+// to be seekable.
+func (u *taskUploader) Add(ctx context.Context, name string, r io.Reader) (string, error) {
+	token, err := u.currentToken(false)
+	if err != nil {
+		return "", err
+	}
+
+	seeker, seekable := r.(io.Seeker)
+	var start int64
+	if seekable {
+		if start, err = seeker.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	cid, err := ipfsAdd(ctx, u.client, u.endpoint(token), token.Token, name, r)
+	if !errors.Is(err, ErrUploadUnauthorized) {
+		return cid, err
+	}
+	if !seekable {
+		return "", fmt.Errorf("%w and %s cannot be resent", err, name)
+	}
+	if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind %s: %w", name, err)
+	}
+
+	token, err = u.currentToken(true)
+	if err != nil {
+		return "", err
+	}
+	return ipfsAdd(ctx, u.client, u.endpoint(token), token.Token, name, r)
+}
+
+// HaveChunks asks the pinning service which chunks it holds, with the
+// task's token
+func (u *taskUploader) HaveChunks(ctx context.Context, hashes []string) (map[string]string, error) {
+	token, err := u.currentToken(false)
+	if err != nil {
+		return nil, err
+	}
+	have, err := ipfsHave(ctx, u.client, u.endpoint(token), token.Token, hashes)
+	if !errors.Is(err, ErrUploadUnauthorized) {
+		return have, err
+	}
+	if token, err = u.currentToken(true); err != nil {
+		return nil, err
+	}
+	return ipfsHave(ctx, u.client, u.endpoint(token), token.Token, hashes)
+}
+
+// AddChunk uploads the chunk hash with the task's token
+func (u *taskUploader) AddChunk(ctx context.Context, hash string, r io.Reader) (string, error) {
+	return u.Add(ctx, hash, r)
+}
+
+func (u *taskUploader) endpoint(token *models.UploadToken) string {
+	if token.APIURL != "" {
+		return strings.TrimSuffix(token.APIURL, "/")
+	}
+	return u.apiURL
+}
+
+// PinningKeyIssuer mints short-lived keys from a pinning service's
+// scoped-key API. It is the only holder of the service's long-lived key;
+// the executor and task uploaders reach it only through a closure that
+// returns scoped keys.
+type PinningKeyIssuer struct {
+	apiURL string
+	key    string
+	ttl    time.Duration
+	client *http.Client
+}
+
+func NewPinningKeyIssuer(apiURL, key string, ttl time.Duration) *PinningKeyIssuer {
+	if ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+	return &PinningKeyIssuer{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		key:    key,
+		ttl:    ttl,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// String keeps the long-lived key out of logs
+func (p *PinningKeyIssuer) String() string {
+	return fmt.Sprintf("PinningKeyIssuer(%s)", p.apiURL)
+}
+
+func (p *PinningKeyIssuer) GoString() string {
+	return p.String()
+}
+
+type scopedKeyRequest struct {
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int64    `json:"expires_in"`
+	TaskID    string   `json:"task_id"`
+}
+
+type scopedKeyResponse struct {
+	Key       string    `json:"key"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// IssueUploadToken creates a key that may only add and pin content and that
+// expires after the issuer's TTL
+func (p *PinningKeyIssuer) IssueUploadToken(taskID string) (*models.UploadToken, error) {
+	reqBody, err := json.Marshal(scopedKeyRequest{
+		Name:      "parity-task-" + taskID,
+		Scopes:    []string{"add", "pin"},
+		ExpiresIn: int64(p.ttl / time.Second),
+		TaskID:    taskID,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal scoped key request: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.apiURL+"/api/v1/keys", bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scoped key request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+p.key)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request scoped key: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("scoped key request failed: status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var scoped scopedKeyResponse
+	if err := json.NewDecoder(resp.Body).Decode(&scoped); err != nil {
+		return nil, fmt.Errorf("failed to decode scoped key response: %w", err)
+	}
+	if scoped.Key == "" {
+		return nil, fmt.Errorf("scoped key response contained no key")
+	}
+	return &models.UploadToken{Token: scoped.Key, TaskID: taskID, ExpiresAt: scoped.ExpiresAt}, nil
+}
@@ -0,0 +1,216 @@
+package artifacts
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const masterKey = "long-lived-master-key"
+
+// fakePinningService mints scoped keys for the master key and accepts adds
+// only with a scoped key that has not been revoked
+type fakePinningService struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	minted  int
+	tasks   map[string]string // scoped key -> task
+	revoked map[string]bool
+	adds    []string // "<task> <name> <content>"
+	headers []string // every Authorization header sent to the add endpoint
+}
+
+func newFakePinningService(t *testing.T) *fakePinningService {
+	s := &fakePinningService{tasks: make(map[string]string), revoked: make(map[string]bool)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		auth := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		switch r.URL.Path {
+		case "/api/v1/keys":
+			if auth != masterKey {
+				http.Error(w, "bad master key", http.StatusForbidden)
+				return
+			}
+			var req scopedKeyRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			s.minted++
+			key := fmt.Sprintf("scoped-%s-%d", req.TaskID, s.minted)
+			s.tasks[key] = req.TaskID
+			json.NewEncoder(w).Encode(scopedKeyResponse{Key: key, ExpiresAt: time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)})
+		case "/api/v0/add":
+			s.headers = append(s.headers, r.Header.Get("Authorization"))
+			task, ok := s.tasks[auth]
+			if !ok || s.revoked[auth] {
+				http.Error(w, "token expired", http.StatusUnauthorized)
+				return
+			}
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			data, _ := io.ReadAll(file)
+			s.adds = append(s.adds, task+" "+header.Filename+" "+string(data))
+			fmt.Fprintf(w, "{\"Name\":%q,\"Hash\":\"bafy-%s\"}\n", header.Filename, data)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fakePinningService) expireAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.tasks {
+		s.revoked[key] = true
+	}
+}
+
+func TestScopedUploadersKeepTasksApart(t *testing.T) {
+	service := newFakePinningService(t)
+	uploaders := NewScopedUploaders(service.URL, NewPinningKeyIssuer(service.URL, masterKey, time.Minute))
+
+	for _, task := range []string{"task-a", "task-b"} {
+		if _, err := uploaders.ForTask(task).Add(context.Background(), "out", strings.NewReader(task)); err != nil {
+			t.Fatalf("Add() for %s: %v", task, err)
+		}
+	}
+
+	want := []string{"task-a out task-a", "task-b out task-b"}
+	if strings.Join(service.adds, "|") != strings.Join(want, "|") {
+		t.Fatalf("adds = %q, want each task uploading with its own key %q", service.adds, want)
+	}
+	if service.minted != 2 {
+		t.Fatalf("minted %d keys, want one per task", service.minted)
+	}
+}
+
+type staticIssuer struct {
+	token *models.UploadToken
+	calls int
+}
+
+func (i *staticIssuer) IssueUploadToken(taskID string) (*models.UploadToken, error) {
+	i.calls++
+	return i.token, nil
+}
+
+func TestTaskUploaderRejectsTokenForAnotherTask(t *testing.T) {
+	service := newFakePinningService(t)
+	issuer := &staticIssuer{token: &models.UploadToken{Token: "scoped-other", TaskID: "task-b"}}
+
+	_, err := NewScopedUploaders(service.URL, issuer).ForTask("task-a").Add(context.Background(), "out", strings.NewReader("x"))
+	if err == nil || !strings.Contains(err.Error(), "scoped to task task-b") {
+		t.Fatalf("expected scope error, got %v", err)
+	}
+	if len(service.headers) != 0 {
+		t.Fatal("upload attempted with another task's token")
+	}
+}
+
+func TestTaskUploaderRefreshesExpiredTokenOnce(t *testing.T) {
+	service := newFakePinningService(t)
+	uploader := NewScopedUploaders(service.URL, NewPinningKeyIssuer(service.URL, masterKey, time.Minute)).ForTask("task-a")
+
+	if _, err := uploader.Add(context.Background(), "first", strings.NewReader("one")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key lapses server-side; the next upload refreshes it and resends
+	service.expireAll()
+	cid, err := uploader.Add(context.Background(), "second", strings.NewReader("two"))
+	if err != nil {
+		t.Fatalf("Add() after expiry: %v", err)
+	}
+	if cid != "bafy-two" || service.minted != 2 {
+		t.Fatalf("cid = %s after %d keys, want bafy-two after one refresh", cid, service.minted)
+	}
+
+	// A refreshed token that is rejected too is not refreshed again
+	issuer := &staticIssuer{token: &models.UploadToken{Token: "never-valid", TaskID: "task-a"}}
+	_, err = NewScopedUploaders(service.URL, issuer).ForTask("task-a").Add(context.Background(), "third", strings.NewReader("three"))
+	if err == nil || issuer.calls != 2 {
+		t.Fatalf("err = %v after %d issues, want failure after a single refresh", err, issuer.calls)
+	}
+
+	// Content that cannot be rewound is not resent
+	service.expireAll()
+	_, err = uploader.Add(context.Background(), "stream", io.MultiReader(strings.NewReader("four")))
+	if err == nil || !strings.Contains(err.Error(), "cannot be resent") {
+		t.Fatalf("expected unseekable upload to fail, got %v", err)
+	}
+}
+
+func TestTaskUploaderReplacesTokenNearExpiry(t *testing.T) {
+	service := newFakePinningService(t)
+	start := time.Now()
+	clk := clocktest.NewFake(start)
+	issuer := &staticIssuer{}
+	issue := func() {
+		issuer.token = &models.UploadToken{Token: fmt.Sprintf("scoped-task-a-%d", issuer.calls), TaskID: "task-a", ExpiresAt: clk.Now().Add(time.Minute)}
+		service.mu.Lock()
+		service.tasks[issuer.token.Token] = "task-a"
+		service.mu.Unlock()
+	}
+	issue()
+
+	uploaders := NewScopedUploaders(service.URL, issuer)
+	uploaders.SetClock(clk)
+	uploader := uploaders.ForTask("task-a")
+
+	if _, err := uploader.Add(context.Background(), "a", strings.NewReader("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uploader.Add(context.Background(), "b", strings.NewReader("b")); err != nil || issuer.calls != 1 {
+		t.Fatalf("fresh token replaced: err %v, %d issues", err, issuer.calls)
+	}
+
+	clk.Advance(45 * time.Second)
+	issue()
+	if _, err := uploader.Add(context.Background(), "c", strings.NewReader("c")); err != nil || issuer.calls != 2 {
+		t.Fatalf("token near expiry kept: err %v, %d issues", err, issuer.calls)
+	}
+}
+
+func TestPinningKeyNeverReachesTaskUploaders(t *testing.T) {
+	service := newFakePinningService(t)
+	issuer := NewPinningKeyIssuer(service.URL, masterKey, time.Minute)
+	uploaders := NewScopedUploaders(service.URL, issuer)
+	uploader := uploaders.ForTask("task-a")
+
+	if _, err := uploader.Add(context.Background(), "out", strings.NewReader("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The source and uploaders the executor holds must not reveal the key
+	for _, state := range []interface{}{uploaders, uploader, issuer} {
+		for _, format := range []string{"%v", "%+v", "%#v"} {
+			if strings.Contains(fmt.Sprintf(format, state), masterKey) {
+				t.Fatalf("%T formatted with %s reveals the pinning key", state, format)
+			}
+		}
+		if data, _ := json.Marshal(state); strings.Contains(string(data), masterKey) {
+			t.Fatalf("%T encodes the pinning key", state)
+		}
+	}
+	for _, header := range service.headers {
+		if strings.Contains(header, masterKey) {
+			t.Fatal("pinning key sent with an artifact upload")
+		}
+	}
+}
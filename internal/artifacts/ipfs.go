@@ -0,0 +1,188 @@
+// Package artifacts uploads task artifacts to IPFS or an S3-compatible
+// object store and remembers what has already been uploaded so repeated
+// content is not sent twice
+package artifacts
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strings"
+)
+
+// DefaultIPFSAPIURL is the local IPFS daemon's RPC endpoint
+const DefaultIPFSAPIURL = "http://localhost:5001"
+
+// ErrUploadUnauthorized is returned when the endpoint rejects the upload's
+// credential, typically because a task-scoped token has expired
+var ErrUploadUnauthorized = errors.New("upload credential rejected")
+
+// Uploader stores content and returns its content identifier
+type Uploader interface {
+	Add(ctx context.Context, name string, r io.Reader) (string, error)
+}
+
+// IPFSUploader adds content through the IPFS RPC API and pins it
+type IPFSUploader struct {
+	apiURL string
+	client *http.Client
+}
+
+func NewIPFSUploader(apiURL string) *IPFSUploader {
+	if apiURL == "" {
+		apiURL = DefaultIPFSAPIURL
+	}
+	return &IPFSUploader{
+		apiURL: strings.TrimSuffix(apiURL, "/"),
+		client: &http.Client{},
+	}
+}
+
+type addResponse struct {
+	Name string `json:"Name"`
+	Hash string `json:"Hash"`
+}
+
+// ForTask returns u itself: the local daemon takes no credentials, so every
+// task's artifacts go through the same uploader
+func (u *IPFSUploader) ForTask(taskID string) Uploader {
+	return u
+}
+
+// Add streams r to the daemon as a single file and returns its CIDv1
+func (u *IPFSUploader) Add(ctx context.Context, name string, r io.Reader) (string, error) {
+	return ipfsAdd(ctx, u.client, u.apiURL, "", name, r)
+}
+
+// ipfsAdd posts r to the add endpoint of apiURL, authenticating with token
+// as a bearer credential when it is set
+func ipfsAdd(ctx context.Context, client *http.Client, apiURL, token, name string, r io.Reader) (string, error) {
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+
+	go func() {
+		part, err := form.CreateFormFile("file", name)
+		if err == nil {
+			_, err = io.Copy(part, r)
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/api/v0/add?pin=true&cid-version=1", body)
+	if err != nil {
+		body.Close()
+		return "", fmt.Errorf("failed to create IPFS add request: %w", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to add %s to IPFS: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return "", fmt.Errorf("failed to add %s to IPFS: %w", name, ErrUploadUnauthorized)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("failed to add %s to IPFS: status code %d: %s", name, resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	// The daemon streams one JSON object per added entry; the last names the root
+	var cid string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var entry addResponse
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return "", fmt.Errorf("failed to decode IPFS add response: %w", err)
+		}
+		cid = entry.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read IPFS add response: %w", err)
+	}
+	if cid == "" {
+		return "", fmt.Errorf("IPFS add response for %s contained no CID", name)
+	}
+	return cid, nil
+}
+
+// HaveChunks asks the daemon which chunks it holds
+func (u *IPFSUploader) HaveChunks(ctx context.Context, hashes []string) (map[string]string, error) {
+	return ipfsHave(ctx, u.client, u.apiURL, "", hashes)
+}
+
+// AddChunk adds the chunk hash to the daemon
+func (u *IPFSUploader) AddChunk(ctx context.Context, hash string, r io.Reader) (string, error) {
+	return u.Add(ctx, hash, r)
+}
+
+type haveRequest struct {
+	Hashes []string `json:"hashes"`
+}
+
+type haveResponse struct {
+	Have map[string]string `json:"have"`
+}
+
+// ipfsHave asks the chunks endpoint of apiURL which of the chunks hashes it
+// holds, by their SHA-256. Pinning services that chunk artifacts serve it;
+// a plain daemon answers 404, which is ErrHaveUnsupported.
+func ipfsHave(ctx context.Context, client *http.Client, apiURL, token string, hashes []string) (map[string]string, error) {
+	body, err := json.Marshal(haveRequest{Hashes: hashes})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal chunk request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, apiURL+"/api/v0/chunks/have", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create chunk request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to ask which chunks are held: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return nil, ErrHaveUnsupported
+	case http.StatusUnauthorized:
+		return nil, fmt.Errorf("failed to ask which chunks are held: %w", ErrUploadUnauthorized)
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("chunk request failed: status code %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	var have haveResponse
+	if err := json.NewDecoder(resp.Body).Decode(&have); err != nil {
+		return nil, fmt.Errorf("failed to decode chunk response: %w", err)
+	}
+	// Only the chunks asked about are taken, so a service cannot slip in
+	// references of its own
+	held := make(map[string]string, len(have.Have))
+	for _, hash := range hashes {
+		if ref := have.Have[hash]; ref != "" {
+			held[hash] = ref
+		}
+	}
+	return held, nil
+}
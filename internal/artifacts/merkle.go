@@ -0,0 +1,138 @@
+package artifacts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// ErrChunkCorrupt is returned when a chunk does not match the Merkle root
+// of its artifact
+var ErrChunkCorrupt = errors.New("chunk does not match the artifact's root")
+
+// Domain prefixes keep a leaf from ever hashing like an interior node
+const (
+	leafPrefix = 0x00
+	nodePrefix = 0x01
+)
+
+// ChunkHash returns the hex SHA-256 of chunk, by which chunks are
+// deduplicated and which the Merkle tree's leaves commit to
+func ChunkHash(chunk []byte) string {
+	sum := sha256.Sum256(chunk)
+	return hex.EncodeToString(sum[:])
+}
+
+func leafHash(chunkHash []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{leafPrefix})
+	h.Write(chunkHash)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{nodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// MerkleTree is the tree over the chunk hashes of an artifact. Each level
+// pairs the nodes of the one below in order; an odd node out is carried up
+// unchanged.
+type MerkleTree struct {
+	levels [][][]byte
+}
+
+// NewMerkleTree builds the tree over hashes, the hex SHA-256 of each chunk
+// in order
+func NewMerkleTree(hashes []string) (*MerkleTree, error) {
+	if len(hashes) == 0 {
+		return nil, errors.New("an artifact has at least one chunk")
+	}
+	leaves := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		raw, err := hex.DecodeString(hash)
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("chunk %d has an invalid hash %q", i, hash)
+		}
+		leaves[i] = leafHash(raw)
+	}
+	t := &MerkleTree{levels: [][][]byte{leaves}}
+	for level := leaves; len(level) > 1; {
+		next := make([][]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+			next = append(next, nodeHash(level[i], level[i+1]))
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t, nil
+}
+
+// Root returns the hex root of the tree
+func (t *MerkleTree) Root() string {
+	return hex.EncodeToString(t.levels[len(t.levels)-1][0])
+}
+
+// Proof returns the hex siblings on the path from chunk index to the root,
+// lowest first, which with the chunk prove it belongs to the artifact
+func (t *MerkleTree) Proof(index int) ([]string, error) {
+	if index < 0 || index >= len(t.levels[0]) {
+		return nil, fmt.Errorf("chunk %d out of range", index)
+	}
+	var proof []string
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, hex.EncodeToString(level[sibling]))
+		}
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyChunk checks chunk, chunk index of count, against root with proof,
+// without the rest of the artifact
+func VerifyChunk(root string, index, count int, chunk []byte, proof []string) error {
+	if index < 0 || index >= count {
+		return fmt.Errorf("chunk %d out of range of %d", index, count)
+	}
+	want, err := hex.DecodeString(root)
+	if err != nil {
+		return fmt.Errorf("invalid root %q", root)
+	}
+	sum := sha256.Sum256(chunk)
+	node := leafHash(sum[:])
+	chunkIndex := index
+	for width := count; width > 1; width = (width + 1) / 2 {
+		sibling := index ^ 1
+		if sibling < width {
+			if len(proof) == 0 {
+				return fmt.Errorf("%w: chunk %d: proof too short", ErrChunkCorrupt, chunkIndex)
+			}
+			raw, err := hex.DecodeString(proof[0])
+			if err != nil {
+				return fmt.Errorf("invalid proof of chunk %d", chunkIndex)
+			}
+			proof = proof[1:]
+			if index%2 == 0 {
+				node = nodeHash(node, raw)
+			} else {
+				node = nodeHash(raw, node)
+			}
+		}
+		index /= 2
+	}
+	if len(proof) != 0 || !bytes.Equal(node, want) {
+		return fmt.Errorf("%w: chunk %d", ErrChunkCorrupt, chunkIndex)
+	}
+	return nil
+}
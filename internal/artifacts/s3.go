@@ -0,0 +1,91 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+
+	"github.com/theblitlabs/parity-runner/internal/objectstore"
+)
+
+// S3Uploaders store artifacts in a bucket of an S3-compatible service,
+// each task's under a prefix of its own, and identify them by s3:// URI.
+// The store's credentials come from the runner's configuration and never
+// leave it.
+type S3Uploaders struct {
+	store  *objectstore.Client
+	bucket string
+	prefix string
+}
+
+func NewS3Uploaders(store *objectstore.Client, bucket, prefix string) *S3Uploaders {
+	return &S3Uploaders{store: store, bucket: bucket, prefix: prefix}
+}
+
+// ForTask returns an uploader storing taskID's artifacts under
+// <prefix>/tasks/<taskID>, and the chunks of chunked artifacts under
+// <prefix>/chunks
+func (s *S3Uploaders) ForTask(taskID string) Uploader {
+	return &s3Uploader{
+		store:  s.store,
+		bucket: s.bucket,
+		prefix: path.Join(s.prefix, "tasks", path.Base(path.Clean("/"+taskID))),
+		chunks: path.Join(s.prefix, "chunks"),
+	}
+}
+
+type s3Uploader struct {
+	store  *objectstore.Client
+	bucket string
+	prefix string
+	chunks string
+}
+
+// Add stores r under the task's prefix as name, which cannot climb out of
+// it, and returns the object's URI
+func (u *s3Uploader) Add(ctx context.Context, name string, r io.Reader) (string, error) {
+	key := path.Join(u.prefix, path.Clean("/" + name)[1:])
+	obj, err := u.store.Put(ctx, u.bucket, key, r)
+	if err != nil {
+		return "", err
+	}
+	return obj.URI(), nil
+}
+
+// chunkKey is where the chunk hash is stored. Chunks are shared by every
+// task under the prefix, as their hash is their name.
+func (u *s3Uploader) chunkKey(hash string) string {
+	return path.Join(u.chunks, path.Base(path.Clean("/"+hash)))
+}
+
+// HaveChunks looks up which chunks are stored, taking only those whose
+// stored hash matches their name
+func (u *s3Uploader) HaveChunks(ctx context.Context, hashes []string) (map[string]string, error) {
+	have := make(map[string]string)
+	for _, hash := range hashes {
+		if _, ok := have[hash]; ok {
+			continue
+		}
+		obj, err := u.store.Head(ctx, u.bucket, u.chunkKey(hash))
+		if errors.Is(err, objectstore.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if obj.SHA256 == hash {
+			have[hash] = obj.URI()
+		}
+	}
+	return have, nil
+}
+
+// AddChunk stores the chunk hash among the shared chunks
+func (u *s3Uploader) AddChunk(ctx context.Context, hash string, r io.Reader) (string, error) {
+	obj, err := u.store.Put(ctx, u.bucket, u.chunkKey(hash), r)
+	if err != nil {
+		return "", err
+	}
+	return obj.URI(), nil
+}
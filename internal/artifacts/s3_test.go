@@ -0,0 +1,45 @@
+package artifacts
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/objectstore"
+	"github.com/theblitlabs/parity-runner/internal/objectstore/objectstoretest"
+)
+
+func TestS3UploadersKeepTasksApart(t *testing.T) {
+	creds := objectstore.Credentials{AccessKeyID: "runner", SecretAccessKey: "secret"}
+	server := objectstoretest.NewServer(t, "us-east-1", creds)
+	store, err := objectstore.New(objectstore.Config{
+		Endpoint:    server.URL,
+		Region:      "us-east-1",
+		PathStyle:   true,
+		Credentials: creds,
+		Buckets:     []string{"artifacts"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploaders := NewS3Uploaders(store, "artifacts", "runner-1")
+
+	for _, tt := range []struct {
+		taskID, name, want string
+	}{
+		{"t-1", "epoch-1.pt", "s3://artifacts/runner-1/tasks/t-1/epoch-1.pt"},
+		{"t-2", "../../t-1/epoch-1.pt", "s3://artifacts/runner-1/tasks/t-2/t-1/epoch-1.pt"},
+		{"../t-3", "sha256:abc", "s3://artifacts/runner-1/tasks/t-3/sha256:abc"},
+	} {
+		uri, err := uploaders.ForTask(tt.taskID).Add(context.Background(), tt.name, strings.NewReader("weights"))
+		if err != nil {
+			t.Fatalf("Add() error = %v", err)
+		}
+		if uri != tt.want {
+			t.Errorf("Add(%s, %s) = %s, want %s", tt.taskID, tt.name, uri, tt.want)
+		}
+	}
+	if obj, ok := server.Object("artifacts", "runner-1/tasks/t-1/epoch-1.pt"); !ok || string(obj.Data) != "weights" {
+		t.Fatal("artifact missing from the bucket")
+	}
+}
@@ -0,0 +1,117 @@
+package artifacts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/unixfs"
+)
+
+// IPFSEndpoints adds content through the first of several IPFS RPC
+// endpoints that takes it, accepting a CID only once the content is
+// re-hashed to it
+type IPFSEndpoints struct {
+	uploaders []*IPFSUploader
+}
+
+func NewIPFSEndpoints(apiURLs []string) *IPFSEndpoints {
+	e := &IPFSEndpoints{}
+	for _, apiURL := range apiURLs {
+		if apiURL = strings.TrimSpace(apiURL); apiURL != "" {
+			e.uploaders = append(e.uploaders, NewIPFSUploader(apiURL))
+		}
+	}
+	return e
+}
+
+// Add tries each endpoint in turn with r's content, which it holds in
+// memory to retry, and returns the CID of the first that took it. An
+// endpoint answering with a CID the content does not hash to is passed
+// over like one that failed.
+func (e *IPFSEndpoints) Add(ctx context.Context, name string, r io.Reader) (string, error) {
+	if len(e.uploaders) == 0 {
+		return "", errors.New("no IPFS endpoints configured")
+	}
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	want, err := unixfs.FileCID(bytes.NewReader(content))
+	if err != nil {
+		return "", err
+	}
+
+	log := gologger.WithComponent("artifacts")
+	var errs []error
+	for _, uploader := range e.uploaders {
+		cid, err := uploader.Add(ctx, name, bytes.NewReader(content))
+		if err == nil && cid != want {
+			err = fmt.Errorf("%w: endpoint returned %s for %s", unixfs.ErrMismatch, cid, want)
+		}
+		if err == nil {
+			return cid, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		log.Warn().Err(err).Str("endpoint", uploader.apiURL).Str("name", name).Msg("IPFS endpoint failed to add content - trying the next")
+		errs = append(errs, fmt.Errorf("%s: %w", uploader.apiURL, err))
+	}
+	return "", errors.Join(errs...)
+}
+
+// FetchVerified downloads cid from the first of gateways that serves
+// content hashing to it, reading at most maxBytes
+func FetchVerified(ctx context.Context, client *http.Client, gateways []string, cid string, maxBytes int64) ([]byte, error) {
+	if len(gateways) == 0 {
+		return nil, errors.New("no IPFS gateways configured")
+	}
+	var errs []error
+	for _, gateway := range gateways {
+		content, err := fetchGateway(ctx, client, gateway, cid, maxBytes)
+		if err == nil {
+			var got string
+			if got, err = unixfs.FileCID(bytes.NewReader(content)); err == nil && got != cid {
+				err = fmt.Errorf("%w: gateway served content of %s", unixfs.ErrMismatch, got)
+			}
+		}
+		if err == nil {
+			return content, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", gateway, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+func fetchGateway(ctx context.Context, client *http.Client, gateway, cid string, maxBytes int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(gateway, "/")+"/"+cid, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gateway request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", cid, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch %s: status code %d", cid, resp.StatusCode)
+	}
+	content, err := io.ReadAll(io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", cid, err)
+	}
+	if int64(len(content)) > maxBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", cid, maxBytes)
+	}
+	return content, nil
+}
@@ -0,0 +1,82 @@
+package artifacts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/unixfs"
+)
+
+// addServer answers adds with cid, or the CID of the content when cid is
+// empty
+func addServer(t *testing.T, cid string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		answer := cid
+		if answer == "" {
+			answer, _ = unixfs.FileCID(file)
+		}
+		fmt.Fprintf(w, "{\"Name\":%q,\"Hash\":%q}\n", header.Filename, answer)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestIPFSEndpointsSkipWrongCID(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "repo full", http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	lying := addServer(t, "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku")
+	honest := addServer(t, "")
+
+	endpoints := NewIPFSEndpoints([]string{down.URL, lying.URL, honest.URL})
+	cid, err := endpoints.Add(context.Background(), "output", strings.NewReader("hello world\n"))
+	if err != nil || cid != "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4" {
+		t.Fatalf("Add() = %s, %v, want the CID of the content from the last endpoint", cid, err)
+	}
+
+	_, err = NewIPFSEndpoints([]string{down.URL, lying.URL}).Add(context.Background(), "output", strings.NewReader("hello world\n"))
+	if !errors.Is(err, unixfs.ErrMismatch) || !strings.Contains(err.Error(), "repo full") {
+		t.Fatalf("Add() error = %v, want every endpoint's failure", err)
+	}
+}
+
+func TestFetchVerifiedChecksContent(t *testing.T) {
+	const cid = "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"
+	gateway := func(content string) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/ipfs/"+cid {
+				http.NotFound(w, r)
+				return
+			}
+			io.WriteString(w, content)
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	tampered := gateway("hello world!\n")
+	good := gateway("hello world\n")
+
+	content, err := FetchVerified(context.Background(), http.DefaultClient, []string{tampered.URL + "/ipfs/", good.URL + "/ipfs"}, cid, 64)
+	if err != nil || string(content) != "hello world\n" {
+		t.Fatalf("FetchVerified() = %q, %v, want the content matching the CID", content, err)
+	}
+	if _, err := FetchVerified(context.Background(), http.DefaultClient, []string{tampered.URL + "/ipfs/"}, cid, 64); !errors.Is(err, unixfs.ErrMismatch) {
+		t.Fatalf("FetchVerified() error = %v, want a CID mismatch", err)
+	}
+	if _, err := FetchVerified(context.Background(), http.DefaultClient, []string{good.URL + "/ipfs/"}, cid, 4); err == nil {
+		t.Fatal("FetchVerified() read past its bound")
+	}
+}
@@ -0,0 +1,152 @@
+// Package atrest encrypts runner-local persistence with AES-GCM data keys
+package atrest
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	keyIDSize = 8
+	keySize   = 32
+)
+
+// magic prefixes every encrypted blob; data without it is plaintext
+var magic = []byte("PRE1")
+
+var (
+	// ErrKeyUnavailable means data was sealed with a data key that is not in the key file
+	ErrKeyUnavailable = errors.New("data key unavailable")
+	// ErrTampered means GCM authentication failed, so the data was modified or corrupted
+	ErrTampered = errors.New("encrypted data failed authentication")
+	// ErrWrongSecret means the key file could not be unlocked with the provided secret
+	ErrWrongSecret = errors.New("cannot unlock data keys: passphrase or keychain secret is incorrect")
+	// ErrPlaintext means unencrypted data was found where only sealed data
+	// is accepted. It counts as tampering: nothing authenticates it.
+	ErrPlaintext = fmt.Errorf("%w: data is not encrypted", ErrTampered)
+)
+
+// Codec seals and opens store contents. Stores treat a nil Codec as plaintext.
+type Codec interface {
+	Seal(plaintext []byte) ([]byte, error)
+	// Open decrypts data, rejecting plaintext with ErrPlaintext
+	Open(data []byte) ([]byte, error)
+	// Migrate opens data for resealing. Plaintext written before encryption
+	// was turned on is returned unchanged until the stores have been
+	// migrated once, and rejected like Open does afterwards.
+	Migrate(data []byte) ([]byte, error)
+	// NeedsReseal reports whether data is plaintext or sealed with a key other than the active one
+	NeedsReseal(data []byte) bool
+}
+
+type dataKey struct {
+	id   [keyIDSize]byte
+	aead cipher.AEAD
+}
+
+func newDataKey(id [keyIDSize]byte, key []byte) (*dataKey, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+	return &dataKey{id: id, aead: aead}, nil
+}
+
+// Keyring holds the unlocked data keys. New data is sealed with the active key;
+// older keys remain available to open data written before a rotation.
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[[keyIDSize]byte]*dataKey
+	active [keyIDSize]byte
+	file   *keyFile
+}
+
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
+
+func (k *Keyring) ActiveKeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return hex.EncodeToString(k.active[:])
+}
+
+func (k *Keyring) Seal(plaintext []byte) ([]byte, error) {
+	k.mu.RLock()
+	key := k.keys[k.active]
+	k.mu.RUnlock()
+
+	header := make([]byte, 0, len(magic)+keyIDSize)
+	header = append(header, magic...)
+	header = append(header, key.id[:]...)
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	out := make([]byte, 0, len(header)+len(nonce)+len(plaintext)+key.aead.Overhead())
+	out = append(out, header...)
+	out = append(out, nonce...)
+	return key.aead.Seal(out, nonce, plaintext, header), nil
+}
+
+func (k *Keyring) Open(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, ErrPlaintext
+	}
+
+	headerLen := len(magic) + keyIDSize
+	if len(data) < headerLen {
+		return nil, fmt.Errorf("%w: truncated header", ErrTampered)
+	}
+
+	var id [keyIDSize]byte
+	copy(id[:], data[len(magic):headerLen])
+
+	k.mu.RLock()
+	key, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: data was sealed with key %s, which is not in the key file - it may have been lost or replaced",
+			ErrKeyUnavailable, hex.EncodeToString(id[:]))
+	}
+
+	nonceSize := key.aead.NonceSize()
+	if len(data) < headerLen+nonceSize {
+		return nil, fmt.Errorf("%w: truncated nonce", ErrTampered)
+	}
+	nonce := data[headerLen : headerLen+nonceSize]
+
+	plaintext, err := key.aead.Open(nil, nonce, data[headerLen+nonceSize:], data[:headerLen])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrTampered, err)
+	}
+	return plaintext, nil
+}
+
+func (k *Keyring) Migrate(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) && !k.Migrated() {
+		return data, nil
+	}
+	return k.Open(data)
+}
+
+func (k *Keyring) NeedsReseal(data []byte) bool {
+	if !IsEncrypted(data) || len(data) < len(magic)+keyIDSize {
+		return true
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return !bytes.Equal(data[len(magic):len(magic)+keyIDSize], k.active[:])
+}
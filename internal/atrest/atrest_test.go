@@ -0,0 +1,171 @@
+package atrest
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestKeyring(t *testing.T) (*Keyring, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "datakeys.json")
+	keyring, err := OpenKeyring(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("OpenKeyring() error = %v", err)
+	}
+	return keyring, path
+}
+
+func TestRotationKeepsOldDataReadableUntilPruned(t *testing.T) {
+	keyring, path := newTestKeyring(t)
+	file := filepath.Join(filepath.Dir(path), "lease.json")
+
+	if err := WriteFile(keyring, file, []byte("before rotation"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	oldKey := keyring.ActiveKeyID()
+
+	newKey, err := keyring.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if newKey == oldKey || keyring.ActiveKeyID() != newKey {
+		t.Fatalf("expected new active key, got %s (old %s)", keyring.ActiveKeyID(), oldKey)
+	}
+
+	// A runner restarted after rotation must still read data under the old key
+	reopened, err := OpenKeyring(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadFile(reopened, file); err != nil || string(got) != "before rotation" {
+		t.Fatalf("ReadFile() = %q, %v", got, err)
+	}
+
+	rewritten, err := ResealFile(reopened, file)
+	if err != nil || !rewritten {
+		t.Fatalf("ResealFile() = %v, %v, want rewrite", rewritten, err)
+	}
+	if err := reopened.PruneInactive(); err != nil {
+		t.Fatal(err)
+	}
+
+	pruned, err := OpenKeyring(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pruned.keys) != 1 {
+		t.Fatalf("expected only the active key after pruning, got %d", len(pruned.keys))
+	}
+	if got, err := ReadFile(pruned, file); err != nil || string(got) != "before rotation" {
+		t.Fatalf("ReadFile() after prune = %q, %v", got, err)
+	}
+}
+
+func TestResealFileEncryptsPlaintext(t *testing.T) {
+	keyring, path := newTestKeyring(t)
+	file := filepath.Join(filepath.Dir(path), "history.jsonl")
+	plaintext := []byte(`{"task_id":"abc"}`)
+
+	if err := os.WriteFile(file, plaintext, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFile(keyring, file); !errors.Is(err, ErrPlaintext) {
+		t.Fatalf("ReadFile() of plaintext = %v, want ErrPlaintext", err)
+	}
+
+	rewritten, err := ResealFile(keyring, file)
+	if err != nil || !rewritten {
+		t.Fatalf("ResealFile() = %v, %v, want rewrite", rewritten, err)
+	}
+
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(raw) || bytes.Contains(raw, []byte("abc")) {
+		t.Fatal("expected file to be encrypted after migration")
+	}
+	if got, err := ReadFile(keyring, file); err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("ReadFile() after migration = %q, %v", got, err)
+	}
+
+	if rewritten, err := ResealFile(keyring, file); err != nil || rewritten {
+		t.Fatalf("second ResealFile() = %v, %v, want no-op", rewritten, err)
+	}
+}
+
+func TestPlaintextIsRejectedAfterMigration(t *testing.T) {
+	keyring, path := newTestKeyring(t)
+	file := filepath.Join(filepath.Dir(path), "lease.json")
+
+	if err := keyring.SetMigrated(true); err != nil {
+		t.Fatal(err)
+	}
+	// The marker must survive a restart
+	reopened, err := OpenKeyring(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reopened.Migrated() {
+		t.Fatal("expected the key file to record the migration")
+	}
+
+	if err := os.WriteFile(file, []byte(`{"task_id":"planted"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ResealFile(reopened, file); !errors.Is(err, ErrPlaintext) {
+		t.Fatalf("ResealFile() of plaintext after migration = %v, want ErrPlaintext", err)
+	}
+	if _, err := reopened.Migrate([]byte("plain")); !errors.Is(err, ErrTampered) {
+		t.Fatalf("Migrate() of plaintext after migration = %v, want tampering", err)
+	}
+}
+
+func TestOpenDetectsTampering(t *testing.T) {
+	keyring, _ := newTestKeyring(t)
+
+	sealed, err := keyring.Seal([]byte("lease record"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, offset := range []int{len(sealed) - 1, len(magic) + keyIDSize + 2, len(magic)} {
+		tampered := append([]byte(nil), sealed...)
+		tampered[offset] ^= 0x01
+		_, err := keyring.Open(tampered)
+		if !errors.Is(err, ErrTampered) && !errors.Is(err, ErrKeyUnavailable) {
+			t.Fatalf("flipping byte %d: expected authentication failure, got %v", offset, err)
+		}
+	}
+
+	if _, err := keyring.Open(sealed[:len(sealed)-4]); !errors.Is(err, ErrTampered) {
+		t.Fatalf("expected truncated data to fail authentication, got %v", err)
+	}
+}
+
+func TestLostKeyIsReportedClearly(t *testing.T) {
+	keyring, path := newTestKeyring(t)
+	sealed, err := keyring.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := OpenKeyring(path, []byte("wrong passphrase")); !errors.Is(err, ErrWrongSecret) {
+		t.Fatalf("expected ErrWrongSecret, got %v", err)
+	}
+
+	// Losing the key file and starting over must not silently return garbage
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	replacement, err := OpenKeyring(path, []byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := replacement.Open(sealed); !errors.Is(err, ErrKeyUnavailable) {
+		t.Fatalf("expected ErrKeyUnavailable, got %v", err)
+	}
+}
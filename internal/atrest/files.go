@@ -0,0 +1,104 @@
+package atrest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes data to a temporary file and renames it over path.
+// The file is synced before the rename and the directory after, so that a
+// crash leaves either the old content or the new, never a torn file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := writeSynced(tmp, data, perm); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+func writeSynced(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir makes a rename in dir durable. Platforms that cannot sync a
+// directory, such as Windows, are left to their own guarantees.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	d.Close()
+}
+
+// ReadFile reads and, when codec is set, decrypts path
+func ReadFile(codec Codec, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if codec == nil {
+		return data, nil
+	}
+	plaintext, err := codec.Open(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+	return plaintext, nil
+}
+
+// WriteFile encrypts data when codec is set and writes it atomically to path
+func WriteFile(codec Codec, path string, data []byte, perm os.FileMode) error {
+	if codec != nil {
+		sealed, err := codec.Seal(data)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %w", path, err)
+		}
+		data = sealed
+	}
+	return WriteFileAtomic(path, data, perm)
+}
+
+// ResealFile rewrites path under the active key if it is plaintext or sealed
+// with an older key. Plaintext is only accepted until the stores have been
+// migrated. It reports whether the file was rewritten.
+func ResealFile(codec Codec, path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	if !codec.NeedsReseal(data) {
+		return false, nil
+	}
+	plaintext, err := codec.Migrate(data)
+	if err != nil {
+		return false, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if err := WriteFile(codec, path, plaintext, info.Mode().Perm()); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,275 @@
+package atrest
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	keyFileVersion = 1
+	saltSize       = 16
+	scryptN        = 1 << 15
+	scryptR        = 8
+	scryptP        = 1
+)
+
+type keyFile struct {
+	Version int          `json:"version"`
+	KDF     string       `json:"kdf"`
+	Salt    string       `json:"salt"`
+	Active  string       `json:"active"`
+	Keys    []wrappedKey `json:"keys"`
+	// Migrated is set once every store holds only sealed data
+	Migrated bool `json:"migrated,omitempty"`
+
+	path string
+	kek  cipher.AEAD
+}
+
+type wrappedKey struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Wrapped   string    `json:"wrapped"`
+}
+
+// OpenKeyring unlocks the data keys stored at path with secret, creating a
+// new key file with a fresh data key when none exists
+func OpenKeyring(path string, secret []byte) (*Keyring, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("data key secret is empty")
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createKeyring(path, secret)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("key file %s is corrupted: %w", path, err)
+	}
+	if file.Version != keyFileVersion || file.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported key file version %d (%s)", file.Version, file.KDF)
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(file.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s has an invalid salt: %w", path, err)
+	}
+	file.path = path
+	if file.kek, err = deriveKEK(secret, salt); err != nil {
+		return nil, err
+	}
+
+	keyring := &Keyring{keys: make(map[[keyIDSize]byte]*dataKey), file: &file}
+	for _, wrapped := range file.Keys {
+		key, err := file.unwrap(wrapped)
+		if err != nil {
+			return nil, err
+		}
+		keyring.keys[key.id] = key
+	}
+
+	active, err := parseKeyID(file.Active)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := keyring.keys[active]; !ok {
+		return nil, fmt.Errorf("%w: active key %s is missing from the key file", ErrKeyUnavailable, file.Active)
+	}
+	keyring.active = active
+	return keyring, nil
+}
+
+func createKeyring(path string, secret []byte) (*Keyring, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	kek, err := deriveKEK(secret, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &keyFile{
+		Version: keyFileVersion,
+		KDF:     "scrypt",
+		Salt:    base64.StdEncoding.EncodeToString(salt),
+		path:    path,
+		kek:     kek,
+	}
+	keyring := &Keyring{keys: make(map[[keyIDSize]byte]*dataKey), file: file}
+	if _, err := keyring.addKey(); err != nil {
+		return nil, err
+	}
+	if err := file.save(); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// Rotate generates a new active data key and persists it. Previous keys stay
+// in the key file so existing data remains readable until it is resealed.
+func (k *Keyring) Rotate() (string, error) {
+	id, err := k.addKey()
+	if err != nil {
+		return "", err
+	}
+	if err := k.file.save(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Migrated reports whether the stores have been migrated, after which
+// plaintext is no longer accepted anywhere
+func (k *Keyring) Migrated() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.file.Migrated
+}
+
+// SetMigrated records whether every store holds only sealed data. It is set
+// once the stores are resealed and cleared only where plaintext is written
+// deliberately, such as a relocation import.
+func (k *Keyring) SetMigrated(migrated bool) error {
+	k.mu.Lock()
+	if k.file.Migrated == migrated {
+		k.mu.Unlock()
+		return nil
+	}
+	k.file.Migrated = migrated
+	k.mu.Unlock()
+
+	return k.file.save()
+}
+
+// PruneInactive removes every key except the active one. Call it only after
+// all stores have been resealed with the active key.
+func (k *Keyring) PruneInactive() error {
+	k.mu.Lock()
+	active := hex.EncodeToString(k.active[:])
+	var kept []wrappedKey
+	for _, wrapped := range k.file.Keys {
+		if wrapped.ID == active {
+			kept = append(kept, wrapped)
+		}
+	}
+	for id := range k.keys {
+		if id != k.active {
+			delete(k.keys, id)
+		}
+	}
+	k.file.Keys = kept
+	k.mu.Unlock()
+
+	return k.file.save()
+}
+
+func (k *Keyring) addKey() (string, error) {
+	var id [keyIDSize]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("failed to generate key ID: %w", err)
+	}
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	key, err := newDataKey(id, raw)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := k.file.wrap(id, raw)
+	if err != nil {
+		return "", err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys[id] = key
+	k.active = id
+	k.file.Keys = append(k.file.Keys, wrapped)
+	k.file.Active = wrapped.ID
+	return wrapped.ID, nil
+}
+
+func deriveKEK(secret, salt []byte) (cipher.AEAD, error) {
+	kek, err := scrypt.Key(secret, salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (f *keyFile) wrap(id [keyIDSize]byte, raw []byte) (wrappedKey, error) {
+	nonce := make([]byte, f.kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return wrappedKey{}, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := f.kek.Seal(nonce, nonce, raw, id[:])
+	return wrappedKey{
+		ID:        hex.EncodeToString(id[:]),
+		CreatedAt: time.Now().UTC(),
+		Wrapped:   base64.StdEncoding.EncodeToString(sealed),
+	}, nil
+}
+
+func (f *keyFile) unwrap(wrapped wrappedKey) (*dataKey, error) {
+	id, err := parseKeyID(wrapped.ID)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(wrapped.Wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("key %s is corrupted: %w", wrapped.ID, err)
+	}
+	nonceSize := f.kek.NonceSize()
+	if len(sealed) < nonceSize {
+		return nil, fmt.Errorf("key %s is corrupted: truncated", wrapped.ID)
+	}
+	raw, err := f.kek.Open(nil, sealed[:nonceSize], sealed[nonceSize:], id[:])
+	if err != nil {
+		return nil, ErrWrongSecret
+	}
+	return newDataKey(id, raw)
+}
+
+func (f *keyFile) save() error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal key file: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create key directory: %w", err)
+	}
+	return WriteFileAtomic(f.path, data, 0o600)
+}
+
+func parseKeyID(s string) ([keyIDSize]byte, error) {
+	var id [keyIDSize]byte
+	raw, err := hex.DecodeString(s)
+	if err != nil || len(raw) != keyIDSize {
+		return id, fmt.Errorf("invalid data key ID %q", s)
+	}
+	copy(id[:], raw)
+	return id, nil
+}
@@ -0,0 +1,104 @@
+package atrest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// PassphraseEnv supplies the data key secret directly, e.g. for headless servers
+	PassphraseEnv = "PARITY_DATA_PASSPHRASE"
+
+	keychainService = "parity-runner"
+	keychainAccount = "data-key"
+	keychainTimeout = 10 * time.Second
+)
+
+// ErrNoSecretSource means neither a passphrase nor an OS keychain is available
+var ErrNoSecretSource = errors.New("no data key secret available: set " + PassphraseEnv + " or install an OS keychain")
+
+// ResolveSecret returns the secret protecting the data keys. A passphrase from
+// the environment takes precedence; otherwise a random secret is kept in the
+// OS keychain (macOS Keychain or the freedesktop Secret Service on Linux).
+// A new keychain secret is only created when create is set, so a failed lookup
+// never replaces the secret protecting an existing key file.
+func ResolveSecret(create bool) ([]byte, string, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return []byte(passphrase), "passphrase", nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), keychainTimeout)
+	defer cancel()
+
+	secret, err := keychainLookup(ctx)
+	if err == nil && len(secret) > 0 {
+		return secret, "keychain", nil
+	}
+	if err == nil {
+		err = errors.New("keychain secret is empty")
+	}
+	if errors.Is(err, ErrNoSecretSource) {
+		return nil, "", err
+	}
+	if !create {
+		return nil, "", fmt.Errorf("data keys exist but their keychain secret could not be read: %w", err)
+	}
+
+	raw := make([]byte, keySize)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("failed to generate keychain secret: %w", err)
+	}
+	secret = []byte(base64.StdEncoding.EncodeToString(raw))
+	if err := keychainStore(ctx, secret); err != nil {
+		return nil, "", err
+	}
+	return secret, "keychain", nil
+}
+
+func keychainLookup(ctx context.Context) ([]byte, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.CommandContext(ctx, "security", "find-generic-password", "-s", keychainService, "-a", keychainAccount, "-w")
+	case "linux":
+		if _, err := exec.LookPath("secret-tool"); err != nil {
+			return nil, ErrNoSecretSource
+		}
+		cmd = exec.CommandContext(ctx, "secret-tool", "lookup", "service", keychainService, "account", keychainAccount)
+	default:
+		return nil, ErrNoSecretSource
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("keychain lookup failed: %w", err)
+	}
+	return bytes.TrimSpace(out), nil
+}
+
+func keychainStore(ctx context.Context, secret []byte) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.CommandContext(ctx, "security", "add-generic-password", "-s", keychainService, "-a", keychainAccount, "-w", string(secret))
+	case "linux":
+		cmd = exec.CommandContext(ctx, "secret-tool", "store", "--label=Parity runner data key", "service", keychainService, "account", keychainAccount)
+		cmd.Stdin = bytes.NewReader(secret)
+	default:
+		return ErrNoSecretSource
+	}
+
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to store secret in keychain: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+// Package attestation collects and verifies hardware evidence that a task
+// ran on an unmodified runner. Evidence binds the runner binary hash, its
+// configuration hash and a server-issued nonce, and optionally a task and its
+// result, into the report data of a TPM quote or SEV-SNP attestation report.
+package attestation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var (
+	// ErrUnsupported is returned when the host offers no attestation mechanism
+	ErrUnsupported = errors.New("attestation is not supported on this host")
+	// ErrMismatch is returned when evidence does not bind the expected values
+	ErrMismatch = errors.New("attestation evidence does not match")
+)
+
+// reportDataDomain separates parity evidence from other uses of the same
+// attestation key
+const reportDataDomain = "parity-runner-attestation-v1"
+
+// Quote is the raw evidence a provider produced over some report data
+type Quote struct {
+	Report    []byte
+	Signature []byte
+	PublicKey []byte
+}
+
+// Provider produces hardware evidence carrying caller-chosen report data
+type Provider interface {
+	Type() models.AttestationType
+	Quote(ctx context.Context, reportData []byte) (*Quote, error)
+}
+
+// Binding is the set of values evidence commits to
+type Binding struct {
+	Nonce      string
+	BinaryHash string
+	ConfigHash string
+	TaskID     string
+	ResultHash string
+}
+
+// ReportData is the 32-byte digest of b placed in the hardware report.
+// Fields are length-prefixed so distinct bindings never share a digest.
+func (b Binding) ReportData() []byte {
+	h := sha256.New()
+	h.Write([]byte(reportDataDomain))
+	for _, field := range []string{b.Nonce, b.BinaryHash, b.ConfigHash, b.TaskID, b.ResultHash} {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(field)))
+		h.Write(size[:])
+		h.Write([]byte(field))
+	}
+	return h.Sum(nil)
+}
+
+func bindingOf(ev *models.AttestationEvidence) Binding {
+	return Binding{
+		Nonce:      ev.Nonce,
+		BinaryHash: ev.BinaryHash,
+		ConfigHash: ev.ConfigHash,
+		TaskID:     ev.TaskID,
+		ResultHash: ev.ResultHash,
+	}
+}
+
+// Attester produces evidence for this runner's binary and configuration
+type Attester struct {
+	provider   Provider
+	binaryHash string
+	configHash string
+	clock      clock.Clock
+}
+
+func NewAttester(provider Provider, binaryHash, configHash string) *Attester {
+	return &Attester{
+		provider:   provider,
+		binaryHash: binaryHash,
+		configHash: configHash,
+		clock:      clock.Real(),
+	}
+}
+
+// SetClock replaces the clock used to timestamp evidence
+func (a *Attester) SetClock(c clock.Clock) {
+	a.clock = c
+}
+
+// Supported reports whether a can produce evidence; a nil Attester cannot
+func (a *Attester) Supported() bool {
+	return a != nil && a.provider != nil
+}
+
+// Type returns the kind of evidence a produces
+func (a *Attester) Type() models.AttestationType {
+	if !a.Supported() {
+		return ""
+	}
+	return a.provider.Type()
+}
+
+// Attest produces evidence of the runner environment bound to nonce
+func (a *Attester) Attest(ctx context.Context, nonce string) (*models.AttestationEvidence, error) {
+	return a.attest(ctx, Binding{Nonce: nonce})
+}
+
+// AttestResult produces evidence that the result hashing to resultHash was
+// computed for taskID in this runner environment
+func (a *Attester) AttestResult(ctx context.Context, nonce, taskID, resultHash string) (*models.AttestationEvidence, error) {
+	return a.attest(ctx, Binding{Nonce: nonce, TaskID: taskID, ResultHash: resultHash})
+}
+
+func (a *Attester) attest(ctx context.Context, b Binding) (*models.AttestationEvidence, error) {
+	if !a.Supported() {
+		return nil, ErrUnsupported
+	}
+	if b.Nonce == "" {
+		return nil, errors.New("attestation nonce is required")
+	}
+	b.BinaryHash = a.binaryHash
+	b.ConfigHash = a.configHash
+
+	reportData := b.ReportData()
+	quote, err := a.provider.Quote(ctx, reportData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect %s evidence: %w", a.provider.Type(), err)
+	}
+
+	return &models.AttestationEvidence{
+		Type:        a.provider.Type(),
+		Nonce:       b.Nonce,
+		BinaryHash:  b.BinaryHash,
+		ConfigHash:  b.ConfigHash,
+		TaskID:      b.TaskID,
+		ResultHash:  b.ResultHash,
+		ReportData:  hex.EncodeToString(reportData),
+		Report:      quote.Report,
+		Signature:   quote.Signature,
+		PublicKey:   quote.PublicKey,
+		CollectedAt: a.clock.Now().UTC(),
+	}, nil
+}
+
+// BinaryHash returns the hex SHA-256 of the running executable
+func BinaryHash() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate runner binary: %w", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open runner binary: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash runner binary: %w", err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ConfigHash returns the hex SHA-256 of cfg's JSON encoding
+func ConfigHash(cfg interface{}) (string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// ResultHash digests the parts of result a creator relies on: its task,
+// exit code, output and artifacts, the fingerprint of the runner build
+// that produced it and the hermetic attestation when set
+func ResultHash(result *models.TaskResult) string {
+	h := sha256.New()
+	h.Write([]byte(result.TaskID.String()))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.Itoa(result.ExitCode)))
+	h.Write([]byte{0})
+	h.Write([]byte(result.Output))
+	for _, cid := range result.ArtifactCIDs {
+		h.Write([]byte{0})
+		h.Write([]byte(cid))
+	}
+	if result.BuildFingerprint != "" {
+		// Set apart from the artifacts, which have no byte of 1
+		h.Write([]byte{1})
+		h.Write([]byte(result.BuildFingerprint))
+	}
+	if result.Hermetic != nil {
+		hermetic, _ := json.Marshal(result.Hermetic)
+		h.Write([]byte{2})
+		h.Write(hermetic)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
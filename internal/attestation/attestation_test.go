@@ -0,0 +1,243 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func newSimulatedAttester(t *testing.T) *Attester {
+	t.Helper()
+	provider, err := NewSimulatedProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewAttester(provider, "binhash", "cfghash")
+}
+
+func TestSimulatedAttestationVerifies(t *testing.T) {
+	a := newSimulatedAttester(t)
+	ev, err := a.AttestResult(context.Background(), "nonce-1", "task-1", "result-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := Expectation{
+		Nonce:          "nonce-1",
+		BinaryHashes:   []string{"other", "binhash"},
+		ConfigHash:     "cfghash",
+		TaskID:         "task-1",
+		ResultHash:     "result-1",
+		AllowSimulated: true,
+	}
+	if err := Verify(ev, exp); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	exp.AllowSimulated = false
+	if err := Verify(ev, exp); err == nil {
+		t.Fatal("simulated evidence accepted without AllowSimulated")
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	a := newSimulatedAttester(t)
+	exp := Expectation{Nonce: "nonce-1", AllowSimulated: true}
+
+	tests := []struct {
+		name   string
+		tamper func(ev *models.AttestationEvidence)
+		exp    func(e *Expectation)
+	}{
+		{"wrong nonce", nil, func(e *Expectation) { e.Nonce = "nonce-2" }},
+		{"unaccepted binary", nil, func(e *Expectation) { e.BinaryHashes = []string{"other"} }},
+		{"wrong result", nil, func(e *Expectation) { e.ResultHash = "result-2" }},
+		{"rewritten binary hash", func(ev *models.AttestationEvidence) { ev.BinaryHash = "forged" }, nil},
+		{"rewritten result hash", func(ev *models.AttestationEvidence) { ev.ResultHash = "forged" }, nil},
+		{"report data swapped", func(ev *models.AttestationEvidence) {
+			ev.ResultHash = "forged"
+			ev.ReportData = "00"
+		}, nil},
+		{"report modified", func(ev *models.AttestationEvidence) { ev.Report[len(ev.Report)-1] ^= 1 }, nil},
+		{"signature from another key", func(ev *models.AttestationEvidence) {
+			other := newSimulatedAttester(t)
+			forged, _ := other.AttestResult(context.Background(), ev.Nonce, ev.TaskID, ev.ResultHash)
+			ev.Signature = forged.Signature
+		}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := a.AttestResult(context.Background(), "nonce-1", "task-1", "result-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := exp
+			if tt.tamper != nil {
+				tt.tamper(ev)
+			}
+			if tt.exp != nil {
+				tt.exp(&e)
+			}
+			if err := Verify(ev, e); err == nil {
+				t.Fatal("Verify() accepted tampered evidence")
+			}
+		})
+	}
+}
+
+func TestAttestRequiresSupportAndNonce(t *testing.T) {
+	var none *Attester
+	if none.Supported() {
+		t.Fatal("nil attester reports support")
+	}
+	if _, err := none.Attest(context.Background(), "n"); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("Attest() on nil = %v, want ErrUnsupported", err)
+	}
+	if _, err := newSimulatedAttester(t).Attest(context.Background(), ""); err == nil {
+		t.Fatal("Attest() accepted an empty nonce")
+	}
+	if _, err := NewProvider(Options{Mode: ModeOff}); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("NewProvider(off) = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestReportDataSeparatesFields(t *testing.T) {
+	a := Binding{Nonce: "ab", BinaryHash: "c"}.ReportData()
+	b := Binding{Nonce: "a", BinaryHash: "bc"}.ReportData()
+	if bytes.Equal(a, b) {
+		t.Fatal("bindings with shifted field boundaries share report data")
+	}
+}
+
+func TestVerifySEVReport(t *testing.T) {
+	vcek, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := Binding{Nonce: "nonce", BinaryHash: "bin", ConfigHash: "cfg"}
+	reportData := b.ReportData()
+
+	report := make([]byte, snpReportSize)
+	copy(report[snpReportDataOffset:], reportData)
+	digest := sha512.Sum384(report[:snpSignatureOffset])
+	r, s, err := ecdsa.Sign(rand.Reader, vcek, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(report[snpSignatureOffset:], reverse(r.FillBytes(make([]byte, snpSignatureSize))))
+	copy(report[snpSignatureOffset+snpSignatureSize:], reverse(s.FillBytes(make([]byte, snpSignatureSize))))
+
+	ev := &models.AttestationEvidence{
+		Type:       models.AttestationSEVSNP,
+		Nonce:      b.Nonce,
+		BinaryHash: b.BinaryHash,
+		ConfigHash: b.ConfigHash,
+		ReportData: hex.EncodeToString(reportData),
+		Report:     report,
+	}
+	if err := Verify(ev, Expectation{Nonce: "nonce", VCEK: &vcek.PublicKey}); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	report[0] ^= 1
+	if err := Verify(ev, Expectation{Nonce: "nonce", VCEK: &vcek.PublicKey}); err == nil {
+		t.Fatal("Verify() accepted a modified report")
+	}
+}
+
+func TestVerifyTPMQuote(t *testing.T) {
+	ak, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	akPub, _ := x509.MarshalPKIXPublicKey(&ak.PublicKey)
+	b := Binding{Nonce: "nonce", BinaryHash: "bin", ConfigHash: "cfg"}
+	reportData := b.ReportData()
+
+	var quote bytes.Buffer
+	binary.Write(&quote, binary.BigEndian, uint32(tpmGeneratedValue))
+	binary.Write(&quote, binary.BigEndian, uint16(tpmSTAttestQuote))
+	binary.Write(&quote, binary.BigEndian, uint16(2))
+	quote.Write([]byte{0x00, 0x0b})
+	binary.Write(&quote, binary.BigEndian, uint16(len(reportData)))
+	quote.Write(reportData)
+	quote.Write(make([]byte, 32)) // clock info and PCR selection, unread
+
+	digest := sha256.Sum256(quote.Bytes())
+	sig, err := ecdsa.SignASN1(rand.Reader, ak, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ev := &models.AttestationEvidence{
+		Type:       models.AttestationTPM,
+		Nonce:      b.Nonce,
+		BinaryHash: b.BinaryHash,
+		ConfigHash: b.ConfigHash,
+		ReportData: hex.EncodeToString(reportData),
+		Report:     quote.Bytes(),
+		Signature:  sig,
+		PublicKey:  akPub,
+	}
+	if err := Verify(ev, Expectation{Nonce: "nonce", TrustedKeys: [][]byte{akPub}}); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+
+	other, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherPub, _ := x509.MarshalPKIXPublicKey(&other.PublicKey)
+	if err := Verify(ev, Expectation{Nonce: "nonce", TrustedKeys: [][]byte{otherPub}}); err == nil {
+		t.Fatal("Verify() accepted a quote from an untrusted key")
+	}
+}
+
+func TestResultHashCoversOutput(t *testing.T) {
+	result := &models.TaskResult{TaskID: uuid.New(), Output: "42", ArtifactCIDs: []string{"cid"}}
+	before := ResultHash(result)
+	result.Output = "43"
+	if ResultHash(result) == before {
+		t.Fatal("result hash ignores output")
+	}
+}
+
+func TestResultHashCoversBuildFingerprint(t *testing.T) {
+	result := &models.TaskResult{TaskID: uuid.New(), Output: "42", ArtifactCIDs: []string{"cid"}}
+	unfingerprinted := ResultHash(result)
+	result.BuildFingerprint = "official"
+	official := ResultHash(result)
+	result.BuildFingerprint = "modified"
+	if official == unfingerprinted || ResultHash(result) == official {
+		t.Fatal("result hash ignores the build fingerprint")
+	}
+
+	// A fingerprint cannot pass for another artifact
+	result.BuildFingerprint = ""
+	result.ArtifactCIDs = append(result.ArtifactCIDs, "official")
+	if ResultHash(result) == official {
+		t.Fatal("build fingerprint digested like an artifact")
+	}
+}
+
+func TestResultHashCoversHermeticAttestation(t *testing.T) {
+	result := &models.TaskResult{TaskID: uuid.New(), Output: "42"}
+	plain := ResultHash(result)
+	closure := models.HermeticClosure{ImageDigest: "sha256:abc", Image: "python@sha256:abc", Env: []string{"TASK_NONCE=1"}}
+	result.Hermetic = &models.HermeticAttestation{Closure: closure, Digest: closure.Digest()}
+	hermetic := ResultHash(result)
+	result.Hermetic.Violations = []models.HermeticViolation{{Kind: models.HermeticViolationEgress, Detail: "1 packets blocked"}}
+	if hermetic == plain || ResultHash(result) == hermetic {
+		t.Fatal("result hash ignores the hermetic attestation")
+	}
+}
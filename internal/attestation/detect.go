@@ -0,0 +1,48 @@
+package attestation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Attestation modes
+const (
+	ModeOff       = "off"
+	ModeAuto      = "auto"
+	ModeTPM       = "tpm"
+	ModeSEVSNP    = "sev-snp"
+	ModeSimulated = "simulated"
+)
+
+// Options selects and configures the attestation provider
+type Options struct {
+	Mode           string
+	TPMAKContext   string
+	TPMAKPublicKey string
+}
+
+// NewProvider returns the provider opts select. Auto prefers SEV-SNP, then a
+// TPM, and returns ErrUnsupported when neither is available; off always
+// returns ErrUnsupported.
+func NewProvider(opts Options) (Provider, error) {
+	switch strings.ToLower(opts.Mode) {
+	case "", ModeOff:
+		return nil, fmt.Errorf("%w: attestation is disabled", ErrUnsupported)
+	case ModeSEVSNP:
+		return NewSEVProvider()
+	case ModeTPM:
+		return NewTPMProvider(opts.TPMAKContext, opts.TPMAKPublicKey)
+	case ModeSimulated:
+		return NewSimulatedProvider()
+	case ModeAuto:
+		if p, err := NewSEVProvider(); err == nil {
+			return p, nil
+		}
+		if p, err := NewTPMProvider(opts.TPMAKContext, opts.TPMAKPublicKey); err == nil {
+			return p, nil
+		}
+		return nil, fmt.Errorf("%w: neither SEV-SNP nor a configured TPM found", ErrUnsupported)
+	default:
+		return nil, fmt.Errorf("unknown attestation mode %q", opts.Mode)
+	}
+}
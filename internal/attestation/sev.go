@@ -0,0 +1,130 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// tsmReportDir is the configfs-tsm interface Linux exposes for guest
+// attestation reports
+const tsmReportDir = "/sys/kernel/config/tsm/report"
+
+// SEV-SNP attestation report layout (AMD SEV-SNP ABI, table 22)
+const (
+	snpReportDataOffset = 0x50
+	snpReportDataSize   = 64
+	snpSignatureOffset  = 0x2A0
+	snpSignatureSize    = 72
+	snpReportSize       = 0x4A0
+)
+
+// SEVProvider collects SEV-SNP attestation reports through configfs-tsm
+type SEVProvider struct {
+	dir string
+}
+
+// NewSEVProvider returns a provider if this guest can request SEV-SNP reports
+func NewSEVProvider() (*SEVProvider, error) {
+	if _, err := os.Stat(tsmReportDir); err != nil {
+		return nil, fmt.Errorf("%w: %s unavailable", ErrUnsupported, tsmReportDir)
+	}
+	return &SEVProvider{dir: tsmReportDir}, nil
+}
+
+func (p *SEVProvider) Type() models.AttestationType {
+	return models.AttestationSEVSNP
+}
+
+// Quote requests a report whose REPORT_DATA is reportData zero-padded to 64
+// bytes. The certificate table the host supplies, if any, is returned as the
+// public key so verifiers can find the VCEK.
+func (p *SEVProvider) Quote(ctx context.Context, reportData []byte) (*Quote, error) {
+	if len(reportData) > snpReportDataSize {
+		return nil, fmt.Errorf("report data is %d bytes, at most %d allowed", len(reportData), snpReportDataSize)
+	}
+
+	entry, err := os.MkdirTemp(p.dir, "parity-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create report entry: %w", err)
+	}
+	defer os.Remove(entry)
+
+	inblob := make([]byte, snpReportDataSize)
+	copy(inblob, reportData)
+	if err := os.WriteFile(filepath.Join(entry, "inblob"), inblob, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write report data: %w", err)
+	}
+
+	provider, err := os.ReadFile(filepath.Join(entry, "provider"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report provider: %w", err)
+	}
+	if name := strings.TrimSpace(string(provider)); name != "sev_guest" {
+		return nil, fmt.Errorf("%w: report provider is %q", ErrUnsupported, name)
+	}
+
+	report, err := os.ReadFile(filepath.Join(entry, "outblob"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report: %w", err)
+	}
+	certs, err := os.ReadFile(filepath.Join(entry, "auxblob"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read certificate table: %w", err)
+	}
+
+	return &Quote{Report: report, PublicKey: certs}, nil
+}
+
+// sevReportData returns the REPORT_DATA field of an SEV-SNP report
+func sevReportData(report []byte) ([]byte, error) {
+	if len(report) < snpReportSize {
+		return nil, fmt.Errorf("report is %d bytes, expected %d", len(report), snpReportSize)
+	}
+	return report[snpReportDataOffset : snpReportDataOffset+snpReportDataSize], nil
+}
+
+// verifySEVReport checks report carries reportData and, when vcek is given,
+// that the report is signed by it. Checking vcek chains to AMD's root is left
+// to the caller.
+func verifySEVReport(report, reportData []byte, vcek *ecdsa.PublicKey) error {
+	got, err := sevReportData(report)
+	if err != nil {
+		return err
+	}
+	want := make([]byte, snpReportDataSize)
+	copy(want, reportData)
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("%w: report data differs", ErrMismatch)
+	}
+	if vcek == nil {
+		return nil
+	}
+
+	// R and S are stored little-endian, zero-extended to 72 bytes
+	sig := report[snpSignatureOffset:]
+	r := new(big.Int).SetBytes(reverse(sig[:snpSignatureSize]))
+	s := new(big.Int).SetBytes(reverse(sig[snpSignatureSize : 2*snpSignatureSize]))
+	digest := sha512.Sum384(report[:snpSignatureOffset])
+	if !ecdsa.Verify(vcek, digest[:], r, s) {
+		return errors.New("report signature is not valid for the VCEK")
+	}
+	return nil
+}
+
+func reverse(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out
+}
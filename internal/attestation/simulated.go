@@ -0,0 +1,60 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// simulatedMagic prefixes simulated reports so they cannot be mistaken for
+// hardware evidence
+var simulatedMagic = []byte("PARITY-SIMULATED-ATTESTATION\x00")
+
+// SimulatedProvider signs report data with a software key. It proves nothing
+// about the host and exists for tests and development on hardware without a
+// TPM or SEV-SNP; verifiers reject it unless explicitly allowed.
+type SimulatedProvider struct {
+	key *ecdsa.PrivateKey
+}
+
+func NewSimulatedProvider() (*SimulatedProvider, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate simulated attestation key: %w", err)
+	}
+	return &SimulatedProvider{key: key}, nil
+}
+
+func (p *SimulatedProvider) Type() models.AttestationType {
+	return models.AttestationSimulated
+}
+
+func (p *SimulatedProvider) Quote(ctx context.Context, reportData []byte) (*Quote, error) {
+	report := append(append([]byte{}, simulatedMagic...), reportData...)
+	digest := sha256.Sum256(report)
+	sig, err := ecdsa.SignASN1(rand.Reader, p.key, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign simulated report: %w", err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(&p.key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode simulated attestation key: %w", err)
+	}
+	return &Quote{Report: report, Signature: sig, PublicKey: pub}, nil
+}
+
+// simulatedReportData returns the report data of a simulated report
+func simulatedReportData(report []byte) ([]byte, error) {
+	if !bytes.HasPrefix(report, simulatedMagic) {
+		return nil, errors.New("report is not a simulated attestation report")
+	}
+	return report[len(simulatedMagic):], nil
+}
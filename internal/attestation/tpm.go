@@ -0,0 +1,135 @@
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const (
+	// tpmGeneratedValue marks structures the TPM itself produced
+	tpmGeneratedValue = 0xff544347
+	tpmSTAttestQuote  = 0x8018
+
+	// tpmQuotePCRs are the boot-chain PCRs included in every quote
+	tpmQuotePCRs = "sha256:0,1,2,3,4,5,6,7"
+)
+
+// TPMProvider collects TPM 2.0 quotes with tpm2-tools, signed by an
+// attestation key the operator has provisioned
+type TPMProvider struct {
+	akContext string
+	akPublic  []byte
+}
+
+// NewTPMProvider returns a provider quoting with the loaded key context at
+// akContext, whose PEM public key is at akPublicKey
+func NewTPMProvider(akContext, akPublicKey string) (*TPMProvider, error) {
+	if akContext == "" || akPublicKey == "" {
+		return nil, fmt.Errorf("%w: no TPM attestation key configured", ErrUnsupported)
+	}
+	if _, err := exec.LookPath("tpm2_quote"); err != nil {
+		return nil, fmt.Errorf("%w: tpm2_quote not installed", ErrUnsupported)
+	}
+	if _, err := os.Stat(akContext); err != nil {
+		return nil, fmt.Errorf("failed to find attestation key context: %w", err)
+	}
+
+	data, err := os.ReadFile(akPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attestation public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("attestation public key is not PEM encoded")
+	}
+	return &TPMProvider{akContext: akContext, akPublic: block.Bytes}, nil
+}
+
+func (p *TPMProvider) Type() models.AttestationType {
+	return models.AttestationTPM
+}
+
+// Quote signs the boot PCRs with reportData as the qualifying data
+func (p *TPMProvider) Quote(ctx context.Context, reportData []byte) (*Quote, error) {
+	dir, err := os.MkdirTemp("", "parity-tpm-quote-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create quote directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	msgPath := filepath.Join(dir, "quote.msg")
+	sigPath := filepath.Join(dir, "quote.sig")
+	cmd := exec.CommandContext(ctx, "tpm2_quote",
+		"--key-context", p.akContext,
+		"--pcr-list", tpmQuotePCRs,
+		"--qualification", hex.EncodeToString(reportData),
+		"--hash-algorithm", "sha256",
+		"--format", "plain",
+		"--message", msgPath,
+		"--signature", sigPath,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("tpm2_quote failed: %w: %s", err, bytes.TrimSpace(out))
+	}
+
+	msg, err := os.ReadFile(msgPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quote: %w", err)
+	}
+	sig, err := os.ReadFile(sigPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read quote signature: %w", err)
+	}
+	return &Quote{Report: msg, Signature: sig, PublicKey: p.akPublic}, nil
+}
+
+// tpmExtraData returns the qualifying data of a TPMS_ATTEST quote structure
+func tpmExtraData(attest []byte) ([]byte, error) {
+	r := bytes.NewReader(attest)
+	var header struct {
+		Magic uint32
+		Type  uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, fmt.Errorf("quote is truncated: %w", err)
+	}
+	if header.Magic != tpmGeneratedValue {
+		return nil, errors.New("quote was not generated by a TPM")
+	}
+	if header.Type != tpmSTAttestQuote {
+		return nil, fmt.Errorf("attestation structure type %#x is not a quote", header.Type)
+	}
+
+	// qualifiedSigner, then extraData, are both TPM2B size-prefixed buffers
+	if _, err := readTPM2B(r); err != nil {
+		return nil, fmt.Errorf("quote signer is truncated: %w", err)
+	}
+	extra, err := readTPM2B(r)
+	if err != nil {
+		return nil, fmt.Errorf("quote extra data is truncated: %w", err)
+	}
+	return extra, nil
+}
+
+func readTPM2B(r *bytes.Reader) ([]byte, error) {
+	var size uint16
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if int(size) > r.Len() {
+		return nil, fmt.Errorf("buffer of %d bytes exceeds remaining %d", size, r.Len())
+	}
+	buf := make([]byte, size)
+	_, err := r.Read(buf)
+	return buf, err
+}
@@ -0,0 +1,139 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// Expectation is what a verifier requires evidence to bind. Empty fields
+// accept any value, except Nonce which is always required.
+type Expectation struct {
+	Nonce string
+	// BinaryHashes lists the runner builds the verifier accepts
+	BinaryHashes []string
+	ConfigHash   string
+	TaskID       string
+	ResultHash   string
+	// TrustedKeys lists the PKIX-encoded TPM attestation keys the verifier
+	// accepts; without it the key in the evidence is only checked for
+	// consistency
+	TrustedKeys [][]byte
+	// VCEK verifies SEV-SNP report signatures; the caller is responsible
+	// for checking it chains to AMD's root
+	VCEK *ecdsa.PublicKey
+	// AllowSimulated accepts software-simulated evidence, for tests only
+	AllowSimulated bool
+}
+
+// Verify checks ev was produced over exp's values by the hardware it claims
+func Verify(ev *models.AttestationEvidence, exp Expectation) error {
+	if ev == nil {
+		return errors.New("no attestation evidence")
+	}
+	if exp.Nonce == "" || ev.Nonce != exp.Nonce {
+		return fmt.Errorf("%w: nonce", ErrMismatch)
+	}
+	if len(exp.BinaryHashes) > 0 && !containsString(exp.BinaryHashes, ev.BinaryHash) {
+		return fmt.Errorf("%w: runner binary %s is not accepted", ErrMismatch, ev.BinaryHash)
+	}
+	for _, check := range []struct{ name, want, got string }{
+		{"config hash", exp.ConfigHash, ev.ConfigHash},
+		{"task", exp.TaskID, ev.TaskID},
+		{"result hash", exp.ResultHash, ev.ResultHash},
+	} {
+		if check.want != "" && check.want != check.got {
+			return fmt.Errorf("%w: %s", ErrMismatch, check.name)
+		}
+	}
+
+	reportData := bindingOf(ev).ReportData()
+	if ev.ReportData != hex.EncodeToString(reportData) {
+		return fmt.Errorf("%w: report data does not bind the evidence fields", ErrMismatch)
+	}
+
+	switch ev.Type {
+	case models.AttestationSEVSNP:
+		return verifySEVReport(ev.Report, reportData, exp.VCEK)
+
+	case models.AttestationTPM:
+		extra, err := tpmExtraData(ev.Report)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(extra, reportData) {
+			return fmt.Errorf("%w: quote qualifying data differs", ErrMismatch)
+		}
+		if len(exp.TrustedKeys) > 0 && !containsKey(exp.TrustedKeys, ev.PublicKey) {
+			return errors.New("quote is signed by an untrusted attestation key")
+		}
+		return verifySignature(ev.PublicKey, ev.Report, ev.Signature)
+
+	case models.AttestationSimulated:
+		if !exp.AllowSimulated {
+			return errors.New("simulated attestation is not accepted")
+		}
+		data, err := simulatedReportData(ev.Report)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(data, reportData) {
+			return fmt.Errorf("%w: report data differs", ErrMismatch)
+		}
+		return verifySignature(ev.PublicKey, ev.Report, ev.Signature)
+
+	default:
+		return fmt.Errorf("unknown attestation type %q", ev.Type)
+	}
+}
+
+// verifySignature checks sig is a SHA-256 signature over msg by the PKIX
+// public key pub
+func verifySignature(pub, msg, sig []byte) error {
+	key, err := x509.ParsePKIXPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("failed to parse attestation public key: %w", err)
+	}
+	digest := sha256.Sum256(msg)
+
+	switch k := key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(k, digest[:], sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil ||
+			rsa.VerifyPSS(k, crypto.SHA256, digest[:], sig, nil) == nil {
+			return nil
+		}
+	default:
+		return fmt.Errorf("unsupported attestation key type %T", key)
+	}
+	return errors.New("attestation signature is not valid")
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsKey(keys [][]byte, key []byte) bool {
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,331 @@
+// Package audit lets the network check a runner by having it execute a past
+// task again. The runner periodically commits to the tasks it has completed
+// with a Merkle root; a challenge names a committed epoch and a seed, and a
+// verifiable random function keyed by the runner selects which committed
+// task is run again, so neither side can steer the choice.
+package audit
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/history"
+)
+
+// maxCommitments is how many past epochs remain answerable
+const maxCommitments = 48
+
+// alphaDomain separates audit VRF inputs from any other use of the key
+const alphaDomain = "parity-runner/audit/v1"
+
+var (
+	// ErrUnknownEpoch is returned for challenges against an epoch the
+	// runner never committed or no longer keeps
+	ErrUnknownEpoch = errors.New("unknown audit epoch")
+	// ErrCommitmentMismatch is returned when the history no longer
+	// reproduces a committed root
+	ErrCommitmentMismatch = errors.New("history does not match the committed root")
+	// ErrNothingCommitted is returned for challenges against an epoch that
+	// committed no tasks
+	ErrNothingCommitted = errors.New("no tasks committed in this epoch")
+)
+
+// Alpha is the VRF input for a challenge against a commitment
+func Alpha(seed []byte, epoch uint64, root []byte) []byte {
+	alpha := append([]byte(alphaDomain), 0x00)
+	alpha = binary.BigEndian.AppendUint64(alpha, epoch)
+	alpha = append(alpha, root...)
+	return append(alpha, seed...)
+}
+
+// SelectIndex maps a VRF output uniformly onto [0, count). Candidates are
+// drawn from the output with a counter and those past the largest multiple
+// of count are rejected, so no index is favoured.
+func SelectIndex(output []byte, count int) int {
+	n := uint64(count)
+	rem := (math.MaxUint64%n + 1) % n
+	var counter [4]byte
+	for i := uint32(0); ; i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+		h := sha256.New()
+		h.Write(output)
+		h.Write(counter[:])
+		x := binary.BigEndian.Uint64(h.Sum(nil))
+		if rem == 0 || x <= math.MaxUint64-rem {
+			return int(x % n)
+		}
+	}
+}
+
+// Selection is the committed task a challenge selects and the proofs that
+// it was selected fairly
+type Selection struct {
+	Commitment     models.AuditCommitment
+	Proof          []byte
+	Index          int
+	TaskID         string
+	ResultDigest   string
+	InclusionProof [][]byte
+}
+
+// Response returns the answer to challenge carrying the selection and its
+// proofs, to be completed with the outcome of the re-execution
+func (s *Selection) Response(challenge *models.AuditChallenge) *models.AuditResponse {
+	inclusion := make([]string, len(s.InclusionProof))
+	for i, hash := range s.InclusionProof {
+		inclusion[i] = hex.EncodeToString(hash)
+	}
+	return &models.AuditResponse{
+		ChallengeID:    challenge.ID,
+		Epoch:          s.Commitment.Epoch,
+		Root:           s.Commitment.Root,
+		Count:          s.Commitment.Count,
+		Proof:          base64.StdEncoding.EncodeToString(s.Proof),
+		Index:          s.Index,
+		TaskID:         s.TaskID,
+		InclusionProof: inclusion,
+		OriginalDigest: s.ResultDigest,
+	}
+}
+
+type leaf struct {
+	taskID       string
+	resultDigest string
+}
+
+// Auditor commits to the runner's completed tasks and answers challenges
+// against those commitments
+type Auditor struct {
+	key     *rsa.PrivateKey
+	history *history.Store
+	bundles *BundleStore
+	path    string
+	clock   clock.Clock
+
+	mu          sync.Mutex
+	commitments []models.AuditCommitment
+}
+
+// NewAuditor commits to completed tasks in store that carry a result
+// digest, keeping commitments in the file at path
+func NewAuditor(key *rsa.PrivateKey, store *history.Store, bundles *BundleStore, path string) (*Auditor, error) {
+	a := &Auditor{key: key, history: store, bundles: bundles, path: path, clock: clock.Real()}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("failed to read audit commitments: %w", err)
+	default:
+		if err := json.Unmarshal(data, &a.commitments); err != nil {
+			return nil, fmt.Errorf("failed to parse audit commitments: %w", err)
+		}
+	}
+	return a, nil
+}
+
+// SetClock replaces the clock used to timestamp commitments
+func (a *Auditor) SetClock(c clock.Clock) {
+	a.clock = c
+}
+
+// Bundles returns the replay bundles of committed tasks
+func (a *Auditor) Bundles() *BundleStore {
+	return a.bundles
+}
+
+// Latest returns the newest commitment, or nil before the first
+func (a *Auditor) Latest() *models.AuditCommitment {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.commitments) == 0 {
+		return nil
+	}
+	latest := a.commitments[len(a.commitments)-1]
+	return &latest
+}
+
+func (a *Auditor) leaves() ([]leaf, error) {
+	records, err := a.history.Load()
+	if err != nil {
+		return nil, err
+	}
+	var leaves []leaf
+	for _, record := range records {
+		if record.Event == "" && record.Status == models.TaskStatusCompleted && record.ResultDigest != "" {
+			leaves = append(leaves, leaf{record.TaskID, record.ResultDigest})
+		}
+	}
+	return leaves, nil
+}
+
+func leafHashes(leaves []leaf) [][]byte {
+	hashes := make([][]byte, len(leaves))
+	for i, l := range leaves {
+		hashes[i] = LeafHash(l.taskID, l.resultDigest)
+	}
+	return hashes
+}
+
+// Commit starts a new epoch when tasks have completed since the latest
+// commitment. It returns the commitment in effect and whether it is new.
+func (a *Auditor) Commit() (*models.AuditCommitment, bool, error) {
+	leaves, err := a.leaves()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to load task history: %w", err)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var epoch uint64 = 1
+	if n := len(a.commitments); n > 0 {
+		latest := a.commitments[n-1]
+		if latest.Count == len(leaves) {
+			return &latest, false, nil
+		}
+		epoch = latest.Epoch + 1
+	}
+
+	pub, err := EncodePublicKey(&a.key.PublicKey)
+	if err != nil {
+		return nil, false, err
+	}
+	commitment := models.AuditCommitment{
+		Epoch:       epoch,
+		Root:        hex.EncodeToString(MerkleRoot(leafHashes(leaves))),
+		Count:       len(leaves),
+		PublicKey:   base64.StdEncoding.EncodeToString(pub),
+		CommittedAt: a.clock.Now(),
+	}
+
+	commitments := append(a.commitments, commitment)
+	if len(commitments) > maxCommitments {
+		commitments = commitments[len(commitments)-maxCommitments:]
+	}
+	data, err := json.Marshal(commitments)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to marshal audit commitments: %w", err)
+	}
+	// A commitment only counts once it survives a restart
+	if err := atrest.WriteFileAtomic(a.path, data, 0o600); err != nil {
+		return nil, false, fmt.Errorf("failed to save audit commitments: %w", err)
+	}
+	a.commitments = commitments
+	return &commitment, true, nil
+}
+
+func (a *Auditor) commitment(epoch uint64) (models.AuditCommitment, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, c := range a.commitments {
+		if c.Epoch == epoch {
+			return c, true
+		}
+	}
+	return models.AuditCommitment{}, false
+}
+
+// Select returns the committed task challenge selects. The history must
+// still reproduce the committed root; tasks completed since only extend it.
+func (a *Auditor) Select(challenge *models.AuditChallenge) (*Selection, error) {
+	seed, err := hex.DecodeString(challenge.Seed)
+	if err != nil || len(seed) == 0 {
+		return nil, errors.New("audit challenge seed must be non-empty hex")
+	}
+	commitment, ok := a.commitment(challenge.Epoch)
+	if !ok {
+		return nil, fmt.Errorf("%w %d", ErrUnknownEpoch, challenge.Epoch)
+	}
+	if commitment.Count == 0 {
+		return nil, ErrNothingCommitted
+	}
+
+	leaves, err := a.leaves()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load task history: %w", err)
+	}
+	if len(leaves) < commitment.Count {
+		return nil, fmt.Errorf("%w: %d tasks committed, %d in history", ErrCommitmentMismatch, commitment.Count, len(leaves))
+	}
+	leaves = leaves[:commitment.Count]
+	hashes := leafHashes(leaves)
+	root := MerkleRoot(hashes)
+	if hex.EncodeToString(root) != commitment.Root {
+		return nil, fmt.Errorf("%w of epoch %d", ErrCommitmentMismatch, commitment.Epoch)
+	}
+
+	proof, output := Prove(a.key, Alpha(seed, commitment.Epoch, root))
+	index := SelectIndex(output, commitment.Count)
+	return &Selection{
+		Commitment:     commitment,
+		Proof:          proof,
+		Index:          index,
+		TaskID:         leaves[index].taskID,
+		ResultDigest:   leaves[index].resultDigest,
+		InclusionProof: InclusionProof(hashes, index),
+	}, nil
+}
+
+// VerifyResponse checks, as the server would, that response answers
+// challenge against commitment with the task the VRF selects
+func VerifyResponse(commitment *models.AuditCommitment, challenge *models.AuditChallenge, response *models.AuditResponse) error {
+	seed, err := hex.DecodeString(challenge.Seed)
+	if err != nil {
+		return fmt.Errorf("invalid challenge seed: %w", err)
+	}
+	root, err := hex.DecodeString(commitment.Root)
+	if err != nil {
+		return fmt.Errorf("invalid commitment root: %w", err)
+	}
+	der, err := base64.StdEncoding.DecodeString(commitment.PublicKey)
+	if err != nil {
+		return fmt.Errorf("invalid commitment public key: %w", err)
+	}
+	pub, err := ParsePublicKey(der)
+	if err != nil {
+		return err
+	}
+	proof, err := base64.StdEncoding.DecodeString(response.Proof)
+	if err != nil {
+		return fmt.Errorf("invalid VRF proof encoding: %w", err)
+	}
+
+	if response.Epoch != commitment.Epoch || response.Root != commitment.Root || response.Count != commitment.Count {
+		return errors.New("response is for a different commitment")
+	}
+	output, err := Verify(pub, Alpha(seed, commitment.Epoch, root), proof)
+	if err != nil {
+		return err
+	}
+	if want := SelectIndex(output, commitment.Count); response.Index != want {
+		return fmt.Errorf("response audits task %d, the VRF selects %d", response.Index, want)
+	}
+
+	inclusion := make([][]byte, len(response.InclusionProof))
+	for i, hash := range response.InclusionProof {
+		if inclusion[i], err = hex.DecodeString(hash); err != nil {
+			return fmt.Errorf("invalid inclusion proof: %w", err)
+		}
+	}
+	leafHash := LeafHash(response.TaskID, response.OriginalDigest)
+	if !VerifyInclusion(root, leafHash, response.Index, commitment.Count, inclusion) {
+		return fmt.Errorf("task %s is not committed at index %d", response.TaskID, response.Index)
+	}
+	if response.Status == models.AuditStatusReexecuted && response.Match != (response.ResultDigest == response.OriginalDigest) {
+		return errors.New("response misreports whether the results match")
+	}
+	return nil
+}
@@ -0,0 +1,257 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/history"
+)
+
+func newKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestVRFProofs(t *testing.T) {
+	key := newKey(t)
+	alpha := []byte("challenge")
+
+	proof, output := Prove(key, alpha)
+	again, _ := Prove(key, alpha)
+	if !bytes.Equal(proof, again) {
+		t.Fatal("Prove() is not deterministic")
+	}
+
+	verified, err := Verify(&key.PublicKey, alpha, proof)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if !bytes.Equal(verified, output) {
+		t.Fatal("Verify() returned a different output than Prove()")
+	}
+
+	if _, other := Prove(key, []byte("another challenge")); bytes.Equal(other, output) {
+		t.Fatal("different inputs gave the same output")
+	}
+
+	tampered := bytes.Clone(proof)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := Verify(&key.PublicKey, alpha, tampered); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("Verify() error = %v for a tampered proof, want ErrInvalidProof", err)
+	}
+	if _, err := Verify(&key.PublicKey, []byte("other"), proof); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("Verify() error = %v for another input, want ErrInvalidProof", err)
+	}
+	if _, err := Verify(&newKey(t).PublicKey, alpha, proof); !errors.Is(err, ErrInvalidProof) {
+		t.Fatalf("Verify() error = %v under another key, want ErrInvalidProof", err)
+	}
+}
+
+func TestLoadOrCreateKeyPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit", "vrf_key.pem")
+	key, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadOrCreateKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(loaded) {
+		t.Fatal("LoadOrCreateKey() generated a new key instead of loading the saved one")
+	}
+}
+
+func TestSelectIndexCoversEveryTask(t *testing.T) {
+	const count, draws = 5, 5000
+	hits := make([]int, count)
+	for i := 0; i < draws; i++ {
+		index := SelectIndex([]byte(fmt.Sprint(i)), count)
+		if index < 0 || index >= count {
+			t.Fatalf("SelectIndex() = %d, out of range", index)
+		}
+		hits[index]++
+	}
+	for index, n := range hits {
+		if n < draws/count*8/10 || n > draws/count*12/10 {
+			t.Fatalf("index %d selected %d of %d times: %v", index, n, draws, hits)
+		}
+	}
+	if SelectIndex([]byte("x"), 1) != 0 {
+		t.Fatal("SelectIndex() of a single task is not 0")
+	}
+}
+
+func TestMerkleInclusionProofs(t *testing.T) {
+	for count := 1; count <= 17; count++ {
+		var leaves [][]byte
+		for i := 0; i < count; i++ {
+			leaves = append(leaves, LeafHash(fmt.Sprint("task-", i), "digest"))
+		}
+		root := MerkleRoot(leaves)
+		for index := range leaves {
+			proof := InclusionProof(leaves, index)
+			if !VerifyInclusion(root, leaves[index], index, count, proof) {
+				t.Fatalf("leaf %d of %d does not verify", index, count)
+			}
+			if count > 1 && VerifyInclusion(root, leaves[index], (index+1)%count, count, proof) {
+				t.Fatalf("leaf %d of %d verifies at the wrong index", index, count)
+			}
+		}
+	}
+}
+
+// newHistory returns a store holding n completed tasks with result digests,
+// interleaved with executions that are never committed
+func newHistory(t *testing.T, n int) (*history.Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := history.NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendTasks(t, store, n)
+	return store, path
+}
+
+func appendTasks(t *testing.T, store *history.Store, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		records := []history.Record{
+			{TaskID: uuid.NewString(), Status: models.TaskStatusCompleted, ResultDigest: fmt.Sprintf("digest-%d", i)},
+			{TaskID: uuid.NewString(), Status: models.TaskStatusFailed},
+			{TaskID: uuid.NewString(), Status: models.TaskStatusCompleted},
+		}
+		for _, record := range records {
+			if err := store.Append(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestCommitmentConsistency(t *testing.T) {
+	key := newKey(t)
+	dir := t.TempDir()
+	store, historyPath := newHistory(t, 5)
+	bundles, err := NewBundleStore(filepath.Join(dir, "bundles"), Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	commitmentsPath := filepath.Join(dir, "commitments.json")
+	auditor, err := NewAuditor(key, store, bundles, commitmentsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, created, err := auditor.Commit()
+	if err != nil || !created || first.Epoch != 1 || first.Count != 5 {
+		t.Fatalf("Commit() = %+v, %v, %v, want a new epoch 1 over 5 tasks", first, created, err)
+	}
+	if again, created, _ := auditor.Commit(); created || again.Epoch != 1 {
+		t.Fatalf("Commit() started epoch %d without new tasks", again.Epoch)
+	}
+
+	appendTasks(t, store, 3)
+	second, created, err := auditor.Commit()
+	if err != nil || !created || second.Epoch != 2 || second.Count != 8 {
+		t.Fatalf("Commit() = %+v, %v, %v, want a new epoch 2 over 8 tasks", second, created, err)
+	}
+	if second.Root == first.Root {
+		t.Fatal("new tasks did not change the root")
+	}
+
+	// Epoch 1 stays answerable after later tasks and a restart
+	challenge := &models.AuditChallenge{ID: "c1", Seed: "00ff10", Epoch: 1}
+	restarted, err := NewAuditor(key, store, bundles, commitmentsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest := restarted.Latest(); latest == nil || latest.Epoch != 2 {
+		t.Fatalf("Latest() after restart = %+v, want epoch 2", latest)
+	}
+	selection, err := restarted.Select(challenge)
+	if err != nil {
+		t.Fatalf("Select() error = %v", err)
+	}
+	if selection.Index >= first.Count {
+		t.Fatalf("Select() chose task %d, beyond the %d committed in epoch 1", selection.Index, first.Count)
+	}
+	if err := VerifyResponse(first, challenge, selection.Response(challenge)); err != nil {
+		t.Fatalf("VerifyResponse() error = %v", err)
+	}
+
+	forged := selection.Response(challenge)
+	forged.Index = (forged.Index + 1) % first.Count
+	if err := VerifyResponse(first, challenge, forged); err == nil {
+		t.Fatal("VerifyResponse() accepted a task the VRF did not select")
+	}
+
+	if _, err := auditor.Select(&models.AuditChallenge{ID: "c2", Seed: "00", Epoch: 9}); !errors.Is(err, ErrUnknownEpoch) {
+		t.Fatalf("Select() error = %v, want ErrUnknownEpoch", err)
+	}
+
+	// Rewriting a committed result breaks the commitment
+	data, err := os.ReadFile(historyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(historyPath, []byte(strings.Replace(string(data), "digest-0", "digest-x", 1)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auditor.Select(challenge); !errors.Is(err, ErrCommitmentMismatch) {
+		t.Fatalf("Select() error = %v after rewriting history, want ErrCommitmentMismatch", err)
+	}
+}
+
+func TestBundleRetention(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	bundles, err := NewBundleStore(t.TempDir(), Retention{MaxAge: time.Hour, MaxBundles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundles.SetClock(clk)
+
+	var tasks []*models.Task
+	for i := 0; i < 3; i++ {
+		task := &models.Task{ID: uuid.New(), Type: models.TaskTypeCommand}
+		if err := bundles.Save(task, fmt.Sprint("digest-", i)); err != nil {
+			t.Fatal(err)
+		}
+		// Order the files by age independently of timestamp resolution
+		past := time.Now().Add(time.Duration(i-3) * time.Minute)
+		if err := os.Chtimes(bundles.path(task.ID.String()), past, past); err != nil {
+			t.Fatal(err)
+		}
+		tasks = append(tasks, task)
+	}
+
+	if _, err := bundles.Load(tasks[0].ID.String()); !errors.Is(err, ErrBundleMissing) {
+		t.Fatalf("Load() error = %v for a bundle beyond MaxBundles, want ErrBundleMissing", err)
+	}
+	bundle, err := bundles.Load(tasks[2].ID.String())
+	if err != nil || bundle.ResultDigest != "digest-2" {
+		t.Fatalf("Load() = %+v, %v", bundle, err)
+	}
+
+	clk.Advance(2 * time.Hour)
+	if _, err := bundles.Load(tasks[2].ID.String()); !errors.Is(err, ErrBundleMissing) {
+		t.Fatalf("Load() error = %v for a bundle past MaxAge, want ErrBundleMissing", err)
+	}
+}
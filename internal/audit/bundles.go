@@ -0,0 +1,206 @@
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+)
+
+// ErrBundleMissing is returned for tasks whose replay bundle was never kept
+// or has been pruned
+var ErrBundleMissing = errors.New("replay bundle not retained")
+
+// Bundle is what the runner needs to execute a completed task again
+type Bundle struct {
+	Task         *models.Task `json:"task"`
+	ResultDigest string       `json:"result_digest"`
+	CompletedAt  time.Time    `json:"completed_at"`
+}
+
+// Retention bounds the replay bundles kept. Bundles older than MaxAge, and
+// the oldest beyond MaxBundles, are pruned; zero disables either limit.
+type Retention struct {
+	MaxAge     time.Duration
+	MaxBundles int
+}
+
+// Model returns the policy as reported to the server
+func (r Retention) Model() *models.AuditRetention {
+	return &models.AuditRetention{
+		MaxAgeSeconds: int64(r.MaxAge / time.Second),
+		MaxBundles:    r.MaxBundles,
+	}
+}
+
+// BundleStore keeps one replay bundle per completed task as a JSON file,
+// encrypted when a codec is set
+type BundleStore struct {
+	dir       string
+	retention Retention
+	codec     atrest.Codec
+	clock     clock.Clock
+	mu        sync.Mutex
+}
+
+func NewBundleStore(dir string, retention Retention) (*BundleStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create replay bundle directory: %w", err)
+	}
+	return &BundleStore{dir: dir, retention: retention, clock: clock.Real()}, nil
+}
+
+// SetClock replaces the clock used to timestamp and age bundles
+func (s *BundleStore) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// SetCodec encrypts bundles written from now on
+func (s *BundleStore) SetCodec(codec atrest.Codec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codec = codec
+}
+
+// Retention returns the retention policy bundles are kept under
+func (s *BundleStore) Retention() Retention {
+	return s.retention
+}
+
+func (s *BundleStore) path(taskID string) string {
+	return filepath.Join(s.dir, taskID+".json")
+}
+
+// Save keeps the bundle of a completed task and prunes bundles the
+// retention policy no longer covers
+func (s *BundleStore) Save(task *models.Task, resultDigest string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(Bundle{Task: task, ResultDigest: resultDigest, CompletedAt: s.clock.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal replay bundle: %w", err)
+	}
+	if err := atrest.WriteFile(s.codec, s.path(task.ID.String()), data, 0o600); err != nil {
+		return fmt.Errorf("failed to save replay bundle: %w", err)
+	}
+	return s.prune()
+}
+
+// Load returns the bundle of taskID, or ErrBundleMissing
+func (s *BundleStore) Load(taskID string) (*Bundle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := atrest.ReadFile(s.codec, s.path(taskID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w for task %s", ErrBundleMissing, taskID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay bundle: %w", err)
+	}
+	var bundle Bundle
+	if err := json.Unmarshal(data, &bundle); err != nil || bundle.Task == nil {
+		return nil, fmt.Errorf("replay bundle for task %s is unreadable", taskID)
+	}
+	if s.retention.MaxAge > 0 && clock.Since(s.clock, bundle.CompletedAt) > s.retention.MaxAge {
+		return nil, fmt.Errorf("%w for task %s: older than %s", ErrBundleMissing, taskID, s.retention.MaxAge)
+	}
+	return &bundle, nil
+}
+
+// PurgeTask securely deletes the bundle of taskID, if one is kept
+func (s *BundleStore) PurgeTask(taskID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := retention.SecureRemove(s.path(taskID)); err != nil {
+		return fmt.Errorf("failed to delete replay bundle: %w", err)
+	}
+	return nil
+}
+
+type bundleFile struct {
+	path    string
+	modTime time.Time
+}
+
+func (s *BundleStore) files() ([]bundleFile, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replay bundle directory: %w", err)
+	}
+	var files []bundleFile
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, bundleFile{filepath.Join(s.dir, entry.Name()), info.ModTime()})
+	}
+	slices.SortFunc(files, func(a, b bundleFile) int { return a.modTime.Compare(b.modTime) })
+	return files, nil
+}
+
+// prune removes bundles past the retention policy, oldest first. Ages come
+// from file modification times so that pruning need not decrypt bundles.
+func (s *BundleStore) prune() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	excess := 0
+	if s.retention.MaxBundles > 0 && len(files) > s.retention.MaxBundles {
+		excess = len(files) - s.retention.MaxBundles
+	}
+	for i, file := range files {
+		expired := s.retention.MaxAge > 0 && clock.Since(s.clock, file.modTime) > s.retention.MaxAge
+		if i >= excess && !expired {
+			continue
+		}
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to prune replay bundle: %w", err)
+		}
+	}
+	return nil
+}
+
+// Reseal rewrites bundles that are plaintext or sealed with an older key
+// under the active key. It returns the number rewritten.
+func (s *BundleStore) Reseal() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.codec == nil {
+		return 0, nil
+	}
+	files, err := s.files()
+	if err != nil {
+		return 0, err
+	}
+	resealed := 0
+	for _, file := range files {
+		rewritten, err := atrest.ResealFile(s.codec, file.path)
+		if err != nil {
+			return resealed, fmt.Errorf("failed to reseal replay bundle %s: %w", filepath.Base(file.path), err)
+		}
+		if rewritten {
+			// Keep the original time so resealing does not reset its age
+			_ = os.Chtimes(file.path, file.modTime, file.modTime)
+			resealed++
+		}
+	}
+	return resealed, nil
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"math/bits"
+)
+
+// The Merkle tree follows RFC 9162: leaves and interior nodes are hashed
+// with distinct prefixes, and a tree of n leaves splits at the largest power
+// of two below n, so a root over the first n tasks stays reproducible as
+// more tasks complete.
+
+// LeafHash is the Merkle leaf of a completed task and its result digest
+func LeafHash(taskID, resultDigest string) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write([]byte(taskID))
+	h.Write([]byte{0x00})
+	h.Write([]byte(resultDigest))
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// split returns the largest power of two below n, for n > 1
+func split(n int) int {
+	return 1 << (bits.Len(uint(n-1)) - 1)
+}
+
+// MerkleRoot returns the root over leaves
+func MerkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return leaves[0]
+	}
+	k := split(len(leaves))
+	return nodeHash(MerkleRoot(leaves[:k]), MerkleRoot(leaves[k:]))
+}
+
+// InclusionProof returns the sibling hashes from leaves[index] up to the
+// root, nearest first
+func InclusionProof(leaves [][]byte, index int) [][]byte {
+	if len(leaves) <= 1 {
+		return nil
+	}
+	k := split(len(leaves))
+	if index < k {
+		return append(InclusionProof(leaves[:k], index), MerkleRoot(leaves[k:]))
+	}
+	return append(InclusionProof(leaves[k:], index-k), MerkleRoot(leaves[:k]))
+}
+
+// VerifyInclusion reports whether proof shows leaf is at index in a tree of
+// count leaves with the given root
+func VerifyInclusion(root, leaf []byte, index, count int, proof [][]byte) bool {
+	if index < 0 || index >= count {
+		return false
+	}
+	fn, sn := index, count-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, root)
+}
@@ -0,0 +1,151 @@
+package audit
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+)
+
+// The VRF is RSA-FDH-VRF-SHA256 from RFC 9381. Unlike an ECDSA or Schnorr
+// signature it has no nonce, so the runner cannot grind for a proof that
+// selects a task it prefers: each input has exactly one valid output.
+
+const (
+	vrfSuite    = 0x01
+	vrfKeyBits  = 2048
+	vrfKeyBlock = "PRIVATE KEY"
+)
+
+// ErrInvalidProof is returned when a VRF proof does not verify
+var ErrInvalidProof = errors.New("invalid VRF proof")
+
+// mgf1 is MGF1 from RFC 8017 with SHA-256
+func mgf1(seed []byte, length int) []byte {
+	out := make([]byte, 0, length+sha256.Size)
+	var counter [4]byte
+	for i := uint32(0); len(out) < length; i++ {
+		binary.BigEndian.PutUint32(counter[:], i)
+		h := sha256.New()
+		h.Write(seed)
+		h.Write(counter[:])
+		out = h.Sum(out)
+	}
+	return out[:length]
+}
+
+// encodeAlpha maps alpha to an integer below the modulus of pub
+func encodeAlpha(pub *rsa.PublicKey, alpha []byte) *big.Int {
+	k := pub.Size()
+	seed := []byte{vrfSuite, 0x01}
+	seed = binary.BigEndian.AppendUint32(seed, uint32(k))
+	seed = append(seed, pub.N.FillBytes(make([]byte, k))...)
+	seed = append(seed, alpha...)
+	return new(big.Int).SetBytes(mgf1(seed, k-1))
+}
+
+// proofToHash returns the VRF output of a proof
+func proofToHash(proof []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{vrfSuite, 0x02})
+	h.Write(proof)
+	return h.Sum(nil)
+}
+
+// Prove returns the VRF proof of alpha under key and the output it proves
+func Prove(key *rsa.PrivateKey, alpha []byte) (proof, output []byte) {
+	m := encodeAlpha(&key.PublicKey, alpha)
+	s := new(big.Int).Exp(m, key.D, key.N)
+	proof = s.FillBytes(make([]byte, key.Size()))
+	return proof, proofToHash(proof)
+}
+
+// Verify checks proof of alpha under pub and returns the output it proves
+func Verify(pub *rsa.PublicKey, alpha, proof []byte) ([]byte, error) {
+	if len(proof) != pub.Size() {
+		return nil, ErrInvalidProof
+	}
+	s := new(big.Int).SetBytes(proof)
+	if s.Cmp(pub.N) >= 0 {
+		return nil, ErrInvalidProof
+	}
+	m := new(big.Int).Exp(s, big.NewInt(int64(pub.E)), pub.N)
+	if subtle.ConstantTimeCompare(m.Bytes(), encodeAlpha(pub, alpha).Bytes()) != 1 {
+		return nil, ErrInvalidProof
+	}
+	return proofToHash(proof), nil
+}
+
+// EncodePublicKey returns pub as PKIX DER, the form commitments publish
+// in base64
+func EncodePublicKey(pub *rsa.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode VRF public key: %w", err)
+	}
+	return der, nil
+}
+
+// ParsePublicKey parses a PKIX DER RSA public key
+func ParsePublicKey(der []byte) (*rsa.PublicKey, error) {
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse VRF public key: %w", err)
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("VRF public key is %T, not RSA", key)
+	}
+	return pub, nil
+}
+
+// LoadOrCreateKey reads the runner's VRF key from path, generating and
+// saving one on first use. The key must outlive every commitment made with
+// it, so it is never rotated implicitly.
+func LoadOrCreateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != vrfKeyBlock {
+			return nil, fmt.Errorf("VRF key file %s is not a PEM private key", path)
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse VRF key: %w", err)
+		}
+		key, ok := parsed.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("VRF key is %T, not RSA", parsed)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read VRF key: %w", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, vrfKeyBits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate VRF key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode VRF key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create audit directory: %w", err)
+	}
+	if err := atrest.WriteFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: vrfKeyBlock, Bytes: der}), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to save VRF key: %w", err)
+	}
+	return key, nil
+}
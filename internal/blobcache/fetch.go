@@ -0,0 +1,254 @@
+package blobcache
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+	"github.com/theblitlabs/parity-runner/internal/timeline"
+)
+
+const (
+	// apiPrefix is where blobs are served to peers on the runner's port
+	apiPrefix = "/runner/blobs"
+
+	timestampHeader = "X-Parity-Fleet-Timestamp"
+	signatureHeader = "X-Parity-Fleet-Signature"
+
+	// maxSkew bounds how far a request's timestamp may be from the server's
+	// clock, limiting how long a captured request can be replayed
+	maxSkew = 5 * time.Minute
+)
+
+// Stats counts where the blobs a runner needed came from and how often it
+// served its own to peers
+type Stats struct {
+	LocalHits     uint64
+	PeerHits      uint64
+	PeerFailures  uint64
+	OriginFetches uint64
+	Served        uint64
+	Refused       uint64
+}
+
+// Fetcher gets blobs from the store, from peers holding them or from their
+// origin, in that order, and serves the store to peers. Peers are asked
+// with requests signed by the runner's wallet, and only answer runners
+// whose wallets are fleet members.
+type Fetcher struct {
+	store   *Store
+	signer  signer.Signer
+	peers   []string
+	members map[common.Address]bool
+	client  *http.Client
+	clock   clock.Clock
+
+	localHits     atomic.Uint64
+	peerHits      atomic.Uint64
+	peerFailures  atomic.Uint64
+	originFetches atomic.Uint64
+	served        atomic.Uint64
+	refused       atomic.Uint64
+}
+
+// NewFetcher fetches blobs into store from the runners at the peers' base
+// URLs before their origin, and serves store to s and members
+func NewFetcher(store *Store, s signer.Signer, peers []string, members []common.Address) *Fetcher {
+	f := &Fetcher{
+		store:   store,
+		signer:  s,
+		members: map[common.Address]bool{s.Address(): true},
+		client:  dualstack.Client(0),
+		clock:   clock.Real(),
+	}
+	for _, peer := range peers {
+		f.peers = append(f.peers, strings.TrimSuffix(peer, "/"))
+	}
+	for _, member := range members {
+		f.members[member] = true
+	}
+	return f
+}
+
+// SetHTTPClient replaces the client peers are asked with
+func (f *Fetcher) SetHTTPClient(client *http.Client) {
+	f.client = client
+}
+
+// SetClock replaces the clock requests are signed and checked by
+func (f *Fetcher) SetClock(c clock.Clock) {
+	f.clock = c
+}
+
+// Store returns the store blobs are fetched into
+func (f *Fetcher) Store() *Store {
+	return f.store
+}
+
+// Fetch returns the file holding the blob with digest and its digest.
+// Without a digest the blob cannot be verified against anything a peer
+// sends, so it is fetched from origin and kept under the digest it has.
+// A peer that fails or sends other content is passed over for the next.
+func (f *Fetcher) Fetch(ctx context.Context, digest string, origin func(ctx context.Context) (io.ReadCloser, error)) (string, string, error) {
+	log := gologger.WithComponent("blobcache")
+
+	if digest != "" {
+		if _, err := ParseDigest(digest); err != nil {
+			return "", "", err
+		}
+		if path, ok := f.store.Lookup(digest); ok {
+			f.localHits.Add(1)
+			return path, digest, nil
+		}
+		for _, peer := range f.peers {
+			path, err := f.fetchPeer(ctx, peer, digest)
+			if err == nil {
+				f.peerHits.Add(1)
+				log.Debug().Str("digest", digest).Str("peer", peer).Msg("Fetched blob from peer")
+				return path, digest, nil
+			}
+			if ctx.Err() != nil {
+				return "", "", ctx.Err()
+			}
+			f.peerFailures.Add(1)
+			log.Debug().Err(err).Str("digest", digest).Str("peer", peer).Msg("Peer could not serve blob")
+		}
+	}
+
+	body, err := origin(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	defer body.Close()
+	path, got, err := f.store.Write(&countingReader{ctx: ctx, r: body}, digest)
+	if err != nil {
+		return "", "", err
+	}
+	f.originFetches.Add(1)
+	return path, got, nil
+}
+
+func (f *Fetcher) fetchPeer(ctx context.Context, peer, digest string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+apiPrefix+"/"+digest, nil)
+	if err != nil {
+		return "", err
+	}
+	ts := strconv.FormatInt(f.clock.Now().Unix(), 10)
+	signature, err := f.signer.SignHash(requestHash(digest, ts))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign blob request: %w", err)
+	}
+	req.Header.Set(timestampHeader, ts)
+	req.Header.Set(signatureHeader, hex.EncodeToString(signature))
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("peer answered %d", resp.StatusCode)
+	}
+	path, _, err := f.store.Write(&countingReader{ctx: ctx, r: resp.Body}, digest)
+	return path, err
+}
+
+// Stats returns the blobs counted so far
+func (f *Fetcher) Stats() Stats {
+	return Stats{
+		LocalHits:     f.localHits.Load(),
+		PeerHits:      f.peerHits.Load(),
+		PeerFailures:  f.peerFailures.Load(),
+		OriginFetches: f.originFetches.Load(),
+		Served:        f.served.Load(),
+		Refused:       f.refused.Load(),
+	}
+}
+
+// RegisterHandlers serves the store to fleet members on mux:
+//
+//	GET /runner/blobs/{digest}   the blob, signed for by a fleet member
+func (f *Fetcher) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+apiPrefix+"/{digest}", f.serve)
+}
+
+func (f *Fetcher) serve(w http.ResponseWriter, req *http.Request) {
+	digest := req.PathValue("digest")
+	if err := f.authorize(digest, req.Header); err != nil {
+		f.refused.Add(1)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	path, ok := f.store.Lookup(digest)
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer file.Close()
+	f.served.Add(1)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	http.ServeContent(w, req, "", time.Time{}, file)
+}
+
+// authorize checks that a fleet member signed for digest recently
+func (f *Fetcher) authorize(digest string, header http.Header) error {
+	ts := header.Get(timestampHeader)
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return fmt.Errorf("missing or invalid %s", timestampHeader)
+	}
+	if skew := f.clock.Now().Sub(time.Unix(unix, 0)); skew > maxSkew || skew < -maxSkew {
+		return fmt.Errorf("request timestamp is %s off", skew.Round(time.Second))
+	}
+	signature, err := hex.DecodeString(header.Get(signatureHeader))
+	if err != nil || len(signature) != crypto.SignatureLength {
+		return fmt.Errorf("missing or malformed %s", signatureHeader)
+	}
+	pub, err := crypto.SigToPub(requestHash(digest, ts), signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %v", err)
+	}
+	if !f.members[crypto.PubkeyToAddress(*pub)] {
+		return fmt.Errorf("request not signed by a fleet member")
+	}
+	return nil
+}
+
+// requestHash is what a request for the blob with digest at ts is signed by
+func requestHash(digest, ts string) []byte {
+	return crypto.Keccak256([]byte("parity-blob\n" + digest + "\n" + ts))
+}
+
+// countingReader counts what it reads toward the task's downloaded bytes
+type countingReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		timeline.From(c.ctx).AddBytes(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,187 @@
+package blobcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+func newSigner(t *testing.T) *signer.Local {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := signer.FromECDSA(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return digestPrefix + hex.EncodeToString(sum[:])
+}
+
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+// servingPeer returns a runner serving content to the fleet member
+func servingPeer(t *testing.T, content []byte, member common.Address) (*httptest.Server, *Fetcher) {
+	t.Helper()
+	store := openStore(t)
+	if _, _, err := store.Write(bytes.NewReader(content), digestOf(content)); err != nil {
+		t.Fatal(err)
+	}
+	fetcher := NewFetcher(store, newSigner(t), nil, []common.Address{member})
+	mux := http.NewServeMux()
+	fetcher.RegisterHandlers(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, fetcher
+}
+
+func noOrigin(ctx context.Context) (io.ReadCloser, error) {
+	return nil, errors.New("origin unreachable")
+}
+
+func TestFetchFromPeer(t *testing.T) {
+	content := []byte("image layers")
+	s := newSigner(t)
+	peer, served := servingPeer(t, content, s.Address())
+
+	fetcher := NewFetcher(openStore(t), s, []string{peer.URL + "/"}, nil)
+	path, digest, err := fetcher.Fetch(context.Background(), digestOf(content), noOrigin)
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, content) || digest != digestOf(content) {
+		t.Errorf("Fetch() = %q, %s", got, digest)
+	}
+	if stats := fetcher.Stats(); stats.PeerHits != 1 || stats.OriginFetches != 0 {
+		t.Errorf("Stats() = %+v, want a peer hit", stats)
+	}
+	if stats := served.Stats(); stats.Served != 1 {
+		t.Errorf("peer Stats() = %+v, want the blob served", stats)
+	}
+
+	// Once cached, the blob is not fetched again
+	if _, _, err := fetcher.Fetch(context.Background(), digestOf(content), noOrigin); err != nil || fetcher.Stats().LocalHits != 1 {
+		t.Errorf("Fetch() of a cached blob = %v, stats %+v", err, fetcher.Stats())
+	}
+}
+
+func TestFetchFallsBackWhenPeerCutsOff(t *testing.T) {
+	content := bytes.Repeat([]byte("layer"), 1<<14)
+	cutoff := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The peer goes down halfway through the blob
+		w.Header().Set("Content-Length", "81920")
+		w.Write(content[:len(content)/2])
+	}))
+	defer cutoff.Close()
+	liar := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("something else"))
+	}))
+	defer liar.Close()
+
+	fetcher := NewFetcher(openStore(t), newSigner(t), []string{cutoff.URL, liar.URL}, nil)
+	origins := 0
+	path, _, err := fetcher.Fetch(context.Background(), digestOf(content), func(ctx context.Context) (io.ReadCloser, error) {
+		origins++
+		return io.NopCloser(bytes.NewReader(content)), nil
+	})
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if got, _ := os.ReadFile(path); !bytes.Equal(got, content) || origins != 1 {
+		t.Errorf("Fetch() read %d bytes after %d origin fetches", len(got), origins)
+	}
+	if stats := fetcher.Stats(); stats.PeerFailures != 2 || stats.OriginFetches != 1 {
+		t.Errorf("Stats() = %+v, want both peers failed and the origin used", stats)
+	}
+}
+
+func TestFetchRejectsMismatchedOrigin(t *testing.T) {
+	store := openStore(t)
+	fetcher := NewFetcher(store, newSigner(t), nil, nil)
+	want := digestOf([]byte("expected"))
+	_, _, err := fetcher.Fetch(context.Background(), want, func(ctx context.Context) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader([]byte("tampered"))), nil
+	})
+	if !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("Fetch() error = %v, want ErrDigestMismatch", err)
+	}
+	if entries, _ := store.Entries(context.Background()); len(entries) != 0 {
+		t.Errorf("store kept %v after a mismatch", entries)
+	}
+
+	// Without a digest the blob is kept under the one it has
+	path, digest, err := fetcher.Fetch(context.Background(), "", func(ctx context.Context) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader([]byte("unpinned"))), nil
+	})
+	if err != nil || digest != digestOf([]byte("unpinned")) {
+		t.Fatalf("Fetch() = %s, %v", digest, err)
+	}
+	if has, _ := store.Has(context.Background(), digest); !has || path == "" {
+		t.Error("store lost the unpinned blob")
+	}
+	if err := store.Remove(context.Background(), digest); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.Lookup(digest); ok {
+		t.Error("Lookup() found a removed blob")
+	}
+}
+
+func TestServeRefusesOutsiders(t *testing.T) {
+	content := []byte("fleet only")
+	member := newSigner(t)
+	peer, served := servingPeer(t, content, member.Address())
+
+	outsider := NewFetcher(openStore(t), newSigner(t), []string{peer.URL}, nil)
+	if _, _, err := outsider.Fetch(context.Background(), digestOf(content), noOrigin); err == nil {
+		t.Fatal("Fetch() by a runner outside the fleet succeeded")
+	}
+
+	// A member's request replayed long after it was signed is refused too
+	clk := clocktest.NewFake(time.Now().Add(-time.Hour))
+	stale := NewFetcher(openStore(t), member, []string{peer.URL}, nil)
+	stale.SetClock(clk)
+	if _, _, err := stale.Fetch(context.Background(), digestOf(content), noOrigin); err == nil {
+		t.Fatal("Fetch() with a stale signature succeeded")
+	}
+
+	resp, err := http.Get(peer.URL + apiPrefix + "/" + digestOf(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("unsigned request answered %d, want 403", resp.StatusCode)
+	}
+	if stats := served.Stats(); stats.Served != 0 || stats.Refused != 3 {
+		t.Errorf("peer Stats() = %+v, want three refusals", stats)
+	}
+}
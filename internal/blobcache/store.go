@@ -0,0 +1,162 @@
+// Package blobcache keeps image tarballs by their SHA-256 digest, for
+// runners whose Docker daemon cannot be pointed at a registry mirror. A
+// tarball a task downloads from its DockerImageURL is kept once, fetched
+// from other runners on the LAN that already hold it before its origin,
+// and served to those runners in turn. Whatever the source, a blob is
+// only kept once its content matches its digest.
+package blobcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/caches"
+)
+
+const digestPrefix = "sha256:"
+
+var (
+	ErrInvalidDigest  = errors.New("invalid blob digest")
+	ErrDigestMismatch = errors.New("blob does not match its digest")
+)
+
+var hexPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ParseDigest returns the hex SHA-256 of digest, which is written as
+// sha256:<hex>
+func ParseDigest(digest string) (string, error) {
+	sum, ok := strings.CutPrefix(digest, digestPrefix)
+	if !ok || !hexPattern.MatchString(sum) {
+		return "", fmt.Errorf("%w %q", ErrInvalidDigest, digest)
+	}
+	return sum, nil
+}
+
+// Store keeps blobs as read-only files named by their digest in a directory
+type Store struct {
+	dir string
+}
+
+// Open keeps blobs in dir, creating it when missing
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create blob cache: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) path(sum string) string {
+	return filepath.Join(s.dir, sum)
+}
+
+// Lookup returns the file holding the blob with digest, if kept, marking
+// it used
+func (s *Store) Lookup(digest string) (string, bool) {
+	sum, err := ParseDigest(digest)
+	if err != nil {
+		return "", false
+	}
+	path := s.path(sum)
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return path, true
+}
+
+// Write keeps the content of r, returning the file and digest it is kept
+// under. With want set, content that does not match it is discarded with
+// ErrDigestMismatch; without, the blob is kept under the digest it has.
+func (s *Store) Write(r io.Reader, want string) (string, string, error) {
+	if want != "" {
+		if _, err := ParseDigest(want); err != nil {
+			return "", "", err
+		}
+	}
+	tmp, err := os.CreateTemp(s.dir, ".*.partial")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, hash), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if want != "" && digestPrefix+sum != want {
+		return "", "", fmt.Errorf("%w: got sha256:%s, want %s", ErrDigestMismatch, sum, want)
+	}
+	path := s.path(sum)
+	if err := os.Chmod(tmp.Name(), 0o444); err != nil {
+		return "", "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	return path, digestPrefix + sum, nil
+}
+
+// Entries implements caches.Store; entries are keyed by digest
+func (s *Store) Entries(ctx context.Context) ([]caches.Entry, error) {
+	dirEntries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob cache: %w", err)
+	}
+	var entries []caches.Entry
+	for _, de := range dirEntries {
+		if !de.Type().IsRegular() || !hexPattern.MatchString(de.Name()) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, caches.Entry{
+			Key:      digestPrefix + de.Name(),
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		})
+	}
+	return entries, nil
+}
+
+// Has implements caches.Store
+func (s *Store) Has(ctx context.Context, key string) (bool, error) {
+	sum, err := ParseDigest(key)
+	if err != nil {
+		return false, nil
+	}
+	info, err := os.Stat(s.path(sum))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil && info.Mode().IsRegular(), err
+}
+
+// Remove implements caches.Store
+func (s *Store) Remove(ctx context.Context, key string) error {
+	sum, err := ParseDigest(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(sum)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove cached blob: %w", err)
+	}
+	return nil
+}
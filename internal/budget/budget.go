@@ -0,0 +1,279 @@
+// Package budget caps the compute a runner contributes in each day and week.
+// Usage is charged as tasks finish; once a window's budget is spent the
+// runner declines new tasks until the window resets.
+package budget
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ErrExhausted is returned for tasks the remaining budget does not allow
+var ErrExhausted = errors.New("compute budget exhausted")
+
+// Limits caps the compute used in one window; a zero limit leaves that
+// resource uncapped
+type Limits struct {
+	CPUHours float64
+	GPUHours float64
+}
+
+func (l Limits) set() bool {
+	return l.CPUHours > 0 || l.GPUHours > 0
+}
+
+// Config sets the daily and weekly budgets. Windows reset at midnight in
+// Location, weekly ones at the start of Monday.
+type Config struct {
+	Daily    Limits
+	Weekly   Limits
+	Location *time.Location
+}
+
+// Usage is compute used, in seconds of CPU and GPU time
+type Usage struct {
+	CPUSeconds float64 `json:"cpu_seconds"`
+	GPUSeconds float64 `json:"gpu_seconds"`
+}
+
+type window struct {
+	name   string
+	limits Limits
+	Start  time.Time `json:"start"`
+	Used   Usage     `json:"used"`
+}
+
+func (w *window) cpuExhausted() bool {
+	return w.limits.CPUHours > 0 && w.Used.CPUSeconds >= w.limits.CPUHours*3600
+}
+
+func (w *window) gpuExhausted() bool {
+	return w.limits.GPUHours > 0 && w.Used.GPUSeconds >= w.limits.GPUHours*3600
+}
+
+// state is what the tracker keeps on disk
+type state struct {
+	Daily  *window `json:"daily"`
+	Weekly *window `json:"weekly"`
+}
+
+// Tracker charges task usage against the budgets
+type Tracker struct {
+	config Config
+	path   string
+	clock  clock.Clock
+
+	mu        sync.Mutex
+	daily     window
+	weekly    window
+	exhausted bool
+}
+
+// New returns a tracker that keeps usage in the file at path, so that
+// restarting the runner does not reset its budget. An empty path keeps
+// usage in memory only.
+func New(config Config, path string) (*Tracker, error) {
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+	t := &Tracker{
+		config: config,
+		path:   path,
+		clock:  clock.Real(),
+		daily:  window{name: "daily", limits: config.Daily},
+		weekly: window{name: "weekly", limits: config.Weekly},
+	}
+	if path == "" {
+		return t, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read budget usage: %w", err)
+	}
+	var saved state
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("invalid budget usage file: %w", err)
+	}
+	if saved.Daily != nil {
+		t.daily.Start, t.daily.Used = saved.Daily.Start, saved.Daily.Used
+	}
+	if saved.Weekly != nil {
+		t.weekly.Start, t.weekly.Used = saved.Weekly.Start, saved.Weekly.Used
+	}
+	return t, nil
+}
+
+// SetClock replaces the clock used to place usage in windows
+func (t *Tracker) SetClock(c clock.Clock) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clock = c
+}
+
+func (t *Tracker) dayStart(now time.Time) time.Time {
+	now = now.In(t.config.Location)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, t.config.Location)
+}
+
+func (t *Tracker) weekStart(now time.Time) time.Time {
+	day := t.dayStart(now)
+	daysSinceMonday := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -daysSinceMonday)
+}
+
+// roll starts new windows for now, discarding the usage of windows that
+// have ended
+func (t *Tracker) roll(now time.Time) {
+	t.rollWindow(&t.daily, t.dayStart(now))
+	t.rollWindow(&t.weekly, t.weekStart(now))
+
+	if t.exhausted && !t.cpuExhausted() {
+		t.exhausted = false
+		log := gologger.WithComponent("budget")
+		log.Info().Msg("Compute budget window reset, accepting tasks")
+	}
+}
+
+func (t *Tracker) rollWindow(w *window, start time.Time) {
+	if w.Start.Equal(start) {
+		return
+	}
+	w.Start = start
+	w.Used = Usage{}
+}
+
+func (t *Tracker) cpuExhausted() bool {
+	return t.daily.cpuExhausted() || t.weekly.cpuExhausted()
+}
+
+func (t *Tracker) resetsAt(w *window) time.Time {
+	if w == &t.weekly {
+		return w.Start.AddDate(0, 0, 7)
+	}
+	return w.Start.AddDate(0, 0, 1)
+}
+
+// Charge accounts for a task that ran from start to end and used usage.
+// Usage is spread evenly over the run, so a task that straddles a reset
+// counts only the share it used after the reset against the new window.
+func (t *Tracker) Charge(start, end time.Time, usage Usage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.roll(t.clock.Now())
+	for _, w := range []*window{&t.daily, &t.weekly} {
+		share := 1.0
+		switch {
+		case end.Before(w.Start):
+			share = 0
+		case start.Before(w.Start):
+			share = float64(end.Sub(w.Start)) / float64(end.Sub(start))
+		}
+		w.Used.CPUSeconds += usage.CPUSeconds * share
+		w.Used.GPUSeconds += usage.GPUSeconds * share
+	}
+
+	if !t.exhausted && t.cpuExhausted() {
+		t.exhausted = true
+		log := gologger.WithComponent("budget")
+		log.Info().
+			Time("resets_at", t.resumesAt()).
+			Msg("Compute budget exhausted, declining tasks until the window resets")
+	}
+
+	if err := t.save(); err != nil {
+		log := gologger.WithComponent("budget")
+		log.Warn().Err(err).Msg("Failed to save budget usage")
+	}
+}
+
+// resumesAt returns when the exhausted CPU budgets next allow a task
+func (t *Tracker) resumesAt() time.Time {
+	var resume time.Time
+	for _, w := range []*window{&t.daily, &t.weekly} {
+		if w.cpuExhausted() && t.resetsAt(w).After(resume) {
+			resume = t.resetsAt(w)
+		}
+	}
+	return resume
+}
+
+// Admit returns an error wrapping ErrExhausted if the budget does not allow
+// another task, or another GPU task when usesGPU is set
+func (t *Tracker) Admit(usesGPU bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.roll(t.clock.Now())
+	for _, w := range []*window{&t.daily, &t.weekly} {
+		if w.cpuExhausted() {
+			return fmt.Errorf("%w: %s CPU budget of %g hours used, resets at %s",
+				ErrExhausted, w.name, w.limits.CPUHours, t.resetsAt(w).Format(time.RFC3339))
+		}
+		if usesGPU && w.gpuExhausted() {
+			return fmt.Errorf("%w: %s GPU budget of %g hours used, resets at %s",
+				ErrExhausted, w.name, w.limits.GPUHours, t.resetsAt(w).Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// Status returns the usage of each budgeted window
+func (t *Tracker) Status() *models.ComputeBudget {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.roll(t.clock.Now())
+	status := &models.ComputeBudget{Exhausted: t.cpuExhausted()}
+	for _, w := range []*window{&t.daily, &t.weekly} {
+		if !w.limits.set() {
+			continue
+		}
+		status.GPUExhausted = status.GPUExhausted || w.gpuExhausted()
+		status.Windows = append(status.Windows, models.BudgetWindow{
+			Window:        w.name,
+			StartedAt:     w.Start,
+			ResetsAt:      t.resetsAt(w),
+			CPUHours:      w.Used.CPUSeconds / 3600,
+			CPUHoursLimit: w.limits.CPUHours,
+			GPUHours:      w.Used.GPUSeconds / 3600,
+			GPUHoursLimit: w.limits.GPUHours,
+		})
+	}
+	if status.Exhausted {
+		status.ResumesAt = t.resumesAt()
+	}
+	return status
+}
+
+func (t *Tracker) save() error {
+	if t.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(state{Daily: &t.daily, Weekly: &t.weekly})
+	if err != nil {
+		return fmt.Errorf("failed to marshal budget usage: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create budget directory: %w", err)
+	}
+	if err := atrest.WriteFileAtomic(t.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write budget usage: %w", err)
+	}
+	return nil
+}
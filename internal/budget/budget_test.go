@@ -0,0 +1,177 @@
+package budget
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// tuesday is 10:00 UTC on a Tuesday
+var tuesday = time.Date(2026, 10, 13, 10, 0, 0, 0, time.UTC)
+
+func newTracker(t *testing.T, config Config, path string, now time.Time) (*Tracker, *clocktest.Fake) {
+	t.Helper()
+	config.Location = time.UTC
+	tracker, err := New(config, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clocktest.NewFake(now)
+	tracker.SetClock(clk)
+	return tracker, clk
+}
+
+func hours(h float64) time.Duration {
+	return time.Duration(h * float64(time.Hour))
+}
+
+func windowStatus(t *testing.T, status *models.ComputeBudget, name string) models.BudgetWindow {
+	t.Helper()
+	for _, w := range status.Windows {
+		if w.Window == name {
+			return w
+		}
+	}
+	t.Fatalf("no %s window in %+v", name, status)
+	return models.BudgetWindow{}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestDailyBudgetResetsAtMidnight(t *testing.T) {
+	tracker, clk := newTracker(t, Config{Daily: Limits{CPUHours: 4}}, "", tuesday)
+
+	start := clk.Now()
+	clk.Advance(3 * time.Hour)
+	tracker.Charge(start, clk.Now(), Usage{CPUSeconds: 3 * 3600})
+	if err := tracker.Admit(false); err != nil {
+		t.Fatalf("Admit() with 1 hour left error = %v", err)
+	}
+
+	start = clk.Now()
+	clk.Advance(2 * time.Hour)
+	tracker.Charge(start, clk.Now(), Usage{CPUSeconds: 2 * 3600})
+	if err := tracker.Admit(false); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("Admit() over budget error = %v, want ErrExhausted", err)
+	}
+
+	midnight := time.Date(2026, 10, 14, 0, 0, 0, 0, time.UTC)
+	status := tracker.Status()
+	if !status.Exhausted || !status.ResumesAt.Equal(midnight) {
+		t.Fatalf("Status() = %+v, want exhausted until %s", status, midnight)
+	}
+	if used := windowStatus(t, status, "daily").CPUHours; !near(used, 5) {
+		t.Errorf("daily CPU hours = %g, want 5", used)
+	}
+
+	clk.Advance(midnight.Sub(clk.Now()))
+	if err := tracker.Admit(false); err != nil {
+		t.Fatalf("Admit() after reset error = %v", err)
+	}
+	if used := windowStatus(t, tracker.Status(), "daily").CPUHours; used != 0 {
+		t.Errorf("daily CPU hours after reset = %g, want 0", used)
+	}
+}
+
+func TestChargeSplitsTasksStraddlingReset(t *testing.T) {
+	config := Config{Daily: Limits{CPUHours: 10}, Weekly: Limits{CPUHours: 50}}
+	tracker, clk := newTracker(t, config, "", time.Date(2026, 10, 13, 23, 0, 0, 0, time.UTC))
+
+	start := clk.Now()
+	clk.Advance(2 * time.Hour)
+	tracker.Charge(start, clk.Now(), Usage{CPUSeconds: 4 * 3600})
+
+	status := tracker.Status()
+	if used := windowStatus(t, status, "daily").CPUHours; !near(used, 2) {
+		t.Errorf("daily CPU hours = %g, want the 2 used after midnight", used)
+	}
+	if used := windowStatus(t, status, "weekly").CPUHours; !near(used, 4) {
+		t.Errorf("weekly CPU hours = %g, want 4", used)
+	}
+
+	// A task straddling the start of the week counts its Monday share only
+	tracker, clk = newTracker(t, config, "", time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC))
+	start = clk.Now()
+	clk.Advance(4 * time.Hour)
+	tracker.Charge(start, clk.Now(), Usage{CPUSeconds: 4 * 3600})
+
+	status = tracker.Status()
+	weekly := windowStatus(t, status, "weekly")
+	if !near(weekly.CPUHours, 3) {
+		t.Errorf("weekly CPU hours = %g, want 3", weekly.CPUHours)
+	}
+	if monday := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC); !weekly.StartedAt.Equal(monday) {
+		t.Errorf("weekly window started at %s, want %s", weekly.StartedAt, monday)
+	}
+}
+
+func TestWeeklyBudgetOutlastsDailyReset(t *testing.T) {
+	config := Config{Daily: Limits{CPUHours: 8}, Weekly: Limits{CPUHours: 12}}
+	tracker, clk := newTracker(t, config, "", tuesday)
+
+	for i := 0; i < 2; i++ {
+		start := clk.Now()
+		clk.Advance(hours(6.5))
+		tracker.Charge(start, clk.Now(), Usage{CPUSeconds: 6.5 * 3600})
+		clk.Advance(24*time.Hour - hours(6.5))
+	}
+
+	err := tracker.Admit(false)
+	if !errors.Is(err, ErrExhausted) {
+		t.Fatalf("Admit() over the weekly budget error = %v, want ErrExhausted", err)
+	}
+	monday := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	if resumes := tracker.Status().ResumesAt; !resumes.Equal(monday) {
+		t.Errorf("ResumesAt = %s, want %s", resumes, monday)
+	}
+
+	clk.Advance(monday.Sub(clk.Now()))
+	if err := tracker.Admit(false); err != nil {
+		t.Fatalf("Admit() in a new week error = %v", err)
+	}
+}
+
+func TestGPUBudgetOnlyBlocksGPUTasks(t *testing.T) {
+	tracker, clk := newTracker(t, Config{Daily: Limits{CPUHours: 10, GPUHours: 1}}, "", tuesday)
+
+	start := clk.Now()
+	clk.Advance(hours(1.5))
+	tracker.Charge(start, clk.Now(), Usage{CPUSeconds: 1.5 * 3600, GPUSeconds: 1.5 * 3600})
+
+	if err := tracker.Admit(true); !errors.Is(err, ErrExhausted) {
+		t.Errorf("Admit(gpu) error = %v, want ErrExhausted", err)
+	}
+	if err := tracker.Admit(false); err != nil {
+		t.Errorf("Admit(cpu) error = %v", err)
+	}
+	if status := tracker.Status(); status.Exhausted || !status.GPUExhausted {
+		t.Errorf("Status() = %+v, want only the GPU budget exhausted", status)
+	}
+}
+
+func TestUsageSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "budget.json")
+	config := Config{Daily: Limits{CPUHours: 2}}
+
+	tracker, clk := newTracker(t, config, path, tuesday)
+	start := clk.Now()
+	clk.Advance(2 * time.Hour)
+	tracker.Charge(start, clk.Now(), Usage{CPUSeconds: 2 * 3600})
+
+	restarted, _ := newTracker(t, config, path, clk.Now())
+	if err := restarted.Admit(false); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("Admit() after restart error = %v, want ErrExhausted", err)
+	}
+
+	nextDay, _ := newTracker(t, config, path, tuesday.Add(24*time.Hour))
+	if err := nextDay.Admit(false); err != nil {
+		t.Fatalf("Admit() after restart on the next day error = %v", err)
+	}
+}
@@ -0,0 +1,304 @@
+package caches
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/events"
+)
+
+// hitDecay is applied to hit counts after every rebalance so arbitration
+// follows recent demand rather than lifetime totals
+const hitDecay = 0.5
+
+// Demand describes one cache to budget arbitration
+type Demand struct {
+	Name  string
+	Usage int64
+	// Hits is the recent, decayed count of lookups the cache served
+	Hits float64
+}
+
+// Arbitrate splits budget bytes between caches. Every cache is guaranteed an
+// equal part of minShare of the budget; the rest is divided in proportion to
+// recent hits, so space flows to the caches that are saving the most
+// downloads. A cache using less than its proportional share keeps only what
+// it uses and the surplus is divided among the others. The quotas always add
+// up to the budget.
+func Arbitrate(budget int64, minShare float64, demands []Demand) map[string]int64 {
+	quotas := make(map[string]int64, len(demands))
+	if len(demands) == 0 {
+		return quotas
+	}
+	if budget < 0 {
+		budget = 0
+	}
+	if minShare < 0 {
+		minShare = 0
+	} else if minShare > 1 {
+		minShare = 1
+	}
+
+	floor := int64(float64(budget) * minShare / float64(len(demands)))
+	remaining := budget
+	for _, d := range demands {
+		quotas[d.Name] = floor
+		remaining -= floor
+	}
+
+	// One hit of smoothing keeps caches that have not been used lately from
+	// being starved entirely
+	weight := func(d Demand) float64 { return d.Hits + 1 }
+
+	active := demands
+	for remaining > 0 && len(active) > 0 {
+		var total float64
+		for _, d := range active {
+			total += weight(d)
+		}
+
+		var short []Demand
+		var spent int64
+		for _, d := range active {
+			share := int64(float64(remaining) * weight(d) / total)
+			need := d.Usage - quotas[d.Name]
+			if need > share {
+				short = append(short, d)
+				continue
+			}
+			if need > 0 {
+				quotas[d.Name] += need
+				spent += need
+			}
+		}
+
+		if len(short) == len(active) {
+			// Every cache wants more than its share; split what is left
+			for _, d := range active {
+				share := int64(float64(remaining) * weight(d) / total)
+				quotas[d.Name] += share
+				spent += share
+			}
+			remaining -= spent
+			break
+		}
+		remaining -= spent
+		active = short
+	}
+
+	// Space no cache currently needs is handed out by weight so caches can
+	// grow until the next rebalance; rounding leftovers go to the heaviest
+	if remaining > 0 {
+		var total float64
+		heaviest := demands[0]
+		for _, d := range demands {
+			total += weight(d)
+			if weight(d) > weight(heaviest) {
+				heaviest = d
+			}
+		}
+		left := remaining
+		for _, d := range demands {
+			share := int64(float64(remaining) * weight(d) / total)
+			quotas[d.Name] += share
+			left -= share
+		}
+		quotas[heaviest.Name] += left
+	}
+	return quotas
+}
+
+// SetBudget enables arbitration of budget bytes of disk between the caches,
+// each guaranteed an equal part of minShare of it. A zero budget disables
+// arbitration.
+func (r *Registry) SetBudget(budget int64, minShare float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.budget = budget
+	r.minShare = minShare
+}
+
+// RebalanceResult reports the quotas set by a rebalance and what was evicted
+// to meet them
+type RebalanceResult struct {
+	Quotas     map[string]int64 `json:"quotas"`
+	Evicted    []Ref            `json:"evicted,omitempty"`
+	FreedBytes int64            `json:"freed_bytes"`
+}
+
+// Rebalance recomputes the cache quotas from current usage and recent hits,
+// then evicts least recently used entries from caches above their quota.
+// Pinned entries and entries held by in-flight tasks are never evicted.
+func (r *Registry) Rebalance(ctx context.Context) (*RebalanceResult, error) {
+	log := gologger.WithComponent("caches")
+
+	r.mu.Lock()
+	budget, minShare, bus := r.budget, r.minShare, r.events
+	r.mu.Unlock()
+
+	result := &RebalanceResult{Quotas: map[string]int64{}}
+	if budget <= 0 {
+		return result, nil
+	}
+
+	listed := make(map[string][]Entry)
+	var demands []Demand
+	for _, name := range r.Names() {
+		entries, err := r.List(ctx, name)
+		if err != nil {
+			// A cache that cannot be listed keeps its previous quota and is
+			// left out of this round
+			log.Warn().Err(err).Str("cache", name).Msg("Skipping cache in rebalance")
+			continue
+		}
+		d := Demand{Name: name}
+		for _, e := range entries {
+			d.Usage += e.Size
+		}
+		r.mu.Lock()
+		d.Hits = r.stores[name].hits
+		r.mu.Unlock()
+		listed[name] = entries
+		demands = append(demands, d)
+	}
+
+	result.Quotas = Arbitrate(budget, minShare, demands)
+
+	r.mu.Lock()
+	for _, ms := range r.stores {
+		ms.hits *= hitDecay
+		ms.lookups *= hitDecay
+	}
+	for name, quota := range result.Quotas {
+		r.stores[name].quota = quota
+	}
+	r.mu.Unlock()
+
+	for _, d := range demands {
+		over := d.Usage - result.Quotas[d.Name]
+		if over <= 0 {
+			continue
+		}
+
+		entries := listed[d.Name]
+		sort.Slice(entries, func(i, j int) bool { return entries[i].LastUsed.Before(entries[j].LastUsed) })
+		for _, e := range entries {
+			if over <= 0 {
+				break
+			}
+			if e.Pinned || e.InUse {
+				continue
+			}
+			removed, _, err := r.remove(ctx, d.Name, e.Key)
+			if err != nil {
+				log.Warn().Err(err).Str("cache", d.Name).Str("key", e.Key).Msg("Failed to evict cache entry")
+				continue
+			}
+			if !removed {
+				continue
+			}
+			over -= e.Size
+			result.FreedBytes += e.Size
+			result.Evicted = append(result.Evicted, Ref{Cache: d.Name, Key: e.Key})
+			bus.Publish(events.CacheEvicted{Cache: d.Name, Key: e.Key, Bytes: e.Size})
+		}
+		if over > 0 {
+			log.Warn().
+				Str("cache", d.Name).
+				Int64("quota_bytes", result.Quotas[d.Name]).
+				Int64("over_bytes", over).
+				Msg("Cache remains over quota - remaining entries are pinned or in use")
+		}
+	}
+
+	if len(result.Evicted) > 0 {
+		r.persist()
+		log.Info().
+			Int("evicted", len(result.Evicted)).
+			Int64("freed_bytes", result.FreedBytes).
+			Msg("Rebalanced cache quotas")
+	}
+	return result, nil
+}
+
+// Evict removes least recently used entries across all caches, whatever
+// their quotas, until bytes are freed, returning the bytes it freed. It
+// makes room for a task that would not fit on disk otherwise. Pinned
+// entries and entries held by in-flight tasks are never evicted.
+func (r *Registry) Evict(ctx context.Context, bytes int64) (int64, error) {
+	log := gologger.WithComponent("caches")
+
+	r.mu.Lock()
+	bus := r.events
+	r.mu.Unlock()
+
+	type candidate struct {
+		cache string
+		entry Entry
+	}
+	var candidates []candidate
+	for _, name := range r.Names() {
+		entries, err := r.List(ctx, name)
+		if err != nil {
+			log.Warn().Err(err).Str("cache", name).Msg("Skipping cache in eviction")
+			continue
+		}
+		for _, e := range entries {
+			if !e.Pinned && !e.InUse {
+				candidates = append(candidates, candidate{cache: name, entry: e})
+			}
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].entry.LastUsed.Before(candidates[j].entry.LastUsed)
+	})
+
+	var freed int64
+	for _, c := range candidates {
+		if freed >= bytes {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return freed, err
+		}
+		removed, _, err := r.remove(ctx, c.cache, c.entry.Key)
+		if err != nil {
+			log.Warn().Err(err).Str("cache", c.cache).Str("key", c.entry.Key).Msg("Failed to evict cache entry")
+			continue
+		}
+		if !removed {
+			continue
+		}
+		freed += c.entry.Size
+		bus.Publish(events.CacheEvicted{Cache: c.cache, Key: c.entry.Key, Bytes: c.entry.Size})
+	}
+	if freed > 0 {
+		r.persist()
+	}
+	return freed, nil
+}
+
+// Run rebalances the caches every interval until ctx is cancelled
+func (r *Registry) Run(ctx context.Context, interval time.Duration) {
+	log := gologger.WithComponent("caches")
+
+	r.mu.Lock()
+	clk := r.clock
+	r.mu.Unlock()
+
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Rebalance(ctx); err != nil {
+			log.Warn().Err(err).Msg("Cache rebalance failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
@@ -0,0 +1,567 @@
+package caches
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/events"
+)
+
+// Names of the caches the runner registers
+const (
+	Images    = "images"
+	Models    = "models"
+	Datasets  = "datasets"
+	Artifacts = "artifacts"
+	Inputs    = "inputs"
+	// ImageBlobs are image tarballs kept by digest
+	ImageBlobs = "image_blobs"
+)
+
+var (
+	ErrUnknownCache = errors.New("unknown cache")
+	ErrNotFound     = errors.New("cache entry not found")
+	ErrEmptyPurge   = errors.New("purge needs keys, all or an age")
+)
+
+// Admin is implemented by the registry and by Client, so cache
+// administration works the same against a running runner or the local disk
+type Admin interface {
+	Summaries(ctx context.Context) ([]Summary, error)
+	List(ctx context.Context, name string) ([]Entry, error)
+	Pin(ctx context.Context, name, key string) error
+	Unpin(ctx context.Context, name, key string) error
+	Purge(ctx context.Context, name string, req PurgeRequest) (*PurgeResult, error)
+}
+
+// Entry is one item held by a cache
+type Entry struct {
+	Key      string    `json:"key"`
+	Size     int64     `json:"size_bytes"`
+	LastUsed time.Time `json:"last_used,omitempty"`
+	Pinned   bool      `json:"pinned"`
+	InUse    bool      `json:"in_use"`
+}
+
+// Store is the disk-backed cache behind a registry entry. Stores report
+// their own entries; pins and in-use tracking are kept by the registry.
+type Store interface {
+	Entries(ctx context.Context) ([]Entry, error)
+	Has(ctx context.Context, key string) (bool, error)
+	Remove(ctx context.Context, key string) error
+}
+
+// StoreOptions adjust how the registry manages a store
+type StoreOptions struct {
+	// TrackedOnly limits the cache to entries the runner has used, leaving
+	// other content of a shared store, such as unrelated Docker images, alone
+	TrackedOnly bool
+}
+
+// Ref names an entry of a registered cache
+type Ref struct {
+	Cache string `json:"cache"`
+	Key   string `json:"key"`
+}
+
+type managedStore struct {
+	store   Store
+	opts    StoreOptions
+	hits    float64
+	lookups float64
+	quota   int64
+}
+
+// persistedEntry is the registry state kept for one entry across restarts
+type persistedEntry struct {
+	Cache    string    `json:"cache"`
+	Key      string    `json:"key"`
+	Pinned   bool      `json:"pinned,omitempty"`
+	LastUsed time.Time `json:"last_used,omitempty"`
+}
+
+// Registry puts the runner's caches behind one interface so they can be
+// listed, pinned and purged together, and arbitrates a shared disk budget
+// between them
+type Registry struct {
+	path  string
+	clock clock.Clock
+
+	persistMu sync.Mutex
+	mu        sync.Mutex
+	stores    map[string]*managedStore
+	pinned    map[Ref]bool
+	lastUsed  map[Ref]time.Time
+	inUse     map[Ref]int
+	removing  map[Ref]chan struct{}
+	budget    int64
+	minShare  float64
+	events    *events.Bus
+}
+
+// OpenRegistry loads pins and usage times from path, starting empty when it
+// does not exist. An empty path keeps the state in memory only.
+func OpenRegistry(path string) (*Registry, error) {
+	r := &Registry{
+		path:     path,
+		clock:    clock.Real(),
+		stores:   make(map[string]*managedStore),
+		pinned:   make(map[Ref]bool),
+		lastUsed: make(map[Ref]time.Time),
+		inUse:    make(map[Ref]int),
+		removing: make(map[Ref]chan struct{}),
+	}
+	if path == "" {
+		return r, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache state directory: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return r, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache state: %w", err)
+	}
+	var entries []persistedEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode cache state: %w", err)
+	}
+	for _, e := range entries {
+		ref := Ref{Cache: e.Cache, Key: e.Key}
+		if e.Pinned {
+			r.pinned[ref] = true
+		}
+		if !e.LastUsed.IsZero() {
+			r.lastUsed[ref] = e.LastUsed
+		}
+	}
+	return r, nil
+}
+
+// SetClock replaces the clock used for usage times and rebalancing
+func (r *Registry) SetClock(c clock.Clock) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clock = c
+}
+
+// SetEventBus publishes CacheEvicted on bus for each entry evicted to keep
+// the caches within budget
+func (r *Registry) SetEventBus(bus *events.Bus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = bus
+}
+
+// Register adds store under name, replacing any store registered before
+func (r *Registry) Register(name string, store Store, opts StoreOptions) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stores[name] = &managedStore{store: store, opts: opts}
+}
+
+// Names returns the registered caches in name order
+func (r *Registry) Names() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.stores))
+	for name := range r.stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Registry) lookup(name string) (*managedStore, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ms, ok := r.stores[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCache, name)
+	}
+	return ms, nil
+}
+
+// Acquire marks refs as needed by an in-flight task until the returned
+// release is called. Held entries are never purged or evicted. Each ref also
+// counts as a lookup, a hit when the entry is already cached, for budget
+// arbitration. Refs to unregistered caches are ignored.
+func (r *Registry) Acquire(ctx context.Context, refs ...Ref) func() {
+	log := gologger.WithComponent("caches")
+
+	var held []Ref
+	for _, ref := range refs {
+		if ref.Key == "" {
+			continue
+		}
+		ms, err := r.lookup(ref.Cache)
+		if err != nil {
+			continue
+		}
+		r.hold(ref)
+		held = append(held, ref)
+
+		hit, err := ms.store.Has(ctx, ref.Key)
+		if err != nil {
+			log.Debug().Err(err).Str("cache", ref.Cache).Str("key", ref.Key).Msg("Failed to look up cache entry")
+		}
+		r.mu.Lock()
+		ms.lookups++
+		if hit {
+			ms.hits++
+		}
+		r.mu.Unlock()
+	}
+	if len(held) > 0 {
+		r.persist()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			now := r.clock.Now()
+			for _, ref := range held {
+				if r.inUse[ref]--; r.inUse[ref] <= 0 {
+					delete(r.inUse, ref)
+				}
+				r.lastUsed[ref] = now
+			}
+		})
+	}
+}
+
+// hold takes an in-use reference on ref, first waiting out any removal of it
+// that is already underway
+func (r *Registry) hold(ref Ref) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for {
+		done, ok := r.removing[ref]
+		if !ok {
+			break
+		}
+		r.mu.Unlock()
+		<-done
+		r.mu.Lock()
+	}
+	r.inUse[ref]++
+	r.lastUsed[ref] = r.clock.Now()
+}
+
+// List returns the entries of cache name, most recently used first
+func (r *Registry) List(ctx context.Context, name string) ([]Entry, error) {
+	ms, err := r.lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ms.store.Entries(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s cache: %w", name, err)
+	}
+
+	r.mu.Lock()
+	listed := entries[:0]
+	for _, e := range entries {
+		ref := Ref{Cache: name, Key: e.Key}
+		used, tracked := r.lastUsed[ref]
+		if ms.opts.TrackedOnly && !tracked {
+			continue
+		}
+		if used.After(e.LastUsed) {
+			e.LastUsed = used
+		}
+		e.Pinned = r.pinned[ref]
+		e.InUse = r.inUse[ref] > 0
+		listed = append(listed, e)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(listed, func(i, j int) bool {
+		if !listed[i].LastUsed.Equal(listed[j].LastUsed) {
+			return listed[i].LastUsed.After(listed[j].LastUsed)
+		}
+		return listed[i].Key < listed[j].Key
+	})
+	return listed, nil
+}
+
+// Summary describes the state of one cache
+type Summary struct {
+	Name        string  `json:"name"`
+	Entries     int     `json:"entries"`
+	UsageBytes  int64   `json:"usage_bytes"`
+	PinnedBytes int64   `json:"pinned_bytes"`
+	QuotaBytes  int64   `json:"quota_bytes,omitempty"`
+	HitRate     float64 `json:"hit_rate"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// Summaries reports usage of every registered cache. A cache that cannot be
+// listed is reported with its error rather than failing the whole call.
+func (r *Registry) Summaries(ctx context.Context) ([]Summary, error) {
+	var summaries []Summary
+	for _, name := range r.Names() {
+		s := Summary{Name: name}
+		entries, err := r.List(ctx, name)
+		if err != nil {
+			s.Error = err.Error()
+		}
+		for _, e := range entries {
+			s.Entries++
+			s.UsageBytes += e.Size
+			if e.Pinned {
+				s.PinnedBytes += e.Size
+			}
+		}
+
+		r.mu.Lock()
+		if ms, ok := r.stores[name]; ok {
+			s.QuotaBytes = ms.quota
+			if ms.lookups > 0 {
+				s.HitRate = ms.hits / ms.lookups
+			}
+		}
+		r.mu.Unlock()
+		summaries = append(summaries, s)
+	}
+	return summaries, nil
+}
+
+// Pin protects key in cache name from purges and budget eviction
+func (r *Registry) Pin(ctx context.Context, name, key string) error {
+	ms, err := r.lookup(name)
+	if err != nil {
+		return err
+	}
+	ok, err := ms.store.Has(ctx, key)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s in %s cache: %w", key, name, err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s in %s cache", ErrNotFound, key, name)
+	}
+
+	r.mu.Lock()
+	ref := Ref{Cache: name, Key: key}
+	r.pinned[ref] = true
+	if _, ok := r.lastUsed[ref]; !ok {
+		r.lastUsed[ref] = r.clock.Now()
+	}
+	r.mu.Unlock()
+	return r.persist()
+}
+
+// Unpin makes key in cache name evictable again
+func (r *Registry) Unpin(ctx context.Context, name, key string) error {
+	if _, err := r.lookup(name); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	ref := Ref{Cache: name, Key: key}
+	if !r.pinned[ref] {
+		r.mu.Unlock()
+		return fmt.Errorf("%w: %s is not pinned in %s cache", ErrNotFound, key, name)
+	}
+	delete(r.pinned, ref)
+	r.mu.Unlock()
+	return r.persist()
+}
+
+// PurgeRequest selects the entries of a cache to purge. Keys takes
+// precedence, then All, then OlderThan.
+type PurgeRequest struct {
+	Keys      []string      `json:"keys,omitempty"`
+	All       bool          `json:"all,omitempty"`
+	OlderThan time.Duration `json:"older_than,omitempty"`
+}
+
+// Skipped is an entry a purge left in place
+type Skipped struct {
+	Key    string `json:"key"`
+	Reason string `json:"reason"`
+}
+
+// Reasons an entry was not purged
+const (
+	SkipPinned   = "pinned"
+	SkipInUse    = "in_use"
+	SkipNotFound = "not_found"
+)
+
+type PurgeResult struct {
+	Cache      string    `json:"cache"`
+	Removed    []Entry   `json:"removed"`
+	Skipped    []Skipped `json:"skipped,omitempty"`
+	FreedBytes int64     `json:"freed_bytes"`
+}
+
+// Purge removes the selected entries of cache name. Pinned entries and
+// entries held by in-flight tasks are skipped, never removed.
+func (r *Registry) Purge(ctx context.Context, name string, req PurgeRequest) (*PurgeResult, error) {
+	if len(req.Keys) == 0 && !req.All && req.OlderThan <= 0 {
+		return nil, ErrEmptyPurge
+	}
+
+	entries, err := r.List(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	result := &PurgeResult{Cache: name, Removed: []Entry{}}
+
+	var selected []Entry
+	switch {
+	case len(req.Keys) > 0:
+		byKey := make(map[string]Entry, len(entries))
+		for _, e := range entries {
+			byKey[e.Key] = e
+		}
+		for _, key := range req.Keys {
+			e, ok := byKey[key]
+			if !ok {
+				result.Skipped = append(result.Skipped, Skipped{Key: key, Reason: SkipNotFound})
+				continue
+			}
+			selected = append(selected, e)
+		}
+	case req.All:
+		selected = entries
+	default:
+		cutoff := r.now().Add(-req.OlderThan)
+		for _, e := range entries {
+			if e.LastUsed.Before(cutoff) {
+				selected = append(selected, e)
+			}
+		}
+	}
+
+	for _, e := range selected {
+		removed, reason, err := r.remove(ctx, name, e.Key)
+		if err != nil {
+			result.Skipped = append(result.Skipped, Skipped{Key: e.Key, Reason: err.Error()})
+			continue
+		}
+		if !removed {
+			result.Skipped = append(result.Skipped, Skipped{Key: e.Key, Reason: reason})
+			continue
+		}
+		result.Removed = append(result.Removed, e)
+		result.FreedBytes += e.Size
+	}
+
+	if len(result.Removed) > 0 {
+		r.persist()
+	}
+	return result, nil
+}
+
+// remove deletes key from cache name unless it is pinned or in use. The
+// entry is marked as being removed so a task acquiring it meanwhile waits
+// and then finds it gone, rather than racing the deletion.
+func (r *Registry) remove(ctx context.Context, name, key string) (bool, string, error) {
+	ms, err := r.lookup(name)
+	if err != nil {
+		return false, "", err
+	}
+	ref := Ref{Cache: name, Key: key}
+
+	r.mu.Lock()
+	if r.pinned[ref] {
+		r.mu.Unlock()
+		return false, SkipPinned, nil
+	}
+	if r.inUse[ref] > 0 {
+		r.mu.Unlock()
+		return false, SkipInUse, nil
+	}
+	if _, ok := r.removing[ref]; ok {
+		r.mu.Unlock()
+		return false, SkipInUse, nil
+	}
+	done := make(chan struct{})
+	r.removing[ref] = done
+	r.mu.Unlock()
+
+	err = ms.store.Remove(ctx, key)
+
+	r.mu.Lock()
+	delete(r.removing, ref)
+	if err == nil {
+		delete(r.lastUsed, ref)
+	}
+	r.mu.Unlock()
+	close(done)
+
+	if err != nil {
+		return false, "", fmt.Errorf("failed to remove %s from %s cache: %w", key, name, err)
+	}
+	return true, "", nil
+}
+
+func (r *Registry) now() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.clock.Now()
+}
+
+// persist writes pins and usage times to the state file
+func (r *Registry) persist() error {
+	if r.path == "" {
+		return nil
+	}
+	r.persistMu.Lock()
+	defer r.persistMu.Unlock()
+
+	r.mu.Lock()
+	refs := make(map[Ref]bool, len(r.lastUsed)+len(r.pinned))
+	for ref := range r.lastUsed {
+		refs[ref] = true
+	}
+	for ref := range r.pinned {
+		refs[ref] = true
+	}
+	entries := make([]persistedEntry, 0, len(refs))
+	for ref := range refs {
+		entries = append(entries, persistedEntry{
+			Cache:    ref.Cache,
+			Key:      ref.Key,
+			Pinned:   r.pinned[ref],
+			LastUsed: r.lastUsed[ref],
+		})
+	}
+	r.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Cache != entries[j].Cache {
+			return entries[i].Cache < entries[j].Cache
+		}
+		return entries[i].Key < entries[j].Key
+	})
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache state: %w", err)
+	}
+	if err := atrest.WriteFileAtomic(r.path, data, 0o600); err != nil {
+		log := gologger.WithComponent("caches")
+		log.Warn().Err(err).Str("path", r.path).Msg("Failed to persist cache state")
+		return fmt.Errorf("failed to persist cache state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,381 @@
+package caches
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+)
+
+type fakeStore struct {
+	mu       sync.Mutex
+	entries  map[string]Entry
+	removing chan struct{}
+	removed  []string
+}
+
+func newFakeStore(sizes map[string]int64) *fakeStore {
+	s := &fakeStore{entries: make(map[string]Entry)}
+	for key, size := range sizes {
+		s.entries[key] = Entry{Key: key, Size: size}
+	}
+	return s
+}
+
+func (s *fakeStore) Entries(ctx context.Context) ([]Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Entry
+	for _, e := range s.entries {
+		out = append(out, e)
+	}
+	return out, nil
+}
+
+func (s *fakeStore) Has(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.entries[key]
+	return ok, nil
+}
+
+func (s *fakeStore) Remove(ctx context.Context, key string) error {
+	if s.removing != nil {
+		<-s.removing
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	s.removed = append(s.removed, key)
+	return nil
+}
+
+func sum(quotas map[string]int64) int64 {
+	var total int64
+	for _, q := range quotas {
+		total += q
+	}
+	return total
+}
+
+func TestArbitrateFavoursCachesWithMoreHits(t *testing.T) {
+	quotas := Arbitrate(1000, 0, []Demand{
+		{Name: Images, Usage: 2000, Hits: 29},
+		{Name: Models, Usage: 2000, Hits: 9},
+	})
+
+	if quotas[Images] != 750 || quotas[Models] != 250 {
+		t.Fatalf("expected a 3:1 split, got %v", quotas)
+	}
+}
+
+func TestArbitrateGuaranteesMinimumShare(t *testing.T) {
+	quotas := Arbitrate(1000, 0.2, []Demand{
+		{Name: Images, Usage: 5000, Hits: 999},
+		{Name: Datasets, Usage: 5000},
+	})
+
+	if quotas[Datasets] < 100 {
+		t.Fatalf("idle cache got %d, below its guaranteed 100", quotas[Datasets])
+	}
+	if sum(quotas) != 1000 {
+		t.Fatalf("quotas %v do not add up to the budget", quotas)
+	}
+}
+
+func TestArbitrateRedistributesUnusedShare(t *testing.T) {
+	// Datasets would get half the budget by hits but only needs 100
+	quotas := Arbitrate(1000, 0, []Demand{
+		{Name: Images, Usage: 5000, Hits: 10},
+		{Name: Datasets, Usage: 100, Hits: 10},
+		{Name: Models, Usage: 5000, Hits: 32},
+	})
+
+	if quotas[Datasets] != 100 {
+		t.Fatalf("expected datasets to keep exactly its usage, got %d", quotas[Datasets])
+	}
+	if quotas[Images]+quotas[Models] != 900 {
+		t.Fatalf("expected the surplus to go to the other caches, got %v", quotas)
+	}
+	if quotas[Models] != 3*quotas[Images] {
+		t.Fatalf("expected the surplus split by hits, got %v", quotas)
+	}
+}
+
+func TestArbitrateSpareSpaceWhenEverythingFits(t *testing.T) {
+	quotas := Arbitrate(1000, 0, []Demand{
+		{Name: Images, Usage: 100},
+		{Name: Models, Usage: 200, Hits: 2},
+	})
+
+	if quotas[Images] < 100 || quotas[Models] < 200 || sum(quotas) != 1000 {
+		t.Fatalf("unexpected quotas %v", quotas)
+	}
+}
+
+func TestArbitrateAlwaysSpendsExactBudget(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		budget := rng.Int63n(1 << 40)
+		var demands []Demand
+		for j, name := range []string{Images, Models, Datasets, Artifacts}[:1+rng.Intn(4)] {
+			demands = append(demands, Demand{
+				Name:  name,
+				Usage: rng.Int63n(1 << 40),
+				Hits:  float64(rng.Intn(100 * (j + 1))),
+			})
+		}
+		minShare := rng.Float64()
+		quotas := Arbitrate(budget, minShare, demands)
+		if sum(quotas) != budget {
+			t.Fatalf("quotas %v add up to %d, budget %d", quotas, sum(quotas), budget)
+		}
+		floor := int64(float64(budget) * minShare / float64(len(demands)))
+		for _, d := range demands {
+			if quotas[d.Name] < floor {
+				t.Fatalf("%s got %d, below floor %d", d.Name, quotas[d.Name], floor)
+			}
+		}
+	}
+}
+
+func TestPurgeSkipsInUseAndPinned(t *testing.T) {
+	ctx := context.Background()
+	reg, err := OpenRegistry("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newFakeStore(map[string]int64{"busy": 10, "pinned": 20, "idle": 30})
+	reg.Register(Images, store, StoreOptions{})
+
+	release := reg.Acquire(ctx, Ref{Cache: Images, Key: "busy"})
+	if err := reg.Pin(ctx, Images, "pinned"); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := reg.Purge(ctx, Images, PurgeRequest{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Removed) != 1 || result.Removed[0].Key != "idle" || result.FreedBytes != 30 {
+		t.Fatalf("expected only the idle entry to be removed, got %+v", result)
+	}
+	reasons := map[string]string{}
+	for _, s := range result.Skipped {
+		reasons[s.Key] = s.Reason
+	}
+	if reasons["busy"] != SkipInUse || reasons["pinned"] != SkipPinned {
+		t.Fatalf("unexpected skip reasons %v", reasons)
+	}
+
+	release()
+	release()
+	result, err = reg.Purge(ctx, Images, PurgeRequest{Keys: []string{"busy", "missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Removed) != 1 || result.Removed[0].Key != "busy" {
+		t.Fatalf("expected the released entry to be purged, got %+v", result)
+	}
+	if len(result.Skipped) != 1 || result.Skipped[0].Reason != SkipNotFound {
+		t.Fatalf("expected the unknown key to be reported, got %+v", result.Skipped)
+	}
+}
+
+func TestAcquireWaitsForRemovalInProgress(t *testing.T) {
+	ctx := context.Background()
+	reg, _ := OpenRegistry("")
+	store := newFakeStore(map[string]int64{"data": 10})
+	store.removing = make(chan struct{})
+	reg.Register(Datasets, store, StoreOptions{})
+
+	purged := make(chan struct{})
+	go func() {
+		defer close(purged)
+		reg.Purge(ctx, Datasets, PurgeRequest{Keys: []string{"data"}})
+	}()
+
+	// Wait until the purge has claimed the entry
+	for {
+		reg.mu.Lock()
+		_, busy := reg.removing[Ref{Cache: Datasets, Key: "data"}]
+		reg.mu.Unlock()
+		if busy {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	acquired := make(chan func())
+	go func() { acquired <- reg.Acquire(ctx, Ref{Cache: Datasets, Key: "data"}) }()
+	select {
+	case <-acquired:
+		t.Fatal("acquire returned while the entry was being removed")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(store.removing)
+	<-purged
+	release := <-acquired
+	defer release()
+
+	summaries, _ := reg.Summaries(ctx)
+	if summaries[0].HitRate != 0 {
+		t.Fatalf("expected the lookup after removal to be a miss, got hit rate %v", summaries[0].HitRate)
+	}
+}
+
+func TestRebalanceEvictsLeastRecentlyUsedButNeverHeldEntries(t *testing.T) {
+	ctx := context.Background()
+	fake := clocktest.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	reg, _ := OpenRegistry("")
+	reg.SetClock(fake)
+
+	images := newFakeStore(map[string]int64{"oldest": 400, "old": 400, "running": 400, "new": 400})
+	reg.Register(Images, images, StoreOptions{})
+	for _, key := range []string{"running", "oldest", "old", "new"} {
+		reg.Acquire(ctx, Ref{Cache: Images, Key: key})()
+		fake.Advance(time.Minute)
+	}
+	// "running" is the least recently used by time but held by a task
+	release := reg.Acquire(ctx, Ref{Cache: Images, Key: "running"})
+	defer release()
+	reg.mu.Lock()
+	reg.lastUsed[Ref{Cache: Images, Key: "running"}] = fake.Now().Add(-time.Hour)
+	reg.mu.Unlock()
+
+	reg.SetBudget(1000, 0)
+	result, err := reg.Rebalance(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Quotas[Images] != 1000 {
+		t.Fatalf("expected the single cache to get the whole budget, got %v", result.Quotas)
+	}
+	if len(images.removed) != 2 || images.removed[0] != "oldest" || images.removed[1] != "old" {
+		t.Fatalf("expected the two least recently used idle images to be evicted, got %v", images.removed)
+	}
+}
+
+func TestEvictFreesAcrossCachesOldestFirst(t *testing.T) {
+	ctx := context.Background()
+	fake := clocktest.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	reg, _ := OpenRegistry("")
+	reg.SetClock(fake)
+
+	images := newFakeStore(map[string]int64{"pinned": 500, "image": 300})
+	inputs := newFakeStore(map[string]int64{"input": 200, "recent": 200})
+	reg.Register(Images, images, StoreOptions{})
+	reg.Register(Inputs, inputs, StoreOptions{})
+	for _, ref := range []Ref{{Images, "pinned"}, {Inputs, "input"}, {Images, "image"}, {Inputs, "recent"}} {
+		reg.Acquire(ctx, ref)()
+		fake.Advance(time.Minute)
+	}
+	if err := reg.Pin(ctx, Images, "pinned"); err != nil {
+		t.Fatal(err)
+	}
+
+	freed, err := reg.Evict(ctx, 400)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freed != 500 || len(inputs.removed) != 1 || inputs.removed[0] != "input" || len(images.removed) != 1 || images.removed[0] != "image" {
+		t.Fatalf("Evict() freed %d, removed %v and %v, want the two oldest unpinned entries", freed, images.removed, inputs.removed)
+	}
+}
+
+func TestTrackedOnlyHidesUnusedEntries(t *testing.T) {
+	ctx := context.Background()
+	reg, _ := OpenRegistry("")
+	reg.Register(Images, newFakeStore(map[string]int64{"task:1": 1, "operator:1": 1}), StoreOptions{TrackedOnly: true})
+	reg.Acquire(ctx, Ref{Cache: Images, Key: "task:1"})()
+
+	entries, err := reg.List(ctx, Images)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Key != "task:1" {
+		t.Fatalf("expected only the task image, got %+v", entries)
+	}
+	if _, err := reg.Purge(ctx, Images, PurgeRequest{Keys: []string{"operator:1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := reg.stores[Images].store.Has(ctx, "operator:1"); !ok {
+		t.Fatal("purge removed an image the runner never used")
+	}
+}
+
+func TestPinsPersist(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "caches", "state.json")
+	store := newFakeStore(map[string]int64{"llama3:latest": 100})
+
+	reg, err := OpenRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.Register(Models, store, StoreOptions{})
+	if err := reg.Pin(ctx, Models, "llama3:latest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Pin(ctx, Models, "absent"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected pinning a missing entry to fail, got %v", err)
+	}
+
+	reopened, err := OpenRegistry(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.Register(Models, store, StoreOptions{})
+	entries, _ := reopened.List(ctx, Models)
+	if len(entries) != 1 || !entries[0].Pinned {
+		t.Fatalf("expected the pin to survive a restart, got %+v", entries)
+	}
+}
+
+func TestClientAgainstHandlers(t *testing.T) {
+	ctx := context.Background()
+	reg, _ := OpenRegistry("")
+	reg.Register(Datasets, newFakeStore(map[string]int64{"bafyA": 5, "bafyB": 7}), StoreOptions{})
+	release := reg.Acquire(ctx, Ref{Cache: Datasets, Key: "bafyA"})
+	defer release()
+
+	mux := http.NewServeMux()
+	reg.RegisterHandlers(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := NewClient(server.URL)
+
+	summaries, err := client.Summaries(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summaries) != 1 || summaries[0].UsageBytes != 12 {
+		t.Fatalf("unexpected summaries %+v", summaries)
+	}
+
+	if err := client.Pin(ctx, Datasets, "bafyB"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := client.Purge(ctx, Datasets, PurgeRequest{All: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Removed) != 0 || len(result.Skipped) != 2 {
+		t.Fatalf("expected nothing purged, got %+v", result)
+	}
+
+	if _, err := client.List(ctx, "unknown"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected not found for an unknown cache, got %v", err)
+	}
+	if _, err := client.Purge(ctx, Datasets, PurgeRequest{}); err == nil {
+		t.Fatal("expected an empty purge to be rejected")
+	}
+}
@@ -0,0 +1,250 @@
+package caches
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/fsck"
+)
+
+// ChecksumIndexName is the file a checksummed cache keeps its index in,
+// next to its entries
+const ChecksumIndexName = ".checksums.json"
+
+// ErrCorrupt is returned for cache entries whose content no longer matches
+// the checksum recorded when they were stored, or that have no checksum
+var ErrCorrupt = errors.New("cache entry failed verification")
+
+// Checksum is the SHA-256 and size of a cache entry as it was stored
+type Checksum struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// HashFile returns the checksum of the file at path
+func HashFile(path string) (Checksum, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Checksum{}, err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return Checksum{}, err
+	}
+	return Checksum{SHA256: hex.EncodeToString(hash.Sum(nil)), Size: size}, nil
+}
+
+// Checksums indexes the entries of a file-backed cache directory by
+// checksum, so that content damaged on disk is caught before it is served.
+// The index is rewritten atomically on every change.
+type Checksums struct {
+	dir        string
+	store      string
+	quarantine *fsck.Quarantine
+	mu         sync.Mutex
+	entries    map[string]Checksum
+}
+
+// OpenChecksums loads the index of the cache store keeps in dir. An index
+// that cannot be read is started afresh, so that the entries it covered
+// fail verification rather than the cache failing to open.
+func OpenChecksums(dir, store string) *Checksums {
+	c := &Checksums{dir: dir, store: store, entries: make(map[string]Checksum)}
+	entries, err := c.load()
+	if err != nil {
+		log := gologger.WithComponent("caches")
+		log.Warn().Err(err).Str("cache", store).Msg("Cache index unreadable - unverifiable entries will be fetched again")
+		return c
+	}
+	c.entries = entries
+	return c
+}
+
+func (c *Checksums) path() string {
+	return filepath.Join(c.dir, ChecksumIndexName)
+}
+
+func (c *Checksums) load() (map[string]Checksum, error) {
+	entries := make(map[string]Checksum)
+	data, err := os.ReadFile(c.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache index: %w", err)
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode cache index: %w", err)
+	}
+	return entries, nil
+}
+
+// SetQuarantine moves entries that fail verification into q instead of
+// deleting them
+func (c *Checksums) SetQuarantine(q *fsck.Quarantine) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.quarantine = q
+}
+
+// Record indexes the entry key with sum
+func (c *Checksums) Record(key string, sum Checksum) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = sum
+	return c.save()
+}
+
+// Forget drops key from the index
+func (c *Checksums) Forget(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; !ok {
+		return nil
+	}
+	delete(c.entries, key)
+	return c.save()
+}
+
+func (c *Checksums) save() error {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache index: %w", err)
+	}
+	if err := atrest.WriteFileAtomic(c.path(), data, 0o600); err != nil {
+		return fmt.Errorf("failed to persist cache index: %w", err)
+	}
+	return nil
+}
+
+// Verify checks the entry key on disk against its recorded checksum. It
+// returns ErrCorrupt for an entry that does not match or was never recorded.
+func (c *Checksums) Verify(key string) (Checksum, error) {
+	c.mu.Lock()
+	want, ok := c.entries[key]
+	c.mu.Unlock()
+
+	got, err := HashFile(filepath.Join(c.dir, key))
+	if err != nil {
+		return Checksum{}, err
+	}
+	if !ok {
+		return got, fmt.Errorf("%w: %s has no recorded checksum", ErrCorrupt, key)
+	}
+	if got != want {
+		return got, fmt.Errorf("%w: %s has sha256 %s and %d bytes, recorded %s and %d bytes",
+			ErrCorrupt, key, got.SHA256, got.Size, want.SHA256, want.Size)
+	}
+	return got, nil
+}
+
+// Discard takes the entry key out of service: into the quarantine when one
+// is set, otherwise deleted
+func (c *Checksums) Discard(key string) error {
+	c.mu.Lock()
+	q := c.quarantine
+	c.mu.Unlock()
+
+	path := filepath.Join(c.dir, key)
+	if _, err := os.Lstat(path); err == nil {
+		if err := q.Move(c.store, path); err != nil {
+			return err
+		}
+	}
+	return c.Forget(key)
+}
+
+// Check cross-checks the index against the entries in the directory:
+// entries missing from disk are dropped from the index, and entries that do
+// not match their checksum are quarantined. isEntry tells entries from other
+// files. An entry the index does not cover is kept only when adopt, which
+// may be nil, vouches for its checksum; otherwise it is quarantined too.
+func (c *Checksums) Check(q *fsck.Quarantine, isEntry func(name string) bool, adopt func(key string, sum Checksum) bool) ([]fsck.Finding, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var findings []fsck.Finding
+	if entries, err := c.load(); err != nil {
+		if err := q.Move(c.store, c.path()); err != nil {
+			return nil, err
+		}
+		findings = append(findings, fsck.Finding{Store: c.store, Item: ChecksumIndexName, Problem: err.Error(), Action: fsck.ActionQuarantined})
+		c.entries = make(map[string]Checksum)
+	} else {
+		c.entries = entries
+	}
+	changed := len(findings) > 0
+
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return findings, fmt.Errorf("failed to read %s cache: %w", c.store, err)
+	}
+	onDisk := make(map[string]bool)
+	for _, de := range dirEntries {
+		key := de.Name()
+		if !de.Type().IsRegular() || !isEntry(key) {
+			continue
+		}
+		onDisk[key] = true
+		sum, err := HashFile(filepath.Join(c.dir, key))
+		if err != nil {
+			return findings, fmt.Errorf("failed to read cache entry %s: %w", key, err)
+		}
+
+		problem := ""
+		want, indexed := c.entries[key]
+		switch {
+		case indexed && want == sum:
+			continue
+		case indexed:
+			problem = "content does not match its recorded checksum"
+		case adopt != nil && adopt(key, sum):
+			c.entries[key] = sum
+			changed = true
+			findings = append(findings, fsck.Finding{Store: c.store, Item: key, Problem: "missing from the index", Action: fsck.ActionRepaired})
+			continue
+		default:
+			problem = "missing from the index and cannot be verified"
+		}
+		if err := q.Move(c.store, filepath.Join(c.dir, key)); err != nil {
+			return findings, err
+		}
+		delete(c.entries, key)
+		delete(onDisk, key)
+		changed = true
+		findings = append(findings, fsck.Finding{Store: c.store, Item: key, Problem: problem, Action: fsck.ActionQuarantined})
+	}
+
+	var stale []string
+	for key := range c.entries {
+		if !onDisk[key] {
+			stale = append(stale, key)
+		}
+	}
+	sort.Strings(stale)
+	for _, key := range stale {
+		delete(c.entries, key)
+		changed = true
+		findings = append(findings, fsck.Finding{Store: c.store, Item: key, Problem: "indexed but missing from disk", Action: fsck.ActionRepaired})
+	}
+
+	if changed {
+		if err := c.save(); err != nil {
+			return findings, err
+		}
+	}
+	return findings, nil
+}
@@ -0,0 +1,102 @@
+package caches
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/fsck"
+)
+
+func TestChecksumsCatchDamage(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "entry")
+	if err := os.WriteFile(path, []byte("content"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c := OpenChecksums(dir, Datasets)
+	sum, err := HashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Record("entry", sum); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Verify("entry"); err != nil {
+		t.Fatalf("Verify() error = %v for an intact entry", err)
+	}
+
+	os.WriteFile(path, []byte("contents"), 0o600)
+	if _, err := c.Verify("entry"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Verify() error = %v for a damaged entry, want ErrCorrupt", err)
+	}
+	// The index survives a reopen
+	if _, err := OpenChecksums(dir, Datasets).Verify("entry"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Verify() error = %v after reopening, want ErrCorrupt", err)
+	}
+
+	quarantine := t.TempDir()
+	findings, err := c.Check(fsck.NewQuarantine(quarantine), func(name string) bool { return name != ChecksumIndexName }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Action != fsck.ActionQuarantined {
+		t.Fatalf("Check() = %+v, want the damaged entry quarantined", findings)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("damaged entry left in the cache")
+	}
+}
+
+func TestChecksumsRecoverUnreadableIndex(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "entry"), []byte("content"), 0o600)
+	os.WriteFile(filepath.Join(dir, ChecksumIndexName), []byte(`{"entry": {"sha2`), 0o600)
+
+	c := OpenChecksums(dir, Datasets)
+	if _, err := c.Verify("entry"); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Verify() error = %v without an index, want ErrCorrupt", err)
+	}
+	findings, err := c.Check(nil, func(name string) bool { return name == "entry" }, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 2 {
+		t.Fatalf("Check() = %+v, want the index and the unverifiable entry quarantined", findings)
+	}
+	if _, err := OpenChecksums(dir, Datasets).load(); err != nil {
+		t.Fatalf("index still unreadable after Check(): %v", err)
+	}
+}
+
+func TestCheckStateDropsMissingEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	os.WriteFile(path, []byte(`[
+		{"cache": "datasets", "key": "kept", "pinned": true},
+		{"cache": "datasets", "key": "gone", "pinned": true},
+		{"cache": "images", "key": "python:3.11", "pinned": true}
+	]`), 0o600)
+	stores := map[string]Store{Datasets: newFakeStore(map[string]int64{"kept": 1})}
+
+	findings, err := CheckState(context.Background(), path, nil, stores)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Item != "datasets/gone" {
+		t.Fatalf("CheckState() = %+v, want the missing dataset dropped", findings)
+	}
+	var entries []persistedEntry
+	data, _ := os.ReadFile(path)
+	if err := json.Unmarshal(data, &entries); err != nil || len(entries) != 2 {
+		t.Fatalf("state = %s, want the other entries kept", data)
+	}
+
+	os.WriteFile(path, []byte(`[{"cache": "datas`), 0o600)
+	findings, err = CheckState(context.Background(), path, nil, stores)
+	if err != nil || len(findings) != 1 || findings[0].Action != fsck.ActionQuarantined {
+		t.Fatalf("CheckState() = %+v, %v, want undecodable state quarantined", findings, err)
+	}
+}
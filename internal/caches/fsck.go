@@ -0,0 +1,77 @@
+package caches
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/fsck"
+)
+
+// StateStore names the registry state in fsck findings
+const StateStore = "cache-state"
+
+// CheckState cross-checks the registry state at path against stores, the
+// caches whose entries can be looked up without a running daemon. State
+// that cannot be decoded is quarantined, which loses pins and usage times
+// but lets the registry open; pins and usage times of entries no longer in
+// their store are dropped.
+func CheckState(ctx context.Context, path string, q *fsck.Quarantine, stores map[string]Store) ([]fsck.Finding, error) {
+	findings, err := fsck.RemoveLeftovers(StateStore, filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return findings, err
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return findings, nil
+	}
+	if err != nil {
+		return findings, fmt.Errorf("failed to read cache state: %w", err)
+	}
+	var entries []persistedEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		if err := q.Move(StateStore, path); err != nil {
+			return findings, err
+		}
+		return append(findings, fsck.Finding{Store: StateStore, Item: filepath.Base(path), Problem: "cannot be decoded", Action: fsck.ActionQuarantined}), nil
+	}
+
+	kept := entries[:0]
+	for _, e := range entries {
+		store, ok := stores[e.Cache]
+		if !ok {
+			kept = append(kept, e)
+			continue
+		}
+		has, err := store.Has(ctx, e.Key)
+		if err != nil {
+			return findings, fmt.Errorf("failed to look up %s entry %s: %w", e.Cache, e.Key, err)
+		}
+		if has {
+			kept = append(kept, e)
+			continue
+		}
+		findings = append(findings, fsck.Finding{
+			Store:   StateStore,
+			Item:    e.Cache + "/" + e.Key,
+			Problem: "tracked but missing from its cache",
+			Action:  fsck.ActionRepaired,
+		})
+	}
+	if len(kept) == len(entries) {
+		return findings, nil
+	}
+	data, err = json.Marshal(kept)
+	if err != nil {
+		return findings, fmt.Errorf("failed to marshal cache state: %w", err)
+	}
+	if err := atrest.WriteFileAtomic(path, data, 0o600); err != nil {
+		return findings, fmt.Errorf("failed to persist cache state: %w", err)
+	}
+	return findings, nil
+}
@@ -0,0 +1,203 @@
+package caches
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+)
+
+// apiPrefix is where the registry is served on the runner's local port
+const apiPrefix = "/runner/caches"
+
+type keyRequest struct {
+	Key string `json:"key"`
+}
+
+// RegisterHandlers exposes the registry on mux:
+//
+//	GET  /runner/caches                  summaries of every cache
+//	GET  /runner/caches/{name}           entries of one cache
+//	POST /runner/caches/{name}/pin       {"key": ...}
+//	POST /runner/caches/{name}/unpin     {"key": ...}
+//	POST /runner/caches/{name}/purge     a PurgeRequest
+//	POST /runner/caches/rebalance        run budget arbitration now
+func (r *Registry) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET "+apiPrefix, func(w http.ResponseWriter, req *http.Request) {
+		summaries, err := r.Summaries(req.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"caches": summaries})
+	})
+	mux.HandleFunc("GET "+apiPrefix+"/{name}", func(w http.ResponseWriter, req *http.Request) {
+		entries, err := r.List(req.Context(), req.PathValue("name"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"entries": entries})
+	})
+	mux.HandleFunc("POST "+apiPrefix+"/{name}/pin", func(w http.ResponseWriter, req *http.Request) {
+		var body keyRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Key == "" {
+			http.Error(w, "request body must name a key", http.StatusBadRequest)
+			return
+		}
+		if err := r.Pin(req.Context(), req.PathValue("name"), body.Key); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST "+apiPrefix+"/{name}/unpin", func(w http.ResponseWriter, req *http.Request) {
+		var body keyRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Key == "" {
+			http.Error(w, "request body must name a key", http.StatusBadRequest)
+			return
+		}
+		if err := r.Unpin(req.Context(), req.PathValue("name"), body.Key); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST "+apiPrefix+"/{name}/purge", func(w http.ResponseWriter, req *http.Request) {
+		var body PurgeRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid purge request", http.StatusBadRequest)
+			return
+		}
+		result, err := r.Purge(req.Context(), req.PathValue("name"), body)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+	mux.HandleFunc("POST "+apiPrefix+"/rebalance", func(w http.ResponseWriter, req *http.Request) {
+		result, err := r.Rebalance(req.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, result)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log := gologger.WithComponent("caches")
+		log.Error().Err(err).Msg("Failed to write cache response")
+	}
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrUnknownCache), errors.Is(err, ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrEmptyPurge):
+		status = http.StatusBadRequest
+	}
+	http.Error(w, err.Error(), status)
+}
+
+var _ Admin = (*Registry)(nil)
+var _ Admin = (*Client)(nil)
+
+// Client administers the caches of a running runner over its local port
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + apiPrefix,
+		httpClient: &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		err := fmt.Errorf("runner returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: %v", ErrNotFound, err)
+		}
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) Summaries(ctx context.Context) ([]Summary, error) {
+	var out struct {
+		Caches []Summary `json:"caches"`
+	}
+	err := c.do(ctx, http.MethodGet, "", nil, &out)
+	return out.Caches, err
+}
+
+func (c *Client) List(ctx context.Context, name string) ([]Entry, error) {
+	var out struct {
+		Entries []Entry `json:"entries"`
+	}
+	err := c.do(ctx, http.MethodGet, "/"+name, nil, &out)
+	return out.Entries, err
+}
+
+func (c *Client) Pin(ctx context.Context, name, key string) error {
+	return c.do(ctx, http.MethodPost, "/"+name+"/pin", keyRequest{Key: key}, nil)
+}
+
+func (c *Client) Unpin(ctx context.Context, name, key string) error {
+	return c.do(ctx, http.MethodPost, "/"+name+"/unpin", keyRequest{Key: key}, nil)
+}
+
+func (c *Client) Purge(ctx context.Context, name string, req PurgeRequest) (*PurgeResult, error) {
+	var out PurgeResult
+	if err := c.do(ctx, http.MethodPost, "/"+name+"/purge", req, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -0,0 +1,277 @@
+// Package callback delivers signed result summaries to creator-supplied endpoints
+package callback
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+const (
+	SignatureHeader = "X-Parity-Signature"
+	RunnerHeader    = "X-Parity-Runner"
+
+	defaultTimeout     = 5 * time.Second
+	defaultMaxAttempts = 3
+	defaultBackoff     = time.Second
+)
+
+// Receipt summarizes the resources the runner accounted for the task
+type Receipt struct {
+	ResultID        string  `json:"result_id"`
+	RunnerAddress   string  `json:"runner_address"`
+	ExecutionTimeMs int64   `json:"execution_time_ms"`
+	CPUSeconds      float64 `json:"cpu_seconds"`
+	MemoryGBHours   float64 `json:"memory_gb_hours"`
+	StorageGB       float64 `json:"storage_gb"`
+	NetworkDataGB   float64 `json:"network_data_gb"`
+	Reward          float64 `json:"reward"`
+	// BuildFingerprint fingerprints the runner build that ran the task
+	BuildFingerprint string `json:"build_fingerprint,omitempty"`
+}
+
+// Summary is the body POSTed to a creator callback. It deliberately carries
+// hashes and identifiers only: task output, errors and environment values may
+// contain secrets and are never included.
+type Summary struct {
+	TaskID       string            `json:"task_id"`
+	Status       models.TaskStatus `json:"status"`
+	ExitCode     int               `json:"exit_code"`
+	OutputHash   string            `json:"output_hash"`
+	ArtifactCIDs []string          `json:"artifact_cids,omitempty"`
+	Receipt      Receipt           `json:"receipt"`
+	SentAt       time.Time         `json:"sent_at"`
+}
+
+// NewSummary builds the callback body for a saved task result
+func NewSummary(task *models.Task, result *models.TaskResult, status models.TaskStatus) *Summary {
+	outputHash := result.ResultHash
+	if outputHash == "" {
+		sum := sha256.Sum256([]byte(result.Output))
+		outputHash = hex.EncodeToString(sum[:])
+	}
+
+	return &Summary{
+		TaskID:       task.ID.String(),
+		Status:       status,
+		ExitCode:     result.ExitCode,
+		OutputHash:   outputHash,
+		ArtifactCIDs: result.ArtifactCIDs,
+		Receipt: Receipt{
+			ResultID:         result.ID.String(),
+			ExecutionTimeMs:  result.ExecutionTime,
+			CPUSeconds:       result.CPUSeconds,
+			MemoryGBHours:    result.MemoryGBHours,
+			StorageGB:        result.StorageGB,
+			NetworkDataGB:    result.NetworkDataGB,
+			Reward:           result.Reward,
+			BuildFingerprint: result.BuildFingerprint,
+		},
+	}
+}
+
+type Config struct {
+	Policy Policy
+	// Timeout bounds each delivery attempt, including connecting
+	Timeout     time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+// Stats counts callback outcomes since the runner started
+type Stats struct {
+	Delivered uint64 `json:"delivered"`
+	Failed    uint64 `json:"failed"`
+}
+
+// Notifier signs and delivers result summaries with the runner's key
+type Notifier struct {
+	cfg       Config
+	client    *http.Client
+	signer    signer.Signer
+	address   common.Address
+	clock     clock.Clock
+	delivered atomic.Uint64
+	failed    atomic.Uint64
+}
+
+func NewNotifier(cfg Config, signer signer.Signer) *Notifier {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultBackoff
+	}
+
+	dialer := &net.Dialer{Timeout: cfg.Timeout, Control: cfg.Policy.CheckAddress}
+	client := &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &http.Transport{
+			// Never route through an environment proxy, which would bypass address checks
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: cfg.Timeout,
+		},
+		// Redirects could lead outside the policy, so they are treated as failures
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	return &Notifier{
+		cfg:     cfg,
+		client:  client,
+		signer:  signer,
+		address: signer.Address(),
+		clock:   clock.Real(),
+	}
+}
+
+// SetClock replaces the clock used for retry backoff and timestamps
+func (n *Notifier) SetClock(c clock.Clock) {
+	n.clock = c
+}
+
+func (n *Notifier) Stats() Stats {
+	return Stats{Delivered: n.delivered.Load(), Failed: n.failed.Load()}
+}
+
+// Notify delivers summary in the background so the caller is never blocked.
+// done, if set, receives the final delivery error.
+func (n *Notifier) Notify(callbackURL string, summary *Summary, done func(error)) {
+	go func() {
+		err := n.Deliver(context.Background(), callbackURL, summary)
+		if done != nil {
+			done(err)
+		}
+	}()
+}
+
+// Deliver POSTs the signed summary, retrying transient failures with
+// exponential backoff
+func (n *Notifier) Deliver(ctx context.Context, callbackURL string, summary *Summary) error {
+	err := n.deliver(ctx, callbackURL, summary)
+	if err != nil {
+		n.failed.Add(1)
+	} else {
+		n.delivered.Add(1)
+	}
+	return err
+}
+
+func (n *Notifier) deliver(ctx context.Context, callbackURL string, summary *Summary) error {
+	log := gologger.WithComponent("callback")
+
+	u, err := n.cfg.Policy.Validate(callbackURL)
+	if err != nil {
+		return err
+	}
+
+	summary.Receipt.RunnerAddress = n.address.Hex()
+	summary.SentAt = n.clock.Now().UTC()
+	body, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to marshal callback summary: %w", err)
+	}
+	signature, err := Sign(body, n.signer)
+	if err != nil {
+		return err
+	}
+
+	backoff := n.cfg.Backoff
+	var lastErr error
+	for attempt := 1; attempt <= n.cfg.MaxAttempts; attempt++ {
+		retryable, err := n.post(ctx, u.String(), body, signature)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retryable || attempt == n.cfg.MaxAttempts {
+			break
+		}
+
+		log.Debug().
+			Err(err).
+			Str("task_id", summary.TaskID).
+			Int("attempt", attempt).
+			Msg("Callback delivery failed, retrying")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.clock.After(backoff):
+		}
+		backoff *= 2
+	}
+	return fmt.Errorf("callback delivery failed: %w", lastErr)
+}
+
+// post performs a single attempt and reports whether a failure is worth retrying
+func (n *Notifier) post(ctx context.Context, url string, body []byte, signature string) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, signature)
+	req.Header.Set(RunnerHeader, n.address.Hex())
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return !errors.Is(err, ErrDisallowedURL), err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return true, nil
+	}
+	retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusRequestTimeout ||
+		resp.StatusCode == http.StatusTooManyRequests
+	return retryable, fmt.Errorf("callback returned status %d", resp.StatusCode)
+}
+
+// Sign returns the hex-encoded secp256k1 signature over the Keccak-256 hash of body
+func Sign(body []byte, s signer.Signer) (string, error) {
+	signature, err := s.SignHash(crypto.Keccak256(body))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign callback: %w", err)
+	}
+	return hexutil.Encode(signature), nil
+}
+
+// VerifySignature recovers the address that signed body. Receivers compare it
+// with the runner address recorded for the task.
+func VerifySignature(body []byte, signature string) (common.Address, error) {
+	sig, err := hexutil.Decode(strings.TrimSpace(signature))
+	if err != nil {
+		return common.Address{}, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	pub, err := crypto.SigToPub(crypto.Keccak256(body), sig)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("invalid signature: %w", err)
+	}
+	return crypto.PubkeyToAddress(*pub), nil
+}
@@ -0,0 +1,110 @@
+package callback
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ErrDisallowedURL is returned for callback URLs rejected by the Policy
+var ErrDisallowedURL = errors.New("callback URL not allowed")
+
+// Policy restricts where creator callbacks may be delivered so a task cannot
+// turn the runner into a proxy for reaching its local network
+type Policy struct {
+	AllowedSchemes []string
+	AllowedPorts   []int
+	// AllowPrivate permits loopback, link-local and private network addresses
+	AllowPrivate bool
+}
+
+// DefaultPolicy only permits HTTPS on the standard port to public addresses
+func DefaultPolicy() Policy {
+	return Policy{
+		AllowedSchemes: []string{"https"},
+		AllowedPorts:   []int{443},
+	}
+}
+
+// Validate checks rawURL against the scheme and port allowlists. Hostnames
+// are resolved at dial time, where CheckAddress rejects private addresses.
+func (p Policy) Validate(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDisallowedURL, err)
+	}
+	if u.Host == "" || u.User != nil {
+		return nil, fmt.Errorf("%w: URL must have a host and no credentials", ErrDisallowedURL)
+	}
+
+	scheme := strings.ToLower(u.Scheme)
+	if !containsString(p.AllowedSchemes, scheme) {
+		return nil, fmt.Errorf("%w: scheme %q", ErrDisallowedURL, u.Scheme)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if scheme == "http" {
+			port = "80"
+		}
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil || !containsInt(p.AllowedPorts, portNum) {
+		return nil, fmt.Errorf("%w: port %s", ErrDisallowedURL, port)
+	}
+
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		if err := p.checkIP(ip); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// CheckAddress is used as a net.Dialer Control function so that hostnames
+// resolving to private addresses are rejected after DNS resolution
+func (p Policy) CheckAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrDisallowedURL, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: unresolved address %s", ErrDisallowedURL, host)
+	}
+	return p.checkIP(ip)
+}
+
+func (p Policy) checkIP(ip net.IP) error {
+	if p.AllowPrivate {
+		return nil
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("%w: address %s is not public", ErrDisallowedURL, ip)
+	}
+	return nil
+}
+
+func containsString(values []string, want string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, want) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(values []int, want int) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+// Package canary runs small self-tests of what a runner offers tasks, so
+// that a runner degrading silently, such as with a half-broken GPU driver or
+// a full scratch disk, stops offering what it can no longer do instead of
+// claiming tasks it then fails. Each capability has a check, run on a
+// schedule; once a check fails Threshold times in a row its capability is
+// degraded, and it is restored as soon as the check passes again.
+package canary
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// Capabilities checked by the canaries a runner runs
+const (
+	// Container runs a minimal container
+	Container = "container"
+	// Scratch writes, reads back and deletes a file in scratch space
+	Scratch = "scratch"
+	// Network reaches an endpoint outside the runner
+	Network = "network"
+
+	modelPrefix = "model:"
+)
+
+// Model names the capability of generating with model
+func Model(model string) string {
+	return modelPrefix + model
+}
+
+// ModelOf returns the model a capability named with Model is of
+func ModelOf(capability string) (string, bool) {
+	return strings.CutPrefix(capability, modelPrefix)
+}
+
+// Check is the canary workload of one capability
+type Check struct {
+	Capability string
+	Run        func(ctx context.Context) error
+}
+
+// Result is the outcome of one run of a check
+type Result struct {
+	Capability string
+	StartedAt  time.Time
+	Duration   time.Duration
+	// Err is nil when the check passed
+	Err error
+}
+
+// Monitor runs the checks of a runner's capabilities and tracks which are
+// degraded
+type Monitor struct {
+	checks    func() []Check
+	threshold int
+	timeout   time.Duration
+	clock     clock.Clock
+	onResult  func(Result)
+	onChange  func(models.CanaryStatus)
+
+	mu     sync.Mutex
+	states map[string]*models.CanaryStatus
+}
+
+// NewMonitor returns a monitor running the checks returned by checks, which
+// is asked again before each round so that checks may come and go, such as
+// those of the models a runner serves. A capability is degraded once its
+// check failed threshold times in a row; each check is given timeout.
+func NewMonitor(checks func() []Check, threshold int, timeout time.Duration) (*Monitor, error) {
+	if threshold < 1 {
+		return nil, fmt.Errorf("canary threshold must be at least 1, got %d", threshold)
+	}
+	return &Monitor{
+		checks:    checks,
+		threshold: threshold,
+		timeout:   timeout,
+		clock:     clock.Real(),
+		states:    make(map[string]*models.CanaryStatus),
+	}, nil
+}
+
+// SetClock replaces the clock checks are scheduled and timed by
+func (m *Monitor) SetClock(c clock.Clock) {
+	m.clock = c
+}
+
+// OnResult calls fn with the result of every check run, such as to keep
+// them in the runner's history
+func (m *Monitor) OnResult(fn func(Result)) {
+	m.onResult = fn
+}
+
+// OnChange calls fn each time a capability is degraded or restored, with
+// its status once changed
+func (m *Monitor) OnChange(fn func(models.CanaryStatus)) {
+	m.onChange = fn
+}
+
+// Run runs the checks now and then every interval until ctx is done
+func (m *Monitor) Run(ctx context.Context, interval time.Duration) {
+	m.RunOnce(ctx)
+	if interval <= 0 {
+		return
+	}
+	ticker := m.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			m.RunOnce(ctx)
+		}
+	}
+}
+
+// RunOnce runs every check in turn and returns their results
+func (m *Monitor) RunOnce(ctx context.Context) []Result {
+	checks := m.checks()
+	m.forget(checks)
+	return m.runChecks(ctx, checks)
+}
+
+// RunMatching runs the checks of the capabilities match accepts, out of
+// schedule, and returns their results
+func (m *Monitor) RunMatching(ctx context.Context, match func(capability string) bool) []Result {
+	var checks []Check
+	for _, check := range m.checks() {
+		if match(check.Capability) {
+			checks = append(checks, check)
+		}
+	}
+	return m.runChecks(ctx, checks)
+}
+
+func (m *Monitor) runChecks(ctx context.Context, checks []Check) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		if ctx.Err() != nil {
+			break
+		}
+		result := m.run(ctx, check)
+		results = append(results, result)
+		if m.onResult != nil {
+			m.onResult(result)
+		}
+		if changed := m.observe(result); changed != nil && m.onChange != nil {
+			m.onChange(*changed)
+		}
+	}
+	return results
+}
+
+func (m *Monitor) run(ctx context.Context, check Check) Result {
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+	run := clock.Start(m.clock)
+	err := check.Run(ctx)
+	return Result{
+		Capability: check.Capability,
+		StartedAt:  run.StartedAt(),
+		Duration:   run.Elapsed(),
+		Err:        err,
+	}
+}
+
+// forget drops the state of capabilities no longer checked
+func (m *Monitor) forget(checks []Check) {
+	current := make(map[string]bool, len(checks))
+	for _, check := range checks {
+		current[check.Capability] = true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for capability := range m.states {
+		if !current[capability] {
+			delete(m.states, capability)
+		}
+	}
+}
+
+// observe counts result against its capability, returning the
+// capability's status when it was degraded or restored by it
+func (m *Monitor) observe(result Result) *models.CanaryStatus {
+	log := gologger.WithComponent("canary")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.states[result.Capability]
+	if !ok {
+		state = &models.CanaryStatus{Capability: result.Capability}
+		m.states[result.Capability] = state
+	}
+	state.LastRunAt = result.StartedAt
+
+	if result.Err == nil {
+		state.ConsecutiveFailures = 0
+		state.LastError = ""
+		if !state.Degraded {
+			return nil
+		}
+		log.Info().
+			Str("capability", result.Capability).
+			Time("degraded_since", *state.DegradedSince).
+			Msg("Canary passed, restoring capability")
+		state.Degraded = false
+		state.DegradedSince = nil
+		changed := *state
+		return &changed
+	}
+
+	state.ConsecutiveFailures++
+	state.LastError = result.Err.Error()
+	log.Warn().Err(result.Err).
+		Str("capability", result.Capability).
+		Int("consecutive_failures", state.ConsecutiveFailures).
+		Msg("Canary failed")
+	if state.Degraded || state.ConsecutiveFailures < m.threshold {
+		return nil
+	}
+	log.Error().
+		Str("capability", result.Capability).
+		Int("consecutive_failures", state.ConsecutiveFailures).
+		Msg("Canary kept failing, no longer advertising capability")
+	state.Degraded = true
+	since := result.StartedAt
+	state.DegradedSince = &since
+	changed := *state
+	return &changed
+}
+
+// Degraded reports whether capability is degraded. A nil monitor degrades
+// nothing.
+func (m *Monitor) Degraded(capability string) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.states[capability]
+	return ok && state.Degraded
+}
+
+// Status returns the status of every capability checked so far, by name
+func (m *Monitor) Status() []models.CanaryStatus {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]models.CanaryStatus, 0, len(m.states))
+	for _, state := range m.states {
+		statuses = append(statuses, *state)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Capability < statuses[j].Capability })
+	return statuses
+}
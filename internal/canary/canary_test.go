@@ -0,0 +1,93 @@
+package canary
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func TestMonitorDegradesAndRestores(t *testing.T) {
+	failing := map[string]bool{}
+	checks := []Check{}
+	for _, capability := range []string{Container, Scratch} {
+		capability := capability
+		checks = append(checks, Check{Capability: capability, Run: func(context.Context) error {
+			if failing[capability] {
+				return errors.New(capability + " broke")
+			}
+			return nil
+		}})
+	}
+	monitor, err := NewMonitor(func() []Check { return checks }, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes []models.CanaryStatus
+	monitor.OnChange(func(status models.CanaryStatus) { changes = append(changes, status) })
+
+	failing[Container] = true
+	monitor.RunOnce(context.Background())
+	if monitor.Degraded(Container) || len(changes) != 0 {
+		t.Fatal("capability degraded after a single failure")
+	}
+	results := monitor.RunOnce(context.Background())
+	if len(results) != 2 || results[0].Err == nil || results[1].Err != nil {
+		t.Fatalf("results = %+v, want the container check failed and scratch passed", results)
+	}
+	if !monitor.Degraded(Container) || monitor.Degraded(Scratch) {
+		t.Fatalf("status = %+v, want only container degraded", monitor.Status())
+	}
+	if len(changes) != 1 || !changes[0].Degraded || changes[0].ConsecutiveFailures != 2 || changes[0].LastError != "container broke" {
+		t.Fatalf("changes = %+v, want container degraded after 2 failures", changes)
+	}
+
+	// Further failures change nothing until the check passes again
+	monitor.RunOnce(context.Background())
+	failing[Container] = false
+	monitor.RunOnce(context.Background())
+	if monitor.Degraded(Container) || len(changes) != 2 || changes[1].Degraded || changes[1].Capability != Container {
+		t.Fatalf("changes = %+v, want container restored on its first pass", changes)
+	}
+
+	// Capabilities no longer checked are forgotten
+	checks = checks[1:]
+	monitor.RunOnce(context.Background())
+	if status := monitor.Status(); len(status) != 1 || status[0].Capability != Scratch {
+		t.Errorf("Status() = %+v, want only scratch", status)
+	}
+
+	if _, err := NewMonitor(func() []Check { return nil }, 0, 0); err == nil {
+		t.Error("NewMonitor() accepted a threshold of 0")
+	}
+}
+
+func TestChecks(t *testing.T) {
+	dir := t.TempDir()
+	if err := ScratchCheck(dir).Run(context.Background()); err != nil {
+		t.Fatalf("scratch check error = %v", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("scratch check left %d files behind", len(entries))
+	}
+	if err := ScratchCheck(dir + "/missing").Run(context.Background()); err == nil {
+		t.Error("scratch check passed without a scratch directory")
+	}
+
+	status := http.StatusUnauthorized
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	if err := NetworkCheck(server.Client(), server.URL).Run(context.Background()); err != nil {
+		t.Errorf("network check error = %v, want any answer accepted", err)
+	}
+	status = http.StatusBadGateway
+	if err := NetworkCheck(server.Client(), server.URL).Run(context.Background()); err == nil {
+		t.Error("network check passed on a server error")
+	}
+}
@@ -0,0 +1,79 @@
+package canary
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// scratchBytes is the size of the file the scratch canary writes
+const scratchBytes = 64 << 10
+
+// ScratchCheck writes a file to dir, the system's temporary directory when
+// empty, reads it back and deletes it
+func ScratchCheck(dir string) Check {
+	return Check{
+		Capability: Scratch,
+		Run: func(ctx context.Context) error {
+			want := make([]byte, scratchBytes)
+			if _, err := rand.Read(want); err != nil {
+				return fmt.Errorf("failed to generate canary data: %w", err)
+			}
+			f, err := os.CreateTemp(dir, "parity-canary-*")
+			if err != nil {
+				return fmt.Errorf("failed to create scratch file: %w", err)
+			}
+			path := f.Name()
+			defer os.Remove(path)
+			if _, err := f.Write(want); err != nil {
+				f.Close()
+				return fmt.Errorf("failed to write scratch file: %w", err)
+			}
+			if err := f.Sync(); err != nil {
+				f.Close()
+				return fmt.Errorf("failed to sync scratch file: %w", err)
+			}
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("failed to write scratch file: %w", err)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read scratch file: %w", err)
+			}
+			if !bytes.Equal(got, want) {
+				return fmt.Errorf("scratch file read back %d bytes that differ from the %d written", len(got), len(want))
+			}
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("failed to delete scratch file: %w", err)
+			}
+			return nil
+		},
+	}
+}
+
+// NetworkCheck requests url with client. Any answer short of a server error
+// shows the endpoint is reachable.
+func NetworkCheck(client *http.Client, url string) Check {
+	return Check{
+		Capability: Network,
+		Run: func(ctx context.Context) error {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return fmt.Errorf("failed to create request: %w", err)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return fmt.Errorf("failed to reach %s: %w", url, err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode >= http.StatusInternalServerError {
+				return fmt.Errorf("%s answered with status %d", url, resp.StatusCode)
+			}
+			return nil
+		},
+	}
+}
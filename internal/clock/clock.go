@@ -0,0 +1,99 @@
+// Package clock abstracts time so timing-dependent runner logic can be driven
+// by a fake clock in tests
+package clock
+
+import "time"
+
+// Clock is the subset of the time package the runner depends on
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+	Reset(d time.Duration)
+}
+
+// Real returns the Clock backed by the time package
+func Real() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time { return t.t.C }
+func (t realTimer) Stop() bool          { return t.t.Stop() }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time   { return t.t.C }
+func (t realTicker) Stop()                 { t.t.Stop() }
+func (t realTicker) Reset(d time.Duration) { t.t.Reset(d) }
+
+// Since returns the time elapsed since t according to c
+func Since(c Clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+// Monotonic is implemented by clocks that keep a monotonic reading apart
+// from their wall time, such as fake clocks whose wall time can be stepped
+type Monotonic interface {
+	// Monotonic returns a reading that only advances as time passes
+	Monotonic() time.Duration
+}
+
+// Stopwatch measures elapsed time on a clock's monotonic reading, which
+// wall-clock steps such as NTP corrections do not move. Real clocks carry
+// that reading in the times Now returns; it is lost once a time is
+// converted with UTC, In or Round, or serialized.
+type Stopwatch struct {
+	c     Clock
+	start time.Time
+	mono  time.Duration
+}
+
+// Start starts a stopwatch on c
+func Start(c Clock) Stopwatch {
+	s := Stopwatch{c: c, start: c.Now()}
+	if m, ok := c.(Monotonic); ok {
+		s.mono = m.Monotonic()
+	}
+	return s
+}
+
+// StartedAt returns the wall-clock time the stopwatch was started at
+func (s Stopwatch) StartedAt() time.Time {
+	return s.start
+}
+
+// Elapsed returns the time elapsed since the stopwatch was started
+func (s Stopwatch) Elapsed() time.Duration {
+	if m, ok := s.c.(Monotonic); ok {
+		return m.Monotonic() - s.mono
+	}
+	return s.c.Now().Sub(s.start)
+}
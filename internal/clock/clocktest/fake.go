@@ -0,0 +1,182 @@
+// Package clocktest provides a manually advanced clock for deterministic tests
+package clocktest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+)
+
+// Fake is a clock.Clock whose time only moves when Advance is called. Timers,
+// tickers and sleepers fire in deadline order as time passes them. Its
+// monotonic reading only moves with Advance, so Step can adjust the wall
+// time the way NTP or an operator would.
+type Fake struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	mono    time.Duration
+	waiters []*waiter
+}
+
+type waiter struct {
+	deadline time.Time
+	period   time.Duration
+	ch       chan time.Time
+}
+
+// NewFake returns a fake clock starting at start
+func NewFake(start time.Time) *Fake {
+	f := &Fake{now: start}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Monotonic returns how far the clock has been advanced
+func (f *Fake) Monotonic() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.mono
+}
+
+// Step moves the wall time by d, which may be negative, without time
+// passing: the monotonic reading stays put and pending timers still fire
+// after the same wait
+func (f *Fake) Step(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	for _, w := range f.waiters {
+		w.deadline = w.deadline.Add(d)
+	}
+}
+
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	return f.addWaiter(d, 0).ch
+}
+
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.After(d)
+}
+
+func (f *Fake) NewTimer(d time.Duration) clock.Timer {
+	return &fakeTimer{fake: f, w: f.addWaiter(d, 0)}
+}
+
+func (f *Fake) NewTicker(d time.Duration) clock.Ticker {
+	if d <= 0 {
+		panic("clocktest: non-positive ticker interval")
+	}
+	return &fakeTicker{fake: f, w: f.addWaiter(d, d)}
+}
+
+// Advance moves the clock forward by d, firing every timer, ticker and
+// sleeper whose deadline is reached along the way
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	target := f.now.Add(d)
+	for {
+		sort.SliceStable(f.waiters, func(i, j int) bool {
+			return f.waiters[i].deadline.Before(f.waiters[j].deadline)
+		})
+		if len(f.waiters) == 0 || f.waiters[0].deadline.After(target) {
+			break
+		}
+
+		w := f.waiters[0]
+		f.now = w.deadline
+		select {
+		case w.ch <- f.now:
+		default:
+			// Like time.Ticker, a slow receiver drops ticks
+		}
+		if w.period > 0 {
+			w.deadline = w.deadline.Add(w.period)
+		} else {
+			f.waiters = f.waiters[1:]
+		}
+	}
+	f.now = target
+	f.mono += d
+	f.cond.Broadcast()
+}
+
+// BlockUntil waits until at least n timers, tickers or sleepers are pending,
+// which lets a test know the code under test has reached its wait
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.waiters) < n {
+		f.cond.Wait()
+	}
+}
+
+// Waiters returns the number of pending timers, tickers and sleepers
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+func (f *Fake) addWaiter(d, period time.Duration) *waiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &waiter{deadline: f.now.Add(d), period: period, ch: make(chan time.Time, 1)}
+	if d <= 0 && period == 0 {
+		w.ch <- f.now
+		return w
+	}
+	f.waiters = append(f.waiters, w)
+	f.cond.Broadcast()
+	return w
+}
+
+func (f *Fake) removeWaiter(w *waiter) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, candidate := range f.waiters {
+		if candidate == w {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			f.cond.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	fake *Fake
+	w    *waiter
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.w.ch }
+func (t *fakeTimer) Stop() bool          { return t.fake.removeWaiter(t.w) }
+
+type fakeTicker struct {
+	fake *Fake
+	w    *waiter
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.w.ch }
+func (t *fakeTicker) Stop()               { t.fake.removeWaiter(t.w) }
+
+func (t *fakeTicker) Reset(d time.Duration) {
+	t.fake.removeWaiter(t.w)
+	t.fake.mu.Lock()
+	t.w.deadline = t.fake.now.Add(d)
+	t.w.period = d
+	t.fake.waiters = append(t.fake.waiters, t.w)
+	t.fake.cond.Broadcast()
+	t.fake.mu.Unlock()
+}
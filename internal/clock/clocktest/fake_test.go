@@ -0,0 +1,122 @@
+package clocktest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+)
+
+func TestAdvanceFiresTimersInDeadlineOrder(t *testing.T) {
+	fake := NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := fake.Now()
+
+	late := fake.NewTimer(3 * time.Second)
+	early := fake.After(time.Second)
+	stopped := fake.NewTimer(2 * time.Second)
+	if !stopped.Stop() {
+		t.Fatal("expected Stop to report a pending timer")
+	}
+
+	fake.Advance(2 * time.Second)
+	select {
+	case at := <-early:
+		if at.Sub(start) != time.Second {
+			t.Fatalf("expected timer to fire at its deadline, got %s", at.Sub(start))
+		}
+	default:
+		t.Fatal("expected the 1s timer to fire")
+	}
+	select {
+	case <-late.C():
+		t.Fatal("3s timer fired early")
+	case <-stopped.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+
+	fake.Advance(time.Second)
+	select {
+	case <-late.C():
+	default:
+		t.Fatal("expected the 3s timer to fire")
+	}
+	if fake.Waiters() != 0 {
+		t.Fatalf("expected no pending waiters, got %d", fake.Waiters())
+	}
+}
+
+func TestTickerDropsTicksForSlowReceivers(t *testing.T) {
+	fake := NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	start := fake.Now()
+	ticker := fake.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	fake.Advance(3 * time.Second)
+	if at := <-ticker.C(); at.Sub(start) != time.Second {
+		t.Fatalf("expected the first tick to be kept, got %s", at.Sub(start))
+	}
+	select {
+	case <-ticker.C():
+		t.Fatal("expected later ticks to be dropped while the channel was full")
+	default:
+	}
+
+	ticker.Reset(5 * time.Second)
+	fake.Advance(4 * time.Second)
+	select {
+	case <-ticker.C():
+		t.Fatal("reset ticker fired early")
+	default:
+	}
+	fake.Advance(time.Second)
+	if at := <-ticker.C(); at.Sub(start) != 8*time.Second {
+		t.Fatalf("expected tick at 8s after reset, got %s", at.Sub(start))
+	}
+}
+
+func TestSleepBlocksUntilAdvanced(t *testing.T) {
+	fake := NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	woke := make(chan struct{})
+	go func() {
+		fake.Sleep(time.Minute)
+		close(woke)
+	}()
+
+	fake.BlockUntil(1)
+	fake.Advance(59 * time.Second)
+	select {
+	case <-woke:
+		t.Fatal("sleeper woke early")
+	default:
+	}
+	fake.Advance(time.Second)
+	<-woke
+}
+
+func TestStopwatchIgnoresWallClockSteps(t *testing.T) {
+	start := time.Date(2025, 3, 30, 0, 59, 0, 0, time.UTC)
+	fake := NewFake(start)
+	watch := clock.Start(fake)
+	timer := fake.NewTimer(2 * time.Minute)
+
+	fake.Advance(time.Minute)
+	fake.Step(-time.Hour)
+	fake.Advance(30 * time.Second)
+	fake.Step(10 * time.Second)
+
+	if elapsed := watch.Elapsed(); elapsed != 90*time.Second {
+		t.Fatalf("Elapsed() = %s across wall-clock steps, want 1m30s", elapsed)
+	}
+	if wall := clock.Since(fake, watch.StartedAt()); wall >= 0 {
+		t.Fatalf("wall-clock difference %s, want the step backwards visible", wall)
+	}
+	select {
+	case <-timer.C():
+		t.Fatal("timer fired early after the wall clock stepped")
+	default:
+	}
+	fake.Advance(30 * time.Second)
+	<-timer.C()
+}
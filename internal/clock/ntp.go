@@ -0,0 +1,96 @@
+package clock
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultNTPServer is queried when no NTP server is configured
+	DefaultNTPServer = "pool.ntp.org"
+
+	ntpPacketSize = 48
+	// ntpEpochOffset is the number of seconds from 1900, the NTP epoch, to
+	// 1970
+	ntpEpochOffset = 2208988800
+	// ntpQueryTimeout bounds a query whose context has no deadline
+	ntpQueryTimeout = 5 * time.Second
+)
+
+// ErrNTPResponse is returned for NTP replies that cannot be used
+var ErrNTPResponse = errors.New("invalid NTP response")
+
+// NTPOffset asks the SNTP server at server, a host with an optional port,
+// how far the local clock is from its own. The offset is positive when the
+// local clock is behind the server. The round trip is timed on the
+// monotonic clock so a local clock stepped mid-query does not skew it.
+func NTPOffset(ctx context.Context, server string) (time.Duration, error) {
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reach NTP server %s: %w", server, err)
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(ntpQueryTimeout)
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return 0, fmt.Errorf("failed to set NTP query deadline: %w", err)
+	}
+
+	request := make([]byte, ntpPacketSize)
+	// Leap indicator 0, version 4, client mode
+	request[0] = 0<<6 | 4<<3 | 3
+	sent := time.Now()
+	// The server echoes the transmit timestamp, which ties its reply to
+	// this request
+	binary.BigEndian.PutUint64(request[40:], toNTPTime(sent))
+	if _, err := conn.Write(request); err != nil {
+		return 0, fmt.Errorf("failed to query NTP server %s: %w", server, err)
+	}
+
+	response := make([]byte, ntpPacketSize)
+	n, err := conn.Read(response)
+	if err != nil {
+		return 0, fmt.Errorf("no reply from NTP server %s: %w", server, err)
+	}
+	received := sent.Add(time.Since(sent))
+
+	switch {
+	case n < ntpPacketSize:
+		return 0, fmt.Errorf("%w: %d bytes", ErrNTPResponse, n)
+	case response[0]&0x7 != 4:
+		return 0, fmt.Errorf("%w: not a server reply", ErrNTPResponse)
+	case response[1] == 0:
+		return 0, fmt.Errorf("%w: server declined the query", ErrNTPResponse)
+	case response[0]>>6 == 3:
+		return 0, fmt.Errorf("%w: server clock is not synchronized", ErrNTPResponse)
+	case binary.BigEndian.Uint64(response[24:]) != binary.BigEndian.Uint64(request[40:]):
+		return 0, fmt.Errorf("%w: reply does not match the query", ErrNTPResponse)
+	}
+
+	serverReceived := fromNTPTime(binary.BigEndian.Uint64(response[32:]))
+	serverSent := fromNTPTime(binary.BigEndian.Uint64(response[40:]))
+	return (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2, nil
+}
+
+func toNTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+func fromNTPTime(v uint64) time.Time {
+	seconds := int64(v>>32) - ntpEpochOffset
+	nanos := int64((v & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanos)
+}
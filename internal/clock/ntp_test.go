@@ -0,0 +1,66 @@
+package clock
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveNTP answers queries on a local UDP port from a clock ahead of the
+// local one by ahead, with stratum as its stratum
+func serveNTP(t *testing.T, ahead time.Duration, stratum byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("UDP unavailable: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, ntpPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < ntpPacketSize {
+				continue
+			}
+			reply := make([]byte, ntpPacketSize)
+			reply[0] = 4<<3 | 4
+			reply[1] = stratum
+			copy(reply[24:32], buf[40:48])
+			binary.BigEndian.PutUint64(reply[32:], toNTPTime(time.Now().Add(ahead)))
+			binary.BigEndian.PutUint64(reply[40:], toNTPTime(time.Now().Add(ahead)))
+			conn.WriteTo(reply, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestNTPOffset(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	offset, err := NTPOffset(ctx, serveNTP(t, -3*time.Second, 2))
+	if err != nil {
+		t.Fatalf("NTPOffset() error = %v", err)
+	}
+	if offset > -2900*time.Millisecond || offset < -3100*time.Millisecond {
+		t.Fatalf("NTPOffset() = %s, want about -3s for a server behind the local clock", offset)
+	}
+
+	if _, err := NTPOffset(ctx, serveNTP(t, 0, 0)); !errors.Is(err, ErrNTPResponse) {
+		t.Fatalf("NTPOffset() error = %v for a kiss-of-death reply, want ErrNTPResponse", err)
+	}
+}
+
+func TestNTPTimeRoundTrip(t *testing.T) {
+	at := time.Date(2026, 10, 25, 1, 30, 0, 123456789, time.UTC)
+	if got := fromNTPTime(toNTPTime(at)); got.Sub(at).Abs() > time.Microsecond {
+		t.Fatalf("round trip of %s gave %s", at, got)
+	}
+}
@@ -0,0 +1,340 @@
+// Package concurrency decides how many tasks a runner runs at once. The
+// limit starts where it is configured and grows by one each time a round
+// of tasks, as many as the limit, ran at the limit about as fast as tasks
+// like them usually run. It is cut multiplicatively once a round runs
+// slower than that or a task times out, and while the host reports
+// pressure stalls or thermal throttling. Additive increase and
+// multiplicative decrease settle the limit around the most tasks the host
+// runs without slowing each of them down, and the limit never leaves the
+// bounds configured, the upper of which is what the host can reserve for
+// its tasks.
+package concurrency
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const (
+	// latencyPercentile is the percentile of a round's tasks held against
+	// how long they usually run
+	latencyPercentile = 90
+	// decisionHistory is how many decisions Status reports
+	decisionHistory = 20
+)
+
+// pressureResources are the resources whose host-wide pressure is read
+var pressureResources = []string{"cpu", "memory", "io"}
+
+// Config bounds the limit and sets how it moves
+type Config struct {
+	// Initial is the limit the controller starts at, and keeps when Pinned
+	Initial int
+	Min     int
+	// Max is the most tasks the host can reserve resources for
+	Max    int
+	Pinned bool
+	// Backoff is the factor the limit is cut by, within (0, 1)
+	Backoff float64
+	// SlowRatio is how many times their usual duration a round's tasks may
+	// run before the limit is cut
+	SlowRatio float64
+	// Pressure is the share of time, in percent, tasks on the host may be
+	// stalled on a resource before the host counts as saturated; zero
+	// ignores pressure
+	Pressure float64
+	// Cooldown is the least time between cuts for a saturated host, so the
+	// limit a cut set has time to relieve it
+	Cooldown time.Duration
+}
+
+// Sample is a task that finished
+type Sample struct {
+	Started  time.Time
+	Duration time.Duration
+	// Expected is how long tasks like it usually run, zero when unknown
+	Expected time.Duration
+	// InFlight is how many tasks were running as it finished, itself
+	// included
+	InFlight int
+	TimedOut bool
+}
+
+// Signals is how saturated the host is
+type Signals struct {
+	// Pressure is the highest share of time, in percent, tasks were
+	// stalled on a resource
+	Pressure  float64
+	Throttled bool
+}
+
+// Controller holds the limit on how many tasks run at once
+type Controller struct {
+	config Config
+	clock  clock.Clock
+
+	mu    sync.Mutex
+	limit int
+	// changedAt is when the limit last changed; tasks started before then
+	// ran under the old limit and say nothing of the current one
+	changedAt time.Time
+	// The round of tasks finished under the current limit: how many, how
+	// many times their usual duration those with one ran, and whether one
+	// ran with the limit reached
+	finished  int
+	ratios    []float64
+	saturated bool
+	signals   Signals
+	cutAt     time.Time
+	increases uint64
+	decreases uint64
+	decisions []models.ConcurrencyDecision
+}
+
+// New returns a controller starting at config's initial limit
+func New(config Config) (*Controller, error) {
+	if config.Min < 1 {
+		return nil, fmt.Errorf("concurrency minimum must be at least 1, got %d", config.Min)
+	}
+	if config.Max < config.Min {
+		return nil, fmt.Errorf("concurrency maximum %d is below the minimum %d", config.Max, config.Min)
+	}
+	if config.Initial < config.Min || config.Initial > config.Max {
+		return nil, fmt.Errorf("initial concurrency %d is outside [%d, %d]", config.Initial, config.Min, config.Max)
+	}
+	if config.Backoff <= 0 || config.Backoff >= 1 {
+		return nil, fmt.Errorf("concurrency backoff must be within (0, 1), got %g", config.Backoff)
+	}
+	if config.SlowRatio <= 1 {
+		return nil, fmt.Errorf("concurrency slow ratio must be above 1, got %g", config.SlowRatio)
+	}
+	if config.Pressure < 0 || config.Pressure > 100 {
+		return nil, fmt.Errorf("concurrency pressure threshold must be within [0, 100], got %g", config.Pressure)
+	}
+	return &Controller{
+		config: config,
+		clock:  clock.Real(),
+		limit:  config.Initial,
+	}, nil
+}
+
+// SetClock replaces the clock decisions are timed with
+func (c *Controller) SetClock(clk clock.Clock) {
+	c.clock = clk
+}
+
+// Limit returns how many tasks may run at once. Without a controller one
+// task runs at a time.
+func (c *Controller) Limit() int {
+	if c == nil {
+		return 1
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.limit
+}
+
+// Admits reports whether another task may start beside inFlight others
+func (c *Controller) Admits(inFlight int) bool {
+	return inFlight < c.Limit()
+}
+
+// Observe records a task that finished, raising the limit once a round of
+// tasks ran at it as fast as usual and cutting it once they ran slower
+func (c *Controller) Observe(s Sample) {
+	if c == nil || c.config.Pinned {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if s.Started.Before(c.changedAt) {
+		return
+	}
+	if s.TimedOut {
+		c.cut(models.ConcurrencyTimeout, fmt.Sprintf("a task timed out after %s", s.Duration.Round(time.Second)))
+		return
+	}
+	c.finished++
+	if s.Expected > 0 {
+		c.ratios = append(c.ratios, float64(s.Duration)/float64(s.Expected))
+	}
+	if s.InFlight >= c.limit {
+		c.saturated = true
+	}
+	if c.finished < c.limit {
+		return
+	}
+
+	ratio := percentile(c.ratios, latencyPercentile)
+	switch {
+	case ratio > c.config.SlowRatio:
+		c.cut(models.ConcurrencySlow, fmt.Sprintf("p%d of the last %d tasks ran %.2fx their usual duration", latencyPercentile, len(c.ratios), ratio))
+	case c.saturated && !c.hostSaturated() && c.limit < c.config.Max:
+		c.set(c.limit+1, models.ConcurrencyHealthy, fmt.Sprintf("p%d of the last %d tasks ran %.2fx their usual duration", latencyPercentile, len(c.ratios), ratio))
+	default:
+		c.resetRound()
+	}
+}
+
+// Sense records how saturated the host is, cutting the limit while it is
+// no more often than the cool-down allows
+func (c *Controller) Sense(signals Signals) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.signals = signals
+	if c.config.Pinned || !c.hostSaturated() {
+		return
+	}
+	now := c.clock.Now()
+	if !c.cutAt.IsZero() && now.Sub(c.cutAt) < c.config.Cooldown {
+		return
+	}
+	if signals.Throttled {
+		c.cut(models.ConcurrencyThrottled, "the host is thermally throttled")
+	} else {
+		c.cut(models.ConcurrencyPressure, fmt.Sprintf("tasks stalled %.1f%% of the time", signals.Pressure))
+	}
+	c.cutAt = now
+}
+
+// hostSaturated reports whether the signals last sensed saturate the host
+func (c *Controller) hostSaturated() bool {
+	return c.signals.Throttled || (c.config.Pressure > 0 && c.signals.Pressure >= c.config.Pressure)
+}
+
+// cut lowers the limit by the backoff factor, and by at least one
+func (c *Controller) cut(reason, detail string) {
+	limit := int(math.Floor(float64(c.limit) * c.config.Backoff))
+	if limit >= c.limit {
+		limit = c.limit - 1
+	}
+	if limit < c.config.Min {
+		limit = c.config.Min
+	}
+	c.set(limit, reason, detail)
+}
+
+// set changes the limit, starting a new round
+func (c *Controller) set(limit int, reason, detail string) {
+	from := c.limit
+	c.resetRound()
+	if limit == from {
+		return
+	}
+	now := c.clock.Now()
+	c.limit = limit
+	c.changedAt = now
+	if limit > from {
+		c.increases++
+	} else {
+		c.decreases++
+	}
+	c.decisions = append(c.decisions, models.ConcurrencyDecision{Time: now, From: from, To: limit, Reason: reason, Detail: detail})
+	if len(c.decisions) > decisionHistory {
+		c.decisions = c.decisions[len(c.decisions)-decisionHistory:]
+	}
+
+	log := gologger.WithComponent("concurrency")
+	log.Info().
+		Int("from", from).
+		Int("to", limit).
+		Str("reason", reason).
+		Str("detail", detail).
+		Msg("Concurrency limit changed")
+}
+
+func (c *Controller) resetRound() {
+	c.finished = 0
+	c.ratios = c.ratios[:0]
+	c.saturated = false
+}
+
+// Status returns the limit and the latest decisions, nil without a
+// controller
+func (c *Controller) Status() *models.ConcurrencyStatus {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return &models.ConcurrencyStatus{
+		Limit:     c.limit,
+		Min:       c.config.Min,
+		Max:       c.config.Max,
+		Pinned:    c.config.Pinned,
+		Pressure:  c.signals.Pressure,
+		Throttled: c.signals.Throttled,
+		Increases: c.increases,
+		Decreases: c.decreases,
+		Decisions: append([]models.ConcurrencyDecision(nil), c.decisions...),
+	}
+}
+
+// percentile returns the pth percentile of values, zero without any
+func percentile(values []float64, p int) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	index := int(math.Ceil(float64(p)/100*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+// ReadPressure returns the highest share of the last ten seconds, in
+// percent, some task on the host was stalled on CPU, memory or IO, from the
+// pressure stall information (PSI) files under root, such as /proc/pressure
+func ReadPressure(root string) (float64, error) {
+	highest, read := 0.0, false
+	for _, resource := range pressureResources {
+		data, err := os.ReadFile(filepath.Join(root, resource))
+		if err != nil {
+			continue
+		}
+		if avg10, ok := parsePressure(string(data)); ok {
+			read = true
+			highest = math.Max(highest, avg10)
+		}
+	}
+	if !read {
+		return 0, fmt.Errorf("no pressure stall information under %s", root)
+	}
+	return highest, nil
+}
+
+// parsePressure returns the avg10 of the "some" line of a PSI file
+func parsePressure(data string) (float64, bool) {
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "some" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if value, ok := strings.CutPrefix(field, "avg10="); ok {
+				avg10, err := strconv.ParseFloat(value, 64)
+				return avg10, err == nil
+			}
+		}
+	}
+	return 0, false
+}
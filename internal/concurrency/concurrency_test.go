@@ -0,0 +1,243 @@
+package concurrency
+
+import (
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func testConfig() Config {
+	return Config{Initial: 1, Min: 1, Max: 16, Backoff: 0.7, SlowRatio: 1.25, Pressure: 60, Cooldown: time.Minute}
+}
+
+func newTestController(t *testing.T, config Config) (*Controller, *clocktest.Fake) {
+	t.Helper()
+	c, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clocktest.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	c.SetClock(clk)
+	return c, clk
+}
+
+// fakeExecutor runs tasks that take base alone and as long up to optimum
+// at once, each further task slowing every one of them down
+type fakeExecutor struct {
+	base    time.Duration
+	optimum int
+	rng     *rand.Rand
+}
+
+func (e *fakeExecutor) duration(inFlight int) time.Duration {
+	factor := 1.0
+	if inFlight > e.optimum {
+		factor += 0.3 * float64(inFlight-e.optimum)
+	}
+	jitter := 0.95 + 0.1*e.rng.Float64()
+	return time.Duration(float64(e.base) * factor * jitter)
+}
+
+type simTask struct {
+	started, finishes time.Time
+}
+
+// simulate keeps the runner busy with as many tasks as the controller
+// admits until n have finished, returning the limit after each
+func simulate(c *Controller, clk *clocktest.Fake, exec *fakeExecutor, n int) []int {
+	var running []simTask
+	limits := make([]int, 0, n)
+	for len(limits) < n {
+		for c.Admits(len(running)) {
+			now := clk.Now()
+			running = append(running, simTask{started: now, finishes: now.Add(exec.duration(len(running) + 1))})
+		}
+		sort.Slice(running, func(i, j int) bool { return running[i].finishes.Before(running[j].finishes) })
+		next := running[0]
+		clk.Advance(next.finishes.Sub(clk.Now()))
+		c.Observe(Sample{
+			Started:  next.started,
+			Duration: next.finishes.Sub(next.started),
+			Expected: exec.base,
+			InFlight: len(running),
+		})
+		running = running[1:]
+		limits = append(limits, c.Limit())
+	}
+	return limits
+}
+
+func TestControllerConvergesNearOptimum(t *testing.T) {
+	for _, optimum := range []int{3, 6, 10} {
+		c, clk := newTestController(t, testConfig())
+		exec := &fakeExecutor{base: time.Minute, optimum: optimum, rng: rand.New(rand.NewSource(int64(optimum)))}
+		limits := simulate(c, clk, exec, 3000)
+
+		tail := limits[len(limits)/2:]
+		sum, highest := 0, 0
+		for _, limit := range tail {
+			sum += limit
+			if limit > highest {
+				highest = limit
+			}
+		}
+		mean := float64(sum) / float64(len(tail))
+		if math.Abs(mean-float64(optimum)) > 1 {
+			t.Errorf("optimum %d: mean limit %.2f once settled, want near the optimum", optimum, mean)
+		}
+		if highest > optimum+1 {
+			t.Errorf("optimum %d: limit reached %d once settled", optimum, highest)
+		}
+		status := c.Status()
+		if status.Increases == 0 || status.Decreases == 0 || len(status.Decisions) == 0 {
+			t.Errorf("optimum %d: status = %+v, want both increases and decreases recorded", optimum, status)
+		}
+	}
+}
+
+func TestControllerNeverExceedsMax(t *testing.T) {
+	config := testConfig()
+	config.Max = 4
+	c, clk := newTestController(t, config)
+	// Tasks never slow down, so only the maximum holds the limit
+	exec := &fakeExecutor{base: time.Minute, optimum: 100, rng: rand.New(rand.NewSource(1))}
+	for i, limit := range simulate(c, clk, exec, 500) {
+		if limit > config.Max {
+			t.Fatalf("limit %d after task %d, above the maximum %d", limit, i, config.Max)
+		}
+	}
+	if got := c.Limit(); got != config.Max {
+		t.Errorf("Limit() = %d, want the maximum %d", got, config.Max)
+	}
+}
+
+func TestControllerPinned(t *testing.T) {
+	config := testConfig()
+	config.Initial = 3
+	config.Pinned = true
+	c, clk := newTestController(t, config)
+	exec := &fakeExecutor{base: time.Minute, optimum: 1, rng: rand.New(rand.NewSource(1))}
+	for _, limit := range simulate(c, clk, exec, 200) {
+		if limit != 3 {
+			t.Fatalf("pinned limit moved to %d", limit)
+		}
+	}
+	c.Sense(Signals{Pressure: 90, Throttled: true})
+	if status := c.Status(); status.Limit != 3 || !status.Pinned || len(status.Decisions) != 0 {
+		t.Errorf("pinned status = %+v", status)
+	}
+}
+
+func TestControllerBacksOffOnSaturation(t *testing.T) {
+	config := testConfig()
+	config.Initial = 10
+	c, clk := newTestController(t, config)
+
+	c.Sense(Signals{Pressure: 30})
+	if got := c.Limit(); got != 10 {
+		t.Fatalf("Limit() = %d below the pressure threshold, want 10", got)
+	}
+	c.Sense(Signals{Pressure: 75})
+	if got := c.Limit(); got != 7 {
+		t.Fatalf("Limit() = %d under pressure, want 7", got)
+	}
+	// Cuts for the host wait out the cool-down
+	clk.Advance(30 * time.Second)
+	c.Sense(Signals{Pressure: 75})
+	if got := c.Limit(); got != 7 {
+		t.Fatalf("Limit() = %d within the cool-down, want 7", got)
+	}
+	clk.Advance(30 * time.Second)
+	c.Sense(Signals{Throttled: true})
+	if got := c.Limit(); got != 4 {
+		t.Fatalf("Limit() = %d when throttled, want 4", got)
+	}
+
+	// A healthy round does not raise the limit while the host is saturated
+	for i := 0; i < 4; i++ {
+		c.Observe(Sample{Started: clk.Now(), Duration: time.Minute, Expected: time.Minute, InFlight: 4})
+	}
+	if got := c.Limit(); got != 4 {
+		t.Errorf("Limit() = %d raised while throttled, want 4", got)
+	}
+	c.Sense(Signals{})
+	for i := 0; i < 4; i++ {
+		c.Observe(Sample{Started: clk.Now(), Duration: time.Minute, Expected: time.Minute, InFlight: 4})
+	}
+	if got := c.Limit(); got != 5 {
+		t.Errorf("Limit() = %d once the host recovered, want 5", got)
+	}
+
+	decisions := c.Status().Decisions
+	reasons := make([]string, len(decisions))
+	for i, decision := range decisions {
+		reasons[i] = decision.Reason
+	}
+	want := []string{models.ConcurrencyPressure, models.ConcurrencyThrottled, models.ConcurrencyHealthy}
+	if len(reasons) != len(want) || reasons[0] != want[0] || reasons[1] != want[1] || reasons[2] != want[2] {
+		t.Errorf("decision reasons = %v, want %v", reasons, want)
+	}
+}
+
+func TestControllerTimeoutsAndStaleSamples(t *testing.T) {
+	config := testConfig()
+	config.Initial = 8
+	c, clk := newTestController(t, config)
+
+	started := clk.Now()
+	clk.Advance(time.Minute)
+	c.Observe(Sample{Started: started, Duration: time.Minute, InFlight: 8, TimedOut: true})
+	if got := c.Limit(); got != 5 {
+		t.Fatalf("Limit() = %d after a timeout, want 5", got)
+	}
+	// Tasks started under the old limit say nothing of the new one
+	c.Observe(Sample{Started: started, Duration: time.Minute, InFlight: 8, TimedOut: true})
+	if got := c.Limit(); got != 5 {
+		t.Errorf("Limit() = %d after a task started before the cut, want 5", got)
+	}
+
+	// Without tasks waiting, a fast round does not raise the limit
+	for i := 0; i < 5; i++ {
+		c.Observe(Sample{Started: clk.Now(), Duration: time.Minute, Expected: time.Minute, InFlight: 1})
+	}
+	if got := c.Limit(); got != 5 {
+		t.Errorf("Limit() = %d after a round below the limit, want 5", got)
+	}
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	for _, mutate := range []func(*Config){
+		func(c *Config) { c.Min = 0 },
+		func(c *Config) { c.Max = 0 },
+		func(c *Config) { c.Initial = 20 },
+		func(c *Config) { c.Backoff = 1 },
+		func(c *Config) { c.SlowRatio = 1 },
+		func(c *Config) { c.Pressure = 120 },
+	} {
+		config := testConfig()
+		mutate(&config)
+		if _, err := New(config); err == nil {
+			t.Errorf("New(%+v) accepted the config", config)
+		}
+	}
+}
+
+func TestReadPressure(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "cpu"), []byte("some avg10=12.50 avg60=3.00 avg300=1.00 total=100\n"), 0o600)
+	os.WriteFile(filepath.Join(root, "memory"), []byte("some avg10=40.25 avg60=0.00 avg300=0.00 total=5\nfull avg10=90.00 avg60=0.00 avg300=0.00 total=5\n"), 0o600)
+	pressure, err := ReadPressure(root)
+	if err != nil || pressure != 40.25 {
+		t.Errorf("ReadPressure() = %g, %v, want 40.25", pressure, err)
+	}
+	if _, err := ReadPressure(t.TempDir()); err == nil {
+		t.Error("ReadPressure() of a host without PSI succeeded")
+	}
+}
@@ -0,0 +1,151 @@
+// Package confirm asks the operator of an interactive runner to approve
+// expensive tasks before they are claimed. A Gate compares a task's cost
+// estimate against thresholds and, for tasks above any of them, waits for a
+// Prompter's answer, denying the task when none comes in time.
+package confirm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ErrNotConfirmed is returned for tasks the operator did not approve
+var ErrNotConfirmed = errors.New("task not confirmed by operator")
+
+// Decision is the outcome of a confirmation request
+type Decision string
+
+const (
+	Approved Decision = "approved"
+	Denied   Decision = "denied"
+	TimedOut Decision = "timed_out"
+)
+
+// Estimate is what a task is expected to cost. Zero values are unknown.
+type Estimate struct {
+	Duration  time.Duration `json:"duration_ns,omitempty"`
+	DiskBytes int64         `json:"disk_bytes,omitempty"`
+	GPU       bool          `json:"gpu,omitempty"`
+}
+
+// Thresholds are the costs above which a task needs confirmation. Zero
+// durations and sizes never require it.
+type Thresholds struct {
+	Duration  time.Duration
+	DiskBytes int64
+	GPU       bool
+}
+
+// Exceeded lists the thresholds estimate is above
+func (t Thresholds) Exceeded(estimate Estimate) []string {
+	var reasons []string
+	if t.Duration > 0 && estimate.Duration > t.Duration {
+		reasons = append(reasons, fmt.Sprintf("estimated duration %s exceeds %s", estimate.Duration, t.Duration))
+	}
+	if t.DiskBytes > 0 && estimate.DiskBytes > t.DiskBytes {
+		reasons = append(reasons, fmt.Sprintf("estimated disk use of %d bytes exceeds %d", estimate.DiskBytes, t.DiskBytes))
+	}
+	if t.GPU && estimate.GPU {
+		reasons = append(reasons, "task uses a GPU")
+	}
+	return reasons
+}
+
+// Request describes a task awaiting confirmation
+type Request struct {
+	TaskID   string          `json:"task_id"`
+	Type     models.TaskType `json:"type"`
+	Title    string          `json:"title,omitempty"`
+	Reward   float64         `json:"reward,omitempty"`
+	Estimate Estimate        `json:"estimate"`
+	// Reasons are the thresholds the task exceeds
+	Reasons []string `json:"reasons"`
+}
+
+// Prompter asks the operator whether to claim a task. It returns once the
+// operator answers or ctx is done, whichever is first.
+type Prompter interface {
+	Prompt(ctx context.Context, req Request) (bool, error)
+}
+
+// Result is a decision and how long it took
+type Result struct {
+	Decision Decision
+	Latency  time.Duration
+}
+
+// Gate asks for confirmation of tasks above its thresholds
+type Gate struct {
+	prompter   Prompter
+	thresholds Thresholds
+	timeout    time.Duration
+	clock      clock.Clock
+}
+
+// NewGate creates a gate asking prompter about tasks above thresholds, and
+// denying those it does not answer within timeout
+func NewGate(prompter Prompter, thresholds Thresholds, timeout time.Duration) *Gate {
+	return &Gate{
+		prompter:   prompter,
+		thresholds: thresholds,
+		timeout:    timeout,
+		clock:      clock.Real(),
+	}
+}
+
+// SetClock replaces the clock latencies and timeouts are measured with
+func (g *Gate) SetClock(c clock.Clock) {
+	g.clock = c
+}
+
+// Needs returns the thresholds estimate exceeds, none when the task can be
+// claimed without asking
+func (g *Gate) Needs(estimate Estimate) []string {
+	return g.thresholds.Exceeded(estimate)
+}
+
+// Confirm asks the operator about req, blocking only the caller. A prompt
+// that fails or is not answered within the gate's timeout denies the task.
+func (g *Gate) Confirm(ctx context.Context, req Request) (Result, error) {
+	start := g.clock.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type answer struct {
+		approved bool
+		err      error
+	}
+	answers := make(chan answer, 1)
+	go func() {
+		approved, err := g.prompter.Prompt(ctx, req)
+		answers <- answer{approved, err}
+	}()
+
+	var timeout <-chan time.Time
+	if g.timeout > 0 {
+		timer := g.clock.NewTimer(g.timeout)
+		defer timer.Stop()
+		timeout = timer.C()
+	}
+
+	select {
+	case a := <-answers:
+		result := Result{Decision: Denied, Latency: g.clock.Now().Sub(start)}
+		if a.err != nil {
+			return result, fmt.Errorf("confirmation prompt failed: %w", a.err)
+		}
+		if a.approved {
+			result.Decision = Approved
+		}
+		return result, nil
+	case <-timeout:
+		return Result{Decision: TimedOut, Latency: g.clock.Now().Sub(start)}, nil
+	case <-ctx.Done():
+		return Result{Decision: TimedOut, Latency: g.clock.Now().Sub(start)}, ctx.Err()
+	}
+}
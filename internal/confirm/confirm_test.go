@@ -0,0 +1,143 @@
+package confirm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+)
+
+// scripted answers each prompt with its answer after wait, or never when
+// wait is negative
+type scripted struct {
+	approve bool
+	err     error
+	wait    time.Duration
+	asked   chan Request
+}
+
+func (s *scripted) Prompt(ctx context.Context, req Request) (bool, error) {
+	if s.asked != nil {
+		s.asked <- req
+	}
+	if s.wait < 0 {
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+	return s.approve, s.err
+}
+
+var start = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestThresholdsExceeded(t *testing.T) {
+	thresholds := Thresholds{Duration: time.Hour, DiskBytes: 1 << 30, GPU: true}
+	if reasons := thresholds.Exceeded(Estimate{Duration: time.Minute, DiskBytes: 1 << 20}); len(reasons) != 0 {
+		t.Errorf("cheap task exceeds %v", reasons)
+	}
+	if reasons := thresholds.Exceeded(Estimate{Duration: 2 * time.Hour, DiskBytes: 2 << 30, GPU: true}); len(reasons) != 3 {
+		t.Errorf("expensive task exceeds %v, want all three thresholds", reasons)
+	}
+	if reasons := (Thresholds{}).Exceeded(Estimate{Duration: 1000 * time.Hour, DiskBytes: 1 << 40, GPU: true}); len(reasons) != 0 {
+		t.Errorf("zero thresholds require confirmation for %v", reasons)
+	}
+}
+
+func TestGateDecisions(t *testing.T) {
+	tests := []struct {
+		name     string
+		prompter *scripted
+		want     Decision
+		wantErr  bool
+	}{
+		{"approve", &scripted{approve: true}, Approved, false},
+		{"deny", &scripted{approve: false}, Denied, false},
+		{"prompt fails", &scripted{approve: true, err: errors.New("no display")}, Denied, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gate := NewGate(tt.prompter, Thresholds{GPU: true}, time.Minute)
+			gate.SetClock(clocktest.NewFake(start))
+			result, err := gate.Confirm(context.Background(), Request{TaskID: "t-1"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Confirm() error = %v, want error %v", err, tt.wantErr)
+			}
+			if result.Decision != tt.want {
+				t.Errorf("Decision = %s, want %s", result.Decision, tt.want)
+			}
+		})
+	}
+}
+
+func TestGateDeniesAfterTimeout(t *testing.T) {
+	clk := clocktest.NewFake(start)
+	prompter := &scripted{wait: -1, asked: make(chan Request, 1)}
+	gate := NewGate(prompter, Thresholds{GPU: true}, 2*time.Minute)
+	gate.SetClock(clk)
+
+	done := make(chan Result, 1)
+	go func() {
+		result, _ := gate.Confirm(context.Background(), Request{TaskID: "t-1"})
+		done <- result
+	}()
+	<-prompter.asked
+	clk.BlockUntil(1)
+	clk.Advance(2 * time.Minute)
+
+	result := <-done
+	if result.Decision != TimedOut || result.Latency != 2*time.Minute {
+		t.Errorf("Confirm() = %+v, want timed out after 2m", result)
+	}
+}
+
+func TestTerminalPrompt(t *testing.T) {
+	in, typed := io.Pipe()
+	var out strings.Builder
+	terminal := NewTerminal(in, &out)
+
+	answers := make(chan bool, 1)
+	go func() {
+		approved, _ := terminal.Prompt(context.Background(), Request{TaskID: "t-1", Type: "docker", Reasons: []string{"task uses a GPU"}})
+		answers <- approved
+	}()
+	if _, err := io.WriteString(typed, "yes\n"); err != nil {
+		t.Fatal(err)
+	}
+	if !<-answers {
+		t.Error("yes did not approve the task")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if approved, err := terminal.Prompt(ctx, Request{TaskID: "t-2"}); approved || !errors.Is(err, context.Canceled) {
+		t.Errorf("Prompt() = %v, %v without an answer", approved, err)
+	}
+	typed.Close()
+}
+
+func TestHookExitStatus(t *testing.T) {
+	for _, tt := range []struct {
+		script  string
+		approve bool
+		wantErr bool
+	}{
+		{`grep -q '"task_id":"t-1"' && test "$PARITY_CONFIRM_TASK_ID" = t-1`, true, false},
+		{"exit 1", false, false},
+		{"exit 3", false, true},
+	} {
+		hook, err := NewHook([]string{"sh", "-c", tt.script})
+		if err != nil {
+			t.Fatal(err)
+		}
+		approved, err := hook.Prompt(context.Background(), Request{TaskID: "t-1"})
+		if approved != tt.approve || (err != nil) != tt.wantErr {
+			t.Errorf("%s: Prompt() = %v, %v", tt.script, approved, err)
+		}
+	}
+	if _, err := NewHook(nil); err == nil {
+		t.Error("NewHook() accepted an empty command")
+	}
+}
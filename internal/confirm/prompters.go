@@ -0,0 +1,136 @@
+package confirm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Terminal asks on a terminal, one task at a time, and reads a yes or no
+// answer. Lines typed while no task is pending are discarded.
+type Terminal struct {
+	out io.Writer
+
+	// mu serializes prompts so their questions and answers do not
+	// interleave
+	mu    sync.Mutex
+	lines chan string
+}
+
+// NewTerminal creates a prompter writing questions to out and reading
+// answers from in
+func NewTerminal(in io.Reader, out io.Writer) *Terminal {
+	t := &Terminal{out: out, lines: make(chan string)}
+	go t.read(in)
+	return t
+}
+
+func (t *Terminal) read(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		t.lines <- scanner.Text()
+	}
+	close(t.lines)
+}
+
+// Prompt asks whether to claim req's task
+func (t *Terminal) Prompt(ctx context.Context, req Request) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Drop answers typed before the question was asked
+drain:
+	for {
+		select {
+		case _, ok := <-t.lines:
+			if !ok {
+				return false, io.EOF
+			}
+		default:
+			break drain
+		}
+	}
+
+	title := req.Title
+	if title == "" {
+		title = req.TaskID
+	}
+	fmt.Fprintf(t.out, "\nClaim %s task %q?\n", req.Type, title)
+	for _, reason := range req.Reasons {
+		fmt.Fprintf(t.out, "  - %s\n", reason)
+	}
+	if req.Reward > 0 {
+		fmt.Fprintf(t.out, "  reward: %g\n", req.Reward)
+	}
+	fmt.Fprint(t.out, "Claim it? [y/N] ")
+
+	select {
+	case line, ok := <-t.lines:
+		if !ok {
+			return false, io.EOF
+		}
+		answer := strings.ToLower(strings.TrimSpace(line))
+		return answer == "y" || answer == "yes", nil
+	case <-ctx.Done():
+		fmt.Fprintln(t.out, "\nNo answer, not claiming the task")
+		return false, ctx.Err()
+	}
+}
+
+// Hook runs a command for each task, such as one raising a desktop
+// notification with approve and deny buttons. The request is passed as JSON
+// on stdin and in PARITY_CONFIRM_* environment variables. Exit status zero
+// approves the task, exit status one denies it, and anything else is an
+// error.
+type Hook struct {
+	command []string
+}
+
+// NewHook creates a prompter running command, a program and its arguments
+func NewHook(command []string) (*Hook, error) {
+	if len(command) == 0 || command[0] == "" {
+		return nil, fmt.Errorf("confirmation hook command is required")
+	}
+	return &Hook{command: command}, nil
+}
+
+// Prompt runs the hook for req, killing it once ctx is done
+func (h *Hook) Prompt(ctx context.Context, req Request) (bool, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode confirmation request: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, h.command[0], h.command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(),
+		"PARITY_CONFIRM_TASK_ID="+req.TaskID,
+		"PARITY_CONFIRM_TASK_TYPE="+string(req.Type),
+		"PARITY_CONFIRM_TASK_TITLE="+req.Title,
+		"PARITY_CONFIRM_REASONS="+strings.Join(req.Reasons, "; "),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return false, ctxErr
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return false, nil
+	default:
+		return false, fmt.Errorf("confirmation hook failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+}
@@ -48,12 +48,737 @@ type SchedulerConfig struct {
 }
 
 type RunnerConfig struct {
-	ServerURL         string        `mapstructure:"SERVER_URL"`
-	WebhookPort       int           `mapstructure:"WEBHOOK_PORT"`
+	ServerURL         string                 `mapstructure:"SERVER_URL"`
+	WebhookPort       int                    `mapstructure:"WEBHOOK_PORT"`
+	HeartbeatInterval time.Duration          `mapstructure:"HEARTBEAT_INTERVAL"`
+	ExecutionTimeout  time.Duration          `mapstructure:"EXECUTION_TIMEOUT"`
+	Docker            DockerConfig           `mapstructure:"DOCKER"`
+	Tunnel            TunnelConfig           `mapstructure:"TUNNEL"`
+	Health            HealthConfig           `mapstructure:"HEALTH"`
+	AdaptiveTimeout   AdaptiveTimeoutConfig  `mapstructure:"ADAPTIVE_TIMEOUT"`
+	History           HistoryConfig          `mapstructure:"HISTORY"`
+	TaskTimeout       TaskTimeoutConfig      `mapstructure:"TASK_TIMEOUT"`
+	Callback          CallbackConfig         `mapstructure:"CALLBACK"`
+	NetworkOverrides  NetworkOverridesConfig `mapstructure:"NETWORK_OVERRIDES"`
+	SecurityProfiles  SecurityProfilesConfig `mapstructure:"SECURITY_PROFILES"`
+	Bandwidth         BandwidthConfig        `mapstructure:"BANDWIDTH"`
+	ImageExport       ImageExportConfig      `mapstructure:"IMAGE_EXPORT"`
+	Cache             CacheConfig            `mapstructure:"CACHE"`
+	Attestation       AttestationConfig      `mapstructure:"ATTESTATION"`
+	TokenUsage        TokenUsageConfig       `mapstructure:"TOKEN_USAGE"`
+	PromptCache       PromptCacheConfig      `mapstructure:"PROMPT_CACHE"`
+	CustomModels      CustomModelsConfig     `mapstructure:"CUSTOM_MODELS"`
+	WarmModels        WarmModelsConfig       `mapstructure:"WARM_MODELS"`
+	ToolUse           ToolUseConfig          `mapstructure:"TOOL_USE"`
+	IdleWork          IdleWorkConfig         `mapstructure:"IDLE_WORK"`
+	ArtifactChunking  ArtifactChunkingConfig `mapstructure:"ARTIFACT_CHUNKING"`
+	Revocation        RevocationConfig       `mapstructure:"REVOCATION"`
+	APIRetry          APIRetryConfig         `mapstructure:"API_RETRY"`
+	Materialize       MaterializeConfig      `mapstructure:"MATERIALIZE"`
+	Transport         string                 `mapstructure:"TRANSPORT"`
+	TaskSocket        TaskSocketConfig       `mapstructure:"TASK_SOCKET"`
+	ArtifactPreview   ArtifactPreviewConfig  `mapstructure:"ARTIFACT_PREVIEW"`
+	ResultOffload     ResultOffloadConfig    `mapstructure:"RESULT_OFFLOAD"`
+	IPFSFetch         IPFSFetchConfig        `mapstructure:"IPFS_FETCH"`
+	Reputation        ReputationConfig       `mapstructure:"REPUTATION"`
+	Fleet             FleetConfig            `mapstructure:"FLEET"`
+	GPU               GPUConfig              `mapstructure:"GPU"`
+	Power             PowerConfig            `mapstructure:"POWER"`
+	Audit             AuditConfig            `mapstructure:"AUDIT"`
+	ErrorReporting    ErrorReportingConfig   `mapstructure:"ERROR_REPORTING"`
+	MetricsPush       MetricsPushConfig      `mapstructure:"METRICS_PUSH"`
+	ExecutionGuard    ExecutionGuardConfig   `mapstructure:"EXECUTION_GUARD"`
+	FleetHints        FleetHintsConfig       `mapstructure:"FLEET_HINTS"`
+	Poison            PoisonConfig           `mapstructure:"POISON"`
+	Canary            CanaryConfig           `mapstructure:"CANARY"`
+	ErrorBudget       ErrorBudgetConfig      `mapstructure:"ERROR_BUDGET"`
+	Redaction         RedactionConfig        `mapstructure:"REDACTION"`
+	Migration         MigrationConfig        `mapstructure:"MIGRATION"`
+	Budget            BudgetConfig           `mapstructure:"BUDGET"`
+	Pricing           PricingConfig          `mapstructure:"PRICING"`
+	Signer            SignerConfig           `mapstructure:"SIGNER"`
+	FLCompression     FLCompressionConfig    `mapstructure:"FL_COMPRESSION"`
+	Interactive       InteractiveConfig      `mapstructure:"INTERACTIVE"`
+	Retention         RetentionConfig        `mapstructure:"RETENTION"`
+	ObjectStorage     ObjectStorageConfig    `mapstructure:"OBJECT_STORAGE"`
+	Events            EventsConfig           `mapstructure:"EVENTS"`
+	Concurrency       ConcurrencyConfig      `mapstructure:"CONCURRENCY"`
+	Registry          RegistryConfig         `mapstructure:"REGISTRY"`
+	Escrow            EscrowConfig           `mapstructure:"ESCROW"`
+	Disk              DiskConfig             `mapstructure:"DISK"`
+	Anomaly           AnomalyConfig          `mapstructure:"ANOMALY"`
+	// InstancesFile lists the logical runners to run in one process; the
+	// process runs a single runner when empty
+	InstancesFile string `mapstructure:"INSTANCES_FILE"`
+	// DisabledTaskTypes are task types the runner does not run, and does
+	// not register for, though it could
+	DisabledTaskTypes []string `mapstructure:"DISABLED_TASK_TYPES"`
+	// FLTrainingMemory bounds the memory FL training holds at once, such as
+	// "2g"; batches that do not fit are split into micro-batches whose
+	// gradients are accumulated. Empty takes a quarter of the host's memory
+	// and "0" leaves training unbounded.
+	FLTrainingMemory string `mapstructure:"FL_TRAINING_MEMORY"`
+	// ONNXLibrary is the path of the ONNX Runtime shared library ONNX tasks
+	// run with; the runner does not run them when empty or when the library
+	// fails to load
+	ONNXLibrary string `mapstructure:"ONNX_LIBRARY"`
+	// EmbeddingInputMaxMB caps the size of an embedding task's input file
+	// the runner downloads
+	EmbeddingInputMaxMB int64 `mapstructure:"EMBEDDING_INPUT_MAX_MB"`
+	// CapabilitySyncDebounce is how long the runner waits after a change
+	// to its capability profile before patching the server's copy, so
+	// changes close together are sent at once
+	CapabilitySyncDebounce time.Duration `mapstructure:"CAPABILITY_SYNC_DEBOUNCE"`
+	// NTPServer is asked how far the host clock is off every NTPInterval,
+	// for the clock details reported with results; "none" never asks
+	NTPServer   string        `mapstructure:"NTP_SERVER"`
+	NTPInterval time.Duration `mapstructure:"NTP_INTERVAL"`
+}
+
+// EventsConfig bounds how far each subscriber of the runner's events, such
+// as creator callbacks, may fall behind before events are dropped for it,
+// and optionally runs HookCommand for each event named in HookEvents, every
+// event when empty, killing it after HookTimeout
+type EventsConfig struct {
+	QueueSize   int           `mapstructure:"QUEUE_SIZE"`
+	HookCommand string        `mapstructure:"HOOK_COMMAND"`
+	HookEvents  []string      `mapstructure:"HOOK_EVENTS"`
+	HookTimeout time.Duration `mapstructure:"HOOK_TIMEOUT"`
+}
+
+// ConcurrencyConfig sets how many tasks the runner runs at once. The limit
+// starts at Initial and, unless Pinned, is raised by one while tasks at the
+// limit run within SlowRatio times their usual duration and the host is not
+// saturated, and cut by the Backoff factor when they run slower, time out,
+// or the host's pressure stall information, sampled every SampleInterval,
+// reaches Pressure percent or it is thermally throttled, at most once per
+// Cooldown. The limit stays within Min and Max, and never exceeds the tasks
+// the host can reserve the Docker memory and CPU limits for; zero Max takes
+// that. On shutdown, tasks in flight are given DrainTimeout to finish.
+type ConcurrencyConfig struct {
+	Initial        int           `mapstructure:"INITIAL"`
+	Min            int           `mapstructure:"MIN"`
+	Max            int           `mapstructure:"MAX"`
+	Pinned         bool          `mapstructure:"PINNED"`
+	Backoff        float64       `mapstructure:"BACKOFF"`
+	SlowRatio      float64       `mapstructure:"SLOW_RATIO"`
+	Pressure       float64       `mapstructure:"PRESSURE"`
+	Cooldown       time.Duration `mapstructure:"COOLDOWN"`
+	SampleInterval time.Duration `mapstructure:"SAMPLE_INTERVAL"`
+	DrainTimeout   time.Duration `mapstructure:"DRAIN_TIMEOUT"`
+}
+
+// RegistryConfig shares image downloads across a fleet on one LAN. Mirrors
+// map registries to pull-through mirrors, as registry=url such as
+// docker.io=http://mirror.lan:5000, tried in order before the registry;
+// each is health-checked every CheckInterval. With BlobCache, the image
+// tarballs of tasks that give a DockerImageURL are kept by digest and
+// fetched from the runners at BlobPeers before their URL; ServeBlobs
+// serves them to runners whose wallet is this runner's or one of Members.
+type RegistryConfig struct {
+	Mirrors       []string      `mapstructure:"MIRRORS"`
+	CheckInterval time.Duration `mapstructure:"CHECK_INTERVAL"`
+	BlobCache     bool          `mapstructure:"BLOB_CACHE"`
+	BlobPeers     []string      `mapstructure:"BLOB_PEERS"`
+	ServeBlobs    bool          `mapstructure:"SERVE_BLOBS"`
+	Members       []string      `mapstructure:"MEMBERS"`
+}
+
+// EscrowConfig escrows the results of tasks asking for it. NetworkKey, the
+// hex public key of the network's escrow holder, is the second recipient of
+// tasks that do not name their own; without it, only tasks naming their
+// own are escrowed.
+type EscrowConfig struct {
+	NetworkKey string `mapstructure:"NETWORK_KEY"`
+}
+
+// DiskConfig reserves the disk space a task is estimated to write before
+// it is claimed, skipping tasks that do not fit beside the running ones.
+// Path is the filesystem reserved on, the data dir when empty; MinFreeMB
+// is always left free and HeadroomFactor is the share of a task's image,
+// inputs and scratch space reserved on top for its results.
+type DiskConfig struct {
+	Reserve        bool    `mapstructure:"RESERVE"`
+	Path           string  `mapstructure:"PATH"`
+	MinFreeMB      int64   `mapstructure:"MIN_FREE_MB"`
+	HeadroomFactor float64 `mapstructure:"HEADROOM_FACTOR"`
+}
+
+// RetentionConfig bounds how long the runner keeps task data locally once a
+// task's result upload is confirmed. Tasks that set no retention of their
+// own have their result copy and scratch remnants kept for Default; their
+// replay bundles follow the audit retention. Data past its retention is
+// securely deleted every PurgeInterval.
+type RetentionConfig struct {
+	Default       time.Duration `mapstructure:"DEFAULT"`
+	PurgeInterval time.Duration `mapstructure:"PURGE_INTERVAL"`
+}
+
+// FLCompressionConfig compresses federated learning model updates. Mode none
+// sends them as JSON, zstd compresses them, and zstd-dict also trains a
+// dictionary on a session's first TrainAfter updates, offers it to the server
+// and compresses later updates with it once accepted, retraining when the
+// compression ratio falls below RetrainRatio of what the dictionary first
+// achieved. MaxDictionaryKB bounds the memory each session's dictionaries and
+// training samples take.
+type FLCompressionConfig struct {
+	Mode            string  `mapstructure:"MODE"`
+	TrainAfter      int     `mapstructure:"TRAIN_AFTER"`
+	MaxDictionaryKB int     `mapstructure:"MAX_DICTIONARY_KB"`
+	RetrainRatio    float64 `mapstructure:"RETRAIN_RATIO"`
+}
+
+// InteractiveConfig asks the operator of a workstation runner before
+// claiming a task estimated to run longer than MaxDuration, to need more
+// than MaxDisk of disk such as "20g", or, with ConfirmGPU, to use a GPU.
+// Prompt terminal asks on the runner's terminal; hook runs HookCommand,
+// which approves by exiting 0 and denies by exiting 1. A task not answered
+// within Timeout is denied. Other tasks are claimed without waiting.
+type InteractiveConfig struct {
+	Enabled     bool          `mapstructure:"ENABLED"`
+	Prompt      string        `mapstructure:"PROMPT"`
+	HookCommand string        `mapstructure:"HOOK_COMMAND"`
+	Timeout     time.Duration `mapstructure:"TIMEOUT"`
+	MaxDuration time.Duration `mapstructure:"MAX_DURATION"`
+	MaxDisk     string        `mapstructure:"MAX_DISK"`
+	ConfirmGPU  bool          `mapstructure:"CONFIRM_GPU"`
+}
+
+// ObjectStorageConfig describes an S3-compatible object store artifacts can
+// be uploaded to and s3:// task inputs read from. CheckpointBackend and
+// ImageExportBackend choose where each kind of artifact goes: ipfs, the
+// default, or s3 for Bucket under Prefix. Inputs may be read from Bucket
+// and InputBuckets only. The credentials come from here alone; tasks can
+// name objects but never supply keys.
+type ObjectStorageConfig struct {
+	Endpoint        string   `mapstructure:"ENDPOINT"`
+	Region          string   `mapstructure:"REGION"`
+	Bucket          string   `mapstructure:"BUCKET"`
+	Prefix          string   `mapstructure:"PREFIX"`
+	InputBuckets    []string `mapstructure:"INPUT_BUCKETS"`
+	PathStyle       bool     `mapstructure:"PATH_STYLE"`
+	AccessKeyID     string   `mapstructure:"ACCESS_KEY_ID"`
+	SecretAccessKey string   `mapstructure:"SECRET_ACCESS_KEY" json:"-"`
+	SessionToken    string   `mapstructure:"SESSION_TOKEN" json:"-"`
+	// SSE is empty for none, AES256 or aws:kms
+	SSE                string `mapstructure:"SSE"`
+	SSEKMSKeyID        string `mapstructure:"SSE_KMS_KEY_ID"`
+	PartSizeMB         int64  `mapstructure:"PART_SIZE_MB"`
+	CheckpointBackend  string `mapstructure:"CHECKPOINT_BACKEND"`
+	ImageExportBackend string `mapstructure:"IMAGE_EXPORT_BACKEND"`
+}
+
+// BudgetConfig caps the compute the runner contributes. Once the CPU hours
+// of a day or week are used up no tasks are claimed until the window
+// resets, and once the GPU hours are no GPU tasks. Zero leaves a budget
+// uncapped. Days start at midnight in Timezone, an IANA name defaulting to
+// the host's zone, and weeks on Monday.
+type BudgetConfig struct {
+	DailyCPUHours  float64 `mapstructure:"DAILY_CPU_HOURS"`
+	DailyGPUHours  float64 `mapstructure:"DAILY_GPU_HOURS"`
+	WeeklyCPUHours float64 `mapstructure:"WEEKLY_CPU_HOURS"`
+	WeeklyGPUHours float64 `mapstructure:"WEEKLY_GPU_HOURS"`
+	Timezone       string  `mapstructure:"TIMEZONE"`
+}
+
+// PricingConfig is what the operator charges per hour of a CPU core, a GPU
+// and a gigabyte of memory. Tasks paying less than their resources cost for
+// as long as they are expected to run are skipped; zero leaves a resource
+// free.
+type PricingConfig struct {
+	CPUHour   float64 `mapstructure:"CPU_HOUR"`
+	GPUHour   float64 `mapstructure:"GPU_HOUR"`
+	GBRAMHour float64 `mapstructure:"GB_RAM_HOUR"`
+}
+
+// SignerConfig moves wallet signing out of the runner's process. Command,
+// a program and its arguments, is started as an external signer, such as a
+// hardware wallet bridge, and asked for each signature over its stdin and
+// stdout, waiting up to Timeout for each. Empty signs with the keystore.
+type SignerConfig struct {
+	Command string        `mapstructure:"COMMAND"`
+	Timeout time.Duration `mapstructure:"TIMEOUT"`
+}
+
+// ExecutionGuardConfig keeps runners of one fleet from executing the same
+// task at once, as can happen after lease confusion. Before executing, the
+// runner records its intent in a shared lock service: Mode http uses the
+// compare-and-set endpoint at URL, Mode file a lock directory Dir that the
+// fleet shares. Each call to the service is bounded by Timeout; when the
+// service is unreachable the runner executes anyway.
+type ExecutionGuardConfig struct {
+	Enabled bool          `mapstructure:"ENABLED"`
+	Mode    string        `mapstructure:"MODE"`
+	URL     string        `mapstructure:"URL"`
+	Dir     string        `mapstructure:"DIR"`
+	Timeout time.Duration `mapstructure:"TIMEOUT"`
+}
+
+// FleetHintsConfig shares with the fleet which tasks fail for every
+// runner, such as those whose image does not exist. Hints are published to
+// and looked up in the hint service at URL with Mode http, or the directory
+// Dir the fleet shares with Mode file, each call bounded by Timeout. A
+// hinted task is skipped for TTL, and only hints signed by this runner's
+// wallet or one of the wallet addresses in Members are heeded.
+type FleetHintsConfig struct {
+	Enabled bool          `mapstructure:"ENABLED"`
+	Mode    string        `mapstructure:"MODE"`
+	URL     string        `mapstructure:"URL"`
+	Dir     string        `mapstructure:"DIR"`
+	TTL     time.Duration `mapstructure:"TTL"`
+	Timeout time.Duration `mapstructure:"TIMEOUT"`
+	Members []string      `mapstructure:"MEMBERS"`
+}
+
+// PoisonConfig abandons tasks that keep failing the same way. A task is
+// poisoned once it failed Threshold times with one failure fingerprint.
+// Failures are counted locally, and with
+// StoreMode http or file also in the failure store at StoreURL or the
+// directory StoreDir the fleet shares, each call bounded by StoreTimeout.
+type PoisonConfig struct {
+	Threshold    int           `mapstructure:"THRESHOLD"`
+	StoreMode    string        `mapstructure:"STORE_MODE"`
+	StoreURL     string        `mapstructure:"STORE_URL"`
+	StoreDir     string        `mapstructure:"STORE_DIR"`
+	StoreTimeout time.Duration `mapstructure:"STORE_TIMEOUT"`
+}
+
+// CanaryConfig runs self-tests of the runner every Interval: a container
+// of Image, a one-token generation with each served model, a write, read
+// and delete in scratch space and a request to NetworkURL. A capability
+// whose canary fails Threshold times in a row stops being advertised, and
+// tasks needing it are skipped, until the canary passes again. Each canary
+// is given Timeout.
+type CanaryConfig struct {
+	Enabled    bool          `mapstructure:"ENABLED"`
+	Interval   time.Duration `mapstructure:"INTERVAL"`
+	Threshold  int           `mapstructure:"THRESHOLD"`
+	Timeout    time.Duration `mapstructure:"TIMEOUT"`
+	Image      string        `mapstructure:"IMAGE"`
+	NetworkURL string        `mapstructure:"NETWORK_URL"`
+}
+
+// ErrorBudgetConfig pauses a task type once the failures the runner caused
+// make up MaxFailureRate of its outcomes within Window, and number at least
+// MinFailures. A paused type is tried again after Cooldown, resuming once
+// the canaries of what it needs pass. With Diagnose the runner's health
+// checks are run as the type is paused, and their findings added to the
+// alert.
+type ErrorBudgetConfig struct {
+	Enabled        bool          `mapstructure:"ENABLED"`
+	Window         time.Duration `mapstructure:"WINDOW"`
+	MinFailures    int           `mapstructure:"MIN_FAILURES"`
+	MaxFailureRate float64       `mapstructure:"MAX_FAILURE_RATE"`
+	Cooldown       time.Duration `mapstructure:"COOLDOWN"`
+	Diagnose       bool          `mapstructure:"DIAGNOSE"`
+}
+
+// AnomalyConfig compares each result the runner submits with its latest
+// Window results of the task type, by Rule zscore, flagging values
+// Threshold standard deviations from the mean, or quantile, flagging those
+// outside the Quantile tails. A type with fewer than MinSamples results is
+// judged by cold-start rules only. Mode flag submits anomalous results with
+// the anomaly noted; hold holds those scoring CriticalScore or more for the
+// operator to approve through the status API, for at most HoldTimeout.
+// Anomalies are published as events and posted to AlertURL, when set.
+type AnomalyConfig struct {
+	Enabled       bool          `mapstructure:"ENABLED"`
+	Rule          string        `mapstructure:"RULE"`
+	Threshold     float64       `mapstructure:"THRESHOLD"`
+	Quantile      float64       `mapstructure:"QUANTILE"`
+	Window        int           `mapstructure:"WINDOW"`
+	MinSamples    int           `mapstructure:"MIN_SAMPLES"`
+	Mode          string        `mapstructure:"MODE"`
+	CriticalScore float64       `mapstructure:"CRITICAL_SCORE"`
+	HoldTimeout   time.Duration `mapstructure:"HOLD_TIMEOUT"`
+	AlertURL      string        `mapstructure:"ALERT_URL"`
+}
+
+// RedactionConfig scrubs sensitive text from task results, streamed
+// output, progress messages and text artifacts before they leave the
+// runner. Detectors lists the built-in detectors applied, key, email, ipv6
+// and ipv4 or none, and RulesFile a JSON array of further rules, each a
+// name, a regular expression pattern and a replacement. Window is how many
+// bytes of streamed text are held back so that matches split across
+// writes are found.
+type RedactionConfig struct {
+	Enabled   bool     `mapstructure:"ENABLED"`
+	Detectors []string `mapstructure:"DETECTORS"`
+	RulesFile string   `mapstructure:"RULES_FILE"`
+	Window    int      `mapstructure:"WINDOW"`
+}
+
+// MigrationConfig moves running tasks to other runners on a planned
+// shutdown. Tasks with state to resume from, the weights of a federated
+// learning round after an epoch or a checkpoint a Docker task reported, are
+// stopped and reassigned with it; the rest are given DrainTimeout to finish
+// before they are returned to the queue.
+type MigrationConfig struct {
+	Enabled      bool          `mapstructure:"ENABLED"`
+	DrainTimeout time.Duration `mapstructure:"DRAIN_TIMEOUT"`
+}
+
+// ErrorReportingConfig shares structured, redacted error events for fleet
+// diagnostics. Mode is server, which sends them to the server, or local,
+// which appends them to File instead. Only the listed Categories are shared;
+// an empty list shares every category. An error seen again within
+// DedupWindow is counted rather than reported, at most RateLimit new events
+// are reported per minute, and events are sent in batches of up to
+// BatchSize every FlushInterval.
+type ErrorReportingConfig struct {
+	Enabled       bool          `mapstructure:"ENABLED"`
+	Mode          string        `mapstructure:"MODE"`
+	File          string        `mapstructure:"FILE"`
+	Categories    []string      `mapstructure:"CATEGORIES"`
+	DedupWindow   time.Duration `mapstructure:"DEDUP_WINDOW"`
+	RateLimit     int           `mapstructure:"RATE_LIMIT"`
+	BatchSize     int           `mapstructure:"BATCH_SIZE"`
+	FlushInterval time.Duration `mapstructure:"FLUSH_INTERVAL"`
+}
+
+// MetricsPushConfig pushes the runner's metrics to URL, a Prometheus
+// remote-write endpoint or a Pushgateway depending on Mode, authenticating
+// with BearerToken or else Username and Password. Only the listed Series and
+// Labels are exported; empty lists export the runner's default allowlist.
+// Metrics are pushed every Interval, backing off up to MaxInterval while
+// pushes fail, in batches of up to BatchSize series; remote-write keeps up to
+// BufferSize series of unsent history.
+type MetricsPushConfig struct {
+	Enabled     bool          `mapstructure:"ENABLED"`
+	Mode        string        `mapstructure:"MODE"`
+	URL         string        `mapstructure:"URL"`
+	Job         string        `mapstructure:"JOB"`
+	Username    string        `mapstructure:"USERNAME"`
+	Password    string        `mapstructure:"PASSWORD" json:"-"`
+	BearerToken string        `mapstructure:"BEARER_TOKEN" json:"-"`
+	Series      []string      `mapstructure:"SERIES"`
+	Labels      []string      `mapstructure:"LABELS"`
+	Interval    time.Duration `mapstructure:"INTERVAL"`
+	MaxInterval time.Duration `mapstructure:"MAX_INTERVAL"`
+	Timeout     time.Duration `mapstructure:"TIMEOUT"`
+	BatchSize   int           `mapstructure:"BATCH_SIZE"`
+	BufferSize  int           `mapstructure:"BUFFER_SIZE"`
+}
+
+// AuditConfig enables audit mode. The runner commits to the tasks it has
+// completed every CommitInterval and keeps what it needs to run them again
+// for Retention, and for at most MaxBundles tasks, to answer audit
+// challenges.
+type AuditConfig struct {
+	Enabled        bool          `mapstructure:"ENABLED"`
+	CommitInterval time.Duration `mapstructure:"COMMIT_INTERVAL"`
+	Retention      time.Duration `mapstructure:"RETENTION"`
+	MaxBundles     int           `mapstructure:"MAX_BUNDLES"`
+}
+
+// PowerConfig makes claiming depend on the host's power source and thermal
+// state. On battery below BatteryThreshold percent only BatteryTaskTypes
+// tasks that run for at most BatteryMaxDuration are claimed, and below
+// PauseBelow percent none are. OnThrottle is pause or ignore. A CPU or GPU
+// above MaxTemperature degrees Celsius counts as throttled; 0 relies on the
+// hardware's own throttle reporting. InFlight is continue, checkpoint or
+// abandon and applies to running tasks the state no longer allows.
+type PowerConfig struct {
+	Enabled            bool          `mapstructure:"ENABLED"`
+	PollInterval       time.Duration `mapstructure:"POLL_INTERVAL"`
+	BatteryThreshold   float64       `mapstructure:"BATTERY_THRESHOLD"`
+	BatteryTaskTypes   []string      `mapstructure:"BATTERY_TASK_TYPES"`
+	BatteryMaxDuration time.Duration `mapstructure:"BATTERY_MAX_DURATION"`
+	PauseBelow         float64       `mapstructure:"PAUSE_BELOW"`
+	OnThrottle         string        `mapstructure:"ON_THROTTLE"`
+	MaxTemperature     float64       `mapstructure:"MAX_TEMPERATURE"`
+	InFlight           string        `mapstructure:"IN_FLIGHT"`
+}
+
+// GPUConfig lets tasks that declare their VRAM share a GPU. SafetyMargin is
+// kept free on every shared GPU, such as "1g", and usage is compared with
+// the declarations every PollInterval.
+type GPUConfig struct {
+	Sharing      bool          `mapstructure:"SHARING"`
+	SafetyMargin string        `mapstructure:"SAFETY_MARGIN"`
+	PollInterval time.Duration `mapstructure:"POLL_INTERVAL"`
+}
+
+// FleetConfig enables configuration overlays delivered with heartbeat
+// responses. Overlays must be signed by PublicKey, a base64 Ed25519 key, and
+// revert after at most OverlayTTL.
+type FleetConfig struct {
+	PublicKey  string        `mapstructure:"PUBLIC_KEY"`
+	OverlayTTL time.Duration `mapstructure:"OVERLAY_TTL"`
+}
+
+// TokenUsageConfig sets when the runner's own token count and the LLM
+// backend's disagree enough to flag a completion for review. Both the
+// relative tolerance and the absolute slack must be exceeded; the slack
+// covers tokens the backend's prompt template adds.
+type TokenUsageConfig struct {
+	Tolerance float64 `mapstructure:"TOLERANCE"`
+	Slack     int     `mapstructure:"SLACK"`
+}
+
+// PromptCacheConfig enables answering LLM prompts identical to one already
+// answered from memory. Only prompts sampled at temperature 0 with a seed
+// are cached, in up to MaxMB megabytes of responses kept for TTL each.
+type PromptCacheConfig struct {
+	Enabled bool          `mapstructure:"ENABLED"`
+	MaxMB   int64         `mapstructure:"MAX_MB"`
+	TTL     time.Duration `mapstructure:"TTL"`
+}
+
+// CustomModelsConfig enables LLM tasks to supply their own GGUF model,
+// downloaded through the input cache and loaded into Ollama under a name
+// derived from its hash. Loaded models stay in the model cache, evicted
+// under the cache budget, unless EvictAfterTask deletes them once their
+// task is served.
+type CustomModelsConfig struct {
+	Enabled        bool `mapstructure:"ENABLED"`
+	EvictAfterTask bool `mapstructure:"EVICT_AFTER_TASK"`
+}
+
+// WarmModelsConfig keeps the models the runner serves loaded in Ollama
+// across runner restarts. The runner records the models it loaded and, on
+// start, reconciles the record with the models Ollama holds, renewing their
+// KeepAlive lease rather than reloading them. A negative KeepAlive keeps
+// them loaded until unloaded.
+type WarmModelsConfig struct {
+	Enabled   bool          `mapstructure:"ENABLED"`
+	KeepAlive time.Duration `mapstructure:"KEEP_ALIVE"`
+}
+
+// ToolUseConfig enables LLM tasks' models to call tools through the runner:
+// web_fetch, reaching only addresses the network overrides policy allows,
+// and code_exec, running Python in CodeExecImage without network. Each
+// task spends at most MaxCalls calls, MaxFetchMB megabytes fetched and
+// MaxToolTime of tool time, less when it asks for less; zero takes the
+// defaults.
+type ToolUseConfig struct {
+	Enabled       bool          `mapstructure:"ENABLED"`
+	MaxCalls      int           `mapstructure:"MAX_CALLS"`
+	MaxFetchMB    int64         `mapstructure:"MAX_FETCH_MB"`
+	MaxToolTime   time.Duration `mapstructure:"MAX_TOOL_TIME"`
+	CodeExecImage string        `mapstructure:"CODE_EXEC_IMAGE"`
+}
+
+// IdleWorkConfig donates the runner's idle time to the community federated
+// learning sessions in Sessions; none when empty. Their rounds train only
+// while no paid task runs, yielding the moment one is claimable, on a
+// thread with nice value Nice under the Sched scheduling policy (idle,
+// batch or normal) and the IOClass IO class (idle or best-effort).
+type IdleWorkConfig struct {
+	Sessions []string `mapstructure:"SESSIONS"`
+	Nice     int      `mapstructure:"NICE"`
+	Sched    string   `mapstructure:"SCHED"`
+	IOClass  string   `mapstructure:"IO_CLASS"`
+}
+
+// ArtifactChunkingConfig uploads checkpoints larger than InlineKB as chunks
+// under a manifest whose Merkle root the result carries, sending only the
+// chunks the destination lacks. Mode is off, fixed (chunks of ChunkKB) or
+// cdc (content-defined chunks averaging ChunkKB).
+type ArtifactChunkingConfig struct {
+	Mode     string `mapstructure:"MODE"`
+	ChunkKB  int    `mapstructure:"CHUNK_KB"`
+	InlineKB int64  `mapstructure:"INLINE_KB"`
+}
+
+// RevocationConfig honors the network's emergency revocations, verified
+// with the base64 Ed25519 PublicKey; off when it is empty. The feed is
+// fetched every PollInterval from the first of FeedURLs that answers within
+// Timeout, the server's own feed when none are set.
+type RevocationConfig struct {
+	PublicKey    string        `mapstructure:"PUBLIC_KEY"`
+	FeedURLs     []string      `mapstructure:"FEED_URLS"`
+	PollInterval time.Duration `mapstructure:"POLL_INTERVAL"`
+	Timeout      time.Duration `mapstructure:"TIMEOUT"`
+}
+
+// APIRetryConfig retries the server API requests that are safe to repeat
+// after connection errors and server errors, up to MaxAttempts attempts in
+// all. The wait before each retry doubles from BaseDelay up to MaxDelay,
+// spread by up to Jitter of itself either way.
+type APIRetryConfig struct {
+	MaxAttempts int           `mapstructure:"MAX_ATTEMPTS"`
+	BaseDelay   time.Duration `mapstructure:"BASE_DELAY"`
+	MaxDelay    time.Duration `mapstructure:"MAX_DELAY"`
+	Jitter      float64       `mapstructure:"JITTER"`
+}
+
+// TaskSocketConfig configures the websocket transport, which a Transport of
+// websocket selects in place of webhook: the server pushes tasks over a
+// socket the runner dials rather than POSTing them to the runner's webhook.
+// The runner pings the server every PingInterval and redials once nothing,
+// pongs included, was read for PongWait; WriteWait bounds each write.
+// Failed dials are retried with backoff doubling up to ReconnectMaxDelay,
+// and while the socket is down available tasks are polled every
+// PollInterval.
+type TaskSocketConfig struct {
+	PingInterval      time.Duration `mapstructure:"PING_INTERVAL"`
+	PongWait          time.Duration `mapstructure:"PONG_WAIT"`
+	WriteWait         time.Duration `mapstructure:"WRITE_WAIT"`
+	ReconnectMaxDelay time.Duration `mapstructure:"RECONNECT_MAX_DELAY"`
+	PollInterval      time.Duration `mapstructure:"POLL_INTERVAL"`
+}
+
+// ArtifactPreviewConfig describes the files tasks produce in their results:
+// their content types, detected from their leading bytes, and previews of
+// them. Mode is on or off. Previews hold up to Lines lines of text and CSV
+// files and image thumbnails up to ThumbnailPx on a side, and are shrunk or
+// dropped to stay within MaxKB each; at most MaxArtifacts files of a task
+// are described.
+type ArtifactPreviewConfig struct {
+	Mode         string `mapstructure:"MODE"`
+	MaxKB        int    `mapstructure:"MAX_KB"`
+	Lines        int    `mapstructure:"LINES"`
+	ThumbnailPx  int    `mapstructure:"THUMBNAIL_PX"`
+	MaxArtifacts int    `mapstructure:"MAX_ARTIFACTS"`
+}
+
+// ResultOffloadConfig keeps large outputs out of the result bodies sent to
+// the server. Mode is on or off. An output larger than ThresholdKB is added
+// to IPFS through the first of IPFSAPIURLs that takes it, and the result
+// carries its CID and its first PreviewKB; when no endpoint takes it, it is
+// sent cut to ThresholdKB. Outputs of escrowed tasks and of tasks setting
+// their own retention are always sent whole. Gateways are where offloaded
+// outputs are fetched back from.
+type ResultOffloadConfig struct {
+	Mode        string   `mapstructure:"MODE"`
+	ThresholdKB int      `mapstructure:"THRESHOLD_KB"`
+	PreviewKB   int      `mapstructure:"PREVIEW_KB"`
+	IPFSAPIURLs []string `mapstructure:"IPFS_API_URLS"`
+	Gateways    []string `mapstructure:"GATEWAYS"`
+}
+
+// IPFSFetchConfig fetches task inputs and datasets given by CID from
+// Gateways without trusting them. Mode is on or off, which leaves them to
+// the single gateway of each. Gateways serving CAR have every block checked
+// as it arrives, and each file is split into SegmentKB segments fetched from
+// up to Parallel gateways at once; files from gateways without CAR support
+// are hashed to their CID once read.
+type IPFSFetchConfig struct {
+	Mode      string   `mapstructure:"MODE"`
+	Gateways  []string `mapstructure:"GATEWAYS"`
+	SegmentKB int      `mapstructure:"SEGMENT_KB"`
+	Parallel  int      `mapstructure:"PARALLEL"`
+}
+
+// ReputationConfig reconciles the reputation score the runner computes from
+// its history with the score the server reports, fetched every
+// ReconcileInterval; the scores diverge when they differ by more than
+// DivergenceTolerance points.
+type ReputationConfig struct {
+	ReconcileInterval   time.Duration `mapstructure:"RECONCILE_INTERVAL"`
+	DivergenceTolerance float64       `mapstructure:"DIVERGENCE_TOLERANCE"`
+}
+
+// MaterializeConfig configures the materialization plugins tasks may name
+// to have their results delivered into their creators' own systems.
+// PluginsFile is a JSON array of plugins, each a name, a type, postgres or
+// webhook, the settings of its type and optionally a timeout, max_attempts
+// and backoff; none are configured when it is empty.
+type MaterializeConfig struct {
+	PluginsFile string `mapstructure:"PLUGINS_FILE"`
+}
+
+// AttestationConfig selects how the runner proves its environment. Mode is
+// off, auto, tpm, sev-snp or simulated; the TPM mode quotes with an
+// attestation key the operator provisioned with tpm2-tools.
+type AttestationConfig struct {
+	Mode           string `mapstructure:"MODE"`
+	TPMAKContext   string `mapstructure:"TPM_AK_CONTEXT"`
+	TPMAKPublicKey string `mapstructure:"TPM_AK_PUBLIC_KEY"`
+}
+
+// CacheConfig sets the disk budget shared by the image, model, dataset and
+// artifact caches. A zero budget leaves each cache unbounded.
+type CacheConfig struct {
+	BudgetGB int64 `mapstructure:"BUDGET_GB"`
+	// MinShare is the fraction of the budget split evenly between caches
+	// regardless of their hit rates
+	MinShare          float64       `mapstructure:"MIN_SHARE"`
+	RebalanceInterval time.Duration `mapstructure:"REBALANCE_INTERVAL"`
+}
+
+type ImageExportConfig struct {
+	Enabled    bool   `mapstructure:"ENABLED"`
+	IPFSAPIURL string `mapstructure:"IPFS_API_URL"`
+	MaxMB      int64  `mapstructure:"MAX_MB"`
+	MaxLayers  int    `mapstructure:"MAX_LAYERS"`
+	// UploadAuth is "none" for a local daemon, "server" for task-scoped
+	// tokens issued by the server, or "scoped-key" for short-lived keys
+	// minted with PinningKey from the pinning service's key API
+	UploadAuth     string        `mapstructure:"UPLOAD_AUTH"`
+	PinningKeyURL  string        `mapstructure:"PINNING_KEY_URL"`
+	PinningKey     string        `mapstructure:"PINNING_KEY" json:"-"`
+	UploadTokenTTL time.Duration `mapstructure:"UPLOAD_TOKEN_TTL"`
+}
+
+type BandwidthConfig struct {
+	Enabled bool `mapstructure:"ENABLED"`
+	// Endpoint defaults to the server's bandwidth test endpoint
+	Endpoint string `mapstructure:"ENDPOINT"`
+	ProbeMB  int64  `mapstructure:"PROBE_MB"`
+}
+
+type CallbackConfig struct {
+	AllowedSchemes []string      `mapstructure:"ALLOWED_SCHEMES"`
+	AllowedPorts   []int         `mapstructure:"ALLOWED_PORTS"`
+	AllowPrivate   bool          `mapstructure:"ALLOW_PRIVATE"`
+	Timeout        time.Duration `mapstructure:"TIMEOUT"`
+	MaxAttempts    int           `mapstructure:"MAX_ATTEMPTS"`
+}
+
+// NetworkOverridesConfig restricts the addresses Docker tasks may pin
+// hostnames to or use as nameservers. Public addresses are always allowed;
+// non-public ones only inside the CIDR ranges in AllowedNetworks. Service
+// tasks may publish ports only on host ports in PublishPorts, ranges such
+// as "8000-8099"; none when empty. Tasks may ask for an IPv6 network only
+// when IPv6Prefix, a ULA prefix such as "fd42:7061:7269::/48", is set.
+type NetworkOverridesConfig struct {
+	AllowedNetworks []string `mapstructure:"ALLOWED_NETWORKS"`
+	PublishPorts    []string `mapstructure:"PUBLISH_PORTS"`
+	IPv6Prefix      string   `mapstructure:"IPV6_PREFIX"`
+}
+
+// SecurityProfilesConfig decides which of the runner's security profiles,
+// restricted, build, network-client and gpu-compute, tasks may ask for.
+// Tasks asking for none run under Default, restricted when empty, which is
+// always permitted; tasks asking for another profile than those in Allowed
+// are skipped.
+type SecurityProfilesConfig struct {
+	Allowed []string `mapstructure:"ALLOWED"`
+	Default string   `mapstructure:"DEFAULT"`
+}
+
+// HistoryConfig bounds the task history timeouts, cost estimates and the
+// reputation score are computed from: records older than Retention, and the
+// oldest beyond MaxRecords, are dropped. Retention never drops records audit
+// mode still keeps bundles for.
+type HistoryConfig struct {
+	Retention  time.Duration `mapstructure:"RETENTION"`
+	MaxRecords int           `mapstructure:"MAX_RECORDS"`
+}
+
+type AdaptiveTimeoutConfig struct {
+	Factor     float64       `mapstructure:"FACTOR"`
+	Min        time.Duration `mapstructure:"MIN"`
+	Max        time.Duration `mapstructure:"MAX"`
+	MinSamples int           `mapstructure:"MIN_SAMPLES"`
+}
+
+// TaskTimeoutConfig bounds how long tasks run. Default replaces the per-type
+// defaults of tasks that declare no timeout and have too little history to
+// adapt one; Max caps every timeout, declared or not. Tasks running without
+// a lease, whose renewals would show the runner alive, heartbeat to the
+// server every HeartbeatInterval.
+type TaskTimeoutConfig struct {
+	Default           time.Duration `mapstructure:"DEFAULT"`
+	Max               time.Duration `mapstructure:"MAX"`
 	HeartbeatInterval time.Duration `mapstructure:"HEARTBEAT_INTERVAL"`
-	ExecutionTimeout  time.Duration `mapstructure:"EXECUTION_TIMEOUT"`
-	Docker            DockerConfig  `mapstructure:"DOCKER"`
-	Tunnel            TunnelConfig  `mapstructure:"TUNNEL"`
+}
+
+type HealthConfig struct {
+	ReadyHeartbeats int   `mapstructure:"READY_HEARTBEATS"`
+	MinFreeDiskMB   int64 `mapstructure:"MIN_FREE_DISK_MB"`
 }
 
 type TunnelConfig struct {
@@ -68,6 +793,41 @@ type DockerConfig struct {
 	MemoryLimit string        `mapstructure:"MEMORY_LIMIT"`
 	CPULimit    string        `mapstructure:"CPU_LIMIT"`
 	Timeout     time.Duration `mapstructure:"TIMEOUT"`
+	// Preflight is "off", "warn" or "abandon": whether a task's command is
+	// checked against its image before the task is claimed, and whether a
+	// mismatch certain to fail the task skips it
+	Preflight string `mapstructure:"PREFLIGHT"`
+	// SoftMemoryRatio places a soft memory limit at this fraction of each
+	// task's hard limit; zero disables it. Tasks whose usage stays above it
+	// for SoftMemorySustain are sent SIGUSR1.
+	SoftMemoryRatio   float64       `mapstructure:"SOFT_MEMORY_RATIO"`
+	SoftMemorySustain time.Duration `mapstructure:"SOFT_MEMORY_SUSTAIN"`
+	// StallMemory, StallIO and StallCPU are the shares of time, in percent,
+	// all of a task's processes may be stalled on each resource; zero
+	// leaves the resource unwatched. Tasks stalled above one for
+	// StallSustain are reported, and abandoned when StallAbandon is set.
+	// Stalls are read from cgroup v2 pressure stall information on Linux.
+	StallMemory  float64       `mapstructure:"STALL_MEMORY"`
+	StallIO      float64       `mapstructure:"STALL_IO"`
+	StallCPU     float64       `mapstructure:"STALL_CPU"`
+	StallSustain time.Duration `mapstructure:"STALL_SUSTAIN"`
+	StallAbandon bool          `mapstructure:"STALL_ABANDON"`
+	// StopGracePeriod is how long a cancelled or timed out task has between
+	// SIGTERM and SIGKILL; tasks are told it in PARITY_STOP_GRACE_SECONDS
+	StopGracePeriod time.Duration `mapstructure:"STOP_GRACE_PERIOD"`
+	// RootPolicy is "deny", "userns" or "allow": whether tasks that set
+	// run_as_root may run as their image's user, "userns" only when the
+	// daemon remaps user namespaces. Other tasks run as a user of their own
+	// from TaskUIDs, such as "200000-200999", keeping only the Linux
+	// capabilities in Capabilities.
+	RootPolicy   string   `mapstructure:"ROOT_POLICY"`
+	TaskUIDs     string   `mapstructure:"TASK_UIDS"`
+	Capabilities []string `mapstructure:"CAPABILITIES"`
+	// RestartPolicy is the docker restart policy given to task containers,
+	// none when empty. Only containers with one are trusted to have
+	// finished on their own when found exited after the Docker daemon
+	// restarted.
+	RestartPolicy string `mapstructure:"RESTART_POLICY"`
 }
 
 type ConfigManager struct {
@@ -153,9 +913,22 @@ func loadConfigFile(path string) (*Config, error) {
 		"HEARTBEAT_INTERVAL": v.GetDuration("RUNNER_HEARTBEAT_INTERVAL"),
 		"EXECUTION_TIMEOUT":  v.GetDuration("RUNNER_EXECUTION_TIMEOUT"),
 		"DOCKER": map[string]interface{}{
-			"MEMORY_LIMIT": v.GetString("RUNNER_DOCKER_MEMORY_LIMIT"),
-			"CPU_LIMIT":    v.GetString("RUNNER_DOCKER_CPU_LIMIT"),
-			"TIMEOUT":      v.GetDuration("RUNNER_DOCKER_TIMEOUT"),
+			"MEMORY_LIMIT":        v.GetString("RUNNER_DOCKER_MEMORY_LIMIT"),
+			"CPU_LIMIT":           v.GetString("RUNNER_DOCKER_CPU_LIMIT"),
+			"TIMEOUT":             v.GetDuration("RUNNER_DOCKER_TIMEOUT"),
+			"PREFLIGHT":           v.GetString("RUNNER_DOCKER_PREFLIGHT"),
+			"SOFT_MEMORY_RATIO":   v.GetFloat64("RUNNER_DOCKER_SOFT_MEMORY_RATIO"),
+			"SOFT_MEMORY_SUSTAIN": v.GetDuration("RUNNER_DOCKER_SOFT_MEMORY_SUSTAIN"),
+			"STALL_MEMORY":        v.GetFloat64("RUNNER_DOCKER_STALL_MEMORY"),
+			"STALL_IO":            v.GetFloat64("RUNNER_DOCKER_STALL_IO"),
+			"STALL_CPU":           v.GetFloat64("RUNNER_DOCKER_STALL_CPU"),
+			"STALL_SUSTAIN":       v.GetDuration("RUNNER_DOCKER_STALL_SUSTAIN"),
+			"STALL_ABANDON":       v.GetBool("RUNNER_DOCKER_STALL_ABANDON"),
+			"STOP_GRACE_PERIOD":   v.GetDuration("RUNNER_DOCKER_STOP_GRACE_PERIOD"),
+			"ROOT_POLICY":         v.GetString("RUNNER_DOCKER_ROOT_POLICY"),
+			"TASK_UIDS":           v.GetString("RUNNER_DOCKER_TASK_UIDS"),
+			"CAPABILITIES":        v.GetStringSlice("RUNNER_DOCKER_CAPABILITIES"),
+			"RESTART_POLICY":      v.GetString("RUNNER_DOCKER_RESTART_POLICY"),
 		},
 		"TUNNEL": map[string]interface{}{
 			"ENABLED":    v.GetBool("RUNNER_TUNNEL_ENABLED"),
@@ -164,6 +937,355 @@ func loadConfigFile(path string) (*Config, error) {
 			"PORT":       v.GetInt("RUNNER_TUNNEL_PORT"),
 			"SECRET":     v.GetString("RUNNER_TUNNEL_SECRET"),
 		},
+		"HEALTH": map[string]interface{}{
+			"READY_HEARTBEATS": v.GetInt("RUNNER_HEALTH_READY_HEARTBEATS"),
+			"MIN_FREE_DISK_MB": v.GetInt64("RUNNER_HEALTH_MIN_FREE_DISK_MB"),
+		},
+		"ADAPTIVE_TIMEOUT": map[string]interface{}{
+			"FACTOR":      v.GetFloat64("RUNNER_ADAPTIVE_TIMEOUT_FACTOR"),
+			"MIN":         v.GetDuration("RUNNER_ADAPTIVE_TIMEOUT_MIN"),
+			"MAX":         v.GetDuration("RUNNER_ADAPTIVE_TIMEOUT_MAX"),
+			"MIN_SAMPLES": v.GetInt("RUNNER_ADAPTIVE_TIMEOUT_MIN_SAMPLES"),
+		},
+		"HISTORY": map[string]interface{}{
+			"RETENTION":   v.GetDuration("RUNNER_HISTORY_RETENTION"),
+			"MAX_RECORDS": v.GetInt("RUNNER_HISTORY_MAX_RECORDS"),
+		},
+		"TASK_TIMEOUT": map[string]interface{}{
+			"DEFAULT":            v.GetDuration("RUNNER_TASK_TIMEOUT_DEFAULT"),
+			"MAX":                v.GetDuration("RUNNER_TASK_TIMEOUT_MAX"),
+			"HEARTBEAT_INTERVAL": v.GetDuration("RUNNER_TASK_TIMEOUT_HEARTBEAT_INTERVAL"),
+		},
+		"CALLBACK": map[string]interface{}{
+			"ALLOWED_SCHEMES": v.GetStringSlice("RUNNER_CALLBACK_ALLOWED_SCHEMES"),
+			"ALLOWED_PORTS":   v.GetIntSlice("RUNNER_CALLBACK_ALLOWED_PORTS"),
+			"ALLOW_PRIVATE":   v.GetBool("RUNNER_CALLBACK_ALLOW_PRIVATE"),
+			"TIMEOUT":         v.GetDuration("RUNNER_CALLBACK_TIMEOUT"),
+			"MAX_ATTEMPTS":    v.GetInt("RUNNER_CALLBACK_MAX_ATTEMPTS"),
+		},
+		"NETWORK_OVERRIDES": map[string]interface{}{
+			"ALLOWED_NETWORKS": v.GetStringSlice("RUNNER_NETWORK_OVERRIDES_ALLOWED_NETWORKS"),
+			"PUBLISH_PORTS":    v.GetStringSlice("RUNNER_NETWORK_OVERRIDES_PUBLISH_PORTS"),
+			"IPV6_PREFIX":      v.GetString("RUNNER_NETWORK_OVERRIDES_IPV6_PREFIX"),
+		},
+		"SECURITY_PROFILES": map[string]interface{}{
+			"ALLOWED": v.GetStringSlice("RUNNER_SECURITY_PROFILES_ALLOWED"),
+			"DEFAULT": v.GetString("RUNNER_SECURITY_PROFILES_DEFAULT"),
+		},
+		"BANDWIDTH": map[string]interface{}{
+			"ENABLED":  v.GetBool("RUNNER_BANDWIDTH_ENABLED"),
+			"ENDPOINT": v.GetString("RUNNER_BANDWIDTH_ENDPOINT"),
+			"PROBE_MB": v.GetInt64("RUNNER_BANDWIDTH_PROBE_MB"),
+		},
+		"IMAGE_EXPORT": map[string]interface{}{
+			"ENABLED":          v.GetBool("RUNNER_IMAGE_EXPORT_ENABLED"),
+			"IPFS_API_URL":     v.GetString("RUNNER_IMAGE_EXPORT_IPFS_API_URL"),
+			"MAX_MB":           v.GetInt64("RUNNER_IMAGE_EXPORT_MAX_MB"),
+			"MAX_LAYERS":       v.GetInt("RUNNER_IMAGE_EXPORT_MAX_LAYERS"),
+			"UPLOAD_AUTH":      v.GetString("RUNNER_IMAGE_EXPORT_UPLOAD_AUTH"),
+			"PINNING_KEY_URL":  v.GetString("RUNNER_IMAGE_EXPORT_PINNING_KEY_URL"),
+			"PINNING_KEY":      v.GetString("RUNNER_IMAGE_EXPORT_PINNING_KEY"),
+			"UPLOAD_TOKEN_TTL": v.GetDuration("RUNNER_IMAGE_EXPORT_UPLOAD_TOKEN_TTL"),
+		},
+		"CACHE": map[string]interface{}{
+			"BUDGET_GB":          v.GetInt64("RUNNER_CACHE_BUDGET_GB"),
+			"MIN_SHARE":          v.GetFloat64("RUNNER_CACHE_MIN_SHARE"),
+			"REBALANCE_INTERVAL": v.GetDuration("RUNNER_CACHE_REBALANCE_INTERVAL"),
+		},
+		"ATTESTATION": map[string]interface{}{
+			"MODE":              v.GetString("RUNNER_ATTESTATION_MODE"),
+			"TPM_AK_CONTEXT":    v.GetString("RUNNER_ATTESTATION_TPM_AK_CONTEXT"),
+			"TPM_AK_PUBLIC_KEY": v.GetString("RUNNER_ATTESTATION_TPM_AK_PUBLIC_KEY"),
+		},
+		"TOKEN_USAGE": map[string]interface{}{
+			"TOLERANCE": v.GetFloat64("RUNNER_TOKEN_USAGE_TOLERANCE"),
+			"SLACK":     v.GetInt("RUNNER_TOKEN_USAGE_SLACK"),
+		},
+		"PROMPT_CACHE": map[string]interface{}{
+			"ENABLED": v.GetBool("RUNNER_PROMPT_CACHE_ENABLED"),
+			"MAX_MB":  v.GetInt64("RUNNER_PROMPT_CACHE_MAX_MB"),
+			"TTL":     v.GetDuration("RUNNER_PROMPT_CACHE_TTL"),
+		},
+		"CUSTOM_MODELS": map[string]interface{}{
+			"ENABLED":          v.GetBool("RUNNER_CUSTOM_MODELS_ENABLED"),
+			"EVICT_AFTER_TASK": v.GetBool("RUNNER_CUSTOM_MODELS_EVICT_AFTER_TASK"),
+		},
+		"WARM_MODELS": map[string]interface{}{
+			"ENABLED":    v.GetBool("RUNNER_WARM_MODELS_ENABLED"),
+			"KEEP_ALIVE": v.GetDuration("RUNNER_WARM_MODELS_KEEP_ALIVE"),
+		},
+		"TOOL_USE": map[string]interface{}{
+			"ENABLED":         v.GetBool("RUNNER_TOOL_USE_ENABLED"),
+			"MAX_CALLS":       v.GetInt("RUNNER_TOOL_USE_MAX_CALLS"),
+			"MAX_FETCH_MB":    v.GetInt64("RUNNER_TOOL_USE_MAX_FETCH_MB"),
+			"MAX_TOOL_TIME":   v.GetDuration("RUNNER_TOOL_USE_MAX_TOOL_TIME"),
+			"CODE_EXEC_IMAGE": v.GetString("RUNNER_TOOL_USE_CODE_EXEC_IMAGE"),
+		},
+		"IDLE_WORK": map[string]interface{}{
+			"SESSIONS": v.GetStringSlice("RUNNER_IDLE_WORK_SESSIONS"),
+			"NICE":     v.GetInt("RUNNER_IDLE_WORK_NICE"),
+			"SCHED":    v.GetString("RUNNER_IDLE_WORK_SCHED"),
+			"IO_CLASS": v.GetString("RUNNER_IDLE_WORK_IO_CLASS"),
+		},
+		"ARTIFACT_CHUNKING": map[string]interface{}{
+			"MODE":      v.GetString("RUNNER_ARTIFACT_CHUNKING_MODE"),
+			"CHUNK_KB":  v.GetInt("RUNNER_ARTIFACT_CHUNKING_CHUNK_KB"),
+			"INLINE_KB": v.GetInt64("RUNNER_ARTIFACT_CHUNKING_INLINE_KB"),
+		},
+		"REVOCATION": map[string]interface{}{
+			"PUBLIC_KEY":    v.GetString("RUNNER_REVOCATION_PUBLIC_KEY"),
+			"FEED_URLS":     v.GetStringSlice("RUNNER_REVOCATION_FEED_URLS"),
+			"POLL_INTERVAL": v.GetDuration("RUNNER_REVOCATION_POLL_INTERVAL"),
+			"TIMEOUT":       v.GetDuration("RUNNER_REVOCATION_TIMEOUT"),
+		},
+		"API_RETRY": map[string]interface{}{
+			"MAX_ATTEMPTS": v.GetInt("RUNNER_API_RETRY_MAX_ATTEMPTS"),
+			"BASE_DELAY":   v.GetDuration("RUNNER_API_RETRY_BASE_DELAY"),
+			"MAX_DELAY":    v.GetDuration("RUNNER_API_RETRY_MAX_DELAY"),
+			"JITTER":       v.GetFloat64("RUNNER_API_RETRY_JITTER"),
+		},
+		"MATERIALIZE": map[string]interface{}{
+			"PLUGINS_FILE": v.GetString("RUNNER_MATERIALIZE_PLUGINS_FILE"),
+		},
+		"TRANSPORT": v.GetString("RUNNER_TRANSPORT"),
+		"TASK_SOCKET": map[string]interface{}{
+			"PING_INTERVAL":       v.GetDuration("RUNNER_TASK_SOCKET_PING_INTERVAL"),
+			"PONG_WAIT":           v.GetDuration("RUNNER_TASK_SOCKET_PONG_WAIT"),
+			"WRITE_WAIT":          v.GetDuration("RUNNER_TASK_SOCKET_WRITE_WAIT"),
+			"RECONNECT_MAX_DELAY": v.GetDuration("RUNNER_TASK_SOCKET_RECONNECT_MAX_DELAY"),
+			"POLL_INTERVAL":       v.GetDuration("RUNNER_TASK_SOCKET_POLL_INTERVAL"),
+		},
+		"ARTIFACT_PREVIEW": map[string]interface{}{
+			"MODE":          v.GetString("RUNNER_ARTIFACT_PREVIEW_MODE"),
+			"MAX_KB":        v.GetInt("RUNNER_ARTIFACT_PREVIEW_MAX_KB"),
+			"LINES":         v.GetInt("RUNNER_ARTIFACT_PREVIEW_LINES"),
+			"THUMBNAIL_PX":  v.GetInt("RUNNER_ARTIFACT_PREVIEW_THUMBNAIL_PX"),
+			"MAX_ARTIFACTS": v.GetInt("RUNNER_ARTIFACT_PREVIEW_MAX_ARTIFACTS"),
+		},
+		"RESULT_OFFLOAD": map[string]interface{}{
+			"MODE":          v.GetString("RUNNER_RESULT_OFFLOAD_MODE"),
+			"THRESHOLD_KB":  v.GetInt("RUNNER_RESULT_OFFLOAD_THRESHOLD_KB"),
+			"PREVIEW_KB":    v.GetInt("RUNNER_RESULT_OFFLOAD_PREVIEW_KB"),
+			"IPFS_API_URLS": v.GetStringSlice("RUNNER_RESULT_OFFLOAD_IPFS_API_URLS"),
+			"GATEWAYS":      v.GetStringSlice("RUNNER_RESULT_OFFLOAD_GATEWAYS"),
+		},
+		"IPFS_FETCH": map[string]interface{}{
+			"MODE":       v.GetString("RUNNER_IPFS_FETCH_MODE"),
+			"GATEWAYS":   v.GetStringSlice("RUNNER_IPFS_FETCH_GATEWAYS"),
+			"SEGMENT_KB": v.GetInt("RUNNER_IPFS_FETCH_SEGMENT_KB"),
+			"PARALLEL":   v.GetInt("RUNNER_IPFS_FETCH_PARALLEL"),
+		},
+		"REPUTATION": map[string]interface{}{
+			"RECONCILE_INTERVAL":   v.GetDuration("RUNNER_REPUTATION_RECONCILE_INTERVAL"),
+			"DIVERGENCE_TOLERANCE": v.GetFloat64("RUNNER_REPUTATION_DIVERGENCE_TOLERANCE"),
+		},
+		"FLEET": map[string]interface{}{
+			"PUBLIC_KEY":  v.GetString("RUNNER_FLEET_PUBLIC_KEY"),
+			"OVERLAY_TTL": v.GetDuration("RUNNER_FLEET_OVERLAY_TTL"),
+		},
+		"GPU": map[string]interface{}{
+			"SHARING":       v.GetBool("RUNNER_GPU_SHARING"),
+			"SAFETY_MARGIN": v.GetString("RUNNER_GPU_SAFETY_MARGIN"),
+			"POLL_INTERVAL": v.GetDuration("RUNNER_GPU_POLL_INTERVAL"),
+		},
+		"POWER": map[string]interface{}{
+			"ENABLED":              v.GetBool("RUNNER_POWER_ENABLED"),
+			"POLL_INTERVAL":        v.GetDuration("RUNNER_POWER_POLL_INTERVAL"),
+			"BATTERY_THRESHOLD":    v.GetFloat64("RUNNER_POWER_BATTERY_THRESHOLD"),
+			"BATTERY_TASK_TYPES":   v.GetStringSlice("RUNNER_POWER_BATTERY_TASK_TYPES"),
+			"BATTERY_MAX_DURATION": v.GetDuration("RUNNER_POWER_BATTERY_MAX_DURATION"),
+			"PAUSE_BELOW":          v.GetFloat64("RUNNER_POWER_PAUSE_BELOW"),
+			"ON_THROTTLE":          v.GetString("RUNNER_POWER_ON_THROTTLE"),
+			"MAX_TEMPERATURE":      v.GetFloat64("RUNNER_POWER_MAX_TEMPERATURE"),
+			"IN_FLIGHT":            v.GetString("RUNNER_POWER_IN_FLIGHT"),
+		},
+		"AUDIT": map[string]interface{}{
+			"ENABLED":         v.GetBool("RUNNER_AUDIT_ENABLED"),
+			"COMMIT_INTERVAL": v.GetDuration("RUNNER_AUDIT_COMMIT_INTERVAL"),
+			"RETENTION":       v.GetDuration("RUNNER_AUDIT_RETENTION"),
+			"MAX_BUNDLES":     v.GetInt("RUNNER_AUDIT_MAX_BUNDLES"),
+		},
+		"ERROR_REPORTING": map[string]interface{}{
+			"ENABLED":        v.GetBool("RUNNER_ERROR_REPORTING_ENABLED"),
+			"MODE":           v.GetString("RUNNER_ERROR_REPORTING_MODE"),
+			"FILE":           v.GetString("RUNNER_ERROR_REPORTING_FILE"),
+			"CATEGORIES":     v.GetStringSlice("RUNNER_ERROR_REPORTING_CATEGORIES"),
+			"DEDUP_WINDOW":   v.GetDuration("RUNNER_ERROR_REPORTING_DEDUP_WINDOW"),
+			"RATE_LIMIT":     v.GetInt("RUNNER_ERROR_REPORTING_RATE_LIMIT"),
+			"BATCH_SIZE":     v.GetInt("RUNNER_ERROR_REPORTING_BATCH_SIZE"),
+			"FLUSH_INTERVAL": v.GetDuration("RUNNER_ERROR_REPORTING_FLUSH_INTERVAL"),
+		},
+		"METRICS_PUSH": map[string]interface{}{
+			"ENABLED":      v.GetBool("RUNNER_METRICS_PUSH_ENABLED"),
+			"MODE":         v.GetString("RUNNER_METRICS_PUSH_MODE"),
+			"URL":          v.GetString("RUNNER_METRICS_PUSH_URL"),
+			"JOB":          v.GetString("RUNNER_METRICS_PUSH_JOB"),
+			"USERNAME":     v.GetString("RUNNER_METRICS_PUSH_USERNAME"),
+			"PASSWORD":     v.GetString("RUNNER_METRICS_PUSH_PASSWORD"),
+			"BEARER_TOKEN": v.GetString("RUNNER_METRICS_PUSH_BEARER_TOKEN"),
+			"SERIES":       v.GetStringSlice("RUNNER_METRICS_PUSH_SERIES"),
+			"LABELS":       v.GetStringSlice("RUNNER_METRICS_PUSH_LABELS"),
+			"INTERVAL":     v.GetDuration("RUNNER_METRICS_PUSH_INTERVAL"),
+			"MAX_INTERVAL": v.GetDuration("RUNNER_METRICS_PUSH_MAX_INTERVAL"),
+			"TIMEOUT":      v.GetDuration("RUNNER_METRICS_PUSH_TIMEOUT"),
+			"BATCH_SIZE":   v.GetInt("RUNNER_METRICS_PUSH_BATCH_SIZE"),
+			"BUFFER_SIZE":  v.GetInt("RUNNER_METRICS_PUSH_BUFFER_SIZE"),
+		},
+		"BUDGET": map[string]interface{}{
+			"DAILY_CPU_HOURS":  v.GetFloat64("RUNNER_BUDGET_DAILY_CPU_HOURS"),
+			"DAILY_GPU_HOURS":  v.GetFloat64("RUNNER_BUDGET_DAILY_GPU_HOURS"),
+			"WEEKLY_CPU_HOURS": v.GetFloat64("RUNNER_BUDGET_WEEKLY_CPU_HOURS"),
+			"WEEKLY_GPU_HOURS": v.GetFloat64("RUNNER_BUDGET_WEEKLY_GPU_HOURS"),
+			"TIMEZONE":         v.GetString("RUNNER_BUDGET_TIMEZONE"),
+		},
+		"PRICING": map[string]interface{}{
+			"CPU_HOUR":    v.GetFloat64("RUNNER_PRICING_CPU_HOUR"),
+			"GPU_HOUR":    v.GetFloat64("RUNNER_PRICING_GPU_HOUR"),
+			"GB_RAM_HOUR": v.GetFloat64("RUNNER_PRICING_GB_RAM_HOUR"),
+		},
+		"SIGNER": map[string]interface{}{
+			"COMMAND": v.GetString("RUNNER_SIGNER_COMMAND"),
+			"TIMEOUT": v.GetDuration("RUNNER_SIGNER_TIMEOUT"),
+		},
+		"POISON": map[string]interface{}{
+			"THRESHOLD":     v.GetInt("RUNNER_POISON_THRESHOLD"),
+			"STORE_MODE":    v.GetString("RUNNER_POISON_STORE_MODE"),
+			"STORE_URL":     v.GetString("RUNNER_POISON_STORE_URL"),
+			"STORE_DIR":     v.GetString("RUNNER_POISON_STORE_DIR"),
+			"STORE_TIMEOUT": v.GetDuration("RUNNER_POISON_STORE_TIMEOUT"),
+		},
+		"CANARY": map[string]interface{}{
+			"ENABLED":     v.GetBool("RUNNER_CANARY_ENABLED"),
+			"INTERVAL":    v.GetDuration("RUNNER_CANARY_INTERVAL"),
+			"THRESHOLD":   v.GetInt("RUNNER_CANARY_THRESHOLD"),
+			"TIMEOUT":     v.GetDuration("RUNNER_CANARY_TIMEOUT"),
+			"IMAGE":       v.GetString("RUNNER_CANARY_IMAGE"),
+			"NETWORK_URL": v.GetString("RUNNER_CANARY_NETWORK_URL"),
+		},
+		"ERROR_BUDGET": map[string]interface{}{
+			"ENABLED":          v.GetBool("RUNNER_ERROR_BUDGET_ENABLED"),
+			"WINDOW":           v.GetDuration("RUNNER_ERROR_BUDGET_WINDOW"),
+			"MIN_FAILURES":     v.GetInt("RUNNER_ERROR_BUDGET_MIN_FAILURES"),
+			"MAX_FAILURE_RATE": v.GetFloat64("RUNNER_ERROR_BUDGET_MAX_FAILURE_RATE"),
+			"COOLDOWN":         v.GetDuration("RUNNER_ERROR_BUDGET_COOLDOWN"),
+			"DIAGNOSE":         v.GetBool("RUNNER_ERROR_BUDGET_DIAGNOSE"),
+		},
+		"REDACTION": map[string]interface{}{
+			"ENABLED":    v.GetBool("RUNNER_REDACTION_ENABLED"),
+			"DETECTORS":  v.GetStringSlice("RUNNER_REDACTION_DETECTORS"),
+			"RULES_FILE": v.GetString("RUNNER_REDACTION_RULES_FILE"),
+			"WINDOW":     v.GetInt("RUNNER_REDACTION_WINDOW"),
+		},
+		"MIGRATION": map[string]interface{}{
+			"ENABLED":       v.GetBool("RUNNER_MIGRATION_ENABLED"),
+			"DRAIN_TIMEOUT": v.GetDuration("RUNNER_MIGRATION_DRAIN_TIMEOUT"),
+		},
+		"EXECUTION_GUARD": map[string]interface{}{
+			"ENABLED": v.GetBool("RUNNER_EXECUTION_GUARD_ENABLED"),
+			"MODE":    v.GetString("RUNNER_EXECUTION_GUARD_MODE"),
+			"URL":     v.GetString("RUNNER_EXECUTION_GUARD_URL"),
+			"DIR":     v.GetString("RUNNER_EXECUTION_GUARD_DIR"),
+			"TIMEOUT": v.GetDuration("RUNNER_EXECUTION_GUARD_TIMEOUT"),
+		},
+		"FLEET_HINTS": map[string]interface{}{
+			"ENABLED": v.GetBool("RUNNER_FLEET_HINTS_ENABLED"),
+			"MODE":    v.GetString("RUNNER_FLEET_HINTS_MODE"),
+			"URL":     v.GetString("RUNNER_FLEET_HINTS_URL"),
+			"DIR":     v.GetString("RUNNER_FLEET_HINTS_DIR"),
+			"TTL":     v.GetDuration("RUNNER_FLEET_HINTS_TTL"),
+			"TIMEOUT": v.GetDuration("RUNNER_FLEET_HINTS_TIMEOUT"),
+			"MEMBERS": v.GetStringSlice("RUNNER_FLEET_HINTS_MEMBERS"),
+		},
+		"FL_COMPRESSION": map[string]interface{}{
+			"MODE":              v.GetString("RUNNER_FL_COMPRESSION_MODE"),
+			"TRAIN_AFTER":       v.GetInt("RUNNER_FL_COMPRESSION_TRAIN_AFTER"),
+			"MAX_DICTIONARY_KB": v.GetInt("RUNNER_FL_COMPRESSION_MAX_DICTIONARY_KB"),
+			"RETRAIN_RATIO":     v.GetFloat64("RUNNER_FL_COMPRESSION_RETRAIN_RATIO"),
+		},
+		"INTERACTIVE": map[string]interface{}{
+			"ENABLED":      v.GetBool("RUNNER_INTERACTIVE_ENABLED"),
+			"PROMPT":       v.GetString("RUNNER_INTERACTIVE_PROMPT"),
+			"HOOK_COMMAND": v.GetString("RUNNER_INTERACTIVE_HOOK_COMMAND"),
+			"TIMEOUT":      v.GetDuration("RUNNER_INTERACTIVE_TIMEOUT"),
+			"MAX_DURATION": v.GetDuration("RUNNER_INTERACTIVE_MAX_DURATION"),
+			"MAX_DISK":     v.GetString("RUNNER_INTERACTIVE_MAX_DISK"),
+			"CONFIRM_GPU":  v.GetBool("RUNNER_INTERACTIVE_CONFIRM_GPU"),
+		},
+		"RETENTION": map[string]interface{}{
+			"DEFAULT":        v.GetDuration("RUNNER_RETENTION_DEFAULT"),
+			"PURGE_INTERVAL": v.GetDuration("RUNNER_RETENTION_PURGE_INTERVAL"),
+		},
+		"OBJECT_STORAGE": map[string]interface{}{
+			"ENDPOINT":             v.GetString("RUNNER_OBJECT_STORAGE_ENDPOINT"),
+			"REGION":               v.GetString("RUNNER_OBJECT_STORAGE_REGION"),
+			"BUCKET":               v.GetString("RUNNER_OBJECT_STORAGE_BUCKET"),
+			"PREFIX":               v.GetString("RUNNER_OBJECT_STORAGE_PREFIX"),
+			"INPUT_BUCKETS":        v.GetStringSlice("RUNNER_OBJECT_STORAGE_INPUT_BUCKETS"),
+			"PATH_STYLE":           v.GetBool("RUNNER_OBJECT_STORAGE_PATH_STYLE"),
+			"ACCESS_KEY_ID":        v.GetString("RUNNER_OBJECT_STORAGE_ACCESS_KEY_ID"),
+			"SECRET_ACCESS_KEY":    v.GetString("RUNNER_OBJECT_STORAGE_SECRET_ACCESS_KEY"),
+			"SESSION_TOKEN":        v.GetString("RUNNER_OBJECT_STORAGE_SESSION_TOKEN"),
+			"SSE":                  v.GetString("RUNNER_OBJECT_STORAGE_SSE"),
+			"SSE_KMS_KEY_ID":       v.GetString("RUNNER_OBJECT_STORAGE_SSE_KMS_KEY_ID"),
+			"PART_SIZE_MB":         v.GetInt64("RUNNER_OBJECT_STORAGE_PART_SIZE_MB"),
+			"CHECKPOINT_BACKEND":   v.GetString("RUNNER_OBJECT_STORAGE_CHECKPOINT_BACKEND"),
+			"IMAGE_EXPORT_BACKEND": v.GetString("RUNNER_OBJECT_STORAGE_IMAGE_EXPORT_BACKEND"),
+		},
+		"EVENTS": map[string]interface{}{
+			"QUEUE_SIZE":   v.GetInt("RUNNER_EVENTS_QUEUE_SIZE"),
+			"HOOK_COMMAND": v.GetString("RUNNER_EVENTS_HOOK_COMMAND"),
+			"HOOK_EVENTS":  v.GetStringSlice("RUNNER_EVENTS_HOOK_EVENTS"),
+			"HOOK_TIMEOUT": v.GetDuration("RUNNER_EVENTS_HOOK_TIMEOUT"),
+		},
+		"CONCURRENCY": map[string]interface{}{
+			"INITIAL":         v.GetInt("RUNNER_CONCURRENCY_INITIAL"),
+			"MIN":             v.GetInt("RUNNER_CONCURRENCY_MIN"),
+			"MAX":             v.GetInt("RUNNER_CONCURRENCY_MAX"),
+			"PINNED":          v.GetBool("RUNNER_CONCURRENCY_PINNED"),
+			"BACKOFF":         v.GetFloat64("RUNNER_CONCURRENCY_BACKOFF"),
+			"SLOW_RATIO":      v.GetFloat64("RUNNER_CONCURRENCY_SLOW_RATIO"),
+			"PRESSURE":        v.GetFloat64("RUNNER_CONCURRENCY_PRESSURE"),
+			"COOLDOWN":        v.GetDuration("RUNNER_CONCURRENCY_COOLDOWN"),
+			"SAMPLE_INTERVAL": v.GetDuration("RUNNER_CONCURRENCY_SAMPLE_INTERVAL"),
+			"DRAIN_TIMEOUT":   v.GetDuration("RUNNER_CONCURRENCY_DRAIN_TIMEOUT"),
+		},
+		"REGISTRY": map[string]interface{}{
+			"MIRRORS":        v.GetStringSlice("RUNNER_REGISTRY_MIRRORS"),
+			"CHECK_INTERVAL": v.GetDuration("RUNNER_REGISTRY_CHECK_INTERVAL"),
+			"BLOB_CACHE":     v.GetBool("RUNNER_REGISTRY_BLOB_CACHE"),
+			"BLOB_PEERS":     v.GetStringSlice("RUNNER_REGISTRY_BLOB_PEERS"),
+			"SERVE_BLOBS":    v.GetBool("RUNNER_REGISTRY_SERVE_BLOBS"),
+			"MEMBERS":        v.GetStringSlice("RUNNER_REGISTRY_MEMBERS"),
+		},
+		"ESCROW": map[string]interface{}{
+			"NETWORK_KEY": v.GetString("RUNNER_ESCROW_NETWORK_KEY"),
+		},
+		"ANOMALY": map[string]interface{}{
+			"ENABLED":        v.GetBool("RUNNER_ANOMALY_ENABLED"),
+			"RULE":           v.GetString("RUNNER_ANOMALY_RULE"),
+			"THRESHOLD":      v.GetFloat64("RUNNER_ANOMALY_THRESHOLD"),
+			"QUANTILE":       v.GetFloat64("RUNNER_ANOMALY_QUANTILE"),
+			"WINDOW":         v.GetInt("RUNNER_ANOMALY_WINDOW"),
+			"MIN_SAMPLES":    v.GetInt("RUNNER_ANOMALY_MIN_SAMPLES"),
+			"MODE":           v.GetString("RUNNER_ANOMALY_MODE"),
+			"CRITICAL_SCORE": v.GetFloat64("RUNNER_ANOMALY_CRITICAL_SCORE"),
+			"HOLD_TIMEOUT":   v.GetDuration("RUNNER_ANOMALY_HOLD_TIMEOUT"),
+			"ALERT_URL":      v.GetString("RUNNER_ANOMALY_ALERT_URL"),
+		},
+		"DISK": map[string]interface{}{
+			"RESERVE":         v.GetBool("RUNNER_DISK_RESERVE"),
+			"PATH":            v.GetString("RUNNER_DISK_PATH"),
+			"MIN_FREE_MB":     v.GetInt64("RUNNER_DISK_MIN_FREE_MB"),
+			"HEADROOM_FACTOR": v.GetFloat64("RUNNER_DISK_HEADROOM_FACTOR"),
+		},
+		"INSTANCES_FILE":           v.GetString("RUNNER_INSTANCES_FILE"),
+		"DISABLED_TASK_TYPES":      v.GetStringSlice("RUNNER_DISABLED_TASK_TYPES"),
+		"FL_TRAINING_MEMORY":       v.GetString("RUNNER_FL_TRAINING_MEMORY"),
+		"ONNX_LIBRARY":             v.GetString("RUNNER_ONNX_LIBRARY"),
+		"EMBEDDING_INPUT_MAX_MB":   v.GetInt64("RUNNER_EMBEDDING_INPUT_MAX_MB"),
+		"CAPABILITY_SYNC_DEBOUNCE": v.GetDuration("RUNNER_CAPABILITY_SYNC_DEBOUNCE"),
+		"NTP_SERVER":               v.GetString("RUNNER_NTP_SERVER"),
+		"NTP_INTERVAL":             v.GetDuration("RUNNER_NTP_INTERVAL"),
 	})
 
 	var config Config
@@ -176,6 +1298,468 @@ func loadConfigFile(path string) (*Config, error) {
 		config.Runner.HeartbeatInterval = 30 * time.Second
 	}
 
+	if config.Runner.Health.ReadyHeartbeats == 0 {
+		config.Runner.Health.ReadyHeartbeats = 3
+	}
+	if config.Runner.Health.MinFreeDiskMB == 0 {
+		config.Runner.Health.MinFreeDiskMB = 1024
+	}
+
+	if config.Runner.AdaptiveTimeout.Factor == 0 {
+		config.Runner.AdaptiveTimeout.Factor = 3
+	}
+	if config.Runner.AdaptiveTimeout.Min == 0 {
+		config.Runner.AdaptiveTimeout.Min = time.Minute
+	}
+	if config.Runner.AdaptiveTimeout.Max == 0 {
+		config.Runner.AdaptiveTimeout.Max = 6 * time.Hour
+	}
+	if config.Runner.AdaptiveTimeout.MinSamples == 0 {
+		config.Runner.AdaptiveTimeout.MinSamples = 5
+	}
+	if config.Runner.History.Retention == 0 {
+		config.Runner.History.Retention = 90 * 24 * time.Hour
+	}
+	if config.Runner.History.MaxRecords == 0 {
+		config.Runner.History.MaxRecords = 100000
+	}
+	if config.Runner.TaskTimeout.Max == 0 {
+		config.Runner.TaskTimeout.Max = 24 * time.Hour
+	}
+	if config.Runner.TaskTimeout.HeartbeatInterval == 0 {
+		config.Runner.TaskTimeout.HeartbeatInterval = time.Minute
+	}
+
+	if len(config.Runner.Callback.AllowedSchemes) == 0 {
+		config.Runner.Callback.AllowedSchemes = []string{"https"}
+	}
+	if len(config.Runner.Callback.AllowedPorts) == 0 {
+		config.Runner.Callback.AllowedPorts = []int{443}
+	}
+	if config.Runner.Callback.Timeout == 0 {
+		config.Runner.Callback.Timeout = 5 * time.Second
+	}
+	if config.Runner.Callback.MaxAttempts == 0 {
+		config.Runner.Callback.MaxAttempts = 3
+	}
+
+	if config.Runner.Bandwidth.Endpoint == "" {
+		config.Runner.Bandwidth.Endpoint = strings.TrimSuffix(config.Runner.ServerURL, "/") + "/api/v1/runners/bandwidth"
+	}
+	if config.Runner.Bandwidth.ProbeMB == 0 {
+		config.Runner.Bandwidth.ProbeMB = 25
+	}
+
+	if config.Runner.Docker.Preflight == "" {
+		config.Runner.Docker.Preflight = "warn"
+	}
+	if config.Runner.Docker.SoftMemorySustain == 0 {
+		config.Runner.Docker.SoftMemorySustain = 10 * time.Second
+	}
+	if config.Runner.Docker.StallSustain == 0 {
+		config.Runner.Docker.StallSustain = 2 * time.Minute
+	}
+	if config.Runner.Docker.StopGracePeriod == 0 {
+		config.Runner.Docker.StopGracePeriod = 10 * time.Second
+	}
+	if config.Runner.Docker.RootPolicy == "" {
+		config.Runner.Docker.RootPolicy = "deny"
+	}
+
+	if config.Runner.ImageExport.IPFSAPIURL == "" {
+		config.Runner.ImageExport.IPFSAPIURL = "http://localhost:5001"
+	}
+	if config.Runner.ImageExport.MaxMB == 0 {
+		config.Runner.ImageExport.MaxMB = 2048
+	}
+	if config.Runner.ImageExport.MaxLayers == 0 {
+		config.Runner.ImageExport.MaxLayers = 64
+	}
+	if config.Runner.ImageExport.UploadAuth == "" {
+		config.Runner.ImageExport.UploadAuth = "none"
+	}
+	if config.Runner.ImageExport.PinningKeyURL == "" {
+		config.Runner.ImageExport.PinningKeyURL = config.Runner.ImageExport.IPFSAPIURL
+	}
+	if config.Runner.ImageExport.UploadTokenTTL == 0 {
+		config.Runner.ImageExport.UploadTokenTTL = 15 * time.Minute
+	}
+
+	if config.Runner.Cache.MinShare == 0 {
+		config.Runner.Cache.MinShare = 0.2
+	}
+	if config.Runner.Cache.RebalanceInterval == 0 {
+		config.Runner.Cache.RebalanceInterval = 10 * time.Minute
+	}
+
+	if config.Runner.Attestation.Mode == "" {
+		config.Runner.Attestation.Mode = "off"
+	}
+
+	if config.Runner.TokenUsage.Tolerance == 0 {
+		config.Runner.TokenUsage.Tolerance = 0.05
+	}
+	if config.Runner.TokenUsage.Slack == 0 {
+		config.Runner.TokenUsage.Slack = 32
+	}
+	if config.Runner.PromptCache.MaxMB == 0 {
+		config.Runner.PromptCache.MaxMB = 256
+	}
+	if config.Runner.PromptCache.TTL == 0 {
+		config.Runner.PromptCache.TTL = time.Hour
+	}
+	if config.Runner.WarmModels.KeepAlive == 0 {
+		config.Runner.WarmModels.KeepAlive = time.Hour
+	}
+	if config.Runner.IdleWork.Nice == 0 {
+		config.Runner.IdleWork.Nice = 19
+	}
+	if config.Runner.IdleWork.Sched == "" {
+		config.Runner.IdleWork.Sched = "idle"
+	}
+	if config.Runner.IdleWork.IOClass == "" {
+		config.Runner.IdleWork.IOClass = "idle"
+	}
+	if config.Runner.ArtifactChunking.Mode == "" {
+		config.Runner.ArtifactChunking.Mode = "off"
+	}
+	if config.Runner.ArtifactChunking.ChunkKB == 0 {
+		config.Runner.ArtifactChunking.ChunkKB = 1024
+	}
+	if config.Runner.ArtifactChunking.InlineKB == 0 {
+		config.Runner.ArtifactChunking.InlineKB = 4096
+	}
+	if config.Runner.Revocation.PollInterval == 0 {
+		config.Runner.Revocation.PollInterval = 5 * time.Second
+	}
+	if config.Runner.Revocation.Timeout == 0 {
+		config.Runner.Revocation.Timeout = 3 * time.Second
+	}
+	if config.Runner.APIRetry.MaxAttempts == 0 {
+		config.Runner.APIRetry.MaxAttempts = 4
+	}
+	if config.Runner.APIRetry.BaseDelay == 0 {
+		config.Runner.APIRetry.BaseDelay = 500 * time.Millisecond
+	}
+	if config.Runner.APIRetry.MaxDelay == 0 {
+		config.Runner.APIRetry.MaxDelay = 10 * time.Second
+	}
+	if config.Runner.APIRetry.Jitter == 0 {
+		config.Runner.APIRetry.Jitter = 0.2
+	}
+	if config.Runner.Transport == "" {
+		config.Runner.Transport = "webhook"
+	}
+	if config.Runner.TaskSocket.PingInterval == 0 {
+		config.Runner.TaskSocket.PingInterval = 20 * time.Second
+	}
+	if config.Runner.TaskSocket.PongWait == 0 {
+		config.Runner.TaskSocket.PongWait = 60 * time.Second
+	}
+	if config.Runner.TaskSocket.WriteWait == 0 {
+		config.Runner.TaskSocket.WriteWait = 10 * time.Second
+	}
+	if config.Runner.TaskSocket.ReconnectMaxDelay == 0 {
+		config.Runner.TaskSocket.ReconnectMaxDelay = 30 * time.Second
+	}
+	if config.Runner.TaskSocket.PollInterval == 0 {
+		config.Runner.TaskSocket.PollInterval = 10 * time.Second
+	}
+	if config.Runner.ArtifactPreview.Mode == "" {
+		config.Runner.ArtifactPreview.Mode = "on"
+	}
+	if config.Runner.ArtifactPreview.MaxKB == 0 {
+		config.Runner.ArtifactPreview.MaxKB = 16
+	}
+	if config.Runner.ArtifactPreview.Lines == 0 {
+		config.Runner.ArtifactPreview.Lines = 20
+	}
+	if config.Runner.ArtifactPreview.ThumbnailPx == 0 {
+		config.Runner.ArtifactPreview.ThumbnailPx = 128
+	}
+	if config.Runner.ArtifactPreview.MaxArtifacts == 0 {
+		config.Runner.ArtifactPreview.MaxArtifacts = 256
+	}
+	if config.Runner.ResultOffload.Mode == "" {
+		config.Runner.ResultOffload.Mode = "on"
+	}
+	if config.Runner.ResultOffload.ThresholdKB == 0 {
+		config.Runner.ResultOffload.ThresholdKB = 1024
+	}
+	if config.Runner.ResultOffload.PreviewKB == 0 {
+		config.Runner.ResultOffload.PreviewKB = 4
+	}
+	if len(config.Runner.ResultOffload.IPFSAPIURLs) == 0 {
+		config.Runner.ResultOffload.IPFSAPIURLs = []string{config.Runner.ImageExport.IPFSAPIURL}
+	}
+	if len(config.Runner.ResultOffload.Gateways) == 0 {
+		config.Runner.ResultOffload.Gateways = []string{"https://ipfs.io/ipfs/"}
+	}
+	if config.Runner.IPFSFetch.Mode == "" {
+		config.Runner.IPFSFetch.Mode = "on"
+	}
+	if len(config.Runner.IPFSFetch.Gateways) == 0 {
+		config.Runner.IPFSFetch.Gateways = []string{"https://ipfs.io/ipfs/", "https://trustless-gateway.link/ipfs/", "https://dweb.link/ipfs/"}
+	}
+	if config.Runner.IPFSFetch.SegmentKB == 0 {
+		config.Runner.IPFSFetch.SegmentKB = 4096
+	}
+	if config.Runner.IPFSFetch.Parallel == 0 {
+		config.Runner.IPFSFetch.Parallel = 4
+	}
+	if config.Runner.Reputation.ReconcileInterval == 0 {
+		config.Runner.Reputation.ReconcileInterval = 15 * time.Minute
+	}
+	if config.Runner.Reputation.DivergenceTolerance == 0 {
+		config.Runner.Reputation.DivergenceTolerance = 5
+	}
+	if config.Runner.Signer.Timeout == 0 {
+		config.Runner.Signer.Timeout = 10 * time.Second
+	}
+	if config.Runner.Fleet.OverlayTTL == 0 {
+		config.Runner.Fleet.OverlayTTL = time.Hour
+	}
+	if config.Runner.GPU.SafetyMargin == "" {
+		config.Runner.GPU.SafetyMargin = "1g"
+	}
+	if config.Runner.GPU.PollInterval == 0 {
+		config.Runner.GPU.PollInterval = 15 * time.Second
+	}
+	if config.Runner.Power.PollInterval == 0 {
+		config.Runner.Power.PollInterval = 30 * time.Second
+	}
+	if config.Runner.Power.BatteryThreshold == 0 {
+		config.Runner.Power.BatteryThreshold = 60
+	}
+	if len(config.Runner.Power.BatteryTaskTypes) == 0 {
+		config.Runner.Power.BatteryTaskTypes = []string{"command"}
+	}
+	if config.Runner.Power.BatteryMaxDuration == 0 {
+		config.Runner.Power.BatteryMaxDuration = 5 * time.Minute
+	}
+	if config.Runner.Power.PauseBelow == 0 {
+		config.Runner.Power.PauseBelow = 20
+	}
+	if config.Runner.Power.OnThrottle == "" {
+		config.Runner.Power.OnThrottle = "pause"
+	}
+	if config.Runner.Power.InFlight == "" {
+		config.Runner.Power.InFlight = "continue"
+	}
+	if config.Runner.Audit.CommitInterval == 0 {
+		config.Runner.Audit.CommitInterval = time.Hour
+	}
+	if config.Runner.Audit.Retention == 0 {
+		config.Runner.Audit.Retention = 7 * 24 * time.Hour
+	}
+	if config.Runner.Audit.MaxBundles == 0 {
+		config.Runner.Audit.MaxBundles = 1000
+	}
+	if config.Runner.ErrorReporting.Mode == "" {
+		config.Runner.ErrorReporting.Mode = "server"
+	}
+	if config.Runner.ErrorReporting.DedupWindow == 0 {
+		config.Runner.ErrorReporting.DedupWindow = time.Hour
+	}
+	if config.Runner.ErrorReporting.RateLimit == 0 {
+		config.Runner.ErrorReporting.RateLimit = 30
+	}
+	if config.Runner.ErrorReporting.BatchSize == 0 {
+		config.Runner.ErrorReporting.BatchSize = 50
+	}
+	if config.Runner.ErrorReporting.FlushInterval == 0 {
+		config.Runner.ErrorReporting.FlushInterval = time.Minute
+	}
+	if config.Runner.MetricsPush.Mode == "" {
+		config.Runner.MetricsPush.Mode = "remote-write"
+	}
+	if config.Runner.MetricsPush.Job == "" {
+		config.Runner.MetricsPush.Job = "parity-runner"
+	}
+	if config.Runner.MetricsPush.Interval == 0 {
+		config.Runner.MetricsPush.Interval = 30 * time.Second
+	}
+	if config.Runner.MetricsPush.MaxInterval == 0 {
+		config.Runner.MetricsPush.MaxInterval = 5 * time.Minute
+	}
+	if config.Runner.MetricsPush.Timeout == 0 {
+		config.Runner.MetricsPush.Timeout = 10 * time.Second
+	}
+	if config.Runner.MetricsPush.BatchSize == 0 {
+		config.Runner.MetricsPush.BatchSize = 500
+	}
+	if config.Runner.MetricsPush.BufferSize == 0 {
+		config.Runner.MetricsPush.BufferSize = 10000
+	}
+	if config.Runner.Poison.Threshold == 0 {
+		config.Runner.Poison.Threshold = 3
+	}
+	if config.Runner.Poison.StoreTimeout == 0 {
+		config.Runner.Poison.StoreTimeout = 5 * time.Second
+	}
+	if config.Runner.Canary.Interval == 0 {
+		config.Runner.Canary.Interval = 15 * time.Minute
+	}
+	if config.Runner.Canary.Threshold == 0 {
+		config.Runner.Canary.Threshold = 2
+	}
+	if config.Runner.Canary.Timeout == 0 {
+		config.Runner.Canary.Timeout = 2 * time.Minute
+	}
+	if config.Runner.Canary.Image == "" {
+		config.Runner.Canary.Image = "hello-world"
+	}
+	if config.Runner.Canary.NetworkURL == "" {
+		config.Runner.Canary.NetworkURL = "https://registry-1.docker.io/v2/"
+	}
+	if config.Runner.ErrorBudget.Window == 0 {
+		config.Runner.ErrorBudget.Window = time.Hour
+	}
+	if config.Runner.ErrorBudget.MinFailures == 0 {
+		config.Runner.ErrorBudget.MinFailures = 5
+	}
+	if config.Runner.ErrorBudget.MaxFailureRate == 0 {
+		config.Runner.ErrorBudget.MaxFailureRate = 0.5
+	}
+	if config.Runner.ErrorBudget.Cooldown == 0 {
+		config.Runner.ErrorBudget.Cooldown = 15 * time.Minute
+	}
+	if len(config.Runner.Redaction.Detectors) == 0 {
+		config.Runner.Redaction.Detectors = []string{"key", "email", "ipv6", "ipv4"}
+	}
+	if config.Runner.Redaction.Window == 0 {
+		config.Runner.Redaction.Window = 4096
+	}
+	if config.Runner.Migration.DrainTimeout == 0 {
+		config.Runner.Migration.DrainTimeout = 15 * time.Second
+	}
+	if config.Runner.ExecutionGuard.Mode == "" {
+		config.Runner.ExecutionGuard.Mode = "http"
+	}
+	if config.Runner.ExecutionGuard.Timeout == 0 {
+		config.Runner.ExecutionGuard.Timeout = 5 * time.Second
+	}
+	if config.Runner.FleetHints.Mode == "" {
+		config.Runner.FleetHints.Mode = "http"
+	}
+	if config.Runner.FleetHints.TTL == 0 {
+		config.Runner.FleetHints.TTL = time.Hour
+	}
+	if config.Runner.FleetHints.Timeout == 0 {
+		config.Runner.FleetHints.Timeout = 5 * time.Second
+	}
+	if config.Runner.Interactive.Prompt == "" {
+		config.Runner.Interactive.Prompt = "terminal"
+	}
+	if config.Runner.Interactive.Timeout == 0 {
+		config.Runner.Interactive.Timeout = 2 * time.Minute
+	}
+	if config.Runner.Events.QueueSize == 0 {
+		config.Runner.Events.QueueSize = 256
+	}
+	if config.Runner.Events.HookTimeout == 0 {
+		config.Runner.Events.HookTimeout = 10 * time.Second
+	}
+	if config.Runner.Concurrency.Initial == 0 {
+		config.Runner.Concurrency.Initial = 1
+	}
+	if config.Runner.Concurrency.Min == 0 {
+		config.Runner.Concurrency.Min = 1
+	}
+	if config.Runner.Concurrency.Backoff == 0 {
+		config.Runner.Concurrency.Backoff = 0.7
+	}
+	if config.Runner.Concurrency.SlowRatio == 0 {
+		config.Runner.Concurrency.SlowRatio = 1.5
+	}
+	if config.Runner.Concurrency.Pressure == 0 {
+		config.Runner.Concurrency.Pressure = 40
+	}
+	if config.Runner.Concurrency.Cooldown == 0 {
+		config.Runner.Concurrency.Cooldown = time.Minute
+	}
+	if config.Runner.Concurrency.SampleInterval == 0 {
+		config.Runner.Concurrency.SampleInterval = 10 * time.Second
+	}
+	if config.Runner.Concurrency.DrainTimeout == 0 {
+		config.Runner.Concurrency.DrainTimeout = 5 * time.Minute
+	}
+	if config.Runner.Registry.CheckInterval == 0 {
+		config.Runner.Registry.CheckInterval = time.Minute
+	}
+	if config.Runner.Anomaly.Rule == "" {
+		config.Runner.Anomaly.Rule = "zscore"
+	}
+	if config.Runner.Anomaly.Threshold == 0 {
+		config.Runner.Anomaly.Threshold = 4
+	}
+	if config.Runner.Anomaly.Quantile == 0 {
+		config.Runner.Anomaly.Quantile = 0.01
+	}
+	if config.Runner.Anomaly.Window == 0 {
+		config.Runner.Anomaly.Window = 200
+	}
+	if config.Runner.Anomaly.MinSamples == 0 {
+		config.Runner.Anomaly.MinSamples = 20
+	}
+	if config.Runner.Anomaly.Mode == "" {
+		config.Runner.Anomaly.Mode = "flag"
+	}
+	if config.Runner.Anomaly.CriticalScore == 0 {
+		config.Runner.Anomaly.CriticalScore = 2
+	}
+	if config.Runner.Anomaly.HoldTimeout == 0 {
+		config.Runner.Anomaly.HoldTimeout = 5 * time.Minute
+	}
+	if config.Runner.Disk.MinFreeMB == 0 {
+		config.Runner.Disk.MinFreeMB = 1024
+	}
+	if config.Runner.Disk.HeadroomFactor == 0 {
+		config.Runner.Disk.HeadroomFactor = 0.25
+	}
+	if config.Runner.NTPServer == "" {
+		config.Runner.NTPServer = "pool.ntp.org"
+	}
+	if config.Runner.NTPInterval == 0 {
+		config.Runner.NTPInterval = time.Hour
+	}
+	if config.Runner.EmbeddingInputMaxMB == 0 {
+		config.Runner.EmbeddingInputMaxMB = 1024
+	}
+	if config.Runner.CapabilitySyncDebounce == 0 {
+		config.Runner.CapabilitySyncDebounce = 2 * time.Second
+	}
+	if config.Runner.ObjectStorage.Region == "" {
+		config.Runner.ObjectStorage.Region = "us-east-1"
+	}
+	if config.Runner.ObjectStorage.PartSizeMB == 0 {
+		config.Runner.ObjectStorage.PartSizeMB = 16
+	}
+	if config.Runner.ObjectStorage.CheckpointBackend == "" {
+		config.Runner.ObjectStorage.CheckpointBackend = "ipfs"
+	}
+	if config.Runner.ObjectStorage.ImageExportBackend == "" {
+		config.Runner.ObjectStorage.ImageExportBackend = "ipfs"
+	}
+	if config.Runner.FLCompression.Mode == "" {
+		config.Runner.FLCompression.Mode = "none"
+	}
+	if config.Runner.FLCompression.TrainAfter == 0 {
+		config.Runner.FLCompression.TrainAfter = 3
+	}
+	if config.Runner.FLCompression.MaxDictionaryKB == 0 {
+		config.Runner.FLCompression.MaxDictionaryKB = 1024
+	}
+	if config.Runner.FLCompression.RetrainRatio == 0 {
+		config.Runner.FLCompression.RetrainRatio = 0.8
+	}
+	if config.Runner.Retention.Default == 0 {
+		config.Runner.Retention.Default = 24 * time.Hour
+	}
+	if config.Runner.Retention.PurgeInterval == 0 {
+		config.Runner.Retention.PurgeInterval = 10 * time.Minute
+	}
+
 	return &config, nil
 }
 
@@ -0,0 +1,68 @@
+package models
+
+import "time"
+
+// Severities of a result anomaly
+const (
+	// AnomalyWarning results are submitted with their anomaly noted
+	AnomalyWarning = "warning"
+	// AnomalyCritical results may be held for the operator to approve
+	AnomalyCritical = "critical"
+)
+
+// Decisions on an anomalous result
+const (
+	// AnomalyFlagged results were submitted with their anomaly noted
+	AnomalyFlagged = "flagged"
+	// AnomalyApproved results were held and approved by the operator
+	AnomalyApproved = "approved"
+	// AnomalyRejected results were held and rejected by the operator,
+	// and reported as failed instead
+	AnomalyRejected = "rejected"
+	// AnomalyTimedOut results were held without an answer and submitted
+	// flagged
+	AnomalyTimedOut = "timed_out"
+)
+
+// AnomalyFinding is one way a result stood out from the runner's earlier
+// results of its task type
+type AnomalyFinding struct {
+	// Metric is output_bytes, duration, response_tokens or exit_code
+	Metric string  `json:"metric"`
+	Value  float64 `json:"value"`
+	// Expected is the typical value, the mean or median of earlier results
+	Expected float64 `json:"expected"`
+	// Score is how far the value lies outside the usual range, 1 at the
+	// edge of it
+	Score  float64 `json:"score"`
+	Reason string  `json:"reason"`
+}
+
+// ResultAnomaly is set on a result the runner's self-monitoring found out
+// of line with its earlier results, a sign of a fault of the runner's own
+type ResultAnomaly struct {
+	Severity string           `json:"severity"`
+	Findings []AnomalyFinding `json:"findings"`
+	Decision string           `json:"decision"`
+	// Baseline is the number of earlier results compared against, zero
+	// when only the cold-start rules applied
+	Baseline int `json:"baseline"`
+}
+
+// HeldResult is an anomalous result awaiting the operator's approval
+type HeldResult struct {
+	TaskID   string         `json:"task_id"`
+	Type     TaskType       `json:"type"`
+	Instance string         `json:"instance,omitempty"`
+	HeldAt   time.Time      `json:"held_at"`
+	Deadline time.Time      `json:"deadline"`
+	Anomaly  *ResultAnomaly `json:"anomaly"`
+}
+
+// AnomalyStatus is the runner's self-monitoring of its results
+type AnomalyStatus struct {
+	Checked  uint64       `json:"checked"`
+	Flagged  uint64       `json:"flagged"`
+	Rejected uint64       `json:"rejected"`
+	Held     []HeldResult `json:"held,omitempty"`
+}
@@ -0,0 +1,83 @@
+package models
+
+// ArtifactPreviewKind says how an artifact's preview describes it
+type ArtifactPreviewKind string
+
+const (
+	ArtifactPreviewText  ArtifactPreviewKind = "text"
+	ArtifactPreviewCSV   ArtifactPreviewKind = "csv"
+	ArtifactPreviewJSON  ArtifactPreviewKind = "json"
+	ArtifactPreviewImage ArtifactPreviewKind = "image"
+)
+
+// ArtifactDescription describes a file a task produced. ContentType is
+// detected from the file's leading bytes, falling back to its extension.
+// Preview is left out for types the runner does not preview, and when the
+// preview could not be generated within its size cap; PreviewError then
+// says why.
+type ArtifactDescription struct {
+	// Path is slash separated and relative to the task's output directory
+	Path         string           `json:"path"`
+	Size         int64            `json:"size"`
+	SHA256       string           `json:"sha256"`
+	ContentType  string           `json:"content_type"`
+	Preview      *ArtifactPreview `json:"preview,omitempty"`
+	PreviewError string           `json:"preview_error,omitempty"`
+}
+
+// ArtifactPreview is a small view of an artifact's content, generated from
+// a bounded prefix of it. Truncated is set when the artifact continues past
+// what the preview covers.
+type ArtifactPreview struct {
+	Kind ArtifactPreviewKind `json:"kind"`
+	// Lines holds the first lines of text and CSV artifacts, each cut to a
+	// bounded length
+	Lines     []string `json:"lines,omitempty"`
+	Truncated bool     `json:"truncated,omitempty"`
+	// Columns and Rows describe the rows of a CSV artifact read
+	Columns []ColumnStats `json:"columns,omitempty"`
+	Rows    int           `json:"rows,omitempty"`
+	JSON    *JSONSummary  `json:"json,omitempty"`
+	Image   *ImagePreview `json:"image,omitempty"`
+}
+
+// ColumnStats summarizes a CSV column over the rows read. Min, Max and
+// Mean are set for columns whose every non-empty value is a number.
+type ColumnStats struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	NonEmpty int      `json:"non_empty"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+	Mean     *float64 `json:"mean,omitempty"`
+}
+
+// JSONSummary describes the structure of a JSON artifact: its top-level
+// type, the first keys of a top-level object, or the length and item type
+// of a top-level array, and how deeply it nests
+type JSONSummary struct {
+	Type     string      `json:"type"`
+	Keys     []JSONField `json:"keys,omitempty"`
+	KeyCount int         `json:"key_count,omitempty"`
+	Length   int         `json:"length,omitempty"`
+	ItemType string      `json:"item_type,omitempty"`
+	Depth    int         `json:"depth"`
+	// Truncated is set when the artifact was not read to its end, so counts
+	// cover only what was read
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// JSONField is a key of a JSON object and the type of its value
+type JSONField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ImagePreview gives an image's dimensions and, when it could be decoded
+// within the runner's bounds, a PNG thumbnail as a data URI
+type ImagePreview struct {
+	Format    string `json:"format"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+}
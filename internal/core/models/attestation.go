@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// AttestationType names the mechanism that produced attestation evidence
+type AttestationType string
+
+const (
+	AttestationTPM    AttestationType = "tpm2-quote"
+	AttestationSEVSNP AttestationType = "sev-snp"
+	// AttestationSimulated is signed by a software key and proves nothing
+	// about the host; verifiers accept it only in tests
+	AttestationSimulated AttestationType = "simulated"
+)
+
+// AttestationChallenge is the server-issued nonce that evidence must bind,
+// proving the evidence was produced fresh
+type AttestationChallenge struct {
+	Nonce     string    `json:"nonce"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// AttestationEvidence binds the runner binary and configuration, and for
+// per-result evidence the task and its result, to a hardware-rooted report.
+// ReportData is the digest of the bound fields that the report carries.
+type AttestationEvidence struct {
+	Type        AttestationType `json:"type"`
+	Nonce       string          `json:"nonce"`
+	BinaryHash  string          `json:"binary_hash"`
+	ConfigHash  string          `json:"config_hash"`
+	TaskID      string          `json:"task_id,omitempty"`
+	ResultHash  string          `json:"result_hash,omitempty"`
+	ReportData  string          `json:"report_data"`
+	Report      []byte          `json:"report"`
+	Signature   []byte          `json:"signature,omitempty"`
+	PublicKey   []byte          `json:"public_key,omitempty"`
+	CollectedAt time.Time       `json:"collected_at"`
+}
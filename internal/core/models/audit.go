@@ -0,0 +1,65 @@
+package models
+
+import "time"
+
+// AuditCommitment is a Merkle root over the tasks a runner has completed,
+// published in heartbeats so that audits can later select among exactly
+// those tasks. Each epoch commits to the first Count completed tasks in the
+// runner's history.
+type AuditCommitment struct {
+	Epoch       uint64    `json:"epoch"`
+	Root        string    `json:"root"`
+	Count       int       `json:"count"`
+	PublicKey   string    `json:"public_key"`
+	CommittedAt time.Time `json:"committed_at"`
+}
+
+// AuditChallenge asks the runner to re-execute the task the VRF selects
+// from the tasks committed in Epoch, with Seed as the server's contribution
+type AuditChallenge struct {
+	ID    string `json:"id"`
+	Seed  string `json:"seed"`
+	Epoch uint64 `json:"epoch"`
+}
+
+// AuditStatus is the outcome of an audit challenge
+type AuditStatus string
+
+const (
+	// AuditStatusReexecuted means the selected task was run again and
+	// ResultDigest holds the new result
+	AuditStatusReexecuted AuditStatus = "reexecuted"
+	// AuditStatusBundleMissing means the runner no longer holds what it
+	// needs to run the selected task again
+	AuditStatusBundleMissing AuditStatus = "bundle_missing"
+	// AuditStatusFailed means the challenge could not be answered, such as
+	// for an unknown epoch or a failed re-execution
+	AuditStatusFailed AuditStatus = "failed"
+)
+
+// AuditRetention is how long the runner keeps replay bundles
+type AuditRetention struct {
+	MaxAgeSeconds int64 `json:"max_age_seconds,omitempty"`
+	MaxBundles    int   `json:"max_bundles,omitempty"`
+}
+
+// AuditResponse answers an audit challenge. Proof is the VRF proof over the
+// challenge and commitment, from which anyone holding the commitment's
+// public key can recompute Index, and InclusionProof shows the selected
+// leaf is in the committed root.
+type AuditResponse struct {
+	ChallengeID    string          `json:"challenge_id"`
+	Epoch          uint64          `json:"epoch"`
+	Root           string          `json:"root"`
+	Count          int             `json:"count"`
+	Proof          string          `json:"proof,omitempty"`
+	Index          int             `json:"index"`
+	TaskID         string          `json:"task_id,omitempty"`
+	InclusionProof []string        `json:"inclusion_proof,omitempty"`
+	OriginalDigest string          `json:"original_digest,omitempty"`
+	ResultDigest   string          `json:"result_digest,omitempty"`
+	Match          bool            `json:"match"`
+	Status         AuditStatus     `json:"status"`
+	Error          string          `json:"error,omitempty"`
+	Retention      *AuditRetention `json:"retention,omitempty"`
+}
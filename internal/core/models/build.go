@@ -0,0 +1,30 @@
+package models
+
+// BuildProvenance describes how a runner binary was built, and fingerprints
+// it. Official builds are stamped with their version, commit and builder at
+// link time; other builds describe themselves from the build information
+// the Go toolchain embeds, when it is there.
+type BuildProvenance struct {
+	Version    string `json:"version"`
+	Module     string `json:"module,omitempty"`
+	Commit     string `json:"commit,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	// Modified is set for a binary built from a tree with uncommitted
+	// changes
+	Modified  bool   `json:"modified,omitempty"`
+	Builder   string `json:"builder,omitempty"`
+	GoVersion string `json:"go_version,omitempty"`
+	// Stamped is set when the version, commit and builder were stamped at
+	// link time rather than read from the toolchain's build information
+	Stamped bool `json:"stamped"`
+	// Ephemeral is set for a binary built by go run or go test, whose hash
+	// is that of a temporary build
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	// BinaryHash is the hex SHA-256 of the running executable, empty when
+	// it could not be read
+	BinaryHash string `json:"binary_hash,omitempty"`
+	// Fingerprint digests BinaryHash with the provenance the binary claims,
+	// so a modified binary claiming an official build's provenance still
+	// fingerprints apart from it
+	Fingerprint string `json:"fingerprint"`
+}
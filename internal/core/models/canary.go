@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// CanaryStatus is the state of the self-test of one capability of the
+// runner, such as running containers or generating with a model
+type CanaryStatus struct {
+	Capability string `json:"capability"`
+	// Degraded capabilities failed their canary Threshold times in a row
+	// and are not advertised until it passes again
+	Degraded            bool       `json:"degraded"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastRunAt           time.Time  `json:"last_run_at"`
+	LastError           string     `json:"last_error,omitempty"`
+	DegradedSince       *time.Time `json:"degraded_since,omitempty"`
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// RunnerFeature is an optional capability a runner may offer tasks
+type RunnerFeature string
+
+const (
+	// RunnerFeatureGPU runs tasks on a GPU
+	RunnerFeatureGPU RunnerFeature = "gpu"
+	// RunnerFeatureNetworkIsolated runs tasks in containers with their own
+	// network namespace
+	RunnerFeatureNetworkIsolated RunnerFeature = "network-isolated"
+	// RunnerFeatureAttestation attests the runner's environment
+	RunnerFeatureAttestation RunnerFeature = "attestation"
+)
+
+// TaskTypeSupport is a task type a runner runs and the config schema
+// versions it understands for it
+type TaskTypeSupport struct {
+	Type           TaskType `json:"type"`
+	SchemaVersions []int    `json:"schema_versions,omitempty"`
+}
+
+// RunnerCapabilities is what a runner tells the server it can run, so the
+// server only routes it tasks it supports
+type RunnerCapabilities struct {
+	TaskTypes []TaskTypeSupport `json:"task_types"`
+	Features  []RunnerFeature   `json:"features,omitempty"`
+}
+
+// Supports reports whether the runner runs tasks of taskType
+func (c *RunnerCapabilities) Supports(taskType TaskType) bool {
+	for _, support := range c.TaskTypes {
+		if support.Type == taskType {
+			return true
+		}
+	}
+	return false
+}
+
+// CapabilityProfile is the part of a runner's registration that changes
+// while it runs, such as its loaded models, free GPUs and task types, as
+// the JSON of each registration field by name
+type CapabilityProfile map[string]json.RawMessage
+
+// Diff returns the fields of next that differ from p, with fields next no
+// longer has set to null
+func (p CapabilityProfile) Diff(next CapabilityProfile) map[string]json.RawMessage {
+	changed := make(map[string]json.RawMessage)
+	for field, value := range next {
+		if old, ok := p[field]; !ok || !bytes.Equal(old, value) {
+			changed[field] = value
+		}
+	}
+	for field := range p {
+		if _, ok := next[field]; !ok {
+			changed[field] = json.RawMessage("null")
+		}
+	}
+	return changed
+}
+
+// CapabilityPatch updates the server's copy of a runner's capability
+// profile from BaseVersion, the version the server last acknowledged, to
+// Version, setting only the fields that changed
+type CapabilityPatch struct {
+	BaseVersion int64                      `json:"base_version"`
+	Version     int64                      `json:"version"`
+	Fields      map[string]json.RawMessage `json:"fields"`
+}
+
+// CapabilityAck is the profile version the server holds once it applied
+// a capability patch
+type CapabilityAck struct {
+	Version int64 `json:"version"`
+}
@@ -0,0 +1,48 @@
+package models
+
+// ArtifactManifestVersion is the version of the chunked artifact manifest
+// format this runner writes
+const ArtifactManifestVersion = 1
+
+// Chunking schemes of chunked artifacts
+const (
+	// ChunkingFixed cuts artifacts into chunks of one size
+	ChunkingFixed = "fixed"
+	// ChunkingContentDefined cuts artifacts where their content says, so
+	// that bytes inserted or removed shift only the chunks around them
+	ChunkingContentDefined = "cdc"
+)
+
+// ArtifactManifest is uploaded in place of an artifact uploaded as chunks,
+// listing them in order. Root is the root of the Merkle tree whose leaves
+// are the chunks' hashes, so that any chunk can be checked against it
+// without the rest of the artifact.
+type ArtifactManifest struct {
+	Version  int             `json:"version"`
+	Name     string          `json:"name"`
+	Size     int64           `json:"size"`
+	Chunking string          `json:"chunking"`
+	Root     string          `json:"root"`
+	Chunks   []ArtifactChunk `json:"chunks"`
+}
+
+// ArtifactChunk is one chunk of a chunked artifact
+type ArtifactChunk struct {
+	// Hash is the hex SHA-256 of the chunk, by which it is deduplicated
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+	CID  string `json:"cid"`
+}
+
+// ChunkedArtifact is how a result refers to an artifact uploaded as chunks
+type ChunkedArtifact struct {
+	Name string `json:"name"`
+	// ManifestCID identifies the artifact's manifest
+	ManifestCID string `json:"manifest_cid"`
+	Root        string `json:"root"`
+	Size        int64  `json:"size"`
+	Chunks      int    `json:"chunks"`
+	// UploadedChunks counts the chunks the destination did not already
+	// hold
+	UploadedChunks int `json:"uploaded_chunks"`
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// ComputeBudget is the runner's use of its daily and weekly compute
+// budgets. While Exhausted the runner declines every task, and while
+// GPUExhausted tasks that need a GPU, until ResumesAt.
+type ComputeBudget struct {
+	Exhausted    bool           `json:"exhausted"`
+	GPUExhausted bool           `json:"gpu_exhausted"`
+	ResumesAt    time.Time      `json:"resumes_at,omitempty"`
+	Windows      []BudgetWindow `json:"windows"`
+}
+
+// BudgetWindow is the compute used in one budget window; a zero limit
+// leaves that resource uncapped
+type BudgetWindow struct {
+	Window        string    `json:"window"`
+	StartedAt     time.Time `json:"started_at"`
+	ResetsAt      time.Time `json:"resets_at"`
+	CPUHours      float64   `json:"cpu_hours"`
+	CPUHoursLimit float64   `json:"cpu_hours_limit,omitempty"`
+	GPUHours      float64   `json:"gpu_hours"`
+	GPUHoursLimit float64   `json:"gpu_hours_limit,omitempty"`
+}
@@ -0,0 +1,57 @@
+package models
+
+import "time"
+
+// Reasons the runner changed how many tasks it runs at once
+const (
+	ConcurrencyHealthy   = "healthy"
+	ConcurrencySlow      = "slow"
+	ConcurrencyTimeout   = "timeout"
+	ConcurrencyPressure  = "pressure"
+	ConcurrencyThrottled = "throttled"
+)
+
+// ConcurrencyDecision is a change to how many tasks the runner runs at once
+type ConcurrencyDecision struct {
+	Time   time.Time `json:"time"`
+	From   int       `json:"from"`
+	To     int       `json:"to"`
+	Reason string    `json:"reason"`
+	// Detail is what prompted the change, such as the latency seen
+	Detail string `json:"detail,omitempty"`
+}
+
+// ConcurrencyStatus is how many tasks the runner runs at once and how it
+// came to: the limit it probes upward while tasks run as fast as they
+// usually do and the host is not saturated, and backs off from otherwise.
+// A pinned limit never changes.
+type ConcurrencyStatus struct {
+	Limit  int  `json:"limit"`
+	Min    int  `json:"min"`
+	Max    int  `json:"max"`
+	Pinned bool `json:"pinned"`
+	// Pressure is the host's pressure stall information last sampled, in
+	// percent, and Throttled whether it was thermally throttled
+	Pressure  float64 `json:"pressure,omitempty"`
+	Throttled bool    `json:"throttled,omitempty"`
+	Increases uint64  `json:"increases"`
+	Decreases uint64  `json:"decreases"`
+	// Decisions are the latest changes to the limit, newest last
+	Decisions []ConcurrencyDecision `json:"decisions,omitempty"`
+	// Reserved is what the tasks running hold of the host
+	Reserved *HostReservation `json:"reserved,omitempty"`
+}
+
+// HostReservation is how much of the host's memory and CPU the tasks
+// running reserved, and of how much. Memory the runner could not measure
+// has no capacity and is not accounted.
+type HostReservation struct {
+	MemoryBytes         uint64 `json:"memory_bytes"`
+	MemoryCapacityBytes uint64 `json:"memory_capacity_bytes,omitempty"`
+	CPUShares           int64  `json:"cpu_shares"`
+	CPUCapacityShares   int64  `json:"cpu_capacity_shares"`
+	Reservations        int    `json:"reservations"`
+	// Rejected counts the tasks skipped for not fitting beside the others
+	// or on the host at all
+	Rejected uint64 `json:"rejected,omitempty"`
+}
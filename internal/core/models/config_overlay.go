@@ -0,0 +1,32 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ConfigOverlay is a fleet configuration overlay as delivered in a heartbeat
+// response. Signature is the base64 Ed25519 signature of the exact Payload
+// bytes, which decode to a ConfigOverlayPayload.
+type ConfigOverlay struct {
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature"`
+}
+
+// AllRunners as a runner ID issues an overlay to the whole fleet
+const AllRunners = "*"
+
+// ConfigOverlayPayload sets runner settings by name on the runners it is
+// issued to until ExpiresAt. Versions increase with every overlay the fleet
+// issues.
+type ConfigOverlayPayload struct {
+	// RunnerID is the device ID of the runner the overlay is issued to, or
+	// AllRunners. Overlays naming no runner at all go to the whole fleet.
+	RunnerID string `json:"runner_id"`
+	// RunnerIDs issues the overlay to several runners
+	RunnerIDs []string                   `json:"runner_ids,omitempty"`
+	Version   int64                      `json:"version"`
+	IssuedAt  time.Time                  `json:"issued_at"`
+	ExpiresAt time.Time                  `json:"expires_at,omitempty"`
+	Settings  map[string]json.RawMessage `json:"settings"`
+}
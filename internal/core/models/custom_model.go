@@ -0,0 +1,55 @@
+package models
+
+// Reasons a model artifact supplied by a task's creator failed to load
+const (
+	// ModelLoadDownload: the artifact could not be downloaded
+	ModelLoadDownload = "download_failed"
+	// ModelLoadHashMismatch: the artifact does not match its declared hash
+	// or size
+	ModelLoadHashMismatch = "hash_mismatch"
+	// ModelLoadInsufficientMemory: the model does not fit in the memory,
+	// RAM or VRAM, the runner has for model weights
+	ModelLoadInsufficientMemory = "insufficient_memory"
+	// ModelLoadNotGGUF: the artifact is not a GGUF file
+	ModelLoadNotGGUF = "not_gguf"
+	// ModelLoadUnsupportedVersion: the artifact is a GGUF version the
+	// runner does not read
+	ModelLoadUnsupportedVersion = "unsupported_version"
+	// ModelLoadMalformed: the artifact's header or tensor table is damaged
+	// or truncated
+	ModelLoadMalformed = "malformed"
+	// ModelLoadQuantizationMismatch: the model is not quantized as the task
+	// requires
+	ModelLoadQuantizationMismatch = "quantization_mismatch"
+	// ModelLoadBackendRejected: the LLM backend refused to load the model
+	ModelLoadBackendRejected = "backend_rejected"
+)
+
+// CustomModelReport describes the model artifact an LLM task's prompts were
+// served with, and why it failed to load when it did
+type CustomModelReport struct {
+	// Model is the ephemeral name the artifact was loaded into the backend
+	// as
+	Model        string `json:"model"`
+	SHA256       string `json:"sha256"`
+	SizeBytes    int64  `json:"size_bytes,omitempty"`
+	Quantization string `json:"quantization,omitempty"`
+	Architecture string `json:"architecture,omitempty"`
+	// Cached is set when the backend already held the model, so it was not
+	// downloaded again
+	Cached bool `json:"cached,omitempty"`
+	// License is the license the creator accepted for the model, as the
+	// task config declares it
+	License *ModelLicense `json:"license,omitempty"`
+	// LoadFailure is one of the ModelLoad reasons when the model failed to
+	// load
+	LoadFailure string `json:"load_failure,omitempty"`
+}
+
+// ModelLicense is the license of a model artifact and whether its terms
+// were accepted
+type ModelLicense struct {
+	Name     string `json:"name"`
+	URL      string `json:"url,omitempty"`
+	Accepted bool   `json:"accepted"`
+}
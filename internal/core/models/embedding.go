@@ -0,0 +1,21 @@
+package models
+
+type EmbeddingFormat string
+
+const (
+	EmbeddingFormatJSONL   EmbeddingFormat = "jsonl"
+	EmbeddingFormatFloat32 EmbeddingFormat = "float32"
+)
+
+// EmbeddingSummary describes the artifact produced by an embedding task
+type EmbeddingSummary struct {
+	Model          string          `json:"model"`
+	Format         EmbeddingFormat `json:"format"`
+	Items          int             `json:"items"`
+	TruncatedItems int             `json:"truncated_items"`
+	Dimensions     int             `json:"dimensions"`
+	Normalized     bool            `json:"normalized"`
+	DurationMs     int64           `json:"duration_ms"`
+	ItemsPerSecond float64         `json:"items_per_second"`
+	Artifacts      []string        `json:"artifacts"`
+}
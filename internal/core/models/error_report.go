@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// ErrorEvent is one deduplicated error a runner shares for fleet
+// diagnostics. Context holds only redacted values; secrets and task payload
+// contents are never included.
+type ErrorEvent struct {
+	Fingerprint   string            `json:"fingerprint"`
+	Code          string            `json:"code"`
+	Category      string            `json:"category"`
+	TaskType      TaskType          `json:"task_type,omitempty"`
+	RunnerVersion string            `json:"runner_version"`
+	OS            string            `json:"os"`
+	Arch          string            `json:"arch"`
+	Message       string            `json:"message,omitempty"`
+	Context       map[string]string `json:"context,omitempty"`
+	// Count is how often the error occurred, including repeats suppressed
+	// within the deduplication window
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// ErrorReport is a batch of error events. Dropped counts events discarded
+// by the rate limit or a full queue since the previous report.
+type ErrorReport struct {
+	Events  []ErrorEvent `json:"events"`
+	Dropped int          `json:"dropped,omitempty"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// EscrowConfig asks for a task's result to be escrowed: encrypted to the
+// creator's key and the network's escrow key and uploaded to IPFS, so what
+// the runner delivered can be shown long after the runner deleted it. Keys
+// are hex secp256k1 public keys; the runner's configured escrow key is
+// used when EscrowKey is empty.
+type EscrowConfig struct {
+	CreatorKey string `json:"creator_key,omitempty"`
+	EscrowKey  string `json:"escrow_key,omitempty"`
+}
+
+// Validate checks that the keys given are hex public keys
+func (c *EscrowConfig) Validate() error {
+	if err := validHexKey(c.CreatorKey); err != nil {
+		return fmt.Errorf("invalid creator key: %w", err)
+	}
+	if err := validHexKey(c.EscrowKey); err != nil {
+		return fmt.Errorf("invalid escrow key: %w", err)
+	}
+	return nil
+}
+
+func validHexKey(key string) error {
+	if key == "" {
+		return nil
+	}
+	_, err := hex.DecodeString(strings.TrimPrefix(key, "0x"))
+	return err
+}
+
+// EscrowReport is the escrow of a task's result. CID locates the sealed
+// result bundle, whose SHA-256 is BundleHash; Recipients are the addresses
+// of the keys that can open it. Skipped says why a task asking for escrow
+// was not escrowed.
+type EscrowReport struct {
+	CID        string   `json:"cid,omitempty"`
+	BundleHash string   `json:"bundle_hash,omitempty"`
+	Recipients []string `json:"recipients,omitempty"`
+	Skipped    string   `json:"skipped,omitempty"`
+}
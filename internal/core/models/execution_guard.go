@@ -0,0 +1,10 @@
+package models
+
+// ExecutionGuardStats counts the decisions of a runner's fleet execution
+// guard since it started. FailedOpen counts executions that proceeded
+// because the shared lock service was unreachable.
+type ExecutionGuardStats struct {
+	Acquired   uint64 `json:"acquired"`
+	Duplicates uint64 `json:"duplicates"`
+	FailedOpen uint64 `json:"failed_open"`
+}
@@ -0,0 +1,109 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// FailureCode classifies why a task failed, telling failures the runner
+// caused apart from those the task caused
+type FailureCode string
+
+const (
+	// FailureTaskExit is a task that ran and exited with an error
+	FailureTaskExit FailureCode = "task_exit"
+	// FailureInvalidConfig is a task whose config cannot be run as given
+	FailureInvalidConfig FailureCode = "invalid_config"
+	// FailurePolicy is a task asking for what the runner's policy forbids
+	FailurePolicy FailureCode = "policy"
+	// FailureInputs is a task whose inputs could not be fetched or verified
+	FailureInputs FailureCode = "inputs"
+	// FailureDependency is a task whose dependencies' artifacts could not
+	// be fetched
+	FailureDependency FailureCode = "dependency"
+	// FailureTimeout is a task that ran past its timeout
+	FailureTimeout FailureCode = "timeout"
+	// FailureOutputs is a task whose outputs did not satisfy its manifest
+	FailureOutputs FailureCode = "outputs"
+	// FailureStalled is a task abandoned after it stalled on memory, IO or
+	// CPU for longer than the runner allows
+	FailureStalled FailureCode = "stalled"
+	// FailureVerdict is a task whose post-processing verdict failed it, or
+	// could not be evaluated
+	FailureVerdict FailureCode = "verdict"
+
+	// FailureContainerRuntime is the container runtime failing to create,
+	// start, watch or read a task's container
+	FailureContainerRuntime FailureCode = "container_runtime"
+	// FailureScratch is the runner's scratch space failing, such as when
+	// its disk is full
+	FailureScratch FailureCode = "scratch"
+	// FailureBackend is the model backend failing to serve a generation
+	FailureBackend FailureCode = "backend"
+	// FailureAnomalous is a result the runner's self-monitoring found
+	// anomalous and the operator rejected
+	FailureAnomalous FailureCode = "anomalous"
+	// FailureRevoked is a task killed by an emergency revocation
+	FailureRevoked FailureCode = "revoked"
+
+	// FailureUnknown is a failure that was not classified
+	FailureUnknown FailureCode = "unknown"
+)
+
+// RunnerCaused reports whether failures with the code were caused by the
+// runner rather than by the task
+func (c FailureCode) RunnerCaused() bool {
+	switch c {
+	case FailureContainerRuntime, FailureScratch, FailureBackend, FailureAnomalous:
+		return true
+	}
+	return false
+}
+
+// FailureError is an error classified with a FailureCode
+type FailureError struct {
+	Code FailureCode
+	Err  error
+}
+
+// Failure classifies err with code
+func Failure(code FailureCode, err error) error {
+	return &FailureError{Code: code, Err: err}
+}
+
+func (e *FailureError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FailureError) Unwrap() error {
+	return e.Err
+}
+
+// FailureCodeOf returns the code err was classified with, FailureUnknown
+// when it was not
+func FailureCodeOf(err error) FailureCode {
+	var failure *FailureError
+	if errors.As(err, &failure) {
+		return failure.Code
+	}
+	return FailureUnknown
+}
+
+// ErrorBudgetStatus is how much of a task type's error budget the runner
+// used within the window: failures it caused against every outcome of the
+// type. Paused types are not claimed until their cool-down has passed and
+// a canary of what they need passes.
+type ErrorBudgetStatus struct {
+	Type           TaskType    `json:"type"`
+	Paused         bool        `json:"paused"`
+	Outcomes       int         `json:"outcomes"`
+	RunnerFailures int         `json:"runner_failures"`
+	FailureRate    float64     `json:"failure_rate"`
+	LastCode       FailureCode `json:"last_code,omitempty"`
+	PausedAt       *time.Time  `json:"paused_at,omitempty"`
+	// ResumeAfter is when a paused type's canary is next tried
+	ResumeAfter *time.Time `json:"resume_after,omitempty"`
+	// Diagnosis lists what the runner's health checks found wrong when
+	// the type was paused
+	Diagnosis []string `json:"diagnosis,omitempty"`
+}
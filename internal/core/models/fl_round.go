@@ -0,0 +1,99 @@
+package models
+
+// FLDeclineReason explains why a runner selected for a federated learning
+// round will not participate
+type FLDeclineReason string
+
+const (
+	FLDeclineNoData                FLDeclineReason = "no_data"
+	FLDeclineAtCapacity            FLDeclineReason = "at_capacity"
+	FLDeclineDraining              FLDeclineReason = "draining"
+	FLDeclineInsufficientResources FLDeclineReason = "insufficient_resources"
+	FLDeclineAttestationRequired   FLDeclineReason = "attestation_required"
+	FLDeclineIncompatibleImage     FLDeclineReason = "incompatible_image"
+	FLDeclineInvalidConfig         FLDeclineReason = "invalid_config"
+	FLDeclinePowerConstrained      FLDeclineReason = "power_constrained"
+	FLDeclineBudgetExhausted       FLDeclineReason = "budget_exhausted"
+	FLDeclineFiltered              FLDeclineReason = "filtered"
+	FLDeclineUnsupportedType       FLDeclineReason = "unsupported_type"
+	// FLDeclineBatchNormAccumulation is given when the model normalizes over
+	// the batch and its batches only fit in memory as micro-batches
+	FLDeclineBatchNormAccumulation FLDeclineReason = "batch_norm_accumulation"
+	// FLDeclineNotConfirmed is given when the operator of an interactive
+	// runner denies the task or does not answer in time
+	FLDeclineNotConfirmed FLDeclineReason = "not_confirmed"
+	// FLDeclineModelIntegrity is given when the round's global model does
+	// not match the hash the coordinator published
+	FLDeclineModelIntegrity FLDeclineReason = "model_integrity"
+	// FLDeclineModelUnavailable is given when the round's global model
+	// cannot be downloaded
+	FLDeclineModelUnavailable FLDeclineReason = "model_unavailable"
+	// FLDeclineRootForbidden is given when the task asks to run as root
+	// and the runner's policy forbids it
+	FLDeclineRootForbidden FLDeclineReason = "root_forbidden"
+	// FLDeclinePaused is given while the operator has paused claiming
+	FLDeclinePaused FLDeclineReason = "paused"
+	// FLDeclineBelowPriceFloor is given when the task pays less than the
+	// operator's price for the resources it holds
+	FLDeclineBelowPriceFloor FLDeclineReason = "below_price_floor"
+	// FLDeclinePoisoned is given for a task the runner abandoned after it
+	// kept failing the same way
+	FLDeclinePoisoned FLDeclineReason = "poisoned"
+	// FLDeclineGroupCancelled is given for a shard of a group with shared
+	// fate once another of its shards failed
+	FLDeclineGroupCancelled FLDeclineReason = "group_cancelled"
+	// FLDeclineProfileForbidden is given when the task asks for a security
+	// profile the runner's policy does not permit
+	FLDeclineProfileForbidden FLDeclineReason = "profile_forbidden"
+	// FLDeclineDegraded is given when the task needs a capability the
+	// runner stopped advertising after its canary self-test kept failing
+	FLDeclineDegraded FLDeclineReason = "degraded"
+	// FLDeclineTypePaused is given when the runner paused the task's type
+	// after using up its error budget
+	FLDeclineTypePaused FLDeclineReason = "type_paused"
+	// FLDeclineHinted is given for a task another runner of the fleet
+	// hinted fails for every runner
+	FLDeclineHinted FLDeclineReason = "hinted"
+	// FLDeclineRevoked is given for a task an emergency revocation in
+	// force matches
+	FLDeclineRevoked FLDeclineReason = "revoked"
+	// FLDeclineUnknownMaterializer is given when the task names a
+	// materialization plugin the runner has not configured
+	FLDeclineUnknownMaterializer FLDeclineReason = "unknown_materializer"
+	// FLDeclineNotHermetic is given for a hermetic task the runner cannot
+	// run hermetically, with the requirements it cannot enforce
+	FLDeclineNotHermetic FLDeclineReason = "not_hermetic"
+)
+
+// SkipReason explains why the runner passed over a task it can run, for a
+// conflict with its own work that clears once that work finishes. A skip is
+// not a decline: it says nothing about whether the runner can run the task.
+type SkipReason string
+
+const (
+	// SkipTaskInProgress is given while the tasks running leave no room
+	// for another
+	SkipTaskInProgress SkipReason = "task_in_progress"
+	// SkipHostBusy is given when the task's memory or CPU only fits once
+	// the tasks holding them finish
+	SkipHostBusy SkipReason = "host_busy"
+	// SkipGPUBusy is given when the task's GPU memory only fits once the
+	// tasks holding it finish
+	SkipGPUBusy SkipReason = "gpu_busy"
+	// SkipDonationActive is given for a donated round while another
+	// donated round trains
+	SkipDonationActive SkipReason = "donation_active"
+)
+
+// FLRoundAck is the runner's response to a round assignment, letting the
+// coordinator replace declined runners instead of waiting for a timeout
+type FLRoundAck struct {
+	SessionID string          `json:"session_id"`
+	RoundID   string          `json:"round_id"`
+	RunnerID  string          `json:"runner_id"`
+	Accepted  bool            `json:"accepted"`
+	Reason    FLDeclineReason `json:"reason,omitempty"`
+	// Skipped is set instead of Reason when the runner is only busy
+	Skipped SkipReason `json:"skipped,omitempty"`
+	Detail  string     `json:"detail,omitempty"`
+}
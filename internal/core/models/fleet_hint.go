@@ -0,0 +1,11 @@
+package models
+
+// FleetHintStats counts a runner's fleet hints since it started: those it
+// published about tasks that fail for every runner, the claims it skipped
+// because of a fleet member's hint and the hints it ignored as signed by
+// keys outside the fleet
+type FleetHintStats struct {
+	Published uint64 `json:"published"`
+	Skipped   uint64 `json:"skipped"`
+	Forged    uint64 `json:"forged"`
+}
@@ -0,0 +1,31 @@
+package models
+
+// GlobalModelRef locates the global model a federated learning round trains
+// from, as the coordinator publishes it
+type GlobalModelRef struct {
+	// SHA256 is the hash of the model's canonical encoding, checked before
+	// the model is used however it was obtained
+	SHA256 string      `json:"sha256"`
+	Source InputSource `json:"source"`
+	Size   int64       `json:"size,omitempty"`
+	// Delta, when present, lets runners holding the previous round's model
+	// build this one from per-layer changes instead of downloading it
+	Delta *GlobalModelDelta `json:"delta,omitempty"`
+}
+
+// GlobalModelDelta describes a round's model as changes to the model of an
+// earlier round of the same session
+type GlobalModelDelta struct {
+	BaseRoundID string       `json:"base_round_id"`
+	BaseSHA256  string       `json:"base_sha256"`
+	Layers      []LayerDelta `json:"layers"`
+}
+
+// LayerDelta is the elementwise change to one layer's weights, published as
+// a JSON array of numbers. Layers without a delta are unchanged.
+type LayerDelta struct {
+	Layer  string      `json:"layer"`
+	Source InputSource `json:"source"`
+	SHA256 string      `json:"sha256,omitempty"`
+	Size   int64       `json:"size,omitempty"`
+}
@@ -0,0 +1,38 @@
+package models
+
+// GPUDevice is a GPU and the VRAM committed on it. A GPU split into MIG
+// partitions is only ever assigned a partition at a time.
+type GPUDevice struct {
+	Index         int            `json:"index"`
+	UUID          string         `json:"uuid"`
+	Name          string         `json:"name"`
+	TotalBytes    uint64         `json:"total_bytes"`
+	UsedBytes     uint64         `json:"used_bytes"`
+	ReservedBytes uint64         `json:"reserved_bytes,omitempty"`
+	Partitions    []GPUPartition `json:"partitions,omitempty"`
+}
+
+// GPUPartition is a MIG instance of a GPU
+type GPUPartition struct {
+	UUID        string `json:"uuid"`
+	Profile     string `json:"profile"`
+	MemoryBytes uint64 `json:"memory_bytes"`
+	TaskID      string `json:"task_id,omitempty"`
+}
+
+// GPUReservation is the VRAM a running task declared, on the GPU or MIG
+// partition it was assigned, and how much its processes were last seen using
+type GPUReservation struct {
+	TaskID        string `json:"task_id"`
+	Device        string `json:"device"`
+	Partition     bool   `json:"partition,omitempty"`
+	DeclaredBytes uint64 `json:"declared_bytes"`
+	ObservedBytes uint64 `json:"observed_bytes"`
+	Priority      int    `json:"priority,omitempty"`
+}
+
+// GPUCapacity is the runner's GPUs and the reservations held on them
+type GPUCapacity struct {
+	Devices      []GPUDevice      `json:"devices"`
+	Reservations []GPUReservation `json:"reservations,omitempty"`
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Hermetic requirements a runner enforces for a hermetic task, named in
+// its attestation and in why a runner refused one
+const (
+	// HermeticNoNetwork is a container without any network but loopback
+	HermeticNoNetwork = "no_network"
+	// HermeticPinnedImage is an image named by its digest
+	HermeticPinnedImage = "pinned_image"
+	// HermeticHashedInputs is every input named by its content hash
+	HermeticHashedInputs = "hashed_inputs"
+	// HermeticClosedEnv is an environment holding only what the task
+	// declares and the facts about the runner hermetic tasks may see
+	HermeticClosedEnv = "closed_env"
+	// HermeticEgressAudit is every attempt to reach the network counted
+	// while the task runs
+	HermeticEgressAudit = "egress_audit"
+)
+
+// HermeticAttestation records that a hermetic task's result was derived
+// from its input closure alone, with no network access
+type HermeticAttestation struct {
+	Closure HermeticClosure `json:"closure"`
+	// Digest is the SHA-256 of the closure, which attestation evidence
+	// for the result covers
+	Digest string `json:"digest"`
+	// Enforced lists the hermetic requirements the runner enforced
+	Enforced []string `json:"enforced"`
+	// Violations are the attempts the task made to leave its closure,
+	// which the runner blocked
+	Violations []HermeticViolation `json:"violations,omitempty"`
+}
+
+// HermeticClosure is everything a hermetic task ran from
+type HermeticClosure struct {
+	// ImageDigest is the ID of the image the container ran
+	ImageDigest string `json:"image_digest"`
+	// Image is the pinned reference the task named
+	Image  string          `json:"image"`
+	Inputs []ResolvedInput `json:"inputs,omitempty"`
+	// Entrypoint and Command are the task's, empty when it ran the
+	// image's
+	Entrypoint []string `json:"entrypoint,omitempty"`
+	Command    []string `json:"command,omitempty"`
+	// Env is the container's environment, sorted
+	Env []string `json:"env"`
+}
+
+// Digest returns the SHA-256 of the closure's JSON encoding
+func (c *HermeticClosure) Digest() string {
+	data, _ := json.Marshal(c)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// HermeticViolationEgress is a hermetic task trying to reach the network
+const HermeticViolationEgress = "egress"
+
+// HermeticViolation is an attempt a hermetic task made to leave its
+// closure, such as blocked egress
+type HermeticViolation struct {
+	Kind   string    `json:"kind"`
+	Detail string    `json:"detail"`
+	Time   time.Time `json:"time"`
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// ImageExportConfig asks the docker executor to return the task's image as an
+// artifact. Without a target the task container itself is committed once it
+// exits successfully.
+type ImageExportConfig struct {
+	// Target names a local image built by the task to export instead
+	Target string `json:"target,omitempty"`
+	// Tag is recorded as the reference name inside the exported archive
+	Tag string `json:"tag,omitempty"`
+	// MaxBytes and MaxLayers can only tighten the runner's own limits
+	MaxBytes  int64 `json:"max_bytes,omitempty"`
+	MaxLayers int   `json:"max_layers,omitempty"`
+}
+
+func (c *ImageExportConfig) Validate() error {
+	if c.MaxBytes < 0 {
+		return errors.New("max_bytes must not be negative")
+	}
+	if c.MaxLayers < 0 {
+		return errors.New("max_layers must not be negative")
+	}
+	if strings.ContainsAny(c.Target, " \t\n") || strings.ContainsAny(c.Tag, " \t\n") {
+		return errors.New("image references must not contain whitespace")
+	}
+	return nil
+}
+
+// ExportedImage summarises an image exported as an OCI archive. Layers
+// already uploaded by an earlier export are referenced by their existing CID.
+type ExportedImage struct {
+	Reference     string          `json:"reference,omitempty"`
+	Digest        string          `json:"digest"`
+	ConfigDigest  string          `json:"config_digest"`
+	SizeBytes     int64           `json:"size_bytes"`
+	UploadedBytes int64           `json:"uploaded_bytes"`
+	IndexCID      string          `json:"index_cid"`
+	Layers        []ExportedLayer `json:"layers"`
+}
+
+type ExportedLayer struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+	CID    string `json:"cid"`
+	Reused bool   `json:"reused,omitempty"`
+}
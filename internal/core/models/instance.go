@@ -0,0 +1,13 @@
+package models
+
+// InstanceStats counts the tasks of one of the logical runners a process
+// runs, since it started, and names the GPUs and cores pinned to it
+type InstanceStats struct {
+	Instance  string   `json:"instance"`
+	GPUs      []string `json:"gpus,omitempty"`
+	CPUs      []int    `json:"cpus,omitempty"`
+	Claimed   uint64   `json:"claimed"`
+	Completed uint64   `json:"completed"`
+	Failed    uint64   `json:"failed"`
+	Declined  uint64   `json:"declined"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// TaskLease is the server-granted exclusive claim on a running task.
+// A zero TTL means the server did not issue a lease and no renewal is needed.
+type TaskLease struct {
+	TaskID    string        `json:"task_id"`
+	LeaseID   string        `json:"lease_id,omitempty"`
+	TTL       time.Duration `json:"ttl"`
+	ExpiresAt time.Time     `json:"expires_at"`
+}
+
+func (l *TaskLease) Expired(now time.Time) bool {
+	return l == nil || (l.TTL > 0 && !now.Before(l.ExpiresAt))
+}
@@ -0,0 +1,24 @@
+package models
+
+// LLMModelStats reports how quickly a runner is currently serving one model
+// so the server can route prompts to the runner likely to answer first
+type LLMModelStats struct {
+	Model      string `json:"model"`
+	Loaded     bool   `json:"loaded"`
+	QueueDepth int    `json:"queue_depth"`
+	// Samples is the number of generations in the sliding window
+	Samples           int     `json:"samples"`
+	P50MsPer100Tokens float64 `json:"p50_ms_per_100_tokens,omitempty"`
+	P95MsPer100Tokens float64 `json:"p95_ms_per_100_tokens,omitempty"`
+	// ExpectedTTFTMs is omitted until the model has served a prompt
+	ExpectedTTFTMs int64 `json:"expected_ttft_ms,omitempty"`
+}
+
+// ClaimHint accompanies a task claim so the server can reroute the task when
+// this runner expects to be too slow
+type ClaimHint struct {
+	Model string `json:"model,omitempty"`
+	// ExpectedTTFTMs is omitted when the runner has no recent samples
+	ExpectedTTFTMs int64 `json:"expected_ttft_ms,omitempty"`
+	QueueDepth     int   `json:"queue_depth"`
+}
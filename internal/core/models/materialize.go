@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// MaterializationMetadataKey names the delivery outcomes of a task's
+// materialization plugins among its result's metadata
+const MaterializationMetadataKey = "materialization"
+
+// MaterializationOutcome is how delivering a task's result through one
+// materialization plugin went. Attempts counts the deliveries tried, and
+// Error is the last one's failure when none succeeded.
+type MaterializationOutcome struct {
+	Plugin    string    `json:"plugin"`
+	Delivered bool      `json:"delivered"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+// ResultMetadataPatch adds fields to the metadata of a saved result,
+// replacing those of the same names
+type ResultMetadataPatch struct {
+	Metadata map[string]interface{} `json:"metadata"`
+}
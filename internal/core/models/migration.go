@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// TaskMigration points a task reassigned after a planned shutdown at the
+// bundle the runner that gave it up left behind, from which the runner
+// claiming it resumes instead of starting over
+type TaskMigration struct {
+	// Bundle is where the bundle was uploaded, as a CID or s3:// URI
+	Bundle string `json:"bundle"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// EpochsCompleted is how many epochs of a federated learning round the
+	// bundle holds the weights of
+	EpochsCompleted int       `json:"epochs_completed,omitempty"`
+	CreatedAt       time.Time `json:"created_at"`
+}
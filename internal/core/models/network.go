@@ -0,0 +1,128 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+const (
+	// MaxDNSServers is how many nameservers a resolver reads from
+	// resolv.conf; further servers would be silently ignored
+	MaxDNSServers = 3
+	// MaxDNSSearch bounds the search domains a task may set
+	MaxDNSSearch = 6
+)
+
+var hostnamePattern = regexp.MustCompile(`^(?i)[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?(\.[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?)*$`)
+
+// NetworkConfig overrides name resolution inside a task's container, for
+// tasks that need internal hostnames or a hostname pinned to an address
+type NetworkConfig struct {
+	// ExtraHosts pin hostnames to addresses, each as "host:ip"
+	ExtraHosts []string `json:"extra_hosts,omitempty"`
+	// DNS lists the nameservers used instead of the runner's
+	DNS []string `json:"dns,omitempty"`
+	// DNSSearch lists the domains unqualified names are looked up in
+	DNSSearch []string `json:"dns_search,omitempty"`
+	// IPv6 gives the container a network of its own with an IPv6 subnet,
+	// reaching IPv6 destinations through NAT66, alongside IPv4
+	IPv6 bool `json:"ipv6,omitempty"`
+}
+
+// HostEntry is one hostname pinned to an address
+type HostEntry struct {
+	Host string
+	IP   net.IP
+}
+
+// String formats the entry as it is given in extra_hosts
+func (h HostEntry) String() string {
+	return h.Host + ":" + h.IP.String()
+}
+
+// Hosts parses ExtraHosts
+func (c *NetworkConfig) Hosts() ([]HostEntry, error) {
+	hosts := make([]HostEntry, 0, len(c.ExtraHosts))
+	for _, entry := range c.ExtraHosts {
+		host, addr, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("extra_hosts entry %q must be host:ip", entry)
+		}
+		if !hostnamePattern.MatchString(host) || len(host) > 253 {
+			return nil, fmt.Errorf("extra_hosts entry %q: %q is not a hostname", entry, host)
+		}
+		ip := net.ParseIP(strings.Trim(addr, "[]"))
+		if ip == nil {
+			return nil, fmt.Errorf("extra_hosts entry %q: %q is not an IP address", entry, addr)
+		}
+		hosts = append(hosts, HostEntry{Host: strings.ToLower(host), IP: ip})
+	}
+	return hosts, nil
+}
+
+// DNSServers parses DNS. IPv6 servers may be given in brackets.
+func (c *NetworkConfig) DNSServers() ([]net.IP, error) {
+	servers := make([]net.IP, 0, len(c.DNS))
+	for _, server := range c.DNS {
+		ip := net.ParseIP(strings.TrimSuffix(strings.TrimPrefix(server, "["), "]"))
+		if ip == nil {
+			return nil, fmt.Errorf("dns server %q is not an IP address", server)
+		}
+		servers = append(servers, ip)
+	}
+	return servers, nil
+}
+
+// Validate checks the overrides and that they do not contradict each other
+func (c *NetworkConfig) Validate() error {
+	hosts, err := c.Hosts()
+	if err != nil {
+		return err
+	}
+	pinned := make(map[string]HostEntry, len(hosts))
+	for _, h := range hosts {
+		if h.Host == "localhost" {
+			return fmt.Errorf("extra_hosts entry %q: localhost cannot be pinned", h)
+		}
+		if prev, ok := pinned[h.Host]; ok {
+			if prev.IP.Equal(h.IP) {
+				return fmt.Errorf("extra_hosts pins %s to %s twice", h.Host, h.IP)
+			}
+			return fmt.Errorf("extra_hosts pins %s to both %s and %s", h.Host, prev.IP, h.IP)
+		}
+		pinned[h.Host] = h
+	}
+
+	servers, err := c.DNSServers()
+	if err != nil {
+		return err
+	}
+	if len(servers) > MaxDNSServers {
+		return fmt.Errorf("dns lists %d servers, at most %d are used", len(servers), MaxDNSServers)
+	}
+	for i, server := range servers {
+		for _, prev := range servers[:i] {
+			if prev.Equal(server) {
+				return fmt.Errorf("dns lists %s twice", server)
+			}
+		}
+	}
+
+	if len(c.DNSSearch) > MaxDNSSearch {
+		return fmt.Errorf("dns_search lists %d domains, at most %d are allowed", len(c.DNSSearch), MaxDNSSearch)
+	}
+	seen := make(map[string]bool, len(c.DNSSearch))
+	for _, domain := range c.DNSSearch {
+		name := strings.ToLower(strings.TrimSuffix(domain, "."))
+		if !hostnamePattern.MatchString(name) || len(name) > 253 {
+			return fmt.Errorf("dns_search domain %q is not a domain name", domain)
+		}
+		if seen[name] {
+			return fmt.Errorf("dns_search lists %s twice", name)
+		}
+		seen[name] = true
+	}
+	return nil
+}
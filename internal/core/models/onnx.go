@@ -0,0 +1,28 @@
+package models
+
+import "encoding/json"
+
+// ONNXSummary describes the outputs of an ONNX inference task
+type ONNXSummary struct {
+	ModelSHA256 string       `json:"model_sha256"`
+	Outputs     []ONNXTensor `json:"outputs"`
+	// Threads is the number of threads each operator ran on
+	Threads    int   `json:"threads"`
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// ONNXTensor is an output tensor of an ONNX task. Small outputs are returned
+// in the result as JSON; larger ones are written to a binary artifact.
+type ONNXTensor struct {
+	Name  string  `json:"name"`
+	DType string  `json:"dtype"`
+	Shape []int64 `json:"shape"`
+	// Data holds the elements in row-major order as a flat JSON array
+	Data json.RawMessage `json:"data,omitempty"`
+	// Artifact is the file holding the elements as raw little-endian values
+	// instead, with its hash
+	Artifact string `json:"artifact,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	// Size is the size of the elements in bytes
+	Size int64 `json:"size"`
+}
@@ -0,0 +1,123 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+type (
+	ManifestMode   string
+	ManifestGating string
+	OutputStatus   string
+)
+
+const (
+	// ManifestModeLenient ignores files that are not described by the manifest
+	ManifestModeLenient ManifestMode = "lenient"
+	// ManifestModeStrict flags any produced file not matched by a manifest entry
+	ManifestModeStrict ManifestMode = "strict"
+)
+
+const (
+	// ManifestGatingEnforce fails the task when the verdict does not pass
+	ManifestGatingEnforce ManifestGating = "enforce"
+	// ManifestGatingReport only records the verdict in the result
+	ManifestGatingReport ManifestGating = "report"
+)
+
+const (
+	OutputStatusOK             OutputStatus = "ok"
+	OutputStatusMissing        OutputStatus = "missing"
+	OutputStatusHashMismatch   OutputStatus = "hash_mismatch"
+	OutputStatusSizeOutOfRange OutputStatus = "size_out_of_range"
+	OutputStatusUnexpected     OutputStatus = "unexpected"
+)
+
+var sha256Pattern = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// OutputManifest declares the files a task is expected to produce
+type OutputManifest struct {
+	Mode    ManifestMode    `json:"mode,omitempty"`
+	Gating  ManifestGating  `json:"gating,omitempty"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// ManifestEntry describes one expected output. Path may be a glob pattern
+// ("*", "?", "[...]" within a segment and "**" across segments). An entry
+// without a hash or size bounds only asserts that a matching file exists.
+type ManifestEntry struct {
+	Path    string `json:"path"`
+	SHA256  string `json:"sha256,omitempty"`
+	MinSize *int64 `json:"min_size,omitempty"`
+	MaxSize *int64 `json:"max_size,omitempty"`
+}
+
+func (m *OutputManifest) Validate() error {
+	if len(m.Entries) == 0 {
+		return errors.New("output manifest must contain at least one entry")
+	}
+
+	switch m.Mode {
+	case "", ManifestModeLenient, ManifestModeStrict:
+	default:
+		return fmt.Errorf("unsupported manifest mode: %s", m.Mode)
+	}
+
+	switch m.Gating {
+	case "", ManifestGatingEnforce, ManifestGatingReport:
+	default:
+		return fmt.Errorf("unsupported manifest gating: %s", m.Gating)
+	}
+
+	for i, entry := range m.Entries {
+		if entry.Path == "" {
+			return fmt.Errorf("manifest entry %d: path is required", i)
+		}
+		if entry.SHA256 != "" && !sha256Pattern.MatchString(entry.SHA256) {
+			return fmt.Errorf("manifest entry %d: sha256 must be 64 lowercase hex characters", i)
+		}
+		if entry.MinSize != nil && entry.MaxSize != nil && *entry.MinSize > *entry.MaxSize {
+			return fmt.Errorf("manifest entry %d: min_size exceeds max_size", i)
+		}
+	}
+
+	return nil
+}
+
+// EffectiveMode returns the manifest mode, defaulting to lenient
+func (m *OutputManifest) EffectiveMode() ManifestMode {
+	if m.Mode == "" {
+		return ManifestModeLenient
+	}
+	return m.Mode
+}
+
+// EffectiveGating returns the gating mode, defaulting to enforce
+func (m *OutputManifest) EffectiveGating() ManifestGating {
+	if m.Gating == "" {
+		return ManifestGatingEnforce
+	}
+	return m.Gating
+}
+
+// OutputVerdict is the outcome of checking produced files against an OutputManifest
+type OutputVerdict struct {
+	Passed     bool                 `json:"passed"`
+	Mode       ManifestMode         `json:"mode"`
+	Gating     ManifestGating       `json:"gating"`
+	Entries    []OutputEntryVerdict `json:"entries"`
+	ExtraFiles []string             `json:"extra_files,omitempty"`
+}
+
+type OutputEntryVerdict struct {
+	Path    string       `json:"path"`
+	Status  OutputStatus `json:"status"`
+	Matched []string     `json:"matched,omitempty"`
+	Detail  string       `json:"detail,omitempty"`
+}
+
+// FailsTask reports whether the verdict should turn the task into a failure
+func (v *OutputVerdict) FailsTask() bool {
+	return v != nil && !v.Passed && v.Gating == ManifestGatingEnforce
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// ReleaseReasonPoisoned is given when a runner abandons a task that keeps
+// failing the same way, for the server to quarantine it fleet-wide
+const ReleaseReasonPoisoned = "poisoned"
+
+// PoisonAttempt is one failed execution of a task
+type PoisonAttempt struct {
+	// Fingerprint identifies the failure apart from host-specific noise
+	// such as paths, timestamps and IDs
+	Fingerprint string `json:"fingerprint"`
+	Runner      string `json:"runner,omitempty"`
+	// ExitCode is the task's exit code, -1 when it failed without exiting
+	ExitCode int `json:"exit_code"`
+	// Summary is the failure's last line, normalized like its fingerprint
+	Summary  string    `json:"summary,omitempty"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// PoisonReport explains why a task was abandoned as poisoned: Fingerprint
+// is the failure it kept repeating, and Attempts every failed execution
+// recorded for it, oldest first
+type PoisonReport struct {
+	Fingerprint string          `json:"fingerprint"`
+	Attempts    []PoisonAttempt `json:"attempts"`
+}
@@ -0,0 +1,69 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/theblitlabs/parity-runner/internal/expr"
+)
+
+const (
+	// MaxPostProcessFields bounds the fields a task may derive
+	MaxPostProcessFields = 16
+	// PostProcessVerdictKey names the verdict among PostProcessReport.Errors
+	PostProcessVerdictKey = "verdict"
+)
+
+var postProcessFieldName = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,63}$`)
+
+// PostProcessConfig derives fields from a task's result with expressions
+// the runner evaluates over it, saving creators a second task for a small
+// transformation of its output. Expressions see exit_code, output, error,
+// json (output parsed as JSON, null when it is not) and usage (the result's
+// resource usage); the verdict also sees the derived fields as fields.
+type PostProcessConfig struct {
+	// Fields maps the names added to the result's metadata to the
+	// expressions computing them
+	Fields map[string]string `json:"fields,omitempty"`
+	// Verdict is a boolean expression deciding whether the task passed,
+	// in place of its exit code
+	Verdict string `json:"verdict,omitempty"`
+}
+
+// Validate checks the field names and that every expression compiles
+func (c *PostProcessConfig) Validate() error {
+	if len(c.Fields) == 0 && c.Verdict == "" {
+		return errors.New("no fields or verdict to post-process")
+	}
+	if len(c.Fields) > MaxPostProcessFields {
+		return fmt.Errorf("%d fields, more than the %d allowed", len(c.Fields), MaxPostProcessFields)
+	}
+	for name, src := range c.Fields {
+		if !postProcessFieldName.MatchString(name) {
+			return fmt.Errorf("invalid field name %q", name)
+		}
+		if _, err := expr.Compile(src); err != nil {
+			return fmt.Errorf("field %s: %w", name, err)
+		}
+	}
+	if c.Verdict != "" {
+		if _, err := expr.Compile(c.Verdict); err != nil {
+			return fmt.Errorf("verdict: %w", err)
+		}
+	}
+	return nil
+}
+
+// PostProcessReport is how a task's post-processing went. The fields it
+// derived are in the result's metadata.
+type PostProcessReport struct {
+	// Verdict is what the verdict expression decided, nil without one or
+	// when it failed
+	Verdict *bool `json:"verdict,omitempty"`
+	// Errors maps the fields, and the verdict, whose expressions failed to
+	// why they did
+	Errors map[string]string `json:"errors,omitempty"`
+	// Cost is the evaluation cost spent, out of the runner's limit
+	Cost int `json:"cost"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// PowerMode is how much work the runner takes on given its power source and
+// thermal condition
+type PowerMode string
+
+const (
+	// PowerModeNormal claims any task
+	PowerModeNormal PowerMode = "normal"
+	// PowerModeRestricted claims only short, light tasks, as when on a low battery
+	PowerModeRestricted PowerMode = "restricted"
+	// PowerModePaused claims nothing, as when thermally throttled
+	PowerModePaused PowerMode = "paused"
+)
+
+// PowerState is the power source and thermal condition of the runner host
+// and the scheduling mode they put the runner in
+type PowerState struct {
+	HasBattery      bool      `json:"has_battery"`
+	OnBattery       bool      `json:"on_battery"`
+	BatteryPercent  float64   `json:"battery_percent,omitempty"`
+	CPUTempC        float64   `json:"cpu_temp_c,omitempty"`
+	GPUTempC        float64   `json:"gpu_temp_c,omitempty"`
+	Throttled       bool      `json:"throttled"`
+	ThrottleReasons []string  `json:"throttle_reasons,omitempty"`
+	Mode            PowerMode `json:"mode"`
+	Reason          string    `json:"reason,omitempty"`
+	SampledAt       time.Time `json:"sampled_at"`
+}
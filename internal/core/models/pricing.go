@@ -0,0 +1,20 @@
+package models
+
+// PricingRates are what an operator charges per hour for each resource
+// class a task holds. A zero rate leaves that class free.
+type PricingRates struct {
+	CPUHour   float64 `json:"cpu_hour"`
+	GPUHour   float64 `json:"gpu_hour"`
+	GBRAMHour float64 `json:"gb_ram_hour"`
+}
+
+// PricingStatus is the price floor the runner applies and what it has
+// skipped for paying below it since it started
+type PricingStatus struct {
+	Rates         PricingRates `json:"rates"`
+	Skipped       uint64       `json:"skipped"`
+	SkippedReward float64      `json:"skipped_reward"`
+	// SkippedFloor is the sum of the skipped tasks' floors; the gap to
+	// SkippedReward is how far below the floors they paid
+	SkippedFloor float64 `json:"skipped_floor"`
+}
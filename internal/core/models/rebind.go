@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// RunnerRebind moves a runner's identity to another machine once its state
+// was imported there. The server binds DeviceID to ToMachine and refuses
+// the copy of the identity left on FromMachine from then on.
+type RunnerRebind struct {
+	DeviceID string `json:"device_id"`
+	// FromMachine and ToMachine identify the machine the identity was
+	// exported from and the one it was imported onto
+	FromMachine string `json:"from_machine"`
+	ToMachine   string `json:"to_machine"`
+	// ArchiveID identifies the export, so that one archive cannot be
+	// imported onto two machines
+	ArchiveID  string    `json:"archive_id"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// RunnerRebindAck is the server's answer to a RunnerRebind
+type RunnerRebindAck struct {
+	Accepted bool `json:"accepted"`
+	// Reason says why a rebind was refused
+	Reason string `json:"reason,omitempty"`
+	// Generation counts the machines the identity was bound to
+	Generation int64 `json:"generation,omitempty"`
+}
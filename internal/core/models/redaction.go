@@ -0,0 +1,11 @@
+package models
+
+// RedactionReport counts what the runner redacted from a task's result,
+// progress messages and text artifacts before they left it
+type RedactionReport struct {
+	// Counts is how many matches of each detector or rule were redacted
+	Counts map[string]int `json:"counts"`
+	// Unredacted names the binary artifacts that left the runner as they
+	// were
+	Unredacted []string `json:"unredacted,omitempty"`
+}
@@ -0,0 +1,87 @@
+package models
+
+import "time"
+
+// Reputation factors, each scored from 0 to 1
+const (
+	// ReputationCompletion is the share of executions that completed
+	ReputationCompletion = "completion"
+	// ReputationReliability is one less the share of executions the runner
+	// abandoned, weighted by why it did
+	ReputationReliability = "reliability"
+	// ReputationLatency is how close completed executions came to the
+	// duration expected of their workload
+	ReputationLatency = "latency"
+	// ReputationVerification is the share of verified results that passed
+	ReputationVerification = "verification"
+)
+
+// ReputationReport is the reputation score the runner computed from its own
+// history with the server's formula, out of 100, and what each factor
+// contributed to it
+type ReputationReport struct {
+	Score          float64 `json:"score"`
+	FormulaVersion int     `json:"formula_version"`
+	// Executions counts the executions within the formula's window
+	Executions int                `json:"executions"`
+	Factors    []ReputationFactor `json:"factors"`
+	ComputedAt time.Time          `json:"computed_at"`
+	// Server is the score the server last reported, set once it was asked
+	Server *ServerReputation `json:"server,omitempty"`
+}
+
+// ReputationFactor is one factor of a reputation score. Contribution is
+// the points it adds to the score, Weight times Value times 100, and Lost
+// the points it falls short of its weight by.
+type ReputationFactor struct {
+	Name         string  `json:"name"`
+	Value        float64 `json:"value"`
+	Weight       float64 `json:"weight"`
+	Contribution float64 `json:"contribution"`
+	Lost         float64 `json:"lost"`
+	// Samples counts the executions the factor was scored from
+	Samples int `json:"samples"`
+}
+
+// ServerReputation is the score the server reported for the runner and how
+// far the runner's own score is from it
+type ServerReputation struct {
+	Score          float64   `json:"score"`
+	FormulaVersion int       `json:"formula_version,omitempty"`
+	FetchedAt      time.Time `json:"fetched_at"`
+	// Divergence is the runner's score less the server's
+	Divergence float64 `json:"divergence"`
+	// Diverged is set when the scores differ by more than the runner
+	// tolerates, or were computed with different formulas
+	Diverged bool `json:"diverged"`
+	// Error is why the server's score could not be fetched last time; the
+	// fields above are from the last time it could
+	Error string `json:"error,omitempty"`
+}
+
+// RunnerInfo is what the server holds of a runner
+type RunnerInfo struct {
+	DeviceID string `json:"device_id"`
+	// ReputationScore is nil while the server has not scored the runner
+	ReputationScore          *float64  `json:"reputation_score,omitempty"`
+	ReputationFormulaVersion int       `json:"reputation_formula_version,omitempty"`
+	ReputationUpdatedAt      time.Time `json:"reputation_updated_at,omitempty"`
+}
+
+// ReputationWhatIf is what would become of the runner's score for each way
+// a task it runs could end
+type ReputationWhatIf struct {
+	TaskID    string               `json:"task_id"`
+	Current   float64              `json:"current"`
+	Scenarios []ReputationScenario `json:"scenarios"`
+}
+
+// ReputationScenario is the score the runner would have if the scenario
+// came about, and Delta its change from the current score
+type ReputationScenario struct {
+	Name        string             `json:"name"`
+	Description string             `json:"description"`
+	Score       float64            `json:"score"`
+	Delta       float64            `json:"delta"`
+	Factors     []ReputationFactor `json:"factors"`
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ResponseFormatType is the kind of constraint an LLM task puts on its
+// response
+type ResponseFormatType string
+
+const (
+	// ResponseFormatJSON asks for a JSON response, matching Schema when set
+	ResponseFormatJSON ResponseFormatType = "json"
+	// ResponseFormatRegex asks for a response Pattern matches in full
+	ResponseFormatRegex ResponseFormatType = "regex"
+	// ResponseFormatGrammar asks for a response Grammar, in GBNF, derives
+	// from its root rule
+	ResponseFormatGrammar ResponseFormatType = "grammar"
+)
+
+const (
+	// DefaultFormatRetries is how many times a response that misses its
+	// format is generated again when the task does not say
+	DefaultFormatRetries = 2
+	// MaxFormatRetries bounds the retries a task may ask for
+	MaxFormatRetries = 5
+)
+
+// ResponseFormat constrains the response of an LLM task. Backends that can
+// enforce it do so while generating; otherwise the runner validates each
+// response and generates again, up to MaxRetries times.
+type ResponseFormat struct {
+	Type ResponseFormatType `json:"type"`
+	// Schema is an optional JSON Schema a json response must match
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// Pattern is a regular expression, in RE2 syntax, a regex response
+	// must match in full
+	Pattern string `json:"pattern,omitempty"`
+	// Grammar is a GBNF grammar a grammar response must derive from
+	Grammar string `json:"grammar,omitempty"`
+	// MaxRetries defaults to DefaultFormatRetries when unset
+	MaxRetries *int `json:"max_retries,omitempty"`
+}
+
+// Validate checks the format names a known type with what that type needs
+func (f *ResponseFormat) Validate() error {
+	switch f.Type {
+	case ResponseFormatJSON:
+		if len(f.Schema) > 0 && !json.Valid(f.Schema) {
+			return fmt.Errorf("response_format schema is not valid JSON")
+		}
+	case ResponseFormatRegex:
+		if f.Pattern == "" {
+			return fmt.Errorf("response_format pattern is required for regex")
+		}
+	case ResponseFormatGrammar:
+		if f.Grammar == "" {
+			return fmt.Errorf("response_format grammar is required for grammar")
+		}
+	default:
+		return fmt.Errorf("unknown response_format type %q", f.Type)
+	}
+	if f.MaxRetries != nil && (*f.MaxRetries < 0 || *f.MaxRetries > MaxFormatRetries) {
+		return fmt.Errorf("response_format max_retries must be between 0 and %d", MaxFormatRetries)
+	}
+	return nil
+}
+
+// Retries returns how many times a response missing the format is
+// generated again
+func (f *ResponseFormat) Retries() int {
+	if f.MaxRetries == nil {
+		return DefaultFormatRetries
+	}
+	return *f.MaxRetries
+}
+
+// ResponseFormatEnforcement says how a response came to meet its format
+type ResponseFormatEnforcement string
+
+const (
+	// FormatMetNatively means the backend enforced the format and its first
+	// response met it
+	FormatMetNatively ResponseFormatEnforcement = "native"
+	// FormatMetByRetry means the runner validated the responses itself and
+	// one of them met the format
+	FormatMetByRetry ResponseFormatEnforcement = "retry"
+	// FormatUnmet means no response met the format within the retries; the
+	// task fails rather than submit one
+	FormatUnmet ResponseFormatEnforcement = "unmet"
+)
+
+// ResponseFormatReport tells the server how an LLM task's response format
+// was enforced
+type ResponseFormatReport struct {
+	Type        ResponseFormatType        `json:"type"`
+	Enforcement ResponseFormatEnforcement `json:"enforcement"`
+	// Attempts counts the responses generated
+	Attempts int `json:"attempts"`
+	// Violations describes how the last response missed the format, when
+	// it did
+	Violations []string `json:"violations,omitempty"`
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionNone asks the runner to keep nothing of a task locally once its
+// result upload is confirmed
+const RetentionNone = "none"
+
+// ParseRetention parses a task's retention directive: "none", or a number
+// of hours or days such as "12h" or "7d". set is false for an empty
+// directive, which leaves retention to the runner's own policy.
+func ParseRetention(directive string) (keep time.Duration, set bool, err error) {
+	directive = strings.TrimSpace(directive)
+	switch {
+	case directive == "":
+		return 0, false, nil
+	case directive == RetentionNone:
+		return 0, true, nil
+	case len(directive) < 2:
+		return 0, false, fmt.Errorf("invalid retention %q", directive)
+	}
+
+	unit := time.Hour
+	switch directive[len(directive)-1] {
+	case 'h':
+	case 'd':
+		unit = 24 * time.Hour
+	default:
+		return 0, false, fmt.Errorf("invalid retention %q: want none, hours such as 12h or days such as 7d", directive)
+	}
+	n, err := strconv.Atoi(directive[:len(directive)-1])
+	if err != nil || n <= 0 {
+		return 0, false, fmt.Errorf("invalid retention %q: want a positive number of hours or days", directive)
+	}
+	return time.Duration(n) * unit, true, nil
+}
+
+// TaskDataDeletion asks the runner to delete what it keeps locally of a
+// task. Signature is the task creator's hex-encoded secp256k1 signature over
+// the Keccak-256 hash of Message.
+type TaskDataDeletion struct {
+	TaskID    string `json:"task_id"`
+	Signature string `json:"signature"`
+}
+
+// Message returns what the task's creator signs to ask for the deletion
+func (d *TaskDataDeletion) Message() []byte {
+	return []byte("parity-runner:delete-task-data:" + d.TaskID)
+}
+
+// TaskDataDeletionStatus is the outcome of a TaskDataDeletion
+type TaskDataDeletionStatus string
+
+const (
+	// TaskDataDeleted means nothing of the task is left on the runner
+	TaskDataDeleted TaskDataDeletionStatus = "deleted"
+	// TaskDataDeletionPending means the deletion is under way: the result
+	// awaits upload confirmation, or deleting failed and is retried. A
+	// deleted acknowledgment follows.
+	TaskDataDeletionPending TaskDataDeletionStatus = "pending"
+	// TaskDataAbsent means the runner keeps nothing of the task
+	TaskDataAbsent TaskDataDeletionStatus = "absent"
+	// TaskDataDeletionRejected means the request was not signed by the
+	// task's creator
+	TaskDataDeletionRejected TaskDataDeletionStatus = "rejected"
+	// TaskDataDeletionFailed means the runner could not take the request
+	// on; the server may send it again
+	TaskDataDeletionFailed TaskDataDeletionStatus = "failed"
+)
+
+// TaskDataDeletionAck reports, in the next heartbeat, what became of a
+// TaskDataDeletion
+type TaskDataDeletionAck struct {
+	TaskID string                 `json:"task_id"`
+	Status TaskDataDeletionStatus `json:"status"`
+	Error  string                 `json:"error,omitempty"`
+	At     time.Time              `json:"at"`
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// RevocationFeed is the network's emergency revocation feed, which runners
+// poll apart from the server's task API. Signature is the base64 Ed25519
+// signature of the exact Payload bytes, which decode to a
+// RevocationFeedPayload.
+type RevocationFeed struct {
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature"`
+}
+
+// RevocationFeedPayload lists the revocations in force. Sequences increase
+// with every feed the network issues.
+type RevocationFeedPayload struct {
+	Sequence    int64        `json:"sequence"`
+	IssuedAt    time.Time    `json:"issued_at"`
+	Revocations []Revocation `json:"revocations"`
+}
+
+// Revocation kills the tasks it matches and blocks them from being claimed
+// until ExpiresAt. It matches a task by ID, by the hex SHA-256 of its
+// config or by its creator, whichever are set; all that are set must match.
+type Revocation struct {
+	ID             string    `json:"id"`
+	TaskID         string    `json:"task_id,omitempty"`
+	ConfigHash     string    `json:"config_hash,omitempty"`
+	CreatorAddress string    `json:"creator_address,omitempty"`
+	Reason         string    `json:"reason,omitempty"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+// Actions the revocation audit log records
+const (
+	// RevocationReceived records a revocation taking effect
+	RevocationReceived = "received"
+	// RevocationTerminated records a running task killed by a revocation
+	RevocationTerminated = "terminated"
+	// RevocationPurged records the cached inputs of a killed task removed
+	RevocationPurged = "purged"
+	// RevocationBlocked records a claim a revocation declined
+	RevocationBlocked = "blocked"
+	// RevocationRejected records a feed that failed verification
+	RevocationRejected = "rejected"
+)
+
+// RevocationAuditEntry is one action the runner took on a revocation
+type RevocationAuditEntry struct {
+	Time         time.Time `json:"time"`
+	Action       string    `json:"action"`
+	RevocationID string    `json:"revocation_id,omitempty"`
+	TaskID       string    `json:"task_id,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+}
@@ -0,0 +1,12 @@
+package models
+
+// AppliedSecurityProfile records the security profile a task ran under,
+// for audit
+type AppliedSecurityProfile struct {
+	Name string `json:"name"`
+	// Seccomp is set when the profile's seccomp filter confined the task
+	Seccomp bool `json:"seccomp"`
+	// AppArmor is set when its AppArmor profile did too, which needs a host
+	// that enables AppArmor and a runner allowed to load profiles
+	AppArmor bool `json:"apparmor,omitempty"`
+}
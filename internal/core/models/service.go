@@ -0,0 +1,91 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ServicePort publishes a port of a service task's container on the host
+type ServicePort struct {
+	ContainerPort int `json:"container_port"`
+	// HostPort is the host port the container port is published on; the
+	// runner's network policy must allow it
+	HostPort int `json:"host_port"`
+	// Protocol is tcp, the default, or udp
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// Validate checks that the ports are in range and the protocol is known
+func (p ServicePort) Validate() error {
+	if p.ContainerPort < 1 || p.ContainerPort > 65535 {
+		return fmt.Errorf("container port %d out of range", p.ContainerPort)
+	}
+	if p.HostPort < 1 || p.HostPort > 65535 {
+		return fmt.Errorf("host port %d out of range", p.HostPort)
+	}
+	switch p.Protocol {
+	case "", "tcp", "udp":
+	default:
+		return errors.New("protocol must be tcp or udp")
+	}
+	return nil
+}
+
+// String is the docker publish flag value of the port
+func (p ServicePort) String() string {
+	protocol := p.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	return fmt.Sprintf("%d:%d/%s", p.HostPort, p.ContainerPort, protocol)
+}
+
+// ServiceStop is why a service task stopped
+type ServiceStop string
+
+const (
+	// ServiceStopDuration is a service that ran for its target duration
+	ServiceStopDuration ServiceStop = "duration"
+	// ServiceStopCancelled is a service stopped early by the server
+	ServiceStopCancelled ServiceStop = "cancelled"
+	// ServiceStopCrashed is a service that kept exiting until its restarts
+	// were used up
+	ServiceStopCrashed ServiceStop = "crashed"
+	// ServiceStopNotReady is a service that did not become ready within
+	// its startup timeout
+	ServiceStopNotReady ServiceStop = "not_ready"
+)
+
+// ServiceHealth is the state of a running service task, reported as it
+// becomes ready or not and every health interval
+type ServiceHealth struct {
+	Ready bool `json:"ready"`
+	// UptimeSeconds is how long the service has been ready, over all of
+	// its runs
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	// ElapsedSeconds is how long the service has been running, including
+	// its startup and restarts
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	Restarts       int     `json:"restarts"`
+}
+
+// ServiceReport is the uptime a service task delivered, from which its
+// reward is reckoned
+type ServiceReport struct {
+	TargetSeconds  float64 `json:"target_seconds"`
+	ElapsedSeconds float64 `json:"elapsed_seconds"`
+	UptimeSeconds  float64 `json:"uptime_seconds"`
+	// UptimeRatio is UptimeSeconds over ElapsedSeconds
+	UptimeRatio float64 `json:"uptime_ratio"`
+	// TimeToReadySeconds is how long the service took to first become
+	// ready, zero when it never did
+	TimeToReadySeconds float64 `json:"time_to_ready_seconds,omitempty"`
+	// Transitions counts the times the service became ready or stopped
+	// being ready
+	Transitions int `json:"transitions"`
+	Restarts    int `json:"restarts"`
+	// ExitCodes are the codes the service exited with before its target
+	// duration, in order
+	ExitCodes []int       `json:"exit_codes,omitempty"`
+	StoppedBy ServiceStop `json:"stopped_by"`
+}
@@ -0,0 +1,37 @@
+package models
+
+// StallEvidence shows why a task was found stalled: the pressure stall
+// information of its cgroup and a snapshot of its processes taken then
+type StallEvidence struct {
+	// Resource is the resource the task stalled on: memory, io or cpu
+	Resource string `json:"resource"`
+	// Pressure is the share of the last ten seconds, in percent, all of
+	// the task's processes were stalled on each resource watched
+	Pressure       map[string]float64 `json:"pressure"`
+	Threshold      float64            `json:"threshold"`
+	StalledSeconds float64            `json:"stalled_seconds"`
+	Processes      []StalledProcess   `json:"processes,omitempty"`
+	// TopIO lists the processes that did the most IO, busiest first
+	TopIO []ProcessIO `json:"top_io,omitempty"`
+}
+
+// StalledProcess is where a process of a stalled task was waiting
+type StalledProcess struct {
+	PID     int    `json:"pid"`
+	Command string `json:"command"`
+	State   string `json:"state"`
+	// WaitChannel is the kernel function the process sleeps in, and Stack
+	// its kernel stack when the runner may read it
+	WaitChannel string   `json:"wait_channel,omitempty"`
+	Stack       []string `json:"stack,omitempty"`
+}
+
+// ProcessIO is the IO a process of a stalled task did, and the files it
+// had open
+type ProcessIO struct {
+	PID        int      `json:"pid"`
+	Command    string   `json:"command"`
+	ReadBytes  uint64   `json:"read_bytes"`
+	WriteBytes uint64   `json:"write_bytes"`
+	Files      []string `json:"files,omitempty"`
+}
@@ -0,0 +1,142 @@
+package models
+
+import "time"
+
+// LocalStatus is what a runner reports on its local status API: the tasks
+// it is running and has recently finished, what it declined and why, and
+// the state of its caches, disk and connection to the server
+type LocalStatus struct {
+	Instance string    `json:"instance,omitempty"`
+	DeviceID string    `json:"device_id"`
+	Time     time.Time `json:"time"`
+	// Paused runners claim no new tasks until resumed; draining runners
+	// also report not ready, so they can be stopped once their tasks end
+	Paused   bool   `json:"paused"`
+	Draining bool   `json:"draining"`
+	LogLevel string `json:"log_level"`
+
+	Tasks      []RunningTask     `json:"tasks"`
+	Recent     []FinishedTask    `json:"recent"`
+	Counters   TaskCounters      `json:"counters"`
+	Pricing    *PricingStatus    `json:"pricing,omitempty"`
+	FLSessions []FLSessionStatus `json:"fl_sessions,omitempty"`
+	Caches     []CacheUsage      `json:"caches,omitempty"`
+	Disk       *DiskUsage        `json:"disk,omitempty"`
+	Server     ServerStatus      `json:"server"`
+	// Canaries are the self-tests of the runner's capabilities
+	Canaries []CanaryStatus `json:"canaries,omitempty"`
+	// ErrorBudgets are the error budgets of the task types the runner ran
+	ErrorBudgets []ErrorBudgetStatus `json:"error_budgets,omitempty"`
+	// Concurrency is how many tasks the runner runs at once
+	Concurrency *ConcurrencyStatus `json:"concurrency,omitempty"`
+	// Anomalies is the self-monitoring of the runner's results, with the
+	// anomalous results held for approval
+	Anomalies *AnomalyStatus `json:"anomalies,omitempty"`
+	// Reputation is the runner's reputation score as computed from its own
+	// history, nil when the history is disabled
+	Reputation *ReputationReport `json:"reputation,omitempty"`
+	// Donation is the idle time the runner donates to community federated
+	// learning sessions, kept apart from its paid work
+	Donation *DonationStatus `json:"donation,omitempty"`
+}
+
+// DonationStatus is the work the runner donated to community federated
+// learning sessions while it had no paid task to run
+type DonationStatus struct {
+	Sessions []string `json:"sessions"`
+	// Active is the donated round being trained, empty when none
+	Active string `json:"active,omitempty"`
+	// Preempted is set while the active round waits for paid tasks
+	Preempted   bool   `json:"preempted"`
+	Rounds      uint64 `json:"rounds"`
+	Preemptions uint64 `json:"preemptions"`
+	// DonatedTime is how long donated rounds trained, leaving out the time
+	// they waited for paid tasks
+	DonatedTime time.Duration `json:"donated_ns"`
+	// LastYield is how long donated training took to stop for the latest
+	// paid task
+	LastYield time.Duration `json:"last_yield_ns,omitempty"`
+}
+
+// RunningTask is a task the runner is executing
+type RunningTask struct {
+	TaskID    string    `json:"task_id"`
+	Type      TaskType  `json:"type"`
+	Instance  string    `json:"instance,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	// Workload is what the runner keys the task's expected duration by,
+	// such as its image or model
+	Workload string `json:"workload,omitempty"`
+	// Timeout is the longest the task may run, zero when unknown
+	Timeout  time.Duration `json:"timeout_ns,omitempty"`
+	Progress *TaskProgress `json:"progress,omitempty"`
+}
+
+// FinishedTask is a task whose execution ended
+type FinishedTask struct {
+	TaskID     string        `json:"task_id"`
+	Type       TaskType      `json:"type"`
+	Instance   string        `json:"instance,omitempty"`
+	Status     TaskStatus    `json:"status"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   time.Duration `json:"duration_ns"`
+	Reward     float64       `json:"reward,omitempty"`
+}
+
+// TaskCounters counts the tasks offered to the runner since it started
+type TaskCounters struct {
+	Claimed   uint64 `json:"claimed"`
+	Completed uint64 `json:"completed"`
+	Failed    uint64 `json:"failed"`
+	Declined  uint64 `json:"declined"`
+	// DeclineReasons counts the declined tasks by reason
+	DeclineReasons map[FLDeclineReason]uint64 `json:"decline_reasons,omitempty"`
+	// Skipped counts the tasks passed over while the runner was busy, by
+	// reason in SkipReasons
+	Skipped     uint64                `json:"skipped"`
+	SkipReasons map[SkipReason]uint64 `json:"skip_reasons,omitempty"`
+}
+
+// FLSessionStatus is the latest round of a federated learning session the
+// runner takes part in
+type FLSessionStatus struct {
+	SessionID string     `json:"session_id"`
+	RoundID   string     `json:"round_id,omitempty"`
+	TaskID    string     `json:"task_id"`
+	Status    TaskStatus `json:"status"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// CacheUsage is how much of a local cache is used
+type CacheUsage struct {
+	Name       string `json:"name"`
+	Entries    int    `json:"entries"`
+	UsageBytes int64  `json:"usage_bytes"`
+	QuotaBytes int64  `json:"quota_bytes,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// DiskUsage is the space left on the filesystem holding the runner's data
+// and, with disk reservations enabled, how much of it running tasks hold
+type DiskUsage struct {
+	Path          string `json:"path"`
+	FreeBytes     uint64 `json:"free_bytes"`
+	ReservedBytes int64  `json:"reserved_bytes,omitempty"`
+	Reservations  int    `json:"reservations,omitempty"`
+	// Rejected counts the tasks skipped for not fitting on disk
+	Rejected uint64 `json:"rejected,omitempty"`
+}
+
+// Heartbeat breaker states. The runner backs off from the server while
+// heartbeats fail, holding the breaker open until one succeeds.
+const (
+	BreakerClosed = "closed"
+	BreakerOpen   = "open"
+)
+
+// ServerStatus is how well the runner reaches the server
+type ServerStatus struct {
+	LastHeartbeat       time.Time `json:"last_heartbeat,omitempty"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Breaker             string    `json:"breaker"`
+}
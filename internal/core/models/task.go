@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/safejson"
 )
 
 type (
@@ -19,6 +21,9 @@ const (
 	TaskStatusRunning   TaskStatus = "running"
 	TaskStatusCompleted TaskStatus = "completed"
 	TaskStatusFailed    TaskStatus = "failed"
+	// TaskStatusRevoked is reported for a task killed by an emergency
+	// revocation
+	TaskStatusRevoked TaskStatus = "revoked"
 )
 
 const (
@@ -26,6 +31,11 @@ const (
 	TaskTypeCommand           TaskType = "command"
 	TaskTypeLLM               TaskType = "llm"
 	TaskTypeFederatedLearning TaskType = "federated_learning"
+	TaskTypeEmbedding         TaskType = "embedding"
+	TaskTypeONNX              TaskType = "onnx"
+	// TaskTypeService hosts a long-lived service, such as an inference
+	// endpoint, for a target duration rather than running to completion
+	TaskTypeService TaskType = "service"
 )
 
 type TaskConfig struct {
@@ -33,7 +43,48 @@ type TaskConfig struct {
 	Env            map[string]string `json:"env,omitempty"`
 	Resources      ResourceConfig    `json:"resources,omitempty"`
 	DockerImageURL string            `json:"docker_image_url,omitempty"`
-	ImageName      string            `json:"image_name,omitempty"`
+	// DockerImageDigest is the sha256 digest of the tarball at
+	// DockerImageURL, which it must match. Tarballs with a digest can be
+	// fetched from fleet peers that cached them.
+	DockerImageDigest string             `json:"docker_image_digest,omitempty"`
+	ImageName         string             `json:"image_name,omitempty"`
+	OutputManifest    *OutputManifest    `json:"output_manifest,omitempty"`
+	ExportImage       *ImageExportConfig `json:"export_image,omitempty"`
+	// Inputs are downloaded and placed at their target paths before the
+	// task starts
+	Inputs []TaskInput `json:"inputs,omitempty"`
+	// RequireAttestation restricts the task to runners that can attest their
+	// environment and asks for evidence bound to the result
+	RequireAttestation bool `json:"require_attestation,omitempty"`
+	// Retention is how long the runner may keep the task's result, replay
+	// bundle and scratch files once the result upload is confirmed: none,
+	// hours such as 12h or days such as 7d. The runner's own policy applies
+	// when empty.
+	Retention string `json:"retention,omitempty"`
+	// Network overrides name resolution in a Docker task's container
+	Network *NetworkConfig `json:"network,omitempty"`
+	// RunAsRoot asks for a Docker task's container to run as its image's
+	// user, typically root, instead of an unprivileged user of its own.
+	// Runners whose policy forbids it skip the task.
+	RunAsRoot bool `json:"run_as_root,omitempty"`
+	// SecurityProfile names the security profile, such as "build", a
+	// Docker or command task runs under; the runner's default when empty.
+	// Runners whose policy does not permit it skip the task.
+	SecurityProfile string `json:"security_profile,omitempty"`
+	// Escrow asks for the task's result to be escrowed to its creator and
+	// the network
+	Escrow *EscrowConfig `json:"escrow,omitempty"`
+	// PostProcess derives fields from the task's result and may decide
+	// whether it passed
+	PostProcess *PostProcessConfig `json:"post_process,omitempty"`
+	// Materialize names the materialization plugins, configured on the
+	// runner, that deliver the task's successful result to its creator's
+	// own systems. Runners lacking one of them skip the task.
+	Materialize []string `json:"materialize,omitempty"`
+	// Hermetic asks for a Docker task to run from its declared inputs
+	// alone, without network access, and for its result to attest so.
+	// Runners that cannot enforce it skip the task.
+	Hermetic bool `json:"hermetic,omitempty"`
 }
 
 func (c *TaskConfig) Validate(taskType TaskType) error {
@@ -45,16 +96,89 @@ func (c *TaskConfig) Validate(taskType TaskType) error {
 	case TaskTypeCommand:
 	case TaskTypeLLM:
 	case TaskTypeFederatedLearning:
+	case TaskTypeEmbedding:
+	case TaskTypeONNX:
+	case TaskTypeService:
+		if c.ImageName == "" {
+			return errors.New("image name is required for service tasks")
+		}
 	default:
 		return fmt.Errorf("unsupported task type: %s", taskType)
 	}
+
+	if c.OutputManifest != nil {
+		if err := c.OutputManifest.Validate(); err != nil {
+			return fmt.Errorf("invalid output manifest: %w", err)
+		}
+	}
+	for i := range c.Inputs {
+		if taskType != TaskTypeDocker && taskType != TaskTypeCommand {
+			return errors.New("inputs are only supported for Docker and command tasks")
+		}
+		if err := c.Inputs[i].Validate(); err != nil {
+			return fmt.Errorf("invalid input: %w", err)
+		}
+	}
+	if _, _, err := ParseRetention(c.Retention); err != nil {
+		return err
+	}
+	if c.Network != nil {
+		// Command tasks run in the runner's own network namespace, where
+		// overriding the resolver would affect the whole host
+		if taskType != TaskTypeDocker {
+			return errors.New("network overrides are only supported for Docker tasks")
+		}
+		if err := c.Network.Validate(); err != nil {
+			return fmt.Errorf("invalid network overrides: %w", err)
+		}
+	}
+	if c.SecurityProfile != "" && taskType != TaskTypeDocker && taskType != TaskTypeCommand {
+		return errors.New("security profiles are only supported for Docker and command tasks")
+	}
+	if c.Escrow != nil {
+		if err := c.Escrow.Validate(); err != nil {
+			return fmt.Errorf("invalid escrow: %w", err)
+		}
+	}
+	if c.PostProcess != nil {
+		if taskType == TaskTypeLLM || taskType == TaskTypeFederatedLearning {
+			return errors.New("post-processing is not supported for LLM and federated learning tasks")
+		}
+		if err := c.PostProcess.Validate(); err != nil {
+			return fmt.Errorf("invalid post-processing: %w", err)
+		}
+	}
+	if len(c.Materialize) > 0 && (taskType == TaskTypeLLM || taskType == TaskTypeFederatedLearning) {
+		return errors.New("materialization is not supported for LLM and federated learning tasks")
+	}
+	if c.ExportImage != nil {
+		if taskType != TaskTypeDocker {
+			return errors.New("image export is only supported for Docker tasks")
+		}
+		if err := c.ExportImage.Validate(); err != nil {
+			return fmt.Errorf("invalid image export: %w", err)
+		}
+	}
 	return nil
 }
 
 type ResourceConfig struct {
-	Memory    string `json:"memory,omitempty"`
-	CPUShares int64  `json:"cpu_shares,omitempty"`
-	Timeout   string `json:"timeout,omitempty"`
+	Memory      string `json:"memory,omitempty"`
+	CPUShares   int64  `json:"cpu_shares,omitempty"`
+	Timeout     string `json:"timeout,omitempty"`
+	Accelerator string `json:"accelerator,omitempty"`
+	// MinBandwidth is the throughput in Mbit/s the task needs in each direction
+	MinBandwidth float64 `json:"min_bandwidth_mbps,omitempty"`
+	// GPUMemory is the VRAM the task needs, such as "8g". Tasks that declare
+	// it may share a GPU with other tasks that do.
+	GPUMemory string `json:"gpu_memory,omitempty"`
+	// Priority decides which task sharing a GPU is evicted when the GPU is
+	// overcommitted; the lowest goes first
+	Priority int `json:"priority,omitempty"`
+	// DiskSpace caps, such as "2g", what a command task may write outside
+	// its working directory. On Linux the task gets ENOSPC once it is used
+	// up; the host's filesystems are left untouched.
+	DiskSpace string `json:"disk_space,omitempty"`
 }
 
 type Task struct {
@@ -70,9 +194,15 @@ type Task struct {
 	CreatorDeviceID string             `json:"creator_device_id" gorm:"type:varchar(255)"`
 	RunnerID        string             `json:"runner_id" gorm:"type:varchar(255)"`
 	Nonce           string             `json:"nonce" gorm:"type:varchar(64);not null"`
+	CallbackURL     string             `json:"callback_url,omitempty" gorm:"type:text"`
 	CreatedAt       time.Time          `json:"created_at" gorm:"type:timestamp"`
 	UpdatedAt       time.Time          `json:"updated_at" gorm:"type:timestamp"`
 	CompletedAt     *time.Time         `json:"completed_at" gorm:"type:timestamp"`
+	// Group is set for a shard of a task group
+	Group *TaskGroup `json:"group,omitempty" gorm:"type:jsonb;serializer:json"`
+	// Migration is set for a task handed off by a runner that shut down
+	// while running it
+	Migration *TaskMigration `json:"migration,omitempty" gorm:"type:jsonb;serializer:json"`
 }
 
 func NewTask() *Task {
@@ -94,7 +224,7 @@ func (t *Task) Validate() error {
 	}
 
 	var config TaskConfig
-	if err := json.Unmarshal(t.Config, &config); err != nil {
+	if err := safejson.Unmarshal(t.Config, &config); err != nil {
 		return fmt.Errorf("failed to unmarshal task config: %w", err)
 	}
 
@@ -102,6 +232,12 @@ func (t *Task) Validate() error {
 		return err
 	}
 
+	if t.Group != nil {
+		if err := t.Group.Validate(); err != nil {
+			return fmt.Errorf("invalid group: %w", err)
+		}
+	}
+
 	if t.Type == TaskTypeDocker && (t.Environment == nil || t.Environment.Type != "docker") {
 		return errors.New("docker environment configuration is required for docker tasks")
 	}
@@ -0,0 +1,81 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// TaskGroup places a task in a group of shards submitted together, such as
+// the points of a parameter sweep, that runners treat as a unit
+type TaskGroup struct {
+	ID string `json:"id"`
+	// Shard is the task's index in the group, from 0
+	Shard int `json:"shard"`
+	// Shards is how many tasks the group holds
+	Shards int `json:"shards"`
+	// SharedFate cancels the group's other shards once one of them fails;
+	// otherwise a failed shard does not affect its siblings
+	SharedFate bool `json:"shared_fate,omitempty"`
+}
+
+func (g *TaskGroup) Validate() error {
+	if g.ID == "" {
+		return errors.New("group ID is required")
+	}
+	if g.Shards < 1 {
+		return errors.New("a group holds at least one shard")
+	}
+	if g.Shard < 0 || g.Shard >= g.Shards {
+		return errors.New("shard index is outside the group")
+	}
+	return nil
+}
+
+// GroupProgress is how far a group has got on one runner, sent with the
+// progress its shards report
+type GroupProgress struct {
+	GroupID string `json:"group_id"`
+	Shards  int    `json:"shards"`
+	// Completed, Failed and Running count the shards the runner ran
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+	Running   int `json:"running"`
+	// Percent is how much of the whole group is done, counting each
+	// finished shard whole and each running shard by its own progress
+	Percent float64 `json:"percent"`
+}
+
+// ShardSummary is the outcome of one shard of a group
+type ShardSummary struct {
+	TaskID     string     `json:"task_id"`
+	Shard      int        `json:"shard"`
+	Status     TaskStatus `json:"status"`
+	ExitCode   int        `json:"exit_code"`
+	DurationMs int64      `json:"duration_ms"`
+	// Cancelled is set for a shard stopped because a sibling failed in a
+	// group with shared fate
+	Cancelled bool `json:"cancelled,omitempty"`
+}
+
+// GroupSummary is a runner's combined result for the shards of a group it
+// ran, submitted once it has no more of them to run. Runners that shared
+// a group each submit their own.
+type GroupSummary struct {
+	GroupID string `json:"group_id"`
+	// Shards is how many tasks the group holds, of which ShardResults are
+	// the ones this runner ran, by shard index
+	Shards       int            `json:"shards"`
+	ShardResults []ShardSummary `json:"shard_results"`
+	Completed    int            `json:"completed"`
+	Failed       int            `json:"failed"`
+	// CancelledBy is the task ID of the shard whose failure cancelled a
+	// group with shared fate
+	CancelledBy string    `json:"cancelled_by,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	// TotalDurationMs adds up the shards' durations, MeanDurationMs and
+	// MaxDurationMs describe them
+	TotalDurationMs int64 `json:"total_duration_ms"`
+	MeanDurationMs  int64 `json:"mean_duration_ms"`
+	MaxDurationMs   int64 `json:"max_duration_ms"`
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+type InputMode string
+
+const (
+	// InputModeReadOnly shares the downloaded file with the task unchanged
+	InputModeReadOnly InputMode = "ro"
+	// InputModeReadWrite gives the task its own copy it may modify
+	InputModeReadWrite InputMode = "rw"
+)
+
+var inputNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,128}$`)
+
+// TaskInput is a file the runner downloads and places at TargetPath before
+// the task starts. Docker tasks give an absolute path in the container;
+// command tasks give a path relative to their working directory.
+type TaskInput struct {
+	Name   string      `json:"name"`
+	Source InputSource `json:"source"`
+	// SHA256 and Size, when given, are verified after download. Inputs with
+	// a hash or a CID are cached by content across tasks.
+	SHA256     string    `json:"sha256,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	TargetPath string    `json:"target_path"`
+	Mode       InputMode `json:"mode,omitempty"`
+}
+
+// InputSource locates an input by URL, by IPFS CID or as an artifact of
+// another task, exactly one of which is set
+type InputSource struct {
+	URL  string           `json:"url,omitempty"`
+	CID  string           `json:"cid,omitempty"`
+	Task *TaskArtifactRef `json:"task,omitempty"`
+}
+
+// TaskArtifactRef names an artifact of a task the input's task depends on.
+// The runner fetches it from the server, streaming it into the input cache,
+// or copies it from its own artifacts when it ran that task.
+type TaskArtifactRef struct {
+	TaskID   string `json:"task_id"`
+	Artifact string `json:"artifact"`
+}
+
+// ReadOnly reports whether the task gets the input without write access
+func (i *TaskInput) ReadOnly() bool {
+	return i.Mode != InputModeReadWrite
+}
+
+// Validate checks the input on its own; target paths are checked against
+// the task type and each other by the inputs package
+func (i *TaskInput) Validate() error {
+	if !inputNamePattern.MatchString(i.Name) {
+		return fmt.Errorf("input name %q must be 1-128 letters, digits, '_', '.' or '-'", i.Name)
+	}
+	sources := 0
+	for _, set := range []bool{i.Source.URL != "", i.Source.CID != "", i.Source.Task != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("input %s: exactly one of source url, cid and task is required", i.Name)
+	}
+	if ref := i.Source.Task; ref != nil {
+		if _, err := uuid.Parse(ref.TaskID); err != nil {
+			return fmt.Errorf("input %s: source task_id must be a UUID", i.Name)
+		}
+		if !inputNamePattern.MatchString(ref.Artifact) {
+			return fmt.Errorf("input %s: source artifact %q must be 1-128 letters, digits, '_', '.' or '-'", i.Name, ref.Artifact)
+		}
+	}
+	if i.SHA256 != "" && !sha256Pattern.MatchString(i.SHA256) {
+		return fmt.Errorf("input %s: sha256 must be 64 lowercase hex characters", i.Name)
+	}
+	if i.Size < 0 {
+		return fmt.Errorf("input %s: size must not be negative", i.Name)
+	}
+	if i.TargetPath == "" {
+		return fmt.Errorf("input %s: target_path is required", i.Name)
+	}
+	switch i.Mode {
+	case "", InputModeReadOnly, InputModeReadWrite:
+	default:
+		return fmt.Errorf("input %s: unsupported mode: %s", i.Name, i.Mode)
+	}
+	return nil
+}
+
+// ResolvedInput records an input as the task received it, for provenance
+type ResolvedInput struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Path   string `json:"path"`
+}
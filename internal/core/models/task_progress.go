@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// TaskProgress is a running task's own report of how far it has got,
+// written from inside its container and relayed by the runner
+type TaskProgress struct {
+	// Percent is between 0 and 100; a checkpoint report carries the last
+	// percentage the task reported
+	Percent float64   `json:"percent"`
+	Message string    `json:"message,omitempty"`
+	Time    time.Time `json:"time"`
+	// Checkpoint is set when the report announces a checkpoint the task
+	// wrote, once it has been uploaded
+	Checkpoint *TaskCheckpoint `json:"checkpoint,omitempty"`
+	// Group is set for a shard of a task group, with the progress of the
+	// group's shards on this runner
+	Group *GroupProgress `json:"group,omitempty"`
+	// Service is set for a service task, reported by the runner as the
+	// service becomes ready or not and every health interval
+	Service *ServiceHealth `json:"service,omitempty"`
+	// Heartbeat is set when the runner reports a task still running that
+	// reported nothing itself for a while, with how long it has run and
+	// when its timeout expires
+	Heartbeat bool       `json:"heartbeat,omitempty"`
+	ElapsedMs int64      `json:"elapsed_ms,omitempty"`
+	Deadline  *time.Time `json:"deadline,omitempty"`
+}
+
+// TaskCheckpoint is a file a task wrote to its checkpoint directory that the
+// runner uploaded, from which a later attempt at the task may resume
+type TaskCheckpoint struct {
+	// Name is the file's path within the checkpoint directory
+	Name string `json:"name"`
+	CID  string `json:"cid"`
+	Size int64  `json:"size"`
+	// Root is set for a checkpoint uploaded as chunks, whose CID names its
+	// manifest
+	Root string `json:"root,omitempty"`
+}
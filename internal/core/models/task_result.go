@@ -32,11 +32,88 @@ type TaskResult struct {
 	MemoryGBHours       float64   `json:"memory_gb_hours" gorm:"type:decimal(20,8);default:0"`
 	StorageGB           float64   `json:"storage_gb" gorm:"type:decimal(20,8);default:0"`
 	NetworkDataGB       float64   `json:"network_data_gb" gorm:"type:decimal(20,8);default:0"`
+	// OutputCID is the IPFS CID of an output too large to send inline,
+	// whose first bytes Output then holds. OutputSize is the size of an
+	// output Output does not hold all of, and OutputTruncated is set when
+	// the rest of it could not be kept anywhere.
+	OutputCID       string `json:"output_cid,omitempty" gorm:"type:varchar(128)"`
+	OutputSize      int64  `json:"output_size,omitempty" gorm:"type:bigint;default:0"`
+	OutputTruncated bool   `json:"output_truncated,omitempty" gorm:"default:false"`
+	// PeakMemoryBytes is the highest memory usage sampled, and
+	// MemoryAboveSoftSeconds how long usage stayed above the soft limit
+	PeakMemoryBytes        int64   `json:"peak_memory_bytes,omitempty" gorm:"type:bigint;default:0"`
+	MemoryAboveSoftSeconds float64 `json:"memory_above_soft_seconds,omitempty" gorm:"type:decimal(20,8);default:0"`
+	// Duration is how long execution took, measured on the runner's
+	// monotonic clock. CreatedAt is the start on the runner's wall clock
+	// plus Duration, so it never precedes the start when the clock is
+	// stepped mid-task.
+	Duration time.Duration `json:"duration_ns,omitempty" gorm:"type:bigint;default:0"`
+	Clock    *ClockInfo    `json:"clock,omitempty" gorm:"type:jsonb;serializer:json"`
 
 	// LLM-specific fields
-	PromptTokens   int   `json:"prompt_tokens,omitempty" gorm:"type:int;default:0"`
-	ResponseTokens int   `json:"response_tokens,omitempty" gorm:"type:int;default:0"`
-	InferenceTime  int64 `json:"inference_time_ms,omitempty" gorm:"type:bigint;default:0"`
+	PromptTokens   int         `json:"prompt_tokens,omitempty" gorm:"type:int;default:0"`
+	ResponseTokens int         `json:"response_tokens,omitempty" gorm:"type:int;default:0"`
+	InferenceTime  int64       `json:"inference_time_ms,omitempty" gorm:"type:bigint;default:0"`
+	TokenUsage     *TokenUsage `json:"token_usage,omitempty" gorm:"type:jsonb;serializer:json"`
+	// ResponseFormat reports how a constrained response met its format
+	ResponseFormat *ResponseFormatReport `json:"response_format,omitempty" gorm:"type:jsonb;serializer:json"`
+	// ServedFromCache is set when the response was not generated for this
+	// prompt but for the identical prompt CachedPromptID
+	ServedFromCache bool   `json:"served_from_cache,omitempty" gorm:"default:false"`
+	CachedPromptID  string `json:"cached_prompt_id,omitempty" gorm:"type:varchar(36)"`
+	// CustomModel describes the model artifact supplied by the task's
+	// creator that its prompts were served with
+	CustomModel *CustomModelReport `json:"custom_model,omitempty" gorm:"type:jsonb;serializer:json"`
+	// ToolUse is the transcript of the tool calls an LLM task's model made
+	// through the runner
+	ToolUse *ToolUseReport `json:"tool_use,omitempty" gorm:"type:jsonb;serializer:json"`
+
+	OutputVerdict  *OutputVerdict       `json:"output_verdict,omitempty" gorm:"type:jsonb;serializer:json"`
+	Embedding      *EmbeddingSummary    `json:"embedding,omitempty" gorm:"type:jsonb;serializer:json"`
+	ONNX           *ONNXSummary         `json:"onnx,omitempty" gorm:"type:jsonb;serializer:json"`
+	AppliedTimeout *AppliedTimeout      `json:"applied_timeout,omitempty" gorm:"type:jsonb;serializer:json"`
+	ArtifactCIDs   []string             `json:"artifact_cids,omitempty" gorm:"type:jsonb;serializer:json"`
+	ExportedImage  *ExportedImage       `json:"exported_image,omitempty" gorm:"type:jsonb;serializer:json"`
+	Attestation    *AttestationEvidence `json:"attestation,omitempty" gorm:"type:jsonb;serializer:json"`
+	Inputs         []ResolvedInput      `json:"inputs,omitempty" gorm:"type:jsonb;serializer:json"`
+	// ChunkedArtifacts lists the artifacts uploaded as chunks, with the
+	// Merkle roots their chunks are verified against
+	ChunkedArtifacts []ChunkedArtifact `json:"chunked_artifacts,omitempty" gorm:"type:jsonb;serializer:json"`
+	// Artifacts describes the files the task produced, with their detected
+	// content types and previews
+	Artifacts []ArtifactDescription `json:"artifacts,omitempty" gorm:"type:jsonb;serializer:json"`
+	// Service is set for a service task, with the uptime it delivered
+	Service *ServiceReport `json:"service,omitempty" gorm:"type:jsonb;serializer:json"`
+	// DependencyFailure is set when the task failed because an artifact of
+	// a task it depends on could not be fetched, not through a fault of its
+	// own
+	DependencyFailure *DependencyFailure `json:"dependency_failure,omitempty" gorm:"type:jsonb;serializer:json"`
+	// FailureCode classifies why a failed task failed
+	FailureCode FailureCode `json:"failure_code,omitempty" gorm:"type:varchar(32)"`
+	// Stall is the evidence a task was found stalled on, set when it was
+	// abandoned for stalling
+	Stall *StallEvidence `json:"stall,omitempty" gorm:"type:jsonb;serializer:json"`
+	// BuildFingerprint fingerprints the runner binary that produced the
+	// result; attestation and callback signatures cover it
+	BuildFingerprint string `json:"build_fingerprint,omitempty" gorm:"type:varchar(64)"`
+	// Redaction is set when the runner redacts what tasks produce
+	Redaction *RedactionReport `json:"redaction,omitempty" gorm:"type:jsonb;serializer:json"`
+	// Timeline is where the task's time went on the runner up to its report
+	Timeline *TaskTimeline `json:"timeline,omitempty" gorm:"type:jsonb;serializer:json"`
+	// SecurityProfile is the security profile Docker and command tasks ran
+	// under
+	SecurityProfile *AppliedSecurityProfile `json:"security_profile,omitempty" gorm:"type:jsonb;serializer:json"`
+	// Escrow is set for tasks asking for their result to be escrowed
+	Escrow *EscrowReport `json:"escrow,omitempty" gorm:"type:jsonb;serializer:json"`
+	// Anomaly is set when the runner found the result out of line with its
+	// earlier results of the task's type
+	Anomaly *ResultAnomaly `json:"anomaly,omitempty" gorm:"type:jsonb;serializer:json"`
+	// Metadata holds the fields the task's post-processing derived
+	Metadata map[string]interface{} `json:"metadata,omitempty" gorm:"type:jsonb;serializer:json"`
+	// PostProcess is set for tasks post-processing their result
+	PostProcess *PostProcessReport `json:"post_process,omitempty" gorm:"type:jsonb;serializer:json"`
+	// Hermetic is set for hermetic tasks, with their input closure
+	Hermetic *HermeticAttestation `json:"hermetic,omitempty" gorm:"type:jsonb;serializer:json"`
 }
 
 func (r *TaskResult) Clean() {
@@ -0,0 +1,61 @@
+package models
+
+// Parts of a task's result a ResultQuery can select
+const (
+	ResultFieldMetadata  = "metadata"
+	ResultFieldOutput    = "output"
+	ResultFieldArtifacts = "artifacts"
+)
+
+// ResultQuery selects part of a task's result, so that runners fetching the
+// result of a task they depend on need not download all of it. The zero
+// query selects everything.
+type ResultQuery struct {
+	// Fields lists the parts to return; all of them when empty
+	Fields []string
+	// OutputOffset and OutputLength select a byte range of the output. A
+	// zero length reads to the end.
+	OutputOffset int64
+	OutputLength int64
+	// Artifacts names the artifacts to describe; all of them when empty
+	Artifacts []string
+}
+
+// TaskResultPage is the part of a task's result a ResultQuery selected
+type TaskResultPage struct {
+	TaskID string `json:"task_id"`
+	// Result is the result's metadata, with its output left empty
+	Result *TaskResult `json:"result,omitempty"`
+	// Output is the selected range of the output, starting at OutputOffset
+	// of its OutputSize bytes
+	Output       string           `json:"output,omitempty"`
+	OutputOffset int64            `json:"output_offset,omitempty"`
+	OutputSize   int64            `json:"output_size,omitempty"`
+	Artifacts    []ResultArtifact `json:"artifacts,omitempty"`
+}
+
+// ResultArtifact describes an artifact of a task's result, which is fetched
+// on its own by name
+type ResultArtifact struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+// Reasons a task's dependency could not be fetched
+const (
+	// DependencyExpired means the server no longer keeps the artifact
+	DependencyExpired = "expired"
+	// DependencyUnavailable means the artifact could not be fetched, or did
+	// not match what the server described
+	DependencyUnavailable = "unavailable"
+)
+
+// DependencyFailure names the artifact of an earlier task that a task could
+// not get, failing it before it ran
+type DependencyFailure struct {
+	TaskID   string `json:"task_id"`
+	Artifact string `json:"artifact"`
+	Input    string `json:"input"`
+	Reason   string `json:"reason"`
+}
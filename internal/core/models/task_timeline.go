@@ -0,0 +1,87 @@
+package models
+
+import "time"
+
+// TimelineVersion is the version of the TaskTimeline format. It changes
+// only when a field changes meaning; fields and phases are only added.
+const TimelineVersion = 1
+
+// TaskPhase is a phase of a task's lifecycle on the runner. A phase lasts
+// from its transition until the next one.
+type TaskPhase string
+
+const (
+	// PhaseClaimed starts when the runner claims the task, and covers what
+	// it does before fetching anything
+	PhaseClaimed TaskPhase = "claimed"
+	// PhaseInputsFetching covers fetching the task's inputs; its bytes are
+	// those downloaded, not those served from the cache
+	PhaseInputsFetching TaskPhase = "inputs_fetching"
+	// PhaseInputsReady starts once the inputs are in place
+	PhaseInputsReady TaskPhase = "inputs_ready"
+	// PhaseImagePulling covers making the task's image available; its bytes
+	// are those of an image downloaded from a URL
+	PhaseImagePulling TaskPhase = "image_pulling"
+	PhaseExecuting    TaskPhase = "executing"
+	// PhaseUploading covers collecting the task's output, uploading its
+	// artifacts and submitting its result
+	PhaseUploading TaskPhase = "uploading"
+	// PhaseReported is the moment the result was submitted; it has no
+	// duration
+	PhaseReported TaskPhase = "reported"
+)
+
+// Outcomes of a task's attempt on the runner other than a report
+const (
+	AttemptReported  = "reported"
+	AttemptRequeued  = "requeued"
+	AttemptAbandoned = "abandoned"
+	AttemptHandedOff = "handed_off"
+	AttemptMigrated  = "migrated"
+	AttemptLeaseLost = "lease_lost"
+)
+
+// TaskTimeline is where a task's time went on the runner, as timestamped
+// phase transitions grouped by attempt
+type TaskTimeline struct {
+	Version int    `json:"version"`
+	TaskID  string `json:"task_id"`
+	// QueueWaitMs is how long the task waited between its creation and its
+	// first claim by this runner, zero when its creation time is unknown
+	QueueWaitMs int64             `json:"queue_wait_ms,omitempty"`
+	Attempts    []TimelineAttempt `json:"attempts"`
+}
+
+// TimelineAttempt is one run of a task on the runner. A task returned to
+// the queue and claimed again by the same runner has an attempt for each
+// run.
+type TimelineAttempt struct {
+	Attempt    int       `json:"attempt"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	// Outcome is how the attempt ended, such as reported or requeued, and
+	// empty while it runs
+	Outcome string          `json:"outcome,omitempty"`
+	Phases  []TimelinePhase `json:"phases"`
+}
+
+// TimelinePhase is a phase of an attempt. Its duration includes the pauses
+// within it.
+type TimelinePhase struct {
+	Phase      TaskPhase `json:"phase"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	PausedMs   int64     `json:"paused_ms,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	// Open is set on the phase still running when the timeline was taken
+	Open   bool            `json:"open,omitempty"`
+	Pauses []TimelinePause `json:"pauses,omitempty"`
+}
+
+// TimelinePause is a window in which the task made no progress, such as
+// while it was suspended for power conditions
+type TimelinePause struct {
+	Reason     string    `json:"reason"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// TaskWarningMemorySoftLimit is sent when a task's memory usage stays above
+// its soft limit; the task has been signalled to release memory
+const TaskWarningMemorySoftLimit = "memory_soft_limit"
+
+// TaskWarningDaemonRestart is sent when the Docker daemon restarted under a
+// running task whose container survived; the runner reattached to it
+const TaskWarningDaemonRestart = "docker_daemon_restart"
+
+// TaskWarningStalled is sent when a task's processes stay stalled on
+// memory, IO or CPU; the warning carries the evidence
+const TaskWarningStalled = "stalled"
+
+// TaskWarning reports a condition of a running task that may end it, so the
+// server can surface it before the task's result arrives
+type TaskWarning struct {
+	Code    string    `json:"code"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+	// Memory usage and limits in bytes, for memory warnings
+	MemoryUsage     uint64 `json:"memory_usage,omitempty"`
+	MemorySoftLimit uint64 `json:"memory_soft_limit,omitempty"`
+	MemoryHardLimit uint64 `json:"memory_hard_limit,omitempty"`
+	// Stall is the evidence of a stall, for stall warnings
+	Stall *StallEvidence `json:"stall,omitempty"`
+}
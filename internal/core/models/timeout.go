@@ -0,0 +1,24 @@
+package models
+
+type TimeoutSource string
+
+const (
+	// TimeoutSourceAdaptive means the timeout was derived from local execution history
+	TimeoutSourceAdaptive TimeoutSource = "adaptive"
+	// TimeoutSourceStatic means too little history existed and the per-type default was used
+	TimeoutSourceStatic TimeoutSource = "static"
+	// TimeoutSourceCapped means the task declared a timeout longer than the runner allows
+	TimeoutSourceCapped TimeoutSource = "capped"
+)
+
+// AppliedTimeout records the timeout a runner chose for a task that did not
+// declare one, or that declared one longer than the runner allows
+type AppliedTimeout struct {
+	Source         TimeoutSource `json:"source"`
+	TimeoutSeconds int64         `json:"timeout_seconds"`
+	Workload       string        `json:"workload,omitempty"`
+	Samples        int           `json:"samples"`
+	P95Ms          int64         `json:"p95_ms,omitempty"`
+	// RequestedSeconds is the timeout a capped task declared
+	RequestedSeconds int64 `json:"requested_seconds,omitempty"`
+}
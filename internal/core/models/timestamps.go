@@ -0,0 +1,60 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ClockInfo describes the runner's clock when it produced a result, for
+// reconciling its timestamps with other machines'
+type ClockInfo struct {
+	// Timezone is the runner's local zone abbreviation and UTCOffsetSeconds
+	// its offset east of UTC. Result timestamps are in UTC whatever they are.
+	Timezone         string `json:"timezone"`
+	UTCOffsetSeconds int    `json:"utc_offset_seconds"`
+	// NTPOffsetMs is how far the runner's clock was behind NTPServer, as
+	// last measured at NTPMeasuredAt; negative when it was ahead. Unset
+	// when the offset has not been measured.
+	NTPOffsetMs   *int64    `json:"ntp_offset_ms,omitempty"`
+	NTPServer     string    `json:"ntp_server,omitempty"`
+	NTPMeasuredAt time.Time `json:"ntp_measured_at,omitempty"`
+}
+
+// utc converts t to UTC, leaving the zero time as it is
+func utc(t time.Time) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.UTC()
+}
+
+// MarshalJSON writes the task's timestamps in UTC
+func (t Task) MarshalJSON() ([]byte, error) {
+	type plain Task
+	t.CreatedAt = utc(t.CreatedAt)
+	t.UpdatedAt = utc(t.UpdatedAt)
+	if t.CompletedAt != nil {
+		completed := utc(*t.CompletedAt)
+		t.CompletedAt = &completed
+	}
+	return json.Marshal(plain(t))
+}
+
+// MarshalJSON writes the result's timestamps in UTC
+func (r TaskResult) MarshalJSON() ([]byte, error) {
+	type plain TaskResult
+	r.CreatedAt = utc(r.CreatedAt)
+	if r.Clock != nil {
+		clock := *r.Clock
+		clock.NTPMeasuredAt = utc(clock.NTPMeasuredAt)
+		r.Clock = &clock
+	}
+	return json.Marshal(plain(r))
+}
+
+// MarshalJSON writes the result's completion time in UTC
+func (r FLTaskResult) MarshalJSON() ([]byte, error) {
+	type plain FLTaskResult
+	r.CompletedAt = utc(r.CompletedAt)
+	return json.Marshal(plain(r))
+}
@@ -0,0 +1,22 @@
+package models
+
+// TokenUsage reports the token counts of one LLM generation as the inference
+// backend measured them next to the runner's own count with the model's
+// tokenizer. Rewards are settled from these figures, so a large disagreement
+// is flagged for review instead of either side being picked silently.
+type TokenUsage struct {
+	// BackendReported is false when the backend returned no usage figures
+	BackendReported       bool `json:"backend_reported"`
+	BackendPromptTokens   int  `json:"backend_prompt_tokens"`
+	BackendResponseTokens int  `json:"backend_response_tokens"`
+	// CountedPromptTokens includes the BOS token when the model adds one
+	CountedPromptTokens   int `json:"counted_prompt_tokens"`
+	CountedResponseTokens int `json:"counted_response_tokens"`
+	// Tokenizer identifies the vocabulary counted with; empty when the
+	// runner could not load the model's tokenizer
+	Tokenizer string `json:"tokenizer,omitempty"`
+	// Discrepancy is the larger relative difference between the prompt and
+	// response figures
+	Discrepancy float64 `json:"discrepancy"`
+	Flagged     bool    `json:"flagged"`
+}
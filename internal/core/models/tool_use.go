@@ -0,0 +1,133 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Tools an LLM task may call through the runner
+const (
+	// ToolWebFetch fetches a URL over HTTP(S)
+	ToolWebFetch = "web_fetch"
+	// ToolCodeExec runs a Python snippet in a container without network
+	ToolCodeExec = "code_exec"
+)
+
+// Reasons the runner ended a task's tool use, recorded as StopReason
+const (
+	// ToolStopMaxCalls: the task made as many tool calls as it may
+	ToolStopMaxCalls = "max_calls"
+	// ToolStopMaxFetchBytes: the task fetched as many bytes as it may
+	ToolStopMaxFetchBytes = "max_fetch_bytes"
+	// ToolStopMaxToolTime: the task's tools ran for as long as they may
+	ToolStopMaxToolTime = "max_tool_time"
+	// ToolStopMaxTurns: the model kept asking for calls it may not make
+	// for MaxToolTurns turns
+	ToolStopMaxTurns = "max_turns"
+)
+
+// Why the runner refused a tool call, recorded as Denied alongside the
+// stop reasons
+const (
+	// ToolDeniedNotOffered: the model asked for a tool it was not offered
+	ToolDeniedNotOffered = "not_offered"
+	// ToolDeniedNetworkPolicy: the call would reach an address the
+	// runner's network policy does not allow
+	ToolDeniedNetworkPolicy = "network_policy"
+)
+
+// MaxToolTurns bounds the turns of a tool-use conversation, so a model
+// answering every result with a call it may not make still ends
+const MaxToolTurns = 64
+
+// ToolUseConfig lets an LLM task's model call tools. The model never calls
+// a tool itself: it asks, and the runner calls the tool on its behalf,
+// metering each call against the task's budget and recording it.
+type ToolUseConfig struct {
+	// Tools are the tools the model is offered
+	Tools []string `json:"tools"`
+	// MaxCalls bounds the tool calls the task makes
+	MaxCalls int `json:"max_calls,omitempty"`
+	// MaxFetchBytes bounds the bytes web_fetch reads over all calls
+	MaxFetchBytes int64 `json:"max_fetch_bytes,omitempty"`
+	// MaxToolSeconds bounds the wall-clock time the tools run for over all
+	// calls
+	MaxToolSeconds float64 `json:"max_tool_seconds,omitempty"`
+}
+
+// Validate checks that the tools are known and the budgets not negative
+func (c *ToolUseConfig) Validate() error {
+	if len(c.Tools) == 0 {
+		return fmt.Errorf("tools names no tools")
+	}
+	for _, tool := range c.Tools {
+		if tool != ToolWebFetch && tool != ToolCodeExec {
+			return fmt.Errorf("unknown tool %q", tool)
+		}
+	}
+	if c.MaxCalls < 0 || c.MaxFetchBytes < 0 || c.MaxToolSeconds < 0 {
+		return fmt.Errorf("tool budgets must not be negative")
+	}
+	return nil
+}
+
+// ToolBudget is what a task's tools may spend
+type ToolBudget struct {
+	MaxCalls      int           `json:"max_calls"`
+	MaxFetchBytes int64         `json:"max_fetch_bytes"`
+	MaxToolTime   time.Duration `json:"max_tool_time_ns"`
+}
+
+// Budget returns the budget of a task configured with c on a runner
+// allowing at most limit: each of limit's budgets, lowered to the task's
+// where it sets one
+func (c *ToolUseConfig) Budget(limit ToolBudget) ToolBudget {
+	budget := limit
+	if c.MaxCalls > 0 && c.MaxCalls < budget.MaxCalls {
+		budget.MaxCalls = c.MaxCalls
+	}
+	if c.MaxFetchBytes > 0 && c.MaxFetchBytes < budget.MaxFetchBytes {
+		budget.MaxFetchBytes = c.MaxFetchBytes
+	}
+	if seconds := time.Duration(c.MaxToolSeconds * float64(time.Second)); seconds > 0 && seconds < budget.MaxToolTime {
+		budget.MaxToolTime = seconds
+	}
+	return budget
+}
+
+// ToolUsage is what a task's tools spent
+type ToolUsage struct {
+	Calls        int           `json:"calls"`
+	FetchedBytes int64         `json:"fetched_bytes"`
+	ToolTime     time.Duration `json:"tool_time_ns"`
+}
+
+// ToolCallRecord is one tool call the model asked for, in the transcript
+type ToolCallRecord struct {
+	Tool      string          `json:"tool"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	StartedAt time.Time       `json:"started_at"`
+	Duration  time.Duration   `json:"duration_ns"`
+	// Bytes is what the call fetched
+	Bytes int64 `json:"bytes,omitempty"`
+	// Result is what the model was given, truncated for the transcript
+	Result    string `json:"result,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Denied is set for calls the runner refused to make: the tool was
+	// not offered, the network policy forbids it, or the budget is spent
+	Denied string `json:"denied,omitempty"`
+}
+
+// ToolUseReport is the transcript of a task's tool use
+type ToolUseReport struct {
+	Budget ToolBudget       `json:"budget"`
+	Usage  ToolUsage        `json:"usage"`
+	Calls  []ToolCallRecord `json:"calls,omitempty"`
+	// StopReason is why the runner stopped offering tools before the model
+	// finished, empty when it finished on its own
+	StopReason string `json:"stop_reason,omitempty"`
+	// Turns is how many times the model was asked for a response
+	Turns int `json:"turns"`
+}
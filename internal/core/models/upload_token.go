@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// UploadToken is a short-lived credential that authorises uploads for a
+// single task's artifacts. APIURL, when set, names the endpoint the token is
+// valid for in place of the runner's configured one.
+type UploadToken struct {
+	Token     string    `json:"token"`
+	TaskID    string    `json:"task_id"`
+	APIURL    string    `json:"api_url,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ExpiresWithin reports whether the token lapses within d of now. A zero
+// ExpiresAt means the issuer set no expiry.
+func (t *UploadToken) ExpiresWithin(now time.Time, d time.Duration) bool {
+	return t == nil || (!t.ExpiresAt.IsZero() && !now.Add(d).Before(t.ExpiresAt))
+}
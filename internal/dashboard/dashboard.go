@@ -0,0 +1,110 @@
+package dashboard
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultInterval is how often the dashboard refreshes
+	DefaultInterval = 2 * time.Second
+	// requestTimeout bounds each call to the runner
+	requestTimeout = 5 * time.Second
+	// defaultWidth is used when the terminal's width is unknown
+	defaultWidth = 100
+)
+
+// clearScreen moves the cursor home and clears the terminal
+const clearScreen = "\x1b[H\x1b[2J"
+
+// Run shows src on out, refreshing every interval, and carries out the
+// keys typed on in until q is typed, in closes or ctx ends. On a terminal
+// keys act as they are typed; elsewhere they take effect once Enter is
+// pressed.
+func Run(ctx context.Context, src Source, in *os.File, out *os.File, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if restore, err := cbreak(in); err == nil {
+		defer restore()
+	}
+
+	keys := make(chan byte)
+	go readKeys(in, keys)
+
+	var m Model
+	refresh := func() {
+		fetchCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+		defer cancel()
+		status, err := src.Status(fetchCtx)
+		m.Update(status, err, time.Now())
+	}
+	draw := func() error {
+		width := terminalWidth(out)
+		if width <= 0 {
+			width = defaultWidth
+		}
+		var frame bytes.Buffer
+		frame.WriteString(clearScreen)
+		if err := Render(&frame, m.View(time.Now()), width); err != nil {
+			return err
+		}
+		_, err := out.Write(frame.Bytes())
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	refresh()
+	for {
+		if err := draw(); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			refresh()
+		case key, ok := <-keys:
+			if !ok || key == 'q' {
+				return nil
+			}
+			if key == 'r' {
+				refresh()
+				continue
+			}
+			action, ok := ActionFor(key, m.Status())
+			if !ok {
+				continue
+			}
+			actionCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+			if err := action.Run(actionCtx, src); err != nil {
+				m.SetMessage(fmt.Sprintf("%s failed: %v", action.Name, err))
+			} else {
+				m.SetMessage(action.Name + " done")
+			}
+			cancel()
+			refresh()
+		}
+	}
+}
+
+// readKeys passes the bytes read from in to keys, closing keys once in
+// ends
+func readKeys(in io.Reader, keys chan<- byte) {
+	defer close(keys)
+	buf := make([]byte, 64)
+	for {
+		n, err := in.Read(buf)
+		for _, b := range buf[:n] {
+			keys <- b
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,350 @@
+// Package dashboard is the terminal view of a runner behind
+// `parity-runner top`. It polls the runner's status API, lays the status
+// out for display and sends the operator's keyboard actions back to it.
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// Source is the runner the dashboard shows and acts on
+type Source interface {
+	Status(ctx context.Context) (*models.LocalStatus, error)
+	SetDraining(ctx context.Context, draining bool) error
+	SetPaused(ctx context.Context, paused bool) error
+	SetLogLevel(ctx context.Context, level string) error
+}
+
+// logLevels are the levels the log level key cycles through
+var logLevels = []string{"trace", "debug", "info", "warn", "error"}
+
+// View is a runner's status laid out for display, every value formatted
+type View struct {
+	// Connected is set once a status has been fetched; until then only the
+	// title, state and error are shown
+	Connected bool
+	Title     string
+	State     string
+	LogLevel  string
+	Server    string
+	Counters  string
+	Disk      string
+	// Error is why the latest status could not be fetched; the rest of
+	// the view then shows the last status fetched
+	Error string
+	// Message reports the outcome of the operator's last action
+	Message string
+
+	Tasks    []TaskRow
+	Recent   []RecentRow
+	Declines []DeclineRow
+	Caches   []CacheRow
+	Sessions []SessionRow
+}
+
+type TaskRow struct {
+	TaskID    string
+	Type      string
+	Progress  string
+	Elapsed   string
+	Remaining string
+}
+
+type RecentRow struct {
+	TaskID   string
+	Type     string
+	Status   string
+	Duration string
+	Reward   string
+	Finished string
+}
+
+type DeclineRow struct {
+	Reason string
+	Count  uint64
+}
+
+type CacheRow struct {
+	Name    string
+	Entries string
+	Usage   string
+	Quota   string
+}
+
+type SessionRow struct {
+	SessionID string
+	RoundID   string
+	Status    string
+	Updated   string
+}
+
+// Model holds what the dashboard knows of the runner between refreshes
+type Model struct {
+	status    *models.LocalStatus
+	fetchedAt time.Time
+	err       error
+	message   string
+}
+
+// Update records the outcome of fetching the runner's status at now. A
+// failed fetch keeps the last status.
+func (m *Model) Update(status *models.LocalStatus, err error, now time.Time) {
+	m.err = err
+	if err == nil {
+		m.status = status
+		m.fetchedAt = now
+	}
+}
+
+// SetMessage reports the outcome of an action
+func (m *Model) SetMessage(message string) {
+	m.message = message
+}
+
+// Status returns the last status fetched, nil before the first
+func (m *Model) Status() *models.LocalStatus {
+	return m.status
+}
+
+// View lays the model out as of now
+func (m *Model) View(now time.Time) View {
+	v := View{Title: "parity-runner", Message: m.message}
+	if m.err != nil {
+		v.Error = m.err.Error()
+	}
+	s := m.status
+	if s == nil {
+		v.State = "connecting"
+		return v
+	}
+	// Elapsed times are measured on the runner's clock, moved on by the
+	// time since the status was fetched
+	runnerNow := s.Time.Add(now.Sub(m.fetchedAt))
+
+	v.Connected = true
+	if s.DeviceID != "" {
+		v.Title += " " + s.DeviceID[:min(len(s.DeviceID), 12)]
+	}
+	if s.Instance != "" {
+		v.Title += " (" + s.Instance + ")"
+	}
+	v.State = runnerState(s)
+	v.LogLevel = s.LogLevel
+	v.Server = serverState(s.Server, runnerNow)
+	v.Counters = fmt.Sprintf("claimed %d  completed %d  failed %d  declined %d",
+		s.Counters.Claimed, s.Counters.Completed, s.Counters.Failed, s.Counters.Declined)
+	if s.Disk != nil {
+		v.Disk = fmt.Sprintf("%s free on %s", formatBytes(int64(s.Disk.FreeBytes)), s.Disk.Path)
+		if s.Disk.ReservedBytes > 0 {
+			v.Disk += fmt.Sprintf(", %s reserved", formatBytes(s.Disk.ReservedBytes))
+		}
+	}
+
+	for _, task := range s.Tasks {
+		v.Tasks = append(v.Tasks, taskRow(task, runnerNow))
+	}
+	for _, task := range s.Recent {
+		row := RecentRow{
+			TaskID:   shortID(task.TaskID),
+			Type:     string(task.Type),
+			Status:   string(task.Status),
+			Duration: formatDuration(task.Duration),
+			Reward:   "-",
+			Finished: formatDuration(runnerNow.Sub(task.FinishedAt)) + " ago",
+		}
+		if task.Reward > 0 {
+			row.Reward = fmt.Sprintf("%g", task.Reward)
+		}
+		v.Recent = append(v.Recent, row)
+	}
+	for reason, count := range s.Counters.DeclineReasons {
+		v.Declines = append(v.Declines, DeclineRow{Reason: string(reason), Count: count})
+	}
+	sort.Slice(v.Declines, func(i, j int) bool {
+		if v.Declines[i].Count != v.Declines[j].Count {
+			return v.Declines[i].Count > v.Declines[j].Count
+		}
+		return v.Declines[i].Reason < v.Declines[j].Reason
+	})
+	for _, c := range s.Caches {
+		row := CacheRow{Name: c.Name, Entries: fmt.Sprint(c.Entries), Usage: formatBytes(c.UsageBytes), Quota: "-"}
+		if c.Error != "" {
+			row.Entries, row.Usage = "-", c.Error
+		} else if c.QuotaBytes > 0 {
+			row.Quota = fmt.Sprintf("%s (%.0f%%)", formatBytes(c.QuotaBytes), 100*float64(c.UsageBytes)/float64(c.QuotaBytes))
+		}
+		v.Caches = append(v.Caches, row)
+	}
+	for _, session := range s.FLSessions {
+		v.Sessions = append(v.Sessions, SessionRow{
+			SessionID: shortID(session.SessionID),
+			RoundID:   shortID(session.RoundID),
+			Status:    string(session.Status),
+			Updated:   formatDuration(runnerNow.Sub(session.UpdatedAt)) + " ago",
+		})
+	}
+	return v
+}
+
+func runnerState(s *models.LocalStatus) string {
+	var states []string
+	if s.Paused {
+		states = append(states, "paused")
+	}
+	if s.Draining {
+		states = append(states, "draining")
+	}
+	if len(states) == 0 {
+		return "running"
+	}
+	return strings.Join(states, ", ")
+}
+
+func serverState(server models.ServerStatus, now time.Time) string {
+	last := "never"
+	if !server.LastHeartbeat.IsZero() {
+		last = formatDuration(now.Sub(server.LastHeartbeat)) + " ago"
+	}
+	if server.Breaker == models.BreakerOpen {
+		return fmt.Sprintf("unreachable, breaker open after %d failures, last heartbeat %s", server.ConsecutiveFailures, last)
+	}
+	return "connected, last heartbeat " + last
+}
+
+// taskRow lays out a running task. The time remaining is estimated from
+// its progress, and never exceeds the time left before its timeout.
+func taskRow(task models.RunningTask, now time.Time) TaskRow {
+	elapsed := now.Sub(task.StartedAt)
+	row := TaskRow{
+		TaskID:    shortID(task.TaskID),
+		Type:      string(task.Type),
+		Progress:  "-",
+		Elapsed:   formatDuration(elapsed),
+		Remaining: "-",
+	}
+	if row.Type == "" {
+		row.Type = "-"
+	}
+
+	var remaining time.Duration
+	known := false
+	if p := task.Progress; p != nil && p.Percent > 0 {
+		row.Progress = fmt.Sprintf("%3.0f%%", p.Percent)
+		if p.Percent < 100 {
+			remaining = time.Duration(float64(elapsed) * (100 - p.Percent) / p.Percent)
+			known = true
+		}
+	}
+	if task.Timeout > 0 {
+		left := max(task.Timeout-elapsed, 0)
+		if !known || left < remaining {
+			remaining = left
+		}
+		known = true
+	}
+	if known {
+		row.Remaining = formatDuration(remaining)
+	}
+	return row
+}
+
+// Action is an operator action bound to a key
+type Action struct {
+	Key  byte
+	Name string
+	run  func(ctx context.Context, src Source) error
+}
+
+// Run carries the action out on src
+func (a Action) Run(ctx context.Context, src Source) error {
+	return a.run(ctx, src)
+}
+
+// ActionFor returns the action key stands for given the runner's status,
+// if any. Drain and pause toggle; the log level key moves to the next
+// level, wrapping around.
+func ActionFor(key byte, status *models.LocalStatus) (Action, bool) {
+	if status == nil {
+		return Action{}, false
+	}
+	switch key {
+	case 'd':
+		draining := !status.Draining
+		name := "drain"
+		if !draining {
+			name = "undrain"
+		}
+		return Action{Key: key, Name: name, run: func(ctx context.Context, src Source) error {
+			return src.SetDraining(ctx, draining)
+		}}, true
+	case 'p':
+		paused := !status.Paused
+		name := "pause"
+		if !paused {
+			name = "resume"
+		}
+		return Action{Key: key, Name: name, run: func(ctx context.Context, src Source) error {
+			return src.SetPaused(ctx, paused)
+		}}, true
+	case 'l':
+		level := nextLogLevel(status.LogLevel)
+		return Action{Key: key, Name: "log level " + level, run: func(ctx context.Context, src Source) error {
+			return src.SetLogLevel(ctx, level)
+		}}, true
+	}
+	return Action{}, false
+}
+
+func nextLogLevel(current string) string {
+	for i, level := range logLevels {
+		if level == current {
+			return logLevels[(i+1)%len(logLevels)]
+		}
+	}
+	return "info"
+}
+
+// shortID shortens UUIDs to their first 8 characters
+func shortID(id string) string {
+	if id == "" {
+		return "-"
+	}
+	if len(id) == 36 && id[8] == '-' {
+		return id[:8]
+	}
+	return id
+}
+
+func formatDuration(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	d = d.Round(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	switch {
+	case h > 0:
+		return fmt.Sprintf("%dh%02dm", h, m)
+	case m > 0:
+		return fmt.Sprintf("%dm%02ds", m, s)
+	}
+	return fmt.Sprintf("%ds", s)
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
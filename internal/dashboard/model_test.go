@@ -0,0 +1,195 @@
+package dashboard
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var fetched = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// sampleStatus is a busy runner as its status API reports it at fetched
+func sampleStatus() *models.LocalStatus {
+	return &models.LocalStatus{
+		DeviceID: "4f9c2d1e7a6b5c3d",
+		Time:     fetched,
+		Paused:   true,
+		LogLevel: "info",
+		Tasks: []models.RunningTask{
+			{
+				TaskID:    "3f1c2a9e-8b7d-4c55-9a1e-2f6b0d4c7e11",
+				Type:      models.TaskTypeDocker,
+				StartedAt: fetched.Add(-10 * time.Minute),
+				Timeout:   time.Hour,
+				Progress:  &models.TaskProgress{Percent: 25},
+			},
+			{
+				TaskID:    "9b2e4c6a-1d3f-4e5a-8b7c-0a1b2c3d4e5f",
+				Type:      models.TaskTypeCommand,
+				StartedAt: fetched.Add(-50 * time.Second),
+				Timeout:   time.Minute,
+				Progress:  &models.TaskProgress{Percent: 10},
+			},
+		},
+		Recent: []models.FinishedTask{
+			{
+				TaskID:     "7c8d9e0f-1a2b-3c4d-5e6f-7a8b9c0d1e2f",
+				Type:       models.TaskTypeFederatedLearning,
+				Status:     models.TaskStatusCompleted,
+				FinishedAt: fetched.Add(-2 * time.Minute),
+				Duration:   95 * time.Second,
+				Reward:     1.5,
+			},
+			{
+				TaskID:     "0a1b2c3d-4e5f-6a7b-8c9d-0e1f2a3b4c5d",
+				Type:       models.TaskTypeDocker,
+				Status:     models.TaskStatusFailed,
+				FinishedAt: fetched.Add(-time.Hour),
+				Duration:   3 * time.Second,
+			},
+		},
+		Counters: models.TaskCounters{
+			Claimed:   14,
+			Completed: 11,
+			Failed:    1,
+			Declined:  9,
+			DeclineReasons: map[models.FLDeclineReason]uint64{
+				models.FLDeclineAtCapacity:            5,
+				models.FLDeclinePaused:                2,
+				models.FLDeclineInsufficientResources: 2,
+			},
+		},
+		FLSessions: []models.FLSessionStatus{
+			{SessionID: "session-a1b2c3d4", RoundID: "round-7", TaskID: "7c8d9e0f", Status: models.TaskStatusCompleted, UpdatedAt: fetched.Add(-2 * time.Minute)},
+		},
+		Caches: []models.CacheUsage{
+			{Name: "images", Entries: 4, UsageBytes: 3 << 30, QuotaBytes: 12 << 30},
+			{Name: "datasets", Error: "permission denied"},
+		},
+		Disk: &models.DiskUsage{Path: "/home/runner/.parity", FreeBytes: 40 << 30},
+		Server: models.ServerStatus{
+			LastHeartbeat:       fetched.Add(-90 * time.Second),
+			ConsecutiveFailures: 3,
+			Breaker:             models.BreakerOpen,
+		},
+	}
+}
+
+func TestViewLaysOutStatus(t *testing.T) {
+	var m Model
+	m.Update(sampleStatus(), nil, fetched)
+	// The dashboard redraws 5 seconds after fetching
+	v := m.View(fetched.Add(5 * time.Second))
+
+	if v.State != "paused" {
+		t.Errorf("State = %q, want paused", v.State)
+	}
+	if want := "unreachable, breaker open after 3 failures, last heartbeat 1m35s ago"; v.Server != want {
+		t.Errorf("Server = %q, want %q", v.Server, want)
+	}
+
+	wantTasks := []TaskRow{
+		// 25% done after 10m05s leaves about 30m15s
+		{TaskID: "3f1c2a9e", Type: "docker", Progress: " 25%", Elapsed: "10m05s", Remaining: "30m15s"},
+		// Progress suggests minutes more, but the timeout ends it in 5s
+		{TaskID: "9b2e4c6a", Type: "command", Progress: " 10%", Elapsed: "55s", Remaining: "5s"},
+	}
+	if !reflect.DeepEqual(v.Tasks, wantTasks) {
+		t.Errorf("Tasks = %+v, want %+v", v.Tasks, wantTasks)
+	}
+
+	if v.Recent[0].Reward != "1.5" || v.Recent[0].Finished != "2m05s ago" || v.Recent[1].Reward != "-" {
+		t.Errorf("Recent = %+v, want rewards and finish times", v.Recent)
+	}
+
+	wantDeclines := []DeclineRow{
+		{Reason: "at_capacity", Count: 5},
+		{Reason: "insufficient_resources", Count: 2},
+		{Reason: "paused", Count: 2},
+	}
+	if !reflect.DeepEqual(v.Declines, wantDeclines) {
+		t.Errorf("Declines = %+v, want %+v", v.Declines, wantDeclines)
+	}
+
+	if v.Caches[0].Quota != "12.0 GiB (25%)" || v.Caches[1].Usage != "permission denied" {
+		t.Errorf("Caches = %+v, want usage against quota and errors", v.Caches)
+	}
+}
+
+func TestViewKeepsLastStatusOnError(t *testing.T) {
+	var m Model
+	if v := m.View(fetched); v.State != "connecting" {
+		t.Fatalf("State = %q before the first status, want connecting", v.State)
+	}
+
+	m.Update(sampleStatus(), nil, fetched)
+	m.Update(nil, context.DeadlineExceeded, fetched.Add(time.Minute))
+	v := m.View(fetched.Add(time.Minute))
+	if v.Error == "" {
+		t.Error("View() reports no error after a failed fetch")
+	}
+	if len(v.Tasks) != 2 {
+		t.Errorf("View() shows %d tasks after a failed fetch, want the last status's 2", len(v.Tasks))
+	}
+}
+
+// recordingSource records the actions the dashboard sends
+type recordingSource struct {
+	calls []string
+}
+
+func (s *recordingSource) Status(ctx context.Context) (*models.LocalStatus, error) {
+	return sampleStatus(), nil
+}
+
+func (s *recordingSource) SetDraining(ctx context.Context, draining bool) error {
+	s.calls = append(s.calls, map[bool]string{true: "drain", false: "undrain"}[draining])
+	return nil
+}
+
+func (s *recordingSource) SetPaused(ctx context.Context, paused bool) error {
+	s.calls = append(s.calls, map[bool]string{true: "pause", false: "resume"}[paused])
+	return nil
+}
+
+func (s *recordingSource) SetLogLevel(ctx context.Context, level string) error {
+	s.calls = append(s.calls, "level "+level)
+	return nil
+}
+
+func TestActionsToggleState(t *testing.T) {
+	status := sampleStatus()
+	if _, ok := ActionFor('d', nil); ok {
+		t.Fatal("ActionFor() acted before the first status")
+	}
+	if _, ok := ActionFor('x', status); ok {
+		t.Fatal("ActionFor() bound an unknown key")
+	}
+
+	src := &recordingSource{}
+	for _, key := range []byte{'d', 'p', 'l'} {
+		action, ok := ActionFor(key, status)
+		if !ok {
+			t.Fatalf("ActionFor(%q) found no action", key)
+		}
+		if err := action.Run(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	status.Draining, status.Paused, status.LogLevel = true, false, "error"
+	for _, key := range []byte{'d', 'p', 'l'} {
+		action, _ := ActionFor(key, status)
+		if err := action.Run(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{"drain", "resume", "level warn", "undrain", "pause", "level trace"}
+	if strings.Join(src.calls, ",") != strings.Join(want, ",") {
+		t.Fatalf("actions sent %v, want %v", src.calls, want)
+	}
+}
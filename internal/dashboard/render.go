@@ -0,0 +1,105 @@
+package dashboard
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"unicode/utf8"
+)
+
+// keyHelp lists the keyboard actions at the foot of every frame
+const keyHelp = "d drain/undrain  p pause/resume  l log level  r refresh  q quit"
+
+// Render writes a frame of v, its lines cut to width columns
+func Render(w io.Writer, v View, width int) error {
+	var frame bytes.Buffer
+
+	fmt.Fprintf(&frame, "%s  state: %s", v.Title, v.State)
+	if v.LogLevel != "" {
+		fmt.Fprintf(&frame, "  log: %s", v.LogLevel)
+	}
+	frame.WriteString("\n")
+	if v.Server != "" {
+		fmt.Fprintf(&frame, "server: %s\n", v.Server)
+	}
+	if v.Error != "" {
+		fmt.Fprintf(&frame, "error: %s\n", v.Error)
+	}
+
+	if v.Connected {
+		writeSections(&frame, v)
+	}
+
+	frame.WriteString("\n")
+	if v.Message != "" {
+		fmt.Fprintf(&frame, "%s\n", v.Message)
+	}
+	frame.WriteString(keyHelp + "\n")
+
+	for _, line := range strings.SplitAfter(frame.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		if _, err := io.WriteString(w, truncate(strings.TrimSuffix(line, "\n"), width)+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSections writes the tables and figures of v's status
+func writeSections(frame *bytes.Buffer, v View) {
+	section(frame, "RUNNING", len(v.Tasks), "TASK\tTYPE\tPROGRESS\tELAPSED\tREMAINING", func(tw io.Writer) {
+		for _, t := range v.Tasks {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", t.TaskID, t.Type, t.Progress, t.Elapsed, t.Remaining)
+		}
+	})
+	section(frame, "RECENT", len(v.Recent), "TASK\tTYPE\tSTATUS\tDURATION\tREWARD\tFINISHED", func(tw io.Writer) {
+		for _, t := range v.Recent {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\t%s\n", t.TaskID, t.Type, t.Status, t.Duration, t.Reward, t.Finished)
+		}
+	})
+
+	fmt.Fprintf(frame, "\nTASKS  %s\n", v.Counters)
+	section(frame, "DECLINED", len(v.Declines), "REASON\tCOUNT", func(tw io.Writer) {
+		for _, d := range v.Declines {
+			fmt.Fprintf(tw, "  %s\t%d\n", d.Reason, d.Count)
+		}
+	})
+	section(frame, "CACHES", len(v.Caches), "CACHE\tENTRIES\tUSAGE\tQUOTA", func(tw io.Writer) {
+		for _, c := range v.Caches {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", c.Name, c.Entries, c.Usage, c.Quota)
+		}
+	})
+	if v.Disk != "" {
+		fmt.Fprintf(frame, "disk: %s\n", v.Disk)
+	}
+	section(frame, "FL SESSIONS", len(v.Sessions), "SESSION\tROUND\tSTATUS\tUPDATED", func(tw io.Writer) {
+		for _, s := range v.Sessions {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", s.SessionID, s.RoundID, s.Status, s.Updated)
+		}
+	})
+}
+
+// section writes a titled table, its rows indented under the title, or
+// "none" when there are none
+func section(frame *bytes.Buffer, title string, rows int, header string, writeRows func(io.Writer)) {
+	fmt.Fprintf(frame, "\n%s\n", title)
+	if rows == 0 {
+		frame.WriteString("  none\n")
+		return
+	}
+	tw := tabwriter.NewWriter(frame, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "  %s\n", header)
+	writeRows(tw)
+	tw.Flush()
+}
+
+func truncate(line string, width int) string {
+	if width <= 0 || utf8.RuneCountInString(line) <= width {
+		return line
+	}
+	return string([]rune(line)[:width])
+}
@@ -0,0 +1,65 @@
+package dashboard
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden frames in testdata")
+
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("frame differs from %s (run with -update to accept):\n%s", path, got)
+	}
+}
+
+func TestRenderBusyRunner(t *testing.T) {
+	var m Model
+	m.Update(sampleStatus(), nil, fetched)
+	m.SetMessage("pause done")
+
+	var frame bytes.Buffer
+	if err := Render(&frame, m.View(fetched.Add(5*time.Second)), 100); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "busy.golden", frame.Bytes())
+}
+
+func TestRenderBeforeFirstStatus(t *testing.T) {
+	var m Model
+	var frame bytes.Buffer
+	if err := Render(&frame, m.View(fetched), 100); err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "connecting.golden", frame.Bytes())
+}
+
+func TestRenderCutsLinesToWidth(t *testing.T) {
+	var m Model
+	m.Update(sampleStatus(), nil, fetched)
+	var frame bytes.Buffer
+	if err := Render(&frame, m.View(fetched), 40); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(frame.String(), "\n"), "\n") {
+		if len([]rune(line)) > 40 {
+			t.Errorf("line %q is wider than 40 columns", line)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package dashboard
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// cbreak makes f, a terminal, deliver keys as they are typed without
+// echoing them, returning a function restoring its previous mode
+func cbreak(f *os.File) (func(), error) {
+	fd := f.Fd()
+	var old syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&old)); err != nil {
+		return nil, err
+	}
+	mode := old
+	mode.Lflag &^= syscall.ICANON | syscall.ECHO
+	mode.Cc[syscall.VMIN] = 1
+	mode.Cc[syscall.VTIME] = 0
+	if err := ioctl(fd, syscall.TCSETS, unsafe.Pointer(&mode)); err != nil {
+		return nil, err
+	}
+	return func() { _ = ioctl(fd, syscall.TCSETS, unsafe.Pointer(&old)) }, nil
+}
+
+// terminalWidth returns the number of columns of f, 0 when f is not a
+// terminal
+func terminalWidth(f *os.File) int {
+	var size struct {
+		Rows, Cols, X, Y uint16
+	}
+	if err := ioctl(f.Fd(), syscall.TIOCGWINSZ, unsafe.Pointer(&size)); err != nil {
+		return 0
+	}
+	return int(size.Cols)
+}
+
+func ioctl(fd uintptr, request uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package dashboard
+
+import (
+	"errors"
+	"os"
+)
+
+// cbreak is not supported here; keys take effect once Enter is pressed
+func cbreak(f *os.File) (func(), error) {
+	return nil, errors.New("terminal modes are not supported on this platform")
+}
+
+// terminalWidth is unknown here
+func terminalWidth(f *os.File) int {
+	return 0
+}
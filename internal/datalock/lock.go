@@ -0,0 +1,339 @@
+// Package datalock keeps a runner data directory to one runner process.
+//
+// The process holding the directory keeps an advisory lock on a lock file in
+// it: flock on unix and LockFileEx on windows, both released by the OS when
+// the process dies, so a crashed runner never leaves the directory locked.
+// The lock file records the holder's PID, start time, host and status API, so
+// a second process can name the one in its way, and ask it to drain and exit
+// when taking over. On filesystems without locks the record alone guards the
+// directory, and a record whose process is gone, or whose PID now belongs to
+// another process, is stale and taken over.
+//
+// The directory also carries the version of its layout, so a binary older
+// than the layout refuses to run on it rather than misreading its stores.
+package datalock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LockFileName is the lock file in the data directory
+	LockFileName = "runner.lock"
+	// LayoutFileName holds the layout version of the data directory
+	LayoutFileName = "layout_version"
+	// LayoutVersion is the data directory layout this binary reads and writes
+	LayoutVersion = 1
+)
+
+// startTimeSlack is how far a recorded start time may differ from the one
+// the OS reports for the process before its PID is taken to be reused
+const startTimeSlack = 2 * time.Second
+
+// ErrLayoutTooNew is returned for a data directory written by a newer runner
+var ErrLayoutTooNew = errors.New("data directory layout is newer than this runner supports")
+
+var (
+	// errLocked is returned by tryLock when another process holds the lock
+	errLocked = errors.New("lock is held")
+	// errUnsupported is returned by tryLock on filesystems without locks
+	errUnsupported = errors.New("file locks are not supported")
+)
+
+// tryLock takes the lock on a lock file without waiting for it, returning
+// errLocked or errUnsupported; tests replace it
+var tryLock = lockFile
+
+// Holder is the process holding a data directory
+type Holder struct {
+	PID int `json:"pid"`
+	// StartedAt is when the process started, telling it apart from a later
+	// process reusing its PID
+	StartedAt time.Time `json:"started_at"`
+	Hostname  string    `json:"hostname,omitempty"`
+	Version   string    `json:"version,omitempty"`
+	// StatusURL is where the process serves its status API, empty when it
+	// serves none
+	StatusURL string `json:"status_url,omitempty"`
+}
+
+// Alive reports whether the holder is still running on this host
+func (h Holder) Alive() bool {
+	if h.PID <= 0 {
+		return false
+	}
+	if host, err := os.Hostname(); err == nil && h.Hostname != "" && h.Hostname != host {
+		// Another host sharing the directory cannot be checked; assume it runs
+		return true
+	}
+	if !processAlive(h.PID) {
+		return false
+	}
+	started, ok := processStart(h.PID)
+	if !ok || h.StartedAt.IsZero() {
+		return true
+	}
+	d := started.Sub(h.StartedAt)
+	return d > -startTimeSlack && d < startTimeSlack
+}
+
+func (h Holder) String() string {
+	s := fmt.Sprintf("pid %d", h.PID)
+	if h.Hostname != "" {
+		s += " on " + h.Hostname
+	}
+	if h.Version != "" {
+		s += ", version " + h.Version
+	}
+	if !h.StartedAt.IsZero() {
+		s += ", started " + h.StartedAt.Format(time.RFC3339)
+	}
+	return s
+}
+
+// LockedError is returned when another process holds the data directory
+type LockedError struct {
+	Dir    string
+	Holder Holder
+}
+
+func (e *LockedError) Error() string {
+	if e.Holder.PID == 0 {
+		return fmt.Sprintf("data directory %s is in use by another runner process", e.Dir)
+	}
+	return fmt.Sprintf("data directory %s is in use by runner %s", e.Dir, e.Holder)
+}
+
+// Lock is a held data directory. It is safe for concurrent use: the
+// process may release it while a takeover or a status request reads it.
+type Lock struct {
+	dir string
+	// advisory is false when the filesystem has no locks and only the
+	// record guards the directory
+	advisory bool
+	stale    *Holder
+
+	// mu guards file, cleared on release, and holder
+	mu     sync.Mutex
+	file   *os.File
+	holder Holder
+}
+
+// Acquire locks dir for this process, described by self, whose PID, start
+// time and host are filled in. It returns a *LockedError when another process
+// holds dir, and ErrLayoutTooNew when a newer runner laid it out.
+func Acquire(dir string, self Holder) (*Lock, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, LockFileName), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+
+	l := &Lock{dir: dir, file: f, advisory: true}
+	previous, readErr := readHolder(f)
+	switch err := tryLock(f); {
+	case err == nil:
+	case errors.Is(err, errUnsupported):
+		if readErr == nil && previous.Alive() {
+			f.Close()
+			return nil, &LockedError{Dir: dir, Holder: previous}
+		}
+		l.advisory = false
+	case errors.Is(err, errLocked):
+		f.Close()
+		return nil, &LockedError{Dir: dir, Holder: previous}
+	default:
+		f.Close()
+		return nil, fmt.Errorf("failed to lock data directory: %w", err)
+	}
+	if readErr == nil {
+		// The last holder exited without releasing the directory
+		l.stale = &previous
+	}
+
+	if err := l.claim(self); err != nil {
+		l.Release()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Adopt takes over the lock on dir a previous runner process passed to this
+// one as f, recording self as its holder
+func Adopt(dir string, f *os.File, self Holder) (*Lock, error) {
+	l := &Lock{dir: dir, file: f, advisory: true}
+	if err := l.claim(self); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Takeover acquires dir as Acquire does, but when another process holds it
+// calls stop to ask that process to drain and exit, then waits for it to
+// release dir, checking every interval until ctx ends
+func Takeover(ctx context.Context, dir string, self Holder, interval time.Duration, stop func(ctx context.Context, holder Holder) error) (*Lock, error) {
+	l, err := Acquire(dir, self)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		return l, err
+	}
+	if err := stop(ctx, locked.Holder); err != nil {
+		return nil, fmt.Errorf("failed to ask runner %s to exit: %w", locked.Holder, err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("runner %s did not exit: %w", locked.Holder, ctx.Err())
+		case <-ticker.C:
+		}
+		l, err := Acquire(dir, self)
+		if !errors.As(err, &locked) {
+			return l, err
+		}
+	}
+}
+
+// claim checks the layout of the directory and records self as the holder
+func (l *Lock) claim(self Holder) error {
+	if err := checkLayout(l.dir); err != nil {
+		return err
+	}
+	self.PID = os.Getpid()
+	if started, ok := processStart(self.PID); ok {
+		self.StartedAt = started
+	} else if self.StartedAt.IsZero() {
+		self.StartedAt = time.Now()
+	}
+	if self.Hostname == "" {
+		self.Hostname, _ = os.Hostname()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return errors.New("lock was released")
+	}
+	if err := writeHolder(l.file, self); err != nil {
+		return fmt.Errorf("failed to record lock holder: %w", err)
+	}
+	l.holder = self
+	return nil
+}
+
+// Holder returns the process recorded as holding the lock, this one
+func (l *Lock) Holder() Holder {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.holder
+}
+
+// Stale returns the holder recorded by a process that exited without
+// releasing the directory, nil when it was released cleanly
+func (l *Lock) Stale() *Holder {
+	return l.stale
+}
+
+// Advisory reports whether the OS enforces the lock; when false the
+// filesystem has no locks and only the holder record guards the directory
+func (l *Lock) Advisory() bool {
+	return l.advisory
+}
+
+// File returns the locked file, for passing the lock to another process,
+// nil once the lock is released
+func (l *Lock) File() *os.File {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file
+}
+
+// Release clears the holder record and unlocks the directory; releasing it
+// again does nothing. It must not be called once the lock has been passed to
+// another process.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Truncate(0)
+	if l.advisory {
+		unlock(l.file)
+	}
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	l.file = nil
+	return err
+}
+
+// readHolder reads the record of the lock file, failing when it is empty
+func readHolder(f *os.File) (Holder, error) {
+	var h Holder
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 64*1024))
+	if err != nil {
+		return h, err
+	}
+	if len(strings.TrimSpace(string(data))) == 0 {
+		return h, errors.New("lock file is empty")
+	}
+	return h, json.Unmarshal(data, &h)
+}
+
+func writeHolder(f *os.File, h Holder) error {
+	data, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(append(data, '\n'), 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// checkLayout refuses a directory laid out by a newer runner, and marks an
+// older or unmarked one with this binary's layout
+func checkLayout(dir string) error {
+	path := filepath.Join(dir, LayoutFileName)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read data directory layout: %w", err)
+	}
+	if err == nil {
+		version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return fmt.Errorf("invalid data directory layout version %q", strings.TrimSpace(string(data)))
+		}
+		if version > LayoutVersion {
+			return fmt.Errorf("%w: %s has layout %d, this runner supports up to %d - upgrade the runner", ErrLayoutTooNew, dir, version, LayoutVersion)
+		}
+		if version == LayoutVersion {
+			return nil
+		}
+	}
+	if err := os.WriteFile(path, []byte(strconv.Itoa(LayoutVersion)+"\n"), 0o600); err != nil {
+		return fmt.Errorf("failed to write data directory layout: %w", err)
+	}
+	return nil
+}
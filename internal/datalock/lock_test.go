@@ -0,0 +1,220 @@
+package datalock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func acquire(t *testing.T, dir string, self Holder) *Lock {
+	t.Helper()
+	l, err := Acquire(dir, self)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Release() })
+	return l
+}
+
+func writeRecord(t *testing.T, dir string, h Holder) {
+	t.Helper()
+	data, err := json.Marshal(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, LockFileName), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// deadPID returns the PID of a process that has exited
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.ProcessState.Pid()
+}
+
+func TestSecondInstanceIsRefused(t *testing.T) {
+	dir := t.TempDir()
+	first := acquire(t, dir, Holder{Version: "1.4.0", StatusURL: "http://localhost:8090"})
+	if first.Holder().PID != os.Getpid() || first.Stale() != nil || !first.Advisory() {
+		t.Fatalf("Acquire() = %+v", first)
+	}
+
+	_, err := Acquire(dir, Holder{})
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("second Acquire() = %v, want a LockedError", err)
+	}
+	if locked.Holder.PID != os.Getpid() || locked.Holder.StatusURL != "http://localhost:8090" {
+		t.Errorf("LockedError names %+v, want the first instance", locked.Holder)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "1.4.0") || !strings.Contains(msg, dir) {
+		t.Errorf("error %q does not name the directory and the other runner", msg)
+	}
+
+	if err := first.Release(); err != nil {
+		t.Fatal(err)
+	}
+	second := acquire(t, dir, Holder{})
+	if second.Stale() != nil {
+		t.Errorf("Stale() = %+v after a clean release", second.Stale())
+	}
+}
+
+func TestOnlyOneConcurrentAcquireSucceeds(t *testing.T) {
+	dir := t.TempDir()
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		held  []*Lock
+		other int
+	)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := Acquire(dir, Holder{})
+			mu.Lock()
+			defer mu.Unlock()
+			var locked *LockedError
+			switch {
+			case err == nil:
+				held = append(held, l)
+			case errors.As(err, &locked):
+				other++
+			default:
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, l := range held {
+		l.Release()
+	}
+	if len(held) != 1 || other != 15 {
+		t.Fatalf("%d instances acquired the directory and %d were refused, want 1 and 15", len(held), other)
+	}
+}
+
+func TestStaleLockIsRecovered(t *testing.T) {
+	dir := t.TempDir()
+	crashed := Holder{PID: deadPID(t), StartedAt: time.Now().Add(-time.Hour), Version: "1.3.0"}
+	writeRecord(t, dir, crashed)
+
+	l := acquire(t, dir, Holder{})
+	if stale := l.Stale(); stale == nil || stale.PID != crashed.PID {
+		t.Fatalf("Stale() = %+v, want the crashed runner", stale)
+	}
+	if l.Holder().PID != os.Getpid() {
+		t.Errorf("Holder() = %+v", l.Holder())
+	}
+}
+
+func TestRecordGuardsFilesystemsWithoutLocks(t *testing.T) {
+	tryLock = func(*os.File) error { return errUnsupported }
+	t.Cleanup(func() { tryLock = lockFile })
+	dir := t.TempDir()
+
+	l, err := Acquire(dir, Holder{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Advisory() {
+		t.Error("Advisory() = true without file locks")
+	}
+	var locked *LockedError
+	if _, err := Acquire(dir, Holder{}); !errors.As(err, &locked) {
+		t.Fatalf("Acquire() over a live holder = %v, want a LockedError", err)
+	}
+	l.Release()
+
+	writeRecord(t, dir, Holder{PID: deadPID(t)})
+	l = acquire(t, dir, Holder{})
+	if l.Stale() == nil {
+		t.Error("Stale() = nil over the record of an exited runner")
+	}
+	l.Release()
+
+	if _, ok := processStart(os.Getpid()); !ok {
+		t.Skip("process start times are not read on this platform")
+	}
+	// This process's PID recorded by an earlier process that had it
+	writeRecord(t, dir, Holder{PID: os.Getpid(), StartedAt: time.Now().Add(-time.Hour)})
+	if l = acquire(t, dir, Holder{}); l.Stale() == nil {
+		t.Error("Stale() = nil over the record of a reused PID")
+	}
+}
+
+func TestLayoutVersion(t *testing.T) {
+	dir := t.TempDir()
+	acquire(t, dir, Holder{}).Release()
+	data, err := os.ReadFile(filepath.Join(dir, LayoutFileName))
+	if err != nil || strings.TrimSpace(string(data)) != "1" {
+		t.Fatalf("layout marker = %q, %v, want this binary's layout", data, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, LayoutFileName), []byte("2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Acquire(dir, Holder{}); !errors.Is(err, ErrLayoutTooNew) {
+		t.Fatalf("Acquire() of a newer layout = %v, want ErrLayoutTooNew", err)
+	}
+	// The refused directory is not left locked
+	os.WriteFile(filepath.Join(dir, LayoutFileName), []byte("1\n"), 0o600)
+	acquire(t, dir, Holder{})
+}
+
+func TestTakeover(t *testing.T) {
+	dir := t.TempDir()
+	running := acquire(t, dir, Holder{StatusURL: "http://localhost:8090"})
+
+	var asked Holder
+	stop := func(ctx context.Context, holder Holder) error {
+		asked = holder
+		// The running instance drains before it exits
+		time.AfterFunc(50*time.Millisecond, func() { running.Release() })
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	l, err := Takeover(ctx, dir, Holder{Version: "1.5.0"}, 10*time.Millisecond, stop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release()
+	if asked.StatusURL != "http://localhost:8090" {
+		t.Errorf("stop asked %+v, want the running instance", asked)
+	}
+	if l.Holder().Version != "1.5.0" {
+		t.Errorf("Holder() = %+v", l.Holder())
+	}
+}
+
+func TestTakeoverGivesUp(t *testing.T) {
+	dir := t.TempDir()
+	acquire(t, dir, Holder{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	ignored := func(context.Context, Holder) error { return nil }
+	if _, err := Takeover(ctx, dir, Holder{}, 10*time.Millisecond, ignored); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Takeover() of a runner that never exits = %v", err)
+	}
+
+	refused := errors.New("connection refused")
+	failing := func(context.Context, Holder) error { return refused }
+	if _, err := Takeover(context.Background(), dir, Holder{}, 10*time.Millisecond, failing); !errors.Is(err, refused) {
+		t.Fatalf("Takeover() = %v, want the stop error", err)
+	}
+}
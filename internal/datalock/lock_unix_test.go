@@ -0,0 +1,34 @@
+//go:build !windows
+
+package datalock
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestAdoptKeepsTheLock(t *testing.T) {
+	dir := t.TempDir()
+	old := acquire(t, dir, Holder{Version: "1.4.0"})
+
+	// The file an upgraded process inherits shares the lock
+	fd, err := syscall.Dup(int(old.File().Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	adopted, err := Adopt(dir, os.NewFile(uintptr(fd), LockFileName), Holder{Version: "1.5.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adopted.Release()
+	// The previous process exits without releasing
+	old.File().Close()
+
+	_, err = Acquire(dir, Holder{})
+	var locked *LockedError
+	if !errors.As(err, &locked) || locked.Holder.Version != "1.5.0" {
+		t.Fatalf("Acquire() after adoption = %v, want the upgraded runner named", err)
+	}
+}
@@ -0,0 +1,70 @@
+//go:build windows
+
+package datalock
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockOffset is where the locked byte lies, far past the holder record, since
+// windows keeps other processes from reading a locked range
+const lockOffset = 1 << 32
+
+// stillActive is the exit code of a process that has not exited
+const stillActive = 259
+
+// lockFile takes an exclusive LockFileEx lock on f without waiting for it
+func lockFile(f *os.File) error {
+	ol := &windows.Overlapped{OffsetHigh: lockOffset >> 32}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY, 0, 1, 0, ol)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, windows.ERROR_LOCK_VIOLATION):
+		return errLocked
+	case errors.Is(err, windows.ERROR_NOT_SUPPORTED), errors.Is(err, windows.ERROR_INVALID_FUNCTION):
+		return errUnsupported
+	}
+	return err
+}
+
+func unlock(f *os.File) {
+	ol := &windows.Overlapped{OffsetHigh: lockOffset >> 32}
+	windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+}
+
+func openProcess(pid int) (windows.Handle, error) {
+	return windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+}
+
+// processAlive reports whether a process with pid is running
+func processAlive(pid int) bool {
+	h, err := openProcess(pid)
+	if err != nil {
+		return errors.Is(err, windows.ERROR_ACCESS_DENIED)
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	if err := windows.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
+
+// processStart returns when the process with pid started
+func processStart(pid int) (time.Time, bool) {
+	h, err := openProcess(pid)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer windows.CloseHandle(h)
+	var created, exited, kernel, user windows.Filetime
+	if err := windows.GetProcessTimes(h, &created, &exited, &kernel, &user); err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, created.Nanoseconds()), true
+}
@@ -0,0 +1,311 @@
+// Package diskreserve keeps concurrent tasks from running a disk out of
+// space between them. Before a task is claimed, what it will write - its
+// image, the inputs not yet cached, its scratch space and headroom for its
+// results - is reserved against one ledger of the filesystem's free space,
+// shared by every task the process runs. A task that does not fit once
+// the other tasks' outstanding reservations are set aside is skipped,
+// after evicting cache entries to make room. Each phase's estimate is held
+// until the phase completes; from then on the disk's own free space
+// accounts for what it actually wrote.
+package diskreserve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/health"
+)
+
+// ErrInsufficientDisk is returned for a task that does not fit on disk even
+// after evicting what the caches can spare
+var ErrInsufficientDisk = errors.New("not enough disk space for task")
+
+// Phase is a part of a task's disk demand, settled once the task is past it
+type Phase string
+
+const (
+	PhaseImage   Phase = "image"
+	PhaseInputs  Phase = "inputs"
+	PhaseScratch Phase = "scratch"
+	PhaseResults Phase = "results"
+)
+
+// Demand is what a task is estimated to write, in bytes, by phase
+type Demand struct {
+	Image   int64
+	Inputs  int64
+	Scratch int64
+	Results int64
+}
+
+// Total is the bytes of every phase of d
+func (d Demand) Total() int64 {
+	return d.Image + d.Inputs + d.Scratch + d.Results
+}
+
+func (d Demand) phases() map[Phase]int64 {
+	return map[Phase]int64{
+		PhaseImage:   d.Image,
+		PhaseInputs:  d.Inputs,
+		PhaseScratch: d.Scratch,
+		PhaseResults: d.Results,
+	}
+}
+
+// Evictor frees disk space held by caches
+type Evictor interface {
+	// Evict removes entries that are neither pinned nor in use, least
+	// recently used first, until bytes are freed, returning what it freed
+	Evict(ctx context.Context, bytes int64) (int64, error)
+}
+
+// Stats reports the ledger
+type Stats struct {
+	Path string
+	// FreeBytes is the filesystem's free space, of which ReservedBytes is
+	// promised to running tasks
+	FreeBytes     uint64
+	ReservedBytes int64
+	Reservations  int
+	// Rejected counts the tasks that did not fit; EvictedBytes what was
+	// evicted from caches to fit others
+	Rejected     uint64
+	EvictedBytes int64
+}
+
+// Ledger holds the disk reservations of the tasks running on one filesystem
+type Ledger struct {
+	path     string
+	minFree  int64
+	headroom float64
+	freeDisk func(path string) (uint64, error)
+	evictor  Evictor
+
+	// reserveMu serializes reservations, so that two tasks never both
+	// count on the same free space or the same eviction
+	reserveMu sync.Mutex
+
+	mu           sync.Mutex
+	reservations map[string]*Reservation
+	rejected     uint64
+	evicted      int64
+}
+
+// NewLedger reserves space on the filesystem holding path, always leaving
+// minFree bytes free. headroom is the share of a task's other demand
+// reserved on top for its results and artifacts.
+func NewLedger(path string, minFree int64, headroom float64) (*Ledger, error) {
+	if path == "" {
+		return nil, errors.New("disk reservations need a path")
+	}
+	if minFree < 0 {
+		return nil, fmt.Errorf("minimum free space must not be negative, got %d", minFree)
+	}
+	if headroom < 0 {
+		return nil, fmt.Errorf("result headroom must not be negative, got %g", headroom)
+	}
+	return &Ledger{
+		path:         path,
+		minFree:      minFree,
+		headroom:     headroom,
+		freeDisk:     health.FreeDiskBytes,
+		reservations: make(map[string]*Reservation),
+	}, nil
+}
+
+// SetEvictor evicts cache entries through evictor to fit a task that does
+// not fit otherwise
+func (l *Ledger) SetEvictor(evictor Evictor) {
+	l.evictor = evictor
+}
+
+// Path is the filesystem the ledger reserves space on
+func (l *Ledger) Path() string {
+	return l.path
+}
+
+// Reserve reserves demand for taskID, adding the result headroom to it. A
+// task that does not fit is given room by evicting cache entries and
+// otherwise refused with ErrInsufficientDisk. Reserving again for a task
+// replaces its reservation. A filesystem that cannot be measured admits
+// every task.
+func (l *Ledger) Reserve(ctx context.Context, taskID string, demand Demand) (*Reservation, error) {
+	log := gologger.WithComponent("diskreserve")
+
+	demand.Results += int64(float64(demand.Image+demand.Inputs+demand.Scratch) * l.headroom)
+	l.reserveMu.Lock()
+	defer l.reserveMu.Unlock()
+
+	l.Release(taskID)
+	if demand.Total() > 0 {
+		short, err := l.shortfall(demand.Total())
+		if err != nil {
+			log.Debug().Err(err).Str("path", l.path).Msg("Cannot measure free disk - not reserving space")
+			short = 0
+		}
+		if short > 0 && l.evictor != nil {
+			freed, err := l.evictor.Evict(ctx, short)
+			if err != nil {
+				log.Warn().Err(err).Msg("Failed to evict cache entries for a task")
+			}
+			l.mu.Lock()
+			l.evicted += freed
+			l.mu.Unlock()
+			if freed > 0 {
+				log.Info().
+					Str("task_id", taskID).
+					Int64("needed_bytes", short).
+					Int64("freed_bytes", freed).
+					Msg("Evicted cache entries to make room for a task")
+			}
+			if short, err = l.shortfall(demand.Total()); err != nil {
+				short = 0
+			}
+		}
+		if short > 0 {
+			l.mu.Lock()
+			l.rejected++
+			l.mu.Unlock()
+			return nil, fmt.Errorf("%w: %d bytes needed, %d short on %s", ErrInsufficientDisk, demand.Total(), short, l.path)
+		}
+	}
+
+	r := &Reservation{ledger: l, taskID: taskID, estimates: demand.phases(), actuals: make(map[Phase]int64)}
+	l.mu.Lock()
+	l.reservations[taskID] = r
+	l.mu.Unlock()
+	return r, nil
+}
+
+// shortfall returns how many of need bytes do not fit beside the other
+// reservations and the free space kept
+func (l *Ledger) shortfall(need int64) (int64, error) {
+	free, err := l.freeDisk(l.path)
+	if err != nil {
+		return 0, err
+	}
+	available := int64(free) - l.outstanding() - l.minFree
+	if need <= available {
+		return 0, nil
+	}
+	return need - max(available, 0), nil
+}
+
+// outstanding is the bytes reserved and not yet settled
+func (l *Ledger) outstanding() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var total int64
+	for _, r := range l.reservations {
+		total += r.outstandingLocked()
+	}
+	return total
+}
+
+// Get returns the reservation of taskID, nil when it has none
+func (l *Ledger) Get(taskID string) *Reservation {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.reservations[taskID]
+}
+
+// Release drops the reservation of taskID, once it no longer writes
+func (l *Ledger) Release(taskID string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.reservations, taskID)
+}
+
+// Stats reports the ledger; it is safe on a nil ledger
+func (l *Ledger) Stats() Stats {
+	if l == nil {
+		return Stats{}
+	}
+	stats := Stats{Path: l.path, ReservedBytes: l.outstanding()}
+	if free, err := l.freeDisk(l.path); err == nil {
+		stats.FreeBytes = free
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats.Reservations = len(l.reservations)
+	stats.Rejected = l.rejected
+	stats.EvictedBytes = l.evicted
+	return stats
+}
+
+// Reservation is the space reserved for one task
+type Reservation struct {
+	ledger    *Ledger
+	taskID    string
+	estimates map[Phase]int64
+	actuals   map[Phase]int64
+}
+
+// Settle marks phase complete, having written actual bytes. Its estimate
+// stops being reserved, as the filesystem's free space now reflects what
+// it wrote. Settling a nil reservation does nothing.
+func (r *Reservation) Settle(phase Phase, actual int64) {
+	if r == nil {
+		return
+	}
+	r.ledger.mu.Lock()
+	defer r.ledger.mu.Unlock()
+	if _, ok := r.actuals[phase]; ok {
+		return
+	}
+	r.actuals[phase] = actual
+
+	log := gologger.WithComponent("diskreserve")
+	log.Debug().
+		Str("task_id", r.taskID).
+		Str("phase", string(phase)).
+		Int64("estimate_bytes", r.estimates[phase]).
+		Int64("actual_bytes", actual).
+		Msg("Disk reservation phase settled")
+}
+
+// Outstanding is the bytes still reserved for the task
+func (r *Reservation) Outstanding() int64 {
+	if r == nil {
+		return 0
+	}
+	r.ledger.mu.Lock()
+	defer r.ledger.mu.Unlock()
+	return r.outstandingLocked()
+}
+
+func (r *Reservation) outstandingLocked() int64 {
+	var total int64
+	for phase, estimate := range r.estimates {
+		if _, settled := r.actuals[phase]; !settled {
+			total += estimate
+		}
+	}
+	return total
+}
+
+type contextKey struct{}
+
+// With returns ctx carrying r, for the executor to settle its phases
+func With(ctx context.Context, r *Reservation) context.Context {
+	if r == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// From returns the reservation ctx carries, nil when it carries none
+func From(ctx context.Context) *Reservation {
+	r, _ := ctx.Value(contextKey{}).(*Reservation)
+	return r
+}
@@ -0,0 +1,141 @@
+package diskreserve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// disk is a filesystem whose free space the test sets
+type disk struct {
+	free atomic.Int64
+}
+
+func (d *disk) freeBytes(string) (uint64, error) {
+	return uint64(d.free.Load()), nil
+}
+
+func newTestLedger(t *testing.T, free, minFree int64, headroom float64) (*Ledger, *disk) {
+	t.Helper()
+	l, err := NewLedger(t.TempDir(), minFree, headroom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &disk{}
+	d.free.Store(free)
+	l.freeDisk = d.freeBytes
+	return l, d
+}
+
+func TestReserveNeverOvercommits(t *testing.T) {
+	l, _ := newTestLedger(t, 10_000, 1_000, 0)
+
+	var (
+		wg       sync.WaitGroup
+		admitted atomic.Int64
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := l.Reserve(context.Background(), fmt.Sprintf("task-%d", i), Demand{Image: 600, Scratch: 400})
+			switch {
+			case err == nil:
+				admitted.Add(1)
+			case !errors.Is(err, ErrInsufficientDisk):
+				t.Errorf("Reserve() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if admitted.Load() != 9 {
+		t.Fatalf("admitted %d tasks of 1000 bytes into 9000 spare, want 9", admitted.Load())
+	}
+	stats := l.Stats()
+	if stats.ReservedBytes != 9_000 || stats.Reservations != 9 || stats.Rejected != 41 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestSettleAndRelease(t *testing.T) {
+	l, d := newTestLedger(t, 10_000, 0, 0.5)
+
+	r, err := l.Reserve(context.Background(), "a", Demand{Image: 2_000, Inputs: 1_000, Scratch: 1_000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 4000 bytes plus half of them for results
+	if got := r.Outstanding(); got != 6_000 {
+		t.Fatalf("Outstanding() = %d, want 6000", got)
+	}
+	if _, err := l.Reserve(context.Background(), "b", Demand{Scratch: 5_000}); !errors.Is(err, ErrInsufficientDisk) {
+		t.Fatalf("Reserve() = %v beside a 6000 byte reservation, want ErrInsufficientDisk", err)
+	}
+
+	// The image turns out smaller than estimated; the disk now holds it
+	r.Settle(PhaseImage, 500)
+	d.free.Add(-500)
+	if got := r.Outstanding(); got != 4_000 {
+		t.Fatalf("Outstanding() after settling the image = %d, want 4000", got)
+	}
+	if _, err := l.Reserve(context.Background(), "b", Demand{Scratch: 3_000}); err != nil {
+		t.Fatalf("Reserve() = %v once the image is settled", err)
+	}
+
+	l.Release("a")
+	l.Release("b")
+	if stats := l.Stats(); stats.ReservedBytes != 0 || stats.Reservations != 0 {
+		t.Errorf("Stats() after release = %+v", stats)
+	}
+	if l.Get("a") != nil {
+		t.Error("Get() returns a released reservation")
+	}
+}
+
+// evictor frees space on its disk by evicting up to cached bytes
+type evictor struct {
+	disk   *disk
+	cached int64
+	asked  []int64
+}
+
+func (e *evictor) Evict(ctx context.Context, bytes int64) (int64, error) {
+	e.asked = append(e.asked, bytes)
+	freed := min(bytes, e.cached)
+	e.cached -= freed
+	e.disk.free.Add(freed)
+	return freed, nil
+}
+
+func TestReserveEvictsToFit(t *testing.T) {
+	l, d := newTestLedger(t, 5_000, 1_000, 0)
+	e := &evictor{disk: d, cached: 3_000}
+	l.SetEvictor(e)
+
+	if _, err := l.Reserve(context.Background(), "fits", Demand{Scratch: 2_000}); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.asked) != 0 {
+		t.Fatalf("evicted %v for a task that fit", e.asked)
+	}
+
+	// 2000 spare beside the first task; the caches give up the rest
+	if _, err := l.Reserve(context.Background(), "evicts", Demand{Image: 4_000}); err != nil {
+		t.Fatalf("Reserve() = %v with enough to evict", err)
+	}
+	if len(e.asked) != 1 || e.asked[0] != 2_000 {
+		t.Fatalf("asked to evict %v, want only the 2000 byte shortfall", e.asked)
+	}
+
+	// The caches have 1000 bytes left, short of the next task
+	if _, err := l.Reserve(context.Background(), "too-big", Demand{Scratch: 2_000}); !errors.Is(err, ErrInsufficientDisk) {
+		t.Fatalf("Reserve() = %v beyond what can be evicted, want ErrInsufficientDisk", err)
+	}
+	if stats := l.Stats(); stats.EvictedBytes != 3_000 || stats.Rejected != 1 {
+		t.Errorf("Stats() = %+v, want 3000 bytes evicted and one task rejected", stats)
+	}
+}
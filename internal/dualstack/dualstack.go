@@ -0,0 +1,54 @@
+// Package dualstack connects the runner to hosts over IPv4 and IPv6 alike,
+// so runners on IPv6-only and dual-stack networks reach the server, IPFS
+// gateways and registry mirrors as runners on IPv4 networks do.
+package dualstack
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FallbackDelay is how long a connection to a host's preferred address
+// gets before a connection over the other address family is raced against
+// it, as RFC 8305 recommends
+const FallbackDelay = 250 * time.Millisecond
+
+// Dialer returns a dialer racing IPv6 and IPv4 connections ("happy
+// eyeballs") to hosts with addresses of both families. Addresses are tried
+// in the order the host's routes prefer, so a host with no IPv4 route
+// tries IPv6 first.
+func Dialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:       30 * time.Second,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: FallbackDelay,
+	}
+}
+
+// Transport returns an HTTP transport with http.DefaultTransport's
+// settings dialing with Dialer
+func Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = Dialer().DialContext
+	return transport
+}
+
+// Client returns an HTTP client dialing with Dialer, giving up on requests
+// after timeout, never when zero
+func Client(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: Transport()}
+}
+
+// HostPort joins host and port, bracketing host when it is an IPv6
+// literal. A host already in brackets is not bracketed again.
+func HostPort(host string, port int) string {
+	return net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(host, "["), "]"), strconv.Itoa(port))
+}
+
+// URL returns the URL of port on host, such as http://[2001:db8::1]:8080
+func URL(scheme, host string, port int) string {
+	return scheme + "://" + HostPort(host, port)
+}
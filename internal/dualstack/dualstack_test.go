@@ -0,0 +1,99 @@
+package dualstack
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenIPv6 returns a listener on ::1, skipping the test on hosts without
+// IPv6 loopback
+func listenIPv6(t *testing.T) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback not available: %v", err)
+	}
+	return ln
+}
+
+func TestHostPort(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "example.com:8080"},
+		{"203.0.113.7", "203.0.113.7:8080"},
+		{"2001:db8::1", "[2001:db8::1]:8080"},
+		{"[2001:db8::1]", "[2001:db8::1]:8080"},
+		{"fe80::1%eth0", "[fe80::1%eth0]:8080"},
+	}
+	for _, tt := range tests {
+		if got := HostPort(tt.host, 8080); got != tt.want {
+			t.Errorf("HostPort(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+	if got := URL("http", "::1", 3000); got != "http://[::1]:3000" {
+		t.Errorf("URL() = %q", got)
+	}
+}
+
+func TestClientReachesIPv6Listener(t *testing.T) {
+	ln := listenIPv6(t)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.Host)
+	}))
+	server.Listener = ln
+	server.Start()
+	defer server.Close()
+
+	port := ln.Addr().(*net.TCPAddr).Port
+	resp, err := Client(5 * time.Second).Get(URL("http", "::1", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+}
+
+func TestProbeIPv6Only(t *testing.T) {
+	ln := listenIPv6(t)
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	r, err := Probe(context.Background(), URL("http", "::1", port), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.IPv6.Reachable || r.IPv4.Reachable || len(r.IPv4.Addresses) != 0 {
+		t.Fatalf("reachability %+v, want reachable over IPv6 only", r)
+	}
+	if r.Host != "::1" || r.Port != strconv.Itoa(port) {
+		t.Fatalf("host %q port %q", r.Host, r.Port)
+	}
+
+	ln.Close()
+	r, err = Probe(context.Background(), URL("http", "::1", port), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.IPv6.Reachable || r.IPv6.Error == "" {
+		t.Fatalf("reachability %+v, want IPv6 unreachable once the listener closed", r)
+	}
+}
+
+func TestProbeRejectsNonURLs(t *testing.T) {
+	if _, err := Probe(context.Background(), "not a url", time.Second); err == nil {
+		t.Fatal("want an error for a target that is not a URL")
+	}
+	if _, err := Probe(context.Background(), "ftp://[::1]", time.Second); err == nil {
+		t.Fatal("want an error for a target naming no port")
+	}
+}
@@ -0,0 +1,84 @@
+package dualstack
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Reachability is how the host of a URL is reached over each address
+// family
+type Reachability struct {
+	Target string `json:"target"`
+	Host   string `json:"host"`
+	Port   string `json:"port"`
+	IPv4   Family `json:"ipv4"`
+	IPv6   Family `json:"ipv6"`
+}
+
+// Family is how a host is reached over one address family
+type Family struct {
+	// Addresses are what the host resolves to in the family
+	Addresses []string `json:"addresses,omitempty"`
+	// Reachable is set when a TCP connection to one of them succeeded
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Probe resolves the host of target, a URL, and connects to its port over
+// IPv4 and over IPv6 separately, each connection given timeout. Errors are
+// returned only for targets that are not URLs or whose host does not
+// resolve at all.
+func Probe(ctx context.Context, target string, timeout time.Duration) (*Reachability, error) {
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Host == "" {
+		return nil, fmt.Errorf("%q is not a URL", target)
+	}
+	r := &Reachability{Target: target, Host: parsed.Hostname(), Port: parsed.Port()}
+	if r.Port == "" {
+		switch parsed.Scheme {
+		case "http", "ws":
+			r.Port = "80"
+		case "https", "wss":
+			r.Port = "443"
+		default:
+			return nil, fmt.Errorf("%q names no port", target)
+		}
+	}
+
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, r.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", r.Host, err)
+	}
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			r.IPv4.Addresses = append(r.IPv4.Addresses, addr.String())
+		} else {
+			r.IPv6.Addresses = append(r.IPv6.Addresses, addr.String())
+		}
+	}
+	r.IPv4.probe(ctx, "tcp4", "A", r.Port, timeout)
+	r.IPv6.probe(ctx, "tcp6", "AAAA", r.Port, timeout)
+	return r, nil
+}
+
+// probe connects to the family's addresses in turn until one accepts
+func (f *Family) probe(ctx context.Context, network, record, port string, timeout time.Duration) {
+	if len(f.Addresses) == 0 {
+		f.Error = "no " + record + " records"
+		return
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	for _, addr := range f.Addresses {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+		if err == nil {
+			conn.Close()
+			f.Reachable = true
+			f.Error = ""
+			return
+		}
+		f.Error = err.Error()
+	}
+}
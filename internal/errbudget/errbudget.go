@@ -0,0 +1,300 @@
+// Package errbudget pauses the task types a runner keeps failing through
+// faults of its own, such as a misconfigured container runtime, before the
+// failures cost it its reputation. The outcomes of each task type are kept
+// over a rolling window; once the failures the runner caused, told apart
+// from those the task caused by their failure codes, use up the type's
+// budget, the type is paused. A paused type resumes once its cool-down has
+// passed and a canary of what it needs passes.
+package errbudget
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// Policy is the error budget of every task type
+type Policy struct {
+	// Window is how long outcomes count for
+	Window time.Duration
+	// MinFailures is the fewest failures the runner caused within the
+	// window that pause a type, so that a single failure never does
+	MinFailures int
+	// MaxFailureRate is the share of a type's outcomes within the window
+	// the runner may fail before the type is paused
+	MaxFailureRate float64
+	// Cooldown is how long a type stays paused before its canary is tried,
+	// and again after each canary that failed
+	Cooldown time.Duration
+}
+
+type outcome struct {
+	at           time.Time
+	runnerCaused bool
+}
+
+type typeState struct {
+	outcomes    []outcome
+	lastCode    models.FailureCode
+	paused      bool
+	pausedAt    time.Time
+	resumeAfter time.Time
+	diagnosis   []string
+}
+
+// Guard tracks the error budget of each task type the runner runs
+type Guard struct {
+	policy   Policy
+	clock    clock.Clock
+	probe    func(ctx context.Context, taskType models.TaskType) error
+	diagnose func(ctx context.Context, taskType models.TaskType) []string
+	onChange func(models.ErrorBudgetStatus)
+
+	mu    sync.Mutex
+	types map[models.TaskType]*typeState
+}
+
+// New returns a guard holding task types to policy
+func New(policy Policy) (*Guard, error) {
+	if policy.Window <= 0 {
+		return nil, fmt.Errorf("error budget window must be positive, got %s", policy.Window)
+	}
+	if policy.MinFailures < 1 {
+		return nil, fmt.Errorf("error budget minimum failures must be at least 1, got %d", policy.MinFailures)
+	}
+	if policy.MaxFailureRate <= 0 || policy.MaxFailureRate > 1 {
+		return nil, fmt.Errorf("error budget failure rate must be within (0, 1], got %g", policy.MaxFailureRate)
+	}
+	return &Guard{
+		policy: policy,
+		clock:  clock.Real(),
+		types:  make(map[models.TaskType]*typeState),
+	}, nil
+}
+
+// SetClock replaces the clock windows and cool-downs are measured with
+func (g *Guard) SetClock(c clock.Clock) {
+	g.clock = c
+}
+
+// SetProbe sets the canary a paused type must pass to resume. Without one,
+// types resume once their cool-down has passed.
+func (g *Guard) SetProbe(probe func(ctx context.Context, taskType models.TaskType) error) {
+	g.probe = probe
+}
+
+// SetDiagnose sets the checks run when a type is paused, whose findings
+// are kept in its status
+func (g *Guard) SetDiagnose(diagnose func(ctx context.Context, taskType models.TaskType) []string) {
+	g.diagnose = diagnose
+}
+
+// OnChange calls fn each time a type is paused or resumed, with its status
+// once changed
+func (g *Guard) OnChange(fn func(models.ErrorBudgetStatus)) {
+	g.onChange = fn
+}
+
+// Succeeded records a task of taskType that succeeded
+func (g *Guard) Succeeded(taskType models.TaskType) {
+	g.record(taskType, "")
+}
+
+// Failed records a task of taskType that failed as code. Only failures the
+// runner caused use up the type's budget.
+func (g *Guard) Failed(taskType models.TaskType, code models.FailureCode) {
+	if code == "" {
+		code = models.FailureUnknown
+	}
+	g.record(taskType, code)
+}
+
+func (g *Guard) record(taskType models.TaskType, code models.FailureCode) {
+	now := g.clock.Now()
+	g.mu.Lock()
+	state, ok := g.types[taskType]
+	if !ok {
+		state = &typeState{}
+		g.types[taskType] = state
+	}
+	state.outcomes = append(g.prune(state.outcomes, now), outcome{at: now, runnerCaused: code.RunnerCaused()})
+	if code != "" {
+		state.lastCode = code
+	}
+	failures, rate := tally(state.outcomes)
+	if state.paused || failures < g.policy.MinFailures || rate < g.policy.MaxFailureRate {
+		g.mu.Unlock()
+		return
+	}
+	state.paused = true
+	state.pausedAt = now
+	state.resumeAfter = now.Add(g.policy.Cooldown)
+	state.diagnosis = nil
+	g.mu.Unlock()
+
+	log := gologger.WithComponent("error_budget")
+	log.Error().
+		Str("type", string(taskType)).
+		Int("runner_failures", failures).
+		Float64("failure_rate", rate).
+		Str("last_code", string(code)).
+		Dur("cooldown", g.policy.Cooldown).
+		Msg("Error budget used up, pausing task type")
+
+	if g.diagnose != nil {
+		diagnosis := g.diagnose(context.Background(), taskType)
+		g.mu.Lock()
+		state.diagnosis = diagnosis
+		g.mu.Unlock()
+	}
+	g.changed(taskType)
+}
+
+// prune drops outcomes older than the window
+func (g *Guard) prune(outcomes []outcome, now time.Time) []outcome {
+	cutoff := now.Add(-g.policy.Window)
+	kept := outcomes[:0]
+	for _, o := range outcomes {
+		if o.at.After(cutoff) {
+			kept = append(kept, o)
+		}
+	}
+	return kept
+}
+
+// tally returns how many outcomes the runner failed and their share
+func tally(outcomes []outcome) (int, float64) {
+	failures := 0
+	for _, o := range outcomes {
+		if o.runnerCaused {
+			failures++
+		}
+	}
+	if len(outcomes) == 0 {
+		return 0, 0
+	}
+	return failures, float64(failures) / float64(len(outcomes))
+}
+
+// Run tries to resume paused types every interval until ctx is done
+func (g *Guard) Run(ctx context.Context, interval time.Duration) {
+	ticker := g.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			g.ResumeDue(ctx)
+		}
+	}
+}
+
+// ResumeDue tries the canary of each paused type whose cool-down has
+// passed, resuming those that pass and pausing the others for another
+// cool-down
+func (g *Guard) ResumeDue(ctx context.Context) {
+	now := g.clock.Now()
+	g.mu.Lock()
+	var due []models.TaskType
+	for taskType, state := range g.types {
+		if state.paused && !now.Before(state.resumeAfter) {
+			due = append(due, taskType)
+		}
+	}
+	g.mu.Unlock()
+	sort.Slice(due, func(i, j int) bool { return due[i] < due[j] })
+
+	log := gologger.WithComponent("error_budget")
+	for _, taskType := range due {
+		var err error
+		if g.probe != nil {
+			err = g.probe(ctx, taskType)
+		}
+
+		g.mu.Lock()
+		state := g.types[taskType]
+		if err != nil {
+			state.resumeAfter = g.clock.Now().Add(g.policy.Cooldown)
+			g.mu.Unlock()
+			log.Warn().Err(err).
+				Str("type", string(taskType)).
+				Dur("cooldown", g.policy.Cooldown).
+				Msg("Canary failed, keeping task type paused")
+			continue
+		}
+		pausedFor := g.clock.Now().Sub(state.pausedAt)
+		*state = typeState{lastCode: state.lastCode}
+		g.mu.Unlock()
+
+		log.Info().
+			Str("type", string(taskType)).
+			Dur("paused_for", pausedFor).
+			Msg("Canary passed, resuming task type")
+		g.changed(taskType)
+	}
+}
+
+func (g *Guard) changed(taskType models.TaskType) {
+	if g.onChange == nil {
+		return
+	}
+	g.mu.Lock()
+	status := g.status(taskType, g.types[taskType], g.clock.Now())
+	g.mu.Unlock()
+	g.onChange(status)
+}
+
+// Paused reports whether taskType is paused. A nil guard pauses nothing.
+func (g *Guard) Paused(taskType models.TaskType) bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	state, ok := g.types[taskType]
+	return ok && state.paused
+}
+
+// Status returns the error budget of every type run so far, by type
+func (g *Guard) Status() []models.ErrorBudgetStatus {
+	if g == nil {
+		return nil
+	}
+	now := g.clock.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	statuses := make([]models.ErrorBudgetStatus, 0, len(g.types))
+	for taskType, state := range g.types {
+		statuses = append(statuses, g.status(taskType, state, now))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Type < statuses[j].Type })
+	return statuses
+}
+
+func (g *Guard) status(taskType models.TaskType, state *typeState, now time.Time) models.ErrorBudgetStatus {
+	state.outcomes = g.prune(state.outcomes, now)
+	failures, rate := tally(state.outcomes)
+	status := models.ErrorBudgetStatus{
+		Type:           taskType,
+		Paused:         state.paused,
+		Outcomes:       len(state.outcomes),
+		RunnerFailures: failures,
+		FailureRate:    rate,
+		LastCode:       state.lastCode,
+		Diagnosis:      state.diagnosis,
+	}
+	if state.paused {
+		pausedAt, resumeAfter := state.pausedAt, state.resumeAfter
+		status.PausedAt = &pausedAt
+		status.ResumeAfter = &resumeAfter
+	}
+	return status
+}
@@ -0,0 +1,131 @@
+package errbudget
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func newTestGuard(t *testing.T) (*Guard, *clocktest.Fake, *[]models.ErrorBudgetStatus) {
+	t.Helper()
+	guard, err := New(Policy{Window: time.Hour, MinFailures: 3, MaxFailureRate: 0.5, Cooldown: 10 * time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clocktest.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	guard.SetClock(clk)
+	var changes []models.ErrorBudgetStatus
+	guard.OnChange(func(status models.ErrorBudgetStatus) { changes = append(changes, status) })
+	return guard, clk, &changes
+}
+
+func TestOnlyRunnerFailuresUseBudget(t *testing.T) {
+	guard, clk, changes := newTestGuard(t)
+	docker := models.TaskTypeDocker
+
+	// Tasks failing by their own fault never pause the type
+	for i := 0; i < 10; i++ {
+		guard.Failed(docker, models.FailureTaskExit)
+		guard.Failed(docker, models.FailureInvalidConfig)
+		guard.Failed(docker, "")
+	}
+	if guard.Paused(docker) {
+		t.Fatal("task-caused failures paused the type")
+	}
+
+	// Runner failures among many task failures stay under the rate
+	for i := 0; i < 5; i++ {
+		guard.Failed(docker, models.FailureContainerRuntime)
+	}
+	if guard.Paused(docker) || len(*changes) != 0 {
+		t.Fatalf("status = %+v, want the type still claimed below the failure rate", guard.Status())
+	}
+
+	// Once the task failures leave the window, runner failures dominate
+	clk.Advance(2 * time.Hour)
+	guard.Succeeded(docker)
+	guard.Failed(docker, models.FailureContainerRuntime)
+	guard.Failed(docker, models.FailureTaskExit)
+	guard.Failed(docker, models.FailureScratch)
+	if guard.Paused(docker) {
+		t.Fatal("type paused below the minimum failures")
+	}
+	guard.Failed(docker, models.FailureContainerRuntime)
+	if !guard.Paused(docker) || guard.Paused(models.TaskTypeCommand) {
+		t.Fatalf("status = %+v, want only docker paused", guard.Status())
+	}
+	if len(*changes) != 1 {
+		t.Fatalf("changes = %+v, want one pause", *changes)
+	}
+	paused := (*changes)[0]
+	if !paused.Paused || paused.RunnerFailures != 3 || paused.Outcomes != 5 || paused.LastCode != models.FailureContainerRuntime || paused.ResumeAfter == nil {
+		t.Errorf("pause = %+v, want 3 runner failures of 5 outcomes", paused)
+	}
+}
+
+func TestResumeAfterCooldownAndCanary(t *testing.T) {
+	guard, clk, changes := newTestGuard(t)
+	canaryErr := errors.New("daemon unreachable")
+	var probed []models.TaskType
+	guard.SetProbe(func(ctx context.Context, taskType models.TaskType) error {
+		probed = append(probed, taskType)
+		return canaryErr
+	})
+	guard.SetDiagnose(func(ctx context.Context, taskType models.TaskType) []string {
+		return []string{"runtime:docker: cannot connect"}
+	})
+	for i := 0; i < 3; i++ {
+		guard.Failed(models.TaskTypeDocker, models.FailureContainerRuntime)
+	}
+	if status := guard.Status(); len(status) != 1 || len(status[0].Diagnosis) != 1 {
+		t.Fatalf("status = %+v, want the diagnosis kept", status)
+	}
+
+	// No canary is tried within the cool-down
+	clk.Advance(5 * time.Minute)
+	guard.ResumeDue(context.Background())
+	if len(probed) != 0 {
+		t.Fatal("canary tried within the cool-down")
+	}
+
+	// A failing canary starts another cool-down
+	clk.Advance(5 * time.Minute)
+	guard.ResumeDue(context.Background())
+	if len(probed) != 1 || !guard.Paused(models.TaskTypeDocker) {
+		t.Fatalf("probed %v, want the type kept paused after its canary failed", probed)
+	}
+	clk.Advance(5 * time.Minute)
+	guard.ResumeDue(context.Background())
+	if len(probed) != 1 {
+		t.Fatal("canary tried again within the new cool-down")
+	}
+
+	canaryErr = nil
+	clk.Advance(5 * time.Minute)
+	guard.ResumeDue(context.Background())
+	if guard.Paused(models.TaskTypeDocker) || len(*changes) != 2 || (*changes)[1].Paused {
+		t.Fatalf("changes = %+v, want the type resumed once its canary passed", *changes)
+	}
+
+	// The failures that paused it no longer count
+	guard.Failed(models.TaskTypeDocker, models.FailureContainerRuntime)
+	if guard.Paused(models.TaskTypeDocker) {
+		t.Error("resumed type paused again by a single failure")
+	}
+}
+
+func TestPolicyValidation(t *testing.T) {
+	for _, policy := range []Policy{
+		{Window: 0, MinFailures: 1, MaxFailureRate: 0.5},
+		{Window: time.Hour, MinFailures: 0, MaxFailureRate: 0.5},
+		{Window: time.Hour, MinFailures: 1, MaxFailureRate: 1.5},
+	} {
+		if _, err := New(policy); err == nil {
+			t.Errorf("New(%+v) accepted an invalid policy", policy)
+		}
+	}
+}
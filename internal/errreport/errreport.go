@@ -0,0 +1,331 @@
+// Package errreport shares the errors a runner hits so that operators can
+// see why runners fail across the fleet. Events are anonymised, fingerprinted
+// so repeats of the same failure collapse into one event with a count,
+// rate-limited and sent in batches to a sink: the server, or a local file
+// for self-hosted aggregation.
+package errreport
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/provenance"
+)
+
+// maxQueued bounds the events waiting to be sent while the sink is
+// unreachable; the oldest are dropped first
+const maxQueued = 1000
+
+// Categories of the errors the runner reports
+const (
+	CategoryExecution         = "execution"
+	CategoryClaim             = "claim"
+	CategoryStatus            = "status"
+	CategoryAttestation       = "attestation"
+	CategoryFederatedLearning = "federated_learning"
+	CategoryHeartbeat         = "heartbeat"
+)
+
+// Event is an error as reported by the runner, before redaction
+type Event struct {
+	Code     string
+	Category string
+	TaskType models.TaskType
+	Err      error
+	Context  map[string]string
+	// Sensitive lists values, such as the strings of a task's payload, that
+	// are scrubbed from the message and context wherever they appear
+	Sensitive []string
+}
+
+// Sink receives batches of redacted events
+type Sink interface {
+	SendErrors(report *models.ErrorReport) error
+}
+
+// Config controls which events are reported and how often
+type Config struct {
+	// Categories lists the categories reported; empty reports all
+	Categories    []string
+	DedupWindow   time.Duration
+	RateLimit     int
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// tracked is the deduplication state of one fingerprint
+type tracked struct {
+	windowStart time.Time
+	// pending is the queued event repeats are counted into, nil once sent
+	pending *models.ErrorEvent
+	// sent is the event last sent, and suppressed counts the repeats seen
+	// after it was sent, until lastSeen
+	sent       *models.ErrorEvent
+	suppressed int
+	lastSeen   time.Time
+}
+
+// Reporter deduplicates, rate-limits and batches error events
+type Reporter struct {
+	cfg      Config
+	sink     Sink
+	clock    clock.Clock
+	redactor *redactor
+	version  string
+	flush    chan struct{}
+
+	mu       sync.Mutex
+	seen     map[string]*tracked
+	queue    []*models.ErrorEvent
+	dropped  int
+	tokens   float64
+	refilled time.Time
+}
+
+func NewReporter(cfg Config, sink Sink) *Reporter {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 50
+	}
+	if cfg.RateLimit <= 0 {
+		cfg.RateLimit = 30
+	}
+	return &Reporter{
+		cfg:      cfg,
+		sink:     sink,
+		clock:    clock.Real(),
+		redactor: newRedactor(),
+		version:  provenance.Version(),
+		flush:    make(chan struct{}, 1),
+		seen:     make(map[string]*tracked),
+		tokens:   float64(cfg.RateLimit),
+	}
+}
+
+// SetClock replaces the clock used for deduplication windows, the rate
+// limit and flushing
+func (r *Reporter) SetClock(c clock.Clock) {
+	r.clock = c
+}
+
+// AddSecrets scrubs values, such as credentials the runner holds, from every
+// event
+func (r *Reporter) AddSecrets(values ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.redactor.secrets = append(r.redactor.secrets, values...)
+}
+
+// Fingerprint identifies an error independently of the task, time or
+// values it involved: the same failure anywhere in the fleet shares one
+func Fingerprint(category, code string, taskType models.TaskType, message string) string {
+	h := sha256.New()
+	for _, part := range []string{category, code, string(taskType), message} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (r *Reporter) allowed(category string) bool {
+	return len(r.cfg.Categories) == 0 || slices.Contains(r.cfg.Categories, category)
+}
+
+// take spends one rate limit token, refilling RateLimit tokens a minute
+func (r *Reporter) take(now time.Time) bool {
+	if !r.refilled.IsZero() {
+		elapsed := now.Sub(r.refilled)
+		r.tokens += elapsed.Minutes() * float64(r.cfg.RateLimit)
+		if r.tokens > float64(r.cfg.RateLimit) {
+			r.tokens = float64(r.cfg.RateLimit)
+		}
+	}
+	r.refilled = now
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
+
+// Report queues event unless its category is filtered out. A repeat within
+// the deduplication window only increments the count of the event already
+// reported; new events beyond the rate limit are dropped and counted.
+func (r *Reporter) Report(event Event) {
+	if r == nil || !r.allowed(event.Category) {
+		return
+	}
+	var message string
+	if event.Err != nil {
+		message = event.Err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	message = r.redactor.redact(message, event.Sensitive)
+	fingerprint := Fingerprint(event.Category, event.Code, event.TaskType, message)
+
+	t := r.seen[fingerprint]
+	if t != nil && now.Sub(t.windowStart) < r.cfg.DedupWindow {
+		if t.pending != nil {
+			t.pending.Count++
+			t.pending.LastSeen = now
+		} else {
+			t.suppressed++
+			t.lastSeen = now
+		}
+		return
+	}
+	if !r.take(now) {
+		r.dropped++
+		return
+	}
+
+	reported := &models.ErrorEvent{
+		Fingerprint:   fingerprint,
+		Code:          event.Code,
+		Category:      event.Category,
+		TaskType:      event.TaskType,
+		RunnerVersion: r.version,
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		Message:       message,
+		Context:       r.redactor.context(event.Context, event.Sensitive),
+		Count:         1,
+		FirstSeen:     now,
+		LastSeen:      now,
+	}
+	if t != nil {
+		// Repeats suppressed in the previous window are reported with the
+		// first occurrence of the next
+		reported.Count += t.suppressed
+	}
+	r.enqueue(reported)
+	r.seen[fingerprint] = &tracked{windowStart: now, pending: reported}
+}
+
+func (r *Reporter) enqueue(event *models.ErrorEvent) {
+	r.queue = append(r.queue, event)
+	if len(r.queue) > maxQueued {
+		r.queue = r.queue[len(r.queue)-maxQueued:]
+		r.dropped++
+	}
+	if len(r.queue) >= r.cfg.BatchSize {
+		select {
+		case r.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Flush sends the queued events in batches. Events a failed send could not
+// deliver stay queued for the next flush.
+func (r *Reporter) Flush() error {
+	for {
+		r.mu.Lock()
+		r.prune(r.clock.Now())
+		if len(r.queue) == 0 && r.dropped == 0 {
+			r.mu.Unlock()
+			return nil
+		}
+		n := min(len(r.queue), r.cfg.BatchSize)
+		batch := r.queue[:n]
+		r.queue = r.queue[n:]
+		report := &models.ErrorReport{Events: make([]models.ErrorEvent, 0, n), Dropped: r.dropped}
+		r.dropped = 0
+		for _, event := range batch {
+			if t := r.seen[event.Fingerprint]; t != nil && t.pending == event {
+				t.pending, t.sent = nil, event
+			}
+			report.Events = append(report.Events, *event)
+		}
+		r.mu.Unlock()
+
+		if err := r.sink.SendErrors(report); err != nil {
+			r.requeue(batch, report.Dropped)
+			return err
+		}
+	}
+}
+
+// requeue puts back a batch that could not be sent. Repeats counted as
+// suppressed meanwhile are folded back into the events.
+func (r *Reporter) requeue(batch []*models.ErrorEvent, dropped int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range batch {
+		if t := r.seen[event.Fingerprint]; t != nil && t.sent == event {
+			t.pending, t.sent = event, nil
+			event.Count += t.suppressed
+			if t.suppressed > 0 {
+				event.LastSeen = t.lastSeen
+			}
+			t.suppressed = 0
+		}
+	}
+	r.queue = append(batch, r.queue...)
+	if len(r.queue) > maxQueued {
+		dropped += len(r.queue) - maxQueued
+		r.queue = r.queue[len(r.queue)-maxQueued:]
+	}
+	r.dropped += dropped
+}
+
+// prune forgets fingerprints whose window has passed. Repeats suppressed
+// during the window are queued as a final count so they are not lost.
+func (r *Reporter) prune(now time.Time) {
+	for fingerprint, t := range r.seen {
+		if t.pending != nil || now.Sub(t.windowStart) < r.cfg.DedupWindow {
+			continue
+		}
+		if t.suppressed > 0 && t.sent != nil {
+			repeats := *t.sent
+			repeats.Count = t.suppressed
+			repeats.FirstSeen = t.windowStart
+			repeats.LastSeen = t.lastSeen
+			r.enqueue(&repeats)
+		}
+		delete(r.seen, fingerprint)
+	}
+}
+
+// Run flushes every FlushInterval, and as soon as a batch fills, until ctx
+// ends, then flushes once more
+func (r *Reporter) Run(ctx context.Context) {
+	log := gologger.WithComponent("errreport")
+
+	interval := r.cfg.FlushInterval
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := r.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	flush := func() {
+		// Failures are only logged; reporting them would feed the queue
+		if err := r.Flush(); err != nil {
+			log.Debug().Err(err).Msg("Failed to send error reports, will retry")
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			flush()
+			return
+		case <-ticker.C():
+			flush()
+		case <-r.flush:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package errreport
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// FileSink appends events to a file as JSON lines, for local-only reporting
+// and self-hosted aggregation
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (s *FileSink) SendErrors(report *models.ErrorReport) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return fmt.Errorf("failed to create error report directory: %w", err)
+	}
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open error report file: %w", err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, event := range report.Events {
+		if err := enc.Encode(event); err != nil {
+			return fmt.Errorf("failed to write error report: %w", err)
+		}
+	}
+	if report.Dropped > 0 {
+		if err := enc.Encode(map[string]int{"dropped": report.Dropped}); err != nil {
+			return fmt.Errorf("failed to write error report: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package errreport
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxValueLength bounds redacted messages and context values, so that long
+// errors that quote command output or response bodies are cut short
+const maxValueLength = 256
+
+// minSensitiveLength is the shortest sensitive value scrubbed; shorter ones
+// would match ordinary words
+const minSensitiveLength = 4
+
+// secretKeyPattern matches context keys whose values are dropped outright
+var secretKeyPattern = regexp.MustCompile(`(?i)(key|secret|token|passw|auth|credential|mnemonic|seed|cookie|signature|private)`)
+
+// replacements anonymise a message, most specific first. Numbers are
+// replaced too, so that the same failure fingerprints alike whatever task,
+// port or size it involved.
+var replacements = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+\S+`), "$1 <redacted>"},
+	{regexp.MustCompile(`(?i)\b([a-z_-]*(?:key|secret|token|passw(?:or)?d?|auth|credential|mnemonic|signature)[a-z_-]*)(["']?\s*[=:]\s*["']?)[^\s"',;&]+`), "$1$2<redacted>"},
+	{regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://)[^/\s@]*@`), "$1"},
+	{regexp.MustCompile(`(https?://[^\s?#"']*)[?#][^\s"']*`), "$1"},
+	{regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`), "<email>"},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]{40}\b`), "<address>"},
+	{regexp.MustCompile(`\b(0x)?[0-9a-fA-F]{16,}\b`), "<hex>"},
+	{regexp.MustCompile(`[A-Za-z0-9+_=-]{24,}`), "<token>"},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}\b`), "<ip>"},
+	{regexp.MustCompile(`\d+`), "<n>"},
+}
+
+// redactor anonymises strings, scrubbing the runner's own secrets and any
+// sensitive values an event names wherever they appear
+type redactor struct {
+	secrets []string
+	home    string
+}
+
+func newRedactor() *redactor {
+	home, _ := os.UserHomeDir()
+	return &redactor{home: home}
+}
+
+// redact returns the first line of s, anonymised and truncated
+func (r *redactor) redact(s string, sensitive []string) string {
+	if line, _, cut := strings.Cut(s, "\n"); cut {
+		s = line
+	}
+
+	// Longest first, so that a value containing another is scrubbed whole
+	values := append(append([]string(nil), r.secrets...), sensitive...)
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	for _, value := range values {
+		if len(value) >= minSensitiveLength {
+			s = strings.ReplaceAll(s, value, "<redacted>")
+		}
+	}
+
+	if r.home != "" && r.home != "/" {
+		s = strings.ReplaceAll(s, r.home, "~")
+	}
+	for _, rep := range replacements {
+		s = rep.pattern.ReplaceAllString(s, rep.replacement)
+	}
+	return truncate(strings.TrimSpace(s))
+}
+
+// context redacts the values of ctx and drops entries whose keys suggest a
+// secret
+func (r *redactor) context(ctx map[string]string, sensitive []string) map[string]string {
+	if len(ctx) == 0 {
+		return nil
+	}
+	redacted := make(map[string]string, len(ctx))
+	for key, value := range ctx {
+		if secretKeyPattern.MatchString(key) {
+			continue
+		}
+		redacted[key] = r.redact(value, sensitive)
+	}
+	return redacted
+}
+
+func truncate(s string) string {
+	if len(s) <= maxValueLength {
+		return s
+	}
+	cut := maxValueLength
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
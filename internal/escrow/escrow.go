@@ -0,0 +1,199 @@
+// Package escrow keeps what a runner delivered provable after it deleted
+// it. A task asking for escrow has its result bundle sealed once with a
+// fresh data key, the data key encrypted to the creator's key and to the
+// network's escrow key, and the envelope signed by the runner's wallet and
+// uploaded to IPFS. The runner keeps only the envelope's CID and hashes;
+// either key holder can open the envelope and re-derive the bundle hash the
+// result reported.
+package escrow
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/crypto/ecies"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+// Version is the envelope format this runner writes and reads
+const Version = 1
+
+var (
+	ErrNotRecipient = errors.New("key is not a recipient of the envelope")
+	ErrTampered     = errors.New("envelope does not match its signed hashes")
+)
+
+// Recipient is the data key encrypted to one recipient's key
+type Recipient struct {
+	Address string `json:"address"`
+	Key     []byte `json:"key"`
+}
+
+// Envelope is a sealed result bundle
+type Envelope struct {
+	Version    int         `json:"version"`
+	TaskID     string      `json:"task_id"`
+	BundleHash string      `json:"bundle_hash"`
+	Recipients []Recipient `json:"recipients"`
+	Nonce      []byte      `json:"nonce"`
+	Ciphertext []byte      `json:"ciphertext"`
+	// Runner signed the task ID, bundle hash and ciphertext hash
+	Runner    string `json:"runner"`
+	Signature string `json:"signature"`
+}
+
+// Bundle encodes result as the bundle escrowed for it, leaving out the
+// escrow report that will point at the bundle
+func Bundle(result *models.TaskResult) ([]byte, error) {
+	bundled := *result
+	bundled.Escrow = nil
+	data, err := json.Marshal(&bundled)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode result bundle: %w", err)
+	}
+	return data, nil
+}
+
+// Hash returns the hex SHA-256 of data
+func Hash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// ParsePublicKey parses a hex secp256k1 public key, compressed or not
+func ParsePublicKey(s string) (*ecdsa.PublicKey, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(s, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	if len(raw) == 33 {
+		return crypto.DecompressPubkey(raw)
+	}
+	return crypto.UnmarshalPubkey(raw)
+}
+
+// Seal encrypts bundle of taskID to every one of recipients and signs the
+// envelope with s
+func Seal(taskID string, bundle []byte, recipients []*ecdsa.PublicKey, s signer.Signer) (*Envelope, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("escrow needs a recipient")
+	}
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	env := &Envelope{
+		Version:    Version,
+		TaskID:     taskID,
+		BundleHash: Hash(bundle),
+		Nonce:      make([]byte, gcm.NonceSize()),
+		Runner:     s.Address().Hex(),
+	}
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	env.Ciphertext = gcm.Seal(nil, env.Nonce, bundle, env.additionalData())
+
+	for _, pub := range recipients {
+		key, err := ecies.Encrypt(rand.Reader, ecies.ImportECDSAPublic(pub), dataKey, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt data key: %w", err)
+		}
+		env.Recipients = append(env.Recipients, Recipient{Address: crypto.PubkeyToAddress(*pub).Hex(), Key: key})
+	}
+
+	signature, err := s.SignHash(env.signedHash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign escrow envelope: %w", err)
+	}
+	env.Signature = hex.EncodeToString(signature)
+	return env, nil
+}
+
+// Verify checks the envelope's signature, returning the runner that
+// sealed it. It does not need a recipient's key.
+func (e *Envelope) Verify() (common.Address, error) {
+	if e.Version != Version {
+		return common.Address{}, fmt.Errorf("unsupported envelope version %d", e.Version)
+	}
+	signature, err := hex.DecodeString(e.Signature)
+	if err != nil || len(signature) != crypto.SignatureLength {
+		return common.Address{}, fmt.Errorf("%w: malformed signature", ErrTampered)
+	}
+	pub, err := crypto.SigToPub(e.signedHash(), signature)
+	if err != nil {
+		return common.Address{}, fmt.Errorf("%w: %v", ErrTampered, err)
+	}
+	signer := crypto.PubkeyToAddress(*pub)
+	if !common.IsHexAddress(e.Runner) || common.HexToAddress(e.Runner) != signer {
+		return common.Address{}, fmt.Errorf("%w: signed by %s, not %s", ErrTampered, signer.Hex(), e.Runner)
+	}
+	return signer, nil
+}
+
+// Open decrypts the bundle with a recipient's key, checking it against the
+// bundle hash
+func (e *Envelope) Open(key *ecdsa.PrivateKey) ([]byte, error) {
+	address := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	for _, recipient := range e.Recipients {
+		if recipient.Address != address {
+			continue
+		}
+		dataKey, err := ecies.ImportECDSA(key).Decrypt(recipient.Key, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+		}
+		gcm, err := newGCM(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		bundle, err := gcm.Open(nil, e.Nonce, e.Ciphertext, e.additionalData())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrTampered, err)
+		}
+		if Hash(bundle) != e.BundleHash {
+			return nil, fmt.Errorf("%w: bundle hash differs", ErrTampered)
+		}
+		return bundle, nil
+	}
+	return nil, ErrNotRecipient
+}
+
+// additionalData binds the ciphertext to the task and bundle hash
+func (e *Envelope) additionalData() []byte {
+	return []byte(e.TaskID + "\n" + e.BundleHash)
+}
+
+func (e *Envelope) signedHash() []byte {
+	recipients := make([]string, len(e.Recipients))
+	for i, recipient := range e.Recipients {
+		recipients[i] = recipient.Address
+	}
+	return crypto.Keccak256([]byte(fmt.Sprintf("parity-escrow\n%d\n%s\n%s\n%s\n%s",
+		e.Version, e.TaskID, e.BundleHash, Hash(e.Ciphertext), strings.Join(recipients, ","))))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,296 @@
+package escrow
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newSigner(t *testing.T) *signer.Local {
+	t.Helper()
+	s, err := signer.FromECDSA(newKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func publicHex(key *ecdsa.PrivateKey) string {
+	return hex.EncodeToString(crypto.CompressPubkey(&key.PublicKey))
+}
+
+// gateway serves what is uploaded through it by CID
+type gateway struct {
+	*httptest.Server
+	mu      sync.Mutex
+	uploads map[string][]byte
+}
+
+func newGateway(t *testing.T) *gateway {
+	t.Helper()
+	g := &gateway{uploads: make(map[string][]byte)}
+	g.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mu.Lock()
+		data, ok := g.uploads[strings.TrimPrefix(r.URL.Path, "/")]
+		g.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(g.Close)
+	return g
+}
+
+func (g *gateway) ForTask(taskID string) artifacts.Uploader { return g }
+
+func (g *gateway) Add(ctx context.Context, name string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	cid := fmt.Sprintf("QmEscrow%d", len(g.uploads)+1)
+	g.uploads[cid] = data
+	return cid, nil
+}
+
+func TestSealOpensForBothRecipients(t *testing.T) {
+	creator, holder, outsider := newKey(t), newKey(t), newKey(t)
+	s := newSigner(t)
+	bundle := []byte(`{"output":"result"}`)
+
+	env, err := Seal("task-1", bundle, []*ecdsa.PublicKey{&creator.PublicKey, &holder.PublicKey}, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(env.Ciphertext, bundle) {
+		t.Fatal("envelope carries the bundle in the clear")
+	}
+	if runner, err := env.Verify(); err != nil || runner != s.Address() {
+		t.Fatalf("Verify() = %s, %v, want %s", runner.Hex(), err, s.Address().Hex())
+	}
+	for _, key := range []*ecdsa.PrivateKey{creator, holder} {
+		got, err := env.Open(key)
+		if err != nil || !bytes.Equal(got, bundle) {
+			t.Errorf("Open() = %q, %v", got, err)
+		}
+	}
+	if _, err := env.Open(outsider); !errors.Is(err, ErrNotRecipient) {
+		t.Errorf("Open() by an outsider = %v, want ErrNotRecipient", err)
+	}
+
+	// A recipient's key swapped for an outsider's breaks the signature
+	forged := *env
+	forged.Recipients = append([]Recipient{}, env.Recipients...)
+	forged.Recipients[1].Address = crypto.PubkeyToAddress(outsider.PublicKey).Hex()
+	if _, err := forged.Verify(); !errors.Is(err, ErrTampered) {
+		t.Errorf("Verify() of a forged envelope = %v, want ErrTampered", err)
+	}
+	forged = *env
+	forged.Ciphertext = append([]byte{}, env.Ciphertext...)
+	forged.Ciphertext[0] ^= 1
+	if _, err := forged.Open(creator); !errors.Is(err, ErrTampered) {
+		t.Errorf("Open() of altered ciphertext = %v, want ErrTampered", err)
+	}
+}
+
+func escrowTask(t *testing.T, creator *ecdsa.PrivateKey) *models.Task {
+	t.Helper()
+	return &models.Task{ID: uuid.New(), CreatorAddress: crypto.PubkeyToAddress(creator.PublicKey).Hex()}
+}
+
+func TestEscrowRecordsCID(t *testing.T) {
+	creator, holder := newKey(t), newKey(t)
+	g := newGateway(t)
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	escrower, err := NewEscrower(g, newSigner(t), store, publicHex(holder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealedAt := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	escrower.SetClock(clocktest.NewFake(sealedAt))
+
+	task := escrowTask(t, creator)
+	result := &models.TaskResult{TaskID: task.ID, Output: "42"}
+	report := escrower.Escrow(context.Background(), task, &models.EscrowConfig{CreatorKey: publicHex(creator)}, result)
+	if report.Skipped != "" || report.CID != "QmEscrow1" || len(report.Recipients) != 2 {
+		t.Fatalf("Escrow() = %+v", report)
+	}
+
+	record, err := store.Get(task.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.CID != report.CID || record.BundleHash != report.BundleHash || !record.SealedAt.Equal(sealedAt) {
+		t.Errorf("record = %+v, want the report's CID and bundle hash", record)
+	}
+
+	// The bundle is the result without its escrow report
+	result.Escrow = report
+	bundle, err := Bundle(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Hash(bundle) != report.BundleHash {
+		t.Error("bundle hash changes once the report is set on the result")
+	}
+}
+
+func TestEscrowSkipsWithoutKeys(t *testing.T) {
+	creator, holder := newKey(t), newKey(t)
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	escrower, err := NewEscrower(newGateway(t), newSigner(t), store, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := escrowTask(t, creator)
+	result := &models.TaskResult{TaskID: task.ID}
+
+	tests := []struct {
+		cfg  models.EscrowConfig
+		want string
+	}{
+		{models.EscrowConfig{}, "task has no creator key"},
+		{models.EscrowConfig{CreatorKey: publicHex(creator)}, "no network escrow key"},
+		{models.EscrowConfig{CreatorKey: publicHex(holder), EscrowKey: publicHex(holder)}, "creator key does not belong to the task's creator"},
+	}
+	for _, tt := range tests {
+		cfg := tt.cfg
+		if report := escrower.Escrow(context.Background(), task, &cfg, result); report.Skipped != tt.want || report.CID != "" {
+			t.Errorf("Escrow(%+v) = %+v, want skipped for %q", tt.cfg, report, tt.want)
+		}
+	}
+	if _, err := store.Get(task.ID.String()); !errors.Is(err, ErrNoRecord) {
+		t.Errorf("Get() = %v for a skipped escrow, want ErrNoRecord", err)
+	}
+
+	// A task naming its own escrow key needs no network key
+	report := escrower.Escrow(context.Background(), task, &models.EscrowConfig{CreatorKey: publicHex(creator), EscrowKey: publicHex(holder)}, result)
+	if report.Skipped != "" || report.CID == "" {
+		t.Errorf("Escrow() = %+v with a task escrow key", report)
+	}
+}
+
+func TestProve(t *testing.T) {
+	creator, holder, outsider := newKey(t), newKey(t), newKey(t)
+	g := newGateway(t)
+	store, err := OpenStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newSigner(t)
+	escrower, err := NewEscrower(g, s, store, publicHex(holder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := escrowTask(t, creator)
+	result := &models.TaskResult{TaskID: task.ID, Output: "delivered"}
+	report := escrower.Escrow(context.Background(), task, &models.EscrowConfig{CreatorKey: publicHex(creator)}, result)
+	record, err := store.Get(task.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := Prove(context.Background(), g.Client(), g.URL+"/", record, nil)
+	if err != nil {
+		t.Fatalf("Prove() error = %v", err)
+	}
+	if proof.Opened || proof.Runner != s.Address() || proof.BundleHash != report.BundleHash {
+		t.Errorf("Prove() = %+v", proof)
+	}
+
+	// The escrow holder decrypts the bundle long after the runner deleted it
+	proof, err = Prove(context.Background(), g.Client(), g.URL, record, holder)
+	if err != nil || !proof.Opened || proof.BundleHash != report.BundleHash {
+		t.Fatalf("Prove() with the holder's key = %+v, %v", proof, err)
+	}
+	if _, err := Prove(context.Background(), g.Client(), g.URL, record, outsider); !errors.Is(err, ErrNotRecipient) {
+		t.Errorf("Prove() with an outsider's key = %v, want ErrNotRecipient", err)
+	}
+
+	// An envelope swapped at the gateway is caught
+	var env Envelope
+	json.Unmarshal(g.uploads[record.CID], &env)
+	env.BundleHash = Hash([]byte("something else"))
+	g.uploads[record.CID], _ = json.Marshal(env)
+	if _, err := Prove(context.Background(), g.Client(), g.URL, record, nil); !errors.Is(err, ErrTampered) {
+		t.Errorf("Prove() of a swapped envelope = %v, want ErrTampered", err)
+	}
+}
+
+func TestStoreSealsRecords(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(&Record{TaskID: "task-1", CID: "QmOld"}); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := atrest.OpenKeyring(filepath.Join(t.TempDir(), "datakeys.json"), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetCodec(keyring)
+	if resealed, err := store.Reseal(); err != nil || resealed != 1 {
+		t.Fatalf("Reseal() = %d, %v, want 1 record", resealed, err)
+	}
+	if err := store.Put(&Record{TaskID: "task-2", CID: "QmNew"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, taskID := range []string{"task-1", "task-2"} {
+		raw, err := os.ReadFile(filepath.Join(dir, taskID+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !atrest.IsEncrypted(raw) {
+			t.Errorf("record of %s is not encrypted", taskID)
+		}
+		if record, err := store.Get(taskID); err != nil || record.TaskID != taskID {
+			t.Errorf("Get(%s) = %+v, %v", taskID, record, err)
+		}
+	}
+}
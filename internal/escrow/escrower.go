@@ -0,0 +1,128 @@
+package escrow
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+// envelopeName is the artifact name envelopes are uploaded as
+const envelopeName = "escrow.json"
+
+// Escrower escrows the results of tasks asking for it
+type Escrower struct {
+	uploaders  artifacts.UploaderSource
+	signer     signer.Signer
+	store      *Store
+	networkKey *ecdsa.PublicKey
+	clock      clock.Clock
+}
+
+// NewEscrower uploads envelopes signed by s through uploaders and records
+// them in store. networkKey, a hex public key, is the escrow key of tasks
+// not naming one; without it such tasks are not escrowed.
+func NewEscrower(uploaders artifacts.UploaderSource, s signer.Signer, store *Store, networkKey string) (*Escrower, error) {
+	if uploaders == nil || s == nil || store == nil {
+		return nil, errors.New("escrow needs an uploader, a signer and a store")
+	}
+	e := &Escrower{uploaders: uploaders, signer: s, store: store, clock: clock.Real()}
+	if networkKey != "" {
+		key, err := ParsePublicKey(networkKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network escrow key: %w", err)
+		}
+		e.networkKey = key
+	}
+	return e, nil
+}
+
+func (e *Escrower) SetClock(clk clock.Clock) {
+	e.clock = clk
+}
+
+// Escrow seals result of task to the keys cfg names and uploads it,
+// returning the report to set on result. A task that cannot be escrowed,
+// for want of a key or because the upload failed, gets a report saying
+// why rather than an error: the result itself is still delivered.
+func (e *Escrower) Escrow(ctx context.Context, task *models.Task, cfg *models.EscrowConfig, result *models.TaskResult) *models.EscrowReport {
+	recipients, reason := e.recipients(task, cfg)
+	if reason != "" {
+		return &models.EscrowReport{Skipped: reason}
+	}
+	taskID := task.ID.String()
+	bundle, err := Bundle(result)
+	if err != nil {
+		return &models.EscrowReport{Skipped: err.Error()}
+	}
+	env, err := Seal(taskID, bundle, recipients, e.signer)
+	if err != nil {
+		return &models.EscrowReport{Skipped: err.Error()}
+	}
+	data, err := json.Marshal(env)
+	if err != nil {
+		return &models.EscrowReport{Skipped: fmt.Sprintf("failed to encode escrow envelope: %v", err)}
+	}
+	cid, err := e.uploaders.ForTask(taskID).Add(ctx, envelopeName, bytes.NewReader(data))
+	if err != nil {
+		return &models.EscrowReport{Skipped: fmt.Sprintf("failed to upload escrow envelope: %v", err)}
+	}
+
+	report := &models.EscrowReport{CID: cid, BundleHash: env.BundleHash}
+	for _, recipient := range env.Recipients {
+		report.Recipients = append(report.Recipients, recipient.Address)
+	}
+	// The envelope is already published, so a failure to record it only
+	// costs the runner its own pointer to it
+	err = e.store.Put(&Record{
+		TaskID:       taskID,
+		CID:          cid,
+		BundleHash:   env.BundleHash,
+		EnvelopeHash: Hash(data),
+		Runner:       env.Runner,
+		Recipients:   report.Recipients,
+		SealedAt:     e.clock.Now(),
+	})
+	if err != nil {
+		log := gologger.WithComponent("escrow")
+		log.Warn().Err(err).Str("id", taskID).Str("cid", cid).Msg("Failed to record escrowed result")
+	}
+	return report
+}
+
+// recipients returns the keys result of task is escrowed to, or why it is
+// not escrowed
+func (e *Escrower) recipients(task *models.Task, cfg *models.EscrowConfig) ([]*ecdsa.PublicKey, string) {
+	if cfg.CreatorKey == "" {
+		return nil, "task has no creator key"
+	}
+	creator, err := ParsePublicKey(cfg.CreatorKey)
+	if err != nil {
+		return nil, fmt.Sprintf("invalid creator key: %v", err)
+	}
+	if task.CreatorAddress != "" && crypto.PubkeyToAddress(*creator) != common.HexToAddress(task.CreatorAddress) {
+		return nil, "creator key does not belong to the task's creator"
+	}
+
+	network := e.networkKey
+	if cfg.EscrowKey != "" {
+		if network, err = ParsePublicKey(cfg.EscrowKey); err != nil {
+			return nil, fmt.Sprintf("invalid escrow key: %v", err)
+		}
+	}
+	if network == nil {
+		return nil, "no network escrow key"
+	}
+	return []*ecdsa.PublicKey{creator, network}, ""
+}
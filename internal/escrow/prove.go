@@ -0,0 +1,83 @@
+package escrow
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// maxEnvelopeSize bounds the envelope downloaded to prove an escrow
+const maxEnvelopeSize = 1 << 30
+
+// Proof is what proving an escrowed result established
+type Proof struct {
+	Record *Record
+	// Runner sealed the envelope, as its signature shows
+	Runner common.Address
+	// BundleHash is the hash the envelope was signed over, re-derived from
+	// the decrypted bundle when Opened
+	BundleHash string
+	Opened     bool
+}
+
+// Prove downloads the envelope record points at from gateway and checks it
+// is the one the runner sealed: its hash, its signature, and the bundle
+// hash it was signed over. With the key of a recipient the bundle is also
+// decrypted and its hash re-derived.
+func Prove(ctx context.Context, client *http.Client, gateway string, record *Record, key *ecdsa.PrivateKey) (*Proof, error) {
+	data, err := download(ctx, client, gateway, record.CID)
+	if err != nil {
+		return nil, err
+	}
+	if Hash(data) != record.EnvelopeHash {
+		return nil, fmt.Errorf("%w: envelope %s hash differs from the record", ErrTampered, record.CID)
+	}
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("failed to decode escrow envelope: %w", err)
+	}
+	runner, err := env.Verify()
+	if err != nil {
+		return nil, err
+	}
+	if env.TaskID != record.TaskID || env.BundleHash != record.BundleHash {
+		return nil, fmt.Errorf("%w: envelope is not the one recorded for task %s", ErrTampered, record.TaskID)
+	}
+
+	proof := &Proof{Record: record, Runner: runner, BundleHash: env.BundleHash}
+	if key != nil {
+		bundle, err := env.Open(key)
+		if err != nil {
+			return nil, err
+		}
+		proof.BundleHash = Hash(bundle)
+		proof.Opened = true
+	}
+	return proof, nil
+}
+
+func download(ctx context.Context, client *http.Client, gateway, cid string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(gateway, "/")+"/"+cid, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gateway: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download escrow envelope: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download escrow envelope %s: gateway returned %s", cid, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxEnvelopeSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download escrow envelope: %w", err)
+	}
+	return data, nil
+}
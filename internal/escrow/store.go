@@ -0,0 +1,130 @@
+package escrow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+)
+
+// ErrNoRecord is returned for a task this runner did not escrow
+var ErrNoRecord = errors.New("no escrow record for task")
+
+// Record is what the runner keeps of an escrowed result: where the
+// envelope is and the hashes to check it against, not the result itself
+type Record struct {
+	TaskID     string `json:"task_id"`
+	CID        string `json:"cid"`
+	BundleHash string `json:"bundle_hash"`
+	// EnvelopeHash is the SHA-256 of the uploaded envelope
+	EnvelopeHash string    `json:"envelope_hash"`
+	Runner       string    `json:"runner"`
+	Recipients   []string  `json:"recipients"`
+	SealedAt     time.Time `json:"sealed_at"`
+}
+
+// Store keeps one record per escrowed task in a directory. Records are
+// small and never expire, so the envelope stays findable after the
+// result's retention.
+type Store struct {
+	dir   string
+	mu    sync.Mutex
+	codec atrest.Codec
+}
+
+// OpenStore returns the store in dir, creating it if needed
+func OpenStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create escrow directory: %w", err)
+	}
+	return &Store{dir: dir}, nil
+}
+
+// SetCodec encrypts records written from now on
+func (s *Store) SetCodec(codec atrest.Codec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codec = codec
+}
+
+// Reseal rewrites records that are plaintext or sealed with an older key
+// under the active key. It returns the number rewritten.
+func (s *Store) Reseal() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.codec == nil {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read escrow directory: %w", err)
+	}
+	resealed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		rewritten, err := atrest.ResealFile(s.codec, filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return resealed, fmt.Errorf("failed to reseal escrow record %s: %w", entry.Name(), err)
+		}
+		if rewritten {
+			resealed++
+		}
+	}
+	return resealed, nil
+}
+
+// Put records the escrow of a task, replacing any earlier one
+func (s *Store) Put(record *Record) error {
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode escrow record: %w", err)
+	}
+	path, err := s.path(record.TaskID)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := atrest.WriteFile(s.codec, path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write escrow record: %w", err)
+	}
+	return nil
+}
+
+// Get returns the escrow record of taskID
+func (s *Store) Get(taskID string) (*Record, error) {
+	path, err := s.path(taskID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := atrest.ReadFile(s.codec, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w %s", ErrNoRecord, taskID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read escrow record: %w", err)
+	}
+	var record Record
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("failed to decode escrow record: %w", err)
+	}
+	return &record, nil
+}
+
+func (s *Store) path(taskID string) (string, error) {
+	if taskID == "" || taskID != filepath.Base(taskID) || taskID == "." || taskID == ".." {
+		return "", fmt.Errorf("invalid task ID %q", taskID)
+	}
+	return filepath.Join(s.dir, taskID+".json"), nil
+}
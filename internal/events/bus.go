@@ -0,0 +1,213 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/theblitlabs/gologger"
+)
+
+// DefaultQueueSize is the number of events a subscriber may fall behind by
+// before further events are dropped for it
+const DefaultQueueSize = 256
+
+// Bus delivers published events to its subscribers. Every subscriber sees
+// the events it receives in the order they were published, so the events
+// of one task always arrive in the order they happened. Publishing never
+// blocks: an event finding a subscriber's queue full is dropped for that
+// subscriber alone and counted.
+type Bus struct {
+	queueSize int
+
+	mu     sync.Mutex
+	subs   []*Subscription
+	closed bool
+}
+
+// NewBus returns a bus without subscribers, each of which it lets fall
+// behind by up to queueSize events, DefaultQueueSize when queueSize is not
+// positive
+func NewBus(queueSize int) *Bus {
+	if queueSize <= 0 {
+		queueSize = DefaultQueueSize
+	}
+	return &Bus{queueSize: queueSize}
+}
+
+// Subscription is one subscriber's queue and the goroutine draining it
+type Subscription struct {
+	name   string
+	queue  chan Event
+	handle func(Event)
+	done   chan struct{}
+
+	mu   sync.Mutex
+	cond *sync.Cond
+	// queued and handled count the events accepted into the queue and
+	// those the handler has returned from
+	queued, handled uint64
+	dropped         uint64
+}
+
+// SubscriberStats describes how a subscriber keeps up with the bus
+type SubscriberStats struct {
+	Name    string `json:"name"`
+	Queued  int    `json:"queued"`
+	Dropped uint64 `json:"dropped"`
+}
+
+// Subscribe passes every event published from now on to handle, in order,
+// from a goroutine of its own. A handle that panics loses the event it was
+// given and keeps receiving the rest.
+func (b *Bus) Subscribe(name string, handle func(Event)) *Subscription {
+	s := &Subscription{
+		name:   name,
+		queue:  make(chan Event, b.queueSize),
+		handle: handle,
+		done:   make(chan struct{}),
+	}
+	s.cond = sync.NewCond(&s.mu)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(s.queue)
+	} else {
+		b.subs = append(b.subs, s)
+	}
+	go s.run()
+	return s
+}
+
+// Publish offers e to every subscriber. A nil bus drops every event, so
+// publishers need not check whether events are wanted.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	// Holding the lock while offering the event gives every subscriber the
+	// same order
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	for _, s := range b.subs {
+		s.offer(e)
+	}
+}
+
+// Sync waits until every subscriber has handled the events published
+// before it was called, or dropped them
+func (b *Bus) Sync() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	subs := append([]*Subscription(nil), b.subs...)
+	targets := make([]uint64, len(subs))
+	for i, s := range subs {
+		s.mu.Lock()
+		targets[i] = s.queued
+		s.mu.Unlock()
+	}
+	b.mu.Unlock()
+
+	for i, s := range subs {
+		s.mu.Lock()
+		for s.handled < targets[i] {
+			s.cond.Wait()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Close stops accepting events and waits for subscribers to handle the
+// ones already queued
+func (b *Bus) Close() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return
+	}
+	b.closed = true
+	subs := b.subs
+	for _, s := range subs {
+		close(s.queue)
+	}
+	b.mu.Unlock()
+
+	for _, s := range subs {
+		<-s.done
+	}
+}
+
+// Stats describes each subscriber's queue
+func (b *Bus) Stats() []SubscriberStats {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	stats := make([]SubscriberStats, len(b.subs))
+	for i, s := range b.subs {
+		stats[i] = s.Stats()
+	}
+	return stats
+}
+
+// Stats describes the subscriber's queue
+func (s *Subscription) Stats() SubscriberStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return SubscriberStats{Name: s.name, Queued: int(s.queued - s.handled), Dropped: s.dropped}
+}
+
+// offer queues e unless the queue is full. It is called with the bus
+// locked, so events are queued in the order they were published.
+func (s *Subscription) offer(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	select {
+	case s.queue <- e:
+		s.queued++
+	default:
+		s.dropped++
+		if s.dropped == 1 || s.dropped%1000 == 0 {
+			log := gologger.WithComponent("events")
+			log.Warn().
+				Str("subscriber", s.name).
+				Str("event", e.Name()).
+				Uint64("dropped", s.dropped).
+				Msg("Event subscriber is falling behind - dropping events")
+		}
+	}
+}
+
+func (s *Subscription) run() {
+	defer close(s.done)
+	for e := range s.queue {
+		s.deliver(e)
+		s.mu.Lock()
+		s.handled++
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+}
+
+func (s *Subscription) deliver(e Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log := gologger.WithComponent("events")
+			log.Error().
+				Str("subscriber", s.name).
+				Str("event", e.Name()).
+				Err(fmt.Errorf("%v", r)).
+				Msg("Event subscriber panicked")
+		}
+	}()
+	s.handle(e)
+}
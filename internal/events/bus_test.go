@@ -0,0 +1,201 @@
+package events
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// recorder keeps the events a subscriber handles
+type recorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *recorder) handle(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *recorder) received() []Event {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+// lifecycle is what the pipeline publishes for one task, in order
+func lifecycle(taskID string) []Event {
+	return []Event{
+		TaskClaimed{TaskID: taskID, Type: models.TaskTypeDocker},
+		TaskProgress{TaskID: taskID, Progress: &models.TaskProgress{}},
+		TaskCompleted{TaskID: taskID, Status: models.TaskStatusCompleted},
+		ResultUploaded{TaskID: taskID, Status: models.TaskStatusCompleted},
+	}
+}
+
+func taskOf(e Event) string {
+	switch e := e.(type) {
+	case TaskClaimed:
+		return e.TaskID
+	case TaskProgress:
+		return e.TaskID
+	case TaskCompleted:
+		return e.TaskID
+	case ResultUploaded:
+		return e.TaskID
+	}
+	return ""
+}
+
+func TestBusKeepsPerTaskOrder(t *testing.T) {
+	bus := NewBus(1024)
+	defer bus.Close()
+	first, second := &recorder{}, &recorder{}
+	bus.Subscribe("first", first.handle)
+	bus.Subscribe("second", second.handle)
+
+	// Tasks run concurrently, each publishing its own lifecycle
+	const tasks = 20
+	var wg sync.WaitGroup
+	for i := 0; i < tasks; i++ {
+		wg.Add(1)
+		go func(taskID string) {
+			defer wg.Done()
+			for _, e := range lifecycle(taskID) {
+				bus.Publish(e)
+			}
+		}(fmt.Sprintf("task-%d", i))
+	}
+	wg.Wait()
+	bus.Sync()
+
+	want := lifecycle("")
+	for name, r := range map[string]*recorder{"first": first, "second": second} {
+		received := r.received()
+		if len(received) != tasks*len(want) {
+			t.Fatalf("%s received %d events, want %d", name, len(received), tasks*len(want))
+		}
+		next := make(map[string]int)
+		for _, e := range received {
+			taskID := taskOf(e)
+			if i := next[taskID]; e.Name() != want[i].Name() {
+				t.Fatalf("%s received %s as event %d of %s, want %s", name, e.Name(), i, taskID, want[i].Name())
+			}
+			next[taskID]++
+		}
+	}
+
+	// Every subscriber sees the same interleaving
+	a, b := first.received(), second.received()
+	for i := range a {
+		if a[i] != b[i] {
+			t.Fatalf("subscribers disagree at event %d: %+v and %+v", i, a[i], b[i])
+		}
+	}
+}
+
+func TestBusIsolatesSlowSubscriber(t *testing.T) {
+	bus := NewBus(4)
+	defer bus.Close()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	unblock := sync.OnceFunc(func() { close(release) })
+	defer unblock()
+	slow := &recorder{}
+	bus.Subscribe("slow", func(e Event) {
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		slow.handle(e)
+	})
+	fast := &recorder{}
+	bus.Subscribe("fast", fast.handle)
+
+	// The slow subscriber is stuck on the first event
+	bus.Publish(CacheEvicted{Cache: "inputs", Key: "0"})
+	<-started
+
+	// Publishing never waits for the slow subscriber
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 1; i < 100; i++ {
+			bus.Publish(CacheEvicted{Cache: "inputs", Key: fmt.Sprint(i)})
+			// Let the fast subscriber keep up
+			for len(fast.received()) <= i {
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Publish() blocked on a slow subscriber")
+	}
+
+	if got := len(fast.received()); got != 100 {
+		t.Fatalf("fast subscriber received %d events, want 100", got)
+	}
+
+	stats := map[string]SubscriberStats{}
+	for _, s := range bus.Stats() {
+		stats[s.Name] = s
+	}
+	if stats["fast"].Dropped != 0 {
+		t.Errorf("fast subscriber dropped %d events, want none", stats["fast"].Dropped)
+	}
+	// The slow subscriber holds one event and queues four; the rest are
+	// dropped for it alone
+	if stats["slow"].Dropped != 95 {
+		t.Errorf("slow subscriber dropped %d events, want 95", stats["slow"].Dropped)
+	}
+
+	unblock()
+	bus.Sync()
+	received := slow.received()
+	if len(received) != 5 {
+		t.Fatalf("slow subscriber received %d events, want 5", len(received))
+	}
+	for i, e := range received {
+		if key := e.(CacheEvicted).Key; key != fmt.Sprint(i) {
+			t.Errorf("slow subscriber event %d has key %s, want the first events in order", i, key)
+		}
+	}
+}
+
+func TestBusSurvivesPanickingSubscriber(t *testing.T) {
+	bus := NewBus(0)
+	defer bus.Close()
+	r := &recorder{}
+	bus.Subscribe("flaky", func(e Event) {
+		if e.(LeaseLost).TaskID == "bad" {
+			panic("boom")
+		}
+		r.handle(e)
+	})
+
+	bus.Publish(LeaseLost{TaskID: "bad"})
+	bus.Publish(LeaseLost{TaskID: "good"})
+	bus.Sync()
+
+	if received := r.received(); len(received) != 1 || received[0].(LeaseLost).TaskID != "good" {
+		t.Fatalf("received %+v, want the event after the panic", received)
+	}
+}
+
+func TestNilBusDropsEvents(t *testing.T) {
+	var bus *Bus
+	bus.Publish(ServerUnreachable{Error: "refused"})
+	bus.Sync()
+	bus.Close()
+	if stats := bus.Stats(); stats != nil {
+		t.Fatalf("Stats() = %+v, want none", stats)
+	}
+}
@@ -0,0 +1,221 @@
+// Package events carries what happens in the task pipeline to the features
+// that react to it, such as metrics, audit bundles, creator callbacks and
+// operator hooks. Each event is published from a single point in the
+// pipeline and delivered to every subscriber through its own bounded queue,
+// so a slow subscriber loses events instead of stalling execution.
+package events
+
+import (
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// Event is something that happened in the runner. Name identifies its
+// type to hooks and in logs.
+type Event interface {
+	Name() string
+}
+
+// Event names
+const (
+	NameTaskClaimed         = "task_claimed"
+	NameTaskDeclined        = "task_declined"
+	NameTaskSkipped         = "task_skipped"
+	NameTaskProgress        = "task_progress"
+	NameTaskCompleted       = "task_completed"
+	NameResultUploaded      = "result_uploaded"
+	NameLeaseLost           = "lease_lost"
+	NameCacheEvicted        = "cache_evicted"
+	NameServerUnreachable   = "server_unreachable"
+	NameCapabilitiesChanged = "capabilities_changed"
+	NameCapabilityDegraded  = "capability_degraded"
+	NameCapabilityRestored  = "capability_restored"
+	NameTaskTypePaused      = "task_type_paused"
+	NameTaskTypeResumed     = "task_type_resumed"
+	NameResultAnomalous     = "result_anomalous"
+)
+
+// Names lists every event name
+var Names = []string{
+	NameTaskClaimed,
+	NameTaskDeclined,
+	NameTaskSkipped,
+	NameTaskProgress,
+	NameTaskCompleted,
+	NameResultUploaded,
+	NameLeaseLost,
+	NameCacheEvicted,
+	NameServerUnreachable,
+	NameCapabilitiesChanged,
+	NameCapabilityDegraded,
+	NameCapabilityRestored,
+	NameTaskTypePaused,
+	NameTaskTypeResumed,
+	NameResultAnomalous,
+}
+
+// TaskClaimed is published once the server has assigned a task to the
+// runner. Task is left out of hook payloads.
+type TaskClaimed struct {
+	Task      *models.Task    `json:"-"`
+	TaskID    string          `json:"task_id"`
+	Type      models.TaskType `json:"type"`
+	Instance  string          `json:"instance,omitempty"`
+	ClaimedAt time.Time       `json:"claimed_at"`
+	// Timeout is the longest the task may run
+	Timeout time.Duration `json:"timeout_ns,omitempty"`
+}
+
+func (TaskClaimed) Name() string { return NameTaskClaimed }
+
+// TaskDeclined is published when a task fails the checks that gate
+// claiming it
+type TaskDeclined struct {
+	TaskID   string                 `json:"task_id"`
+	Type     models.TaskType        `json:"type"`
+	Instance string                 `json:"instance,omitempty"`
+	Reason   models.FLDeclineReason `json:"reason"`
+	Detail   string                 `json:"detail,omitempty"`
+}
+
+func (TaskDeclined) Name() string { return NameTaskDeclined }
+
+// TaskSkipped is published when a task the runner can run is passed over
+// because the runner is busy with its own work
+type TaskSkipped struct {
+	TaskID   string            `json:"task_id"`
+	Type     models.TaskType   `json:"type"`
+	Instance string            `json:"instance,omitempty"`
+	Reason   models.SkipReason `json:"reason"`
+	Detail   string            `json:"detail,omitempty"`
+}
+
+func (TaskSkipped) Name() string { return NameTaskSkipped }
+
+// TaskProgress is published for each progress report a running task makes
+type TaskProgress struct {
+	TaskID   string               `json:"task_id"`
+	Progress *models.TaskProgress `json:"progress"`
+}
+
+func (TaskProgress) Name() string { return NameTaskProgress }
+
+// TaskCompleted is published when a task's execution finishes, whether it
+// succeeded or failed. Task and Result are left out of hook payloads;
+// Result is nil when the execution produced none.
+type TaskCompleted struct {
+	Task     *models.Task       `json:"-"`
+	Result   *models.TaskResult `json:"-"`
+	TaskID   string             `json:"task_id"`
+	Type     models.TaskType    `json:"type"`
+	Instance string             `json:"instance,omitempty"`
+	Status   models.TaskStatus  `json:"status"`
+	Duration time.Duration      `json:"duration_ns"`
+	// FailureCode classifies why a failed execution failed
+	FailureCode models.FailureCode `json:"failure_code,omitempty"`
+	// ResultDigest is the digest recorded in task history for audits,
+	// empty when audits are disabled
+	ResultDigest string `json:"result_digest,omitempty"`
+}
+
+func (TaskCompleted) Name() string { return NameTaskCompleted }
+
+// ResultUploaded is published once the server has accepted a task's result
+type ResultUploaded struct {
+	Task     *models.Task       `json:"-"`
+	Result   *models.TaskResult `json:"-"`
+	TaskID   string             `json:"task_id"`
+	Instance string             `json:"instance,omitempty"`
+	Status   models.TaskStatus  `json:"status"`
+}
+
+func (ResultUploaded) Name() string { return NameResultUploaded }
+
+// LeaseLost is published when the server reassigns a task the runner was
+// executing
+type LeaseLost struct {
+	TaskID string `json:"task_id"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (LeaseLost) Name() string { return NameLeaseLost }
+
+// CacheEvicted is published for each entry evicted from a local cache
+type CacheEvicted struct {
+	Cache string `json:"cache"`
+	Key   string `json:"key"`
+	Bytes int64  `json:"bytes"`
+}
+
+func (CacheEvicted) Name() string { return NameCacheEvicted }
+
+// ServerUnreachable is published for each failed attempt to reach the
+// server
+type ServerUnreachable struct {
+	Error               string `json:"error"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+}
+
+func (ServerUnreachable) Name() string { return NameServerUnreachable }
+
+// CapabilitiesChanged is published when something the runner registered
+// with changes outside the task pipeline, such as its task types, served
+// models or measured bandwidth. Reason names what changed.
+type CapabilitiesChanged struct {
+	Reason string `json:"reason"`
+}
+
+func (CapabilitiesChanged) Name() string { return NameCapabilitiesChanged }
+
+// CapabilityDegraded is published when a capability's canary self-test
+// failed enough times in a row that the runner stops advertising it
+type CapabilityDegraded struct {
+	Capability          string `json:"capability"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	Error               string `json:"error,omitempty"`
+}
+
+func (CapabilityDegraded) Name() string { return NameCapabilityDegraded }
+
+// CapabilityRestored is published when a degraded capability's canary
+// passes again and the runner advertises it again
+type CapabilityRestored struct {
+	Capability string `json:"capability"`
+}
+
+func (CapabilityRestored) Name() string { return NameCapabilityRestored }
+
+// TaskTypePaused is published when the runner used up a task type's error
+// budget and stops claiming it. Diagnosis lists what the runner's health
+// checks found wrong, when they are run.
+type TaskTypePaused struct {
+	Type           models.TaskType    `json:"type"`
+	RunnerFailures int                `json:"runner_failures"`
+	FailureRate    float64            `json:"failure_rate"`
+	LastCode       models.FailureCode `json:"last_code,omitempty"`
+	Diagnosis      []string           `json:"diagnosis,omitempty"`
+}
+
+func (TaskTypePaused) Name() string { return NameTaskTypePaused }
+
+// TaskTypeResumed is published when a paused task type's cool-down passed
+// and its canary passed, and the runner claims it again
+type TaskTypeResumed struct {
+	Type models.TaskType `json:"type"`
+}
+
+func (TaskTypeResumed) Name() string { return NameTaskTypeResumed }
+
+// ResultAnomalous is published when the runner finds a result of its own
+// out of line with its earlier results of the type, before the result is
+// submitted. Held is set when the result awaits the operator's approval.
+type ResultAnomalous struct {
+	TaskID   string                `json:"task_id"`
+	Type     models.TaskType       `json:"type"`
+	Instance string                `json:"instance,omitempty"`
+	Held     bool                  `json:"held"`
+	Anomaly  *models.ResultAnomaly `json:"anomaly"`
+}
+
+func (ResultAnomalous) Name() string { return NameResultAnomalous }
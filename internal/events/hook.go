@@ -0,0 +1,83 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Hook runs an operator's command for events, such as one forwarding them
+// to a chat channel or a local dashboard. The event is passed on stdin as
+// JSON of the form {"event": name, "payload": event}, and its name in the
+// PARITY_EVENT environment variable. The command's exit status is logged
+// and otherwise ignored.
+type Hook struct {
+	command []string
+	names   map[string]bool
+	timeout time.Duration
+}
+
+// NewHook creates a hook running command, a program and its arguments, for
+// the events named in names, every event when names is empty. A command
+// still running after timeout is killed; zero leaves it running.
+func NewHook(command []string, names []string, timeout time.Duration) (*Hook, error) {
+	if len(command) == 0 || command[0] == "" {
+		return nil, fmt.Errorf("event hook command is required")
+	}
+	h := &Hook{command: command, timeout: timeout}
+	if len(names) > 0 {
+		known := make(map[string]bool, len(Names))
+		for _, name := range Names {
+			known[name] = true
+		}
+		h.names = make(map[string]bool, len(names))
+		for _, name := range names {
+			if !known[name] {
+				return nil, fmt.Errorf("unknown event %q (supported: %s)", name, strings.Join(Names, ", "))
+			}
+			h.names[name] = true
+		}
+	}
+	return h, nil
+}
+
+// Wants reports whether the hook runs for events named name
+func (h *Hook) Wants(name string) bool {
+	return h.names == nil || h.names[name]
+}
+
+// Run runs the hook for e, killing it once ctx is done or the hook's
+// timeout passes
+func (h *Hook) Run(ctx context.Context, e Event) error {
+	payload, err := json.Marshal(struct {
+		Event   string `json:"event"`
+		Payload Event  `json:"payload"`
+	}{e.Name(), e})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", e.Name(), err)
+	}
+
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, h.command[0], h.command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(os.Environ(), "PARITY_EVENT="+e.Name())
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return fmt.Errorf("event hook for %s did not finish: %w", e.Name(), ctxErr)
+		}
+		return fmt.Errorf("event hook for %s failed: %w: %s", e.Name(), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const (
+	defaultBatchSize = 32
+	// defaultTokenLimit is used when the backend cannot report the model context length
+	defaultTokenLimit = 512
+	// charsPerToken is a conservative estimate used to bound input length
+	// without a model-specific tokenizer
+	charsPerToken = 3
+)
+
+// Backend produces embeddings for a batch of inputs
+type Backend interface {
+	Embed(ctx context.Context, model string, inputs []string) ([][]float32, error)
+	ContextLength(ctx context.Context, model string) (int, error)
+}
+
+type Config struct {
+	Model     string                 `json:"model"`
+	Input     InputSource            `json:"input"`
+	BatchSize int                    `json:"batch_size"`
+	Normalize bool                   `json:"normalize"`
+	Format    models.EmbeddingFormat `json:"format"`
+	MaxTokens int                    `json:"max_tokens,omitempty"`
+}
+
+func (c *Config) Validate() error {
+	if c.Model == "" {
+		return errors.New("model is required for embedding tasks")
+	}
+	if err := c.Input.Validate(); err != nil {
+		return err
+	}
+	if c.BatchSize < 0 {
+		return errors.New("batch size must not be negative")
+	}
+	switch c.Format {
+	case "", models.EmbeddingFormatJSONL, models.EmbeddingFormatFloat32:
+	default:
+		return fmt.Errorf("unsupported embedding format: %s", c.Format)
+	}
+	return nil
+}
+
+// Run streams the configured inputs, fetched through sources, through
+// backend in batches and writes the resulting vectors into outputDir
+func Run(ctx context.Context, backend Backend, sources Sources, cfg *Config, outputDir string) (*models.EmbeddingSummary, error) {
+	log := gologger.WithComponent("embedding")
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	batchSize := cfg.BatchSize
+	if batchSize == 0 {
+		batchSize = defaultBatchSize
+	}
+	format := cfg.Format
+	if format == "" {
+		format = models.EmbeddingFormatJSONL
+	}
+
+	tokenLimit := cfg.MaxTokens
+	if tokenLimit == 0 {
+		limit, err := backend.ContextLength(ctx, cfg.Model)
+		if err != nil {
+			log.Warn().Err(err).Str("model", cfg.Model).Int("token_limit", defaultTokenLimit).Msg("Using default token limit")
+			limit = defaultTokenLimit
+		}
+		tokenLimit = limit
+	}
+
+	reader, err := sources.open(ctx, cfg.Input)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	writer, err := newWriter(format, outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &models.EmbeddingSummary{
+		Model:      cfg.Model,
+		Format:     format,
+		Normalized: cfg.Normalize,
+	}
+
+	start := time.Now()
+	batch := make([]Item, 0, batchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		texts := make([]string, len(batch))
+		for i, item := range batch {
+			texts[i] = item.Text
+		}
+		vectors, err := backend.Embed(ctx, cfg.Model, texts)
+		if err != nil {
+			return fmt.Errorf("failed to embed batch at item %d: %w", summary.Items, err)
+		}
+		if len(vectors) != len(batch) {
+			return fmt.Errorf("backend returned %d vectors for %d inputs", len(vectors), len(batch))
+		}
+		for i, item := range batch {
+			vector := vectors[i]
+			if cfg.Normalize {
+				normalize(vector)
+			}
+			if summary.Dimensions == 0 {
+				summary.Dimensions = len(vector)
+			} else if len(vector) != summary.Dimensions {
+				return fmt.Errorf("item %s has %d dimensions, expected %d", item.ID, len(vector), summary.Dimensions)
+			}
+			if err := writer.Write(item, vector); err != nil {
+				return err
+			}
+			summary.Items++
+			if item.Truncated {
+				summary.TruncatedItems++
+			}
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		item, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			writer.Close()
+			return nil, err
+		}
+		item.Text, item.Truncated = truncateToTokens(item.Text, tokenLimit)
+		batch = append(batch, item)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				writer.Close()
+				return nil, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	artifacts, err := writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	elapsed := time.Since(start)
+	summary.Artifacts = artifacts
+	summary.DurationMs = elapsed.Milliseconds()
+	if elapsed > 0 {
+		summary.ItemsPerSecond = float64(summary.Items) / elapsed.Seconds()
+	}
+
+	log.Info().
+		Str("model", cfg.Model).
+		Int("items", summary.Items).
+		Int("truncated", summary.TruncatedItems).
+		Int("dimensions", summary.Dimensions).
+		Float64("items_per_second", summary.ItemsPerSecond).
+		Msg("Embedding run completed")
+
+	return summary, nil
+}
+
+// truncateToTokens bounds text to the estimated token limit, cutting on a rune boundary
+func truncateToTokens(text string, tokenLimit int) (string, bool) {
+	if tokenLimit <= 0 {
+		return text, false
+	}
+	maxRunes := tokenLimit * charsPerToken
+	runes := []rune(text)
+	if len(runes) <= maxRunes {
+		return text, false
+	}
+	return string(runes[:maxRunes]), true
+}
+
+func normalize(vector []float32) {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	if sum == 0 {
+		return
+	}
+	norm := float32(math.Sqrt(sum))
+	for i := range vector {
+		vector[i] /= norm
+	}
+}
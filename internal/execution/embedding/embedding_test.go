@@ -0,0 +1,213 @@
+package embedding
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/unixfs"
+)
+
+// mockBackend embeds a text as [rune count, 3, 4] and records batch sizes
+type mockBackend struct {
+	contextLength int
+	batches       []int
+	maxRunes      int
+}
+
+func (b *mockBackend) Embed(ctx context.Context, model string, inputs []string) ([][]float32, error) {
+	b.batches = append(b.batches, len(inputs))
+	vectors := make([][]float32, len(inputs))
+	for i, input := range inputs {
+		n := utf8.RuneCountInString(input)
+		if n > b.maxRunes {
+			b.maxRunes = n
+		}
+		vectors[i] = []float32{float32(n), 3, 4}
+	}
+	return vectors, nil
+}
+
+func (b *mockBackend) ContextLength(ctx context.Context, model string) (int, error) {
+	return b.contextLength, nil
+}
+
+// corpusServer serves the test corpus as a gateway does, under its CID,
+// and serve, when set, under any other path
+func corpusServer(t *testing.T, serve []byte) (*httptest.Server, string) {
+	t.Helper()
+	corpus, err := os.ReadFile(filepath.Join("testdata", "corpus.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cid, err := unixfs.FileCID(bytes.NewReader(corpus))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/ipfs/"+cid:
+			w.Write(corpus)
+		case serve != nil:
+			w.Write(serve)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, cid
+}
+
+func TestRunJSONLTruncatesOverLengthItems(t *testing.T) {
+	server, cid := corpusServer(t, nil)
+	backend := &mockBackend{contextLength: 50}
+	outputDir := t.TempDir()
+
+	summary, err := Run(context.Background(), backend, Sources{}, &Config{
+		Model:     "nomic-embed-text",
+		Input:     InputSource{CID: cid, IPFSGateway: server.URL + "/ipfs/"},
+		BatchSize: 2,
+	}, outputDir)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if summary.Items != 5 || summary.TruncatedItems != 2 || summary.Dimensions != 3 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+	if want := []int{2, 2, 1}; len(backend.batches) != len(want) || backend.batches[0] != 2 || backend.batches[2] != 1 {
+		t.Fatalf("expected batches %v, got %v", want, backend.batches)
+	}
+	if backend.maxRunes > 50*charsPerToken {
+		t.Fatalf("backend received %d runes, limit is %d", backend.maxRunes, 50*charsPerToken)
+	}
+
+	file, err := os.Open(filepath.Join(outputDir, jsonlFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []jsonlRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record jsonlRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	wantIDs := []string{"0", "doc-2", "7", "3", "doc-5"}
+	wantTruncated := []bool{false, false, true, false, true}
+	for i, record := range records {
+		if record.ID != wantIDs[i] || record.Truncated != wantTruncated[i] {
+			t.Fatalf("record %d = {id:%s truncated:%v}, want {id:%s truncated:%v}",
+				i, record.ID, record.Truncated, wantIDs[i], wantTruncated[i])
+		}
+	}
+	if len(records) != len(wantIDs) {
+		t.Fatalf("expected %d records, got %d", len(wantIDs), len(records))
+	}
+}
+
+func TestRunFloat32NormalizedWithIndex(t *testing.T) {
+	backend := &mockBackend{contextLength: 512}
+	outputDir := t.TempDir()
+
+	summary, err := Run(context.Background(), backend, Sources{}, &Config{
+		Model:     "nomic-embed-text",
+		Input:     InputSource{Texts: []string{"", "abc"}},
+		Normalize: true,
+		Format:    models.EmbeddingFormatFloat32,
+	}, outputDir)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(summary.Artifacts) != 2 {
+		t.Fatalf("expected vectors and index artifacts, got %v", summary.Artifacts)
+	}
+
+	indexData, err := os.ReadFile(filepath.Join(outputDir, indexFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index Float32Index
+	if err := json.Unmarshal(indexData, &index); err != nil {
+		t.Fatal(err)
+	}
+	if index.Count != 2 || index.Dimensions != 3 || index.Entries[1].Offset != 12 {
+		t.Fatalf("unexpected index: %+v", index)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(outputDir, float32FileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) != 2*3*4 {
+		t.Fatalf("expected 24 bytes of vectors, got %d", len(raw))
+	}
+
+	// "" embeds as [0,3,4], which normalizes to [0,0.6,0.8]
+	want := []float32{0, 0.6, 0.8}
+	for i, w := range want {
+		got := math.Float32frombits(binary.LittleEndian.Uint32(raw[4*i:]))
+		if math.Abs(float64(got-w)) > 1e-6 {
+			t.Fatalf("component %d = %f, want %f", i, got, w)
+		}
+	}
+}
+
+func TestConfigValidateRequiresSingleSource(t *testing.T) {
+	cfg := &Config{Model: "m", Input: InputSource{Texts: []string{"a"}, FileURL: "http://example.com/x.jsonl"}}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected error for multiple input sources")
+	}
+	cfg.Input = InputSource{}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("expected error for missing input source")
+	}
+}
+
+func TestRunRejectsUnverifiedInputs(t *testing.T) {
+	forged := []byte(`"a document the task did not name"` + "\n")
+	server, cid := corpusServer(t, forged)
+	backend := &mockBackend{contextLength: 512}
+
+	// A gateway serving other content under the CID
+	forgedCID, err := unixfs.FileCID(bytes.NewReader([]byte("the task's corpus\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Run(context.Background(), backend, Sources{}, &Config{
+		Model: "nomic-embed-text",
+		Input: InputSource{CID: forgedCID, IPFSGateway: server.URL + "/ipfs/"},
+	}, t.TempDir())
+	if !errors.Is(err, unixfs.ErrMismatch) {
+		t.Fatalf("Run() error = %v, want a CID mismatch", err)
+	}
+
+	// An input larger than the runner takes
+	_, err = Run(context.Background(), backend, Sources{MaxBytes: 64}, &Config{
+		Model: "nomic-embed-text",
+		Input: InputSource{CID: cid, IPFSGateway: server.URL + "/ipfs/"},
+	}, t.TempDir())
+	if err == nil || !strings.Contains(err.Error(), "larger than 64 bytes") {
+		t.Fatalf("Run() error = %v, want the input rejected as too large", err)
+	}
+	if len(backend.batches) != 0 {
+		t.Fatalf("backend embedded %v batches of rejected inputs", backend.batches)
+	}
+}
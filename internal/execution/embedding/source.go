@@ -0,0 +1,279 @@
+package embedding
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+	"github.com/theblitlabs/parity-runner/internal/unixfs"
+)
+
+const (
+	defaultIPFSGateway = "https://ipfs.io/ipfs/"
+	maxLineBytes       = 16 * 1024 * 1024
+
+	// DefaultMaxInputBytes caps inputs of runners not configuring a cap
+	DefaultMaxInputBytes = 1 << 30
+	// defaultFetchTimeout bounds downloading an input
+	defaultFetchTimeout = 30 * time.Minute
+)
+
+// CIDOpener fetches files by CID, verifying what gateways serve
+type CIDOpener interface {
+	Open(ctx context.Context, cid string) (io.ReadCloser, error)
+}
+
+// Sources fetches the inputs of tasks that are not given inline. Inputs are
+// downloaded in full, at most MaxBytes within Timeout, and those named by
+// CID are checked against it before any of their documents is embedded.
+// Zero fields take their defaults.
+type Sources struct {
+	// Client requests file URLs and the task's IPFS gateway
+	Client *http.Client
+	// IPFS fetches CIDs instead of the task's gateway when set
+	IPFS     CIDOpener
+	MaxBytes int64
+	Timeout  time.Duration
+}
+
+// InputSource selects exactly one of inline texts, a JSONL file URL or a JSONL IPFS CID
+type InputSource struct {
+	Texts       []string `json:"texts,omitempty"`
+	FileURL     string   `json:"file_url,omitempty"`
+	CID         string   `json:"cid,omitempty"`
+	IPFSGateway string   `json:"ipfs_gateway,omitempty"`
+}
+
+func (s *InputSource) Validate() error {
+	sources := 0
+	if len(s.Texts) > 0 {
+		sources++
+	}
+	if s.FileURL != "" {
+		sources++
+	}
+	if s.CID != "" {
+		sources++
+	}
+	if sources != 1 {
+		return errors.New("embedding input requires exactly one of texts, file_url or cid")
+	}
+	return nil
+}
+
+// Item is a single document to embed. Truncated is set when the text was cut
+// to fit the model token limit.
+type Item struct {
+	ID        string
+	Text      string
+	Truncated bool
+}
+
+type itemReader interface {
+	Next() (Item, error)
+	Close() error
+}
+
+func (s Sources) open(ctx context.Context, src InputSource) (itemReader, error) {
+	if len(src.Texts) > 0 {
+		return &inlineReader{texts: src.Texts}, nil
+	}
+
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = defaultFetchTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var body io.ReadCloser
+	var err error
+	if src.CID != "" && s.IPFS != nil {
+		body, err = s.IPFS.Open(ctx, src.CID)
+	} else {
+		url := src.FileURL
+		if src.CID != "" {
+			gateway := src.IPFSGateway
+			if gateway == "" {
+				gateway = defaultIPFSGateway
+			}
+			url = strings.TrimSuffix(gateway, "/") + "/" + src.CID
+		}
+		body, err = s.get(ctx, url)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch embedding input: %w", err)
+	}
+	defer body.Close()
+
+	file, err := s.download(body)
+	if err != nil {
+		return nil, err
+	}
+	if src.CID != "" {
+		if err := verifyCID(file, src.CID); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	return newJSONLReader(file), nil
+}
+
+func (s Sources) get(ctx context.Context, url string) (io.ReadCloser, error) {
+	client := s.Client
+	if client == nil {
+		client = dualstack.Client(0)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// download copies body into a scratch file removed when closed, failing
+// when it holds more than MaxBytes
+func (s Sources) download(body io.Reader) (*scratchFile, error) {
+	maxBytes := s.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxInputBytes
+	}
+	f, err := os.CreateTemp("", "parity-embedding-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create input file: %w", err)
+	}
+	file := &scratchFile{File: f}
+	n, err := io.Copy(file, io.LimitReader(body, maxBytes+1))
+	if err == nil && n > maxBytes {
+		err = fmt.Errorf("embedding input is larger than %d bytes", maxBytes)
+	} else if err != nil {
+		err = fmt.Errorf("failed to fetch embedding input: %w", err)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// verifyCID checks that file holds the content cid names, and rewinds it
+func verifyCID(file *scratchFile, cid string) error {
+	want, err := unixfs.Parse(cid)
+	if err != nil {
+		return err
+	}
+	got, err := unixfs.Import(file, nil)
+	if err != nil {
+		return fmt.Errorf("failed to verify embedding input: %w", err)
+	}
+	if !got.Equal(want) {
+		return fmt.Errorf("%w: embedding input %s was served as %s", unixfs.ErrMismatch, cid, got)
+	}
+	_, err = file.Seek(0, io.SeekStart)
+	return err
+}
+
+// scratchFile is a downloaded input, removed once read
+type scratchFile struct {
+	*os.File
+}
+
+func (f *scratchFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.Name())
+	return err
+}
+
+type inlineReader struct {
+	texts []string
+	pos   int
+}
+
+func (r *inlineReader) Next() (Item, error) {
+	if r.pos >= len(r.texts) {
+		return Item{}, io.EOF
+	}
+	item := Item{ID: strconv.Itoa(r.pos), Text: r.texts[r.pos]}
+	r.pos++
+	return item, nil
+}
+
+func (r *inlineReader) Close() error { return nil }
+
+// jsonlReader reads one document per line, either a JSON string or an
+// object with "text" and an optional "id"
+type jsonlReader struct {
+	body    io.ReadCloser
+	scanner *bufio.Scanner
+	line    int
+	index   int
+}
+
+func newJSONLReader(body io.ReadCloser) *jsonlReader {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), maxLineBytes)
+	return &jsonlReader{body: body, scanner: scanner}
+}
+
+func (r *jsonlReader) Next() (Item, error) {
+	for r.scanner.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		item := Item{ID: strconv.Itoa(r.index)}
+		if line[0] == '"' {
+			if err := json.Unmarshal(line, &item.Text); err != nil {
+				return Item{}, fmt.Errorf("invalid input on line %d: %w", r.line, err)
+			}
+		} else {
+			var record struct {
+				ID   json.RawMessage `json:"id"`
+				Text *string         `json:"text"`
+			}
+			if err := json.Unmarshal(line, &record); err != nil {
+				return Item{}, fmt.Errorf("invalid input on line %d: %w", r.line, err)
+			}
+			if record.Text == nil {
+				return Item{}, fmt.Errorf("input on line %d has no text field", r.line)
+			}
+			item.Text = *record.Text
+			if len(record.ID) > 0 {
+				item.ID = strings.Trim(string(record.ID), `"`)
+			}
+		}
+		r.index++
+		return item, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return Item{}, fmt.Errorf("failed to read embedding input: %w", err)
+	}
+	return Item{}, io.EOF
+}
+
+func (r *jsonlReader) Close() error {
+	return r.body.Close()
+}
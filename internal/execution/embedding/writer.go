@@ -0,0 +1,157 @@
+package embedding
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const (
+	jsonlFileName   = "embeddings.jsonl"
+	float32FileName = "embeddings.f32"
+	indexFileName   = "embeddings.index.json"
+)
+
+type vectorWriter interface {
+	Write(item Item, vector []float32) error
+	// Close flushes the artifact and returns the paths of the files written
+	Close() ([]string, error)
+}
+
+func newWriter(format models.EmbeddingFormat, dir string) (vectorWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create output directory: %w", err)
+	}
+	switch format {
+	case models.EmbeddingFormatFloat32:
+		return newFloat32Writer(dir)
+	default:
+		return newJSONLWriter(dir)
+	}
+}
+
+type jsonlRecord struct {
+	ID        string    `json:"id"`
+	Embedding []float32 `json:"embedding"`
+	Truncated bool      `json:"truncated,omitempty"`
+}
+
+type jsonlWriter struct {
+	path string
+	file *os.File
+	buf  *bufio.Writer
+	enc  *json.Encoder
+}
+
+func newJSONLWriter(dir string) (*jsonlWriter, error) {
+	path := filepath.Join(dir, jsonlFileName)
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings file: %w", err)
+	}
+	buf := bufio.NewWriter(file)
+	return &jsonlWriter{path: path, file: file, buf: buf, enc: json.NewEncoder(buf)}, nil
+}
+
+func (w *jsonlWriter) Write(item Item, vector []float32) error {
+	if err := w.enc.Encode(jsonlRecord{ID: item.ID, Embedding: vector, Truncated: item.Truncated}); err != nil {
+		return fmt.Errorf("failed to write embedding: %w", err)
+	}
+	return nil
+}
+
+func (w *jsonlWriter) Close() ([]string, error) {
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return nil, fmt.Errorf("failed to flush embeddings: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close embeddings file: %w", err)
+	}
+	return []string{w.path}, nil
+}
+
+// Float32Index describes a float32 artifact: vectors are stored row-major as
+// little-endian float32 values, one row of Dimensions values per entry
+type Float32Index struct {
+	DType      string              `json:"dtype"`
+	ByteOrder  string              `json:"byte_order"`
+	Dimensions int                 `json:"dimensions"`
+	Count      int                 `json:"count"`
+	Entries    []Float32IndexEntry `json:"entries"`
+}
+
+type Float32IndexEntry struct {
+	ID        string `json:"id"`
+	Offset    int64  `json:"offset"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+type float32Writer struct {
+	dir    string
+	file   *os.File
+	buf    *bufio.Writer
+	offset int64
+	index  Float32Index
+	row    []byte
+}
+
+func newFloat32Writer(dir string) (*float32Writer, error) {
+	file, err := os.Create(filepath.Join(dir, float32FileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embeddings file: %w", err)
+	}
+	return &float32Writer{
+		dir:   dir,
+		file:  file,
+		buf:   bufio.NewWriter(file),
+		index: Float32Index{DType: "float32", ByteOrder: "little"},
+	}, nil
+}
+
+func (w *float32Writer) Write(item Item, vector []float32) error {
+	if w.index.Dimensions == 0 {
+		w.index.Dimensions = len(vector)
+		w.row = make([]byte, 4*len(vector))
+	}
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(w.row[4*i:], math.Float32bits(v))
+	}
+	if _, err := w.buf.Write(w.row); err != nil {
+		return fmt.Errorf("failed to write embedding: %w", err)
+	}
+	w.index.Entries = append(w.index.Entries, Float32IndexEntry{
+		ID:        item.ID,
+		Offset:    w.offset,
+		Truncated: item.Truncated,
+	})
+	w.offset += int64(len(w.row))
+	w.index.Count++
+	return nil
+}
+
+func (w *float32Writer) Close() ([]string, error) {
+	if err := w.buf.Flush(); err != nil {
+		w.file.Close()
+		return nil, fmt.Errorf("failed to flush embeddings: %w", err)
+	}
+	if err := w.file.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close embeddings file: %w", err)
+	}
+
+	data, err := json.Marshal(w.index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embeddings index: %w", err)
+	}
+	indexPath := filepath.Join(w.dir, indexFileName)
+	if err := os.WriteFile(indexPath, data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write embeddings index: %w", err)
+	}
+	return []string{w.file.Name(), indexPath}, nil
+}
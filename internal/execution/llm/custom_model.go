@@ -0,0 +1,325 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+)
+
+// customModelPrefix starts the names model artifacts are loaded into the
+// backend as, so they are never mistaken for models the operator pulled
+const customModelPrefix = "parity-gguf-"
+
+var (
+	// ErrCustomModelsDisabled is returned for tasks supplying a model on
+	// runners that do not load them
+	ErrCustomModelsDisabled = errors.New("custom models are not enabled on this runner")
+	// ErrModelTooLarge is returned for models that do not fit in the memory
+	// the runner has for model weights
+	ErrModelTooLarge = errors.New("model does not fit in the memory available for model weights")
+)
+
+// ModelArtifact is a GGUF model supplied by a task's creator, which the
+// task's prompts are served with instead of a model the runner offers
+type ModelArtifact struct {
+	Source models.InputSource `json:"source"`
+	SHA256 string             `json:"sha256"`
+	Size   int64              `json:"size,omitempty"`
+	// Quantization is the quantization the model must have, such as Q4_K_M
+	Quantization string `json:"quantization"`
+	// License is the model's license, recorded in the result with whether
+	// the creator accepted its terms
+	License *models.ModelLicense `json:"license,omitempty"`
+}
+
+// Validate checks the artifact is located by URL or CID and pinned by hash
+func (a *ModelArtifact) Validate() error {
+	if a.Source.Task != nil {
+		return errors.New("model_artifact: source must be a url or cid")
+	}
+	if a.SHA256 == "" {
+		return errors.New("model_artifact: sha256 is required")
+	}
+	if err := a.Input().Validate(); err != nil {
+		return fmt.Errorf("model_artifact: %w", err)
+	}
+	known := false
+	for _, q := range Quantizations() {
+		known = known || q == a.Quantization
+	}
+	if !known {
+		return fmt.Errorf("model_artifact: unsupported quantization %q (supported: %s)", a.Quantization, strings.Join(Quantizations(), ", "))
+	}
+	return nil
+}
+
+// ModelName is the name the artifact is loaded into the backend as. It
+// derives from the hash, so tasks supplying the same model share it.
+func (a *ModelArtifact) ModelName() string {
+	return CanonicalModelName(customModelPrefix + a.SHA256[:min(len(a.SHA256), 16)])
+}
+
+// Input returns the artifact as an input to fetch
+func (a *ModelArtifact) Input() *models.TaskInput {
+	return &models.TaskInput{
+		Name:       "model",
+		Source:     a.Source,
+		SHA256:     a.SHA256,
+		Size:       a.Size,
+		TargetPath: "model.gguf",
+	}
+}
+
+// LoadError is returned for model artifacts that failed to load, with the
+// reason as one of the models.ModelLoad codes
+type LoadError struct {
+	Code string
+	Err  error
+}
+
+func (e *LoadError) Error() string {
+	return fmt.Sprintf("model load failed (%s): %v", e.Code, e.Err)
+}
+
+func (e *LoadError) Unwrap() error {
+	return e.Err
+}
+
+// Fetcher downloads a model artifact, verified against its hash
+type Fetcher interface {
+	Fetch(ctx context.Context, spec *models.TaskInput, scratch string) (string, error)
+}
+
+// ModelLoader loads model artifacts supplied by tasks into Ollama. Loaded
+// models join the runner's model cache, so tasks supplying the same model
+// find it there until it is evicted under the cache budget, or, when not
+// kept, are deleted once the task's prompts are served.
+type ModelLoader struct {
+	baseURL string
+	client  *http.Client
+	store   *ModelStore
+	fetcher Fetcher
+	// budget returns the bytes available for model weights, 0 when unknown
+	budget func() uint64
+	keep   bool
+}
+
+func NewModelLoader(baseURL string, fetcher Fetcher) *ModelLoader {
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	return &ModelLoader{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: 30 * time.Minute},
+		store:   NewModelStore(baseURL),
+		fetcher: fetcher,
+		budget:  func() uint64 { return hardware.Detect().ModelMemoryBudget() },
+		keep:    true,
+	}
+}
+
+// SetMemoryBudget replaces how the memory available for model weights is
+// learned, such as to count the free VRAM of the runner's GPUs
+func (l *ModelLoader) SetMemoryBudget(budget func() uint64) {
+	l.budget = budget
+}
+
+// SetKeep decides whether loaded models stay in the model cache after
+// their task, true by default
+func (l *ModelLoader) SetKeep(keep bool) {
+	l.keep = keep
+}
+
+// Fits checks before a model is downloaded that its declared size fits in
+// the memory available for model weights, returning an error wrapping
+// ErrModelTooLarge when it does not
+func (l *ModelLoader) Fits(size int64) error {
+	budget := l.budget()
+	if size > 0 && !hardware.FitsBudget(uint64(size), budget) {
+		return fmt.Errorf("%w: %d bytes, %d available", ErrModelTooLarge, size, budget)
+	}
+	return nil
+}
+
+// Load makes the model artifact available to generate with under its
+// ModelName, downloading it into scratch or the input cache and creating it
+// in the backend unless the backend already holds it. Artifacts that fail
+// to load return a *LoadError.
+func (l *ModelLoader) Load(ctx context.Context, artifact *ModelArtifact, scratch string) (*models.CustomModelReport, error) {
+	log := gologger.WithComponent("custom_models")
+	name := artifact.ModelName()
+	report := &models.CustomModelReport{
+		Model:        name,
+		SHA256:       artifact.SHA256,
+		SizeBytes:    artifact.Size,
+		Quantization: artifact.Quantization,
+		License:      artifact.License,
+	}
+
+	if has, err := l.store.Has(ctx, name); err == nil && has {
+		report.Cached = true
+		return report, nil
+	}
+	if err := l.Fits(artifact.Size); err != nil {
+		return report, &LoadError{Code: models.ModelLoadInsufficientMemory, Err: err}
+	}
+
+	path, err := l.fetcher.Fetch(ctx, artifact.Input(), scratch)
+	if err != nil {
+		code := models.ModelLoadDownload
+		if errors.Is(err, inputs.ErrVerification) {
+			code = models.ModelLoadHashMismatch
+		}
+		return report, &LoadError{Code: code, Err: err}
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return report, fmt.Errorf("failed to stat model: %w", err)
+	}
+	report.SizeBytes = stat.Size()
+	if err := l.Fits(stat.Size()); err != nil {
+		return report, &LoadError{Code: models.ModelLoadInsufficientMemory, Err: err}
+	}
+
+	info, err := ReadGGUF(path)
+	if err != nil {
+		return report, err
+	}
+	report.Architecture = info.Architecture
+	if info.Quantization != artifact.Quantization {
+		return report, &LoadError{
+			Code: models.ModelLoadQuantizationMismatch,
+			Err:  fmt.Errorf("model is quantized as %q, the task requires %s", info.Quantization, artifact.Quantization),
+		}
+	}
+	report.Quantization = info.Quantization
+
+	if err := l.pushBlob(ctx, path, artifact.SHA256); err != nil {
+		return report, err
+	}
+	if err := l.create(ctx, name, artifact.SHA256); err != nil {
+		return report, err
+	}
+	log.Info().
+		Str("model", name).
+		Str("sha256", artifact.SHA256).
+		Str("quantization", report.Quantization).
+		Str("architecture", report.Architecture).
+		Int64("size_bytes", report.SizeBytes).
+		Msg("Loaded custom model")
+	return report, nil
+}
+
+// pushBlob uploads the model file to the backend unless it holds it already
+func (l *ModelLoader) pushBlob(ctx context.Context, path, sha256 string) error {
+	url := l.baseURL + "/api/blobs/sha256:" + sha256
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to query model blob: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open model: %w", err)
+	}
+	defer f.Close()
+	req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, f)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err = l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload model blob: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return &LoadError{Code: models.ModelLoadBackendRejected, Err: backendError("blob upload", resp)}
+	}
+	return nil
+}
+
+// create creates model name in the backend from the uploaded blob
+func (l *ModelLoader) create(ctx context.Context, name, sha256 string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"model":  name,
+		"files":  map[string]string{"model.gguf": "sha256:" + sha256},
+		"stream": false,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.baseURL+"/api/create", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to create model: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return &LoadError{Code: models.ModelLoadBackendRejected, Err: backendError("create", resp)}
+	}
+	return nil
+}
+
+// Release unloads model name from memory once its task is served, deleting
+// it from the backend too when models are not kept
+func (l *ModelLoader) Release(ctx context.Context, name string) error {
+	body, err := json.Marshal(map[string]interface{}{"model": name, "keep_alive": 0})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.baseURL+"/api/generate", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := l.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to unload model: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return backendError("unload", resp)
+	}
+	if l.keep {
+		return nil
+	}
+	return l.store.Remove(ctx, name)
+}
+
+// backendError describes a failed backend request from its status and the
+// error Ollama answers with
+func backendError(op string, resp *http.Response) error {
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	var parsed struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(msg, &parsed) == nil && parsed.Error != "" {
+		msg = []byte(parsed.Error)
+	}
+	return fmt.Errorf("ollama %s failed with status %d: %s", op, resp.StatusCode, strings.TrimSpace(string(msg)))
+}
@@ -0,0 +1,268 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+)
+
+// fakeBackend mocks the Ollama API models are loaded through: blobs are
+// kept by digest, and created models listed until deleted
+type fakeBackend struct {
+	*httptest.Server
+	rejectCreate atomic.Bool
+
+	mu       sync.Mutex
+	blobs    map[string][]byte
+	created  map[string]string
+	unloaded []string
+}
+
+func newFakeBackend(t *testing.T) *fakeBackend {
+	b := &fakeBackend{blobs: make(map[string][]byte), created: make(map[string]string)}
+	b.Server = httptest.NewServer(http.HandlerFunc(b.serve))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func (b *fakeBackend) serve(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/blobs/sha256:"):
+		digest := strings.TrimPrefix(r.URL.Path, "/api/blobs/sha256:")
+		if r.Method == http.MethodHead {
+			if _, ok := b.blobs[digest]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != digest {
+			http.Error(w, `{"error":"digest mismatch"}`, http.StatusBadRequest)
+			return
+		}
+		b.blobs[digest] = data
+		w.WriteHeader(http.StatusCreated)
+	case r.URL.Path == "/api/create":
+		var req struct {
+			Model string            `json:"model"`
+			Files map[string]string `json:"files"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		digest := strings.TrimPrefix(req.Files["model.gguf"], "sha256:")
+		if _, ok := b.blobs[digest]; !ok || b.rejectCreate.Load() {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unsupported model architecture"})
+			return
+		}
+		b.created[req.Model] = digest
+		json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+	case r.URL.Path == "/api/tags":
+		var list ListResponse
+		for name, digest := range b.created {
+			list.Models = append(list.Models, Model{Name: name, Digest: digest, Size: int64(len(b.blobs[digest]))})
+		}
+		json.NewEncoder(w).Encode(list)
+	case r.URL.Path == "/api/generate":
+		var req struct {
+			Model     string `json:"model"`
+			KeepAlive *int   `json:"keep_alive"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.KeepAlive != nil && *req.KeepAlive == 0 {
+			b.unloaded = append(b.unloaded, req.Model)
+		}
+		json.NewEncoder(w).Encode(GenerateResponse{Done: true})
+	case r.URL.Path == "/api/delete":
+		var req struct {
+			Model string `json:"model"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		delete(b.created, req.Model)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (b *fakeBackend) models() map[string]string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	created := make(map[string]string, len(b.created))
+	for name, digest := range b.created {
+		created[name] = digest
+	}
+	return created
+}
+
+// serveFixtures serves testdata over HTTP, counting downloads
+func serveFixtures(t *testing.T, downloads *atomic.Int32) *httptest.Server {
+	files := http.FileServer(http.Dir("testdata"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads.Add(1)
+		files.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func fixtureArtifact(t *testing.T, server *httptest.Server, name string) *ModelArtifact {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return &ModelArtifact{
+		Source:       models.InputSource{URL: server.URL + "/" + name},
+		SHA256:       hex.EncodeToString(sum[:]),
+		Size:         int64(len(data)),
+		Quantization: "Q4_K_M",
+		License:      &models.ModelLicense{Name: "apache-2.0", Accepted: true},
+	}
+}
+
+func newTestLoader(t *testing.T, backend *fakeBackend) *ModelLoader {
+	t.Helper()
+	manager, err := inputs.NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := NewModelLoader(backend.URL, manager)
+	loader.SetMemoryBudget(func() uint64 { return 1 << 30 })
+	return loader
+}
+
+func TestLoadCustomModel(t *testing.T) {
+	backend := newFakeBackend(t)
+	var downloads atomic.Int32
+	files := serveFixtures(t, &downloads)
+	loader := newTestLoader(t, backend)
+	artifact := fixtureArtifact(t, files, "tiny.gguf")
+	if err := artifact.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	report, err := loader.Load(context.Background(), artifact, t.TempDir())
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	name := "parity-gguf-" + artifact.SHA256[:16] + ":latest"
+	if report.Model != name || report.Cached || report.Architecture != "llama" || report.Quantization != "Q4_K_M" || report.SizeBytes != artifact.Size {
+		t.Errorf("report = %+v", report)
+	}
+	if report.License == nil || !report.License.Accepted || report.License.Name != "apache-2.0" {
+		t.Errorf("license = %+v, want the accepted license recorded", report.License)
+	}
+	if got := backend.models()[name]; got != artifact.SHA256 {
+		t.Fatalf("backend models = %v, want %s created from the artifact", backend.models(), name)
+	}
+
+	// A task supplying the same model finds it in the backend
+	report, err = loader.Load(context.Background(), artifact, t.TempDir())
+	if err != nil || !report.Cached || downloads.Load() != 1 {
+		t.Fatalf("second Load() = %+v, %v after %d downloads, want the cached model", report, err, downloads.Load())
+	}
+
+	if err := loader.Release(context.Background(), name); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if len(backend.unloaded) != 1 || backend.models()[name] == "" {
+		t.Errorf("unloaded %v with models %v, want the model unloaded but kept", backend.unloaded, backend.models())
+	}
+	loader.SetKeep(false)
+	if err := loader.Release(context.Background(), name); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if _, ok := backend.models()[name]; ok {
+		t.Error("model kept after release with keep off")
+	}
+}
+
+func TestCustomModelLoadFailures(t *testing.T) {
+	var downloads atomic.Int32
+	files := serveFixtures(t, &downloads)
+	notGGUF := filepath.Join(t.TempDir(), "model.bin")
+	if err := os.WriteFile(notGGUF, []byte("PK\x03\x04 not a model at all"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		fixture string
+		adjust  func(a *ModelArtifact, b *fakeBackend, l *ModelLoader)
+		code    string
+	}{
+		{"truncated", "truncated.gguf", nil, models.ModelLoadMalformed},
+		{"quantization", "tiny.gguf", func(a *ModelArtifact, b *fakeBackend, l *ModelLoader) {
+			a.Quantization = "Q8_0"
+		}, models.ModelLoadQuantizationMismatch},
+		{"hash", "tiny.gguf", func(a *ModelArtifact, b *fakeBackend, l *ModelLoader) {
+			a.SHA256 = strings.Repeat("0", 64)
+		}, models.ModelLoadHashMismatch},
+		{"memory", "tiny.gguf", func(a *ModelArtifact, b *fakeBackend, l *ModelLoader) {
+			l.SetMemoryBudget(func() uint64 { return 100 })
+		}, models.ModelLoadInsufficientMemory},
+		{"backend", "tiny.gguf", func(a *ModelArtifact, b *fakeBackend, l *ModelLoader) {
+			b.rejectCreate.Store(true)
+		}, models.ModelLoadBackendRejected},
+		{"missing", "tiny.gguf", func(a *ModelArtifact, b *fakeBackend, l *ModelLoader) {
+			a.Source.URL += ".missing"
+		}, models.ModelLoadDownload},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newFakeBackend(t)
+			loader := newTestLoader(t, backend)
+			artifact := fixtureArtifact(t, files, tt.fixture)
+			if tt.adjust != nil {
+				tt.adjust(artifact, backend, loader)
+			}
+			report, err := loader.Load(context.Background(), artifact, t.TempDir())
+			var loadErr *LoadError
+			if !errors.As(err, &loadErr) || loadErr.Code != tt.code {
+				t.Fatalf("Load() error = %v, want a %s load failure", err, tt.code)
+			}
+			if report == nil || report.SHA256 != artifact.SHA256 {
+				t.Errorf("report = %+v, want the artifact described", report)
+			}
+			if len(backend.models()) != 0 {
+				t.Errorf("backend models = %v, want none created", backend.models())
+			}
+		})
+	}
+
+	if _, err := ReadGGUF(notGGUF); !isLoadFailure(err, models.ModelLoadNotGGUF) {
+		t.Errorf("ReadGGUF(zip) error = %v, want not_gguf", err)
+	}
+	tiny, err := os.ReadFile(filepath.Join("testdata", "tiny.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := filepath.Join(t.TempDir(), "v1.gguf")
+	if err := os.WriteFile(v1, append([]byte("GGUF\x01\x00\x00\x00"), tiny[8:]...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadGGUF(v1); !isLoadFailure(err, models.ModelLoadUnsupportedVersion) {
+		t.Errorf("ReadGGUF(v1) error = %v, want unsupported_version", err)
+	}
+}
+
+func isLoadFailure(err error, code string) bool {
+	var loadErr *LoadError
+	return errors.As(err, &loadErr) && loadErr.Code == code
+}
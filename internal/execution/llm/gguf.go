@@ -0,0 +1,302 @@
+package llm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const (
+	ggufMagic            = "GGUF"
+	ggufDefaultAlignment = 32
+	// Bounds on what a header may declare, so that a damaged one fails
+	// instead of exhausting memory
+	ggufMaxString  = 1 << 20
+	ggufMaxArray   = 1 << 24
+	ggufMaxEntries = 1 << 20
+	ggufMaxDims    = 8
+)
+
+// GGUF metadata value types
+const (
+	ggufUint8 uint32 = iota
+	ggufInt8
+	ggufUint16
+	ggufInt16
+	ggufUint32
+	ggufInt32
+	ggufFloat32
+	ggufBool
+	ggufString
+	ggufArray
+	ggufUint64
+	ggufInt64
+	ggufFloat64
+)
+
+// ggufFileTypes names the quantizations of general.file_type
+var ggufFileTypes = map[uint32]string{
+	0:  "F32",
+	1:  "F16",
+	2:  "Q4_0",
+	3:  "Q4_1",
+	7:  "Q8_0",
+	8:  "Q5_0",
+	9:  "Q5_1",
+	10: "Q2_K",
+	11: "Q3_K_S",
+	12: "Q3_K_M",
+	13: "Q3_K_L",
+	14: "Q4_K_S",
+	15: "Q4_K_M",
+	16: "Q5_K_S",
+	17: "Q5_K_M",
+	18: "Q6_K",
+	32: "BF16",
+}
+
+// ggmlBlock is the size in bytes of a block of elements of a tensor type
+type ggmlBlock struct {
+	bytes, elements uint64
+}
+
+// ggmlBlocks sizes the tensor types quantizations use. Tensors of other
+// types are located but not sized.
+var ggmlBlocks = map[uint32]ggmlBlock{
+	0:  {4, 1},     // F32
+	1:  {2, 1},     // F16
+	2:  {18, 32},   // Q4_0
+	3:  {20, 32},   // Q4_1
+	6:  {22, 32},   // Q5_0
+	7:  {24, 32},   // Q5_1
+	8:  {34, 32},   // Q8_0
+	10: {84, 256},  // Q2_K
+	11: {110, 256}, // Q3_K
+	12: {144, 256}, // Q4_K
+	13: {176, 256}, // Q5_K
+	14: {210, 256}, // Q6_K
+	30: {2, 1},     // BF16
+}
+
+// GGUFInfo is what the runner reads of a GGUF model file
+type GGUFInfo struct {
+	Version      uint32
+	Architecture string
+	// Quantization names general.file_type, empty when the file does not
+	// declare it
+	Quantization string
+	Tensors      uint64
+}
+
+// Quantizations returns the quantization names a model artifact may
+// require
+func Quantizations() []string {
+	names := make([]string, 0, len(ggufFileTypes))
+	for _, name := range ggufFileTypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ReadGGUF reads the header and tensor table of the GGUF file at path,
+// checking that every tensor lies within the file. Files that are not GGUF,
+// of a version other than 2 or 3, or damaged return a *LoadError.
+func ReadGGUF(path string) (*GGUFInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open model: %w", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat model: %w", err)
+	}
+
+	r := &ggufReader{r: bufio.NewReader(f)}
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r.r, magic); err != nil || string(magic) != ggufMagic {
+		return nil, &LoadError{Code: models.ModelLoadNotGGUF, Err: errors.New("file does not start with the GGUF magic")}
+	}
+	r.pos = 4
+
+	info := &GGUFInfo{Version: r.uint32()}
+	if r.err == nil && info.Version != 2 && info.Version != 3 {
+		return nil, &LoadError{Code: models.ModelLoadUnsupportedVersion, Err: fmt.Errorf("GGUF version %d is not supported", info.Version)}
+	}
+	info.Tensors = r.uint64()
+	entries := r.uint64()
+	if r.err == nil && (info.Tensors > ggufMaxEntries || entries > ggufMaxEntries) {
+		r.fail(fmt.Errorf("header declares %d tensors and %d metadata entries", info.Tensors, entries))
+	}
+
+	alignment := uint64(ggufDefaultAlignment)
+	for i := uint64(0); i < entries && r.err == nil; i++ {
+		key := r.string()
+		typ := r.uint32()
+		switch {
+		case key == "general.architecture" && typ == ggufString:
+			info.Architecture = r.string()
+		case key == "general.file_type" && typ == ggufUint32:
+			fileType := r.uint32()
+			info.Quantization = ggufFileTypes[fileType]
+			if info.Quantization == "" {
+				info.Quantization = fmt.Sprintf("file_type_%d", fileType)
+			}
+		case key == "general.alignment" && typ == ggufUint32:
+			alignment = uint64(r.uint32())
+			if r.err == nil && (alignment == 0 || alignment&(alignment-1) != 0) {
+				r.fail(fmt.Errorf("alignment %d is not a power of two", alignment))
+			}
+		default:
+			r.skip(typ)
+		}
+	}
+
+	// Tensor offsets are relative to the aligned start of the data that
+	// follows the tensor table
+	type tensor struct{ offset, size uint64 }
+	tensors := make([]tensor, 0, min(info.Tensors, 4096))
+	for i := uint64(0); i < info.Tensors && r.err == nil; i++ {
+		name := r.string()
+		dims := r.uint32()
+		if r.err == nil && (dims == 0 || dims > ggufMaxDims) {
+			r.fail(fmt.Errorf("tensor %s has %d dimensions", name, dims))
+		}
+		elements := uint64(1)
+		for d := uint32(0); d < dims && r.err == nil; d++ {
+			n := r.uint64()
+			if n != 0 && elements > math.MaxUint64/n {
+				r.fail(fmt.Errorf("tensor %s has too many elements", name))
+			}
+			elements *= n
+		}
+		typ := r.uint32()
+		offset := r.uint64()
+		var size uint64
+		if block, ok := ggmlBlocks[typ]; ok {
+			blocks := elements / block.elements
+			if elements%block.elements != 0 {
+				blocks++
+			}
+			if blocks > math.MaxUint64/block.bytes {
+				r.fail(fmt.Errorf("tensor %s has too many elements", name))
+			}
+			size = blocks * block.bytes
+		}
+		if r.err == nil && offset%alignment != 0 {
+			r.fail(fmt.Errorf("tensor %s is not aligned", name))
+		}
+		tensors = append(tensors, tensor{offset, size})
+	}
+	if r.err != nil {
+		return nil, &LoadError{Code: models.ModelLoadMalformed, Err: r.err}
+	}
+
+	dataStart := (r.pos + alignment - 1) / alignment * alignment
+	if dataStart > uint64(stat.Size()) {
+		return nil, &LoadError{Code: models.ModelLoadMalformed, Err: errors.New("file ends before its tensor data")}
+	}
+	data := uint64(stat.Size()) - dataStart
+	for _, t := range tensors {
+		if t.offset > data || t.size > data-t.offset {
+			return nil, &LoadError{Code: models.ModelLoadMalformed, Err: fmt.Errorf("tensor data runs past the end of the file (%d bytes of data)", data)}
+		}
+	}
+	return info, nil
+}
+
+// ggufReader reads little-endian GGUF values, keeping the first error and
+// the offset read to
+type ggufReader struct {
+	r   *bufio.Reader
+	pos uint64
+	err error
+}
+
+func (r *ggufReader) fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+func (r *ggufReader) read(n int) []byte {
+	if r.err != nil {
+		return make([]byte, n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		r.fail(fmt.Errorf("header truncated at offset %d", r.pos))
+		return buf
+	}
+	r.pos += uint64(n)
+	return buf
+}
+
+func (r *ggufReader) discard(n uint64) {
+	if r.err != nil {
+		return
+	}
+	discarded, err := r.r.Discard(int(n))
+	r.pos += uint64(discarded)
+	if err != nil {
+		r.fail(fmt.Errorf("header truncated at offset %d", r.pos))
+	}
+}
+
+func (r *ggufReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.read(4))
+}
+
+func (r *ggufReader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.read(8))
+}
+
+func (r *ggufReader) string() string {
+	n := r.uint64()
+	if r.err == nil && n > ggufMaxString {
+		r.fail(fmt.Errorf("string of %d bytes at offset %d", n, r.pos))
+	}
+	if r.err != nil {
+		return ""
+	}
+	return string(r.read(int(n)))
+}
+
+// skip reads past a metadata value of type typ
+func (r *ggufReader) skip(typ uint32) {
+	switch typ {
+	case ggufUint8, ggufInt8, ggufBool:
+		r.discard(1)
+	case ggufUint16, ggufInt16:
+		r.discard(2)
+	case ggufUint32, ggufInt32, ggufFloat32:
+		r.discard(4)
+	case ggufUint64, ggufInt64, ggufFloat64:
+		r.discard(8)
+	case ggufString:
+		n := r.uint64()
+		if r.err == nil && n > ggufMaxString {
+			r.fail(fmt.Errorf("string of %d bytes at offset %d", n, r.pos))
+		}
+		r.discard(n)
+	case ggufArray:
+		elem := r.uint32()
+		n := r.uint64()
+		if r.err == nil && (n > ggufMaxArray || elem == ggufArray) {
+			r.fail(fmt.Errorf("array of %d elements of type %d at offset %d", n, elem, r.pos))
+		}
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			r.skip(elem)
+		}
+	default:
+		r.fail(fmt.Errorf("unknown value type %d at offset %d", typ, r.pos))
+	}
+}
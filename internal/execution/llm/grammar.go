@@ -0,0 +1,503 @@
+package llm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// grammarNodeKind is the kind of a parsed GBNF expression
+type grammarNodeKind int
+
+const (
+	grammarLiteral grammarNodeKind = iota
+	grammarClass
+	grammarAny
+	grammarRef
+	grammarSequence
+	grammarChoice
+	grammarRepeat
+)
+
+type runeRange struct {
+	lo, hi rune
+}
+
+type grammarNode struct {
+	kind     grammarNodeKind
+	literal  []rune
+	ranges   []runeRange
+	negated  bool
+	rule     string
+	children []*grammarNode
+	// min and max bound a repeat; max is -1 when unbounded
+	min, max int
+}
+
+// Grammar is a parsed GBNF grammar, the notation llama.cpp constrains
+// generation with. It is used to check responses from backends that cannot
+// enforce a grammar themselves.
+type Grammar struct {
+	rules map[string]*grammarNode
+}
+
+// ParseGrammar parses a GBNF grammar, which must define a root rule
+func ParseGrammar(source string) (*Grammar, error) {
+	p := &grammarParser{src: []rune(source)}
+	g := &Grammar{rules: make(map[string]*grammarNode)}
+	for {
+		p.skipSpace(true)
+		if p.done() {
+			break
+		}
+		name := p.name()
+		if name == "" {
+			return nil, p.errorf("expected a rule name")
+		}
+		p.skipSpace(true)
+		if !p.consume("::=") {
+			return nil, p.errorf("expected ::= after rule %s", name)
+		}
+		body, err := p.choice(false)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := g.rules[name]; ok {
+			return nil, fmt.Errorf("grammar rule %s defined twice", name)
+		}
+		g.rules[name] = body
+	}
+	if _, ok := g.rules["root"]; !ok {
+		return nil, fmt.Errorf("grammar has no root rule")
+	}
+	for _, body := range g.rules {
+		if err := g.checkRefs(body); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+func (g *Grammar) checkRefs(n *grammarNode) error {
+	if n.kind == grammarRef {
+		if _, ok := g.rules[n.rule]; !ok {
+			return fmt.Errorf("grammar rule %s is not defined", n.rule)
+		}
+	}
+	for _, child := range n.children {
+		if err := g.checkRefs(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type grammarParser struct {
+	src []rune
+	pos int
+}
+
+func (p *grammarParser) done() bool {
+	return p.pos >= len(p.src)
+}
+
+func (p *grammarParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("grammar offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// skipSpace skips blanks and comments, and newlines too when newlines is
+// set; a newline outside parentheses otherwise ends a rule
+func (p *grammarParser) skipSpace(newlines bool) {
+	for !p.done() {
+		switch c := p.src[p.pos]; {
+		case c == '#':
+			for !p.done() && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == '\n' || c == '\r':
+			if !newlines {
+				return
+			}
+			p.pos++
+		case c == ' ' || c == '\t':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+func (p *grammarParser) consume(token string) bool {
+	t := []rune(token)
+	if p.pos+len(t) > len(p.src) || string(p.src[p.pos:p.pos+len(t)]) != token {
+		return false
+	}
+	p.pos += len(t)
+	return true
+}
+
+func isNameRune(c rune) bool {
+	return c == '-' || c == '_' || c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c))
+}
+
+func (p *grammarParser) name() string {
+	start := p.pos
+	for !p.done() && isNameRune(p.src[p.pos]) {
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+// choice parses alternatives up to the end of the rule, or up to the
+// closing parenthesis when nested
+func (p *grammarParser) choice(nested bool) (*grammarNode, error) {
+	var alternatives []*grammarNode
+	for {
+		seq, err := p.sequence(nested)
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, seq)
+		p.skipSpace(nested)
+		if !p.consume("|") {
+			break
+		}
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return &grammarNode{kind: grammarChoice, children: alternatives}, nil
+}
+
+func (p *grammarParser) sequence(nested bool) (*grammarNode, error) {
+	var items []*grammarNode
+	for {
+		// A newline after | continues the rule
+		p.skipSpace(nested || len(items) == 0)
+		if p.done() {
+			break
+		}
+		c := p.src[p.pos]
+		if c == '|' || c == ')' || c == '\n' || c == '\r' {
+			break
+		}
+		if isNameRune(c) && p.definesRule() {
+			break
+		}
+		item, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		if item, err = p.postfix(item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return &grammarNode{kind: grammarSequence, children: items}, nil
+}
+
+// definesRule reports whether the name at the current position starts the
+// next rule rather than referring to one
+func (p *grammarParser) definesRule() bool {
+	start := p.pos
+	defer func() { p.pos = start }()
+	p.name()
+	p.skipSpace(true)
+	return p.consume("::=")
+}
+
+func (p *grammarParser) term() (*grammarNode, error) {
+	switch c := p.src[p.pos]; {
+	case c == '"':
+		p.pos++
+		var text []rune
+		for {
+			if p.done() {
+				return nil, p.errorf("unterminated literal")
+			}
+			if p.src[p.pos] == '"' {
+				p.pos++
+				return &grammarNode{kind: grammarLiteral, literal: text}, nil
+			}
+			r, err := p.char()
+			if err != nil {
+				return nil, err
+			}
+			text = append(text, r)
+		}
+	case c == '[':
+		p.pos++
+		n := &grammarNode{kind: grammarClass}
+		if !p.done() && p.src[p.pos] == '^' {
+			n.negated = true
+			p.pos++
+		}
+		for {
+			if p.done() {
+				return nil, p.errorf("unterminated character class")
+			}
+			if p.src[p.pos] == ']' {
+				p.pos++
+				return n, nil
+			}
+			lo, err := p.char()
+			if err != nil {
+				return nil, err
+			}
+			hi := lo
+			if p.pos+1 < len(p.src) && p.src[p.pos] == '-' && p.src[p.pos+1] != ']' {
+				p.pos++
+				if hi, err = p.char(); err != nil {
+					return nil, err
+				}
+			}
+			n.ranges = append(n.ranges, runeRange{lo, hi})
+		}
+	case c == '.':
+		p.pos++
+		return &grammarNode{kind: grammarAny}, nil
+	case c == '(':
+		p.pos++
+		n, err := p.choice(true)
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace(true)
+		if !p.consume(")") {
+			return nil, p.errorf("expected )")
+		}
+		return n, nil
+	case isNameRune(c):
+		return &grammarNode{kind: grammarRef, rule: p.name()}, nil
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+// char reads one character of a literal or class, decoding escapes
+func (p *grammarParser) char() (rune, error) {
+	c := p.src[p.pos]
+	p.pos++
+	if c != '\\' {
+		return c, nil
+	}
+	if p.done() {
+		return 0, p.errorf("unterminated escape")
+	}
+	e := p.src[p.pos]
+	p.pos++
+	digits := 0
+	switch e {
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'x':
+		digits = 2
+	case 'u':
+		digits = 4
+	case 'U':
+		digits = 8
+	default:
+		return e, nil
+	}
+	if p.pos+digits > len(p.src) {
+		return 0, p.errorf("short \\%c escape", e)
+	}
+	v, err := strconv.ParseUint(string(p.src[p.pos:p.pos+digits]), 16, 32)
+	if err != nil {
+		return 0, p.errorf("invalid \\%c escape", e)
+	}
+	p.pos += digits
+	return rune(v), nil
+}
+
+func (p *grammarParser) postfix(item *grammarNode) (*grammarNode, error) {
+	for !p.done() {
+		switch p.src[p.pos] {
+		case '*':
+			item = &grammarNode{kind: grammarRepeat, children: []*grammarNode{item}, min: 0, max: -1}
+		case '+':
+			item = &grammarNode{kind: grammarRepeat, children: []*grammarNode{item}, min: 1, max: -1}
+		case '?':
+			item = &grammarNode{kind: grammarRepeat, children: []*grammarNode{item}, min: 0, max: 1}
+		case '{':
+			end := p.pos
+			for end < len(p.src) && p.src[end] != '}' {
+				end++
+			}
+			if end == len(p.src) {
+				return nil, p.errorf("unterminated repetition")
+			}
+			min, max, err := parseRepetition(string(p.src[p.pos+1 : end]))
+			if err != nil {
+				return nil, p.errorf("%v", err)
+			}
+			p.pos = end
+			item = &grammarNode{kind: grammarRepeat, children: []*grammarNode{item}, min: min, max: max}
+		default:
+			return item, nil
+		}
+		p.pos++
+	}
+	return item, nil
+}
+
+// parseRepetition parses the inside of {m}, {m,} or {m,n}
+func parseRepetition(spec string) (int, int, error) {
+	lo, hi, ranged := strings.Cut(spec, ",")
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil || min < 0 {
+		return 0, 0, fmt.Errorf("invalid repetition {%s}", spec)
+	}
+	if !ranged {
+		return min, min, nil
+	}
+	if strings.TrimSpace(hi) == "" {
+		return min, -1, nil
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(hi))
+	if err != nil || max < min {
+		return 0, 0, fmt.Errorf("invalid repetition {%s}", spec)
+	}
+	return min, max, nil
+}
+
+// Match reports whether the root rule derives text in full
+func (g *Grammar) Match(text string) bool {
+	m := &grammarMatcher{g: g, text: []rune(text), memo: make(map[grammarMemoKey][]int)}
+	for _, end := range m.match(&grammarNode{kind: grammarRef, rule: "root"}, 0) {
+		if end == len(m.text) {
+			return true
+		}
+	}
+	return false
+}
+
+type grammarMemoKey struct {
+	rule string
+	pos  int
+}
+
+// grammarMatcher finds every position a node can match up to from a start,
+// memoising rules so that ambiguous grammars stay polynomial
+type grammarMatcher struct {
+	g    *Grammar
+	text []rune
+	memo map[grammarMemoKey][]int
+}
+
+func (m *grammarMatcher) match(n *grammarNode, pos int) []int {
+	switch n.kind {
+	case grammarLiteral:
+		if pos+len(n.literal) <= len(m.text) && string(m.text[pos:pos+len(n.literal)]) == string(n.literal) {
+			return []int{pos + len(n.literal)}
+		}
+		return nil
+	case grammarClass:
+		if pos < len(m.text) && n.matchesRune(m.text[pos]) {
+			return []int{pos + 1}
+		}
+		return nil
+	case grammarAny:
+		if pos < len(m.text) {
+			return []int{pos + 1}
+		}
+		return nil
+	case grammarRef:
+		key := grammarMemoKey{n.rule, pos}
+		if ends, ok := m.memo[key]; ok {
+			return ends
+		}
+		// Left recursion finds nothing rather than looping
+		m.memo[key] = nil
+		ends := m.match(m.g.rules[n.rule], pos)
+		m.memo[key] = ends
+		return ends
+	case grammarSequence:
+		positions := []int{pos}
+		for _, child := range n.children {
+			var next []int
+			for _, p := range positions {
+				next = append(next, m.match(child, p)...)
+			}
+			if positions = uniqueSorted(next); len(positions) == 0 {
+				return nil
+			}
+		}
+		return positions
+	case grammarChoice:
+		var ends []int
+		for _, child := range n.children {
+			ends = append(ends, m.match(child, pos)...)
+		}
+		return uniqueSorted(ends)
+	case grammarRepeat:
+		return m.matchRepeat(n, pos)
+	}
+	return nil
+}
+
+func (m *grammarMatcher) matchRepeat(n *grammarNode, pos int) []int {
+	var ends []int
+	if n.min == 0 {
+		ends = append(ends, pos)
+	}
+	seen := map[int]bool{pos: true}
+	current := []int{pos}
+	for count := 1; n.max < 0 || count <= n.max; count++ {
+		var next []int
+		for _, p := range current {
+			next = append(next, m.match(n.children[0], p)...)
+		}
+		next = uniqueSorted(next)
+		if count >= n.min && n.max < 0 {
+			// Positions already reached add nothing once the minimum is met
+			fresh := next[:0]
+			for _, p := range next {
+				if !seen[p] {
+					seen[p] = true
+					fresh = append(fresh, p)
+				}
+			}
+			next = fresh
+		}
+		if len(next) == 0 {
+			break
+		}
+		if count >= n.min {
+			ends = append(ends, next...)
+		}
+		current = next
+	}
+	return uniqueSorted(ends)
+}
+
+func (n *grammarNode) matchesRune(r rune) bool {
+	in := false
+	for _, rr := range n.ranges {
+		if r >= rr.lo && r <= rr.hi {
+			in = true
+			break
+		}
+	}
+	return in != n.negated
+}
+
+func uniqueSorted(positions []int) []int {
+	if len(positions) < 2 {
+		return positions
+	}
+	sort.Ints(positions)
+	out := positions[:1]
+	for _, p := range positions[1:] {
+		if p != out[len(out)-1] {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,108 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/caches"
+)
+
+// ModelStore exposes the models pulled into Ollama as a cache
+type ModelStore struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewModelStore(baseURL string) *ModelStore {
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	return &ModelStore{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: time.Minute},
+	}
+}
+
+// CanonicalModelName adds the tag Ollama assumes when a model name has none
+func CanonicalModelName(name string) string {
+	if name == "" || strings.Contains(name, ":") {
+		return name
+	}
+	return name + ":latest"
+}
+
+func (s *ModelStore) Entries(ctx context.Context) ([]caches.Entry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.baseURL+"/api/tags", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list models: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ollama request failed with status: %d", resp.StatusCode)
+	}
+
+	var list ListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	entries := make([]caches.Entry, 0, len(list.Models))
+	for _, m := range list.Models {
+		modified, _ := time.Parse(time.RFC3339Nano, m.ModifiedAt)
+		entries = append(entries, caches.Entry{
+			Key:      CanonicalModelName(m.Name),
+			Size:     m.Size,
+			LastUsed: modified,
+		})
+	}
+	return entries, nil
+}
+
+func (s *ModelStore) Has(ctx context.Context, name string) (bool, error) {
+	entries, err := s.Entries(ctx)
+	if err != nil {
+		return false, err
+	}
+	name = CanonicalModelName(name)
+	for _, e := range entries {
+		if e.Key == name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *ModelStore) Remove(ctx context.Context, name string) error {
+	name = CanonicalModelName(name)
+	body, err := json.Marshal(map[string]string{"model": name, "name": name})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.baseURL+"/api/delete", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to delete model: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("ollama delete failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ChatMessage is a message of a conversation with the backend
+type ChatMessage struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolName names the tool a "tool" message is the result of
+	ToolName string `json:"tool_name,omitempty"`
+}
+
+// ToolCall is a tool call the model asks for
+type ToolCall struct {
+	Function ToolCallFunction `json:"function"`
+}
+
+type ToolCallFunction struct {
+	Name      string          `json:"name"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// ToolDefinition offers a tool to the model
+type ToolDefinition struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Parameters is the JSON Schema of the tool's arguments
+	Parameters json.RawMessage `json:"parameters"`
+}
+
+type ChatRequest struct {
+	Model     string           `json:"model"`
+	Messages  []ChatMessage    `json:"messages"`
+	Tools     []ToolDefinition `json:"tools,omitempty"`
+	Stream    bool             `json:"stream"`
+	Options   *Sampling        `json:"options,omitempty"`
+	KeepAlive string           `json:"keep_alive,omitempty"`
+}
+
+type ChatResponse struct {
+	Message            ChatMessage `json:"message"`
+	Done               bool        `json:"done"`
+	PromptEvalCount    int         `json:"prompt_eval_count"`
+	EvalCount          int         `json:"eval_count"`
+	TotalDuration      int64       `json:"total_duration"`
+	LoadDuration       int64       `json:"load_duration"`
+	PromptEvalDuration int64       `json:"prompt_eval_duration"`
+	EvalDuration       int64       `json:"eval_duration"`
+}
+
+// Chatter answers one turn of a conversation
+type Chatter interface {
+	Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error)
+}
+
+// Chat answers one turn of a conversation, queued and counted with the
+// model's generations. Turns are not retried, since the conversation they
+// belong to has tool calls with side effects behind it.
+func (e *OllamaExecutor) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	dequeue := e.stats.Enqueue(req.Model)
+	defer dequeue()
+
+	select {
+	case e.semaphore <- struct{}{}:
+		defer func() { <-e.semaphore }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	turn := *req
+	turn.Stream = false
+	if turn.Options == nil {
+		turn.Options = samplingFrom(ctx)
+	}
+	if turn.KeepAlive == "" {
+		turn.KeepAlive = e.warm.KeepAlive(req.Model)
+	}
+	body, err := json.Marshal(turn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, e.baseURL+"/api/chat", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	start := clock.Start(e.clock)
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return nil, models.Failure(models.FailureBackend, fmt.Errorf("failed to execute request: %w", err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, models.Failure(models.FailureBackend, backendError("chat", resp))
+	}
+	var response ChatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	if !response.Done {
+		return nil, fmt.Errorf("ollama response not complete (done: %v)", response.Done)
+	}
+	e.warm.Loaded(req.Model)
+
+	e.stats.Observe(req.Model, Generation{
+		Total:        start.Elapsed(),
+		TTFT:         time.Duration(response.LoadDuration + response.PromptEvalDuration),
+		EvalTokens:   response.EvalCount,
+		EvalDuration: time.Duration(response.EvalDuration),
+	})
+	return &response, nil
+}
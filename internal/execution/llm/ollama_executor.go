@@ -11,6 +11,9 @@ import (
 	"time"
 
 	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
 )
 
 var (
@@ -24,12 +27,21 @@ type OllamaExecutor struct {
 	baseURL   string
 	client    *http.Client
 	semaphore chan struct{}
+	clock     clock.Clock
+	stats     *Stats
+	warm      *WarmModels
 }
 
 type GenerateRequest struct {
 	Model  string `json:"model"`
 	Prompt string `json:"prompt"`
 	Stream bool   `json:"stream"`
+	// Format is "json" or a JSON Schema the response is constrained to
+	Format  json.RawMessage `json:"format,omitempty"`
+	Options *Sampling       `json:"options,omitempty"`
+	// KeepAlive is how long the model stays loaded after the request,
+	// the backend's default when empty
+	KeepAlive string `json:"keep_alive,omitempty"`
 }
 
 type GenerateResponse struct {
@@ -51,12 +63,16 @@ type Model struct {
 	Name       string `json:"name"`
 	ModifiedAt string `json:"modified_at"`
 	Size       int64  `json:"size"`
+	Digest     string `json:"digest"`
 }
 
 type ModelInfo struct {
 	Name      string
 	IsLoaded  bool
 	MaxTokens int
+	SizeBytes int64
+	// Digest identifies the model's weights
+	Digest string
 }
 
 func NewOllamaExecutor(baseURL string) *OllamaExecutor {
@@ -70,12 +86,46 @@ func NewOllamaExecutor(baseURL string) *OllamaExecutor {
 			Timeout: 5 * time.Minute,
 		},
 		semaphore: make(chan struct{}, 1), // Allow max 1 concurrent request to avoid Ollama conflicts
+		clock:     clock.Real(),
+		stats:     NewStats(clock.Real()),
 	}
 }
 
+// SetClock replaces the clock used for request spacing, retry backoff and
+// latency statistics
+func (e *OllamaExecutor) SetClock(c clock.Clock) {
+	e.clock = c
+	e.stats.SetClock(c)
+}
+
+// SetWarmModels keeps the models warm records loaded between generations
+func (e *OllamaExecutor) SetWarmModels(warm *WarmModels) {
+	e.warm = warm
+}
+
+// Stats returns the per-model queue and latency statistics
+func (e *OllamaExecutor) Stats() *Stats {
+	return e.stats
+}
+
 func (e *OllamaExecutor) Generate(ctx context.Context, modelName, prompt string) (*GenerateResponse, error) {
+	return e.GenerateFormat(ctx, modelName, prompt, nil)
+}
+
+// SupportsFormat reports whether Ollama enforces format: it constrains
+// responses to JSON, and to a JSON Schema, but not to patterns or grammars
+func (e *OllamaExecutor) SupportsFormat(format *models.ResponseFormat) bool {
+	return format != nil && format.Type == models.ResponseFormatJSON
+}
+
+// GenerateFormat generates a response to prompt, passing format on to
+// Ollama when it supports it
+func (e *OllamaExecutor) GenerateFormat(ctx context.Context, modelName, prompt string, format *models.ResponseFormat) (*GenerateResponse, error) {
 	log := gologger.WithComponent("ollama_executor")
 
+	dequeue := e.stats.Enqueue(modelName)
+	defer dequeue()
+
 	// Acquire semaphore to limit concurrent requests
 	log.Debug().Msg("Waiting for semaphore to limit Ollama concurrency")
 	select {
@@ -83,7 +133,7 @@ func (e *OllamaExecutor) Generate(ctx context.Context, modelName, prompt string)
 		log.Debug().Msg("Acquired semaphore for Ollama request")
 		defer func() {
 			// Add delay before releasing to space out requests
-			time.Sleep(1 * time.Second)
+			e.clock.Sleep(1 * time.Second)
 			<-e.semaphore
 			log.Debug().Msg("Released semaphore after Ollama request")
 		}()
@@ -91,14 +141,20 @@ func (e *OllamaExecutor) Generate(ctx context.Context, modelName, prompt string)
 		return nil, ctx.Err()
 	}
 
-	startTime := time.Now()
+	startTime := e.clock.Now()
 	maxRetries := 3
 	baseDelay := 3 * time.Second // Aggressive delay between retries for stability
 
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		response, err := e.generateWithRetry(ctx, modelName, prompt, attempt)
+		response, err := e.generateWithRetry(ctx, modelName, prompt, ollamaFormat(format), attempt)
 		if err == nil {
-			response.TotalDuration = time.Since(startTime).Nanoseconds()
+			response.TotalDuration = clock.Since(e.clock, startTime).Nanoseconds()
+			e.stats.Observe(modelName, Generation{
+				Total:        time.Duration(response.TotalDuration),
+				TTFT:         time.Duration(response.LoadDuration + response.PromptEvalDuration),
+				EvalTokens:   response.EvalCount,
+				EvalDuration: time.Duration(response.EvalDuration),
+			})
 
 			log.Info().
 				Str("model", modelName).
@@ -125,7 +181,7 @@ func (e *OllamaExecutor) Generate(ctx context.Context, modelName, prompt string)
 			select {
 			case <-ctx.Done():
 				return nil, ctx.Err()
-			case <-time.After(delay):
+			case <-e.clock.After(delay):
 				// Continue to next attempt
 			}
 		} else {
@@ -134,33 +190,49 @@ func (e *OllamaExecutor) Generate(ctx context.Context, modelName, prompt string)
 				Str("model", modelName).
 				Int("attempts", attempt).
 				Msg("All Ollama retry attempts exhausted")
-			return nil, fmt.Errorf("failed after %d attempts: %w", maxRetries, err)
+			return nil, models.Failure(models.FailureBackend, fmt.Errorf("failed after %d attempts: %w", maxRetries, err))
 		}
 	}
 
 	return nil, fmt.Errorf("unexpected retry loop exit")
 }
 
-func (e *OllamaExecutor) generateWithRetry(ctx context.Context, modelName, prompt string, attempt int) (*GenerateResponse, error) {
+// ollamaFormat returns the format field Ollama constrains a response with,
+// nil when it cannot enforce format
+func ollamaFormat(format *models.ResponseFormat) json.RawMessage {
+	if format == nil || format.Type != models.ResponseFormatJSON {
+		return nil
+	}
+	// A boolean schema leaves nothing to pass beyond JSON itself
+	if schema := bytes.TrimSpace(format.Schema); len(schema) > 0 && schema[0] == '{' {
+		return schema
+	}
+	return json.RawMessage(`"json"`)
+}
+
+func (e *OllamaExecutor) generateWithRetry(ctx context.Context, modelName, prompt string, format json.RawMessage, attempt int) (*GenerateResponse, error) {
 	log := gologger.WithComponent("ollama_executor")
 
 	// Global rate limiting to ensure minimum time between requests
 	ollamaRequestMutex.Lock()
-	timeSinceLastRequest := time.Since(lastOllamaRequest)
+	timeSinceLastRequest := clock.Since(e.clock, lastOllamaRequest)
 	if timeSinceLastRequest < minRequestInterval {
 		waitTime := minRequestInterval - timeSinceLastRequest
 		log.Debug().
 			Dur("wait_time", waitTime).
 			Msg("Rate limiting Ollama request")
-		time.Sleep(waitTime)
+		e.clock.Sleep(waitTime)
 	}
-	lastOllamaRequest = time.Now()
+	lastOllamaRequest = e.clock.Now()
 	ollamaRequestMutex.Unlock()
 
 	req := GenerateRequest{
-		Model:  modelName,
-		Prompt: prompt,
-		Stream: false,
+		Model:     modelName,
+		Prompt:    prompt,
+		Stream:    false,
+		Format:    format,
+		Options:   samplingFrom(ctx),
+		KeepAlive: e.warm.KeepAlive(modelName),
 	}
 
 	reqBody, err := json.Marshal(req)
@@ -200,13 +272,51 @@ func (e *OllamaExecutor) generateWithRetry(ctx context.Context, modelName, promp
 	if !response.Done {
 		return nil, fmt.Errorf("ollama response not complete (done: %v)", response.Done)
 	}
+	e.warm.Loaded(modelName)
 
 	// Add small delay after successful response to let Ollama stabilize
-	time.Sleep(200 * time.Millisecond)
+	e.clock.Sleep(200 * time.Millisecond)
 
 	return &response, nil
 }
 
+// Probe generates a single token with modelName, bypassing the request
+// queue, retries and statistics of task generations, to check that the
+// model still serves
+func (e *OllamaExecutor) Probe(ctx context.Context, modelName string) error {
+	one := 1
+	body, err := json.Marshal(GenerateRequest{
+		Model:   modelName,
+		Prompt:  "ping",
+		Options: &Sampling{NumPredict: &one},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", e.baseURL+"/api/generate", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return backendError("generate", resp)
+	}
+	var response GenerateResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if !response.Done {
+		return fmt.Errorf("ollama response not complete (done: %v)", response.Done)
+	}
+	return nil
+}
+
 func (e *OllamaExecutor) ListModels(ctx context.Context) ([]ModelInfo, error) {
 	log := gologger.WithComponent("ollama_executor")
 
@@ -236,6 +346,8 @@ func (e *OllamaExecutor) ListModels(ctx context.Context) ([]ModelInfo, error) {
 			Name:      model.Name,
 			IsLoaded:  true,
 			MaxTokens: 4096,
+			SizeBytes: model.Size,
+			Digest:    model.Digest,
 		}
 	}
 
@@ -244,6 +356,21 @@ func (e *OllamaExecutor) ListModels(ctx context.Context) ([]ModelInfo, error) {
 	return models, nil
 }
 
+// ModelDigest returns the digest of the installed model modelName
+func (e *OllamaExecutor) ModelDigest(ctx context.Context, modelName string) (string, error) {
+	installed, err := e.ListModels(ctx)
+	if err != nil {
+		return "", err
+	}
+	name := CanonicalModelName(modelName)
+	for _, model := range installed {
+		if CanonicalModelName(model.Name) == name && model.Digest != "" {
+			return model.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("model %s is not installed", modelName)
+}
+
 func (e *OllamaExecutor) IsHealthy(ctx context.Context) bool {
 	httpReq, err := http.NewRequestWithContext(ctx, "GET", e.baseURL+"/api/tags", nil)
 	if err != nil {
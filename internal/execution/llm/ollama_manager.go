@@ -13,6 +13,8 @@ import (
 	"time"
 
 	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/hardware"
 )
 
 type OllamaManager struct {
@@ -23,6 +25,9 @@ type OllamaManager struct {
 	dockerImage   string
 	modelVolume   string
 	port          string
+	profile       *hardware.Profile
+	native        bool
+	nativeCmd     *exec.Cmd
 }
 
 func NewOllamaManager(baseURL string, models []string) *OllamaManager {
@@ -42,7 +47,64 @@ func NewOllamaManager(baseURL string, models []string) *OllamaManager {
 		dockerImage:   "ollama/ollama:latest",
 		modelVolume:   modelVolume,
 		port:          "11434",
+		profile:       hardware.Detect(),
+	}
+}
+
+// metalBackendEnv returns the Ollama environment tuned for Apple Silicon.
+// Flash attention is stable on Metal and reduces KV cache pressure on unified memory.
+func metalBackendEnv() []string {
+	return []string{
+		"OLLAMA_FLASH_ATTENTION=1",
+		"OLLAMA_KV_CACHE_TYPE=q8_0",
+		"OLLAMA_MAX_LOADED_MODELS=1",
+	}
+}
+
+// startNativeOllama uses or launches a host Ollama process so inference runs on the Metal GPU
+func (m *OllamaManager) startNativeOllama(ctx context.Context) error {
+	log := gologger.WithComponent("ollama_manager")
+
+	if m.executor.IsHealthy(ctx) && !m.isContainerRunning(ctx) {
+		log.Info().Msg("Using native Ollama server with Metal acceleration")
+		m.native = true
+		return nil
+	}
+
+	binary, err := exec.LookPath("ollama")
+	if err != nil {
+		return fmt.Errorf("ollama binary not found in PATH: %w", err)
+	}
+
+	if err := m.stopContainer(ctx); err != nil {
+		log.Warn().Err(err).Msg("Failed to stop existing container")
+	}
+
+	cmd := exec.Command(binary, "serve")
+	cmd.Env = append(os.Environ(), metalBackendEnv()...)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("OLLAMA_HOST=127.0.0.1:%s", m.port))
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start native Ollama: %w", err)
 	}
+	m.nativeCmd = cmd
+	m.native = true
+
+	for i := 0; i < 15; i++ {
+		time.Sleep(time.Second)
+		if m.executor.IsHealthy(ctx) {
+			log.Info().
+				Str("chip", m.profile.AppleChip).
+				Int("pid", cmd.Process.Pid).
+				Msg("Native Ollama server started with Metal acceleration")
+			return nil
+		}
+	}
+
+	_ = cmd.Process.Kill()
+	_ = cmd.Wait()
+	m.nativeCmd = nil
+	m.native = false
+	return fmt.Errorf("native Ollama failed to become healthy")
 }
 
 func (m *OllamaManager) InstallOllama(ctx context.Context) error {
@@ -133,6 +195,15 @@ func (m *OllamaManager) removeContainer(ctx context.Context) error {
 func (m *OllamaManager) StartOllama(ctx context.Context) error {
 	log := gologger.WithComponent("ollama_manager")
 
+	// Containers on macOS have no access to the Metal GPU, so prefer a native backend
+	if m.profile.Accelerator == hardware.AcceleratorMetal {
+		err := m.startNativeOllama(ctx)
+		if err == nil {
+			return nil
+		}
+		log.Warn().Err(err).Msg("Native Ollama unavailable, falling back to container without Metal acceleration")
+	}
+
 	// Check if container is already running and healthy
 	if m.isContainerRunning(ctx) && m.executor.IsHealthy(ctx) {
 		log.Info().Msg("Ollama container is already running and healthy")
@@ -311,7 +382,7 @@ func (m *OllamaManager) pullModel(ctx context.Context, modelName string) error {
 	log.Info().Str("model", modelName).Msg("Starting model pull in container...")
 
 	// Ensure container is running
-	if !m.isContainerRunning(ctx) {
+	if !m.native && !m.isContainerRunning(ctx) {
 		return fmt.Errorf("ollama container is not running. Please start it first")
 	}
 
@@ -330,6 +401,10 @@ func (m *OllamaManager) pullModel(ctx context.Context, modelName string) error {
 
 	// Use docker exec to run ollama pull inside the container
 	cmd := exec.CommandContext(pullCtx, "docker", "exec", m.containerName, "ollama", "pull", modelName)
+	if m.native {
+		cmd = exec.CommandContext(pullCtx, "ollama", "pull", modelName)
+		cmd.Env = append(os.Environ(), "OLLAMA_HOST="+m.baseURL)
+	}
 
 	// Create pipes for output
 	stdout, err := cmd.StdoutPipe()
@@ -516,6 +591,16 @@ func (m *OllamaManager) ListAvailableModelsInRegistry(ctx context.Context) error
 func (m *OllamaManager) StopOllama(ctx context.Context) error {
 	log := gologger.WithComponent("ollama_manager")
 
+	if m.nativeCmd != nil {
+		log.Info().Int("pid", m.nativeCmd.Process.Pid).Msg("Stopping native Ollama server...")
+		if err := m.nativeCmd.Process.Signal(os.Interrupt); err != nil {
+			return fmt.Errorf("failed to stop native Ollama: %w", err)
+		}
+		_ = m.nativeCmd.Wait()
+		m.nativeCmd = nil
+		return nil
+	}
+
 	log.Info().Str("container", m.containerName).Msg("Stopping Ollama container...")
 
 	if err := m.stopContainer(ctx); err != nil {
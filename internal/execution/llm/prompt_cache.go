@@ -0,0 +1,200 @@
+package llm
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// Sampling are the sampling options of a generation, passed to Ollama as
+// its options. Unset options keep Ollama's defaults.
+type Sampling struct {
+	Temperature *float64 `json:"temperature,omitempty"`
+	Seed        *int64   `json:"seed,omitempty"`
+	TopK        *int     `json:"top_k,omitempty"`
+	TopP        *float64 `json:"top_p,omitempty"`
+	NumPredict  *int     `json:"num_predict,omitempty"`
+}
+
+// Deterministic reports whether generating with s always gives the same
+// response: only a temperature of 0 with a fixed seed does
+func (s *Sampling) Deterministic() bool {
+	return s != nil && s.Temperature != nil && *s.Temperature == 0 && s.Seed != nil
+}
+
+type samplingKey struct{}
+
+// WithSampling makes generations under ctx use sampling
+func WithSampling(ctx context.Context, sampling *Sampling) context.Context {
+	if sampling == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, samplingKey{}, sampling)
+}
+
+func samplingFrom(ctx context.Context) *Sampling {
+	sampling, _ := ctx.Value(samplingKey{}).(*Sampling)
+	return sampling
+}
+
+// promptEntryOverhead approximates what an entry costs beyond its key and
+// response text
+const promptEntryOverhead = 512
+
+// CanonicalPrompt returns prompt in the form prompts are compared in: NFC
+// normalized, with Unix line endings, no trailing whitespace on any line and
+// no whitespace around the whole
+func CanonicalPrompt(prompt string) string {
+	prompt = norm.NFC.String(prompt)
+	prompt = strings.ReplaceAll(prompt, "\r\n", "\n")
+	prompt = strings.ReplaceAll(prompt, "\r", "\n")
+	lines := strings.Split(prompt, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// PromptKey returns the cache key of generating prompt with the model of
+// digest, sampling and format, and false when the generation must not be
+// cached because its response is not determined by them
+func PromptKey(modelDigest, prompt string, sampling *Sampling, format *models.ResponseFormat) (string, bool) {
+	if modelDigest == "" || !sampling.Deterministic() {
+		return "", false
+	}
+	options, err := json.Marshal(sampling)
+	if err != nil {
+		return "", false
+	}
+	var constraint []byte
+	if format != nil {
+		if constraint, err = json.Marshal(format); err != nil {
+			return "", false
+		}
+	}
+
+	h := sha256.New()
+	for _, part := range [][]byte{[]byte(modelDigest), []byte(CanonicalPrompt(prompt)), options, constraint} {
+		// Parts are length-prefixed so no two sets of them hash alike
+		h.Write([]byte{byte(len(part) >> 24), byte(len(part) >> 16), byte(len(part) >> 8), byte(len(part))})
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil)), true
+}
+
+// CachedResponse is a response kept for prompts identical to the one that
+// produced it
+type CachedResponse struct {
+	// PromptID is the task whose generation produced the response
+	PromptID       string
+	Response       string
+	PromptTokens   int
+	ResponseTokens int
+	TokenUsage     *models.TokenUsage
+	ResponseFormat *models.ResponseFormatReport
+	StoredAt       time.Time
+}
+
+func (r *CachedResponse) size(key string) int64 {
+	return int64(len(key)+len(r.Response)+len(r.PromptID)) + promptEntryOverhead
+}
+
+type promptEntry struct {
+	key      string
+	response *CachedResponse
+}
+
+// PromptCache keeps the responses of deterministic generations in memory,
+// within a byte budget, for up to a TTL, so that repeated prompts are
+// answered without running the model again. The least recently used
+// responses are evicted first.
+type PromptCache struct {
+	maxBytes int64
+	ttl      time.Duration
+	clock    clock.Clock
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	size    int64
+}
+
+func NewPromptCache(maxBytes int64, ttl time.Duration) *PromptCache {
+	return &PromptCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		clock:    clock.Real(),
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *PromptCache) SetClock(clk clock.Clock) {
+	c.clock = clk
+}
+
+// Get returns the response kept under key, unless it expired
+func (c *PromptCache) Get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*promptEntry)
+	if c.ttl > 0 && clock.Since(c.clock, entry.response.StoredAt) > c.ttl {
+		c.remove(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.response, true
+}
+
+// Put keeps response under key, evicting the least recently used responses
+// until it fits the budget. Responses larger than the whole budget are not
+// kept.
+func (c *PromptCache) Put(key string, response *CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	size := response.size(key)
+	if size > c.maxBytes {
+		return
+	}
+	if response.StoredAt.IsZero() {
+		response.StoredAt = c.clock.Now()
+	}
+	for c.size+size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+	c.entries[key] = c.order.PushFront(&promptEntry{key: key, response: response})
+	c.size += size
+}
+
+// Usage returns the number of responses kept and the bytes they take
+func (c *PromptCache) Usage() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries), c.size
+}
+
+func (c *PromptCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*promptEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.response.size(entry.key)
+}
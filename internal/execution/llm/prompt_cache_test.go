@@ -0,0 +1,134 @@
+package llm
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func deterministic(seed int64) *Sampling {
+	temperature := 0.0
+	return &Sampling{Temperature: &temperature, Seed: &seed}
+}
+
+func TestCanonicalPrompt(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		same bool
+	}{
+		{"surrounding whitespace", "Summarize this", "  Summarize this\n\n", true},
+		{"line endings", "line one\r\nline two", "line one\nline two", true},
+		{"trailing whitespace", "line one \t\nline two", "line one\nline two", true},
+		{"composed and decomposed", "caf\u00e9", "cafe\u0301", true},
+		{"inner whitespace", "a  b", "a b", false},
+		{"indentation", "code:\n  x = 1", "code:\nx = 1", false},
+		{"case", "Summarize", "summarize", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := CanonicalPrompt(tt.a), CanonicalPrompt(tt.b)
+			if (a == b) != tt.same {
+				t.Fatalf("CanonicalPrompt(%q) = %q, CanonicalPrompt(%q) = %q, want same = %v", tt.a, a, tt.b, b, tt.same)
+			}
+		})
+	}
+}
+
+func TestPromptKeyDeterminismGate(t *testing.T) {
+	warm := 0.7
+	cold := 0.0
+	seed := int64(42)
+	for name, sampling := range map[string]*Sampling{
+		"no options":     nil,
+		"no temperature": {Seed: &seed},
+		"no seed":        {Temperature: &cold},
+		"warm":           {Temperature: &warm, Seed: &seed},
+	} {
+		if key, ok := PromptKey("sha256:abc", "hello", sampling, nil); ok {
+			t.Errorf("%s: PromptKey() = %q, want not cacheable", name, key)
+		}
+	}
+	if _, ok := PromptKey("", "hello", deterministic(1), nil); ok {
+		t.Error("PromptKey() without a model digest is cacheable")
+	}
+
+	key, ok := PromptKey("sha256:abc", "hello", deterministic(1), nil)
+	if !ok {
+		t.Fatal("PromptKey() of a deterministic generation is not cacheable")
+	}
+	if same, _ := PromptKey("sha256:abc", " hello\n", deterministic(1), nil); same != key {
+		t.Error("canonically equal prompts have different keys")
+	}
+	format := &models.ResponseFormat{Type: models.ResponseFormatJSON}
+	for name, other := range map[string]func() (string, bool){
+		"model":  func() (string, bool) { return PromptKey("sha256:def", "hello", deterministic(1), nil) },
+		"seed":   func() (string, bool) { return PromptKey("sha256:abc", "hello", deterministic(2), nil) },
+		"format": func() (string, bool) { return PromptKey("sha256:abc", "hello", deterministic(1), format) },
+		"prompt": func() (string, bool) { return PromptKey("sha256:abc", "hello!", deterministic(1), nil) },
+	} {
+		if otherKey, _ := other(); otherKey == key {
+			t.Errorf("a different %s has the same key", name)
+		}
+	}
+}
+
+func TestPromptCacheBudget(t *testing.T) {
+	response := func(id string) *CachedResponse {
+		return &CachedResponse{PromptID: id, Response: strings.Repeat("x", 1000)}
+	}
+	size := response("a").size("key-a")
+	cache := NewPromptCache(3*size, time.Hour)
+
+	cache.Put("key-a", response("a"))
+	cache.Put("key-b", response("b"))
+	cache.Put("key-c", response("c"))
+	// Reading a makes b the least recently used
+	if _, ok := cache.Get("key-a"); !ok {
+		t.Fatal("Get(key-a) missed")
+	}
+	cache.Put("key-d", response("d"))
+
+	if _, ok := cache.Get("key-b"); ok {
+		t.Error("least recently used response was not evicted")
+	}
+	for _, key := range []string{"key-a", "key-c", "key-d"} {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("Get(%s) missed", key)
+		}
+	}
+	if entries, bytes := cache.Usage(); entries != 3 || bytes > 3*size {
+		t.Errorf("Usage() = %d, %d, want 3 entries within %d bytes", entries, bytes, 3*size)
+	}
+
+	huge := &CachedResponse{PromptID: "huge", Response: strings.Repeat("x", int(4*size))}
+	cache.Put("key-huge", huge)
+	if _, ok := cache.Get("key-huge"); ok {
+		t.Error("response larger than the budget was kept")
+	}
+	if entries, _ := cache.Usage(); entries != 3 {
+		t.Errorf("oversized response evicted others, %d entries left", entries)
+	}
+}
+
+func TestPromptCacheTTL(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 5, 1, 9, 0, 0, 0, time.UTC))
+	cache := NewPromptCache(1<<20, time.Hour)
+	cache.SetClock(clk)
+
+	cache.Put("key", &CachedResponse{PromptID: "prompt-1", Response: "42"})
+	clk.Step(59 * time.Minute)
+	if cached, ok := cache.Get("key"); !ok || cached.PromptID != "prompt-1" {
+		t.Fatalf("Get() = %+v, %v before the TTL", cached, ok)
+	}
+	clk.Step(2 * time.Minute)
+	if _, ok := cache.Get("key"); ok {
+		t.Fatal("Get() hit after the TTL")
+	}
+	if entries, bytes := cache.Usage(); entries != 0 || bytes != 0 {
+		t.Fatalf("Usage() = %d, %d after expiry, want empty", entries, bytes)
+	}
+}
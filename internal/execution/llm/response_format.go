@@ -0,0 +1,142 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/taskschema"
+)
+
+// FormatBackend generates responses, enforcing some response formats itself
+type FormatBackend interface {
+	// SupportsFormat reports whether the backend enforces format while
+	// generating
+	SupportsFormat(format *models.ResponseFormat) bool
+	// GenerateFormat generates a response to prompt, enforcing format when
+	// it is non-nil and the backend supports it
+	GenerateFormat(ctx context.Context, modelName, prompt string, format *models.ResponseFormat) (*GenerateResponse, error)
+}
+
+// FormatChecker checks responses against a response format
+type FormatChecker struct {
+	format  *models.ResponseFormat
+	pattern *regexp.Regexp
+	grammar *Grammar
+}
+
+// NewFormatChecker compiles format's pattern or grammar
+func NewFormatChecker(format *models.ResponseFormat) (*FormatChecker, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	c := &FormatChecker{format: format}
+	switch format.Type {
+	case models.ResponseFormatJSON:
+		if len(format.Schema) > 0 {
+			// A schema the validator cannot resolve fails every response
+			if _, err := taskschema.ValidateAgainst(format.Schema, "#", []byte("null")); err != nil {
+				return nil, fmt.Errorf("invalid response_format schema: %w", err)
+			}
+		}
+	case models.ResponseFormatRegex:
+		pattern, err := regexp.Compile(`^(?:` + format.Pattern + `)$`)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response_format pattern: %w", err)
+		}
+		c.pattern = pattern
+	case models.ResponseFormatGrammar:
+		grammar, err := ParseGrammar(format.Grammar)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response_format grammar: %w", err)
+		}
+		c.grammar = grammar
+	}
+	return c, nil
+}
+
+// Check returns how response misses the format, nothing when it meets it
+func (c *FormatChecker) Check(response string) []string {
+	switch c.format.Type {
+	case models.ResponseFormatJSON:
+		data := []byte(strings.TrimSpace(response))
+		if !json.Valid(data) {
+			return []string{"response is not valid JSON"}
+		}
+		if len(c.format.Schema) == 0 {
+			return nil
+		}
+		found, err := taskschema.ValidateAgainst(c.format.Schema, "#", data)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		violations := make([]string, len(found))
+		for i, v := range found {
+			violations[i] = v.String()
+		}
+		return violations
+	case models.ResponseFormatRegex:
+		if !c.pattern.MatchString(response) {
+			return []string{"response does not match the pattern"}
+		}
+	case models.ResponseFormatGrammar:
+		if !c.grammar.Match(response) {
+			return []string{"response does not derive from the grammar"}
+		}
+	}
+	return nil
+}
+
+// GenerateWithFormat generates a response to prompt that meets format.
+// backend enforces the format when it can; every response is checked all
+// the same and, when it misses, generated again up to the format's retry
+// bound. The report says how the format was met. A response that never met
+// it is returned with a FormatUnmet report, and must not be submitted.
+func GenerateWithFormat(ctx context.Context, backend FormatBackend, modelName, prompt string, format *models.ResponseFormat) (*GenerateResponse, *models.ResponseFormatReport, error) {
+	log := gologger.WithComponent("llm_format")
+
+	checker, err := NewFormatChecker(format)
+	if err != nil {
+		return nil, nil, err
+	}
+	native := backend.SupportsFormat(format)
+	report := &models.ResponseFormatReport{Type: format.Type}
+
+	for {
+		response, err := backend.GenerateFormat(ctx, modelName, prompt, format)
+		if err != nil {
+			return nil, nil, err
+		}
+		report.Attempts++
+		report.Violations = checker.Check(response.Response)
+		if len(report.Violations) == 0 {
+			report.Enforcement = models.FormatMetByRetry
+			if native && report.Attempts == 1 {
+				report.Enforcement = models.FormatMetNatively
+			}
+			return response, report, nil
+		}
+
+		if report.Attempts > format.Retries() {
+			report.Enforcement = models.FormatUnmet
+			log.Warn().
+				Str("model", modelName).
+				Str("format", string(format.Type)).
+				Int("attempts", report.Attempts).
+				Strs("violations", report.Violations).
+				Msg("Response never met its format")
+			return response, report, nil
+		}
+		log.Debug().
+			Str("model", modelName).
+			Str("format", string(format.Type)).
+			Int("attempt", report.Attempts).
+			Strs("violations", report.Violations).
+			Msg("Response missed its format, generating again")
+	}
+}
@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// scriptedBackend answers with its responses in turn
+type scriptedBackend struct {
+	native    bool
+	responses []string
+	formats   []*models.ResponseFormat
+}
+
+func (b *scriptedBackend) SupportsFormat(format *models.ResponseFormat) bool {
+	return b.native
+}
+
+func (b *scriptedBackend) GenerateFormat(ctx context.Context, modelName, prompt string, format *models.ResponseFormat) (*GenerateResponse, error) {
+	b.formats = append(b.formats, format)
+	response := b.responses[0]
+	if len(b.responses) > 1 {
+		b.responses = b.responses[1:]
+	}
+	return &GenerateResponse{Response: response, Done: true}, nil
+}
+
+func invoiceFormat(retries int) *models.ResponseFormat {
+	return &models.ResponseFormat{
+		Type:       models.ResponseFormatJSON,
+		Schema:     json.RawMessage(`{"type": "object", "required": ["total"], "properties": {"total": {"type": "number"}}}`),
+		MaxRetries: &retries,
+	}
+}
+
+func TestGenerateWithFormatNative(t *testing.T) {
+	backend := &scriptedBackend{native: true, responses: []string{`{"total": 12.5}`}}
+	format := invoiceFormat(2)
+
+	response, report, err := GenerateWithFormat(context.Background(), backend, "tiny", "total?", format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Response != `{"total": 12.5}` || report.Enforcement != models.FormatMetNatively || report.Attempts != 1 {
+		t.Fatalf("response %q, report %+v, want the first response met natively", response.Response, report)
+	}
+	if len(backend.formats) != 1 || backend.formats[0] != format {
+		t.Fatal("format not passed to the backend")
+	}
+}
+
+func TestGenerateWithFormatRetries(t *testing.T) {
+	backend := &scriptedBackend{responses: []string{"ANSWER: maybe", "ANSWER: yes"}}
+	format := &models.ResponseFormat{Type: models.ResponseFormatRegex, Pattern: `ANSWER: (yes|no)`}
+
+	response, report, err := GenerateWithFormat(context.Background(), backend, "tiny", "yes or no?", format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Response != "ANSWER: yes" || report.Enforcement != models.FormatMetByRetry || report.Attempts != 2 || len(report.Violations) != 0 {
+		t.Fatalf("response %q, report %+v, want the second response met by retry", response.Response, report)
+	}
+
+	// A native backend whose response still misses is checked and retried
+	backend = &scriptedBackend{native: true, responses: []string{`{"total": "12.5"}`, `{"total": 12.5}`}}
+	if _, report, err = GenerateWithFormat(context.Background(), backend, "tiny", "total?", invoiceFormat(2)); err != nil {
+		t.Fatal(err)
+	}
+	if report.Enforcement != models.FormatMetByRetry || report.Attempts != 2 {
+		t.Fatalf("report %+v, want the native miss met by retry", report)
+	}
+}
+
+func TestGenerateWithFormatExhausted(t *testing.T) {
+	backend := &scriptedBackend{responses: []string{"not json", `{"total": "12.5"}`}}
+
+	_, report, err := GenerateWithFormat(context.Background(), backend, "tiny", "total?", invoiceFormat(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Enforcement != models.FormatUnmet || report.Attempts != 2 {
+		t.Fatalf("report %+v, want unmet after one retry", report)
+	}
+	if len(report.Violations) != 1 || report.Violations[0] != "/total must be a number, got string" {
+		t.Fatalf("violations = %q, want the last response's schema violation", report.Violations)
+	}
+}
+
+func TestGrammarMatch(t *testing.T) {
+	grammar, err := ParseGrammar(`
+# a list of yes/no answers
+root   ::= answer ("," ws answer)*
+answer ::=
+  ("yes" | "no") [0-9]{0,2}
+ws     ::= [ \t\n]*
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for text, want := range map[string]bool{
+		"yes":             true,
+		"yes, no1,\nno42": true,
+		"yes,":            false,
+		"yes no":          false,
+		"no123":           false,
+		"":                false,
+	} {
+		if got := grammar.Match(text); got != want {
+			t.Errorf("Match(%q) = %v, want %v", text, got, want)
+		}
+	}
+
+	for _, source := range []string{`answer ::= "yes"`, `root ::= missing`, `root ::= "unterminated`} {
+		if _, err := ParseGrammar(source); err == nil {
+			t.Errorf("ParseGrammar(%q) accepted an invalid grammar", source)
+		}
+	}
+}
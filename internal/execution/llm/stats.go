@@ -0,0 +1,217 @@
+package llm
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const (
+	// DefaultModel serves LLM tasks that do not name a model
+	DefaultModel = "llama2"
+
+	statsWindowSize = 256
+	statsWindowAge  = 10 * time.Minute
+)
+
+// TaskModel returns the model an LLM task config asks for
+func TaskModel(config json.RawMessage) string {
+	var parsed struct {
+		Model         string         `json:"model"`
+		ModelArtifact *ModelArtifact `json:"model_artifact"`
+	}
+	if err := json.Unmarshal(config, &parsed); err != nil {
+		return DefaultModel
+	}
+	if parsed.ModelArtifact != nil && parsed.ModelArtifact.SHA256 != "" {
+		return parsed.ModelArtifact.ModelName()
+	}
+	if parsed.Model == "" {
+		return DefaultModel
+	}
+	return parsed.Model
+}
+
+// latencyWindow is a ring of the most recent samples. A writer claims a slot
+// with one atomic add and publishes with one atomic store, so concurrent
+// generations never contend on a lock. Each slot packs the sample's Unix
+// second into the high 32 bits and its value in microseconds into the low 32.
+type latencyWindow struct {
+	next  atomic.Uint64
+	slots [statsWindowSize]atomic.Uint64
+}
+
+func (w *latencyWindow) add(now time.Time, d time.Duration) {
+	micros := d.Microseconds()
+	if micros < 0 {
+		micros = 0
+	}
+	if micros > math.MaxUint32 {
+		micros = math.MaxUint32
+	}
+	slot := (w.next.Add(1) - 1) % statsWindowSize
+	w.slots[slot].Store(uint64(uint32(now.Unix()))<<32 | uint64(micros))
+}
+
+// values returns the samples taken within maxAge of now, sorted ascending
+func (w *latencyWindow) values(now time.Time, maxAge time.Duration) []time.Duration {
+	cutoff := now.Add(-maxAge).Unix()
+	var values []time.Duration
+	for i := range w.slots {
+		packed := w.slots[i].Load()
+		if packed == 0 || int64(packed>>32) < cutoff {
+			continue
+		}
+		values = append(values, time.Duration(uint32(packed))*time.Microsecond)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	return values
+}
+
+// percentile returns the nearest-rank q-th percentile of sorted values
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+type modelStats struct {
+	queue  atomic.Int64
+	loaded atomic.Bool
+	// per100 is generation latency per 100 response tokens, ttft the time to
+	// the first token once a request is served and service the whole request
+	per100  latencyWindow
+	ttft    latencyWindow
+	service latencyWindow
+}
+
+// Generation describes one completed request for Stats.Observe
+type Generation struct {
+	// Total is the wall time the request was served for, excluding queueing
+	Total time.Duration
+	// TTFT is model load plus prompt evaluation time
+	TTFT         time.Duration
+	EvalTokens   int
+	EvalDuration time.Duration
+}
+
+// Stats tracks per-model queue depth and generation latency over a sliding
+// window for latency-aware prompt routing
+type Stats struct {
+	clock  clock.Clock
+	models sync.Map
+}
+
+func NewStats(c clock.Clock) *Stats {
+	return &Stats{clock: c}
+}
+
+// SetClock replaces the clock that timestamps samples
+func (s *Stats) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+func (s *Stats) model(name string) *modelStats {
+	if m, ok := s.models.Load(name); ok {
+		return m.(*modelStats)
+	}
+	m, _ := s.models.LoadOrStore(name, &modelStats{})
+	return m.(*modelStats)
+}
+
+// Enqueue counts a request for model as queued or running until the
+// returned function is called
+func (s *Stats) Enqueue(model string) func() {
+	m := s.model(model)
+	m.queue.Add(1)
+	var once sync.Once
+	return func() { once.Do(func() { m.queue.Add(-1) }) }
+}
+
+// SetLoaded records whether model is resident in memory
+func (s *Stats) SetLoaded(model string, loaded bool) {
+	s.model(model).loaded.Store(loaded)
+}
+
+// Observe records a completed generation; the model is loaded afterwards
+func (s *Stats) Observe(model string, g Generation) {
+	m := s.model(model)
+	now := s.clock.Now()
+
+	generation := g.EvalDuration
+	if generation <= 0 {
+		generation = g.Total - g.TTFT
+	}
+	if g.EvalTokens > 0 && generation > 0 {
+		m.per100.add(now, generation*100/time.Duration(g.EvalTokens))
+	}
+	m.ttft.add(now, g.TTFT)
+	m.service.add(now, g.Total)
+	m.loaded.Store(true)
+}
+
+// ExpectedTTFT estimates how long a prompt for model submitted now would wait
+// for its first token: the requests already queued ahead at the median
+// service time plus the median time to first token. ok is false until the
+// model has served a request within the window.
+func (s *Stats) ExpectedTTFT(model string) (time.Duration, bool) {
+	m := s.model(model)
+	now := s.clock.Now()
+
+	ttft := m.ttft.values(now, statsWindowAge)
+	if len(ttft) == 0 {
+		return 0, false
+	}
+	wait := time.Duration(m.queue.Load()) * percentile(m.service.values(now, statsWindowAge), 0.5)
+	return wait + percentile(ttft, 0.5), true
+}
+
+// ModelStats returns the current statistics for model
+func (s *Stats) ModelStats(model string) models.LLMModelStats {
+	m := s.model(model)
+	per100 := m.per100.values(s.clock.Now(), statsWindowAge)
+
+	stats := models.LLMModelStats{
+		Model:             model,
+		Loaded:            m.loaded.Load(),
+		QueueDepth:        int(m.queue.Load()),
+		Samples:           len(per100),
+		P50MsPer100Tokens: durationMs(percentile(per100, 0.5)),
+		P95MsPer100Tokens: durationMs(percentile(per100, 0.95)),
+	}
+	if ttft, ok := s.ExpectedTTFT(model); ok {
+		stats.ExpectedTTFTMs = ttft.Milliseconds()
+	}
+	return stats
+}
+
+// Snapshot returns statistics for every model seen, ordered by name
+func (s *Stats) Snapshot() []models.LLMModelStats {
+	var names []string
+	s.models.Range(func(key, _ any) bool {
+		names = append(names, key.(string))
+		return true
+	})
+	sort.Strings(names)
+
+	snapshot := make([]models.LLMModelStats, 0, len(names))
+	for _, name := range names {
+		snapshot = append(snapshot, s.ModelStats(name))
+	}
+	return snapshot
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,156 @@
+package llm
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+)
+
+func newTestStats() (*Stats, *clocktest.Fake) {
+	fake := clocktest.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	return NewStats(fake), fake
+}
+
+func TestPercentileNearestRank(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 100; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+
+	if got := percentile(sorted, 0.5); got != 50*time.Millisecond {
+		t.Fatalf("p50 = %s, want 50ms", got)
+	}
+	if got := percentile(sorted, 0.95); got != 95*time.Millisecond {
+		t.Fatalf("p95 = %s, want 95ms", got)
+	}
+	if got := percentile(sorted[:1], 0.95); got != time.Millisecond {
+		t.Fatalf("p95 of one sample = %s, want 1ms", got)
+	}
+	if got := percentile(nil, 0.5); got != 0 {
+		t.Fatalf("percentile of no samples = %s, want 0", got)
+	}
+}
+
+func TestObserveNormalisesPer100Tokens(t *testing.T) {
+	stats, _ := newTestStats()
+
+	// 400 tokens in 2s is 500ms per 100 tokens
+	stats.Observe("llama3", Generation{Total: 3 * time.Second, TTFT: time.Second, EvalTokens: 400, EvalDuration: 2 * time.Second})
+	// Without an eval duration the time after the first token is used
+	stats.Observe("llama3", Generation{Total: 3 * time.Second, TTFT: time.Second, EvalTokens: 100})
+
+	got := stats.ModelStats("llama3")
+	if got.Samples != 2 || got.P50MsPer100Tokens != 500 || got.P95MsPer100Tokens != 2000 {
+		t.Fatalf("unexpected stats %+v", got)
+	}
+	if !got.Loaded {
+		t.Fatal("expected a model that served a request to be loaded")
+	}
+}
+
+func TestWindowDropsOldSamples(t *testing.T) {
+	stats, fake := newTestStats()
+
+	stats.Observe("llama3", Generation{Total: time.Second, EvalTokens: 100, EvalDuration: 4 * time.Second})
+	fake.Advance(statsWindowAge / 2)
+	stats.Observe("llama3", Generation{Total: time.Second, EvalTokens: 100, EvalDuration: time.Second})
+
+	if got := stats.ModelStats("llama3"); got.Samples != 2 || got.P95MsPer100Tokens != 4000 {
+		t.Fatalf("expected both samples in the window, got %+v", got)
+	}
+
+	fake.Advance(statsWindowAge/2 + time.Second)
+	if got := stats.ModelStats("llama3"); got.Samples != 1 || got.P95MsPer100Tokens != 1000 {
+		t.Fatalf("expected the old sample to age out, got %+v", got)
+	}
+}
+
+func TestWindowKeepsMostRecentSamples(t *testing.T) {
+	var w latencyWindow
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < statsWindowSize; i++ {
+		w.add(now, time.Second)
+	}
+	for i := 0; i < statsWindowSize/2; i++ {
+		w.add(now, 3*time.Second)
+	}
+
+	values := w.values(now, time.Minute)
+	if len(values) != statsWindowSize {
+		t.Fatalf("expected a full window, got %d samples", len(values))
+	}
+	if got := percentile(values, 0.5); got != time.Second {
+		t.Fatalf("p50 = %s, want 1s", got)
+	}
+	if got := percentile(values, 0.51); got != 3*time.Second {
+		t.Fatalf("p51 = %s, want 3s after overwriting half the window", got)
+	}
+}
+
+func TestExpectedTTFTIncludesQueue(t *testing.T) {
+	stats, _ := newTestStats()
+
+	if _, ok := stats.ExpectedTTFT("llama3"); ok {
+		t.Fatal("expected no estimate before any generation")
+	}
+
+	stats.Observe("llama3", Generation{Total: 4 * time.Second, TTFT: 500 * time.Millisecond, EvalTokens: 100})
+	stats.Observe("llama3", Generation{Total: 2 * time.Second, TTFT: 300 * time.Millisecond, EvalTokens: 100})
+	stats.Observe("llama3", Generation{Total: 6 * time.Second, TTFT: 700 * time.Millisecond, EvalTokens: 100})
+
+	if got, _ := stats.ExpectedTTFT("llama3"); got != 500*time.Millisecond {
+		t.Fatalf("idle TTFT = %s, want 500ms", got)
+	}
+
+	done := stats.Enqueue("llama3")
+	stats.Enqueue("llama3")
+	if got, _ := stats.ExpectedTTFT("llama3"); got != 8500*time.Millisecond {
+		t.Fatalf("TTFT behind two requests = %s, want 8.5s", got)
+	}
+	if depth := stats.ModelStats("llama3").QueueDepth; depth != 2 {
+		t.Fatalf("queue depth = %d, want 2", depth)
+	}
+
+	done()
+	done()
+	if depth := stats.ModelStats("llama3").QueueDepth; depth != 1 {
+		t.Fatalf("queue depth = %d, want 1 after one request finished", depth)
+	}
+}
+
+func TestStatsConcurrentObserve(t *testing.T) {
+	stats, _ := newTestStats()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				done := stats.Enqueue("llama3")
+				stats.Observe("llama3", Generation{Total: time.Second, TTFT: 100 * time.Millisecond, EvalTokens: 100, EvalDuration: time.Second})
+				stats.Snapshot()
+				done()
+			}
+		}()
+	}
+	wg.Wait()
+
+	got := stats.ModelStats("llama3")
+	if got.QueueDepth != 0 || got.Samples != statsWindowSize || got.P50MsPer100Tokens != 1000 {
+		t.Fatalf("unexpected stats after concurrent use %+v", got)
+	}
+}
+
+func TestTaskModel(t *testing.T) {
+	if got := TaskModel(json.RawMessage(`{"model":"mistral","prompt":"hi"}`)); got != "mistral" {
+		t.Fatalf("TaskModel() = %s, want mistral", got)
+	}
+	if got := TaskModel(json.RawMessage(`{"prompt":"hi"}`)); got != DefaultModel {
+		t.Fatalf("TaskModel() = %s, want default", got)
+	}
+}
@@ -0,0 +1,78 @@
+//go:build ignore
+
+// generate writes the fixture models of the llm package's tests:
+//
+//	go run generate.go
+//
+// tiny.gguf is a GGUF v3 model declaring Q4_K_M quantization, with a single
+// 32x8 tensor of Q4_0 blocks of zeros: enough for the runner to read and
+// size it. truncated.gguf is tiny.gguf cut short inside its tensor data, as
+// an interrupted download leaves it.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"os"
+)
+
+const (
+	typeUint32 = 4
+	typeString = 8
+	typeArray  = 9
+	fileQ4KM   = 15
+	tensorQ4_0 = 2
+	alignment  = 32
+)
+
+type writer struct{ bytes.Buffer }
+
+func (w *writer) u32(v uint32) { binary.Write(&w.Buffer, binary.LittleEndian, v) }
+func (w *writer) u64(v uint64) { binary.Write(&w.Buffer, binary.LittleEndian, v) }
+func (w *writer) str(s string) { w.u64(uint64(len(s))); w.WriteString(s) }
+
+func main() {
+	var w writer
+	w.WriteString("GGUF")
+	w.u32(3)
+	w.u64(1) // tensors
+	w.u64(4) // metadata entries
+
+	w.str("general.architecture")
+	w.u32(typeString)
+	w.str("llama")
+	w.str("general.name")
+	w.u32(typeString)
+	w.str("tiny")
+	w.str("general.file_type")
+	w.u32(typeUint32)
+	w.u32(fileQ4KM)
+	w.str("tokenizer.ggml.tokens")
+	w.u32(typeArray)
+	w.u32(typeString)
+	w.u64(2)
+	w.str("<s>")
+	w.str("</s>")
+
+	w.str("token_embd.weight")
+	w.u32(2)
+	w.u64(32)
+	w.u64(8)
+	w.u32(tensorQ4_0)
+	w.u64(0)
+
+	for w.Len()%alignment != 0 {
+		w.WriteByte(0)
+	}
+	// 256 elements in 8 blocks of 32, 18 bytes each
+	w.Write(make([]byte, 8*18))
+
+	tiny := w.Bytes()
+	if err := os.WriteFile("tiny.gguf", tiny, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("truncated.gguf", tiny[:len(tiny)-40], 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
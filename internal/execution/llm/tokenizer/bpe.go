@@ -0,0 +1,249 @@
+package tokenizer
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// byteRunes maps each byte to the printable rune GPT-2 style byte-level
+// vocabularies use for it
+var byteRunes = func() [256]rune {
+	var table [256]rune
+	n := 0
+	for b := 0; b < 256; b++ {
+		if (b >= '!' && b <= '~') || (b >= 0xA1 && b <= 0xAC) || (b >= 0xAE && b <= 0xFF) {
+			table[b] = rune(b)
+		} else {
+			table[b] = rune(256 + n)
+			n++
+		}
+	}
+	return table
+}()
+
+// preTokenizer selects the regular expression llama.cpp splits text with
+// before merging. Unknown pre-tokenizers fall back to the GPT-2 rules.
+type preTokenizer struct {
+	// caseInsensitive matches contractions such as 'S and 'LL
+	caseInsensitive bool
+	// letterPrefix lets a single non-letter, non-digit, non-newline rune
+	// join the letters that follow it, instead of only a space
+	letterPrefix bool
+	// maxDigits bounds the digits in one pre-token; zero means unbounded and
+	// lets a space join the digits that follow it
+	maxDigits int
+	// newlines splits runs of whitespace ending in newlines from the rest
+	newlines bool
+}
+
+func newPreTokenizer(pre string) preTokenizer {
+	switch pre {
+	case "llama-bpe", "llama3", "smaug-bpe", "dbrx":
+		return preTokenizer{caseInsensitive: true, letterPrefix: true, maxDigits: 3, newlines: true}
+	case "qwen2", "deepseek-r1-qwen":
+		return preTokenizer{caseInsensitive: true, letterPrefix: true, maxDigits: 1, newlines: true}
+	default:
+		return preTokenizer{}
+	}
+}
+
+func isLetter(r rune) bool { return unicode.IsLetter(r) }
+func isNumber(r rune) bool { return unicode.IsNumber(r) }
+func isNewline(r rune) bool {
+	return r == '\r' || r == '\n'
+}
+func isOther(r rune) bool {
+	return !unicode.IsSpace(r) && !isLetter(r) && !isNumber(r)
+}
+
+// split returns the pre-tokens of text
+func (p preTokenizer) split(text string) []string {
+	runes := []rune(text)
+	var words []string
+	for i := 0; i < len(runes); {
+		n := p.match(runes, i)
+		words = append(words, string(runes[i:i+n]))
+		i += n
+	}
+	return words
+}
+
+// match returns the length of the pre-token starting at runes[i]
+func (p preTokenizer) match(runes []rune, i int) int {
+	// Past the end reads as a space, which none of the rules below consume
+	at := func(j int) rune {
+		if j < len(runes) {
+			return runes[j]
+		}
+		return ' '
+	}
+	span := func(j int, pred func(rune) bool, limit int) int {
+		k := j
+		for k < len(runes) && pred(runes[k]) && (limit == 0 || k-j < limit) {
+			k++
+		}
+		return k - j
+	}
+
+	// Contractions
+	if runes[i] == '\'' {
+		rest := string(runes[i+1 : min(i+3, len(runes))])
+		if p.caseInsensitive {
+			rest = strings.ToLower(rest)
+		}
+		for _, c := range []string{"re", "ve", "ll"} {
+			if strings.HasPrefix(rest, c) {
+				return 3
+			}
+		}
+		if rest != "" && strings.ContainsRune("stmd", rune(rest[0])) {
+			return 2
+		}
+	}
+
+	// Letters, optionally after one prefix rune
+	if isLetter(runes[i]) {
+		return span(i, isLetter, 0)
+	}
+	prefixed := runes[i] == ' '
+	if p.letterPrefix {
+		prefixed = !isNewline(runes[i]) && !isNumber(runes[i])
+	}
+	if prefixed && isLetter(at(i+1)) {
+		return 1 + span(i+1, isLetter, 0)
+	}
+
+	// Numbers
+	if p.maxDigits > 0 {
+		if isNumber(runes[i]) {
+			return span(i, isNumber, p.maxDigits)
+		}
+	} else {
+		if isNumber(runes[i]) {
+			return span(i, isNumber, 0)
+		}
+		if runes[i] == ' ' && isNumber(at(i+1)) {
+			return 1 + span(i+1, isNumber, 0)
+		}
+	}
+
+	// Punctuation and symbols, optionally after a space
+	start := i
+	if runes[i] == ' ' && isOther(at(i+1)) {
+		start = i + 1
+	}
+	if isOther(runes[start]) {
+		n := start - i + span(start, isOther, 0)
+		if p.newlines {
+			n += span(i+n, isNewline, 0)
+		}
+		return n
+	}
+
+	// Whitespace
+	ws := span(i, unicode.IsSpace, 0)
+	if p.newlines {
+		last := -1
+		for k := i; k < i+ws; k++ {
+			if isNewline(runes[k]) {
+				last = k
+			}
+		}
+		if last >= 0 {
+			return last - i + 1
+		}
+	}
+	if ws > 1 && i+ws < len(runes) {
+		// Leave the last space to prefix the following word
+		return ws - 1
+	}
+	if ws > 0 {
+		return ws
+	}
+	return 1
+}
+
+// bpe merges byte-level symbols by rank
+type bpe struct {
+	t     *Tokenizer
+	pre   preTokenizer
+	ranks map[[2]string]int
+}
+
+func newBPE(t *Tokenizer) (*bpe, error) {
+	ranks := make(map[[2]string]int, len(t.spec.Merges))
+	for rank, merge := range t.spec.Merges {
+		left, right, ok := strings.Cut(merge, " ")
+		if !ok {
+			return nil, fmt.Errorf("invalid merge %q", merge)
+		}
+		pair := [2]string{left, right}
+		if _, ok := ranks[pair]; !ok {
+			ranks[pair] = rank
+		}
+	}
+	return &bpe{t: t, pre: newPreTokenizer(t.spec.Pre), ranks: ranks}, nil
+}
+
+func (b *bpe) encode(text string) []int {
+	var ids []int
+	for _, word := range b.pre.split(text) {
+		ids = append(ids, b.encodeWord(word)...)
+	}
+	return ids
+}
+
+func (b *bpe) encodeWord(word string) []int {
+	symbols := make([]string, 0, len(word))
+	for i := 0; i < len(word); i++ {
+		symbols = append(symbols, string(byteRunes[word[i]]))
+	}
+
+	for len(symbols) > 1 {
+		best, bestRank := -1, 0
+		for i := 0; i+1 < len(symbols); i++ {
+			if rank, ok := b.ranks[[2]string{symbols[i], symbols[i+1]}]; ok && (best < 0 || rank < bestRank) {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		pair := [2]string{symbols[best], symbols[best+1]}
+		merged := symbols[:0:0]
+		for i := 0; i < len(symbols); i++ {
+			if i+1 < len(symbols) && symbols[i] == pair[0] && symbols[i+1] == pair[1] {
+				merged = append(merged, pair[0]+pair[1])
+				i++
+				continue
+			}
+			merged = append(merged, symbols[i])
+		}
+		symbols = merged
+	}
+
+	ids := make([]int, 0, len(symbols))
+	for _, symbol := range symbols {
+		if id, ok := b.t.vocab[symbol]; ok {
+			ids = append(ids, id)
+			continue
+		}
+		// A merge result missing from the vocabulary falls back to its bytes
+		for _, r := range symbol {
+			ids = append(ids, b.t.byteToken(runeByte(r)))
+		}
+	}
+	return ids
+}
+
+// runeByte inverts byteRunes
+func runeByte(r rune) byte {
+	for b, br := range byteRunes {
+		if br == r {
+			return byte(b)
+		}
+	}
+	return 0
+}
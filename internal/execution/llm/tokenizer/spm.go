@@ -0,0 +1,122 @@
+package tokenizer
+
+import (
+	"container/heap"
+	"strings"
+	"unicode/utf8"
+)
+
+// spaceMarker replaces spaces in SentencePiece vocabularies
+const spaceMarker = "▁"
+
+// spm merges the adjacent pieces whose union scores highest, as llama.cpp's
+// SentencePiece tokenizer does, and falls back to byte tokens for pieces
+// missing from the vocabulary
+type spm struct {
+	t *Tokenizer
+}
+
+func newSPM(t *Tokenizer) *spm {
+	return &spm{t: t}
+}
+
+type spmSymbol struct {
+	text       string
+	prev, next int
+}
+
+type spmBigram struct {
+	left, right int
+	score       float32
+	size        int
+}
+
+type spmQueue []spmBigram
+
+func (q spmQueue) Len() int { return len(q) }
+func (q spmQueue) Less(i, j int) bool {
+	if q[i].score != q[j].score {
+		return q[i].score > q[j].score
+	}
+	return q[i].left < q[j].left
+}
+func (q spmQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *spmQueue) Push(x any)   { *q = append(*q, x.(spmBigram)) }
+func (q *spmQueue) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
+
+func (s *spm) encode(text string) []int {
+	text = spaceMarker + strings.ReplaceAll(text, " ", spaceMarker)
+
+	symbols := make([]spmSymbol, 0, utf8.RuneCountInString(text))
+	for i, r := range text {
+		size := utf8.RuneLen(r)
+		if size < 0 {
+			size = 1
+		}
+		symbols = append(symbols, spmSymbol{text: text[i : i+size], prev: len(symbols) - 1, next: len(symbols) + 1})
+	}
+	symbols[len(symbols)-1].next = -1
+
+	queue := &spmQueue{}
+	tryAdd := func(left, right int) {
+		if left < 0 || right < 0 {
+			return
+		}
+		piece := symbols[left].text + symbols[right].text
+		id, ok := s.t.vocab[piece]
+		if !ok {
+			return
+		}
+		heap.Push(queue, spmBigram{left: left, right: right, score: s.t.spec.Scores[id], size: len(piece)})
+	}
+	for i := 1; i < len(symbols); i++ {
+		tryAdd(i-1, i)
+	}
+
+	for queue.Len() > 0 {
+		bigram := heap.Pop(queue).(spmBigram)
+		left, right := &symbols[bigram.left], &symbols[bigram.right]
+		// Skip bigrams made stale by an earlier merge
+		if left.text == "" || right.text == "" || len(left.text)+len(right.text) != bigram.size {
+			continue
+		}
+
+		left.text += right.text
+		right.text = ""
+		left.next = right.next
+		if right.next >= 0 {
+			symbols[right.next].prev = bigram.left
+		}
+
+		tryAdd(left.prev, bigram.left)
+		tryAdd(bigram.left, left.next)
+	}
+
+	var ids []int
+	for i := 0; i >= 0; i = symbols[i].next {
+		ids = append(ids, s.resegment(symbols[i].text)...)
+	}
+	return ids
+}
+
+// resegment returns the tokens of a merged piece, spelling it out as bytes
+// when the vocabulary has no token for it
+func (s *spm) resegment(piece string) []int {
+	if id, ok := s.t.vocab[piece]; ok {
+		return []int{id}
+	}
+	ids := make([]int, 0, len(piece))
+	for i := 0; i < len(piece); i++ {
+		id := s.t.byteToken(piece[i])
+		if id < 0 {
+			id = s.t.unknownToken()
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,168 @@
+// Package tokenizer counts tokens the way llama.cpp based backends such as
+// Ollama do, from the vocabulary stored in a model's GGUF metadata. It covers
+// byte-level BPE vocabularies (GGUF model "gpt2") and SentencePiece
+// vocabularies (GGUF model "llama").
+package tokenizer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+)
+
+// GGUF tokenizer models
+const (
+	ModelBPE = "gpt2"
+	ModelSPM = "llama"
+)
+
+// Token types as stored in tokenizer.ggml.token_type
+const (
+	TypeNormal      = 1
+	TypeUnknown     = 2
+	TypeControl     = 3
+	TypeUserDefined = 4
+	TypeUnused      = 5
+	TypeByte        = 6
+)
+
+// Spec is a tokenizer as described by GGUF metadata
+type Spec struct {
+	Model      string    `json:"model"`
+	Pre        string    `json:"pre,omitempty"`
+	Tokens     []string  `json:"tokens"`
+	Scores     []float32 `json:"scores,omitempty"`
+	TokenTypes []int32   `json:"token_types,omitempty"`
+	Merges     []string  `json:"merges,omitempty"`
+	AddBOS     bool      `json:"add_bos,omitempty"`
+}
+
+// Tokenizer encodes text into token IDs
+type Tokenizer struct {
+	spec    Spec
+	version string
+	vocab   map[string]int
+	encode  func(text string) []int
+}
+
+// New builds the tokenizer spec describes
+func New(spec Spec) (*Tokenizer, error) {
+	if len(spec.Tokens) == 0 {
+		return nil, fmt.Errorf("tokenizer has no tokens")
+	}
+
+	t := &Tokenizer{
+		spec:    spec,
+		version: version(spec),
+		vocab:   make(map[string]int, len(spec.Tokens)),
+	}
+	for id, token := range spec.Tokens {
+		if _, ok := t.vocab[token]; !ok {
+			t.vocab[token] = id
+		}
+	}
+
+	switch spec.Model {
+	case ModelBPE:
+		bpe, err := newBPE(t)
+		if err != nil {
+			return nil, err
+		}
+		t.encode = bpe.encode
+	case ModelSPM:
+		if len(spec.Scores) != len(spec.Tokens) {
+			return nil, fmt.Errorf("sentencepiece tokenizer has %d scores for %d tokens", len(spec.Scores), len(spec.Tokens))
+		}
+		t.encode = newSPM(t).encode
+	default:
+		return nil, fmt.Errorf("unsupported tokenizer model %q", spec.Model)
+	}
+	return t, nil
+}
+
+// Version identifies the tokenizer model, pre-tokenizer and vocabulary, so
+// counts made with different vocabularies are never compared as equal
+func (t *Tokenizer) Version() string {
+	return t.version
+}
+
+// Encode returns the token IDs of text, without special tokens
+func (t *Tokenizer) Encode(text string) []int {
+	if text == "" {
+		return nil
+	}
+	return t.encode(text)
+}
+
+// Count returns the number of tokens in text, without special tokens
+func (t *Tokenizer) Count(text string) int {
+	return len(t.Encode(text))
+}
+
+// CountPrompt returns the number of tokens the backend evaluates for text
+// sent as a prompt, including the BOS token when the model adds one
+func (t *Tokenizer) CountPrompt(text string) int {
+	n := t.Count(text)
+	if t.spec.AddBOS {
+		n++
+	}
+	return n
+}
+
+// byteToken returns the ID of the token for a single byte, or -1
+func (t *Tokenizer) byteToken(b byte) int {
+	var piece string
+	if t.spec.Model == ModelBPE {
+		piece = string(byteRunes[b])
+	} else {
+		piece = fmt.Sprintf("<0x%02X>", b)
+	}
+	if id, ok := t.vocab[piece]; ok {
+		return id
+	}
+	return -1
+}
+
+// unknownToken returns the ID of the unknown token, or -1
+func (t *Tokenizer) unknownToken() int {
+	for id, typ := range t.spec.TokenTypes {
+		if typ == TypeUnknown {
+			return id
+		}
+	}
+	if id, ok := t.vocab["<unk>"]; ok {
+		return id
+	}
+	return -1
+}
+
+func version(spec Spec) string {
+	h := sha256.New()
+	write := func(s string) {
+		var n [8]byte
+		binary.BigEndian.PutUint64(n[:], uint64(len(s)))
+		h.Write(n[:])
+		h.Write([]byte(s))
+	}
+	write(spec.Model)
+	write(spec.Pre)
+	for _, token := range spec.Tokens {
+		write(token)
+	}
+	for _, merge := range spec.Merges {
+		write(merge)
+	}
+	for _, score := range spec.Scores {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], math.Float32bits(score))
+		h.Write(b[:])
+	}
+
+	name := spec.Model
+	if spec.Pre != "" {
+		name += "-" + spec.Pre
+	}
+	return name + ":" + hex.EncodeToString(h.Sum(nil))[:12]
+}
@@ -0,0 +1,136 @@
+package tokenizer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type fixture struct {
+	Spec   Spec `json:"spec"`
+	Pieces []struct {
+		Piece string  `json:"piece"`
+		Score float32 `json:"score"`
+	} `json:"pieces"`
+	Cases []struct {
+		Name   string `json:"name"`
+		Text   string `json:"text"`
+		Tokens int    `json:"tokens"`
+	} `json:"cases"`
+}
+
+// loadFixture builds the vocabulary a fixture describes: every byte plus the
+// results of its merges for BPE, and control, byte and listed pieces for
+// SentencePiece
+func loadFixture(t *testing.T, name string) (*Tokenizer, fixture) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f fixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatal(err)
+	}
+
+	spec := f.Spec
+	add := func(piece string, score float32, typ int32) {
+		spec.Tokens = append(spec.Tokens, piece)
+		spec.Scores = append(spec.Scores, score)
+		spec.TokenTypes = append(spec.TokenTypes, typ)
+	}
+	switch spec.Model {
+	case ModelBPE:
+		for _, r := range byteRunes {
+			add(string(r), 0, TypeNormal)
+		}
+		for _, merge := range spec.Merges {
+			add(strings.Replace(merge, " ", "", 1), 0, TypeNormal)
+		}
+		spec.Scores = nil
+	case ModelSPM:
+		add("<unk>", 0, TypeUnknown)
+		add("<s>", 0, TypeControl)
+		add("</s>", 0, TypeControl)
+		for b := 0; b < 256; b++ {
+			add(fmt.Sprintf("<0x%02X>", b), 0, TypeByte)
+		}
+		for _, p := range f.Pieces {
+			add(p.Piece, p.Score, TypeNormal)
+		}
+	}
+
+	tok, err := New(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok, f
+}
+
+func TestFixtureCounts(t *testing.T) {
+	for _, name := range []string{"gpt2.json", "llama3.json", "llama.json"} {
+		tok, f := loadFixture(t, name)
+		for _, c := range f.Cases {
+			t.Run(strings.TrimSuffix(name, ".json")+"/"+c.Name, func(t *testing.T) {
+				if got := tok.Count(c.Text); got != c.Tokens {
+					t.Fatalf("Count(%q) = %d, want %d (ids %v)", c.Text, got, c.Tokens, tok.Encode(c.Text))
+				}
+			})
+		}
+	}
+}
+
+func TestEncodeNeverDropsBytes(t *testing.T) {
+	text := "naïve café ☕ — 𝓉𝑒𝓍𝓉 👩‍👩‍👧 ‘quoted’ 12345\t\r\n"
+	for _, name := range []string{"gpt2.json", "llama3.json", "llama.json"} {
+		tok, _ := loadFixture(t, name)
+		for _, id := range tok.Encode(text) {
+			if id < 0 {
+				t.Fatalf("%s: Encode produced an invalid token id", name)
+			}
+		}
+	}
+}
+
+func TestCountPromptAddsBOS(t *testing.T) {
+	tok, _ := loadFixture(t, "llama.json")
+	if got := tok.CountPrompt("hello world"); got != 3 {
+		t.Fatalf("CountPrompt() = %d, want 3 with BOS", got)
+	}
+	gpt2, _ := loadFixture(t, "gpt2.json")
+	if got := gpt2.CountPrompt("hello world"); got != 5 {
+		t.Fatalf("CountPrompt() = %d, want 5 without BOS", got)
+	}
+}
+
+func TestVersionIdentifiesVocabulary(t *testing.T) {
+	gpt2, _ := loadFixture(t, "gpt2.json")
+	llama3, _ := loadFixture(t, "llama3.json")
+	again, _ := loadFixture(t, "gpt2.json")
+
+	if gpt2.Version() != again.Version() {
+		t.Fatal("version is not stable for the same vocabulary")
+	}
+	if gpt2.Version() == llama3.Version() {
+		t.Fatal("version ignores the pre-tokenizer")
+	}
+	if !strings.HasPrefix(llama3.Version(), "gpt2-llama-bpe:") {
+		t.Fatalf("Version() = %q", llama3.Version())
+	}
+}
+
+func TestNewRejectsInvalidSpecs(t *testing.T) {
+	for _, spec := range []Spec{
+		{},
+		{Model: "wordpiece", Tokens: []string{"a"}},
+		{Model: ModelSPM, Tokens: []string{"a"}},
+		{Model: ModelBPE, Tokens: []string{"a"}, Merges: []string{"ab"}},
+	} {
+		if _, err := New(spec); err == nil {
+			t.Fatalf("New(%+v) succeeded", spec)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm/tokenizer"
+)
+
+// TokenizerStore loads the tokenizer of each model from the vocabulary
+// Ollama exposes and caches it on disk until the model is pulled again
+type TokenizerStore struct {
+	baseURL string
+	client  *http.Client
+	dir     string
+
+	mu     sync.Mutex
+	loaded map[string]*cachedTokenizer
+}
+
+type cachedTokenizer struct {
+	ModifiedAt string         `json:"modified_at"`
+	Spec       tokenizer.Spec `json:"spec"`
+
+	tokenizer *tokenizer.Tokenizer
+}
+
+func NewTokenizerStore(baseURL, dir string) *TokenizerStore {
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	return &TokenizerStore{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  &http.Client{Timeout: time.Minute},
+		dir:     dir,
+		loaded:  make(map[string]*cachedTokenizer),
+	}
+}
+
+// Get returns the tokenizer of model
+func (s *TokenizerStore) Get(ctx context.Context, model string) (*tokenizer.Tokenizer, error) {
+	model = CanonicalModelName(model)
+
+	var current struct {
+		ModifiedAt string `json:"modified_at"`
+	}
+	if err := s.show(ctx, model, false, &current); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cached, ok := s.loaded[model]; ok && cached.ModifiedAt == current.ModifiedAt {
+		return cached.tokenizer, nil
+	}
+	if cached, err := s.readCache(model); err == nil && cached.ModifiedAt == current.ModifiedAt {
+		s.loaded[model] = cached
+		return cached.tokenizer, nil
+	}
+
+	var verbose struct {
+		ModifiedAt string                     `json:"modified_at"`
+		ModelInfo  map[string]json.RawMessage `json:"model_info"`
+	}
+	if err := s.show(ctx, model, true, &verbose); err != nil {
+		return nil, err
+	}
+	spec, err := specFromModelInfo(verbose.ModelInfo)
+	if err != nil {
+		return nil, fmt.Errorf("model %s: %w", model, err)
+	}
+	tok, err := tokenizer.New(spec)
+	if err != nil {
+		return nil, fmt.Errorf("model %s: %w", model, err)
+	}
+
+	cached := &cachedTokenizer{ModifiedAt: verbose.ModifiedAt, Spec: spec, tokenizer: tok}
+	s.loaded[model] = cached
+	if err := s.writeCache(model, cached); err != nil {
+		log := gologger.WithComponent("llm")
+		log.Warn().Err(err).Str("model", model).Msg("Failed to cache tokenizer on disk")
+	}
+	return tok, nil
+}
+
+func (s *TokenizerStore) show(ctx context.Context, model string, verbose bool, out interface{}) error {
+	reqBody, err := json.Marshal(map[string]interface{}{"model": model, "verbose": verbose})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/api/show", bytes.NewBuffer(reqBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("ollama show request failed with status: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// specFromModelInfo reads the tokenizer.ggml.* GGUF keys Ollama reports
+func specFromModelInfo(info map[string]json.RawMessage) (tokenizer.Spec, error) {
+	var spec tokenizer.Spec
+	fields := []struct {
+		key      string
+		out      interface{}
+		required bool
+	}{
+		{"tokenizer.ggml.model", &spec.Model, true},
+		{"tokenizer.ggml.tokens", &spec.Tokens, true},
+		{"tokenizer.ggml.pre", &spec.Pre, false},
+		{"tokenizer.ggml.scores", &spec.Scores, false},
+		{"tokenizer.ggml.token_type", &spec.TokenTypes, false},
+		{"tokenizer.ggml.merges", &spec.Merges, false},
+		{"tokenizer.ggml.add_bos_token", &spec.AddBOS, false},
+	}
+	for _, field := range fields {
+		raw, ok := info[field.key]
+		if !ok || string(raw) == "null" {
+			if field.required {
+				return spec, fmt.Errorf("tokenizer not reported (missing %s)", field.key)
+			}
+			continue
+		}
+		if err := json.Unmarshal(raw, field.out); err != nil {
+			return spec, fmt.Errorf("invalid %s: %w", field.key, err)
+		}
+	}
+	return spec, nil
+}
+
+func (s *TokenizerStore) cachePath(model string) string {
+	name := strings.NewReplacer("/", "_", ":", "_").Replace(model)
+	return filepath.Join(s.dir, name+".json")
+}
+
+func (s *TokenizerStore) readCache(model string) (*cachedTokenizer, error) {
+	if s.dir == "" {
+		return nil, os.ErrNotExist
+	}
+	data, err := os.ReadFile(s.cachePath(model))
+	if err != nil {
+		return nil, err
+	}
+	var cached cachedTokenizer
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, err
+	}
+	cached.tokenizer, err = tokenizer.New(cached.Spec)
+	if err != nil {
+		return nil, err
+	}
+	return &cached, nil
+}
+
+func (s *TokenizerStore) writeCache(model string, cached *cachedTokenizer) error {
+	if s.dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create tokenizer cache: %w", err)
+	}
+	data, err := json.Marshal(cached)
+	if err != nil {
+		return fmt.Errorf("failed to encode tokenizer: %w", err)
+	}
+	if err := atrest.WriteFileAtomic(s.cachePath(model), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write tokenizer cache: %w", err)
+	}
+	return nil
+}
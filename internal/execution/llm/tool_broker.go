@@ -0,0 +1,260 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ErrToolUseDisabled is returned for LLM tasks asking for tools on a runner
+// that does not call them
+var ErrToolUseDisabled = errors.New("tool use is not enabled on this runner")
+
+// ErrToolNetworkPolicy is returned by tools asked to reach an address the
+// runner's network policy does not allow
+var ErrToolNetworkPolicy = errors.New("address not allowed by the network policy")
+
+// transcriptResultLimit bounds the result of each call kept in the
+// transcript; the model is given the whole result
+const transcriptResultLimit = 4 << 10
+
+// DefaultToolBudget is what a task's tools may spend on a runner that sets
+// no budget of its own
+var DefaultToolBudget = models.ToolBudget{
+	MaxCalls:      16,
+	MaxFetchBytes: 8 << 20,
+	MaxToolTime:   2 * time.Minute,
+}
+
+// ToolOutput is what a tool call produced
+type ToolOutput struct {
+	// Content is what the model is given
+	Content string
+	// Bytes is what the call fetched over the network
+	Bytes int64
+	// Truncated is set when the call had more to read than it was allowed
+	Truncated bool
+}
+
+// Tool is a tool the runner calls on a model's behalf
+type Tool interface {
+	// Definition offers the tool to the model
+	Definition() ToolDefinition
+	// Call runs the tool with the arguments the model gave, fetching at
+	// most limit bytes
+	Call(ctx context.Context, args json.RawMessage, limit int64) (*ToolOutput, error)
+}
+
+// ToolResponse is the answer a model gave after using tools, with the
+// tokens of every turn it took
+type ToolResponse struct {
+	Response        string
+	PromptEvalCount int
+	EvalCount       int
+	TotalDuration   int64
+}
+
+// ToolBroker lets LLM tasks' models call tools. The model backend never
+// calls a tool: the model asks for a call in its response, and the broker
+// makes it, metered against the task's budget, and answers with the result
+// on the next turn. Once a budget is spent the broker stops offering tools
+// and asks the model for a final answer, so a task whose model keeps
+// asking ends with the reason rather than running on.
+type ToolBroker struct {
+	tools map[string]Tool
+	clock clock.Clock
+}
+
+// NewToolBroker returns a broker calling tools
+func NewToolBroker(tools ...Tool) *ToolBroker {
+	b := &ToolBroker{tools: make(map[string]Tool, len(tools)), clock: clock.Real()}
+	for _, tool := range tools {
+		b.tools[tool.Definition().Function.Name] = tool
+	}
+	return b
+}
+
+// SetClock replaces the clock tool calls are timed with
+func (b *ToolBroker) SetClock(c clock.Clock) {
+	b.clock = c
+}
+
+// Check returns an error naming the first tool in config the broker does
+// not call
+func (b *ToolBroker) Check(config *models.ToolUseConfig) error {
+	for _, name := range config.Tools {
+		if _, ok := b.tools[name]; !ok {
+			return fmt.Errorf("%w: tool %s is not available", ErrToolUseDisabled, name)
+		}
+	}
+	return nil
+}
+
+// toolSession is the state of one task's tool use
+type toolSession struct {
+	broker  *ToolBroker
+	offered map[string]Tool
+	report  *models.ToolUseReport
+}
+
+// Run answers prompt with modelName, calling the tools config offers
+// whenever the model asks, within budget. The report is returned along
+// with errors ending the conversation.
+func (b *ToolBroker) Run(ctx context.Context, chatter Chatter, modelName, prompt string, config *models.ToolUseConfig, budget models.ToolBudget) (*ToolResponse, *models.ToolUseReport, error) {
+	if err := b.Check(config); err != nil {
+		return nil, nil, err
+	}
+	s := &toolSession{
+		broker:  b,
+		offered: make(map[string]Tool, len(config.Tools)),
+		report:  &models.ToolUseReport{Budget: budget},
+	}
+	var definitions []ToolDefinition
+	for _, name := range config.Tools {
+		if _, ok := s.offered[name]; ok {
+			continue
+		}
+		s.offered[name] = b.tools[name]
+		definitions = append(definitions, b.tools[name].Definition())
+	}
+
+	messages := []ChatMessage{{Role: "user", Content: prompt}}
+	var answer ToolResponse
+	for {
+		req := &ChatRequest{Model: modelName, Messages: messages}
+		if s.report.StopReason == "" {
+			req.Tools = definitions
+		}
+		resp, err := chatter.Chat(ctx, req)
+		if err != nil {
+			return nil, s.report, err
+		}
+		s.report.Turns++
+		answer.Response = resp.Message.Content
+		answer.PromptEvalCount += resp.PromptEvalCount
+		answer.EvalCount += resp.EvalCount
+		answer.TotalDuration += resp.TotalDuration
+
+		stopped := s.report.StopReason != ""
+		if len(resp.Message.ToolCalls) == 0 {
+			return &answer, s.report, nil
+		}
+		messages = append(messages, resp.Message)
+		for _, call := range resp.Message.ToolCalls {
+			messages = append(messages, s.call(ctx, call))
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, s.report, err
+		}
+		if stopped {
+			// The model was asked for a final answer and asked for tools
+			// again: its content is all the answer there is
+			return &answer, s.report, nil
+		}
+		if s.report.StopReason == "" && s.report.Turns >= models.MaxToolTurns {
+			s.report.StopReason = models.ToolStopMaxTurns
+		}
+		if s.report.StopReason != "" {
+			messages = append(messages, ChatMessage{
+				Role:    "system",
+				Content: fmt.Sprintf("The tool budget is spent (%s). Answer now without calling tools.", s.report.StopReason),
+			})
+		}
+	}
+}
+
+// call makes the call the model asked for, unless it may not, and returns
+// the message answering it
+func (s *toolSession) call(ctx context.Context, call ToolCall) ChatMessage {
+	name := call.Function.Name
+	record := models.ToolCallRecord{
+		Tool:      name,
+		Arguments: call.Function.Arguments,
+		StartedAt: s.broker.clock.Now(),
+	}
+	answer := func(content string) ChatMessage {
+		s.report.Calls = append(s.report.Calls, record)
+		return ChatMessage{Role: "tool", ToolName: name, Content: content}
+	}
+
+	tool, ok := s.offered[name]
+	if !ok {
+		record.Denied = models.ToolDeniedNotOffered
+		return answer(fmt.Sprintf("denied: tool %q is not offered", name))
+	}
+	if denied := s.exhausted(name); denied != "" {
+		record.Denied = denied
+		return answer(fmt.Sprintf("denied: the tool budget is spent (%s)", denied))
+	}
+
+	budget, usage := s.report.Budget, &s.report.Usage
+	callCtx, cancel := context.WithTimeout(ctx, budget.MaxToolTime-usage.ToolTime)
+	defer cancel()
+	start := clock.Start(s.broker.clock)
+	output, err := tool.Call(callCtx, call.Function.Arguments, budget.MaxFetchBytes-usage.FetchedBytes)
+	record.Duration = start.Elapsed()
+
+	usage.Calls++
+	usage.ToolTime = min(usage.ToolTime+record.Duration, budget.MaxToolTime)
+	if callCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		// The call was cut off by the time budget however long the clock
+		// says it took
+		usage.ToolTime = budget.MaxToolTime
+	}
+	if output != nil {
+		usage.FetchedBytes += output.Bytes
+		record.Bytes = output.Bytes
+		record.Truncated = output.Truncated
+	}
+	if s.report.StopReason == "" {
+		switch {
+		case usage.ToolTime >= budget.MaxToolTime:
+			s.report.StopReason = models.ToolStopMaxToolTime
+		case usage.FetchedBytes >= budget.MaxFetchBytes && record.Truncated:
+			s.report.StopReason = models.ToolStopMaxFetchBytes
+		}
+	}
+
+	log := gologger.WithComponent("tool_broker")
+	switch {
+	case errors.Is(err, ErrToolNetworkPolicy):
+		record.Denied = models.ToolDeniedNetworkPolicy
+		record.Error = err.Error()
+		log.Warn().Err(err).Str("tool", name).Msg("Tool call denied by the network policy")
+		return answer("denied: " + err.Error())
+	case err != nil:
+		record.Error = err.Error()
+		return answer("error: " + err.Error())
+	}
+	record.Result = output.Content
+	if len(record.Result) > transcriptResultLimit {
+		record.Result = record.Result[:transcriptResultLimit]
+		record.Truncated = true
+	}
+	return answer(output.Content)
+}
+
+// exhausted returns the budget a call to tool would exceed, empty when it
+// may be made, ending the task's tool use once one is spent
+func (s *toolSession) exhausted(tool string) string {
+	if s.report.StopReason != "" {
+		return s.report.StopReason
+	}
+	budget, usage := s.report.Budget, s.report.Usage
+	switch {
+	case usage.Calls >= budget.MaxCalls:
+		s.report.StopReason = models.ToolStopMaxCalls
+	case usage.ToolTime >= budget.MaxToolTime:
+		s.report.StopReason = models.ToolStopMaxToolTime
+	case tool == models.ToolWebFetch && usage.FetchedBytes >= budget.MaxFetchBytes:
+		s.report.StopReason = models.ToolStopMaxFetchBytes
+	}
+	return s.report.StopReason
+}
@@ -0,0 +1,207 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// scriptedChatter asks for the calls of its turns in order, and answers
+// "done" once they run out
+type scriptedChatter struct {
+	turns    [][]ToolCall
+	requests []*ChatRequest
+}
+
+func (c *scriptedChatter) Chat(ctx context.Context, req *ChatRequest) (*ChatResponse, error) {
+	c.requests = append(c.requests, req)
+	if len(c.turns) == 0 {
+		return &ChatResponse{Message: ChatMessage{Role: "assistant", Content: "done"}, Done: true, EvalCount: 1}, nil
+	}
+	calls := c.turns[0]
+	c.turns = c.turns[1:]
+	return &ChatResponse{Message: ChatMessage{Role: "assistant", ToolCalls: calls}, Done: true, EvalCount: 1}, nil
+}
+
+// fakeTool fetches size bytes per call, taking took on clk
+type fakeTool struct {
+	name  string
+	size  int64
+	took  time.Duration
+	clk   *clocktest.Fake
+	calls int
+}
+
+func (t *fakeTool) Definition() ToolDefinition {
+	return ToolDefinition{Type: "function", Function: ToolFunction{Name: t.name}}
+}
+
+func (t *fakeTool) Call(ctx context.Context, args json.RawMessage, limit int64) (*ToolOutput, error) {
+	t.calls++
+	t.clk.Advance(t.took)
+	output := &ToolOutput{Content: strings.Repeat("x", int(min(t.size, limit))), Bytes: min(t.size, limit)}
+	output.Truncated = t.size > limit
+	return output, nil
+}
+
+func call(tool string) ToolCall {
+	return ToolCall{Function: ToolCallFunction{Name: tool, Arguments: json.RawMessage(`{"url": "https://example.com"}`)}}
+}
+
+func repeatTurns(tool string, n int) [][]ToolCall {
+	turns := make([][]ToolCall, n)
+	for i := range turns {
+		turns[i] = []ToolCall{call(tool)}
+	}
+	return turns
+}
+
+func newFakeBroker(tool *fakeTool) *ToolBroker {
+	tool.clk = clocktest.NewFake(time.Unix(0, 0))
+	broker := NewToolBroker(tool)
+	broker.SetClock(tool.clk)
+	return broker
+}
+
+var roomyBudget = models.ToolBudget{MaxCalls: 100, MaxFetchBytes: 1 << 20, MaxToolTime: time.Hour}
+
+func TestToolBrokerAnswersAfterToolCalls(t *testing.T) {
+	tool := &fakeTool{name: models.ToolWebFetch, size: 10, took: time.Second}
+	chatter := &scriptedChatter{turns: repeatTurns(models.ToolWebFetch, 2)}
+	config := &models.ToolUseConfig{Tools: []string{models.ToolWebFetch}}
+
+	response, report, err := newFakeBroker(tool).Run(context.Background(), chatter, "tiny", "look it up", config, roomyBudget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Response != "done" || response.EvalCount != 3 || report.StopReason != "" || report.Turns != 3 {
+		t.Fatalf("response %+v, report %+v, want done after 3 turns", response, report)
+	}
+	if report.Usage.Calls != 2 || report.Usage.FetchedBytes != 20 || report.Usage.ToolTime != 2*time.Second {
+		t.Fatalf("usage %+v, want 2 calls of 10 bytes and 1s each", report.Usage)
+	}
+	if len(report.Calls) != 2 || report.Calls[0].Result != strings.Repeat("x", 10) || report.Calls[0].Duration != time.Second {
+		t.Fatalf("transcript %+v", report.Calls)
+	}
+	last := chatter.requests[2].Messages
+	if tail := last[len(last)-1]; tail.Role != "tool" || tail.ToolName != models.ToolWebFetch {
+		t.Fatalf("last message %+v, want the tool result", tail)
+	}
+}
+
+func TestToolBrokerStopsAtEachBudget(t *testing.T) {
+	tests := []struct {
+		name       string
+		tool       *fakeTool
+		budget     models.ToolBudget
+		wantReason string
+		wantCalls  int
+	}{
+		{
+			name:       "calls",
+			tool:       &fakeTool{name: models.ToolWebFetch, size: 10},
+			budget:     models.ToolBudget{MaxCalls: 3, MaxFetchBytes: 1 << 20, MaxToolTime: time.Hour},
+			wantReason: models.ToolStopMaxCalls,
+			wantCalls:  3,
+		},
+		{
+			name:       "fetch bytes",
+			tool:       &fakeTool{name: models.ToolWebFetch, size: 400},
+			budget:     models.ToolBudget{MaxCalls: 100, MaxFetchBytes: 1000, MaxToolTime: time.Hour},
+			wantReason: models.ToolStopMaxFetchBytes,
+			wantCalls:  3,
+		},
+		{
+			name:       "tool time",
+			tool:       &fakeTool{name: models.ToolCodeExec, took: 4 * time.Second},
+			budget:     models.ToolBudget{MaxCalls: 100, MaxFetchBytes: 1 << 20, MaxToolTime: 10 * time.Second},
+			wantReason: models.ToolStopMaxToolTime,
+			wantCalls:  3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The model would call tools forever
+			chatter := &scriptedChatter{turns: repeatTurns(tt.tool.name, 1000)}
+			config := &models.ToolUseConfig{Tools: []string{tt.tool.name}}
+
+			response, report, err := newFakeBroker(tt.tool).Run(context.Background(), chatter, "tiny", "go", config, tt.budget)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.StopReason != tt.wantReason {
+				t.Fatalf("stop reason %q, want %q", report.StopReason, tt.wantReason)
+			}
+			if tt.tool.calls != tt.wantCalls || report.Usage.Calls != tt.wantCalls {
+				t.Fatalf("tool called %d times, %d recorded, want %d", tt.tool.calls, report.Usage.Calls, tt.wantCalls)
+			}
+			if response == nil || report.Turns > tt.wantCalls+2 {
+				t.Fatalf("generation did not end promptly: %d turns", report.Turns)
+			}
+			final := chatter.requests[len(chatter.requests)-1]
+			if len(final.Tools) != 0 {
+				t.Fatal("tools still offered after the budget was spent")
+			}
+			if denied := report.Calls[len(report.Calls)-1]; tt.wantReason == models.ToolStopMaxCalls && denied.Denied != models.ToolStopMaxCalls {
+				t.Fatalf("call past the budget recorded as %+v, want denied", denied)
+			}
+		})
+	}
+}
+
+func TestToolBrokerDeniesToolsNotOffered(t *testing.T) {
+	tool := &fakeTool{name: models.ToolWebFetch}
+	chatter := &scriptedChatter{turns: repeatTurns(models.ToolCodeExec, 1000)}
+	config := &models.ToolUseConfig{Tools: []string{models.ToolWebFetch}}
+
+	_, report, err := newFakeBroker(tool).Run(context.Background(), chatter, "tiny", "go", config, roomyBudget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tool.calls != 0 || report.StopReason != models.ToolStopMaxTurns || report.Turns != models.MaxToolTurns+1 {
+		t.Fatalf("report %+v after %d calls, want every call denied until max_turns", report.Usage, tool.calls)
+	}
+	if report.Calls[0].Denied != models.ToolDeniedNotOffered {
+		t.Fatalf("call recorded as %+v, want denied as not offered", report.Calls[0])
+	}
+}
+
+func TestToolBrokerRejectsUnavailableTools(t *testing.T) {
+	broker := NewToolBroker(&fakeTool{name: models.ToolWebFetch})
+	config := &models.ToolUseConfig{Tools: []string{models.ToolCodeExec}}
+	if _, _, err := broker.Run(context.Background(), &scriptedChatter{}, "tiny", "go", config, roomyBudget); !errors.Is(err, ErrToolUseDisabled) {
+		t.Fatalf("err %v, want ErrToolUseDisabled", err)
+	}
+}
+
+func TestWebFetchFollowsNetworkPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, strings.Repeat("y", 100))
+	}))
+	defer server.Close()
+	args := json.RawMessage(fmt.Sprintf(`{"url": %q}`, server.URL))
+
+	denied := NewWebFetch(func(ip net.IP) bool { return !ip.IsLoopback() })
+	if _, err := denied.Call(context.Background(), args, 1000); !errors.Is(err, ErrToolNetworkPolicy) {
+		t.Fatalf("err %v, want ErrToolNetworkPolicy for a loopback address", err)
+	}
+
+	allowed := NewWebFetch(func(net.IP) bool { return true })
+	output, err := allowed.Call(context.Background(), args, 40)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output.Bytes != 40 || !output.Truncated || !strings.HasSuffix(output.Content, strings.Repeat("y", 40)) {
+		t.Fatalf("output %+v, want 40 bytes truncated", output)
+	}
+}
@@ -0,0 +1,206 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const (
+	// toolResultLimit bounds what one call gives the model, so a single
+	// page cannot fill its context
+	toolResultLimit = 64 << 10
+	// maxFetchRedirects bounds the redirects web_fetch follows
+	maxFetchRedirects = 5
+	// DefaultCodeExecImage runs code_exec snippets
+	DefaultCodeExecImage = "python:3.12-alpine"
+)
+
+// WebFetch fetches URLs for the model. Every address it connects to,
+// including those redirects lead to, must be allowed by the network policy.
+type WebFetch struct {
+	allow  func(net.IP) bool
+	client *http.Client
+}
+
+// NewWebFetch returns a web_fetch tool connecting only to addresses allow
+// accepts
+func NewWebFetch(allow func(net.IP) bool) *WebFetch {
+	f := &WebFetch{allow: allow}
+	f.client = &http.Client{
+		Transport: &http.Transport{
+			// Never through a proxy, which would connect on the tool's
+			// behalf to addresses the policy was not asked about
+			Proxy:       nil,
+			DialContext: f.dial,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+	return f
+}
+
+// dial connects to the first address of addr's host, refusing hosts that
+// resolve to an address the policy does not allow. It connects to the
+// address it checked, so the host cannot be rebound to another in between.
+func (f *WebFetch) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if !f.allow(ip) {
+			return nil, fmt.Errorf("%w: %s resolves to %s", ErrToolNetworkPolicy, host, ip)
+		}
+	}
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].String(), port))
+}
+
+func (f *WebFetch) Definition() ToolDefinition {
+	return ToolDefinition{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        models.ToolWebFetch,
+			Description: "Fetch a URL over HTTP(S) and return the response body",
+			Parameters:  json.RawMessage(`{"type": "object", "required": ["url"], "properties": {"url": {"type": "string", "description": "the http or https URL to fetch"}}}`),
+		},
+	}
+}
+
+func (f *WebFetch) Call(ctx context.Context, args json.RawMessage, limit int64) (*ToolOutput, error) {
+	var parsed struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(args, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+	target, err := url.Parse(parsed.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return nil, fmt.Errorf("url must be an http or https URL")
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && errors.Is(urlErr.Err, ErrToolNetworkPolicy) {
+			return nil, urlErr.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	read := min(limit, toolResultLimit)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, read+1))
+	output := &ToolOutput{Bytes: int64(len(body))}
+	if output.Bytes > read {
+		body = body[:read]
+		output.Bytes = read
+		output.Truncated = true
+	}
+	output.Content = fmt.Sprintf("HTTP %d\n\n%s", resp.StatusCode, body)
+	if err != nil {
+		return output, fmt.Errorf("failed to read response: %w", err)
+	}
+	return output, nil
+}
+
+// CodeExec runs Python snippets for the model in a throwaway container
+// with no network, so code the model writes reaches nothing
+type CodeExec struct {
+	image string
+}
+
+// NewCodeExec returns a code_exec tool running snippets in image, the
+// default image when empty
+func NewCodeExec(image string) *CodeExec {
+	if image == "" {
+		image = DefaultCodeExecImage
+	}
+	return &CodeExec{image: image}
+}
+
+func (c *CodeExec) Definition() ToolDefinition {
+	return ToolDefinition{
+		Type: "function",
+		Function: ToolFunction{
+			Name:        models.ToolCodeExec,
+			Description: "Run a Python 3 snippet without network access and return what it prints",
+			Parameters:  json.RawMessage(`{"type": "object", "required": ["code"], "properties": {"code": {"type": "string", "description": "the Python source to run"}}}`),
+		},
+	}
+}
+
+func (c *CodeExec) Call(ctx context.Context, args json.RawMessage, limit int64) (*ToolOutput, error) {
+	var parsed struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(args, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid arguments: %w", err)
+	}
+	if strings.TrimSpace(parsed.Code) == "" {
+		return nil, fmt.Errorf("code is required")
+	}
+	cmd := exec.CommandContext(ctx, "docker", "run", "--rm", "-i",
+		"--network", "none",
+		"--read-only", "--tmpfs", "/tmp:size=16m",
+		"--memory", "256m", "--cpus", "1", "--pids-limit", "64",
+		"--cap-drop", "ALL", "--security-opt", "no-new-privileges",
+		c.image, "python3", "-")
+	cmd.Stdin = strings.NewReader(parsed.Code)
+	var out bytes.Buffer
+	cmd.Stdout = &limitedBuffer{buf: &out, limit: toolResultLimit}
+	cmd.Stderr = cmd.Stdout
+	err := cmd.Run()
+	output := &ToolOutput{Content: out.String(), Truncated: out.Len() >= toolResultLimit}
+	if ctx.Err() != nil {
+		return output, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		output.Content = fmt.Sprintf("exit code %d\n\n%s", exitErr.ExitCode(), output.Content)
+		return output, nil
+	}
+	if err != nil {
+		return output, fmt.Errorf("failed to run code: %w", err)
+	}
+	return output, nil
+}
+
+// limitedBuffer keeps the first limit bytes written to it and drops the
+// rest, without failing the writer
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// UsagePolicy sets when the runner's token count and the backend's disagree
+// enough to be flagged. A figure is flagged only when its difference exceeds
+// both Tolerance, relative to the larger count, and Slack tokens.
+type UsagePolicy struct {
+	Tolerance float64
+	Slack     int
+}
+
+// UsageReconciler counts the tokens of each generation with the model's own
+// tokenizer and compares the count to what the backend reported
+type UsageReconciler struct {
+	tokenizers *TokenizerStore
+	policy     UsagePolicy
+}
+
+func NewUsageReconciler(tokenizers *TokenizerStore, policy UsagePolicy) *UsageReconciler {
+	return &UsageReconciler{tokenizers: tokenizers, policy: policy}
+}
+
+// Reconcile returns both accounts of a generation's token usage. A zero
+// backend figure means the backend did not report it, as Ollama does for a
+// prompt served entirely from its cache, and is not compared.
+func (r *UsageReconciler) Reconcile(ctx context.Context, model, prompt, response string, backendPrompt, backendResponse int) *models.TokenUsage {
+	usage := &models.TokenUsage{
+		BackendReported:       backendPrompt > 0 || backendResponse > 0,
+		BackendPromptTokens:   backendPrompt,
+		BackendResponseTokens: backendResponse,
+	}
+
+	tok, err := r.tokenizers.Get(ctx, model)
+	if err != nil {
+		log := gologger.WithComponent("llm")
+		log.Warn().Err(err).Str("model", model).Msg("Tokenizer unavailable - reporting backend token usage only")
+		return usage
+	}
+
+	usage.Tokenizer = tok.Version()
+	usage.CountedPromptTokens = tok.CountPrompt(prompt)
+	usage.CountedResponseTokens = tok.Count(response)
+	r.policy.Apply(usage)
+	return usage
+}
+
+// Apply sets the discrepancy of usage and flags it when it exceeds p
+func (p UsagePolicy) Apply(usage *models.TokenUsage) {
+	usage.Discrepancy = 0
+	usage.Flagged = false
+	if usage.Tokenizer == "" {
+		return
+	}
+
+	compare := func(backend, counted int) {
+		if backend <= 0 {
+			return
+		}
+		diff := backend - counted
+		if diff < 0 {
+			diff = -diff
+		}
+		larger := max(backend, counted)
+		relative := float64(diff) / float64(larger)
+		if relative > usage.Discrepancy {
+			usage.Discrepancy = relative
+		}
+		if diff > p.Slack && relative > p.Tolerance {
+			usage.Flagged = true
+		}
+	}
+	compare(usage.BackendPromptTokens, usage.CountedPromptTokens)
+	compare(usage.BackendResponseTokens, usage.CountedResponseTokens)
+}
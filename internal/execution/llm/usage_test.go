@@ -0,0 +1,136 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// fakeShowServer serves /api/show with a small SentencePiece vocabulary in
+// which "hi hi" is two tokens
+type fakeShowServer struct {
+	*httptest.Server
+	modifiedAt   atomic.Value
+	verboseCalls atomic.Int32
+	failVerbose  atomic.Bool
+}
+
+func newFakeShowServer(t *testing.T) *fakeShowServer {
+	s := &fakeShowServer{}
+	s.modifiedAt.Store("2025-01-01T00:00:00Z")
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model   string `json:"model"`
+			Verbose bool   `json:"verbose"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Model != "tiny:latest" {
+			http.NotFound(w, r)
+			return
+		}
+		resp := map[string]interface{}{"modified_at": s.modifiedAt.Load()}
+		if req.Verbose {
+			s.verboseCalls.Add(1)
+			if s.failVerbose.Load() {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			resp["model_info"] = map[string]interface{}{
+				"tokenizer.ggml.model":         "llama",
+				"tokenizer.ggml.tokens":        []string{"<unk>", "<s>", "</s>", "▁", "h", "i", "▁h", "▁hi"},
+				"tokenizer.ggml.scores":        []float32{0, 0, 0, -10, -10, -10, -2, -1},
+				"tokenizer.ggml.token_type":    []int{2, 3, 3, 1, 1, 1, 1, 1},
+				"tokenizer.ggml.add_bos_token": true,
+			}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestReconcileCountsWithModelTokenizer(t *testing.T) {
+	server := newFakeShowServer(t)
+	r := NewUsageReconciler(NewTokenizerStore(server.URL, t.TempDir()), UsagePolicy{Tolerance: 0.05, Slack: 0})
+
+	usage := r.Reconcile(context.Background(), "tiny", "hi hi", "hi", 3, 1)
+	if usage.Tokenizer == "" || usage.CountedPromptTokens != 3 || usage.CountedResponseTokens != 1 {
+		t.Fatalf("usage = %+v, want 3 prompt tokens with BOS and 1 response token", usage)
+	}
+	if usage.Flagged || usage.Discrepancy != 0 {
+		t.Fatalf("matching counts flagged: %+v", usage)
+	}
+
+	usage = r.Reconcile(context.Background(), "tiny", "hi hi", "hi", 3, 2)
+	if !usage.Flagged || usage.Discrepancy != 0.5 {
+		t.Fatalf("usage = %+v, want the response figure flagged", usage)
+	}
+}
+
+func TestReconcileWithoutTokenizerKeepsBackendFigures(t *testing.T) {
+	server := newFakeShowServer(t)
+	r := NewUsageReconciler(NewTokenizerStore(server.URL, ""), UsagePolicy{})
+
+	usage := r.Reconcile(context.Background(), "missing", "hello", "world", 7, 9)
+	if usage.Tokenizer != "" || usage.Flagged || !usage.BackendReported {
+		t.Fatalf("usage = %+v", usage)
+	}
+	if usage.BackendPromptTokens != 7 || usage.BackendResponseTokens != 9 {
+		t.Fatalf("backend figures lost: %+v", usage)
+	}
+}
+
+func TestUsagePolicyNeedsToleranceAndSlackExceeded(t *testing.T) {
+	policy := UsagePolicy{Tolerance: 0.05, Slack: 32}
+	tests := []struct {
+		name    string
+		usage   models.TokenUsage
+		flagged bool
+	}{
+		{"within slack", models.TokenUsage{BackendPromptTokens: 40, CountedPromptTokens: 10}, false},
+		{"within tolerance", models.TokenUsage{BackendResponseTokens: 10000, CountedResponseTokens: 9600}, false},
+		{"beyond both", models.TokenUsage{BackendResponseTokens: 10000, CountedResponseTokens: 9000}, true},
+		{"prompt not reported", models.TokenUsage{BackendPromptTokens: 0, CountedPromptTokens: 500}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage := tt.usage
+			usage.Tokenizer = "llama:test"
+			policy.Apply(&usage)
+			if usage.Flagged != tt.flagged {
+				t.Fatalf("Flagged = %v, want %v (discrepancy %.3f)", usage.Flagged, tt.flagged, usage.Discrepancy)
+			}
+		})
+	}
+}
+
+func TestTokenizerStoreCachesOnDiskUntilModelChanges(t *testing.T) {
+	server := newFakeShowServer(t)
+	dir := t.TempDir()
+
+	first, err := NewTokenizerStore(server.URL, dir).Get(context.Background(), "tiny")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new process reads the cached vocabulary instead of fetching it again
+	server.failVerbose.Store(true)
+	second, err := NewTokenizerStore(server.URL, dir).Get(context.Background(), "tiny")
+	if err != nil {
+		t.Fatalf("Get() from disk cache: %v", err)
+	}
+	if second.Version() != first.Version() || server.verboseCalls.Load() != 1 {
+		t.Fatalf("vocabulary fetched %d times", server.verboseCalls.Load())
+	}
+
+	// Pulling the model again invalidates the cache
+	server.modifiedAt.Store("2025-02-01T00:00:00Z")
+	if _, err := NewTokenizerStore(server.URL, dir).Get(context.Background(), "tiny"); err == nil {
+		t.Fatal("stale tokenizer served after the model changed")
+	}
+}
@@ -0,0 +1,370 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+)
+
+// WarmModels keeps the models a runner serves loaded in the backend across
+// runner restarts. It records the models the runner loaded, so that a
+// runner starting against a backend that outlived the last one reconciles
+// with what the backend holds instead of assuming it cold: models it loaded
+// that are still loaded have their keep-alive lease renewed, those the
+// backend lost, such as by restarting, are loaded again while still served,
+// and models other clients loaded are left alone.
+type WarmModels struct {
+	baseURL   string
+	client    *http.Client
+	path      string
+	keepAlive time.Duration
+	clock     clock.Clock
+
+	mu     sync.Mutex
+	state  warmState
+	served map[string]bool
+}
+
+// warmState is the record kept at WarmModels' path
+type warmState struct {
+	// Backend is the URL of the backend the models were loaded into
+	Backend string               `json:"backend"`
+	Models  map[string]WarmLease `json:"models"`
+}
+
+// WarmLease is a model the runner loaded and keeps loaded
+type WarmLease struct {
+	LoadedAt  time.Time `json:"loaded_at"`
+	KeepAlive string    `json:"keep_alive"`
+}
+
+// RunningModel is a model the backend holds in memory
+type RunningModel struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SizeVRAM  int64     `json:"size_vram"`
+	Digest    string    `json:"digest"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// WarmReport is what a reconciliation did with each model
+type WarmReport struct {
+	// Renewed are models the runner loaded that were still loaded, whose
+	// leases were renewed
+	Renewed []string
+	// Reloaded are models the runner serves that the backend lost, loaded
+	// again
+	Reloaded []string
+	// Dropped are models the runner loaded but no longer serves, or could
+	// not load again, which it unloaded when still loaded and forgot
+	Dropped []string
+	// Foreign are models other clients loaded, left alone
+	Foreign []string
+}
+
+// NewWarmModels returns the warm models of the backend at baseURL, recorded
+// in the file at path and kept loaded for keepAlive after their last use; a
+// negative keepAlive keeps them loaded until unloaded. A record of another
+// backend is discarded, since the models it names were never loaded here.
+func NewWarmModels(baseURL, path string, keepAlive time.Duration) (*WarmModels, error) {
+	if baseURL == "" {
+		baseURL = "http://localhost:11434"
+	}
+	w := &WarmModels{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		client:    &http.Client{Timeout: 10 * time.Minute},
+		path:      path,
+		keepAlive: keepAlive,
+		clock:     clock.Real(),
+		served:    make(map[string]bool),
+	}
+	w.state = warmState{Backend: w.baseURL, Models: make(map[string]WarmLease)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return w, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read warm models: %w", err)
+	}
+	var saved warmState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("invalid warm models file: %w", err)
+	}
+	if saved.Backend == w.baseURL && saved.Models != nil {
+		w.state.Models = saved.Models
+	}
+	return w, nil
+}
+
+// SetClock replaces the clock loads are recorded with
+func (w *WarmModels) SetClock(c clock.Clock) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.clock = c
+}
+
+// SetServed sets the models the runner serves, which are kept warm
+func (w *WarmModels) SetServed(names []string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.served = make(map[string]bool, len(names))
+	for _, name := range names {
+		w.served[CanonicalModelName(name)] = true
+	}
+}
+
+// KeepAlive returns the keep-alive lease generations with model ask for,
+// empty for models that are not kept warm
+func (w *WarmModels) KeepAlive(model string) string {
+	if w == nil {
+		return ""
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.served[CanonicalModelName(model)] {
+		return ""
+	}
+	return w.keepAliveValue()
+}
+
+// keepAliveValue is the lease in the form Ollama reads
+func (w *WarmModels) keepAliveValue() string {
+	if w.keepAlive < 0 {
+		return "-1s"
+	}
+	return w.keepAlive.String()
+}
+
+// Loaded records that a generation loaded model, when it is kept warm
+func (w *WarmModels) Loaded(model string) {
+	if w == nil {
+		return
+	}
+	name := CanonicalModelName(model)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.served[name] {
+		return
+	}
+	if _, ok := w.state.Models[name]; ok {
+		return
+	}
+	w.state.Models[name] = WarmLease{LoadedAt: w.clock.Now(), KeepAlive: w.keepAliveValue()}
+	if err := w.save(); err != nil {
+		log := gologger.WithComponent("ollama_executor")
+		log.Warn().Err(err).Str("model", name).Msg("Failed to record warm model")
+	}
+}
+
+// Tracked returns the models the runner loaded, by name
+func (w *WarmModels) Tracked() map[string]WarmLease {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	tracked := make(map[string]WarmLease, len(w.state.Models))
+	for name, lease := range w.state.Models {
+		tracked[name] = lease
+	}
+	return tracked
+}
+
+// Reconcile brings the backend in line with the record: it renews the
+// leases of recorded models still loaded, loads again the served ones the
+// backend lost, unloads and forgets those no longer served, and leaves the
+// models of other clients alone. The record is left as it was when the
+// backend cannot be reached.
+func (w *WarmModels) Reconcile(ctx context.Context) (*WarmReport, error) {
+	log := gologger.WithComponent("ollama_manager")
+
+	running, err := w.Running(ctx)
+	if err != nil {
+		return nil, err
+	}
+	loaded := make(map[string]bool, len(running))
+	for _, model := range running {
+		loaded[CanonicalModelName(model.Name)] = true
+	}
+
+	report := &WarmReport{}
+	tracked := w.Tracked()
+	for _, model := range running {
+		if name := CanonicalModelName(model.Name); !hasLease(tracked, name) {
+			report.Foreign = append(report.Foreign, name)
+		}
+	}
+
+	names := make([]string, 0, len(tracked))
+	for name := range tracked {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		w.mu.Lock()
+		served := w.served[name]
+		w.mu.Unlock()
+
+		switch {
+		case !served:
+			if loaded[name] {
+				if err := w.setKeepAlive(ctx, name, "0"); err != nil {
+					log.Warn().Err(err).Str("model", name).Msg("Failed to unload model no longer served")
+				}
+			}
+			w.forget(name)
+			report.Dropped = append(report.Dropped, name)
+		case loaded[name]:
+			if err := w.setKeepAlive(ctx, name, w.keepAliveValue()); err != nil {
+				log.Warn().Err(err).Str("model", name).Msg("Failed to renew warm model lease")
+				continue
+			}
+			report.Renewed = append(report.Renewed, name)
+		default:
+			if err := w.setKeepAlive(ctx, name, w.keepAliveValue()); err != nil {
+				log.Warn().Err(err).Str("model", name).Msg("Failed to load warm model the backend lost")
+				w.forget(name)
+				report.Dropped = append(report.Dropped, name)
+				continue
+			}
+			w.mu.Lock()
+			w.state.Models[name] = WarmLease{LoadedAt: w.clock.Now(), KeepAlive: w.keepAliveValue()}
+			w.mu.Unlock()
+			report.Reloaded = append(report.Reloaded, name)
+		}
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.save(); err != nil {
+		return report, err
+	}
+	return report, nil
+}
+
+func hasLease(tracked map[string]WarmLease, name string) bool {
+	_, ok := tracked[name]
+	return ok
+}
+
+// Running returns the models the backend holds in memory
+func (w *WarmModels) Running(ctx context.Context) ([]RunningModel, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.baseURL+"/api/ps", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list running models: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, backendError("ps", resp)
+	}
+	var list struct {
+		Models []RunningModel `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return list.Models, nil
+}
+
+// Unload unloads model from the backend and forgets it
+func (w *WarmModels) Unload(ctx context.Context, model string) error {
+	name := CanonicalModelName(model)
+	if err := w.setKeepAlive(ctx, name, "0"); err != nil {
+		return err
+	}
+	w.forget(name)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.save()
+}
+
+// UnloadAll unloads every model the backend holds, whoever loaded it, so
+// the backend is cold, and forgets them all. It returns the models it
+// unloaded.
+func (w *WarmModels) UnloadAll(ctx context.Context) ([]string, error) {
+	running, err := w.Running(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var unloaded []string
+	var errs []error
+	for _, model := range running {
+		name := CanonicalModelName(model.Name)
+		if err := w.setKeepAlive(ctx, name, "0"); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		unloaded = append(unloaded, name)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.state.Models = make(map[string]WarmLease)
+	if err := w.save(); err != nil {
+		errs = append(errs, err)
+	}
+	return unloaded, errors.Join(errs...)
+}
+
+func (w *WarmModels) forget(name string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.state.Models, name)
+}
+
+// setKeepAlive sets the lease of model, loading it when the backend does
+// not hold it; a lease of "0" unloads it
+func (w *WarmModels) setKeepAlive(ctx context.Context, model, keepAlive string) error {
+	body, err := json.Marshal(map[string]interface{}{"model": model, "keep_alive": keepAlive, "stream": false})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.baseURL+"/api/generate", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to set keep-alive: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return backendError("keep-alive", resp)
+	}
+	return nil
+}
+
+// save writes the record; the caller holds mu
+func (w *WarmModels) save() error {
+	if w.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(w.state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal warm models: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(w.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create warm models directory: %w", err)
+	}
+	if err := atrest.WriteFileAtomic(w.path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write warm models: %w", err)
+	}
+	return nil
+}
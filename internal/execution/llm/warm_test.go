@@ -0,0 +1,291 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRunningBackend mocks the Ollama API models are loaded, leased and
+// unloaded through: installed models can be loaded, and loaded ones are
+// listed by /api/ps until unloaded
+type fakeRunningBackend struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	installed map[string]bool
+	running   map[string]string
+	// requests lists the keep-alive requests per model
+	requests map[string][]string
+	loads    int
+}
+
+func newFakeRunningBackend(t *testing.T, installed ...string) *fakeRunningBackend {
+	b := &fakeRunningBackend{installed: make(map[string]bool), running: make(map[string]string), requests: make(map[string][]string)}
+	for _, name := range installed {
+		b.installed[name] = true
+	}
+	b.Server = httptest.NewServer(http.HandlerFunc(b.serve))
+	t.Cleanup(b.Close)
+	return b
+}
+
+func (b *fakeRunningBackend) serve(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch r.URL.Path {
+	case "/api/ps":
+		var list struct {
+			Models []RunningModel `json:"models"`
+		}
+		for name := range b.running {
+			list.Models = append(list.Models, RunningModel{Name: name, Size: 1 << 30})
+		}
+		json.NewEncoder(w).Encode(list)
+	case "/api/generate":
+		var req struct {
+			Model     string `json:"model"`
+			KeepAlive string `json:"keep_alive"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		b.requests[req.Model] = append(b.requests[req.Model], req.KeepAlive)
+		if !b.installed[req.Model] {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "model not found"})
+			return
+		}
+		if req.KeepAlive == "0" {
+			delete(b.running, req.Model)
+		} else {
+			if _, ok := b.running[req.Model]; !ok {
+				b.loads++
+			}
+			b.running[req.Model] = req.KeepAlive
+		}
+		json.NewEncoder(w).Encode(GenerateResponse{Done: true})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// load marks name loaded by a client other than WarmModels
+func (b *fakeRunningBackend) load(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running[name] = "5m"
+}
+
+// restart drops every loaded model, as a backend restarting does
+func (b *fakeRunningBackend) restart() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.running = make(map[string]string)
+}
+
+func (b *fakeRunningBackend) snapshot() (running map[string]string, requests map[string][]string, loads int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	running = make(map[string]string, len(b.running))
+	for k, v := range b.running {
+		running[k] = v
+	}
+	requests = make(map[string][]string, len(b.requests))
+	for k, v := range b.requests {
+		requests[k] = append([]string(nil), v...)
+	}
+	return running, requests, b.loads
+}
+
+// warmRecord writes the record of a previous runner that loaded models
+func warmRecord(t *testing.T, backend *fakeRunningBackend, models ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "llm_backend.json")
+	warm, err := NewWarmModels(backend.URL, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warm.SetServed(models)
+	for _, model := range models {
+		warm.Loaded(model)
+	}
+	return path
+}
+
+func TestWarmModelsRenewsLeasesOfModelsStillLoaded(t *testing.T) {
+	backend := newFakeRunningBackend(t, "llama2:latest")
+	path := warmRecord(t, backend, "llama2")
+	backend.load("llama2:latest")
+
+	warm, err := NewWarmModels(backend.URL, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warm.SetServed([]string{"llama2:latest"})
+	report, err := warm.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(report.Renewed, []string{"llama2:latest"}) || len(report.Reloaded) != 0 {
+		t.Fatalf("report = %+v, want llama2 renewed", report)
+	}
+	running, _, loads := backend.snapshot()
+	if running["llama2:latest"] != "1h0m0s" {
+		t.Errorf("lease = %q, want 1h0m0s", running["llama2:latest"])
+	}
+	if loads != 0 {
+		t.Errorf("backend loaded %d models, want the loaded one kept", loads)
+	}
+}
+
+func TestWarmModelsReloadsModelsAfterBackendRestart(t *testing.T) {
+	backend := newFakeRunningBackend(t, "llama2:latest", "mistral:7b")
+	path := warmRecord(t, backend, "llama2", "mistral:7b")
+	backend.restart()
+	// The model was deleted while the backend was down
+	backend.mu.Lock()
+	delete(backend.installed, "mistral:7b")
+	backend.mu.Unlock()
+
+	warm, err := NewWarmModels(backend.URL, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warm.SetServed([]string{"llama2:latest", "mistral:7b"})
+	report, err := warm.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(report.Reloaded, []string{"llama2:latest"}) {
+		t.Errorf("reloaded = %v, want llama2", report.Reloaded)
+	}
+	if !reflect.DeepEqual(report.Dropped, []string{"mistral:7b"}) {
+		t.Errorf("dropped = %v, want the model the backend cannot load", report.Dropped)
+	}
+	if running, _, _ := backend.snapshot(); running["llama2:latest"] != "1h0m0s" {
+		t.Errorf("running = %v, want llama2 loaded with its lease", running)
+	}
+	if _, ok := warm.Tracked()["mistral:7b"]; ok {
+		t.Error("dropped model still recorded")
+	}
+
+	// The record outlives the runner
+	reopened, err := NewWarmModels(backend.URL, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracked := reopened.Tracked(); len(tracked) != 1 || tracked["llama2:latest"].KeepAlive != "1h0m0s" {
+		t.Errorf("reopened record = %v, want llama2 alone", tracked)
+	}
+}
+
+func TestWarmModelsLeavesOtherClientsModelsAlone(t *testing.T) {
+	backend := newFakeRunningBackend(t, "llama2:latest", "phi:2.7b", "codellama:7b")
+	path := warmRecord(t, backend, "llama2", "codellama:7b")
+	backend.load("phi:2.7b")
+	_, before, _ := backend.snapshot()
+
+	warm, err := NewWarmModels(backend.URL, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The runner no longer serves codellama, which it loaded itself
+	warm.SetServed([]string{"llama2", "phi:2.7b"})
+	report, err := warm.Reconcile(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(report.Foreign, []string{"phi:2.7b"}) {
+		t.Errorf("foreign = %v, want phi", report.Foreign)
+	}
+	if !reflect.DeepEqual(report.Dropped, []string{"codellama:7b"}) {
+		t.Errorf("dropped = %v, want codellama", report.Dropped)
+	}
+	running, after, _ := backend.snapshot()
+	if running["phi:2.7b"] != "5m" || len(after["phi:2.7b"]) != len(before["phi:2.7b"]) {
+		t.Errorf("other client's model touched: lease %q, requests %v", running["phi:2.7b"], after["phi:2.7b"])
+	}
+	if _, ok := running["codellama:7b"]; ok {
+		t.Error("model no longer served left loaded")
+	}
+	if _, ok := warm.Tracked()["phi:2.7b"]; ok {
+		t.Error("other client's model recorded")
+	}
+}
+
+func TestWarmModelsRecordsOnlyServedModels(t *testing.T) {
+	warm, err := NewWarmModels("", filepath.Join(t.TempDir(), "llm_backend.json"), -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	warm.SetServed([]string{"llama2"})
+
+	if got := warm.KeepAlive("llama2:latest"); got != "-1s" {
+		t.Errorf("keep-alive = %q, want -1s for a lease that never expires", got)
+	}
+	if got := warm.KeepAlive("phi"); got != "" {
+		t.Errorf("keep-alive of unserved model = %q, want the backend default", got)
+	}
+	warm.Loaded("phi")
+	warm.Loaded("llama2")
+	if tracked := warm.Tracked(); len(tracked) != 1 {
+		t.Errorf("tracked = %v, want llama2 alone", tracked)
+	}
+
+	var none *WarmModels
+	if none.KeepAlive("llama2") != "" {
+		t.Error("nil warm models asked for a lease")
+	}
+	none.Loaded("llama2")
+}
+
+func TestWarmModelsDiscardsRecordOfAnotherBackend(t *testing.T) {
+	backend := newFakeRunningBackend(t, "llama2:latest")
+	path := warmRecord(t, backend, "llama2")
+
+	warm, err := NewWarmModels("http://other:11434", path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracked := warm.Tracked(); len(tracked) != 0 {
+		t.Errorf("tracked = %v, want nothing from another backend", tracked)
+	}
+}
+
+func TestWarmModelsUnloadAll(t *testing.T) {
+	backend := newFakeRunningBackend(t, "llama2:latest", "phi:2.7b")
+	path := warmRecord(t, backend, "llama2")
+	backend.load("llama2:latest")
+	backend.load("phi:2.7b")
+
+	warm, err := NewWarmModels(backend.URL, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unloaded, err := warm.UnloadAll(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unloaded) != 2 {
+		t.Errorf("unloaded = %v, want both models", unloaded)
+	}
+	if running, _, _ := backend.snapshot(); len(running) != 0 {
+		t.Errorf("running = %v, want a cold backend", running)
+	}
+	reopened, err := NewWarmModels(backend.URL, path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tracked := reopened.Tracked(); len(tracked) != 0 {
+		t.Errorf("tracked = %v, want the record cleared", tracked)
+	}
+}
@@ -0,0 +1,335 @@
+package onnx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+)
+
+const (
+	// defaultInlineLimit is how many bytes of output are returned in the
+	// result when the task sets no limit
+	defaultInlineLimit = 64 << 10
+	maxInlineLimit     = 16 << 20
+	// maxRank and maxTensorBytes bound each input tensor
+	maxRank        = 8
+	maxTensorBytes = 1 << 30
+	// maxInlineElements bounds the elements of a tensor given in the config
+	// itself; larger tensors are given as files
+	maxInlineElements = 1 << 20
+)
+
+// DType is the element type of a tensor
+type DType string
+
+const (
+	Float32 DType = "float32"
+	Float64 DType = "float64"
+	Int8    DType = "int8"
+	Uint8   DType = "uint8"
+	Int32   DType = "int32"
+	Int64   DType = "int64"
+	Bool    DType = "bool"
+)
+
+// dtypes maps the element types ONNX tasks support to their ONNX
+// TensorProto data type and size in bytes
+var dtypes = map[DType]struct {
+	elemType int32
+	size     int64
+}{
+	Float32: {1, 4},
+	Uint8:   {2, 1},
+	Int8:    {3, 1},
+	Int32:   {6, 4},
+	Int64:   {7, 8},
+	Bool:    {9, 1},
+	Float64: {11, 8},
+}
+
+// elemTypeNames names every ONNX TensorProto data type, for errors about
+// models using ones tasks cannot supply
+var elemTypeNames = map[int32]string{
+	1: "float32", 2: "uint8", 3: "int8", 4: "uint16", 5: "int16", 6: "int32",
+	7: "int64", 8: "string", 9: "bool", 10: "float16", 11: "float64",
+	12: "uint32", 13: "uint64", 14: "complex64", 15: "complex128", 16: "bfloat16",
+}
+
+func elemTypeName(elemType int32) string {
+	if name, ok := elemTypeNames[elemType]; ok {
+		return name
+	}
+	return fmt.Sprintf("element type %d", elemType)
+}
+
+func (d DType) supported() bool {
+	_, ok := dtypes[d]
+	return ok
+}
+
+// Size is the size of an element in bytes
+func (d DType) Size() int64 {
+	return dtypes[d].size
+}
+
+func supportedDTypes() string {
+	names := make([]string, 0, len(dtypes))
+	for dtype := range dtypes {
+		names = append(names, string(dtype))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Config is the config of an ONNX inference task
+type Config struct {
+	Model Model `json:"model"`
+	// Tensors are the model's inputs
+	Tensors []TensorInput `json:"tensors"`
+	// Outputs names the model outputs to return; all of them when empty
+	Outputs []string `json:"outputs,omitempty"`
+	// InlineLimit is how many bytes of output are returned in the result as
+	// JSON; outputs beyond it are written to artifacts. 64 KiB when 0.
+	InlineLimit int64 `json:"inline_limit,omitempty"`
+	// Resources set the threads and memory the model runs with
+	Resources models.ResourceConfig `json:"resources,omitempty"`
+}
+
+// Model locates the model file, which must match its hash
+type Model struct {
+	Source models.InputSource `json:"source"`
+	SHA256 string             `json:"sha256"`
+	Size   int64              `json:"size,omitempty"`
+}
+
+// TensorInput is an input tensor of the model, given in the config as a
+// flat JSON array of its elements in row-major order, or as a file holding
+// them as raw little-endian values
+type TensorInput struct {
+	Name   string              `json:"name"`
+	DType  DType               `json:"dtype"`
+	Shape  []int64             `json:"shape"`
+	Data   json.RawMessage     `json:"data,omitempty"`
+	Source *models.InputSource `json:"source,omitempty"`
+	SHA256 string              `json:"sha256,omitempty"`
+}
+
+// elements returns the number of elements of the tensor's shape
+func (t *TensorInput) elements() int64 {
+	n := int64(1)
+	for _, dim := range t.Shape {
+		n *= dim
+	}
+	return n
+}
+
+// modelInput returns the model as an input to fetch
+func (c *Config) modelInput() *models.TaskInput {
+	return &models.TaskInput{
+		Name:       "model",
+		Source:     c.Model.Source,
+		SHA256:     c.Model.SHA256,
+		Size:       c.Model.Size,
+		TargetPath: "model.onnx",
+	}
+}
+
+// fileInput returns the i-th tensor, given as a file, as an input to fetch.
+// Its size follows from its shape.
+func (c *Config) fileInput(i int) *models.TaskInput {
+	t := &c.Tensors[i]
+	return &models.TaskInput{
+		Name:       fmt.Sprintf("tensor-%d", i),
+		Source:     *t.Source,
+		SHA256:     t.SHA256,
+		Size:       t.elements() * t.DType.Size(),
+		TargetPath: fmt.Sprintf("tensor-%d.bin", i),
+	}
+}
+
+// Files returns the files the task fetches: its model and the tensors given
+// as files
+func (c *Config) Files() []models.TaskInput {
+	files := []models.TaskInput{*c.modelInput()}
+	for i := range c.Tensors {
+		if c.Tensors[i].Source != nil && c.Tensors[i].DType.supported() {
+			files = append(files, *c.fileInput(i))
+		}
+	}
+	return files
+}
+
+func (c *Config) Validate() error {
+	if c.Model.SHA256 == "" {
+		return errors.New("model sha256 is required for ONNX tasks")
+	}
+	if err := c.modelInput().Validate(); err != nil {
+		return err
+	}
+	if len(c.Tensors) == 0 {
+		return errors.New("at least one input tensor is required for ONNX tasks")
+	}
+	names := make(map[string]bool, len(c.Tensors))
+	for i := range c.Tensors {
+		t := &c.Tensors[i]
+		if t.Name == "" {
+			return fmt.Errorf("tensor %d: name is required", i)
+		}
+		if names[t.Name] {
+			return fmt.Errorf("tensor %q is given twice", t.Name)
+		}
+		names[t.Name] = true
+		if err := c.validateTensor(i); err != nil {
+			return fmt.Errorf("tensor %q: %w", t.Name, err)
+		}
+	}
+	outputs := make(map[string]bool, len(c.Outputs))
+	for _, name := range c.Outputs {
+		if name == "" {
+			return errors.New("output names must not be empty")
+		}
+		if outputs[name] {
+			return fmt.Errorf("output %q is named twice", name)
+		}
+		outputs[name] = true
+	}
+	if c.InlineLimit < 0 || c.InlineLimit > maxInlineLimit {
+		return fmt.Errorf("inline_limit must be between 0 and %d bytes", maxInlineLimit)
+	}
+	if _, err := gpu.ParseBytes(c.Resources.Memory); err != nil {
+		return fmt.Errorf("invalid resources.memory: %w", err)
+	}
+	if c.Resources.CPUShares < 0 {
+		return errors.New("resources.cpu_shares must not be negative")
+	}
+	return nil
+}
+
+func (c *Config) validateTensor(i int) error {
+	t := &c.Tensors[i]
+	if !t.DType.supported() {
+		return fmt.Errorf("unsupported dtype %q, want one of %s", t.DType, supportedDTypes())
+	}
+	if len(t.Shape) > maxRank {
+		return fmt.Errorf("shape %v has rank %d, at most %d is supported", t.Shape, len(t.Shape), maxRank)
+	}
+	elements := int64(1)
+	for d, dim := range t.Shape {
+		if dim < 1 {
+			return fmt.Errorf("dimension %d of shape %v must be positive", d, t.Shape)
+		}
+		if elements > maxTensorBytes/t.DType.Size()/dim {
+			return fmt.Errorf("shape %v exceeds %d bytes of %s", t.Shape, int64(maxTensorBytes), t.DType)
+		}
+		elements *= dim
+	}
+
+	switch {
+	case len(t.Data) > 0 && t.Source != nil:
+		return errors.New("exactly one of data and source is required, not both")
+	case len(t.Data) > 0:
+		if t.SHA256 != "" {
+			return errors.New("sha256 only applies to tensors given by source")
+		}
+		_, err := t.encode()
+		return err
+	case t.Source != nil:
+		return c.fileInput(i).Validate()
+	}
+	return errors.New("exactly one of data and source is required")
+}
+
+// encode returns the tensor's inline data as raw little-endian values,
+// checking each element fits the dtype
+func (t *TensorInput) encode() ([]byte, error) {
+	want := t.elements()
+	if want > maxInlineElements {
+		return nil, fmt.Errorf("shape %v has %d elements, at most %d may be given as data; give larger tensors by source", t.Shape, want, maxInlineElements)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(t.Data))
+	decoder.UseNumber()
+	var elements []interface{}
+	if err := decoder.Decode(&elements); err != nil {
+		return nil, fmt.Errorf("data must be a flat JSON array of elements: %w", err)
+	}
+	if int64(len(elements)) != want {
+		return nil, fmt.Errorf("data has %d elements, shape %v needs %d", len(elements), t.Shape, want)
+	}
+
+	data := make([]byte, 0, want*t.DType.Size())
+	for i, element := range elements {
+		var err error
+		if data, err = appendElement(data, t.DType, element); err != nil {
+			return nil, fmt.Errorf("element %d: %w", i, err)
+		}
+	}
+	return data, nil
+}
+
+func appendElement(data []byte, dtype DType, element interface{}) ([]byte, error) {
+	if dtype == Bool {
+		value, ok := element.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%v is not a bool", element)
+		}
+		if value {
+			return append(data, 1), nil
+		}
+		return append(data, 0), nil
+	}
+	number, ok := element.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("%v is not a number", element)
+	}
+
+	switch dtype {
+	case Float32, Float64:
+		bits := 64
+		if dtype == Float32 {
+			bits = 32
+		}
+		value, err := strconv.ParseFloat(number.String(), bits)
+		if err != nil {
+			return nil, fmt.Errorf("%s is out of range for %s", number, dtype)
+		}
+		if dtype == Float32 {
+			return binary.LittleEndian.AppendUint32(data, math.Float32bits(float32(value))), nil
+		}
+		return binary.LittleEndian.AppendUint64(data, math.Float64bits(value)), nil
+	case Uint8:
+		value, err := strconv.ParseUint(number.String(), 10, 8)
+		if err != nil {
+			return nil, integerError(number, dtype)
+		}
+		return append(data, byte(value)), nil
+	}
+
+	bits := map[DType]int{Int8: 8, Int32: 32, Int64: 64}[dtype]
+	value, err := strconv.ParseInt(number.String(), 10, bits)
+	if err != nil {
+		return nil, integerError(number, dtype)
+	}
+	switch dtype {
+	case Int8:
+		return append(data, byte(int8(value))), nil
+	case Int32:
+		return binary.LittleEndian.AppendUint32(data, uint32(int32(value))), nil
+	}
+	return binary.LittleEndian.AppendUint64(data, uint64(value)), nil
+}
+
+func integerError(number json.Number, dtype DType) error {
+	if strings.ContainsAny(number.String(), ".eE") {
+		return fmt.Errorf("%s is not an integer, as %s needs", number, dtype)
+	}
+	return fmt.Errorf("%s is out of range for %s", number, dtype)
+}
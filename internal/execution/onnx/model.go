@@ -0,0 +1,402 @@
+package onnx
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// ErrInvalidModel is returned for model files that are not ONNX models
+var ErrInvalidModel = errors.New("invalid ONNX model")
+
+// maxNameBytes bounds the names read from a model
+const maxNameBytes = 64 << 10
+
+// Field numbers of the parts of onnx.proto the signature is read from
+const (
+	modelGraph         protowire.Number = 7
+	graphInitializer   protowire.Number = 5
+	graphInput         protowire.Number = 11
+	graphOutput        protowire.Number = 12
+	tensorProtoName    protowire.Number = 8
+	valueInfoName      protowire.Number = 1
+	valueInfoType      protowire.Number = 2
+	typeTensor         protowire.Number = 1
+	tensorTypeElemType protowire.Number = 1
+	tensorTypeShape    protowire.Number = 2
+	shapeDim           protowire.Number = 1
+	dimensionValue     protowire.Number = 1
+	dimensionParam     protowire.Number = 2
+)
+
+// unknownDimensionSize is the size of dimensions a model declares neither a
+// size nor a name for
+const unknownDimensionSize = -1
+
+// Dim is a dimension of a model input or output: a size, or a name the
+// model leaves the size to the caller under
+type Dim struct {
+	Value int64
+	Param string
+}
+
+func (d Dim) String() string {
+	switch {
+	case d.Param != "":
+		return d.Param
+	case d.Value == unknownDimensionSize:
+		return "?"
+	}
+	return strconv.FormatInt(d.Value, 10)
+}
+
+// ValueInfo describes an input or output of a model's graph
+type ValueInfo struct {
+	Name string
+	// ElemType is the ONNX TensorProto data type, 0 for values that are not
+	// tensors
+	ElemType int32
+	// Shape is nil when the model does not declare one
+	Shape []Dim
+	// Optional is set for inputs with an initializer, which need not be given
+	Optional bool
+}
+
+func formatShape(shape []Dim) string {
+	dims := make([]string, len(shape))
+	for i, dim := range shape {
+		dims[i] = dim.String()
+	}
+	return "[" + strings.Join(dims, " ") + "]"
+}
+
+// Signature is the inputs and outputs of a model's graph
+type Signature struct {
+	Inputs  []ValueInfo
+	Outputs []ValueInfo
+}
+
+// ReadSignature reads the signature of the model at path without loading
+// it, skipping over its weights. Files that are not ONNX models, or are
+// truncated, return ErrInvalidModel.
+func ReadSignature(path string) (*Signature, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open model: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat model: %w", err)
+	}
+
+	d := &decoder{r: bufio.NewReaderSize(f, 64<<10)}
+	var sig *Signature
+	err = d.message(info.Size(), func(num protowire.Number, _ uint64, end int64) error {
+		if num != modelGraph || end < 0 {
+			return nil
+		}
+		var err error
+		sig, err = d.graph(end)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidModel, err)
+	}
+	if sig == nil {
+		return nil, fmt.Errorf("%w: no graph", ErrInvalidModel)
+	}
+	if len(sig.Outputs) == 0 {
+		return nil, fmt.Errorf("%w: graph has no outputs", ErrInvalidModel)
+	}
+	return sig, nil
+}
+
+// decoder walks protobuf messages read from r, reading only the fields
+// asked for and discarding the rest
+type decoder struct {
+	r   *bufio.Reader
+	pos int64
+}
+
+func (d *decoder) ReadByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err == nil {
+		d.pos++
+	}
+	return b, err
+}
+
+func (d *decoder) varint() (uint64, error) {
+	v, err := binary.ReadUvarint(d)
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+func (d *decoder) skip(n int64) error {
+	for n > 0 {
+		chunk := n
+		if chunk > 1<<30 {
+			chunk = 1 << 30
+		}
+		discarded, err := d.r.Discard(int(chunk))
+		d.pos += int64(discarded)
+		n -= int64(discarded)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// message reads the fields of a message ending at end, calling field for
+// each. Varint fields pass their value with an end of -1; length-delimited
+// fields pass where they end, and whatever field leaves unread of them is
+// skipped.
+func (d *decoder) message(end int64, field func(num protowire.Number, value uint64, end int64) error) error {
+	for d.pos < end {
+		tag, err := d.varint()
+		if err != nil {
+			return err
+		}
+		num, typ := protowire.DecodeTag(tag)
+		if !num.IsValid() {
+			return fmt.Errorf("invalid field number %d at offset %d", num, d.pos)
+		}
+		switch typ {
+		case protowire.VarintType:
+			value, err := d.varint()
+			if err != nil {
+				return err
+			}
+			if err := field(num, value, -1); err != nil {
+				return err
+			}
+		case protowire.Fixed32Type:
+			err = d.skip(4)
+		case protowire.Fixed64Type:
+			err = d.skip(8)
+		case protowire.BytesType:
+			var length uint64
+			if length, err = d.varint(); err != nil {
+				return err
+			}
+			if length > uint64(end-d.pos) {
+				return fmt.Errorf("field %d at offset %d runs past the end of its message", num, d.pos)
+			}
+			fieldEnd := d.pos + int64(length)
+			if err := field(num, 0, fieldEnd); err != nil {
+				return err
+			}
+			if d.pos > fieldEnd {
+				return fmt.Errorf("field %d at offset %d overruns its length", num, d.pos)
+			}
+			err = d.skip(fieldEnd - d.pos)
+		default:
+			return fmt.Errorf("unsupported wire type %d at offset %d", typ, d.pos)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if d.pos != end {
+		return fmt.Errorf("message overruns its length at offset %d", d.pos)
+	}
+	return nil
+}
+
+func (d *decoder) string(end int64) (string, error) {
+	length := end - d.pos
+	if length > maxNameBytes {
+		return "", fmt.Errorf("name at offset %d is %d bytes long", d.pos, length)
+	}
+	buf := make([]byte, length)
+	n, err := io.ReadFull(d.r, buf)
+	d.pos += int64(n)
+	return string(buf), err
+}
+
+func (d *decoder) graph(end int64) (*Signature, error) {
+	sig := &Signature{}
+	initializers := make(map[string]bool)
+	err := d.message(end, func(num protowire.Number, _ uint64, end int64) error {
+		if end < 0 {
+			return nil
+		}
+		switch num {
+		case graphInitializer:
+			return d.message(end, func(num protowire.Number, _ uint64, end int64) error {
+				if num != tensorProtoName || end < 0 {
+					return nil
+				}
+				name, err := d.string(end)
+				initializers[name] = true
+				return err
+			})
+		case graphInput, graphOutput:
+			info, err := d.valueInfo(end)
+			if err != nil {
+				return err
+			}
+			if num == graphInput {
+				sig.Inputs = append(sig.Inputs, *info)
+			} else {
+				sig.Outputs = append(sig.Outputs, *info)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Models before IR version 4 list their initializers among the inputs
+	for i := range sig.Inputs {
+		sig.Inputs[i].Optional = initializers[sig.Inputs[i].Name]
+	}
+	return sig, nil
+}
+
+func (d *decoder) valueInfo(end int64) (*ValueInfo, error) {
+	info := &ValueInfo{}
+	err := d.message(end, func(num protowire.Number, _ uint64, end int64) error {
+		if end < 0 {
+			return nil
+		}
+		switch num {
+		case valueInfoName:
+			name, err := d.string(end)
+			info.Name = name
+			return err
+		case valueInfoType:
+			return d.message(end, func(num protowire.Number, _ uint64, end int64) error {
+				if num != typeTensor || end < 0 {
+					return nil
+				}
+				return d.tensorType(end, info)
+			})
+		}
+		return nil
+	})
+	return info, err
+}
+
+func (d *decoder) tensorType(end int64, info *ValueInfo) error {
+	return d.message(end, func(num protowire.Number, value uint64, end int64) error {
+		switch {
+		case num == tensorTypeElemType && end < 0:
+			info.ElemType = int32(value)
+		case num == tensorTypeShape && end >= 0:
+			info.Shape = []Dim{}
+			return d.message(end, func(num protowire.Number, _ uint64, end int64) error {
+				if num != shapeDim || end < 0 {
+					return nil
+				}
+				dim := Dim{Value: unknownDimensionSize}
+				err := d.message(end, func(num protowire.Number, value uint64, end int64) error {
+					switch {
+					case num == dimensionValue && end < 0:
+						dim.Value = int64(value)
+					case num == dimensionParam && end >= 0:
+						param, err := d.string(end)
+						dim.Param = param
+						return err
+					}
+					return nil
+				})
+				info.Shape = append(info.Shape, dim)
+				return err
+			})
+		}
+		return nil
+	})
+}
+
+// Check matches the tensors and outputs a task gives against the signature,
+// so mismatches are reported before the model is loaded. It returns the
+// outputs to fetch: those named, or every output of the model.
+func (s *Signature) Check(tensors []TensorInput, outputs []string) ([]string, error) {
+	given := make(map[string]*TensorInput, len(tensors))
+	for i := range tensors {
+		given[tensors[i].Name] = &tensors[i]
+	}
+	inputs := make(map[string]bool, len(s.Inputs))
+	for _, input := range s.Inputs {
+		inputs[input.Name] = true
+		t, ok := given[input.Name]
+		if !ok {
+			if !input.Optional {
+				return nil, fmt.Errorf("model input %q is required but no tensor gives it", input.Name)
+			}
+			continue
+		}
+		if err := input.check(t); err != nil {
+			return nil, fmt.Errorf("tensor %q: %w", t.Name, err)
+		}
+	}
+	for _, t := range tensors {
+		if !inputs[t.Name] {
+			return nil, fmt.Errorf("tensor %q is not an input of the model, which takes %s", t.Name, names(s.Inputs))
+		}
+	}
+
+	if len(outputs) == 0 {
+		for _, output := range s.Outputs {
+			outputs = append(outputs, output.Name)
+		}
+		return outputs, nil
+	}
+	declared := make(map[string]bool, len(s.Outputs))
+	for _, output := range s.Outputs {
+		declared[output.Name] = true
+	}
+	for _, name := range outputs {
+		if !declared[name] {
+			return nil, fmt.Errorf("output %q is not an output of the model, which gives %s", name, names(s.Outputs))
+		}
+	}
+	return outputs, nil
+}
+
+func (v *ValueInfo) check(t *TensorInput) error {
+	if v.ElemType == 0 {
+		return errors.New("the model input is not a tensor")
+	}
+	if want := dtypes[t.DType].elemType; want != v.ElemType {
+		return fmt.Errorf("dtype %s does not match the model's %s", t.DType, elemTypeName(v.ElemType))
+	}
+	if v.Shape == nil {
+		return nil
+	}
+	if len(t.Shape) != len(v.Shape) {
+		return fmt.Errorf("shape %v has rank %d, the model's %s has rank %d", t.Shape, len(t.Shape), formatShape(v.Shape), len(v.Shape))
+	}
+	for i, dim := range v.Shape {
+		if dim.Param == "" && dim.Value != unknownDimensionSize && dim.Value != t.Shape[i] {
+			return fmt.Errorf("dimension %d of shape %v is %d, the model's %s needs %d", i, t.Shape, t.Shape[i], formatShape(v.Shape), dim.Value)
+		}
+	}
+	return nil
+}
+
+func names(values []ValueInfo) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value.Name)
+	}
+	if len(quoted) == 0 {
+		return "none"
+	}
+	return strings.Join(quoted, ", ")
+}
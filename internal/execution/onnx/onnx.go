@@ -0,0 +1,277 @@
+// Package onnx runs inference on ONNX models with input tensors a task
+// supplies, returning small outputs in the result and writing larger ones
+// to artifacts
+package onnx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+)
+
+// cpuSharesPerThread is how many CPU shares each thread the model runs on
+// takes, as a Docker CPU share of 1024 is one core
+const cpuSharesPerThread = 1024
+
+// Tensor is a tensor with its elements as raw little-endian values in
+// row-major order
+type Tensor struct {
+	Name  string
+	DType DType
+	Shape []int64
+	Data  []byte
+}
+
+// SessionOptions are what a model is loaded with, derived from the task's
+// resources
+type SessionOptions struct {
+	// Threads is the number of threads each operator runs on
+	Threads int
+	// MemoryLimit caps the memory the session holds in bytes, 0 for no cap
+	MemoryLimit uint64
+}
+
+// Runtime loads and runs ONNX models
+type Runtime interface {
+	// Run loads the model at modelPath and runs it on inputs, returning the
+	// outputs named
+	Run(ctx context.Context, modelPath string, options SessionOptions, inputs []*Tensor, outputs []string) ([]*Tensor, error)
+}
+
+// Fetcher downloads the files a task names, verified against their hashes
+type Fetcher interface {
+	Fetch(ctx context.Context, spec *models.TaskInput, scratch string) (string, error)
+}
+
+// sessionOptions derives the session options from the task's resources: a
+// thread for each core's worth of CPU shares, one by default, and the
+// memory it declares
+func (c *Config) sessionOptions() (SessionOptions, error) {
+	limit, err := gpu.ParseBytes(c.Resources.Memory)
+	if err != nil {
+		return SessionOptions{}, fmt.Errorf("invalid resources.memory: %w", err)
+	}
+	threads := int((c.Resources.CPUShares + cpuSharesPerThread - 1) / cpuSharesPerThread)
+	if threads < 1 {
+		threads = 1
+	}
+	if threads > runtime.NumCPU() {
+		threads = runtime.NumCPU()
+	}
+	return SessionOptions{Threads: threads, MemoryLimit: limit}, nil
+}
+
+// Run validates cfg, fetches the model and the tensors given as files into
+// scratch and checks them against the model's signature before running it.
+// Outputs beyond the config's inline limit are written to outputDir.
+func Run(ctx context.Context, rt Runtime, fetcher Fetcher, cfg *Config, scratch, outputDir string) (*models.ONNXSummary, error) {
+	log := gologger.WithComponent("onnx")
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	options, err := cfg.sessionOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	modelPath, err := fetcher.Fetch(ctx, cfg.modelInput(), scratch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch model: %w", err)
+	}
+	sig, err := ReadSignature(modelPath)
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := sig.Check(cfg.Tensors, cfg.Outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	inputs, err := loadTensors(ctx, fetcher, cfg, scratch)
+	if err != nil {
+		return nil, err
+	}
+	if options.MemoryLimit > 0 {
+		info, err := os.Stat(modelPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat model: %w", err)
+		}
+		needed := uint64(info.Size())
+		for _, t := range inputs {
+			needed += uint64(len(t.Data))
+		}
+		if needed > options.MemoryLimit {
+			return nil, fmt.Errorf("model and input tensors take %d bytes, more than the %d of resources.memory", needed, options.MemoryLimit)
+		}
+	}
+
+	log.Info().
+		Str("model_sha256", cfg.Model.SHA256).
+		Int("inputs", len(inputs)).
+		Strs("outputs", outputs).
+		Int("threads", options.Threads).
+		Uint64("memory_limit", options.MemoryLimit).
+		Msg("Running ONNX model")
+
+	start := time.Now()
+	results, err := rt.Run(ctx, modelPath, options, inputs, outputs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run model: %w", err)
+	}
+	duration := time.Since(start)
+	if len(results) != len(outputs) {
+		return nil, fmt.Errorf("runtime returned %d outputs for %d requested", len(results), len(outputs))
+	}
+
+	summary := &models.ONNXSummary{
+		ModelSHA256: cfg.Model.SHA256,
+		Threads:     options.Threads,
+		DurationMs:  duration.Milliseconds(),
+	}
+	limit := cfg.InlineLimit
+	if limit == 0 {
+		limit = defaultInlineLimit
+	}
+	written, err := writeOutputs(results, limit, outputDir)
+	if err != nil {
+		return nil, err
+	}
+	summary.Outputs = written
+	return summary, nil
+}
+
+// loadTensors returns the task's input tensors, decoding those given as
+// data and reading those given as files
+func loadTensors(ctx context.Context, fetcher Fetcher, cfg *Config, scratch string) ([]*Tensor, error) {
+	tensors := make([]*Tensor, len(cfg.Tensors))
+	for i := range cfg.Tensors {
+		input := &cfg.Tensors[i]
+		tensor := &Tensor{Name: input.Name, DType: input.DType, Shape: input.Shape}
+		if input.Source == nil {
+			data, err := input.encode()
+			if err != nil {
+				return nil, fmt.Errorf("tensor %q: %w", input.Name, err)
+			}
+			tensor.Data = data
+		} else {
+			spec := cfg.fileInput(i)
+			path, err := fetcher.Fetch(ctx, spec, scratch)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch tensor %q: %w", input.Name, err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read tensor %q: %w", input.Name, err)
+			}
+			if int64(len(data)) != spec.Size {
+				return nil, fmt.Errorf("tensor %q: file holds %d bytes, shape %v of %s needs %d", input.Name, len(data), input.Shape, input.DType, spec.Size)
+			}
+			tensor.Data = data
+		}
+		tensors[i] = tensor
+	}
+	return tensors, nil
+}
+
+var unsafeArtifactChars = regexp.MustCompile(`[^A-Za-z0-9_.-]+`)
+
+// writeOutputs returns the outputs for the result. They are given as JSON
+// while they fit within limit bytes in all; the rest, and any holding NaN or
+// infinity which JSON cannot, are written to artifacts in outputDir.
+func writeOutputs(tensors []*Tensor, limit int64, outputDir string) ([]models.ONNXTensor, error) {
+	outputs := make([]models.ONNXTensor, len(tensors))
+	inlined := int64(0)
+	for i, t := range tensors {
+		if !t.DType.supported() {
+			return nil, fmt.Errorf("output %q has unsupported dtype %q", t.Name, t.DType)
+		}
+		elements := int64(1)
+		for _, dim := range t.Shape {
+			elements *= dim
+		}
+		if want := elements * t.DType.Size(); int64(len(t.Data)) != want {
+			return nil, fmt.Errorf("output %q holds %d bytes, shape %v of %s needs %d", t.Name, len(t.Data), t.Shape, t.DType, want)
+		}
+		output := models.ONNXTensor{Name: t.Name, DType: string(t.DType), Shape: t.Shape, Size: int64(len(t.Data))}
+
+		if inlined+output.Size <= limit {
+			if data, ok := inlineData(t); ok {
+				output.Data = data
+				inlined += output.Size
+				outputs[i] = output
+				continue
+			}
+		}
+
+		if err := os.MkdirAll(outputDir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+		name := unsafeArtifactChars.ReplaceAllString(t.Name, "_")
+		if len(name) > 64 {
+			name = name[:64]
+		}
+		path := filepath.Join(outputDir, fmt.Sprintf("output-%d-%s.bin", i, name))
+		if err := os.WriteFile(path, t.Data, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to write output %q: %w", t.Name, err)
+		}
+		sum := sha256.Sum256(t.Data)
+		output.Artifact = path
+		output.SHA256 = hex.EncodeToString(sum[:])
+		outputs[i] = output
+	}
+	return outputs, nil
+}
+
+// inlineData returns the tensor's elements as a JSON array, false when it
+// holds values JSON cannot represent
+func inlineData(t *Tensor) (json.RawMessage, bool) {
+	size := int(t.DType.Size())
+	values := make([]interface{}, len(t.Data)/size)
+	for i := range values {
+		element := t.Data[i*size : (i+1)*size]
+		switch t.DType {
+		case Float32:
+			value := float64(math.Float32frombits(binary.LittleEndian.Uint32(element)))
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				return nil, false
+			}
+			values[i] = float32(value)
+		case Float64:
+			value := math.Float64frombits(binary.LittleEndian.Uint64(element))
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				return nil, false
+			}
+			values[i] = value
+		case Int8:
+			values[i] = int8(element[0])
+		case Uint8:
+			values[i] = element[0]
+		case Int32:
+			values[i] = int32(binary.LittleEndian.Uint32(element))
+		case Int64:
+			values[i] = int64(binary.LittleEndian.Uint64(element))
+		case Bool:
+			values[i] = element[0] != 0
+		}
+	}
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
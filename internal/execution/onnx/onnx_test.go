@@ -0,0 +1,305 @@
+package onnx
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// fakeFetcher serves files by URL from local paths
+type fakeFetcher map[string]string
+
+func (f fakeFetcher) Fetch(ctx context.Context, spec *models.TaskInput, scratch string) (string, error) {
+	path, ok := f[spec.Source.URL]
+	if !ok {
+		return "", errors.New("not found")
+	}
+	return path, nil
+}
+
+// fakeRuntime answers with outputs computed by run, recording what it was
+// asked
+type fakeRuntime struct {
+	run     func(inputs []*Tensor) []*Tensor
+	calls   int
+	options SessionOptions
+}
+
+func (r *fakeRuntime) Run(ctx context.Context, modelPath string, options SessionOptions, inputs []*Tensor, outputs []string) ([]*Tensor, error) {
+	r.calls++
+	r.options = options
+	return r.run(inputs), nil
+}
+
+func float32s(values ...float32) []byte {
+	data := make([]byte, 0, 4*len(values))
+	for _, v := range values {
+		data = binary.LittleEndian.AppendUint32(data, math.Float32bits(v))
+	}
+	return data
+}
+
+func fixture(t *testing.T, name string) (string, string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	return path, hex.EncodeToString(sum[:])
+}
+
+// linear computes the linear.onnx fixture, y = x·[2 -1 0.5] + 1
+func linear(inputs []*Tensor) []*Tensor {
+	x := inputs[0]
+	rows := x.Shape[0]
+	var y []float32
+	for i := int64(0); i < rows; i++ {
+		row := x.Data[i*12 : (i+1)*12]
+		value := float32(1)
+		for j, w := range []float32{2, -1, 0.5} {
+			value += w * math.Float32frombits(binary.LittleEndian.Uint32(row[4*j:]))
+		}
+		y = append(y, value)
+	}
+	return []*Tensor{{Name: "y", DType: Float32, Shape: []int64{rows, 1}, Data: float32s(y...)}}
+}
+
+func linearConfig(sha string) *Config {
+	return &Config{
+		Model: Model{Source: models.InputSource{URL: "https://models.example.com/linear.onnx"}, SHA256: sha},
+		Tensors: []TensorInput{
+			{Name: "x", DType: Float32, Shape: []int64{2, 3}, Data: json.RawMessage(`[1, 2, 3, 0, 0, 0]`)},
+		},
+	}
+}
+
+func TestRunReturnsSmallOutputsInline(t *testing.T) {
+	path, sha := fixture(t, "linear.onnx")
+	rt := &fakeRuntime{run: linear}
+	cfg := linearConfig(sha)
+	cfg.Resources = models.ResourceConfig{CPUShares: 2048, Memory: "1g"}
+
+	summary, err := Run(context.Background(), rt, fakeFetcher{cfg.Model.Source.URL: path}, cfg, t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.Outputs) != 1 {
+		t.Fatalf("Outputs = %+v, want y alone", summary.Outputs)
+	}
+	y := summary.Outputs[0]
+	if string(y.Data) != "[2.5,1]" || y.Artifact != "" || y.Size != 8 {
+		t.Fatalf("output = %+v, want [2.5,1] inline", y)
+	}
+	if y.DType != "float32" || len(y.Shape) != 2 || y.Shape[0] != 2 || y.Shape[1] != 1 {
+		t.Fatalf("output is %s%v, want float32[2 1]", y.DType, y.Shape)
+	}
+	wantThreads := 2
+	if runtime.NumCPU() < 2 {
+		wantThreads = runtime.NumCPU()
+	}
+	if rt.options.Threads != wantThreads || rt.options.MemoryLimit != 1<<30 || summary.Threads != wantThreads {
+		t.Fatalf("session options = %+v, want %d threads within 1 GiB", rt.options, wantThreads)
+	}
+	if summary.ModelSHA256 != sha {
+		t.Fatalf("ModelSHA256 = %s, want %s", summary.ModelSHA256, sha)
+	}
+}
+
+func TestRunWritesLargeOutputsToArtifacts(t *testing.T) {
+	path, sha := fixture(t, "cnn.onnx")
+	image := filepath.Join(t.TempDir(), "image.bin")
+	pixels := make([]float32, 16)
+	for i := range pixels {
+		pixels[i] = float32(i % 4 / 2)
+	}
+	if err := os.WriteFile(image, float32s(pixels...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	features := float32s(3, 3, 3, 3, 0, 0, 0, 0)
+	rt := &fakeRuntime{run: func(inputs []*Tensor) []*Tensor {
+		if string(inputs[0].Data) != string(float32s(pixels...)) {
+			t.Errorf("image = %v, want the file's pixels", inputs[0].Data)
+		}
+		return []*Tensor{{Name: "features", DType: Float32, Shape: []int64{1, 8}, Data: features}}
+	}}
+	cfg := &Config{
+		Model: Model{Source: models.InputSource{URL: "https://models.example.com/cnn.onnx"}, SHA256: sha},
+		Tensors: []TensorInput{
+			{Name: "image", DType: Float32, Shape: []int64{1, 1, 4, 4}, Source: &models.InputSource{URL: "https://data.example.com/image.bin"}},
+		},
+		InlineLimit: 16,
+	}
+	fetcher := fakeFetcher{cfg.Model.Source.URL: path, "https://data.example.com/image.bin": image}
+
+	outputDir := t.TempDir()
+	summary, err := Run(context.Background(), rt, fetcher, cfg, t.TempDir(), outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := summary.Outputs[0]
+	if output.Data != nil || filepath.Dir(output.Artifact) != outputDir {
+		t.Fatalf("output = %+v, want an artifact in %s", output, outputDir)
+	}
+	data, err := os.ReadFile(output.Artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(features)
+	if string(data) != string(features) || output.SHA256 != hex.EncodeToString(sum[:]) || output.Size != 32 {
+		t.Fatalf("artifact holds %v (%+v), want the features", data, output)
+	}
+	if rt.options.Threads != 1 || rt.options.MemoryLimit != 0 {
+		t.Fatalf("session options = %+v, want one thread and no memory cap by default", rt.options)
+	}
+
+	// Outputs JSON cannot hold go to artifacts whatever their size
+	nan := float32(math.NaN())
+	rt.run = func([]*Tensor) []*Tensor {
+		return []*Tensor{{Name: "features", DType: Float32, Shape: []int64{1, 8}, Data: float32s(nan, 0, 0, 0, 0, 0, 0, 0)}}
+	}
+	cfg.InlineLimit = 0
+	summary, err = Run(context.Background(), rt, fetcher, cfg, t.TempDir(), outputDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output := summary.Outputs[0]; output.Data != nil || output.Artifact == "" {
+		t.Fatalf("output = %+v, want NaN written to an artifact", output)
+	}
+}
+
+func TestMismatchedTensorsFailBeforeModelLoad(t *testing.T) {
+	linearPath, linearSHA := fixture(t, "linear.onnx")
+	cnnPath, cnnSHA := fixture(t, "cnn.onnx")
+	image := func(dtype DType, shape ...int64) TensorInput {
+		elements := int64(1)
+		for _, dim := range shape {
+			elements *= dim
+		}
+		data, _ := json.Marshal(make([]int, elements))
+		return TensorInput{Name: "image", DType: dtype, Shape: shape, Data: data}
+	}
+	cnn := func(tensors ...TensorInput) *Config {
+		return &Config{Model: Model{Source: models.InputSource{URL: "https://models.example.com/cnn.onnx"}, SHA256: cnnSHA}, Tensors: tensors}
+	}
+
+	tests := []struct {
+		name string
+		cfg  *Config
+		want string
+	}{
+		{"dtype", cnn(image(Float64, 1, 1, 4, 4)), `tensor "image": dtype float64 does not match the model's float32`},
+		{"rank", cnn(image(Float32, 1, 4, 4)), `tensor "image": shape [1 4 4] has rank 3, the model's [1 1 4 4] has rank 4`},
+		{"dimension", cnn(image(Float32, 1, 1, 5, 4)), `tensor "image": dimension 2 of shape [1 1 5 4] is 5, the model's [1 1 4 4] needs 4`},
+		{"unknown tensor", cnn(image(Float32, 1, 1, 4, 4), TensorInput{Name: "mask", DType: Bool, Shape: []int64{1}, Data: json.RawMessage(`[true]`)}),
+			`tensor "mask" is not an input of the model, which takes "image"`},
+		{"missing input", cnn(TensorInput{Name: "mask", DType: Bool, Shape: []int64{1}, Data: json.RawMessage(`[true]`)}),
+			`model input "image" is required but no tensor gives it`},
+		{"unknown output", func() *Config {
+			cfg := linearConfig(linearSHA)
+			cfg.Outputs = []string{"logits"}
+			return cfg
+		}(), `output "logits" is not an output of the model, which gives "y"`},
+		{"element count", func() *Config {
+			cfg := linearConfig(linearSHA)
+			cfg.Tensors[0].Data = json.RawMessage(`[1, 2, 3, 4, 5]`)
+			return cfg
+		}(), `tensor "x": data has 5 elements, shape [2 3] needs 6`},
+		{"element range", cnn(TensorInput{Name: "image", DType: Int8, Shape: []int64{2}, Data: json.RawMessage(`[1, 300]`)}),
+			`tensor "image": element 1: 300 is out of range for int8`},
+		{"integer", cnn(TensorInput{Name: "image", DType: Int32, Shape: []int64{2}, Data: json.RawMessage(`[1, 2.5]`)}),
+			`tensor "image": element 1: 2.5 is not an integer, as int32 needs`},
+		{"unsupported dtype", cnn(image("float16", 1, 1, 4, 4)), `tensor "image": unsupported dtype "float16"`},
+		{"dimension size", cnn(image(Float32, 1, 1, 0, 4)), `tensor "image": dimension 2 of shape [1 1 0 4] must be positive`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := &fakeRuntime{run: linear}
+			fetcher := fakeFetcher{
+				"https://models.example.com/linear.onnx": linearPath,
+				"https://models.example.com/cnn.onnx":    cnnPath,
+			}
+			_, err := Run(context.Background(), rt, fetcher, tt.cfg, t.TempDir(), t.TempDir())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Run() error = %v, want %q", err, tt.want)
+			}
+			if rt.calls != 0 {
+				t.Fatal("model was run despite the mismatch")
+			}
+		})
+	}
+}
+
+func TestCorruptedModel(t *testing.T) {
+	model, err := os.ReadFile(filepath.Join("testdata", "cnn.onnx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"truncated": model[:len(model)-40],
+		"garbage":   []byte("this is not a model, but it might be mistaken for one"),
+		"empty":     nil,
+	} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "model.onnx")
+			if err := os.WriteFile(path, data, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			sum := sha256.Sum256(data)
+			cfg := linearConfig(hex.EncodeToString(sum[:]))
+			rt := &fakeRuntime{run: linear}
+			_, err := Run(context.Background(), rt, fakeFetcher{cfg.Model.Source.URL: path}, cfg, t.TempDir(), t.TempDir())
+			if !errors.Is(err, ErrInvalidModel) {
+				t.Fatalf("Run() error = %v, want ErrInvalidModel", err)
+			}
+			if rt.calls != 0 {
+				t.Fatal("corrupted model was run")
+			}
+		})
+	}
+}
+
+func TestReadSignature(t *testing.T) {
+	tests := []struct {
+		fixture string
+		inputs  string
+		outputs string
+	}{
+		{"linear.onnx", "x[batch 3]", "y[batch 1]"},
+		{"cnn.onnx", "image[1 1 4 4]", "features[1 8]"},
+	}
+	for _, tt := range tests {
+		sig, err := ReadSignature(filepath.Join("testdata", tt.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		describe := func(values []ValueInfo) string {
+			var parts []string
+			for _, v := range values {
+				if v.ElemType != dtypes[Float32].elemType {
+					t.Errorf("%s: %s has element type %d, want float32", tt.fixture, v.Name, v.ElemType)
+				}
+				parts = append(parts, v.Name+formatShape(v.Shape))
+			}
+			return strings.Join(parts, ", ")
+		}
+		if got := describe(sig.Inputs); got != tt.inputs {
+			t.Errorf("%s: inputs %s, want %s", tt.fixture, got, tt.inputs)
+		}
+		if got := describe(sig.Outputs); got != tt.outputs {
+			t.Errorf("%s: outputs %s, want %s", tt.fixture, got, tt.outputs)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+//go:build cgo
+
+package onnx
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+
+	ort "github.com/yalue/onnxruntime_go"
+)
+
+// ORTRuntime runs models with the ONNX Runtime shared library, loaded on
+// first use
+type ORTRuntime struct {
+	library string
+
+	once sync.Once
+	err  error
+}
+
+// NewORTRuntime returns a runtime using the ONNX Runtime library at
+// library, or the platform's default name for it when empty
+func NewORTRuntime(library string) *ORTRuntime {
+	return &ORTRuntime{library: library}
+}
+
+// Init loads the library, returning why models cannot be run when it fails
+func (r *ORTRuntime) Init() error {
+	r.once.Do(func() {
+		if r.library != "" {
+			ort.SetSharedLibraryPath(r.library)
+		}
+		if err := ort.InitializeEnvironment(); err != nil {
+			r.err = fmt.Errorf("failed to load ONNX Runtime: %w", err)
+		}
+	})
+	return r.err
+}
+
+func (r *ORTRuntime) Run(ctx context.Context, modelPath string, options SessionOptions, inputs []*Tensor, outputs []string) ([]*Tensor, error) {
+	if err := r.Init(); err != nil {
+		return nil, err
+	}
+
+	sessionOptions, err := ort.NewSessionOptions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session options: %w", err)
+	}
+	defer sessionOptions.Destroy()
+	if err := sessionOptions.SetIntraOpNumThreads(options.Threads); err != nil {
+		return nil, fmt.Errorf("failed to set threads: %w", err)
+	}
+	if err := sessionOptions.SetInterOpNumThreads(1); err != nil {
+		return nil, fmt.Errorf("failed to set threads: %w", err)
+	}
+	if options.MemoryLimit > 0 {
+		// The CPU arena keeps what it grows to for the whole session and
+		// plans ahead of the tensors actually live; without it, memory is
+		// allocated and returned per tensor and stays within what the
+		// model needs
+		if err := sessionOptions.SetCpuMemArena(false); err != nil {
+			return nil, fmt.Errorf("failed to disable the memory arena: %w", err)
+		}
+		if err := sessionOptions.SetMemPattern(false); err != nil {
+			return nil, fmt.Errorf("failed to disable memory patterns: %w", err)
+		}
+	}
+
+	inputNames := make([]string, len(inputs))
+	values := make([]ort.Value, len(inputs))
+	defer func() {
+		for _, value := range values {
+			if value != nil {
+				value.Destroy()
+			}
+		}
+	}()
+	for i, input := range inputs {
+		inputNames[i] = input.Name
+		value, err := ort.NewCustomDataTensor(ort.NewShape(input.Shape...), input.Data, ort.TensorElementDataType(dtypes[input.DType].elemType))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create tensor %q: %w", input.Name, err)
+		}
+		values[i] = value
+	}
+
+	session, err := ort.NewDynamicAdvancedSession(modelPath, inputNames, outputs, sessionOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load model: %w", err)
+	}
+	defer session.Destroy()
+
+	runOptions, err := ort.NewRunOptions()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create run options: %w", err)
+	}
+	defer runOptions.Destroy()
+	stop := context.AfterFunc(ctx, func() { runOptions.Terminate() })
+	defer stop()
+
+	results := make([]ort.Value, len(outputs))
+	defer func() {
+		for _, value := range results {
+			if value != nil {
+				value.Destroy()
+			}
+		}
+	}()
+	if err := session.RunWithOptions(values, results, runOptions); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+
+	tensors := make([]*Tensor, len(outputs))
+	for i, value := range results {
+		dtype, data, err := tensorData(value)
+		if err != nil {
+			return nil, fmt.Errorf("output %q: %w", outputs[i], err)
+		}
+		tensors[i] = &Tensor{Name: outputs[i], DType: dtype, Shape: value.GetShape(), Data: data}
+	}
+	return tensors, nil
+}
+
+// tensorData returns the elements of an output as raw little-endian values
+func tensorData(value ort.Value) (DType, []byte, error) {
+	switch t := value.(type) {
+	case *ort.Tensor[float32]:
+		data := make([]byte, 0, 4*len(t.GetData()))
+		for _, v := range t.GetData() {
+			data = binary.LittleEndian.AppendUint32(data, math.Float32bits(v))
+		}
+		return Float32, data, nil
+	case *ort.Tensor[float64]:
+		data := make([]byte, 0, 8*len(t.GetData()))
+		for _, v := range t.GetData() {
+			data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v))
+		}
+		return Float64, data, nil
+	case *ort.Tensor[int8]:
+		data := make([]byte, len(t.GetData()))
+		for i, v := range t.GetData() {
+			data[i] = byte(v)
+		}
+		return Int8, data, nil
+	case *ort.Tensor[uint8]:
+		return Uint8, append([]byte(nil), t.GetData()...), nil
+	case *ort.Tensor[int32]:
+		data := make([]byte, 0, 4*len(t.GetData()))
+		for _, v := range t.GetData() {
+			data = binary.LittleEndian.AppendUint32(data, uint32(v))
+		}
+		return Int32, data, nil
+	case *ort.Tensor[int64]:
+		data := make([]byte, 0, 8*len(t.GetData()))
+		for _, v := range t.GetData() {
+			data = binary.LittleEndian.AppendUint64(data, uint64(v))
+		}
+		return Int64, data, nil
+	case *ort.Tensor[bool]:
+		data := make([]byte, len(t.GetData()))
+		for i, v := range t.GetData() {
+			if v {
+				data[i] = 1
+			}
+		}
+		return Bool, data, nil
+	}
+	return "", nil, fmt.Errorf("unsupported output type %T", value)
+}
@@ -0,0 +1,25 @@
+//go:build !cgo
+
+package onnx
+
+import (
+	"context"
+	"errors"
+)
+
+var errNoCgo = errors.New("ONNX Runtime is not available in builds without cgo")
+
+// ORTRuntime stands in for the ONNX Runtime backend, which needs cgo
+type ORTRuntime struct{}
+
+func NewORTRuntime(library string) *ORTRuntime {
+	return &ORTRuntime{}
+}
+
+func (r *ORTRuntime) Init() error {
+	return errNoCgo
+}
+
+func (r *ORTRuntime) Run(ctx context.Context, modelPath string, options SessionOptions, inputs []*Tensor, outputs []string) ([]*Tensor, error) {
+	return nil, errNoCgo
+}
@@ -0,0 +1,60 @@
+//go:build cgo
+
+package onnx
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// TestORTRuntime runs the fixtures through ONNX Runtime itself, when
+// ONNXRUNTIME_LIB locates its shared library
+func TestORTRuntime(t *testing.T) {
+	library := os.Getenv("ONNXRUNTIME_LIB")
+	if library == "" {
+		t.Skip("ONNXRUNTIME_LIB is not set")
+	}
+	rt := NewORTRuntime(library)
+	if err := rt.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	linearPath, linearSHA := fixture(t, "linear.onnx")
+	summary, err := Run(context.Background(), rt, fakeFetcher{"https://models.example.com/linear.onnx": linearPath},
+		linearConfig(linearSHA), t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(summary.Outputs[0].Data); got != "[2.5,1]" {
+		t.Fatalf("linear model gave %s, want [2.5,1]", got)
+	}
+
+	cnnPath, cnnSHA := fixture(t, "cnn.onnx")
+	image := filepath.Join(t.TempDir(), "image.bin")
+	pixels := make([]float32, 16)
+	for i := range pixels {
+		pixels[i] = float32(i % 4 / 2)
+	}
+	if err := os.WriteFile(image, float32s(pixels...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &Config{
+		Model: Model{Source: models.InputSource{URL: "https://models.example.com/cnn.onnx"}, SHA256: cnnSHA},
+		Tensors: []TensorInput{
+			{Name: "image", DType: Float32, Shape: []int64{1, 1, 4, 4}, Source: &models.InputSource{URL: "https://data.example.com/image.bin"}},
+		},
+		Resources: models.ResourceConfig{Memory: "64m"},
+	}
+	fetcher := fakeFetcher{"https://models.example.com/cnn.onnx": cnnPath, "https://data.example.com/image.bin": image}
+	summary, err = Run(context.Background(), rt, fetcher, cfg, t.TempDir(), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(summary.Outputs[0].Data); got != "[3,3,3,3,0,0,0,0]" {
+		t.Fatalf("CNN gave %s, want [3,3,3,3,0,0,0,0]", got)
+	}
+}
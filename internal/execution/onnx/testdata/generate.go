@@ -0,0 +1,165 @@
+//go:build ignore
+
+// generate writes the fixture models of the onnx package's tests:
+//
+//	go run generate.go
+//
+// linear.onnx computes y = x·W + b for a batch of x of 3 features, and
+// cnn.onnx convolves a 1x1x4x4 image with two 3x3 filters, applies a ReLU
+// and flattens the result to 1x8 features.
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"math"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	elemFloat  = 1
+	attrInt    = 2
+	irVersion  = 8
+	opsetLevel = 13
+)
+
+type message []byte
+
+func (m message) bytes(num protowire.Number, value []byte) message {
+	m = protowire.AppendTag(m, num, protowire.BytesType)
+	return protowire.AppendBytes(m, value)
+}
+
+func (m message) str(num protowire.Number, value string) message {
+	return m.bytes(num, []byte(value))
+}
+
+func (m message) varint(num protowire.Number, value int64) message {
+	m = protowire.AppendTag(m, num, protowire.VarintType)
+	return protowire.AppendVarint(m, uint64(value))
+}
+
+// dim is a fixed size, or a named one when a string
+type dim interface{}
+
+func valueInfo(name string, dims ...dim) message {
+	var shape message
+	for _, d := range dims {
+		var dimension message
+		switch d := d.(type) {
+		case int:
+			dimension = dimension.varint(1, int64(d))
+		case string:
+			dimension = dimension.str(2, d)
+		}
+		shape = shape.bytes(1, dimension)
+	}
+	tensorType := message(nil).varint(1, elemFloat).bytes(2, shape)
+	typeProto := message(nil).bytes(1, tensorType)
+	return message(nil).str(1, name).bytes(2, typeProto)
+}
+
+func initializer(name string, dims []int64, values []float32) message {
+	var tensor message
+	for _, d := range dims {
+		tensor = tensor.varint(1, d)
+	}
+	tensor = tensor.varint(2, elemFloat).str(8, name)
+	raw := make([]byte, 0, 4*len(values))
+	for _, v := range values {
+		raw = binary.LittleEndian.AppendUint32(raw, math.Float32bits(v))
+	}
+	return tensor.bytes(9, raw)
+}
+
+type attribute struct {
+	name string
+	ints []int64
+	i    *int64
+}
+
+func node(op string, inputs, outputs []string, attributes ...attribute) message {
+	var n message
+	for _, input := range inputs {
+		n = n.str(1, input)
+	}
+	for _, output := range outputs {
+		n = n.str(2, output)
+	}
+	n = n.str(3, op).str(4, op)
+	for _, a := range attributes {
+		attr := message(nil).str(1, a.name)
+		if a.i != nil {
+			attr = attr.varint(3, *a.i).varint(20, attrInt)
+		} else {
+			for _, v := range a.ints {
+				attr = attr.varint(8, v)
+			}
+			attr = attr.varint(20, 7)
+		}
+		n = n.bytes(5, attr)
+	}
+	return n
+}
+
+func model(name string, nodes, initializers, inputs, outputs []message) []byte {
+	graph := message(nil)
+	for _, n := range nodes {
+		graph = graph.bytes(1, n)
+	}
+	graph = graph.str(2, name)
+	for _, i := range initializers {
+		graph = graph.bytes(5, i)
+	}
+	for _, i := range inputs {
+		graph = graph.bytes(11, i)
+	}
+	for _, o := range outputs {
+		graph = graph.bytes(12, o)
+	}
+	opset := message(nil).str(1, "").varint(2, opsetLevel)
+	return message(nil).varint(1, irVersion).str(2, "parity-runner").bytes(7, graph).bytes(8, opset)
+}
+
+func main() {
+	linear := model("linear",
+		[]message{
+			node("MatMul", []string{"x", "W"}, []string{"xW"}),
+			node("Add", []string{"xW", "b"}, []string{"y"}),
+		},
+		[]message{
+			initializer("W", []int64{3, 1}, []float32{2, -1, 0.5}),
+			initializer("b", []int64{1}, []float32{1}),
+		},
+		[]message{valueInfo("x", "batch", 3)},
+		[]message{valueInfo("y", "batch", 1)},
+	)
+
+	axis := int64(1)
+	cnn := model("cnn",
+		[]message{
+			node("Conv", []string{"image", "filters", "bias"}, []string{"conv"},
+				attribute{name: "kernel_shape", ints: []int64{3, 3}}),
+			node("Relu", []string{"conv"}, []string{"relu"}),
+			node("Flatten", []string{"relu"}, []string{"features"}, attribute{name: "axis", i: &axis}),
+		},
+		[]message{
+			// An edge detector and its negation
+			initializer("filters", []int64{2, 1, 3, 3}, []float32{
+				-1, 0, 1, -1, 0, 1, -1, 0, 1,
+				1, 0, -1, 1, 0, -1, 1, 0, -1,
+			}),
+			initializer("bias", []int64{2}, []float32{0, 0}),
+		},
+		[]message{valueInfo("image", 1, 1, 4, 4)},
+		[]message{valueInfo("features", 1, 8)},
+	)
+
+	for name, data := range map[string][]byte{"linear.onnx": linear, "cnn.onnx": cnn} {
+		if err := os.WriteFile(name, data, 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package outputs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// Snapshot is the state of the files under a directory before a task ran
+// in it, keyed by their slash separated path relative to the directory
+type Snapshot map[string]fileState
+
+// fileState tells a file a task changed apart from one it left alone. A
+// file replaced by another, even of the same size and time, is no longer
+// the same file.
+type fileState struct {
+	info    os.FileInfo
+	size    int64
+	modTime time.Time
+}
+
+// unchanged reports whether info is the file state was taken of, as it was
+func (state fileState) unchanged(info os.FileInfo) bool {
+	return os.SameFile(state.info, info) && info.Size() == state.size && info.ModTime().Equal(state.modTime)
+}
+
+// TakeSnapshot records the files under root, so that a task running in a
+// directory that already holds files is only checked for those it writes
+func TakeSnapshot(root string) (Snapshot, error) {
+	files, err := listFiles(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files before the task: %w", err)
+	}
+	snapshot := make(Snapshot, len(files))
+	for _, file := range files {
+		info, err := os.Stat(filepath.Join(root, filepath.FromSlash(file)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", file, err)
+		}
+		snapshot[file] = fileState{info: info, size: info.Size(), modTime: info.ModTime()}
+	}
+	return snapshot, nil
+}
+
+// VerifyManifest checks the files under root against the manifest and returns a verdict.
+// Paths in the verdict are slash separated and relative to root.
+func VerifyManifest(root string, manifest *models.OutputManifest) (*models.OutputVerdict, error) {
+	return VerifyManifestSince(root, manifest, nil)
+}
+
+// VerifyManifestSince checks the manifest as VerifyManifest does. Declared
+// entries are checked against every file under root, but in strict mode only
+// the files created or changed since before was taken count as extra.
+func VerifyManifestSince(root string, manifest *models.OutputManifest, before Snapshot) (*models.OutputVerdict, error) {
+	if manifest == nil {
+		return nil, fmt.Errorf("nil manifest")
+	}
+
+	files, err := listFiles(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list output files: %w", err)
+	}
+
+	verdict := &models.OutputVerdict{
+		Passed: true,
+		Mode:   manifest.EffectiveMode(),
+		Gating: manifest.EffectiveGating(),
+	}
+
+	claimed := make(map[string]bool)
+
+	for _, entry := range manifest.Entries {
+		pattern := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(entry.Path)), "/")
+
+		var matched []string
+		for _, file := range files {
+			if matchGlob(pattern, file) {
+				matched = append(matched, file)
+				claimed[file] = true
+			}
+		}
+
+		entryVerdict := models.OutputEntryVerdict{
+			Path:    entry.Path,
+			Status:  models.OutputStatusOK,
+			Matched: matched,
+		}
+
+		if len(matched) == 0 {
+			entryVerdict.Status = models.OutputStatusMissing
+			entryVerdict.Detail = "no file matches this entry"
+		} else {
+			for _, file := range matched {
+				status, detail, err := checkEntry(filepath.Join(root, filepath.FromSlash(file)), entry)
+				if err != nil {
+					return nil, err
+				}
+				if status != models.OutputStatusOK {
+					entryVerdict.Status = status
+					entryVerdict.Detail = fmt.Sprintf("%s: %s", file, detail)
+					break
+				}
+			}
+		}
+
+		if entryVerdict.Status != models.OutputStatusOK {
+			verdict.Passed = false
+		}
+		verdict.Entries = append(verdict.Entries, entryVerdict)
+	}
+
+	if verdict.Mode == models.ManifestModeStrict {
+		written := files
+		if before != nil {
+			if written, err = changedFiles(root, files, before); err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range written {
+			if !claimed[file] {
+				verdict.ExtraFiles = append(verdict.ExtraFiles, file)
+			}
+		}
+		if len(verdict.ExtraFiles) > 0 {
+			verdict.Passed = false
+		}
+	}
+
+	return verdict, nil
+}
+
+func checkEntry(filePath string, entry models.ManifestEntry) (models.OutputStatus, string, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to stat %s: %w", filePath, err)
+	}
+
+	size := info.Size()
+	if entry.MinSize != nil && size < *entry.MinSize {
+		return models.OutputStatusSizeOutOfRange, fmt.Sprintf("size %d below minimum %d", size, *entry.MinSize), nil
+	}
+	if entry.MaxSize != nil && size > *entry.MaxSize {
+		return models.OutputStatusSizeOutOfRange, fmt.Sprintf("size %d above maximum %d", size, *entry.MaxSize), nil
+	}
+
+	if entry.SHA256 != "" {
+		sum, err := hashFile(filePath)
+		if err != nil {
+			return "", "", err
+		}
+		if sum != entry.SHA256 {
+			return models.OutputStatusHashMismatch, fmt.Sprintf("sha256 %s does not match expected %s", sum, entry.SHA256), nil
+		}
+	}
+
+	return models.OutputStatusOK, "", nil
+}
+
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", filePath, err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+func listFiles(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// changedFiles returns the files of root that before does not hold as
+// they are now
+func changedFiles(root string, files []string, before Snapshot) ([]string, error) {
+	var changed []string
+	for _, file := range files {
+		state, existed := before[file]
+		if existed {
+			info, err := os.Stat(filepath.Join(root, filepath.FromSlash(file)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to stat %s: %w", file, err)
+			}
+			if state.unchanged(info) {
+				continue
+			}
+		}
+		changed = append(changed, file)
+	}
+	return changed, nil
+}
+
+// matchGlob matches a slash separated path against a pattern where "**"
+// matches zero or more whole path segments
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		ok, err := path.Match(pattern[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,189 @@
+package outputs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func writeOutput(t *testing.T, root, name, content string) string {
+	t.Helper()
+	full := filepath.Join(root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(full, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func int64Ptr(v int64) *int64 { return &v }
+
+func TestVerifyManifestLenient(t *testing.T) {
+	root := t.TempDir()
+	reportHash := writeOutput(t, root, "report.json", `{"ok":true}`)
+	writeOutput(t, root, "shards/part-0.bin", "0123456789")
+	writeOutput(t, root, "shards/nested/part-1.bin", "abcdefghij")
+	writeOutput(t, root, "debug.log", "noise")
+
+	manifest := &models.OutputManifest{
+		Mode: models.ManifestModeLenient,
+		Entries: []models.ManifestEntry{
+			{Path: "report.json", SHA256: reportHash},
+			{Path: "shards/**/*.bin", MinSize: int64Ptr(5), MaxSize: int64Ptr(20)},
+		},
+	}
+
+	verdict, err := VerifyManifest(root, manifest)
+	if err != nil {
+		t.Fatalf("Failed to verify manifest: %v", err)
+	}
+
+	if !verdict.Passed {
+		t.Fatalf("Expected lenient verdict to pass, got %+v", verdict)
+	}
+	if len(verdict.Entries[1].Matched) != 2 {
+		t.Errorf("Expected glob to match 2 files, got %v", verdict.Entries[1].Matched)
+	}
+	if len(verdict.ExtraFiles) != 0 {
+		t.Errorf("Lenient mode should not report extra files, got %v", verdict.ExtraFiles)
+	}
+}
+
+func TestVerifyManifestStrict(t *testing.T) {
+	root := t.TempDir()
+	writeOutput(t, root, "model.bin", "weights")
+	writeOutput(t, root, "tmp/cache.dat", "leftover")
+
+	manifest := &models.OutputManifest{
+		Mode:    models.ManifestModeStrict,
+		Entries: []models.ManifestEntry{{Path: "model.bin"}},
+	}
+
+	verdict, err := VerifyManifest(root, manifest)
+	if err != nil {
+		t.Fatalf("Failed to verify manifest: %v", err)
+	}
+
+	if verdict.Passed {
+		t.Error("Expected strict verdict to fail with an extra file")
+	}
+	if len(verdict.ExtraFiles) != 1 || verdict.ExtraFiles[0] != "tmp/cache.dat" {
+		t.Errorf("Unexpected extra files: %v", verdict.ExtraFiles)
+	}
+	if !verdict.FailsTask() {
+		t.Error("Expected default enforce gating to fail the task")
+	}
+}
+
+func TestVerifyManifestMismatches(t *testing.T) {
+	root := t.TempDir()
+	writeOutput(t, root, "result.txt", "actual")
+	writeOutput(t, root, "small.bin", "x")
+
+	manifest := &models.OutputManifest{
+		Gating: models.ManifestGatingReport,
+		Entries: []models.ManifestEntry{
+			{Path: "result.txt", SHA256: "0000000000000000000000000000000000000000000000000000000000000000"},
+			{Path: "small.bin", MinSize: int64Ptr(10)},
+			{Path: "missing.csv"},
+		},
+	}
+
+	verdict, err := VerifyManifest(root, manifest)
+	if err != nil {
+		t.Fatalf("Failed to verify manifest: %v", err)
+	}
+
+	expected := []models.OutputStatus{
+		models.OutputStatusHashMismatch,
+		models.OutputStatusSizeOutOfRange,
+		models.OutputStatusMissing,
+	}
+	for i, status := range expected {
+		if verdict.Entries[i].Status != status {
+			t.Errorf("Entry %d: expected %s, got %s", i, status, verdict.Entries[i].Status)
+		}
+	}
+	if verdict.FailsTask() {
+		t.Error("Report gating must not fail the task")
+	}
+}
+
+func TestVerifyManifestSinceIgnoresExistingFiles(t *testing.T) {
+	root := t.TempDir()
+	writeOutput(t, root, "README.md", "the user's project")
+	writeOutput(t, root, "data/input.csv", "a,b")
+	writeOutput(t, root, "model.bin", "old weights")
+
+	before, err := TakeSnapshot(root)
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+
+	// The task rewrites its output and adds a file it did not declare
+	writeOutput(t, root, "model.bin", "new weights!")
+	writeOutput(t, root, "tmp/scratch.dat", "leftover")
+
+	manifest := &models.OutputManifest{
+		Mode:    models.ManifestModeStrict,
+		Entries: []models.ManifestEntry{{Path: "model.bin"}},
+	}
+	verdict, err := VerifyManifestSince(root, manifest, before)
+	if err != nil {
+		t.Fatalf("Failed to verify manifest: %v", err)
+	}
+
+	if len(verdict.ExtraFiles) != 1 || verdict.ExtraFiles[0] != "tmp/scratch.dat" {
+		t.Errorf("Expected only the file the task created to be extra, got %v", verdict.ExtraFiles)
+	}
+	if verdict.Entries[0].Status != models.OutputStatusOK {
+		t.Errorf("Expected the rewritten output to match, got %+v", verdict.Entries[0])
+	}
+}
+
+func TestVerifyManifestSinceKeepsExistingOutputs(t *testing.T) {
+	root := t.TempDir()
+	writeOutput(t, root, "config.json", "{}")
+	writeOutput(t, root, "data/input.csv", "a,b")
+
+	before, err := TakeSnapshot(root)
+	if err != nil {
+		t.Fatalf("Failed to take snapshot: %v", err)
+	}
+	// The task replaces an input with a file of the same size and time,
+	// and leaves its declared config as it was
+	info, err := os.Stat(filepath.Join(root, "data/input.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeOutput(t, root, "data/replaced.tmp", "c,d")
+	if err := os.Rename(filepath.Join(root, "data/replaced.tmp"), filepath.Join(root, "data/input.csv")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(root, "data/input.csv"), info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := &models.OutputManifest{
+		Mode:    models.ManifestModeStrict,
+		Entries: []models.ManifestEntry{{Path: "config.json"}},
+	}
+	verdict, err := VerifyManifestSince(root, manifest, before)
+	if err != nil {
+		t.Fatalf("Failed to verify manifest: %v", err)
+	}
+
+	if verdict.Entries[0].Status != models.OutputStatusOK {
+		t.Errorf("Expected the existing declared output to be present, got %+v", verdict.Entries[0])
+	}
+	if len(verdict.ExtraFiles) != 1 || verdict.ExtraFiles[0] != "data/input.csv" {
+		t.Errorf("Expected the replaced file to be extra, got %v", verdict.ExtraFiles)
+	}
+}
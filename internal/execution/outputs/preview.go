@@ -0,0 +1,635 @@
+package outputs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const (
+	// sniffLen is how much of an artifact its content type is detected
+	// from, as much as http.DetectContentType considers
+	sniffLen = 512
+	// scanLimit bounds how much of a text, CSV or JSON artifact its
+	// preview reads
+	scanLimit = 1 << 20
+	// maxLineBytes cuts the lines a preview holds
+	maxLineBytes = 256
+	// maxColumns and maxJSONKeys bound what CSV and JSON previews list
+	maxColumns  = 64
+	maxJSONKeys = 32
+	// maxImageBytes and maxImagePixels bound the images decoded for a
+	// thumbnail; larger ones are previewed by their dimensions alone
+	maxImageBytes  = 32 << 20
+	maxImagePixels = 40_000_000
+	// minThumbnail is the smallest thumbnail shrunk to before the
+	// thumbnail is dropped from a preview over its cap
+	minThumbnail = 8
+)
+
+// PreviewOptions bounds the previews DescribeArtifacts generates
+type PreviewOptions struct {
+	// MaxBytes caps the encoded preview of each artifact; a preview that
+	// cannot be shrunk below it is dropped
+	MaxBytes int
+	// Lines is how many lines of text and CSV artifacts previews hold
+	Lines int
+	// ThumbnailSize bounds the width and height of image thumbnails
+	ThumbnailSize int
+	// MaxArtifacts bounds how many artifacts are described; zero describes
+	// every one
+	MaxArtifacts int
+}
+
+// DescribeArtifacts describes the regular files under root in path order,
+// detecting their content types and generating bounded previews of them.
+// Symlinks and other special files are skipped. A preview that fails
+// leaves the artifact described by its type alone.
+func DescribeArtifacts(root string, opts PreviewOptions) ([]models.ArtifactDescription, error) {
+	files, err := listFiles(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list output files: %w", err)
+	}
+	if opts.MaxArtifacts > 0 && len(files) > opts.MaxArtifacts {
+		files = files[:opts.MaxArtifacts]
+	}
+
+	descriptions := make([]models.ArtifactDescription, 0, len(files))
+	for _, file := range files {
+		description, err := DescribeArtifact(filepath.Join(root, filepath.FromSlash(file)), file, opts)
+		if err != nil {
+			return nil, err
+		}
+		descriptions = append(descriptions, description)
+	}
+	return descriptions, nil
+}
+
+// DescribeArtifact describes the file at filePath as the artifact name
+func DescribeArtifact(filePath, name string, opts PreviewOptions) (models.ArtifactDescription, error) {
+	description := models.ArtifactDescription{Path: name}
+
+	info, err := os.Lstat(filePath)
+	if err != nil {
+		return description, fmt.Errorf("failed to stat %s: %w", filePath, err)
+	}
+	if !info.Mode().IsRegular() {
+		return description, fmt.Errorf("%s is not a regular file", name)
+	}
+	description.Size = info.Size()
+	if description.SHA256, err = hashFile(filePath); err != nil {
+		return description, err
+	}
+	if description.ContentType, err = detectContentType(filePath, name); err != nil {
+		return description, err
+	}
+
+	preview, err := generatePreview(filePath, description.ContentType, opts)
+	if err != nil {
+		description.PreviewError = err.Error()
+		return description, nil
+	}
+	description.Preview = preview
+	return description, nil
+}
+
+// detectContentType detects the content type of the file at filePath from
+// its leading bytes, refined by name's extension where the bytes alone do
+// not tell text formats apart
+func detectContentType(filePath, name string) (string, error) {
+	head, err := readHead(filePath, sniffLen)
+	if err != nil {
+		return "", err
+	}
+	if len(head) == 0 {
+		return "application/octet-stream", nil
+	}
+
+	detected := http.DetectContentType(head)
+	ext := strings.ToLower(path.Ext(name))
+	if strings.HasPrefix(detected, "text/plain") {
+		switch ext {
+		case ".json":
+			return "application/json", nil
+		case ".jsonl", ".ndjson":
+			return "application/x-ndjson", nil
+		case ".csv":
+			return "text/csv; charset=utf-8", nil
+		case ".tsv":
+			return "text/tab-separated-values; charset=utf-8", nil
+		}
+		// Text named for no type of its own is JSON if it starts like it
+		trimmed := bytes.TrimLeft(head, " \t\r\n")
+		if mime.TypeByExtension(ext) == "" && len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+			return "application/json", nil
+		}
+		return detected, nil
+	}
+	if detected == "application/octet-stream" {
+		if byExt := mime.TypeByExtension(ext); byExt != "" {
+			return byExt, nil
+		}
+	}
+	return detected, nil
+}
+
+func readHead(filePath string, n int) ([]byte, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	head := make([]byte, n)
+	read, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+	return head[:read], nil
+}
+
+// generatePreview previews the file at filePath by its content type,
+// returning nil for types it does not preview. Whatever goes wrong while
+// reading the artifact, a panic of a decoder included, is returned as an
+// error.
+func generatePreview(filePath, contentType string, opts PreviewOptions) (preview *models.ArtifactPreview, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			preview, err = nil, fmt.Errorf("preview failed: %v", r)
+		}
+	}()
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json":
+		preview, err = previewJSON(filePath)
+	case mediaType == "text/csv":
+		preview, err = previewCSV(filePath, ',', opts)
+	case mediaType == "text/tab-separated-values":
+		preview, err = previewCSV(filePath, '\t', opts)
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/x-ndjson":
+		preview, err = previewText(filePath, opts)
+	case strings.HasPrefix(mediaType, "image/"):
+		preview, err = previewImage(filePath, opts)
+	default:
+		return nil, nil
+	}
+	if err != nil || preview == nil {
+		return nil, err
+	}
+	return fitPreview(preview, opts.MaxBytes)
+}
+
+// fitPreview shrinks preview until it encodes within maxBytes
+func fitPreview(preview *models.ArtifactPreview, maxBytes int) (*models.ArtifactPreview, error) {
+	if maxBytes <= 0 {
+		return preview, nil
+	}
+	for {
+		encoded, err := json.Marshal(preview)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode preview: %w", err)
+		}
+		if len(encoded) <= maxBytes {
+			return preview, nil
+		}
+		if !shrinkPreview(preview) {
+			return nil, fmt.Errorf("preview exceeds %d bytes", maxBytes)
+		}
+	}
+}
+
+// shrinkPreview drops the least telling part of preview, reporting false
+// once nothing is left to drop
+func shrinkPreview(preview *models.ArtifactPreview) bool {
+	switch {
+	case len(preview.Lines) > 0:
+		preview.Lines = preview.Lines[:len(preview.Lines)-1]
+		preview.Truncated = true
+	case len(preview.Columns) > 0:
+		preview.Columns = preview.Columns[:len(preview.Columns)-1]
+	case preview.JSON != nil && len(preview.JSON.Keys) > 0:
+		preview.JSON.Keys = preview.JSON.Keys[:len(preview.JSON.Keys)-1]
+	case preview.Image != nil && preview.Image.Thumbnail != "":
+		preview.Image.Thumbnail = ""
+	default:
+		return false
+	}
+	return true
+}
+
+// previewText holds the first lines of a text artifact
+func previewText(filePath string, opts PreviewOptions) (*models.ArtifactPreview, error) {
+	lines, truncated, err := readLines(filePath, opts.Lines)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ArtifactPreview{Kind: models.ArtifactPreviewText, Lines: lines, Truncated: truncated}, nil
+}
+
+// readLines reads up to n lines of the file at filePath within scanLimit,
+// cutting each to maxLineBytes, and reports whether the file continues
+// past them
+func readLines(filePath string, n int) ([]string, bool, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(io.LimitReader(file, scanLimit), maxLineBytes)
+	var lines []string
+	for len(lines) < n {
+		line, cut, err := readLine(reader)
+		if errors.Is(err, io.EOF) {
+			return lines, false, nil
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read %s: %w", filePath, err)
+		}
+		lines = append(lines, line)
+		if cut {
+			// The rest of the line may run past scanLimit
+			if _, err := reader.Peek(1); err != nil {
+				return lines, true, nil
+			}
+		}
+	}
+	_, err = reader.Peek(1)
+	return lines, err == nil, nil
+}
+
+// readLine reads a line of reader, keeping its first maxLineBytes and
+// skipping the rest. It reports whether the line was cut.
+func readLine(reader *bufio.Reader) (string, bool, error) {
+	var (
+		kept []byte
+		cut  bool
+	)
+	for {
+		fragment, more, err := reader.ReadLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) && (kept != nil || cut) {
+				break
+			}
+			return "", false, err
+		}
+		if room := maxLineBytes - len(kept); room > 0 {
+			if len(fragment) > room {
+				fragment, cut = fragment[:room], true
+			}
+			kept = append(kept, fragment...)
+		} else if len(fragment) > 0 {
+			cut = true
+		}
+		if kept == nil {
+			kept = []byte{}
+		}
+		if !more {
+			break
+		}
+	}
+	line := strings.ToValidUTF8(string(kept), string(utf8.RuneError))
+	return line, cut, nil
+}
+
+// previewCSV holds the first lines of a CSV artifact and summarizes its
+// columns over the rows within scanLimit
+func previewCSV(filePath string, comma rune, opts PreviewOptions) (*models.ArtifactPreview, error) {
+	lines, truncated, err := readLines(filePath, opts.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	limited := &io.LimitedReader{R: file, N: scanLimit}
+	reader := csv.NewReader(limited)
+	reader.Comma = comma
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return &models.ArtifactPreview{Kind: models.ArtifactPreviewCSV, Lines: lines}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CSV header: %w", err)
+	}
+	columns := make([]columnAccumulator, min(len(header), maxColumns))
+	for i := range columns {
+		columns[i].name = truncateString(header[i], maxLineBytes)
+	}
+
+	rows := 0
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if limited.N <= 0 {
+				// The last row read was cut by scanLimit
+				truncated = true
+				break
+			}
+			return nil, fmt.Errorf("failed to parse CSV row %d: %w", rows+1, err)
+		}
+		rows++
+		for i := range columns {
+			if i < len(record) {
+				columns[i].add(record[i])
+			}
+		}
+	}
+	if limited.N <= 0 {
+		truncated = true
+	}
+
+	preview := &models.ArtifactPreview{
+		Kind:      models.ArtifactPreviewCSV,
+		Lines:     lines,
+		Truncated: truncated,
+		Rows:      rows,
+	}
+	for i := range columns {
+		preview.Columns = append(preview.Columns, columns[i].stats())
+	}
+	return preview, nil
+}
+
+// columnAccumulator gathers the statistics of a CSV column
+type columnAccumulator struct {
+	name     string
+	nonEmpty int
+	text     bool
+	min, max float64
+	sum      float64
+}
+
+func (c *columnAccumulator) add(value string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return
+	}
+	c.nonEmpty++
+	if c.text {
+		return
+	}
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		c.text = true
+		return
+	}
+	if c.nonEmpty == 1 || number < c.min {
+		c.min = number
+	}
+	if c.nonEmpty == 1 || number > c.max {
+		c.max = number
+	}
+	c.sum += number
+}
+
+func (c *columnAccumulator) stats() models.ColumnStats {
+	stats := models.ColumnStats{Name: c.name, Type: "text", NonEmpty: c.nonEmpty}
+	if c.nonEmpty == 0 {
+		stats.Type = "empty"
+		return stats
+	}
+	if !c.text {
+		minimum, maximum, mean := c.min, c.max, c.sum/float64(c.nonEmpty)
+		stats.Type = "number"
+		stats.Min, stats.Max, stats.Mean = &minimum, &maximum, &mean
+	}
+	return stats
+}
+
+// jsonFrame is a JSON object or array being walked
+type jsonFrame struct {
+	object bool
+	// key is set when the next token of an object is a key
+	key bool
+}
+
+// previewJSON summarizes the structure of a JSON artifact by walking its
+// tokens within scanLimit, never holding more than a token at a time
+func previewJSON(filePath string) (*models.ArtifactPreview, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	limited := &io.LimitedReader{R: file, N: scanLimit}
+	decoder := json.NewDecoder(limited)
+	decoder.UseNumber()
+
+	summary := &models.JSONSummary{}
+	var (
+		stack   []jsonFrame
+		pending string
+	)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			if limited.N <= 0 {
+				summary.Truncated = true
+				break
+			}
+			return nil, fmt.Errorf("malformed JSON: %w", err)
+		}
+
+		depth := len(stack)
+		if delim, ok := token.(json.Delim); ok && (delim == '}' || delim == ']') {
+			stack = stack[:depth-1]
+			if len(stack) == 0 {
+				break
+			}
+			continue
+		}
+		if depth > 0 && stack[depth-1].object && stack[depth-1].key {
+			stack[depth-1].key = false
+			if depth == 1 {
+				pending, _ = token.(string)
+			}
+			continue
+		}
+
+		kind := jsonType(token)
+		switch {
+		case depth == 0:
+			summary.Type = kind
+		case depth == 1 && stack[0].object:
+			summary.KeyCount++
+			if len(summary.Keys) < maxJSONKeys {
+				summary.Keys = append(summary.Keys, models.JSONField{Name: truncateString(pending, maxLineBytes), Type: kind})
+			}
+		case depth == 1:
+			summary.Length++
+			if summary.ItemType == "" {
+				summary.ItemType = kind
+			} else if summary.ItemType != kind {
+				summary.ItemType = "mixed"
+			}
+		}
+		if depth > 0 && stack[depth-1].object {
+			stack[depth-1].key = true
+		}
+
+		delim, ok := token.(json.Delim)
+		if !ok {
+			if depth == 0 {
+				break
+			}
+			continue
+		}
+		stack = append(stack, jsonFrame{object: delim == '{', key: delim == '{'})
+		summary.Depth = max(summary.Depth, len(stack))
+	}
+	if summary.Type == "" {
+		return nil, errors.New("malformed JSON: no value")
+	}
+	return &models.ArtifactPreview{Kind: models.ArtifactPreviewJSON, JSON: summary, Truncated: summary.Truncated}, nil
+}
+
+// jsonType names the type of the JSON value token starts
+func jsonType(token json.Token) string {
+	switch v := token.(type) {
+	case json.Delim:
+		if v == '{' {
+			return "object"
+		}
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+// previewImage gives an image's dimensions from its header and, for one
+// within maxImageBytes and maxImagePixels, a thumbnail of it
+func previewImage(filePath string, opts PreviewOptions) (*models.ArtifactPreview, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	config, format, err := image.DecodeConfig(io.LimitReader(file, scanLimit))
+	if errors.Is(err, image.ErrFormat) {
+		// Not a format the runner decodes
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed image: %w", err)
+	}
+	preview := &models.ArtifactPreview{
+		Kind:  models.ArtifactPreviewImage,
+		Image: &models.ImagePreview{Format: format, Width: config.Width, Height: config.Height},
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, errors.New("malformed image: no pixels")
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat %s: %w", filePath, err)
+	}
+	if opts.ThumbnailSize <= 0 || info.Size() > maxImageBytes || int64(config.Width)*int64(config.Height) > maxImagePixels {
+		return preview, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+	img, _, err := image.Decode(io.LimitReader(file, maxImageBytes))
+	if err != nil {
+		return nil, fmt.Errorf("malformed image: %w", err)
+	}
+
+	// Halve the thumbnail until it fits what a preview may hold
+	for size := opts.ThumbnailSize; size >= minThumbnail; size /= 2 {
+		thumbnail, err := encodeThumbnail(img, size)
+		if err != nil {
+			return nil, err
+		}
+		if opts.MaxBytes <= 0 || len(thumbnail) <= opts.MaxBytes/2 || size/2 < minThumbnail {
+			preview.Image.Thumbnail = thumbnail
+			break
+		}
+	}
+	return preview, nil
+}
+
+// encodeThumbnail scales img to fit size by nearest neighbour and returns
+// it as a PNG data URI
+func encodeThumbnail(img image.Image, size int) (string, error) {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, max(1, height*size/width)
+		} else {
+			width, height = max(1, width*size/height), size
+		}
+	}
+
+	thumbnail := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		sy := bounds.Min.Y + y*bounds.Dy()/height
+		for x := 0; x < width; x++ {
+			sx := bounds.Min.X + x*bounds.Dx()/width
+			thumbnail.Set(x, y, color.NRGBAModel.Convert(img.At(sx, sy)))
+		}
+	}
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, thumbnail); err != nil {
+		return "", fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(encoded.Bytes()), nil
+}
+
+// truncateString cuts s to at most n bytes without splitting a rune
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n]
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
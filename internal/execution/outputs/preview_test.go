@@ -0,0 +1,205 @@
+package outputs
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var testPreviewOptions = PreviewOptions{MaxBytes: 16 << 10, Lines: 3, ThumbnailSize: 32}
+
+// copyFixture copies the testdata fixture name into root
+func copyFixture(t *testing.T, root, name string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("Failed to read fixture: %v", err)
+	}
+	writeOutput(t, root, name, string(data))
+}
+
+func describeFixture(t *testing.T, name string, opts PreviewOptions) models.ArtifactDescription {
+	t.Helper()
+	description, err := DescribeArtifact(filepath.Join("testdata", name), name, opts)
+	if err != nil {
+		t.Fatalf("DescribeArtifact(%s) error = %v", name, err)
+	}
+	return description
+}
+
+func TestDescribeArtifacts(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"cities.csv", "gradient.png", "metrics.json", "train.log"} {
+		copyFixture(t, root, name)
+	}
+	writeOutput(t, root, "model.bin", "\x00\x01\x02\x03weights")
+	if err := os.Symlink("/etc/passwd", filepath.Join(root, "passwd")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	descriptions, err := DescribeArtifacts(root, testPreviewOptions)
+	if err != nil {
+		t.Fatalf("DescribeArtifacts() error = %v", err)
+	}
+	types := make(map[string]string)
+	for _, description := range descriptions {
+		types[description.Path] = description.ContentType
+		if description.Size == 0 || len(description.SHA256) != 64 {
+			t.Errorf("%s described as %+v, want its size and hash", description.Path, description)
+		}
+	}
+	want := map[string]string{
+		"cities.csv":   "text/csv; charset=utf-8",
+		"gradient.png": "image/png",
+		"metrics.json": "application/json",
+		"model.bin":    "application/octet-stream",
+		"train.log":    "text/plain; charset=utf-8",
+	}
+	if len(types) != len(want) {
+		t.Fatalf("described %v, want %v without the symlink", types, want)
+	}
+	for name, contentType := range want {
+		if types[name] != contentType {
+			t.Errorf("%s detected as %q, want %q", name, types[name], contentType)
+		}
+	}
+}
+
+func TestPreviewText(t *testing.T) {
+	description := describeFixture(t, "train.log", testPreviewOptions)
+	preview := description.Preview
+	if preview == nil || preview.Kind != models.ArtifactPreviewText {
+		t.Fatalf("preview = %+v, want a text preview", preview)
+	}
+	if len(preview.Lines) != 3 || preview.Lines[0] != "epoch 1 loss=0.913" || !preview.Truncated {
+		t.Errorf("preview = %+v, want the first 3 of 5 lines", preview)
+	}
+}
+
+func TestPreviewCSV(t *testing.T) {
+	preview := describeFixture(t, "cities.csv", testPreviewOptions).Preview
+	if preview == nil || preview.Kind != models.ArtifactPreviewCSV {
+		t.Fatalf("preview = %+v, want a CSV preview", preview)
+	}
+	if preview.Rows != 4 || len(preview.Columns) != 4 || len(preview.Lines) != 3 {
+		t.Fatalf("preview = %+v, want 4 rows of 4 columns and 3 lines", preview)
+	}
+	population := preview.Columns[1]
+	if population.Type != "number" || population.NonEmpty != 4 || *population.Min != 64560 || *population.Max != 545923 {
+		t.Errorf("population column = %+v", population)
+	}
+	if note := preview.Columns[3]; note.Type != "text" || note.NonEmpty != 3 || note.Mean != nil {
+		t.Errorf("note column = %+v", note)
+	}
+}
+
+func TestPreviewJSON(t *testing.T) {
+	preview := describeFixture(t, "metrics.json", testPreviewOptions).Preview
+	if preview == nil || preview.JSON == nil {
+		t.Fatalf("preview = %+v, want a JSON summary", preview)
+	}
+	summary := preview.JSON
+	if summary.Type != "object" || summary.KeyCount != 5 || summary.Depth != 3 || summary.Truncated {
+		t.Errorf("summary = %+v, want an object of 5 keys nesting 3 deep", summary)
+	}
+	want := []models.JSONField{
+		{Name: "accuracy", Type: "number"},
+		{Name: "labels", Type: "array"},
+		{Name: "confusion", Type: "array"},
+		{Name: "model", Type: "object"},
+		{Name: "notes", Type: "null"},
+	}
+	for i, field := range want {
+		if i >= len(summary.Keys) || summary.Keys[i] != field {
+			t.Errorf("keys = %+v, want %+v", summary.Keys, want)
+			break
+		}
+	}
+}
+
+func TestPreviewImage(t *testing.T) {
+	preview := describeFixture(t, "gradient.png", testPreviewOptions).Preview
+	if preview == nil || preview.Image == nil {
+		t.Fatalf("preview = %+v, want an image preview", preview)
+	}
+	image := preview.Image
+	if image.Format != "png" || image.Width != 640 || image.Height != 480 {
+		t.Errorf("image = %+v, want a 640x480 png", image)
+	}
+	if !strings.HasPrefix(image.Thumbnail, "data:image/png;base64,") {
+		t.Errorf("thumbnail = %q, want a PNG data URI", image.Thumbnail)
+	}
+
+	// A cap too small for any thumbnail keeps the dimensions
+	small := describeFixture(t, "gradient.png", PreviewOptions{MaxBytes: 120, ThumbnailSize: 32}).Preview
+	if small == nil || small.Image == nil || small.Image.Thumbnail != "" || small.Image.Width != 640 {
+		t.Errorf("preview under a small cap = %+v, want dimensions without a thumbnail", small)
+	}
+}
+
+func TestMalformedImageDegradesToType(t *testing.T) {
+	description := describeFixture(t, "truncated.png", testPreviewOptions)
+	if description.ContentType != "image/png" {
+		t.Errorf("content type = %q, want image/png", description.ContentType)
+	}
+	if description.Preview != nil || description.PreviewError == "" {
+		t.Errorf("description = %+v, want no preview and why", description)
+	}
+}
+
+func TestEnormousSingleLineJSON(t *testing.T) {
+	// An array of a million objects on one line, many times what a preview
+	// reads
+	path := filepath.Join(t.TempDir(), "huge.json")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := bufio.NewWriter(file)
+	writer.WriteString("[")
+	for i := 0; i < 1_000_000; i++ {
+		if i > 0 {
+			writer.WriteString(",")
+		}
+		writer.WriteString(`{"id":1,"label":"abcdefghijklmnop"}`)
+	}
+	writer.WriteString("]")
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	description, err := DescribeArtifact(path, "huge.json", testPreviewOptions)
+	if err != nil {
+		t.Fatalf("DescribeArtifact() error = %v", err)
+	}
+	preview := description.Preview
+	if preview == nil || preview.JSON == nil {
+		t.Fatalf("description = %+v, want a JSON summary", description)
+	}
+	summary := preview.JSON
+	if summary.Type != "array" || summary.ItemType != "object" || !summary.Truncated || summary.Depth != 2 {
+		t.Errorf("summary = %+v, want a truncated array of objects", summary)
+	}
+	if summary.Length == 0 || summary.Length >= 1_000_000 {
+		t.Errorf("length = %d, want only the items read", summary.Length)
+	}
+	encoded, _ := json.Marshal(preview)
+	if len(encoded) > testPreviewOptions.MaxBytes {
+		t.Errorf("preview encodes to %d bytes, over the %d cap", len(encoded), testPreviewOptions.MaxBytes)
+	}
+
+	// Read as text, the line is cut
+	text, err := DescribeArtifact(path, "huge.txt", testPreviewOptions)
+	if err != nil {
+		t.Fatalf("DescribeArtifact() error = %v", err)
+	}
+	if text.Preview == nil || len(text.Preview.Lines) != 1 || len(text.Preview.Lines[0]) != maxLineBytes || !text.Preview.Truncated {
+		t.Errorf("text preview = %+v, want its one line cut", text.Preview)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build ignore
+
+// generate writes the fixture images of the outputs package's tests:
+//
+//	go run generate.go
+//
+// gradient.png is a 640x480 gradient. truncated.png is gradient.png cut
+// short after its header, so its dimensions can be read but its pixels
+// cannot.
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"os"
+)
+
+func main() {
+	img := image.NewNRGBA(image.Rect(0, 0, 640, 480))
+	for y := 0; y < 480; y++ {
+		for x := 0; x < 640; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 255 / 640), uint8(y * 255 / 480), 128, 255})
+		}
+	}
+
+	var encoded bytes.Buffer
+	if err := png.Encode(&encoded, img); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("gradient.png", encoded.Bytes(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("truncated.png", encoded.Bytes()[:200], 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
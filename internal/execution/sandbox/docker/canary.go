@@ -0,0 +1,21 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+// RunCanary runs a container of image, pulling the image first if needed,
+// and checks that it exits successfully. The container has no network and
+// is removed once it exits.
+func (e *DockerExecutor) RunCanary(ctx context.Context, image string) error {
+	if err := e.imageManager.EnsureImageAvailable(ctx, image, "", ""); err != nil {
+		return fmt.Errorf("failed to prepare canary image: %w", err)
+	}
+	if _, err := executils.ExecCommand(ctx, "docker", "run", "--rm", "--network", "none", "--label", "parity.canary=true", e.imageManager.Local(image)); err != nil {
+		return fmt.Errorf("canary container failed: %w", err)
+	}
+	return nil
+}
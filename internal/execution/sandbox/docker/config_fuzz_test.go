@@ -0,0 +1,57 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// FuzzDockerConfig decodes arbitrary Docker task configs as ExecuteTask
+// does and runs the checks made before a container is created: memory
+// limits, network overrides and the arguments they become
+func FuzzDockerConfig(f *testing.F) {
+	fixtures, err := filepath.Glob(filepath.Join("..", "..", "..", "taskschema", "testdata", "docker", "*.json"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, fixture := range fixtures {
+		config, err := os.ReadFile(fixture)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(config)
+	}
+	f.Add([]byte(`{"image_name":"alpine","resources":{"memory":"1e30t"}}`))
+
+	policy, err := ParseNetworkPolicy([]string{"10.0.0.0/8"})
+	if err != nil {
+		f.Fatal(err)
+	}
+	e := &DockerExecutor{config: &ExecutorConfig{MemoryLimit: "8g"}, memory: MemoryPolicy{SoftRatio: 0.9}, network: policy}
+
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		var config models.TaskConfig
+		if err := safejson.Unmarshal(raw, &config); err != nil {
+			return
+		}
+		_ = config.Validate(models.TaskTypeDocker)
+
+		if limits, err := e.memoryLimits(config.Resources); err == nil {
+			if limits.Hard > 8<<30 || limits.Soft > limits.Hard {
+				t.Fatalf("memoryLimits(%q) = %+v, above the runner's 8g limit", config.Resources.Memory, limits)
+			}
+		}
+
+		if e.network.Check(config.Network) == nil && config.Network != nil {
+			for _, arg := range networkArgs(config.Network) {
+				if strings.ContainsAny(arg, "\x00\n") {
+					t.Fatalf("network argument %q holds a control character", arg)
+				}
+			}
+		}
+	})
+}
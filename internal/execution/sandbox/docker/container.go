@@ -3,8 +3,9 @@ package docker
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -13,124 +14,81 @@ import (
 
 	"github.com/theblitlabs/gologger"
 
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
 	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/profiles"
 )
 
-type SeccompProfile struct {
-	DefaultAction string   `json:"defaultAction"`
-	Architectures []string `json:"architectures"`
-	Syscalls      []struct {
-		Name   string `json:"name"`
-		Action string `json:"action"`
-	} `json:"syscalls"`
-}
-
 type ContainerManager struct {
-	memoryLimit    string
-	cpuLimit       string
-	seccompProfile string
+	memoryLimit string
+	cpuLimit    string
+	// seccompProfiles are the paths of the seccomp filters of the library's
+	// security profiles, by name
+	seccompProfiles map[string]string
+	clock           clock.Clock
+	// stopGrace is how long a cancelled task has between StopSignal and
+	// SIGKILL
+	stopGrace time.Duration
+	// cpuset pins containers to these cores when set
+	cpuset string
+	// restartPolicy is the docker restart policy of task containers, none
+	// when empty
+	restartPolicy string
+	daemon        daemonClient
 }
 
-func createSeccompProfile() (*SeccompProfile, error) {
-	profile := &SeccompProfile{
-		DefaultAction: "SCMP_ACT_ALLOW",
-		Architectures: []string{"SCMP_ARCH_X86_64", "SCMP_ARCH_X86", "SCMP_ARCH_AARCH64"},
-		Syscalls: []struct {
-			Name   string `json:"name"`
-			Action string `json:"action"`
-		}{
-			{
-				Name:   "ptrace", // Block process tracing
-				Action: "SCMP_ACT_ERRNO",
-			},
-			{
-				Name:   "process_vm_readv", // Block reading from another process's memory
-				Action: "SCMP_ACT_ERRNO",
-			},
-			{
-				Name:   "process_vm_writev", // Block writing to another process's memory
-				Action: "SCMP_ACT_ERRNO",
-			},
-			// Important: We MUST allow execve as it's needed for Python and other interpreters to work
-			// Blocking execve causes container startup failures, especially with interpreted languages
-			// {
-			//   Name:   "execve",
-			//   Action: "SCMP_ACT_ERRNO",
-			// },
-			{
-				Name:   "reboot", // Block system reboot
-				Action: "SCMP_ACT_ERRNO",
-			},
-			{
-				Name:   "mount", // Block filesystem mounting
-				Action: "SCMP_ACT_ERRNO",
-			},
-			{
-				Name:   "umount", // Block filesystem unmounting
-				Action: "SCMP_ACT_ERRNO",
-			},
-			{
-				Name:   "umount2", // Block filesystem unmounting (variant)
-				Action: "SCMP_ACT_ERRNO",
-			},
-		},
-	}
-
-	return profile, nil
+// SetClock replaces the clock used between container status retries
+func (cm *ContainerManager) SetClock(c clock.Clock) {
+	cm.clock = c
 }
 
-func writeSeccompProfileToTempFile() (string, error) {
+// writeSeccompProfiles writes the seccomp filter of each security profile
+// of the library to a file of its own for the Docker daemon, returning
+// their paths by profile
+func writeSeccompProfiles() (map[string]string, error) {
 	log := gologger.WithComponent("docker.container")
 
-	tmpDir := os.TempDir()
-	seccompPath := filepath.Join(tmpDir, "seccomp-profile-"+strconv.FormatInt(time.Now().UnixNano(), 10)+".json")
-
-	profile, err := createSeccompProfile()
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to create seccomp profile")
-		return "", err
-	}
-
-	profileData, err := json.MarshalIndent(profile, "", "  ")
+	dir, err := os.MkdirTemp("", "seccomp-profiles-")
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to marshal seccomp profile to JSON")
-		return "", err
-	}
-
-	if err := os.WriteFile(seccompPath, profileData, 0o600); err != nil {
-		log.Error().Err(err).Msg("Failed to write seccomp profile to temporary file")
-		return "", err
+		return nil, fmt.Errorf("failed to create seccomp profile directory: %w", err)
 	}
-
-	if _, err := os.Stat(seccompPath); os.IsNotExist(err) {
-		log.Error().Err(err).Str("path", seccompPath).Msg("Seccomp profile file not found after writing")
-		return "", fmt.Errorf("seccomp profile creation failed: file not found after writing")
+	paths := make(map[string]string)
+	for _, name := range profiles.Names() {
+		profile, err := profiles.Get(name)
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join(dir, name+".json")
+		if err := os.WriteFile(path, profile.SeccompJSON, 0o600); err != nil {
+			log.Error().Err(err).Str("profile", name).Msg("Failed to write seccomp profile to temporary file")
+			return nil, err
+		}
+		paths[name] = path
 	}
 
-	log.Debug().Str("path", seccompPath).Msg("Seccomp profile written to temporary file")
-	return seccompPath, nil
+	log.Debug().Str("dir", dir).Msg("Seccomp profiles written to temporary files")
+	return paths, nil
 }
 
 func NewContainerManager(memoryLimit, cpuLimit string) (*ContainerManager, error) {
 	log := gologger.WithComponent("docker.container")
 
-	seccompPath, err := writeSeccompProfileToTempFile()
+	seccompPaths, err := writeSeccompProfiles()
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create seccomp profile file")
-		return nil, fmt.Errorf("failed to create required seccomp profile: %w", err)
-	}
-
-	if _, err := os.Stat(seccompPath); err != nil {
-		log.Error().Err(err).Str("path", seccompPath).Msg("Unable to access seccomp profile after creation")
-		return nil, fmt.Errorf("seccomp profile inaccessible after creation: %w", err)
+		log.Error().Err(err).Msg("Failed to create seccomp profile files")
+		return nil, fmt.Errorf("failed to create required seccomp profiles: %w", err)
 	}
 
-	log.Debug().Str("seccomp_profile", seccompPath).Msg("Container manager initialized with seccomp profile")
+	log.Debug().Int("seccomp_profiles", len(seccompPaths)).Msg("Container manager initialized with seccomp profiles")
 
 	return &ContainerManager{
-		memoryLimit:    memoryLimit,
-		cpuLimit:       cpuLimit,
-		seccompProfile: seccompPath,
+		memoryLimit:     memoryLimit,
+		cpuLimit:        cpuLimit,
+		seccompProfiles: seccompPaths,
+		clock:           clock.Real(),
+		stopGrace:       DefaultStopGracePeriod,
+		daemon:          cliDaemon{},
 	}, nil
 }
 
@@ -145,34 +103,159 @@ func formatContainerOutput(output []byte) string {
 	return strings.TrimSpace(string(cleaned))
 }
 
+// Mount binds a host path into the task container
+type Mount struct {
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+// ContainerOptions carries optional per-task settings for container creation
+type ContainerOptions struct {
+	Mounts []Mount
+	Labels map[string]string
+	// Entrypoint replaces the image's when non-nil; Command replaces its Cmd
+	Entrypoint []string
+	Command    []string
+	// GPUDevice is the UUID of the GPU or MIG partition given to the
+	// container, or a comma-separated list of GPUs
+	GPUDevice string
+	// Memory replaces the manager's memory limit when set
+	Memory string
+	// Network overrides name resolution in the container; it must have
+	// passed the runner's NetworkPolicy
+	Network *models.NetworkConfig
+	// NetworkName is the docker network the container joins instead of the
+	// default bridge
+	NetworkName string
+	// Ports are published on the host; they must have passed the runner's
+	// NetworkPolicy
+	Ports []models.ServicePort
+	// User is the user the container runs as, "uid:gid"; empty keeps the
+	// image's
+	User string
+	// Capabilities are the Linux capabilities the container keeps; every
+	// other is dropped
+	Capabilities []string
+	// SecurityProfile confines the container under its seccomp filter and,
+	// when AppArmor is set, its AppArmor profile, which must be loaded. Nil
+	// confines it under the restricted profile's filter.
+	SecurityProfile *profiles.Profile
+	AppArmor        bool
+}
+
+// securityArgs are the docker create options that keep a container from
+// gaining privileges: it cannot raise them through setuid binaries, holds
+// only opts' capabilities and runs as opts' user
+func securityArgs(opts ContainerOptions) []string {
+	args := []string{
+		"--security-opt", "no-new-privileges",
+		"--cap-drop", "ALL",
+	}
+	for _, capability := range opts.Capabilities {
+		args = append(args, "--cap-add", capability)
+	}
+	if opts.User != "" {
+		args = append(args, "--user", opts.User)
+	}
+	return args
+}
+
+// profileArgs are the docker create options confining a container under
+// opts' security profile
+func (cm *ContainerManager) profileArgs(opts ContainerOptions) ([]string, error) {
+	log := gologger.WithComponent("docker.container")
+
+	name := profiles.Restricted
+	if opts.SecurityProfile != nil {
+		name = opts.SecurityProfile.Name
+	}
+	seccompPath := cm.seccompProfiles[name]
+	if seccompPath == "" {
+		return nil, fmt.Errorf("missing required seccomp profile %s", name)
+	}
+	if _, err := os.Stat(seccompPath); os.IsNotExist(err) {
+		log.Error().Str("path", seccompPath).Msg("Seccomp profile file does not exist")
+		return nil, fmt.Errorf("seccomp profile file not found: %w", err)
+	}
+
+	args := []string{"--security-opt", "seccomp=" + seccompPath}
+	if opts.AppArmor && opts.SecurityProfile != nil {
+		args = append(args, "--security-opt", "apparmor="+opts.SecurityProfile.AppArmorName())
+	}
+	log.Debug().Str("security_profile", name).Bool("apparmor", opts.AppArmor).Msg("Using security profile")
+	return args, nil
+}
+
 func (cm *ContainerManager) CreateContainer(ctx context.Context, image string, workdir string, envVars []string) (string, error) {
+	return cm.CreateContainerWithOptions(ctx, image, workdir, envVars, ContainerOptions{})
+}
+
+func (cm *ContainerManager) CreateContainerWithOptions(ctx context.Context, image string, workdir string, envVars []string, opts ContainerOptions) (string, error) {
 	log := gologger.WithComponent("docker.container")
 
+	memoryLimit := cm.memoryLimit
+	if opts.Memory != "" {
+		memoryLimit = opts.Memory
+	}
+
 	createArgs := []string{
 		"create",
-		"--memory", cm.memoryLimit,
+		"--memory", memoryLimit,
 		"--cpus", cm.cpuLimit,
 		"--workdir", workdir,
-		"--security-opt", "no-new-privileges", // Prevent privilege escalation
 	}
-
-	if cm.seccompProfile == "" {
-		return "", fmt.Errorf("missing required seccomp profile")
+	createArgs = append(createArgs, securityArgs(opts)...)
+	if cm.cpuset != "" {
+		createArgs = append(createArgs, "--cpuset-cpus", cm.cpuset)
 	}
-
-	if _, err := os.Stat(cm.seccompProfile); os.IsNotExist(err) {
-		log.Error().Str("path", cm.seccompProfile).Msg("Seccomp profile file does not exist")
-		return "", fmt.Errorf("seccomp profile file not found: %w", err)
+	if cm.restartPolicy != "" {
+		createArgs = append(createArgs, "--restart", cm.restartPolicy)
 	}
 
-	createArgs = append(createArgs, "--security-opt", "seccomp="+cm.seccompProfile)
-	log.Debug().Str("seccomp_profile", cm.seccompProfile).Msg("Using seccomp profile")
+	profileArgs, err := cm.profileArgs(opts)
+	if err != nil {
+		return "", err
+	}
+	createArgs = append(createArgs, profileArgs...)
 
 	for _, env := range envVars {
 		createArgs = append(createArgs, "-e", env)
 	}
 
+	for _, mount := range opts.Mounts {
+		spec := fmt.Sprintf("type=bind,source=%s,target=%s", mount.Source, mount.Target)
+		if mount.ReadOnly {
+			spec += ",readonly"
+		}
+		createArgs = append(createArgs, "--mount", spec)
+	}
+
+	for key, value := range opts.Labels {
+		createArgs = append(createArgs, "--label", key+"="+value)
+	}
+
+	if opts.GPUDevice != "" {
+		gpus := "device=" + opts.GPUDevice
+		if strings.Contains(opts.GPUDevice, ",") {
+			// Docker reads --gpus as CSV, so a list of devices is quoted
+			gpus = `"` + gpus + `"`
+		}
+		createArgs = append(createArgs, "--gpus", gpus)
+	}
+
+	if opts.NetworkName != "" {
+		createArgs = append(createArgs, "--network", opts.NetworkName)
+	}
+	createArgs = append(createArgs, networkArgs(opts.Network)...)
+	for _, port := range opts.Ports {
+		createArgs = append(createArgs, "--publish", port.String())
+	}
+
+	commandOpts, commandArgs := TaskCommand{Entrypoint: opts.Entrypoint, Command: opts.Command}.createArgs()
+	createArgs = append(createArgs, commandOpts...)
 	createArgs = append(createArgs, image)
+	createArgs = append(createArgs, commandArgs...)
 
 	output, err := executils.ExecCommand(ctx, "docker", createArgs...)
 	if err != nil {
@@ -196,6 +279,42 @@ func (cm *ContainerManager) StartContainer(ctx context.Context, containerID stri
 	return nil
 }
 
+// SignalContainer sends signal, such as "SIGUSR1", to the container's main
+// process
+func (cm *ContainerManager) SignalContainer(ctx context.Context, containerID, signal string) error {
+	if _, err := executils.ExecCommand(ctx, "docker", "kill", "--signal", signal, containerID); err != nil {
+		return fmt.Errorf("container signal failed: %w", err)
+	}
+	return nil
+}
+
+// TerminateContainer sends StopSignal to the container's main process and,
+// if the container is still running after grace, SIGKILL. Unlike docker stop
+// the first signal is StopSignal whatever STOPSIGNAL the image declares, as
+// task containers are promised it.
+func (cm *ContainerManager) TerminateContainer(ctx context.Context, containerID string, grace time.Duration) error {
+	log := gologger.WithComponent("docker.container")
+
+	if err := cm.SignalContainer(ctx, containerID, StopSignal); err != nil {
+		return err
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, grace)
+	defer cancel()
+	if _, err := executils.ExecCommand(waitCtx, "docker", "wait", containerID); err == nil {
+		log.Info().Str("container", containerID).Msg("Container stopped gracefully")
+		return nil
+	} else if waitCtx.Err() == nil {
+		return fmt.Errorf("container wait failed: %w", err)
+	}
+
+	log.Warn().
+		Str("container", containerID).
+		Dur("grace", grace).
+		Msg("Container still running after its grace period, killing it")
+	return cm.SignalContainer(ctx, containerID, "SIGKILL")
+}
+
 func (cm *ContainerManager) StopContainer(ctx context.Context, containerID string, timeout time.Duration) error {
 	log := gologger.WithComponent("docker.container")
 
@@ -213,48 +332,56 @@ func (cm *ContainerManager) StopContainer(ctx context.Context, containerID strin
 }
 
 func (cm *ContainerManager) WaitForContainer(ctx context.Context, containerID string) (int, error) {
-	log := gologger.WithComponent("docker.container")
+	return cm.WaitForContainerOrDetach(ctx, containerID, nil)
+}
+
+// WaitForContainerOrDetach waits like WaitForContainer, but returns
+// ErrDetached without stopping the container once detach is closed
+func (cm *ContainerManager) WaitForContainerOrDetach(ctx context.Context, containerID string, detach <-chan struct{}) (int, error) {
+	return cm.waitForContainer(ctx, containerID, detach, nil)
+}
+
+// waitForContainer waits like WaitForContainerOrDetach, going on waiting
+// on a container that survives a restart of the Docker daemon. timeout, the
+// execution timeout ending ctx, is paused while the daemon is away.
+func (cm *ContainerManager) waitForContainer(ctx context.Context, containerID string, detach <-chan struct{}, timeout *execTimeout) (int, error) {
+	for reattaches := 0; ; reattaches++ {
+		exitCode, err := cm.waitOnce(ctx, containerID, detach)
+		if err == nil || errors.Is(err, ErrDetached) || ctx.Err() != nil || reattaches == maxReattaches {
+			return exitCode, err
+		}
+		again, exitCode, err := cm.recoverWait(ctx, containerID, err, timeout)
+		if ctx.Err() != nil {
+			return cm.stopCancelled(ctx, containerID)
+		}
+		if !again {
+			return exitCode, err
+		}
+	}
+}
 
+func (cm *ContainerManager) waitOnce(ctx context.Context, containerID string, detach <-chan struct{}) (int, error) {
 	exitCodeChan := make(chan int, 1)
 	errChan := make(chan error, 1)
 
-	go func() {
-		waitOutput, err := executils.ExecCommand(ctx, "docker", "wait", containerID)
-		if err != nil {
-			errChan <- fmt.Errorf("container wait failed: %w", err)
-			return
-		}
+	waitCtx, cancelWait := context.WithCancel(ctx)
+	defer cancelWait()
 
-		exitCode, err := strconv.Atoi(strings.TrimSpace(string(waitOutput)))
+	go func() {
+		exitCode, err := cm.daemon.Wait(waitCtx, containerID)
 		if err != nil {
-			errChan <- fmt.Errorf("failed to parse exit code: %w", err)
+			errChan <- err
 			return
 		}
-
 		exitCodeChan <- exitCode
 	}()
 
 	select {
-	case <-ctx.Done():
-		log.Info().
-			Str("container", containerID).
-			Msg("Context cancelled, attempting graceful shutdown")
-
-		stopCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		if err := cm.StopContainer(stopCtx, containerID, 9*time.Second); err != nil {
-			log.Warn().
-				Err(err).
-				Str("container", containerID).
-				Msg("Graceful shutdown failed, container will be killed")
-		} else {
-			log.Info().
-				Str("container", containerID).
-				Msg("Container stopped gracefully")
-		}
+	case <-detach:
+		return -1, ErrDetached
 
-		return -1, ctx.Err()
+	case <-ctx.Done():
+		return cm.stopCancelled(ctx, containerID)
 
 	case err := <-errChan:
 		return -1, err
@@ -264,6 +391,27 @@ func (cm *ContainerManager) WaitForContainer(ctx context.Context, containerID st
 	}
 }
 
+// stopCancelled stops the container of a task whose ctx ended
+func (cm *ContainerManager) stopCancelled(ctx context.Context, containerID string) (int, error) {
+	log := gologger.WithComponent("docker.container")
+	log.Info().
+		Str("container", containerID).
+		Dur("grace", cm.stopGrace).
+		Msg("Context cancelled, attempting graceful shutdown")
+
+	stopCtx, cancel := context.WithTimeout(context.Background(), cm.stopGrace+10*time.Second)
+	defer cancel()
+
+	if err := cm.TerminateContainer(stopCtx, containerID, cm.stopGrace); err != nil {
+		log.Warn().
+			Err(err).
+			Str("container", containerID).
+			Msg("Graceful shutdown failed")
+	}
+
+	return -1, ctx.Err()
+}
+
 func (cm *ContainerManager) GetContainerLogs(ctx context.Context, containerID string) (string, error) {
 	log := gologger.WithComponent("docker.container")
 
@@ -276,6 +424,30 @@ func (cm *ContainerManager) GetContainerLogs(ctx context.Context, containerID st
 	return formatContainerOutput(logs), nil
 }
 
+// FollowContainerLogs copies the container's output to w as it is produced,
+// until the container exits or ctx ends. Should the Docker daemon restart,
+// the stream is picked up again from when it broke off once the daemon
+// answers, which may repeat a few lines.
+func (cm *ContainerManager) FollowContainerLogs(ctx context.Context, containerID string, w io.Writer) error {
+	var since time.Time
+	for reattaches := 0; ; reattaches++ {
+		err := cm.daemon.FollowLogs(ctx, containerID, since, w)
+		if err == nil || ctx.Err() != nil {
+			return nil
+		}
+		if reattaches == maxReattaches {
+			return fmt.Errorf("log follow failed: %w", err)
+		}
+		since = cm.clock.Now()
+		if _, _, rerr := cm.reconnect(ctx, containerID); rerr != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("log follow failed: %w", err)
+		}
+	}
+}
+
 func (cm *ContainerManager) RemoveContainer(ctx context.Context, containerID string) error {
 	log := gologger.WithComponent("docker.container")
 
@@ -338,7 +510,7 @@ func (cm *ContainerManager) preVerifyContainer(ctx context.Context, containerID
 		}
 
 		sleepTime := time.Duration(100+i*50) * time.Millisecond
-		time.Sleep(sleepTime)
+		cm.clock.Sleep(sleepTime)
 	}
 
 	// final check before giving up
@@ -362,7 +534,7 @@ func (cm *ContainerManager) preVerifyContainer(ctx context.Context, containerID
 func (cm *ContainerManager) validateSeccompProfile(containerID string) (bool, string, error) {
 	log := gologger.WithComponent("docker.container")
 
-	if cm.seccompProfile == "" {
+	if len(cm.seccompProfiles) == 0 {
 		log.Error().Str("container", containerID).Msg("Container running without seccomp profile")
 		return false, "Missing required seccomp profile", fmt.Errorf("missing seccomp profile")
 	}
@@ -405,7 +577,7 @@ func (cm *ContainerManager) waitForContainerRunning(ctx context.Context, contain
 			if retryDelay > maxDelay {
 				retryDelay = maxDelay // Cap the delay
 			}
-			time.Sleep(retryDelay)
+			cm.clock.Sleep(retryDelay)
 			continue
 		}
 
@@ -422,7 +594,7 @@ func (cm *ContainerManager) waitForContainerRunning(ctx context.Context, contain
 		log.Warn().Str("container", containerID).
 			Int("attempt", i+1).Int("max_retries", maxRetries).Dur("retry_delay", retryDelay).
 			Msg("Container not running yet, retrying")
-		time.Sleep(retryDelay)
+		cm.clock.Sleep(retryDelay)
 		retryDelay = retryDelay * 2 // Exponential backoff
 		if retryDelay > maxDelay {
 			retryDelay = maxDelay // Cap the delay
@@ -498,7 +670,7 @@ func (cm *ContainerManager) TestSeccompProfile(ctx context.Context, containerID
 
 	log.Debug().
 		Str("container", containerID).
-		Str("seccomp_profile", cm.seccompProfile).
+		Int("seccomp_profiles", len(cm.seccompProfiles)).
 		Int("max_retries", maxRetries).
 		Dur("initial_delay", retryDelay).
 		Dur("max_delay", maxRetryDelay).
@@ -0,0 +1,333 @@
+package docker
+
+// A restart of the Docker daemon, as unattended upgrades do, cuts the
+// runner's waits on task containers and their log streams. Rather than fail
+// the tasks, the runner waits for the daemon to answer again, finds each
+// container again and goes on waiting on those that survived: those still
+// running or restarted by their restart policy, and those that exited on
+// their own under one. A container that is gone, or that exited without a
+// restart policy and so may have been stopped by the restart rather than
+// have finished, fails its task with ErrContainerLost. A task's execution
+// timeout does not run while the daemon is away.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+// ErrContainerLost is returned for a task container that did not survive a
+// restart of the Docker daemon
+var ErrContainerLost = errors.New("task container did not survive a Docker daemon restart")
+
+// errNoSuchContainer is returned by a daemon asked about a container it does
+// not have
+var errNoSuchContainer = errors.New("no such container")
+
+const (
+	// daemonRetryMin and daemonRetryMax bound the backoff between attempts
+	// to reach the daemon again
+	daemonRetryMin = 500 * time.Millisecond
+	daemonRetryMax = 10 * time.Second
+	// maxDaemonOutage is how long a task waits for the daemon to come back
+	maxDaemonOutage = 10 * time.Minute
+	// maxReattaches bounds how often a wait or log stream is resumed, so
+	// that one failing while the daemon answers is not retried forever
+	maxReattaches = 5
+	// daemonPause is the reason timelines give for the time a task spent
+	// waiting for the daemon
+	daemonPause = "docker_daemon"
+)
+
+// containerState is what the daemon reports of a container found again
+type containerState struct {
+	// Running is set for a running or restarting container
+	Running  bool
+	ExitCode int
+	// RestartPolicy is the name of the container's restart policy, "no"
+	// when it has none
+	RestartPolicy string
+}
+
+// restarts reports whether the container has a restart policy
+func (s containerState) restarts() bool {
+	return s.RestartPolicy != "" && s.RestartPolicy != "no"
+}
+
+// daemonClient is the part of the Docker daemon waiting on task containers
+// uses
+type daemonClient interface {
+	// Ping returns an error while the daemon does not answer
+	Ping(ctx context.Context) error
+	// Wait returns the exit code of the container once it exits
+	Wait(ctx context.Context, containerID string) (int, error)
+	// Inspect returns errNoSuchContainer for a container the daemon does
+	// not have
+	Inspect(ctx context.Context, containerID string) (containerState, error)
+	// FollowLogs copies the container's output from since, or from its
+	// start when since is zero, to w until it exits
+	FollowLogs(ctx context.Context, containerID string, since time.Time, w io.Writer) error
+}
+
+// cliDaemon reaches the daemon through the docker CLI
+type cliDaemon struct{}
+
+func (cliDaemon) Ping(ctx context.Context) error {
+	_, err := executils.ExecCommand(ctx, "docker", "version", "--format", "{{.Server.Version}}")
+	return err
+}
+
+func (cliDaemon) Wait(ctx context.Context, containerID string) (int, error) {
+	out, err := executils.ExecCommand(ctx, "docker", "wait", containerID)
+	if err != nil {
+		return -1, fmt.Errorf("container wait failed: %w", err)
+	}
+	exitCode, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil {
+		return -1, fmt.Errorf("failed to parse exit code: %w", err)
+	}
+	return exitCode, nil
+}
+
+func (cliDaemon) Inspect(ctx context.Context, containerID string) (containerState, error) {
+	out, err := executils.ExecCommand(ctx, "docker", "inspect", "--type", "container", "--format",
+		"{{.State.Running}} {{.State.Restarting}} {{.State.ExitCode}} {{.HostConfig.RestartPolicy.Name}}", containerID)
+	if err != nil {
+		if strings.Contains(string(out), "No such") {
+			return containerState{}, errNoSuchContainer
+		}
+		return containerState{}, fmt.Errorf("container inspect failed: %w", err)
+	}
+	fields := strings.Fields(string(out))
+	if len(fields) < 3 {
+		return containerState{}, fmt.Errorf("unexpected container state %q", strings.TrimSpace(string(out)))
+	}
+	exitCode, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return containerState{}, fmt.Errorf("failed to parse exit code: %w", err)
+	}
+	state := containerState{Running: fields[0] == "true" || fields[1] == "true", ExitCode: exitCode, RestartPolicy: "no"}
+	if len(fields) > 3 {
+		state.RestartPolicy = fields[3]
+	}
+	return state, nil
+}
+
+func (cliDaemon) FollowLogs(ctx context.Context, containerID string, since time.Time, w io.Writer) error {
+	args := []string{"logs", "--follow"}
+	if !since.IsZero() {
+		args = append(args, "--since", since.Format(time.RFC3339Nano))
+	}
+	cmd := exec.CommandContext(ctx, "docker", append(args, containerID)...)
+	cmd.Stdout = w
+	cmd.Stderr = w
+	return cmd.Run()
+}
+
+// ParseRestartPolicy checks policy, a docker restart policy given to task
+// containers: no, on-failure[:max-retries], always or unless-stopped. Empty
+// is no.
+func ParseRestartPolicy(policy string) (string, error) {
+	name, retries, hasRetries := strings.Cut(policy, ":")
+	switch name {
+	case "":
+		return "", nil
+	case "no", "always", "unless-stopped":
+		if !hasRetries {
+			return policy, nil
+		}
+	case "on-failure":
+		if n, err := strconv.Atoi(retries); !hasRetries || (err == nil && n > 0) {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("invalid restart policy %q: want no, on-failure[:max-retries], always or unless-stopped", policy)
+}
+
+// SetRestartPolicy gives task containers policy, as ParseRestartPolicy
+// accepts it. Only containers with a restart policy survive a restart of
+// the daemon once they have exited.
+func (e *DockerExecutor) SetRestartPolicy(policy string) {
+	e.containerMgr.restartPolicy = policy
+}
+
+// reconnect waits, backing off, for the daemon to answer again, until ctx
+// ends or maxDaemonOutage passes, and returns the state of containerID
+// once it does along with how long the daemon was away
+func (cm *ContainerManager) reconnect(ctx context.Context, containerID string) (containerState, time.Duration, error) {
+	start := cm.clock.Now()
+	delay := daemonRetryMin
+	for {
+		err := cm.daemon.Ping(ctx)
+		if err == nil {
+			break
+		}
+		if outage := clock.Since(cm.clock, start); outage >= maxDaemonOutage {
+			return containerState{}, outage, fmt.Errorf("docker daemon unreachable for %s: %w", outage.Round(time.Second), err)
+		}
+		select {
+		case <-ctx.Done():
+			return containerState{}, clock.Since(cm.clock, start), ctx.Err()
+		case <-cm.clock.After(delay):
+		}
+		delay = min(2*delay, daemonRetryMax)
+	}
+	outage := clock.Since(cm.clock, start)
+	state, err := cm.daemon.Inspect(ctx, containerID)
+	return state, outage, err
+}
+
+// recoverWait finds out, after a wait on containerID failed with waitErr,
+// whether the container survived. It returns whether to wait on it again,
+// or else its exit code or why it did not survive.
+func (cm *ContainerManager) recoverWait(ctx context.Context, containerID string, waitErr error, timeout *execTimeout) (bool, int, error) {
+	log := gologger.WithComponent("docker.container")
+	log.Warn().Err(waitErr).Str("container", containerID).Msg("Lost the wait on a task container, checking the Docker daemon")
+
+	timeout.pause()
+	state, outage, err := cm.reconnect(ctx, containerID)
+	timeout.resume()
+	switch {
+	case errors.Is(err, errNoSuchContainer):
+		return false, -1, fmt.Errorf("%w: container %s is gone", ErrContainerLost, containerID)
+	case err != nil:
+		return false, -1, fmt.Errorf("%w (reconnecting: %v)", waitErr, err)
+	case state.Running:
+		log.Warn().
+			Str("container", containerID).
+			Dur("outage", outage).
+			Str("restart_policy", state.RestartPolicy).
+			Msg("Reattached to task container after losing the Docker daemon")
+		timeout.reattach(outage)
+		return true, 0, nil
+	case state.restarts():
+		log.Info().
+			Str("container", containerID).
+			Int("exit_code", state.ExitCode).
+			Msg("Task container exited while the Docker daemon was away")
+		return false, state.ExitCode, nil
+	default:
+		return false, -1, fmt.Errorf("%w: container %s exited with code %d and has no restart policy", ErrContainerLost, containerID, state.ExitCode)
+	}
+}
+
+// execTimeout ends its context once a task has run for its execution
+// timeout, not counting the time the Docker daemon was away. A nil
+// execTimeout does nothing.
+type execTimeout struct {
+	clock  clock.Clock
+	cancel context.CancelCauseFunc
+
+	mu        sync.Mutex
+	remaining time.Duration
+	started   time.Time
+	timer     clock.Timer
+	// stop is closed when the timer is stopped, nil while paused
+	stop chan struct{}
+	// reattached is called with the length of each outage a wait survived
+	reattached func(outage time.Duration)
+}
+
+// withExecTimeout returns ctx ended with the cause context.DeadlineExceeded
+// once timeout has passed, and the timeout
+func withExecTimeout(ctx context.Context, clk clock.Clock, timeout time.Duration) (context.Context, *execTimeout) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	t := &execTimeout{clock: clk, cancel: cancel, remaining: timeout}
+	t.start()
+	return ctx, t
+}
+
+func (t *execTimeout) start() {
+	t.started = t.clock.Now()
+	t.stop = make(chan struct{})
+	t.timer = t.clock.NewTimer(t.remaining)
+	go func(timer clock.Timer, stop chan struct{}) {
+		select {
+		case <-timer.C():
+		case <-stop:
+			return
+		}
+		// The timer may have fired as it was paused
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		select {
+		case <-stop:
+		default:
+			t.cancel(context.DeadlineExceeded)
+		}
+	}(t.timer, t.stop)
+}
+
+// pause stops the timeout while the daemon is away
+func (t *execTimeout) pause() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stop == nil {
+		return
+	}
+	t.timer.Stop()
+	close(t.stop)
+	t.stop = nil
+	t.remaining -= clock.Since(t.clock, t.started)
+}
+
+// resume starts the timeout again after an outage
+func (t *execTimeout) resume() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stop != nil {
+		return
+	}
+	t.start()
+}
+
+func (t *execTimeout) reattach(outage time.Duration) {
+	if t != nil && t.reattached != nil {
+		t.reattached(outage)
+	}
+}
+
+// release stops the timeout and ends its context
+func (t *execTimeout) release() {
+	t.pause()
+	t.cancel(context.Canceled)
+}
+
+// warnDaemonRestart tells the server that the daemon restarted under task,
+// whose container survived an outage of outage
+func (e *DockerExecutor) warnDaemonRestart(task *models.Task, containerID string, outage time.Duration) {
+	if e.warnings == nil {
+		return
+	}
+	warning := &models.TaskWarning{
+		Code:    models.TaskWarningDaemonRestart,
+		Message: fmt.Sprintf("docker daemon was unreachable for %s; reattached to container %s", outage.Round(time.Millisecond), containerID),
+		Time:    e.containerMgr.clock.Now(),
+	}
+	go func() {
+		if err := e.warnings.SendTaskWarning(task.ID.String(), warning); err != nil {
+			log := gologger.WithComponent("docker")
+			log.Warn().Err(err).
+				Str("task_id", task.ID.String()).
+				Msg("Failed to send daemon restart warning")
+		}
+	}()
+}
@@ -0,0 +1,269 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+)
+
+// fakeDaemon runs a single container. Its waits and log streams break off
+// when it restarts, and it does not answer while it is down.
+type fakeDaemon struct {
+	mu       sync.Mutex
+	up       bool
+	restarts chan struct{}
+	exited   chan struct{}
+	exitCode int
+	state    containerState
+	gone     bool
+	sinces   []time.Time
+
+	pings   chan struct{}
+	waits   chan struct{}
+	follows chan struct{}
+}
+
+func newFakeDaemon() *fakeDaemon {
+	return &fakeDaemon{
+		up:       true,
+		restarts: make(chan struct{}),
+		exited:   make(chan struct{}),
+		state:    containerState{Running: true, RestartPolicy: "no"},
+		pings:    make(chan struct{}, 16),
+		waits:    make(chan struct{}, 16),
+		follows:  make(chan struct{}, 16),
+	}
+}
+
+// restart breaks off every wait and log stream, leaving the daemon down
+// until answer is called unless back is set
+func (d *fakeDaemon) restart(back bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	close(d.restarts)
+	d.restarts = make(chan struct{})
+	d.up = back
+}
+
+// answer brings the daemon back with the container in state, or without it
+func (d *fakeDaemon) answer(state containerState, gone bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.up, d.state, d.gone = true, state, gone
+}
+
+func (d *fakeDaemon) exit(code int) {
+	d.mu.Lock()
+	d.exitCode = code
+	d.mu.Unlock()
+	close(d.exited)
+}
+
+func (d *fakeDaemon) await(ctx context.Context) error {
+	d.mu.Lock()
+	up, restarts := d.up, d.restarts
+	d.mu.Unlock()
+	if !up {
+		return errors.New("cannot connect to the Docker daemon")
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-restarts:
+		return errors.New("unexpected EOF")
+	case <-d.exited:
+		return nil
+	}
+}
+
+func (d *fakeDaemon) Ping(ctx context.Context) error {
+	d.mu.Lock()
+	up := d.up
+	d.mu.Unlock()
+	d.pings <- struct{}{}
+	if !up {
+		return errors.New("cannot connect to the Docker daemon")
+	}
+	return nil
+}
+
+func (d *fakeDaemon) Wait(ctx context.Context, containerID string) (int, error) {
+	d.waits <- struct{}{}
+	if err := d.await(ctx); err != nil {
+		return -1, fmt.Errorf("container wait failed: %w", err)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.exitCode, nil
+}
+
+func (d *fakeDaemon) Inspect(ctx context.Context, containerID string) (containerState, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.gone {
+		return containerState{}, errNoSuchContainer
+	}
+	return d.state, nil
+}
+
+func (d *fakeDaemon) FollowLogs(ctx context.Context, containerID string, since time.Time, w io.Writer) error {
+	d.mu.Lock()
+	d.sinces = append(d.sinces, since)
+	fmt.Fprintf(w, "line %d\n", len(d.sinces))
+	d.mu.Unlock()
+	d.follows <- struct{}{}
+	return d.await(ctx)
+}
+
+func newDaemonManager(d *fakeDaemon, clk *clocktest.Fake) *ContainerManager {
+	return &ContainerManager{clock: clk, stopGrace: DefaultStopGracePeriod, daemon: d}
+}
+
+func TestWaitReattachesAcrossDaemonRestart(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC))
+	d := newFakeDaemon()
+	cm := newDaemonManager(d, clk)
+	ctx, timeout := withExecTimeout(context.Background(), clk, time.Minute)
+	defer timeout.release()
+	var outages []time.Duration
+	timeout.reattached = func(outage time.Duration) { outages = append(outages, outage) }
+
+	type waited struct {
+		code int
+		err  error
+	}
+	done := make(chan waited, 1)
+	go func() {
+		code, err := cm.waitForContainer(ctx, "task-1", nil, timeout)
+		done <- waited{code, err}
+	}()
+	<-d.waits
+	clk.Advance(40 * time.Second)
+
+	d.restart(false)
+	<-d.pings
+	clk.BlockUntil(1)
+	clk.Advance(5 * time.Minute)
+	<-d.pings
+	d.answer(containerState{Running: true, RestartPolicy: "unless-stopped"}, false)
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	<-d.pings
+	<-d.waits
+
+	// The outage did not count towards the timeout's minute
+	clk.Advance(19 * time.Second)
+	if ctx.Err() != nil {
+		t.Fatal("execution timed out counting the outage")
+	}
+	d.exit(0)
+	got := <-done
+	if got.code != 0 || got.err != nil {
+		t.Fatalf("waitForContainer() = %d, %v, want 0, nil", got.code, got.err)
+	}
+	if len(outages) != 1 || outages[0] != 5*time.Minute+time.Second {
+		t.Errorf("reattached after outages %v, want one of 5m1s", outages)
+	}
+}
+
+func TestWaitAfterDaemonRestart(t *testing.T) {
+	for name, tt := range map[string]struct {
+		state    containerState
+		gone     bool
+		wantCode int
+		wantLost bool
+	}{
+		"gone":                    {gone: true, wantCode: -1, wantLost: true},
+		"exited without a policy": {state: containerState{ExitCode: 137, RestartPolicy: "no"}, wantCode: -1, wantLost: true},
+		"exited under a policy":   {state: containerState{ExitCode: 3, RestartPolicy: "on-failure"}, wantCode: 3},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d := newFakeDaemon()
+			cm := newDaemonManager(d, clocktest.NewFake(time.Now()))
+			done := make(chan error, 1)
+			var code int
+			go func() {
+				var err error
+				code, err = cm.WaitForContainerOrDetach(context.Background(), "task-1", nil)
+				done <- err
+			}()
+			<-d.waits
+			d.answer(tt.state, tt.gone)
+			d.restart(true)
+
+			err := <-done
+			if errors.Is(err, ErrContainerLost) != tt.wantLost || code != tt.wantCode {
+				t.Fatalf("WaitForContainerOrDetach() = %d, %v, want %d, lost %v", code, err, tt.wantCode, tt.wantLost)
+			}
+			if !tt.wantLost && err != nil {
+				t.Fatalf("WaitForContainerOrDetach() error = %v", err)
+			}
+		})
+	}
+}
+
+func TestLogsFollowedAcrossDaemonRestart(t *testing.T) {
+	start := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	clk := clocktest.NewFake(start)
+	d := newFakeDaemon()
+	cm := newDaemonManager(d, clk)
+
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- cm.FollowContainerLogs(context.Background(), "task-1", &out) }()
+	<-d.follows
+	clk.Advance(3 * time.Second)
+	d.restart(true)
+	<-d.follows
+	d.exit(0)
+
+	if err := <-done; err != nil {
+		t.Fatalf("FollowContainerLogs() error = %v", err)
+	}
+	if out.String() != "line 1\nline 2\n" {
+		t.Errorf("output = %q", out.String())
+	}
+	if len(d.sinces) != 2 || !d.sinces[0].IsZero() || !d.sinces[1].Equal(start.Add(3*time.Second)) {
+		t.Errorf("followed since %v, want from the start then from the restart", d.sinces)
+	}
+}
+
+func TestExecTimeoutSkipsPauses(t *testing.T) {
+	clk := clocktest.NewFake(time.Now())
+	ctx, timeout := withExecTimeout(context.Background(), clk, 10*time.Second)
+	defer timeout.release()
+
+	clk.Advance(6 * time.Second)
+	timeout.pause()
+	clk.Advance(time.Hour)
+	timeout.resume()
+	clk.Advance(3 * time.Second)
+	if ctx.Err() != nil {
+		t.Fatal("timed out while paused")
+	}
+	clk.Advance(time.Second)
+	<-ctx.Done()
+	if !errors.Is(context.Cause(ctx), context.DeadlineExceeded) {
+		t.Errorf("cause = %v, want context.DeadlineExceeded", context.Cause(ctx))
+	}
+}
+
+func TestParseRestartPolicy(t *testing.T) {
+	for _, policy := range []string{"", "no", "always", "unless-stopped", "on-failure", "on-failure:3"} {
+		if got, err := ParseRestartPolicy(policy); err != nil || got != policy {
+			t.Errorf("ParseRestartPolicy(%q) = %q, %v", policy, got, err)
+		}
+	}
+	for _, policy := range []string{"sometimes", "always:2", "on-failure:0", "on-failure:x"} {
+		if _, err := ParseRestartPolicy(policy); err == nil {
+			t.Errorf("ParseRestartPolicy(%q) accepted", policy)
+		}
+	}
+}
@@ -2,22 +2,64 @@ package docker
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/theblitlabs/gologger"
 
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/blobcache"
+	"github.com/theblitlabs/parity-runner/internal/clock"
 	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/diskreserve"
+	"github.com/theblitlabs/parity-runner/internal/execution/outputs"
 	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/profiles"
+	"github.com/theblitlabs/parity-runner/internal/execution/tasklog"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+	"github.com/theblitlabs/parity-runner/internal/migration"
+	"github.com/theblitlabs/parity-runner/internal/registrymirror"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+	"github.com/theblitlabs/parity-runner/internal/timeline"
 	"github.com/theblitlabs/parity-runner/internal/utils"
 )
 
+// ContainerOutputDir is where task containers write files checked against an output manifest
+const ContainerOutputDir = "/parity/output"
+
 type DockerExecutor struct {
-	config       *ExecutorConfig
-	imageManager *ImageManager
-	containerMgr *ContainerManager
+	config        *ExecutorConfig
+	imageManager  *ImageManager
+	containerMgr  *ContainerManager
+	imageExporter *ImageExporter
+	previews      *outputs.PreviewOptions
+	preflighter   *Preflighter
+	inputs        *inputs.Manager
+	network       NetworkPolicy
+	security      profiles.Policy
+	memory        MemoryPolicy
+	stall         StallPolicy
+	warnings      WarningSink
+	progress      ProgressSink
+	checkpoints   artifacts.UploaderSource
+	detachMu      sync.Mutex
+	detach        chan struct{}
+	// users decides which user tasks run as, allocated by userAlloc
+	users      UserPolicy
+	userAlloc  userAllocator
+	usernsOnce sync.Once
+	userns     bool
+	// isolation is what the platform offers hermetic tasks; the host's
+	// when nil
+	isolation isolation
 }
 
 type ExecutorConfig struct {
@@ -52,15 +94,131 @@ func NewDockerExecutor(config *ExecutorConfig) (*DockerExecutor, error) {
 		config:       config,
 		imageManager: NewImageManager(),
 		containerMgr: containerMgr,
+		preflighter:  NewPreflighter(),
+		detach:       make(chan struct{}),
+		users:        UserPolicy{Root: RootDeny, UIDBase: DefaultUIDBase, UIDCount: DefaultUIDCount},
 	}, nil
 }
 
+// SetClock replaces the clock used for execution timing and container retries
+func (e *DockerExecutor) SetClock(c clock.Clock) {
+	e.containerMgr.SetClock(c)
+}
+
+// SetCPUSet pins task containers to cpuset, a Docker --cpuset-cpus value
+// such as "0-3" or "4,5"; empty lets them use every core
+func (e *DockerExecutor) SetCPUSet(cpuset string) {
+	e.containerMgr.cpuset = cpuset
+}
+
+// SetImageExporter enables tasks to return their image as an artifact
+func (e *DockerExecutor) SetImageExporter(exporter *ImageExporter) {
+	e.imageExporter = exporter
+}
+
+// SetArtifactPreviews describes the files tasks write to their output
+// directory in their results, previewed within opts
+func (e *DockerExecutor) SetArtifactPreviews(opts outputs.PreviewOptions) {
+	e.previews = &opts
+}
+
+// SetRegistryMirrors pulls task images through the registry mirrors of
+// pool, falling back to their registries
+func (e *DockerExecutor) SetRegistryMirrors(pool *registrymirror.Pool) {
+	e.imageManager.SetRegistryMirrors(pool)
+}
+
+// SetHTTPClient downloads the image tarballs tasks name with client
+func (e *DockerExecutor) SetHTTPClient(client *http.Client) {
+	e.imageManager.SetHTTPClient(client)
+}
+
+// SetBlobFetcher keeps the image tarballs tasks download in fetcher's
+// cache, shared with fleet peers
+func (e *DockerExecutor) SetBlobFetcher(fetcher *blobcache.Fetcher) {
+	e.imageManager.SetBlobFetcher(fetcher)
+}
+
+// EstimateImageSize estimates the disk making a task's image available
+// takes; see ImageManager.EstimateSize
+func (e *DockerExecutor) EstimateImageSize(ctx context.Context, imageName, imageURL string) (int64, error) {
+	return e.imageManager.EstimateSize(ctx, imageName, imageURL)
+}
+
+// SetInputManager enables tasks to declare inputs, downloaded through
+// manager and mounted into their containers
+func (e *DockerExecutor) SetInputManager(manager *inputs.Manager) {
+	e.inputs = manager
+}
+
+// SetNetworkPolicy replaces the policy tasks' network overrides are checked
+// against
+func (e *DockerExecutor) SetNetworkPolicy(policy NetworkPolicy) {
+	e.network = policy
+}
+
+// CheckNetwork checks a task's network overrides against the policy
+func (e *DockerExecutor) CheckNetwork(config *models.NetworkConfig) error {
+	return e.network.Check(config)
+}
+
+// SetSecurityPolicy replaces the policy deciding which security profiles
+// tasks may ask for and which they run under by default
+func (e *DockerExecutor) SetSecurityPolicy(policy profiles.Policy) {
+	e.security = policy
+}
+
+// CheckSecurityProfile checks that the security policy permits the profile
+// a task asks for, the default when name is empty
+func (e *DockerExecutor) CheckSecurityProfile(name string) error {
+	_, err := e.security.Resolve(name)
+	return err
+}
+
+// inputMounts binds each staged input at its target path, read-only unless
+// the input asks for a writable copy
+func inputMounts(set *inputs.Set) []Mount {
+	mounts := make([]Mount, 0, len(set.Staged))
+	for _, staged := range set.Staged {
+		mounts = append(mounts, Mount{
+			Source:   staged.HostPath,
+			Target:   staged.Target,
+			ReadOnly: staged.Input.ReadOnly(),
+		})
+	}
+	return mounts
+}
+
+// Preflight pulls the task's image if needed and checks that the task's
+// command can run in it, returning a *PreflightError if it may not
+func (e *DockerExecutor) Preflight(ctx context.Context, task *models.Task) error {
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if config.ImageName == "" {
+		return fmt.Errorf("image name required")
+	}
+
+	setupCtx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+
+	if err := e.imageManager.EnsureImageAvailable(setupCtx, config.ImageName, config.DockerImageURL, config.DockerImageDigest); err != nil {
+		return fmt.Errorf("image preparation failed: %w", err)
+	}
+	return e.preflighter.Check(setupCtx, e.imageManager.Local(config.ImageName), taskCommand(task.Environment))
+}
+
 func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
 	log := gologger.WithComponent("docker")
-	startTime := time.Now()
+	startTime := e.containerMgr.clock.Now()
 	result := models.NewTaskResult()
 	result.TaskID = task.ID
 
+	// detached leaves the container and its output directory in place for
+	// the runner process that adopts the task
+	var detached bool
+
 	log.Info().
 		Str("task_id", task.ID.String()).
 		Str("nonce", task.Nonce).
@@ -75,7 +233,7 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 	}
 
 	var config models.TaskConfig
-	if err := json.Unmarshal(task.Config, &config); err != nil {
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
 		log.Error().
 			Err(err).
 			Str("task_id", task.ID.String()).
@@ -91,15 +249,69 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 		return nil, fmt.Errorf("image name required")
 	}
 
+	if config.ExportImage != nil && e.imageExporter == nil {
+		log.Error().
+			Str("task_id", task.ID.String()).
+			Msg("Task requests an image export but exports are disabled on this runner")
+		return nil, fmt.Errorf("image export is not enabled on this runner")
+	}
+
+	if err := e.network.Check(config.Network); err != nil {
+		log.Error().
+			Err(err).
+			Str("task_id", task.ID.String()).
+			Msg("Task network overrides rejected")
+		return nil, fmt.Errorf("invalid network overrides: %w", err)
+	}
+	if config.Hermetic {
+		if err := e.CheckHermetic(&config, task.Environment); err != nil {
+			log.Error().
+				Err(err).
+				Str("task_id", task.ID.String()).
+				Msg("Hermetic task cannot run hermetically")
+			return nil, models.Failure(models.FailurePolicy, err)
+		}
+	}
+
+	profile, err := e.security.Resolve(config.SecurityProfile)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("task_id", task.ID.String()).
+			Msg("Task security profile rejected")
+		return nil, err
+	}
+	appArmor := profiles.LoadAppArmor(ctx, profile)
+	result.SecurityProfile = &models.AppliedSecurityProfile{Name: profile.Name, Seccomp: true, AppArmor: appArmor}
+
+	user, err := e.taskUser(ctx, config.RunAsRoot)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("task_id", task.ID.String()).
+			Msg("No user to run the task as")
+		return nil, err
+	}
+	defer func() {
+		if !detached {
+			e.userAlloc.release(user)
+		}
+	}()
+
 	log.Info().
 		Str("task_id", task.ID.String()).
 		Str("image", image).
+		Str("user", user.String()).
+		Bool("root", user.Root).
+		Str("security_profile", profile.Name).
 		Msg("Task configuration loaded")
 
 	setupCtx, setupCancel := context.WithTimeout(ctx, e.config.Timeout)
 	defer setupCancel()
 
-	if err := e.imageManager.EnsureImageAvailable(setupCtx, image, config.DockerImageURL); err != nil {
+	tl := timeline.From(ctx)
+	tl.Enter(models.PhaseImagePulling)
+	if err := e.imageManager.EnsureImageAvailable(setupCtx, image, config.DockerImageURL, config.DockerImageDigest); err != nil {
 		log.Error().
 			Err(err).
 			Str("task_id", task.ID.String()).
@@ -107,60 +319,170 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 			Msg("Failed to prepare Docker image")
 		return nil, fmt.Errorf("image preparation failed: %w", err)
 	}
+	image = e.imageManager.Local(image)
+	if reservation := diskreserve.From(ctx); reservation != nil {
+		reservation.Settle(diskreserve.PhaseImage, e.imageManager.LocalSize(ctx, image))
+	}
 
-	// Verify image hash
-	imageHashVerified, err := utils.VerifyImageHash(image)
+	if err := verifyTaskHashes(task, image, result); err != nil {
+		return nil, err
+	}
+
+	command := taskCommand(task.Environment)
+	workdir := command.WorkDir
+
+	envVars := []string{
+		fmt.Sprintf("TASK_NONCE=%s", task.Nonce),
+	}
+
+	if env, ok := task.Environment.Config["env"].([]interface{}); ok {
+		for _, v := range env {
+			if str, ok := v.(string); ok {
+				envVars = append(envVars, str)
+			}
+		}
+	}
+
+	limits, err := e.memoryLimits(config.Resources)
 	if err != nil {
 		log.Error().
 			Err(err).
 			Str("task_id", task.ID.String()).
-			Str("image", image).
-			Msg("Failed to verify image hash")
-		return nil, fmt.Errorf("image hash verification failed: %w", err)
+			Msg("Invalid memory limits")
+		return nil, err
+	}
+	if !config.Hermetic {
+		envVars = append(envVars, limits.env()...)
 	}
-	result.ImageHashVerified = imageHashVerified
 
-	// Verify command hash if task has command
-	var commandHashVerified string
-	if task.Environment != nil && task.Environment.Config != nil {
-		if cmd, ok := task.Environment.Config["command"].([]interface{}); ok {
-			commandSlice := make([]string, len(cmd))
-			for i, v := range cmd {
-				if str, ok := v.(string); ok {
-					commandSlice[i] = str
-				}
+	containerOpts := ContainerOptions{
+		Labels:          map[string]string{TaskIDLabel: task.ID.String()},
+		Network:         config.Network,
+		Capabilities:    e.users.Capabilities,
+		SecurityProfile: profile,
+		AppArmor:        appArmor,
+	}
+	if !user.Root {
+		containerOpts.User = user.String()
+		// The task user has no home in the image; tasks may set their own
+		envVars = append([]string{"HOME=/tmp"}, envVars...)
+	}
+	if config.Resources.Memory != "" {
+		containerOpts.Memory = strconv.FormatUint(limits.Hard, 10)
+	}
+	if device, ok := gpu.DeviceFromContext(ctx); ok {
+		containerOpts.GPUDevice = device
+	}
+	var outputDir string
+	if config.OutputManifest != nil {
+		var err error
+		outputDir, err = os.MkdirTemp("", retention.ScratchPattern("output", task.ID.String()))
+		if err != nil {
+			return nil, models.Failure(models.FailureScratch, fmt.Errorf("failed to create output directory: %w", err))
+		}
+		defer func() {
+			if !detached {
+				os.RemoveAll(outputDir)
 			}
-			commandHashVerified = utils.ComputeCommandHash(commandSlice)
-			result.CommandHashVerified = commandHashVerified
+		}()
+		if err := grantPath(outputDir, user); err != nil {
+			return nil, err
 		}
-	}
 
-	log.Info().
-		Str("task_id", task.ID.String()).
-		Str("image", image).
-		Str("image_hash_verified", imageHashVerified).
-		Str("command_hash_verified", commandHashVerified).
-		Msg("Hash verification completed")
+		containerOpts.Mounts = append(containerOpts.Mounts, Mount{Source: outputDir, Target: ContainerOutputDir})
+		envVars = append(envVars, fmt.Sprintf("PARITY_OUTPUT_DIR=%s", ContainerOutputDir))
+	}
 
-	workdir, ok := task.Environment.Config["workdir"].(string)
-	if !ok || workdir == "" {
-		workdir = "/"
-		log.Debug().
-			Str("task_id", task.ID.String()).
-			Str("workdir", workdir).
-			Msg("Using default working directory")
+	// The task is stopped at ctx's deadline, the task's own timeout capped
+	// by the runner. The executor's execution timeout only bounds tasks run
+	// without one.
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = startTime.Add(e.config.ExecutionTimeout)
+	}
+	lifecycleDir, fifo, err := newLifecycleDir(task.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if !detached {
+			os.RemoveAll(lifecycleDir)
+		}
+	}()
+	containerOpts.Mounts = append(containerOpts.Mounts, Mount{Source: lifecycleDir, Target: ContainerLifecycleDir})
+	lifecycle := lifecycleEnv(task, deadline, e.containerMgr.stopGrace, fifo)
+	if config.Hermetic {
+		// The deadline and grace period are facts about this runner, and
+		// a checkpoint is an input the task did not declare
+		lifecycle = hermeticEnv(lifecycle)
+		containerOpts.NetworkName = "none"
+	}
+	envVars = append(envVars, lifecycle...)
+	if bundle := migration.Resume(ctx); bundle != nil && bundle.Checkpoint != nil && !config.Hermetic {
+		resume, err := e.restoreCheckpoint(ctx, task.ID.String(), lifecycleDir, bundle.Checkpoint)
+		if err != nil {
+			log.Warn().Err(err).Str("task_id", task.ID.String()).Msg("Cannot restore checkpoint of migrated task - starting over")
+		} else {
+			envVars = append(envVars, "PARITY_RESUME_CHECKPOINT="+resume)
+		}
 	}
 
-	envVars := []string{
-		fmt.Sprintf("TASK_NONCE=%s", task.Nonce),
+	if config.Network != nil && config.Network.IPv6 {
+		networkName := TaskNetworkName(task.ID.String())
+		subnet := TaskSubnet(e.network.IPv6Prefix, task.ID.String())
+		if err := e.containerMgr.CreateTaskNetwork(setupCtx, networkName, task.ID.String(), subnet); err != nil {
+			log.Error().
+				Err(err).
+				Str("task_id", task.ID.String()).
+				Str("subnet", subnet.String()).
+				Msg("Failed to create IPv6 task network")
+			return nil, models.Failure(models.FailureContainerRuntime, err)
+		}
+		defer func() {
+			if detached {
+				return
+			}
+			if err := e.containerMgr.RemoveTaskNetwork(context.Background(), networkName); err != nil {
+				log.Error().
+					Err(err).
+					Str("task_id", task.ID.String()).
+					Str("network", networkName).
+					Msg("Failed to remove task network")
+			}
+		}()
+		containerOpts.NetworkName = networkName
 	}
 
-	if env, ok := task.Environment.Config["env"].([]interface{}); ok {
-		for _, v := range env {
-			if str, ok := v.(string); ok {
-				envVars = append(envVars, str)
+	var inputSet *inputs.Set
+	if len(config.Inputs) > 0 {
+		if e.inputs == nil {
+			return nil, fmt.Errorf("task inputs are not enabled on this runner")
+		}
+		var err error
+		tl.Enter(models.PhaseInputsFetching)
+		inputSet, err = e.inputs.Prepare(ctx, config.Inputs, models.TaskTypeDocker)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("task_id", task.ID.String()).
+				Msg("Failed to prepare task inputs")
+			return nil, fmt.Errorf("input preparation failed: %w", err)
+		}
+		defer func() {
+			if !detached {
+				inputSet.Close()
+			}
+		}()
+		tl.Enter(models.PhaseInputsReady)
+		diskreserve.From(ctx).Settle(diskreserve.PhaseInputs, inputSet.Size())
+		for _, staged := range inputSet.Staged {
+			if !staged.Input.ReadOnly() {
+				if err := grantPath(staged.HostPath, user); err != nil {
+					return nil, err
+				}
 			}
 		}
+		containerOpts.Mounts = append(containerOpts.Mounts, inputMounts(inputSet)...)
 	}
 
 	log.Debug().
@@ -168,19 +490,23 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 		Strs("env_vars", envVars).
 		Msg("Container environment variables set")
 
-	log.Debug().
-		Str("task_id", task.ID.String()).
-		Str("image", image).
-		Msg("Using default command from image")
+	containerOpts.Entrypoint = command.Entrypoint
+	containerOpts.Command = command.Command
+	if command.Entrypoint == nil && len(command.Command) == 0 {
+		log.Debug().
+			Str("task_id", task.ID.String()).
+			Str("image", image).
+			Msg("Using default command from image")
+	}
 
-	containerID, err := e.containerMgr.CreateContainer(setupCtx, image, workdir, envVars)
+	containerID, err := e.containerMgr.CreateContainerWithOptions(setupCtx, image, workdir, envVars, containerOpts)
 	if err != nil {
 		log.Error().
 			Err(err).
 			Str("task_id", task.ID.String()).
 			Str("image", image).
 			Msg("Failed to create container")
-		return nil, fmt.Errorf("container creation failed: %w", err)
+		return nil, models.Failure(models.FailureContainerRuntime, fmt.Errorf("container creation failed: %w", err))
 	}
 
 	log.Info().
@@ -189,6 +515,9 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 		Msg("Container created, attempting to start")
 
 	defer func() {
+		if detached {
+			return
+		}
 		if err := e.containerMgr.RemoveContainer(context.Background(), containerID); err != nil {
 			log.Error().
 				Err(err).
@@ -198,19 +527,37 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 		}
 	}()
 
+	tl.Enter(models.PhaseExecuting)
 	if err := e.containerMgr.StartContainer(setupCtx, containerID); err != nil {
 		log.Error().
 			Err(err).
 			Str("task_id", task.ID.String()).
 			Str("container_id", containerID).
 			Msg("Failed to start container")
-		return nil, fmt.Errorf("container start failed: %w", err)
+		return nil, models.Failure(models.FailureContainerRuntime, fmt.Errorf("container start failed: %w", err))
 	}
 
 	log.Info().
 		Str("task_id", task.ID.String()).
 		Str("container_id", containerID).
 		Msg("Container started successfully")
+
+	var audit *egressAudit
+	stopAudit := func() {}
+	if config.Hermetic {
+		audit, stopAudit = e.auditEgress(ctx, task, containerID)
+		defer stopAudit()
+	}
+
+	if limits.Soft > 0 {
+		if err := setMemoryHigh(setupCtx, containerID, limits.Soft); err != nil {
+			log.Warn().
+				Err(err).
+				Str("task_id", task.ID.String()).
+				Str("container_id", containerID).
+				Msg("Failed to set soft memory limit in the kernel, relying on signals")
+		}
+	}
 	securityCtx, securityCancel := context.WithTimeout(context.Background(), 120*time.Second)
 	defer securityCancel()
 
@@ -246,18 +593,61 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 		Str("security_status", securityMsg).
 		Msg("Container security verified successfully")
 
-	execCtx, execCancel := context.WithTimeout(ctx, e.config.ExecutionTimeout)
-	defer execCancel()
+	timeout := deadline.Sub(e.containerMgr.clock.Now())
+	result, err = e.awaitResult(ctx, task, &config, image, containerID, outputDir, lifecycleDir, startTime, timeout, result)
+	if errors.Is(err, ErrDetached) {
+		detached = true
+		return nil, &DetachedError{Container: &DetachedContainer{
+			ContainerID:  containerID,
+			OutputDir:    outputDir,
+			LifecycleDir: lifecycleDir,
+			Network:      containerOpts.NetworkName,
+			StartedAt:    startTime,
+		}}
+	}
+	if result != nil && inputSet != nil {
+		result.Inputs = inputSet.Resolved
+	}
+	if result != nil && config.Hermetic {
+		stopAudit()
+		result.Hermetic = hermeticAttestation(models.HermeticClosure{
+			ImageDigest: result.ImageHashVerified,
+			Image:       config.ImageName,
+			Inputs:      result.Inputs,
+			Entrypoint:  command.Entrypoint,
+			Command:     command.Command,
+			Env:         envVars,
+		}, audit)
+	}
+	return result, err
+}
+
+// awaitResult waits up to timeout for a started task container, relaying
+// the reports it writes to the progress pipe in lifecycleDir, and collects
+// its output, metrics and exports into result. It returns ErrDetached,
+// leaving the container running, if the executor is detached first.
+func (e *DockerExecutor) awaitResult(ctx context.Context, task *models.Task, config *models.TaskConfig, image, containerID, outputDir, lifecycleDir string, startTime time.Time, timeout time.Duration, result *models.TaskResult) (*models.TaskResult, error) {
+	log := gologger.WithComponent("docker")
+
+	execCtx, execTimer := withExecTimeout(ctx, e.containerMgr.clock, timeout)
+	defer execTimer.release()
+	execTimer.reattached = func(outage time.Duration) {
+		timeline.From(ctx).AddPause(daemonPause, outage)
+		e.warnDaemonRestart(task, containerID, outage)
+	}
 
 	log.Info().
 		Str("task_id", task.ID.String()).
 		Str("container_id", containerID).
-		Dur("timeout", e.config.ExecutionTimeout).
+		Dur("timeout", timeout).
 		Msg("Container running, execution timeout started")
 
-	var metrics *ResourceMonitor
-	metrics, err = NewResourceMetrics(containerID)
+	var pressure *memoryPressure
+	metrics, err := NewResourceMetrics(containerID)
 	if err == nil {
+		if limits, err := e.memoryLimits(config.Resources); err == nil {
+			pressure = e.watchMemory(task, containerID, limits, metrics)
+		}
 		if err := metrics.Start(execCtx); err != nil {
 			log.Error().
 				Err(err).
@@ -275,17 +665,63 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 			Msg("Failed to initialize metrics collector")
 	}
 
-	exitCode, err := e.containerMgr.WaitForContainer(execCtx, containerID)
+	// A stalled task is abandoned by ending execCtx with the evidence as its
+	// cause
+	go e.watchStall(execCtx, task, containerID, func(stalled *StallError) {
+		execTimer.cancel(stalled)
+	})
+
+	if w, ok := tasklog.Output(ctx); ok {
+		followed := make(chan struct{})
+		go func() {
+			defer close(followed)
+			if err := e.containerMgr.FollowContainerLogs(execCtx, containerID, w); err != nil {
+				log.Debug().Err(err).Str("container_id", containerID).Msg("Stopped following container logs")
+			}
+		}()
+		// The stream ends with the container; a detached container is left
+		// to run once the stream has had a moment to drain
+		defer func() {
+			select {
+			case <-followed:
+			case <-time.After(5 * time.Second):
+			}
+		}()
+	}
+
+	relay := e.startProgressRelay(task, lifecycleDir)
+
+	exitCode, err := e.containerMgr.waitForContainer(execCtx, containerID, e.detachSignal(), execTimer)
+	if errors.Is(err, ErrDetached) {
+		relay.abandon()
+		log.Info().
+			Str("task_id", task.ID.String()).
+			Str("container_id", containerID).
+			Msg("Detached from running container")
+		return nil, err
+	}
+	timeline.From(ctx).Enter(models.PhaseUploading)
 	var isGracefulTimeout bool
+	var stalled *StallError
 	if err != nil {
-		if execCtx.Err() == context.DeadlineExceeded {
+		if errors.Is(context.Cause(execCtx), context.DeadlineExceeded) {
 			log.Info().
 				Str("task_id", task.ID.String()).
 				Str("container_id", containerID).
-				Dur("timeout", e.config.ExecutionTimeout).
+				Dur("timeout", timeout).
 				Msg("Task execution timed out, container stopped gracefully")
-			result.Error = fmt.Sprintf("task execution exceeded timeout of %s and was gracefully stopped", e.config.ExecutionTimeout)
+			result.Error = fmt.Sprintf("timed out after %s; the container was stopped", timeout.Round(time.Second))
+			result.FailureCode = models.FailureTimeout
 			isGracefulTimeout = true
+		} else if errors.As(context.Cause(execCtx), &stalled) {
+			log.Warn().
+				Str("task_id", task.ID.String()).
+				Str("container_id", containerID).
+				Str("resource", stalled.Evidence.Resource).
+				Msg("Task abandoned after stalling, container stopped gracefully")
+			result.Error = stalled.Error()
+			result.Stall = stalled.Evidence
+			result.FailureCode = models.FailureStalled
 		} else {
 			log.Error().
 				Err(err).
@@ -304,6 +740,9 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 			Msg("Container execution completed")
 	}
 
+	// A task stopped on purpose still reports what it produced
+	stopped := isGracefulTimeout || stalled != nil
+
 	// Check for potential seccomp-related errors (exit code 255 often indicates a syscall was blocked)
 	if exitCode == 255 {
 		log.Warn().
@@ -318,6 +757,9 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 	cleanupCtx, cleanupCancel := context.WithTimeout(ctx, e.config.Timeout)
 	defer cleanupCancel()
 
+	relay.stop(cleanupCtx)
+	result.ChunkedArtifacts = relay.chunkedArtifacts()
+
 	logs, logsErr := e.containerMgr.GetContainerLogs(cleanupCtx, containerID)
 	if logsErr != nil {
 		log.Error().
@@ -325,8 +767,8 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 			Str("task_id", task.ID.String()).
 			Str("container_id", containerID).
 			Msg("Failed to fetch container logs")
-		if !isGracefulTimeout {
-			return result, fmt.Errorf("log fetch failed: %w", logsErr)
+		if !stopped {
+			return result, models.Failure(models.FailureContainerRuntime, fmt.Errorf("log fetch failed: %w", logsErr))
 		}
 	} else {
 		result.Output = fmt.Sprintf("NONCE: %s\n%s", task.Nonce, logs)
@@ -337,7 +779,7 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 				Str("container_id", containerID).
 				Str("nonce", task.Nonce).
 				Msg("Nonce verification failed")
-			if !isGracefulTimeout {
+			if !stopped {
 				return nil, fmt.Errorf("nonce verification failed: nonce not found in output")
 			}
 		} else {
@@ -356,8 +798,13 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 		result.MemoryGBHours = collectedMetrics.MemoryGBHours
 		result.StorageGB = collectedMetrics.StorageGB
 		result.NetworkDataGB = collectedMetrics.NetworkDataGB
+		if pressure != nil {
+			peak, above := pressure.usage()
+			result.PeakMemoryBytes = int64(peak)
+			result.MemoryAboveSoftSeconds = above.Seconds()
+		}
 
-		duration := time.Since(startTime).Round(time.Millisecond)
+		duration := clock.Since(e.containerMgr.clock, startTime).Round(time.Millisecond)
 		log.Info().
 			Str("task_id", task.ID.String()).
 			Str("container_id", containerID).
@@ -365,14 +812,60 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 			Str("duration", duration.String()).
 			Float64("cpu_seconds", result.CPUSeconds).
 			Float64("memory_gb_hours", result.MemoryGBHours).
+			Int64("peak_memory_bytes", result.PeakMemoryBytes).
 			Float64("storage_gb", result.StorageGB).
 			Float64("network_gb", result.NetworkDataGB).
 			Bool("timed_out", isGracefulTimeout).
 			Msg("Task execution completed")
 	}
 
-	if err != nil && !isGracefulTimeout {
-		return result, fmt.Errorf("container wait failed: %w", err)
+	if err != nil && !stopped {
+		return result, models.Failure(models.FailureContainerRuntime, fmt.Errorf("container wait failed: %w", err))
+	}
+
+	if config.OutputManifest != nil {
+		verdict, verifyErr := outputs.VerifyManifest(outputDir, config.OutputManifest)
+		if verifyErr != nil {
+			log.Error().
+				Err(verifyErr).
+				Str("task_id", task.ID.String()).
+				Msg("Failed to verify output manifest")
+			return result, fmt.Errorf("output manifest verification failed: %w", verifyErr)
+		}
+		result.OutputVerdict = verdict
+
+		log.Info().
+			Str("task_id", task.ID.String()).
+			Bool("passed", verdict.Passed).
+			Str("mode", string(verdict.Mode)).
+			Str("gating", string(verdict.Gating)).
+			Int("extra_files", len(verdict.ExtraFiles)).
+			Msg("Output manifest verified")
+	}
+
+	if outputDir != "" && e.previews != nil {
+		described, describeErr := outputs.DescribeArtifacts(outputDir, *e.previews)
+		if describeErr != nil {
+			log.Warn().
+				Err(describeErr).
+				Str("task_id", task.ID.String()).
+				Msg("Failed to describe output artifacts")
+		} else {
+			result.Artifacts = described
+		}
+	}
+
+	if config.ExportImage != nil && result.ExitCode == 0 && !stopped {
+		exported, exportErr := e.exportTaskImage(ctx, task, containerID, image, config.ExportImage)
+		if exportErr != nil {
+			log.Error().
+				Err(exportErr).
+				Str("task_id", task.ID.String()).
+				Msg("Failed to export task image")
+			return result, fmt.Errorf("image export failed: %w", exportErr)
+		}
+		result.ExportedImage = exported
+		result.ArtifactCIDs = append(result.ArtifactCIDs, exported.IndexCID)
 	}
 
 	// Compute result hash
@@ -389,3 +882,42 @@ func (e *DockerExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*m
 
 	return result, nil
 }
+
+// verifyTaskHashes records the verified image and command hashes of task in result
+func verifyTaskHashes(task *models.Task, image string, result *models.TaskResult) error {
+	log := gologger.WithComponent("docker")
+
+	imageHashVerified, err := utils.VerifyImageHash(image)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("task_id", task.ID.String()).
+			Str("image", image).
+			Msg("Failed to verify image hash")
+		return fmt.Errorf("image hash verification failed: %w", err)
+	}
+	result.ImageHashVerified = imageHashVerified
+
+	// Verify command hash if task has command
+	var commandHashVerified string
+	if task.Environment != nil && task.Environment.Config != nil {
+		if cmd, ok := task.Environment.Config["command"].([]interface{}); ok {
+			commandSlice := make([]string, len(cmd))
+			for i, v := range cmd {
+				if str, ok := v.(string); ok {
+					commandSlice[i] = str
+				}
+			}
+			commandHashVerified = utils.ComputeCommandHash(commandSlice)
+			result.CommandHashVerified = commandHashVerified
+		}
+	}
+
+	log.Info().
+		Str("task_id", task.ID.String()).
+		Str("image", image).
+		Str("image_hash_verified", imageHashVerified).
+		Str("command_hash_verified", commandHashVerified).
+		Msg("Hash verification completed")
+	return nil
+}
@@ -0,0 +1,209 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// TaskIDLabel marks task containers with the task they run, so a runner
+// process other than the one that started a container can find it
+const TaskIDLabel = "io.parity.task-id"
+
+// reattachMinTimeout is the least time a reattached container is given to
+// finish when its execution timeout has already elapsed during the handoff
+const reattachMinTimeout = 10 * time.Second
+
+// ErrDetached is returned when the executor stopped waiting for a task
+// container and left it running
+var ErrDetached = errors.New("detached from running task container")
+
+// DetachedContainer is a running task container left for another runner
+// process to adopt
+type DetachedContainer struct {
+	ContainerID  string    `json:"container_id"`
+	OutputDir    string    `json:"output_dir,omitempty"`
+	LifecycleDir string    `json:"lifecycle_dir,omitempty"`
+	Network      string    `json:"network,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+}
+
+// DetachedError is returned by ExecuteTask when the executor was detached
+// while the task container ran
+type DetachedError struct {
+	Container *DetachedContainer
+}
+
+func (e *DetachedError) Error() string {
+	return fmt.Sprintf("%s %s", ErrDetached, e.Container.ContainerID)
+}
+
+func (e *DetachedError) Unwrap() error { return ErrDetached }
+
+// Detach makes running and future ExecuteTask calls return a DetachedError
+// once their container is running, instead of waiting for it to exit
+func (e *DockerExecutor) Detach() {
+	e.detachMu.Lock()
+	defer e.detachMu.Unlock()
+	select {
+	case <-e.detach:
+	default:
+		close(e.detach)
+	}
+}
+
+// Attach undoes Detach, so tasks wait for their containers again
+func (e *DockerExecutor) Attach() {
+	e.detachMu.Lock()
+	defer e.detachMu.Unlock()
+	select {
+	case <-e.detach:
+		e.detach = make(chan struct{})
+	default:
+	}
+}
+
+func (e *DockerExecutor) detachSignal() <-chan struct{} {
+	e.detachMu.Lock()
+	defer e.detachMu.Unlock()
+	return e.detach
+}
+
+// FindTaskContainer returns the ID of the container labelled with taskID
+func (e *DockerExecutor) FindTaskContainer(ctx context.Context, taskID string) (string, error) {
+	out, err := executils.ExecCommand(ctx, "docker", "ps", "--all", "--quiet", "--no-trunc",
+		"--filter", "label="+TaskIDLabel+"="+taskID)
+	if err != nil {
+		return "", fmt.Errorf("failed to list task containers: %w", err)
+	}
+	ids := strings.Fields(string(out))
+	if len(ids) == 0 {
+		return "", fmt.Errorf("no container found for task %s", taskID)
+	}
+	return ids[0], nil
+}
+
+// TaskContainers maps the full IDs of running task containers to the tasks
+// they run
+func TaskContainers(ctx context.Context) (map[string]string, error) {
+	out, err := executils.ExecCommand(ctx, "docker", "ps", "--no-trunc",
+		"--filter", "label="+TaskIDLabel,
+		"--format", `{{.ID}} {{.Label "`+TaskIDLabel+`"}}`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list task containers: %w", err)
+	}
+	containers := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if id, task, ok := strings.Cut(strings.TrimSpace(line), " "); ok {
+			containers[id] = task
+		}
+	}
+	return containers, nil
+}
+
+// ReattachTask adopts a container detached by another runner process, waits
+// for it within what remains of its execution timeout and collects its
+// result as ExecuteTask would have
+func (e *DockerExecutor) ReattachTask(ctx context.Context, task *models.Task, detached *DetachedContainer) (*models.TaskResult, error) {
+	log := gologger.WithComponent("docker")
+
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	containerID, err := e.FindTaskContainer(ctx, task.ID.String())
+	if err != nil {
+		return nil, err
+	}
+	if detached.ContainerID != "" && !strings.HasPrefix(containerID, detached.ContainerID) {
+		return nil, fmt.Errorf("task container %s does not match handed off container %s", containerID, detached.ContainerID)
+	}
+
+	var keep bool
+	defer func() {
+		if keep {
+			return
+		}
+		if err := e.containerMgr.RemoveContainer(context.Background(), containerID); err != nil {
+			log.Error().
+				Err(err).
+				Str("task_id", task.ID.String()).
+				Str("container_id", containerID).
+				Msg("Failed to remove container")
+		}
+		if detached.OutputDir != "" {
+			os.RemoveAll(detached.OutputDir)
+		}
+		if detached.LifecycleDir != "" {
+			os.RemoveAll(detached.LifecycleDir)
+		}
+		if detached.Network != "" {
+			if err := e.containerMgr.RemoveTaskNetwork(context.Background(), detached.Network); err != nil {
+				log.Error().
+					Err(err).
+					Str("task_id", task.ID.String()).
+					Str("network", detached.Network).
+					Msg("Failed to remove task network")
+			}
+		}
+	}()
+
+	result := models.NewTaskResult()
+	result.TaskID = task.ID
+	if err := verifyTaskHashes(task, config.ImageName, result); err != nil {
+		return nil, err
+	}
+
+	remaining := e.config.ExecutionTimeout - clock.Since(e.containerMgr.clock, detached.StartedAt)
+	if remaining < reattachMinTimeout {
+		remaining = reattachMinTimeout
+	}
+
+	log.Info().
+		Str("task_id", task.ID.String()).
+		Str("container_id", containerID).
+		Dur("remaining", remaining).
+		Msg("Reattached to task container")
+
+	result, err = e.awaitResult(ctx, task, &config, config.ImageName, containerID, detached.OutputDir, detached.LifecycleDir, detached.StartedAt, remaining, result)
+	if errors.Is(err, ErrDetached) {
+		keep = true
+		return nil, &DetachedError{Container: &DetachedContainer{
+			ContainerID:  containerID,
+			OutputDir:    detached.OutputDir,
+			LifecycleDir: detached.LifecycleDir,
+			Network:      detached.Network,
+			StartedAt:    detached.StartedAt,
+		}}
+	}
+	return result, err
+}
+
+// DiscardDetached removes a detached task container that will not be
+// adopted, along with its output and lifecycle directories and its network
+func (e *DockerExecutor) DiscardDetached(ctx context.Context, detached *DetachedContainer) error {
+	if detached.OutputDir != "" {
+		os.RemoveAll(detached.OutputDir)
+	}
+	if detached.LifecycleDir != "" {
+		os.RemoveAll(detached.LifecycleDir)
+	}
+	if err := e.containerMgr.RemoveContainer(ctx, detached.ContainerID); err != nil {
+		return err
+	}
+	if detached.Network != "" {
+		return e.containerMgr.RemoveTaskNetwork(ctx, detached.Network)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ErrNotHermetic is returned for hermetic tasks the runner cannot run
+// hermetically
+var ErrNotHermetic = errors.New("task cannot run hermetically")
+
+// egressInterval is how often the egress a hermetic task attempted is
+// counted
+const egressInterval = time.Second
+
+// hermeticEnvAllowed are the variables the runner sets that hermetic
+// tasks still get: they name the task and where the runner's contract
+// with it lives, not facts about the runner's host or the time
+var hermeticEnvAllowed = map[string]bool{
+	"TASK_NONCE":            true,
+	"HOME":                  true,
+	"PARITY_TASK_ID":        true,
+	"PARITY_OUTPUT_DIR":     true,
+	"PARITY_CHECKPOINT_DIR": true,
+	"PARITY_STOP_SIGNAL":    true,
+	"PARITY_PROGRESS_FIFO":  true,
+}
+
+// HermeticError names the hermetic requirements that cannot be enforced
+// for a task, each with why
+type HermeticError struct {
+	Unmet map[string]string
+}
+
+func (e *HermeticError) Error() string {
+	names := make([]string, 0, len(e.Unmet))
+	for name := range e.Unmet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	reasons := make([]string, len(names))
+	for i, name := range names {
+		reasons[i] = name + ": " + e.Unmet[name]
+	}
+	return fmt.Sprintf("%s: %s", ErrNotHermetic, strings.Join(reasons, "; "))
+}
+
+func (e *HermeticError) Unwrap() error { return ErrNotHermetic }
+
+// isolation is what the platform offers hermetic tasks
+type isolation interface {
+	// unmet says why each hermetic requirement the platform cannot
+	// enforce is unmet
+	unmet() map[string]string
+	// openEgress counts what the running container failed to send for
+	// want of a network
+	openEgress(ctx context.Context, containerID string) (egressSource, error)
+}
+
+// egressSource counts the packets a container without a network failed
+// to send
+type egressSource interface {
+	blocked() (uint64, error)
+}
+
+func (e *DockerExecutor) platform() isolation {
+	if e.isolation != nil {
+		return e.isolation
+	}
+	return hostIsolation{}
+}
+
+// CheckHermetic checks that the hermetic task config and environment
+// describe can run hermetically here, returning a *HermeticError naming
+// every requirement that cannot be enforced
+func (e *DockerExecutor) CheckHermetic(config *models.TaskConfig, environment *models.EnvironmentConfig) error {
+	unmet := e.platform().unmet()
+	if unmet == nil {
+		unmet = make(map[string]string)
+	}
+	if config.Network != nil {
+		unmet[models.HermeticNoNetwork] = "the task overrides its network"
+	}
+	if !pinnedImage(config) {
+		unmet[models.HermeticPinnedImage] = fmt.Sprintf("image %s is not named by its digest", config.ImageName)
+	}
+	var unhashed []string
+	for _, input := range config.Inputs {
+		if input.SHA256 == "" && input.Source.CID == "" {
+			unhashed = append(unhashed, input.Name)
+		}
+	}
+	if len(unhashed) > 0 {
+		unmet[models.HermeticHashedInputs] = "inputs " + strings.Join(unhashed, ", ") + " have no sha256 or CID"
+	}
+	for _, entry := range taskCommand(environment).Env {
+		if !strings.Contains(entry, "=") {
+			unmet[models.HermeticClosedEnv] = fmt.Sprintf("variable %s takes its value from the runner", entry)
+			break
+		}
+	}
+	if len(unmet) > 0 {
+		return &HermeticError{Unmet: unmet}
+	}
+	return nil
+}
+
+// pinnedImage reports whether config names its image by digest, in the
+// image reference or as the digest of its tarball
+func pinnedImage(config *models.TaskConfig) bool {
+	if config.DockerImageURL != "" {
+		return config.DockerImageDigest != ""
+	}
+	_, digest, ok := strings.Cut(config.ImageName, "@")
+	return ok && strings.HasPrefix(digest, "sha256:")
+}
+
+// hermeticEnv keeps the variables of env, set by the runner, that
+// hermetic tasks may see
+func hermeticEnv(env []string) []string {
+	kept := env[:0:0]
+	for _, entry := range env {
+		name, _, _ := strings.Cut(entry, "=")
+		if hermeticEnvAllowed[name] {
+			kept = append(kept, entry)
+		}
+	}
+	return kept
+}
+
+// egressAudit records the egress a hermetic task's container attempted
+type egressAudit struct {
+	mu         sync.Mutex
+	violations []models.HermeticViolation
+	// unavailable is set when the container's egress could not be
+	// counted, which its attestation then does not claim
+	unavailable bool
+	stopped     chan struct{}
+}
+
+// auditEgress counts the packets the container failed to send every
+// egressInterval until ctx ends, recording a violation for each interval
+// in which it tried. Stop the audit with the returned function before
+// reading its violations.
+func (e *DockerExecutor) auditEgress(ctx context.Context, task *models.Task, containerID string) (*egressAudit, func()) {
+	audit := &egressAudit{stopped: make(chan struct{})}
+	ctx, cancel := context.WithCancel(ctx)
+	source, err := e.platform().openEgress(ctx, containerID)
+	if err != nil {
+		log := gologger.WithComponent("docker.hermetic")
+		log.Warn().Err(err).
+			Str("task_id", task.ID.String()).
+			Str("container_id", containerID).
+			Msg("Cannot count the egress of hermetic task - its attestation will not claim the audit")
+		audit.unavailable = true
+		close(audit.stopped)
+		return audit, cancel
+	}
+	go func() {
+		defer close(audit.stopped)
+		e.followEgress(ctx, task, containerID, source, audit)
+	}()
+	return audit, func() {
+		cancel()
+		<-audit.stopped
+	}
+}
+
+func (e *DockerExecutor) followEgress(ctx context.Context, task *models.Task, containerID string, source egressSource, audit *egressAudit) {
+	log := gologger.WithComponent("docker.hermetic")
+	var last uint64
+	ticker := e.containerMgr.clock.NewTicker(egressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		blocked, err := source.blocked()
+		if err != nil {
+			// The container's network namespace is gone once it exits
+			log.Debug().Err(err).Str("container_id", containerID).Msg("Stopped counting task egress")
+			return
+		}
+		if blocked <= last {
+			continue
+		}
+		log.Warn().
+			Str("task_id", task.ID.String()).
+			Str("container_id", containerID).
+			Uint64("packets", blocked-last).
+			Msg("Hermetic task attempted network egress")
+		audit.record(models.HermeticViolation{
+			Kind:   models.HermeticViolationEgress,
+			Detail: fmt.Sprintf("%d packets blocked for want of a network", blocked-last),
+			Time:   e.containerMgr.clock.Now(),
+		})
+		last = blocked
+	}
+}
+
+func (a *egressAudit) record(violation models.HermeticViolation) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.violations = append(a.violations, violation)
+}
+
+// hermeticAttestation attests that the task ran from closure, with the
+// violations audit recorded
+func hermeticAttestation(closure models.HermeticClosure, audit *egressAudit) *models.HermeticAttestation {
+	closure.Env = append([]string(nil), closure.Env...)
+	sort.Strings(closure.Env)
+	attestation := &models.HermeticAttestation{
+		Closure: closure,
+		Digest:  closure.Digest(),
+		Enforced: []string{
+			models.HermeticNoNetwork,
+			models.HermeticPinnedImage,
+			models.HermeticHashedInputs,
+			models.HermeticClosedEnv,
+		},
+	}
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	if !audit.unavailable {
+		attestation.Enforced = append(attestation.Enforced, models.HermeticEgressAudit)
+	}
+	attestation.Violations = append(attestation.Violations, audit.violations...)
+	return attestation
+}
@@ -0,0 +1,110 @@
+//go:build linux
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+// hostIsolation runs hermetic containers without a network and counts
+// the packets they fail to send from their network namespace's SNMP
+// counters, which requires the docker daemon to run on this host
+type hostIsolation struct{}
+
+func (hostIsolation) unmet() map[string]string {
+	if host := os.Getenv("DOCKER_HOST"); host != "" && !strings.HasPrefix(host, "unix://") {
+		return map[string]string{
+			models.HermeticEgressAudit: fmt.Sprintf("the docker daemon at %s is not on this host", host),
+		}
+	}
+	if _, err := os.Stat(filepath.Join(procRoot, "self", "net", "snmp")); err != nil {
+		return map[string]string{
+			models.HermeticEgressAudit: "network counters are unavailable: " + err.Error(),
+		}
+	}
+	return nil
+}
+
+func (hostIsolation) openEgress(ctx context.Context, containerID string) (egressSource, error) {
+	out, err := executils.ExecCommand(ctx, "docker", "inspect", "--format", "{{.State.Pid}}", containerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || pid <= 0 {
+		return nil, fmt.Errorf("container has no running process")
+	}
+	return netnsCounters(filepath.Join(procRoot, strconv.Itoa(pid), "net")), nil
+}
+
+// netnsCounters is the net directory of a process in a network namespace
+type netnsCounters string
+
+// blocked sums the IPv4 and IPv6 packets the namespace found no route
+// for, which is every packet sent to another host by a container without
+// a network
+func (dir netnsCounters) blocked() (uint64, error) {
+	v4, err := snmpCounter(filepath.Join(string(dir), "snmp"), "Ip:", "OutNoRoutes")
+	if err != nil {
+		return 0, err
+	}
+	// Hosts with IPv6 disabled have no snmp6
+	v6, err := snmpCounter(filepath.Join(string(dir), "snmp6"), "", "Ip6OutNoRoutes")
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	return v4 + v6, nil
+}
+
+// snmpCounter reads counter from an SNMP file of the proc filesystem.
+// With prefix, the file is /proc/net/snmp's pairs of a header line naming
+// the counters of a protocol and a line of their values; without, it is
+// /proc/net/snmp6's lines of a counter name and its value.
+func snmpCounter(path, prefix, counter string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	var header []string
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if prefix == "" {
+			if fields[0] == counter {
+				return strconv.ParseUint(fields[1], 10, 64)
+			}
+			continue
+		}
+		if fields[0] != prefix {
+			continue
+		}
+		if header == nil {
+			header = fields
+			continue
+		}
+		for i, name := range header {
+			if name == counter && i < len(fields) {
+				return strconv.ParseUint(fields[i], 10, 64)
+			}
+		}
+		header = nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s has no %s counter", path, counter)
+}
@@ -0,0 +1,36 @@
+//go:build linux
+
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNetnsCountersBlocked(t *testing.T) {
+	dir := t.TempDir()
+	snmp := "Ip: Forwarding DefaultTTL InReceives OutRequests OutDiscards OutNoRoutes\n" +
+		"Ip: 2 64 10 12 0 7\n" +
+		"Icmp: InMsgs OutNoRoutes\n" +
+		"Icmp: 0 99\n"
+	snmp6 := "Ip6InReceives                   \t4\nIp6OutNoRoutes                  \t5\n"
+	if err := os.WriteFile(filepath.Join(dir, "snmp"), []byte(snmp), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hosts with IPv6 disabled count IPv4 alone
+	if blocked, err := netnsCounters(dir).blocked(); err != nil || blocked != 7 {
+		t.Fatalf("blocked() = %d, %v, want the 7 IPv4 packets", blocked, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "snmp6"), []byte(snmp6), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, err := netnsCounters(dir).blocked(); err != nil || blocked != 12 {
+		t.Fatalf("blocked() = %d, %v, want the IPv4 and IPv6 packets", blocked, err)
+	}
+
+	if _, err := netnsCounters(t.TempDir()).blocked(); err == nil {
+		t.Fatal("blocked() counted a namespace that is gone")
+	}
+}
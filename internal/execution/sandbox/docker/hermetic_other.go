@@ -0,0 +1,25 @@
+//go:build !linux
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"runtime"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// hostIsolation cannot count what a container tries to send outside
+// Linux, where the runner cannot read the container's network namespace
+type hostIsolation struct{}
+
+func (hostIsolation) unmet() map[string]string {
+	return map[string]string{
+		models.HermeticEgressAudit: "egress cannot be counted on " + runtime.GOOS,
+	}
+}
+
+func (hostIsolation) openEgress(ctx context.Context, containerID string) (egressSource, error) {
+	return nil, errors.ErrUnsupported
+}
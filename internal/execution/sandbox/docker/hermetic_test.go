@@ -0,0 +1,184 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// stubIsolation is a platform enforcing every requirement missing does not
+// name, counting egress with egress when it is set
+type stubIsolation struct {
+	missing map[string]string
+	egress  *fakeEgress
+}
+
+func (s stubIsolation) unmet() map[string]string {
+	unmet := make(map[string]string, len(s.missing))
+	for name, why := range s.missing {
+		unmet[name] = why
+	}
+	return unmet
+}
+
+func (s stubIsolation) openEgress(context.Context, string) (egressSource, error) {
+	if s.egress == nil {
+		return nil, errors.ErrUnsupported
+	}
+	return s.egress, nil
+}
+
+// fakeEgress returns one count per read, then fails as a container gone
+type fakeEgress struct {
+	counts []uint64
+	reads  chan struct{}
+}
+
+func (f *fakeEgress) blocked() (uint64, error) {
+	defer func() { f.reads <- struct{}{} }()
+	if len(f.counts) == 0 {
+		return 0, errors.New("network namespace gone")
+	}
+	count := f.counts[0]
+	f.counts = f.counts[1:]
+	return count, nil
+}
+
+func hermeticEnvironment(env ...string) *models.EnvironmentConfig {
+	values := make([]interface{}, len(env))
+	for i, entry := range env {
+		values[i] = entry
+	}
+	return &models.EnvironmentConfig{Type: "docker", Config: map[string]interface{}{"env": values}}
+}
+
+func TestCheckHermeticNamesUnmetRequirements(t *testing.T) {
+	e := &DockerExecutor{isolation: stubIsolation{missing: map[string]string{
+		models.HermeticEgressAudit: "egress cannot be counted on plan9",
+	}}}
+	config := &models.TaskConfig{
+		ImageName: "python:3.11",
+		Network:   &models.NetworkConfig{DNS: []string{"1.1.1.1"}},
+		Inputs: []models.TaskInput{
+			{Name: "data", Source: models.InputSource{URL: "https://example.com/data.csv"}, TargetPath: "data.csv"},
+			{Name: "weights", Source: models.InputSource{CID: "bafy"}, TargetPath: "weights.bin"},
+		},
+	}
+
+	err := e.CheckHermetic(config, hermeticEnvironment("MODE=fast", "AWS_SECRET_ACCESS_KEY"))
+	var hermetic *HermeticError
+	if !errors.As(err, &hermetic) || !errors.Is(err, ErrNotHermetic) {
+		t.Fatalf("CheckHermetic() error = %v, want a HermeticError", err)
+	}
+	for _, requirement := range []string{
+		models.HermeticEgressAudit,
+		models.HermeticNoNetwork,
+		models.HermeticPinnedImage,
+		models.HermeticHashedInputs,
+		models.HermeticClosedEnv,
+	} {
+		if hermetic.Unmet[requirement] == "" {
+			t.Errorf("unmet = %v, want %s named", hermetic.Unmet, requirement)
+		}
+	}
+	if len(hermetic.Unmet) != 5 {
+		t.Errorf("unmet = %v, want exactly the five requirements", hermetic.Unmet)
+	}
+}
+
+func TestCheckHermeticAcceptsPinnedTask(t *testing.T) {
+	e := &DockerExecutor{isolation: stubIsolation{}}
+	config := &models.TaskConfig{
+		ImageName: "python@sha256:" + "ab12",
+		Inputs: []models.TaskInput{
+			{Name: "data", Source: models.InputSource{URL: "https://example.com/data.csv"}, SHA256: "cd34", TargetPath: "data.csv"},
+		},
+	}
+	if err := e.CheckHermetic(config, hermeticEnvironment("MODE=fast")); err != nil {
+		t.Fatalf("CheckHermetic() error = %v, want a pinned task accepted", err)
+	}
+
+	// A tarball is pinned by its digest, not by the name it is loaded as
+	config.DockerImageURL = "https://example.com/image.tar"
+	if err := e.CheckHermetic(config, nil); err == nil {
+		t.Fatal("CheckHermetic() accepted a tarball without a digest")
+	}
+	config.DockerImageDigest = "ef56"
+	if err := e.CheckHermetic(config, nil); err != nil {
+		t.Fatalf("CheckHermetic() error = %v, want a tarball with a digest accepted", err)
+	}
+}
+
+func TestHermeticEnvKeepsAllowedFacts(t *testing.T) {
+	task := &models.Task{ID: uuid.New()}
+	env := lifecycleEnv(task, time.Unix(1700000000, 0), 10*time.Second, true)
+	got := hermeticEnv(env)
+	want := []string{
+		"PARITY_TASK_ID=" + task.ID.String(),
+		"PARITY_STOP_SIGNAL=" + StopSignal,
+		"PARITY_CHECKPOINT_DIR=" + ContainerCheckpointDir,
+		"PARITY_PROGRESS_FIFO=" + ContainerProgressFIFO,
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("hermeticEnv() = %v, want %v without the deadline and grace period", got, want)
+	}
+}
+
+func TestEgressAuditRecordsViolations(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC))
+	egress := &fakeEgress{counts: []uint64{0, 3, 3, 5}, reads: make(chan struct{}, 8)}
+	e := &DockerExecutor{
+		containerMgr: &ContainerManager{clock: clk},
+		isolation:    stubIsolation{egress: egress},
+	}
+
+	audit, stop := e.auditEgress(context.Background(), &models.Task{ID: uuid.New()}, "abc")
+	clk.BlockUntil(1)
+	for i := 0; i < 5; i++ {
+		clk.Advance(egressInterval)
+		<-egress.reads
+	}
+	stop()
+
+	closure := models.HermeticClosure{
+		ImageDigest: "sha256:ab12",
+		Image:       "python@sha256:ab12",
+		Env:         []string{"TASK_NONCE=abcdef", "HOME=/tmp", "MODE=fast"},
+	}
+	attestation := hermeticAttestation(closure, audit)
+	if len(attestation.Violations) != 2 || attestation.Violations[0].Detail != "3 packets blocked for want of a network" ||
+		attestation.Violations[1].Detail != "2 packets blocked for want of a network" {
+		t.Fatalf("violations = %+v, want the 3 and then 2 packets blocked", attestation.Violations)
+	}
+	if !slices.Contains(attestation.Enforced, models.HermeticEgressAudit) || len(attestation.Enforced) != 5 {
+		t.Errorf("enforced = %v, want every requirement", attestation.Enforced)
+	}
+	if !slices.IsSorted(attestation.Closure.Env) || attestation.Digest != attestation.Closure.Digest() {
+		t.Errorf("closure = %+v with digest %s, want a sorted environment digested", attestation.Closure, attestation.Digest)
+	}
+	if closure.Env[0] != "TASK_NONCE=abcdef" {
+		t.Error("attesting reordered the caller's environment")
+	}
+}
+
+func TestEgressAuditUnavailable(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC))
+	e := &DockerExecutor{
+		containerMgr: &ContainerManager{clock: clk},
+		isolation:    stubIsolation{},
+	}
+	audit, stop := e.auditEgress(context.Background(), &models.Task{ID: uuid.New()}, "abc")
+	stop()
+
+	attestation := hermeticAttestation(models.HermeticClosure{Image: "python@sha256:ab12"}, audit)
+	if slices.Contains(attestation.Enforced, models.HermeticEgressAudit) {
+		t.Fatalf("enforced = %v, want the egress audit left out when egress could not be counted", attestation.Enforced)
+	}
+}
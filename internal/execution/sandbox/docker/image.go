@@ -2,57 +2,254 @@ package docker
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/theblitlabs/gologger"
 
+	"github.com/theblitlabs/parity-runner/internal/blobcache"
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
 	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+	"github.com/theblitlabs/parity-runner/internal/registrymirror"
+	"github.com/theblitlabs/parity-runner/internal/timeline"
 )
 
-type ImageManager struct{}
+// ErrImageNotFound is returned for a task image the registry does not have,
+// which no runner can pull
+var ErrImageNotFound = errors.New("image not found")
+
+// imageNotFoundMessages are what docker pull prints for an image, tag or
+// repository the registry does not have
+var imageNotFoundMessages = []string{
+	"manifest unknown",
+	"not found: manifest",
+	"repository does not exist",
+	": not found",
+}
+
+type ImageManager struct {
+	run     func(ctx context.Context, name string, args ...string) ([]byte, error)
+	mirrors *registrymirror.Pool
+	blobs   *blobcache.Fetcher
+	client  *http.Client
+
+	mu sync.Mutex
+	// local maps images pinned by digest to the mirror references they
+	// were pulled as
+	local map[string]string
+}
 
 func NewImageManager() *ImageManager {
-	return &ImageManager{}
+	return &ImageManager{
+		run:    executils.ExecCommand,
+		client: dualstack.Client(0),
+		local:  make(map[string]string),
+	}
+}
+
+// SetHTTPClient replaces the client image tarballs are downloaded with
+func (im *ImageManager) SetHTTPClient(client *http.Client) {
+	im.client = client
+}
+
+// SetRegistryMirrors pulls images of the registries pool has mirrors for
+// through them
+func (im *ImageManager) SetRegistryMirrors(pool *registrymirror.Pool) {
+	im.mirrors = pool
+}
+
+// SetBlobFetcher keeps image tarballs in fetcher's cache, fetching them
+// from peers that hold them before their URL
+func (im *ImageManager) SetBlobFetcher(fetcher *blobcache.Fetcher) {
+	im.blobs = fetcher
+}
+
+// Local returns the name the daemon knows imageName by once available. An
+// image pinned by digest and pulled from a mirror keeps the mirror's name,
+// as the daemon only finds a digest under the repository it came from.
+func (im *ImageManager) Local(imageName string) string {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	if local, ok := im.local[imageName]; ok {
+		return local
+	}
+	return imageName
 }
 
 func (im *ImageManager) PullImage(ctx context.Context, imageName string) error {
 	log := gologger.WithComponent("docker.image")
 
+	if im.mirrors != nil && im.pullMirrored(ctx, imageName) {
+		return nil
+	}
+
 	log.Info().Str("image", imageName).Msg("Pulling image from registry")
-	if _, err := executils.ExecCommand(ctx, "docker", "pull", imageName); err != nil {
+	if output, err := im.run(ctx, "docker", "pull", imageName); err != nil {
 		log.Error().Err(err).Str("image", imageName).Msg("Pull failed")
+		if imageNotFound(string(output)) {
+			return fmt.Errorf("image pull failed: %w: %s: %v", ErrImageNotFound, imageName, err)
+		}
 		return fmt.Errorf("image pull failed: %w", err)
 	}
 
 	return nil
 }
 
-func (im *ImageManager) DownloadAndLoadImage(ctx context.Context, imageURL, imageName string) error {
+// pullMirrored pulls imageName from the healthy mirrors of its registry in
+// turn, reporting whether one served it. Mirrors are only asked for the
+// image's digest, so the daemon checks what they serve against it.
+func (im *ImageManager) pullMirrored(ctx context.Context, imageName string) bool {
+	log := gologger.WithComponent("docker.image")
+
+	ref, err := registrymirror.ParseReference(imageName)
+	if err != nil || !im.mirrors.Mirrored(ref.Registry) {
+		return false
+	}
+	mirrors := im.mirrors.For(ref.Registry)
+	if len(mirrors) == 0 {
+		im.mirrors.Fallback()
+		return false
+	}
+	digest, err := im.mirrors.ResolveDigest(ctx, ref)
+	if err != nil {
+		log.Debug().Err(err).Str("image", imageName).Msg("Cannot resolve image digest, pulling from registry")
+		im.mirrors.Fallback()
+		return false
+	}
+
+	for _, mirror := range mirrors {
+		mirrored := mirror.Reference(ref.Repository, digest)
+		log.Info().Str("image", imageName).Str("mirror", mirror.URL.Host).Msg("Pulling image from registry mirror")
+		output, err := im.run(ctx, "docker", "pull", mirrored)
+		if err == nil && ref.Digest == "" {
+			// Name the image by its tag as the registry would have
+			output, err = im.run(ctx, "docker", "tag", mirrored, imageName)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			log.Warn().Err(err).Str("image", imageName).Str("mirror", mirror.URL.Host).Msg("Registry mirror pull failed")
+			// A mirror that does not have the image may still be up
+			if !imageNotFound(string(output)) {
+				im.mirrors.Failed(mirror, err)
+			}
+			continue
+		}
+		if ref.Digest != "" {
+			im.mu.Lock()
+			im.local[imageName] = mirrored
+			im.mu.Unlock()
+		}
+		im.mirrors.Hit()
+		return true
+	}
+	im.mirrors.Fallback()
+	return false
+}
+
+// imageNotFound reports whether output, from a failed docker pull, says
+// the registry does not have the image
+func imageNotFound(output string) bool {
+	output = strings.ToLower(output)
+	for _, message := range imageNotFoundMessages {
+		if strings.Contains(output, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// DownloadAndLoadImage loads imageName from the tarball at imageURL. With
+// a digest the tarball must match it; with a blob cache it is kept there
+// and fetched from peers that hold it before imageURL.
+func (im *ImageManager) DownloadAndLoadImage(ctx context.Context, imageURL, imageName, digest string) error {
+	log := gologger.WithComponent("docker.image")
+
+	origin := func(ctx context.Context) (io.ReadCloser, error) {
+		return openImageURL(ctx, im.client, imageURL)
+	}
+	if im.blobs != nil {
+		path, got, err := im.blobs.Fetch(ctx, digest, origin)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to download Docker image")
+			return fmt.Errorf("failed to download Docker image: %w", err)
+		}
+		log.Debug().Str("image", imageName).Str("digest", got).Msg("Docker image tarball cached")
+		return im.loadImage(ctx, path, imageName)
+	}
+
+	body, err := origin(ctx)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	tmpFile, err := os.CreateTemp("", "docker-image-*.tar")
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create temporary file")
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		if err := os.Remove(tmpFile.Name()); err != nil {
+			log.Debug().Err(err).Str("file", tmpFile.Name()).Msg("Failed to remove temporary file")
+		}
+	}()
+	defer tmpFile.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmpFile, hash), body)
+	timeline.From(ctx).AddBytes(n)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to save Docker image")
+		return fmt.Errorf("failed to save Docker image: %w", err)
+	}
+	if got := "sha256:" + hex.EncodeToString(hash.Sum(nil)); digest != "" && got != digest {
+		return fmt.Errorf("failed to download Docker image: %w: got %s, want %s", blobcache.ErrDigestMismatch, got, digest)
+	}
+
+	return im.loadImage(ctx, tmpFile.Name(), imageName)
+}
+
+func (im *ImageManager) loadImage(ctx context.Context, path, imageName string) error {
+	log := gologger.WithComponent("docker.image")
+
+	log.Info().Str("image", imageName).Msg("Loading Docker image")
+	if _, err := im.run(ctx, "docker", "load", "-i", path); err != nil {
+		log.Error().Err(err).Msg("Failed to load Docker image")
+		return fmt.Errorf("failed to load Docker image: %w", err)
+	}
+	return nil
+}
+
+// openImageURL starts downloading the image tarball at imageURL
+func openImageURL(ctx context.Context, client *http.Client, imageURL string) (io.ReadCloser, error) {
 	log := gologger.WithComponent("docker.image")
 
 	parsedURL, err := url.Parse(imageURL)
 	if err != nil {
 		log.Error().Err(err).Str("url", imageURL).Msg("Failed to parse image URL")
-		return fmt.Errorf("failed to parse image URL: %w", err)
+		return nil, fmt.Errorf("failed to parse image URL: %w", err)
 	}
 
+	var req *http.Request
+	// For IPFS URLs, use the API endpoint directly to avoid gateway redirect issues
 	if strings.Contains(parsedURL.Path, "/ipfs/") {
 		log.Info().Str("url", imageURL).Msg("Downloading Docker image from IPFS/Filecoin")
-	} else {
-		log.Info().Str("url", imageURL).Msg("Downloading Docker image from HTTP")
-	}
 
-	// For IPFS URLs, use the API endpoint directly to avoid gateway redirect issues
-	if strings.Contains(imageURL, "/ipfs/") {
 		// Extract CID from URL like http://localhost:8080/ipfs/QmXXX
 		parts := strings.Split(imageURL, "/ipfs/")
 		if len(parts) != 2 {
-			return fmt.Errorf("invalid IPFS URL format: %s", imageURL)
+			return nil, fmt.Errorf("invalid IPFS URL format: %s", imageURL)
 		}
 
 		cid := strings.Split(parts[1], "?")[0] // Remove any query parameters
@@ -61,105 +258,100 @@ func (im *ImageManager) DownloadAndLoadImage(ctx context.Context, imageURL, imag
 		apiURL := "http://localhost:5001/api/v0/cat?arg=" + cid
 		log.Info().Str("api_url", apiURL).Str("cid", cid).Msg("Using IPFS API to download image")
 
-		req, err := http.NewRequest("POST", apiURL, nil)
+		req, err = http.NewRequestWithContext(ctx, "POST", apiURL, nil)
 		if err != nil {
 			log.Error().Err(err).Msg("Failed to create IPFS API request")
-			return fmt.Errorf("failed to create IPFS API request: %w", err)
-		}
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Error().Err(err).Msg("Failed to download Docker image")
-			return fmt.Errorf("failed to download Docker image: %w", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			log.Error().Int("status_code", resp.StatusCode).Msg("Failed to download Docker image")
-			return fmt.Errorf("failed to download Docker image: status code %d", resp.StatusCode)
+			return nil, fmt.Errorf("failed to create IPFS API request: %w", err)
 		}
+	} else {
+		log.Info().Str("url", imageURL).Msg("Downloading Docker image from HTTP")
 
-		tmpFile, err := os.CreateTemp("", "docker-image-*.tar")
+		req, err = http.NewRequestWithContext(ctx, "GET", imageURL, nil)
 		if err != nil {
-			log.Error().Err(err).Msg("Failed to create temporary file")
-			return fmt.Errorf("failed to create temporary file: %w", err)
-		}
-		defer func() {
-			if err := os.Remove(tmpFile.Name()); err != nil {
-				log.Debug().Err(err).Str("file", tmpFile.Name()).Msg("Failed to remove temporary file")
-			}
-		}()
-		defer tmpFile.Close()
-
-		if _, err := io.Copy(tmpFile, resp.Body); err != nil {
-			log.Error().Err(err).Msg("Failed to save Docker image")
-			return fmt.Errorf("failed to save Docker image: %w", err)
+			log.Error().Err(err).Msg("Failed to create HTTP request")
+			return nil, fmt.Errorf("failed to create HTTP request: %w", err)
 		}
-
-		log.Info().Str("image", imageName).Msg("Loading Docker image")
-		if _, err := executils.ExecCommand(context.Background(), "docker", "load", "-i", tmpFile.Name()); err != nil {
-			log.Error().Err(err).Msg("Failed to load Docker image")
-			return fmt.Errorf("failed to load Docker image: %w", err)
-		}
-
-		return nil
-	}
-
-	// For non-IPFS URLs, use regular HTTP GET
-	log.Info().Str("url", imageURL).Msg("Downloading Docker image from HTTP")
-
-	req, err := http.NewRequest("GET", imageURL, nil)
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to create HTTP request")
-		return fmt.Errorf("failed to create HTTP request: %w", err)
+		req.Header.Set("User-Agent", "parity-runner/1.0")
+		req.Header.Set("Accept", "application/octet-stream")
 	}
 
-	req.Header.Set("User-Agent", "parity-runner/1.0")
-	req.Header.Set("Accept", "application/octet-stream")
-
-	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to download Docker image")
-		return fmt.Errorf("failed to download Docker image: %w", err)
+		return nil, fmt.Errorf("failed to download Docker image: %w", err)
 	}
-	defer resp.Body.Close()
-
 	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
 		log.Error().Int("status_code", resp.StatusCode).Msg("Failed to download Docker image")
-		return fmt.Errorf("failed to download Docker image: status code %d", resp.StatusCode)
+		return nil, fmt.Errorf("failed to download Docker image: status code %d", resp.StatusCode)
 	}
+	return resp.Body, nil
+}
 
-	tmpFile, err := os.CreateTemp("", "docker-image-*.tar")
+// imageUnpackFactor is how much disk an image takes while it is pulled, as
+// a multiple of its compressed layers: the layers are downloaded, then
+// unpacked to about twice their size
+const imageUnpackFactor = 3
+
+// EstimateSize estimates the disk that making imageName available takes,
+// 0 when the daemon already has it. Registry images are sized from their
+// manifest; an image tarball is downloaded and then loaded at about its
+// own size.
+func (im *ImageManager) EstimateSize(ctx context.Context, imageName, imageURL string) (int64, error) {
+	if imageURL != "" {
+		size, err := imageURLSize(ctx, im.client, imageURL)
+		return 2 * size, err
+	}
+	if im.present(ctx, im.Local(imageName)) {
+		return 0, nil
+	}
+	ref, err := registrymirror.ParseReference(imageName)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create temporary file")
-		return fmt.Errorf("failed to create temporary file: %w", err)
+		return 0, err
 	}
-	defer func() {
-		if err := os.Remove(tmpFile.Name()); err != nil {
-			log.Debug().Err(err).Str("file", tmpFile.Name()).Msg("Failed to remove temporary file")
-		}
-	}()
-	defer tmpFile.Close()
-
-	if _, err := io.Copy(tmpFile, resp.Body); err != nil {
-		log.Error().Err(err).Msg("Failed to save Docker image")
-		return fmt.Errorf("failed to save Docker image: %w", err)
+	pool := im.mirrors
+	if pool == nil {
+		pool = registrymirror.NewPool(nil)
 	}
+	size, err := pool.ImageSize(ctx, ref)
+	return size * imageUnpackFactor, err
+}
 
-	log.Info().Str("image", imageName).Msg("Loading Docker image")
-	if _, err := executils.ExecCommand(ctx, "docker", "load", "-i", tmpFile.Name()); err != nil {
-		log.Error().Err(err).Msg("Failed to load Docker image")
-		return fmt.Errorf("failed to load Docker image: %w", err)
+// LocalSize returns the size of imageName in the daemon, 0 when unknown
+func (im *ImageManager) LocalSize(ctx context.Context, imageName string) int64 {
+	out, err := im.run(ctx, "docker", "image", "inspect", "--format", "{{.Size}}", im.Local(imageName))
+	if err != nil {
+		return 0
 	}
+	size, _ := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	return size
+}
 
-	return nil
+func (im *ImageManager) present(ctx context.Context, imageName string) bool {
+	_, err := im.run(ctx, "docker", "image", "inspect", "--format", "{{.Id}}", imageName)
+	return err == nil
+}
+
+// imageURLSize returns the size the server at imageURL gives the tarball
+func imageURLSize(ctx context.Context, client *http.Client, imageURL string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, imageURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse image URL: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to size Docker image: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+		return 0, fmt.Errorf("image URL gave no size (status code %d)", resp.StatusCode)
+	}
+	return resp.ContentLength, nil
 }
 
-func (im *ImageManager) EnsureImageAvailable(ctx context.Context, imageName, imageURL string) error {
+func (im *ImageManager) EnsureImageAvailable(ctx context.Context, imageName, imageURL, imageDigest string) error {
 	if imageURL != "" {
-		return im.DownloadAndLoadImage(ctx, imageURL, imageName)
+		return im.DownloadAndLoadImage(ctx, imageURL, imageName, imageDigest)
 	}
 	return im.PullImage(ctx, imageName)
 }
@@ -0,0 +1,139 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/registrymirror"
+)
+
+func TestImageNotFound(t *testing.T) {
+	tests := []struct {
+		output string
+		want   bool
+	}{
+		{"Error response from daemon: manifest for alpine:nope not found: manifest unknown: manifest unknown", true},
+		{"Error response from daemon: pull access denied for nosuchimage, repository does not exist or may require 'docker login'", true},
+		{"Error response from daemon: Get \"https://registry-1.docker.io/v2/\": dial tcp: lookup registry-1.docker.io: no such host", false},
+		{"Error response from daemon: toomanyrequests: You have reached your pull rate limit.", false},
+		{"Error response from daemon: pull access denied for private/image, unauthorized: authentication required", false},
+	}
+	for _, tt := range tests {
+		if got := imageNotFound(tt.output); got != tt.want {
+			t.Errorf("imageNotFound(%q) = %v, want %v", tt.output, got, tt.want)
+		}
+	}
+}
+
+// fakeDocker records the docker commands it is asked to run, failing the
+// pulls of references in fail
+type fakeDocker struct {
+	commands []string
+	fail     map[string]string
+}
+
+func (d *fakeDocker) run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	command := strings.Join(append([]string{name}, args...), " ")
+	d.commands = append(d.commands, command)
+	if len(args) == 2 && args[0] == "pull" {
+		if output, ok := d.fail[args[1]]; ok {
+			return []byte(output), errors.New("exit status 1")
+		}
+	}
+	return nil, nil
+}
+
+const testImageDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// newMirroredRegistry returns a registry resolving every tag to
+// testImageDigest and a pool of its mirrors, which all answer health checks
+func newMirroredRegistry(t *testing.T, mirrors ...string) (string, *registrymirror.Pool) {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Docker-Content-Digest", testImageDigest)
+	}))
+	t.Cleanup(server.Close)
+	registry := strings.TrimPrefix(server.URL, "http://")
+
+	specs := make([]string, len(mirrors))
+	for i, mirror := range mirrors {
+		specs[i] = registry + "=" + mirror
+	}
+	parsed, err := registrymirror.ParseMirrors(specs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry, registrymirror.NewPool(parsed)
+}
+
+func TestPullFallsBackWhenMirrorGoesDown(t *testing.T) {
+	registry, pool := newMirroredRegistry(t, "http://mirror.lan:5000")
+	image := registry + "/org/app:1.0"
+	mirrored := "mirror.lan:5000/org/app@" + testImageDigest
+
+	docker := &fakeDocker{fail: map[string]string{
+		mirrored: "error pulling image configuration: unexpected EOF",
+	}}
+	im := NewImageManager()
+	im.run = docker.run
+	im.SetRegistryMirrors(pool)
+
+	if err := im.EnsureImageAvailable(context.Background(), image, "", ""); err != nil {
+		t.Fatalf("EnsureImageAvailable() error = %v, want the registry to complete the pull", err)
+	}
+	want := []string{"docker pull " + mirrored, "docker pull " + image}
+	if strings.Join(docker.commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("commands = %q, want %q", docker.commands, want)
+	}
+	if stats := pool.Stats(); stats.Hits != 0 || stats.Fallbacks != 1 || stats.Healthy != 0 {
+		t.Errorf("Stats() = %+v, want a fallback and the mirror out of use", stats)
+	}
+	if got := im.Local(image); got != image {
+		t.Errorf("Local() = %q for an image pulled from the registry", got)
+	}
+
+	// Later pulls skip the mirror until a health check finds it back
+	docker.commands = nil
+	if err := im.PullImage(context.Background(), image); err != nil {
+		t.Fatal(err)
+	}
+	if len(docker.commands) != 1 || docker.commands[0] != "docker pull "+image {
+		t.Errorf("commands = %q, want the registry only", docker.commands)
+	}
+}
+
+func TestPullFromMirror(t *testing.T) {
+	registry, pool := newMirroredRegistry(t, "http://first.lan:5000", "http://second.lan:5000")
+	docker := &fakeDocker{fail: map[string]string{
+		// A mirror without the image is passed over but stays in use
+		"first.lan:5000/org/app@" + testImageDigest: "manifest unknown",
+	}}
+	im := NewImageManager()
+	im.run = docker.run
+	im.SetRegistryMirrors(pool)
+
+	tagged := registry + "/org/app:1.0"
+	if err := im.PullImage(context.Background(), tagged); err != nil {
+		t.Fatal(err)
+	}
+	second := "second.lan:5000/org/app@" + testImageDigest
+	if last := docker.commands[len(docker.commands)-1]; last != "docker tag "+second+" "+tagged {
+		t.Errorf("last command = %q, want the image tagged by its name", last)
+	}
+
+	// An image pinned by digest keeps the mirror's name
+	pinned := registry + "/org/app@" + testImageDigest
+	if err := im.PullImage(context.Background(), pinned); err != nil {
+		t.Fatal(err)
+	}
+	if got := im.Local(pinned); got != second {
+		t.Errorf("Local() = %q, want %q", got, second)
+	}
+	if stats := pool.Stats(); stats.Hits != 2 || stats.Fallbacks != 0 || stats.Healthy != 2 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
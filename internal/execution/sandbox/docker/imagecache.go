@@ -0,0 +1,89 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+// ImageStore exposes local Docker images as a cache, keyed by tag
+type ImageStore struct{}
+
+func NewImageStore() *ImageStore {
+	return &ImageStore{}
+}
+
+// CanonicalImageName adds the tag Docker assumes when a reference has
+// neither a tag nor a digest
+func CanonicalImageName(name string) string {
+	if name == "" || strings.Contains(name, "@") {
+		return name
+	}
+	if strings.Contains(name[strings.LastIndex(name, "/")+1:], ":") {
+		return name
+	}
+	return name + ":latest"
+}
+
+func (s *ImageStore) Entries(ctx context.Context) ([]caches.Entry, error) {
+	out, err := executils.ExecCommand(ctx, "docker", "image", "ls", "--quiet", "--no-trunc")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+	ids := uniqueFields(string(out))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	args := append([]string{"image", "inspect", "--format",
+		`{{.Size}}{{"\t"}}{{.Created}}{{"\t"}}{{join .RepoTags " "}}`}, ids...)
+	out, err = executils.ExecCommand(ctx, "docker", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect images: %w", err)
+	}
+
+	var entries []caches.Entry
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[0], 10, 64)
+		created, _ := time.Parse(time.RFC3339Nano, fields[1])
+		for _, tag := range strings.Fields(fields[2]) {
+			entries = append(entries, caches.Entry{Key: tag, Size: size, LastUsed: created})
+		}
+	}
+	return entries, nil
+}
+
+func (s *ImageStore) Has(ctx context.Context, name string) (bool, error) {
+	if _, err := executils.ExecCommand(ctx, "docker", "image", "inspect", "--format", "{{.Id}}", name); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (s *ImageStore) Remove(ctx context.Context, name string) error {
+	if _, err := executils.ExecCommand(ctx, "docker", "rmi", name); err != nil {
+		return fmt.Errorf("failed to remove image: %w", err)
+	}
+	return nil
+}
+
+func uniqueFields(s string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, f := range strings.Fields(s) {
+		if !seen[f] {
+			seen[f] = true
+			out = append(out, f)
+		}
+	}
+	return out
+}
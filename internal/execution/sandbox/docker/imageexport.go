@@ -0,0 +1,218 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+)
+
+// exportTaskLabel marks images built for a task; only such images, or the
+// task's own image, may be exported as a named target
+const exportTaskLabel = "org.parity.task-id"
+
+// ImageExporter saves task images as OCI archives and uploads their blobs,
+// skipping layers the artifact cache already holds. Each export uploads
+// through the uploader its source hands out for the task.
+type ImageExporter struct {
+	uploaders artifacts.UploaderSource
+	cache     *artifacts.Cache
+	limits    ImageExportLimits
+}
+
+func NewImageExporter(uploaders artifacts.UploaderSource, cache *artifacts.Cache, limits ImageExportLimits) *ImageExporter {
+	return &ImageExporter{uploaders: uploaders, cache: cache, limits: limits}
+}
+
+// Limits returns the runner's caps tightened by any the task asked for
+func (x *ImageExporter) Limits(cfg *models.ImageExportConfig) ImageExportLimits {
+	limits := x.limits
+	if cfg == nil {
+		return limits
+	}
+	if cfg.MaxBytes > 0 && (limits.MaxBytes == 0 || cfg.MaxBytes < limits.MaxBytes) {
+		limits.MaxBytes = cfg.MaxBytes
+	}
+	if cfg.MaxLayers > 0 && (limits.MaxLayers == 0 || cfg.MaxLayers < limits.MaxLayers) {
+		limits.MaxLayers = cfg.MaxLayers
+	}
+	return limits
+}
+
+// save writes image ref to archivePath as an OCI tarball that `docker load`
+// also accepts. workDir holds the unpacked layout, which publish reads.
+func (x *ImageExporter) save(ctx context.Context, ref, tag, workDir, archivePath string, limits ImageExportLimits) (*ociImage, error) {
+	saved := filepath.Join(workDir, "docker-save.tar")
+	if _, err := executils.ExecCommand(ctx, "docker", "save", "-o", saved, ref); err != nil {
+		return nil, fmt.Errorf("failed to save image: %w", err)
+	}
+	defer os.Remove(saved)
+
+	if info, err := os.Stat(saved); err == nil && limits.MaxBytes > 0 && info.Size() > 2*limits.MaxBytes {
+		return nil, fmt.Errorf("%w: saved archive is %d bytes, limit %d", ErrImageTooLarge, info.Size(), limits.MaxBytes)
+	}
+
+	if tag == "" {
+		tag = ref
+	}
+	img, err := convertDockerArchive(saved, workDir, tag, limits)
+	if err != nil {
+		return nil, err
+	}
+	if err := img.WriteArchive(archivePath); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// exportIndex is uploaded last and names every blob of the image, letting a
+// consumer fetch the blobs by CID and rebuild the OCI archive
+type exportIndex struct {
+	Index json.RawMessage   `json:"index"`
+	Blobs map[string]string `json:"blobs"`
+}
+
+// publish uploads the image's blobs and an index naming them. Layers already
+// in the artifact cache are referenced rather than uploaded again.
+func (x *ImageExporter) publish(ctx context.Context, img *ociImage, uploader artifacts.Uploader) (*models.ExportedImage, error) {
+	log := gologger.WithComponent("docker.export")
+
+	exported := &models.ExportedImage{
+		Reference:    img.Ref,
+		Digest:       img.Manifest.Digest,
+		ConfigDigest: img.Config.Digest,
+		SizeBytes:    img.Size(),
+	}
+	index := exportIndex{Index: img.Index, Blobs: make(map[string]string)}
+
+	upload := func(desc ociDescriptor) (string, bool, error) {
+		if cid, ok := index.Blobs[desc.Digest]; ok {
+			return cid, true, nil
+		}
+		if cid, ok := x.cache.Lookup(desc.Digest); ok {
+			index.Blobs[desc.Digest] = cid
+			return cid, true, nil
+		}
+
+		f, err := os.Open(img.blobPath(desc.Digest))
+		if err != nil {
+			return "", false, fmt.Errorf("failed to open image blob: %w", err)
+		}
+		defer f.Close()
+
+		cid, err := uploader.Add(ctx, desc.Digest, f)
+		if err != nil {
+			return "", false, err
+		}
+		exported.UploadedBytes += desc.Size
+		index.Blobs[desc.Digest] = cid
+		if err := x.cache.Record(desc.Digest, cid); err != nil {
+			log.Warn().Err(err).Str("digest", desc.Digest).Msg("Failed to record uploaded blob")
+		}
+		return cid, false, nil
+	}
+
+	for _, layer := range img.Layers {
+		cid, reused, err := upload(layer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload layer %s: %w", layer.Digest, err)
+		}
+		exported.Layers = append(exported.Layers, models.ExportedLayer{
+			Digest: layer.Digest,
+			Size:   layer.Size,
+			CID:    cid,
+			Reused: reused,
+		})
+	}
+	for _, desc := range []ociDescriptor{img.Config, img.Manifest} {
+		if _, _, err := upload(desc); err != nil {
+			return nil, fmt.Errorf("failed to upload %s: %w", desc.MediaType, err)
+		}
+	}
+
+	data, err := json.Marshal(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal export index: %w", err)
+	}
+	exported.IndexCID, err = uploader.Add(ctx, "index.json", bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload export index: %w", err)
+	}
+	return exported, nil
+}
+
+// Export saves and publishes ref for taskID, removing the local archive
+// afterwards
+func (x *ImageExporter) Export(ctx context.Context, taskID, ref string, cfg *models.ImageExportConfig) (*models.ExportedImage, error) {
+	workDir, err := os.MkdirTemp("", retention.ScratchPattern("export", taskID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	img, err := x.save(ctx, ref, cfg.Tag, workDir, filepath.Join(workDir, "image.oci.tar"), x.Limits(cfg))
+	if err != nil {
+		return nil, err
+	}
+	return x.publish(ctx, img, x.uploaders.ForTask(taskID))
+}
+
+// exportTaskImage commits the finished task container, or resolves the
+// requested target, and exports it
+func (e *DockerExecutor) exportTaskImage(ctx context.Context, task *models.Task, containerID, taskImage string, cfg *models.ImageExportConfig) (*models.ExportedImage, error) {
+	log := gologger.WithComponent("docker.export")
+
+	ref := cfg.Target
+	if ref == "" {
+		ref = "parity-export/" + task.ID.String() + ":latest"
+		if _, err := executils.ExecCommand(ctx, "docker", "commit", containerID, ref); err != nil {
+			return nil, fmt.Errorf("failed to commit task container: %w", err)
+		}
+		defer func() {
+			if _, err := executils.ExecCommand(context.Background(), "docker", "rmi", ref); err != nil {
+				log.Debug().Err(err).Str("image", ref).Msg("Failed to remove committed task image")
+			}
+		}()
+	} else if ref != taskImage {
+		out, err := executils.ExecCommand(ctx, "docker", "image", "inspect", "--format",
+			`{{index .Config.Labels "`+exportTaskLabel+`"}}`, ref)
+		if err != nil {
+			return nil, fmt.Errorf("export target %s not found: %w", ref, err)
+		}
+		if strings.TrimSpace(string(out)) != task.ID.String() {
+			return nil, fmt.Errorf("export target %s was not built by this task", ref)
+		}
+	}
+
+	exported, err := e.imageExporter.Export(ctx, task.ID.String(), ref, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	reused := 0
+	for _, layer := range exported.Layers {
+		if layer.Reused {
+			reused++
+		}
+	}
+	log.Info().
+		Str("task_id", task.ID.String()).
+		Str("digest", exported.Digest).
+		Str("index_cid", exported.IndexCID).
+		Int("layers", len(exported.Layers)).
+		Int("reused_layers", reused).
+		Int64("size_bytes", exported.SizeBytes).
+		Int64("uploaded_bytes", exported.UploadedBytes).
+		Msg("Exported task image")
+	return exported, nil
+}
@@ -0,0 +1,284 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+// writeDockerSave writes a legacy `docker save` archive with the given layers
+func writeDockerSave(t *testing.T, path string, layers ...[]byte) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	add := func(name string, data []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entry := dockerArchiveManifest{Config: "config.json", RepoTags: []string{"task:latest"}}
+	for i, layer := range layers {
+		name := fmt.Sprintf("layer%d/layer.tar", i)
+		add(name, layer)
+		entry.Layers = append(entry.Layers, name)
+	}
+	add("config.json", []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers"}}`))
+	manifest, _ := json.Marshal([]dockerArchiveManifest{entry})
+	add("manifest.json", manifest)
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func digestOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func readArchive(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(tr)
+		files[hdr.Name] = data
+	}
+}
+
+func TestConvertDockerArchiveProducesOCILayout(t *testing.T) {
+	dir := t.TempDir()
+	base, app := []byte("base layer"), []byte("app layer")
+	writeDockerSave(t, filepath.Join(dir, "save.tar"), base, app)
+
+	img, err := convertDockerArchive(filepath.Join(dir, "save.tar"), dir, "example/out:v1", ImageExportLimits{})
+	if err != nil {
+		t.Fatalf("convertDockerArchive() error = %v", err)
+	}
+	if len(img.Layers) != 2 || img.Layers[0].Digest != digestOf(base) || img.Layers[1].Digest != digestOf(app) {
+		t.Fatalf("unexpected layers %+v", img.Layers)
+	}
+
+	archive := filepath.Join(dir, "image.oci.tar")
+	if err := img.WriteArchive(archive); err != nil {
+		t.Fatal(err)
+	}
+	files := readArchive(t, archive)
+
+	if string(files["oci-layout"]) != ociLayoutVersion {
+		t.Fatalf("unexpected oci-layout %q", files["oci-layout"])
+	}
+	for name, data := range files {
+		if strings.HasPrefix(name, "blobs/sha256/") && "sha256:"+strings.TrimPrefix(name, "blobs/sha256/") != digestOf(data) {
+			t.Fatalf("blob %s does not match its digest", name)
+		}
+	}
+
+	var index ociIndex
+	if err := json.Unmarshal(files["index.json"], &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 1 || index.Manifests[0].Digest != img.Manifest.Digest ||
+		index.Manifests[0].Annotations[ociRefNameAnnotation] != "example/out:v1" {
+		t.Fatalf("unexpected index %+v", index)
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(files[blobArchivePath(img.Manifest.Digest)], &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if manifest.Config.Digest != img.Config.Digest || len(manifest.Layers) != 2 || manifest.Layers[0].MediaType != ociMediaTypeLayer {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+
+	var dockerManifest []dockerArchiveManifest
+	if err := json.Unmarshal(files["manifest.json"], &dockerManifest); err != nil {
+		t.Fatal(err)
+	}
+	for _, layer := range dockerManifest[0].Layers {
+		if _, ok := files[layer]; !ok {
+			t.Fatalf("docker manifest references missing layer %s", layer)
+		}
+	}
+	if dockerManifest[0].RepoTags[0] != "example/out:v1" {
+		t.Fatalf("unexpected repo tags %v", dockerManifest[0].RepoTags)
+	}
+}
+
+func TestConvertDockerArchiveEnforcesLimits(t *testing.T) {
+	dir := t.TempDir()
+	writeDockerSave(t, filepath.Join(dir, "save.tar"), []byte("one"), []byte("two"), []byte("three"))
+
+	_, err := convertDockerArchive(filepath.Join(dir, "save.tar"), t.TempDir(), "", ImageExportLimits{MaxLayers: 2})
+	if !errors.Is(err, ErrTooManyLayers) {
+		t.Fatalf("expected layer limit error, got %v", err)
+	}
+
+	_, err = convertDockerArchive(filepath.Join(dir, "save.tar"), t.TempDir(), "", ImageExportLimits{MaxBytes: 16})
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Fatalf("expected size limit error, got %v", err)
+	}
+}
+
+func TestExporterLimitsOnlyTighten(t *testing.T) {
+	x := NewImageExporter(nil, nil, ImageExportLimits{MaxBytes: 1000, MaxLayers: 10})
+
+	if got := x.Limits(&models.ImageExportConfig{MaxBytes: 5000, MaxLayers: 20}); got.MaxBytes != 1000 || got.MaxLayers != 10 {
+		t.Fatalf("task raised runner limits: %+v", got)
+	}
+	if got := x.Limits(&models.ImageExportConfig{MaxBytes: 500, MaxLayers: 3}); got.MaxBytes != 500 || got.MaxLayers != 3 {
+		t.Fatalf("task could not tighten limits: %+v", got)
+	}
+}
+
+type fakeUploader struct {
+	mu       sync.Mutex
+	uploaded map[string][]byte
+}
+
+func (u *fakeUploader) ForTask(taskID string) artifacts.Uploader {
+	return u
+}
+
+func (u *fakeUploader) Add(ctx context.Context, name string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.uploaded == nil {
+		u.uploaded = make(map[string][]byte)
+	}
+	cid := "cid-" + digestOf(data)[7:19]
+	u.uploaded[cid] = data
+	return cid, nil
+}
+
+func TestPublishSkipsCachedLayers(t *testing.T) {
+	dir := t.TempDir()
+	base, app := []byte("shared base layer"), []byte("task output layer")
+	writeDockerSave(t, filepath.Join(dir, "save.tar"), base, app)
+
+	img, err := convertDockerArchive(filepath.Join(dir, "save.tar"), dir, "out:latest", ImageExportLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := artifacts.OpenCache(filepath.Join(t.TempDir(), "blobs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Record(digestOf(base), "cid-base"); err != nil {
+		t.Fatal(err)
+	}
+
+	uploader := &fakeUploader{}
+	x := NewImageExporter(uploader, cache, ImageExportLimits{})
+	exported, err := x.publish(context.Background(), img, uploader)
+	if err != nil {
+		t.Fatalf("publish() error = %v", err)
+	}
+
+	if exported.Digest != img.Manifest.Digest || exported.IndexCID == "" {
+		t.Fatalf("unexpected export summary %+v", exported)
+	}
+	if !exported.Layers[0].Reused || exported.Layers[0].CID != "cid-base" {
+		t.Fatalf("expected cached base layer to be reused, got %+v", exported.Layers[0])
+	}
+	if exported.Layers[1].Reused {
+		t.Fatal("expected the new layer to be uploaded")
+	}
+	if want := int64(len(app)) + img.Config.Size + img.Manifest.Size; exported.UploadedBytes != want {
+		t.Fatalf("expected %d uploaded bytes, got %d", want, exported.UploadedBytes)
+	}
+	for _, data := range uploader.uploaded {
+		if bytes.Equal(data, base) {
+			t.Fatal("cached layer was uploaded again")
+		}
+	}
+
+	var index exportIndex
+	if err := json.Unmarshal(uploader.uploaded[exported.IndexCID], &index); err != nil {
+		t.Fatal(err)
+	}
+	for _, digest := range []string{digestOf(base), digestOf(app), img.Config.Digest, img.Manifest.Digest} {
+		if index.Blobs[digest] == "" {
+			t.Fatalf("export index does not name blob %s", digest)
+		}
+	}
+
+	again, err := x.publish(context.Background(), img, uploader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.UploadedBytes != 0 {
+		t.Fatalf("expected a repeated export to upload nothing, got %d bytes", again.UploadedBytes)
+	}
+}
+
+func TestExportedArchiveLoadsAndRuns(t *testing.T) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker not available")
+	}
+	ctx := context.Background()
+	if _, err := executils.ExecCommand(ctx, "docker", "image", "inspect", "busybox:latest"); err != nil {
+		t.Skip("busybox image not available locally")
+	}
+
+	dir := t.TempDir()
+	x := NewImageExporter(&fakeUploader{}, nil, ImageExportLimits{MaxBytes: 64 << 20, MaxLayers: 8})
+	archive := filepath.Join(dir, "image.oci.tar")
+	tag := "parity-export-test/busybox:latest"
+	if _, err := x.save(ctx, "busybox:latest", tag, dir, archive, x.Limits(nil)); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	defer executils.ExecCommand(context.Background(), "docker", "rmi", tag)
+
+	if _, err := executils.ExecCommand(ctx, "docker", "load", "-i", archive); err != nil {
+		t.Fatalf("docker load error = %v", err)
+	}
+	out, err := executils.ExecCommand(ctx, "docker", "run", "--rm", tag, "echo", "exported")
+	if err != nil {
+		t.Fatalf("docker run error = %v", err)
+	}
+	if !strings.Contains(string(out), "exported") {
+		t.Fatalf("unexpected output %q", out)
+	}
+}
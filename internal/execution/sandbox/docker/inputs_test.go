@@ -0,0 +1,58 @@
+package docker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+)
+
+func TestInputMounts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	manager, err := inputs.NewManager(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set, err := manager.Prepare(context.Background(), []models.TaskInput{
+		{Name: "weights", Source: models.InputSource{URL: server.URL}, SHA256: "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73", TargetPath: "/models//weights.bin"},
+		{Name: "scratch", Source: models.InputSource{URL: server.URL}, TargetPath: "/work/scratch.bin", Mode: models.InputModeReadWrite},
+	}, models.TaskTypeDocker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	mounts := inputMounts(set)
+	if len(mounts) != 2 {
+		t.Fatalf("inputMounts() = %+v, want one mount per input", mounts)
+	}
+	weights, scratch := mounts[0], mounts[1]
+	if weights.Target != "/models/weights.bin" || !weights.ReadOnly {
+		t.Fatalf("read-only input mounted as %+v", weights)
+	}
+	if filepath.Dir(weights.Source) != cacheDir {
+		t.Fatalf("read-only input mounted from %s, want the cached file in %s", weights.Source, cacheDir)
+	}
+	if scratch.Target != "/work/scratch.bin" || scratch.ReadOnly {
+		t.Fatalf("read-write input mounted as %+v", scratch)
+	}
+	if !strings.HasPrefix(scratch.Source, cacheDir+string(filepath.Separator)+".staging-") {
+		t.Fatalf("read-write input mounted from %s, want a private copy", scratch.Source)
+	}
+
+	if _, err := manager.Prepare(context.Background(), []models.TaskInput{
+		{Name: "escape", Source: models.InputSource{URL: server.URL}, TargetPath: "/parity/output/escape"},
+	}, models.TaskTypeDocker); err == nil {
+		t.Fatal("Prepare() accepted an input over the output directory")
+	}
+}
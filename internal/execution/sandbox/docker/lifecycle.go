@@ -0,0 +1,539 @@
+package docker
+
+// Task containers are started with a lifecycle contract, so that tasks can
+// cooperate with cancellation and checkpointing. Besides the variables that
+// describe their limits, containers get:
+//
+//	PARITY_TASK_ID             the task's ID
+//	PARITY_DEADLINE_UNIX       when, in Unix seconds, the task is stopped if still running
+//	PARITY_STOP_SIGNAL         the signal that stops it, always SIGTERM
+//	PARITY_STOP_GRACE_SECONDS  how long it has after that signal before SIGKILL
+//	PARITY_CHECKPOINT_DIR      a writable directory for checkpoints
+//	PARITY_PROGRESS_FIFO       a named pipe for reports to the runner
+//	PARITY_RESUME_CHECKPOINT   for a task migrated from another runner, the
+//	                           checkpoint it last reported there
+//
+// The stop signal is sent when the deadline passes and whenever the runner
+// cancels the task. Reports are lines written to the pipe:
+//
+//	progress <percent> [message]
+//	checkpoint <name>
+//
+// where name is the path, within PARITY_CHECKPOINT_DIR, of a checkpoint the
+// task has finished writing. The runner relays progress to the server and
+// uploads checkpoints before reporting them. Tasks should open the pipe for
+// each report: the runner keeps it open, but may stop reading while another
+// runner process takes the task over.
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/migration"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+)
+
+const (
+	// ContainerLifecycleDir holds the progress pipe and checkpoint directory
+	// inside task containers
+	ContainerLifecycleDir  = "/parity/lifecycle"
+	ContainerProgressFIFO  = ContainerLifecycleDir + "/" + progressFIFOName
+	ContainerCheckpointDir = ContainerLifecycleDir + "/" + checkpointDirName
+
+	// StopSignal is sent to a task container that is cancelled or runs past
+	// its deadline, a grace period before it is killed
+	StopSignal = "SIGTERM"
+	// DefaultStopGracePeriod is the grace period unless the runner sets one
+	DefaultStopGracePeriod = 10 * time.Second
+
+	progressFIFOName  = "progress"
+	checkpointDirName = "checkpoints"
+
+	// progressInterval is the least time between relayed progress reports;
+	// reports in between are coalesced into the latest
+	progressInterval = time.Second
+	// maxReportLine caps the length of a report line
+	maxReportLine = 4096
+	// fifoDrainTimeout is how long reports still in the pipe are read once
+	// the container has exited
+	fifoDrainTimeout = 100 * time.Millisecond
+)
+
+// errFIFOUnsupported is returned where named pipes cannot be created
+var errFIFOUnsupported = errors.New("named pipes are not supported on this platform")
+
+// ProgressSink receives the progress running tasks report
+type ProgressSink interface {
+	ReportProgress(taskID string, progress *models.TaskProgress) error
+}
+
+// SetProgressSink relays the progress and checkpoints tasks report through
+// their progress pipe to sink
+func (e *DockerExecutor) SetProgressSink(sink ProgressSink) {
+	e.progress = sink
+}
+
+// SetCheckpointUploaders uploads the checkpoints tasks announce through the
+// uploaders source hands out, before they are reported to the progress sink.
+// Without uploaders checkpoint announcements are ignored.
+func (e *DockerExecutor) SetCheckpointUploaders(source artifacts.UploaderSource) {
+	e.checkpoints = source
+}
+
+// SetStopGracePeriod sets how long a cancelled or timed out task has between
+// StopSignal and SIGKILL
+func (e *DockerExecutor) SetStopGracePeriod(grace time.Duration) {
+	if grace <= 0 {
+		grace = DefaultStopGracePeriod
+	}
+	e.containerMgr.stopGrace = grace
+}
+
+// newLifecycleDir creates the host directory of taskID mounted at
+// ContainerLifecycleDir, holding the progress pipe and checkpoint directory.
+// It reports whether the pipe could be created; where it cannot, tasks get
+// no PARITY_PROGRESS_FIFO.
+func newLifecycleDir(taskID string) (dir string, fifo bool, err error) {
+	dir, err = os.MkdirTemp("", retention.ScratchPattern("lifecycle", taskID))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to create lifecycle directory: %w", err)
+	}
+	// Tasks may run as any user: they can write checkpoints and reports but
+	// not list or read the directory's other entries
+	if err := os.Chmod(dir, 0o711); err != nil {
+		os.RemoveAll(dir)
+		return "", false, fmt.Errorf("failed to create lifecycle directory: %w", err)
+	}
+	checkpoints := filepath.Join(dir, checkpointDirName)
+	if err := os.Mkdir(checkpoints, 0o700); err != nil {
+		os.RemoveAll(dir)
+		return "", false, fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	if err := os.Chmod(checkpoints, 0o777); err != nil {
+		os.RemoveAll(dir)
+		return "", false, fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+
+	err = makeFIFO(filepath.Join(dir, progressFIFOName))
+	if errors.Is(err, errFIFOUnsupported) {
+		return dir, false, nil
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", false, fmt.Errorf("failed to create progress pipe: %w", err)
+	}
+	return dir, true, nil
+}
+
+// lifecycleEnv describes the lifecycle contract to a task stopped at
+// deadline, with grace between StopSignal and SIGKILL
+func lifecycleEnv(task *models.Task, deadline time.Time, grace time.Duration, fifo bool) []string {
+	env := []string{
+		"PARITY_TASK_ID=" + task.ID.String(),
+		"PARITY_DEADLINE_UNIX=" + strconv.FormatInt(deadline.Unix(), 10),
+		"PARITY_STOP_SIGNAL=" + StopSignal,
+		"PARITY_STOP_GRACE_SECONDS=" + strconv.Itoa(int(math.Ceil(grace.Seconds()))),
+		"PARITY_CHECKPOINT_DIR=" + ContainerCheckpointDir,
+	}
+	if fifo {
+		env = append(env, "PARITY_PROGRESS_FIFO="+ContainerProgressFIFO)
+	}
+	return env
+}
+
+// restoreCheckpoint fetches checkpoint, the last a migrated task reported on
+// the runner it left, into the checkpoint directory under lifecycleDir,
+// returning its path inside the container
+func (e *DockerExecutor) restoreCheckpoint(ctx context.Context, taskID, lifecycleDir string, checkpoint *models.TaskCheckpoint) (string, error) {
+	if e.inputs == nil {
+		return "", errors.New("task inputs are not enabled on this runner")
+	}
+	if !filepath.IsLocal(checkpoint.Name) {
+		return "", fmt.Errorf("checkpoint %q is outside the checkpoint directory", checkpoint.Name)
+	}
+	scratch, err := os.MkdirTemp("", retention.ScratchPattern("resume", taskID))
+	if err != nil {
+		return "", fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+	var path string
+	if checkpoint.Root != "" {
+		path, err = e.assembleCheckpoint(ctx, checkpoint, scratch)
+	} else {
+		path, err = e.inputs.Fetch(ctx, &models.TaskInput{
+			Name:   "checkpoint",
+			Source: migration.Source(checkpoint.CID),
+			Size:   checkpoint.Size,
+		}, scratch)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch checkpoint: %w", err)
+	}
+
+	// The task may write over it, as any user
+	target := filepath.Join(lifecycleDir, checkpointDirName, checkpoint.Name)
+	if dir := filepath.Dir(target); dir != filepath.Join(lifecycleDir, checkpointDirName) {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return "", fmt.Errorf("failed to restore checkpoint: %w", err)
+		}
+		if err := os.Chmod(dir, 0o777); err != nil {
+			return "", fmt.Errorf("failed to restore checkpoint: %w", err)
+		}
+	}
+	if err := copyCheckpoint(path, target); err != nil {
+		return "", fmt.Errorf("failed to restore checkpoint: %w", err)
+	}
+	return ContainerCheckpointDir + "/" + filepath.ToSlash(checkpoint.Name), nil
+}
+
+// assembleCheckpoint fetches the manifest of checkpoint, uploaded as
+// chunks, and each chunk it lists into scratch, checking them against the
+// checkpoint's root. Chunks are fetched by their hash, so those already in
+// the input cache are not downloaded again.
+func (e *DockerExecutor) assembleCheckpoint(ctx context.Context, checkpoint *models.TaskCheckpoint, scratch string) (string, error) {
+	manifestPath, err := e.inputs.Fetch(ctx, &models.TaskInput{
+		Name:   "checkpoint.manifest.json",
+		Source: migration.Source(checkpoint.CID),
+	}, scratch)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(manifestPath)
+	if err != nil {
+		return "", err
+	}
+	manifest, err := artifacts.ReadManifest(f, checkpoint.Root)
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(scratch, "checkpoint")
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return "", err
+	}
+	err = artifacts.Assemble(ctx, manifest, func(ctx context.Context, index int, chunk models.ArtifactChunk) (io.ReadCloser, error) {
+		chunkPath, err := e.inputs.Fetch(ctx, &models.TaskInput{
+			Name:   fmt.Sprintf("checkpoint-chunk-%d", index),
+			Source: migration.Source(chunk.CID),
+			SHA256: chunk.Hash,
+			Size:   chunk.Size,
+		}, scratch)
+		if err != nil {
+			return nil, err
+		}
+		return os.Open(chunkPath)
+	}, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func copyCheckpoint(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, 0o666)
+}
+
+// progressRelay reads a task's reports from its progress pipe and relays
+// them. Reading never waits on the server, so that writing a report never
+// blocks the task for longer than the pipe takes to drain.
+type progressRelay struct {
+	taskID      string
+	checkpoints string
+	sink        ProgressSink
+	uploader    artifacts.Uploader
+	clock       clock.Clock
+	interval    time.Duration
+	pipe        *os.File
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wake   chan struct{}
+	read   chan struct{}
+	sent   chan struct{}
+
+	mu        sync.Mutex
+	percent   float64
+	pending   *models.TaskProgress
+	announced []string
+	// chunked lists the checkpoints uploaded as chunks
+	chunked []models.ChunkedArtifact
+}
+
+// startProgressRelay starts relaying the reports a task writes to the
+// progress pipe in dir. The pipe is read even without a progress sink, as
+// tasks block writing to a pipe nothing reads. It returns nil when dir has no
+// pipe.
+func (e *DockerExecutor) startProgressRelay(task *models.Task, dir string) *progressRelay {
+	log := gologger.WithComponent("docker.lifecycle")
+
+	if dir == "" {
+		return nil
+	}
+	pipe, err := openFIFO(filepath.Join(dir, progressFIFOName))
+	if err != nil {
+		if !errors.Is(err, errFIFOUnsupported) && !errors.Is(err, os.ErrNotExist) {
+			log.Warn().Err(err).Str("task_id", task.ID.String()).Msg("Failed to open progress pipe")
+		}
+		return nil
+	}
+
+	r := &progressRelay{
+		taskID:      task.ID.String(),
+		checkpoints: filepath.Join(dir, checkpointDirName),
+		sink:        e.progress,
+		clock:       e.containerMgr.clock,
+		interval:    progressInterval,
+		pipe:        pipe,
+		wake:        make(chan struct{}, 1),
+		read:        make(chan struct{}),
+		sent:        make(chan struct{}),
+	}
+	if e.checkpoints != nil {
+		r.uploader = e.checkpoints.ForTask(r.taskID)
+	}
+	r.ctx, r.cancel = context.WithCancel(context.Background())
+	go r.readReports()
+	go r.relay()
+	return r
+}
+
+// stop reads the reports left in the pipe and waits until ctx is done for
+// those read to be relayed
+func (r *progressRelay) stop(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	r.pipe.SetReadDeadline(time.Now().Add(fifoDrainTimeout))
+	select {
+	case <-r.sent:
+	case <-ctx.Done():
+	}
+	r.cancel()
+	<-r.sent
+}
+
+// chunkedArtifacts returns the checkpoints the relay uploaded as chunks
+func (r *progressRelay) chunkedArtifacts() []models.ChunkedArtifact {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.chunked)
+}
+
+// abandon stops reading reports without relaying those not yet sent, for
+// a task another runner process takes over
+func (r *progressRelay) abandon() {
+	if r == nil {
+		return
+	}
+	r.cancel()
+	r.pipe.Close()
+	<-r.sent
+}
+
+func (r *progressRelay) readReports() {
+	log := gologger.WithComponent("docker.lifecycle")
+	defer close(r.read)
+	defer r.pipe.Close()
+
+	scanner := bufio.NewScanner(r.pipe)
+	scanner.Buffer(make([]byte, 0, 256), maxReportLine)
+	for scanner.Scan() {
+		if err := r.handle(scanner.Text()); err != nil {
+			log.Debug().Err(err).Str("task_id", r.taskID).Msg("Ignoring task report")
+		}
+	}
+}
+
+// handle records one report line for relaying
+func (r *progressRelay) handle(line string) error {
+	kind, rest, _ := strings.Cut(strings.TrimSpace(line), " ")
+	rest = strings.TrimSpace(rest)
+
+	if r.sink == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch kind {
+	case "progress":
+		value, message, _ := strings.Cut(rest, " ")
+		percent, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(percent) || percent < 0 || percent > 100 {
+			return fmt.Errorf("invalid progress %q", value)
+		}
+		r.percent = percent
+		r.pending = &models.TaskProgress{Percent: percent, Message: strings.TrimSpace(message), Time: r.clock.Now()}
+	case "checkpoint":
+		if rest == "" || !filepath.IsLocal(rest) {
+			return fmt.Errorf("invalid checkpoint name %q", rest)
+		}
+		if r.uploader == nil {
+			return fmt.Errorf("checkpoint uploads are not enabled on this runner")
+		}
+		r.announced = append(r.announced, filepath.Clean(rest))
+	default:
+		return fmt.Errorf("unknown report %q", kind)
+	}
+
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// relay sends recorded reports until the reader has stopped and nothing is
+// left to send, or the relay is cancelled
+func (r *progressRelay) relay() {
+	log := gologger.WithComponent("docker.lifecycle")
+	defer close(r.sent)
+
+	read := r.read
+	var next time.Time
+	for {
+		r.mu.Lock()
+		announced := r.announced
+		r.announced = nil
+		progressed := r.pending != nil
+		r.mu.Unlock()
+
+		for _, name := range announced {
+			if err := r.uploadCheckpoint(name); err != nil {
+				log.Warn().Err(err).Str("task_id", r.taskID).Str("checkpoint", name).Msg("Failed to upload task checkpoint")
+			}
+		}
+
+		if progressed {
+			// Reports arriving while the relay waits replace the one it
+			// waits to send, unless the task has exited
+			if wait := next.Sub(r.clock.Now()); wait > 0 && read != nil {
+				select {
+				case <-r.clock.After(wait):
+				case <-read:
+					read = nil
+				case <-r.ctx.Done():
+					return
+				}
+			}
+			r.mu.Lock()
+			pending := r.pending
+			r.pending = nil
+			r.mu.Unlock()
+			if err := r.sink.ReportProgress(r.taskID, pending); err != nil {
+				log.Warn().Err(err).Str("task_id", r.taskID).Msg("Failed to relay task progress")
+			}
+			next = r.clock.Now().Add(r.interval)
+		}
+
+		if read == nil {
+			r.mu.Lock()
+			idle := r.pending == nil && len(r.announced) == 0
+			r.mu.Unlock()
+			if idle {
+				return
+			}
+			continue
+		}
+		select {
+		case <-r.wake:
+		case <-read:
+			read = nil
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// uploadCheckpoint uploads the checkpoint name and reports it. The
+// checkpoint directory is writable by the task, so the file is opened only
+// if no part of its path is a symbolic link that could lead out of it.
+func (r *progressRelay) uploadCheckpoint(name string) error {
+	path := r.checkpoints
+	var info os.FileInfo
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		path = filepath.Join(path, part)
+		var err error
+		if info, err = os.Lstat(path); err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("checkpoint path %s is a symbolic link", name)
+		}
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("checkpoint %s is not a regular file", name)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	opened, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(info, opened) {
+		return fmt.Errorf("checkpoint %s changed while it was opened", name)
+	}
+
+	cid, chunked, err := artifacts.Upload(r.ctx, r.uploader, name, f)
+	if err != nil {
+		return err
+	}
+	checkpoint := &models.TaskCheckpoint{Name: filepath.ToSlash(name), CID: cid, Size: opened.Size()}
+	r.mu.Lock()
+	if chunked != nil {
+		chunked.Name = checkpoint.Name
+		checkpoint.Root = chunked.Root
+		r.chunked = append(r.chunked, *chunked)
+	}
+	progress := &models.TaskProgress{
+		Percent:    r.percent,
+		Time:       r.clock.Now(),
+		Checkpoint: checkpoint,
+	}
+	r.mu.Unlock()
+	return r.sink.ReportProgress(r.taskID, progress)
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package docker
+
+import (
+	"os"
+	"syscall"
+)
+
+// makeFIFO creates the named pipe tasks write reports to. Tasks may run as
+// any user, so anyone may write to it.
+func makeFIFO(path string) error {
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0o622)
+}
+
+// openFIFO opens the named pipe at path for reading. It is opened for
+// writing too, so that the open does not wait for a writer and reads do not
+// end when a task closes its end.
+func openFIFO(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_RDWR, 0)
+}
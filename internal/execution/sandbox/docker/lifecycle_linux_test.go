@@ -0,0 +1,308 @@
+//go:build linux
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+// progressRecorder keeps the progress reported
+type progressRecorder struct {
+	mu      sync.Mutex
+	reports []*models.TaskProgress
+}
+
+func (r *progressRecorder) ReportProgress(taskID string, progress *models.TaskProgress) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reports = append(r.reports, progress)
+	return nil
+}
+
+// split returns the plain progress reports and the checkpoints reported
+func (r *progressRecorder) split() (progress []*models.TaskProgress, checkpoints []*models.TaskCheckpoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, report := range r.reports {
+		if report.Checkpoint != nil {
+			checkpoints = append(checkpoints, report.Checkpoint)
+		} else {
+			progress = append(progress, report)
+		}
+	}
+	return progress, checkpoints
+}
+
+func newLifecycleExecutor(sink ProgressSink, uploader *fakeUploader) *DockerExecutor {
+	e := &DockerExecutor{
+		config:       &ExecutorConfig{},
+		containerMgr: &ContainerManager{clock: clock.Real(), stopGrace: DefaultStopGracePeriod, daemon: cliDaemon{}},
+		progress:     sink,
+	}
+	if uploader != nil {
+		e.checkpoints = uploader
+	}
+	return e
+}
+
+func newTestLifecycleDir(t *testing.T) string {
+	t.Helper()
+	dir, fifo, err := newLifecycleDir("task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	if !fifo {
+		t.Fatal("no progress pipe created on linux")
+	}
+	return dir
+}
+
+// writeReports writes lines to the progress pipe in dir as a task would
+func writeReports(dir string, lines ...string) error {
+	pipe, err := os.OpenFile(filepath.Join(dir, progressFIFOName), os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer pipe.Close()
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(pipe, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestProgressRelayReportsProgressAndCheckpoints(t *testing.T) {
+	dir := newTestLifecycleDir(t)
+	checkpoints := filepath.Join(dir, checkpointDirName)
+	if err := os.MkdirAll(filepath.Join(checkpoints, "step"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(checkpoints, "step", "1.ckpt"), []byte("weights"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("host secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(secret, filepath.Join(checkpoints, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Dir(secret), filepath.Join(checkpoints, "outside")); err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &progressRecorder{}
+	uploader := &fakeUploader{}
+	e := newLifecycleExecutor(sink, uploader)
+	relay := e.startProgressRelay(&models.Task{ID: uuid.New()}, dir)
+	if relay == nil {
+		t.Fatal("no relay started")
+	}
+
+	if err := writeReports(dir,
+		"progress 10",
+		"progress 150",
+		"progress NaN",
+		"restart now",
+		"checkpoint step/1.ckpt",
+		"checkpoint escape",
+		"checkpoint outside/secret",
+		"checkpoint ../secret",
+		"progress 40 halfway there",
+	); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	relay.stop(ctx)
+
+	progress, reported := sink.split()
+	if len(progress) == 0 {
+		t.Fatal("no progress reported")
+	}
+	last := progress[len(progress)-1]
+	if last.Percent != 40 || last.Message != "halfway there" {
+		t.Errorf("last progress = %+v, want 40%% halfway there", last)
+	}
+	for _, p := range progress {
+		if p.Percent != 10 && p.Percent != 40 {
+			t.Errorf("invalid progress %v relayed", p.Percent)
+		}
+	}
+
+	if len(reported) != 1 {
+		t.Fatalf("checkpoints reported = %+v, want only step/1.ckpt", reported)
+	}
+	checkpoint := reported[0]
+	if checkpoint.Name != "step/1.ckpt" || checkpoint.Size != int64(len("weights")) || string(uploader.uploaded[checkpoint.CID]) != "weights" {
+		t.Errorf("checkpoint = %+v, want step/1.ckpt uploaded", checkpoint)
+	}
+	if len(uploader.uploaded) != 1 {
+		t.Errorf("%d files uploaded, want only the checkpoint", len(uploader.uploaded))
+	}
+}
+
+func TestProgressPipeIsReadWithoutSink(t *testing.T) {
+	dir := newTestLifecycleDir(t)
+	e := newLifecycleExecutor(nil, nil)
+	relay := e.startProgressRelay(&models.Task{ID: uuid.New()}, dir)
+	if relay == nil {
+		t.Fatal("no relay started")
+	}
+	defer relay.stop(context.Background())
+
+	// More than a pipe buffer of reports, which would block a task were the
+	// pipe not read
+	lines := make([]string, 10000)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("progress %d", i%100)
+	}
+	written := make(chan error, 1)
+	go func() {
+		written <- writeReports(dir, lines...)
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("writing reports blocked")
+	}
+}
+
+// runLifecycleFixture runs testdata/lifecycle/task.sh until it reports it is
+// ready, then cancels it as the runner would, returning its exit code, its
+// logs and how long it took to stop
+func runLifecycleFixture(t *testing.T, cm *ContainerManager, e *DockerExecutor, env ...string) (int, string, time.Duration) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	fixture, err := filepath.Abs(filepath.Join("testdata", "lifecycle", "task.sh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := newTestLifecycleDir(t)
+	task := &models.Task{ID: uuid.New()}
+	env = append(env, lifecycleEnv(task, time.Now().Add(time.Hour), cm.stopGrace, true)...)
+
+	containerID, err := cm.CreateContainerWithOptions(ctx, "alpine:latest", "/", env, ContainerOptions{
+		Mounts: []Mount{
+			{Source: dir, Target: ContainerLifecycleDir},
+			{Source: fixture, Target: "/fixture/task.sh", ReadOnly: true},
+		},
+		Command: []string{"sh", "/fixture/task.sh"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm.RemoveContainer(context.Background(), containerID)
+
+	relay := e.startProgressRelay(task, dir)
+	if err := cm.StartContainer(ctx, containerID); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		logs, _ := cm.GetContainerLogs(ctx, containerID)
+		if strings.Contains(logs, "ready") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("fixture did not become ready, logs: %q", logs)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	waitCtx, stop := context.WithCancel(ctx)
+	stop()
+	start := time.Now()
+	if _, err := cm.WaitForContainerOrDetach(waitCtx, containerID, nil); err != context.Canceled {
+		t.Fatalf("WaitForContainerOrDetach() error = %v, want context.Canceled", err)
+	}
+	stopped := time.Since(start)
+	relay.stop(ctx)
+
+	out, err := executils.ExecCommand(ctx, "docker", "inspect", "--format", "{{.State.Running}} {{.State.ExitCode}}", containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var running bool
+	var exitCode int
+	if _, err := fmt.Sscan(string(out), &running, &exitCode); err != nil || running {
+		t.Fatalf("container state %q, want it stopped", out)
+	}
+	logs, err := cm.GetContainerLogs(ctx, containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exitCode, logs, stopped
+}
+
+func TestTaskLifecycleContract(t *testing.T) {
+	if _, err := executils.ExecCommand(context.Background(), "docker", "version"); err != nil {
+		t.Skip("docker is not available")
+	}
+	if _, err := executils.ExecCommand(context.Background(), "docker", "image", "inspect", "alpine:latest"); err != nil {
+		t.Skip("alpine image not available locally")
+	}
+
+	cm, err := NewContainerManager("64m", "0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm.stopGrace = 3 * time.Second
+
+	t.Run("stops on SIGTERM", func(t *testing.T) {
+		sink := &progressRecorder{}
+		uploader := &fakeUploader{}
+		e := newLifecycleExecutor(sink, uploader)
+		e.containerMgr = cm
+
+		exitCode, logs, stopped := runLifecycleFixture(t, cm, e)
+		if exitCode != 0 || !strings.Contains(logs, "stopping within 3s") {
+			t.Fatalf("exit code %d, logs %q, want the task to stop on SIGTERM", exitCode, logs)
+		}
+		if stopped >= cm.stopGrace {
+			t.Errorf("stopping took %s, want less than the grace period", stopped)
+		}
+
+		progress, checkpoints := sink.split()
+		if len(progress) == 0 || progress[len(progress)-1].Percent != 100 || progress[len(progress)-1].Message != "stopped" {
+			t.Errorf("progress = %+v, want the last report written on SIGTERM", progress)
+		}
+		if len(checkpoints) != 1 || string(uploader.uploaded[checkpoints[0].CID]) != "state at step 1\n" {
+			t.Errorf("checkpoints = %+v, want step-1.ckpt uploaded", checkpoints)
+		}
+	})
+
+	t.Run("killed after grace period", func(t *testing.T) {
+		e := newLifecycleExecutor(&progressRecorder{}, nil)
+		e.containerMgr = cm
+
+		exitCode, _, stopped := runLifecycleFixture(t, cm, e, "IGNORE_TERM=1")
+		if exitCode != 137 {
+			t.Fatalf("exit code %d, want 137 from SIGKILL", exitCode)
+		}
+		if stopped < cm.stopGrace {
+			t.Errorf("killed after %s, want the %s grace period first", stopped, cm.stopGrace)
+		}
+	})
+}
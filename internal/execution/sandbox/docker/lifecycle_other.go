@@ -0,0 +1,15 @@
+//go:build !linux
+
+package docker
+
+import "os"
+
+// makeFIFO is unsupported where docker runs containers in a virtual machine,
+// which named pipes on the host do not reach
+func makeFIFO(path string) error {
+	return errFIFOUnsupported
+}
+
+func openFIFO(path string) (*os.File, error) {
+	return nil, errFIFOUnsupported
+}
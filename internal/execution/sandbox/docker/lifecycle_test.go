@@ -0,0 +1,45 @@
+package docker
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func TestLifecycleEnv(t *testing.T) {
+	task := &models.Task{ID: uuid.New()}
+	deadline := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+
+	env := envMap(lifecycleEnv(task, deadline, 2500*time.Millisecond, true))
+	want := map[string]string{
+		"PARITY_TASK_ID":            task.ID.String(),
+		"PARITY_DEADLINE_UNIX":      strconv.FormatInt(deadline.Unix(), 10),
+		"PARITY_STOP_SIGNAL":        "SIGTERM",
+		"PARITY_STOP_GRACE_SECONDS": "3",
+		"PARITY_CHECKPOINT_DIR":     "/parity/lifecycle/checkpoints",
+		"PARITY_PROGRESS_FIFO":      "/parity/lifecycle/progress",
+	}
+	for key, value := range want {
+		if env[key] != value {
+			t.Errorf("%s = %q, want %q", key, env[key], value)
+		}
+	}
+
+	if _, ok := envMap(lifecycleEnv(task, deadline, time.Second, false))["PARITY_PROGRESS_FIFO"]; ok {
+		t.Error("PARITY_PROGRESS_FIFO set without a progress pipe")
+	}
+}
+
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		m[key] = value
+	}
+	return m
+}
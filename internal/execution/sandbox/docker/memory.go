@@ -0,0 +1,217 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+)
+
+// MemorySignal is sent to a task whose memory usage stays above its soft
+// limit, asking it to release memory before the hard limit ends it
+const MemorySignal = "SIGUSR1"
+
+// defaultMemorySustain is how long usage must stay above the soft limit
+// before the task is signalled, unless the policy sets one
+const defaultMemorySustain = 10 * time.Second
+
+// MemoryPolicy configures two-tier memory enforcement. The hard limit ends
+// the task; above the soft limit the kernel reclaims the container's memory
+// and, when usage stays there, the task is signalled.
+type MemoryPolicy struct {
+	// SoftRatio places the soft limit at this fraction of the hard limit;
+	// zero disables the soft limit
+	SoftRatio float64
+	// Sustain is how long usage must stay above the soft limit before the
+	// task is signalled
+	Sustain time.Duration
+}
+
+// WarningSink receives warnings about running tasks
+type WarningSink interface {
+	SendTaskWarning(taskID string, warning *models.TaskWarning) error
+}
+
+// MemoryLimits are a task's memory limits in bytes; Soft is zero when the
+// soft limit is disabled
+type MemoryLimits struct {
+	Hard uint64
+	Soft uint64
+}
+
+// SetMemoryPolicy enables a soft memory limit below each task's hard limit
+func (e *DockerExecutor) SetMemoryPolicy(policy MemoryPolicy) {
+	e.memory = policy
+}
+
+// SetWarningSink sends warnings about running tasks, such as sustained
+// memory pressure, to sink
+func (e *DockerExecutor) SetWarningSink(sink WarningSink) {
+	e.warnings = sink
+}
+
+// memoryLimits returns a task's limits: the memory its resources declare,
+// capped at the runner's limit, with the soft limit placed below it by the
+// policy
+func (e *DockerExecutor) memoryLimits(resources models.ResourceConfig) (MemoryLimits, error) {
+	hard, err := gpu.ParseBytes(e.config.MemoryLimit)
+	if err != nil {
+		return MemoryLimits{}, fmt.Errorf("invalid memory limit: %w", err)
+	}
+	if resources.Memory != "" {
+		declared, err := gpu.ParseBytes(resources.Memory)
+		if err != nil {
+			return MemoryLimits{}, fmt.Errorf("invalid task memory: %w", err)
+		}
+		if declared > 0 && (hard == 0 || declared < hard) {
+			hard = declared
+		}
+	}
+
+	limits := MemoryLimits{Hard: hard}
+	if ratio := e.memory.SoftRatio; ratio > 0 && ratio < 1 && hard > 0 {
+		limits.Soft = uint64(float64(hard) * ratio)
+	}
+	return limits, nil
+}
+
+// env describes the limits to the task. PARITY_MEMORY_SIGNAL names the
+// signal sent when usage stays above PARITY_MEMORY_SOFT_LIMIT.
+func (l MemoryLimits) env() []string {
+	if l.Hard == 0 {
+		return nil
+	}
+	env := []string{"PARITY_MEMORY_HARD_LIMIT=" + strconv.FormatUint(l.Hard, 10)}
+	if l.Soft > 0 {
+		env = append(env,
+			"PARITY_MEMORY_SOFT_LIMIT="+strconv.FormatUint(l.Soft, 10),
+			"PARITY_MEMORY_SIGNAL="+MemorySignal,
+		)
+	}
+	return env
+}
+
+// memoryPressure follows a task's memory usage against its soft limit
+type memoryPressure struct {
+	soft    uint64
+	sustain time.Duration
+
+	mu         sync.Mutex
+	peak       uint64
+	above      time.Duration
+	last       time.Time
+	aboveSince time.Time
+	signalled  bool
+}
+
+func newMemoryPressure(soft uint64, sustain time.Duration) *memoryPressure {
+	if sustain <= 0 {
+		sustain = defaultMemorySustain
+	}
+	return &memoryPressure{soft: soft, sustain: sustain}
+}
+
+// observe records a usage sample and reports whether the task should be
+// signalled: once each time usage has stayed above the soft limit for the
+// sustain period
+func (p *memoryPressure) observe(now time.Time, usage uint64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if usage > p.peak {
+		p.peak = usage
+	}
+	if p.soft == 0 {
+		return false
+	}
+	if !p.aboveSince.IsZero() && !p.last.IsZero() {
+		// The interval since the previous sample counts as above the limit
+		// if usage was above it then
+		p.above += now.Sub(p.last)
+	}
+	p.last = now
+
+	if usage <= p.soft {
+		p.aboveSince = time.Time{}
+		p.signalled = false
+		return false
+	}
+	if p.aboveSince.IsZero() {
+		p.aboveSince = now
+	}
+	if p.signalled || now.Sub(p.aboveSince) < p.sustain {
+		return false
+	}
+	p.signalled = true
+	return true
+}
+
+// usage returns the peak usage and the time spent above the soft limit
+func (p *memoryPressure) usage() (peak uint64, above time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.peak, p.above
+}
+
+// watchMemory follows the container's memory usage through monitor. Each
+// time usage stays above the soft limit the task is signalled and a warning
+// is sent; only the kernel's hard limit ends it.
+func (e *DockerExecutor) watchMemory(task *models.Task, containerID string, limits MemoryLimits, monitor *ResourceMonitor) *memoryPressure {
+	pressure := newMemoryPressure(limits.Soft, e.memory.Sustain)
+	monitor.SetMemoryObserver(func(now time.Time, usage uint64) {
+		if pressure.observe(now, usage) {
+			e.relieveMemory(task, containerID, limits, usage)
+		}
+	})
+	return pressure
+}
+
+// relieveMemory signals the task to release memory and warns the server
+func (e *DockerExecutor) relieveMemory(task *models.Task, containerID string, limits MemoryLimits, usage uint64) {
+	log := gologger.WithComponent("docker.memory")
+
+	log.Warn().
+		Str("task_id", task.ID.String()).
+		Str("container_id", containerID).
+		Uint64("usage", usage).
+		Uint64("soft_limit", limits.Soft).
+		Uint64("hard_limit", limits.Hard).
+		Msg("Task memory above soft limit, signalling task")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.containerMgr.SignalContainer(ctx, containerID, MemorySignal); err != nil {
+		log.Warn().Err(err).
+			Str("task_id", task.ID.String()).
+			Str("container_id", containerID).
+			Msg("Failed to signal task about memory pressure")
+	}
+
+	if e.warnings == nil {
+		return
+	}
+	warning := &models.TaskWarning{
+		Code: models.TaskWarningMemorySoftLimit,
+		Message: fmt.Sprintf("memory usage %d bytes above soft limit %d bytes; task sent %s",
+			usage, limits.Soft, MemorySignal),
+		Time:            e.containerMgr.clock.Now(),
+		MemoryUsage:     usage,
+		MemorySoftLimit: limits.Soft,
+		MemoryHardLimit: limits.Hard,
+	}
+	// Sent in the background so that a slow server does not hold up
+	// metrics collection
+	go func() {
+		if err := e.warnings.SendTaskWarning(task.ID.String(), warning); err != nil {
+			log.Warn().Err(err).
+				Str("task_id", task.ID.String()).
+				Msg("Failed to send memory warning")
+		}
+	}()
+}
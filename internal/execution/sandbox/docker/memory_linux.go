@@ -0,0 +1,73 @@
+//go:build linux
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+// cgroupRoot is where the unified cgroup v2 hierarchy is mounted
+const cgroupRoot = "/sys/fs/cgroup"
+
+// setMemoryHigh writes limit to memory.high of the container's cgroup, so
+// that the kernel throttles and reclaims the container's memory above it.
+// It requires cgroup v2 and a runner on the same host as the docker daemon.
+func setMemoryHigh(ctx context.Context, containerID string, limit uint64) error {
+	dir, err := containerCgroup(ctx, containerID)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "memory.high")
+	if err := os.WriteFile(path, []byte(strconv.FormatUint(limit, 10)), 0); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// containerCgroup returns the directory of the running container's cgroup
+// v2 group
+func containerCgroup(ctx context.Context, containerID string) (string, error) {
+	out, err := executils.ExecCommand(ctx, "docker", "inspect", "--format", "{{.State.Pid}}", containerID)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(out)))
+	if err != nil || pid <= 0 {
+		return "", fmt.Errorf("container has no running process")
+	}
+
+	group, err := unifiedCgroup(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cgroupRoot, group), nil
+}
+
+// unifiedCgroup returns the cgroup v2 path listed in a /proc/<pid>/cgroup
+// file
+func unifiedCgroup(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read container cgroup: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if group, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return group, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read container cgroup: %w", err)
+	}
+	return "", fmt.Errorf("container cgroup is not on cgroup v2, which is required")
+}
@@ -0,0 +1,128 @@
+//go:build linux
+
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+func TestUnifiedCgroup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cgroup")
+	if err := os.WriteFile(path, []byte("0::/system.slice/docker-abc.scope\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if group, err := unifiedCgroup(path); err != nil || group != "/system.slice/docker-abc.scope" {
+		t.Fatalf("unifiedCgroup() = %q, %v", group, err)
+	}
+
+	if err := os.WriteFile(path, []byte("12:memory:/docker/abc\n11:cpu:/docker/abc\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unifiedCgroup(path); err == nil || !strings.Contains(err.Error(), "cgroup v2") {
+		t.Fatalf("unifiedCgroup() error = %v on cgroup v1, want cgroup v2 required", err)
+	}
+}
+
+// rampScript grows the shell's memory by a megabyte every fifth of a second
+// until the kernel kills it, printing SIGNALLED when sent SIGUSR1
+const rampScript = `trap 'echo SIGNALLED' USR1
+chunk=$(head -c 1048576 /dev/zero | tr '\0' x)
+s=
+while :; do s="$s$chunk"; sleep 0.2; done`
+
+func TestSoftLimitSignalsBeforeKill(t *testing.T) {
+	if _, err := executils.ExecCommand(context.Background(), "docker", "version"); err != nil {
+		t.Skip("docker is not available")
+	}
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		t.Skip("cgroup v2 is not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	cm, err := NewContainerManager("96m", "0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings := &warningRecorder{}
+	e := &DockerExecutor{
+		config:       &ExecutorConfig{MemoryLimit: "96m"},
+		containerMgr: cm,
+		memory:       MemoryPolicy{SoftRatio: 0.5, Sustain: 2 * time.Second},
+		warnings:     warnings,
+	}
+	task := &models.Task{ID: uuid.New()}
+	limits, err := e.memoryLimits(models.ResourceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	containerID, err := cm.CreateContainerWithOptions(ctx, "alpine:latest", "/", limits.env(), ContainerOptions{
+		Memory:  strconv.FormatUint(limits.Hard, 10),
+		Command: []string{"sh", "-c", rampScript},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm.RemoveContainer(context.Background(), containerID)
+
+	if err := cm.StartContainer(ctx, containerID); err != nil {
+		t.Fatal(err)
+	}
+	if err := setMemoryHigh(ctx, containerID, limits.Soft); err != nil {
+		t.Logf("memory.high not set, relying on signals: %v", err)
+	}
+
+	monitor, err := NewResourceMetrics(containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pressure := e.watchMemory(task, containerID, limits, monitor)
+	if err := monitor.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	exitCode, err := cm.WaitForContainer(ctx, containerID)
+	monitor.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := executils.ExecCommand(ctx, "docker", "inspect", "--format", "{{.State.OOMKilled}}", containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exitCode != 137 || strings.TrimSpace(string(out)) != "true" {
+		t.Fatalf("container exited with %d, OOM killed %s, want the hard limit to end it", exitCode, out)
+	}
+
+	logs, err := cm.GetContainerLogs(ctx, containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logs, "SIGNALLED") {
+		t.Fatalf("task was not signalled before the kill, logs: %q", logs)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for warnings.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if warnings.count() == 0 {
+		t.Fatal("no memory warning sent")
+	}
+	peak, above := pressure.usage()
+	if peak < limits.Soft || above < 2*time.Second {
+		t.Fatalf("peak %d, %s above the soft limit, want usage recorded above %d for the sustain period", peak, above, limits.Soft)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package docker
+
+import (
+	"context"
+	"errors"
+)
+
+// setMemoryHigh is unsupported where docker runs containers in a virtual
+// machine; the soft limit is then enforced by signalling alone
+func setMemoryHigh(ctx context.Context, containerID string, limit uint64) error {
+	return errors.ErrUnsupported
+}
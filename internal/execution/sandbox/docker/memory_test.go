@@ -0,0 +1,118 @@
+package docker
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// warningRecorder keeps the warnings sent
+type warningRecorder struct {
+	mu       sync.Mutex
+	warnings []*models.TaskWarning
+}
+
+func (r *warningRecorder) SendTaskWarning(taskID string, warning *models.TaskWarning) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.warnings = append(r.warnings, warning)
+	return nil
+}
+
+func (r *warningRecorder) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.warnings)
+}
+
+func TestMemoryLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		runner   string
+		declared string
+		ratio    float64
+		want     MemoryLimits
+	}{
+		{"runner limit", "1g", "", 0, MemoryLimits{Hard: 1 << 30}},
+		{"declared below runner limit", "1g", "512m", 0, MemoryLimits{Hard: 512 << 20}},
+		{"declared above runner limit", "1g", "2g", 0, MemoryLimits{Hard: 1 << 30}},
+		{"soft limit", "1g", "", 0.75, MemoryLimits{Hard: 1 << 30, Soft: 768 << 20}},
+		{"ratio of one disables", "1g", "", 1, MemoryLimits{Hard: 1 << 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &DockerExecutor{config: &ExecutorConfig{MemoryLimit: tt.runner}, memory: MemoryPolicy{SoftRatio: tt.ratio}}
+			got, err := e.memoryLimits(models.ResourceConfig{Memory: tt.declared})
+			if err != nil || got != tt.want {
+				t.Fatalf("memoryLimits() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+
+	e := &DockerExecutor{config: &ExecutorConfig{MemoryLimit: "1g"}}
+	if _, err := e.memoryLimits(models.ResourceConfig{Memory: "lots"}); err == nil {
+		t.Fatal("memoryLimits() accepted an invalid task memory")
+	}
+}
+
+func TestMemoryLimitsEnv(t *testing.T) {
+	env := MemoryLimits{Hard: 1024, Soft: 512}.env()
+	want := []string{"PARITY_MEMORY_HARD_LIMIT=1024", "PARITY_MEMORY_SOFT_LIMIT=512", "PARITY_MEMORY_SIGNAL=SIGUSR1"}
+	if !slices.Equal(env, want) {
+		t.Fatalf("env() = %v, want %v", env, want)
+	}
+	if env := (MemoryLimits{Hard: 1024}).env(); !slices.Equal(env, want[:1]) {
+		t.Fatalf("env() without a soft limit = %v", env)
+	}
+}
+
+func TestMemoryPressure(t *testing.T) {
+	p := newMemoryPressure(100, 3*time.Second)
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+
+	samples := []struct {
+		second int
+		usage  uint64
+		signal bool
+	}{
+		{0, 50, false},
+		{1, 120, false},
+		{2, 130, false},
+		// A dip below the soft limit restarts the sustain period
+		{3, 90, false},
+		{4, 150, false},
+		{6, 160, false},
+		{7, 170, true},
+		// Signalled once while usage stays above
+		{9, 180, false},
+		{10, 80, false},
+		{11, 110, false},
+		{14, 110, true},
+	}
+	for _, s := range samples {
+		if got := p.observe(at(s.second), s.usage); got != s.signal {
+			t.Fatalf("observe(%ds, %d) = %v, want %v", s.second, s.usage, got, s.signal)
+		}
+	}
+
+	peak, above := p.usage()
+	if peak != 180 {
+		t.Fatalf("peak = %d, want 180", peak)
+	}
+	// Above from 1s to 3s, 4s to 10s and 11s to 14s
+	if above != 11*time.Second {
+		t.Fatalf("time above soft limit = %s, want 11s", above)
+	}
+
+	disabled := newMemoryPressure(0, time.Second)
+	if disabled.observe(at(0), 1<<40) || disabled.observe(at(5), 1<<40) {
+		t.Fatal("signalled without a soft limit")
+	}
+	if peak, above := disabled.usage(); peak != 1<<40 || above != 0 {
+		t.Fatalf("usage() without a soft limit = %d, %s", peak, above)
+	}
+}
@@ -31,6 +31,8 @@ type ResourceMonitor struct {
 	metricsLock    sync.RWMutex
 	metrics        ContainerMetrics
 	lastNonZeroCPU float64
+	// observeMemory, when set, receives each memory usage sample in bytes
+	observeMemory func(now time.Time, usage uint64)
 }
 
 func NewResourceMetrics(containerID string) (*ResourceMonitor, error) {
@@ -52,6 +54,12 @@ func (rc *ResourceMonitor) GetSystemMetrics() (memory int64, cpu float64) {
 	return memoryBytes, rc.metrics.CPUSeconds
 }
 
+// SetMemoryObserver passes each memory usage sample to observe. It must be
+// called before Start.
+func (rc *ResourceMonitor) SetMemoryObserver(observe func(now time.Time, usage uint64)) {
+	rc.observeMemory = observe
+}
+
 func (rc *ResourceMonitor) Start(ctx context.Context) error {
 	log := gologger.WithComponent("docker.metrics")
 
@@ -129,6 +137,14 @@ func (rc *ResourceMonitor) collectMetrics(startTime time.Time) {
 		return
 	}
 
+	// The sample is observed once the lock is released
+	memoryUsage := int64(-1)
+	defer func() {
+		if rc.observeMemory != nil && memoryUsage >= 0 {
+			rc.observeMemory(time.Now(), uint64(memoryUsage))
+		}
+	}()
+
 	rc.metricsLock.Lock()
 	defer rc.metricsLock.Unlock()
 
@@ -186,6 +202,9 @@ func (rc *ResourceMonitor) collectMetrics(startTime time.Time) {
 
 	if parts := strings.Split(stats.Memory, " / "); len(parts) >= 1 {
 		if memStr := parts[0]; memStr != "" {
+			if usage, err := parseSize(memStr); err == nil {
+				memoryUsage = usage
+			}
 
 			var mem float64
 			var unit string
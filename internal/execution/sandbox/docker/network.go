@@ -0,0 +1,266 @@
+package docker
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+// ErrNetworkPolicy is returned for network overrides the runner's policy
+// does not allow
+var ErrNetworkPolicy = errors.New("network override not allowed")
+
+// NetworkPolicy decides which addresses a task's network overrides may name.
+// A hostname pinned to an address, or a nameserver, reaches whatever it
+// points at, so without a policy a task could use them to reach the
+// runner's local network, such as a cloud metadata service. Public
+// addresses are always allowed; loopback, private, link-local and other
+// non-public addresses only inside AllowedNetworks, which may hold IPv4 and
+// IPv6 ranges alike. IPv6 addresses embedding an IPv4 address, through
+// NAT64 or 6to4, are judged by the IPv4 address they reach. Service tasks
+// may publish their ports only on host ports inside PublishPorts. Tasks
+// may ask for an IPv6 network only when IPv6Prefix, a ULA prefix their
+// subnets are carved from, is set.
+type NetworkPolicy struct {
+	AllowedNetworks []*net.IPNet
+	PublishPorts    []PortRange
+	IPv6Prefix      *net.IPNet
+}
+
+// minTaskSubnets is the fewest IPv6 task subnets a prefix must hold, so
+// tasks running at once seldom draw the same one
+const minTaskSubnets = 256
+
+// ulaNetwork holds the unique local addresses of RFC 4193, which task
+// networks are numbered from and NAT66 translates
+var ulaNetwork = &net.IPNet{IP: net.ParseIP("fc00::"), Mask: net.CIDRMask(7, 128)}
+
+// ParseIPv6Prefix parses the ULA prefix IPv6 task networks are carved
+// from, such as "fd42:7061:7269::/48"; nil when prefix is empty
+func ParseIPv6Prefix(prefix string) (*net.IPNet, error) {
+	prefix = strings.TrimSpace(prefix)
+	if prefix == "" {
+		return nil, nil
+	}
+	ip, network, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPv6 prefix %q: %w", prefix, err)
+	}
+	if ip.To4() != nil || !ulaNetwork.Contains(ip) {
+		return nil, fmt.Errorf("IPv6 prefix %q is not a unique local (fc00::/7) prefix", prefix)
+	}
+	if ones, _ := network.Mask.Size(); 1<<(64-ones) < minTaskSubnets || ones > 64 {
+		return nil, fmt.Errorf("IPv6 prefix %q must be /%d or shorter to hold %d task subnets", prefix, 64-8, minTaskSubnets)
+	}
+	return network, nil
+}
+
+// PortRange is an inclusive range of host ports
+type PortRange struct {
+	First, Last int
+}
+
+// ParsePortRanges parses ranges such as "8000-8099" or single ports such
+// as "9000"
+func ParsePortRanges(ranges []string) ([]PortRange, error) {
+	var parsed []PortRange
+	for _, r := range ranges {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		first, last, found := strings.Cut(r, "-")
+		if !found {
+			last = first
+		}
+		a, errA := strconv.Atoi(strings.TrimSpace(first))
+		b, errB := strconv.Atoi(strings.TrimSpace(last))
+		if errA != nil || errB != nil || a < 1 || b > 65535 || a > b {
+			return nil, fmt.Errorf("invalid port range %q", r)
+		}
+		parsed = append(parsed, PortRange{First: a, Last: b})
+	}
+	return parsed, nil
+}
+
+// ParseNetworkPolicy builds a policy allowing the CIDR ranges in networks
+func ParseNetworkPolicy(networks []string) (NetworkPolicy, error) {
+	var policy NetworkPolicy
+	for _, cidr := range networks {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return NetworkPolicy{}, fmt.Errorf("invalid allowed network %q: %w", cidr, err)
+		}
+		policy.AllowedNetworks = append(policy.AllowedNetworks, network)
+	}
+	return policy, nil
+}
+
+// Check validates config and checks every address it names against the
+// policy, naming the first entry that is not allowed
+func (p NetworkPolicy) Check(config *models.NetworkConfig) error {
+	if config == nil {
+		return nil
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	if config.IPv6 && p.IPv6Prefix == nil {
+		return fmt.Errorf("%w: IPv6 task networks are not enabled on this runner", ErrNetworkPolicy)
+	}
+	hosts, _ := config.Hosts()
+	for _, h := range hosts {
+		if !p.Allows(h.IP) {
+			return fmt.Errorf("%w: extra_hosts pins %s to %s, which is not public and not in an allowed network", ErrNetworkPolicy, h.Host, h.IP)
+		}
+	}
+	servers, _ := config.DNSServers()
+	for _, server := range servers {
+		if !p.Allows(server) {
+			return fmt.Errorf("%w: dns server %s is not public and not in an allowed network", ErrNetworkPolicy, server)
+		}
+	}
+	return nil
+}
+
+// CheckPorts checks that every host port in ports is one the policy lets
+// tasks publish on
+func (p NetworkPolicy) CheckPorts(ports []models.ServicePort) error {
+	for _, port := range ports {
+		allowed := false
+		for _, r := range p.PublishPorts {
+			if port.HostPort >= r.First && port.HostPort <= r.Last {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("%w: host port %d is not in a port range the runner publishes on", ErrNetworkPolicy, port.HostPort)
+		}
+	}
+	return nil
+}
+
+// Allows reports whether tasks may reach ip: any public address, and
+// other addresses inside AllowedNetworks
+func (p NetworkPolicy) Allows(ip net.IP) bool {
+	for _, network := range p.AllowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	if v4 := embeddedIPv4(ip); v4 != nil {
+		return p.Allows(v4)
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || ip.IsInterfaceLocalMulticast() || siteLocalNetwork.Contains(ip))
+}
+
+var (
+	// nat64Networks translate their last 32 bits to an IPv4 address: the
+	// well-known prefix of RFC 6052 and the local-use prefix of RFC 8215
+	nat64Networks = []*net.IPNet{
+		{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 128)},
+		{IP: net.ParseIP("64:ff9b:1::"), Mask: net.CIDRMask(48, 128)},
+	}
+	// sixToFourNetwork carries an IPv4 address in bits 16 to 48 (RFC 3056)
+	sixToFourNetwork = &net.IPNet{IP: net.ParseIP("2002::"), Mask: net.CIDRMask(16, 128)}
+	// siteLocalNetwork is deprecated (RFC 3879) but still not public
+	siteLocalNetwork = &net.IPNet{IP: net.ParseIP("fec0::"), Mask: net.CIDRMask(10, 128)}
+)
+
+// embeddedIPv4 returns the IPv4 address a NAT64 or 6to4 address reaches,
+// nil for other addresses
+func embeddedIPv4(ip net.IP) net.IP {
+	if ip.To4() != nil {
+		return nil
+	}
+	ip = ip.To16()
+	for _, network := range nat64Networks {
+		if network.Contains(ip) {
+			return net.IPv4(ip[12], ip[13], ip[14], ip[15])
+		}
+	}
+	if sixToFourNetwork.Contains(ip) {
+		return net.IPv4(ip[2], ip[3], ip[4], ip[5])
+	}
+	return nil
+}
+
+// TaskNetworkName names the network of the task taskID
+func TaskNetworkName(taskID string) string {
+	return "parity-task-" + taskID
+}
+
+// TaskSubnet returns the /64 of prefix the network of the task taskID is
+// numbered from, chosen by hashing the task ID
+func TaskSubnet(prefix *net.IPNet, taskID string) *net.IPNet {
+	sum := sha256.Sum256([]byte(taskID))
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, prefix.IP.To16())
+	ones, _ := prefix.Mask.Size()
+	for bit := ones; bit < 64; bit++ {
+		if sum[bit/8]&(0x80>>(bit%8)) != 0 {
+			ip[bit/8] |= 0x80 >> (bit % 8)
+		}
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(64, 128)}
+}
+
+// CreateTaskNetwork creates the bridge network name for the task taskID,
+// numbering its IPv6 addresses from subnet and masquerading them (NAT66)
+// behind the host's addresses. Docker numbers its IPv4 addresses from its
+// default pools.
+func (cm *ContainerManager) CreateTaskNetwork(ctx context.Context, name, taskID string, subnet *net.IPNet) error {
+	_, err := executils.ExecCommand(ctx, "docker", "network", "create",
+		"--driver", "bridge",
+		"--ipv6",
+		"--subnet", subnet.String(),
+		"--label", TaskIDLabel+"="+taskID,
+		"--opt", "com.docker.network.bridge.enable_ip_masquerade=true",
+		name)
+	if err != nil {
+		return fmt.Errorf("network creation failed: %w", err)
+	}
+	return nil
+}
+
+// RemoveTaskNetwork removes a network made by CreateTaskNetwork once no
+// container is attached to it
+func (cm *ContainerManager) RemoveTaskNetwork(ctx context.Context, name string) error {
+	if _, err := executils.ExecCommand(ctx, "docker", "network", "rm", name); err != nil {
+		return fmt.Errorf("network removal failed: %w", err)
+	}
+	return nil
+}
+
+// networkArgs are the docker create flags applying config
+func networkArgs(config *models.NetworkConfig) []string {
+	if config == nil {
+		return nil
+	}
+	var args []string
+	hosts, _ := config.Hosts()
+	for _, h := range hosts {
+		args = append(args, "--add-host", h.String())
+	}
+	servers, _ := config.DNSServers()
+	for _, server := range servers {
+		args = append(args, "--dns", server.String())
+	}
+	for _, domain := range config.DNSSearch {
+		args = append(args, "--dns-search", domain)
+	}
+	return args
+}
@@ -0,0 +1,69 @@
+//go:build linux
+
+package docker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+func TestNetworkOverridesInContainer(t *testing.T) {
+	if _, err := executils.ExecCommand(context.Background(), "docker", "version"); err != nil {
+		t.Skip("docker is not available")
+	}
+	if _, err := executils.ExecCommand(context.Background(), "docker", "image", "inspect", "alpine:latest"); err != nil {
+		t.Skip("alpine image not available locally")
+	}
+
+	cm, err := NewContainerManager("64m", "0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	network := &models.NetworkConfig{
+		ExtraHosts: []string{"mirror.example.com:203.0.113.7"},
+		DNS:        []string{"9.9.9.9"},
+		DNSSearch:  []string{"datasets.example.com"},
+	}
+	if err := (NetworkPolicy{}).Check(network); err != nil {
+		t.Fatal(err)
+	}
+	containerID, err := cm.CreateContainerWithOptions(ctx, "alpine:latest", "/", nil, ContainerOptions{
+		Command: []string{"sh", "-c", "getent hosts mirror.example.com; cat /etc/resolv.conf"},
+		Network: network,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm.RemoveContainer(context.Background(), containerID)
+	if err := cm.StartContainer(ctx, containerID); err != nil {
+		t.Fatal(err)
+	}
+	if exitCode, err := cm.WaitForContainer(ctx, containerID); err != nil || exitCode != 0 {
+		t.Fatalf("container exited %d, %v", exitCode, err)
+	}
+	logs, err := cm.GetContainerLogs(ctx, containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(logs, "203.0.113.7") || !strings.Contains(logs, "mirror.example.com") {
+		t.Errorf("mirror.example.com not resolved to the pinned address, logs: %q", logs)
+	}
+	// Docker's embedded resolver forwards to the configured servers on
+	// user-defined networks, so only the search domains are certain to
+	// appear verbatim
+	if !strings.Contains(logs, "search datasets.example.com") {
+		t.Errorf("search domain missing from resolv.conf, logs: %q", logs)
+	}
+	if !strings.Contains(logs, "nameserver 9.9.9.9") && !strings.Contains(logs, "nameserver 127.0.0.11") {
+		t.Errorf("nameserver not overridden, logs: %q", logs)
+	}
+}
@@ -0,0 +1,208 @@
+package docker
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func TestNetworkOverrideConflicts(t *testing.T) {
+	tests := []struct {
+		name   string
+		config models.NetworkConfig
+		// want is part of the error, "" for a valid config
+		want string
+	}{
+		{"valid", models.NetworkConfig{
+			ExtraHosts: []string{"mirror.example.com:203.0.113.7", "v6.example.com:2001:4860::8888"},
+			DNS:        []string{"1.1.1.1", "2606:4700:4700::1111"},
+			DNSSearch:  []string{"example.com"},
+		}, ""},
+		{"host pinned twice", models.NetworkConfig{ExtraHosts: []string{"mirror.example.com:203.0.113.7", "Mirror.example.com:203.0.113.8"}},
+			"pins mirror.example.com to both 203.0.113.7 and 203.0.113.8"},
+		{"duplicate host", models.NetworkConfig{ExtraHosts: []string{"mirror.example.com:203.0.113.7", "mirror.example.com:203.0.113.7"}},
+			"pins mirror.example.com to 203.0.113.7 twice"},
+		{"localhost", models.NetworkConfig{ExtraHosts: []string{"localhost:203.0.113.7"}}, "localhost cannot be pinned"},
+		{"host gateway", models.NetworkConfig{ExtraHosts: []string{"runner:host-gateway"}}, `"host-gateway" is not an IP address`},
+		{"no address", models.NetworkConfig{ExtraHosts: []string{"mirror.example.com"}}, "must be host:ip"},
+		{"duplicate dns", models.NetworkConfig{DNS: []string{"1.1.1.1", "8.8.8.8", "1.1.1.1"}}, "dns lists 1.1.1.1 twice"},
+		{"too many dns", models.NetworkConfig{DNS: []string{"1.1.1.1", "8.8.8.8", "9.9.9.9", "8.8.4.4"}}, "at most 3 are used"},
+		{"dns by name", models.NetworkConfig{DNS: []string{"dns.example.com"}}, "is not an IP address"},
+		{"duplicate search", models.NetworkConfig{DNSSearch: []string{"example.com", "EXAMPLE.com."}}, "dns_search lists example.com twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NetworkPolicy{}.Check(&tt.config)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("Check() error = %v, want valid", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Check() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNetworkPolicy(t *testing.T) {
+	policy, err := ParseNetworkPolicy([]string{"10.20.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		config  models.NetworkConfig
+		allowed bool
+	}{
+		{models.NetworkConfig{ExtraHosts: []string{"mirror.internal:10.20.1.5"}, DNS: []string{"10.20.0.2"}}, true},
+		{models.NetworkConfig{ExtraHosts: []string{"mirror.internal:10.30.1.5"}}, false},
+		{models.NetworkConfig{ExtraHosts: []string{"metadata:169.254.169.254"}}, false},
+		{models.NetworkConfig{ExtraHosts: []string{"api.example.com:127.0.0.1"}}, false},
+		{models.NetworkConfig{DNS: []string{"192.168.1.1"}}, false},
+		{models.NetworkConfig{DNS: []string{"::1"}}, false},
+		// Search domains reach nothing by themselves
+		{models.NetworkConfig{DNSSearch: []string{"corp.internal"}}, true},
+	}
+	for _, tt := range tests {
+		err := policy.Check(&tt.config)
+		if tt.allowed != (err == nil) {
+			t.Errorf("Check(%+v) error = %v, want allowed %v", tt.config, err, tt.allowed)
+		}
+		if err != nil && !tt.allowed && !errors.Is(err, ErrNetworkPolicy) {
+			t.Errorf("Check(%+v) error = %v, want ErrNetworkPolicy", tt.config, err)
+		}
+	}
+
+	if _, err := ParseNetworkPolicy([]string{"10.20.0.0"}); err == nil {
+		t.Error("ParseNetworkPolicy() accepted an address without a prefix length")
+	}
+}
+
+func TestNetworkPolicyIPv6(t *testing.T) {
+	policy, err := ParseNetworkPolicy([]string{"10.20.0.0/16", "fd00:1234::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"2606:4700:4700::1111", true},
+		{"fd00:1234::53", true},
+		{"fd00:5678::53", false},
+		{"::1", false},
+		{"fe80::1", false},
+		{"fec0::1", false},
+		{"ff02::1", false},
+		// NAT64 and 6to4 addresses are judged by the IPv4 address they reach
+		{"64:ff9b::808:808", true},
+		{"64:ff9b::a9fe:a9fe", false},
+		{"64:ff9b::a14:105", true},
+		{"64:ff9b:1::c0a8:101", false},
+		{"2002:c0a8:101::1", false},
+		{"2002:808:808::1", true},
+	}
+	for _, tt := range tests {
+		if got := policy.Allows(net.ParseIP(tt.ip)); got != tt.allowed {
+			t.Errorf("Allows(%s) = %v, want %v", tt.ip, got, tt.allowed)
+		}
+	}
+
+	config := &models.NetworkConfig{DNS: []string{"[fd00:1234::53]"}, IPv6: true}
+	if err := policy.Check(config); !errors.Is(err, ErrNetworkPolicy) {
+		t.Fatalf("Check() error = %v, want ErrNetworkPolicy without an IPv6 prefix", err)
+	}
+	if policy.IPv6Prefix, err = ParseIPv6Prefix("fd42:7061:7269::/48"); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Check(config); err != nil {
+		t.Fatalf("Check() error = %v, want allowed", err)
+	}
+	if args := networkArgs(config); !reflect.DeepEqual(args, []string{"--dns", "fd00:1234::53"}) {
+		t.Fatalf("networkArgs() = %q, want the nameserver unbracketed", args)
+	}
+}
+
+func TestParseIPv6Prefix(t *testing.T) {
+	for _, prefix := range []string{"2001:db8::/48", "fd42::/60", "10.0.0.0/8", "fd42::1"} {
+		if _, err := ParseIPv6Prefix(prefix); err == nil {
+			t.Errorf("ParseIPv6Prefix(%q) accepted", prefix)
+		}
+	}
+	if prefix, err := ParseIPv6Prefix(""); prefix != nil || err != nil {
+		t.Errorf("ParseIPv6Prefix(\"\") = %v, %v, want none", prefix, err)
+	}
+}
+
+func TestTaskSubnet(t *testing.T) {
+	prefix, err := ParseIPv6Prefix("fd42:7061:7269::/48")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := TaskSubnet(prefix, "task-a")
+	if ones, _ := a.Mask.Size(); ones != 64 || !prefix.Contains(a.IP) {
+		t.Fatalf("TaskSubnet() = %s, want a /64 inside %s", a, prefix)
+	}
+	if again := TaskSubnet(prefix, "task-a"); again.String() != a.String() {
+		t.Fatalf("TaskSubnet() = %s then %s for one task", a, again)
+	}
+	if b := TaskSubnet(prefix, "task-b"); b.String() == a.String() {
+		t.Fatalf("TaskSubnet() = %s for two tasks", a)
+	}
+}
+
+func TestNetworkArgs(t *testing.T) {
+	args := networkArgs(&models.NetworkConfig{
+		ExtraHosts: []string{"Mirror.example.com:203.0.113.7"},
+		DNS:        []string{"1.1.1.1"},
+		DNSSearch:  []string{"example.com"},
+	})
+	want := []string{"--add-host", "mirror.example.com:203.0.113.7", "--dns", "1.1.1.1", "--dns-search", "example.com"}
+	if !reflect.DeepEqual(args, want) {
+		t.Fatalf("networkArgs() = %q, want %q", args, want)
+	}
+	if args := networkArgs(nil); args != nil {
+		t.Fatalf("networkArgs(nil) = %q, want none", args)
+	}
+}
+
+func TestNetworkPolicyPorts(t *testing.T) {
+	ranges, err := ParsePortRanges([]string{"8000-8099", " 9000 "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := NetworkPolicy{PublishPorts: ranges}
+	tests := []struct {
+		ports   []models.ServicePort
+		allowed bool
+	}{
+		{nil, true},
+		{[]models.ServicePort{{ContainerPort: 80, HostPort: 8000}, {ContainerPort: 81, HostPort: 9000, Protocol: "udp"}}, true},
+		{[]models.ServicePort{{ContainerPort: 80, HostPort: 8099}}, true},
+		{[]models.ServicePort{{ContainerPort: 80, HostPort: 8100}}, false},
+		{[]models.ServicePort{{ContainerPort: 80, HostPort: 22}}, false},
+	}
+	for _, tt := range tests {
+		err := policy.CheckPorts(tt.ports)
+		if tt.allowed != (err == nil) {
+			t.Errorf("CheckPorts(%v) error = %v, want allowed %v", tt.ports, err, tt.allowed)
+		}
+		if err != nil && !errors.Is(err, ErrNetworkPolicy) {
+			t.Errorf("CheckPorts(%v) error = %v, want ErrNetworkPolicy", tt.ports, err)
+		}
+	}
+	if err := (NetworkPolicy{}).CheckPorts([]models.ServicePort{{ContainerPort: 80, HostPort: 8000}}); err == nil {
+		t.Error("CheckPorts() published a port without any allowed range")
+	}
+
+	for _, bad := range []string{"8099-8000", "0", "70000", "80-", "http"} {
+		if _, err := ParsePortRanges([]string{bad}); err == nil {
+			t.Errorf("ParsePortRanges(%q) accepted an invalid range", bad)
+		}
+	}
+}
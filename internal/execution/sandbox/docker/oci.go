@@ -0,0 +1,349 @@
+package docker
+
+import (
+	"archive/tar"
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ociMediaTypeIndex     = "application/vnd.oci.image.index.v1+json"
+	ociMediaTypeManifest  = "application/vnd.oci.image.manifest.v1+json"
+	ociMediaTypeConfig    = "application/vnd.oci.image.config.v1+json"
+	ociMediaTypeLayer     = "application/vnd.oci.image.layer.v1.tar"
+	ociMediaTypeLayerGzip = "application/vnd.oci.image.layer.v1.tar+gzip"
+	ociRefNameAnnotation  = "org.opencontainers.image.ref.name"
+	ociLayoutVersion      = `{"imageLayoutVersion":"1.0.0"}`
+)
+
+var (
+	// ErrImageTooLarge is returned when an exported image exceeds its size cap
+	ErrImageTooLarge = errors.New("image exceeds export size limit")
+	// ErrTooManyLayers is returned when an exported image exceeds its layer cap
+	ErrTooManyLayers = errors.New("image exceeds export layer limit")
+)
+
+// ImageExportLimits bound what a task may export; zero means unlimited
+type ImageExportLimits struct {
+	MaxBytes  int64
+	MaxLayers int
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// dockerArchiveManifest is an entry of manifest.json in a `docker save` archive
+type dockerArchiveManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// ociImage describes an image laid out as an OCI image layout directory
+type ociImage struct {
+	Dir      string
+	Ref      string
+	Manifest ociDescriptor
+	Config   ociDescriptor
+	Layers   []ociDescriptor
+	Index    []byte
+}
+
+// Size is the total size of the image's distinct blobs
+func (img *ociImage) Size() int64 {
+	total := img.Manifest.Size + img.Config.Size
+	seen := make(map[string]bool)
+	for _, layer := range img.Layers {
+		if !seen[layer.Digest] {
+			seen[layer.Digest] = true
+			total += layer.Size
+		}
+	}
+	return total
+}
+
+func (img *ociImage) blobPath(digest string) string {
+	return filepath.Join(img.Dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+// convertDockerArchive turns a `docker save` archive into an OCI image layout
+// under dir, enforcing limits while the archive is unpacked. ref is recorded
+// as the image's reference name.
+func convertDockerArchive(archivePath, dir, ref string, limits ImageExportLimits) (*ociImage, error) {
+	extractDir := filepath.Join(dir, "extract")
+	if err := extractArchive(archivePath, extractDir, limits.MaxBytes); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(extractDir)
+
+	data, err := os.ReadFile(filepath.Join(extractDir, "manifest.json"))
+	if err != nil {
+		return nil, fmt.Errorf("image archive has no manifest.json: %w", err)
+	}
+	var entries []dockerArchiveManifest
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("failed to decode image archive manifest: %w", err)
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("image archive must contain exactly one image, found %d", len(entries))
+	}
+	entry := entries[0]
+	if limits.MaxLayers > 0 && len(entry.Layers) > limits.MaxLayers {
+		return nil, fmt.Errorf("%w: %d layers, limit %d", ErrTooManyLayers, len(entry.Layers), limits.MaxLayers)
+	}
+
+	img := &ociImage{Dir: filepath.Join(dir, "layout"), Ref: ref}
+	if err := os.MkdirAll(filepath.Join(img.Dir, "blobs", "sha256"), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create image layout: %w", err)
+	}
+
+	if img.Config, err = img.addBlob(filepath.Join(extractDir, filepath.FromSlash(entry.Config)), ociMediaTypeConfig); err != nil {
+		return nil, err
+	}
+	added := make(map[string]ociDescriptor)
+	for _, layerPath := range entry.Layers {
+		layer, ok := added[layerPath]
+		if !ok {
+			if layer, err = img.addBlob(filepath.Join(extractDir, filepath.FromSlash(layerPath)), ""); err != nil {
+				return nil, err
+			}
+			added[layerPath] = layer
+		}
+		img.Layers = append(img.Layers, layer)
+	}
+
+	manifest, err := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     ociMediaTypeManifest,
+		Config:        img.Config,
+		Layers:        img.Layers,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal image manifest: %w", err)
+	}
+	if img.Manifest, err = img.writeBlob(manifest, ociMediaTypeManifest); err != nil {
+		return nil, err
+	}
+	if ref != "" {
+		img.Manifest.Annotations = map[string]string{ociRefNameAnnotation: ref}
+	}
+
+	img.Index, err = json.Marshal(ociIndex{
+		SchemaVersion: 2,
+		MediaType:     ociMediaTypeIndex,
+		Manifests:     []ociDescriptor{img.Manifest},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal image index: %w", err)
+	}
+
+	if limits.MaxBytes > 0 && img.Size() > limits.MaxBytes {
+		return nil, fmt.Errorf("%w: %d bytes, limit %d", ErrImageTooLarge, img.Size(), limits.MaxBytes)
+	}
+	return img, nil
+}
+
+// addBlob moves a file into the layout under its digest. An empty mediaType
+// selects a layer media type from the file's compression.
+func (img *ociImage) addBlob(src, mediaType string) (ociDescriptor, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return ociDescriptor{}, fmt.Errorf("failed to open image blob: %w", err)
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	if mediaType == "" {
+		mediaType = ociMediaTypeLayer
+		if magic, _ := reader.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+			mediaType = ociMediaTypeLayerGzip
+		}
+	}
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, reader)
+	if err != nil {
+		return ociDescriptor{}, fmt.Errorf("failed to hash image blob: %w", err)
+	}
+	desc := ociDescriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(hash.Sum(nil)), Size: size}
+
+	dst := img.blobPath(desc.Digest)
+	if _, err := os.Stat(dst); err == nil {
+		return desc, nil
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return ociDescriptor{}, fmt.Errorf("failed to store image blob: %w", err)
+	}
+	return desc, nil
+}
+
+func (img *ociImage) writeBlob(data []byte, mediaType string) (ociDescriptor, error) {
+	sum := sha256.Sum256(data)
+	desc := ociDescriptor{MediaType: mediaType, Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(data))}
+	if err := os.WriteFile(img.blobPath(desc.Digest), data, 0o600); err != nil {
+		return ociDescriptor{}, fmt.Errorf("failed to store image blob: %w", err)
+	}
+	return desc, nil
+}
+
+// WriteArchive writes the layout as a tarball that is both an OCI image
+// layout and a `docker load` archive
+func (img *ociImage) WriteArchive(dst string) error {
+	dockerManifest := dockerArchiveManifest{Config: blobArchivePath(img.Config.Digest)}
+	if img.Ref != "" {
+		dockerManifest.RepoTags = []string{img.Ref}
+	}
+	for _, layer := range img.Layers {
+		dockerManifest.Layers = append(dockerManifest.Layers, blobArchivePath(layer.Digest))
+	}
+	manifestJSON, err := json.Marshal([]dockerArchiveManifest{dockerManifest})
+	if err != nil {
+		return fmt.Errorf("failed to marshal docker manifest: %w", err)
+	}
+
+	f, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create image archive: %w", err)
+	}
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	writeFile := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	if err := writeFile("oci-layout", []byte(ociLayoutVersion)); err != nil {
+		return fmt.Errorf("failed to write image archive: %w", err)
+	}
+	if err := writeFile("index.json", img.Index); err != nil {
+		return fmt.Errorf("failed to write image archive: %w", err)
+	}
+	if err := writeFile("manifest.json", manifestJSON); err != nil {
+		return fmt.Errorf("failed to write image archive: %w", err)
+	}
+
+	blobs, err := os.ReadDir(filepath.Join(img.Dir, "blobs", "sha256"))
+	if err != nil {
+		return fmt.Errorf("failed to read image layout: %w", err)
+	}
+	for _, blob := range blobs {
+		if err := copyBlobToTar(tw, filepath.Join(img.Dir, "blobs", "sha256", blob.Name()), "blobs/sha256/"+blob.Name()); err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish image archive: %w", err)
+	}
+	return f.Close()
+}
+
+func blobArchivePath(digest string) string {
+	return "blobs/sha256/" + strings.TrimPrefix(digest, "sha256:")
+}
+
+func copyBlobToTar(tw *tar.Writer, src, name string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open image blob: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat image blob: %w", err)
+	}
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: info.Size(), Typeflag: tar.TypeReg}); err != nil {
+		return fmt.Errorf("failed to write image archive: %w", err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to write image archive: %w", err)
+	}
+	return nil
+}
+
+// extractArchive unpacks the regular files of a tarball into dir, rejecting
+// entries that escape it and stopping once maxBytes is exceeded
+func extractArchive(archivePath, dir string, maxBytes int64) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open image archive: %w", err)
+	}
+	defer f.Close()
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create extraction directory: %w", err)
+	}
+
+	var total int64
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read image archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean("/" + hdr.Name)[1:]
+		if name == "" || !fs.ValidPath(name) {
+			return fmt.Errorf("image archive contains invalid path %q", hdr.Name)
+		}
+		total += hdr.Size
+		if maxBytes > 0 && total > maxBytes {
+			return fmt.Errorf("%w: more than %d bytes", ErrImageTooLarge, maxBytes)
+		}
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+			return fmt.Errorf("failed to create extraction directory: %w", err)
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to extract image archive: %w", err)
+		}
+		_, copyErr := io.Copy(out, io.LimitReader(tr, hdr.Size))
+		closeErr := out.Close()
+		if copyErr != nil {
+			return fmt.Errorf("failed to extract image archive: %w", copyErr)
+		}
+		if closeErr != nil {
+			return fmt.Errorf("failed to extract image archive: %w", closeErr)
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package docker
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+// PreflightMode decides what a runner does with a task whose command cannot
+// run in its image
+type PreflightMode string
+
+const (
+	PreflightOff PreflightMode = "off"
+	// PreflightWarn logs mismatches and runs the task anyway
+	PreflightWarn PreflightMode = "warn"
+	// PreflightAbandon skips tasks with a mismatch that is certain to fail
+	// them, before they are claimed
+	PreflightAbandon PreflightMode = "abandon"
+)
+
+// defaultPath is the PATH Docker gives containers whose image sets none
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// maxLinkHops bounds symlink resolution, as the kernel's ELOOP limit does
+const maxLinkHops = 40
+
+// ImageConfig is the part of an image's configuration that decides what its
+// containers run
+type ImageConfig struct {
+	Entrypoint []string `json:"Entrypoint"`
+	Cmd        []string `json:"Cmd"`
+	Env        []string `json:"Env"`
+	WorkingDir string   `json:"WorkingDir"`
+}
+
+// TaskCommand is what a task overrides in its image's configuration. A nil
+// Entrypoint keeps the image's and an empty one clears it.
+type TaskCommand struct {
+	Entrypoint []string
+	Command    []string
+	Env        []string
+	WorkDir    string
+}
+
+// taskCommand reads the entrypoint, command, environment and working
+// directory of a task. A command given as a string is in shell form.
+func taskCommand(env *models.EnvironmentConfig) TaskCommand {
+	cmd := TaskCommand{WorkDir: "/"}
+	if env == nil || env.Config == nil {
+		return cmd
+	}
+	if workdir, ok := env.Config["workdir"].(string); ok && workdir != "" {
+		cmd.WorkDir = workdir
+	}
+	if raw, ok := env.Config["entrypoint"]; ok {
+		cmd.Entrypoint = stringArgs(raw)
+		if cmd.Entrypoint == nil {
+			cmd.Entrypoint = []string{}
+		}
+	}
+	cmd.Command = stringArgs(env.Config["command"])
+	cmd.Env = stringArgs(env.Config["env"])
+	return cmd
+}
+
+func stringArgs(raw interface{}) []string {
+	switch v := raw.(type) {
+	case string:
+		if v == "" {
+			return nil
+		}
+		return []string{"/bin/sh", "-c", v}
+	case []interface{}:
+		args := make([]string, 0, len(v))
+		for _, arg := range v {
+			if s, ok := arg.(string); ok {
+				args = append(args, s)
+			}
+		}
+		return args
+	}
+	return nil
+}
+
+// Argv returns the process a container of img runs, following Docker: a task
+// entrypoint replaces the image's and drops its Cmd, while a task command
+// replaces only the Cmd
+func (c TaskCommand) Argv(img ImageConfig) []string {
+	entrypoint, cmd := img.Entrypoint, img.Cmd
+	if c.Entrypoint != nil {
+		entrypoint, cmd = c.Entrypoint, nil
+	}
+	if len(c.Command) > 0 {
+		cmd = c.Command
+	}
+	return append(append([]string{}, entrypoint...), cmd...)
+}
+
+// createArgs returns the docker create options and trailing arguments that
+// apply c
+func (c TaskCommand) createArgs() (opts []string, args []string) {
+	if c.Entrypoint != nil {
+		first := ""
+		if len(c.Entrypoint) > 0 {
+			first = c.Entrypoint[0]
+			args = append(args, c.Entrypoint[1:]...)
+		}
+		opts = append(opts, "--entrypoint", first)
+	}
+	return opts, append(args, c.Command...)
+}
+
+// PathInfo describes a path inside an image
+type PathInfo struct {
+	Exists     bool
+	Dir        bool
+	Mode       os.FileMode
+	LinkTarget string
+}
+
+type statFunc func(ctx context.Context, p string) (PathInfo, error)
+
+// Mismatch is a reason a task's command may not run in its image. Fatal
+// mismatches are certain to fail the task.
+type Mismatch struct {
+	Reason string
+	Fatal  bool
+}
+
+// PreflightError lists what is wrong with a task's command in its image
+type PreflightError struct {
+	Image      string
+	Mismatches []Mismatch
+}
+
+func (e *PreflightError) Error() string {
+	reasons := make([]string, len(e.Mismatches))
+	for i, m := range e.Mismatches {
+		reasons[i] = m.Reason
+	}
+	return fmt.Sprintf("image %s cannot run the task as configured: %s", e.Image, strings.Join(reasons, "; "))
+}
+
+// Fatal reports whether any mismatch is certain to fail the task
+func (e *PreflightError) Fatal() bool {
+	for _, m := range e.Mismatches {
+		if m.Fatal {
+			return true
+		}
+	}
+	return false
+}
+
+// checkCommand reports why cmd may not run in an image with configuration
+// img, looking up files in the image with stat
+func checkCommand(ctx context.Context, img ImageConfig, cmd TaskCommand, stat statFunc) ([]Mismatch, error) {
+	var mismatches []Mismatch
+	fatal := func(format string, args ...interface{}) {
+		mismatches = append(mismatches, Mismatch{Reason: fmt.Sprintf(format, args...), Fatal: true})
+	}
+	warn := func(format string, args ...interface{}) {
+		mismatches = append(mismatches, Mismatch{Reason: fmt.Sprintf(format, args...)})
+	}
+
+	workdir := cmd.WorkDir
+	if workdir == "" {
+		workdir = "/"
+	}
+	if info, err := resolve(ctx, stat, workdir); err != nil {
+		return nil, err
+	} else if !info.Exists {
+		warn("working directory %s does not exist in the image", workdir)
+	} else if !info.Dir {
+		fatal("working directory %s is not a directory", workdir)
+	}
+
+	argv := cmd.Argv(img)
+	if len(argv) == 0 {
+		fatal("the image has no entrypoint or cmd and the task sets no command")
+		return mismatches, nil
+	}
+
+	searchPath := lookupEnv(append(append([]string{}, img.Env...), cmd.Env...), "PATH", defaultPath)
+	reason, err := findExecutable(ctx, stat, argv[0], workdir, searchPath)
+	if err != nil {
+		return nil, err
+	}
+	if reason != "" {
+		fatal("%s", reason)
+	}
+
+	if cmd.Entrypoint == nil && len(cmd.Command) > 0 && len(img.Entrypoint) > 0 {
+		if isShellForm(img.Entrypoint) {
+			fatal("the image's entrypoint is in shell form and ignores the task command %q", strings.Join(cmd.Command, " "))
+		} else if path.Base(cmd.Command[0]) == path.Base(img.Entrypoint[0]) {
+			warn("the task command repeats the image's entrypoint %s, which receives it as arguments", img.Entrypoint[0])
+		}
+	}
+
+	if isShellForm(argv) && reason == "" {
+		if name := scriptCommand(argv[2]); name != "" {
+			inner, err := findExecutable(ctx, stat, name, workdir, searchPath)
+			if err != nil {
+				return nil, err
+			}
+			if inner != "" {
+				fatal("%s (run by the shell)", inner)
+			}
+		}
+	}
+	return mismatches, nil
+}
+
+// findExecutable looks name up the way execvp does and returns why it cannot
+// be run, or "" when it can
+func findExecutable(ctx context.Context, stat statFunc, name, workdir, searchPath string) (string, error) {
+	check := func(p string) (string, error) {
+		info, err := resolve(ctx, stat, p)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case !info.Exists:
+			return fmt.Sprintf("executable %s not found in the image", p), nil
+		case info.Dir:
+			return fmt.Sprintf("executable %s is a directory", p), nil
+		case info.Mode&0o111 == 0:
+			return fmt.Sprintf("%s is not executable", p), nil
+		}
+		return "", nil
+	}
+
+	if strings.Contains(name, "/") {
+		p := name
+		if !path.IsAbs(p) {
+			p = path.Join(workdir, p)
+		}
+		return check(p)
+	}
+
+	var firstProblem string
+	for _, dir := range strings.Split(searchPath, ":") {
+		if dir == "" {
+			dir = workdir
+		}
+		reason, err := check(path.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		if reason == "" {
+			return "", nil
+		}
+		if firstProblem == "" && !strings.HasSuffix(reason, "not found in the image") {
+			firstProblem = reason
+		}
+	}
+	if firstProblem != "" {
+		return firstProblem, nil
+	}
+	return fmt.Sprintf("executable %q not found in the image's PATH (%s)", name, searchPath), nil
+}
+
+// resolve stats p, following symlinks in its last element
+func resolve(ctx context.Context, stat statFunc, p string) (PathInfo, error) {
+	for hop := 0; hop < maxLinkHops; hop++ {
+		info, err := stat(ctx, p)
+		if err != nil || !info.Exists || info.LinkTarget == "" {
+			return info, err
+		}
+		target := info.LinkTarget
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(p), target)
+		}
+		p = target
+	}
+	return PathInfo{}, fmt.Errorf("too many levels of symbolic links resolving %s", p)
+}
+
+func lookupEnv(env []string, key, fallback string) string {
+	value := fallback
+	for _, kv := range env {
+		if k, v, ok := strings.Cut(kv, "="); ok && k == key {
+			value = v
+		}
+	}
+	return value
+}
+
+// isShellForm reports whether argv runs a script through a shell, as
+// Dockerfile shell-form instructions do
+func isShellForm(argv []string) bool {
+	if len(argv) < 3 || argv[1] != "-c" {
+		return false
+	}
+	switch path.Base(argv[0]) {
+	case "sh", "bash", "ash", "dash", "zsh":
+		return true
+	}
+	return false
+}
+
+// shellBuiltins are commands a shell runs itself rather than from PATH
+var shellBuiltins = map[string]bool{
+	".": true, ":": true, "cd": true, "echo": true, "eval": true, "exit": true,
+	"export": true, "printf": true, "read": true, "set": true, "source": true,
+	"test": true, "[": true, "trap": true, "true": true, "false": true,
+	"ulimit": true, "umask": true, "unset": true, "wait": true, "if": true,
+	"for": true, "while": true, "until": true, "case": true, "!": true,
+}
+
+// scriptCommand returns the executable a shell script starts with, or "" if
+// the script does not start with a plain command word the runner can check
+func scriptCommand(script string) string {
+	words := strings.Fields(script)
+	for len(words) > 0 {
+		word := words[0]
+		if strings.ContainsAny(word, "$`'\"\\;|&<>(){}*?~") {
+			return ""
+		}
+		// Leading assignments only set the command's environment
+		if eq := strings.IndexByte(word, '='); eq > 0 {
+			words = words[1:]
+			continue
+		}
+		if word == "exec" || word == "command" {
+			words = words[1:]
+			continue
+		}
+		if shellBuiltins[word] {
+			return ""
+		}
+		return word
+	}
+	return ""
+}
+
+// imageInspection caches what preflight learned about one image, by digest
+type imageInspection struct {
+	config ImageConfig
+
+	mu    sync.Mutex
+	paths map[string]PathInfo
+}
+
+// Preflighter checks before a task is claimed that its command can run in
+// its image, caching what it inspects of each image by digest
+type Preflighter struct {
+	mu          sync.Mutex
+	inspections map[string]*imageInspection
+}
+
+func NewPreflighter() *Preflighter {
+	return &Preflighter{inspections: make(map[string]*imageInspection)}
+}
+
+// inspect returns the cached inspection of image, reading its configuration
+// on first use
+func (p *Preflighter) inspect(ctx context.Context, image string) (*imageInspection, error) {
+	out, err := executils.ExecCommand(ctx, "docker", "image", "inspect", "--format", "{{json .}}", image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image %s: %w", image, err)
+	}
+	var inspected struct {
+		ID     string      `json:"Id"`
+		Config ImageConfig `json:"Config"`
+	}
+	if err := json.Unmarshal(bytes.TrimSpace(out), &inspected); err != nil {
+		return nil, fmt.Errorf("failed to decode image configuration: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if cached, ok := p.inspections[inspected.ID]; ok {
+		return cached, nil
+	}
+	inspection := &imageInspection{config: inspected.Config, paths: make(map[string]PathInfo)}
+	p.inspections[inspected.ID] = inspection
+	return inspection, nil
+}
+
+// Check inspects image and returns a *PreflightError if cmd may not run in it
+func (p *Preflighter) Check(ctx context.Context, image string, cmd TaskCommand) error {
+	inspection, err := p.inspect(ctx, image)
+	if err != nil {
+		return err
+	}
+
+	// Paths not yet cached are read from a container that is created but
+	// never started, so nothing from the image runs
+	var containerID string
+	defer func() {
+		if containerID != "" {
+			if _, err := executils.ExecCommand(context.Background(), "docker", "rm", "-f", containerID); err != nil {
+				log := gologger.WithComponent("docker.preflight")
+				log.Debug().Err(err).Str("container_id", containerID).Msg("Failed to remove preflight container")
+			}
+		}
+	}()
+	stat := func(ctx context.Context, p string) (PathInfo, error) {
+		inspection.mu.Lock()
+		info, ok := inspection.paths[p]
+		inspection.mu.Unlock()
+		if ok {
+			return info, nil
+		}
+		if containerID == "" {
+			out, err := executils.ExecCommand(ctx, "docker", "create", "--network", "none", image)
+			if err != nil {
+				return PathInfo{}, fmt.Errorf("failed to create preflight container: %w", err)
+			}
+			containerID = strings.TrimSpace(string(out))
+		}
+		info, err := statContainerPath(ctx, containerID, p)
+		if err != nil {
+			return PathInfo{}, err
+		}
+		inspection.mu.Lock()
+		inspection.paths[p] = info
+		inspection.mu.Unlock()
+		return info, nil
+	}
+
+	mismatches, err := checkCommand(ctx, inspection.config, cmd, stat)
+	if err != nil {
+		return err
+	}
+	if len(mismatches) > 0 {
+		return &PreflightError{Image: image, Mismatches: mismatches}
+	}
+	return nil
+}
+
+// statContainerPath reads the tar header `docker cp` emits for p and stops
+// before the content follows
+func statContainerPath(ctx context.Context, containerID, p string) (PathInfo, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "docker", "cp", containerID+":"+p, "-")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return PathInfo{}, fmt.Errorf("failed to stat %s: %w", p, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return PathInfo{}, fmt.Errorf("failed to stat %s: %w", p, err)
+	}
+
+	header, headerErr := tar.NewReader(stdout).Next()
+	cancel()
+	io.Copy(io.Discard, stdout)
+	cmd.Wait()
+
+	if headerErr != nil {
+		msg := stderr.String()
+		if errors.Is(headerErr, io.EOF) && (strings.Contains(msg, "Could not find the file") || strings.Contains(msg, "No such container:path")) {
+			return PathInfo{}, nil
+		}
+		return PathInfo{}, fmt.Errorf("failed to stat %s: %s", p, strings.TrimSpace(msg))
+	}
+	return pathInfoFromHeader(header), nil
+}
+
+func pathInfoFromHeader(header *tar.Header) PathInfo {
+	info := PathInfo{Exists: true, Mode: os.FileMode(header.Mode).Perm()}
+	switch header.Typeflag {
+	case tar.TypeDir:
+		info.Dir = true
+	case tar.TypeSymlink:
+		info.LinkTarget = header.Linkname
+	}
+	return info
+}
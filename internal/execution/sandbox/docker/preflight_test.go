@@ -0,0 +1,232 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+// imageFixture is an image configuration and the files of the image that
+// preflight may look up
+type imageFixture struct {
+	Config ImageConfig `json:"config"`
+	Files  map[string]struct {
+		Dir  bool        `json:"dir"`
+		Mode os.FileMode `json:"mode"`
+		Link string      `json:"link"`
+	} `json:"files"`
+}
+
+func loadImageFixture(t *testing.T, name string) (ImageConfig, statFunc) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "preflight", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fixture imageFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		t.Fatal(err)
+	}
+	stat := func(ctx context.Context, p string) (PathInfo, error) {
+		file, ok := fixture.Files[p]
+		if !ok {
+			return PathInfo{}, nil
+		}
+		return PathInfo{Exists: true, Dir: file.Dir, Mode: file.Mode, LinkTarget: file.Link}, nil
+	}
+	return fixture.Config, stat
+}
+
+func TestCheckCommand(t *testing.T) {
+	tests := []struct {
+		name  string
+		image string
+		cmd   TaskCommand
+		// want lists the expected mismatches as "fatal: reason" or "warn: reason"
+		want []string
+	}{
+		{
+			name:  "image default through PATH symlinks",
+			image: "python-slim.json",
+			cmd:   TaskCommand{WorkDir: "/"},
+		},
+		{
+			name:  "command found in PATH",
+			image: "python-slim.json",
+			cmd:   TaskCommand{Command: []string{"python", "train.py"}, WorkDir: "/app"},
+		},
+		{
+			name:  "misspelt command",
+			image: "python-slim.json",
+			cmd:   TaskCommand{Command: []string{"pyhton", "train.py"}, WorkDir: "/app"},
+			want:  []string{`fatal: executable "pyhton" not found in the image's PATH (/usr/local/bin:/usr/local/sbin:/usr/sbin:/usr/bin:/sbin:/bin)`},
+		},
+		{
+			name:  "relative script resolved against the working directory",
+			image: "python-slim.json",
+			cmd:   TaskCommand{Command: []string{"./start.sh"}, WorkDir: "/app"},
+		},
+		{
+			name:  "script without the executable bit",
+			image: "python-slim.json",
+			cmd:   TaskCommand{Entrypoint: []string{"/app/run.sh"}, WorkDir: "/app"},
+			want:  []string{"fatal: /app/run.sh is not executable"},
+		},
+		{
+			name:  "task PATH reaches a virtualenv",
+			image: "python-slim.json",
+			cmd:   TaskCommand{Command: []string{"gunicorn", "app:app"}, Env: []string{"PATH=/opt/venv/bin:/usr/bin"}, WorkDir: "/app"},
+		},
+		{
+			name:  "missing working directory",
+			image: "python-slim.json",
+			cmd:   TaskCommand{Command: []string{"python3"}, WorkDir: "/workspace"},
+			want:  []string{"warn: working directory /workspace does not exist in the image"},
+		},
+		{
+			name:  "shell form command runs a missing program",
+			image: "python-slim.json",
+			cmd:   TaskCommand{Command: []string{"/bin/sh", "-c", "LOG=1 exec gunicorn app:app"}, WorkDir: "/app"},
+			want:  []string{`fatal: executable "gunicorn" not found in the image's PATH (/usr/local/bin:/usr/local/sbin:/usr/sbin:/usr/bin:/sbin:/bin) (run by the shell)`},
+		},
+		{
+			name:  "shell form starting with a builtin is not second-guessed",
+			image: "python-slim.json",
+			cmd:   TaskCommand{Command: []string{"/bin/sh", "-c", "cd /app && nonexistent"}, WorkDir: "/"},
+		},
+		{
+			name:  "shell form in an image without a shell",
+			image: "distroless.json",
+			cmd:   TaskCommand{Entrypoint: []string{}, Command: []string{"/bin/sh", "-c", "python3 train.py"}, WorkDir: "/app"},
+			want:  []string{"fatal: executable /bin/sh not found in the image"},
+		},
+		{
+			name:  "command passed to an exec form entrypoint",
+			image: "distroless.json",
+			cmd:   TaskCommand{Command: []string{"/app/train.py"}, WorkDir: "/app"},
+		},
+		{
+			name:  "command repeats the entrypoint",
+			image: "distroless.json",
+			cmd:   TaskCommand{Command: []string{"python3.11", "/app/train.py"}, WorkDir: "/app"},
+			want:  []string{"warn: the task command repeats the image's entrypoint /usr/bin/python3.11, which receives it as arguments"},
+		},
+		{
+			name:  "shell form entrypoint ignores the command",
+			image: "node-shell-entrypoint.json",
+			cmd:   TaskCommand{Command: []string{"node", "worker.js"}, WorkDir: "/srv"},
+			want:  []string{`fatal: the image's entrypoint is in shell form and ignores the task command "node worker.js"`},
+		},
+		{
+			name:  "shell form entrypoint checks its script",
+			image: "node-shell-entrypoint.json",
+			cmd:   TaskCommand{WorkDir: "/srv"},
+		},
+		{
+			name:  "clearing the entrypoint drops the image cmd",
+			image: "python-slim.json",
+			cmd:   TaskCommand{Entrypoint: []string{}, WorkDir: "/"},
+			want:  []string{"fatal: the image has no entrypoint or cmd and the task sets no command"},
+		},
+		{
+			name:  "scratch image with an absolute command",
+			image: "scratch.json",
+			cmd:   TaskCommand{Command: []string{"/server"}, WorkDir: "/"},
+		},
+		{
+			name:  "scratch image with nothing to run",
+			image: "scratch.json",
+			cmd:   TaskCommand{WorkDir: "/"},
+			want:  []string{"fatal: the image has no entrypoint or cmd and the task sets no command"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, stat := loadImageFixture(t, tt.image)
+			mismatches, err := checkCommand(context.Background(), img, tt.cmd, stat)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, m := range mismatches {
+				severity := "warn"
+				if m.Fatal {
+					severity = "fatal"
+				}
+				got = append(got, severity+": "+m.Reason)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("mismatches = %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskCommandFollowsDockerOverrides(t *testing.T) {
+	img := ImageConfig{Entrypoint: []string{"tini", "--"}, Cmd: []string{"serve"}}
+
+	tests := []struct {
+		name       string
+		env        map[string]interface{}
+		argv       []string
+		createOpts []string
+		createArgs []string
+	}{
+		{"image defaults", nil, []string{"tini", "--", "serve"}, nil, nil},
+		{"command replaces cmd", map[string]interface{}{"command": []interface{}{"work", "--fast"}},
+			[]string{"tini", "--", "work", "--fast"}, nil, []string{"work", "--fast"}},
+		{"entrypoint drops cmd", map[string]interface{}{"entrypoint": []interface{}{"python", "-u"}},
+			[]string{"python", "-u"}, []string{"--entrypoint", "python"}, []string{"-u"}},
+		{"shell form command", map[string]interface{}{"entrypoint": []interface{}{}, "command": "echo hi"},
+			[]string{"/bin/sh", "-c", "echo hi"}, []string{"--entrypoint", ""}, []string{"/bin/sh", "-c", "echo hi"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := taskCommand(&models.EnvironmentConfig{Type: "docker", Config: tt.env})
+			if got := cmd.Argv(img); !reflect.DeepEqual(got, tt.argv) {
+				t.Fatalf("Argv() = %q, want %q", got, tt.argv)
+			}
+			opts, args := cmd.createArgs()
+			if !reflect.DeepEqual(opts, tt.createOpts) || !reflect.DeepEqual(args, tt.createArgs) {
+				t.Fatalf("createArgs() = %q %q, want %q %q", opts, args, tt.createOpts, tt.createArgs)
+			}
+		})
+	}
+}
+
+func TestPreflighterCachesByDigest(t *testing.T) {
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker not available")
+	}
+	ctx := context.Background()
+	if _, err := executils.ExecCommand(ctx, "docker", "image", "inspect", "busybox:latest"); err != nil {
+		t.Skip("busybox image not available locally")
+	}
+
+	p := NewPreflighter()
+	if err := p.Check(ctx, "busybox:latest", TaskCommand{Command: []string{"sh", "-c", "echo ok"}, WorkDir: "/"}); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	err := p.Check(ctx, "busybox:latest", TaskCommand{Command: []string{"python3"}, WorkDir: "/"})
+	if err == nil || !strings.Contains(err.Error(), `"python3" not found`) {
+		t.Fatalf("expected python3 to be missing from busybox, got %v", err)
+	}
+
+	if len(p.inspections) != 1 {
+		t.Fatalf("expected one cached inspection, got %d", len(p.inspections))
+	}
+	for _, inspection := range p.inspections {
+		if _, ok := inspection.paths["/bin/sh"]; !ok {
+			t.Fatal("expected /bin/sh to be cached")
+		}
+	}
+}
@@ -2,17 +2,34 @@ package docker
 
 import (
 	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/profiles"
 )
 
 func TestSeccompProfileGeneration(t *testing.T) {
-	profile, err := createSeccompProfile()
+	paths, err := writeSeccompProfiles()
 	if err != nil {
-		t.Fatalf("Failed to create seccomp profile: %v", err)
+		t.Fatalf("Failed to create seccomp profiles: %v", err)
+	}
+	defer os.RemoveAll(filepath.Dir(paths[profiles.Restricted]))
+	if len(paths) != len(profiles.Names()) {
+		t.Errorf("Wrote %d seccomp profiles, want one per security profile", len(paths))
+	}
+
+	data, err := os.ReadFile(paths[profiles.Restricted])
+	if err != nil {
+		t.Fatalf("Failed to read restricted seccomp profile: %v", err)
+	}
+	var profile profiles.Seccomp
+	if err := json.Unmarshal(data, &profile); err != nil {
+		t.Fatalf("Invalid restricted seccomp profile: %v", err)
 	}
 
 	if profile.DefaultAction != "SCMP_ACT_ALLOW" {
@@ -28,14 +45,16 @@ func TestSeccompProfileGeneration(t *testing.T) {
 	hasRebootRestriction := false
 
 	for _, syscall := range profile.Syscalls {
-		if syscall.Name == "ptrace" && syscall.Action == "SCMP_ACT_ERRNO" {
-			hasPtraceRestriction = true
-		}
-		if syscall.Name == "mount" && syscall.Action == "SCMP_ACT_ERRNO" {
-			hasMountRestriction = true
-		}
-		if syscall.Name == "reboot" && syscall.Action == "SCMP_ACT_ERRNO" {
-			hasRebootRestriction = true
+		for _, name := range syscall.Names {
+			if name == "ptrace" && syscall.Action == "SCMP_ACT_ERRNO" {
+				hasPtraceRestriction = true
+			}
+			if name == "mount" && syscall.Action == "SCMP_ACT_ERRNO" {
+				hasMountRestriction = true
+			}
+			if name == "reboot" && syscall.Action == "SCMP_ACT_ERRNO" {
+				hasRebootRestriction = true
+			}
 		}
 	}
 
@@ -52,6 +71,31 @@ func TestSeccompProfileGeneration(t *testing.T) {
 	}
 }
 
+func TestProfileArgs(t *testing.T) {
+	paths, err := writeSeccompProfiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Dir(paths[profiles.Restricted]))
+	cm := &ContainerManager{seccompProfiles: paths}
+
+	args, err := cm.profileArgs(ContainerOptions{})
+	if err != nil || strings.Join(args, " ") != "--security-opt seccomp="+paths[profiles.Restricted] {
+		t.Errorf("profileArgs() = %q, %v, want the restricted filter", args, err)
+	}
+
+	build, _ := profiles.Get(profiles.Build)
+	args, err = cm.profileArgs(ContainerOptions{SecurityProfile: build, AppArmor: true})
+	want := "--security-opt seccomp=" + paths[profiles.Build] + " --security-opt apparmor=parity-build"
+	if err != nil || strings.Join(args, " ") != want {
+		t.Errorf("profileArgs() = %q, %v, want %q", args, err, want)
+	}
+
+	if _, err := (&ContainerManager{}).profileArgs(ContainerOptions{}); err == nil {
+		t.Error("profileArgs() confined a container without its seccomp filter")
+	}
+}
+
 func TestContainerSecurityCheck(t *testing.T) {
 	t.Skip("Manual test only - requires Docker environment")
 
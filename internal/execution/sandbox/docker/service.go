@@ -0,0 +1,213 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/profiles"
+)
+
+// ServiceSpec describes the container of a service task
+type ServiceSpec struct {
+	Image    string
+	ImageURL string
+	// ImageDigest is the sha256 digest of the tarball at ImageURL
+	ImageDigest string
+	Entrypoint  []string
+	Command     []string
+	Env         map[string]string
+	// Ports are published on the host once the network policy allows them
+	Ports           []models.ServicePort
+	Resources       models.ResourceConfig
+	SecurityProfile string
+}
+
+// CheckPorts checks a service task's ports against the network policy
+func (e *DockerExecutor) CheckPorts(ports []models.ServicePort) error {
+	return e.network.CheckPorts(ports)
+}
+
+// ServiceContainer is the container of a service task, created anew each
+// time the service is started so that a restart does not inherit the state
+// of the run that crashed
+type ServiceContainer struct {
+	e     *DockerExecutor
+	image string
+	env   []string
+	opts  ContainerOptions
+	user  TaskUser
+	soft  uint64
+	ports []models.ServicePort
+
+	mu          sync.Mutex
+	containerID string
+}
+
+// NewServiceContainer prepares the container of a service task as spec
+// describes, pulling its image. The container is not started until Start;
+// Close releases what it holds once the service is done.
+func (e *DockerExecutor) NewServiceContainer(ctx context.Context, task *models.Task, spec ServiceSpec) (*ServiceContainer, *models.AppliedSecurityProfile, error) {
+	log := gologger.WithComponent("docker")
+
+	if err := e.network.CheckPorts(spec.Ports); err != nil {
+		return nil, nil, fmt.Errorf("invalid service ports: %w", err)
+	}
+	profile, err := e.security.Resolve(spec.SecurityProfile)
+	if err != nil {
+		return nil, nil, err
+	}
+	appArmor := profiles.LoadAppArmor(ctx, profile)
+	applied := &models.AppliedSecurityProfile{Name: profile.Name, Seccomp: true, AppArmor: appArmor}
+
+	limits, err := e.memoryLimits(spec.Resources)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	setupCtx, cancel := context.WithTimeout(ctx, e.config.Timeout)
+	defer cancel()
+	if err := e.imageManager.EnsureImageAvailable(setupCtx, spec.Image, spec.ImageURL, spec.ImageDigest); err != nil {
+		return nil, nil, fmt.Errorf("image preparation failed: %w", err)
+	}
+
+	user, err := e.taskUser(ctx, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	env := []string{"HOME=/tmp", fmt.Sprintf("TASK_NONCE=%s", task.Nonce)}
+	keys := make([]string, 0, len(spec.Env))
+	for k := range spec.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+spec.Env[k])
+	}
+	env = append(env, limits.env()...)
+
+	opts := ContainerOptions{
+		Labels:          map[string]string{TaskIDLabel: task.ID.String()},
+		Ports:           spec.Ports,
+		Capabilities:    e.users.Capabilities,
+		SecurityProfile: profile,
+		AppArmor:        appArmor,
+		Entrypoint:      spec.Entrypoint,
+		Command:         spec.Command,
+		User:            user.String(),
+	}
+	if spec.Resources.Memory != "" {
+		opts.Memory = strconv.FormatUint(limits.Hard, 10)
+	}
+
+	log.Info().
+		Str("task_id", task.ID.String()).
+		Str("image", spec.Image).
+		Str("user", user.String()).
+		Int("ports", len(spec.Ports)).
+		Str("security_profile", profile.Name).
+		Msg("Service container prepared")
+
+	return &ServiceContainer{
+		e:     e,
+		image: e.imageManager.Local(spec.Image),
+		env:   env,
+		opts:  opts,
+		user:  user,
+		soft:  limits.Soft,
+		ports: spec.Ports,
+	}, applied, nil
+}
+
+// Start creates and starts a fresh container for the service
+func (c *ServiceContainer) Start(ctx context.Context) error {
+	cm := c.e.containerMgr
+	containerID, err := cm.CreateContainerWithOptions(ctx, c.image, "", c.env, c.opts)
+	if err != nil {
+		return models.Failure(models.FailureContainerRuntime, fmt.Errorf("container creation failed: %w", err))
+	}
+	c.mu.Lock()
+	c.containerID = containerID
+	c.mu.Unlock()
+
+	if err := cm.StartContainer(ctx, containerID); err != nil {
+		return models.Failure(models.FailureContainerRuntime, fmt.Errorf("container start failed: %w", err))
+	}
+	if c.soft > 0 {
+		if err := setMemoryHigh(ctx, containerID, c.soft); err != nil {
+			log := gologger.WithComponent("docker")
+			log.Warn().Err(err).Str("container_id", containerID).Msg("Failed to set soft memory limit in the kernel, relying on signals")
+		}
+	}
+	return nil
+}
+
+func (c *ServiceContainer) id() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.containerID
+}
+
+// Wait returns the exit code of the running container
+func (c *ServiceContainer) Wait(ctx context.Context) (int, error) {
+	containerID := c.id()
+	if containerID == "" {
+		return -1, errors.New("service container is not running")
+	}
+	return c.e.containerMgr.WaitForContainer(ctx, containerID)
+}
+
+// Stop stops and removes the running container, if any
+func (c *ServiceContainer) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	containerID := c.containerID
+	c.containerID = ""
+	c.mu.Unlock()
+	if containerID == "" {
+		return nil
+	}
+	// A container that already exited cannot be signalled; removing it is
+	// all that is left to do
+	_ = c.e.containerMgr.TerminateContainer(ctx, containerID, c.e.containerMgr.stopGrace)
+	return c.e.containerMgr.RemoveContainer(ctx, containerID)
+}
+
+// Address returns where port of the service is reached from the runner:
+// the loopback address of its published host port, or the container's own
+// address, IPv4 when it has one, when the port is not published
+func (c *ServiceContainer) Address(ctx context.Context, port int) (string, error) {
+	for _, p := range c.ports {
+		if p.ContainerPort == port && (p.Protocol == "" || p.Protocol == "tcp") {
+			return net.JoinHostPort("127.0.0.1", strconv.Itoa(p.HostPort)), nil
+		}
+	}
+	containerID := c.id()
+	if containerID == "" {
+		return "", errors.New("service container is not running")
+	}
+	output, err := executils.ExecCommand(ctx, "docker", "inspect", "-f", "{{range .NetworkSettings.Networks}}{{.IPAddress}} {{.GlobalIPv6Address}} {{end}}", containerID)
+	if err != nil {
+		return "", fmt.Errorf("container inspect failed: %w", err)
+	}
+	ips := strings.Fields(string(output))
+	if len(ips) == 0 {
+		return "", errors.New("service container has no address")
+	}
+	return net.JoinHostPort(ips[0], strconv.Itoa(port)), nil
+}
+
+// Close releases the user the service ran as; the container must have been
+// stopped
+func (c *ServiceContainer) Close() {
+	c.e.userAlloc.release(c.user)
+}
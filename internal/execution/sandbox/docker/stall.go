@@ -0,0 +1,251 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// stallInterval is how often a task's pressure is read; the kernel averages
+// it over the last ten seconds
+const stallInterval = 5 * time.Second
+
+// defaultStallSustain is how long a task must stall before it is reported,
+// unless the policy sets it
+const defaultStallSustain = 2 * time.Minute
+
+// stallResources are the resources whose pressure can be watched
+var stallResources = []string{"memory", "io", "cpu"}
+
+// StallPolicy configures stall detection from the pressure stall information
+// (PSI) of each task's cgroup, on Linux hosts with cgroup v2. A task stalls
+// on a resource while the share of time all of its processes were waiting
+// on it stays at or above the resource's threshold; tasks thrashing on
+// memory or IO make no progress long before their timeout ends them.
+type StallPolicy struct {
+	// Memory, IO and CPU are the thresholds in percent; zero leaves the
+	// resource unwatched
+	Memory float64
+	IO     float64
+	CPU    float64
+	// Sustain is how long a task must stall before it is reported
+	Sustain time.Duration
+	// Abandon ends a task reported stalled rather than leaving it to run
+	// until its timeout
+	Abandon bool
+}
+
+// threshold returns the policy's threshold for resource, zero if unwatched
+func (p StallPolicy) threshold(resource string) float64 {
+	switch resource {
+	case "memory":
+		return p.Memory
+	case "io":
+		return p.IO
+	case "cpu":
+		return p.CPU
+	}
+	return 0
+}
+
+// resources returns the resources the policy watches
+func (p StallPolicy) resources() []string {
+	var watched []string
+	for _, resource := range stallResources {
+		if p.threshold(resource) > 0 {
+			watched = append(watched, resource)
+		}
+	}
+	return watched
+}
+
+// SetStallPolicy reports, and optionally abandons, tasks whose processes
+// stay stalled on memory, IO or CPU
+func (e *DockerExecutor) SetStallPolicy(policy StallPolicy) {
+	e.stall = policy
+}
+
+// StallError ends a task abandoned for stalling
+type StallError struct {
+	Evidence *models.StallEvidence
+}
+
+func (e *StallError) Error() string {
+	ev := e.Evidence
+	return fmt.Sprintf("task stalled on %s for %s: fully stalled %.1f%% of the time, above the threshold of %.1f%%",
+		ev.Resource, time.Duration(ev.StalledSeconds*float64(time.Second)).Round(time.Second), ev.Pressure[ev.Resource], ev.Threshold)
+}
+
+// stallSource reads the pressure of a running task and snapshots its
+// processes
+type stallSource interface {
+	// pressure returns the full avg10 of each of resources the host reports
+	pressure(resources []string) (map[string]float64, error)
+	snapshot() ([]models.StalledProcess, []models.ProcessIO)
+}
+
+// parsePressure returns the avg10 of the "full" line of a PSI file, the
+// share of the last ten seconds every process was stalled. Files without
+// one, such as cpu.pressure on older kernels, report false.
+func parsePressure(data string) (float64, bool) {
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "full" {
+			continue
+		}
+		for _, field := range fields[1:] {
+			if value, ok := strings.CutPrefix(field, "avg10="); ok {
+				avg10, err := strconv.ParseFloat(value, 64)
+				return avg10, err == nil
+			}
+		}
+	}
+	return 0, false
+}
+
+// stallTracker follows a task's pressure against the policy's thresholds
+type stallTracker struct {
+	policy   StallPolicy
+	sustain  time.Duration
+	since    map[string]time.Time
+	reported bool
+}
+
+func newStallTracker(policy StallPolicy) *stallTracker {
+	sustain := policy.Sustain
+	if sustain <= 0 {
+		sustain = defaultStallSustain
+	}
+	return &stallTracker{policy: policy, sustain: sustain, since: make(map[string]time.Time)}
+}
+
+// observe records a pressure sample and returns the resource the task has
+// stalled on for the sustain period and for how long: once each time it
+// stalls, until every resource drops below its threshold again
+func (t *stallTracker) observe(now time.Time, pressure map[string]float64) (string, time.Duration, bool) {
+	stalling := false
+	resource, longest := "", time.Duration(0)
+	for _, r := range stallResources {
+		threshold := t.policy.threshold(r)
+		value, ok := pressure[r]
+		if threshold <= 0 || !ok || value < threshold {
+			delete(t.since, r)
+			continue
+		}
+		stalling = true
+		if _, ok := t.since[r]; !ok {
+			t.since[r] = now
+		}
+		if stalled := now.Sub(t.since[r]); stalled >= t.sustain && stalled > longest {
+			resource, longest = r, stalled
+		}
+	}
+	if !stalling {
+		t.reported = false
+	}
+	if resource == "" || t.reported {
+		return "", 0, false
+	}
+	t.reported = true
+	return resource, longest, true
+}
+
+// watchStall follows the pressure of the container's cgroup until ctx
+// ends. Each time the task stalls for the sustain period its processes
+// are snapshotted and a warning is sent; when the policy abandons stalled
+// tasks, abandon is called with the evidence. Hosts without cgroup v2
+// pressure stall information skip this.
+func (e *DockerExecutor) watchStall(ctx context.Context, task *models.Task, containerID string, abandon func(*StallError)) {
+	if len(e.stall.resources()) == 0 {
+		return
+	}
+	source, err := openStallSource(ctx, containerID)
+	if err != nil {
+		log := gologger.WithComponent("docker.stall")
+		log.Debug().Err(err).
+			Str("task_id", task.ID.String()).
+			Str("container_id", containerID).
+			Msg("Stall detection unavailable for task")
+		return
+	}
+	e.followStall(ctx, task, containerID, source, abandon)
+}
+
+func (e *DockerExecutor) followStall(ctx context.Context, task *models.Task, containerID string, source stallSource, abandon func(*StallError)) {
+	log := gologger.WithComponent("docker.stall")
+	resources := e.stall.resources()
+	tracker := newStallTracker(e.stall)
+
+	ticker := e.containerMgr.clock.NewTicker(stallInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		pressure, err := source.pressure(resources)
+		if err != nil {
+			// The container's cgroup is gone once it exits
+			log.Debug().Err(err).Str("container_id", containerID).Msg("Stopped watching task pressure")
+			return
+		}
+		resource, stalled, ok := tracker.observe(e.containerMgr.clock.Now(), pressure)
+		if !ok {
+			continue
+		}
+
+		evidence := &models.StallEvidence{
+			Resource:       resource,
+			Pressure:       pressure,
+			Threshold:      e.stall.threshold(resource),
+			StalledSeconds: stalled.Seconds(),
+		}
+		evidence.Processes, evidence.TopIO = source.snapshot()
+		e.warnStall(task, containerID, evidence)
+		if e.stall.Abandon {
+			abandon(&StallError{Evidence: evidence})
+			return
+		}
+	}
+}
+
+// warnStall logs a stalled task and warns the server with the evidence
+func (e *DockerExecutor) warnStall(task *models.Task, containerID string, evidence *models.StallEvidence) {
+	log := gologger.WithComponent("docker.stall")
+
+	log.Warn().
+		Str("task_id", task.ID.String()).
+		Str("container_id", containerID).
+		Str("resource", evidence.Resource).
+		Float64("pressure", evidence.Pressure[evidence.Resource]).
+		Float64("threshold", evidence.Threshold).
+		Float64("stalled_seconds", evidence.StalledSeconds).
+		Int("processes", len(evidence.Processes)).
+		Bool("abandon", e.stall.Abandon).
+		Msg("Task stalled")
+
+	if e.warnings == nil {
+		return
+	}
+	warning := &models.TaskWarning{
+		Code:    models.TaskWarningStalled,
+		Message: (&StallError{Evidence: evidence}).Error(),
+		Time:    e.containerMgr.clock.Now(),
+		Stall:   evidence,
+	}
+	go func() {
+		if err := e.warnings.SendTaskWarning(task.ID.String(), warning); err != nil {
+			log.Warn().Err(err).
+				Str("task_id", task.ID.String()).
+				Msg("Failed to send stall warning")
+		}
+	}()
+}
@@ -0,0 +1,204 @@
+//go:build linux
+
+package docker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const (
+	// procRoot is where the proc filesystem is mounted
+	procRoot = "/proc"
+	// stallSnapshotProcesses caps the processes a stall snapshot lists
+	stallSnapshotProcesses = 32
+	// stallSnapshotTopIO is how many of the busiest processes a stall
+	// snapshot lists the IO and open files of
+	stallSnapshotTopIO = 5
+	// stallSnapshotFiles caps the open files listed per process
+	stallSnapshotFiles = 16
+)
+
+// cgroupStallSource reads the pressure files of a container's cgroup
+type cgroupStallSource struct {
+	dir string
+}
+
+// openStallSource returns the pressure of the running container's cgroup.
+// It requires cgroup v2 with pressure stall information enabled and a
+// runner on the same host as the docker daemon.
+func openStallSource(ctx context.Context, containerID string) (stallSource, error) {
+	dir, err := containerCgroup(ctx, containerID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, "memory.pressure")); err != nil {
+		return nil, fmt.Errorf("pressure stall information unavailable: %w", err)
+	}
+	return &cgroupStallSource{dir: dir}, nil
+}
+
+func (s *cgroupStallSource) pressure(resources []string) (map[string]float64, error) {
+	pressure := make(map[string]float64, len(resources))
+	for _, resource := range resources {
+		data, err := os.ReadFile(filepath.Join(s.dir, resource+".pressure"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s pressure: %w", resource, err)
+		}
+		if avg10, ok := parsePressure(string(data)); ok {
+			pressure[resource] = avg10
+		}
+	}
+	return pressure, nil
+}
+
+func (s *cgroupStallSource) snapshot() ([]models.StalledProcess, []models.ProcessIO) {
+	return snapshotProcesses(procRoot, cgroupProcs(s.dir))
+}
+
+// cgroupProcs returns the IDs of the processes in the cgroup at dir
+func cgroupProcs(dir string) []int {
+	f, err := os.Open(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var pids []int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if pid, err := strconv.Atoi(strings.TrimSpace(scanner.Text())); err == nil {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+// snapshotProcesses reads where each of pids is waiting, and the IO and open
+// files of the busiest, from the proc filesystem at proc. Processes that
+// exited meanwhile are left out; kernel stacks are only readable by root.
+func snapshotProcesses(proc string, pids []int) ([]models.StalledProcess, []models.ProcessIO) {
+	if len(pids) > stallSnapshotProcesses {
+		pids = pids[:stallSnapshotProcesses]
+	}
+	var processes []models.StalledProcess
+	var io []models.ProcessIO
+	for _, pid := range pids {
+		dir := filepath.Join(proc, strconv.Itoa(pid))
+		command, state, ok := processStat(filepath.Join(dir, "stat"))
+		if !ok {
+			continue
+		}
+		process := models.StalledProcess{PID: pid, Command: command, State: state}
+		if wchan, err := os.ReadFile(filepath.Join(dir, "wchan")); err == nil && string(wchan) != "0" {
+			process.WaitChannel = strings.TrimSpace(string(wchan))
+		}
+		process.Stack = kernelStack(filepath.Join(dir, "stack"))
+		processes = append(processes, process)
+
+		if read, write, ok := processIO(filepath.Join(dir, "io")); ok && read+write > 0 {
+			io = append(io, models.ProcessIO{PID: pid, Command: command, ReadBytes: read, WriteBytes: write})
+		}
+	}
+
+	sort.Slice(io, func(i, j int) bool {
+		return io[i].ReadBytes+io[i].WriteBytes > io[j].ReadBytes+io[j].WriteBytes
+	})
+	if len(io) > stallSnapshotTopIO {
+		io = io[:stallSnapshotTopIO]
+	}
+	for i := range io {
+		io[i].Files = openFiles(filepath.Join(proc, strconv.Itoa(io[i].PID), "fd"))
+	}
+	return processes, io
+}
+
+// processStat returns the command and state in a /proc/<pid>/stat file. The
+// command is parenthesised and may itself contain spaces and parentheses.
+func processStat(path string) (string, string, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", false
+	}
+	stat := string(data)
+	open, end := strings.IndexByte(stat, '('), strings.LastIndexByte(stat, ')')
+	if open < 0 || end < open {
+		return "", "", false
+	}
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) == 0 {
+		return "", "", false
+	}
+	return stat[open+1 : end], fields[0], true
+}
+
+// kernelStack returns the functions of a /proc/<pid>/stack file
+func kernelStack(path string) []string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var stack []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if _, frame, ok := strings.Cut(line, "] "); ok {
+			stack = append(stack, frame)
+		}
+	}
+	return stack
+}
+
+// processIO returns the bytes a process read from and wrote to storage,
+// from its /proc/<pid>/io file
+func processIO(path string) (uint64, uint64, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, false
+	}
+	var read, write uint64
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			continue
+		}
+		switch key {
+		case "read_bytes":
+			read = n
+		case "write_bytes":
+			write = n
+		}
+	}
+	return read, write, true
+}
+
+// openFiles returns the files a process has open, from its /proc/<pid>/fd
+// directory, leaving out pipes, sockets and other descriptors without a path
+func openFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	for _, entry := range entries {
+		target, err := os.Readlink(filepath.Join(dir, entry.Name()))
+		if err != nil || !strings.HasPrefix(target, "/") {
+			continue
+		}
+		files = append(files, target)
+		if len(files) == stallSnapshotFiles {
+			break
+		}
+	}
+	return files
+}
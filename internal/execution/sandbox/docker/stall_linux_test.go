@@ -0,0 +1,122 @@
+//go:build linux
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+func TestSnapshotProcesses(t *testing.T) {
+	dir := t.TempDir()
+	procs := strconv.Itoa(os.Getpid()) + "\n999999999\n"
+	if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(procs), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(dir, "held-open"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(make([]byte, 1<<20)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The process that exited is left out
+	processes, io := snapshotProcesses(procRoot, cgroupProcs(dir))
+	if len(processes) != 1 || processes[0].PID != os.Getpid() || processes[0].Command == "" || processes[0].State == "" {
+		t.Fatalf("processes = %+v, want the test process", processes)
+	}
+	if len(io) == 0 {
+		t.Skip("process IO accounting is not available")
+	}
+	found := false
+	for _, file := range io[0].Files {
+		found = found || file == f.Name()
+	}
+	if io[0].WriteBytes == 0 || !found {
+		t.Fatalf("top IO = %+v, want the test process's writes and %s", io[0], f.Name())
+	}
+}
+
+// thrashScript grows the shell's memory far past memory.high, where the
+// kernel throttles and reclaims it on every allocation
+const thrashScript = `chunk=$(head -c 1048576 /dev/zero | tr '\0' x)
+s=
+while :; do s="$s$chunk"; done`
+
+func TestStallAbandonsThrashingTask(t *testing.T) {
+	if _, err := executils.ExecCommand(context.Background(), "docker", "version"); err != nil {
+		t.Skip("docker is not available")
+	}
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		t.Skip("cgroup v2 is not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
+	defer cancel()
+
+	cm, err := NewContainerManager("512m", "0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	warnings := &warningRecorder{}
+	e := &DockerExecutor{
+		containerMgr: cm,
+		stall:        StallPolicy{Memory: 5, Sustain: 10 * time.Second, Abandon: true},
+		warnings:     warnings,
+	}
+
+	containerID, err := cm.CreateContainerWithOptions(ctx, "alpine:latest", "/", nil, ContainerOptions{
+		Memory:  "512m",
+		Command: []string{"sh", "-c", thrashScript},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm.RemoveContainer(context.Background(), containerID)
+	if err := cm.StartContainer(ctx, containerID); err != nil {
+		t.Fatal(err)
+	}
+	if err := setMemoryHigh(ctx, containerID, 16<<20); err != nil {
+		t.Skipf("memory.high cannot be set: %v", err)
+	}
+	if _, err := openStallSource(ctx, containerID); err != nil {
+		t.Skipf("pressure stall information is not available: %v", err)
+	}
+
+	execCtx, abandon := context.WithCancelCause(ctx)
+	defer abandon(nil)
+	go e.watchStall(execCtx, &models.Task{ID: uuid.New()}, containerID, func(stalled *StallError) {
+		abandon(stalled)
+	})
+	<-execCtx.Done()
+
+	var stalled *StallError
+	if !errors.As(context.Cause(execCtx), &stalled) {
+		t.Fatalf("cause = %v, want the thrashing task abandoned", context.Cause(execCtx))
+	}
+	if stalled.Evidence.Resource != "memory" || len(stalled.Evidence.Processes) == 0 {
+		t.Fatalf("evidence = %+v, want a memory stall with the task's processes", stalled.Evidence)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for warnings.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if warnings.count() == 0 {
+		t.Fatal("no stall warning sent")
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package docker
+
+import (
+	"context"
+	"errors"
+)
+
+// openStallSource is unsupported without cgroup v2 pressure stall
+// information, so stall detection is skipped
+func openStallSource(ctx context.Context, containerID string) (stallSource, error) {
+	return nil, errors.ErrUnsupported
+}
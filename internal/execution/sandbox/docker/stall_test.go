@@ -0,0 +1,136 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func TestParsePressure(t *testing.T) {
+	psi := "some avg10=61.20 avg60=40.02 avg300=12.50 total=81234567\n" +
+		"full avg10=48.75 avg60=30.10 avg300=9.00 total=60123456\n"
+	if avg10, ok := parsePressure(psi); !ok || avg10 != 48.75 {
+		t.Fatalf("parsePressure() = %v, %v, want the full avg10 48.75", avg10, ok)
+	}
+	// cpu.pressure has no full line on kernels before 5.13
+	if _, ok := parsePressure("some avg10=5.00 avg60=1.00 avg300=0.20 total=1000\n"); ok {
+		t.Fatal("parsePressure() reported a pressure without a full line")
+	}
+	if _, ok := parsePressure("full avg10=x total=1\n"); ok {
+		t.Fatal("parsePressure() reported a malformed pressure")
+	}
+}
+
+func TestStallTracker(t *testing.T) {
+	tracker := newStallTracker(StallPolicy{Memory: 50, IO: 30, Sustain: 20 * time.Second})
+	start := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	// CPU is not watched, and a dip below the threshold restarts the count
+	for _, sample := range []struct {
+		at       time.Duration
+		pressure map[string]float64
+	}{
+		{0, map[string]float64{"memory": 70, "cpu": 100}},
+		{15 * time.Second, map[string]float64{"memory": 70, "cpu": 100}},
+		{20 * time.Second, map[string]float64{"memory": 10, "cpu": 100}},
+		{25 * time.Second, map[string]float64{"memory": 70, "io": 40}},
+		{40 * time.Second, map[string]float64{"memory": 70, "io": 40}},
+	} {
+		if resource, _, ok := tracker.observe(at(sample.at), sample.pressure); ok {
+			t.Fatalf("stalled on %s at %s, want no stall sustained", resource, sample.at)
+		}
+	}
+
+	resource, stalled, ok := tracker.observe(at(45*time.Second), map[string]float64{"memory": 70, "io": 40})
+	if !ok || resource != "memory" || stalled != 20*time.Second {
+		t.Fatalf("observe() = %s, %s, %v, want memory stalled for 20s", resource, stalled, ok)
+	}
+	if _, _, ok := tracker.observe(at(50*time.Second), map[string]float64{"memory": 70, "io": 40}); ok {
+		t.Fatal("stall reported twice")
+	}
+
+	// Once every resource recovers, the next stall is reported again
+	tracker.observe(at(55*time.Second), map[string]float64{"memory": 10, "io": 10})
+	tracker.observe(at(60*time.Second), map[string]float64{"io": 90})
+	if resource, _, ok := tracker.observe(at(80*time.Second), map[string]float64{"io": 90}); !ok || resource != "io" {
+		t.Fatalf("observe() = %s, %v, want a new stall on io", resource, ok)
+	}
+}
+
+// fakeStallSource returns one pressure sample per read
+type fakeStallSource struct {
+	samples []map[string]float64
+	reads   chan struct{}
+}
+
+func (s *fakeStallSource) pressure(resources []string) (map[string]float64, error) {
+	defer func() { s.reads <- struct{}{} }()
+	if len(s.samples) == 0 {
+		return nil, errors.New("cgroup removed")
+	}
+	sample := s.samples[0]
+	s.samples = s.samples[1:]
+	return sample, nil
+}
+
+func (s *fakeStallSource) snapshot() ([]models.StalledProcess, []models.ProcessIO) {
+	return []models.StalledProcess{{PID: 42, Command: "thrash", State: "D", WaitChannel: "folio_wait_bit_common"}}, nil
+}
+
+func TestFollowStallAbandons(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC))
+	warnings := &warningRecorder{}
+	e := &DockerExecutor{
+		containerMgr: &ContainerManager{clock: clk},
+		stall:        StallPolicy{Memory: 50, Sustain: 10 * time.Second, Abandon: true},
+		warnings:     warnings,
+	}
+	source := &fakeStallSource{
+		samples: []map[string]float64{{"memory": 80}, {"memory": 80}, {"memory": 85}},
+		reads:   make(chan struct{}, 4),
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.followStall(ctx, &models.Task{ID: uuid.New()}, "abc", source, func(stalled *StallError) {
+			cancel(stalled)
+		})
+	}()
+
+	clk.BlockUntil(1)
+	for i := 0; i < 3; i++ {
+		clk.Advance(stallInterval)
+		<-source.reads
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task not abandoned after stalling")
+	}
+
+	var stalled *StallError
+	if !errors.As(context.Cause(ctx), &stalled) {
+		t.Fatalf("cause = %v, want a stall", context.Cause(ctx))
+	}
+	evidence := stalled.Evidence
+	if evidence.Resource != "memory" || evidence.StalledSeconds != 10 || evidence.Pressure["memory"] != 85 || len(evidence.Processes) != 1 {
+		t.Fatalf("evidence = %+v, want memory stalled for 10s with the snapshot", evidence)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for warnings.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if warnings.count() != 1 || warnings.warnings[0].Code != models.TaskWarningStalled || warnings.warnings[0].Stall != evidence {
+		t.Fatalf("warnings = %+v, want one stall warning with the evidence", warnings.warnings)
+	}
+}
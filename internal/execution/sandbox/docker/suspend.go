@@ -0,0 +1,33 @@
+package docker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+// SuspendTask freezes the container running a task with docker pause. Its
+// processes stay in memory but get no CPU time until ResumeSuspendedTask.
+func (e *DockerExecutor) SuspendTask(ctx context.Context, taskID string) error {
+	containerID, err := e.FindTaskContainer(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	if _, err := executils.ExecCommand(ctx, "docker", "pause", containerID); err != nil {
+		return fmt.Errorf("failed to pause task container: %w", err)
+	}
+	return nil
+}
+
+// ResumeSuspendedTask unfreezes a container SuspendTask froze
+func (e *DockerExecutor) ResumeSuspendedTask(ctx context.Context, taskID string) error {
+	containerID, err := e.FindTaskContainer(ctx, taskID)
+	if err != nil {
+		return err
+	}
+	if _, err := executils.ExecCommand(ctx, "docker", "unpause", containerID); err != nil {
+		return fmt.Errorf("failed to unpause task container: %w", err)
+	}
+	return nil
+}
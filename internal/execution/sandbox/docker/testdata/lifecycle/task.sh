@@ -0,0 +1,20 @@
+#!/bin/sh
+# Fixture task for the lifecycle contract. It reports progress, writes and
+# announces a checkpoint, then runs until it is stopped. With IGNORE_TERM set
+# it ignores the stop signal and is killed once its grace period is over.
+
+report() { echo "$*" > "$PARITY_PROGRESS_FIFO"; }
+
+if [ -n "$IGNORE_TERM" ]; then
+	trap '' TERM
+else
+	trap 'echo "stopping within ${PARITY_STOP_GRACE_SECONDS}s"; report "progress 100 stopped"; exit 0' TERM
+fi
+
+report "progress 25 started $PARITY_TASK_ID"
+echo "state at step 1" > "$PARITY_CHECKPOINT_DIR/step-1.ckpt"
+report "checkpoint step-1.ckpt"
+report "progress 50"
+echo ready
+
+while :; do sleep 0.1; done
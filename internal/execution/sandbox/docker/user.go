@@ -0,0 +1,209 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+// ErrRootForbidden is returned for tasks that ask to run as root when the
+// runner's policy does not allow it
+var ErrRootForbidden = errors.New("task requires root, which this runner's policy forbids")
+
+// RootPolicy decides whether tasks that ask for root may have it
+type RootPolicy string
+
+const (
+	// RootDeny runs every task as an unprivileged user
+	RootDeny RootPolicy = "deny"
+	// RootUserns lets tasks run as root only when the Docker daemon remaps
+	// user namespaces, so that root in the container is unprivileged on
+	// the host
+	RootUserns RootPolicy = "userns"
+	// RootAllow lets tasks run as root
+	RootAllow RootPolicy = "allow"
+)
+
+// Default range of the users tasks run as, well above the users of any
+// host or image
+const (
+	DefaultUIDBase  = 200000
+	DefaultUIDCount = 1000
+)
+
+var capabilityPattern = regexp.MustCompile(`^[A-Z_]+$`)
+
+// UserPolicy decides which user task containers run as and which Linux
+// capabilities they keep. Tasks run as a user of their own allocated from
+// UIDBase and up, unless they ask for root and Root allows it. Every
+// capability is dropped except those in Capabilities.
+type UserPolicy struct {
+	Root         RootPolicy
+	UIDBase      int
+	UIDCount     int
+	Capabilities []string
+}
+
+// ParseUserPolicy checks a root policy, a range of task users such as
+// "200000-200999", empty for the default, and an allowlist of capabilities
+// such as NET_BIND_SERVICE
+func ParseUserPolicy(root string, uids string, capabilities []string) (UserPolicy, error) {
+	policy := UserPolicy{Root: RootPolicy(root), UIDBase: DefaultUIDBase, UIDCount: DefaultUIDCount}
+	switch policy.Root {
+	case RootDeny, RootUserns, RootAllow:
+	case "":
+		policy.Root = RootDeny
+	default:
+		return UserPolicy{}, fmt.Errorf("unknown root policy %q (supported: deny, userns, allow)", root)
+	}
+
+	if uids != "" {
+		first, last, ok := strings.Cut(uids, "-")
+		base, err := strconv.Atoi(strings.TrimSpace(first))
+		end, endErr := strconv.Atoi(strings.TrimSpace(last))
+		if !ok || err != nil || endErr != nil || base <= 0 || end < base {
+			return UserPolicy{}, fmt.Errorf("task user range %q must be first-last, such as 200000-200999, above 0", uids)
+		}
+		policy.UIDBase, policy.UIDCount = base, end-base+1
+	}
+
+	for _, capability := range capabilities {
+		capability = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(capability)), "CAP_")
+		if capability == "" {
+			continue
+		}
+		if !capabilityPattern.MatchString(capability) || capability == "ALL" {
+			return UserPolicy{}, fmt.Errorf("invalid capability %q", capability)
+		}
+		policy.Capabilities = append(policy.Capabilities, capability)
+	}
+	return policy, nil
+}
+
+// TaskUser is the user a task container runs as
+type TaskUser struct {
+	// Root leaves the container running as the image's user
+	Root bool
+	UID  int
+	GID  int
+}
+
+// String returns the user as Docker's --user takes it
+func (u TaskUser) String() string {
+	return fmt.Sprintf("%d:%d", u.UID, u.GID)
+}
+
+// userAllocator hands each running task a user no other running task has
+type userAllocator struct {
+	mu   sync.Mutex
+	used map[int]bool
+	next int
+}
+
+// allocate returns an unused user of policy's range, each its own group
+func (a *userAllocator) allocate(policy UserPolicy) (TaskUser, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.used == nil {
+		a.used = make(map[int]bool)
+	}
+	for i := 0; i < policy.UIDCount; i++ {
+		uid := policy.UIDBase + (a.next+i)%policy.UIDCount
+		if !a.used[uid] {
+			a.used[uid] = true
+			a.next = (a.next + i + 1) % policy.UIDCount
+			return TaskUser{UID: uid, GID: uid}, nil
+		}
+	}
+	return TaskUser{}, fmt.Errorf("all %d task users are in use", policy.UIDCount)
+}
+
+// release returns user to the range
+func (a *userAllocator) release(user TaskUser) {
+	if user.Root {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.used, user.UID)
+}
+
+// SetUserPolicy replaces the policy deciding which user tasks run as
+func (e *DockerExecutor) SetUserPolicy(policy UserPolicy) {
+	e.users = policy
+}
+
+// CheckUser checks that a task asking for root may have it
+func (e *DockerExecutor) CheckUser(ctx context.Context, runAsRoot bool) error {
+	if !runAsRoot {
+		return nil
+	}
+	switch e.users.Root {
+	case RootAllow:
+		return nil
+	case RootUserns:
+		if e.remapsUsers(ctx) {
+			return nil
+		}
+		return fmt.Errorf("%w: the docker daemon does not remap user namespaces", ErrRootForbidden)
+	default:
+		return ErrRootForbidden
+	}
+}
+
+// taskUser allocates the user a task runs as, to be released once its
+// container is removed
+func (e *DockerExecutor) taskUser(ctx context.Context, runAsRoot bool) (TaskUser, error) {
+	if err := e.CheckUser(ctx, runAsRoot); err != nil {
+		return TaskUser{}, err
+	}
+	if runAsRoot {
+		return TaskUser{Root: true}, nil
+	}
+	return e.userAlloc.allocate(e.users)
+}
+
+// remapsUsers reports whether the Docker daemon runs containers in a
+// remapped user namespace, asking it once
+func (e *DockerExecutor) remapsUsers(ctx context.Context) bool {
+	e.usernsOnce.Do(func() {
+		output, err := executils.ExecCommand(ctx, "docker", "info", "--format", "{{json .SecurityOptions}}")
+		e.userns = err == nil && strings.Contains(string(output), "name=userns")
+	})
+	return e.userns
+}
+
+// grantPath lets user write to path, a directory or file the runner
+// created for the task. Runners allowed to change ownership give path to
+// the user; others make it writable by everyone.
+func grantPath(path string, user TaskUser) error {
+	if user.Root {
+		return nil
+	}
+	err := os.Lchown(path, user.UID, user.GID)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("failed to give %s to the task user: %w", path, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to give %s to the task user: %w", path, err)
+	}
+	mode := os.FileMode(0o666)
+	if info.IsDir() {
+		mode = 0o777
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("failed to give %s to the task user: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+//go:build linux
+
+package docker
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker/executils"
+)
+
+func TestTaskUserInContainer(t *testing.T) {
+	if _, err := executils.ExecCommand(context.Background(), "docker", "version"); err != nil {
+		t.Skip("docker is not available")
+	}
+	if _, err := executils.ExecCommand(context.Background(), "docker", "image", "inspect", "alpine:latest"); err != nil {
+		t.Skip("alpine image not available locally")
+	}
+
+	cm, err := NewContainerManager("64m", "0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	user := TaskUser{UID: DefaultUIDBase, GID: DefaultUIDBase}
+	outputDir := t.TempDir()
+	if err := grantPath(outputDir, user); err != nil {
+		t.Fatal(err)
+	}
+	containerID, err := cm.CreateContainerWithOptions(ctx, "alpine:latest", "/", nil, ContainerOptions{
+		Command: []string{"sh", "-c", "id -u; grep CapEff /proc/self/status; touch /parity/output/result && echo written"},
+		Mounts:  []Mount{{Source: outputDir, Target: "/parity/output"}},
+		User:    user.String(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cm.RemoveContainer(context.Background(), containerID)
+	if err := cm.StartContainer(ctx, containerID); err != nil {
+		t.Fatal(err)
+	}
+	if exitCode, err := cm.WaitForContainer(ctx, containerID); err != nil || exitCode != 0 {
+		t.Fatalf("container exited %d, %v", exitCode, err)
+	}
+	logs, err := cm.GetContainerLogs(ctx, containerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(logs, "200000") {
+		t.Errorf("container did not run as the task user, logs: %q", logs)
+	}
+	if !strings.Contains(logs, "0000000000000000") {
+		t.Errorf("container kept capabilities, logs: %q", logs)
+	}
+	if !strings.Contains(logs, "written") {
+		t.Errorf("task user could not write its output, logs: %q", logs)
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "result")); err != nil {
+		t.Errorf("output not written to the host: %v", err)
+	}
+}
+
+func TestGrantPath(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "input.csv")
+	if err := os.WriteFile(file, []byte("a,b\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	user := TaskUser{UID: 200000, GID: 200000}
+
+	for _, path := range []string{dir, file} {
+		if err := grantPath(path, user); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Either the task user owns the path or everyone may write to it
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) == user.UID {
+			continue
+		}
+		wantMode := os.FileMode(0o666)
+		if info.IsDir() {
+			wantMode = 0o777
+		}
+		if info.Mode().Perm() != wantMode {
+			t.Errorf("%s has mode %v after grantPath(), want it owned by %d or %v", path, info.Mode().Perm(), user.UID, wantMode)
+		}
+	}
+
+	// Root tasks need nothing granted
+	if err := grantPath(file, TaskUser{Root: true}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,113 @@
+package docker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseUserPolicy(t *testing.T) {
+	policy, err := ParseUserPolicy("", "", []string{"cap_net_bind_service", " CHOWN "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := UserPolicy{Root: RootDeny, UIDBase: DefaultUIDBase, UIDCount: DefaultUIDCount, Capabilities: []string{"NET_BIND_SERVICE", "CHOWN"}}
+	if !reflect.DeepEqual(policy, want) {
+		t.Fatalf("ParseUserPolicy() = %+v, want %+v", policy, want)
+	}
+
+	policy, err = ParseUserPolicy("userns", "300000-300009", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policy.Root != RootUserns || policy.UIDBase != 300000 || policy.UIDCount != 10 {
+		t.Fatalf("ParseUserPolicy() = %+v, want userns over 300000-300009", policy)
+	}
+
+	invalid := []struct {
+		root, uids   string
+		capabilities []string
+	}{
+		{"sometimes", "", nil},
+		{"deny", "300000", nil},
+		{"deny", "300009-300000", nil},
+		{"deny", "0-10", nil},
+		{"deny", "", []string{"ALL"}},
+		{"deny", "", []string{"sys-admin"}},
+	}
+	for _, tt := range invalid {
+		if _, err := ParseUserPolicy(tt.root, tt.uids, tt.capabilities); err == nil {
+			t.Errorf("ParseUserPolicy(%q, %q, %v) succeeded, want an error", tt.root, tt.uids, tt.capabilities)
+		}
+	}
+}
+
+func TestUserAllocator(t *testing.T) {
+	policy := UserPolicy{UIDBase: 200000, UIDCount: 3}
+	var alloc userAllocator
+
+	seen := make(map[int]bool)
+	users := make([]TaskUser, 0, 3)
+	for i := 0; i < 3; i++ {
+		user, err := alloc.allocate(policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[user.UID] || user.UID < 200000 || user.UID > 200002 || user.GID != user.UID {
+			t.Fatalf("allocate() = %+v, want an unused user of 200000-200002", user)
+		}
+		seen[user.UID] = true
+		users = append(users, user)
+	}
+	if _, err := alloc.allocate(policy); err == nil {
+		t.Fatal("allocate() succeeded with every user in use")
+	}
+
+	alloc.release(users[1])
+	user, err := alloc.allocate(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != users[1] {
+		t.Fatalf("allocate() = %+v, want the released %+v", user, users[1])
+	}
+}
+
+func TestCheckUser(t *testing.T) {
+	e := &DockerExecutor{}
+	e.SetUserPolicy(UserPolicy{Root: RootDeny})
+	if err := e.CheckUser(context.Background(), false); err != nil {
+		t.Fatalf("CheckUser() error = %v for a task not asking for root", err)
+	}
+	if err := e.CheckUser(context.Background(), true); !errors.Is(err, ErrRootForbidden) {
+		t.Fatalf("CheckUser() error = %v, want ErrRootForbidden", err)
+	}
+	e.SetUserPolicy(UserPolicy{Root: RootAllow})
+	if err := e.CheckUser(context.Background(), true); err != nil {
+		t.Fatalf("CheckUser() error = %v with root allowed", err)
+	}
+}
+
+func TestSecurityArgs(t *testing.T) {
+	args := strings.Join(securityArgs(ContainerOptions{
+		User:         "200000:200000",
+		Capabilities: []string{"NET_BIND_SERVICE"},
+	}), " ")
+	for _, want := range []string{
+		"--security-opt no-new-privileges",
+		"--cap-drop ALL",
+		"--cap-add NET_BIND_SERVICE",
+		"--user 200000:200000",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("securityArgs() = %q, missing %q", args, want)
+		}
+	}
+
+	args = strings.Join(securityArgs(ContainerOptions{}), " ")
+	if strings.Contains(args, "--user") || strings.Contains(args, "--cap-add") {
+		t.Errorf("securityArgs() = %q, want the image's user and no capabilities", args)
+	}
+}
@@ -0,0 +1,75 @@
+package profiles
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/theblitlabs/gologger"
+)
+
+const (
+	appArmorEnabled  = "/sys/module/apparmor/parameters/enabled"
+	appArmorProfiles = "/sys/kernel/security/apparmor/profiles"
+)
+
+var (
+	appArmorMu     sync.Mutex
+	appArmorLoaded = make(map[string]bool)
+)
+
+// LoadAppArmor loads p's AppArmor profile into the kernel if it is not
+// already, reporting whether it is loaded. It is not where the host does
+// not enable AppArmor or the runner may not load profiles, in which case
+// tasks run under p's seccomp filter alone. A failed load is not retried.
+func LoadAppArmor(ctx context.Context, p *Profile) bool {
+	appArmorMu.Lock()
+	defer appArmorMu.Unlock()
+	if loaded, ok := appArmorLoaded[p.Name]; ok {
+		return loaded
+	}
+	loaded := loadAppArmor(ctx, p)
+	appArmorLoaded[p.Name] = loaded
+	return loaded
+}
+
+func loadAppArmor(ctx context.Context, p *Profile) bool {
+	log := gologger.WithComponent("security_profiles")
+
+	enabled, err := os.ReadFile(appArmorEnabled)
+	if err != nil || strings.TrimSpace(string(enabled)) != "Y" {
+		return false
+	}
+	if appArmorHas(p.AppArmorName()) {
+		return true
+	}
+
+	cmd := exec.CommandContext(ctx, "apparmor_parser", "--replace", "--write-cache")
+	cmd.Stdin = strings.NewReader(p.AppArmor)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		log.Warn().Err(err).Str("profile", p.Name).Str("stderr", strings.TrimSpace(stderr.String())).
+			Msg("Failed to load AppArmor profile - tasks under it are confined by seccomp alone")
+		return false
+	}
+	log.Info().Str("profile", p.AppArmorName()).Msg("Loaded AppArmor profile")
+	return true
+}
+
+// appArmorHas reports whether the kernel has the AppArmor profile name
+func appArmorHas(name string) bool {
+	data, err := os.ReadFile(appArmorProfiles)
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if loaded, _, ok := strings.Cut(line, " ("); ok && loaded == name {
+			return true
+		}
+	}
+	return false
+}
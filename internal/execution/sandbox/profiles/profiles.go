@@ -0,0 +1,187 @@
+// Package profiles is the library of named security profiles tasks run
+// under. Each profile pairs a seccomp filter, in the JSON format Docker
+// reads, with an AppArmor profile. Docker tasks are confined by handing
+// both to the Docker daemon; command tasks by the runner itself, which
+// re-executes itself to install the filter before starting the command.
+package profiles
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Names of the profiles in the library
+const (
+	// Restricted suits most tasks: it blocks administering the host,
+	// mounting, kernel keyrings and BPF, tracing other processes, new
+	// namespaces and NUMA memory policies
+	Restricted = "restricted"
+	// Build lets build and test tooling trace and profile its own
+	// processes and create namespaces, as debuggers, sanitizers and
+	// rootless container builds do
+	Build = "build"
+	// NetworkClient is Restricted for tasks that only make outgoing
+	// connections: they cannot listen for or accept connections
+	NetworkClient = "network-client"
+	// GPUCompute is Restricted with the performance counters and NUMA
+	// memory policies GPU runtimes and their profilers use
+	GPUCompute = "gpu-compute"
+)
+
+// ErrNotPermitted is returned for tasks asking for a profile the runner's
+// policy does not permit
+var ErrNotPermitted = errors.New("security profile not permitted by this runner's policy")
+
+//go:embed seccomp/*.json apparmor/*
+var library embed.FS
+
+// Seccomp is a seccomp filter in the JSON format Docker reads
+type Seccomp struct {
+	DefaultAction string        `json:"defaultAction"`
+	Architectures []string      `json:"architectures"`
+	Syscalls      []SyscallRule `json:"syscalls"`
+}
+
+// SyscallRule applies Action to the syscalls in Names. An ERRNO action
+// fails them with ErrnoRet, EPERM when zero.
+type SyscallRule struct {
+	Names    []string `json:"names"`
+	Action   string   `json:"action"`
+	ErrnoRet uint     `json:"errnoRet,omitempty"`
+}
+
+// Seccomp actions the library uses
+const (
+	ActAllow = "SCMP_ACT_ALLOW"
+	ActErrno = "SCMP_ACT_ERRNO"
+	ActKill  = "SCMP_ACT_KILL_PROCESS"
+)
+
+// Profile is a named security profile of the library
+type Profile struct {
+	Name    string
+	Seccomp *Seccomp
+	// SeccompJSON is the filter as shipped, for the Docker daemon
+	SeccompJSON []byte
+	// AppArmor is the AppArmor profile, named AppArmorName
+	AppArmor string
+}
+
+// AppArmorName is the name the profile is loaded into AppArmor as
+func (p *Profile) AppArmorName() string {
+	return "parity-" + p.Name
+}
+
+// Denies reports whether the profile's filter fails syscall
+func (p *Profile) Denies(syscall string) bool {
+	for _, rule := range p.Seccomp.Syscalls {
+		for _, name := range rule.Names {
+			if name == syscall {
+				return rule.Action != ActAllow
+			}
+		}
+	}
+	return p.Seccomp.DefaultAction != ActAllow
+}
+
+// Names returns the names of the profiles in the library, in order
+func Names() []string {
+	return []string{Restricted, Build, NetworkClient, GPUCompute}
+}
+
+// Get returns the profile called name
+func Get(name string) (*Profile, error) {
+	known := false
+	for _, n := range Names() {
+		known = known || n == name
+	}
+	if !known {
+		return nil, fmt.Errorf("unknown security profile %q (supported: %s)", name, strings.Join(Names(), ", "))
+	}
+
+	data, err := library.ReadFile("seccomp/" + name + ".json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read seccomp filter of profile %s: %w", name, err)
+	}
+	var seccomp Seccomp
+	if err := json.Unmarshal(data, &seccomp); err != nil {
+		return nil, fmt.Errorf("invalid seccomp filter of profile %s: %w", name, err)
+	}
+	apparmor, err := library.ReadFile("apparmor/" + name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AppArmor profile of profile %s: %w", name, err)
+	}
+	return &Profile{Name: name, Seccomp: &seccomp, SeccompJSON: data, AppArmor: string(apparmor)}, nil
+}
+
+// Policy decides which profiles tasks may ask for. Tasks asking for none
+// run under Default, which is always permitted. The zero Policy runs every
+// task under Restricted and permits no other profile.
+type Policy struct {
+	Allowed map[string]bool
+	Default string
+}
+
+func (p Policy) defaultName() string {
+	if p.Default == "" {
+		return Restricted
+	}
+	return p.Default
+}
+
+// ParsePolicy builds a policy permitting the profiles in allowed, and
+// defaultName, and running tasks that ask for none under defaultName,
+// Restricted when empty
+func ParsePolicy(allowed []string, defaultName string) (Policy, error) {
+	defaultName = strings.TrimSpace(defaultName)
+	if defaultName == "" {
+		defaultName = Restricted
+	}
+	if _, err := Get(defaultName); err != nil {
+		return Policy{}, fmt.Errorf("invalid default security profile: %w", err)
+	}
+	policy := Policy{Allowed: make(map[string]bool), Default: defaultName}
+	for _, name := range allowed {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, err := Get(name); err != nil {
+			return Policy{}, err
+		}
+		policy.Allowed[name] = true
+	}
+	return policy, nil
+}
+
+// Permitted returns the names of the profiles the policy permits, in order
+func (p Policy) Permitted() []string {
+	names := []string{p.defaultName()}
+	for name, allowed := range p.Allowed {
+		if allowed && name != p.defaultName() {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve returns the profile a task asking for name runs under, Default
+// when name is empty
+func (p Policy) Resolve(name string) (*Profile, error) {
+	if name == "" {
+		name = p.defaultName()
+	}
+	profile, err := Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if name != p.defaultName() && !p.Allowed[name] {
+		return nil, fmt.Errorf("%w: %s (permitted: %s)", ErrNotPermitted, name, strings.Join(p.Permitted(), ", "))
+	}
+	return profile, nil
+}
@@ -0,0 +1,98 @@
+package profiles
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLibrary(t *testing.T) {
+	for _, name := range Names() {
+		p, err := Get(name)
+		if err != nil {
+			t.Fatalf("Get(%q) error = %v", name, err)
+		}
+		if !strings.Contains(p.AppArmor, "profile "+p.AppArmorName()+" ") {
+			t.Errorf("AppArmor profile of %s is not named %s", name, p.AppArmorName())
+		}
+		if Supported() {
+			if _, err := compile(p.Seccomp); err != nil {
+				t.Errorf("seccomp filter of %s does not compile: %v", name, err)
+			}
+		}
+	}
+
+	// What the runner blocked before it had profiles stays blocked by
+	// default
+	restricted, _ := Get(Restricted)
+	for _, syscall := range []string{"ptrace", "process_vm_readv", "process_vm_writev", "reboot", "mount", "umount", "umount2"} {
+		if !restricted.Denies(syscall) {
+			t.Errorf("restricted allows %s", syscall)
+		}
+	}
+	for _, syscall := range []string{"execve", "clone", "listen"} {
+		if restricted.Denies(syscall) {
+			t.Errorf("restricted denies %s", syscall)
+		}
+	}
+
+	for _, c := range []struct {
+		profile, syscall string
+		denied           bool
+	}{
+		{Build, "ptrace", false},
+		{Build, "unshare", false},
+		{Build, "mount", true},
+		{NetworkClient, "listen", true},
+		{NetworkClient, "connect", false},
+		{GPUCompute, "perf_event_open", false},
+		{GPUCompute, "mbind", false},
+		{GPUCompute, "ptrace", true},
+	} {
+		p, _ := Get(c.profile)
+		if p.Denies(c.syscall) != c.denied {
+			t.Errorf("%s denies %s = %v, want %v", c.profile, c.syscall, !c.denied, c.denied)
+		}
+	}
+
+	if _, err := Get("unconfined"); err == nil {
+		t.Error("Get() found a profile outside the library")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	policy, err := ParsePolicy([]string{" build", ""}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, err := policy.Resolve(""); err != nil || p.Name != Restricted {
+		t.Errorf("Resolve(\"\") = %v, %v, want the default", p, err)
+	}
+	if p, err := policy.Resolve(Build); err != nil || p.Name != Build {
+		t.Errorf("Resolve(build) = %v, %v", p, err)
+	}
+	if _, err := policy.Resolve(GPUCompute); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("Resolve(gpu-compute) error = %v, want ErrNotPermitted", err)
+	}
+	if _, err := policy.Resolve("bogus"); err == nil || errors.Is(err, ErrNotPermitted) {
+		t.Errorf("Resolve(bogus) error = %v, want an unknown profile", err)
+	}
+
+	policy, err = ParsePolicy(nil, NetworkClient)
+	if err != nil || policy.Default != NetworkClient || len(policy.Permitted()) != 1 {
+		t.Errorf("ParsePolicy() = %+v, %v, want only network-client", policy, err)
+	}
+	var zero Policy
+	if p, err := zero.Resolve(""); err != nil || p.Name != Restricted {
+		t.Errorf("zero policy resolved %v, %v, want restricted", p, err)
+	}
+	if _, err := zero.Resolve(Build); !errors.Is(err, ErrNotPermitted) {
+		t.Errorf("zero policy permitted build: %v", err)
+	}
+	if _, err := ParsePolicy([]string{"bogus"}, ""); err == nil {
+		t.Error("ParsePolicy() permitted an unknown profile")
+	}
+	if _, err := ParsePolicy(nil, "bogus"); err == nil {
+		t.Error("ParsePolicy() defaulted to an unknown profile")
+	}
+}
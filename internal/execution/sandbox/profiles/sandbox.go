@@ -0,0 +1,83 @@
+package profiles
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// A confined command is started as the runner's own executable with these
+// set in its environment. Enter, run first thing in main, installs the
+// profile and executes the command in its place.
+const (
+	sandboxProfileEnv  = "PARITY_SANDBOX_PROFILE"
+	sandboxPathEnv     = "PARITY_SANDBOX_PATH"
+	sandboxAppArmorEnv = "PARITY_SANDBOX_APPARMOR"
+)
+
+// ErrUnsupported is returned for commands confined on platforms that
+// cannot confine them
+var ErrUnsupported = errors.New("command tasks cannot be confined on this platform")
+
+// Confine makes cmd, not yet started, run under p: under its seccomp filter
+// and, when appArmor is set, its AppArmor profile, which must be loaded. A
+// cmd whose command was not found is left to fail when started.
+func Confine(cmd *exec.Cmd, p *Profile, appArmor bool) error {
+	if !Supported() {
+		return ErrUnsupported
+	}
+	if cmd.Err != nil {
+		return nil
+	}
+	// The filter is compiled here too, so that a profile that does not
+	// compile fails the task instead of its command
+	if _, err := compile(p.Seccomp); err != nil {
+		return fmt.Errorf("invalid seccomp filter of profile %s: %w", p.Name, err)
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the runner executable: %w", err)
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	env = append(env, sandboxProfileEnv+"="+p.Name, sandboxPathEnv+"="+cmd.Path)
+	if appArmor {
+		env = append(env, sandboxAppArmorEnv+"=1")
+	}
+	cmd.Env = env
+	cmd.Args = append([]string{self}, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+// Enter returns at once unless the process was started by Confine, in
+// which case it confines itself and executes the command, never returning.
+// It must run before anything else in main.
+func Enter() {
+	name, ok := os.LookupEnv(sandboxProfileEnv)
+	if !ok {
+		return
+	}
+	path := os.Getenv(sandboxPathEnv)
+	appArmor := os.Getenv(sandboxAppArmorEnv) != ""
+
+	var env []string
+	for _, kv := range os.Environ() {
+		key, _, _ := strings.Cut(kv, "=")
+		if key != sandboxProfileEnv && key != sandboxPathEnv && key != sandboxAppArmorEnv {
+			env = append(env, kv)
+		}
+	}
+
+	p, err := Get(name)
+	if err == nil {
+		err = execConfined(p, appArmor, path, os.Args[1:], env)
+	}
+	fmt.Fprintf(os.Stderr, "parity sandbox: %v\n", err)
+	os.Exit(126)
+}
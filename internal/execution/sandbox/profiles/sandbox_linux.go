@@ -0,0 +1,138 @@
+//go:build linux && (amd64 || arm64)
+
+package profiles
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Supported reports whether command tasks can be confined on this platform
+func Supported() bool {
+	return true
+}
+
+// Offsets of the fields of struct seccomp_data a filter reads
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+)
+
+// maxFilterLen is the longest filter the kernel accepts, BPF_MAXINSNS
+const maxFilterLen = 4096
+
+func stmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func jump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// compile turns s into a BPF program. Syscalls of the native architecture
+// are matched against the rules in order; those of any other ABI the
+// process could switch to kill it, as the rules do not cover them.
+func compile(s *Seccomp) ([]unix.SockFilter, error) {
+	def, err := seccompAction(s.DefaultAction, 0)
+	if err != nil {
+		return nil, err
+	}
+	prog := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArch),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, auditArch, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNr),
+	}
+	prog = append(prog, abiFilter...)
+	for _, rule := range s.Syscalls {
+		ret, err := seccompAction(rule.Action, rule.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range rule.Names {
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				if otherArchSyscalls[name] {
+					continue
+				}
+				return nil, fmt.Errorf("unknown syscall %q", name)
+			}
+			prog = append(prog,
+				jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, uint32(nr), 0, 1),
+				stmt(unix.BPF_RET|unix.BPF_K, ret),
+			)
+		}
+	}
+	prog = append(prog, stmt(unix.BPF_RET|unix.BPF_K, def))
+	if len(prog) > maxFilterLen {
+		return nil, fmt.Errorf("filter of %d instructions is longer than the kernel accepts", len(prog))
+	}
+	return prog, nil
+}
+
+// seccompAction is the filter return value of a seccomp action
+func seccompAction(action string, errnoRet uint) (uint32, error) {
+	switch action {
+	case ActAllow:
+		return unix.SECCOMP_RET_ALLOW, nil
+	case ActErrno:
+		if errnoRet == 0 {
+			errnoRet = uint(unix.EPERM)
+		}
+		return unix.SECCOMP_RET_ERRNO | uint32(errnoRet&unix.SECCOMP_RET_DATA), nil
+	case ActKill:
+		return unix.SECCOMP_RET_KILL_PROCESS, nil
+	default:
+		return 0, fmt.Errorf("unsupported seccomp action %q", action)
+	}
+}
+
+// execConfined confines the process under p and executes path in its place
+func execConfined(p *Profile, appArmor bool, path string, args, env []string) error {
+	filter, err := compile(p.Seccomp)
+	if err != nil {
+		return fmt.Errorf("invalid seccomp filter of profile %s: %w", p.Name, err)
+	}
+
+	// The AppArmor profile is taken on at exec by the thread that asked
+	// for it, so the rest happens on this thread
+	runtime.LockOSThread()
+	if appArmor {
+		if err := changeOnExec(p.AppArmorName()); err != nil {
+			return err
+		}
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("failed to set no_new_privs: %w", err)
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	// TSYNC puts every thread of the runtime under the filter, returning
+	// the ID of a thread it could not
+	tid, _, errno := unix.Syscall(unix.SYS_SECCOMP, unix.SECCOMP_SET_MODE_FILTER, unix.SECCOMP_FILTER_FLAG_TSYNC, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %w", errno)
+	}
+	if tid != 0 {
+		return fmt.Errorf("failed to install seccomp filter: thread %d could not be synchronized", tid)
+	}
+	return syscall.Exec(path, args, env)
+}
+
+// changeOnExec asks AppArmor to confine the thread under profile from its
+// next exec
+func changeOnExec(profile string) error {
+	err := os.WriteFile("/proc/thread-self/attr/apparmor/exec", []byte("exec "+profile), 0)
+	if errors.Is(err, os.ErrNotExist) {
+		err = os.WriteFile("/proc/thread-self/attr/exec", []byte("exec "+profile), 0)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to change to AppArmor profile %s: %w", profile, err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+//go:build linux && (amd64 || arm64)
+
+package profiles
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// The test binary stands in for the runner: confined commands are started
+// through its TestMain
+func TestMain(m *testing.M) {
+	Enter()
+	os.Exit(m.Run())
+}
+
+const helperEnv = "PROFILES_TEST_HELPER"
+
+// TestHelperProcess is the confined command: it reads its own memory with
+// process_vm_readv, which restricted blocks and build allows for debuggers
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(helperEnv) == "" {
+		t.Skip("run by TestRestrictedBlocksWhatBuildAllows")
+	}
+	src := []byte("parity")
+	dst := make([]byte, len(src))
+	local := unix.Iovec{Base: &dst[0]}
+	local.SetLen(len(dst))
+	remote := unix.RemoteIovec{Base: uintptr(unsafe.Pointer(&src[0])), Len: len(src)}
+	_, err := unix.ProcessVMReadv(os.Getpid(), []unix.Iovec{local}, []unix.RemoteIovec{remote}, 0)
+	fmt.Printf("process_vm_readv: %v\n", err)
+	os.Exit(0)
+}
+
+func TestRestrictedBlocksWhatBuildAllows(t *testing.T) {
+	run := func(name string) string {
+		t.Helper()
+		p, err := Get(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+		cmd.Env = append(os.Environ(), helperEnv+"=1")
+		if err := Confine(cmd, p, false); err != nil {
+			t.Fatal(err)
+		}
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("command under %s failed: %v\n%s", name, err, out)
+		}
+		return string(out)
+	}
+
+	if out := run(Restricted); !strings.Contains(out, "process_vm_readv: "+syscall.EPERM.Error()) {
+		t.Errorf("process_vm_readv under restricted: %q, want EPERM", out)
+	}
+	if out := run(Build); !strings.Contains(out, "process_vm_readv: <nil>") {
+		t.Errorf("process_vm_readv under build: %q, want it to succeed", out)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux || !(amd64 || arm64)
+
+package profiles
+
+// Supported reports whether command tasks can be confined on this platform
+func Supported() bool {
+	return false
+}
+
+func compile(s *Seccomp) ([]struct{}, error) {
+	return nil, ErrUnsupported
+}
+
+func execConfined(p *Profile, appArmor bool, path string, args, env []string) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,25 @@
+package profiles
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_X86_64
+
+// x32Bit is set in the numbers of syscalls made through the x32 ABI
+const x32Bit = 0x40000000
+
+// abiFilter kills processes making syscalls through the x32 ABI, whose
+// numbers the rules do not match
+var abiFilter = []unix.SockFilter{
+	jump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, x32Bit, 0, 1),
+	stmt(unix.BPF_RET|unix.BPF_K, unix.SECCOMP_RET_KILL_PROCESS),
+}
+
+var archSyscallNumbers = map[string]uintptr{
+	"iopl":   unix.SYS_IOPL,
+	"ioperm": unix.SYS_IOPERM,
+}
+
+// otherArchSyscalls are syscalls of other architectures filters may name
+var otherArchSyscalls = map[string]bool{
+	"umount": true,
+}
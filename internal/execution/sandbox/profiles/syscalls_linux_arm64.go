@@ -0,0 +1,16 @@
+package profiles
+
+import "golang.org/x/sys/unix"
+
+const auditArch = unix.AUDIT_ARCH_AARCH64
+
+var abiFilter []unix.SockFilter
+
+var archSyscallNumbers = map[string]uintptr{}
+
+// otherArchSyscalls are syscalls of other architectures filters may name
+var otherArchSyscalls = map[string]bool{
+	"umount": true,
+	"iopl":   true,
+	"ioperm": true,
+}
@@ -0,0 +1,131 @@
+// Package quota caps what command tasks may write outside their working
+// directory. A capped command runs in a mount namespace of its own, under
+// an overlayfs root whose lower layer is the host's root filesystem, read
+// only, and whose upper layer is a tmpfs sized to the task's disk space.
+// Writes to /tmp, the task's home or anywhere else land in the upper layer,
+// so a task that fills it gets ENOSPC instead of filling the host. The
+// working directory is bind-mounted in from the host. When the command
+// exits, the output paths it declared are copied from the upper layer into
+// the working directory and the rest is discarded with the namespace.
+//
+// As with security profiles, the runner re-executes itself to set this up:
+// Enter, run first thing in main, builds the root and runs the command in
+// it.
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// quotaEnv carries the spec of a capped command to the runner executable
+// Confine starts in its place
+const quotaEnv = "PARITY_QUOTA_SPEC"
+
+// ErrUnsupported is returned for commands capped on platforms that cannot
+// cap them
+var ErrUnsupported = errors.New("command tasks cannot be given disk quotas on this platform")
+
+// spec is how Enter builds the root of a capped command
+type spec struct {
+	Path string `json:"path"`
+	// Dir is the command's working directory, bind-mounted in from the
+	// host
+	Dir string `json:"dir"`
+	// Size is the size in bytes of the upper layer
+	Size uint64 `json:"size"`
+	// Outputs are the absolute paths copied out of the upper layer into
+	// Dir once the command exits
+	Outputs []string `json:"outputs,omitempty"`
+	// UserNS is set when the command runs in a user namespace of its own,
+	// the runner not being root
+	UserNS bool `json:"user_ns,omitempty"`
+}
+
+// Confine makes cmd, not yet started, write outside its working directory
+// into at most size bytes, copying outputs back into the working directory
+// when it exits. cmd must have a working directory. A cmd whose command
+// was not found is left to fail when started.
+func Confine(cmd *exec.Cmd, size uint64, outputs []string) error {
+	if !Supported() {
+		return ErrUnsupported
+	}
+	if cmd.Err != nil {
+		return nil
+	}
+	if cmd.Dir == "" || !filepath.IsAbs(cmd.Dir) {
+		return errors.New("capped commands need an absolute working directory")
+	}
+	if size == 0 {
+		return errors.New("disk quota must be above zero")
+	}
+	for _, p := range outputs {
+		if err := ValidateOutputPath(p); err != nil {
+			return err
+		}
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the runner executable: %w", err)
+	}
+
+	s := spec{Path: cmd.Path, Dir: cmd.Dir, Size: size, Outputs: outputs, UserNS: os.Geteuid() != 0}
+	raw, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, quotaEnv+"="+string(raw))
+	cmd.Args = append([]string{self}, cmd.Args...)
+	cmd.Path = self
+	cmd.SysProcAttr = namespaceAttr(cmd.SysProcAttr, s.UserNS)
+	return nil
+}
+
+// ValidateOutputPath checks a path a capped command declares as output: an
+// absolute, clean path other than the root
+func ValidateOutputPath(p string) error {
+	if !filepath.IsAbs(p) || filepath.Clean(p) != p || p == "/" {
+		return fmt.Errorf("output path %q must be an absolute, clean path below /", p)
+	}
+	return nil
+}
+
+// Enter returns at once unless the process was started by Confine, in
+// which case it runs the command under its quota and exits with the
+// command's exit code, never returning. It must run before anything else
+// in main, and before profiles.Enter, so that a command both capped and
+// confined is confined inside its root.
+func Enter() {
+	raw, ok := os.LookupEnv(quotaEnv)
+	if !ok {
+		return
+	}
+
+	var env []string
+	for _, kv := range os.Environ() {
+		if key, _, _ := strings.Cut(kv, "="); key != quotaEnv {
+			env = append(env, kv)
+		}
+	}
+
+	var s spec
+	err := json.Unmarshal([]byte(raw), &s)
+	if err == nil {
+		var code int
+		code, err = run(&s, os.Args[1:], env)
+		if err == nil {
+			os.Exit(code)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "parity quota: %v\n", err)
+	os.Exit(126)
+}
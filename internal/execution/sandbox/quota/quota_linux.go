@@ -0,0 +1,186 @@
+//go:build linux
+
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Supported reports whether command tasks can be given disk quotas on this
+// platform
+func Supported() bool {
+	return true
+}
+
+// namespaceAttr starts a capped command in a mount namespace of its own,
+// and in a user namespace mapping the runner's user to root when the
+// runner is not root, so that it may mount its root
+func namespaceAttr(attr *syscall.SysProcAttr, userNS bool) *syscall.SysProcAttr {
+	if attr == nil {
+		attr = &syscall.SysProcAttr{}
+	}
+	attr.Cloneflags |= syscall.CLONE_NEWNS
+	if userNS {
+		attr.Cloneflags |= syscall.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	}
+	return attr
+}
+
+// hostMounts are bind-mounted into the root from the host, as the overlay
+// only covers the root filesystem
+var hostMounts = []string{"/dev", "/proc", "/sys"}
+
+// run builds the root of s and runs path in it, returning its exit code
+func run(s *spec, args, env []string) (int, error) {
+	// Nothing mounted from here on may propagate back to the host
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return 0, fmt.Errorf("failed to make mounts private: %w", err)
+	}
+
+	state, err := os.MkdirTemp("", "parity-quota-")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create the upper layer: %w", err)
+	}
+	defer os.Remove(state)
+	if err := unix.Mount("tmpfs", state, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, fmt.Sprintf("size=%d,mode=0755", s.Size)); err != nil {
+		return 0, fmt.Errorf("failed to mount the upper layer: %w", err)
+	}
+	defer unix.Unmount(state, unix.MNT_DETACH)
+
+	upper := filepath.Join(state, "upper")
+	work := filepath.Join(state, "work")
+	root := filepath.Join(state, "root")
+	for _, dir := range []string{upper, work, root} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			return 0, fmt.Errorf("failed to create the upper layer: %w", err)
+		}
+	}
+	opts := fmt.Sprintf("lowerdir=/,upperdir=%s,workdir=%s", upper, work)
+	if s.UserNS {
+		opts += ",userxattr"
+	}
+	if err := unix.Mount("overlay", root, "overlay", 0, opts); err != nil {
+		return 0, fmt.Errorf("failed to mount the overlay root: %w", err)
+	}
+
+	for _, dir := range hostMounts {
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		if err := unix.Mount(dir, filepath.Join(root, dir), "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return 0, fmt.Errorf("failed to mount %s: %w", dir, err)
+		}
+	}
+	target := filepath.Join(root, s.Dir)
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return 0, fmt.Errorf("failed to mount the working directory: %w", err)
+	}
+	if err := unix.Mount(s.Dir, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return 0, fmt.Errorf("failed to mount the working directory: %w", err)
+	}
+
+	cmd := &exec.Cmd{
+		Path:   s.Path,
+		Args:   args,
+		Env:    env,
+		Dir:    s.Dir,
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		// The command dies with this process, which the runner kills when
+		// the task is cancelled or times out
+		SysProcAttr: &syscall.SysProcAttr{Chroot: root, Pdeathsig: syscall.SIGKILL},
+	}
+	code := 0
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return 0, err
+		}
+		code = exitCode(exitErr.ProcessState)
+	}
+
+	for _, p := range s.Outputs {
+		if within(p, s.Dir) {
+			continue
+		}
+		if err := copyTree(filepath.Join(root, p), filepath.Join(s.Dir, p)); err != nil {
+			return 0, fmt.Errorf("failed to capture output %s: %w", p, err)
+		}
+	}
+	return code, nil
+}
+
+// exitCode is the code a shell would report for a command that exited as
+// state describes
+func exitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
+
+// within reports whether p is dir or below it
+func within(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, dir+string(filepath.Separator))
+}
+
+// copyTree copies the regular files and directories at and below src to
+// dst. Symbolic links are left behind, as they would resolve against the
+// host outside the root; a missing src copies nothing.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == src {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0o755)
+		case d.Type().IsRegular():
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			return copyFile(path, target)
+		default:
+			return nil
+		}
+	})
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
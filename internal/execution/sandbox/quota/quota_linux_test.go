@@ -0,0 +1,95 @@
+//go:build linux
+
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// The test binary stands in for the runner: capped commands are started
+// through its TestMain
+func TestMain(m *testing.M) {
+	Enter()
+	os.Exit(m.Run())
+}
+
+const (
+	helperEnv  = "QUOTA_TEST_HELPER"
+	helperFile = "QUOTA_TEST_FILE"
+)
+
+// TestHelperProcess is the capped command: it fills the file it is given
+// until the write fails, and writes an output file
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(helperEnv) == "" {
+		t.Skip("run by TestQuotaCapsWritesOutsideWorkingDir")
+	}
+	if err := os.MkdirAll("/var/tmp/parity-quota-output", 0o755); err == nil {
+		os.WriteFile("/var/tmp/parity-quota-output/result.txt", []byte("captured"), 0o644)
+	}
+	f, err := os.Create(os.Getenv(helperFile))
+	if err != nil {
+		fmt.Printf("create: %v\n", err)
+		os.Exit(0)
+	}
+	chunk := make([]byte, 64<<10)
+	for i := 0; i < 1024; i++ {
+		if _, err = f.Write(chunk); err != nil {
+			break
+		}
+	}
+	f.Close()
+	fmt.Printf("enospc: %v\n", errors.Is(err, syscall.ENOSPC))
+	os.Exit(0)
+}
+
+func TestQuotaCapsWritesOutsideWorkingDir(t *testing.T) {
+	dir := t.TempDir()
+	fill := filepath.Join(os.TempDir(), fmt.Sprintf("parity-quota-fill-%d", os.Getpid()))
+	t.Cleanup(func() { os.Remove(fill) })
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	cmd.Env = append(os.Environ(), helperEnv+"=1", helperFile+"="+fill)
+	cmd.Dir = dir
+	if err := Confine(cmd, 4<<20, []string{"/var/tmp/parity-quota-output"}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := cmd.CombinedOutput()
+	if strings.Contains(string(out), "parity quota:") {
+		t.Skipf("mount namespaces with an overlay root are unavailable here: %s", out)
+	}
+	if err != nil {
+		t.Fatalf("capped command failed: %v\n%s", err, out)
+	}
+
+	if !strings.Contains(string(out), "enospc: true") {
+		t.Errorf("filling the quota: %q, want ENOSPC", out)
+	}
+	if _, err := os.Stat(fill); !os.IsNotExist(err) {
+		t.Errorf("file filled inside the quota exists on the host: %v", err)
+	}
+	if _, err := os.Stat("/var/tmp/parity-quota-output"); !os.IsNotExist(err) {
+		t.Errorf("output path exists on the host: %v", err)
+	}
+	captured, err := os.ReadFile(filepath.Join(dir, "var/tmp/parity-quota-output/result.txt"))
+	if err != nil || string(captured) != "captured" {
+		t.Errorf("captured output = %q, %v; want it copied into the working directory", captured, err)
+	}
+}
+
+func TestConfineRejectsBadOutputPaths(t *testing.T) {
+	for _, p := range []string{"relative", "/", "/tmp/../etc", "/tmp/"} {
+		cmd := exec.Command("true")
+		cmd.Dir = t.TempDir()
+		if err := Confine(cmd, 1<<20, []string{p}); err == nil {
+			t.Errorf("Confine accepted output path %q", p)
+		}
+	}
+}
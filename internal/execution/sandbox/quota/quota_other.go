@@ -0,0 +1,19 @@
+//go:build !linux
+
+package quota
+
+import "syscall"
+
+// Supported reports whether command tasks can be given disk quotas on this
+// platform
+func Supported() bool {
+	return false
+}
+
+func namespaceAttr(attr *syscall.SysProcAttr, userNS bool) *syscall.SysProcAttr {
+	return attr
+}
+
+func run(s *spec, args, env []string) (int, error) {
+	return 0, ErrUnsupported
+}
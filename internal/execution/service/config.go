@@ -0,0 +1,169 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+const (
+	defaultHealthInterval = time.Minute
+	defaultProbeInterval  = 5 * time.Second
+	defaultStartupTimeout = 5 * time.Minute
+	defaultMaxRestarts    = 3
+	// maxRestarts bounds the restarts a task may ask for, so that a
+	// service that keeps crashing is given up on
+	maxRestarts = 20
+	// setupMargin is the time a service task is given past its duration to
+	// pull its image and start
+	setupMargin = 15 * time.Minute
+)
+
+// Config is the config of a service task
+type Config struct {
+	ImageName      string `json:"image_name"`
+	DockerImageURL string `json:"docker_image_url,omitempty"`
+	// DockerImageDigest is the sha256 digest of the tarball at
+	// DockerImageURL
+	DockerImageDigest string `json:"docker_image_digest,omitempty"`
+	// Entrypoint replaces the image's when set; Command replaces its Cmd
+	Entrypoint []string          `json:"entrypoint,omitempty"`
+	Command    []string          `json:"command,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	// Ports are published on the host, as the runner's network policy
+	// allows
+	Ports     []models.ServicePort `json:"ports,omitempty"`
+	Readiness Probe                `json:"readiness"`
+	// Duration is how long the service is kept running, such as "6h"
+	Duration string `json:"duration"`
+	// HealthInterval is how often the service's health and uptime are
+	// reported; a minute when empty
+	HealthInterval string `json:"health_interval,omitempty"`
+	// MaxRestarts is how many times the service is restarted after it
+	// exits before its duration is up; 3 when unset
+	MaxRestarts     *int                  `json:"max_restarts,omitempty"`
+	Resources       models.ResourceConfig `json:"resources,omitempty"`
+	SecurityProfile string                `json:"security_profile,omitempty"`
+
+	duration       time.Duration
+	healthInterval time.Duration
+	maxRestarts    int
+}
+
+// Probe decides whether the service is ready: an HTTP GET of HTTPPath on
+// Port answered with a 2xx or 3xx status, or a TCP connection to Port when
+// HTTPPath is empty
+type Probe struct {
+	HTTPPath string `json:"http_path,omitempty"`
+	Port     int    `json:"port"`
+	// Interval is how often the service is probed; 5s when empty
+	Interval string `json:"interval,omitempty"`
+	// StartupTimeout is how long each run of the service may take to
+	// become ready; 5m when empty
+	StartupTimeout string `json:"startup_timeout,omitempty"`
+
+	interval       time.Duration
+	startupTimeout time.Duration
+}
+
+// ParseConfig parses and validates the config of a service task
+func ParseConfig(raw json.RawMessage) (*Config, error) {
+	var config Config
+	if err := safejson.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse service task config: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// Validate checks the config and resolves its durations
+func (c *Config) Validate() error {
+	if c.ImageName == "" {
+		return errors.New("image name is required for service tasks")
+	}
+	var err error
+	if c.duration, err = positiveDuration("duration", c.Duration, 0); err != nil {
+		return err
+	}
+	if c.healthInterval, err = positiveDuration("health_interval", c.HealthInterval, defaultHealthInterval); err != nil {
+		return err
+	}
+	c.maxRestarts = defaultMaxRestarts
+	if c.MaxRestarts != nil {
+		if *c.MaxRestarts < 0 || *c.MaxRestarts > maxRestarts {
+			return fmt.Errorf("max_restarts must be between 0 and %d", maxRestarts)
+		}
+		c.maxRestarts = *c.MaxRestarts
+	}
+	if err := c.Readiness.validate(); err != nil {
+		return fmt.Errorf("invalid readiness probe: %w", err)
+	}
+	seen := make(map[string]bool, len(c.Ports))
+	for _, port := range c.Ports {
+		if err := port.Validate(); err != nil {
+			return fmt.Errorf("invalid port: %w", err)
+		}
+		protocol := port.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		key := fmt.Sprintf("%d/%s", port.HostPort, protocol)
+		if seen[key] {
+			return fmt.Errorf("host port %s is published twice", key)
+		}
+		seen[key] = true
+	}
+	if _, err := gpu.ParseBytes(c.Resources.Memory); err != nil {
+		return fmt.Errorf("invalid resources.memory: %w", err)
+	}
+	return nil
+}
+
+func (p *Probe) validate() error {
+	if p.Port < 1 || p.Port > 65535 {
+		return fmt.Errorf("port %d out of range", p.Port)
+	}
+	if p.HTTPPath != "" && p.HTTPPath[0] != '/' {
+		return errors.New("http_path must start with /")
+	}
+	var err error
+	if p.interval, err = positiveDuration("interval", p.Interval, defaultProbeInterval); err != nil {
+		return err
+	}
+	if p.startupTimeout, err = positiveDuration("startup_timeout", p.StartupTimeout, defaultStartupTimeout); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Timeout is how long a service task of config may run in all: its
+// duration and time to set it up
+func (c *Config) Timeout() time.Duration {
+	return c.duration + setupMargin
+}
+
+// positiveDuration parses value, the field name, returning def when it is
+// empty and def is not zero
+func positiveDuration(name, value string, def time.Duration) (time.Duration, error) {
+	if value == "" {
+		if def == 0 {
+			return 0, fmt.Errorf("%s is required for service tasks", name)
+		}
+		return def, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("%s must be positive", name)
+	}
+	return d, nil
+}
@@ -0,0 +1,311 @@
+// Package service runs long-lived service tasks, such as an inference
+// endpoint, for a target duration rather than to completion. The service is
+// probed for readiness as it runs, restarted a bounded number of times when
+// it exits early, and the time it was ready is accounted so that its reward
+// can follow the uptime it delivered.
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var (
+	// ErrCrashed is returned for a service that kept exiting until its
+	// restarts were used up
+	ErrCrashed = errors.New("service kept exiting")
+	// ErrNotReady is returned for a service that did not become ready
+	// within its startup timeout
+	ErrNotReady = errors.New("service did not become ready")
+)
+
+// restartBackoff is how long the first restart of a service waits; each
+// later one waits twice as long, up to maxRestartBackoff
+var restartBackoff = time.Second
+
+const (
+	maxRestartBackoff = 30 * time.Second
+	// maxProbeTimeout bounds each readiness probe
+	maxProbeTimeout = 10 * time.Second
+	// stopTimeout bounds stopping the service
+	stopTimeout = 2 * time.Minute
+)
+
+// Process is the service a task runs, such as its container
+type Process interface {
+	// Start starts the service anew
+	Start(ctx context.Context) error
+	// Wait returns the service's exit code once it exits
+	Wait(ctx context.Context) (int, error)
+	// Stop stops the service if it is running and cleans up after it
+	Stop(ctx context.Context) error
+	// Address returns the host:port at which port of the service is probed
+	Address(ctx context.Context, port int) (string, error)
+}
+
+// Supervisor keeps a service running for its duration
+type Supervisor struct {
+	config  *Config
+	process Process
+	report  func(*models.TaskProgress)
+	clock   clock.Clock
+	client  *http.Client
+
+	start      time.Time
+	ready      bool
+	readySince time.Time
+	uptime     time.Duration
+	stats      models.ServiceReport
+}
+
+// New returns a supervisor running process as config describes, passing
+// its readiness transitions and health reports to report
+func New(config *Config, process Process, report func(*models.TaskProgress)) *Supervisor {
+	return &Supervisor{
+		config:  config,
+		process: process,
+		report:  report,
+		clock:   clock.Real(),
+		client: &http.Client{
+			// A redirect already says the service answers
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// SetClock replaces the clock the service is timed by
+func (s *Supervisor) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+type exit struct {
+	code int
+	err  error
+}
+
+// Run runs the service until its duration is up or ctx ends, returning
+// the uptime it delivered. A service that keeps exiting or never becomes
+// ready is stopped with an error wrapping ErrCrashed or ErrNotReady.
+func (s *Supervisor) Run(ctx context.Context) (*models.ServiceReport, error) {
+	log := gologger.WithComponent("service")
+	s.start = s.clock.Now()
+	s.stats = models.ServiceReport{TargetSeconds: s.config.duration.Seconds()}
+
+	deadline := s.clock.NewTimer(s.config.duration)
+	defer deadline.Stop()
+	health := s.clock.NewTicker(s.config.healthInterval)
+	defer health.Stop()
+
+	backoff := restartBackoff
+	for {
+		stop, err := s.runOnce(ctx, deadline.C(), health.C())
+		if stop != "" {
+			return s.finish(stop), err
+		}
+
+		if s.stats.Restarts >= s.config.maxRestarts {
+			return s.finish(models.ServiceStopCrashed), fmt.Errorf("%w: exited %d times", ErrCrashed, len(s.stats.ExitCodes))
+		}
+		log.Warn().
+			Int("exit_code", s.stats.ExitCodes[len(s.stats.ExitCodes)-1]).
+			Int("restarts", s.stats.Restarts).
+			Dur("backoff", backoff).
+			Msg("Service exited before its duration, restarting")
+		wait := s.clock.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			wait.Stop()
+			return s.finish(models.ServiceStopCancelled), ctx.Err()
+		case <-deadline.C():
+			wait.Stop()
+			return s.finish(models.ServiceStopDuration), nil
+		case <-wait.C():
+		}
+		backoff *= 2
+		if backoff > maxRestartBackoff {
+			backoff = maxRestartBackoff
+		}
+		s.stats.Restarts++
+	}
+}
+
+// runOnce starts the service and runs it until it exits, when it returns
+// an empty stop, or until it is stopped for good
+func (s *Supervisor) runOnce(ctx context.Context, deadline, health <-chan time.Time) (models.ServiceStop, error) {
+	if err := s.process.Start(ctx); err != nil {
+		s.stop()
+		return models.ServiceStopCrashed, fmt.Errorf("failed to start service: %w", err)
+	}
+	waitCtx, cancelWait := context.WithCancel(ctx)
+	defer cancelWait()
+	exited := make(chan exit, 1)
+	go func() {
+		code, err := s.process.Wait(waitCtx)
+		exited <- exit{code, err}
+	}()
+
+	probe := s.clock.NewTicker(s.config.Readiness.interval)
+	defer probe.Stop()
+	startup := s.clock.NewTimer(s.config.Readiness.startupTimeout)
+	defer startup.Stop()
+	becameReady := false
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.stop()
+			return models.ServiceStopCancelled, ctx.Err()
+		case <-deadline:
+			s.stop()
+			return models.ServiceStopDuration, nil
+		case <-startup.C():
+			if !becameReady {
+				s.stop()
+				return models.ServiceStopNotReady, fmt.Errorf("%w within %s", ErrNotReady, s.config.Readiness.startupTimeout)
+			}
+		case <-probe.C():
+			ready := s.probe(ctx)
+			becameReady = becameReady || ready
+			s.setReady(ready)
+		case <-health:
+			s.reportHealth()
+		case x := <-exited:
+			s.setReady(false)
+			s.stop()
+			code := x.code
+			if x.err != nil {
+				code = -1
+			}
+			s.stats.ExitCodes = append(s.stats.ExitCodes, code)
+			return "", nil
+		}
+	}
+}
+
+func (s *Supervisor) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	if err := s.process.Stop(ctx); err != nil {
+		log := gologger.WithComponent("service")
+		log.Warn().Err(err).Msg("Failed to stop service")
+	}
+}
+
+// probe reports whether the service answers its readiness probe
+func (s *Supervisor) probe(ctx context.Context) bool {
+	readiness := &s.config.Readiness
+	addr, err := s.process.Address(ctx, readiness.Port)
+	if err != nil {
+		return false
+	}
+	timeout := readiness.interval
+	if timeout > maxProbeTimeout {
+		timeout = maxProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if readiness.HTTPPath == "" {
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+readiness.HTTPPath, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	return resp.StatusCode >= 200 && resp.StatusCode < 400
+}
+
+// setReady records whether the service is ready, reporting transitions
+func (s *Supervisor) setReady(ready bool) {
+	if ready == s.ready {
+		return
+	}
+	now := s.clock.Now()
+	if ready {
+		s.readySince = now
+		if s.stats.TimeToReadySeconds == 0 {
+			s.stats.TimeToReadySeconds = now.Sub(s.start).Seconds()
+		}
+	} else {
+		s.uptime += now.Sub(s.readySince)
+	}
+	s.ready = ready
+	s.stats.Transitions++
+	s.reportHealth()
+}
+
+// uptimeAt is how long the service has been ready at now
+func (s *Supervisor) uptimeAt(now time.Time) time.Duration {
+	uptime := s.uptime
+	if s.ready {
+		uptime += now.Sub(s.readySince)
+	}
+	return uptime
+}
+
+func (s *Supervisor) reportHealth() {
+	if s.report == nil {
+		return
+	}
+	now := s.clock.Now()
+	elapsed := now.Sub(s.start)
+	percent := 100 * elapsed.Seconds() / s.config.duration.Seconds()
+	if percent > 100 {
+		percent = 100
+	}
+	message := "service not ready"
+	if s.ready {
+		message = "service ready"
+	}
+	s.report(&models.TaskProgress{
+		Percent: percent,
+		Message: message,
+		Time:    now,
+		Service: &models.ServiceHealth{
+			Ready:          s.ready,
+			UptimeSeconds:  s.uptimeAt(now).Seconds(),
+			ElapsedSeconds: elapsed.Seconds(),
+			Restarts:       s.stats.Restarts,
+		},
+	})
+}
+
+// finish returns the service's report once it stopped for stop
+func (s *Supervisor) finish(stop models.ServiceStop) *models.ServiceReport {
+	now := s.clock.Now()
+	uptime := s.uptimeAt(now)
+	s.uptime, s.ready = uptime, false
+
+	report := s.stats
+	report.ElapsedSeconds = now.Sub(s.start).Seconds()
+	report.UptimeSeconds = uptime.Seconds()
+	if report.ElapsedSeconds > 0 {
+		report.UptimeRatio = report.UptimeSeconds / report.ElapsedSeconds
+	}
+	report.StoppedBy = stop
+	return &report
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func TestMain(m *testing.M) {
+	restartBackoff = 10 * time.Millisecond
+	os.Exit(m.Run())
+}
+
+// fixtureService is an HTTP service answering its readiness probe with
+// status, which exits with code 1 crashAfter[i] into its i-th run and
+// otherwise runs until stopped
+type fixtureService struct {
+	status     int
+	crashAfter []time.Duration
+
+	mu     sync.Mutex
+	starts int
+	stops  int
+	srv    *httptest.Server
+	exit   chan int
+}
+
+func (f *fixtureService) Start(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(f.status)
+	}))
+	exit := make(chan int, 1)
+	f.exit = exit
+	if f.starts < len(f.crashAfter) && f.crashAfter[f.starts] > 0 {
+		time.AfterFunc(f.crashAfter[f.starts], func() { exit <- 1 })
+	}
+	f.starts++
+	return nil
+}
+
+func (f *fixtureService) Wait(ctx context.Context) (int, error) {
+	f.mu.Lock()
+	exit := f.exit
+	f.mu.Unlock()
+	select {
+	case code := <-exit:
+		return code, nil
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+}
+
+func (f *fixtureService) Stop(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stops++
+	if f.srv != nil {
+		f.srv.Close()
+		f.srv = nil
+	}
+	return nil
+}
+
+func (f *fixtureService) Address(ctx context.Context, port int) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.srv == nil {
+		return "", errors.New("not running")
+	}
+	return f.srv.Listener.Addr().String(), nil
+}
+
+func parse(t *testing.T, config string) *Config {
+	t.Helper()
+	c, err := ParseConfig(json.RawMessage(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// progressLog collects the health reports of a service
+type progressLog struct {
+	mu      sync.Mutex
+	reports []*models.TaskProgress
+}
+
+func (l *progressLog) report(p *models.TaskProgress) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reports = append(l.reports, p)
+}
+
+func (l *progressLog) readiness() []bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var states []bool
+	for _, p := range l.reports {
+		if len(states) == 0 || states[len(states)-1] != p.Service.Ready {
+			states = append(states, p.Service.Ready)
+		}
+	}
+	return states
+}
+
+func TestServiceCompletesAtDuration(t *testing.T) {
+	config := parse(t, `{"image_name": "inference", "duration": "400ms", "health_interval": "50ms",
+		"readiness": {"http_path": "/healthz", "port": 8080, "interval": "10ms"}}`)
+	process := &fixtureService{status: http.StatusOK}
+	var progress progressLog
+
+	report, err := New(config, process, progress.report).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.StoppedBy != models.ServiceStopDuration || report.Restarts != 0 || len(report.ExitCodes) != 0 {
+		t.Errorf("report = %+v, want a clean stop at the duration", report)
+	}
+	if report.UptimeRatio < 0.5 || report.UptimeRatio > 1 || report.UptimeSeconds > report.ElapsedSeconds {
+		t.Errorf("uptime %.3fs of %.3fs (ratio %.2f), want the service ready most of its run", report.UptimeSeconds, report.ElapsedSeconds, report.UptimeRatio)
+	}
+	if report.TimeToReadySeconds <= 0 || report.Transitions != 1 {
+		t.Errorf("report = %+v, want one transition to ready", report)
+	}
+	if states := progress.readiness(); len(states) != 1 || !states[0] {
+		t.Errorf("reported readiness %v, want ready", states)
+	}
+	if len(progress.reports) < 3 {
+		t.Errorf("%d health reports, want periodic ones besides the transition", len(progress.reports))
+	}
+	if process.srv != nil {
+		t.Error("service still running after its duration")
+	}
+}
+
+func TestServiceRestartsAfterCrash(t *testing.T) {
+	config := parse(t, `{"image_name": "inference", "duration": "600ms", "max_restarts": 2,
+		"readiness": {"http_path": "/healthz", "port": 8080, "interval": "10ms"}}`)
+	process := &fixtureService{status: http.StatusOK, crashAfter: []time.Duration{150 * time.Millisecond}}
+	var progress progressLog
+
+	report, err := New(config, process, progress.report).Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.StoppedBy != models.ServiceStopDuration || report.Restarts != 1 || len(report.ExitCodes) != 1 || report.ExitCodes[0] != 1 {
+		t.Errorf("report = %+v, want one restart after exit code 1", report)
+	}
+	if process.starts != 2 {
+		t.Errorf("service started %d times, want twice", process.starts)
+	}
+	if states := progress.readiness(); len(states) != 3 || !states[0] || states[1] || !states[2] {
+		t.Errorf("reported readiness %v, want ready, not ready and ready again", states)
+	}
+	if report.UptimeRatio >= 1 || report.UptimeRatio <= 0 {
+		t.Errorf("uptime ratio %.2f, want the crash to cost uptime", report.UptimeRatio)
+	}
+}
+
+func TestServiceGivesUpAfterRestarts(t *testing.T) {
+	config := parse(t, `{"image_name": "inference", "duration": "1h", "max_restarts": 1,
+		"readiness": {"port": 8080, "interval": "10ms"}}`)
+	process := &fixtureService{status: http.StatusOK, crashAfter: []time.Duration{20 * time.Millisecond, 20 * time.Millisecond}}
+
+	report, err := New(config, process, nil).Run(context.Background())
+	if !errors.Is(err, ErrCrashed) {
+		t.Fatalf("Run() error = %v, want ErrCrashed", err)
+	}
+	if report.StoppedBy != models.ServiceStopCrashed || report.Restarts != 1 || len(report.ExitCodes) != 2 {
+		t.Errorf("report = %+v, want it given up on after one restart", report)
+	}
+}
+
+func TestServiceNotReady(t *testing.T) {
+	config := parse(t, `{"image_name": "inference", "duration": "1h",
+		"readiness": {"http_path": "/healthz", "port": 8080, "interval": "10ms", "startup_timeout": "150ms"}}`)
+	process := &fixtureService{status: http.StatusServiceUnavailable}
+	var progress progressLog
+
+	report, err := New(config, process, progress.report).Run(context.Background())
+	if !errors.Is(err, ErrNotReady) {
+		t.Fatalf("Run() error = %v, want ErrNotReady", err)
+	}
+	if report.StoppedBy != models.ServiceStopNotReady || report.UptimeSeconds != 0 || report.TimeToReadySeconds != 0 {
+		t.Errorf("report = %+v, want no uptime", report)
+	}
+	if process.srv != nil {
+		t.Error("service still running after it failed to become ready")
+	}
+}
+
+func TestServiceStopsWhenCancelled(t *testing.T) {
+	config := parse(t, `{"image_name": "inference", "duration": "1h",
+		"readiness": {"port": 8080, "interval": "10ms"}}`)
+	process := &fixtureService{status: http.StatusOK}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	report, err := New(config, process, nil).Run(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Run() error = %v, want the context's", err)
+	}
+	if report.StoppedBy != models.ServiceStopCancelled || report.UptimeSeconds == 0 {
+		t.Errorf("report = %+v, want a cancelled stop with uptime", report)
+	}
+}
+
+func TestParseConfigRejects(t *testing.T) {
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{`{"duration": "1h", "readiness": {"port": 80}}`, "image name"},
+		{`{"image_name": "a", "readiness": {"port": 80}}`, "duration is required"},
+		{`{"image_name": "a", "duration": "-1h", "readiness": {"port": 80}}`, "duration must be positive"},
+		{`{"image_name": "a", "duration": "1h", "readiness": {"port": 0}}`, "port 0"},
+		{`{"image_name": "a", "duration": "1h", "readiness": {"port": 80, "http_path": "healthz"}}`, "http_path"},
+		{`{"image_name": "a", "duration": "1h", "readiness": {"port": 80}, "max_restarts": 100}`, "max_restarts"},
+		{`{"image_name": "a", "duration": "1h", "readiness": {"port": 80}, "ports": [{"container_port": 80, "host_port": 8080}, {"container_port": 81, "host_port": 8080, "protocol": "tcp"}]}`, "published twice"},
+	}
+	for _, tt := range tests {
+		if _, err := ParseConfig(json.RawMessage(tt.config)); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseConfig(%s) error = %v, want %q", tt.config, err, tt.want)
+		}
+	}
+}
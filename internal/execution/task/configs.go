@@ -0,0 +1,308 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/embedding"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+	"github.com/theblitlabs/parity-runner/internal/execution/onnx"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/quota"
+	"github.com/theblitlabs/parity-runner/internal/execution/training"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// Task configs are decoded here, apart from executing them, so that every
+// field a task creator controls is checked before any of it is acted on
+
+const (
+	// defaultCommandTimeout is the timeout, in seconds, of command tasks
+	// that set none
+	defaultCommandTimeout = 300
+	// maxCommandTimeout bounds command task timeouts to a week, in seconds
+	maxCommandTimeout = 7 * 24 * 60 * 60
+	// maxTotalParts bounds how many parts a federated learning dataset may
+	// be partitioned into
+	maxTotalParts = 1 << 16
+	// maxEpochs and maxBatchSize bound a federated learning round's training
+	maxEpochs    = 100000
+	maxBatchSize = 1 << 20
+)
+
+type commandConfig struct {
+	Command        string                 `json:"command"`
+	WorkingDir     string                 `json:"working_dir"`
+	Environment    map[string]string      `json:"environment"`
+	Timeout        int                    `json:"timeout_seconds"`
+	OutputManifest *models.OutputManifest `json:"output_manifest,omitempty"`
+	Inputs         []models.TaskInput     `json:"inputs,omitempty"`
+	// SecurityProfile is the security profile the command runs under
+	SecurityProfile string `json:"security_profile,omitempty"`
+	// Resources.DiskSpace caps what the command writes outside its
+	// working directory
+	Resources models.ResourceConfig `json:"resources,omitempty"`
+	// OutputPaths are absolute paths outside the working directory the
+	// command writes its outputs to. When the command runs under a disk
+	// quota they are copied into the working directory, at the same path
+	// below it, before the rest of what it wrote is discarded.
+	OutputPaths []string `json:"output_paths,omitempty"`
+
+	// diskSpace is Resources.DiskSpace in bytes
+	diskSpace uint64
+}
+
+func parseCommandConfig(raw json.RawMessage) (*commandConfig, error) {
+	var config commandConfig
+	if err := safejson.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse command config: %w", err)
+	}
+	if config.Command == "" {
+		return nil, fmt.Errorf("command is required")
+	}
+	if config.Timeout == 0 {
+		config.Timeout = defaultCommandTimeout
+	}
+	if config.Timeout < 0 || config.Timeout > maxCommandTimeout {
+		return nil, fmt.Errorf("timeout_seconds must be between 1 and %d", maxCommandTimeout)
+	}
+	diskSpace, err := gpu.ParseBytes(config.Resources.DiskSpace)
+	if err != nil {
+		return nil, fmt.Errorf("invalid disk_space: %w", err)
+	}
+	config.diskSpace = diskSpace
+	for _, p := range config.OutputPaths {
+		if err := quota.ValidateOutputPath(p); err != nil {
+			return nil, err
+		}
+	}
+	return &config, nil
+}
+
+type llmConfig struct {
+	Prompt         string                 `json:"prompt"`
+	ResponseFormat *models.ResponseFormat `json:"response_format"`
+	// Options are the sampling options the response is generated with
+	Options *llm.Sampling `json:"options,omitempty"`
+	// ModelArtifact is a model the creator supplies to serve the prompt
+	// with, in place of a model the runner offers
+	ModelArtifact *llm.ModelArtifact `json:"model_artifact,omitempty"`
+	// ToolUse lets the model call tools through the runner
+	ToolUse *models.ToolUseConfig `json:"tool_use,omitempty"`
+}
+
+func parseLLMConfig(raw json.RawMessage) (*llmConfig, error) {
+	var config llmConfig
+	if err := safejson.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse LLM task config: %w", err)
+	}
+	if config.Prompt == "" {
+		return nil, fmt.Errorf("prompt is required for LLM task")
+	}
+	if options := config.Options; options != nil {
+		if options.Temperature != nil && *options.Temperature < 0 {
+			return nil, fmt.Errorf("options.temperature must not be negative")
+		}
+		if options.TopP != nil && (*options.TopP < 0 || *options.TopP > 1) {
+			return nil, fmt.Errorf("options.top_p must be between 0 and 1")
+		}
+	}
+	if config.ModelArtifact != nil {
+		if err := config.ModelArtifact.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	if config.ToolUse != nil {
+		if err := config.ToolUse.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid tool_use: %w", err)
+		}
+		if config.ResponseFormat != nil {
+			return nil, fmt.Errorf("tool_use cannot be combined with response_format")
+		}
+	}
+	return &config, nil
+}
+
+func parseEmbeddingConfig(raw json.RawMessage) (*embedding.Config, error) {
+	var config embedding.Config
+	if err := safejson.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse embedding task config: %w", err)
+	}
+	return &config, nil
+}
+
+func parseONNXConfig(raw json.RawMessage) (*onnx.Config, error) {
+	var config onnx.Config
+	if err := safejson.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse ONNX task config: %w", err)
+	}
+	return &config, nil
+}
+
+type flConfig struct {
+	SessionID       string                 `json:"session_id"`
+	RoundID         string                 `json:"round_id"`
+	ModelType       string                 `json:"model_type"`
+	DatasetCID      string                 `json:"dataset_cid"`
+	DataFormat      string                 `json:"data_format"`
+	ModelConfig     map[string]interface{} `json:"model_config"`
+	ModelSpec       *training.ModelSpec    `json:"model_spec"`
+	ModelSpecHash   string                 `json:"model_spec_hash"`
+	TrainConfig     map[string]interface{} `json:"train_config"`
+	PartitionConfig map[string]interface{} `json:"partition_config"`
+	OutputFormat    string                 `json:"output_format"`
+	// DatasetSchema is what the dataset holds, checked as it downloads
+	DatasetSchema *training.DatasetSchema `json:"dataset_schema"`
+	// GlobalModel is the model the round trains from; without one training
+	// starts from freshly initialized weights
+	GlobalModel *models.GlobalModelRef `json:"global_model"`
+	// FinalRound marks the session's last round, after which its cached
+	// global models are dropped
+	FinalRound bool `json:"final_round"`
+}
+
+func parseFLConfig(raw json.RawMessage) (*flConfig, error) {
+	var config flConfig
+	if err := safejson.Unmarshal(raw, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse federated learning config: %w", err)
+	}
+	if config.ModelType == "" && config.ModelSpec == nil {
+		return nil, fmt.Errorf("model_type or model_spec is required")
+	}
+	if config.DatasetCID == "" {
+		return nil, fmt.Errorf("dataset_cid is required")
+	}
+	if config.DataFormat == "" {
+		return nil, fmt.Errorf("data_format is required")
+	}
+	if config.DatasetSchema != nil {
+		if err := config.DatasetSchema.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid dataset_schema: %w", err)
+		}
+	}
+	if config.SessionID == "" {
+		return nil, fmt.Errorf("session_id is required")
+	}
+	if config.RoundID == "" {
+		return nil, fmt.Errorf("round_id is required")
+	}
+	return &config, nil
+}
+
+// newTrainer creates the trainer the session's model spec describes or, for
+// sessions without one, the one its model type names. It returns the spec's
+// hash when there is a spec.
+func (c *flConfig) newTrainer() (training.Trainer, string, error) {
+	var trainer training.Trainer
+	var err error
+	switch {
+	case c.ModelSpec != nil:
+		if err := c.ModelSpec.Verify(c.ModelSpecHash); err != nil {
+			return nil, "", fmt.Errorf("invalid model spec: %w", err)
+		}
+		specHash, _ := c.ModelSpec.Hash()
+		c.ModelType = c.ModelSpec.Family
+		trainer, err = training.NewTrainerFromSpec(c.ModelSpec)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create trainer: %w", err)
+		}
+		return trainer, specHash, nil
+	case c.ModelType == "neural_network":
+		trainer, err = training.NewNeuralNetworkTrainer(c.ModelConfig)
+	case c.ModelType == "linear_regression":
+		trainer, err = training.NewLinearRegressionTrainer(c.ModelConfig)
+	case c.ModelType == "random_forest":
+		trainer, err = training.NewRandomForestTrainer(c.ModelConfig)
+	default:
+		return nil, "", fmt.Errorf("unsupported model type: %s", c.ModelType)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create trainer: %w", err)
+	}
+	return trainer, "", nil
+}
+
+// partition returns how the round's dataset is partitioned, nil when it is
+// used whole
+func (c *flConfig) partition() (*training.PartitionConfig, error) {
+	if c.PartitionConfig == nil {
+		return nil, nil
+	}
+	strategy := getStringFromMap(c.PartitionConfig, "strategy", "")
+	if strategy == "" {
+		return nil, fmt.Errorf("partition strategy is required")
+	}
+
+	partition := &training.PartitionConfig{
+		Strategy:     strategy,
+		TotalParts:   getIntFromMap(c.PartitionConfig, "total_parts", 1),
+		PartIndex:    getIntFromMap(c.PartitionConfig, "part_index", 0),
+		Alpha:        getFloatFromMap(c.PartitionConfig, "alpha", 0),
+		MinSamples:   getIntFromMap(c.PartitionConfig, "min_samples", 0),
+		OverlapRatio: getFloatFromMap(c.PartitionConfig, "overlap_ratio", 0),
+	}
+
+	if partition.TotalParts < 1 || partition.TotalParts > maxTotalParts {
+		return nil, fmt.Errorf("total_parts must be between 1 and %d", maxTotalParts)
+	}
+	if partition.PartIndex < 0 || partition.PartIndex >= partition.TotalParts {
+		return nil, fmt.Errorf("part_index must be between 0 and %d", partition.TotalParts-1)
+	}
+	if strategy == "non_iid" && !(partition.Alpha > 0) {
+		return nil, fmt.Errorf("alpha parameter is required for non_iid partitioning strategy")
+	}
+	if !(partition.OverlapRatio >= 0 && partition.OverlapRatio < 1) {
+		return nil, fmt.Errorf("overlap_ratio must be at least 0 and less than 1")
+	}
+	if partition.MinSamples <= 0 {
+		return nil, fmt.Errorf("min_samples must be provided and positive")
+	}
+	return partition, nil
+}
+
+// trainingParams returns the epochs, batch size and learning rate of the
+// round, which must all be set
+func (c *flConfig) trainingParams() (epochs, batchSize int, learningRate float64, err error) {
+	var hasEpochs, hasBatchSize, hasLearningRate bool
+	if c.TrainConfig != nil {
+		if e, ok := c.TrainConfig["epochs"].(float64); ok && e >= 0 && e <= maxEpochs {
+			epochs = int(e)
+			hasEpochs = true
+		}
+		if b, ok := c.TrainConfig["batch_size"].(float64); ok && b >= 0 && b <= maxBatchSize {
+			batchSize = int(b)
+			hasBatchSize = true
+		}
+		if lr, ok := c.TrainConfig["learning_rate"].(float64); ok && !math.IsInf(lr, 0) {
+			learningRate = lr
+			hasLearningRate = true
+		}
+	}
+
+	if !hasEpochs || !hasBatchSize || !hasLearningRate || epochs <= 0 || batchSize <= 0 || learningRate <= 0 {
+		return 0, 0, 0, fmt.Errorf("training configuration is incomplete - epochs (%d), batch_size (%d), and learning_rate (%f) must all be provided and positive, with at most %d epochs and batches of %d", epochs, batchSize, learningRate, maxEpochs, maxBatchSize)
+	}
+	return epochs, batchSize, learningRate, nil
+}
+
+// accumulation fits the round's batches of batchSize samples into budget
+// bytes, setting the trainer's micro-batch size. Trainers that cannot
+// accumulate gradients train on whole batches.
+func (c *flConfig) accumulation(trainer training.Trainer, inputSize, batchSize int, budget uint64) (training.AccumulationPlan, error) {
+	full := training.AccumulationPlan{BatchSize: batchSize, MicroBatchSize: batchSize, Steps: 1}
+	micro, ok := trainer.(training.MicroBatchTrainer)
+	if !ok {
+		return full, nil
+	}
+	plan, err := training.PlanAccumulation(batchSize, micro.MemoryEstimate(inputSize), budget)
+	if err != nil {
+		return full, err
+	}
+	if plan.Accumulates() && c.ModelSpec != nil && c.ModelSpec.UsesBatchStatistics() {
+		return full, fmt.Errorf("%w: batches of %d samples need %d accumulation steps", training.ErrBatchStatistics, batchSize, plan.Steps)
+	}
+	micro.SetMicroBatchSize(plan.MicroBatchSize)
+	return plan, nil
+}
@@ -0,0 +1,191 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+	"github.com/theblitlabs/parity-runner/internal/execution/training"
+)
+
+func TestFLPartitionRanges(t *testing.T) {
+	for name, partition := range map[string]string{
+		"no parts":         `{"strategy":"random","total_parts":0,"min_samples":1}`,
+		"negative index":   `{"strategy":"random","total_parts":4,"part_index":-1,"min_samples":1}`,
+		"index past parts": `{"strategy":"random","total_parts":4,"part_index":4,"min_samples":1}`,
+		"whole overlap":    `{"strategy":"random","total_parts":4,"overlap_ratio":1,"min_samples":1}`,
+		"too many parts":   `{"strategy":"random","total_parts":1e12,"min_samples":1}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			config := &flConfig{}
+			if err := json.Unmarshal([]byte(partition), &config.PartitionConfig); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := config.partition(); err == nil {
+				t.Fatalf("partition() accepted %s", partition)
+			}
+		})
+	}
+
+	config := &flConfig{PartitionConfig: map[string]interface{}{"strategy": "random", "total_parts": 4.0, "part_index": 3.0, "min_samples": 10.0}}
+	partition, err := config.partition()
+	if err != nil || partition.TotalParts != 4 || partition.PartIndex != 3 {
+		t.Fatalf("partition() = %+v, %v", partition, err)
+	}
+}
+
+func TestFLTrainingParamRanges(t *testing.T) {
+	for _, train := range []map[string]interface{}{
+		{"epochs": 1e18, "batch_size": 32.0, "learning_rate": 0.01},
+		{"epochs": 5.0, "batch_size": -1.0, "learning_rate": 0.01},
+		{"epochs": 5.0, "batch_size": 32.0},
+	} {
+		if _, _, _, err := (&flConfig{TrainConfig: train}).trainingParams(); err == nil {
+			t.Errorf("trainingParams() accepted %v", train)
+		}
+	}
+	epochs, batchSize, rate, err := (&flConfig{TrainConfig: map[string]interface{}{"epochs": 5.0, "batch_size": 32.0, "learning_rate": 0.01}}).trainingParams()
+	if err != nil || epochs != 5 || batchSize != 32 || rate != 0.01 {
+		t.Fatalf("trainingParams() = %d, %d, %v, %v", epochs, batchSize, rate, err)
+	}
+}
+
+func TestFLDatasetSchemaRanges(t *testing.T) {
+	for _, schema := range []string{
+		`{"num_features":-1}`,
+		`{"sample_rows":1e9}`,
+	} {
+		raw := `{"session_id":"s","round_id":"r","model_type":"random_forest","dataset_cid":"cid","data_format":"csv","dataset_schema":` + schema + `}`
+		if _, err := parseFLConfig(json.RawMessage(raw)); err == nil {
+			t.Errorf("parseFLConfig() accepted dataset_schema %s", schema)
+		}
+	}
+	config, err := parseFLConfig(json.RawMessage(`{"session_id":"s","round_id":"r","model_type":"random_forest","dataset_cid":"cid","data_format":"csv","dataset_schema":{"target":"species","num_features":4,"sample_rows":50}}`))
+	if err != nil || config.DatasetSchema.Target != "species" || config.DatasetSchema.NumFeatures != 4 || config.DatasetSchema.SampleRows != 50 {
+		t.Fatalf("parseFLConfig() = %+v, %v", config, err)
+	}
+}
+
+func TestFLAccumulation(t *testing.T) {
+	config, err := parseFLConfig(json.RawMessage(`{"session_id":"s","round_id":"r","dataset_cid":"cid","data_format":"csv","model_spec":{"family":"mlp","layers":[{"type":"dense","units":4},{"type":"dense","units":1}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	trainer, _, err := config.newTrainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2*4+4 + 4+1 = 17 parameters and 5 activations for two features
+	budget := uint64((2*17+2*4)*8 + 5*8*10)
+	plan, err := config.accumulation(trainer, 2, 32, budget)
+	if err != nil || plan.MicroBatchSize != 10 || plan.Steps != 4 {
+		t.Fatalf("accumulation() = %+v, %v, want micro-batches of 10 in 4 steps", plan, err)
+	}
+	if _, err := config.accumulation(trainer, 2, 32, 100); !errors.Is(err, training.ErrInsufficientMemory) {
+		t.Fatalf("accumulation() error = %v, want ErrInsufficientMemory", err)
+	}
+
+	forest, _ := training.NewRandomForestTrainer(map[string]interface{}{})
+	if plan, err := config.accumulation(forest, 2, 32, 100); err != nil || plan.Accumulates() {
+		t.Fatalf("accumulation() = %+v, %v for a trainer without micro-batches", plan, err)
+	}
+}
+
+func TestCommandTimeoutRange(t *testing.T) {
+	for _, config := range []string{
+		`{"command":"true","timeout_seconds":-5}`,
+		`{"command":"true","timeout_seconds":9223372036854775807}`,
+	} {
+		if _, err := parseCommandConfig(json.RawMessage(config)); err == nil {
+			t.Errorf("parseCommandConfig() accepted %s", config)
+		}
+	}
+	config, err := parseCommandConfig(json.RawMessage(`{"command":"true"}`))
+	if err != nil || config.Timeout != defaultCommandTimeout {
+		t.Fatalf("parseCommandConfig() = %+v, %v", config, err)
+	}
+}
+
+func TestCommandDiskQuota(t *testing.T) {
+	for _, config := range []string{
+		`{"command":"true","resources":{"disk_space":"lots"}}`,
+		`{"command":"true","output_paths":["out"]}`,
+		`{"command":"true","output_paths":["/"]}`,
+	} {
+		if _, err := parseCommandConfig(json.RawMessage(config)); err == nil {
+			t.Errorf("parseCommandConfig() accepted %s", config)
+		}
+	}
+	config, err := parseCommandConfig(json.RawMessage(`{"command":"true","resources":{"disk_space":"2g"},"output_paths":["/var/tmp/out"]}`))
+	if err != nil || config.diskSpace != 2<<30 {
+		t.Fatalf("parseCommandConfig() = %+v, %v", config, err)
+	}
+}
+
+// FuzzExecutorConfigs runs every executor's config decoding, and the checks
+// made on a decoded config before anything is executed, on arbitrary
+// configs. Seeds are the schema fixtures of real task configs.
+func FuzzExecutorConfigs(f *testing.F) {
+	fixtures, err := filepath.Glob(filepath.Join("..", "..", "taskschema", "testdata", "*", "*.json"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, fixture := range fixtures {
+		config, err := os.ReadFile(fixture)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(filepath.Base(filepath.Dir(fixture)), config)
+	}
+
+	f.Fuzz(func(t *testing.T, taskType string, raw []byte) {
+		switch models.TaskType(taskType) {
+		case models.TaskTypeCommand:
+			if config, err := parseCommandConfig(raw); err == nil {
+				if config.Timeout <= 0 || config.Timeout > maxCommandTimeout {
+					t.Fatalf("parseCommandConfig() accepted timeout %d", config.Timeout)
+				}
+				if config.OutputManifest != nil {
+					_ = config.OutputManifest.Validate()
+				}
+				for i := range config.Inputs {
+					_ = config.Inputs[i].Validate()
+				}
+				_ = strings.Fields(config.Command)
+			}
+		case models.TaskTypeLLM:
+			_ = llm.TaskModel(raw)
+			if config, err := parseLLMConfig(raw); err == nil && config.ResponseFormat != nil {
+				_, _ = llm.NewFormatChecker(config.ResponseFormat)
+			}
+		case models.TaskTypeEmbedding:
+			if config, err := parseEmbeddingConfig(raw); err == nil {
+				_ = config.Validate()
+			}
+		case models.TaskTypeONNX:
+			if config, err := parseONNXConfig(raw); err == nil {
+				_ = config.Validate()
+				_ = config.Files()
+			}
+		case models.TaskTypeFederatedLearning:
+			config, err := parseFLConfig(raw)
+			if err != nil {
+				return
+			}
+			if partition, err := config.partition(); err == nil && partition != nil {
+				if partition.PartIndex < 0 || partition.PartIndex >= partition.TotalParts {
+					t.Fatalf("partition() accepted part %d of %d", partition.PartIndex, partition.TotalParts)
+				}
+			}
+			if epochs, batchSize, _, err := config.trainingParams(); err == nil && (epochs > maxEpochs || batchSize > maxBatchSize) {
+				t.Fatalf("trainingParams() accepted %d epochs of batch size %d", epochs, batchSize)
+			}
+			_, _, _ = config.newTrainer()
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package task
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/execution/training"
+	"github.com/theblitlabs/parity-runner/internal/idlework"
+)
+
+// trainDonated trains epochs of a round as Train does. A donated round,
+// one donor is given for, trains only while no paid task runs, at the
+// donor's priority; when a paid task preempts it, the model goes back to
+// the weights the epochs began from and they are trained again once the
+// runner is idle. Models that cannot load weights, or are not built before
+// they first train, start the epochs over from where the preemption left
+// them.
+func trainDonated(ctx context.Context, donor *idlework.Donor, trainer training.Trainer, features [][]float64, labels []float64, epochs, batchSize int, learningRate float64) ([]float64, float64, float64, error) {
+	if donor == nil {
+		return trainer.Train(ctx, features, labels, epochs, batchSize, learningRate)
+	}
+	loader, _ := trainer.(training.WeightLoader)
+	for {
+		epochCtx, done, err := donor.Epoch(ctx)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		var checkpoint map[string][]float64
+		if loader != nil {
+			checkpoint = trainer.GetModelWeights()
+		}
+
+		var (
+			gradients      []float64
+			loss, accuracy float64
+		)
+		donor.Run(func() {
+			gradients, loss, accuracy, err = trainer.Train(epochCtx, features, labels, epochs, batchSize, learningRate)
+		})
+		preempted := err != nil && idlework.Preempted(epochCtx)
+		done()
+		if !preempted {
+			return gradients, loss, accuracy, err
+		}
+
+		log := gologger.WithComponent("task_executor")
+		log.Info().Int("epochs", epochs).Msg("Donated training yielded to a paid task")
+		if len(checkpoint) > 0 {
+			if err := loader.SetModelWeights(checkpoint); err != nil {
+				return nil, 0, 0, fmt.Errorf("failed to restore weights after preemption: %w", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/training"
+	"github.com/theblitlabs/parity-runner/internal/idlework"
+)
+
+// batchTrainer is a model whose one weight counts the batches it was
+// trained on. Each batch takes a millisecond; the batch after pauseAt
+// waits for resume.
+type batchTrainer struct {
+	mu      sync.Mutex
+	weight  float64
+	batches int
+
+	pauseAt float64
+	paused  chan struct{}
+	resume  chan struct{}
+	yielded time.Time
+}
+
+func (b *batchTrainer) LoadData(context.Context, string, string) ([][]float64, []float64, error) {
+	return nil, nil, errors.New("not a dataset trainer")
+}
+
+func (b *batchTrainer) Train(ctx context.Context, features [][]float64, labels []float64, epochs, batchSize int, learningRate float64) ([]float64, float64, float64, error) {
+	for range epochs {
+		for i := 0; i < len(features); i += batchSize {
+			if err := ctx.Err(); err != nil {
+				b.mu.Lock()
+				b.yielded = time.Now()
+				b.mu.Unlock()
+				return nil, 0, 0, err
+			}
+			time.Sleep(time.Millisecond)
+			b.mu.Lock()
+			b.weight++
+			b.batches++
+			pause := b.weight == b.pauseAt && b.paused != nil
+			b.mu.Unlock()
+			if pause {
+				close(b.paused)
+				b.paused = nil
+				<-b.resume
+			}
+		}
+	}
+	return []float64{b.weight}, 0, 1, nil
+}
+
+func (b *batchTrainer) GetModelWeights() map[string][]float64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return map[string][]float64{"w": {b.weight}}
+}
+
+func (b *batchTrainer) SetModelWeights(weights map[string][]float64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.weight = weights["w"][0]
+	return nil
+}
+
+func (b *batchTrainer) GetGradients() map[string][]float64 { return nil }
+
+func (b *batchTrainer) SetDatasetCache(*training.DatasetCache) {}
+
+func (b *batchTrainer) trained() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.batches
+}
+
+func TestDonatedRoundYieldsToPaidTaskMidEpoch(t *testing.T) {
+	const (
+		epochs      = 3
+		batches     = 10
+		maxLatency  = 50 * time.Millisecond
+		resumeAfter = 20 * time.Millisecond
+	)
+	donor, err := idlework.New([]string{"session-1"}, idlework.Priority{Nice: 19, Sched: idlework.SchedIdle, IO: idlework.IOIdle})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pause halfway through the second epoch
+	trainer := &batchTrainer{pauseAt: batches + batches/2, paused: make(chan struct{}), resume: make(chan struct{})}
+	paused := trainer.paused
+	sink := &epochSink{clock: clocktest.NewFake(time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC))}
+	e := &Executor{clock: sink.clock, progress: sink}
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeFederatedLearning}
+
+	type result struct {
+		gradients []float64
+		err       error
+	}
+	finished := make(chan result, 1)
+	go func() {
+		gradients, _, _, _, err := e.train(idlework.WithDonor(context.Background(), donor), task, trainer, make([][]float64, batches), make([]float64, batches), epochs, 1, 0.01, "")
+		finished <- result{gradients, err}
+	}()
+
+	select {
+	case <-paused:
+	case <-time.After(5 * time.Second):
+		t.Fatal("donated round never reached its second epoch")
+	}
+	preempted := time.Now()
+	release := donor.Preempt()
+	close(trainer.resume)
+
+	// The paid task runs; the donated round must not train meanwhile
+	deadline := time.Now().Add(5 * time.Second)
+	for donor.Status().LastYield == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("donated round did not yield")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	trainer.mu.Lock()
+	latency := trainer.yielded.Sub(preempted)
+	trainer.mu.Unlock()
+	if latency > maxLatency {
+		t.Errorf("donated round yielded after %v, want within %v", latency, maxLatency)
+	}
+	if yield := donor.Status().LastYield; yield > maxLatency {
+		t.Errorf("status reports a yield after %v, want within %v", yield, maxLatency)
+	}
+	held := trainer.trained()
+	time.Sleep(resumeAfter)
+	if trainer.trained() != held {
+		t.Fatal("donated round trained while a paid task ran")
+	}
+	if status := donor.Status(); status.Preemptions != 1 {
+		t.Errorf("status reports %d preemptions, want 1", status.Preemptions)
+	}
+
+	release()
+	var res result
+	select {
+	case res = <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("donated round did not resume once the paid task finished")
+	}
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	// The half epoch cut short is trained over from its checkpoint, so the
+	// model saw every batch exactly once per epoch
+	if got := trainer.GetModelWeights()["w"][0]; got != epochs*batches {
+		t.Errorf("model trained on %v batches, want %d", got, epochs*batches)
+	}
+	if len(res.gradients) != 1 || res.gradients[0] != epochs*batches {
+		t.Errorf("gradients = %v, want the weights after every epoch", res.gradients)
+	}
+	if trainer.trained() != epochs*batches+batches/2 {
+		t.Errorf("trained %d batches, want the %d of the round and the %d preempted", trainer.trained(), epochs*batches, batches/2)
+	}
+	if len(sink.reports) != epochs || sink.reports[epochs-1] != "epoch 3/3" {
+		t.Errorf("reported %v, want each epoch once", sink.reports)
+	}
+}
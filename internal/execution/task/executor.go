@@ -1,28 +1,75 @@
 package task
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/theblitlabs/gologger"
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/clock"
 	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/diskreserve"
+	"github.com/theblitlabs/parity-runner/internal/execution/embedding"
 	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+	"github.com/theblitlabs/parity-runner/internal/execution/onnx"
+	"github.com/theblitlabs/parity-runner/internal/execution/outputs"
 	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/profiles"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/quota"
+	"github.com/theblitlabs/parity-runner/internal/execution/service"
+	"github.com/theblitlabs/parity-runner/internal/execution/tasklog"
 	"github.com/theblitlabs/parity-runner/internal/execution/training"
+	"github.com/theblitlabs/parity-runner/internal/flmodel"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+	"github.com/theblitlabs/parity-runner/internal/migration"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+	"github.com/theblitlabs/parity-runner/internal/timeline"
+	"github.com/theblitlabs/parity-runner/internal/utils"
 )
 
 type Executor struct {
 	ollamaExecutor *llm.OllamaExecutor
 	dockerExecutor *docker.DockerExecutor
+	datasetCache   *training.DatasetCache
+	trainingMemory uint64
+	embeddings     embedding.Sources
+	usage          *llm.UsageReconciler
+	prompts        *llm.PromptCache
+	customModels   *llm.ModelLoader
+	tools          *llm.ToolBroker
+	toolLimit      models.ToolBudget
+	inputs         *inputs.Manager
+	globalModels   *flmodel.Fetcher
+	onnxRuntime    onnx.Runtime
+	migrations     *migration.Tracker
+	progress       docker.ProgressSink
+	previews       *outputs.PreviewOptions
+	clock          clock.Clock
+	// security confines command tasks once set; until then they run
+	// unconfined
+	security *profiles.Policy
+
+	mu       sync.RWMutex
+	disabled map[models.TaskType]bool
+	onChange []func()
 }
 
+type runFunc func(ctx context.Context, task *models.Task) (*models.TaskResult, error)
+
 func NewExecutor() *Executor {
 	// Detect available CPUs and set reasonable limits
 	cpuLimit := "8.0" // Default safe value
@@ -44,7 +91,547 @@ func NewExecutor() *Executor {
 	return &Executor{
 		ollamaExecutor: llm.NewOllamaExecutor("http://localhost:11434"),
 		dockerExecutor: dockerExecutor,
+		clock:          clock.Real(),
+	}
+}
+
+// registry returns how the executor runs each task type it is able to,
+// whether disabled or not. Docker and service tasks need a Docker executor, and ONNX
+// tasks an ONNX runtime and an input manager to fetch their models.
+func (e *Executor) registry() map[models.TaskType]runFunc {
+	registry := map[models.TaskType]runFunc{
+		models.TaskTypeCommand:           e.executeCommand,
+		models.TaskTypeLLM:               e.executeLLMTask,
+		models.TaskTypeFederatedLearning: e.executeFederatedLearningTask,
+		models.TaskTypeEmbedding:         e.executeEmbeddingTask,
+	}
+	if e.dockerExecutor != nil {
+		registry[models.TaskTypeDocker] = e.executeDockerTask
+		registry[models.TaskTypeService] = e.executeServiceTask
+	}
+	if e.onnxRuntime != nil && e.inputs != nil {
+		registry[models.TaskTypeONNX] = e.executeONNXTask
+	}
+	return registry
+}
+
+// runner returns how the executor runs tasks of taskType, false when it
+// does not run them
+func (e *Executor) runner(taskType models.TaskType) (runFunc, bool) {
+	e.mu.RLock()
+	disabled := e.disabled[taskType]
+	e.mu.RUnlock()
+	if disabled {
+		return nil, false
+	}
+	run, ok := e.registry()[taskType]
+	return run, ok
+}
+
+// TaskTypes returns the task types the executor runs, in order
+func (e *Executor) TaskTypes() []models.TaskType {
+	var types []models.TaskType
+	for taskType := range e.registry() {
+		if _, ok := e.runner(taskType); ok {
+			types = append(types, taskType)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// Disable stops the executor running tasks of types, calling the functions
+// passed to OnTaskTypesChange when that changes what it runs
+func (e *Executor) Disable(types ...models.TaskType) {
+	e.setDisabled(types, true)
+}
+
+// Enable lets the executor run tasks of types again after Disable
+func (e *Executor) Enable(types ...models.TaskType) {
+	e.setDisabled(types, false)
+}
+
+func (e *Executor) setDisabled(types []models.TaskType, disabled bool) {
+	registry := e.registry()
+
+	e.mu.Lock()
+	changed := false
+	for _, taskType := range types {
+		if _, ok := registry[taskType]; !ok || e.disabled[taskType] == disabled {
+			continue
+		}
+		if e.disabled == nil {
+			e.disabled = make(map[models.TaskType]bool)
+		}
+		e.disabled[taskType] = disabled
+		changed = true
+	}
+	onChange := append([]func(){}, e.onChange...)
+	e.mu.Unlock()
+
+	if changed {
+		for _, fn := range onChange {
+			fn()
+		}
+	}
+}
+
+// OnTaskTypesChange calls fn whenever the task types the executor runs change
+func (e *Executor) OnTaskTypesChange(fn func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onChange = append(e.onChange, fn)
+}
+
+// SetClock replaces the clock used by the underlying executors for timing and
+// retry backoff
+func (e *Executor) SetClock(c clock.Clock) {
+	e.clock = c
+	e.ollamaExecutor.SetClock(c)
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetClock(c)
+	}
+}
+
+// SetWarmModels keeps the models warm records loaded between LLM tasks
+func (e *Executor) SetWarmModels(warm *llm.WarmModels) {
+	e.ollamaExecutor.SetWarmModels(warm)
+}
+
+// LLMStats returns the queue and latency statistics of LLM generations
+func (e *Executor) LLMStats() *llm.Stats {
+	return e.ollamaExecutor.Stats()
+}
+
+// SetCPUSet pins Docker task containers to the cores in cpuset
+func (e *Executor) SetCPUSet(cpuset string) {
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetCPUSet(cpuset)
+	}
+}
+
+// SetImageExporter enables Docker tasks to return their image as an artifact
+func (e *Executor) SetImageExporter(exporter *docker.ImageExporter) {
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetImageExporter(exporter)
+	}
+}
+
+// SetArtifactPreviews describes the artifacts tasks produce in their
+// results, previewed within opts
+func (e *Executor) SetArtifactPreviews(opts outputs.PreviewOptions) {
+	e.previews = &opts
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetArtifactPreviews(opts)
+	}
+}
+
+// SetNetworkPolicy replaces the policy Docker tasks' network overrides are
+// checked against
+func (e *Executor) SetNetworkPolicy(policy docker.NetworkPolicy) {
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetNetworkPolicy(policy)
+	}
+}
+
+// CheckNetworkOverrides checks before a task is claimed that its network
+// overrides, and the ports a service task publishes, are valid and allowed
+// by the network policy
+func (e *Executor) CheckNetworkOverrides(task *models.Task) error {
+	if task.Type == models.TaskTypeService {
+		config, err := service.ParseConfig(task.Config)
+		if err != nil || len(config.Ports) == 0 {
+			return nil
+		}
+		if e.dockerExecutor == nil {
+			return fmt.Errorf("docker executor not available")
+		}
+		return e.dockerExecutor.CheckPorts(config.Ports)
+	}
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil || config.Network == nil {
+		return nil
+	}
+	if task.Type != models.TaskTypeDocker {
+		return fmt.Errorf("network overrides are only supported for Docker tasks")
+	}
+	if e.dockerExecutor == nil {
+		return fmt.Errorf("docker executor not available")
+	}
+	return e.dockerExecutor.CheckNetwork(config.Network)
+}
+
+// CheckHermetic checks that a task asking to run hermetically can here,
+// naming every requirement that cannot be enforced
+func (e *Executor) CheckHermetic(task *models.Task) error {
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil || !config.Hermetic {
+		return nil
+	}
+	if task.Type != models.TaskTypeDocker {
+		return fmt.Errorf("%w: only Docker tasks run hermetically", docker.ErrNotHermetic)
+	}
+	if e.dockerExecutor == nil {
+		return fmt.Errorf("docker executor not available")
+	}
+	return e.dockerExecutor.CheckHermetic(&config, task.Environment)
+}
+
+// SetUserPolicy replaces the policy deciding which user Docker tasks run as
+// and which capabilities they keep
+func (e *Executor) SetUserPolicy(policy docker.UserPolicy) {
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetUserPolicy(policy)
+	}
+}
+
+// CheckTaskUser checks before a task is claimed that, if it asks to run as
+// root, the user policy allows it
+func (e *Executor) CheckTaskUser(ctx context.Context, task *models.Task) error {
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil || !config.RunAsRoot {
+		return nil
+	}
+	if task.Type != models.TaskTypeDocker {
+		return fmt.Errorf("run_as_root is only supported for Docker tasks")
+	}
+	if e.dockerExecutor == nil {
+		return fmt.Errorf("docker executor not available")
+	}
+	return e.dockerExecutor.CheckUser(ctx, true)
+}
+
+// SetSecurityPolicy replaces the policy deciding which security profiles
+// tasks may ask for and which they run under by default, and confines
+// command tasks under them where the platform allows
+func (e *Executor) SetSecurityPolicy(policy profiles.Policy) {
+	e.security = &policy
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetSecurityPolicy(policy)
+	}
+}
+
+// CheckSecurityProfile checks before a task is claimed that the security
+// profile it asks for is permitted and can be applied to it
+func (e *Executor) CheckSecurityProfile(task *models.Task) error {
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil || config.SecurityProfile == "" {
+		return nil
+	}
+	switch task.Type {
+	case models.TaskTypeDocker, models.TaskTypeService:
+		if e.dockerExecutor == nil {
+			return fmt.Errorf("docker executor not available")
+		}
+		return e.dockerExecutor.CheckSecurityProfile(config.SecurityProfile)
+	case models.TaskTypeCommand:
+		_, err := e.commandProfile(config.SecurityProfile)
+		return err
+	default:
+		return fmt.Errorf("security profiles are only supported for Docker, service and command tasks")
+	}
+}
+
+// commandProfile returns the security profile a command task asking for
+// name runs under, nil when command tasks run unconfined
+func (e *Executor) commandProfile(name string) (*profiles.Profile, error) {
+	if e.security == nil || !profiles.Supported() {
+		if name != "" {
+			return nil, fmt.Errorf("security profile %s requested, but command tasks cannot be confined on this runner", name)
+		}
+		return nil, nil
+	}
+	return e.security.Resolve(name)
+}
+
+// SetMemoryPolicy enables a soft memory limit below the hard limit of
+// Docker tasks
+func (e *Executor) SetMemoryPolicy(policy docker.MemoryPolicy) {
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetMemoryPolicy(policy)
+	}
+}
+
+// SetStallPolicy reports, and optionally abandons, Docker tasks whose
+// processes stay stalled on memory, IO or CPU
+func (e *Executor) SetStallPolicy(policy docker.StallPolicy) {
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetStallPolicy(policy)
+	}
+}
+
+// SetWarningSink sends warnings about running Docker tasks to sink
+func (e *Executor) SetWarningSink(sink docker.WarningSink) {
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetWarningSink(sink)
+	}
+}
+
+// SetProgressSink relays the progress and checkpoints Docker tasks report
+// from inside their containers to sink, along with the epochs federated
+// learning rounds trained an epoch at a time complete
+func (e *Executor) SetProgressSink(sink docker.ProgressSink) {
+	e.progress = sink
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetProgressSink(sink)
+	}
+}
+
+// SetCheckpointUploaders uploads the checkpoints Docker tasks announce
+func (e *Executor) SetCheckpointUploaders(source artifacts.UploaderSource) {
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetCheckpointUploaders(source)
+	}
+}
+
+// SetStopGracePeriod sets how long a cancelled or timed out Docker task has
+// between SIGTERM and SIGKILL
+func (e *Executor) SetStopGracePeriod(grace time.Duration) {
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetStopGracePeriod(grace)
+	}
+}
+
+// SetRestartPolicy gives Docker task containers a docker restart policy
+func (e *Executor) SetRestartPolicy(policy string) {
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetRestartPolicy(policy)
+	}
+}
+
+// SetUsageReconciler makes LLM task results carry the runner's own token
+// count next to the backend's
+func (e *Executor) SetUsageReconciler(usage *llm.UsageReconciler) {
+	e.usage = usage
+}
+
+// SetPromptCache answers LLM prompts identical to one already answered,
+// with deterministic sampling, from prompts instead of generating again
+func (e *Executor) SetPromptCache(prompts *llm.PromptCache) {
+	e.prompts = prompts
+}
+
+// SetCustomModels enables LLM tasks to supply their own model artifact,
+// loaded into the backend by loader
+func (e *Executor) SetCustomModels(loader *llm.ModelLoader) {
+	e.customModels = loader
+}
+
+// CheckCustomModel checks before an LLM task is claimed that the model
+// artifact it supplies, if any, is valid and fits in model memory. Errors
+// wrap llm.ErrCustomModelsDisabled when the runner does not load custom
+// models and llm.ErrModelTooLarge when the model does not fit.
+func (e *Executor) CheckCustomModel(task *models.Task) error {
+	if task.Type != models.TaskTypeLLM {
+		return nil
+	}
+	config, err := parseLLMConfig(task.Config)
+	if err != nil || config.ModelArtifact == nil {
+		return err
+	}
+	if e.customModels == nil {
+		return llm.ErrCustomModelsDisabled
+	}
+	return e.customModels.Fits(config.ModelArtifact.Size)
+}
+
+// SetToolUse enables LLM tasks' models to call tools through broker, each
+// task spending at most limit
+func (e *Executor) SetToolUse(broker *llm.ToolBroker, limit models.ToolBudget) {
+	e.tools = broker
+	e.toolLimit = limit
+}
+
+// CheckToolUse checks before an LLM task is claimed that the runner calls
+// the tools it asks for. Errors wrap llm.ErrToolUseDisabled when it does
+// not.
+func (e *Executor) CheckToolUse(task *models.Task) error {
+	if task.Type != models.TaskTypeLLM {
+		return nil
+	}
+	config, err := parseLLMConfig(task.Config)
+	if err != nil || config.ToolUse == nil {
+		return err
+	}
+	if e.tools == nil {
+		return llm.ErrToolUseDisabled
+	}
+	return e.tools.Check(config.ToolUse)
+}
+
+// SetDatasetCache keeps federated learning datasets on disk between rounds
+func (e *Executor) SetDatasetCache(cache *training.DatasetCache) {
+	e.datasetCache = cache
+}
+
+// SetTrainingMemoryBudget bounds the memory federated learning training
+// holds at once; batches that do not fit are split into micro-batches whose
+// gradients are accumulated. Zero leaves training unbounded.
+func (e *Executor) SetTrainingMemoryBudget(bytes uint64) {
+	e.trainingMemory = bytes
+}
+
+// SetEmbeddingSources fetches the inputs of embedding tasks not given
+// inline through sources
+func (e *Executor) SetEmbeddingSources(sources embedding.Sources) {
+	e.embeddings = sources
+}
+
+// SetONNXRuntime enables ONNX tasks, run with runtime
+func (e *Executor) SetONNXRuntime(runtime onnx.Runtime) {
+	e.onnxRuntime = runtime
+}
+
+// SetInputManager enables Docker and command tasks to declare inputs,
+// downloaded through manager
+func (e *Executor) SetInputManager(manager *inputs.Manager) {
+	e.inputs = manager
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.SetInputManager(manager)
+	}
+}
+
+// SetGlobalModels enables federated learning rounds to train from the
+// global model the coordinator publishes, obtained through fetcher
+func (e *Executor) SetGlobalModels(fetcher *flmodel.Fetcher) {
+	e.globalModels = fetcher
+}
+
+// FetchGlobalModel obtains and verifies the global model of an FL round
+// before it is accepted, so the round's training finds it cached. Errors
+// wrap flmodel.ErrIntegrity when the model does not match its published
+// hash.
+func (e *Executor) FetchGlobalModel(ctx context.Context, task *models.Task) error {
+	if task.Type != models.TaskTypeFederatedLearning {
+		return nil
+	}
+	var config flConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil || config.GlobalModel == nil {
+		return nil
 	}
+	_, err := e.globalModel(ctx, &config)
+	return err
+}
+
+// globalModel returns the global model config trains from
+func (e *Executor) globalModel(ctx context.Context, config *flConfig) (map[string][]float64, error) {
+	if e.globalModels == nil {
+		return nil, fmt.Errorf("global models are not enabled on this runner")
+	}
+	return e.globalModels.Fetch(ctx, config.SessionID, config.RoundID, config.GlobalModel)
+}
+
+// CheckInputSpace checks before a task is claimed that its declared inputs
+// are valid and fit on disk, returning an error wrapping
+// inputs.ErrInsufficientDisk when they do not fit
+func (e *Executor) CheckInputSpace(ctx context.Context, task *models.Task) error {
+	if task.Type != models.TaskTypeDocker && task.Type != models.TaskTypeCommand {
+		return nil
+	}
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil || len(config.Inputs) == 0 {
+		return nil
+	}
+	if e.inputs == nil {
+		return fmt.Errorf("task inputs are not enabled on this runner")
+	}
+	if err := inputs.Validate(config.Inputs, task.Type); err != nil {
+		return err
+	}
+	return e.inputs.CheckSpace(ctx, config.Inputs)
+}
+
+// EstimateDisk estimates what task writes to disk before it is claimed:
+// its image, the inputs not yet cached and its declared scratch space. A
+// part that cannot be sized is left out of the demand and reported in the
+// error.
+func (e *Executor) EstimateDisk(ctx context.Context, task *models.Task) (diskreserve.Demand, error) {
+	var demand diskreserve.Demand
+	switch task.Type {
+	case models.TaskTypeDocker, models.TaskTypeCommand, models.TaskTypeService:
+	default:
+		return demand, nil
+	}
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return demand, nil
+	}
+
+	var errs []error
+	if task.Type != models.TaskTypeCommand && config.ImageName != "" && e.dockerExecutor != nil {
+		size, err := e.dockerExecutor.EstimateImageSize(ctx, config.ImageName, config.DockerImageURL)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("image: %w", err))
+		}
+		demand.Image = size
+	}
+	if e.inputs != nil && len(config.Inputs) > 0 {
+		demand.Inputs = e.inputs.Required(ctx, config.Inputs)
+	}
+	if config.Resources.DiskSpace != "" {
+		size, err := gpu.ParseBytes(config.Resources.DiskSpace)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("disk space: %w", err))
+		}
+		demand.Scratch = int64(size)
+	}
+	return demand, errors.Join(errs...)
+}
+
+// Detach leaves running task containers in place for another runner process
+// to adopt; see docker.DockerExecutor.Detach
+func (e *Executor) Detach() {
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.Detach()
+	}
+}
+
+// Attach undoes Detach
+func (e *Executor) Attach() {
+	if e.dockerExecutor != nil {
+		e.dockerExecutor.Attach()
+	}
+}
+
+// ReattachTask adopts a task container detached by another runner process
+func (e *Executor) ReattachTask(ctx context.Context, task *models.Task, detached *docker.DetachedContainer) (*models.TaskResult, error) {
+	if e.dockerExecutor == nil {
+		return nil, fmt.Errorf("docker executor not available")
+	}
+	return e.dockerExecutor.ReattachTask(ctx, task, detached)
+}
+
+// DiscardDetached removes a detached task container that will not be adopted
+func (e *Executor) DiscardDetached(ctx context.Context, detached *docker.DetachedContainer) error {
+	if e.dockerExecutor == nil {
+		return fmt.Errorf("docker executor not available")
+	}
+	return e.dockerExecutor.DiscardDetached(ctx, detached)
+}
+
+// PreflightTask checks before a docker task is claimed that its command can
+// run in its image. Other task types always pass.
+func (e *Executor) PreflightTask(ctx context.Context, task *models.Task) error {
+	if task.Type != models.TaskTypeDocker || e.dockerExecutor == nil {
+		return nil
+	}
+	return e.dockerExecutor.Preflight(ctx, task)
+}
+
+// SuspendTask freezes a running docker task in place
+func (e *Executor) SuspendTask(ctx context.Context, task *models.Task) error {
+	if task.Type != models.TaskTypeDocker || e.dockerExecutor == nil {
+		return fmt.Errorf("%s tasks cannot be suspended", task.Type)
+	}
+	return e.dockerExecutor.SuspendTask(ctx, task.ID.String())
+}
+
+// ResumeSuspendedTask continues a task SuspendTask froze
+func (e *Executor) ResumeSuspendedTask(ctx context.Context, task *models.Task) error {
+	if e.dockerExecutor == nil {
+		return fmt.Errorf("docker executor not available")
+	}
+	return e.dockerExecutor.ResumeSuspendedTask(ctx, task.ID.String())
 }
 
 func (e *Executor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
@@ -58,39 +645,26 @@ func (e *Executor) ExecuteTask(ctx context.Context, task *models.Task) (*models.
 		Str("task_type", string(task.Type)).
 		Msg("Starting task execution")
 
-	switch task.Type {
-	case models.TaskTypeCommand:
-		return e.executeCommand(ctx, task)
-	case models.TaskTypeLLM:
-		return e.executeLLMTask(ctx, task)
-	case models.TaskTypeFederatedLearning:
-		return e.executeFederatedLearningTask(ctx, task)
-	case models.TaskTypeDocker:
-		return e.executeDockerTask(ctx, task)
-	default:
+	run, ok := e.runner(task.Type)
+	if !ok {
 		return nil, fmt.Errorf("unsupported task type: %s", task.Type)
 	}
+	// Docker and command tasks mark their phases as they reach them
+	if task.Type != models.TaskTypeDocker && task.Type != models.TaskTypeCommand {
+		timeline.From(ctx).Enter(models.PhaseExecuting)
+	}
+	return run(ctx, task)
 }
 
 func (e *Executor) executeCommand(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
-	var config struct {
-		Command     string            `json:"command"`
-		WorkingDir  string            `json:"working_dir"`
-		Environment map[string]string `json:"environment"`
-		Timeout     int               `json:"timeout_seconds"`
-	}
-
-	if err := json.Unmarshal(task.Config, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse command config: %w", err)
-	}
-
-	if config.Command == "" {
-		return nil, fmt.Errorf("command is required")
+	config, err := parseCommandConfig(task.Config)
+	if err != nil {
+		return nil, err
 	}
 
-	// Set default timeout if not specified
-	if config.Timeout == 0 {
-		config.Timeout = 300 // 5 minutes default
+	profile, err := e.commandProfile(config.SecurityProfile)
+	if err != nil {
+		return nil, err
 	}
 
 	// Create command context with timeout
@@ -117,6 +691,73 @@ func (e *Executor) executeCommand(ctx context.Context, task *models.Task) (*mode
 		cmd.Dir = config.WorkingDir
 	}
 
+	// Tasks with an output manifest write into a dedicated directory that is verified afterwards
+	if config.OutputManifest != nil {
+		if err := config.OutputManifest.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid output manifest: %w", err)
+		}
+		if cmd.Dir == "" {
+			outputDir, err := os.MkdirTemp("", retention.ScratchPattern("output", task.ID.String()))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create output directory: %w", err)
+			}
+			defer os.RemoveAll(outputDir)
+			cmd.Dir = outputDir
+		}
+		if config.Environment == nil {
+			config.Environment = make(map[string]string)
+		}
+		config.Environment["PARITY_OUTPUT_DIR"] = cmd.Dir
+	}
+
+	// Inputs are linked into the working directory, a scratch one if the
+	// task does not name its own
+	var inputSet *inputs.Set
+	if len(config.Inputs) > 0 {
+		if e.inputs == nil {
+			return nil, fmt.Errorf("task inputs are not enabled on this runner")
+		}
+		if cmd.Dir == "" {
+			workDir, err := os.MkdirTemp("", retention.ScratchPattern("work", task.ID.String()))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create working directory: %w", err)
+			}
+			defer os.RemoveAll(workDir)
+			cmd.Dir = workDir
+		}
+		var err error
+		timeline.From(ctx).Enter(models.PhaseInputsFetching)
+		inputSet, err = e.inputs.Prepare(ctx, config.Inputs, models.TaskTypeCommand)
+		if err != nil {
+			return nil, fmt.Errorf("input preparation failed: %w", err)
+		}
+		defer inputSet.Close()
+		if err := inputSet.Link(cmd.Dir); err != nil {
+			return nil, fmt.Errorf("input preparation failed: %w", err)
+		}
+		timeline.From(ctx).Enter(models.PhaseInputsReady)
+		diskreserve.From(ctx).Settle(diskreserve.PhaseInputs, inputSet.Size())
+	}
+
+	// Commands under a disk quota write outside their working directory
+	// into a capped layer discarded afterwards, so they always get one
+	capped := config.diskSpace > 0 && quota.Supported()
+	if config.diskSpace > 0 && !capped {
+		log := gologger.WithComponent("task_executor")
+		log.Warn().
+			Str("task_id", task.ID.String()).
+			Str("disk_space", config.Resources.DiskSpace).
+			Msg("Disk quotas are not supported on this platform, running command task without one")
+	}
+	if capped && cmd.Dir == "" {
+		workDir, err := os.MkdirTemp("", retention.ScratchPattern("work", task.ID.String()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create working directory: %w", err)
+		}
+		defer os.RemoveAll(workDir)
+		cmd.Dir = workDir
+	}
+
 	// Set environment variables
 	if len(config.Environment) > 0 {
 		env := os.Environ()
@@ -126,27 +767,74 @@ func (e *Executor) executeCommand(ctx context.Context, task *models.Task) (*mode
 		cmd.Env = env
 	}
 
-	// Capture output
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		if cmdCtx.Err() == context.DeadlineExceeded {
-			return nil, fmt.Errorf("command timed out after %d seconds", config.Timeout)
+	var applied *models.AppliedSecurityProfile
+	if profile != nil {
+		appArmor := profiles.LoadAppArmor(ctx, profile)
+		if err := profiles.Confine(cmd, profile, appArmor); err != nil {
+			return nil, fmt.Errorf("failed to confine command: %w", err)
+		}
+		applied = &models.AppliedSecurityProfile{Name: profile.Name, Seccomp: true, AppArmor: appArmor}
+	}
+	// The quota wraps the security profile, so that the command is
+	// confined inside its capped root
+	if capped {
+		if err := quota.Confine(cmd, config.diskSpace, config.OutputPaths); err != nil {
+			return nil, fmt.Errorf("failed to apply disk quota: %w", err)
 		}
-		return &models.TaskResult{
-			TaskID:    task.ID,
-			Output:    string(output),
-			Error:     err.Error(),
-			ExitCode:  cmd.ProcessState.ExitCode(),
-			CreatedAt: time.Now(),
-		}, nil
 	}
 
-	return &models.TaskResult{
-		TaskID:    task.ID,
-		Output:    string(output),
-		ExitCode:  cmd.ProcessState.ExitCode(),
-		CreatedAt: time.Now(),
-	}, nil
+	// A task may run in a directory already holding files, which are not
+	// its outputs
+	var before outputs.Snapshot
+	if config.OutputManifest != nil {
+		if before, err = outputs.TakeSnapshot(cmd.Dir); err != nil {
+			return nil, err
+		}
+	}
+
+	// Capture output, streaming it too when asked
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	if w, ok := tasklog.Output(ctx); ok {
+		out = io.MultiWriter(&buf, w)
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	timeline.From(ctx).Enter(models.PhaseExecuting)
+	run := clock.Start(e.clock)
+	err = cmd.Run()
+	timeline.From(ctx).Enter(models.PhaseUploading)
+	output := buf.Bytes()
+
+	result := &models.TaskResult{
+		TaskID:          task.ID,
+		Output:          string(output),
+		ExitCode:        cmd.ProcessState.ExitCode(),
+		CreatedAt:       time.Now(),
+		SecurityProfile: applied,
+	}
+	if err != nil && cmdCtx.Err() == context.DeadlineExceeded {
+		// A command killed at its deadline still reports what it wrote
+		result.Error = fmt.Sprintf("timed out after %s; the command was killed", run.Elapsed().Round(time.Second))
+		result.FailureCode = models.FailureTimeout
+		result.ExitCode = -1
+	} else if err != nil {
+		result.Error = err.Error()
+	}
+	if inputSet != nil {
+		result.Inputs = inputSet.Resolved
+		inputSet.Unlink()
+	}
+
+	if config.OutputManifest != nil {
+		verdict, verifyErr := outputs.VerifyManifestSince(cmd.Dir, config.OutputManifest, before)
+		if verifyErr != nil {
+			return nil, fmt.Errorf("output manifest verification failed: %w", verifyErr)
+		}
+		result.OutputVerdict = verdict
+	}
+
+	return result, nil
 }
 
 func (e *Executor) executeLLMTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
@@ -156,23 +844,51 @@ func (e *Executor) executeLLMTask(ctx context.Context, task *models.Task) (*mode
 		Msg("Executing LLM task")
 
 	// Extract model and prompt from task
-	var config struct {
-		Model  string `json:"model"`
-		Prompt string `json:"prompt"`
+	config, err := parseLLMConfig(task.Config)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := json.Unmarshal(task.Config, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse LLM task config: %w", err)
-	}
+	modelName := llm.TaskModel(task.Config)
+	prompt := config.Prompt
+	ctx = llm.WithSampling(ctx, config.Options)
 
-	modelName := config.Model
-	if modelName == "" {
-		modelName = "llama2" // Default model
+	var customModel *models.CustomModelReport
+	if config.ModelArtifact != nil {
+		var failed *models.TaskResult
+		customModel, failed, err = e.loadCustomModel(ctx, task, config.ModelArtifact)
+		if failed != nil || err != nil {
+			return failed, err
+		}
+		modelName = customModel.Model
+		defer func() {
+			if err := e.customModels.Release(context.WithoutCancel(ctx), modelName); err != nil {
+				log.Warn().Err(err).Str("model", modelName).Msg("Failed to release custom model")
+			}
+		}()
 	}
 
-	prompt := config.Prompt
-	if prompt == "" {
-		return nil, fmt.Errorf("prompt is required for LLM task")
+	cacheKey, cacheable := e.promptCacheKey(ctx, modelName, config)
+	if cacheable {
+		if cached, ok := e.prompts.Get(cacheKey); ok {
+			log.Info().
+				Str("task_id", task.ID.String()).
+				Str("model", modelName).
+				Str("cached_prompt_id", cached.PromptID).
+				Msg("Serving LLM response from the prompt cache")
+			return &models.TaskResult{
+				TaskID:          task.ID,
+				Output:          cached.Response,
+				PromptTokens:    cached.PromptTokens,
+				ResponseTokens:  cached.ResponseTokens,
+				TokenUsage:      cached.TokenUsage,
+				ResponseFormat:  cached.ResponseFormat,
+				ServedFromCache: true,
+				CachedPromptID:  cached.PromptID,
+				CustomModel:     customModel,
+				CreatedAt:       time.Now(),
+			}, nil
+		}
 	}
 
 	log.Info().
@@ -180,7 +896,17 @@ func (e *Executor) executeLLMTask(ctx context.Context, task *models.Task) (*mode
 		Str("model", modelName).
 		Msg("Generating LLM response")
 
-	response, err := e.ollamaExecutor.Generate(ctx, modelName, prompt)
+	if config.ToolUse != nil {
+		return e.executeToolUse(ctx, task, modelName, config, customModel)
+	}
+
+	var response *llm.GenerateResponse
+	var format *models.ResponseFormatReport
+	if config.ResponseFormat != nil {
+		response, format, err = llm.GenerateWithFormat(ctx, e.ollamaExecutor, modelName, prompt, config.ResponseFormat)
+	} else {
+		response, err = e.ollamaExecutor.Generate(ctx, modelName, prompt)
+	}
 	if err != nil {
 		log.Error().Err(err).
 			Str("task_id", task.ID.String()).
@@ -194,103 +920,339 @@ func (e *Executor) executeLLMTask(ctx context.Context, task *models.Task) (*mode
 		Str("model", modelName).
 		Msg("LLM response generated successfully")
 
-	return &models.TaskResult{
+	result := &models.TaskResult{
 		TaskID:         task.ID,
 		Output:         response.Response,
 		ExitCode:       0,
 		PromptTokens:   response.PromptEvalCount,
 		ResponseTokens: response.EvalCount,
 		InferenceTime:  response.TotalDuration / 1000000, // Convert nanoseconds to milliseconds
+		ResponseFormat: format,
+		CustomModel:    customModel,
+		CreatedAt:      time.Now(),
+	}
+	if format != nil && format.Enforcement == models.FormatUnmet {
+		// Malformed output is never submitted
+		result.Output = ""
+		result.ExitCode = 1
+		result.Error = fmt.Sprintf("response did not meet its %s format after %d attempts: %s",
+			format.Type, format.Attempts, strings.Join(format.Violations, "; "))
+		return result, nil
+	}
+
+	if e.usage != nil {
+		result.TokenUsage = e.usage.Reconcile(ctx, modelName, prompt, response.Response, response.PromptEvalCount, response.EvalCount)
+		if result.TokenUsage.Flagged {
+			log.Warn().
+				Str("task_id", task.ID.String()).
+				Str("model", modelName).
+				Str("tokenizer", result.TokenUsage.Tokenizer).
+				Int("backend_prompt_tokens", result.TokenUsage.BackendPromptTokens).
+				Int("counted_prompt_tokens", result.TokenUsage.CountedPromptTokens).
+				Int("backend_response_tokens", result.TokenUsage.BackendResponseTokens).
+				Int("counted_response_tokens", result.TokenUsage.CountedResponseTokens).
+				Msg("Token counts disagree with the backend - flagging for review")
+		}
+	}
+
+	if cacheable {
+		e.prompts.Put(cacheKey, &llm.CachedResponse{
+			PromptID:       task.ID.String(),
+			Response:       result.Output,
+			PromptTokens:   result.PromptTokens,
+			ResponseTokens: result.ResponseTokens,
+			TokenUsage:     result.TokenUsage,
+			ResponseFormat: result.ResponseFormat,
+		})
+	}
+
+	return result, nil
+}
+
+// executeToolUse answers an LLM task's prompt letting its model call
+// tools. A task whose tool budget runs out still succeeds with the answer
+// the model gave last, the report naming the budget.
+func (e *Executor) executeToolUse(ctx context.Context, task *models.Task, modelName string, config *llmConfig, customModel *models.CustomModelReport) (*models.TaskResult, error) {
+	log := gologger.WithComponent("task_executor")
+	if e.tools == nil {
+		return nil, llm.ErrToolUseDisabled
+	}
+	budget := config.ToolUse.Budget(e.toolLimit)
+	response, report, err := e.tools.Run(ctx, e.ollamaExecutor, modelName, config.Prompt, config.ToolUse, budget)
+	if err != nil {
+		log.Error().Err(err).
+			Str("task_id", task.ID.String()).
+			Str("model", modelName).
+			Msg("Failed to generate LLM response with tools")
+		if report == nil {
+			return nil, fmt.Errorf("failed to generate response: %w", err)
+		}
+		return &models.TaskResult{
+			TaskID:      task.ID,
+			ExitCode:    1,
+			Error:       fmt.Sprintf("failed to generate response: %v", err),
+			ToolUse:     report,
+			CustomModel: customModel,
+			CreatedAt:   time.Now(),
+		}, nil
+	}
+
+	event := log.Info()
+	if report.StopReason != "" {
+		event = log.Warn().Str("stop_reason", report.StopReason)
+	}
+	event.
+		Str("task_id", task.ID.String()).
+		Str("model", modelName).
+		Int("tool_calls", report.Usage.Calls).
+		Int64("fetched_bytes", report.Usage.FetchedBytes).
+		Dur("tool_time", report.Usage.ToolTime).
+		Int("turns", report.Turns).
+		Msg("LLM response generated with tools")
+
+	return &models.TaskResult{
+		TaskID:         task.ID,
+		Output:         response.Response,
+		PromptTokens:   response.PromptEvalCount,
+		ResponseTokens: response.EvalCount,
+		InferenceTime:  response.TotalDuration / 1000000, // Convert nanoseconds to milliseconds
+		ToolUse:        report,
+		CustomModel:    customModel,
 		CreatedAt:      time.Now(),
 	}, nil
 }
 
-func (e *Executor) executeFederatedLearningTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+// loadCustomModel loads the model artifact an LLM task supplies. A model
+// that fails to load fails the task with a result naming the reason.
+func (e *Executor) loadCustomModel(ctx context.Context, task *models.Task, artifact *llm.ModelArtifact) (*models.CustomModelReport, *models.TaskResult, error) {
+	if e.customModels == nil {
+		return nil, nil, llm.ErrCustomModelsDisabled
+	}
+	scratch, err := os.MkdirTemp("", retention.ScratchPattern("model", task.ID.String()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	tl := timeline.From(ctx)
+	tl.Enter(models.PhaseInputsFetching)
+	report, err := e.customModels.Load(ctx, artifact, scratch)
+	var loadErr *llm.LoadError
+	if errors.As(err, &loadErr) {
+		log := gologger.WithComponent("task_executor")
+		log.Warn().Err(err).
+			Str("task_id", task.ID.String()).
+			Str("sha256", artifact.SHA256).
+			Str("load_failure", loadErr.Code).
+			Msg("Custom model failed to load")
+		report.LoadFailure = loadErr.Code
+		return nil, &models.TaskResult{
+			TaskID:      task.ID,
+			ExitCode:    1,
+			Error:       err.Error(),
+			CustomModel: report,
+			CreatedAt:   time.Now(),
+		}, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load custom model: %w", err)
+	}
+	tl.Enter(models.PhaseInputsReady)
+	tl.Enter(models.PhaseExecuting)
+	return report, nil, nil
+}
+
+// promptCacheKey returns the prompt cache key of an LLM task's generation,
+// and false when there is no cache or the generation must not be cached
+func (e *Executor) promptCacheKey(ctx context.Context, modelName string, config *llmConfig) (string, bool) {
+	// Answers given with tools depend on what the tools returned
+	if e.prompts == nil || !config.Options.Deterministic() || config.ToolUse != nil {
+		return "", false
+	}
+	digest, err := e.ollamaExecutor.ModelDigest(ctx, modelName)
+	if err != nil {
+		log := gologger.WithComponent("task_executor")
+		log.Debug().Err(err).Str("model", modelName).Msg("Not caching LLM response - model digest unknown")
+		return "", false
+	}
+	return llm.PromptKey(digest, config.Prompt, config.Options, config.ResponseFormat)
+}
+
+func (e *Executor) executeEmbeddingTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
 	log := gologger.WithComponent("task_executor")
+
+	config, err := parseEmbeddingConfig(task.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	outputDir := filepath.Join(homeDir, utils.KeystoreDirName, "artifacts", task.ID.String())
+
 	log.Info().
 		Str("task_id", task.ID.String()).
-		Msg("Starting federated learning task execution")
+		Str("model", config.Model).
+		Str("output_dir", outputDir).
+		Msg("Executing embedding task")
 
-	var config struct {
-		SessionID       string                 `json:"session_id"`
-		RoundID         string                 `json:"round_id"`
-		ModelType       string                 `json:"model_type"`
-		DatasetCID      string                 `json:"dataset_cid"`
-		DataFormat      string                 `json:"data_format"`
-		ModelConfig     map[string]interface{} `json:"model_config"`
-		TrainConfig     map[string]interface{} `json:"train_config"`
-		PartitionConfig map[string]interface{} `json:"partition_config"`
-		OutputFormat    string                 `json:"output_format"`
+	summary, err := embedding.Run(ctx, e.ollamaExecutor, e.embeddings, config, outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("embedding task failed: %w", err)
 	}
 
-	if err := json.Unmarshal(task.Config, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse federated learning config: %w", err)
+	output, err := json.Marshal(summary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal embedding summary: %w", err)
 	}
 
-	// Validate required fields
-	if config.ModelType == "" {
-		return nil, fmt.Errorf("model_type is required")
+	return &models.TaskResult{
+		TaskID:    task.ID,
+		Output:    string(output),
+		ExitCode:  0,
+		Embedding: summary,
+		Artifacts: e.describeArtifacts(task, outputDir),
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+func (e *Executor) executeONNXTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	log := gologger.WithComponent("task_executor")
+
+	config, err := parseONNXConfig(task.Config)
+	if err != nil {
+		return nil, err
 	}
-	if config.DatasetCID == "" {
-		return nil, fmt.Errorf("dataset_cid is required")
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
 	}
-	if config.DataFormat == "" {
-		return nil, fmt.Errorf("data_format is required")
+	outputDir := filepath.Join(homeDir, utils.KeystoreDirName, "artifacts", task.ID.String())
+	scratch, err := os.MkdirTemp("", "parity-onnx-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scratch directory: %w", err)
 	}
-	if config.SessionID == "" {
-		return nil, fmt.Errorf("session_id is required")
+	defer os.RemoveAll(scratch)
+
+	log.Info().
+		Str("task_id", task.ID.String()).
+		Str("model_sha256", config.Model.SHA256).
+		Str("output_dir", outputDir).
+		Msg("Executing ONNX task")
+
+	summary, err := onnx.Run(ctx, e.onnxRuntime, e.inputs, config, scratch, outputDir)
+	if err != nil {
+		return nil, fmt.Errorf("ONNX task failed: %w", err)
 	}
-	if config.RoundID == "" {
-		return nil, fmt.Errorf("round_id is required")
+
+	output, err := json.Marshal(summary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal ONNX summary: %w", err)
 	}
 
-	// Create appropriate trainer based on model type
-	var trainer training.Trainer
-	var err error
+	return &models.TaskResult{
+		TaskID:    task.ID,
+		Output:    string(output),
+		ExitCode:  0,
+		ONNX:      summary,
+		Artifacts: e.describeArtifacts(task, outputDir),
+		CreatedAt: time.Now(),
+	}, nil
+}
 
-	switch config.ModelType {
-	case "neural_network":
-		trainer, err = training.NewNeuralNetworkTrainer(config.ModelConfig)
-	case "linear_regression":
-		trainer, err = training.NewLinearRegressionTrainer(config.ModelConfig)
-	case "random_forest":
-		trainer, err = training.NewRandomForestTrainer(config.ModelConfig)
-	default:
-		return nil, fmt.Errorf("unsupported model type: %s", config.ModelType)
+// describeArtifacts describes the artifacts task wrote to outputDir, or
+// returns nil when artifact previews are off or they cannot be listed
+func (e *Executor) describeArtifacts(task *models.Task, outputDir string) []models.ArtifactDescription {
+	if e.previews == nil {
+		return nil
 	}
-
+	described, err := outputs.DescribeArtifacts(outputDir, *e.previews)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create trainer: %w", err)
+		log := gologger.WithComponent("task_executor")
+		log.Warn().
+			Err(err).
+			Str("task_id", task.ID.String()).
+			Msg("Failed to describe task artifacts")
+		return nil
 	}
+	return described
+}
 
-	// Load training data with partitioning
-	var features [][]float64
-	var labels []float64
+func (e *Executor) executeFederatedLearningTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	log := gologger.WithComponent("task_executor")
+	log.Info().
+		Str("task_id", task.ID.String()).
+		Msg("Starting federated learning task execution")
 
-	if config.PartitionConfig != nil {
-		// Convert partition config from map to struct - validate required values
-		strategy := getStringFromMap(config.PartitionConfig, "strategy", "")
-		if strategy == "" {
-			return nil, fmt.Errorf("partition strategy is required")
-		}
+	config, err := parseFLConfig(task.Config)
+	if err != nil {
+		return nil, err
+	}
 
-		partitionConfig := &training.PartitionConfig{
-			Strategy:     strategy,
-			TotalParts:   getIntFromMap(config.PartitionConfig, "total_parts", 1),
-			PartIndex:    getIntFromMap(config.PartitionConfig, "part_index", 0),
-			Alpha:        getFloatFromMap(config.PartitionConfig, "alpha", 0),
-			MinSamples:   getIntFromMap(config.PartitionConfig, "min_samples", 0),
-			OverlapRatio: getFloatFromMap(config.PartitionConfig, "overlap_ratio", 0),
+	trainer, specHash, err := config.newTrainer()
+	if err != nil {
+		return nil, err
+	}
+	if config.ModelSpec != nil {
+		log.Info().
+			Str("family", config.ModelSpec.Family).
+			Int("layers", len(config.ModelSpec.Layers)).
+			Str("spec_hash", specHash).
+			Msg("Constructing model from session spec")
+	}
+	trainer.SetDatasetCache(e.datasetCache)
+	if config.DatasetSchema != nil {
+		checker, ok := trainer.(training.SchemaChecker)
+		if !ok {
+			return nil, fmt.Errorf("model type %s cannot check a dataset schema", config.ModelType)
 		}
+		checker.SetDatasetSchema(config.DatasetSchema)
+	}
 
-		// Validate strategy-specific requirements
-		if strategy == "non_iid" && partitionConfig.Alpha <= 0 {
-			return nil, fmt.Errorf("alpha parameter is required for non_iid partitioning strategy")
+	if config.GlobalModel != nil {
+		weights, err := e.globalModel(ctx, config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to obtain global model: %w", err)
+		}
+		loader, ok := trainer.(training.WeightLoader)
+		if !ok {
+			return nil, fmt.Errorf("model type %s cannot start from a global model", config.ModelType)
 		}
-		if partitionConfig.MinSamples <= 0 {
-			return nil, fmt.Errorf("min_samples must be provided and positive")
+		if err := loader.SetModelWeights(weights); err != nil {
+			return nil, fmt.Errorf("global model does not fit the session's model: %w", err)
 		}
+		log.Info().
+			Str("session_id", config.SessionID).
+			Str("round_id", config.RoundID).
+			Str("sha256", config.GlobalModel.SHA256).
+			Msg("Training from verified global model")
+	}
+	if config.FinalRound && e.globalModels != nil {
+		defer func() {
+			if err := e.globalModels.Forget(config.SessionID); err != nil {
+				log.Warn().Err(err).Str("session_id", config.SessionID).Msg("Failed to drop cached global models")
+			}
+		}()
+	}
 
+	// Load training data with partitioning
+	var features [][]float64
+	var labels []float64
+
+	partitionConfig, err := config.partition()
+	if err != nil {
+		return nil, err
+	}
+	// Extract training parameters - all values must be provided
+	epochs, batchSize, learningRate, err := config.trainingParams()
+	if err != nil {
+		return nil, err
+	}
+
+	if partitionConfig != nil {
 		log.Info().
 			Str("strategy", partitionConfig.Strategy).
 			Int("total_parts", partitionConfig.TotalParts).
@@ -299,11 +1261,8 @@ func (e *Executor) executeFederatedLearningTask(ctx context.Context, task *model
 			Msg("Loading partitioned training data")
 
 		// Use partitioned data loading
-		if nnTrainer, ok := trainer.(*training.NeuralNetworkTrainer); ok {
-			features, labels, err = nnTrainer.LoadPartitionedData(ctx, config.DatasetCID, config.DataFormat, partitionConfig)
-		} else if rfTrainer, ok := trainer.(*training.RandomForestTrainer); ok {
-			// Random forest trainer supports partitioned data loading
-			features, labels, err = rfTrainer.LoadPartitionedData(ctx, config.DatasetCID, config.DataFormat, partitionConfig)
+		if loader, ok := trainer.(training.PartitionedLoader); ok {
+			features, labels, err = loader.LoadPartitionedData(ctx, config.DatasetCID, config.DataFormat, partitionConfig)
 		} else {
 			// Fallback for other trainer types
 			features, labels, err = trainer.LoadData(ctx, config.DatasetCID, config.DataFormat)
@@ -333,38 +1292,29 @@ func (e *Executor) executeFederatedLearningTask(ctx context.Context, task *model
 	if err != nil {
 		return nil, fmt.Errorf("failed to load training data: %w", err)
 	}
+	if len(features) == 0 {
+		return nil, fmt.Errorf("failed to load training data: no samples loaded")
+	}
 
 	log.Info().
 		Int("samples_loaded", len(features)).
 		Int("features_per_sample", len(features[0])).
 		Msg("Training data loaded successfully")
 
-	// Extract training parameters - all values must be provided
-	var epochs, batchSize int
-	var learningRate float64
-	var hasEpochs, hasBatchSize, hasLearningRate bool
-
-	if config.TrainConfig != nil {
-		if e, ok := config.TrainConfig["epochs"].(float64); ok {
-			epochs = int(e)
-			hasEpochs = true
-		}
-		if b, ok := config.TrainConfig["batch_size"].(float64); ok {
-			batchSize = int(b)
-			hasBatchSize = true
-		}
-		if lr, ok := config.TrainConfig["learning_rate"].(float64); ok {
-			learningRate = lr
-			hasLearningRate = true
-		}
+	plan, err := config.accumulation(trainer, len(features[0]), min(batchSize, len(features)), e.trainingMemory)
+	if err != nil {
+		return nil, err
 	}
-
-	if !hasEpochs || !hasBatchSize || !hasLearningRate || epochs <= 0 || batchSize <= 0 || learningRate <= 0 {
-		return nil, fmt.Errorf("training configuration is incomplete - epochs (%d), batch_size (%d), and learning_rate (%f) must all be provided and positive", epochs, batchSize, learningRate)
+	if plan.Accumulates() {
+		log.Info().
+			Int("batch_size", plan.BatchSize).
+			Int("micro_batch_size", plan.MicroBatchSize).
+			Int("accumulation_steps", plan.Steps).
+			Msg("Accumulating gradients over micro-batches")
 	}
 
 	// Train the model
-	gradients, loss, accuracy, err := trainer.Train(ctx, features, labels, epochs, batchSize, learningRate)
+	gradients, loss, accuracy, resumedFrom, err := e.train(ctx, task, trainer, features, labels, epochs, batchSize, learningRate, specHash)
 	if err != nil {
 		return nil, fmt.Errorf("training failed: %w", err)
 	}
@@ -374,7 +1324,11 @@ func (e *Executor) executeFederatedLearningTask(ctx context.Context, task *model
 	var gradientsMap map[string][]float64
 
 	// Check if trainer supports the new interface
-	if nnTrainer, ok := trainer.(*training.NeuralNetworkTrainer); ok {
+	if config.ModelSpec != nil {
+		// Keyed as the spec's WeightKeys
+		weightsMap = trainer.GetModelWeights()
+		gradientsMap = trainer.GetGradients()
+	} else if nnTrainer, ok := trainer.(*training.NeuralNetworkTrainer); ok {
 		weightsMap = nnTrainer.GetModelWeights()
 		gradientsMap = nnTrainer.GetGradients()
 	} else if rfTrainer, ok := trainer.(*training.RandomForestTrainer); ok {
@@ -404,18 +1358,27 @@ func (e *Executor) executeFederatedLearningTask(ctx context.Context, task *model
 			"data_size":     len(features),
 			"training_time": 1000, // Placeholder training time in ms
 			"metadata": map[string]interface{}{
-				"model_type":     config.ModelType,
-				"epochs":         epochs,
-				"batch_size":     batchSize,
-				"learning_rate":  learningRate,
-				"dataset_cid":    config.DatasetCID,
-				"data_format":    config.DataFormat,
-				"feature_count":  len(features[0]),
-				"sample_count":   len(features),
-				"partition_info": config.PartitionConfig,
+				"model_type":         config.ModelType,
+				"epochs":             epochs,
+				"batch_size":         batchSize,
+				"micro_batch_size":   plan.MicroBatchSize,
+				"accumulation_steps": plan.Steps,
+				"learning_rate":      learningRate,
+				"dataset_cid":        config.DatasetCID,
+				"data_format":        config.DataFormat,
+				"feature_count":      len(features[0]),
+				"sample_count":       len(features),
+				"partition_info":     config.PartitionConfig,
 			},
 		}
 
+		if specHash != "" {
+			outputData["model_spec_hash"] = specHash
+		}
+		if resumedFrom > 0 {
+			outputData["metadata"].(map[string]interface{})["resumed_from_epoch"] = resumedFrom
+		}
+
 		// Add random forest specific metadata
 		if rfTrainer, ok := trainer.(*training.RandomForestTrainer); ok {
 			outputData["rf_metrics"] = map[string]interface{}{
@@ -493,3 +1456,90 @@ func (e *Executor) executeDockerTask(ctx context.Context, task *models.Task) (*m
 
 	return e.dockerExecutor.ExecuteTask(ctx, task)
 }
+
+// executeServiceTask keeps a service task's container running for its
+// duration, relaying its health as progress. A service that keeps crashing
+// or never becomes ready fails with its uptime report rather than an error,
+// so that the uptime it did deliver is still accounted.
+func (e *Executor) executeServiceTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	log := gologger.WithComponent("task_executor")
+
+	if e.dockerExecutor == nil {
+		return nil, fmt.Errorf("docker executor not available")
+	}
+	if err := utils.VerifyDrandNonce(task.Nonce); err != nil {
+		return nil, fmt.Errorf("invalid nonce format: %w", err)
+	}
+	config, err := service.ParseConfig(task.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	tl := timeline.From(ctx)
+	tl.Enter(models.PhaseImagePulling)
+	container, profile, err := e.dockerExecutor.NewServiceContainer(ctx, task, docker.ServiceSpec{
+		Image:           config.ImageName,
+		ImageURL:        config.DockerImageURL,
+		ImageDigest:     config.DockerImageDigest,
+		Entrypoint:      config.Entrypoint,
+		Command:         config.Command,
+		Env:             config.Env,
+		Ports:           config.Ports,
+		Resources:       config.Resources,
+		SecurityProfile: config.SecurityProfile,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer container.Close()
+
+	log.Info().
+		Str("task_id", task.ID.String()).
+		Str("image", config.ImageName).
+		Str("duration", config.Duration).
+		Msg("Executing service task")
+
+	report := func(progress *models.TaskProgress) {
+		if e.progress == nil {
+			return
+		}
+		if err := e.progress.ReportProgress(task.ID.String(), progress); err != nil {
+			log.Debug().Err(err).Str("task_id", task.ID.String()).Msg("Failed to report service health")
+		}
+	}
+	supervisor := service.New(config, container, report)
+	supervisor.SetClock(e.clock)
+
+	tl.Enter(models.PhaseExecuting)
+	stats, err := supervisor.Run(ctx)
+	result := &models.TaskResult{
+		TaskID:          task.ID,
+		Service:         stats,
+		SecurityProfile: profile,
+		CreatedAt:       time.Now(),
+	}
+	switch {
+	case errors.Is(err, service.ErrCrashed), errors.Is(err, service.ErrNotReady):
+		result.ExitCode = 1
+		if n := len(stats.ExitCodes); n > 0 && stats.ExitCodes[n-1] != 0 {
+			result.ExitCode = stats.ExitCodes[n-1]
+		}
+		result.Error = err.Error()
+	case err != nil:
+		return nil, err
+	}
+
+	output, err := json.Marshal(stats)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal service report: %w", err)
+	}
+	result.Output = string(output)
+
+	log.Info().
+		Str("task_id", task.ID.String()).
+		Str("stopped_by", string(stats.StoppedBy)).
+		Float64("uptime_ratio", stats.UptimeRatio).
+		Int("restarts", stats.Restarts).
+		Msg("Service task finished")
+	return result, nil
+}
@@ -0,0 +1,213 @@
+package task
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+	"github.com/theblitlabs/parity-runner/internal/execution/onnx"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+)
+
+func TestCommandTaskInputs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("input content"))
+	}))
+	defer server.Close()
+
+	manager, err := inputs.NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Executor{clock: clock.Real()}
+	e.SetInputManager(manager)
+
+	workdir := t.TempDir()
+	config, err := json.Marshal(map[string]interface{}{
+		"command":     "cat data/input.txt",
+		"working_dir": workdir,
+		"inputs": []models.TaskInput{
+			{Name: "input", Source: models.InputSource{URL: server.URL}, TargetPath: "data/input.txt"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeCommand, Config: config}
+
+	result, err := e.ExecuteTask(context.Background(), task)
+	if err != nil {
+		t.Fatalf("ExecuteTask() error = %v", err)
+	}
+	if result.ExitCode != 0 || strings.TrimSpace(result.Output) != "input content" {
+		t.Fatalf("command read %q (exit %d), want the linked input", result.Output, result.ExitCode)
+	}
+	if len(result.Inputs) != 1 || result.Inputs[0].Path != "data/input.txt" || result.Inputs[0].Size != int64(len("input content")) {
+		t.Fatalf("result inputs = %+v", result.Inputs)
+	}
+	if _, err := os.Lstat(filepath.Join(workdir, "data", "input.txt")); !os.IsNotExist(err) {
+		t.Fatalf("input link left in the working directory: %v", err)
+	}
+
+	escaping, _ := json.Marshal(map[string]interface{}{
+		"command": "true",
+		"inputs": []models.TaskInput{
+			{Name: "input", Source: models.InputSource{URL: server.URL}, TargetPath: "../input.txt"},
+		},
+	})
+	task.Config = escaping
+	if _, err := e.ExecuteTask(context.Background(), task); err == nil {
+		t.Fatal("ExecuteTask() accepted an input outside the working directory")
+	}
+	if err := e.CheckInputSpace(context.Background(), task); err == nil {
+		t.Fatal("CheckInputSpace() accepted an input outside the working directory")
+	}
+}
+
+func TestCommandTimeoutKeepsPartialOutput(t *testing.T) {
+	workdir := t.TempDir()
+	script := "echo partial\nexec sleep 10\n"
+	if err := os.WriteFile(filepath.Join(workdir, "task.sh"), []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := json.Marshal(map[string]interface{}{"command": "sh task.sh", "working_dir": workdir})
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeCommand, Config: config}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	result, err := (&Executor{clock: clock.Real()}).ExecuteTask(ctx, task)
+	if err != nil {
+		t.Fatalf("ExecuteTask() error = %v", err)
+	}
+	if result.FailureCode != models.FailureTimeout || !strings.HasPrefix(result.Error, "timed out after") {
+		t.Fatalf("result = %+v, want a timeout failure", result)
+	}
+	if strings.TrimSpace(result.Output) != "partial" {
+		t.Fatalf("output = %q, want what the command wrote before it was killed", result.Output)
+	}
+}
+
+func TestExecutorRegistry(t *testing.T) {
+	e := &Executor{clock: clock.Real()}
+	want := []models.TaskType{models.TaskTypeCommand, models.TaskTypeEmbedding, models.TaskTypeFederatedLearning, models.TaskTypeLLM}
+	if got := e.TaskTypes(); !equalTypes(got, want) {
+		t.Fatalf("TaskTypes() = %v, want %v without a Docker executor", got, want)
+	}
+
+	changes := 0
+	e.OnTaskTypesChange(func() { changes++ })
+	e.Disable(models.TaskTypeFederatedLearning, models.TaskTypeDocker)
+	if changes != 1 {
+		t.Fatalf("%d changes reported after disabling FL, want 1", changes)
+	}
+	want = []models.TaskType{models.TaskTypeCommand, models.TaskTypeEmbedding, models.TaskTypeLLM}
+	if got := e.TaskTypes(); !equalTypes(got, want) {
+		t.Fatalf("TaskTypes() = %v, want %v with FL disabled", got, want)
+	}
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeFederatedLearning, Config: []byte("{}")}
+	if _, err := e.ExecuteTask(context.Background(), task); err == nil || !strings.Contains(err.Error(), "unsupported task type") {
+		t.Fatalf("ExecuteTask() error = %v, want a disabled type unsupported", err)
+	}
+
+	e.Disable(models.TaskTypeFederatedLearning)
+	if changes != 1 {
+		t.Fatal("change reported for a type already disabled")
+	}
+	e.Enable(models.TaskTypeFederatedLearning)
+	if changes != 2 || len(e.TaskTypes()) != 4 {
+		t.Fatalf("after Enable() %d changes and types %v", changes, e.TaskTypes())
+	}
+}
+
+func TestExecutorRegistersONNX(t *testing.T) {
+	e := &Executor{clock: clock.Real()}
+	e.SetONNXRuntime(onnx.NewORTRuntime(""))
+	if _, ok := e.runner(models.TaskTypeONNX); ok {
+		t.Fatal("ONNX tasks run without an input manager to fetch their models")
+	}
+	manager, err := inputs.NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetInputManager(manager)
+	if _, ok := e.runner(models.TaskTypeONNX); !ok {
+		t.Fatalf("TaskTypes() = %v, want ONNX tasks run with a runtime", e.TaskTypes())
+	}
+}
+
+func equalTypes(a, b []models.TaskType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCorruptedCustomModelFailsTask(t *testing.T) {
+	model, err := os.ReadFile(filepath.Join("..", "llm", "testdata", "truncated.gguf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(model)
+	}))
+	defer files.Close()
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/tags" {
+			t.Errorf("backend asked %s %s for a model that failed to load", r.Method, r.URL.Path)
+		}
+		w.Write([]byte(`{"models": []}`))
+	}))
+	defer backend.Close()
+
+	manager, err := inputs.NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := &Executor{clock: clock.Real()}
+	e.SetCustomModels(llm.NewModelLoader(backend.URL, manager))
+
+	sum := sha256.Sum256(model)
+	config, err := json.Marshal(map[string]interface{}{
+		"prompt": "hello",
+		"model_artifact": map[string]interface{}{
+			"source":       map[string]string{"url": files.URL + "/model.gguf"},
+			"sha256":       hex.EncodeToString(sum[:]),
+			"quantization": "Q4_K_M",
+			"license":      map[string]interface{}{"name": "apache-2.0", "accepted": true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeLLM, Config: config}
+
+	result, err := e.ExecuteTask(context.Background(), task)
+	if err != nil {
+		t.Fatalf("ExecuteTask() error = %v", err)
+	}
+	report := result.CustomModel
+	if result.ExitCode != 1 || report == nil || report.LoadFailure != models.ModelLoadMalformed {
+		t.Fatalf("result = %+v with model %+v, want a malformed load failure", result, report)
+	}
+	if report.License == nil || !report.License.Accepted {
+		t.Errorf("license = %+v, want the acceptance recorded", report.License)
+	}
+}
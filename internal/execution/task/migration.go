@@ -0,0 +1,120 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/training"
+	"github.com/theblitlabs/parity-runner/internal/idlework"
+	"github.com/theblitlabs/parity-runner/internal/migration"
+)
+
+// epochProgressInterval is the least time between the progress reports of
+// a federated learning round trained an epoch at a time
+const epochProgressInterval = time.Second
+
+// SetMigrationTracker records the weights of federated learning rounds in
+// tracker after each epoch, so that a round can migrate to another runner
+// and resume there. Rounds whose model cannot start from given weights are
+// trained in one go.
+func (e *Executor) SetMigrationTracker(tracker *migration.Tracker) {
+	e.migrations = tracker
+}
+
+// train trains a federated learning round, returning what Train returns for
+// its last epoch and the epoch it resumed from. The round is trained an
+// epoch at a time while it is tracked for migration, resumes from a
+// migration bundle or is donated work.
+func (e *Executor) train(ctx context.Context, task *models.Task, trainer training.Trainer, features [][]float64, labels []float64, epochs, batchSize int, learningRate float64, specHash string) ([]float64, float64, float64, int, error) {
+	log := gologger.WithComponent("task_executor")
+	taskID := task.ID.String()
+
+	start := 0
+	if bundle := migration.Resume(ctx); bundle != nil && bundle.Training != nil {
+		var err error
+		if start, err = resumeTraining(trainer, bundle.Training, epochs, specHash); err != nil {
+			log.Warn().Err(err).Str("task_id", taskID).Msg("Cannot resume federated learning round from its migration bundle - starting over")
+		} else {
+			log.Info().
+				Str("task_id", taskID).
+				Int("epochs_completed", start).
+				Int("epochs", epochs).
+				Msg("Resuming federated learning round from migration bundle")
+		}
+	}
+
+	donor := idlework.From(ctx)
+	if _, ok := trainer.(training.WeightLoader); !ok || (e.migrations == nil && start == 0 && donor == nil) {
+		gradients, loss, accuracy, err := trainDonated(ctx, donor, trainer, features, labels, epochs, batchSize, learningRate)
+		return gradients, loss, accuracy, 0, err
+	}
+
+	var (
+		gradients      []float64
+		loss, accuracy float64
+		next           time.Time
+	)
+	for epoch := start; epoch < epochs; epoch++ {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, 0, start, err
+		}
+		var err error
+		gradients, loss, accuracy, err = trainDonated(ctx, donor, trainer, features, labels, 1, batchSize, learningRate)
+		if err != nil {
+			return nil, 0, 0, start, fmt.Errorf("epoch %d: %w", epoch+1, err)
+		}
+		if e.migrations != nil {
+			e.migrations.RecordTraining(taskID, migration.Training{
+				EpochsCompleted: epoch + 1,
+				Epochs:          epochs,
+				SpecHash:        specHash,
+				Weights:         trainer.GetModelWeights(),
+			})
+		}
+		if e.progress == nil {
+			continue
+		}
+		if now := e.clock.Now(); !now.Before(next) {
+			next = now.Add(epochProgressInterval)
+			e.reportEpoch(taskID, epoch+1, epochs, now)
+		}
+	}
+	return gradients, loss, accuracy, start, nil
+}
+
+// resumeTraining loads the weights of state into trainer, returning the
+// epoch to go on from
+func resumeTraining(trainer training.Trainer, state *migration.Training, epochs int, specHash string) (int, error) {
+	loader, ok := trainer.(training.WeightLoader)
+	if !ok {
+		return 0, errors.New("model cannot start from given weights")
+	}
+	if state.Epochs != epochs {
+		return 0, fmt.Errorf("bundle is of a round of %d epochs, not %d", state.Epochs, epochs)
+	}
+	if state.SpecHash != specHash {
+		return 0, fmt.Errorf("bundle is of model spec %q, not %q", state.SpecHash, specHash)
+	}
+	if err := loader.SetModelWeights(state.Weights); err != nil {
+		return 0, fmt.Errorf("bundle weights do not fit the model: %w", err)
+	}
+	return state.EpochsCompleted, nil
+}
+
+// reportEpoch reports that done of a round's epochs are trained
+func (e *Executor) reportEpoch(taskID string, done, epochs int, now time.Time) {
+	progress := &models.TaskProgress{
+		Percent: 100 * float64(done) / float64(epochs),
+		Message: fmt.Sprintf("epoch %d/%d", done, epochs),
+		Time:    now,
+	}
+	if err := e.progress.ReportProgress(taskID, progress); err != nil {
+		log := gologger.WithComponent("task_executor")
+		log.Debug().Err(err).Str("task_id", taskID).Msg("Failed to report training progress")
+	}
+}
@@ -0,0 +1,162 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/training"
+	"github.com/theblitlabs/parity-runner/internal/migration"
+)
+
+const migrationDatasetCID = "QmMigrationDataset1"
+
+// epochSink records the epochs reported, calling onEpoch with each and
+// moving the clock past the report interval
+type epochSink struct {
+	clock   *clocktest.Fake
+	reports []string
+	onEpoch func(message string)
+}
+
+func (s *epochSink) ReportProgress(taskID string, progress *models.TaskProgress) error {
+	s.reports = append(s.reports, progress.Message)
+	s.clock.Advance(epochProgressInterval)
+	if s.onEpoch != nil {
+		s.onEpoch(progress.Message)
+	}
+	return nil
+}
+
+func newMigrationExecutor(t *testing.T, sink *epochSink) *Executor {
+	t.Helper()
+	cache, err := training.OpenDatasetCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dataset strings.Builder
+	dataset.WriteString("x1,x2,y\n")
+	for i := range 16 {
+		x := float64(i) / 16
+		dataset.WriteString(strings.Join([]string{ftoa(x), ftoa(1 - x), ftoa(2 * x)}, ",") + "\n")
+	}
+	body, err := cache.Open(context.Background(), migrationDatasetCID, func(context.Context, string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(dataset.String())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+	return &Executor{datasetCache: cache, clock: sink.clock, progress: sink}
+}
+
+func ftoa(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func newRoundTask(t *testing.T) *models.Task {
+	t.Helper()
+	config, err := json.Marshal(map[string]interface{}{
+		"session_id":    "session-1",
+		"round_id":      "round-1",
+		"dataset_cid":   migrationDatasetCID,
+		"data_format":   "csv",
+		"output_format": "json",
+		"model_spec": map[string]interface{}{
+			"family": "mlp",
+			"layers": []map[string]interface{}{{"type": "dense", "units": 4}, {"type": "dense", "units": 1}},
+		},
+		"train_config": map[string]interface{}{"epochs": 5, "batch_size": 4, "learning_rate": 0.01},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Task{ID: uuid.New(), Type: models.TaskTypeFederatedLearning, Config: config}
+}
+
+func TestRoundResumesFromMigrationBundle(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC))
+	task := newRoundTask(t)
+
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	leaving := &epochSink{clock: clk, onEpoch: func(message string) {
+		if message == "epoch 2/5" {
+			stop()
+		}
+	}}
+	tracker := migration.NewTracker()
+	e := newMigrationExecutor(t, leaving)
+	e.SetMigrationTracker(tracker)
+	if _, err := e.executeFederatedLearningTask(ctx, task); err == nil {
+		t.Fatal("round finished despite being stopped")
+	}
+	bundle := tracker.Bundle(task.ID.String())
+	if bundle == nil || bundle.Training == nil || bundle.Training.EpochsCompleted != 2 || bundle.Training.Epochs != 5 {
+		t.Fatalf("bundle = %+v, want two of five epochs", bundle)
+	}
+
+	// The next runner reads the bundle as uploaded
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resumed migration.Bundle
+	if err := json.Unmarshal(data, &resumed); err != nil {
+		t.Fatal(err)
+	}
+	if err := resumed.Validate(task.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+
+	arriving := &epochSink{clock: clk}
+	result, err := newMigrationExecutor(t, arriving).executeFederatedLearningTask(migration.WithResume(context.Background(), &resumed), task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"epoch 3/5", "epoch 4/5", "epoch 5/5"}; strings.Join(arriving.reports, ",") != strings.Join(want, ",") {
+		t.Errorf("resumed round trained %v, want %v", arriving.reports, want)
+	}
+	var output struct {
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	if err := json.Unmarshal([]byte(result.Output), &output); err != nil {
+		t.Fatal(err)
+	}
+	if output.Metadata["resumed_from_epoch"] != 2.0 {
+		t.Errorf("resumed_from_epoch = %v, want 2", output.Metadata["resumed_from_epoch"])
+	}
+}
+
+func TestRoundStartsOverFromMismatchedBundle(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC))
+	task := newRoundTask(t)
+	bundle := &migration.Bundle{
+		Version: migration.Version,
+		TaskID:  task.ID.String(),
+		// A round of another length
+		Training: &migration.Training{EpochsCompleted: 2, Epochs: 10, Weights: map[string][]float64{"w": {1}}},
+	}
+
+	sink := &epochSink{clock: clk}
+	e := newMigrationExecutor(t, sink)
+	e.SetMigrationTracker(migration.NewTracker())
+	result, err := e.executeFederatedLearningTask(migration.WithResume(context.Background(), bundle), task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.reports) != 5 || sink.reports[0] != "epoch 1/5" {
+		t.Errorf("round trained %v, want all five epochs", sink.reports)
+	}
+	if strings.Contains(result.Output, "resumed_from_epoch") {
+		t.Error("round reported resuming from a bundle it did not fit")
+	}
+}
@@ -0,0 +1,23 @@
+// Package tasklog carries a writer through an execution's context so that
+// executors can stream a task's output live, as well as returning it in the
+// result.
+package tasklog
+
+import (
+	"context"
+	"io"
+)
+
+type outputKey struct{}
+
+// WithOutput returns a context whose task's output is copied to w as it is
+// produced
+func WithOutput(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, w)
+}
+
+// Output returns the writer set by WithOutput, if any
+func Output(ctx context.Context) (io.Writer, bool) {
+	w, ok := ctx.Value(outputKey{}).(io.Writer)
+	return w, ok && w != nil
+}
@@ -0,0 +1,118 @@
+package training
+
+import (
+	"errors"
+	"fmt"
+)
+
+// LayerBatchNorm names batch normalization layers. No trainer supports them
+// yet; the name is recognised so that a session asking for them can be
+// declined for the right reason when gradients would be accumulated.
+const LayerBatchNorm = "batch_norm"
+
+// bytesPerValue is the size of a float64 weight, gradient or activation
+const bytesPerValue = 8
+
+// ErrInsufficientMemory is returned when not even a single sample fits in
+// the training memory budget
+var ErrInsufficientMemory = errors.New("insufficient memory for training")
+
+// ErrBatchStatistics is returned when a model normalizes over the batch and
+// the batch would be split into micro-batches, whose statistics differ from
+// those of the batch the session asked for
+var ErrBatchStatistics = errors.New("batch normalization cannot be trained with gradient accumulation")
+
+// MemoryEstimate is the memory training takes: Fixed bytes for the weights
+// and gradient accumulators, plus PerSample bytes for each sample of a
+// micro-batch held in memory at once
+type MemoryEstimate struct {
+	Fixed     uint64
+	PerSample uint64
+}
+
+// AccumulationPlan splits each batch of BatchSize samples into Steps
+// micro-batches of at most MicroBatchSize samples. Gradients are summed over
+// the micro-batches and the optimizer steps once per batch, so the update is
+// that of the full batch.
+type AccumulationPlan struct {
+	BatchSize      int
+	MicroBatchSize int
+	Steps          int
+}
+
+// Accumulates reports whether batches are split
+func (p AccumulationPlan) Accumulates() bool {
+	return p.Steps > 1
+}
+
+// PlanAccumulation fits batches of batchSize samples into budget bytes. A
+// zero budget is unlimited.
+func PlanAccumulation(batchSize int, estimate MemoryEstimate, budget uint64) (AccumulationPlan, error) {
+	if batchSize <= 0 {
+		return AccumulationPlan{}, fmt.Errorf("batch size must be positive, got %d", batchSize)
+	}
+	plan := AccumulationPlan{BatchSize: batchSize, MicroBatchSize: batchSize, Steps: 1}
+	if budget == 0 || estimate.PerSample == 0 {
+		return plan, nil
+	}
+	if estimate.Fixed+estimate.PerSample > budget {
+		return AccumulationPlan{}, fmt.Errorf("%w: model needs %d bytes plus %d per sample, budget is %d", ErrInsufficientMemory, estimate.Fixed, estimate.PerSample, budget)
+	}
+	if fits := (budget - estimate.Fixed) / estimate.PerSample; fits < uint64(batchSize) {
+		plan.MicroBatchSize = int(fits)
+		plan.Steps = (batchSize + plan.MicroBatchSize - 1) / plan.MicroBatchSize
+	}
+	return plan, nil
+}
+
+// MicroBatchTrainer is implemented by trainers that can accumulate gradients
+// over micro-batches
+type MicroBatchTrainer interface {
+	Trainer
+	// MemoryEstimate returns the memory training takes for inputs of
+	// inputSize features
+	MemoryEstimate(inputSize int) MemoryEstimate
+	// SetMicroBatchSize bounds how many samples are held in memory at once;
+	// zero holds the whole batch
+	SetMicroBatchSize(n int)
+}
+
+// UsesBatchStatistics reports whether the spec normalizes over the batch
+func (s *ModelSpec) UsesBatchStatistics() bool {
+	for _, layer := range s.Layers {
+		if layer.Type == LayerBatchNorm {
+			return true
+		}
+	}
+	return false
+}
+
+// MemoryEstimate returns what training an MLP of the spec's layers takes
+// for inputs of inputSize features, or the zero estimate for other
+// families
+func (s *ModelSpec) MemoryEstimate(inputSize int) MemoryEstimate {
+	if s.Family != FamilyMLP || inputSize <= 0 || inputSize > MaxInputSize {
+		return MemoryEstimate{}
+	}
+	var params, activations uint64
+	widest := uint64(inputSize)
+	in := uint64(inputSize)
+	for _, layer := range s.Layers {
+		if layer.Type == LayerBatchNorm {
+			// A scale and shift per input, which it passes through
+			params += 2 * in
+			activations += in
+			continue
+		}
+		units := uint64(min(max(layer.Units, 0), MaxLayerUnits))
+		params += in*units + units
+		activations += units
+		widest = max(widest, units)
+		in = units
+	}
+	return MemoryEstimate{
+		// Weights and their accumulators, and the deltas of one sample
+		Fixed:     (2*params + 2*widest) * bytesPerValue,
+		PerSample: activations * bytesPerValue,
+	}
+}
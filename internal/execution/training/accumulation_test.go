@@ -0,0 +1,100 @@
+package training
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestPlanAccumulation(t *testing.T) {
+	estimate := MemoryEstimate{Fixed: 1000, PerSample: 100}
+	tests := []struct {
+		name      string
+		batchSize int
+		budget    uint64
+		want      AccumulationPlan
+		wantErr   error
+	}{
+		{"unlimited", 64, 0, AccumulationPlan{BatchSize: 64, MicroBatchSize: 64, Steps: 1}, nil},
+		{"batch fits", 10, 2000, AccumulationPlan{BatchSize: 10, MicroBatchSize: 10, Steps: 1}, nil},
+		{"split evenly", 20, 2000, AccumulationPlan{BatchSize: 20, MicroBatchSize: 10, Steps: 2}, nil},
+		{"split with remainder", 25, 2000, AccumulationPlan{BatchSize: 25, MicroBatchSize: 10, Steps: 3}, nil},
+		{"single sample", 8, 1100, AccumulationPlan{BatchSize: 8, MicroBatchSize: 1, Steps: 8}, nil},
+		{"no room for a sample", 8, 1099, AccumulationPlan{}, ErrInsufficientMemory},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PlanAccumulation(tt.batchSize, estimate, tt.budget)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("PlanAccumulation() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("PlanAccumulation() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("PlanAccumulation() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpecMemoryEstimate(t *testing.T) {
+	spec := parseSpec(t, `{"family":"mlp","layers":[{"type":"dense","units":4},{"type":"dense","units":1}]}`)
+	// 3*4+4 + 4*1+1 = 21 parameters, 5 activations, widest layer 4
+	want := MemoryEstimate{Fixed: (2*21 + 2*4) * 8, PerSample: 5 * 8}
+	if got := spec.MemoryEstimate(3); got != want {
+		t.Errorf("MemoryEstimate() = %+v, want %+v", got, want)
+	}
+	if spec.UsesBatchStatistics() {
+		t.Error("dense spec reported as using batch statistics")
+	}
+	bn := parseSpec(t, `{"family":"mlp","layers":[{"type":"dense","units":4},{"type":"batch_norm"},{"type":"dense","units":1}]}`)
+	if !bn.UsesBatchStatistics() {
+		t.Error("batch_norm spec not reported as using batch statistics")
+	}
+}
+
+// TestAccumulatedTrainingMatchesFullBatch trains the same network twice from
+// the same weights, once on whole batches and once on micro-batches that do
+// not divide them evenly
+func TestAccumulatedTrainingMatchesFullBatch(t *testing.T) {
+	spec := parseSpec(t, `{"family":"mlp","input_size":2,"layers":[{"type":"dense","units":6,"activation":"tanh"},{"type":"dense","units":1,"activation":"sigmoid"}]}`)
+	full := NewMLPTrainer(spec)
+	accumulated := NewMLPTrainer(spec)
+	for l, layer := range full.layers {
+		copy(accumulated.layers[l].weights, layer.weights)
+		copy(accumulated.layers[l].bias, layer.bias)
+	}
+	accumulated.SetMicroBatchSize(3)
+
+	features, labels := xorData()
+	_, fullLoss, fullAccuracy, err := full.Train(context.Background(), features, labels, 5, 16, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, accLoss, accAccuracy, err := accumulated.Train(context.Background(), features, labels, 5, 16, 0.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const tolerance = 1e-9
+	if math.Abs(fullLoss-accLoss) > tolerance || fullAccuracy != accAccuracy {
+		t.Errorf("accumulated loss %v, accuracy %v; full-batch %v, %v", accLoss, accAccuracy, fullLoss, fullAccuracy)
+	}
+	fullWeights, accWeights := full.GetModelWeights(), accumulated.GetModelWeights()
+	for key, want := range fullWeights {
+		got := accWeights[key]
+		if len(got) != len(want) {
+			t.Fatalf("%s has %d values, want %d", key, len(got), len(want))
+		}
+		for i := range want {
+			if math.Abs(got[i]-want[i]) > tolerance {
+				t.Errorf("%s[%d] = %v after accumulation, want %v", key, i, got[i], want[i])
+			}
+		}
+	}
+}
@@ -11,11 +11,16 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
 )
 
 // DataLoader handles loading training data from IPFS/Filecoin
 type DataLoader struct {
 	ipfsGateway string
+	client      *http.Client
+	cache       *DatasetCache
+	schema      *DatasetSchema
 }
 
 // PartitionConfig defines how to partition data for federated learning
@@ -35,9 +40,21 @@ func NewDataLoader(ipfsGateway string) *DataLoader {
 	}
 	return &DataLoader{
 		ipfsGateway: ipfsGateway,
+		client:      dualstack.Client(0),
 	}
 }
 
+// SetCache reads datasets through cache instead of fetching them every time
+func (d *DataLoader) SetCache(cache *DatasetCache) {
+	d.cache = cache
+}
+
+// SetSchema checks datasets against schema as they download, aborting
+// downloads that do not match it
+func (d *DataLoader) SetSchema(schema *DatasetSchema) {
+	d.schema = schema
+}
+
 // LoadData loads data from IPFS/Filecoin based on CID and format
 func (d *DataLoader) LoadData(ctx context.Context, cid string, format string) ([][]float64, []float64, error) {
 	return d.LoadPartitionedData(ctx, cid, format, nil)
@@ -45,27 +62,30 @@ func (d *DataLoader) LoadData(ctx context.Context, cid string, format string) ([
 
 // LoadPartitionedData loads and partitions data for federated learning
 func (d *DataLoader) LoadPartitionedData(ctx context.Context, cid string, format string, partitionConfig *PartitionConfig) ([][]float64, []float64, error) {
-	url := d.ipfsGateway + cid
-
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create request: %w", err)
+	fetch := d.fetch
+	if d.schema != nil {
+		fetch = func(ctx context.Context, cid string) (io.ReadCloser, error) {
+			body, err := d.fetch(ctx, cid)
+			if err != nil {
+				return nil, err
+			}
+			return newValidatingReader(body, format, d.schema), nil
+		}
 	}
-
-	resp, err := http.DefaultClient.Do(req)
+	body, err := d.cache.Open(ctx, cid, fetch)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to fetch data: %w", err)
+		return nil, nil, err
 	}
-	defer resp.Body.Close()
+	defer body.Close()
 
 	var features [][]float64
 	var labels []float64
 
 	switch strings.ToLower(format) {
 	case "csv":
-		features, labels, err = d.parseCSV(resp.Body)
+		features, labels, err = d.parseCSV(body)
 	case "json":
-		features, labels, err = d.parseJSON(resp.Body)
+		features, labels, err = d.parseJSON(body)
 	default:
 		return nil, nil, fmt.Errorf("unsupported data format: %s", format)
 	}
@@ -82,6 +102,28 @@ func (d *DataLoader) LoadPartitionedData(ctx context.Context, cid string, format
 	return features, labels, nil
 }
 
+func (d *DataLoader) fetch(ctx context.Context, cid string) (io.ReadCloser, error) {
+	if f := d.cache.fetcher(); f != nil {
+		return f.Open(ctx, cid)
+	}
+	url := d.ipfsGateway + cid
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch data: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to fetch data: status code %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
 func (d *DataLoader) partitionData(features [][]float64, labels []float64, config *PartitionConfig) ([][]float64, []float64, error) {
 	if config.TotalParts <= 0 || config.PartIndex < 0 || config.PartIndex >= config.TotalParts {
 		return nil, nil, fmt.Errorf("invalid partition configuration")
@@ -388,19 +430,29 @@ func (d *DataLoader) labelSkewPartition(features [][]float64, labels []float64,
 }
 
 func (d *DataLoader) parseCSV(r io.Reader) ([][]float64, []float64, error) {
+	schema := d.schema
+	if schema == nil {
+		schema = &DatasetSchema{}
+	}
 	csvReader := csv.NewReader(r)
+	csvReader.FieldsPerRecord = -1
 
-	// Skip header
-	if _, err := csvReader.Read(); err != nil {
+	header, err := csvReader.Read()
+	if err != nil {
 		return nil, nil, fmt.Errorf("failed to read CSV header: %w", err)
 	}
+	layout, err := newCSVLayout(header, schema)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	var features [][]float64
 	var labels []float64
 	labelMap := make(map[string]float64)
 	nextLabelValue := 0.0
 
-	for {
+	for row := 1; ; row++ {
+		offset := csvReader.InputOffset()
 		record, err := csvReader.Read()
 		if err == io.EOF {
 			break
@@ -409,18 +461,12 @@ func (d *DataLoader) parseCSV(r io.Reader) ([][]float64, []float64, error) {
 			return nil, nil, fmt.Errorf("failed to read CSV record: %w", err)
 		}
 
-		// Last column is assumed to be the label
-		featureVals := make([]float64, len(record)-1)
-		for i := 0; i < len(record)-1; i++ {
-			val, err := strconv.ParseFloat(record[i], 64)
-			if err != nil {
-				return nil, nil, fmt.Errorf("failed to parse feature value: %w", err)
-			}
-			featureVals[i] = val
+		featureVals, labelStr, reason := layout.parse(row, record)
+		if reason != "" {
+			return nil, nil, &DatasetError{Offset: offset, Reason: reason}
 		}
 
 		// Handle label - try to parse as float first, if that fails treat as categorical
-		labelStr := record[len(record)-1]
 		var label float64
 
 		if labelValue, err := strconv.ParseFloat(labelStr, 64); err == nil {
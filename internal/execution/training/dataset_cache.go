@@ -0,0 +1,180 @@
+package training
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/fsck"
+	"github.com/theblitlabs/parity-runner/internal/ipfsfetch"
+)
+
+// cacheableCID matches CIDs safe to use as file names; datasets referenced
+// any other way are fetched without caching
+var cacheableCID = regexp.MustCompile(`^[A-Za-z0-9]{8,128}$`)
+
+// DatasetCache keeps fetched datasets on disk by CID. Content addressing
+// means a cached copy never goes stale, so repeated FL rounds over the same
+// dataset skip the download. A copy damaged on disk does not match the
+// checksum recorded when it was stored and is fetched again.
+type DatasetCache struct {
+	dir       string
+	checksums *caches.Checksums
+	// ipfs fetches datasets in place of the loaders' gateway
+	ipfs *ipfsfetch.Fetcher
+}
+
+// OpenDatasetCache uses dir for cached datasets, creating it when missing
+func OpenDatasetCache(dir string) (*DatasetCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create dataset cache directory: %w", err)
+	}
+	return &DatasetCache{dir: dir, checksums: caches.OpenChecksums(dir, caches.Datasets)}, nil
+}
+
+// SetQuarantine moves cached datasets that fail verification into q instead
+// of deleting them
+func (c *DatasetCache) SetQuarantine(q *fsck.Quarantine) {
+	c.checksums.SetQuarantine(q)
+}
+
+// SetIPFSFetcher has loaders reading through the cache fetch datasets
+// through f, verified against their CID, instead of from their gateway
+func (c *DatasetCache) SetIPFSFetcher(f *ipfsfetch.Fetcher) {
+	c.ipfs = f
+}
+
+func (c *DatasetCache) fetcher() *ipfsfetch.Fetcher {
+	if c == nil {
+		return nil
+	}
+	return c.ipfs
+}
+
+func (c *DatasetCache) path(cid string) string {
+	return filepath.Join(c.dir, cid)
+}
+
+// Open returns the dataset cid, calling fetch and caching its content when
+// it is not on disk yet
+func (c *DatasetCache) Open(ctx context.Context, cid string, fetch func(context.Context, string) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	if c == nil || !cacheableCID.MatchString(cid) {
+		return fetch(ctx, cid)
+	}
+
+	path := c.path(cid)
+	if _, err := os.Stat(path); err == nil {
+		if _, err := c.checksums.Verify(cid); err == nil {
+			if f, err := os.Open(path); err == nil {
+				now := time.Now()
+				_ = os.Chtimes(path, now, now)
+				return f, nil
+			}
+		} else {
+			log := gologger.WithComponent("dataset_cache")
+			log.Warn().Err(err).Str("cid", cid).Msg("Discarding cached dataset that failed verification")
+			if err := c.checksums.Discard(cid); err != nil {
+				return nil, fmt.Errorf("failed to discard cached dataset: %w", err)
+			}
+		}
+	}
+
+	body, err := fetch(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	tmp, err := os.CreateTemp(c.dir, "."+cid+".*.partial")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dataset cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	if err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("failed to download dataset: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write dataset cache file: %w", err)
+	}
+	// Recorded ahead of the rename, so that a crash never leaves an entry
+	// without its checksum
+	if err := c.checksums.Record(cid, caches.Checksum{SHA256: hex.EncodeToString(hash.Sum(nil)), Size: size}); err != nil {
+		return nil, fmt.Errorf("failed to index cached dataset: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("failed to store dataset in cache: %w", err)
+	}
+	return os.Open(path)
+}
+
+// Entries lists the cached datasets, using modification time as last use
+func (c *DatasetCache) Entries(ctx context.Context) ([]caches.Entry, error) {
+	dirEntries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dataset cache: %w", err)
+	}
+
+	var entries []caches.Entry
+	for _, de := range dirEntries {
+		if !de.Type().IsRegular() || !cacheableCID.MatchString(de.Name()) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, caches.Entry{
+			Key:      de.Name(),
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		})
+	}
+	return entries, nil
+}
+
+func (c *DatasetCache) Has(ctx context.Context, cid string) (bool, error) {
+	if !cacheableCID.MatchString(cid) {
+		return false, nil
+	}
+	_, err := os.Stat(c.path(cid))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (c *DatasetCache) Remove(ctx context.Context, cid string) error {
+	if !cacheableCID.MatchString(cid) {
+		return fmt.Errorf("invalid dataset CID %q", cid)
+	}
+	if err := os.Remove(c.path(cid)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove cached dataset: %w", err)
+	}
+	return c.checksums.Forget(cid)
+}
+
+// Fsck removes partial downloads and cross-checks the cache against its
+// index. A CID cannot be checked locally, so datasets the index does not
+// cover are quarantined like damaged ones and fetched again when next used.
+func (c *DatasetCache) Fsck(q *fsck.Quarantine) ([]fsck.Finding, error) {
+	findings, err := fsck.RemoveLeftovers(caches.Datasets, c.dir, ".*.partial")
+	if err != nil {
+		return findings, err
+	}
+	found, err := c.checksums.Check(q, cacheableCID.MatchString, nil)
+	return append(findings, found...), err
+}
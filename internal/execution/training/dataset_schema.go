@@ -0,0 +1,309 @@
+package training
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// defaultSampleRows is how many rows of a dataset are checked as it
+// downloads when its schema does not say
+const defaultSampleRows = 100
+
+// MaxSampleRows bounds DatasetSchema.SampleRows
+const MaxSampleRows = 100000
+
+// maxSchemaFeatures bounds DatasetSchema.NumFeatures
+const maxSchemaFeatures = 1 << 20
+
+// DatasetSchema declares what a task's dataset holds. The header and the
+// first rows of the dataset are checked against it as they download, so
+// that a dataset without its target column is rejected after a few bytes
+// rather than gigabytes; the rest is checked as it is parsed.
+type DatasetSchema struct {
+	// Target names the CSV column holding the labels, the last column when
+	// empty
+	Target string `json:"target,omitempty"`
+	// NumFeatures is how many features each row holds; when 0, any number
+	// as long as it is the same in every row
+	NumFeatures int `json:"num_features,omitempty"`
+	// SampleRows is how many rows are checked as the dataset downloads
+	SampleRows int `json:"sample_rows,omitempty"`
+}
+
+// Validate checks the schema's values are in range
+func (s *DatasetSchema) Validate() error {
+	if s.NumFeatures < 0 || s.NumFeatures > maxSchemaFeatures {
+		return fmt.Errorf("num_features must be between 0 and %d", maxSchemaFeatures)
+	}
+	if s.SampleRows < 0 || s.SampleRows > MaxSampleRows {
+		return fmt.Errorf("sample_rows must be between 0 and %d", MaxSampleRows)
+	}
+	return nil
+}
+
+func (s *DatasetSchema) sampleRows() int {
+	if s.SampleRows > 0 {
+		return s.SampleRows
+	}
+	return defaultSampleRows
+}
+
+// DatasetError is a dataset that does not match its schema
+type DatasetError struct {
+	// Offset is the byte offset of the row that does not match
+	Offset int64
+	Reason string
+}
+
+func (e *DatasetError) Error() string {
+	return fmt.Sprintf("dataset does not match its schema at byte %d: %s", e.Offset, e.Reason)
+}
+
+// csvLayout is where a CSV dataset keeps its features and labels, read
+// from its header
+type csvLayout struct {
+	columns []string
+	target  int
+}
+
+func newCSVLayout(header []string, schema *DatasetSchema) (csvLayout, error) {
+	layout := csvLayout{columns: append([]string(nil), header...), target: len(header) - 1}
+	if len(header) < 2 {
+		return layout, &DatasetError{Reason: fmt.Sprintf("header has %d columns, need at least a feature and the target", len(header))}
+	}
+	if schema.Target != "" {
+		layout.target = -1
+		for i, column := range header {
+			if strings.TrimSpace(column) == schema.Target {
+				layout.target = i
+				break
+			}
+		}
+		if layout.target < 0 {
+			return layout, &DatasetError{Reason: fmt.Sprintf("target column %q is not in the header", schema.Target)}
+		}
+	}
+	if schema.NumFeatures > 0 && len(header)-1 != schema.NumFeatures {
+		return layout, &DatasetError{Reason: fmt.Sprintf("header has %d feature columns, want %d", len(header)-1, schema.NumFeatures)}
+	}
+	return layout, nil
+}
+
+// parse returns the features and the target of a row, or why they cannot
+// be read
+func (l csvLayout) parse(row int, record []string) ([]float64, string, string) {
+	if len(record) != len(l.columns) {
+		return nil, "", fmt.Sprintf("row %d has %d columns, the header %d", row, len(record), len(l.columns))
+	}
+	features := make([]float64, 0, len(record)-1)
+	for i, value := range record {
+		if i == l.target {
+			continue
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, "", fmt.Sprintf("row %d: feature %q is not a number: %q", row, l.columns[i], value)
+		}
+		features = append(features, f)
+	}
+	target := strings.TrimSpace(record[l.target])
+	if target == "" {
+		return nil, "", fmt.Sprintf("row %d has no %q value", row, l.columns[l.target])
+	}
+	return features, target, ""
+}
+
+// validatingReader passes a dataset through as it downloads while its
+// header and first rows are checked against the schema. Once they do not
+// match, reads fail with the DatasetError, so that whoever is downloading
+// stops and closes the body.
+type validatingReader struct {
+	body     io.ReadCloser
+	pw       *io.PipeWriter
+	result   chan error
+	checking bool
+	err      error
+}
+
+func newValidatingReader(body io.ReadCloser, format string, schema *DatasetSchema) io.ReadCloser {
+	pr, pw := io.Pipe()
+	v := &validatingReader{body: body, pw: pw, result: make(chan error, 1), checking: true}
+	go func() {
+		err := sampleDataset(pr, format, schema)
+		// Unblocks the writer once the sample is checked
+		pr.Close()
+		v.result <- err
+	}()
+	return v
+}
+
+func (v *validatingReader) Read(p []byte) (int, error) {
+	if v.err != nil {
+		return 0, v.err
+	}
+	n, err := v.body.Read(p)
+	if v.checking {
+		if n > 0 {
+			if _, werr := v.pw.Write(p[:n]); werr != nil {
+				v.finish()
+			}
+		}
+		if err != nil && v.checking {
+			v.pw.CloseWithError(err)
+			v.finish()
+		}
+		if v.err != nil {
+			return 0, v.err
+		}
+	}
+	return n, err
+}
+
+// finish waits for the sample's verdict
+func (v *validatingReader) finish() {
+	v.checking = false
+	var mismatch *DatasetError
+	if err := <-v.result; errors.As(err, &mismatch) {
+		v.err = err
+	}
+}
+
+func (v *validatingReader) Close() error {
+	if v.checking {
+		v.pw.CloseWithError(io.ErrClosedPipe)
+		v.finish()
+	}
+	return v.body.Close()
+}
+
+// sampleDataset checks the header and first rows of a dataset read from r
+// against schema, returning a DatasetError when they do not match
+func sampleDataset(r io.Reader, format string, schema *DatasetSchema) error {
+	switch strings.ToLower(format) {
+	case "csv":
+		return sampleCSV(r, schema)
+	case "json":
+		return sampleJSON(r, schema)
+	}
+	return nil
+}
+
+func sampleCSV(r io.Reader, schema *DatasetSchema) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return &DatasetError{Reason: "dataset is empty"}
+	}
+	if err != nil {
+		return malformed(0, "failed to read CSV header", err)
+	}
+	layout, err := newCSVLayout(header, schema)
+	if err != nil {
+		return err
+	}
+	for row := 1; row <= schema.sampleRows(); row++ {
+		offset := reader.InputOffset()
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return malformed(offset, fmt.Sprintf("row %d", row), err)
+		}
+		if _, _, reason := layout.parse(row, record); reason != "" {
+			return &DatasetError{Offset: offset, Reason: reason}
+		}
+	}
+	return nil
+}
+
+func sampleJSON(r io.Reader, schema *DatasetSchema) error {
+	dec := json.NewDecoder(r)
+	tok, err := dec.Token()
+	if err != nil {
+		return malformed(0, "invalid JSON", err)
+	}
+	if tok != json.Delim('{') {
+		return &DatasetError{Reason: "dataset is not a JSON object"}
+	}
+	for dec.More() {
+		offset := dec.InputOffset()
+		key, err := dec.Token()
+		if err != nil {
+			return malformed(offset, "invalid JSON", err)
+		}
+		if key != "features" {
+			if err := skipJSONValue(dec); err != nil {
+				return malformed(offset, "invalid JSON", err)
+			}
+			continue
+		}
+		tok, err := dec.Token()
+		if err != nil {
+			return malformed(offset, "invalid JSON", err)
+		}
+		if tok != json.Delim('[') {
+			return &DatasetError{Offset: offset, Reason: "features is not an array"}
+		}
+		width := schema.NumFeatures
+		for row := 1; row <= schema.sampleRows() && dec.More(); row++ {
+			offset := dec.InputOffset()
+			var features []float64
+			if err := dec.Decode(&features); err != nil {
+				var typeErr *json.UnmarshalTypeError
+				if errors.As(err, &typeErr) {
+					return &DatasetError{Offset: offset, Reason: fmt.Sprintf("row %d: features are not all numbers", row)}
+				}
+				return malformed(offset, fmt.Sprintf("row %d", row), err)
+			}
+			if width == 0 {
+				width = len(features)
+			}
+			if len(features) != width {
+				return &DatasetError{Offset: offset, Reason: fmt.Sprintf("row %d has %d features, want %d", row, len(features), width)}
+			}
+		}
+		return nil
+	}
+	return &DatasetError{Offset: dec.InputOffset(), Reason: "dataset has no features"}
+}
+
+// malformed returns err as a DatasetError at offset when it is a syntax
+// error in the dataset, and as is when reading the dataset failed
+func malformed(offset int64, context string, err error) error {
+	var csvErr *csv.ParseError
+	var jsonErr *json.SyntaxError
+	if errors.As(err, &csvErr) || errors.As(err, &jsonErr) {
+		return &DatasetError{Offset: offset, Reason: fmt.Sprintf("%s: %v", context, err)}
+	}
+	return err
+}
+
+// skipJSONValue reads past the next value without keeping it, since the
+// values before the features may be as large as the dataset
+func skipJSONValue(dec *json.Decoder) error {
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
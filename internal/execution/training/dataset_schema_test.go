@@ -0,0 +1,203 @@
+package training
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// streamSize is how much a gateway streams of a dataset unless the
+// download is aborted
+const streamSize = 16 << 20
+
+// streamingGateway serves a CSV dataset of header and rows, with row bad
+// (1-based, 0 for none) replaced by a row whose second feature is not a
+// number, followed by good rows until streamSize bytes. It reports how many
+// bytes it sent.
+func streamingGateway(t *testing.T, header string, bad int) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+	var sent atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+		n, err := fmt.Fprintf(w, "%s\n", header)
+		sent.Add(int64(n))
+		if err != nil {
+			return
+		}
+		for row := 1; sent.Load() < streamSize; row++ {
+			line := fmt.Sprintf("%d.5,%d,%s\n", row%10, row%7, []string{"cat", "dog"}[row%2])
+			if row == bad {
+				line = fmt.Sprintf("%d.5,n/a,cat\n", row%10)
+			}
+			n, err := w.Write([]byte(line))
+			sent.Add(int64(n))
+			if err != nil {
+				return
+			}
+			if row%64 == 0 {
+				flusher.Flush()
+			}
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &sent
+}
+
+// rowOffset is where row (1-based) of a dataset served by streamingGateway
+// starts
+func rowOffset(header string, row int) int64 {
+	offset := int64(len(header) + 1)
+	for r := 1; r < row; r++ {
+		offset += int64(len(fmt.Sprintf("%d.5,%d,%s\n", r%10, r%7, []string{"cat", "dog"}[r%2])))
+	}
+	return offset
+}
+
+func newSchemaLoader(t *testing.T, srv *httptest.Server, schema *DatasetSchema) (*DataLoader, *DatasetCache, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cache, err := OpenDatasetCache(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader := NewDataLoader(srv.URL + "/ipfs/")
+	loader.SetCache(cache)
+	loader.SetSchema(schema)
+	return loader, cache, dir
+}
+
+// assertNothingCached fails unless the cache holds neither datasets nor
+// partial downloads
+func assertNothingCached(t *testing.T, cache *DatasetCache, dir string) {
+	t.Helper()
+	entries, err := cache.Entries(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("cache holds %+v after an aborted download", entries)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".partial") {
+			t.Errorf("partial download %s left in the cache", f.Name())
+		}
+	}
+}
+
+func TestDatasetAbortedAtHeader(t *testing.T) {
+	srv, sent := streamingGateway(t, "petal_length,petal_width,species", 0)
+	loader, cache, dir := newSchemaLoader(t, srv, &DatasetSchema{Target: "label"})
+
+	_, _, err := loader.LoadData(context.Background(), "bafyheader0001", "csv")
+	var mismatch *DatasetError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("LoadData() error = %v, want a DatasetError", err)
+	}
+	if mismatch.Offset != 0 || !strings.Contains(mismatch.Reason, `target column "label"`) {
+		t.Errorf("DatasetError = %+v, want the missing target at byte 0", mismatch)
+	}
+	srv.Close()
+	if sent.Load() >= streamSize {
+		t.Errorf("gateway sent the whole dataset, %d bytes", sent.Load())
+	}
+	assertNothingCached(t, cache, dir)
+}
+
+func TestDatasetAbortedAtRow(t *testing.T) {
+	header := "petal_length,petal_width,species"
+	srv, sent := streamingGateway(t, header, 3)
+	loader, cache, dir := newSchemaLoader(t, srv, &DatasetSchema{Target: "species", NumFeatures: 2})
+
+	_, _, err := loader.LoadData(context.Background(), "bafyrow3000001", "csv")
+	var mismatch *DatasetError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("LoadData() error = %v, want a DatasetError", err)
+	}
+	if want := rowOffset(header, 3); mismatch.Offset != want || !strings.Contains(mismatch.Reason, `row 3: feature "petal_width" is not a number`) {
+		t.Errorf("DatasetError = %+v, want row 3's petal_width at byte %d", mismatch, want)
+	}
+	if !strings.Contains(err.Error(), fmt.Sprintf("at byte %d", mismatch.Offset)) {
+		t.Errorf("error %q does not name the byte offset", err)
+	}
+	srv.Close()
+	if sent.Load() >= streamSize {
+		t.Errorf("gateway sent the whole dataset, %d bytes", sent.Load())
+	}
+	assertNothingCached(t, cache, dir)
+}
+
+func TestDatasetMismatchAfterSampleCaughtByParse(t *testing.T) {
+	header := "petal_length,petal_width,species"
+	srv, sent := streamingGateway(t, header, 12)
+	loader, _, _ := newSchemaLoader(t, srv, &DatasetSchema{Target: "species", SampleRows: 10})
+
+	_, _, err := loader.LoadData(context.Background(), "bafyrow1200001", "csv")
+	var mismatch *DatasetError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("LoadData() error = %v, want a DatasetError", err)
+	}
+	if want := rowOffset(header, 12); mismatch.Offset != want || !strings.Contains(mismatch.Reason, "row 12:") {
+		t.Errorf("DatasetError = %+v, want row 12 at byte %d", mismatch, want)
+	}
+	srv.Close()
+	if sent.Load() < streamSize {
+		t.Errorf("gateway sent %d bytes, want the sampled rows to pass and the whole dataset downloaded", sent.Load())
+	}
+}
+
+func TestDatasetSchemaSelectsTarget(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "species,petal_length,petal_width\ncat,1.5,0.2\ndog, 4.5 ,1.3\n")
+	}))
+	defer srv.Close()
+	loader, _, _ := newSchemaLoader(t, srv, &DatasetSchema{Target: "species", NumFeatures: 2})
+
+	features, labels, err := loader.LoadData(context.Background(), "bafytarget0001", "csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(features) != 2 || features[1][0] != 4.5 || features[1][1] != 1.3 || labels[0] != 0 || labels[1] != 1 {
+		t.Errorf("LoadData() = %v, %v, want the species column as labels", features, labels)
+	}
+}
+
+func TestSampleJSONDataset(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		dataset string
+		schema  DatasetSchema
+		offset  int64
+		reason  string
+	}{
+		{"valid", `{"labels": [0, 1], "features": [[1, 2], [3, 4]]}`, DatasetSchema{NumFeatures: 2}, 0, ""},
+		{"not an object", `[[1, 2]]`, DatasetSchema{}, 0, "not a JSON object"},
+		{"no features", `{"labels": [0, 1]}`, DatasetSchema{}, 17, "no features"},
+		{"wrong width", `{"features": [[1, 2], [3]]}`, DatasetSchema{}, 20, "row 2 has 1 features, want 2"},
+		{"declared width", `{"features": [[1, 2]]}`, DatasetSchema{NumFeatures: 3}, 14, "row 1 has 2 features, want 3"},
+		{"not numbers", `{"features": [[1, 2], [3, "x"]]}`, DatasetSchema{}, 20, "row 2: features are not all numbers"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := sampleDataset(strings.NewReader(tt.dataset), "json", &tt.schema)
+			if tt.reason == "" {
+				if err != nil {
+					t.Fatalf("sampleDataset() error = %v", err)
+				}
+				return
+			}
+			var mismatch *DatasetError
+			if !errors.As(err, &mismatch) || mismatch.Offset != tt.offset || !strings.Contains(mismatch.Reason, tt.reason) {
+				t.Fatalf("sampleDataset() error = %v, want %q at byte %d", err, tt.reason, tt.offset)
+			}
+		})
+	}
+}
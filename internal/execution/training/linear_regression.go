@@ -8,9 +8,11 @@ import (
 	"time"
 )
 
-// LinearRegressionTrainer implements linear regression training
+// LinearRegressionTrainer implements linear regression training, or
+// logistic regression when logistic is set
 type LinearRegressionTrainer struct {
 	inputSize     int
+	logistic      bool
 	weights       []float64
 	dataLoader    *DataLoader
 	lastGradients map[string][]float64 // Store gradients from last training step
@@ -19,17 +21,27 @@ type LinearRegressionTrainer struct {
 // NewLinearRegressionTrainer creates a new linear regression trainer
 func NewLinearRegressionTrainer(config map[string]interface{}) (*LinearRegressionTrainer, error) {
 	inputSize, _ := config["input_size"].(float64)
-	if inputSize == 0 {
+	if inputSize < 1 || inputSize > MaxInputSize {
 		return nil, fmt.Errorf("invalid input size")
 	}
 
+	return newLinearTrainer(int(inputSize), false), nil
+}
+
+func newLinearTrainer(inputSize int, logistic bool) *LinearRegressionTrainer {
 	trainer := &LinearRegressionTrainer{
-		inputSize:  int(inputSize),
+		inputSize:  inputSize,
+		logistic:   logistic,
 		dataLoader: NewDataLoader(""),
 	}
 
 	trainer.initializeWeights()
-	return trainer, nil
+	return trainer
+}
+
+// SetDatasetCache makes LoadData read datasets through cache
+func (t *LinearRegressionTrainer) SetDatasetCache(cache *DatasetCache) {
+	t.dataLoader.SetCache(cache)
 }
 
 // LoadData loads training data from IPFS/Filecoin
@@ -55,6 +67,9 @@ func (t *LinearRegressionTrainer) Train(ctx context.Context, features [][]float6
 		indices := rand.Perm(totalSamples)
 
 		for i := 0; i < totalSamples; i += batchSize {
+			if err := ctx.Err(); err != nil {
+				return nil, 0, 0, err
+			}
 			batchEnd := min(i+batchSize, totalSamples)
 			batchSize := batchEnd - i
 
@@ -69,10 +84,10 @@ func (t *LinearRegressionTrainer) Train(ctx context.Context, features [][]float6
 				// Forward pass
 				prediction := t.forward(features[idx])
 
-				// Compute loss
+				// Compute loss; with a sigmoid output, log loss has the
+				// same gradient as squared error has without one
 				diff := prediction - labels[idx]
-				loss := 0.5 * diff * diff
-				batchLoss += loss
+				batchLoss += t.loss(prediction, labels[idx])
 
 				// Compute gradients
 				gradients[0] += diff // bias gradient
@@ -106,10 +121,33 @@ func (t *LinearRegressionTrainer) forward(input []float64) float64 {
 	for i := 0; i < t.inputSize; i++ {
 		prediction += input[i] * t.weights[i+1]
 	}
+	if t.logistic {
+		return 1 / (1 + math.Exp(-prediction))
+	}
 	return prediction
 }
 
+func (t *LinearRegressionTrainer) loss(prediction, label float64) float64 {
+	if !t.logistic {
+		diff := prediction - label
+		return 0.5 * diff * diff
+	}
+	const eps = 1e-12
+	p := math.Min(math.Max(prediction, eps), 1-eps)
+	return -(label*math.Log(p) + (1-label)*math.Log(1-p))
+}
+
 func (t *LinearRegressionTrainer) computeAccuracy(features [][]float64, labels []float64) float64 {
+	if t.logistic {
+		correct := 0
+		for i := range features {
+			if (t.forward(features[i]) > 0.5) == (labels[i] > 0.5) {
+				correct++
+			}
+		}
+		return float64(correct) / float64(len(features))
+	}
+
 	meanLabel := 0.0
 
 	// Compute mean label value
@@ -150,16 +188,29 @@ func (t *LinearRegressionTrainer) initializeWeights() {
 // GetModelWeights returns the current model weights as a map
 func (t *LinearRegressionTrainer) GetModelWeights() map[string][]float64 {
 	weights := make(map[string][]float64)
-	weights["linear_weights"] = append([]float64(nil), t.weights...)
+	weights[t.weightKey()] = append([]float64(nil), t.weights...)
 	return weights
 }
 
+// SetModelWeights replaces the model's weights with weights
+func (t *LinearRegressionTrainer) SetModelWeights(weights map[string][]float64) error {
+	values, ok := weights[t.weightKey()]
+	if !ok || len(weights) != 1 {
+		return fmt.Errorf("weights must hold exactly the layer %s", t.weightKey())
+	}
+	if len(values) != len(t.weights) {
+		return fmt.Errorf("layer %s has %d weights, want %d", t.weightKey(), len(values), len(t.weights))
+	}
+	copy(t.weights, values)
+	return nil
+}
+
 // GetGradients returns the gradients from the last training step
 func (t *LinearRegressionTrainer) GetGradients() map[string][]float64 {
 	if t.lastGradients == nil {
 		// Return zero gradients with proper structure
 		gradients := make(map[string][]float64)
-		gradients["linear_weights"] = make([]float64, len(t.weights))
+		gradients[t.weightKey()] = make([]float64, len(t.weights))
 		return gradients
 	}
 
@@ -170,3 +221,10 @@ func (t *LinearRegressionTrainer) GetGradients() map[string][]float64 {
 	}
 	return gradientsCopy
 }
+
+func (t *LinearRegressionTrainer) weightKey() string {
+	if t.logistic {
+		return "logistic_weights"
+	}
+	return "linear_weights"
+}
@@ -0,0 +1,408 @@
+package training
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+// MLPTrainer trains a feed-forward network whose layers a ModelSpec
+// describes
+type MLPTrainer struct {
+	spec          *ModelSpec
+	inputSize     int
+	layers        []*denseLayer
+	dataLoader    *DataLoader
+	lastGradients map[string][]float64 // Store gradients from last training step
+	// microBatchSize bounds the samples whose activations are held at once;
+	// zero holds the whole batch
+	microBatchSize int
+}
+
+// denseLayer is a fully connected layer; weights[i*out+j] connects input i
+// to unit j
+type denseLayer struct {
+	spec    LayerSpec
+	in, out int
+	weights []float64
+	bias    []float64
+}
+
+// NewMLPTrainer creates a trainer for the MLP spec describes. The input size
+// is taken from the spec or, when it has none, from the training data.
+func NewMLPTrainer(spec *ModelSpec) *MLPTrainer {
+	trainer := &MLPTrainer{
+		spec:       spec.normalized(),
+		dataLoader: NewDataLoader(""),
+	}
+	if spec.InputSize > 0 {
+		trainer.build(spec.InputSize)
+	}
+	return trainer
+}
+
+// SetDatasetCache makes LoadData read datasets through cache
+func (t *MLPTrainer) SetDatasetCache(cache *DatasetCache) {
+	t.dataLoader.SetCache(cache)
+}
+
+// SetMicroBatchSize splits each batch into micro-batches of at most n
+// samples, accumulating their gradients before the batch's optimizer step
+func (t *MLPTrainer) SetMicroBatchSize(n int) {
+	t.microBatchSize = n
+}
+
+// MemoryEstimate returns what training takes for inputs of inputSize
+// features
+func (t *MLPTrainer) MemoryEstimate(inputSize int) MemoryEstimate {
+	return t.spec.MemoryEstimate(inputSize)
+}
+
+// LoadData loads training data from IPFS/Filecoin
+func (t *MLPTrainer) LoadData(ctx context.Context, datasetCID string, format string) ([][]float64, []float64, error) {
+	return t.LoadPartitionedData(ctx, datasetCID, format, nil)
+}
+
+func (t *MLPTrainer) LoadPartitionedData(ctx context.Context, datasetCID string, format string, partitionConfig *PartitionConfig) ([][]float64, []float64, error) {
+	features, labels, err := t.dataLoader.LoadPartitionedData(ctx, datasetCID, format, partitionConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(features) == 0 {
+		return nil, nil, fmt.Errorf("no data loaded")
+	}
+	if err := t.ensureInputSize(len(features[0])); err != nil {
+		return nil, nil, err
+	}
+	return features, labels, nil
+}
+
+// ensureInputSize builds the layers for inputs of size n, or checks n
+// against the size they were built for
+func (t *MLPTrainer) ensureInputSize(n int) error {
+	if t.layers == nil {
+		if n <= 0 {
+			return fmt.Errorf("invalid input size: %d", n)
+		}
+		t.build(n)
+		return nil
+	}
+	if n != t.inputSize {
+		return fmt.Errorf("feature size mismatch: model expects %d, got %d", t.inputSize, n)
+	}
+	return nil
+}
+
+func (t *MLPTrainer) build(inputSize int) {
+	t.inputSize = inputSize
+	t.layers = make([]*denseLayer, len(t.spec.Layers))
+	in := inputSize
+	for i, spec := range t.spec.Layers {
+		layer := &denseLayer{
+			spec:    spec,
+			in:      in,
+			out:     spec.Units,
+			weights: make([]float64, in*spec.Units),
+			bias:    make([]float64, spec.Units),
+		}
+		// Xavier/Glorot initialization
+		limit := math.Min(math.Sqrt(6.0/float64(in+spec.Units)), 1.0)
+		for k := range layer.weights {
+			layer.weights[k] = (rand.Float64()*2 - 1) * limit
+		}
+		for k := range layer.bias {
+			layer.bias[k] = (rand.Float64() - 0.5) * 0.02
+		}
+		t.layers[i] = layer
+		in = spec.Units
+	}
+}
+
+// Train performs training using backpropagation
+func (t *MLPTrainer) Train(ctx context.Context, features [][]float64, labels []float64, epochs int, batchSize int, learningRate float64) ([]float64, float64, float64, error) {
+	if len(features) == 0 || len(labels) == 0 {
+		return nil, 0, 0, fmt.Errorf("empty training data")
+	}
+	if len(features) != len(labels) {
+		return nil, 0, 0, fmt.Errorf("feature and label count mismatch")
+	}
+	if learningRate <= 0 || learningRate > 1.0 {
+		return nil, 0, 0, fmt.Errorf("learning rate must be in (0, 1], got %f", learningRate)
+	}
+	if batchSize <= 0 {
+		return nil, 0, 0, fmt.Errorf("batch size must be positive, got %d", batchSize)
+	}
+	if err := t.ensureInputSize(len(features[0])); err != nil {
+		return nil, 0, 0, err
+	}
+
+	numSamples := len(features)
+	var finalLoss, finalAccuracy float64
+	for epoch := 0; epoch < epochs; epoch++ {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, 0, err
+		}
+		totalLoss := 0.0
+		correct := 0
+		for start := 0; start < numSamples; start += batchSize {
+			if err := ctx.Err(); err != nil {
+				return nil, 0, 0, err
+			}
+			end := min(start+batchSize, numSamples)
+			batchLoss, batchCorrect := t.trainBatch(features[start:end], labels[start:end], learningRate)
+			if math.IsNaN(batchLoss) || math.IsInf(batchLoss, 0) {
+				return nil, 0, 0, fmt.Errorf("training produced NaN/Inf loss at epoch %d, batch %d - this indicates numerical instability", epoch, start/batchSize)
+			}
+			totalLoss += batchLoss
+			correct += batchCorrect
+		}
+		finalLoss = totalLoss / float64(numSamples)
+		finalAccuracy = float64(correct) / float64(numSamples)
+	}
+
+	// As with the other trainers, the weights are sent as the update
+	t.lastGradients = t.GetModelWeights()
+
+	var flattened []float64
+	for _, key := range t.spec.WeightKeys() {
+		flattened = append(flattened, t.lastGradients[key]...)
+	}
+	return flattened, finalLoss, finalAccuracy, nil
+}
+
+// trainBatch takes one optimizer step on the batch, summing the gradients
+// of its micro-batches first
+func (t *MLPTrainer) trainBatch(features [][]float64, labels []float64, learningRate float64) (float64, int) {
+	gradWeights := make([][]float64, len(t.layers))
+	gradBias := make([][]float64, len(t.layers))
+	for l, layer := range t.layers {
+		gradWeights[l] = make([]float64, len(layer.weights))
+		gradBias[l] = make([]float64, len(layer.bias))
+	}
+
+	micro := t.microBatchSize
+	if micro <= 0 || micro > len(features) {
+		micro = len(features)
+	}
+	totalLoss := 0.0
+	correct := 0
+	for start := 0; start < len(features); start += micro {
+		end := min(start+micro, len(features))
+		loss, c := t.accumulate(features[start:end], labels[start:end], gradWeights, gradBias)
+		totalLoss += loss
+		correct += c
+	}
+
+	// Scaled by the whole batch, so accumulated and full-batch steps agree
+	scale := learningRate / float64(len(features))
+	for l, layer := range t.layers {
+		for k := range layer.weights {
+			if w := layer.weights[k] - scale*gradWeights[l][k]; !math.IsNaN(w) && !math.IsInf(w, 0) {
+				layer.weights[k] = w
+			}
+		}
+		for k := range layer.bias {
+			if b := layer.bias[k] - scale*gradBias[l][k]; !math.IsNaN(b) && !math.IsInf(b, 0) {
+				layer.bias[k] = b
+			}
+		}
+	}
+	return totalLoss, correct
+}
+
+// accumulate adds the micro-batch's gradients to gradWeights and gradBias.
+// The activations of all its samples are held while it backpropagates.
+func (t *MLPTrainer) accumulate(features [][]float64, labels []float64, gradWeights, gradBias [][]float64) (float64, int) {
+	activations := make([][][]float64, len(features))
+	for n, input := range features {
+		activations[n] = t.forward(input)
+	}
+
+	totalLoss := 0.0
+	correct := 0
+	for n := range features {
+		output := activations[n][len(activations[n])-1]
+		target := t.target(labels[n])
+
+		delta := make([]float64, len(output))
+		for j := range output {
+			diff := output[j] - target[j]
+			totalLoss += diff * diff / 2
+			delta[j] = diff * activationDerivative(t.layers[len(t.layers)-1].spec.Activation, output[j])
+		}
+		if t.correct(output, labels[n]) {
+			correct++
+		}
+
+		for l := len(t.layers) - 1; l >= 0; l-- {
+			layer := t.layers[l]
+			in := activations[n][l]
+			for j := 0; j < layer.out; j++ {
+				gradBias[l][j] += delta[j]
+				for i := 0; i < layer.in; i++ {
+					gradWeights[l][i*layer.out+j] += in[i] * delta[j]
+				}
+			}
+			if l == 0 {
+				break
+			}
+			prev := make([]float64, layer.in)
+			for i := 0; i < layer.in; i++ {
+				sum := 0.0
+				for j := 0; j < layer.out; j++ {
+					sum += layer.weights[i*layer.out+j] * delta[j]
+				}
+				prev[i] = sum * activationDerivative(t.layers[l-1].spec.Activation, in[i])
+			}
+			delta = prev
+		}
+	}
+	return totalLoss, correct
+}
+
+// forward returns the input followed by each layer's activations
+func (t *MLPTrainer) forward(input []float64) [][]float64 {
+	activations := [][]float64{input}
+	for _, layer := range t.layers {
+		out := make([]float64, layer.out)
+		for j := range out {
+			sum := layer.bias[j]
+			for i := 0; i < layer.in; i++ {
+				sum += input[i] * layer.weights[i*layer.out+j]
+			}
+			out[j] = activate(layer.spec.Activation, sum)
+		}
+		activations = append(activations, out)
+		input = out
+	}
+	return activations
+}
+
+// target encodes label for the output layer: the label itself for a single
+// output, one-hot otherwise
+func (t *MLPTrainer) target(label float64) []float64 {
+	outputs := t.layers[len(t.layers)-1].out
+	target := make([]float64, outputs)
+	if outputs == 1 {
+		target[0] = label
+	} else if idx := int(label); idx >= 0 && idx < outputs {
+		target[idx] = 1
+	}
+	return target
+}
+
+func (t *MLPTrainer) correct(output []float64, label float64) bool {
+	if len(output) == 1 {
+		return (output[0] > 0.5) == (label > 0.5)
+	}
+	best := 0
+	for j := range output {
+		if output[j] > output[best] {
+			best = j
+		}
+	}
+	return best == int(label)
+}
+
+func activate(activation string, x float64) float64 {
+	switch activation {
+	case "relu":
+		return math.Max(0, x)
+	case "sigmoid":
+		return 1 / (1 + math.Exp(-x))
+	case "tanh":
+		return math.Tanh(x)
+	default:
+		return x
+	}
+}
+
+// activationDerivative is the derivative of activation at the point where
+// it output y
+func activationDerivative(activation string, y float64) float64 {
+	switch activation {
+	case "relu":
+		if y > 0 {
+			return 1
+		}
+		return 0
+	case "sigmoid":
+		return y * (1 - y)
+	case "tanh":
+		return 1 - y*y
+	default:
+		return 1
+	}
+}
+
+// GetModelWeights returns the current model weights, keyed as the spec's
+// WeightKeys
+func (t *MLPTrainer) GetModelWeights() map[string][]float64 {
+	weights := make(map[string][]float64, 2*len(t.layers))
+	for i, layer := range t.layers {
+		weights[layerKey(i, layer.spec, "weights")] = cleanWeights(layer.weights)
+		weights[layerKey(i, layer.spec, "bias")] = cleanWeights(layer.bias)
+	}
+	return weights
+}
+
+// SetModelWeights replaces the model's weights with weights, keyed as the
+// spec's WeightKeys. A model not yet built takes its input size from the
+// first layer's weights.
+func (t *MLPTrainer) SetModelWeights(weights map[string][]float64) error {
+	if len(weights) != 2*len(t.spec.Layers) {
+		return fmt.Errorf("weights hold %d layers, want %d", len(weights), 2*len(t.spec.Layers))
+	}
+	if t.layers == nil {
+		first := t.spec.Layers[0]
+		n := len(weights[layerKey(0, first, "weights")])
+		if n == 0 || n%first.Units != 0 || n/first.Units > MaxInputSize {
+			return fmt.Errorf("layer %s has %d weights, which fits no input size for its %d units", layerKey(0, first, "weights"), n, first.Units)
+		}
+		t.build(n / first.Units)
+	}
+	for i, layer := range t.layers {
+		for _, param := range []struct {
+			name   string
+			values []float64
+		}{{"weights", layer.weights}, {"bias", layer.bias}} {
+			key := layerKey(i, layer.spec, param.name)
+			if len(weights[key]) != len(param.values) {
+				return fmt.Errorf("layer %s has %d values, want %d", key, len(weights[key]), len(param.values))
+			}
+		}
+	}
+	for i, layer := range t.layers {
+		copy(layer.weights, weights[layerKey(i, layer.spec, "weights")])
+		copy(layer.bias, weights[layerKey(i, layer.spec, "bias")])
+	}
+	return nil
+}
+
+// GetGradients returns the gradients from the last training step
+func (t *MLPTrainer) GetGradients() map[string][]float64 {
+	gradients := make(map[string][]float64, 2*len(t.layers))
+	if t.lastGradients == nil {
+		for i, layer := range t.layers {
+			gradients[layerKey(i, layer.spec, "weights")] = make([]float64, len(layer.weights))
+			gradients[layerKey(i, layer.spec, "bias")] = make([]float64, len(layer.bias))
+		}
+		return gradients
+	}
+	for key, values := range t.lastGradients {
+		gradients[key] = append([]float64(nil), values...)
+	}
+	return gradients
+}
+
+// cleanWeights copies values, replacing NaN and Inf with zero
+func cleanWeights(values []float64) []float64 {
+	clean := make([]float64, len(values))
+	for i, v := range values {
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			clean[i] = v
+		}
+	}
+	return clean
+}
@@ -31,6 +31,9 @@ func NewNeuralNetworkTrainer(config map[string]interface{}) (*NeuralNetworkTrain
 
 	// Get hidden size from config - required parameter
 	if hiddenSize, ok := config["hidden_size"].(float64); ok {
+		if hiddenSize < 1 || hiddenSize > MaxLayerUnits {
+			return nil, fmt.Errorf("hidden_size must be between 1 and %d", MaxLayerUnits)
+		}
 		trainer.hiddenSize = int(hiddenSize)
 	} else {
 		return nil, fmt.Errorf("hidden_size is required in neural network configuration")
@@ -39,6 +42,11 @@ func NewNeuralNetworkTrainer(config map[string]interface{}) (*NeuralNetworkTrain
 	return trainer, nil
 }
 
+// SetDatasetCache makes LoadData read datasets through cache
+func (t *NeuralNetworkTrainer) SetDatasetCache(cache *DatasetCache) {
+	t.dataLoader.SetCache(cache)
+}
+
 // LoadData loads training data from IPFS/Filecoin
 func (t *NeuralNetworkTrainer) LoadData(ctx context.Context, datasetCID string, format string) ([][]float64, []float64, error) {
 	return t.LoadPartitionedData(ctx, datasetCID, format, nil)
@@ -134,6 +142,9 @@ func (t *NeuralNetworkTrainer) Train(ctx context.Context, features [][]float64,
 
 		// Mini-batch training
 		for i := 0; i < numSamples; i += batchSize {
+			if err := ctx.Err(); err != nil {
+				return nil, 0, 0, err
+			}
 			end := i + batchSize
 			if end > numSamples {
 				end = numSamples
@@ -93,6 +93,10 @@ func NewRandomForestTrainer(config map[string]interface{}) (Trainer, error) {
 		return nil, fmt.Errorf("failed to unmarshal random forest config: %w", err)
 	}
 
+	if rfConfig.NumTrees > MaxTrees {
+		return nil, fmt.Errorf("random forest may have at most %d trees, got %d", MaxTrees, rfConfig.NumTrees)
+	}
+
 	// Set defaults for basic parameters
 	if rfConfig.NumTrees <= 0 {
 		rfConfig.NumTrees = 100
@@ -150,10 +154,23 @@ func NewRandomForestTrainer(config map[string]interface{}) (Trainer, error) {
 		dataLoader:        NewDataLoader(""),
 		classWeights:      make(map[float64]float64),
 	}
+	// Datasets without a declared schema are still checked for numeric
+	// features of one width before they finish downloading
+	trainer.dataLoader.SetSchema(&DatasetSchema{})
 
 	return trainer, nil
 }
 
+// SetDatasetCache makes LoadData read datasets through cache
+func (rf *RandomForestTrainer) SetDatasetCache(cache *DatasetCache) {
+	rf.dataLoader.SetCache(cache)
+}
+
+// SetDatasetSchema checks datasets against schema as they download
+func (rf *RandomForestTrainer) SetDatasetSchema(schema *DatasetSchema) {
+	rf.dataLoader.SetSchema(schema)
+}
+
 func (rf *RandomForestTrainer) LoadData(ctx context.Context, datasetCID string, format string) ([][]float64, []float64, error) {
 	return rf.LoadPartitionedData(ctx, datasetCID, format, nil)
 }
@@ -0,0 +1,257 @@
+package training
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Model families a spec can describe
+const (
+	FamilyMLP          = "mlp"
+	FamilyRandomForest = "random_forest"
+	FamilyLinear       = "linear"
+	FamilyLogistic     = "logistic"
+)
+
+// LayerDense is the only layer type MLP specs support
+const LayerDense = "dense"
+
+// Bounds on the models a task may ask for, so that a config cannot make the
+// runner allocate more than it can hold
+const (
+	// MaxInputSize is the most features a model may take
+	MaxInputSize = 1 << 20
+	// MaxLayerUnits is the most units a layer may have
+	MaxLayerUnits = 1 << 16
+	// MaxParameters is the most weights and biases a model may have
+	MaxParameters = 1 << 26
+	// MaxTrees is the most trees a random forest may grow
+	MaxTrees = 10000
+)
+
+var supportedActivations = []string{"relu", "sigmoid", "tanh", "linear"}
+
+// ErrSpecMismatch is returned when a spec's hash differs from the one the
+// session expects, meaning this runner would train a different model from
+// the other participants
+var ErrSpecMismatch = errors.New("model spec does not match session")
+
+// ModelSpec describes a model's architecture declaratively, so that a
+// session can train a new layout without a runner release
+type ModelSpec struct {
+	Family string `json:"family"`
+	// InputSize is the number of features; it is required for linear
+	// families and, when set, checked against the data for MLPs
+	InputSize int `json:"input_size,omitempty"`
+	// Layers of an MLP, in order; the last is the output layer
+	Layers []LayerSpec `json:"layers,omitempty"`
+	// Forest parameters of a random forest
+	Forest *ForestSpec `json:"forest,omitempty"`
+}
+
+// LayerSpec describes one MLP layer. Activation defaults to relu for hidden
+// layers and linear for the output layer.
+type LayerSpec struct {
+	Type       string `json:"type"`
+	Units      int    `json:"units"`
+	Activation string `json:"activation,omitempty"`
+}
+
+// ForestSpec describes a random forest; zero values take the trainer's
+// defaults
+type ForestSpec struct {
+	NumTrees        int     `json:"num_trees,omitempty"`
+	MaxDepth        int     `json:"max_depth,omitempty"`
+	MinSamplesSplit int     `json:"min_samples_split,omitempty"`
+	MinSamplesLeaf  int     `json:"min_samples_leaf,omitempty"`
+	MaxFeatures     int     `json:"max_features,omitempty"`
+	Criterion       string  `json:"criterion,omitempty"`
+	Subsample       float64 `json:"subsample,omitempty"`
+	Bootstrap       bool    `json:"bootstrap_samples,omitempty"`
+	RandomState     int64   `json:"random_state,omitempty"`
+}
+
+// Validate checks that the spec only uses supported families, layer types
+// and activations
+func (s *ModelSpec) Validate() error {
+	if s.InputSize < 0 || s.InputSize > MaxInputSize {
+		return fmt.Errorf("input_size must be between 0 and %d, got %d", MaxInputSize, s.InputSize)
+	}
+	switch s.Family {
+	case FamilyMLP:
+		if len(s.Layers) == 0 {
+			return errors.New("mlp spec requires at least one layer")
+		}
+		// Parameters of the first layer are only known once the data is,
+		// when the spec leaves input_size out
+		parameters, inputs := 0, s.InputSize
+		for i, layer := range s.Layers {
+			if layer.Type != LayerDense {
+				return fmt.Errorf("layers[%d]: unsupported layer type %q (supported: %s)", i, layer.Type, LayerDense)
+			}
+			if layer.Units <= 0 {
+				return fmt.Errorf("layers[%d]: units must be positive, got %d", i, layer.Units)
+			}
+			if layer.Units > MaxLayerUnits {
+				return fmt.Errorf("layers[%d]: units must be at most %d, got %d", i, MaxLayerUnits, layer.Units)
+			}
+			if parameters += (inputs + 1) * layer.Units; parameters > MaxParameters {
+				return fmt.Errorf("model has more than %d parameters", MaxParameters)
+			}
+			inputs = layer.Units
+			if layer.Activation != "" && !isSupportedActivation(layer.Activation) {
+				return fmt.Errorf("layers[%d]: unsupported activation %q (supported: %s)",
+					i, layer.Activation, strings.Join(supportedActivations, ", "))
+			}
+		}
+	case FamilyRandomForest:
+		if len(s.Layers) > 0 {
+			return errors.New("random_forest spec must not declare layers")
+		}
+		if f := s.Forest; f != nil {
+			if f.NumTrees < 0 || f.MaxDepth < 0 || f.MinSamplesSplit < 0 || f.MinSamplesLeaf < 0 || f.MaxFeatures < 0 {
+				return errors.New("forest parameters must not be negative")
+			}
+			if f.NumTrees > MaxTrees {
+				return fmt.Errorf("forest may have at most %d trees, got %d", MaxTrees, f.NumTrees)
+			}
+			if f.Subsample < 0 || f.Subsample > 1 {
+				return fmt.Errorf("forest subsample must be between 0 and 1, got %g", f.Subsample)
+			}
+			switch f.Criterion {
+			case "", "gini", "entropy":
+			default:
+				return fmt.Errorf("unsupported forest criterion %q (supported: gini, entropy)", f.Criterion)
+			}
+		}
+	case FamilyLinear, FamilyLogistic:
+		if len(s.Layers) > 0 {
+			return fmt.Errorf("%s spec must not declare layers", s.Family)
+		}
+		if s.InputSize == 0 {
+			return fmt.Errorf("%s spec requires input_size", s.Family)
+		}
+	case "":
+		return errors.New("model spec family is required")
+	default:
+		return fmt.Errorf("unsupported model family %q (supported: %s, %s, %s, %s)",
+			s.Family, FamilyMLP, FamilyRandomForest, FamilyLinear, FamilyLogistic)
+	}
+	return nil
+}
+
+func isSupportedActivation(activation string) bool {
+	for _, supported := range supportedActivations {
+		if activation == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// normalized returns a copy of the spec with defaults spelled out, so that
+// specs describing the same model hash the same
+func (s *ModelSpec) normalized() *ModelSpec {
+	n := &ModelSpec{Family: s.Family, InputSize: s.InputSize}
+	for i, layer := range s.Layers {
+		if layer.Activation == "" {
+			layer.Activation = "relu"
+			if i == len(s.Layers)-1 {
+				layer.Activation = "linear"
+			}
+		}
+		n.Layers = append(n.Layers, layer)
+	}
+	if s.Family == FamilyRandomForest {
+		forest := ForestSpec{}
+		if s.Forest != nil {
+			forest = *s.Forest
+		}
+		n.Forest = &forest
+	}
+	return n
+}
+
+// Hash identifies the model the spec describes. Participants of a session
+// whose hashes differ are training different models.
+func (s *ModelSpec) Hash() (string, error) {
+	if err := s.Validate(); err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(s.normalized())
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal model spec: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Verify checks the spec against the hash the session expects
+func (s *ModelSpec) Verify(expected string) error {
+	hash, err := s.Hash()
+	if err != nil {
+		return err
+	}
+	if expected != "" && !strings.EqualFold(hash, expected) {
+		return fmt.Errorf("%w: spec hash %s, session expects %s", ErrSpecMismatch, hash, expected)
+	}
+	return nil
+}
+
+// WeightKeys returns the keys of the weight map the spec's model produces,
+// in layer order. They depend only on the spec.
+func (s *ModelSpec) WeightKeys() []string {
+	switch s.Family {
+	case FamilyMLP:
+		keys := make([]string, 0, 2*len(s.Layers))
+		for i, layer := range s.Layers {
+			keys = append(keys, layerKey(i, layer, "weights"), layerKey(i, layer, "bias"))
+		}
+		return keys
+	case FamilyRandomForest:
+		return []string{"trees", "feature_importance"}
+	case FamilyLinear:
+		return []string{"linear_weights"}
+	case FamilyLogistic:
+		return []string{"logistic_weights"}
+	}
+	return nil
+}
+
+func layerKey(index int, layer LayerSpec, param string) string {
+	return fmt.Sprintf("layer_%d_%s_%d_%s", index, layer.Type, layer.Units, param)
+}
+
+// NewTrainerFromSpec constructs the model spec describes
+func NewTrainerFromSpec(spec *ModelSpec) (Trainer, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid model spec: %w", err)
+	}
+	spec = spec.normalized()
+
+	switch spec.Family {
+	case FamilyMLP:
+		return NewMLPTrainer(spec), nil
+	case FamilyRandomForest:
+		f := spec.Forest
+		return NewRandomForestTrainer(map[string]interface{}{
+			"num_trees":         f.NumTrees,
+			"max_depth":         f.MaxDepth,
+			"min_samples_split": f.MinSamplesSplit,
+			"min_samples_leaf":  f.MinSamplesLeaf,
+			"max_features":      f.MaxFeatures,
+			"criterion":         f.Criterion,
+			"subsample":         f.Subsample,
+			"bootstrap_samples": f.Bootstrap,
+			"random_state":      f.RandomState,
+		})
+	case FamilyLinear:
+		return newLinearTrainer(spec.InputSize, false), nil
+	default:
+		return newLinearTrainer(spec.InputSize, true), nil
+	}
+}
@@ -0,0 +1,202 @@
+package training
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// xorData is a small binary classification problem with two features
+func xorData() ([][]float64, []float64) {
+	var features [][]float64
+	var labels []float64
+	for i := 0; i < 40; i++ {
+		a, b := float64(i%2), float64((i/2)%2)
+		features = append(features, []float64{a, b})
+		if a != b {
+			labels = append(labels, 1)
+		} else {
+			labels = append(labels, 0)
+		}
+	}
+	return features, labels
+}
+
+func parseSpec(t *testing.T, raw string) *ModelSpec {
+	t.Helper()
+	var spec ModelSpec
+	if err := json.Unmarshal([]byte(raw), &spec); err != nil {
+		t.Fatal(err)
+	}
+	return &spec
+}
+
+func sortedKeys(m map[string][]float64) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func TestNewTrainerFromSpec(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		spec string
+	}{
+		{"mlp", `{"family":"mlp","layers":[{"type":"dense","units":8,"activation":"relu"},{"type":"dense","units":1,"activation":"sigmoid"}]}`},
+		{"deep mlp", `{"family":"mlp","layers":[{"type":"dense","units":6,"activation":"tanh"},{"type":"dense","units":4},{"type":"dense","units":2}]}`},
+		{"random forest", `{"family":"random_forest","forest":{"num_trees":3,"max_depth":3,"random_state":1}}`},
+		{"linear", `{"family":"linear","input_size":2}`},
+		{"logistic", `{"family":"logistic","input_size":2}`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := parseSpec(t, tt.spec)
+			features, labels := xorData()
+			want := append([]string(nil), spec.WeightKeys()...)
+			sort.Strings(want)
+
+			var trained [][]string
+			for i := 0; i < 2; i++ {
+				trainer, err := NewTrainerFromSpec(spec)
+				if err != nil {
+					t.Fatalf("NewTrainerFromSpec() error = %v", err)
+				}
+				_, loss, accuracy, err := trainer.Train(context.Background(), features, labels, 5, 8, 0.1)
+				if err != nil {
+					t.Fatalf("Train() error = %v", err)
+				}
+				if loss < 0 || accuracy < 0 || accuracy > 1 {
+					t.Fatalf("Train() loss = %g, accuracy = %g", loss, accuracy)
+				}
+				trained = append(trained, sortedKeys(trainer.GetModelWeights()))
+			}
+
+			for _, keys := range trained {
+				if strings.Join(keys, ",") != strings.Join(want, ",") {
+					t.Errorf("weight keys = %v, want %v", keys, want)
+				}
+			}
+		})
+	}
+}
+
+func TestMLPWeightShapes(t *testing.T) {
+	spec := parseSpec(t, `{"family":"mlp","input_size":2,"layers":[{"type":"dense","units":3},{"type":"dense","units":1}]}`)
+	trainer, err := NewTrainerFromSpec(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weights := trainer.GetModelWeights()
+	for key, size := range map[string]int{
+		"layer_0_dense_3_weights": 6,
+		"layer_0_dense_3_bias":    3,
+		"layer_1_dense_1_weights": 3,
+		"layer_1_dense_1_bias":    1,
+	} {
+		if got := len(weights[key]); got != size {
+			t.Errorf("len(%s) = %d, want %d", key, got, size)
+		}
+	}
+
+	features, labels := xorData()
+	wide := make([][]float64, len(features))
+	for i := range features {
+		wide[i] = append(features[i], 0)
+	}
+	if _, _, _, err := trainer.Train(context.Background(), wide, labels, 1, 8, 0.1); err == nil {
+		t.Error("Train() with more features than the spec's input_size succeeded")
+	}
+}
+
+func TestSetModelWeights(t *testing.T) {
+	for _, raw := range []string{
+		`{"family":"mlp","layers":[{"type":"dense","units":3},{"type":"dense","units":1}]}`,
+		`{"family":"linear","input_size":2}`,
+	} {
+		spec := parseSpec(t, raw)
+		source, _ := NewTrainerFromSpec(spec)
+		features, labels := xorData()
+		if _, _, _, err := source.Train(context.Background(), features, labels, 1, 8, 0.1); err != nil {
+			t.Fatal(err)
+		}
+		global := source.GetModelWeights()
+
+		// A model without an input size takes it from the weights
+		trainer, _ := NewTrainerFromSpec(spec)
+		loader := trainer.(WeightLoader)
+		if err := loader.SetModelWeights(global); err != nil {
+			t.Fatalf("%s: SetModelWeights() error = %v", spec.Family, err)
+		}
+		if got := trainer.GetModelWeights(); !reflect.DeepEqual(got, global) {
+			t.Errorf("%s: weights = %v, want %v", spec.Family, got, global)
+		}
+
+		for key := range global {
+			short := make(map[string][]float64, len(global))
+			for k, v := range global {
+				short[k] = v
+			}
+			short[key] = short[key][1:]
+			if err := loader.SetModelWeights(short); err == nil {
+				t.Errorf("%s: SetModelWeights() accepted a short %s", spec.Family, key)
+			}
+		}
+	}
+}
+
+func TestModelSpecValidate(t *testing.T) {
+	for _, tt := range []struct {
+		spec string
+		want string
+	}{
+		{`{"family":"mlp","layers":[{"type":"dense","units":4},{"type":"conv2d","units":4}]}`, `layers[1]: unsupported layer type "conv2d"`},
+		{`{"family":"mlp","layers":[{"type":"dense","units":4,"activation":"softplus"}]}`, `layers[0]: unsupported activation "softplus"`},
+		{`{"family":"mlp","layers":[{"type":"dense","units":0}]}`, `layers[0]: units must be positive`},
+		{`{"family":"mlp"}`, `at least one layer`},
+		{`{"family":"transformer"}`, `unsupported model family "transformer"`},
+		{`{"family":"logistic"}`, `requires input_size`},
+		{`{"family":"random_forest","forest":{"criterion":"mse"}}`, `unsupported forest criterion "mse"`},
+	} {
+		err := parseSpec(t, tt.spec).Validate()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Validate(%s) error = %v, want %q", tt.spec, err, tt.want)
+		}
+		if _, err := NewTrainerFromSpec(parseSpec(t, tt.spec)); err == nil {
+			t.Errorf("NewTrainerFromSpec(%s) succeeded", tt.spec)
+		}
+	}
+}
+
+func TestModelSpecHash(t *testing.T) {
+	base := parseSpec(t, `{"family":"mlp","layers":[{"type":"dense","units":8},{"type":"dense","units":1}]}`)
+	explicit := parseSpec(t, `{"family":"mlp","layers":[{"type":"dense","units":8,"activation":"relu"},{"type":"dense","units":1,"activation":"linear"}]}`)
+	wider := parseSpec(t, `{"family":"mlp","layers":[{"type":"dense","units":16},{"type":"dense","units":1}]}`)
+
+	hash, err := base.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := explicit.Hash(); got != hash {
+		t.Errorf("spec with defaults spelled out hashes to %s, want %s", got, hash)
+	}
+	if err := explicit.Verify(hash); err != nil {
+		t.Errorf("Verify() of equivalent spec error = %v", err)
+	}
+
+	other, _ := wider.Hash()
+	if other == hash {
+		t.Fatal("specs with different layer sizes hash the same")
+	}
+	if err := wider.Verify(hash); !errors.Is(err, ErrSpecMismatch) {
+		t.Errorf("Verify() of mismatched spec error = %v, want ErrSpecMismatch", err)
+	}
+	if err := wider.Verify(""); err != nil {
+		t.Errorf("Verify() without an expected hash error = %v", err)
+	}
+}
@@ -28,6 +28,29 @@ type Trainer interface {
 
 	// GetGradients returns the gradients from the last training step
 	GetGradients() map[string][]float64
+
+	// SetDatasetCache makes LoadData read datasets through cache
+	SetDatasetCache(cache *DatasetCache)
+}
+
+// WeightLoader is implemented by trainers that can start from given
+// weights, such as a federated learning round's global model
+type WeightLoader interface {
+	// SetModelWeights replaces the model's weights with weights, keyed as
+	// GetModelWeights returns them
+	SetModelWeights(weights map[string][]float64) error
+}
+
+// PartitionedLoader is implemented by trainers that can load one
+// participant's partition of a dataset
+type PartitionedLoader interface {
+	LoadPartitionedData(ctx context.Context, datasetCID string, format string, partitionConfig *PartitionConfig) ([][]float64, []float64, error)
+}
+
+// SchemaChecker is implemented by trainers that check their dataset
+// against a declared schema as it downloads
+type SchemaChecker interface {
+	SetDatasetSchema(schema *DatasetSchema)
 }
 
 // NewTrainer creates a new trainer instance based on model type
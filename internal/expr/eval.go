@@ -0,0 +1,628 @@
+package expr
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// errMissing is the error of a field or index a value does not have, which
+// has() turns into false
+var errMissing = errors.New("missing")
+
+type node interface {
+	eval(e *env) (interface{}, error)
+}
+
+type literalNode struct{ value interface{} }
+
+func (n *literalNode) eval(e *env) (interface{}, error) {
+	return n.value, e.budget.charge(1)
+}
+
+type identNode struct{ name string }
+
+func (n *identNode) eval(e *env) (interface{}, error) {
+	if err := e.budget.charge(1); err != nil {
+		return nil, err
+	}
+	v, ok := e.vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable %q", n.name)
+	}
+	return v, nil
+}
+
+type listNode struct{ items []node }
+
+func (n *listNode) eval(e *env) (interface{}, error) {
+	if err := e.budget.charge(1); err != nil {
+		return nil, err
+	}
+	list := make([]interface{}, len(n.items))
+	for i, item := range n.items {
+		v, err := item.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+type memberNode struct {
+	x    node
+	name string
+}
+
+func (n *memberNode) eval(e *env) (interface{}, error) {
+	x, err := n.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.budget.charge(1); err != nil {
+		return nil, err
+	}
+	return member(x, n.name)
+}
+
+func member(x interface{}, name string) (interface{}, error) {
+	if x == nil {
+		// Output that is not JSON has no fields, which has() reports
+		return nil, fmt.Errorf("%w field %q of null", errMissing, name)
+	}
+	m, ok := x.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s has no field %q", typeName(x), name)
+	}
+	v, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("%w field %q", errMissing, name)
+	}
+	return v, nil
+}
+
+type indexNode struct {
+	x, index node
+}
+
+func (n *indexNode) eval(e *env) (interface{}, error) {
+	x, err := n.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	index, err := n.index.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.budget.charge(1); err != nil {
+		return nil, err
+	}
+	return lookup(x, index)
+}
+
+func lookup(x, index interface{}) (interface{}, error) {
+	switch x := x.(type) {
+	case map[string]interface{}:
+		key, ok := index.(string)
+		if !ok {
+			return nil, fmt.Errorf("map index must be a string, not %s", typeName(index))
+		}
+		return member(x, key)
+	case []interface{}:
+		i, err := toIndex(index)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= len(x) {
+			return nil, fmt.Errorf("%w index %d of a list of %d", errMissing, i, len(x))
+		}
+		return x[i], nil
+	}
+	return nil, fmt.Errorf("cannot index %s", typeName(x))
+}
+
+func toIndex(v interface{}) (int, error) {
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) || math.Abs(f) > math.MaxInt32 {
+		return 0, fmt.Errorf("list index must be a whole number, not %v", v)
+	}
+	return int(f), nil
+}
+
+// hasNode reports whether a field or index exists
+type hasNode struct{ x node }
+
+func (n *hasNode) eval(e *env) (interface{}, error) {
+	if err := e.budget.charge(1); err != nil {
+		return nil, err
+	}
+	_, err := n.x.eval(e)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, errMissing):
+		return false, nil
+	}
+	return nil, err
+}
+
+type unaryNode struct {
+	op string
+	x  node
+}
+
+func (n *unaryNode) eval(e *env) (interface{}, error) {
+	x, err := n.x.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.budget.charge(1); err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "!":
+		b, ok := x.(bool)
+		if !ok {
+			return nil, fmt.Errorf("! needs a bool, not %s", typeName(x))
+		}
+		return !b, nil
+	default:
+		f, ok := x.(float64)
+		if !ok {
+			return nil, fmt.Errorf("- needs a number, not %s", typeName(x))
+		}
+		return -f, nil
+	}
+}
+
+type condNode struct {
+	cond, then, otherwise node
+}
+
+func (n *condNode) eval(e *env) (interface{}, error) {
+	c, err := n.cond.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := c.(bool)
+	if !ok {
+		return nil, fmt.Errorf("condition must be a bool, not %s", typeName(c))
+	}
+	if b {
+		return n.then.eval(e)
+	}
+	return n.otherwise.eval(e)
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(e *env) (interface{}, error) {
+	left, err := n.left.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	if err := e.budget.charge(1); err != nil {
+		return nil, err
+	}
+
+	// && and || only evaluate their right side when it decides the result
+	if n.op == "&&" || n.op == "||" {
+		l, ok := left.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs bools, not %s", n.op, typeName(left))
+		}
+		if l == (n.op == "||") {
+			return l, nil
+		}
+		right, err := n.right.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := right.(bool)
+		if !ok {
+			return nil, fmt.Errorf("%s needs bools, not %s", n.op, typeName(right))
+		}
+		return r, nil
+	}
+
+	right, err := n.right.eval(e)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		return in(e, left, right)
+	}
+
+	if l, ok := left.(string); ok {
+		r, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot apply %s to string and %s", n.op, typeName(right))
+		}
+		switch n.op {
+		case "+":
+			return l + r, e.budget.charge((len(l) + len(r)) / 64)
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+		return nil, fmt.Errorf("cannot apply %s to strings", n.op)
+	}
+
+	l, lok := left.(float64)
+	r, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("cannot apply %s to %s and %s", n.op, typeName(left), typeName(right))
+	}
+	switch n.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, errors.New("division by zero")
+		}
+		return l / r, nil
+	case "%":
+		if r == 0 {
+			return nil, errors.New("modulo by zero")
+		}
+		return math.Mod(l, r), nil
+	case "<":
+		return l < r, nil
+	case "<=":
+		return l <= r, nil
+	case ">":
+		return l > r, nil
+	default:
+		return l >= r, nil
+	}
+}
+
+func equal(a, b interface{}) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// in reports whether x is an item of a list, a key of a map or a substring
+// of a string
+func in(e *env, x, container interface{}) (interface{}, error) {
+	switch c := container.(type) {
+	case []interface{}:
+		if err := e.budget.charge(len(c) / 64); err != nil {
+			return nil, err
+		}
+		for _, item := range c {
+			if equal(x, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		key, ok := x.(string)
+		if !ok {
+			return nil, fmt.Errorf("map keys are strings, not %s", typeName(x))
+		}
+		_, ok = c[key]
+		return ok, nil
+	case string:
+		sub, ok := x.(string)
+		if !ok {
+			return nil, fmt.Errorf("cannot look for %s in a string", typeName(x))
+		}
+		if err := e.budget.charge(len(c) / 64); err != nil {
+			return nil, err
+		}
+		return strings.Contains(c, sub), nil
+	}
+	return nil, fmt.Errorf("cannot look in %s", typeName(container))
+}
+
+// function is a builtin taking between minArgs and maxArgs arguments, or
+// any number from minArgs when maxArgs is negative
+type function struct {
+	minArgs, maxArgs int
+	call             func(e *env, args []interface{}) (interface{}, error)
+}
+
+type callNode struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n *callNode) eval(e *env) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(e)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	if err := e.budget.charge(1); err != nil {
+		return nil, err
+	}
+	v, err := n.fn.call(e, args)
+	if err != nil && !errors.Is(err, ErrCostLimit) {
+		return nil, fmt.Errorf("%s: %w", n.name, err)
+	}
+	return v, err
+}
+
+// functions are the builtins expressions may call
+var functions = map[string]function{
+	"size":       {1, 1, fnSize},
+	"int":        {1, 1, fnInt},
+	"number":     {1, 1, fnNumber},
+	"string":     {1, 1, fnString},
+	"contains":   {2, 2, stringPredicate(strings.Contains)},
+	"startsWith": {2, 2, stringPredicate(strings.HasPrefix)},
+	"endsWith":   {2, 2, stringPredicate(strings.HasSuffix)},
+	"lower":      {1, 1, stringFunc(strings.ToLower)},
+	"upper":      {1, 1, stringFunc(strings.ToUpper)},
+	"trim":       {1, 1, stringFunc(strings.TrimSpace)},
+	"matches":    {2, 2, fnMatches},
+	"extract":    {2, 2, fnExtract},
+	"split":      {2, 2, fnSplit},
+	"abs":        {1, 1, numberFunc(math.Abs)},
+	"round":      {1, 1, numberFunc(math.Round)},
+	"floor":      {1, 1, numberFunc(math.Floor)},
+	"ceil":       {1, 1, numberFunc(math.Ceil)},
+	"min":        {1, -1, fnMin},
+	"max":        {1, -1, fnMax},
+}
+
+func fnSize(e *env, args []interface{}) (interface{}, error) {
+	switch x := args[0].(type) {
+	case string:
+		if err := e.budget.charge(len(x) / 64); err != nil {
+			return nil, err
+		}
+		return float64(utf8.RuneCountInString(x)), nil
+	case []interface{}:
+		return float64(len(x)), nil
+	case map[string]interface{}:
+		return float64(len(x)), nil
+	}
+	return nil, fmt.Errorf("%s has no size", typeName(args[0]))
+}
+
+func fnInt(e *env, args []interface{}) (interface{}, error) {
+	f, err := fnNumber(e, args)
+	if err != nil {
+		return nil, err
+	}
+	return math.Trunc(f.(float64)), nil
+}
+
+func fnNumber(e *env, args []interface{}) (interface{}, error) {
+	switch x := args[0].(type) {
+	case float64:
+		return x, nil
+	case bool:
+		if x {
+			return 1.0, nil
+		}
+		return 0.0, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", truncate(x))
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to a number", typeName(args[0]))
+}
+
+func fnString(e *env, args []interface{}) (interface{}, error) {
+	switch x := args[0].(type) {
+	case string:
+		return x, nil
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case nil:
+		return "null", nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to a string", typeName(args[0]))
+}
+
+func stringArgs(e *env, args []interface{}) ([]string, error) {
+	strs := make([]string, len(args))
+	cost := 0
+	for i, arg := range args {
+		s, ok := arg.(string)
+		if !ok {
+			return nil, fmt.Errorf("argument %d must be a string, not %s", i+1, typeName(arg))
+		}
+		strs[i] = s
+		cost += len(s)
+	}
+	return strs, e.budget.charge(cost / 64)
+}
+
+func stringPredicate(fn func(s, sub string) bool) func(*env, []interface{}) (interface{}, error) {
+	return func(e *env, args []interface{}) (interface{}, error) {
+		s, err := stringArgs(e, args)
+		if err != nil {
+			return nil, err
+		}
+		return fn(s[0], s[1]), nil
+	}
+}
+
+func stringFunc(fn func(s string) string) func(*env, []interface{}) (interface{}, error) {
+	return func(e *env, args []interface{}) (interface{}, error) {
+		s, err := stringArgs(e, args)
+		if err != nil {
+			return nil, err
+		}
+		return fn(s[0]), nil
+	}
+}
+
+func numberFunc(fn func(f float64) float64) func(*env, []interface{}) (interface{}, error) {
+	return func(e *env, args []interface{}) (interface{}, error) {
+		f, ok := args[0].(float64)
+		if !ok {
+			return nil, fmt.Errorf("argument must be a number, not %s", typeName(args[0]))
+		}
+		return fn(f), nil
+	}
+}
+
+// compileRegexp compiles pattern, charging for its length; RE2 matches in
+// time linear in the input, which the caller charges for
+func compileRegexp(e *env, pattern string) (*regexp.Regexp, error) {
+	if err := e.budget.charge(len(pattern)); err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return re, nil
+}
+
+func fnMatches(e *env, args []interface{}) (interface{}, error) {
+	s, err := stringArgs(e, args)
+	if err != nil {
+		return nil, err
+	}
+	re, err := compileRegexp(e, s[1])
+	if err != nil {
+		return nil, err
+	}
+	return re.MatchString(s[0]), nil
+}
+
+// fnExtract returns the first group of the first match of the pattern, or
+// the whole match when it has no group, and null when nothing matches
+func fnExtract(e *env, args []interface{}) (interface{}, error) {
+	s, err := stringArgs(e, args)
+	if err != nil {
+		return nil, err
+	}
+	re, err := compileRegexp(e, s[1])
+	if err != nil {
+		return nil, err
+	}
+	m := re.FindStringSubmatch(s[0])
+	switch {
+	case m == nil:
+		return nil, nil
+	case len(m) > 1:
+		return m[1], nil
+	}
+	return m[0], nil
+}
+
+func fnSplit(e *env, args []interface{}) (interface{}, error) {
+	s, err := stringArgs(e, args)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(s[0], s[1])
+	if err := e.budget.charge(len(parts)); err != nil {
+		return nil, err
+	}
+	list := make([]interface{}, len(parts))
+	for i, p := range parts {
+		list[i] = p
+	}
+	return list, nil
+}
+
+// numbers returns the numbers of args, or of the one list argument
+func numbers(e *env, args []interface{}) ([]float64, error) {
+	if list, ok := args[0].([]interface{}); ok && len(args) == 1 {
+		args = list
+	}
+	if len(args) == 0 {
+		return nil, errors.New("no numbers given")
+	}
+	if err := e.budget.charge(len(args) / 64); err != nil {
+		return nil, err
+	}
+	nums := make([]float64, len(args))
+	for i, arg := range args {
+		f, ok := arg.(float64)
+		if !ok {
+			return nil, fmt.Errorf("argument must be a number, not %s", typeName(arg))
+		}
+		nums[i] = f
+	}
+	return nums, nil
+}
+
+func fnMin(e *env, args []interface{}) (interface{}, error) {
+	nums, err := numbers(e, args)
+	if err != nil {
+		return nil, err
+	}
+	m := nums[0]
+	for _, f := range nums[1:] {
+		m = math.Min(m, f)
+	}
+	return m, nil
+}
+
+func fnMax(e *env, args []interface{}) (interface{}, error) {
+	nums, err := numbers(e, args)
+	if err != nil {
+		return nil, err
+	}
+	m := nums[0]
+	for _, f := range nums[1:] {
+		m = math.Max(m, f)
+	}
+	return m, nil
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// truncate shortens s for an error message
+func truncate(s string) string {
+	if len(s) > 32 {
+		return s[:32] + "..."
+	}
+	return s
+}
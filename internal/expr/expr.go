@@ -0,0 +1,91 @@
+// Package expr evaluates the small expression language task creators use to
+// post-process results on the runner.
+//
+// The language is a sandboxed subset of CEL: literals (numbers, strings in
+// single or double quotes, true, false, null and lists), variables, member
+// access and indexing, the arithmetic, comparison and logical operators,
+// `in`, the conditional `c ? a : b`, and a fixed set of pure functions.
+// Expressions cannot loop, define anything or reach outside the values they
+// are given, so they perform no IO. Their size and nesting are bounded at
+// compile time, and the work they do at evaluation time is counted against
+// a Budget, so a creator cannot make the runner spend more than the budget
+// on them however large the result they run over.
+//
+// Values are those JSON decodes to: nil, bool, float64, string,
+// []interface{} and map[string]interface{}.
+package expr
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// MaxSize is the longest expression accepted, in bytes
+	MaxSize = 1024
+	// MaxDepth bounds how deeply an expression may nest
+	MaxDepth = 32
+)
+
+// ErrCostLimit is returned by an evaluation that ran out of budget
+var ErrCostLimit = errors.New("expression cost limit exceeded")
+
+// Program is a compiled expression
+type Program struct {
+	source string
+	root   node
+}
+
+// Compile parses src, failing with the position of a syntax error, an
+// unknown function or an expression over the size or nesting limits
+func Compile(src string) (*Program, error) {
+	if len(src) > MaxSize {
+		return nil, fmt.Errorf("expression is %d bytes, more than the %d allowed", len(src), MaxSize)
+	}
+	p := &parser{lex: lexer{src: src}}
+	p.next()
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Program{source: src, root: root}, nil
+}
+
+// String returns the expression the program was compiled from
+func (p *Program) String() string {
+	return p.source
+}
+
+// Budget is the evaluation cost an evaluation may spend. One budget can be
+// shared by several evaluations, bounding them together.
+type Budget struct {
+	Limit int
+	Spent int
+}
+
+// NewBudget returns a budget of limit
+func NewBudget(limit int) *Budget {
+	return &Budget{Limit: limit}
+}
+
+// charge spends cost, failing once more than the limit is spent
+func (b *Budget) charge(cost int) error {
+	b.Spent += cost
+	if b.Spent > b.Limit {
+		return ErrCostLimit
+	}
+	return nil
+}
+
+// Eval evaluates the program over vars, spending from budget: a step per
+// operation, and for functions over strings and lists a step per 64 bytes or
+// items they go through
+func (p *Program) Eval(vars map[string]interface{}, budget *Budget) (interface{}, error) {
+	return p.root.eval(&env{vars: vars, budget: budget})
+}
+
+// env is the state of one evaluation
+type env struct {
+	vars   map[string]interface{}
+	budget *Budget
+}
@@ -0,0 +1,162 @@
+package expr
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func vars(t *testing.T, output string) map[string]interface{} {
+	t.Helper()
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(output), &parsed); err != nil {
+		parsed = nil
+	}
+	return map[string]interface{}{
+		"exit_code": 0.0,
+		"output":    output,
+		"json":      parsed,
+		"usage":     map[string]interface{}{"cpu_seconds": 12.5, "peak_memory_bytes": 3e8},
+	}
+}
+
+func eval(t *testing.T, src string, vars map[string]interface{}) (interface{}, error) {
+	t.Helper()
+	p, err := Compile(src)
+	if err != nil {
+		t.Fatalf("Compile(%q) error = %v", src, err)
+	}
+	return p.Eval(vars, NewBudget(10_000))
+}
+
+func TestEval(t *testing.T) {
+	jsonOut := vars(t, `{"metrics": {"accuracy": 0.93, "loss": 0.2}, "labels": ["cat", "dog"], "ok": true}`)
+	textOut := vars(t, "epoch 10 done\naccuracy=0.871 loss=0.30\n")
+
+	tests := []struct {
+		src  string
+		vars map[string]interface{}
+		want interface{}
+	}{
+		{`json.metrics.accuracy`, jsonOut, 0.93},
+		{`json["metrics"]["loss"] * 100`, jsonOut, 20.0},
+		{`json.labels[1]`, jsonOut, "dog"},
+		{`size(json.labels)`, jsonOut, 2.0},
+		{`"cat" in json.labels && "accuracy" in json.metrics`, jsonOut, true},
+		{`json.metrics.accuracy >= 0.9 ? "pass" : "fail"`, jsonOut, "pass"},
+		{`has(json.metrics.f1)`, jsonOut, false},
+		{`has(json.metrics.loss)`, jsonOut, true},
+		{`has(json.metrics)`, textOut, false},
+		{`number(extract(output, "accuracy=([0-9.]+)"))`, textOut, 0.871},
+		{`extract(output, "f1=([0-9.]+)")`, textOut, nil},
+		{`int(number(extract(output, 'epoch (\d+)'))) + 1`, textOut, 11.0},
+		{`matches(output, "^epoch \\d+")`, textOut, true},
+		{`contains(lower(output), "ACCURACY") || startsWith(output, "epoch")`, textOut, true},
+		{`size(split(trim(output), "\n"))`, textOut, 2.0},
+		{`exit_code == 0 && usage.cpu_seconds < 60`, textOut, true},
+		{`max(usage.peak_memory_bytes / 1e6, 100)`, textOut, 300.0},
+		{`min([3, 1, 2])`, textOut, 1.0},
+		{`string(round(2.6)) + "x"`, textOut, "3x"},
+		{`-abs(-4) % 3`, textOut, -1.0},
+		{`!(1 < 2) == false`, textOut, true},
+		{`[1, "a", null] == [1, "a", null]`, textOut, true},
+		{`1 == "1"`, textOut, false},
+	}
+	for _, tt := range tests {
+		got, err := eval(t, tt.src, tt.vars)
+		if err != nil {
+			t.Errorf("%s: error = %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	v := vars(t, `{"score": "high", "n": 0}`)
+	tests := []struct{ src, want string }{
+		{`json.score * 2`, "cannot apply * to string and number"},
+		{`json.missing`, `missing field "missing"`},
+		{`10 / json.n`, "division by zero"},
+		{`number(json.score)`, `number: "high" is not a number`},
+		{`json.score && true`, "&& needs bools"},
+		{`unknown + 1`, `unknown variable "unknown"`},
+		{`matches(output, "(")`, "matches: invalid pattern"},
+		{`json.score[0]`, "cannot index string"},
+	}
+	for _, tt := range tests {
+		_, err := eval(t, tt.src, v)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+
+	// The right side of a decided && or || is not evaluated
+	if got, err := eval(t, `has(json.missing) && json.missing > 1`, v); err != nil || got != false {
+		t.Errorf("short-circuit = %v, %v", got, err)
+	}
+}
+
+func TestCompileRejectsMalformedExpressions(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{`json.score >`, "unexpected end of expression"},
+		{`(1 + 2`, `want ")"`},
+		{`1 + * 2`, `unexpected "*"`},
+		{`"unterminated`, "unterminated string"},
+		{`exec("rm -rf /")`, `unknown function "exec"`},
+		{`size(1, 2)`, "wrong number of arguments to size"},
+		{`has(output)`, "has takes a field"},
+		{`json.score @ 2`, `unexpected '@'`},
+		{`1 2`, `want end of expression`},
+		{strings.Repeat("(", 40) + "1" + strings.Repeat(")", 40), "nests more than"},
+		{strings.Repeat("1+", MaxSize) + "1", "more than the 1024 allowed"},
+	}
+	for _, tt := range tests {
+		_, err := Compile(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Compile(%.40q) error = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestCostLimit(t *testing.T) {
+	big := vars(t, strings.Repeat("0123456789", 100_000))
+
+	// Every operation costs, so a small budget stops a long expression
+	p, err := Compile(strings.Repeat("1 + ", 200) + "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Eval(big, NewBudget(100)); !errors.Is(err, ErrCostLimit) {
+		t.Fatalf("Eval() over budget error = %v, want ErrCostLimit", err)
+	}
+
+	// Scanning large output costs by its size
+	p, err = Compile(`contains(output, "x") || matches(output, "x") || size(output) > 0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	budget := NewBudget(10_000)
+	if _, err := p.Eval(big, budget); !errors.Is(err, ErrCostLimit) {
+		t.Fatalf("Eval() of scans over 1 MB error = %v, want ErrCostLimit", err)
+	}
+	if _, err := p.Eval(big, NewBudget(100_000)); err != nil {
+		t.Fatalf("Eval() within budget error = %v", err)
+	}
+
+	// A budget is shared by the evaluations it is given to
+	shared := NewBudget(12)
+	p, _ = Compile(`1 + 2 + 3`)
+	for i := 0; i < 2; i++ {
+		if _, err := p.Eval(nil, shared); err != nil {
+			t.Fatalf("evaluation %d error = %v", i, err)
+		}
+	}
+	if _, err := p.Eval(nil, shared); !errors.Is(err, ErrCostLimit) {
+		t.Fatalf("third evaluation error = %v, want the shared budget spent", err)
+	}
+}
@@ -0,0 +1,395 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenString
+	tokenIdent
+	tokenOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	// value is the decoded value of a number or string
+	value interface{}
+	pos   int
+}
+
+// lexer splits an expression into tokens
+type lexer struct {
+	src string
+	pos int
+}
+
+// operators are matched longest first
+var operators = []string{"&&", "||", "==", "!=", "<=", ">=", "(", ")", "[", "]", ".", ",", "?", ":", "!", "-", "+", "*", "/", "%", "<", ">"}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && strings.ContainsRune(" \t\r\n", rune(l.src[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos == len(l.src) {
+		return token{kind: tokenEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case isDigit(c):
+		for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '.' || l.src[l.pos] == 'e' || l.src[l.pos] == 'E' ||
+			((l.src[l.pos] == '-' || l.src[l.pos] == '+') && (l.src[l.pos-1] == 'e' || l.src[l.pos-1] == 'E'))) {
+			l.pos++
+		}
+		text := l.src[start:l.pos]
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return token{}, fmt.Errorf("invalid number %q at %d", text, start)
+		}
+		return token{kind: tokenNumber, text: text, value: f, pos: start}, nil
+	case c == '"' || c == '\'':
+		return l.string(c)
+	case isIdentStart(c):
+		for l.pos < len(l.src) && (isIdentStart(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.pos++
+		}
+		return token{kind: tokenIdent, text: l.src[start:l.pos], pos: start}, nil
+	}
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokenOp, text: op, pos: start}, nil
+		}
+	}
+	return token{}, fmt.Errorf("unexpected %q at %d", c, start)
+}
+
+// string reads a string literal quoted with quote, with the escapes \\, \n,
+// \t, \r and the quotes
+func (l *lexer) string(quote byte) (token, error) {
+	start := l.pos
+	l.pos++
+	var b strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		l.pos++
+		switch c {
+		case quote:
+			return token{kind: tokenString, text: l.src[start:l.pos], value: b.String(), pos: start}, nil
+		case '\\':
+			if l.pos == len(l.src) {
+				return token{}, fmt.Errorf("unterminated string at %d", start)
+			}
+			e := l.src[l.pos]
+			l.pos++
+			switch e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\', '"', '\'':
+				b.WriteByte(e)
+			default:
+				// Kept as written, so regular expressions read naturally
+				b.WriteByte('\\')
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return token{}, fmt.Errorf("unterminated string at %d", start)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// parser builds the tree of an expression by recursive descent, one
+// function per precedence level from the conditional down
+type parser struct {
+	lex   lexer
+	tok   token
+	err   error
+	depth int
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+}
+
+func (p *parser) is(op string) bool {
+	return p.err == nil && p.tok.kind == tokenOp && p.tok.text == op
+}
+
+func (p *parser) expect(op string) error {
+	if p.err != nil {
+		return p.err
+	}
+	if !p.is(op) {
+		return p.unexpected(fmt.Sprintf("%q", op))
+	}
+	p.next()
+	return p.err
+}
+
+func (p *parser) unexpected(want string) error {
+	if p.tok.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of expression, want %s", want)
+	}
+	return fmt.Errorf("unexpected %q at %d, want %s", p.tok.text, p.tok.pos, want)
+}
+
+func (p *parser) parse() (node, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	n, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokenEOF {
+		return nil, p.unexpected("end of expression")
+	}
+	return n, nil
+}
+
+// enter counts a level of nesting, failing past MaxDepth
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > MaxDepth {
+		return fmt.Errorf("expression nests more than %d deep", MaxDepth)
+	}
+	return nil
+}
+
+func (p *parser) conditional() (node, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+
+	cond, err := p.binary(0)
+	if err != nil || !p.is("?") {
+		return cond, err
+	}
+	p.next()
+	then, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	return &condNode{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+// precedence lists the binary operators from the loosest binding
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) binaryOp(level int) (string, bool) {
+	if p.err != nil {
+		return "", false
+	}
+	for _, op := range precedence[level] {
+		if (p.tok.kind == tokenOp || p.tok.kind == tokenIdent) && p.tok.text == op {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) binary(level int) (node, error) {
+	if level == len(precedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.binaryOp(level)
+		if !ok {
+			return left, p.err
+		}
+		p.next()
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) unary() (node, error) {
+	if p.is("!") || p.is("-") {
+		op := p.tok.text
+		p.next()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer func() { p.depth-- }()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: op, x: x}, nil
+	}
+	return p.postfix()
+}
+
+func (p *parser) postfix() (node, error) {
+	n, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for depth := 0; ; depth++ {
+		if depth > MaxDepth {
+			return nil, fmt.Errorf("expression nests more than %d deep", MaxDepth)
+		}
+		switch {
+		case p.is("."):
+			p.next()
+			if p.err != nil {
+				return nil, p.err
+			}
+			if p.tok.kind != tokenIdent {
+				return nil, p.unexpected("a field name")
+			}
+			n = &memberNode{x: n, name: p.tok.text}
+			p.next()
+		case p.is("["):
+			p.next()
+			index, err := p.conditional()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = &indexNode{x: n, index: index}
+		default:
+			return n, p.err
+		}
+	}
+}
+
+func (p *parser) primary() (node, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	tok := p.tok
+	switch tok.kind {
+	case tokenNumber, tokenString:
+		p.next()
+		return &literalNode{value: tok.value}, p.err
+	case tokenIdent:
+		p.next()
+		switch tok.text {
+		case "true":
+			return &literalNode{value: true}, p.err
+		case "false":
+			return &literalNode{value: false}, p.err
+		case "null":
+			return &literalNode{value: nil}, p.err
+		case "in":
+			return nil, fmt.Errorf("unexpected %q at %d", tok.text, tok.pos)
+		}
+		if p.is("(") {
+			return p.call(tok)
+		}
+		return &identNode{name: tok.text}, p.err
+	case tokenOp:
+		switch tok.text {
+		case "(":
+			p.next()
+			n, err := p.conditional()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			p.next()
+			items, err := p.list("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listNode{items: items}, nil
+		}
+	}
+	return nil, p.unexpected("a value")
+}
+
+// list parses expressions separated by commas up to the closing op
+func (p *parser) list(closing string) ([]node, error) {
+	var items []node
+	for !p.is(closing) {
+		if len(items) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		item, err := p.conditional()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	p.next()
+	return items, p.err
+}
+
+func (p *parser) call(name token) (node, error) {
+	p.next()
+	args, err := p.list(")")
+	if err != nil {
+		return nil, err
+	}
+	if name.text == "has" {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("has takes one field, at %d", name.pos)
+		}
+		switch args[0].(type) {
+		case *memberNode, *indexNode:
+		default:
+			return nil, fmt.Errorf("has takes a field such as json.score, at %d", name.pos)
+		}
+		return &hasNode{x: args[0]}, nil
+	}
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at %d", name.text, name.pos)
+	}
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments to %s at %d", name.text, name.pos)
+	}
+	return &callNode{name: name.text, fn: fn, args: args}, nil
+}
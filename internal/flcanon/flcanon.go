@@ -0,0 +1,198 @@
+// Package flcanon encodes federated learning payloads canonically, so that
+// an update encodes to the same bytes, and so hashes and signs the same,
+// whichever platform the runner trained it on. The encoding is the JSON
+// Canonicalization Scheme of RFC 8785: object keys sorted by their UTF-16
+// code units, no insignificant whitespace, strings escaped minimally and
+// numbers formatted as ECMAScript formats them, the shortest decimal that
+// reads back as the same float64. Negative zero is encoded as 0, and NaN and
+// infinities are refused, as JSON cannot carry them.
+package flcanon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+)
+
+// ErrNonFinite is returned for a payload holding NaN or an infinity
+var ErrNonFinite = errors.New("value is not finite")
+
+// CheckFinite checks that every value of layers is finite, naming the first
+// that is not. field names layers in the error, such as "gradients".
+func CheckFinite(field string, layers map[string][]float64) error {
+	names := make([]string, 0, len(layers))
+	for name := range layers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for i, v := range layers[name] {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return fmt.Errorf("%w: %s[%q][%d] is %v", ErrNonFinite, field, name, i, v)
+			}
+		}
+	}
+	return nil
+}
+
+// AppendFloat appends the canonical encoding of v to dst. v must be finite.
+func AppendFloat(dst []byte, v float64) []byte {
+	if v == 0 {
+		// Covers negative zero
+		return append(dst, '0')
+	}
+	format := byte('f')
+	if abs := math.Abs(v); abs < 1e-6 || abs >= 1e21 {
+		format = 'e'
+	}
+	dst = strconv.AppendFloat(dst, v, format, -1, 64)
+	if format == 'e' {
+		// ECMAScript writes e-7 where strconv writes e-07
+		n := len(dst)
+		if n >= 4 && dst[n-4] == 'e' && dst[n-3] == '-' && dst[n-2] == '0' {
+			dst[n-2] = dst[n-1]
+			dst = dst[:n-1]
+		}
+	}
+	return dst
+}
+
+// Marshal returns the canonical encoding of v, which is first encoded as
+// encoding/json encodes it. Every number is read as a float64, so integers
+// beyond 2^53 lose precision, as they would in most JSON readers.
+func Marshal(v interface{}) ([]byte, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		var unsupported *json.UnsupportedValueError
+		if errors.As(err, &unsupported) {
+			return nil, fmt.Errorf("%w: %s", ErrNonFinite, unsupported.Str)
+		}
+		return nil, err
+	}
+	return Canonicalize(raw)
+}
+
+// Canonicalize returns the canonical encoding of the JSON document raw
+func Canonicalize(raw []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if decoder.More() {
+		return nil, errors.New("invalid JSON: trailing data")
+	}
+	return appendValue(make([]byte, 0, len(raw)), value)
+}
+
+// Hash returns the hex SHA-256 of the canonical encoding of v
+func Hash(v interface{}) (string, error) {
+	encoded, err := Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func appendValue(dst []byte, value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(dst, "null"...), nil
+	case bool:
+		return strconv.AppendBool(dst, v), nil
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%w: %s", ErrNonFinite, v)
+		}
+		return AppendFloat(dst, f), nil
+	case string:
+		return appendString(dst, v), nil
+	case []interface{}:
+		dst = append(dst, '[')
+		for i, elem := range v {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			var err error
+			if dst, err = appendValue(dst, elem); err != nil {
+				return nil, err
+			}
+		}
+		return append(dst, ']'), nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return lessUTF16(keys[i], keys[j]) })
+		dst = append(dst, '{')
+		for i, key := range keys {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = appendString(dst, key)
+			dst = append(dst, ':')
+			var err error
+			if dst, err = appendValue(dst, v[key]); err != nil {
+				return nil, err
+			}
+		}
+		return append(dst, '}'), nil
+	default:
+		return nil, fmt.Errorf("unexpected JSON value %T", value)
+	}
+}
+
+// lessUTF16 orders strings by their UTF-16 code units, which differs from
+// byte order for characters beyond the Basic Multilingual Plane
+func lessUTF16(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
+
+const hexDigits = "0123456789abcdef"
+
+// appendString escapes only what JSON requires: quotes, backslashes and
+// control characters, the latter by their short escape where one exists
+func appendString(dst []byte, s string) []byte {
+	dst = append(dst, '"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '"', '\\':
+			dst = append(dst, '\\', c)
+		case '\b':
+			dst = append(dst, '\\', 'b')
+		case '\f':
+			dst = append(dst, '\\', 'f')
+		case '\n':
+			dst = append(dst, '\\', 'n')
+		case '\r':
+			dst = append(dst, '\\', 'r')
+		case '\t':
+			dst = append(dst, '\\', 't')
+		default:
+			if c < 0x20 {
+				dst = append(dst, '\\', 'u', '0', '0', hexDigits[c>>4], hexDigits[c&0xf])
+			} else {
+				dst = append(dst, c)
+			}
+		}
+	}
+	return append(dst, '"')
+}
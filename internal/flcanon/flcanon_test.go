@@ -0,0 +1,115 @@
+package flcanon
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+// The expected encodings are those of ECMAScript's Number.prototype.toString,
+// which any platform's canonical encoder has to reproduce byte for byte
+func TestAppendFloatGolden(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{math.Copysign(0, -1), "0"},
+		{1, "1"},
+		{-1, "-1"},
+		{100, "100"},
+		{0.1, "0.1"},
+		{0.30000000000000004, "0.30000000000000004"},
+		{4.35, "4.35"},
+		{333333333.3333333, "333333333.3333333"},
+		{1e20, "100000000000000000000"},
+		{123456789012345680000, "123456789012345680000"},
+		{1e21, "1e+21"},
+		{1e300, "1e+300"},
+		{1e-6, "0.000001"},
+		{0.000001234, "0.000001234"},
+		{1e-7, "1e-7"},
+		{-1.5e-7, "-1.5e-7"},
+		{1.2345e-10, "1.2345e-10"},
+		{9007199254740993, "9007199254740992"},
+		// Denormals and the extremes of float64
+		{5e-324, "5e-324"},
+		{2.225073858507201e-308, "2.225073858507201e-308"},
+		{2.2250738585072014e-308, "2.2250738585072014e-308"},
+		{math.MaxFloat64, "1.7976931348623157e+308"},
+		{-math.MaxFloat64, "-1.7976931348623157e+308"},
+	}
+	for _, tt := range tests {
+		if got := string(AppendFloat(nil, tt.value)); got != tt.want {
+			t.Errorf("AppendFloat(%v) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMarshalGolden(t *testing.T) {
+	update := map[string]interface{}{
+		"weights": map[string][]float64{
+			"layer_1.bias":   {math.Copysign(0, -1), 5e-324},
+			"layer_0.weight": {0.1, -1e-7, 1e21},
+		},
+		"gradients": map[string][]float64{"layer_0.weight": {0.30000000000000004}},
+		"runner_id": "runner <1> & \"2\"\n",
+		// Sorted by UTF-16 code units: U+1F600 is a surrogate pair, which
+		// sorts before U+FB01 although its UTF-8 bytes sort after
+		"\U0001F600": 1,
+		"ﬁ":          2,
+		"data_size":  int64(1000),
+		"loss":       0.25,
+	}
+	want := `{"data_size":1000,"gradients":{"layer_0.weight":[0.30000000000000004]},"loss":0.25,` +
+		`"runner_id":"runner <1> & \"2\"\n","weights":{"layer_0.weight":[0.1,-1e-7,1e+21],"layer_1.bias":[0,5e-324]},` +
+		`"` + "\U0001F600" + `":1,"` + "ﬁ" + `":2}`
+	got, err := Marshal(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("Marshal() =\n%s\nwant\n%s", got, want)
+	}
+
+	// Produced with Node.js, sorting keys and hashing JSON.stringify's output
+	const wantHash = "5ad54decfd015c82115cbb6e28081159530a8b14b2b7ecbfab772a1c9ba6e392"
+	hash, err := Hash(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != wantHash {
+		t.Errorf("Hash() = %s, want %s", hash, wantHash)
+	}
+}
+
+func TestCanonicalizeIgnoresFormatting(t *testing.T) {
+	a, err := Canonicalize([]byte(`{"b": [1.0, 2.50, -0.0, 1E2], "a": "A\/"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Canonicalize([]byte(`{"a":"A/","b":[1,2.5,0,100]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(a) != string(b) || string(a) != `{"a":"A/","b":[1,2.5,0,100]}` {
+		t.Errorf("Canonicalize() = %s and %s, want both the same canonical document", a, b)
+	}
+	if _, err := Canonicalize([]byte(`{"a": 1e400}`)); !errors.Is(err, ErrNonFinite) {
+		t.Errorf("Canonicalize() of an overflowing number error = %v, want ErrNonFinite", err)
+	}
+}
+
+func TestNonFiniteRejected(t *testing.T) {
+	layers := map[string][]float64{"b": {1, math.Inf(-1)}, "a": {math.NaN()}}
+	err := CheckFinite("gradients", layers)
+	if !errors.Is(err, ErrNonFinite) || err.Error() != `value is not finite: gradients["a"][0] is NaN` {
+		t.Errorf("CheckFinite() error = %v, want the first non-finite value named", err)
+	}
+	if err := CheckFinite("weights", map[string][]float64{"a": {0, -0.5}}); err != nil {
+		t.Errorf("CheckFinite() error = %v for finite values", err)
+	}
+	if _, err := Marshal(map[string][]float64{"a": {math.Inf(1)}}); !errors.Is(err, ErrNonFinite) {
+		t.Errorf("Marshal() error = %v, want ErrNonFinite", err)
+	}
+}
@@ -0,0 +1,410 @@
+// Package flcompress compresses federated learning model updates with zstd.
+// Updates of one session resemble each other round after round, so once a
+// few have been sent a dictionary is trained on them and offered to the
+// server; updates are compressed with it once the server accepts it, and
+// without one until then or if it declines.
+package flcompress
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/theblitlabs/gologger"
+)
+
+// Payload encodings
+const (
+	EncodingZstd     = "zstd"
+	EncodingZstdDict = "zstd-dict"
+)
+
+const (
+	DefaultTrainAfter         = 3
+	DefaultMaxDictionaryBytes = 1 << 20
+	DefaultRetrainRatio       = 0.8
+	DefaultMaxSessions        = 8
+
+	// dictionaryShare is the part of a session's memory bound given to the
+	// dictionary; samples to train the next one get the rest
+	dictionaryShare = 4
+	// ratioSmoothing weighs each update's compression ratio against those
+	// before it
+	ratioSmoothing = 0.5
+	// dictionaryOverhead is room left in a dictionary's share for the
+	// entropy tables stored beside its history
+	dictionaryOverhead = 4 << 10
+	// minDictionaryID is the first dictionary ID zstd leaves unreserved
+	minDictionaryID = 32768
+)
+
+// ErrDictionaryMismatch is returned when decoding a payload compressed with
+// a dictionary other than the one given
+var ErrDictionaryMismatch = errors.New("payload was compressed with a different dictionary")
+
+// Config tunes dictionary training
+type Config struct {
+	// TrainAfter is how many updates of a session are sent before a
+	// dictionary is trained on them, at least two
+	TrainAfter int
+	// MaxDictionaryBytes bounds the memory one session's dictionary and the
+	// samples the next is trained on take
+	MaxDictionaryBytes int
+	// RetrainRatio triggers retraining once a session's compression ratio
+	// falls below this fraction of what its dictionary first achieved
+	RetrainRatio float64
+	// MaxSessions bounds how many sessions are tracked; the least recently
+	// used is forgotten beyond it
+	MaxSessions int
+	// DisableDictionaries compresses every update without a dictionary
+	DisableDictionaries bool
+}
+
+// Dictionary is a zstd dictionary trained for one session
+type Dictionary struct {
+	ID uint32
+	// Hash is the hex SHA-256 of Data
+	Hash string
+	Data []byte
+}
+
+// Payload is a compressed update
+type Payload struct {
+	Encoding string
+	Data     []byte
+	// DictionaryHash identifies the dictionary of an EncodingZstdDict payload
+	DictionaryHash string
+}
+
+// ratio is how many times smaller data is than raw
+func ratio(raw, data []byte) float64 {
+	if len(data) == 0 {
+		return 0
+	}
+	return float64(len(raw)) / float64(len(data))
+}
+
+// Codec compresses the updates of the sessions a runner trains in
+type Codec struct {
+	config Config
+	plain  *zstd.Encoder
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	// used orders sessions from least to most recently used
+	used []string
+}
+
+type session struct {
+	// dictionary is the one the server accepted, encoder compresses with it
+	dictionary *Dictionary
+	encoder    *zstd.Encoder
+	// pending is trained but not yet offered, offered is awaiting an answer
+	pending  *Dictionary
+	offered  *Dictionary
+	declined bool
+
+	samples     [][]byte
+	sampleBytes int
+	// baseline is the ratio dictionary first achieved, ratio the recent one
+	baseline float64
+	ratio    float64
+}
+
+// New returns a codec with config's zero fields defaulted
+func New(config Config) (*Codec, error) {
+	if config.TrainAfter <= 0 {
+		config.TrainAfter = DefaultTrainAfter
+	} else if config.TrainAfter < 2 {
+		config.TrainAfter = 2
+	}
+	if config.MaxDictionaryBytes <= 0 {
+		config.MaxDictionaryBytes = DefaultMaxDictionaryBytes
+	}
+	if config.RetrainRatio <= 0 || config.RetrainRatio >= 1 {
+		config.RetrainRatio = DefaultRetrainRatio
+	}
+	if config.MaxSessions <= 0 {
+		config.MaxSessions = DefaultMaxSessions
+	}
+	plain, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+	}
+	return &Codec{
+		config:   config,
+		plain:    plain,
+		sessions: make(map[string]*session),
+	}, nil
+}
+
+// session returns the state of sessionID, marking it most recently used and
+// forgetting the least recently used session beyond the bound
+func (c *Codec) session(sessionID string) *session {
+	for i, id := range c.used {
+		if id == sessionID {
+			c.used = append(c.used[:i], c.used[i+1:]...)
+			break
+		}
+	}
+	c.used = append(c.used, sessionID)
+
+	s, ok := c.sessions[sessionID]
+	if !ok {
+		s = &session{}
+		c.sessions[sessionID] = s
+	}
+	for len(c.used) > c.config.MaxSessions {
+		c.sessions[c.used[0]].release()
+		delete(c.sessions, c.used[0])
+		c.used = c.used[1:]
+	}
+	return s
+}
+
+// Encode compresses raw, an update of sessionID, with the session's
+// dictionary once the server has accepted one. Each update is kept as a
+// sample, and a dictionary is trained once there are enough samples or the
+// current dictionary's ratio has degraded; Pending returns it for offering.
+func (c *Codec) Encode(sessionID string, raw []byte) (*Payload, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := c.session(sessionID)
+
+	payload := &Payload{Encoding: EncodingZstd}
+	if s.dictionary == nil {
+		payload.Data = c.plain.EncodeAll(raw, nil)
+	} else {
+		if s.encoder == nil {
+			encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithEncoderDict(s.dictionary.Data))
+			if err != nil {
+				return nil, fmt.Errorf("failed to create zstd encoder: %w", err)
+			}
+			s.encoder = encoder
+		}
+		data := s.encoder.EncodeAll(raw, nil)
+		payload.Encoding = EncodingZstdDict
+		payload.Data = data
+		payload.DictionaryHash = s.dictionary.Hash
+		s.observe(ratio(raw, data))
+	}
+
+	if !c.config.DisableDictionaries {
+		c.addSample(s, raw)
+	}
+	if s.pending == nil && s.offered == nil && c.needsTraining(s) {
+		dictionary, err := c.train(s)
+		if err != nil {
+			// Updates are still sent, with the dictionary they use now
+			log := gologger.WithComponent("fl_compress")
+			log.Warn().Err(err).Str("session_id", sessionID).Msg("Failed to train compression dictionary")
+			return payload, nil
+		}
+		s.pending = dictionary
+	}
+	return payload, nil
+}
+
+// Pending returns the dictionary trained for sessionID that is to be offered
+// to the server, marking it offered; Acknowledge records the answer
+func (c *Codec) Pending(sessionID string) *Dictionary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.sessions[sessionID]
+	if !ok || s.pending == nil {
+		return nil
+	}
+	s.offered, s.pending = s.pending, nil
+	return s.offered
+}
+
+// Acknowledge records the server's answer to the offer of the dictionary
+// with hash. Accepted dictionaries compress the session's later updates; once
+// one is declined the session's updates are compressed without a dictionary.
+func (c *Codec) Acknowledge(sessionID, hash string, accepted bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.sessions[sessionID]
+	if !ok || s.offered == nil || s.offered.Hash != hash {
+		return
+	}
+	if accepted {
+		s.release()
+		s.dictionary = s.offered
+		s.baseline, s.ratio = 0, 0
+	} else {
+		s.declined = true
+	}
+	s.offered = nil
+}
+
+// Withdraw returns an offered dictionary whose offer failed to reach the
+// server to pending, to be offered again with a later update
+func (c *Codec) Withdraw(sessionID, hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.sessions[sessionID]; ok && s.offered != nil && s.offered.Hash == hash {
+		s.pending, s.offered = s.offered, nil
+	}
+}
+
+// Forget drops the state of a session that has ended
+func (c *Codec) Forget(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.sessions[sessionID]; ok {
+		s.release()
+	}
+	delete(c.sessions, sessionID)
+	for i, id := range c.used {
+		if id == sessionID {
+			c.used = append(c.used[:i], c.used[i+1:]...)
+			break
+		}
+	}
+}
+
+// MemoryUsage returns the bytes a session's dictionaries and samples hold
+func (c *Codec) MemoryUsage(sessionID string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.sessions[sessionID]
+	if !ok {
+		return 0
+	}
+	n := s.sampleBytes
+	for _, d := range []*Dictionary{s.dictionary, s.pending, s.offered} {
+		if d != nil {
+			n += len(d.Data)
+		}
+	}
+	return n
+}
+
+// release closes the encoder of the session's dictionary
+func (s *session) release() {
+	if s.encoder != nil {
+		s.encoder.Close()
+		s.encoder = nil
+	}
+}
+
+// observe records the ratio an update achieved with the session's dictionary
+func (s *session) observe(r float64) {
+	if s.baseline == 0 {
+		s.baseline, s.ratio = r, r
+		return
+	}
+	s.ratio = ratioSmoothing*r + (1-ratioSmoothing)*s.ratio
+}
+
+// needsTraining reports whether a dictionary should be trained for s: a
+// first one once there are enough samples, or a replacement once the ratio
+// of the current one has degraded
+func (c *Codec) needsTraining(s *session) bool {
+	if c.config.DisableDictionaries || s.declined || len(s.samples) < c.config.TrainAfter {
+		return false
+	}
+	if s.dictionary == nil {
+		return true
+	}
+	return s.baseline > 0 && s.ratio < s.baseline*c.config.RetrainRatio
+}
+
+// dictionaryLimit is the longest history a dictionary is trained with. A
+// session holds at most two dictionaries, the one in use and its
+// replacement; samples get the rest of its memory bound, shared between
+// TrainAfter of them.
+func (c *Codec) dictionaryLimit() int {
+	if limit := c.config.MaxDictionaryBytes/dictionaryShare - dictionaryOverhead; limit > 8 {
+		return limit
+	}
+	return 8
+}
+
+func (c *Codec) sampleLimit() int {
+	return (c.config.MaxDictionaryBytes - 2*c.config.MaxDictionaryBytes/dictionaryShare) / c.config.TrainAfter
+}
+
+// addSample keeps the start of raw as a sample, dropping the oldest samples
+// beyond TrainAfter
+func (c *Codec) addSample(s *session, raw []byte) {
+	sample := raw
+	if limit := c.sampleLimit(); len(sample) > limit {
+		sample = sample[:limit]
+	}
+	sample = append([]byte(nil), sample...)
+	s.samples = append(s.samples, sample)
+	s.sampleBytes += len(sample)
+	for len(s.samples) > c.config.TrainAfter {
+		s.sampleBytes -= len(s.samples[0])
+		s.samples = s.samples[1:]
+	}
+}
+
+// train builds a dictionary from the session's samples. Its history, the
+// content matches are found in, is the most recent samples up to the
+// dictionary limit, as later updates resemble recent ones most. The newest
+// sample is left out of it for the entropy tables to be trained on the
+// literals it leaves.
+func (c *Codec) train(s *session) (dictionary *Dictionary, err error) {
+	limit := c.dictionaryLimit()
+	var history []byte
+	for i := len(s.samples) - 2; i >= 0 && len(history) < limit; i-- {
+		sample := s.samples[i]
+		if room := limit - len(history); len(sample) > room {
+			sample = sample[len(sample)-room:]
+		}
+		history = append(append([]byte(nil), sample...), history...)
+	}
+	if len(history) < 8 {
+		return nil, fmt.Errorf("samples too short to train a dictionary")
+	}
+
+	sum := sha256.Sum256(history)
+	id := minDictionaryID + binary.BigEndian.Uint32(sum[:4])%(1<<31-minDictionaryID)
+	// BuildDict panics on samples it cannot derive tables from
+	defer func() {
+		if r := recover(); r != nil {
+			dictionary, err = nil, fmt.Errorf("failed to train dictionary: %v", r)
+		}
+	}()
+	data, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       id,
+		Contents: s.samples,
+		History:  history,
+		Offsets:  [3]int{1, 4, 8},
+		Level:    zstd.SpeedDefault,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to train dictionary: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	return &Dictionary{ID: id, Hash: hex.EncodeToString(hash[:]), Data: data}, nil
+}
+
+// Decode decompresses payload, with dictionary when it was compressed with
+// one, as the server does
+func Decode(payload *Payload, dictionary *Dictionary) ([]byte, error) {
+	var options []zstd.DOption
+	switch payload.Encoding {
+	case EncodingZstd:
+	case EncodingZstdDict:
+		if dictionary == nil || dictionary.Hash != payload.DictionaryHash {
+			return nil, ErrDictionaryMismatch
+		}
+		options = append(options, zstd.WithDecoderDicts(dictionary.Data))
+	default:
+		return nil, fmt.Errorf("unknown payload encoding %q", payload.Encoding)
+	}
+	decoder, err := zstd.NewReader(nil, append(options, zstd.WithDecoderConcurrency(1))...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create zstd decoder: %w", err)
+	}
+	defer decoder.Close()
+	return decoder.DecodeAll(payload.Data, nil)
+}
@@ -0,0 +1,323 @@
+package flcompress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// weightStream produces the updates a runner would send round after round:
+// the same layers, with weights drifting a little each round
+type weightStream struct {
+	rng     *rand.Rand
+	prefix  string
+	weights map[string][]float64
+}
+
+func newWeightStream(seed int64, prefix string, layers, width int) *weightStream {
+	s := &weightStream{rng: rand.New(rand.NewSource(seed)), prefix: prefix, weights: make(map[string][]float64)}
+	for i := 0; i < layers; i++ {
+		layer := make([]float64, width)
+		for j := range layer {
+			layer[j] = float64(s.rng.Intn(2000)-1000) / 1000
+		}
+		s.weights[fmt.Sprintf("%s_layer_%d.weight", prefix, i)] = layer
+	}
+	return s
+}
+
+// next returns the next round's update, JSON encoded as it is submitted
+func (s *weightStream) next(t testing.TB) []byte {
+	t.Helper()
+	gradients := make(map[string][]float64, len(s.weights))
+	for name, layer := range s.weights {
+		grad := make([]float64, len(layer))
+		for j := range layer {
+			// Only some weights move each round, by a quantised step
+			if s.rng.Intn(8) == 0 {
+				grad[j] = float64(s.rng.Intn(21)-10) / 1000
+				layer[j] += grad[j]
+			}
+		}
+		gradients[name] = grad
+	}
+	raw, err := json.Marshal(map[string]interface{}{"gradients": gradients, "weights": s.weights})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func mustEncode(t testing.TB, c *Codec, sessionID string, raw []byte) *Payload {
+	t.Helper()
+	payload, err := c.Encode(sessionID, raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func mustDecode(t testing.TB, payload *Payload, dictionary *Dictionary, raw []byte) {
+	t.Helper()
+	got, err := Decode(payload, dictionary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, raw) {
+		t.Fatal("decoded payload differs from the update")
+	}
+}
+
+// trainAndAccept sends updates until a dictionary is trained, then accepts it
+func trainAndAccept(t *testing.T, c *Codec, sessionID string, stream *weightStream) *Dictionary {
+	t.Helper()
+	for i := 0; i < c.config.TrainAfter; i++ {
+		mustEncode(t, c, sessionID, stream.next(t))
+	}
+	dictionary := c.Pending(sessionID)
+	if dictionary == nil {
+		t.Fatalf("no dictionary after %d updates", c.config.TrainAfter)
+	}
+	c.Acknowledge(sessionID, dictionary.Hash, true)
+	return dictionary
+}
+
+func TestCodecTrainsDictionaryAfterUpdates(t *testing.T) {
+	c, err := New(Config{TrainAfter: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := newWeightStream(1, "dense", 4, 256)
+
+	for i := 0; i < 2; i++ {
+		raw := stream.next(t)
+		payload := mustEncode(t, c, "s1", raw)
+		if payload.Encoding != EncodingZstd || payload.DictionaryHash != "" {
+			t.Fatalf("update %d encoding = %s, want %s before training", i, payload.Encoding, EncodingZstd)
+		}
+		mustDecode(t, payload, nil, raw)
+		if c.Pending("s1") != nil {
+			t.Fatalf("dictionary trained after %d updates, want 3", i+1)
+		}
+	}
+	mustEncode(t, c, "s1", stream.next(t))
+	dictionary := c.Pending("s1")
+	if dictionary == nil {
+		t.Fatal("no dictionary after 3 updates")
+	}
+	if dictionary.ID < minDictionaryID || len(dictionary.Hash) != 64 {
+		t.Errorf("dictionary ID %d, hash %q", dictionary.ID, dictionary.Hash)
+	}
+	if c.Pending("s1") != nil {
+		t.Error("dictionary pending again once offered")
+	}
+
+	// Updates stay plain until the server answers
+	raw := stream.next(t)
+	if payload := mustEncode(t, c, "s1", raw); payload.Encoding != EncodingZstd {
+		t.Errorf("encoding = %s before the dictionary was accepted", payload.Encoding)
+	}
+	c.Acknowledge("s1", dictionary.Hash, true)
+
+	raw = stream.next(t)
+	payload := mustEncode(t, c, "s1", raw)
+	if payload.Encoding != EncodingZstdDict || payload.DictionaryHash != dictionary.Hash {
+		t.Fatalf("payload = %s %s, want %s with the accepted dictionary", payload.Encoding, payload.DictionaryHash, EncodingZstdDict)
+	}
+	mustDecode(t, payload, dictionary, raw)
+	if _, err := Decode(payload, nil); !errors.Is(err, ErrDictionaryMismatch) {
+		t.Errorf("Decode() without the dictionary error = %v, want ErrDictionaryMismatch", err)
+	}
+}
+
+func TestCodecFallsBackWhenDictionaryDeclined(t *testing.T) {
+	c, err := New(Config{TrainAfter: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := newWeightStream(2, "conv", 3, 128)
+	for i := 0; i < 2; i++ {
+		mustEncode(t, c, "s1", stream.next(t))
+	}
+	dictionary := c.Pending("s1")
+	if dictionary == nil {
+		t.Fatal("no dictionary trained")
+	}
+	c.Acknowledge("s1", dictionary.Hash, false)
+
+	for i := 0; i < 5; i++ {
+		raw := stream.next(t)
+		payload := mustEncode(t, c, "s1", raw)
+		if payload.Encoding != EncodingZstd {
+			t.Fatalf("encoding = %s after the dictionary was declined", payload.Encoding)
+		}
+		mustDecode(t, payload, nil, raw)
+		if c.Pending("s1") != nil {
+			t.Fatal("dictionary offered again after one was declined")
+		}
+	}
+}
+
+func TestCodecOffersWithdrawnDictionaryAgain(t *testing.T) {
+	c, err := New(Config{TrainAfter: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := newWeightStream(3, "dense", 2, 128)
+	for i := 0; i < 2; i++ {
+		mustEncode(t, c, "s1", stream.next(t))
+	}
+	dictionary := c.Pending("s1")
+	if dictionary == nil {
+		t.Fatal("no dictionary trained")
+	}
+	c.Withdraw("s1", dictionary.Hash)
+	if again := c.Pending("s1"); again == nil || again.Hash != dictionary.Hash {
+		t.Fatalf("Pending() after Withdraw() = %v, want the same dictionary", again)
+	}
+	// Answers about other dictionaries are ignored
+	c.Acknowledge("s1", "other", true)
+	if payload := mustEncode(t, c, "s1", stream.next(t)); payload.Encoding != EncodingZstd {
+		t.Errorf("encoding = %s after acknowledging another dictionary", payload.Encoding)
+	}
+}
+
+func TestCodecRetrainsWhenRatioDegrades(t *testing.T) {
+	c, err := New(Config{TrainAfter: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := newWeightStream(4, "encoder", 4, 64)
+	first := trainAndAccept(t, c, "s1", stream)
+	for i := 0; i < 2; i++ {
+		raw := stream.next(t)
+		mustDecode(t, mustEncode(t, c, "s1", raw), first, raw)
+	}
+	if c.Pending("s1") != nil {
+		t.Fatal("dictionary retrained while it still matched")
+	}
+
+	// The model changes shape, so the dictionary stops matching its updates
+	changed := newWeightStream(5, "decoder", 6, 64)
+	var retrained *Dictionary
+	for i := 0; i < 6 && retrained == nil; i++ {
+		raw := changed.next(t)
+		payload := mustEncode(t, c, "s1", raw)
+		mustDecode(t, payload, first, raw)
+		retrained = c.Pending("s1")
+	}
+	if retrained == nil {
+		t.Fatal("no dictionary retrained after the ratio degraded")
+	}
+	if retrained.Hash == first.Hash {
+		t.Fatal("retrained dictionary is the first one")
+	}
+	c.Acknowledge("s1", retrained.Hash, true)
+	raw := changed.next(t)
+	payload := mustEncode(t, c, "s1", raw)
+	if payload.DictionaryHash != retrained.Hash {
+		t.Errorf("payload dictionary = %s, want the retrained one", payload.DictionaryHash)
+	}
+	mustDecode(t, payload, retrained, raw)
+}
+
+func TestCodecBoundsSessionMemory(t *testing.T) {
+	const limit = 64 << 10
+	c, err := New(Config{TrainAfter: 2, MaxDictionaryBytes: limit, RetrainRatio: 0.99})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Updates far larger than the bound, each shifting the model enough to
+	// keep retraining
+	for round := 0; round < 8; round++ {
+		raw := newWeightStream(int64(round), fmt.Sprintf("round%d", round), 8, 2048).next(t)
+		mustEncode(t, c, "s1", raw)
+		if dictionary := c.Pending("s1"); dictionary != nil {
+			c.Acknowledge("s1", dictionary.Hash, true)
+		}
+		if usage := c.MemoryUsage("s1"); usage > limit {
+			t.Fatalf("round %d: session holds %d bytes, want at most %d", round, usage, limit)
+		}
+	}
+}
+
+func TestCodecForgetsLeastRecentlyUsedSession(t *testing.T) {
+	c, err := New(Config{MaxSessions: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := newWeightStream(6, "dense", 1, 64)
+	for _, id := range []string{"a", "b", "a", "c"} {
+		mustEncode(t, c, id, stream.next(t))
+	}
+	if c.MemoryUsage("b") != 0 {
+		t.Error("least recently used session b kept")
+	}
+	if c.MemoryUsage("a") == 0 || c.MemoryUsage("c") == 0 {
+		t.Error("recently used sessions forgotten")
+	}
+	c.Forget("a")
+	if c.MemoryUsage("a") != 0 {
+		t.Error("forgotten session kept")
+	}
+}
+
+func TestCodecWithoutDictionaries(t *testing.T) {
+	c, err := New(Config{TrainAfter: 1, DisableDictionaries: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := newWeightStream(7, "dense", 2, 64)
+	for i := 0; i < 3; i++ {
+		raw := stream.next(t)
+		payload := mustEncode(t, c, "s1", raw)
+		mustDecode(t, payload, nil, raw)
+	}
+	if c.Pending("s1") != nil || c.MemoryUsage("s1") != 0 {
+		t.Error("dictionary trained with dictionaries disabled")
+	}
+}
+
+// BenchmarkEncode compresses a stream of small updates, where a dictionary
+// helps most, reporting the compression ratio achieved
+func BenchmarkEncode(b *testing.B) {
+	for _, bench := range []struct {
+		name       string
+		dictionary bool
+	}{
+		{"zstd", false},
+		{"zstd-dict", true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			c, err := New(Config{DisableDictionaries: !bench.dictionary})
+			if err != nil {
+				b.Fatal(err)
+			}
+			stream := newWeightStream(8, "dense", 4, 64)
+			if bench.dictionary {
+				dictionary := c.Pending("s1")
+				for ; dictionary == nil; dictionary = c.Pending("s1") {
+					mustEncode(b, c, "s1", stream.next(b))
+				}
+				c.Acknowledge("s1", dictionary.Hash, true)
+			}
+			updates := make([][]byte, 16)
+			for i := range updates {
+				updates[i] = stream.next(b)
+			}
+
+			var raw, compressed int
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				update := updates[i%len(updates)]
+				payload := mustEncode(b, c, "s1", update)
+				raw += len(update)
+				compressed += len(payload.Data)
+			}
+			b.ReportMetric(float64(raw)/float64(compressed), "ratio")
+		})
+	}
+}
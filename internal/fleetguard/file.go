@@ -0,0 +1,181 @@
+package fleetguard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileStore keeps intents as files in a directory the fleet shares, such as
+// an NFS mount. Files are created with hard links, which fail if the file
+// exists, so that an intent appears whole or not at all.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create intent directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(intent *Intent) string {
+	return filepath.Join(s.dir, intent.Key()+".json")
+}
+
+// Acquire implements Store
+func (s *FileStore) Acquire(ctx context.Context, intent *Intent, now time.Time) (*Intent, error) {
+	data, err := json.Marshal(intent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal intent: %w", err)
+	}
+	path := s.path(intent)
+
+	for attempt := 0; attempt < 3; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err := s.create(path, data)
+		if err == nil {
+			return intent, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+
+		raw, current, err := s.read(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if current.Holder == intent.Holder {
+			if err := s.replace(path, data); err != nil {
+				return nil, err
+			}
+			return intent, nil
+		}
+		if !current.expired(now) {
+			return current, ErrHeld
+		}
+		if err := s.takeOver(path, raw); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("intent for task %s changed while acquiring it", intent.TaskID)
+}
+
+// Release implements Store
+func (s *FileStore) Release(ctx context.Context, intent *Intent) error {
+	path := s.path(intent)
+	_, current, err := s.read(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if current.Holder != intent.Holder {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove intent: %w", err)
+	}
+	return nil
+}
+
+// create writes data to path unless path exists
+func (s *FileStore) create(path string, data []byte) error {
+	tmp, err := s.writeTemp(data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := os.Link(tmp, path); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return err
+		}
+		return fmt.Errorf("failed to create intent: %w", err)
+	}
+	return nil
+}
+
+// replace overwrites the intent at path, which this runner holds
+func (s *FileStore) replace(path string, data []byte) error {
+	tmp, err := s.writeTemp(data)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to renew intent: %w", err)
+	}
+	return nil
+}
+
+// takeOver moves the expired intent stale, as read from path, out of the
+// way. Of runners racing to take it over only one moves it; another that
+// moved a fresh intent by mistake puts it back and reports it held.
+func (s *FileStore) takeOver(path string, stale []byte) error {
+	moved, err := os.CreateTemp(s.dir, ".stale-*")
+	if err != nil {
+		return fmt.Errorf("failed to take over intent: %w", err)
+	}
+	moved.Close()
+	defer os.Remove(moved.Name())
+
+	if err := os.Rename(path, moved.Name()); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to take over intent: %w", err)
+	}
+	data, err := os.ReadFile(moved.Name())
+	if err != nil {
+		return fmt.Errorf("failed to take over intent: %w", err)
+	}
+	if !bytes.Equal(data, stale) {
+		// Restoring fails only if yet another runner created an intent,
+		// which then holds the task instead
+		os.Link(moved.Name(), path)
+		return ErrHeld
+	}
+	return nil
+}
+
+func (s *FileStore) read(path string) ([]byte, *Intent, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var intent Intent
+	if err := json.Unmarshal(data, &intent); err != nil {
+		return nil, nil, fmt.Errorf("invalid intent %s: %w", filepath.Base(path), err)
+	}
+	return data, &intent, nil
+}
+
+func (s *FileStore) writeTemp(data []byte) (string, error) {
+	f, err := os.CreateTemp(s.dir, ".intent-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to write intent: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write intent: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write intent: %w", err)
+	}
+	return f.Name(), nil
+}
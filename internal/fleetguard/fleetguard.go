@@ -0,0 +1,148 @@
+// Package fleetguard keeps the runners of one fleet from executing the same
+// task at once. Before executing, a runner records its intent in a store the
+// fleet shares; a runner that finds another member's intent stands down.
+// The guard fails open: when the store is unreachable, execution proceeds.
+package fleetguard
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ErrHeld is returned by a store when another holder's unexpired intent
+// exists for the task
+var ErrHeld = errors.New("execution intent held by another runner")
+
+// Intent records that Holder is executing a task until ExpiresAt
+type Intent struct {
+	TaskID    string    `json:"task_id"`
+	Nonce     string    `json:"nonce"`
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Key identifies an intent by its task ID and nonce
+func (i *Intent) Key() string {
+	sum := sha256.Sum256([]byte(i.Nonce))
+	return i.TaskID + "-" + hex.EncodeToString(sum[:8])
+}
+
+func (i *Intent) expired(now time.Time) bool {
+	return !i.ExpiresAt.After(now)
+}
+
+// Store is a lock service the fleet shares
+type Store interface {
+	// Acquire records intent unless another holder's unexpired intent for
+	// the same key exists, in which case it returns that intent and ErrHeld.
+	// A holder acquiring its own intent again renews it.
+	Acquire(ctx context.Context, intent *Intent, now time.Time) (*Intent, error)
+	// Release removes intent if its holder still holds it
+	Release(ctx context.Context, intent *Intent) error
+}
+
+// Guard acquires execution intents for one runner
+type Guard struct {
+	store   Store
+	holder  string
+	timeout time.Duration
+	clock   clock.Clock
+
+	acquired   atomic.Uint64
+	duplicates atomic.Uint64
+	failedOpen atomic.Uint64
+}
+
+// New returns a guard recording intents as holder in store. Each store
+// call is bounded by timeout.
+func New(store Store, holder string, timeout time.Duration) *Guard {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &Guard{
+		store:   store,
+		holder:  holder,
+		timeout: timeout,
+		clock:   clock.Real(),
+	}
+}
+
+// SetClock replaces the clock used for intent expiry
+func (g *Guard) SetClock(c clock.Clock) {
+	g.clock = c
+}
+
+// Acquire records this runner's intent to execute the task for up to ttl.
+// It returns the intent to release once execution ends, or, when another
+// runner already holds the task, an error wrapping ErrHeld. An unreachable
+// store is counted and logged and execution proceeds, with a nil intent.
+func (g *Guard) Acquire(ctx context.Context, task *models.Task, ttl time.Duration) (*Intent, error) {
+	log := gologger.WithComponent("fleetguard")
+
+	intent := &Intent{
+		TaskID:    task.ID.String(),
+		Nonce:     task.Nonce,
+		Holder:    g.holder,
+		ExpiresAt: g.clock.Now().Add(ttl),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	current, err := g.store.Acquire(ctx, intent, g.clock.Now())
+	switch {
+	case err == nil:
+		g.acquired.Add(1)
+		return intent, nil
+	case errors.Is(err, ErrHeld):
+		g.duplicates.Add(1)
+		holder := "unknown"
+		if current != nil {
+			holder = current.Holder
+		}
+		log.Warn().
+			Str("id", intent.TaskID).
+			Str("holder", holder).
+			Msg("Task already being executed by another fleet runner")
+		return nil, err
+	default:
+		g.failedOpen.Add(1)
+		log.Warn().
+			Err(err).
+			Str("id", intent.TaskID).
+			Msg("Execution guard unreachable, proceeding without it")
+		return nil, nil
+	}
+}
+
+// Release gives up intent, letting another runner execute the task. A nil
+// intent, from a guard that failed open, is ignored.
+func (g *Guard) Release(intent *Intent) {
+	if intent == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), g.timeout)
+	defer cancel()
+	if err := g.store.Release(ctx, intent); err != nil {
+		log := gologger.WithComponent("fleetguard")
+		log.Debug().Err(err).Str("id", intent.TaskID).Msg("Failed to release execution intent")
+	}
+}
+
+// Stats counts the guard's decisions since the runner started
+func (g *Guard) Stats() *models.ExecutionGuardStats {
+	return &models.ExecutionGuardStats{
+		Acquired:   g.acquired.Load(),
+		Duplicates: g.duplicates.Load(),
+		FailedOpen: g.failedOpen.Load(),
+	}
+}
@@ -0,0 +1,209 @@
+package fleetguard
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func newTask() *models.Task {
+	return &models.Task{ID: uuid.New(), Nonce: "abcdef"}
+}
+
+func newFileGuards(t *testing.T, holders ...string) ([]*Guard, *clocktest.Fake) {
+	t.Helper()
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clocktest.NewFake(time.Now())
+	var guards []*Guard
+	for _, holder := range holders {
+		g := New(store, holder, time.Second)
+		g.SetClock(clk)
+		guards = append(guards, g)
+	}
+	return guards, clk
+}
+
+func TestFileStoreHoldsIntent(t *testing.T) {
+	guards, _ := newFileGuards(t, "a", "b")
+	a, b := guards[0], guards[1]
+	task := newTask()
+
+	intent, err := a.Acquire(context.Background(), task, time.Minute)
+	if err != nil || intent == nil {
+		t.Fatalf("Acquire() = %v, %v, want intent", intent, err)
+	}
+	if _, err := b.Acquire(context.Background(), task, time.Minute); !errors.Is(err, ErrHeld) {
+		t.Fatalf("second Acquire() error = %v, want ErrHeld", err)
+	}
+
+	// Another nonce is another execution of the task
+	retry := *task
+	retry.Nonce = "123456"
+	if _, err := b.Acquire(context.Background(), &retry, time.Minute); err != nil {
+		t.Fatalf("Acquire() with new nonce error = %v", err)
+	}
+
+	a.Release(intent)
+	if _, err := b.Acquire(context.Background(), task, time.Minute); err != nil {
+		t.Fatalf("Acquire() after release error = %v", err)
+	}
+
+	if got := a.Stats(); got.Acquired != 1 || got.Duplicates != 0 {
+		t.Errorf("a.Stats() = %+v", got)
+	}
+	if got := b.Stats(); got.Acquired != 2 || got.Duplicates != 1 {
+		t.Errorf("b.Stats() = %+v", got)
+	}
+}
+
+func TestFileStoreRenewAndTakeOver(t *testing.T) {
+	guards, clk := newFileGuards(t, "a", "b")
+	a, b := guards[0], guards[1]
+	task := newTask()
+
+	if _, err := a.Acquire(context.Background(), task, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(50 * time.Second)
+	if _, err := a.Acquire(context.Background(), task, time.Minute); err != nil {
+		t.Fatalf("renewing Acquire() error = %v", err)
+	}
+	clk.Advance(50 * time.Second)
+	if _, err := b.Acquire(context.Background(), task, time.Minute); !errors.Is(err, ErrHeld) {
+		t.Fatalf("Acquire() of renewed intent error = %v, want ErrHeld", err)
+	}
+
+	clk.Advance(time.Minute)
+	intent, err := b.Acquire(context.Background(), task, time.Minute)
+	if err != nil {
+		t.Fatalf("Acquire() of expired intent error = %v", err)
+	}
+	if _, err := a.Acquire(context.Background(), task, time.Minute); !errors.Is(err, ErrHeld) {
+		t.Fatalf("Acquire() after takeover error = %v, want ErrHeld", err)
+	}
+
+	// Only the holder's release removes the intent
+	a.Release(&Intent{TaskID: intent.TaskID, Nonce: intent.Nonce, Holder: "a"})
+	if _, err := a.Acquire(context.Background(), task, time.Minute); !errors.Is(err, ErrHeld) {
+		t.Fatalf("Acquire() after foreign release error = %v, want ErrHeld", err)
+	}
+}
+
+func TestFileStoreRace(t *testing.T) {
+	for _, stale := range []bool{false, true} {
+		holders := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+		guards, clk := newFileGuards(t, append(holders, "old")...)
+		task := newTask()
+		if stale {
+			if _, err := guards[len(holders)].Acquire(context.Background(), task, time.Second); err != nil {
+				t.Fatal(err)
+			}
+			clk.Advance(time.Minute)
+		}
+
+		var wg sync.WaitGroup
+		var won atomic.Int32
+		for _, g := range guards[:len(holders)] {
+			wg.Add(1)
+			go func(g *Guard) {
+				defer wg.Done()
+				intent, err := g.Acquire(context.Background(), task, time.Minute)
+				if err == nil && intent != nil {
+					won.Add(1)
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		if got := won.Load(); got != 1 {
+			t.Errorf("stale=%v: %d runners acquired the intent, want 1", stale, got)
+		}
+	}
+}
+
+// casServer is a compare-and-set intent service
+func casServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	intents := map[string]Intent{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/intents/")
+		mu.Lock()
+		defer mu.Unlock()
+		current, held := intents[key]
+		switch r.Method {
+		case http.MethodPut:
+			var intent Intent
+			if err := json.NewDecoder(r.Body).Decode(&intent); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if held && current.Holder != intent.Holder && current.ExpiresAt.After(time.Now()) {
+				w.WriteHeader(http.StatusConflict)
+				json.NewEncoder(w).Encode(current)
+				return
+			}
+			intents[key] = intent
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodDelete:
+			if held && current.Holder == r.URL.Query().Get("holder") {
+				delete(intents, key)
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHTTPStore(t *testing.T) {
+	srv := casServer(t)
+	a := New(NewHTTPStore(srv.URL), "a", time.Second)
+	b := New(NewHTTPStore(srv.URL+"/"), "b", time.Second)
+	task := newTask()
+
+	intent, err := a.Acquire(context.Background(), task, time.Minute)
+	if err != nil || intent == nil {
+		t.Fatalf("Acquire() = %v, %v, want intent", intent, err)
+	}
+	current, err := b.store.Acquire(context.Background(), &Intent{TaskID: task.ID.String(), Nonce: task.Nonce, Holder: "b"}, time.Now())
+	if !errors.Is(err, ErrHeld) || current == nil || current.Holder != "a" {
+		t.Fatalf("Acquire() = %+v, %v, want intent held by a", current, err)
+	}
+
+	a.Release(intent)
+	if _, err := b.Acquire(context.Background(), task, time.Minute); err != nil {
+		t.Fatalf("Acquire() after release error = %v", err)
+	}
+}
+
+func TestGuardFailsOpen(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.Close()
+
+	g := New(NewHTTPStore(srv.URL), "a", time.Second)
+	intent, err := g.Acquire(context.Background(), newTask(), time.Minute)
+	if err != nil || intent != nil {
+		t.Fatalf("Acquire() = %v, %v, want nil, nil", intent, err)
+	}
+	g.Release(intent)
+
+	if got := g.Stats(); got.FailedOpen != 1 || got.Acquired != 0 {
+		t.Errorf("Stats() = %+v, want one failed open", got)
+	}
+}
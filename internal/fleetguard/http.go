@@ -0,0 +1,94 @@
+package fleetguard
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// HTTPStore keeps intents in a compare-and-set HTTP service.
+//
+// PUT {url}/intents/{key} with an intent records it unless another holder's
+// unexpired intent exists, answering 409 Conflict with that intent.
+// DELETE {url}/intents/{key}?holder={holder} removes the intent if holder
+// still holds it.
+type HTTPStore struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPStore(baseURL string) *HTTPStore {
+	return &HTTPStore{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		client: &http.Client{},
+	}
+}
+
+func (s *HTTPStore) endpoint(intent *Intent) string {
+	return fmt.Sprintf("%s/intents/%s", s.url, url.PathEscape(intent.Key()))
+}
+
+// Acquire implements Store. The service judges expiry by its own clock.
+func (s *HTTPStore) Acquire(ctx context.Context, intent *Intent, _ time.Time) (*Intent, error) {
+	body, err := json.Marshal(intent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal intent: %w", err)
+	}
+	endpoint := s.endpoint(intent)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create intent request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP PUT failed for %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNoContent:
+		return intent, nil
+	case http.StatusConflict:
+		var current Intent
+		if err := json.NewDecoder(resp.Body).Decode(&current); err != nil {
+			return nil, ErrHeld
+		}
+		return &current, ErrHeld
+	default:
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("intent unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+}
+
+// Release implements Store
+func (s *HTTPStore) Release(ctx context.Context, intent *Intent) error {
+	endpoint := s.endpoint(intent) + "?holder=" + url.QueryEscape(intent.Holder)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, endpoint, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create intent request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP DELETE failed for %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound, http.StatusConflict:
+		return nil
+	default:
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("intent unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+}
@@ -0,0 +1,316 @@
+package flmodel
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+)
+
+// DefaultMaxSessions bounds the sessions whose models are cached
+const DefaultMaxSessions = 8
+
+var sha256Pattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Fetcher downloads global models through the runner's input manager and
+// caches each session's latest model on disk, keyed by session and round,
+// so the next round can be built from deltas to it. A session's models are
+// dropped when it ends, or when more than the bounded number of sessions
+// are cached, the least recently used first.
+type Fetcher struct {
+	dir         string
+	inputs      *inputs.Manager
+	maxSessions int
+
+	// mu serializes fetches, which instances sharing the fetcher may make
+	// for the same round at once
+	mu sync.Mutex
+	// used orders cached sessions from least to most recently used
+	used []string
+}
+
+// NewFetcher caches models in dir, creating it when missing, and downloads
+// them through manager
+func NewFetcher(dir string, manager *inputs.Manager) (*Fetcher, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create global model cache directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read global model cache directory: %w", err)
+	}
+	f := &Fetcher{dir: dir, inputs: manager, maxSessions: DefaultMaxSessions}
+
+	// Sessions cached by an earlier run are ordered by when they were
+	// last written
+	type session struct {
+		key     string
+		modTime int64
+	}
+	var sessions []session
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			// Left behind by an interrupted download
+			os.RemoveAll(filepath.Join(dir, entry.Name()))
+			continue
+		}
+		if info, err := entry.Info(); err == nil && entry.IsDir() {
+			sessions = append(sessions, session{entry.Name(), info.ModTime().UnixNano()})
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].modTime < sessions[j].modTime })
+	for _, s := range sessions {
+		f.used = append(f.used, s.key)
+	}
+	f.trim()
+	return f, nil
+}
+
+// SetMaxSessions bounds the sessions whose models are cached
+func (f *Fetcher) SetMaxSessions(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.maxSessions = n
+	f.trim()
+}
+
+// key names the cache entry of a session or round. IDs come from the
+// coordinator, so they are hashed rather than used as file names.
+func key(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:16])
+}
+
+func (f *Fetcher) path(sessionID, roundID string) string {
+	return filepath.Join(f.dir, key(sessionID), key(roundID)+".json")
+}
+
+// Fetch returns the global model of round roundID in session sessionID that
+// ref describes. The model comes from the cache, from ref's deltas applied
+// to the cached base round, or from a full download, in that order of
+// preference, and is returned only once it matches ref's hash. A model
+// that cannot be made to match fails with ErrIntegrity.
+func (f *Fetcher) Fetch(ctx context.Context, sessionID, roundID string, ref *models.GlobalModelRef) (map[string][]float64, error) {
+	if err := validate(ref); err != nil {
+		return nil, err
+	}
+	log := gologger.WithComponent("fl_model")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if model, err := f.load(sessionID, roundID, ref.SHA256); err == nil {
+		f.touch(sessionID)
+		return model, nil
+	}
+
+	if ref.Delta != nil {
+		model, err := f.fromDelta(ctx, sessionID, ref)
+		if err == nil {
+			if err := f.store(sessionID, roundID, model); err != nil {
+				return nil, err
+			}
+			log.Info().Str("session_id", sessionID).Str("round_id", roundID).Int("layers", len(ref.Delta.Layers)).Msg("Built global model from layer deltas")
+			return model, nil
+		}
+		log.Info().Err(err).Str("session_id", sessionID).Str("round_id", roundID).Msg("Cannot apply global model deltas - downloading the full model")
+	}
+
+	model, err := f.download(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.store(sessionID, roundID, model); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func validate(ref *models.GlobalModelRef) error {
+	if ref == nil {
+		return errors.New("no global model given")
+	}
+	if !sha256Pattern.MatchString(ref.SHA256) {
+		return fmt.Errorf("global model sha256 %q must be 64 lowercase hex digits", ref.SHA256)
+	}
+	if (ref.Source.URL == "") == (ref.Source.CID == "") {
+		return errors.New("global model source must set exactly one of url or cid")
+	}
+	if ref.Delta != nil {
+		for _, layer := range ref.Delta.Layers {
+			if (layer.Source.URL == "") == (layer.Source.CID == "") {
+				return fmt.Errorf("delta for layer %s must set exactly one of url or cid", layer.Layer)
+			}
+		}
+	}
+	return nil
+}
+
+// load reads the cached model of a round, which must hash to sha
+func (f *Fetcher) load(sessionID, roundID, sha string) (map[string][]float64, error) {
+	data, err := os.ReadFile(f.path(sessionID, roundID))
+	if err != nil {
+		return nil, err
+	}
+	var model map[string][]float64
+	if err := json.Unmarshal(data, &model); err != nil || Hash(model) != sha {
+		// A damaged or superseded entry is replaced
+		os.Remove(f.path(sessionID, roundID))
+		return nil, fmt.Errorf("%w: cached model of round %s", ErrIntegrity, roundID)
+	}
+	return model, nil
+}
+
+// fromDelta builds ref's model from its deltas and the cached model of the
+// round they apply to
+func (f *Fetcher) fromDelta(ctx context.Context, sessionID string, ref *models.GlobalModelRef) (map[string][]float64, error) {
+	base, err := f.load(sessionID, ref.Delta.BaseRoundID, ref.Delta.BaseSHA256)
+	if err != nil {
+		return nil, fmt.Errorf("base round %s is not cached: %w", ref.Delta.BaseRoundID, err)
+	}
+	deltas := make(map[string][]float64, len(ref.Delta.Layers))
+	for _, layer := range ref.Delta.Layers {
+		spec := &models.TaskInput{Name: "layer-delta", Source: layer.Source, SHA256: layer.SHA256, Size: layer.Size}
+		var values []float64
+		if err := f.fetchJSON(ctx, spec, &values); err != nil {
+			return nil, fmt.Errorf("delta for layer %s: %w", layer.Layer, err)
+		}
+		deltas[layer.Layer] = values
+	}
+	model, err := ApplyDelta(base, deltas)
+	if err != nil {
+		return nil, err
+	}
+	if sum := Hash(model); sum != ref.SHA256 {
+		return nil, fmt.Errorf("%w: model built from deltas has sha256 %s, want %s", ErrIntegrity, sum, ref.SHA256)
+	}
+	return model, nil
+}
+
+// download fetches ref's full model
+func (f *Fetcher) download(ctx context.Context, ref *models.GlobalModelRef) (map[string][]float64, error) {
+	spec := &models.TaskInput{Name: "global-model", Source: ref.Source, Size: ref.Size}
+	var model map[string][]float64
+	if err := f.fetchJSON(ctx, spec, &model); err != nil {
+		if errors.Is(err, inputs.ErrVerification) {
+			return nil, fmt.Errorf("%w: %w", ErrIntegrity, err)
+		}
+		return nil, err
+	}
+	if sum := Hash(model); sum != ref.SHA256 {
+		return nil, fmt.Errorf("%w: model has sha256 %s, want %s", ErrIntegrity, sum, ref.SHA256)
+	}
+	return model, nil
+}
+
+// fetchJSON downloads spec through the input manager and decodes it into v
+func (f *Fetcher) fetchJSON(ctx context.Context, spec *models.TaskInput, v interface{}) error {
+	scratch, err := os.MkdirTemp(f.dir, ".download-*")
+	if err != nil {
+		return fmt.Errorf("failed to create download directory: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	path, err := f.inputs.Fetch(ctx, spec, scratch)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", spec.Name, err)
+	}
+	// Models outgrow safejson's limits; their shape is fixed by v
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s is not valid JSON: %w", ErrIntegrity, spec.Name, err)
+	}
+	return nil
+}
+
+// store caches model as the round's, replacing the session's earlier
+// rounds: deltas only ever apply to the latest
+func (f *Fetcher) store(sessionID, roundID string, model map[string][]float64) error {
+	dir := filepath.Join(f.dir, key(sessionID))
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create global model cache directory: %w", err)
+	}
+	data, err := json.Marshal(model)
+	if err != nil {
+		return fmt.Errorf("failed to encode global model: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, ".model-*")
+	if err != nil {
+		return fmt.Errorf("failed to cache global model: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to cache global model: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to cache global model: %w", err)
+	}
+	path := f.path(sessionID, roundID)
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to cache global model: %w", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if other := filepath.Join(dir, entry.Name()); other != path {
+			os.RemoveAll(other)
+		}
+	}
+	f.touch(sessionID)
+	return nil
+}
+
+// touch marks a session most recently used, dropping the least recently
+// used beyond the bound
+func (f *Fetcher) touch(sessionID string) {
+	k := key(sessionID)
+	for i, id := range f.used {
+		if id == k {
+			f.used = append(f.used[:i], f.used[i+1:]...)
+			break
+		}
+	}
+	f.used = append(f.used, k)
+	f.trim()
+}
+
+func (f *Fetcher) trim() {
+	for f.maxSessions > 0 && len(f.used) > f.maxSessions {
+		os.RemoveAll(filepath.Join(f.dir, f.used[0]))
+		f.used = f.used[1:]
+	}
+}
+
+// Forget drops the cached models of a session that has ended
+func (f *Fetcher) Forget(sessionID string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	k := key(sessionID)
+	for i, id := range f.used {
+		if id == k {
+			f.used = append(f.used[:i], f.used[i+1:]...)
+			break
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(f.dir, k)); err != nil {
+		return fmt.Errorf("failed to drop cached models of session %s: %w", sessionID, err)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package flmodel
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+)
+
+// modelServer serves JSON documents by path and counts their downloads
+type modelServer struct {
+	*httptest.Server
+	mu    sync.Mutex
+	files map[string][]byte
+	gets  map[string]int
+}
+
+func newModelServer(t *testing.T) *modelServer {
+	t.Helper()
+	s := &modelServer{files: make(map[string][]byte), gets: make(map[string]int)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		data, ok := s.files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		s.gets[r.URL.Path]++
+		w.Write(data)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *modelServer) put(t *testing.T, path string, v interface{}) models.InputSource {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[path] = data
+	return models.InputSource{URL: s.URL + path}
+}
+
+func (s *modelServer) downloads(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.gets[path]
+}
+
+func newFetcher(t *testing.T) (*Fetcher, string) {
+	t.Helper()
+	manager, err := inputs.NewManager(filepath.Join(t.TempDir(), "inputs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(t.TempDir(), "models")
+	f, err := NewFetcher(dir, manager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, dir
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := map[string][]float64{"w": {1, 2, 3}, "b": {0.5}}
+	model, err := ApplyDelta(base, map[string][]float64{"w": {0.25, -2, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][]float64{"w": {1.25, 0, 3}, "b": {0.5}}
+	if !reflect.DeepEqual(model, want) {
+		t.Fatalf("ApplyDelta() = %v, want %v", model, want)
+	}
+	if !reflect.DeepEqual(base, map[string][]float64{"w": {1, 2, 3}, "b": {0.5}}) {
+		t.Fatalf("ApplyDelta() changed its base to %v", base)
+	}
+
+	if _, err := ApplyDelta(base, map[string][]float64{"x": {1}}); err == nil {
+		t.Error("ApplyDelta() accepted a delta for an unknown layer")
+	}
+	if _, err := ApplyDelta(base, map[string][]float64{"w": {1, 2}}); err == nil {
+		t.Error("ApplyDelta() accepted a delta of the wrong length")
+	}
+}
+
+func TestHashIsCanonical(t *testing.T) {
+	a := map[string][]float64{"w": {1, 2}, "b": {3}}
+	b := map[string][]float64{"b": {3}, "w": {1, 2}}
+	if Hash(a) != Hash(b) {
+		t.Error("Hash() depends on map order")
+	}
+	// Moving a value between layers changes the hash
+	if Hash(a) == Hash(map[string][]float64{"w": {1}, "b": {2, 3}}) {
+		t.Error("Hash() does not separate layers")
+	}
+}
+
+func TestFetchBuildsRoundsFromDeltas(t *testing.T) {
+	server := newModelServer(t)
+	f, dir := newFetcher(t)
+	ctx := context.Background()
+
+	round1 := map[string][]float64{"w": {1, 2, 3}, "b": {0.5}}
+	ref1 := &models.GlobalModelRef{SHA256: Hash(round1), Source: server.put(t, "/round-1.json", round1)}
+	got, err := f.Fetch(ctx, "session-1", "round-1", ref1)
+	if err != nil || !reflect.DeepEqual(got, round1) {
+		t.Fatalf("Fetch() = %v, %v", got, err)
+	}
+
+	// Fetching the same round again is served from the cache
+	if _, err := f.Fetch(ctx, "session-1", "round-1", ref1); err != nil || server.downloads("/round-1.json") != 1 {
+		t.Fatalf("Fetch() error = %v after %d downloads, want the cached model", err, server.downloads("/round-1.json"))
+	}
+
+	round2 := map[string][]float64{"w": {1.5, 2, 2}, "b": {0.5}}
+	ref2 := &models.GlobalModelRef{
+		SHA256: Hash(round2),
+		Source: server.put(t, "/round-2.json", round2),
+		Delta: &models.GlobalModelDelta{
+			BaseRoundID: "round-1",
+			BaseSHA256:  Hash(round1),
+			Layers: []models.LayerDelta{
+				{Layer: "w", Source: server.put(t, "/round-2-w.json", []float64{0.5, 0, -1})},
+			},
+		},
+	}
+	got, err = f.Fetch(ctx, "session-1", "round-2", ref2)
+	if err != nil || !reflect.DeepEqual(got, round2) {
+		t.Fatalf("Fetch() = %v, %v from deltas", got, err)
+	}
+	if server.downloads("/round-2.json") != 0 || server.downloads("/round-2-w.json") != 1 {
+		t.Fatal("round 2 was not built from its layer delta")
+	}
+	if _, err := os.Stat(f.path("session-1", "round-1")); !os.IsNotExist(err) {
+		t.Error("round 1 is still cached after round 2 replaced it")
+	}
+
+	if err := f.Forget("session-1"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("cache holds %d entries after the session ended", len(entries))
+	}
+}
+
+func TestFetchVerificationFailure(t *testing.T) {
+	server := newModelServer(t)
+	f, _ := newFetcher(t)
+	ctx := context.Background()
+
+	published := map[string][]float64{"w": {1, 2, 3}}
+	tampered := map[string][]float64{"w": {1, 2, 4}}
+	ref := &models.GlobalModelRef{SHA256: Hash(published), Source: server.put(t, "/model.json", tampered)}
+	if _, err := f.Fetch(ctx, "session-1", "round-1", ref); !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("Fetch() error = %v for a tampered model, want ErrIntegrity", err)
+	}
+	if _, err := os.Stat(f.path("session-1", "round-1")); !os.IsNotExist(err) {
+		t.Fatal("a model that failed verification was cached")
+	}
+
+	// Deltas that do not produce the published model fall back to the
+	// full model, which is then verified in turn
+	server.put(t, "/model.json", published)
+	base := map[string][]float64{"w": {1, 2, 2}}
+	if _, err := f.Fetch(ctx, "session-1", "round-0", &models.GlobalModelRef{SHA256: Hash(base), Source: server.put(t, "/base.json", base)}); err != nil {
+		t.Fatal(err)
+	}
+	ref.Delta = &models.GlobalModelDelta{
+		BaseRoundID: "round-0",
+		BaseSHA256:  Hash(base),
+		Layers:      []models.LayerDelta{{Layer: "w", Source: server.put(t, "/delta.json", []float64{0, 0, 2})}},
+	}
+	got, err := f.Fetch(ctx, "session-1", "round-1", ref)
+	if err != nil || !reflect.DeepEqual(got, published) {
+		t.Fatalf("Fetch() = %v, %v, want the full model after bad deltas", got, err)
+	}
+	if server.downloads("/model.json") != 2 {
+		t.Fatalf("full model downloaded %d times, want 2", server.downloads("/model.json"))
+	}
+}
+
+func TestFetchBoundsCachedSessions(t *testing.T) {
+	server := newModelServer(t)
+	f, dir := newFetcher(t)
+	f.SetMaxSessions(2)
+
+	model := map[string][]float64{"w": {1}}
+	ref := &models.GlobalModelRef{SHA256: Hash(model), Source: server.put(t, "/model.json", model)}
+	for _, session := range []string{"a", "b", "a", "c"} {
+		if _, err := f.Fetch(context.Background(), session, "round-1", ref); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, key("b"))); !os.IsNotExist(err) {
+		t.Error("least recently used session was not dropped")
+	}
+	for _, session := range []string{"a", "c"} {
+		if _, err := os.Stat(f.path(session, "round-1")); err != nil {
+			t.Errorf("session %s was dropped: %v", session, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Package flmodel obtains the global model a federated learning round
+// trains from. Models are downloaded whole, or built from per-layer deltas
+// to the previous round's model when the coordinator publishes them, and
+// either way are only used once they match the hash the coordinator
+// published.
+package flmodel
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ErrIntegrity is returned when a global model does not match its published
+// hash
+var ErrIntegrity = errors.New("global model failed verification")
+
+// Hash returns the hash of weights' canonical encoding: for each layer in
+// order of name, the name's length as a big-endian uint32, the name, the
+// number of values as a big-endian uint64 and each value's IEEE 754 bits
+// as a big-endian uint64. Unlike a text encoding it does not depend on how
+// the publisher formats numbers.
+func Hash(weights map[string][]float64) string {
+	names := make([]string, 0, len(weights))
+	for name := range weights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	var buf [8]byte
+	for _, name := range names {
+		binary.BigEndian.PutUint32(buf[:4], uint32(len(name)))
+		hash.Write(buf[:4])
+		hash.Write([]byte(name))
+		values := weights[name]
+		binary.BigEndian.PutUint64(buf[:], uint64(len(values)))
+		hash.Write(buf[:])
+		for _, v := range values {
+			binary.BigEndian.PutUint64(buf[:], math.Float64bits(v))
+			hash.Write(buf[:])
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ApplyDelta returns base with each layer's delta added elementwise. Every
+// delta must name a layer of base and match its length; base is left
+// unchanged.
+func ApplyDelta(base map[string][]float64, deltas map[string][]float64) (map[string][]float64, error) {
+	for layer, delta := range deltas {
+		values, ok := base[layer]
+		if !ok {
+			return nil, fmt.Errorf("delta for unknown layer %s", layer)
+		}
+		if len(delta) != len(values) {
+			return nil, fmt.Errorf("delta for layer %s has %d values, want %d", layer, len(delta), len(values))
+		}
+	}
+	model := make(map[string][]float64, len(base))
+	for layer, values := range base {
+		updated := append([]float64(nil), values...)
+		for i, d := range deltas[layer] {
+			updated[i] += d
+		}
+		model[layer] = updated
+	}
+	return model, nil
+}
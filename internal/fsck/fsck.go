@@ -0,0 +1,265 @@
+// Package fsck checks the runner's on-disk caches and stores after an
+// unclean shutdown. Indexes that disagree with the files they describe are
+// repaired, content that cannot be trusted is moved to a quarantine rather
+// than deleted, and what was found is reported.
+package fsck
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+)
+
+// Action is what fsck did about a problem
+type Action string
+
+const (
+	// ActionRepaired means an index was rewritten to match the disk
+	ActionRepaired Action = "repaired"
+	// ActionQuarantined means content was moved to the quarantine
+	ActionQuarantined Action = "quarantined"
+	// ActionRemoved means a leftover of an interrupted write was deleted
+	ActionRemoved Action = "removed"
+)
+
+// Finding is one problem fsck found and dealt with
+type Finding struct {
+	Store   string `json:"store"`
+	Item    string `json:"item"`
+	Problem string `json:"problem"`
+	Action  Action `json:"action"`
+}
+
+// Check checks one store, repairing and quarantining through q
+type Check struct {
+	Store string
+	Run   func(q *Quarantine) ([]Finding, error)
+}
+
+// Failure is a store fsck could not check
+type Failure struct {
+	Store string `json:"store"`
+	Error string `json:"error"`
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Checked  []string  `json:"checked"`
+	Findings []Finding `json:"findings,omitempty"`
+	Failures []Failure `json:"failures,omitempty"`
+	// Pruned counts quarantined items old enough to be deleted
+	Pruned int `json:"pruned,omitempty"`
+}
+
+// Clean reports whether every store was checked and found sound
+func (r *Report) Clean() bool {
+	return len(r.Findings) == 0 && len(r.Failures) == 0
+}
+
+// Run runs checks in order. A check that fails is reported and the others
+// still run.
+func Run(q *Quarantine, checks ...Check) *Report {
+	report := &Report{}
+	for _, check := range checks {
+		findings, err := check.Run(q)
+		report.Findings = append(report.Findings, findings...)
+		if err != nil {
+			report.Failures = append(report.Failures, Failure{Store: check.Store, Error: err.Error()})
+			continue
+		}
+		report.Checked = append(report.Checked, check.Store)
+	}
+	return report
+}
+
+// Quarantine keeps content fsck took out of service, so that it can be
+// inspected before it is pruned
+type Quarantine struct {
+	dir   string
+	clock clock.Clock
+}
+
+// NewQuarantine keeps quarantined content under dir
+func NewQuarantine(dir string) *Quarantine {
+	return &Quarantine{dir: dir, clock: clock.Real()}
+}
+
+// SetClock replaces the clock quarantined items are stamped with
+func (q *Quarantine) SetClock(c clock.Clock) {
+	q.clock = c
+}
+
+// Move takes path out of service into the quarantine of store. Without a
+// quarantine the content is deleted.
+func (q *Quarantine) Move(store, path string) error {
+	if q == nil {
+		if err := os.RemoveAll(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		return nil
+	}
+	dir := filepath.Join(q.dir, store)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	dest := filepath.Join(dir, fmt.Sprintf("%d-%s", q.clock.Now().UnixNano(), filepath.Base(path)))
+	if err := os.Rename(path, dest); err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", path, err)
+	}
+	return nil
+}
+
+// Prune deletes quarantined items older than maxAge and returns how many
+// it deleted
+func (q *Quarantine) Prune(maxAge time.Duration) (int, error) {
+	stores, err := os.ReadDir(q.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read quarantine: %w", err)
+	}
+	cutoff := q.clock.Now().Add(-maxAge)
+	pruned := 0
+	for _, store := range stores {
+		if !store.IsDir() {
+			continue
+		}
+		items, err := os.ReadDir(filepath.Join(q.dir, store.Name()))
+		if err != nil {
+			return pruned, fmt.Errorf("failed to read quarantine: %w", err)
+		}
+		for _, item := range items {
+			stamp, _, ok := strings.Cut(item.Name(), "-")
+			if !ok {
+				continue
+			}
+			nanos, err := strconv.ParseInt(stamp, 10, 64)
+			if err != nil || time.Unix(0, nanos).After(cutoff) {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(q.dir, store.Name(), item.Name())); err != nil {
+				return pruned, fmt.Errorf("failed to prune quarantine: %w", err)
+			}
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+// RemoveLeftovers deletes the files in dir matching patterns, such as the
+// temporary files of writes a crash interrupted
+func RemoveLeftovers(store, dir string, patterns ...string) ([]Finding, error) {
+	var findings []Finding
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return findings, err
+		}
+		sort.Strings(matches)
+		for _, path := range matches {
+			if err := os.RemoveAll(path); err != nil {
+				return findings, fmt.Errorf("failed to remove %s: %w", path, err)
+			}
+			findings = append(findings, Finding{
+				Store:   store,
+				Item:    filepath.Base(path),
+				Problem: "left behind by an interrupted write",
+				Action:  ActionRemoved,
+			})
+		}
+	}
+	return findings, nil
+}
+
+// CheckJSONFile quarantines path, opened with codec, when it does not hold
+// JSON. A missing file is sound. Content sealed with a key that is not
+// available is left alone, since it cannot be told from damage.
+func CheckJSONFile(store, path string, codec atrest.Codec, q *Quarantine) ([]Finding, error) {
+	findings, err := RemoveLeftovers(store, filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return findings, err
+	}
+	problem, err := jsonProblem(path, codec)
+	if err != nil || problem == "" {
+		return findings, err
+	}
+	if err := q.Move(store, path); err != nil {
+		return findings, err
+	}
+	return append(findings, Finding{Store: store, Item: filepath.Base(path), Problem: problem, Action: ActionQuarantined}), nil
+}
+
+// CheckJSONDir checks every .json file in dir, and in its subdirectories
+// when nested, as CheckJSONFile does
+func CheckJSONDir(store, dir string, codec atrest.Codec, q *Quarantine, nested bool) ([]Finding, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+	}
+	findings, err := RemoveLeftovers(store, dir, "*.json.tmp")
+	if err != nil {
+		return findings, err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		var found []Finding
+		switch {
+		case entry.IsDir() && nested:
+			found, err = CheckJSONDir(store, path, codec, q, false)
+		case !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json"):
+			found, err = CheckJSONFile(store, path, codec, q)
+		default:
+			continue
+		}
+		findings = append(findings, found...)
+		if err != nil {
+			return findings, err
+		}
+	}
+	return findings, nil
+}
+
+// jsonProblem describes what is wrong with the JSON file at path, "" when
+// nothing is or it cannot be told
+func jsonProblem(path string, codec atrest.Codec) (string, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if atrest.IsEncrypted(data) {
+		if codec == nil {
+			return "", nil
+		}
+		data, err = codec.Open(data)
+		if errors.Is(err, atrest.ErrKeyUnavailable) {
+			return "", nil
+		}
+		if err != nil {
+			return "encrypted content failed authentication", nil
+		}
+	} else if codec != nil {
+		if _, err := codec.Migrate(data); errors.Is(err, atrest.ErrPlaintext) {
+			return "unencrypted content in an encrypted store", nil
+		}
+	}
+	if !json.Valid(data) {
+		return "not valid JSON", nil
+	}
+	return "", nil
+}
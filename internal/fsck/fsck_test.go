@@ -0,0 +1,64 @@
+package fsck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+)
+
+func TestCheckJSONDirQuarantinesDamage(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a/sound.json":     `{"task_id": "a"}`,
+		"a/truncated.json": `{"task_id": "b", "out`,
+		"a/sound.json.tmp": `{"task_id"`,
+		"notes.txt":        "not checked",
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o700)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clk := clocktest.NewFake(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := NewQuarantine(t.TempDir())
+	q.SetClock(clk)
+	findings, err := CheckJSONDir("outbox", dir, nil, q, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Finding{
+		{Store: "outbox", Item: "sound.json.tmp", Problem: "left behind by an interrupted write", Action: ActionRemoved},
+		{Store: "outbox", Item: "truncated.json", Problem: "not valid JSON", Action: ActionQuarantined},
+	}
+	if len(findings) != len(want) || findings[0] != want[0] || findings[1] != want[1] {
+		t.Fatalf("findings = %+v, want %+v", findings, want)
+	}
+	for name, kept := range map[string]bool{"a/sound.json": true, "a/truncated.json": false, "notes.txt": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != kept {
+			t.Errorf("%s kept = %v, want %v", name, err == nil, kept)
+		}
+	}
+
+	if pruned, err := q.Prune(time.Hour); err != nil || pruned != 0 {
+		t.Fatalf("Prune() = %d, %v before the quarantine expired", pruned, err)
+	}
+	clk.Advance(2 * time.Hour)
+	if pruned, err := q.Prune(time.Hour); err != nil || pruned != 1 {
+		t.Fatalf("Prune() = %d, %v, want the quarantined file deleted", pruned, err)
+	}
+}
+
+func TestRunReportsFailures(t *testing.T) {
+	report := Run(nil,
+		Check{Store: "sound", Run: func(q *Quarantine) ([]Finding, error) { return nil, nil }},
+		Check{Store: "broken", Run: func(q *Quarantine) ([]Finding, error) { return nil, os.ErrPermission }},
+	)
+	if report.Clean() || len(report.Checked) != 1 || len(report.Failures) != 1 || report.Failures[0].Store != "broken" {
+		t.Fatalf("report = %+v, want the failing store reported and the other checked", report)
+	}
+}
@@ -0,0 +1,331 @@
+package gpu
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// reservation is a models.GPUReservation with the bookkeeping to pick
+// eviction victims
+type reservation struct {
+	models.GPUReservation
+	seq      uint64
+	evicting bool
+}
+
+// Allocator places tasks on GPUs by their declared VRAM. Tasks share a GPU
+// while their declarations, or observed usage where it is larger, plus
+// usage no task accounts for and a safety margin fit in its memory. MIG
+// partitions are assigned whole, one task each.
+type Allocator struct {
+	stats      StatsProvider
+	attributor Attributor
+	margin     uint64
+
+	mu           sync.Mutex
+	devices      []models.GPUDevice
+	processes    []Process
+	owners       map[int]string
+	reservations map[string]*reservation
+	seq          uint64
+}
+
+// NewAllocator keeps margin bytes of every shared GPU free
+func NewAllocator(stats StatsProvider, margin uint64) *Allocator {
+	return &Allocator{
+		stats:        stats,
+		margin:       margin,
+		reservations: make(map[string]*reservation),
+	}
+}
+
+// SetAttributor enables per-task usage tracking, which eviction relies on
+func (a *Allocator) SetAttributor(attributor Attributor) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.attributor = attributor
+}
+
+// Refresh reads current GPU and per-task usage
+func (a *Allocator) Refresh(ctx context.Context) error {
+	devices, err := a.stats.Devices(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read GPUs: %w", err)
+	}
+	processes, err := a.stats.Processes(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read GPU processes: %w", err)
+	}
+
+	a.mu.Lock()
+	attributor := a.attributor
+	a.mu.Unlock()
+
+	var owners map[int]string
+	if attributor != nil && len(processes) > 0 {
+		pids := make([]int, len(processes))
+		for i, p := range processes {
+			pids[i] = p.PID
+		}
+		if owners, err = attributor.Attribute(ctx, pids); err != nil {
+			log := gologger.WithComponent("gpu")
+			log.Debug().Err(err).Msg("Failed to attribute GPU processes to tasks")
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.devices = devices
+	a.processes = processes
+	a.owners = owners
+	a.observe()
+	return nil
+}
+
+// observe sums the usage of each reservation's processes. Callers hold mu.
+func (a *Allocator) observe() {
+	for _, r := range a.reservations {
+		r.ObservedBytes = 0
+	}
+	for _, p := range a.processes {
+		if r, ok := a.reservations[a.owners[p.PID]]; ok {
+			r.ObservedBytes += p.UsedBytes
+		}
+	}
+}
+
+// committed returns the larger of declared and observed usage
+func committed(r *reservation) uint64 {
+	return max(r.DeclaredBytes, r.ObservedBytes)
+}
+
+// demand returns the memory of a shared device accounted for: reservations
+// at their committed size, usage no reservation explains, and the margin.
+// Callers hold mu.
+func (a *Allocator) demand(device models.GPUDevice) uint64 {
+	var reserved, observed uint64
+	for _, r := range a.reservations {
+		if r.Device == device.UUID {
+			reserved += committed(r)
+			observed += r.ObservedBytes
+		}
+	}
+	var unattributed uint64
+	if device.UsedBytes > observed {
+		unattributed = device.UsedBytes - observed
+	}
+	return reserved + unattributed + a.margin
+}
+
+// partitionTaken reports whether a MIG partition is reserved. Callers hold mu.
+func (a *Allocator) partitionTaken(uuid string) bool {
+	for _, r := range a.reservations {
+		if r.Partition && r.Device == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// Reserve places a task needing bytes of VRAM on the MIG partition or
+// shared GPU it fits most tightly. It returns ErrGPUBusy when the task only
+// fits once other tasks finish and ErrExceedsGPU when it never fits.
+func (a *Allocator) Reserve(ctx context.Context, taskID string, bytes uint64, priority int) (models.GPUReservation, error) {
+	return a.ReserveOn(ctx, taskID, bytes, priority, nil)
+}
+
+// ReserveOn is Reserve limited to the GPUs, and their MIG partitions, whose
+// index or UUID is in devices, or to every GPU when devices is nil
+func (a *Allocator) ReserveOn(ctx context.Context, taskID string, bytes uint64, priority int, devices []string) (models.GPUReservation, error) {
+	if err := a.Refresh(ctx); err != nil {
+		return models.GPUReservation{}, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if r, ok := a.reservations[taskID]; ok {
+		return r.GPUReservation, nil
+	}
+
+	var best *reservation
+	var bestFree uint64
+	fitsIdle := false
+	consider := func(device string, partition bool, free, capacity uint64) {
+		if bytes <= capacity {
+			fitsIdle = true
+		}
+		if bytes > free || (best != nil && free >= bestFree) {
+			return
+		}
+		best = &reservation{GPUReservation: models.GPUReservation{
+			TaskID:        taskID,
+			Device:        device,
+			Partition:     partition,
+			DeclaredBytes: bytes,
+			Priority:      priority,
+		}}
+		bestFree = free
+	}
+
+	for _, device := range a.devices {
+		if devices != nil && !matchesDevice(device, devices) {
+			continue
+		}
+		if len(device.Partitions) > 0 {
+			for _, p := range device.Partitions {
+				free := p.MemoryBytes
+				if a.partitionTaken(p.UUID) {
+					free = 0
+				}
+				consider(p.UUID, true, free, p.MemoryBytes)
+			}
+			continue
+		}
+
+		var capacity, free uint64
+		if device.TotalBytes > a.margin {
+			capacity = device.TotalBytes - a.margin
+		}
+		if demand := a.demand(device); device.TotalBytes > demand {
+			free = device.TotalBytes - demand
+		}
+		consider(device.UUID, false, free, capacity)
+	}
+
+	if best == nil {
+		if !fitsIdle {
+			return models.GPUReservation{}, fmt.Errorf("%w: %d MiB", ErrExceedsGPU, bytes>>20)
+		}
+		return models.GPUReservation{}, fmt.Errorf("%w: %d MiB requested", ErrGPUBusy, bytes>>20)
+	}
+
+	a.seq++
+	best.seq = a.seq
+	a.reservations[taskID] = best
+	return best.GPUReservation, nil
+}
+
+// matchesDevice reports whether device is named in devices by index or UUID
+func matchesDevice(device models.GPUDevice, devices []string) bool {
+	for _, name := range devices {
+		if name == device.UUID || name == strconv.Itoa(device.Index) {
+			return true
+		}
+	}
+	return false
+}
+
+// Release frees the VRAM reserved for a task
+func (a *Allocator) Release(taskID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.reservations, taskID)
+}
+
+// Len returns the number of reservations held
+func (a *Allocator) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.reservations)
+}
+
+// Observe refreshes per-task usage and returns the tasks to evict. When a
+// task uses more than it declared and a shared GPU no longer holds every
+// task's usage, the lowest priority task on that GPU is chosen, the most
+// recently placed first among equals. Tasks over their declaration on a
+// GPU with room to spare are only logged.
+func (a *Allocator) Observe(ctx context.Context) ([]models.GPUReservation, error) {
+	if err := a.Refresh(ctx); err != nil {
+		return nil, err
+	}
+
+	log := gologger.WithComponent("gpu")
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var evict []models.GPUReservation
+	for _, device := range a.devices {
+		var onDevice, over []*reservation
+		for _, r := range a.reservations {
+			if r.Device != device.UUID || r.Partition {
+				continue
+			}
+			onDevice = append(onDevice, r)
+			if r.ObservedBytes > r.DeclaredBytes {
+				over = append(over, r)
+			}
+		}
+		if len(over) == 0 {
+			continue
+		}
+
+		demand := a.demand(device)
+		for _, r := range over {
+			log.Warn().
+				Str("task_id", r.TaskID).
+				Str("device", device.UUID).
+				Uint64("declared_bytes", r.DeclaredBytes).
+				Uint64("observed_bytes", r.ObservedBytes).
+				Bool("overcommitted", demand > device.TotalBytes).
+				Msg("Task is using more GPU memory than it declared")
+		}
+		if demand <= device.TotalBytes {
+			continue
+		}
+
+		var victim *reservation
+		for _, r := range onDevice {
+			if r.evicting {
+				continue
+			}
+			if victim == nil || r.Priority < victim.Priority ||
+				(r.Priority == victim.Priority && r.seq > victim.seq) {
+				victim = r
+			}
+		}
+		if victim != nil {
+			victim.evicting = true
+			evict = append(evict, victim.GPUReservation)
+		}
+	}
+	return evict, nil
+}
+
+// Snapshot returns the GPUs as last observed with the reservations on them
+func (a *Allocator) Snapshot() *models.GPUCapacity {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	capacity := &models.GPUCapacity{Devices: make([]models.GPUDevice, len(a.devices))}
+	for i, device := range a.devices {
+		device.Partitions = append([]models.GPUPartition(nil), device.Partitions...)
+		for _, r := range a.reservations {
+			if r.Device == device.UUID {
+				device.ReservedBytes += r.DeclaredBytes
+			}
+			for j := range device.Partitions {
+				if r.Partition && r.Device == device.Partitions[j].UUID {
+					device.Partitions[j].TaskID = r.TaskID
+					device.ReservedBytes += r.DeclaredBytes
+				}
+			}
+		}
+		capacity.Devices[i] = device
+	}
+	for _, r := range a.reservations {
+		capacity.Reservations = append(capacity.Reservations, r.GPUReservation)
+	}
+	sort.Slice(capacity.Reservations, func(i, j int) bool {
+		return capacity.Reservations[i].TaskID < capacity.Reservations[j].TaskID
+	})
+	return capacity
+}
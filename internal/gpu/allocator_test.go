@@ -0,0 +1,273 @@
+package gpu
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const gib = 1 << 30
+
+// fakeStats is a GPU whose per-process usage the test sets
+type fakeStats struct {
+	devices   []models.GPUDevice
+	processes []Process
+}
+
+func (f *fakeStats) Devices(ctx context.Context) ([]models.GPUDevice, error) {
+	devices := make([]models.GPUDevice, len(f.devices))
+	copy(devices, f.devices)
+	for i := range devices {
+		devices[i].UsedBytes = 0
+		for _, p := range f.processes {
+			if p.Device == devices[i].UUID {
+				devices[i].UsedBytes += p.UsedBytes
+			}
+		}
+	}
+	return devices, nil
+}
+
+func (f *fakeStats) Processes(ctx context.Context) ([]Process, error) {
+	return f.processes, nil
+}
+
+// use sets the usage of the process pid, running task on device
+func (f *fakeStats) use(pid int, device string, bytes uint64) {
+	for i := range f.processes {
+		if f.processes[i].PID == pid {
+			f.processes[i].UsedBytes = bytes
+			return
+		}
+	}
+	f.processes = append(f.processes, Process{PID: pid, Device: device, UsedBytes: bytes})
+}
+
+type pidOwners map[int]string
+
+func (p pidOwners) Attribute(ctx context.Context, pids []int) (map[int]string, error) {
+	return p, nil
+}
+
+func TestReserveSharesGPUWhileDeclarationsFit(t *testing.T) {
+	ctx := context.Background()
+	stats := &fakeStats{devices: []models.GPUDevice{{UUID: "GPU-a", TotalBytes: 24 * gib}}}
+	a := NewAllocator(stats, 1*gib)
+
+	llm, err := a.Reserve(ctx, "llm", 14*gib, 10)
+	if err != nil {
+		t.Fatalf("Reserve(llm) error = %v", err)
+	}
+	if llm.Device != "GPU-a" {
+		t.Fatalf("llm placed on %q", llm.Device)
+	}
+	if _, err := a.Reserve(ctx, "fl", 8*gib, 0); err != nil {
+		t.Fatalf("Reserve(fl) error = %v, want it to fit beside the LLM", err)
+	}
+
+	if _, err := a.Reserve(ctx, "third", 2*gib, 0); !errors.Is(err, ErrGPUBusy) {
+		t.Fatalf("Reserve(third) error = %v, want ErrGPUBusy within the safety margin", err)
+	}
+	if _, err := a.Reserve(ctx, "huge", 40*gib, 0); !errors.Is(err, ErrExceedsGPU) {
+		t.Fatalf("Reserve(huge) error = %v, want ErrExceedsGPU", err)
+	}
+
+	a.Release("fl")
+	if _, err := a.Reserve(ctx, "third", 2*gib, 0); err != nil {
+		t.Fatalf("Reserve(third) error = %v after the FL task finished", err)
+	}
+
+	snapshot := a.Snapshot()
+	if len(snapshot.Reservations) != 2 || snapshot.Devices[0].ReservedBytes != 16*gib {
+		t.Fatalf("snapshot = %+v, want 2 reservations holding 16GiB", snapshot)
+	}
+}
+
+func TestReserveCountsUsageNoTaskAccountsFor(t *testing.T) {
+	ctx := context.Background()
+	stats := &fakeStats{devices: []models.GPUDevice{{UUID: "GPU-a", TotalBytes: 24 * gib}}}
+	stats.use(100, "GPU-a", 10*gib) // a desktop session or a model loaded outside the runner
+	a := NewAllocator(stats, 1*gib)
+
+	if _, err := a.Reserve(ctx, "fl", 14*gib, 0); !errors.Is(err, ErrGPUBusy) {
+		t.Fatalf("Reserve() error = %v, want ErrGPUBusy", err)
+	}
+	if _, err := a.Reserve(ctx, "fl", 12*gib, 0); err != nil {
+		t.Fatalf("Reserve() error = %v", err)
+	}
+}
+
+func TestReserveOnKeepsToPinnedDevices(t *testing.T) {
+	ctx := context.Background()
+	stats := &fakeStats{devices: []models.GPUDevice{
+		{Index: 0, UUID: "GPU-a", TotalBytes: 24 * gib},
+		{Index: 1, UUID: "GPU-b", TotalBytes: 48 * gib},
+	}}
+	a := NewAllocator(stats, 1*gib)
+
+	r, err := a.ReserveOn(ctx, "by-uuid", 14*gib, 0, []string{"GPU-b"})
+	if err != nil || r.Device != "GPU-b" {
+		t.Fatalf("ReserveOn(GPU-b) = %+v, %v, want GPU-b", r, err)
+	}
+	if _, err := a.ReserveOn(ctx, "too-big", 30*gib, 0, []string{"0"}); !errors.Is(err, ErrExceedsGPU) {
+		t.Fatalf("ReserveOn(0) error = %v, want ErrExceedsGPU though another GPU fits it", err)
+	}
+	r, err = a.ReserveOn(ctx, "by-index", 14*gib, 0, []string{"0"})
+	if err != nil || r.Device != "GPU-a" {
+		t.Fatalf("ReserveOn(0) = %+v, %v, want GPU-a", r, err)
+	}
+	if r, err := a.Reserve(ctx, "any", 30*gib, 0); err != nil || r.Device != "GPU-b" {
+		t.Fatalf("Reserve() = %+v, %v, want any GPU that fits", r, err)
+	}
+}
+
+func TestObserveEvictsLowestPriorityOnOvercommit(t *testing.T) {
+	ctx := context.Background()
+	stats := &fakeStats{devices: []models.GPUDevice{{UUID: "GPU-a", TotalBytes: 24 * gib}}}
+	a := NewAllocator(stats, 1*gib)
+	a.SetAttributor(pidOwners{1: "llm", 2: "fl", 3: "batch"})
+
+	for _, r := range []struct {
+		task     string
+		bytes    uint64
+		priority int
+	}{{"llm", 12 * gib, 10}, {"fl", 6 * gib, 5}, {"batch", 4 * gib, 5}} {
+		if _, err := a.Reserve(ctx, r.task, r.bytes, r.priority); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Over its declaration, but the GPU still has room: warn only
+	stats.use(1, "GPU-a", 12*gib)
+	stats.use(2, "GPU-a", 7*gib)
+	stats.use(3, "GPU-a", 1*gib)
+	evict, err := a.Observe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evict) != 0 {
+		t.Fatalf("evicted %v while the GPU had room", evict)
+	}
+
+	// The FL task grows until the GPU is overcommitted. Of the two lowest
+	// priority tasks the most recently placed goes first.
+	stats.use(2, "GPU-a", 9*gib)
+	evict, err = a.Observe(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evict) != 1 || evict[0].TaskID != "batch" {
+		t.Fatalf("evicted %v, want batch", evict)
+	}
+	if evict[0].ObservedBytes != 1*gib {
+		t.Fatalf("observed %d bytes for batch, want 1GiB", evict[0].ObservedBytes)
+	}
+
+	// While batch is still stopping it is not chosen again
+	evict, _ = a.Observe(ctx)
+	if len(evict) != 1 || evict[0].TaskID != "fl" {
+		t.Fatalf("evicted %v, want fl next", evict)
+	}
+
+	snapshot := a.Snapshot()
+	for _, r := range snapshot.Reservations {
+		if r.TaskID == "fl" && r.ObservedBytes != 9*gib {
+			t.Fatalf("snapshot reports %d observed bytes for fl, want 9GiB", r.ObservedBytes)
+		}
+	}
+}
+
+func TestReserveAssignsWholeMIGPartitions(t *testing.T) {
+	ctx := context.Background()
+	stats := &fakeStats{devices: []models.GPUDevice{{
+		UUID:       "GPU-a100",
+		TotalBytes: 40 * gib,
+		Partitions: []models.GPUPartition{
+			{UUID: "MIG-big", Profile: "3g.20gb", MemoryBytes: 20 * gib},
+			{UUID: "MIG-mid", Profile: "2g.10gb", MemoryBytes: 10 * gib},
+			{UUID: "MIG-small", Profile: "1g.5gb", MemoryBytes: 5 * gib},
+		},
+	}}}
+	a := NewAllocator(stats, 1*gib)
+
+	small, err := a.Reserve(ctx, "small", 4*gib, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small.Device != "MIG-small" || !small.Partition {
+		t.Fatalf("4GiB task placed on %+v, want the 1g.5gb partition", small)
+	}
+
+	// The tightest free partition is taken, leaving the largest for big tasks
+	next, err := a.Reserve(ctx, "next", 3*gib, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Device != "MIG-mid" {
+		t.Fatalf("3GiB task placed on %q, want MIG-mid", next.Device)
+	}
+
+	if _, err := a.Reserve(ctx, "wide", 12*gib, 0); err != nil {
+		t.Fatalf("Reserve(wide) error = %v", err)
+	}
+	if _, err := a.Reserve(ctx, "more", 1*gib, 0); !errors.Is(err, ErrGPUBusy) {
+		t.Fatalf("Reserve() error = %v, want ErrGPUBusy with every partition taken", err)
+	}
+	if _, err := a.Reserve(ctx, "too-big", 30*gib, 0); !errors.Is(err, ErrExceedsGPU) {
+		t.Fatalf("Reserve() error = %v, want ErrExceedsGPU beyond the largest partition", err)
+	}
+
+	snapshot := a.Snapshot()
+	for _, p := range snapshot.Devices[0].Partitions {
+		if p.TaskID == "" {
+			t.Fatalf("partition %s reported free", p.UUID)
+		}
+	}
+}
+
+func TestParseNvidiaSMI(t *testing.T) {
+	devices, err := parseDevices("0, GPU-5d5b, NVIDIA GeForce RTX 4090, 24564, 1893\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 1 || devices[0].TotalBytes != 24564*mib || devices[0].UsedBytes != 1893*mib || devices[0].Name != "NVIDIA GeForce RTX 4090" {
+		t.Fatalf("parseDevices() = %+v", devices)
+	}
+
+	processes, err := parseProcesses("4012, GPU-5d5b, 1620\n5120, MIG-c6d4, [N/A]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(processes) != 2 || processes[0].UsedBytes != 1620*mib || processes[1].UsedBytes != 0 {
+		t.Fatalf("parseProcesses() = %+v", processes)
+	}
+
+	partitions := parsePartitions(`GPU 0: NVIDIA A100-SXM4-40GB (UUID: GPU-5d5b)
+  MIG 3g.20gb     Device  0: (UUID: MIG-c6d4)
+  MIG 1g.5gb      Device  1: (UUID: MIG-91aa)
+GPU 1: NVIDIA A100-SXM4-40GB (UUID: GPU-77e0)
+`)
+	if got := partitions["GPU-5d5b"]; len(got) != 2 || got[0].MemoryBytes != 20*gib || got[1].UUID != "MIG-91aa" {
+		t.Fatalf("parsePartitions() = %+v", partitions)
+	}
+	if len(partitions["GPU-77e0"]) != 0 {
+		t.Fatalf("GPU without MIG reported partitions %+v", partitions["GPU-77e0"])
+	}
+}
+
+func TestParseBytes(t *testing.T) {
+	for in, want := range map[string]uint64{"8g": 8 * gib, "512MiB": 512 * mib, "1.5GB": 3 * gib / 2, "1024": 1024, "": 0} {
+		got, err := ParseBytes(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseBytes(%q) = %d, %v, want %d", in, got, err, want)
+		}
+	}
+	if _, err := ParseBytes("8 parsecs"); err == nil {
+		t.Fatal("ParseBytes accepted an unknown unit")
+	}
+	if _, err := ParseBytes("1e30t"); err == nil {
+		t.Fatal("ParseBytes accepted a size that overflows")
+	}
+}
@@ -0,0 +1,48 @@
+package gpu
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+var containerIDPattern = regexp.MustCompile(`[0-9a-f]{64}`)
+
+// ContainerAttributor attributes processes to the tasks whose containers
+// they run in, found from each process's cgroup
+type ContainerAttributor struct {
+	// containers maps full container IDs to the task each runs
+	containers func(ctx context.Context) (map[string]string, error)
+	procRoot   string
+}
+
+func NewContainerAttributor(containers func(ctx context.Context) (map[string]string, error)) *ContainerAttributor {
+	return &ContainerAttributor{containers: containers, procRoot: "/proc"}
+}
+
+func (a *ContainerAttributor) Attribute(ctx context.Context, pids []int) (map[int]string, error) {
+	if len(pids) == 0 {
+		return nil, nil
+	}
+	containers, err := a.containers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tasks := make(map[int]string)
+	for _, pid := range pids {
+		cgroup, err := os.ReadFile(filepath.Join(a.procRoot, strconv.Itoa(pid), "cgroup"))
+		if err != nil {
+			continue
+		}
+		for _, id := range containerIDPattern.FindAllString(string(cgroup), -1) {
+			if task, ok := containers[id]; ok {
+				tasks[pid] = task
+				break
+			}
+		}
+	}
+	return tasks, nil
+}
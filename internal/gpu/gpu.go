@@ -0,0 +1,95 @@
+// Package gpu accounts for GPU memory so that tasks declaring their VRAM
+// needs can share a GPU, or be given their own MIG partition
+package gpu
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var (
+	// ErrGPUBusy is returned when a task's VRAM does not fit beside the
+	// reservations already held, but would on an idle GPU
+	ErrGPUBusy = errors.New("not enough free GPU memory")
+	// ErrExceedsGPU is returned when no GPU or partition is large enough
+	ErrExceedsGPU = errors.New("task needs more GPU memory than any device provides")
+	// ErrEvicted is the cancellation cause of a task evicted from an
+	// overcommitted GPU
+	ErrEvicted = errors.New("evicted from an overcommitted GPU")
+)
+
+// Process is a process using GPU memory
+type Process struct {
+	PID int
+	// Device is the UUID of the GPU or MIG partition the process runs on
+	Device    string
+	UsedBytes uint64
+}
+
+// StatsProvider reports GPUs and the processes using them
+type StatsProvider interface {
+	Devices(ctx context.Context) ([]models.GPUDevice, error)
+	Processes(ctx context.Context) ([]Process, error)
+}
+
+// Attributor maps GPU processes to the tasks that started them. Processes
+// it cannot attribute count against the GPU but no task.
+type Attributor interface {
+	Attribute(ctx context.Context, pids []int) (map[int]string, error)
+}
+
+type deviceKey struct{}
+
+// WithDevice returns ctx carrying the GPU or MIG partition assigned to a task
+func WithDevice(ctx context.Context, uuid string) context.Context {
+	return context.WithValue(ctx, deviceKey{}, uuid)
+}
+
+// DeviceFromContext returns the GPU or MIG partition assigned to the task
+// running under ctx, if any
+func DeviceFromContext(ctx context.Context) (string, bool) {
+	uuid, ok := ctx.Value(deviceKey{}).(string)
+	return uuid, ok && uuid != ""
+}
+
+// ParseBytes parses a memory size such as "8g", "512MiB" or "2GB". Units
+// are binary and a bare number is bytes.
+func ParseBytes(s string) (uint64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	number := strings.TrimRight(s, "abcdefghijklmnopqrstuvwxyz")
+	unit := strings.TrimSpace(s[len(number):])
+
+	shift := 0
+	switch strings.TrimSuffix(strings.TrimSuffix(unit, "b"), "i") {
+	case "":
+	case "k":
+		shift = 10
+	case "m":
+		shift = 20
+	case "g":
+		shift = 30
+	case "t":
+		shift = 40
+	default:
+		return 0, fmt.Errorf("unknown memory unit %q", unit)
+	}
+
+	value, err := strconv.ParseFloat(strings.TrimSpace(number), 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	bytes := value * float64(uint64(1)<<shift)
+	if bytes >= math.MaxUint64 {
+		return 0, fmt.Errorf("memory size %q is too large", s)
+	}
+	return uint64(bytes), nil
+}
@@ -0,0 +1,166 @@
+package gpu
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const mib = 1 << 20
+
+// NvidiaSMI reads GPU and per-process memory usage with nvidia-smi
+type NvidiaSMI struct {
+	path string
+}
+
+// NewNvidiaSMI returns a provider using the nvidia-smi on PATH, or nil when
+// there is none
+func NewNvidiaSMI() *NvidiaSMI {
+	path, err := exec.LookPath("nvidia-smi")
+	if err != nil {
+		return nil
+	}
+	return &NvidiaSMI{path: path}
+}
+
+func (n *NvidiaSMI) run(ctx context.Context, args ...string) (string, error) {
+	out, err := exec.CommandContext(ctx, n.path, args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("nvidia-smi %s failed: %w", args[0], err)
+	}
+	return string(out), nil
+}
+
+func (n *NvidiaSMI) Devices(ctx context.Context) ([]models.GPUDevice, error) {
+	out, err := n.run(ctx, "--query-gpu=index,uuid,name,memory.total,memory.used", "--format=csv,noheader,nounits")
+	if err != nil {
+		return nil, err
+	}
+	devices, err := parseDevices(out)
+	if err != nil {
+		return nil, err
+	}
+
+	listing, err := n.run(ctx, "-L")
+	if err != nil {
+		return nil, err
+	}
+	partitions := parsePartitions(listing)
+	for i := range devices {
+		devices[i].Partitions = partitions[devices[i].UUID]
+	}
+	return devices, nil
+}
+
+func (n *NvidiaSMI) Processes(ctx context.Context) ([]Process, error) {
+	out, err := n.run(ctx, "--query-compute-apps=pid,gpu_uuid,used_memory", "--format=csv,noheader,nounits")
+	if err != nil {
+		return nil, err
+	}
+	return parseProcesses(out)
+}
+
+func csvFields(line string) []string {
+	fields := strings.Split(line, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields
+}
+
+// parseDevices parses index, uuid, name, memory.total and memory.used rows
+// reported in MiB
+func parseDevices(out string) ([]models.GPUDevice, error) {
+	var devices []models.GPUDevice
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := csvFields(line)
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("unexpected nvidia-smi GPU row %q", line)
+		}
+		index, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid GPU index %q", fields[0])
+		}
+		total, err := strconv.ParseUint(fields[3], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GPU memory %q", fields[3])
+		}
+		used, err := strconv.ParseUint(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GPU memory %q", fields[4])
+		}
+		devices = append(devices, models.GPUDevice{
+			Index:      index,
+			UUID:       fields[1],
+			Name:       fields[2],
+			TotalBytes: total * mib,
+			UsedBytes:  used * mib,
+		})
+	}
+	return devices, nil
+}
+
+// parseProcesses parses pid, gpu_uuid and used_memory rows reported in MiB
+func parseProcesses(out string) ([]Process, error) {
+	var processes []Process
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields := csvFields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected nvidia-smi process row %q", line)
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid process id %q", fields[0])
+		}
+		// Usage is "[N/A]" for processes in MIG partitions without
+		// permission to query them
+		used, _ := strconv.ParseUint(fields[2], 10, 64)
+		processes = append(processes, Process{PID: pid, Device: fields[1], UsedBytes: used * mib})
+	}
+	return processes, nil
+}
+
+var (
+	gpuLine = regexp.MustCompile(`^GPU \d+: .*\(UUID: (GPU-[^)]+)\)`)
+	migLine = regexp.MustCompile(`^\s+MIG (\S+)\s+Device\s+\d+: \(UUID: (MIG-[^)]+)\)`)
+	// migMemory reads the memory size from a MIG profile such as 3g.20gb
+	migMemory = regexp.MustCompile(`\.(\d+)gb$`)
+)
+
+// parsePartitions parses the MIG instances listed by nvidia-smi -L under
+// each GPU
+func parsePartitions(out string) map[string][]models.GPUPartition {
+	partitions := make(map[string][]models.GPUPartition)
+	var gpu string
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := gpuLine.FindStringSubmatch(line); m != nil {
+			gpu = m[1]
+			continue
+		}
+		m := migLine.FindStringSubmatch(line)
+		if m == nil || gpu == "" {
+			continue
+		}
+		partition := models.GPUPartition{UUID: m[2], Profile: m[1]}
+		if size := migMemory.FindStringSubmatch(m[1]); size != nil {
+			gb, _ := strconv.ParseUint(size[1], 10, 64)
+			partition.MemoryBytes = gb << 30
+		}
+		partitions[gpu] = append(partitions[gpu], partition)
+	}
+	return partitions
+}
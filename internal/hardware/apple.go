@@ -0,0 +1,50 @@
+package hardware
+
+import (
+	"strconv"
+	"strings"
+)
+
+// sysctlFunc reads a single sysctl value by name
+type sysctlFunc func(name string) (string, error)
+
+// detectApple fills in Apple specific details using the given sysctl probe.
+// Apple Silicon is identified by hw.optional.arm64 and an "Apple" brand string;
+// Intel Macs keep the discrete memory model and no Metal compute class.
+func detectApple(p *Profile, sysctl sysctlFunc) {
+	if brand, err := sysctl("machdep.cpu.brand_string"); err == nil {
+		p.CPUModel = strings.TrimSpace(brand)
+	}
+
+	if memsize, err := sysctl("hw.memsize"); err == nil {
+		if n, err := strconv.ParseUint(strings.TrimSpace(memsize), 10, 64); err == nil {
+			p.TotalMemoryBytes = n
+		}
+	}
+
+	if cores, err := sysctl("hw.ncpu"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(cores)); err == nil && n > 0 {
+			p.CPUCores = n
+		}
+	}
+
+	arm64, err := sysctl("hw.optional.arm64")
+	if err != nil || strings.TrimSpace(arm64) != "1" {
+		return
+	}
+
+	if !strings.HasPrefix(p.CPUModel, "Apple") {
+		return
+	}
+
+	p.AppleChip = p.CPUModel
+	p.UnifiedMemory = true
+	p.MetalSupported = true
+	p.Accelerator = AcceleratorMetal
+
+	if gpuCores, err := sysctl("machdep.gpu.core_count"); err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(gpuCores)); err == nil {
+			p.GPUCores = n
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package hardware
+
+import (
+	"fmt"
+	"testing"
+)
+
+func mockSysctl(values map[string]string) sysctlFunc {
+	return func(name string) (string, error) {
+		if v, ok := values[name]; ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("unknown oid %s", name)
+	}
+}
+
+func TestDetectAppleSilicon(t *testing.T) {
+	p := baseProfile()
+	detectApple(p, mockSysctl(map[string]string{
+		"machdep.cpu.brand_string": "Apple M2 Pro",
+		"hw.memsize":               "34359738368",
+		"hw.ncpu":                  "12",
+		"hw.optional.arm64":        "1",
+		"machdep.gpu.core_count":   "19",
+	}))
+
+	if p.Accelerator != AcceleratorMetal {
+		t.Errorf("Expected metal accelerator, got %s", p.Accelerator)
+	}
+	if !p.UnifiedMemory || !p.MetalSupported {
+		t.Error("Expected unified memory and Metal support on Apple Silicon")
+	}
+	if p.AppleChip != "Apple M2 Pro" {
+		t.Errorf("Expected chip Apple M2 Pro, got %q", p.AppleChip)
+	}
+	if p.CPUCores != 12 || p.GPUCores != 19 {
+		t.Errorf("Unexpected core counts: cpu=%d gpu=%d", p.CPUCores, p.GPUCores)
+	}
+	if !p.SupportsAccelerator("metal") || p.SupportsAccelerator("cuda") {
+		t.Error("Accelerator matching does not reflect Metal profile")
+	}
+}
+
+func TestDetectIntelMac(t *testing.T) {
+	p := baseProfile()
+	detectApple(p, mockSysctl(map[string]string{
+		"machdep.cpu.brand_string": "Intel(R) Core(TM) i7-9750H CPU @ 2.60GHz",
+		"hw.memsize":               "17179869184",
+	}))
+
+	if p.Accelerator != AcceleratorNone {
+		t.Errorf("Expected no accelerator on Intel Mac, got %s", p.Accelerator)
+	}
+	if p.UnifiedMemory {
+		t.Error("Intel Mac should not report unified memory")
+	}
+	if p.ModelMemoryBudget() != 17179869184 {
+		t.Errorf("Expected full memory budget, got %d", p.ModelMemoryBudget())
+	}
+}
+
+func TestUnifiedMemoryModelFit(t *testing.T) {
+	p := &Profile{TotalMemoryBytes: 16 << 30, UnifiedMemory: true}
+
+	// 16GB unified memory leaves roughly 10.6GB for the GPU working set
+	if !p.FitsModel(4 << 30) {
+		t.Error("Expected 4GB model to fit in 16GB unified memory")
+	}
+	if p.FitsModel(10 << 30) {
+		t.Error("Expected 10GB model not to fit once KV cache headroom is included")
+	}
+
+	large := &Profile{TotalMemoryBytes: 64 << 30, UnifiedMemory: true}
+	if large.ModelMemoryBudget() != 48<<30 {
+		t.Errorf("Expected 48GB budget on 64GB machine, got %d", large.ModelMemoryBudget())
+	}
+}
@@ -0,0 +1,299 @@
+package hardware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+)
+
+const (
+	// bandwidthWarmupFraction of each transfer is excluded from throughput so
+	// TCP slow start does not understate sustained bandwidth
+	bandwidthWarmupFraction = 0.1
+	bandwidthLatencySamples = 5
+
+	// Daily re-measurements run at a random time inside this local window so
+	// runners do not probe the server at the same moment
+	offPeakStartHour = 2
+	offPeakHours     = 3
+)
+
+// NetworkProfile is the measured network capacity of the runner
+type NetworkProfile struct {
+	DownloadMbps float64   `json:"download_mbps"`
+	UploadMbps   float64   `json:"upload_mbps"`
+	LatencyMs    float64   `json:"latency_ms"`
+	MeasuredAt   time.Time `json:"measured_at"`
+}
+
+// SupportsBandwidth reports whether a task requiring minMbps in both
+// directions would not be bottlenecked here. Unmeasured runners are not
+// excluded, since bandwidth probing is opt-in.
+func (n *NetworkProfile) SupportsBandwidth(minMbps float64) bool {
+	if minMbps <= 0 || n == nil {
+		return true
+	}
+	return n.DownloadMbps >= minMbps && n.UploadMbps >= minMbps
+}
+
+type BandwidthProbeConfig struct {
+	// Endpoint serves GET {Endpoint}/download?bytes=N and accepts POST {Endpoint}/upload
+	Endpoint      string
+	DownloadBytes int64
+	UploadBytes   int64
+	Timeout       time.Duration
+}
+
+// BandwidthProbe measures sustained throughput and latency against an endpoint
+type BandwidthProbe struct {
+	config BandwidthProbeConfig
+	client *http.Client
+	clock  clock.Clock
+}
+
+func NewBandwidthProbe(config BandwidthProbeConfig) *BandwidthProbe {
+	if config.DownloadBytes <= 0 {
+		config.DownloadBytes = 25 << 20
+	}
+	if config.UploadBytes <= 0 {
+		config.UploadBytes = config.DownloadBytes
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 2 * time.Minute
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &BandwidthProbe{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		clock:  clock.Real(),
+	}
+}
+
+// SetClock replaces the clock that schedules daily re-measurement. Transfer
+// timings always use wall time since they measure the real network.
+func (p *BandwidthProbe) SetClock(c clock.Clock) {
+	p.clock = c
+}
+
+// Measure runs the latency, download and upload probes in sequence
+func (p *BandwidthProbe) Measure(ctx context.Context) (*NetworkProfile, error) {
+	latency, err := p.measureLatency(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("latency probe failed: %w", err)
+	}
+	download, err := p.measureDownload(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("download probe failed: %w", err)
+	}
+	upload, err := p.measureUpload(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("upload probe failed: %w", err)
+	}
+
+	return &NetworkProfile{
+		DownloadMbps: download,
+		UploadMbps:   upload,
+		LatencyMs:    float64(latency.Microseconds()) / 1000,
+		MeasuredAt:   p.clock.Now().UTC(),
+	}, nil
+}
+
+// RunDaily re-measures once a day at a random off-peak time, passing every
+// successful measurement to report until ctx is cancelled
+func (p *BandwidthProbe) RunDaily(ctx context.Context, report func(*NetworkProfile)) {
+	log := gologger.WithComponent("bandwidth")
+
+	for {
+		now := p.clock.Now()
+		timer := p.clock.NewTimer(nextOffPeak(now, rand.Float64()).Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		profile, err := p.Measure(ctx)
+		if err != nil {
+			log.Warn().Err(err).Msg("Bandwidth measurement failed")
+			continue
+		}
+		LogNetworkProfile(profile)
+		report(profile)
+	}
+}
+
+func LogNetworkProfile(profile *NetworkProfile) {
+	log := gologger.WithComponent("bandwidth")
+	log.Info().
+		Float64("download_mbps", profile.DownloadMbps).
+		Float64("upload_mbps", profile.UploadMbps).
+		Float64("latency_ms", profile.LatencyMs).
+		Msg("Measured network bandwidth")
+}
+
+// nextOffPeak returns a time in tomorrow's off-peak window; r in [0,1)
+// selects the offset within the window
+func nextOffPeak(now time.Time, r float64) time.Time {
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, offPeakStartHour, 0, 0, 0, now.Location())
+	return tomorrow.Add(time.Duration(r * float64(offPeakHours*time.Hour)))
+}
+
+func (p *BandwidthProbe) measureLatency(ctx context.Context) (time.Duration, error) {
+	samples := make([]time.Duration, 0, bandwidthLatencySamples)
+	for i := 0; i < bandwidthLatencySamples; i++ {
+		start := time.Now()
+		resp, err := p.get(ctx, 0)
+		if err != nil {
+			return 0, err
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		samples = append(samples, time.Since(start))
+	}
+
+	// The first request includes connection setup, so the median is used
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples[len(samples)/2], nil
+}
+
+func (p *BandwidthProbe) measureDownload(ctx context.Context) (float64, error) {
+	resp, err := p.get(ctx, p.config.DownloadBytes)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	meter := newThroughputMeter(p.config.DownloadBytes, bandwidthWarmupFraction)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		meter.add(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return meter.mbps()
+}
+
+func (p *BandwidthProbe) measureUpload(ctx context.Context) (float64, error) {
+	// Socket buffers accept the first bytes of an upload immediately, so the
+	// whole transfer is timed up to the server's acknowledgment instead
+	meter := newThroughputMeter(p.config.UploadBytes, 0)
+	body := &meteredReader{remaining: p.config.UploadBytes, meter: meter}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.config.Endpoint+"/upload", body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.ContentLength = p.config.UploadBytes
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return 0, fmt.Errorf("upload endpoint returned status %d", resp.StatusCode)
+	}
+
+	// The transfer is complete once the server acknowledges it
+	meter.finish()
+	return meter.mbps()
+}
+
+func (p *BandwidthProbe) get(ctx context.Context, size int64) (*http.Response, error) {
+	url := fmt.Sprintf("%s/download?bytes=%d", p.config.Endpoint, size)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download endpoint returned status %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// throughputMeter measures the rate of a transfer after its warm-up portion
+type throughputMeter struct {
+	total       int64
+	warmupBytes int64
+	seen        int64
+	warmAt      time.Time
+	warmSeen    int64
+	endAt       time.Time
+}
+
+func newThroughputMeter(total int64, warmupFraction float64) *throughputMeter {
+	return &throughputMeter{total: total, warmupBytes: int64(float64(total) * warmupFraction)}
+}
+
+func (m *throughputMeter) add(n int) {
+	if m.warmAt.IsZero() && m.warmupBytes == 0 {
+		m.warmAt = time.Now()
+	}
+	m.seen += int64(n)
+	if m.warmAt.IsZero() && m.seen >= m.warmupBytes {
+		m.warmAt = time.Now()
+		m.warmSeen = m.seen
+	}
+	if m.seen >= m.total {
+		m.finish()
+	}
+}
+
+func (m *throughputMeter) finish() {
+	m.endAt = time.Now()
+}
+
+func (m *throughputMeter) mbps() (float64, error) {
+	if m.seen < m.total {
+		return 0, fmt.Errorf("transfer incomplete: %d of %d bytes", m.seen, m.total)
+	}
+	elapsed := m.endAt.Sub(m.warmAt)
+	measured := m.seen - m.warmSeen
+	if elapsed <= 0 || measured <= 0 {
+		return 0, fmt.Errorf("transfer too small to measure")
+	}
+	return float64(measured) * 8 / elapsed.Seconds() / 1e6, nil
+}
+
+// meteredReader produces zero bytes for an upload while feeding a meter
+type meteredReader struct {
+	remaining int64
+	meter     *throughputMeter
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	for i := range p {
+		p[i] = 0
+	}
+	r.remaining -= int64(len(p))
+	r.meter.add(len(p))
+	return len(p), nil
+}
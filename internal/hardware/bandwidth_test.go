@@ -0,0 +1,124 @@
+package hardware
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const throttleChunk = 16 * 1024
+
+// throttledServer serves the bandwidth endpoints at bytesPerSecond in each direction
+func throttledServer(t *testing.T, bytesPerSecond float64) *httptest.Server {
+	t.Helper()
+	pace := func(start time.Time, done int64) {
+		if wait := time.Until(start.Add(time.Duration(float64(done) / bytesPerSecond * float64(time.Second)))); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/bandwidth/download", func(w http.ResponseWriter, r *http.Request) {
+		size, _ := strconv.ParseInt(r.URL.Query().Get("bytes"), 10, 64)
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+		chunk := make([]byte, throttleChunk)
+		start := time.Now()
+		for sent := int64(0); sent < size; {
+			n := int64(len(chunk))
+			if size-sent < n {
+				n = size - sent
+			}
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			sent += n
+			pace(start, sent)
+		}
+	})
+	mux.HandleFunc("/bandwidth/upload", func(w http.ResponseWriter, r *http.Request) {
+		chunk := make([]byte, throttleChunk)
+		start := time.Now()
+		var received int64
+		for {
+			n, err := io.ReadFull(r.Body, chunk)
+			received += int64(n)
+			pace(start, received)
+			if err != nil {
+				break
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBandwidthProbeMeasuresThrottledEndpoint(t *testing.T) {
+	const rate = 4 << 20 // 4 MiB/s
+	server := throttledServer(t, rate)
+
+	probe := NewBandwidthProbe(BandwidthProbeConfig{
+		Endpoint:      server.URL + "/bandwidth",
+		DownloadBytes: 2 << 20,
+		UploadBytes:   2 << 20,
+		Timeout:       10 * time.Second,
+	})
+
+	profile, err := probe.Measure(context.Background())
+	if err != nil {
+		t.Fatalf("Measure() error = %v", err)
+	}
+
+	wantMbps := float64(rate) * 8 / 1e6
+	for name, got := range map[string]float64{"download": profile.DownloadMbps, "upload": profile.UploadMbps} {
+		if math.Abs(got-wantMbps)/wantMbps > 0.25 {
+			t.Errorf("%s = %.1f Mbps, want %.1f ±25%%", name, got, wantMbps)
+		}
+	}
+	if profile.LatencyMs <= 0 || profile.LatencyMs > 100 {
+		t.Errorf("unexpected loopback latency %.2fms", profile.LatencyMs)
+	}
+	if time.Since(profile.MeasuredAt) > time.Minute {
+		t.Errorf("measurement timestamp not set: %s", profile.MeasuredAt)
+	}
+}
+
+func TestNextOffPeak(t *testing.T) {
+	now := time.Date(2025, 3, 10, 14, 30, 0, 0, time.UTC)
+
+	if got, want := nextOffPeak(now, 0), time.Date(2025, 3, 11, 2, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("nextOffPeak(r=0) = %s, want %s", got, want)
+	}
+	if got, want := nextOffPeak(now, 0.5), time.Date(2025, 3, 11, 3, 30, 0, 0, time.UTC); !got.Equal(want) {
+		t.Fatalf("nextOffPeak(r=0.5) = %s, want %s", got, want)
+	}
+
+	// Just after midnight still waits for the following night, keeping runs a day apart
+	early := time.Date(2025, 3, 10, 0, 5, 0, 0, time.UTC)
+	if got := nextOffPeak(early, 0); got.Sub(early) < 24*time.Hour {
+		t.Fatalf("expected next run at least a day away, got %s", got)
+	}
+}
+
+func TestSupportsBandwidth(t *testing.T) {
+	measured := &NetworkProfile{DownloadMbps: 200, UploadMbps: 50}
+
+	if !measured.SupportsBandwidth(0) || !measured.SupportsBandwidth(50) {
+		t.Fatal("expected requirement within both directions to be supported")
+	}
+	if measured.SupportsBandwidth(100) {
+		t.Fatal("expected upload bottleneck to reject 100 Mbps")
+	}
+	var unmeasured *NetworkProfile
+	if !unmeasured.SupportsBandwidth(1000) {
+		t.Fatal("unmeasured runners should not skip tasks")
+	}
+}
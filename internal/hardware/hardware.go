@@ -0,0 +1,94 @@
+package hardware
+
+import (
+	"runtime"
+	"sync"
+)
+
+type AcceleratorClass string
+
+const (
+	AcceleratorNone  AcceleratorClass = "none"
+	AcceleratorCUDA  AcceleratorClass = "cuda"
+	AcceleratorMetal AcceleratorClass = "metal"
+)
+
+// Profile describes the compute hardware available to the runner
+type Profile struct {
+	OS               string           `json:"os"`
+	Arch             string           `json:"arch"`
+	CPUModel         string           `json:"cpu_model,omitempty"`
+	CPUCores         int              `json:"cpu_cores"`
+	TotalMemoryBytes uint64           `json:"total_memory_bytes"`
+	Accelerator      AcceleratorClass `json:"accelerator"`
+	AppleChip        string           `json:"apple_chip,omitempty"`
+	UnifiedMemory    bool             `json:"unified_memory"`
+	MetalSupported   bool             `json:"metal_supported"`
+	GPUCores         int              `json:"gpu_cores,omitempty"`
+}
+
+var (
+	detectOnce      sync.Once
+	detectedProfile *Profile
+)
+
+// Detect probes the host hardware once and caches the result
+func Detect() *Profile {
+	detectOnce.Do(func() {
+		detectedProfile = detect()
+	})
+	return detectedProfile
+}
+
+func baseProfile() *Profile {
+	return &Profile{
+		OS:          runtime.GOOS,
+		Arch:        runtime.GOARCH,
+		CPUCores:    runtime.NumCPU(),
+		Accelerator: AcceleratorNone,
+	}
+}
+
+// SupportsAccelerator reports whether a task requesting the given accelerator can run here
+func (p *Profile) SupportsAccelerator(requested string) bool {
+	switch AcceleratorClass(requested) {
+	case "", AcceleratorNone:
+		return true
+	default:
+		return p != nil && p.Accelerator == AcceleratorClass(requested)
+	}
+}
+
+// ModelMemoryBudget returns the number of bytes available for model weights.
+// On unified memory systems the GPU shares system RAM, and macOS caps the
+// wired GPU working set at roughly two thirds of RAM (three quarters above 36GB).
+func (p *Profile) ModelMemoryBudget() uint64 {
+	if p == nil || p.TotalMemoryBytes == 0 {
+		return 0
+	}
+
+	if !p.UnifiedMemory {
+		return p.TotalMemoryBytes
+	}
+
+	if p.TotalMemoryBytes > 36<<30 {
+		return p.TotalMemoryBytes / 4 * 3
+	}
+	return p.TotalMemoryBytes / 3 * 2
+}
+
+// FitsModel reports whether a model of the given on-disk size can be loaded,
+// leaving headroom for the KV cache and runtime buffers
+func (p *Profile) FitsModel(modelBytes uint64) bool {
+	return FitsBudget(modelBytes, p.ModelMemoryBudget())
+}
+
+// FitsBudget reports whether a model of the given on-disk size fits in
+// budget bytes of model memory with the same headroom, always when either
+// is unknown
+func FitsBudget(modelBytes, budget uint64) bool {
+	if budget == 0 || modelBytes == 0 {
+		return true
+	}
+	return modelBytes+modelBytes/5 <= budget
+}
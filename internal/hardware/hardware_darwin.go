@@ -0,0 +1,22 @@
+//go:build darwin
+
+package hardware
+
+import (
+	"os/exec"
+	"strings"
+)
+
+func detect() *Profile {
+	p := baseProfile()
+	detectApple(p, readSysctl)
+	return p
+}
+
+func readSysctl(name string) (string, error) {
+	output, err := exec.Command("sysctl", "-n", name).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
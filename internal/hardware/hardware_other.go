@@ -0,0 +1,43 @@
+//go:build !darwin
+
+package hardware
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+func detect() *Profile {
+	p := baseProfile()
+	p.TotalMemoryBytes = readMemTotal()
+
+	if _, err := exec.LookPath("nvidia-smi"); err == nil {
+		p.Accelerator = AcceleratorCUDA
+	}
+
+	return p
+}
+
+func readMemTotal() uint64 {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0
+			}
+			return kb * 1024
+		}
+	}
+	return 0
+}
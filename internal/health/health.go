@@ -0,0 +1,213 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+)
+
+const (
+	// loopTickInterval is how often the liveness probe loop records progress
+	loopTickInterval = time.Second
+	// loopStallThreshold is how long the probe loop may go without progress
+	// before the process is reported as not alive
+	loopStallThreshold  = 10 * time.Second
+	runtimeCheckTimeout = 5 * time.Second
+)
+
+// HeartbeatStatus reports when the coordination server was last reached
+type HeartbeatStatus interface {
+	LastSuccess() time.Time
+	Interval() time.Duration
+}
+
+// RuntimeCheck probes a runtime the runner depends on, such as Docker
+type RuntimeCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type Config struct {
+	// ReadyHeartbeats is the number of heartbeat intervals the server may go
+	// unreached before the runner reports not ready
+	ReadyHeartbeats int
+	// DiskPath is the filesystem whose free space is checked against MinFreeDiskBytes
+	DiskPath         string
+	MinFreeDiskBytes uint64
+}
+
+// Failure is a single reason the runner is not healthy or not ready
+type Failure struct {
+	Check  string `json:"check"`
+	Reason string `json:"reason"`
+}
+
+type Report struct {
+	Status   string    `json:"status"`
+	Failures []Failure `json:"failures,omitempty"`
+}
+
+func (r *Report) OK() bool {
+	return len(r.Failures) == 0
+}
+
+// Checker evaluates liveness and readiness of the runner process
+type Checker struct {
+	config    Config
+	heartbeat HeartbeatStatus
+	deviceID  func() error
+	runtimes  []RuntimeCheck
+	freeDisk  func(path string) (uint64, error)
+	clock     clock.Clock
+
+	mu       sync.RWMutex
+	lastTick time.Time
+	draining atomic.Bool
+}
+
+func NewChecker(config Config, heartbeat HeartbeatStatus, deviceID func() error, runtimes ...RuntimeCheck) *Checker {
+	if config.ReadyHeartbeats <= 0 {
+		config.ReadyHeartbeats = 3
+	}
+	c := &Checker{
+		config:    config,
+		heartbeat: heartbeat,
+		deviceID:  deviceID,
+		runtimes:  runtimes,
+		freeDisk:  FreeDiskBytes,
+		clock:     clock.Real(),
+	}
+	c.lastTick = c.clock.Now()
+	return c
+}
+
+// SetClock replaces the clock driving the probe loop and staleness checks
+func (c *Checker) SetClock(clk clock.Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clk
+	c.lastTick = clk.Now()
+}
+
+// Run drives the liveness probe loop until ctx is cancelled. A loop that stops
+// ticking indicates a wedged process.
+func (c *Checker) Run(ctx context.Context) {
+	ticker := c.clock.NewTicker(loopTickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			c.tick()
+		}
+	}
+}
+
+func (c *Checker) tick() {
+	c.mu.Lock()
+	c.lastTick = c.clock.Now()
+	c.mu.Unlock()
+}
+
+// SetDraining marks the runner as draining; a draining runner stays alive but is not ready
+func (c *Checker) SetDraining(draining bool) {
+	c.draining.Store(draining)
+}
+
+func (c *Checker) Liveness() *Report {
+	report := &Report{}
+
+	c.mu.RLock()
+	stalled := c.clock.Now().Sub(c.lastTick)
+	c.mu.RUnlock()
+
+	if stalled > loopStallThreshold {
+		report.Failures = append(report.Failures, Failure{
+			Check:  "event_loop",
+			Reason: fmt.Sprintf("probe loop has not run for %s", stalled.Round(time.Second)),
+		})
+	}
+	return finish(report, "alive", "not_alive")
+}
+
+func (c *Checker) Readiness(ctx context.Context) *Report {
+	report := &Report{}
+	fail := func(check, reason string) {
+		report.Failures = append(report.Failures, Failure{Check: check, Reason: reason})
+	}
+
+	if c.draining.Load() {
+		fail("draining", "runner is draining and not accepting new tasks")
+	}
+
+	if c.heartbeat != nil {
+		limit := time.Duration(c.config.ReadyHeartbeats) * c.heartbeat.Interval()
+		last := c.heartbeat.LastSuccess()
+		if last.IsZero() {
+			fail("server", "no successful heartbeat yet")
+		} else if since := c.clock.Now().Sub(last); since > limit {
+			fail("server", fmt.Sprintf("last successful heartbeat %s ago exceeds %s", since.Round(time.Second), limit))
+		}
+	}
+
+	if c.deviceID != nil {
+		if err := c.deviceID(); err != nil {
+			fail("device_id", err.Error())
+		}
+	}
+
+	for _, runtime := range c.runtimes {
+		checkCtx, cancel := context.WithTimeout(ctx, runtimeCheckTimeout)
+		err := runtime.Check(checkCtx)
+		cancel()
+		if err != nil {
+			fail("runtime:"+runtime.Name, err.Error())
+		}
+	}
+
+	if c.config.MinFreeDiskBytes > 0 && c.config.DiskPath != "" {
+		free, err := c.freeDisk(c.config.DiskPath)
+		if err != nil {
+			fail("disk", fmt.Sprintf("failed to stat %s: %v", c.config.DiskPath, err))
+		} else if free < c.config.MinFreeDiskBytes {
+			fail("disk", fmt.Sprintf("%d bytes free on %s, below watermark of %d", free, c.config.DiskPath, c.config.MinFreeDiskBytes))
+		}
+	}
+
+	return finish(report, "ready", "not_ready")
+}
+
+func finish(report *Report, ok, notOK string) *Report {
+	report.Status = ok
+	if !report.OK() {
+		report.Status = notOK
+	}
+	return report
+}
+
+// RegisterHandlers adds /healthz and /readyz to mux
+func (c *Checker) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, c.Liveness())
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, c.Readiness(r.Context()))
+	})
+}
+
+func writeReport(w http.ResponseWriter, report *Report) {
+	w.Header().Set("Content-Type", "application/json")
+	if report.OK() {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,260 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+)
+
+type fakeHeartbeat struct {
+	last     time.Time
+	interval time.Duration
+}
+
+func (f *fakeHeartbeat) LastSuccess() time.Time  { return f.last }
+func (f *fakeHeartbeat) Interval() time.Duration { return f.interval }
+
+func newTestChecker(now time.Time, hb *fakeHeartbeat) *Checker {
+	c := NewChecker(Config{ReadyHeartbeats: 3, DiskPath: "/data", MinFreeDiskBytes: 1000}, hb, func() error { return nil })
+	c.SetClock(clocktest.NewFake(now))
+	c.freeDisk = func(string) (uint64, error) { return 5000, nil }
+	return c
+}
+
+func failedChecks(r *Report) []string {
+	var checks []string
+	for _, f := range r.Failures {
+		checks = append(checks, f.Check)
+	}
+	return checks
+}
+
+func TestReadinessConditions(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		mutate func(c *Checker, hb *fakeHeartbeat)
+		want   string
+	}{
+		{"ready", func(c *Checker, hb *fakeHeartbeat) {}, ""},
+		{"draining", func(c *Checker, hb *fakeHeartbeat) { c.SetDraining(true) }, "draining"},
+		{"server never reached", func(c *Checker, hb *fakeHeartbeat) { hb.last = time.Time{} }, "server"},
+		{"server outage", func(c *Checker, hb *fakeHeartbeat) { hb.last = now.Add(-91 * time.Second) }, "server"},
+		{"invalid device id", func(c *Checker, hb *fakeHeartbeat) {
+			c.deviceID = func() error { return errors.New("device ID mismatch") }
+		}, "device_id"},
+		{"runtime unavailable", func(c *Checker, hb *fakeHeartbeat) {
+			c.runtimes = []RuntimeCheck{{Name: "docker", Check: func(context.Context) error { return errors.New("daemon not running") }}}
+		}, "runtime:docker"},
+		{"disk below watermark", func(c *Checker, hb *fakeHeartbeat) {
+			c.freeDisk = func(string) (uint64, error) { return 999, nil }
+		}, "disk"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hb := &fakeHeartbeat{last: now.Add(-89 * time.Second), interval: 30 * time.Second}
+			c := newTestChecker(now, hb)
+			tt.mutate(c, hb)
+
+			report := c.Readiness(context.Background())
+			checks := failedChecks(report)
+			if tt.want == "" {
+				if !report.OK() || report.Status != "ready" {
+					t.Fatalf("expected ready, got %+v", report)
+				}
+				return
+			}
+			if report.OK() || report.Status != "not_ready" || len(checks) != 1 || checks[0] != tt.want {
+				t.Fatalf("expected single %q failure, got %+v", tt.want, report)
+			}
+			if report.Failures[0].Reason == "" {
+				t.Fatal("expected a failure reason")
+			}
+		})
+	}
+}
+
+func TestReadinessRecoversAfterDrainAndOutage(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	hb := &fakeHeartbeat{last: now.Add(-5 * time.Minute), interval: 30 * time.Second}
+	c := newTestChecker(now, hb)
+
+	if c.Readiness(context.Background()).OK() {
+		t.Fatal("expected not ready during server outage")
+	}
+	hb.last = now
+	if !c.Readiness(context.Background()).OK() {
+		t.Fatal("expected ready once heartbeats succeed again")
+	}
+	c.SetDraining(true)
+	if c.Readiness(context.Background()).OK() {
+		t.Fatal("expected not ready while draining")
+	}
+	if !c.Liveness().OK() {
+		t.Fatal("draining runner should still be alive")
+	}
+}
+
+func TestLivenessDetectsStalledLoop(t *testing.T) {
+	fake := clocktest.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	c := newTestChecker(fake.Now(), nil)
+	c.SetClock(fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+
+	// A running probe loop keeps the process alive however much time passes
+	fake.BlockUntil(1)
+	for i := 0; i < 30; i++ {
+		fake.Advance(loopTickInterval)
+	}
+	waitFor(t, func() bool { return c.Liveness().OK() })
+
+	cancel()
+	<-done
+
+	fake.Advance(loopStallThreshold)
+	if !c.Liveness().OK() {
+		t.Fatal("expected alive exactly at the stall threshold")
+	}
+	fake.Advance(time.Second)
+	report := c.Liveness()
+	if report.OK() || report.Failures[0].Check != "event_loop" {
+		t.Fatalf("expected event loop failure, got %+v", report)
+	}
+}
+
+// waitFor polls cond, which observes work done by a goroutine after the fake
+// clock has fired, without depending on wall-clock timing for correctness
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWatchdogKeepaliveTiming(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socketPath)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	n := NewNotifierFromEnv()
+	if got := n.WatchdogInterval(); got != 50*time.Millisecond {
+		t.Fatalf("expected keepalive every 50ms, got %s", got)
+	}
+
+	fake := clocktest.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	n.SetClock(fake)
+	checker := newTestChecker(fake.Now(), nil)
+	checker.SetClock(fake)
+
+	var keepalives atomic.Int32
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			nr, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			if strings.TrimSpace(string(buf[:nr])) == NotifyWatchdog {
+				keepalives.Add(1)
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.RunWatchdog(ctx, checker)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	fake.BlockUntil(1)
+	fake.Advance(49 * time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	if got := keepalives.Load(); got != 0 {
+		t.Fatalf("expected no keepalive before 50ms, got %d", got)
+	}
+
+	for i := int32(1); i <= 5; i++ {
+		if i == 1 {
+			fake.Advance(time.Millisecond)
+		} else {
+			fake.Advance(50 * time.Millisecond)
+		}
+		want := i
+		waitFor(t, func() bool { return keepalives.Load() == want })
+	}
+}
+
+func TestWatchdogWithheldWhenNotAlive(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram sockets unavailable: %v", err)
+	}
+	defer conn.Close()
+
+	fake := clocktest.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	n := &Notifier{socket: socketPath, watchdog: 40 * time.Millisecond, clock: fake}
+	checker := newTestChecker(fake.Now(), nil)
+	checker.SetClock(fake)
+	fake.Advance(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		n.RunWatchdog(ctx, checker)
+		close(done)
+	}()
+	fake.BlockUntil(1)
+	for i := 0; i < 3; i++ {
+		fake.Advance(40 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+	if _, err := conn.Read(make([]byte, 64)); err == nil {
+		t.Fatal("expected no keepalive while the runner is not alive")
+	}
+}
+
+func TestNotifierDisabledWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	n := NewNotifierFromEnv()
+	if n.Enabled() {
+		t.Fatal("expected notifier to be disabled")
+	}
+	if err := n.Notify(NotifyReady); err != nil {
+		t.Fatalf("disabled notifier should be a no-op, got %v", err)
+	}
+}
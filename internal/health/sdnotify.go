@@ -0,0 +1,114 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+)
+
+const (
+	NotifyReady    = "READY=1"
+	NotifyStopping = "STOPPING=1"
+	NotifyWatchdog = "WATCHDOG=1"
+)
+
+// Notifier implements the systemd sd_notify protocol. It is a no-op when the
+// runner is not started by systemd with NOTIFY_SOCKET set.
+type Notifier struct {
+	socket   string
+	watchdog time.Duration
+	clock    clock.Clock
+}
+
+func NewNotifierFromEnv() *Notifier {
+	n := &Notifier{socket: os.Getenv("NOTIFY_SOCKET")}
+	if usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64); err == nil && usec > 0 {
+		// WATCHDOG_PID, when set, must name this process
+		if pid := os.Getenv("WATCHDOG_PID"); pid == "" || pid == strconv.Itoa(os.Getpid()) {
+			n.watchdog = time.Duration(usec) * time.Microsecond
+		}
+	}
+	return n
+}
+
+func (n *Notifier) Enabled() bool {
+	return n != nil && n.socket != ""
+}
+
+// WatchdogInterval returns how often keepalives are sent: half the systemd
+// watchdog timeout, or zero when the watchdog is disabled
+func (n *Notifier) WatchdogInterval() time.Duration {
+	if !n.Enabled() {
+		return 0
+	}
+	return n.watchdog / 2
+}
+
+func (n *Notifier) Notify(state string) error {
+	if !n.Enabled() {
+		return nil
+	}
+
+	addr := n.socket
+	// A leading @ denotes a Linux abstract socket
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("failed to connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("failed to send %q to notify socket: %w", state, err)
+	}
+	return nil
+}
+
+// SetClock replaces the clock pacing watchdog keepalives
+func (n *Notifier) SetClock(c clock.Clock) {
+	n.clock = c
+}
+
+// RunWatchdog sends watchdog keepalives until ctx is cancelled. Keepalives are
+// withheld while the checker reports the process as not alive, so systemd
+// restarts a wedged runner.
+func (n *Notifier) RunWatchdog(ctx context.Context, checker *Checker) {
+	interval := n.WatchdogInterval()
+	if interval <= 0 {
+		return
+	}
+
+	clk := n.clock
+	if clk == nil {
+		clk = clock.Real()
+	}
+
+	log := gologger.WithComponent("sd_notify")
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			if checker != nil && !checker.Liveness().OK() {
+				log.Warn().Msg("Withholding watchdog keepalive - runner is not alive")
+				continue
+			}
+			if err := n.Notify(NotifyWatchdog); err != nil {
+				log.Warn().Err(err).Msg("Failed to send watchdog keepalive")
+			}
+		}
+	}
+}
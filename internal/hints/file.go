@@ -0,0 +1,95 @@
+package hints
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore keeps hints in a directory the fleet shares, such as an NFS
+// mount, as a file of JSON lines per task. Each hint is appended with a
+// single write, so that concurrent runners never interleave their lines.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create hint directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(taskID string) (string, error) {
+	if taskID == "" || strings.ContainsAny(taskID, `/\`) || taskID == "." || taskID == ".." {
+		return "", fmt.Errorf("invalid task ID %q", taskID)
+	}
+	return filepath.Join(s.dir, taskID+".jsonl"), nil
+}
+
+// Publish implements Store
+func (s *FileStore) Publish(ctx context.Context, taskID string, signed *Signed) error {
+	path, err := s.path(taskID)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	line, err := json.Marshal(signed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal hint: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open task hints: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to append task hint: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to append task hint: %w", err)
+	}
+	return nil
+}
+
+// Lookup implements Store, returning the newest hints first
+func (s *FileStore) Lookup(ctx context.Context, taskID string) ([]Signed, error) {
+	path, err := s.path(taskID)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task hints: %w", err)
+	}
+	var hints []Signed
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var signed Signed
+		if err := json.Unmarshal(scanner.Bytes(), &signed); err != nil {
+			// A line torn by a runner that crashed mid-write
+			continue
+		}
+		hints = append([]Signed{signed}, hints...)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read task hints: %w", err)
+	}
+	return hints, nil
+}
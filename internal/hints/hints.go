@@ -0,0 +1,311 @@
+// Package hints spares a fleet from each of its runners discovering on its
+// own that a task is broken. A runner that fails a task for a reason that
+// fails it everywhere, such as an image that does not exist, inputs that
+// cannot be fetched or a config that is invalid, publishes a hint signed
+// with its wallet key to a store the fleet shares. The other runners check
+// for hints before claiming a task and skip it while the hint lasts. Hints
+// signed by keys outside the fleet are ignored, and failures that depend
+// on the runner, such as running out of memory or disk, are never hinted.
+// The store fails open: when it is unreachable, tasks are claimed as usual.
+package hints
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+// Reason is why a hinted task fails for every runner
+type Reason string
+
+const (
+	// ReasonImageNotFound is a Docker task whose image does not exist
+	ReasonImageNotFound Reason = "image_not_found"
+	// ReasonInputUnavailable is a task whose inputs cannot be fetched from
+	// their source or do not match their declared hash or size
+	ReasonInputUnavailable Reason = "input_unavailable"
+	// ReasonInvalidConfig is a task whose config does not match its
+	// schema or cannot be run as given
+	ReasonInvalidConfig Reason = "invalid_config"
+)
+
+// Valid reports whether r is one of the reasons hints are published for
+func (r Reason) Valid() bool {
+	switch r {
+	case ReasonImageNotFound, ReasonInputUnavailable, ReasonInvalidConfig:
+		return true
+	}
+	return false
+}
+
+// maxDetailLength bounds a hint's detail
+const maxDetailLength = 200
+
+var (
+	ErrForged  = errors.New("hint not signed by a fleet member")
+	ErrExpired = errors.New("hint has expired")
+)
+
+// Hint is a fleet member's word that a task fails for every runner until
+// ExpiresAt
+type Hint struct {
+	TaskID string `json:"task_id"`
+	Nonce  string `json:"nonce"`
+	Reason Reason `json:"reason"`
+	// Detail is the first line of the failure, for operators
+	Detail string `json:"detail,omitempty"`
+	// Runner is the wallet address of the runner that published the hint
+	Runner    string    `json:"runner"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Signed is a hint as published: its JSON encoding and the hex signature of
+// that encoding's Keccak-256 hash by the publishing runner's wallet
+type Signed struct {
+	Payload   json.RawMessage `json:"payload"`
+	Signature string          `json:"signature"`
+}
+
+// Store is a channel the fleet shares hints through
+type Store interface {
+	// Publish records signed, a hint for the task
+	Publish(ctx context.Context, taskID string, signed *Signed) error
+	// Lookup returns the hints recorded for the task
+	Lookup(ctx context.Context, taskID string) ([]Signed, error)
+}
+
+// Board publishes and checks the hints of one runner
+type Board struct {
+	store   Store
+	signer  signer.Signer
+	members map[common.Address]bool
+	ttl     time.Duration
+	timeout time.Duration
+	clock   clock.Clock
+
+	mu    sync.Mutex
+	known map[string]*Hint
+
+	published atomic.Uint64
+	skipped   atomic.Uint64
+	forged    atomic.Uint64
+}
+
+// New returns a board publishing hints to store signed by s, lasting ttl,
+// and heeding hints signed by s or any of members. Each store call is
+// bounded by timeout.
+func New(store Store, s signer.Signer, members []common.Address, ttl, timeout time.Duration) *Board {
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	b := &Board{
+		store:   store,
+		signer:  s,
+		members: map[common.Address]bool{s.Address(): true},
+		ttl:     ttl,
+		timeout: timeout,
+		clock:   clock.Real(),
+		known:   make(map[string]*Hint),
+	}
+	for _, member := range members {
+		b.members[member] = true
+	}
+	return b
+}
+
+// SetClock replaces the clock hints are timed by
+func (b *Board) SetClock(c clock.Clock) {
+	b.clock = c
+}
+
+// Publish tells the fleet that task fails for every runner for reason,
+// with detail explaining how. A task already hinted is not hinted again
+// until its hint expires.
+func (b *Board) Publish(task *models.Task, reason Reason, detail string) error {
+	if !reason.Valid() {
+		return fmt.Errorf("failures for reason %q are not hinted", reason)
+	}
+	now := b.clock.Now().UTC()
+	b.mu.Lock()
+	known := b.known[task.ID.String()]
+	b.mu.Unlock()
+	if known != nil && known.Nonce == task.Nonce && known.ExpiresAt.After(now) {
+		return nil
+	}
+	hint := &Hint{
+		TaskID:    task.ID.String(),
+		Nonce:     task.Nonce,
+		Reason:    reason,
+		Detail:    firstLine(detail),
+		Runner:    b.signer.Address().Hex(),
+		IssuedAt:  now,
+		ExpiresAt: now.Add(b.ttl),
+	}
+	signed, err := Sign(hint, b.signer)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+	defer cancel()
+	if err := b.store.Publish(ctx, hint.TaskID, signed); err != nil {
+		return fmt.Errorf("failed to publish hint: %w", err)
+	}
+	b.remember(hint)
+	b.published.Add(1)
+	return nil
+}
+
+// Check returns an unexpired hint a fleet member published for task, nil
+// when there is none or the store is unreachable
+func (b *Board) Check(ctx context.Context, task *models.Task) *Hint {
+	taskID := task.ID.String()
+	now := b.clock.Now()
+
+	b.mu.Lock()
+	hint := b.known[taskID]
+	b.mu.Unlock()
+	if hint != nil && hint.Nonce == task.Nonce && hint.ExpiresAt.After(now) {
+		b.skipped.Add(1)
+		return hint
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+	log := gologger.WithComponent("hints")
+	signed, err := b.store.Lookup(ctx, taskID)
+	if err != nil {
+		log.Warn().Err(err).Str("id", taskID).Msg("Hint store unreachable, claiming without hints")
+		return nil
+	}
+	for i := range signed {
+		hint, err := b.verify(&signed[i], now)
+		if err != nil {
+			if errors.Is(err, ErrForged) {
+				b.forged.Add(1)
+				log.Warn().Err(err).Str("id", taskID).Msg("Ignoring hint from outside the fleet")
+			}
+			continue
+		}
+		if hint.TaskID != taskID || hint.Nonce != task.Nonce {
+			continue
+		}
+		b.remember(hint)
+		b.skipped.Add(1)
+		return hint
+	}
+	return nil
+}
+
+// verify decodes signed, checking that a fleet member signed it and that it
+// has not expired at now
+func (b *Board) verify(signed *Signed, now time.Time) (*Hint, error) {
+	hint, by, err := Open(signed)
+	if err != nil {
+		return nil, err
+	}
+	if !b.members[by] || !common.IsHexAddress(hint.Runner) || common.HexToAddress(hint.Runner) != by {
+		return nil, fmt.Errorf("%w: signed by %s", ErrForged, by.Hex())
+	}
+	if !hint.Reason.Valid() {
+		return nil, fmt.Errorf("hint has unknown reason %q", hint.Reason)
+	}
+	// Hints lasting longer than this runner's own are cut short, so that
+	// no member can keep a task from the fleet for good
+	expiresAt := hint.ExpiresAt
+	if limit := hint.IssuedAt.Add(b.ttl); limit.Before(expiresAt) {
+		expiresAt = limit
+	}
+	if !expiresAt.After(now) {
+		return nil, ErrExpired
+	}
+	hint.ExpiresAt = expiresAt
+	return hint, nil
+}
+
+func (b *Board) remember(hint *Hint) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.clock.Now()
+	for taskID, known := range b.known {
+		if !known.ExpiresAt.After(now) {
+			delete(b.known, taskID)
+		}
+	}
+	b.known[hint.TaskID] = hint
+}
+
+// Stats counts the board's hints since the runner started
+func (b *Board) Stats() *models.FleetHintStats {
+	return &models.FleetHintStats{
+		Published: b.published.Load(),
+		Skipped:   b.skipped.Load(),
+		Forged:    b.forged.Load(),
+	}
+}
+
+// Sign encodes hint and signs it with s
+func Sign(hint *Hint, s signer.Signer) (*Signed, error) {
+	payload, err := json.Marshal(hint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal hint: %w", err)
+	}
+	signature, err := s.SignHash(crypto.Keccak256(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign hint: %w", err)
+	}
+	return &Signed{Payload: payload, Signature: hex.EncodeToString(signature)}, nil
+}
+
+// Open decodes signed, returning the address its signature recovers to
+func Open(signed *Signed) (*Hint, common.Address, error) {
+	signature, err := hex.DecodeString(signed.Signature)
+	if err != nil || len(signature) != crypto.SignatureLength {
+		return nil, common.Address{}, fmt.Errorf("%w: malformed signature", ErrForged)
+	}
+	pub, err := crypto.SigToPub(crypto.Keccak256(signed.Payload), signature)
+	if err != nil {
+		return nil, common.Address{}, fmt.Errorf("%w: %v", ErrForged, err)
+	}
+	var hint Hint
+	if err := json.Unmarshal(signed.Payload, &hint); err != nil {
+		return nil, common.Address{}, fmt.Errorf("invalid hint: %w", err)
+	}
+	return &hint, crypto.PubkeyToAddress(*pub), nil
+}
+
+// firstLine returns the first line of s, bounded to maxDetailLength bytes
+func firstLine(s string) string {
+	for i, c := range s {
+		if c == '\n' {
+			s = s[:i]
+			break
+		}
+	}
+	if len(s) <= maxDetailLength {
+		return s
+	}
+	cut := maxDetailLength
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
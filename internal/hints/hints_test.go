@@ -0,0 +1,238 @@
+package hints
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+func newSigner(t *testing.T) *signer.Local {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := signer.FromECDSA(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func newTask() *models.Task {
+	return &models.Task{ID: uuid.New(), Nonce: uuid.NewString(), Type: models.TaskTypeDocker}
+}
+
+// fleet returns boards for n runners of one fleet sharing store, and the
+// fake clock they run on
+func fleet(t *testing.T, store Store, n int) ([]*Board, *clocktest.Fake) {
+	t.Helper()
+	clk := clocktest.NewFake(time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC))
+	signers := make([]*signer.Local, n)
+	members := make([]common.Address, n)
+	for i := range signers {
+		signers[i] = newSigner(t)
+		members[i] = signers[i].Address()
+	}
+	boards := make([]*Board, n)
+	for i := range boards {
+		boards[i] = New(store, signers[i], members, time.Hour, time.Second)
+		boards[i].SetClock(clk)
+	}
+	return boards, clk
+}
+
+func TestHintSkipsTaskAcrossFleet(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	boards, clk := fleet(t, store, 3)
+	first, second, third := boards[0], boards[1], boards[2]
+	task := newTask()
+
+	if hint := second.Check(context.Background(), task); hint != nil {
+		t.Fatalf("Check() = %+v before any hint was published", hint)
+	}
+	if err := first.Publish(task, ReasonImageNotFound, "image pull failed: manifest unknown\nOutput: ..."); err != nil {
+		t.Fatal(err)
+	}
+
+	hint := second.Check(context.Background(), task)
+	if hint == nil {
+		t.Fatal("Check() = nil after a fleet member published a hint")
+	}
+	if hint.Reason != ReasonImageNotFound || hint.Runner != first.signer.Address().Hex() || hint.Detail != "image pull failed: manifest unknown" {
+		t.Errorf("Check() = %+v", hint)
+	}
+	if stats := second.Stats(); stats.Skipped != 1 || stats.Forged != 0 {
+		t.Errorf("Stats() = %+v, want one skip", stats)
+	}
+
+	// The same task ID under another nonce is another submission
+	resubmitted := *task
+	resubmitted.Nonce = uuid.NewString()
+	if hint := third.Check(context.Background(), &resubmitted); hint != nil {
+		t.Errorf("Check() = %+v for a resubmission", hint)
+	}
+
+	clk.Advance(time.Hour + time.Second)
+	if hint := second.Check(context.Background(), task); hint != nil {
+		t.Errorf("Check() = %+v after the hint expired", hint)
+	}
+	if hint := third.Check(context.Background(), task); hint != nil {
+		t.Errorf("Check() = %+v after the hint expired", hint)
+	}
+}
+
+func TestHintFromOutsideFleetIsIgnored(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	boards, clk := fleet(t, store, 2)
+	member := boards[0]
+	task := newTask()
+
+	// An outsider publishes to the fleet's store with a key of its own
+	outsider := New(store, newSigner(t), nil, time.Hour, time.Second)
+	outsider.SetClock(clk)
+	if err := outsider.Publish(task, ReasonInvalidConfig, "forged"); err != nil {
+		t.Fatal(err)
+	}
+	// and claims to be a member, signing with its own key
+	claimed := &Hint{
+		TaskID:    task.ID.String(),
+		Nonce:     task.Nonce,
+		Reason:    ReasonInvalidConfig,
+		Runner:    member.signer.Address().Hex(),
+		IssuedAt:  clk.Now(),
+		ExpiresAt: clk.Now().Add(time.Hour),
+	}
+	signed, err := Sign(claimed, outsider.signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Publish(context.Background(), task.ID.String(), signed); err != nil {
+		t.Fatal(err)
+	}
+	// and alters a member's genuine hint for another task
+	other := newTask()
+	genuine, err := Sign(&Hint{TaskID: other.ID.String(), Nonce: other.Nonce, Reason: ReasonInvalidConfig, Runner: member.signer.Address().Hex(), IssuedAt: clk.Now(), ExpiresAt: clk.Now().Add(time.Hour)}, member.signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(string(genuine.Payload), other.ID.String(), task.ID.String(), 1)
+	tampered = strings.Replace(tampered, other.Nonce, task.Nonce, 1)
+	if err := store.Publish(context.Background(), task.ID.String(), &Signed{Payload: json.RawMessage(tampered), Signature: genuine.Signature}); err != nil {
+		t.Fatal(err)
+	}
+
+	if hint := boards[1].Check(context.Background(), task); hint != nil {
+		t.Fatalf("Check() = %+v, want forged hints ignored", hint)
+	}
+	if stats := boards[1].Stats(); stats.Forged != 3 || stats.Skipped != 0 {
+		t.Errorf("Stats() = %+v, want three forged hints", stats)
+	}
+}
+
+func TestHintLifetimeIsBoundedByOwnTTL(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	boards, clk := fleet(t, store, 2)
+	task := newTask()
+	long := &Hint{
+		TaskID:    task.ID.String(),
+		Nonce:     task.Nonce,
+		Reason:    ReasonInputUnavailable,
+		Runner:    boards[0].signer.Address().Hex(),
+		IssuedAt:  clk.Now(),
+		ExpiresAt: clk.Now().Add(365 * 24 * time.Hour),
+	}
+	signed, err := Sign(long, boards[0].signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Publish(context.Background(), task.ID.String(), signed); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(2 * time.Hour)
+	if hint := boards[1].Check(context.Background(), task); hint != nil {
+		t.Errorf("Check() = %+v, want a year-long hint cut to the runner's TTL", hint)
+	}
+}
+
+func TestPublishRejectsRunnerSpecificReasons(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	boards, _ := fleet(t, store, 1)
+	for _, reason := range []Reason{"oom", "scratch", ""} {
+		if err := boards[0].Publish(newTask(), reason, ""); err == nil {
+			t.Errorf("Publish() accepted reason %q", reason)
+		}
+	}
+}
+
+func TestHTTPStore(t *testing.T) {
+	var mu sync.Mutex
+	published := make(map[string][]Signed)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		taskID := strings.TrimPrefix(r.URL.Path, "/hints/")
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPost:
+			var signed Signed
+			if err := json.NewDecoder(r.Body).Decode(&signed); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			published[taskID] = append(published[taskID], signed)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodGet:
+			if len(published[taskID]) == 0 {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(published[taskID])
+		}
+	}))
+	defer srv.Close()
+
+	boards, _ := fleet(t, NewHTTPStore(srv.URL+"/"), 2)
+	task := newTask()
+	if hint := boards[1].Check(context.Background(), task); hint != nil {
+		t.Fatalf("Check() = %+v before any hint was published", hint)
+	}
+	if err := boards[0].Publish(task, ReasonInputUnavailable, "input weights: status 404"); err != nil {
+		t.Fatal(err)
+	}
+	if hint := boards[1].Check(context.Background(), task); hint == nil || hint.Reason != ReasonInputUnavailable {
+		t.Errorf("Check() = %+v, want the published hint", hint)
+	}
+}
+
+func TestUnreachableStoreFailsOpen(t *testing.T) {
+	boards, _ := fleet(t, NewHTTPStore("http://127.0.0.1:1"), 1)
+	if hint := boards[0].Check(context.Background(), newTask()); hint != nil {
+		t.Errorf("Check() = %+v with the store unreachable", hint)
+	}
+}
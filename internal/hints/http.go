@@ -0,0 +1,93 @@
+package hints
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// HTTPStore keeps hints in a pub/sub HTTP service, which may be the fleet's
+// execution guard service.
+//
+// POST {url}/hints/{taskID} with a signed hint records it. GET
+// {url}/hints/{taskID} answers with the hints recorded for the task as a
+// JSON array, or 404 Not Found when there are none.
+type HTTPStore struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPStore(baseURL string) *HTTPStore {
+	return &HTTPStore{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		client: &http.Client{},
+	}
+}
+
+func (s *HTTPStore) endpoint(taskID string) string {
+	return fmt.Sprintf("%s/hints/%s", s.url, url.PathEscape(taskID))
+}
+
+// Publish implements Store
+func (s *HTTPStore) Publish(ctx context.Context, taskID string, signed *Signed) error {
+	body, err := json.Marshal(signed)
+	if err != nil {
+		return fmt.Errorf("failed to marshal hint: %w", err)
+	}
+	endpoint := s.endpoint(taskID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create hint request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP POST failed for %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusAccepted, http.StatusNoContent:
+		return nil
+	default:
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("hint store unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+}
+
+// Lookup implements Store
+func (s *HTTPStore) Lookup(ctx context.Context, taskID string) ([]Signed, error) {
+	endpoint := s.endpoint(taskID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create hint request: %w", err)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP GET failed for %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("hint store unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+	var hints []Signed
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&hints); err != nil {
+		return nil, fmt.Errorf("failed to decode task hints: %w", err)
+	}
+	return hints, nil
+}
@@ -0,0 +1,495 @@
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/fsck"
+)
+
+const maxRecordBytes = 1024 * 1024
+
+// durationsKept is how many durations of each task type and workload the
+// store keeps in memory, at least as many as any caller asks Durations for
+const durationsKept = 256
+
+// compactEvery is how many appends pass between compactions of a store
+// with a retention
+const compactEvery = 1000
+
+// EventCallbackFailed marks a record noting that the creator callback for a
+// task could not be delivered
+const EventCallbackFailed = "callback_failed"
+
+// EventConfirmation marks a record of the operator's decision on a task
+// that needed confirmation before it was claimed; StartedAt is when the
+// operator was asked and the duration how long the answer took
+const EventConfirmation = "confirmation"
+
+// EventTimeline marks a record holding the lifecycle timeline of a task's
+// attempt on this runner, written as the attempt ends
+const EventTimeline = "timeline"
+
+// EventCanary marks a record of a canary self-test rather than a task:
+// Workload names the capability it checked, and Status is completed when
+// it passed
+const EventCanary = "canary"
+
+// Record is one finished task execution on this runner. Records with an Event
+// annotate an earlier execution and are not executions themselves.
+type Record struct {
+	TaskID     string            `json:"task_id"`
+	Type       models.TaskType   `json:"type"`
+	Workload   string            `json:"workload,omitempty"`
+	Status     models.TaskStatus `json:"status"`
+	StartedAt  time.Time         `json:"started_at"`
+	DurationMs int64             `json:"duration_ms"`
+	Event      string            `json:"event,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	// ResultDigest is set for completed executions kept for audit replay
+	ResultDigest string `json:"result_digest,omitempty"`
+	// Instance names the logical runner that executed the task when a
+	// process runs several
+	Instance string `json:"instance,omitempty"`
+	// Decision is the operator's answer on confirmation records: approved,
+	// denied or timed_out
+	Decision string `json:"decision,omitempty"`
+	// Timeline is the task's timeline so far on timeline records
+	Timeline *models.TaskTimeline `json:"timeline,omitempty"`
+	// FailureCode classifies why a failed execution failed
+	FailureCode models.FailureCode `json:"failure_code,omitempty"`
+	// Verified is whether the result passed its output manifest and
+	// post-processing verdict, unset when it had neither
+	Verified *bool `json:"verified,omitempty"`
+}
+
+func (r *Record) Duration() time.Duration {
+	return time.Duration(r.DurationMs) * time.Millisecond
+}
+
+// Retention bounds a history: records that started longer than MaxAge ago,
+// and the oldest beyond MaxRecords, are dropped when it is compacted. Zero
+// fields keep every record.
+type Retention struct {
+	MaxAge     time.Duration
+	MaxRecords int
+}
+
+// workload is the task type and workload durations are kept for
+type workload struct {
+	taskType models.TaskType
+	name     string
+}
+
+// Store is an append-only JSONL log of task executions. With a codec set,
+// each line holds a base64-encoded encrypted record instead of plain JSON.
+// With a retention set it is compacted every compactEvery appends.
+type Store struct {
+	path      string
+	codec     atrest.Codec
+	retention Retention
+	clock     clock.Clock
+	mu        sync.Mutex
+	// durations are the latest completed durations of each workload,
+	// read from the file on first use and kept current by Append
+	durations map[workload][]time.Duration
+	appended  int
+}
+
+func NewStore(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+	return &Store{path: path, clock: clock.Real()}, nil
+}
+
+// SetRetention bounds the history to retention from the next compaction on
+func (s *Store) SetRetention(retention Retention) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retention = retention
+}
+
+// SetClock replaces the clock records are aged by
+func (s *Store) SetClock(c clock.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = c
+}
+
+// SetCodec encrypts records appended from now on
+func (s *Store) SetCodec(codec atrest.Codec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codec = codec
+}
+
+func (s *Store) encodeLine(record Record) ([]byte, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal history record: %w", err)
+	}
+	if s.codec == nil {
+		return data, nil
+	}
+	sealed, err := s.codec.Seal(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt history record: %w", err)
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// decodeLine parses a plaintext or encrypted line, returning the sealed bytes
+// for encrypted lines. ok is false for lines that are not records at all,
+// such as a partial write at crash time. With a codec set, plaintext lines
+// are only accepted while migrating.
+func (s *Store) decodeLine(line []byte, migrating bool) (Record, []byte, bool, error) {
+	var record Record
+	var sealed []byte
+
+	if len(line) > 0 && line[0] == '{' && s.codec != nil {
+		open := s.codec.Open
+		if migrating {
+			open = s.codec.Migrate
+		}
+		if _, err := open(line); err != nil {
+			return Record{}, nil, false, fmt.Errorf("failed to read history record: %w", err)
+		}
+	}
+	if len(line) > 0 && line[0] != '{' {
+		if s.codec == nil {
+			return Record{}, nil, false, errors.New("history is encrypted but no data key is configured")
+		}
+		raw, err := base64.StdEncoding.DecodeString(string(line))
+		if err != nil {
+			return Record{}, nil, false, nil
+		}
+		plaintext, err := s.codec.Open(raw)
+		if err != nil {
+			return Record{}, nil, false, fmt.Errorf("failed to decrypt history record: %w", err)
+		}
+		line, sealed = plaintext, raw
+	}
+
+	if err := json.Unmarshal(line, &record); err != nil {
+		return Record{}, nil, false, nil
+	}
+	return record, sealed, true, nil
+}
+
+func (s *Store) Append(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := s.encodeLine(record)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	// A line a crash cut short is ended first, so that it does not swallow
+	// this record too
+	torn, err := tornTail(f)
+	if err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+	line := append(data, '\n')
+	if torn {
+		line = append([]byte{'\n'}, line...)
+	}
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("failed to append history record: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync history: %w", err)
+	}
+	s.remember(record)
+
+	s.appended++
+	if s.appended >= compactEvery && s.retention != (Retention{}) {
+		// The record is kept either way; a failed compaction is tried again
+		// after the next append
+		if _, err := s.compact(); err != nil {
+			log := gologger.WithComponent("history")
+			log.Warn().Err(err).Msg("Failed to compact task history")
+		}
+	}
+	return nil
+}
+
+// remember adds record's duration to those kept in memory, once they are
+func (s *Store) remember(record Record) {
+	if s.durations == nil || record.Event != "" || record.Status != models.TaskStatusCompleted {
+		return
+	}
+	key := workload{record.Type, record.Workload}
+	durations := append(s.durations[key], record.Duration())
+	if len(durations) > durationsKept {
+		durations = durations[len(durations)-durationsKept:]
+	}
+	s.durations[key] = durations
+}
+
+// Compact drops the records the retention does not keep, returning how
+// many it dropped
+func (s *Store) Compact() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+func (s *Store) compact() (int, error) {
+	records, _, err := s.load(false)
+	if err != nil {
+		return 0, err
+	}
+	s.appended = 0
+
+	kept := records
+	if s.retention.MaxAge > 0 {
+		cutoff := s.clock.Now().Add(-s.retention.MaxAge)
+		kept = kept[:0:0]
+		for _, record := range records {
+			// Records without a start time cannot be aged and are bounded
+			// by MaxRecords alone
+			if record.StartedAt.IsZero() || !record.StartedAt.Before(cutoff) {
+				kept = append(kept, record)
+			}
+		}
+	}
+	if s.retention.MaxRecords > 0 && len(kept) > s.retention.MaxRecords {
+		kept = kept[len(kept)-s.retention.MaxRecords:]
+	}
+	dropped := len(records) - len(kept)
+	if dropped == 0 {
+		return 0, nil
+	}
+
+	if err := s.rewrite(kept); err != nil {
+		return 0, err
+	}
+	s.durations = nil
+	return dropped, nil
+}
+
+// rewrite replaces the history with records, encoded with the codec set
+func (s *Store) rewrite(records []Record) error {
+	var buf bytes.Buffer
+	for _, record := range records {
+		line, err := s.encodeLine(record)
+		if err != nil {
+			return err
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	if err := atrest.WriteFileAtomic(s.path, buf.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("failed to rewrite history: %w", err)
+	}
+	return nil
+}
+
+// tornTail reports whether f ends partway through a line
+func tornTail(f *os.File) (bool, error) {
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return false, err
+	}
+	last := make([]byte, 1)
+	if _, err := f.ReadAt(last, info.Size()-1); err != nil {
+		return false, err
+	}
+	return last[0] != '\n', nil
+}
+
+// Fsck drops the lines of the history at path that hold no record, such as
+// a write a crash cut short, keeping the history as it was in q. Records
+// that cannot be opened with codec, because it is missing or lacks their
+// key, are kept; plaintext records in a migrated history are dropped.
+func Fsck(path string, codec atrest.Codec, q *fsck.Quarantine) ([]fsck.Finding, error) {
+	const store = "history"
+	findings, err := fsck.RemoveLeftovers(store, filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return findings, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return findings, nil
+	}
+	if err != nil {
+		return findings, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	s := &Store{path: path, codec: codec}
+	var kept bytes.Buffer
+	dropped := 0
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !s.recoverable(line) {
+			dropped++
+			continue
+		}
+		kept.Write(line)
+		kept.WriteByte('\n')
+	}
+	if dropped == 0 {
+		return findings, nil
+	}
+
+	damaged := path + ".damaged"
+	if err := os.WriteFile(damaged, data, 0o600); err != nil {
+		return findings, fmt.Errorf("failed to keep damaged history: %w", err)
+	}
+	if err := q.Move(store, damaged); err != nil {
+		os.Remove(damaged)
+		return findings, err
+	}
+	if err := atrest.WriteFileAtomic(path, kept.Bytes(), 0o600); err != nil {
+		return findings, fmt.Errorf("failed to rewrite history: %w", err)
+	}
+	return append(findings, fsck.Finding{
+		Store:   store,
+		Item:    filepath.Base(path),
+		Problem: fmt.Sprintf("%d unreadable lines", dropped),
+		Action:  fsck.ActionRepaired,
+	}), nil
+}
+
+// recoverable reports whether line is a record, or may be one that only a
+// data key at hand could open
+func (s *Store) recoverable(line []byte) bool {
+	if line[0] != '{' && s.codec == nil {
+		return true
+	}
+	_, _, ok, err := s.decodeLine(line, true)
+	if err != nil {
+		return !errors.Is(err, atrest.ErrTampered)
+	}
+	return ok
+}
+
+// Load returns all records in the order they were written. Unparseable lines,
+// such as a partial write at crash time, are skipped; records that fail to
+// decrypt are reported as errors.
+func (s *Store) Load() ([]Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records, _, err := s.load(false)
+	return records, err
+}
+
+func (s *Store) load(migrating bool) ([]Record, bool, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	needsReseal := false
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordBytes)
+	for scanner.Scan() {
+		record, sealed, ok, err := s.decodeLine(scanner.Bytes(), migrating)
+		if err != nil {
+			return nil, false, err
+		}
+		if !ok {
+			continue
+		}
+		if s.codec != nil && (sealed == nil || s.codec.NeedsReseal(sealed)) {
+			needsReseal = true
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to read history: %w", err)
+	}
+	return records, needsReseal, nil
+}
+
+// Reseal rewrites the history under the active data key when any record is
+// plaintext or sealed with an older key. Plaintext is only accepted until the
+// stores have been migrated. It reports whether a rewrite happened.
+func (s *Store) Reseal() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.codec == nil {
+		return false, nil
+	}
+
+	records, needsReseal, err := s.load(true)
+	if err != nil || !needsReseal {
+		return false, err
+	}
+	if err := s.rewrite(records); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Durations returns the durations of the most recent completed executions of
+// the given task type and workload, newest last, at most limit entries and
+// at most durationsKept. The history is read once; later calls are answered
+// from memory.
+func (s *Store) Durations(taskType models.TaskType, name string, limit int) ([]time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.durations == nil {
+		records, _, err := s.load(false)
+		if err != nil {
+			return nil, err
+		}
+		s.durations = make(map[workload][]time.Duration)
+		for _, record := range records {
+			s.remember(record)
+		}
+	}
+
+	durations := s.durations[workload{taskType, name}]
+	if limit > 0 && len(durations) > limit {
+		durations = durations[len(durations)-limit:]
+	}
+	return append([]time.Duration(nil), durations...), nil
+}
+
+// Timeline returns the latest timeline recorded for the task with taskID,
+// or nil when none was
+func (s *Store) Timeline(taskID string) (*models.TaskTimeline, error) {
+	records, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].Event == EventTimeline && records[i].TaskID == taskID && records[i].Timeline != nil {
+			return records[i].Timeline, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,119 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/fsck"
+)
+
+func TestTornWriteIsRepaired(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Append(Record{TaskID: "a", Status: models.TaskStatusCompleted}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash cut the next record short
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"task_id":"b","sta`)
+	f.Close()
+
+	if err := s.Append(Record{TaskID: "c", Status: models.TaskStatusCompleted}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[1].TaskID != "c" {
+		t.Fatalf("Load() = %+v, want the records around the torn one", records)
+	}
+
+	quarantine := t.TempDir()
+	findings, err := Fsck(path, nil, fsck.NewQuarantine(quarantine))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(findings) != 1 || findings[0].Action != fsck.ActionRepaired {
+		t.Fatalf("Fsck() = %+v, want the torn line dropped", findings)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Count(string(data), "\n") != 2 || strings.Contains(string(data), `"sta`+"\n") {
+		t.Fatalf("history = %q, want only whole records", data)
+	}
+	if kept, _ := os.ReadDir(filepath.Join(quarantine, "history")); len(kept) != 1 {
+		t.Fatalf("quarantined %d files, want the damaged history", len(kept))
+	}
+	if findings, err := Fsck(path, nil, nil); err != nil || len(findings) != 0 {
+		t.Fatalf("second Fsck() = %+v, %v", findings, err)
+	}
+}
+
+func TestCompactKeepsRetainedRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	s, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	s.SetClock(clocktest.NewFake(now))
+
+	for i, age := range []time.Duration{100 * 24 * time.Hour, 40 * 24 * time.Hour, 2 * time.Hour, time.Hour, 0} {
+		record := Record{
+			TaskID:     string(rune('a' + i)),
+			Type:       models.TaskTypeDocker,
+			Workload:   "alpine",
+			Status:     models.TaskStatusCompleted,
+			StartedAt:  now.Add(-age),
+			DurationMs: int64(i+1) * 1000,
+		}
+		if err := s.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if durations, _ := s.Durations(models.TaskTypeDocker, "alpine", 0); len(durations) != 5 {
+		t.Fatalf("Durations() = %v before compaction, want every execution", durations)
+	}
+
+	s.SetRetention(Retention{MaxAge: 90 * 24 * time.Hour, MaxRecords: 3})
+	dropped, err := s.Compact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dropped != 2 {
+		t.Fatalf("Compact() dropped %d records, want the expired one and the oldest over the cap", dropped)
+	}
+	records, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[0].TaskID != "c" {
+		t.Fatalf("Load() = %+v, want the 3 newest records", records)
+	}
+
+	durations, err := s.Durations(models.TaskTypeDocker, "alpine", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(durations) != 2 || durations[0] != 4*time.Second || durations[1] != 5*time.Second {
+		t.Fatalf("Durations() = %v, want the newest two kept", durations)
+	}
+	// Appends update what is kept in memory without reading the file again
+	os.Remove(path)
+	s.Append(Record{Type: models.TaskTypeDocker, Workload: "alpine", Status: models.TaskStatusCompleted, DurationMs: 6000})
+	if durations, _ := s.Durations(models.TaskTypeDocker, "alpine", 1); len(durations) != 1 || durations[0] != 6*time.Second {
+		t.Fatalf("Durations() = %v, want the appended execution", durations)
+	}
+}
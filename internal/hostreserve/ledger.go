@@ -0,0 +1,146 @@
+// Package hostreserve keeps the tasks a runner runs at once within the
+// memory and CPU of its host. Before a task is claimed, the memory and CPU
+// shares its resources declare are reserved against one ledger of the
+// host's capacity, and held until the task finishes or fails. A task that
+// fits only once others finish is refused with ErrHostBusy, so the server
+// can offer it again later; one that would not fit on an idle host is
+// refused with ErrExceedsHost.
+package hostreserve
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// CPUSharesPerCore is what a task running on a full core declares, as
+// Docker counts CPU shares
+const CPUSharesPerCore = 1024
+
+var (
+	// ErrExceedsHost is returned for a task that needs more than the host
+	// has, however few tasks run beside it
+	ErrExceedsHost = errors.New("task needs more than the host has")
+	// ErrHostBusy is returned for a task that fits on the host only once
+	// tasks running now finish
+	ErrHostBusy = errors.New("host resources committed to running tasks")
+)
+
+// Demand is the memory and CPU a task holds while it runs
+type Demand struct {
+	MemoryBytes uint64
+	CPUShares   int64
+}
+
+// add returns d and o together
+func (d Demand) add(o Demand) Demand {
+	return Demand{MemoryBytes: d.MemoryBytes + o.MemoryBytes, CPUShares: d.CPUShares + o.CPUShares}
+}
+
+// exceeds reports which of d's resources exceed capacity, empty when none
+// does. A zero capacity is not accounted.
+func (d Demand) exceeds(capacity Demand) string {
+	switch {
+	case capacity.MemoryBytes > 0 && d.MemoryBytes > capacity.MemoryBytes:
+		return "memory"
+	case capacity.CPUShares > 0 && d.CPUShares > capacity.CPUShares:
+		return "cpu"
+	}
+	return ""
+}
+
+// Stats reports the ledger
+type Stats struct {
+	Capacity Demand
+	// Committed is what running tasks hold of Capacity
+	Committed    Demand
+	Reservations int
+	// Rejected counts the tasks refused for not fitting
+	Rejected uint64
+}
+
+// Ledger holds the memory and CPU reservations of the tasks running on
+// one host
+type Ledger struct {
+	capacity Demand
+
+	mu           sync.Mutex
+	reservations map[string]Demand
+	committed    Demand
+	rejected     uint64
+}
+
+// NewLedger reserves against capacity. A resource whose capacity is zero,
+// as when the host's memory cannot be measured, admits every task.
+func NewLedger(capacity Demand) (*Ledger, error) {
+	if capacity.CPUShares < 0 {
+		return nil, fmt.Errorf("CPU capacity must not be negative, got %d shares", capacity.CPUShares)
+	}
+	return &Ledger{capacity: capacity, reservations: make(map[string]Demand)}, nil
+}
+
+// Reserve commits demand to taskID, refusing it with ErrExceedsHost or
+// ErrHostBusy when it does not fit. Reserving again for a task replaces
+// its reservation.
+func (l *Ledger) Reserve(taskID string, demand Demand) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.releaseLocked(taskID)
+	if resource := demand.exceeds(l.capacity); resource != "" {
+		l.rejected++
+		return fmt.Errorf("%w: %s", ErrExceedsHost, describe(resource, demand, l.capacity))
+	}
+	if resource := l.committed.add(demand).exceeds(l.capacity); resource != "" {
+		l.rejected++
+		free := Demand{
+			MemoryBytes: l.capacity.MemoryBytes - min(l.committed.MemoryBytes, l.capacity.MemoryBytes),
+			CPUShares:   l.capacity.CPUShares - min(l.committed.CPUShares, l.capacity.CPUShares),
+		}
+		return fmt.Errorf("%w: %s", ErrHostBusy, describe(resource, demand, free))
+	}
+	l.reservations[taskID] = demand
+	l.committed = l.committed.add(demand)
+	return nil
+}
+
+// describe says how much of resource demand needs against what is
+// available
+func describe(resource string, demand, available Demand) string {
+	if resource == "memory" {
+		return fmt.Sprintf("%d bytes of memory needed, %d available", demand.MemoryBytes, available.MemoryBytes)
+	}
+	return fmt.Sprintf("%d CPU shares needed, %d available", demand.CPUShares, available.CPUShares)
+}
+
+// Release frees the reservation of taskID, if any
+func (l *Ledger) Release(taskID string) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.releaseLocked(taskID)
+}
+
+func (l *Ledger) releaseLocked(taskID string) {
+	demand, ok := l.reservations[taskID]
+	if !ok {
+		return
+	}
+	delete(l.reservations, taskID)
+	l.committed.MemoryBytes -= demand.MemoryBytes
+	l.committed.CPUShares -= demand.CPUShares
+}
+
+// Stats reports the ledger's capacity and what is committed of it
+func (l *Ledger) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Stats{
+		Capacity:     l.capacity,
+		Committed:    l.committed,
+		Reservations: len(l.reservations),
+		Rejected:     l.rejected,
+	}
+}
@@ -0,0 +1,85 @@
+package hostreserve
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const gib = 1 << 30
+
+func TestReserveNeverOvercommits(t *testing.T) {
+	l, err := NewLedger(Demand{MemoryBytes: 32 * gib, CPUShares: 8 * CPUSharesPerCore})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		admitted atomic.Int64
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := l.Reserve(fmt.Sprintf("task-%d", i), Demand{MemoryBytes: 3 * gib, CPUShares: CPUSharesPerCore / 2})
+			switch {
+			case err == nil:
+				admitted.Add(1)
+			case !errors.Is(err, ErrHostBusy):
+				t.Errorf("Reserve() error = %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Memory runs out first: ten tasks of 3 GiB fit in 32 GiB
+	if admitted.Load() != 10 {
+		t.Fatalf("admitted %d tasks, want 10", admitted.Load())
+	}
+	stats := l.Stats()
+	if stats.Committed.MemoryBytes != 30*gib || stats.Committed.CPUShares != 5*CPUSharesPerCore || stats.Reservations != 10 || stats.Rejected != 40 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+}
+
+func TestReleaseFreesCapacity(t *testing.T) {
+	l, _ := NewLedger(Demand{MemoryBytes: 8 * gib, CPUShares: 2 * CPUSharesPerCore})
+	if err := l.Reserve("first", Demand{MemoryBytes: 6 * gib, CPUShares: CPUSharesPerCore}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reserve("second", Demand{MemoryBytes: 4 * gib, CPUShares: CPUSharesPerCore}); !errors.Is(err, ErrHostBusy) {
+		t.Fatalf("Reserve() beside a running task = %v, want ErrHostBusy", err)
+	}
+	l.Release("first")
+	if err := l.Reserve("second", Demand{MemoryBytes: 4 * gib, CPUShares: CPUSharesPerCore}); err != nil {
+		t.Fatalf("Reserve() once the first task finished = %v", err)
+	}
+	// Reserving again replaces the reservation rather than adding to it
+	if err := l.Reserve("second", Demand{MemoryBytes: 8 * gib, CPUShares: 2 * CPUSharesPerCore}); err != nil {
+		t.Fatalf("Reserve() replacing a reservation = %v", err)
+	}
+	l.Release("second")
+	l.Release("unknown")
+	if stats := l.Stats(); stats.Committed != (Demand{}) || stats.Reservations != 0 {
+		t.Errorf("Stats() after releasing everything = %+v", stats)
+	}
+}
+
+func TestReserveRefusesWhatExceedsTheHost(t *testing.T) {
+	l, _ := NewLedger(Demand{MemoryBytes: 8 * gib, CPUShares: 2 * CPUSharesPerCore})
+	if err := l.Reserve("huge", Demand{MemoryBytes: 16 * gib}); !errors.Is(err, ErrExceedsHost) {
+		t.Errorf("Reserve() of more memory than the host has = %v, want ErrExceedsHost", err)
+	}
+	if err := l.Reserve("wide", Demand{CPUShares: 4 * CPUSharesPerCore}); !errors.Is(err, ErrExceedsHost) {
+		t.Errorf("Reserve() of more CPU than the host has = %v, want ErrExceedsHost", err)
+	}
+
+	// Unmeasured memory is not accounted
+	unmeasured, _ := NewLedger(Demand{CPUShares: CPUSharesPerCore})
+	if err := unmeasured.Reserve("task", Demand{MemoryBytes: 64 * gib, CPUShares: CPUSharesPerCore}); err != nil {
+		t.Errorf("Reserve() without a memory capacity = %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+// Package idlework donates a runner's idle time to community federated
+// learning sessions. A donated round trains only while no paid task runs,
+// at the lowest priority the host offers, and yields the moment a paid task
+// is claimable: the epoch in progress is cancelled, the model goes back to
+// the weights it had when the epoch began, and training carries on from
+// there once the runner is idle again.
+package idlework
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ErrPreempted is the cause of the context of an epoch of donated training
+// cancelled because a paid task arrived
+var ErrPreempted = errors.New("donated training preempted by a paid task")
+
+// Scheduling policies donated training may run under
+const (
+	// SchedIdle runs donated training only when a CPU has nothing else to
+	// run (Linux SCHED_IDLE)
+	SchedIdle = "idle"
+	// SchedBatch runs it as CPU-bound batch work, niced (SCHED_BATCH)
+	SchedBatch = "batch"
+	// SchedNormal runs it niced under the default policy
+	SchedNormal = "normal"
+)
+
+// IO classes donated training may run under
+const (
+	// IOIdle gives donated training disk time only when no other process
+	// asks for it
+	IOIdle = "idle"
+	// IOBestEffort shares disk time by the training's nice value
+	IOBestEffort = "best-effort"
+)
+
+// Priority is what donated training runs at
+type Priority struct {
+	// Nice is the nice value, 0 to 19
+	Nice  int
+	Sched string
+	IO    string
+}
+
+// Validate checks that the priority is one the runner can apply
+func (p Priority) Validate() error {
+	if p.Nice < 0 || p.Nice > 19 {
+		return fmt.Errorf("nice value %d is not between 0 and 19", p.Nice)
+	}
+	if !slices.Contains([]string{SchedIdle, SchedBatch, SchedNormal}, p.Sched) {
+		return fmt.Errorf("unknown scheduling policy %q (supported: %s, %s, %s)", p.Sched, SchedIdle, SchedBatch, SchedNormal)
+	}
+	if !slices.Contains([]string{IOIdle, IOBestEffort}, p.IO) {
+		return fmt.Errorf("unknown IO class %q (supported: %s, %s)", p.IO, IOIdle, IOBestEffort)
+	}
+	return nil
+}
+
+// Donor decides which federated learning rounds are donated work and makes
+// them yield to paid tasks
+type Donor struct {
+	sessions []string
+	priority Priority
+	clock    clock.Clock
+
+	mu sync.Mutex
+	// paid counts the paid tasks holding donated training back; idle is
+	// closed while there are none
+	paid int
+	idle chan struct{}
+	// epochs cancels the epochs of donated training running
+	epochs      map[int]context.CancelCauseFunc
+	nextEpoch   int
+	preemptedAt time.Time
+	active      string
+	rounds      uint64
+	preemptions uint64
+	donated     time.Duration
+	lastYield   time.Duration
+}
+
+// New returns a donor of the rounds of sessions, training at priority
+func New(sessions []string, priority Priority) (*Donor, error) {
+	if err := priority.Validate(); err != nil {
+		return nil, err
+	}
+	d := &Donor{
+		priority: priority,
+		clock:    clock.Real(),
+		idle:     make(chan struct{}),
+		epochs:   make(map[int]context.CancelCauseFunc),
+	}
+	for _, session := range sessions {
+		if session = strings.TrimSpace(session); session != "" && !slices.Contains(d.sessions, session) {
+			d.sessions = append(d.sessions, session)
+		}
+	}
+	if len(d.sessions) == 0 {
+		return nil, errors.New("no community sessions to donate to")
+	}
+	close(d.idle)
+	return d, nil
+}
+
+// SetClock replaces the clock donated time is measured with
+func (d *Donor) SetClock(c clock.Clock) {
+	d.clock = c
+}
+
+// Donates reports whether the rounds of sessionID are donated work
+func (d *Donor) Donates(sessionID string) bool {
+	return d != nil && sessionID != "" && slices.Contains(d.sessions, sessionID)
+}
+
+// Idle reports whether no paid task holds donated training back
+func (d *Donor) Idle() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paid == 0
+}
+
+// Begin marks the donated round taskID as the one the runner trains. It
+// returns false when another donated round already is.
+func (d *Donor) Begin(taskID string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.active != "" {
+		return false
+	}
+	d.active = taskID
+	return true
+}
+
+// Active returns the donated round the runner trains, empty when none
+func (d *Donor) Active() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.active
+}
+
+// Finish marks the donated round taskID finished, counting it when it
+// completed
+func (d *Donor) Finish(taskID string, completed bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.active != taskID {
+		return
+	}
+	d.active = ""
+	if completed {
+		d.rounds++
+	}
+}
+
+// Preempt makes donated training yield to a paid task: the epochs running
+// are cancelled with ErrPreempted, and no epoch starts until release has
+// been called for every Preempt
+func (d *Donor) Preempt() (release func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.paid == 0 {
+		d.idle = make(chan struct{})
+	}
+	d.paid++
+	if len(d.epochs) > 0 {
+		d.preemptions++
+		d.preemptedAt = d.clock.Now()
+		for _, cancel := range d.epochs {
+			cancel(ErrPreempted)
+		}
+	}
+	var once sync.Once
+	return func() { once.Do(d.release) }
+}
+
+func (d *Donor) release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paid--
+	if d.paid == 0 {
+		close(d.idle)
+	}
+}
+
+// Epoch waits until no paid task holds donated training back and returns
+// the context an epoch of training runs under, cancelled with ErrPreempted
+// once a paid task arrives. done ends the epoch.
+func (d *Donor) Epoch(ctx context.Context) (epochCtx context.Context, done func(), err error) {
+	for {
+		d.mu.Lock()
+		if d.paid == 0 {
+			break
+		}
+		idle := d.idle
+		d.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-idle:
+		}
+	}
+	defer d.mu.Unlock()
+
+	epochCtx, cancel := context.WithCancelCause(ctx)
+	id := d.nextEpoch
+	d.nextEpoch++
+	d.epochs[id] = cancel
+	started := d.clock.Now()
+	var once sync.Once
+	return epochCtx, func() {
+		once.Do(func() {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			delete(d.epochs, id)
+			now := d.clock.Now()
+			d.donated += now.Sub(started)
+			if Preempted(epochCtx) {
+				d.lastYield = now.Sub(d.preemptedAt)
+			}
+			cancel(nil)
+		})
+	}, nil
+}
+
+// Preempted reports whether ctx, the context of an epoch, was cancelled
+// because a paid task arrived
+func Preempted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrPreempted)
+}
+
+// Run runs f on an OS thread of its own lowered to the donor's priority.
+// The thread is never handed back to the Go scheduler, so it exits with f
+// rather than running other goroutines at that priority.
+func (d *Donor) Run(f func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		runtime.LockOSThread()
+		if err := lowerPriority(d.priority); err != nil {
+			log := gologger.WithComponent("idlework")
+			log.Debug().Err(err).Msg("Failed to lower the priority of donated training")
+		}
+		f()
+	}()
+	<-done
+}
+
+// Status reports the work the runner donated
+func (d *Donor) Status() *models.DonationStatus {
+	if d == nil {
+		return nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return &models.DonationStatus{
+		Sessions:    slices.Clone(d.sessions),
+		Active:      d.active,
+		Preempted:   d.paid > 0 && d.active != "",
+		Rounds:      d.rounds,
+		Preemptions: d.preemptions,
+		DonatedTime: d.donated,
+		LastYield:   d.lastYield,
+	}
+}
+
+type donorKey struct{}
+
+// WithDonor returns ctx carrying d, making the round run under it donated
+// work
+func WithDonor(ctx context.Context, d *Donor) context.Context {
+	return context.WithValue(ctx, donorKey{}, d)
+}
+
+// From returns the donor ctx carries, nil for paid work
+func From(ctx context.Context) *Donor {
+	d, _ := ctx.Value(donorKey{}).(*Donor)
+	return d
+}
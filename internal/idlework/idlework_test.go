@@ -0,0 +1,126 @@
+package idlework
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+)
+
+var lowest = Priority{Nice: 19, Sched: SchedIdle, IO: IOIdle}
+
+func newDonor(t *testing.T) (*Donor, *clocktest.Fake) {
+	t.Helper()
+	d, err := New([]string{"community-1", " community-2 ", "community-1"}, lowest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clocktest.NewFake(time.Unix(0, 0))
+	d.SetClock(clk)
+	return d, clk
+}
+
+func TestNewValidates(t *testing.T) {
+	if _, err := New(nil, lowest); err == nil {
+		t.Error("New() accepted no sessions")
+	}
+	for _, priority := range []Priority{
+		{Nice: 20, Sched: SchedIdle, IO: IOIdle},
+		{Nice: 19, Sched: "realtime", IO: IOIdle},
+		{Nice: 19, Sched: SchedIdle, IO: "realtime"},
+	} {
+		if _, err := New([]string{"community-1"}, priority); err == nil {
+			t.Errorf("New() accepted priority %+v", priority)
+		}
+	}
+}
+
+func TestDonates(t *testing.T) {
+	d, _ := newDonor(t)
+	if !d.Donates("community-1") || !d.Donates("community-2") || d.Donates("paid-1") || d.Donates("") {
+		t.Fatal("Donates() does not match the configured sessions")
+	}
+	var none *Donor
+	if none.Donates("community-1") || none.Status() != nil {
+		t.Fatal("nil donor donates")
+	}
+}
+
+func TestPreemptCancelsEpochsAndHoldsNewOnes(t *testing.T) {
+	d, clk := newDonor(t)
+	epochCtx, done, err := d.Epoch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	release := d.Preempt()
+	if !Preempted(epochCtx) {
+		t.Fatal("running epoch not preempted")
+	}
+	clk.Advance(20 * time.Millisecond)
+	done()
+
+	started := make(chan struct{})
+	go func() {
+		_, done, err := d.Epoch(context.Background())
+		if err == nil {
+			done()
+		}
+		close(started)
+	}()
+	select {
+	case <-started:
+		t.Fatal("epoch started while a paid task runs")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	second := d.Preempt()
+	release()
+	release()
+	select {
+	case <-started:
+		t.Fatal("epoch started while a second paid task runs")
+	case <-time.After(20 * time.Millisecond):
+	}
+	second()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("epoch did not start once the paid tasks ended")
+	}
+
+	status := d.Status()
+	if status.Preemptions != 1 || status.LastYield != 20*time.Millisecond || status.DonatedTime != 20*time.Millisecond {
+		t.Fatalf("status %+v, want one preemption yielding after 20ms", status)
+	}
+}
+
+func TestEpochWaitEndsWithContext(t *testing.T) {
+	d, _ := newDonor(t)
+	release := d.Preempt()
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := d.Epoch(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Epoch() error = %v, want the context's", err)
+	}
+}
+
+func TestBeginFinish(t *testing.T) {
+	d, _ := newDonor(t)
+	if !d.Begin("task-1") || d.Begin("task-2") {
+		t.Fatal("Begin() let two donated rounds train at once")
+	}
+	release := d.Preempt()
+	if status := d.Status(); status.Active != "task-1" || !status.Preempted {
+		t.Fatalf("status %+v, want task-1 preempted", status)
+	}
+	release()
+	d.Finish("task-2", true)
+	d.Finish("task-1", true)
+	if status := d.Status(); status.Active != "" || status.Rounds != 1 || status.Preempted {
+		t.Fatalf("status %+v, want one round finished", status)
+	}
+}
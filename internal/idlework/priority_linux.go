@@ -0,0 +1,43 @@
+package idlework
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ioprioWhoProcess makes ioprio_set address a single thread by its ID
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+	ioprioClassBE    = 2
+	ioprioClassIdle  = 3
+)
+
+// lowerPriority applies priority to the calling thread: its scheduling
+// policy and nice value, then its IO class
+func lowerPriority(priority Priority) error {
+	policy := uint32(unix.SCHED_NORMAL)
+	switch priority.Sched {
+	case SchedIdle:
+		policy = unix.SCHED_IDLE
+	case SchedBatch:
+		policy = unix.SCHED_BATCH
+	}
+	attr := unix.SchedAttr{Policy: policy, Nice: int32(priority.Nice)}
+	attr.Size = uint32(unsafe.Sizeof(attr))
+	if err := unix.SchedSetAttr(0, &attr, 0); err != nil {
+		return fmt.Errorf("failed to set scheduling policy: %w", err)
+	}
+
+	ioprio := ioprioClassIdle << ioprioClassShift
+	if priority.IO == IOBestEffort {
+		// Best-effort levels run 0 to 7 as nice values run -20 to 19
+		ioprio = ioprioClassBE<<ioprioClassShift | (priority.Nice+20)/5
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(unix.Gettid()), uintptr(ioprio)); errno != 0 {
+		return fmt.Errorf("failed to set IO class: %w", errno)
+	}
+	return nil
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package idlework
+
+import "errors"
+
+// lowerPriority is unsupported without per-thread scheduling policies, so
+// donated training runs at the runner's priority and relies on yielding
+func lowerPriority(priority Priority) error {
+	return errors.ErrUnsupported
+}
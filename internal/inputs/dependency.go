@@ -0,0 +1,150 @@
+package inputs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ErrArtifactExpired is returned by an ArtifactSource for artifacts the
+// server no longer keeps
+var ErrArtifactExpired = errors.New("artifact expired")
+
+var artifactSHA256 = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ArtifactSource fetches the artifacts of the earlier tasks inputs name
+type ArtifactSource interface {
+	// DescribeArtifact returns the hash and size of taskID's artifact
+	DescribeArtifact(ctx context.Context, taskID, name string) (*models.ResultArtifact, error)
+	// OpenArtifact starts reading the artifact's content
+	OpenArtifact(ctx context.Context, taskID, name string) (io.ReadCloser, error)
+}
+
+// DependencyError is returned when an input naming an artifact of another
+// task cannot be fetched. The task fails without having run, through no
+// fault of its own.
+type DependencyError struct {
+	Input    string
+	TaskID   string
+	Artifact string
+	// Reason is models.DependencyExpired or models.DependencyUnavailable
+	Reason string
+	Err    error
+}
+
+func (e *DependencyError) Error() string {
+	return fmt.Sprintf("input %s: artifact %s of task %s is %s: %v", e.Input, e.Artifact, e.TaskID, e.Reason, e.Err)
+}
+
+func (e *DependencyError) Unwrap() error {
+	return e.Err
+}
+
+// Failure describes the error for the failed task's result
+func (e *DependencyError) Failure() *models.DependencyFailure {
+	return &models.DependencyFailure{
+		TaskID:   e.TaskID,
+		Artifact: e.Artifact,
+		Input:    e.Input,
+		Reason:   e.Reason,
+	}
+}
+
+// dependencyError returns err as the failure to fetch spec's artifact
+func dependencyError(spec *models.TaskInput, err error) error {
+	var dependency *DependencyError
+	if errors.As(err, &dependency) {
+		return err
+	}
+	reason := models.DependencyUnavailable
+	if errors.Is(err, ErrArtifactExpired) {
+		reason = models.DependencyExpired
+	}
+	return &DependencyError{
+		Input:    spec.Name,
+		TaskID:   spec.Source.Task.TaskID,
+		Artifact: spec.Source.Task.Artifact,
+		Reason:   reason,
+		Err:      err,
+	}
+}
+
+// resolveArtifact returns a copy of spec declaring the hash and size of the
+// artifact it names, which makes it cached by its content. A hash spec
+// declares itself must match the server's.
+func (m *Manager) resolveArtifact(ctx context.Context, spec *models.TaskInput) (*models.TaskInput, error) {
+	ref := spec.Source.Task
+	if m.artifacts == nil {
+		return nil, fmt.Errorf("input %s: task artifacts are not enabled on this runner", spec.Name)
+	}
+	artifact, err := m.artifacts.DescribeArtifact(ctx, ref.TaskID, ref.Artifact)
+	if err != nil {
+		return nil, dependencyError(spec, err)
+	}
+	if !artifactSHA256.MatchString(artifact.SHA256) {
+		return nil, dependencyError(spec, fmt.Errorf("server described the artifact with an invalid sha256 %q", artifact.SHA256))
+	}
+	if spec.SHA256 != "" && spec.SHA256 != artifact.SHA256 {
+		return nil, dependencyError(spec, fmt.Errorf("%w: server describes sha256 %s, input declares %s", ErrVerification, artifact.SHA256, spec.SHA256))
+	}
+	resolved := *spec
+	resolved.SHA256 = artifact.SHA256
+	if resolved.Size == 0 {
+		resolved.Size = artifact.Size
+	}
+	return &resolved, nil
+}
+
+// SetLocalArtifacts serves inputs naming an artifact of a task this runner
+// ran from dir, where such artifacts are kept as dir/<task id>/<name>,
+// instead of downloading them from the server. A local copy is used only
+// when it matches the hash and size the server describes.
+func (m *Manager) SetLocalArtifacts(dir string) {
+	m.localArtifacts = dir
+}
+
+// fetchLocal writes the local copy of the artifact resolved spec names to
+// dest, reporting whether there was one matching it. Copies that are
+// missing or differ from the server's are left for download to replace.
+func (m *Manager) fetchLocal(ctx context.Context, spec *models.TaskInput, key, dir, dest string) (string, int64, bool) {
+	ref := spec.Source.Task
+	if m.localArtifacts == "" || ref.Artifact == "." || ref.Artifact == ".." {
+		return "", 0, false
+	}
+	log := gologger.WithComponent("inputs")
+	f, err := os.Open(filepath.Join(m.localArtifacts, ref.TaskID, ref.Artifact))
+	if err != nil {
+		return "", 0, false
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() || info.Size() != spec.Size {
+		log.Debug().
+			Str("input", spec.Name).
+			Str("task_id", ref.TaskID).
+			Msg("Local artifact differs in size from the server's, downloading it")
+		return "", 0, false
+	}
+
+	digest, size, err := m.write(ctx, spec, f, key, dir, dest)
+	if err != nil {
+		log.Warn().Err(err).
+			Str("input", spec.Name).
+			Str("task_id", ref.TaskID).
+			Msg("Local artifact does not match the server's, downloading it")
+		return "", 0, false
+	}
+	log.Debug().
+		Str("input", spec.Name).
+		Str("task_id", ref.TaskID).
+		Str("artifact", ref.Artifact).
+		Msg("Served dependency from local artifacts")
+	return digest, size, true
+}
@@ -0,0 +1,273 @@
+package inputs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// fakeArtifacts serves the artifacts of earlier tasks, keyed by task ID and
+// name, as the server would
+type fakeArtifacts struct {
+	mu        sync.Mutex
+	artifacts map[string]string
+	// expired artifacts are described but gone once opened
+	expired map[string]bool
+	opens   map[string]int
+	// release, when set, holds opens until it is closed
+	release chan struct{}
+}
+
+func newFakeArtifacts() *fakeArtifacts {
+	return &fakeArtifacts{
+		artifacts: make(map[string]string),
+		expired:   make(map[string]bool),
+		opens:     make(map[string]int),
+	}
+}
+
+func (f *fakeArtifacts) DescribeArtifact(ctx context.Context, taskID, name string) (*models.ResultArtifact, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content, ok := f.artifacts[taskID+"/"+name]
+	if !ok {
+		return nil, fmt.Errorf("%w: status 410", ErrArtifactExpired)
+	}
+	return &models.ResultArtifact{Name: name, SHA256: digest(content), Size: int64(len(content))}, nil
+}
+
+func (f *fakeArtifacts) OpenArtifact(ctx context.Context, taskID, name string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	key := taskID + "/" + name
+	f.opens[key]++
+	content, expired, release := f.artifacts[key], f.expired[key], f.release
+	f.mu.Unlock()
+	if release != nil {
+		<-release
+	}
+	if expired {
+		return nil, fmt.Errorf("%w: status 404", ErrArtifactExpired)
+	}
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+func (f *fakeArtifacts) openCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	total := 0
+	for _, opens := range f.opens {
+		total += opens
+	}
+	return total
+}
+
+func artifactInput(name, taskID, artifact string) models.TaskInput {
+	return models.TaskInput{
+		Name:       name,
+		Source:     models.InputSource{Task: &models.TaskArtifactRef{TaskID: taskID, Artifact: artifact}},
+		TargetPath: "/data/" + name,
+	}
+}
+
+const (
+	taskA = "a0000000-0000-4000-8000-000000000001"
+	taskB = "b0000000-0000-4000-8000-000000000002"
+	taskC = "c0000000-0000-4000-8000-000000000003"
+)
+
+// TestDiamondDependenciesFetchOnce runs the tasks of a diamond: B and C
+// both depend on A's features, at the same time, and D depends on B, C and
+// A again. A's artifact is downloaded once.
+func TestDiamondDependenciesFetchOnce(t *testing.T) {
+	source := newFakeArtifacts()
+	source.artifacts[taskA+"/features.bin"] = "features of A"
+	source.artifacts[taskB+"/scores.csv"] = "scores of B"
+	source.artifacts[taskC+"/scores.csv"] = "scores of C"
+	source.release = make(chan struct{})
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetArtifactSource(source)
+
+	prepare := func(specs ...models.TaskInput) *Set {
+		set, err := m.Prepare(context.Background(), specs, models.TaskTypeDocker)
+		if err != nil {
+			t.Errorf("Prepare() error = %v", err)
+			return nil
+		}
+		return set
+	}
+
+	var wg sync.WaitGroup
+	sets := make([]*Set, 2)
+	for i := range sets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sets[i] = prepare(artifactInput("features", taskA, "features.bin"))
+		}()
+	}
+	// Hold the first download open while the other task asks for the same
+	// artifact
+	for source.openCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(source.release)
+	wg.Wait()
+	for _, set := range sets {
+		if set == nil {
+			t.FailNow()
+		}
+		if got := set.Resolved[0]; got.SHA256 != digest("features of A") {
+			t.Errorf("Resolved = %+v, want A's features", got)
+		}
+		set.Close()
+	}
+
+	set := prepare(
+		artifactInput("b", taskB, "scores.csv"),
+		artifactInput("c", taskC, "scores.csv"),
+		artifactInput("features", taskA, "features.bin"),
+	)
+	if set == nil {
+		t.FailNow()
+	}
+	defer set.Close()
+	for _, staged := range set.Staged {
+		data, err := os.ReadFile(staged.HostPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]string{"b": "scores of B", "c": "scores of C", "features": "features of A"}[staged.Input.Name]
+		if string(data) != want {
+			t.Errorf("input %s holds %q, want %q", staged.Input.Name, data, want)
+		}
+	}
+
+	for key, opens := range source.opens {
+		if opens != 1 {
+			t.Errorf("%s downloaded %d times, want once", key, opens)
+		}
+	}
+}
+
+func TestExpiredDependencyFailsTask(t *testing.T) {
+	source := newFakeArtifacts()
+	source.artifacts[taskA+"/described.bin"] = "gone by the time it is read"
+	source.expired[taskA+"/described.bin"] = true
+	source.artifacts[taskA+"/changed.bin"] = "changed"
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetArtifactSource(source)
+
+	changed := artifactInput("changed", taskA, "changed.bin")
+	changed.SHA256 = digest("what the child expected")
+	tests := []struct {
+		name   string
+		spec   models.TaskInput
+		reason string
+	}{
+		{"unknown to the server", artifactInput("missing", taskA, "missing.bin"), models.DependencyExpired},
+		{"expired while fetched", artifactInput("described", taskA, "described.bin"), models.DependencyExpired},
+		{"different content", changed, models.DependencyUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := m.Prepare(context.Background(), []models.TaskInput{tt.spec}, models.TaskTypeDocker)
+			var dependency *DependencyError
+			if !errors.As(err, &dependency) {
+				t.Fatalf("Prepare() error = %v, want a DependencyError", err)
+			}
+			want := models.DependencyFailure{TaskID: taskA, Artifact: tt.spec.Source.Task.Artifact, Input: tt.spec.Name, Reason: tt.reason}
+			if got := dependency.Failure(); *got != want {
+				t.Fatalf("Failure() = %+v, want %+v", got, want)
+			}
+		})
+	}
+
+	// Inputs that fail for their own reasons are not dependency failures
+	invalid := artifactInput("invalid", "not-a-uuid", "features.bin")
+	_, err = m.Prepare(context.Background(), []models.TaskInput{invalid}, models.TaskTypeDocker)
+	var dependency *DependencyError
+	if err == nil || errors.As(err, &dependency) {
+		t.Fatalf("Prepare() error = %v for an invalid input, want a plain error", err)
+	}
+}
+
+// writeLocalArtifact keeps content as taskID's artifact name under dir, as
+// the runner keeps the artifacts of tasks it ran
+func writeLocalArtifact(t *testing.T, dir, taskID, name, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, taskID), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, taskID, name), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLocalDependencies serves a child from its parent's artifacts on the
+// same runner, falling back to the server for copies that differ from its
+// own
+func TestLocalDependencies(t *testing.T) {
+	source := newFakeArtifacts()
+	source.artifacts[taskA+"/features.bin"] = "features of A"
+	source.artifacts[taskB+"/scores.csv"] = "scores of B"
+	local := t.TempDir()
+	writeLocalArtifact(t, local, taskA, "features.bin", "features of A")
+	// B's artifact was changed after the server took it, keeping its size
+	writeLocalArtifact(t, local, taskB, "scores.csv", "scores of X")
+
+	newManager := func() *Manager {
+		m, err := NewManager(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.SetArtifactSource(source)
+		m.SetLocalArtifacts(local)
+		return m
+	}
+	read := func(m *Manager, spec models.TaskInput) string {
+		t.Helper()
+		set, err := m.Prepare(context.Background(), []models.TaskInput{spec}, models.TaskTypeDocker)
+		if err != nil {
+			t.Fatalf("Prepare() error = %v", err)
+		}
+		defer set.Close()
+		data, err := os.ReadFile(set.Staged[0].HostPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	m := newManager()
+	if got := read(m, artifactInput("features", taskA, "features.bin")); got != "features of A" || source.openCount() != 0 {
+		t.Fatalf("input holds %q after %d downloads, want A's features served locally", got, source.openCount())
+	}
+	if got := read(m, artifactInput("b", taskB, "scores.csv")); got != "scores of B" || source.opens[taskB+"/scores.csv"] != 1 {
+		t.Fatalf("input holds %q, want B's scores downloaded in place of the changed local copy", got)
+	}
+	if data, err := os.ReadFile(filepath.Join(local, taskB, "scores.csv")); err != nil || string(data) != "scores of X" {
+		t.Errorf("local artifact = %q, %v, want it left as it was", data, err)
+	}
+
+	// The artifacts outlive the runner: a restarted one with an empty input
+	// cache still serves them locally
+	if got := read(newManager(), artifactInput("features", taskA, "features.bin")); got != "features of A" || source.opens[taskA+"/features.bin"] != 0 {
+		t.Fatalf("input holds %q after a restart, want A's features served locally", got)
+	}
+}
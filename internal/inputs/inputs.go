@@ -0,0 +1,112 @@
+// Package inputs downloads the named files a task declares, verifies them
+// and places them where the task expects them: bind-mounted into docker
+// containers or linked into a command task's working directory.
+package inputs
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/objectstore"
+)
+
+var (
+	// ErrInsufficientDisk is returned when the inputs a task still needs to
+	// download do not fit on disk
+	ErrInsufficientDisk = errors.New("not enough disk space for task inputs")
+	// ErrVerification is returned for downloads that do not match the hash
+	// or size their input declares
+	ErrVerification = errors.New("input verification failed")
+	// ErrUnavailable is returned for inputs their source says it does not
+	// have, such as a CID no gateway serves
+	ErrUnavailable = errors.New("input unavailable")
+)
+
+// reservedContainerPaths may not be replaced by an input. The output
+// directory matches docker.ContainerOutputDir.
+var reservedContainerPaths = []string{"/proc", "/sys", "/dev", "/parity/output"}
+
+// Validate checks specs for a task of taskType: each input on its own, that
+// names are unique, that target paths stay where the task type allows and
+// that no target is the same as, or nested inside, another
+func Validate(specs []models.TaskInput, taskType models.TaskType) error {
+	names := make(map[string]bool, len(specs))
+	targets := make([]string, len(specs))
+	for i := range specs {
+		spec := &specs[i]
+		if err := spec.Validate(); err != nil {
+			return err
+		}
+		if objectstore.IsURI(spec.Source.URL) {
+			// Credentials for s3:// inputs only ever come from the runner
+			if _, _, err := objectstore.ParseURI(spec.Source.URL); err != nil {
+				return fmt.Errorf("input %s: %w", spec.Name, err)
+			}
+		}
+		if names[spec.Name] {
+			return fmt.Errorf("duplicate input name %s", spec.Name)
+		}
+		names[spec.Name] = true
+
+		target, err := TargetPath(spec, taskType)
+		if err != nil {
+			return err
+		}
+		for j, other := range targets[:i] {
+			if target == other || nested(other, target) || nested(target, other) {
+				return fmt.Errorf("input %s: target %s collides with input %s", spec.Name, target, specs[j].Name)
+			}
+		}
+		targets[i] = target
+	}
+	return nil
+}
+
+// TargetPath returns the cleaned, slash-separated target of spec. Docker
+// targets must be absolute container paths outside the system and output
+// directories; command targets must be relative paths inside the working
+// directory. Targets with ".." elements are rejected rather than cleaned
+// so that a path never means something other than it reads.
+func TargetPath(spec *models.TaskInput, taskType models.TaskType) (string, error) {
+	raw := filepath.ToSlash(spec.TargetPath)
+	for _, element := range strings.Split(raw, "/") {
+		if element == ".." {
+			return "", fmt.Errorf("input %s: target %s must not contain '..'", spec.Name, spec.TargetPath)
+		}
+	}
+	target := path.Clean(raw)
+
+	switch taskType {
+	case models.TaskTypeDocker:
+		if !path.IsAbs(target) {
+			return "", fmt.Errorf("input %s: target %s must be an absolute container path", spec.Name, spec.TargetPath)
+		}
+		if target == "/" {
+			return "", fmt.Errorf("input %s: target must not be the container root", spec.Name)
+		}
+		for _, reserved := range reservedContainerPaths {
+			if target == reserved || nested(reserved, target) {
+				return "", fmt.Errorf("input %s: target %s is inside reserved path %s", spec.Name, target, reserved)
+			}
+		}
+	case models.TaskTypeCommand:
+		if path.IsAbs(target) || filepath.IsAbs(spec.TargetPath) || filepath.VolumeName(spec.TargetPath) != "" {
+			return "", fmt.Errorf("input %s: target %s must be relative to the working directory", spec.Name, spec.TargetPath)
+		}
+		if target == "." {
+			return "", fmt.Errorf("input %s: target must not be the working directory itself", spec.Name)
+		}
+	default:
+		return "", fmt.Errorf("inputs are not supported for %s tasks", taskType)
+	}
+	return target, nil
+}
+
+// nested reports whether target lies strictly inside dir
+func nested(dir, target string) bool {
+	return strings.HasPrefix(target, strings.TrimSuffix(dir, "/")+"/")
+}
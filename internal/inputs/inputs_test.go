@@ -0,0 +1,440 @@
+package inputs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/fsck"
+	"github.com/theblitlabs/parity-runner/internal/ipfsfetch"
+	"github.com/theblitlabs/parity-runner/internal/objectstore"
+	"github.com/theblitlabs/parity-runner/internal/objectstore/objectstoretest"
+	"github.com/theblitlabs/parity-runner/internal/unixfs"
+)
+
+func input(name, target string) models.TaskInput {
+	return models.TaskInput{Name: name, Source: models.InputSource{URL: "https://example.com/" + name}, TargetPath: target}
+}
+
+func TestTargetPathSanitization(t *testing.T) {
+	tests := []struct {
+		taskType models.TaskType
+		target   string
+		want     string
+		wantErr  string
+	}{
+		{models.TaskTypeDocker, "/data/weights.bin", "/data/weights.bin", ""},
+		{models.TaskTypeDocker, "/data//./weights.bin", "/data/weights.bin", ""},
+		{models.TaskTypeDocker, "data/weights.bin", "", "absolute container path"},
+		{models.TaskTypeDocker, "/data/../etc/passwd", "", "'..'"},
+		{models.TaskTypeDocker, "/", "", "container root"},
+		{models.TaskTypeDocker, "/proc/self/environ", "", "reserved path /proc"},
+		{models.TaskTypeDocker, "/dev", "", "reserved path /dev"},
+		{models.TaskTypeDocker, "/parity/output/data.csv", "", "reserved path /parity/output"},
+		{models.TaskTypeDocker, "/devices/data.csv", "/devices/data.csv", ""},
+		{models.TaskTypeCommand, "data/labels.csv", "data/labels.csv", ""},
+		{models.TaskTypeCommand, "./labels.csv", "labels.csv", ""},
+		{models.TaskTypeCommand, "/etc/labels.csv", "", "relative to the working directory"},
+		{models.TaskTypeCommand, "data/../../labels.csv", "", "'..'"},
+		{models.TaskTypeCommand, "..", "", "'..'"},
+		{models.TaskTypeCommand, ".", "", "working directory itself"},
+		{models.TaskTypeLLM, "labels.csv", "", "not supported"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.taskType)+" "+tt.target, func(t *testing.T) {
+			spec := input("in", tt.target)
+			got, err := TargetPath(&spec, tt.taskType)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("TargetPath() error = %v, want one mentioning %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("TargetPath() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateCollisions(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []models.TaskInput
+		wantErr string
+	}{
+		{"distinct targets", []models.TaskInput{input("a", "/data/a"), input("b", "/data/b")}, ""},
+		{"sibling prefix is no collision", []models.TaskInput{input("a", "/data/a"), input("b", "/data/ab")}, ""},
+		{"same target", []models.TaskInput{input("a", "/data/a"), input("b", "/data//a")}, "collides with input a"},
+		{"nested target", []models.TaskInput{input("a", "/data/a"), input("b", "/data/a/b")}, "collides with input a"},
+		{"enclosing target", []models.TaskInput{input("a", "/data/a/b"), input("b", "/data/a")}, "collides with input a"},
+		{"duplicate name", []models.TaskInput{input("a", "/data/a"), input("a", "/data/b")}, "duplicate input name"},
+		{"bad name", []models.TaskInput{input("../a", "/data/a")}, "input name"},
+		{"two sources", []models.TaskInput{{Name: "a", Source: models.InputSource{URL: "https://example.com/a", CID: "bafyabcdefgh"}, TargetPath: "/a"}}, "exactly one"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.specs, models.TaskTypeDocker)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want one mentioning %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// newServer serves content at every path and counts downloads
+func newServer(t *testing.T, content string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var gets atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			gets.Add(1)
+		}
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(server.Close)
+	return server, &gets
+}
+
+func digest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestPrepareCachesAndVerifies(t *testing.T) {
+	const content = "feature,label\n1,0\n"
+	server, gets := newServer(t, content)
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := models.TaskInput{Name: "labels", Source: models.InputSource{URL: server.URL + "/labels.csv"}, SHA256: digest(content), TargetPath: "data/labels.csv"}
+	for i := 0; i < 2; i++ {
+		set, err := m.Prepare(context.Background(), []models.TaskInput{spec}, models.TaskTypeCommand)
+		if err != nil {
+			t.Fatalf("Prepare() error = %v", err)
+		}
+		want := models.ResolvedInput{Name: "labels", SHA256: digest(content), Size: int64(len(content)), Path: "data/labels.csv"}
+		if len(set.Resolved) != 1 || set.Resolved[0] != want {
+			t.Fatalf("Resolved = %+v, want %+v", set.Resolved, want)
+		}
+		set.Close()
+	}
+	if gets.Load() != 1 {
+		t.Fatalf("downloaded %d times, want the cached copy reused", gets.Load())
+	}
+	if has, _ := m.Has(context.Background(), "sha256-"+digest(content)); !has {
+		t.Fatal("input missing from the cache")
+	}
+
+	spec.SHA256 = digest("something else")
+	if _, err := m.Prepare(context.Background(), []models.TaskInput{spec}, models.TaskTypeCommand); !errors.Is(err, ErrVerification) {
+		t.Fatalf("Prepare() error = %v for a hash mismatch, want ErrVerification", err)
+	}
+	spec.SHA256, spec.Size = "", 3
+	if _, err := m.Prepare(context.Background(), []models.TaskInput{spec}, models.TaskTypeCommand); !errors.Is(err, ErrVerification) {
+		t.Fatalf("Prepare() error = %v for a size mismatch, want ErrVerification", err)
+	}
+}
+
+func TestMissingInputIsUnavailable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := models.TaskInput{Name: "labels", Source: models.InputSource{URL: server.URL + "/labels.csv"}, TargetPath: "labels.csv"}
+	if _, err := m.Prepare(context.Background(), []models.TaskInput{spec}, models.TaskTypeCommand); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("Prepare() error = %v for a missing input, want ErrUnavailable", err)
+	}
+}
+
+func TestCIDInputsVerifiedThroughFetcher(t *testing.T) {
+	const content = "feature,label\n1,0\n"
+	cid, err := unixfs.FileCID(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := models.TaskInput{Name: "labels", Source: models.InputSource{CID: cid}, TargetPath: "labels.csv"}
+	scores, err := ipfsfetch.OpenScores("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	good, _ := newServer(t, content)
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetIPFSFetcher(ipfsfetch.New([]string{good.URL}, scores))
+	set, err := m.Prepare(context.Background(), []models.TaskInput{spec}, models.TaskTypeCommand)
+	if err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	set.Close()
+
+	// Content not hashing to the CID is rejected and never cached
+	bad, _ := newServer(t, "feature,label\n1,1\n")
+	m, err = NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetIPFSFetcher(ipfsfetch.New([]string{bad.URL}, scores))
+	if _, err := m.Prepare(context.Background(), []models.TaskInput{spec}, models.TaskTypeCommand); !errors.Is(err, ErrVerification) {
+		t.Fatalf("Prepare() error = %v for content of another CID, want ErrVerification", err)
+	}
+	if has, _ := m.Has(context.Background(), "cid-"+cid); has {
+		t.Fatal("content of another CID was cached")
+	}
+}
+
+// corrupt overwrites the read-only cached file at path
+func corrupt(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.Chmod(path, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o400); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCorruptedInputIsNotServed(t *testing.T) {
+	const content = "feature,label\n1,0\n"
+	server, gets := newServer(t, content)
+	dir := t.TempDir()
+	m, err := NewManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarantine := t.TempDir()
+	m.SetQuarantine(fsck.NewQuarantine(quarantine))
+
+	m.SetGateway(server.URL)
+
+	// Without a declared hash only the recorded checksum catches the damage
+	const cid = "bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	spec := models.TaskInput{Name: "labels", Source: models.InputSource{CID: cid}, TargetPath: "labels.csv"}
+	set, err := m.Prepare(context.Background(), []models.TaskInput{spec}, models.TaskTypeCommand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set.Close()
+	corrupt(t, filepath.Join(dir, "cid-"+cid), "feature,label\n1,1\n")
+
+	set, err = m.Prepare(context.Background(), []models.TaskInput{spec}, models.TaskTypeCommand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+	workdir := t.TempDir()
+	if err := set.Link(workdir); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(workdir, "labels.csv")); string(data) != content {
+		t.Fatalf("served %q, want the input fetched again", data)
+	}
+	if gets.Load() != 2 {
+		t.Fatalf("downloaded %d times, want the damaged copy replaced", gets.Load())
+	}
+	if moved, _ := os.ReadDir(filepath.Join(quarantine, caches.Inputs)); len(moved) != 1 {
+		t.Fatalf("quarantined %d files, want the damaged copy", len(moved))
+	}
+}
+
+func TestFsckRepairsIndex(t *testing.T) {
+	dir := t.TempDir()
+	m, err := NewManager(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const content = "weights"
+	selfNamed := "sha256-" + digest(content)
+	unverifiable := "cid-bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi"
+	for _, key := range []string{selfNamed, unverifiable} {
+		if err := os.WriteFile(filepath.Join(dir, key), []byte(content), 0o400); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.WriteFile(filepath.Join(dir, ".download.partial"), []byte("half"), 0o600)
+	os.WriteFile(filepath.Join(dir, caches.ChecksumIndexName), []byte(`{"cid-gone00000": {"sha256": "00", "size": 1}}`), 0o600)
+
+	findings, err := m.Fsck(fsck.NewQuarantine(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := make(map[string]fsck.Action)
+	for _, f := range findings {
+		actions[f.Item] = f.Action
+	}
+	want := map[string]fsck.Action{
+		".download.partial": fsck.ActionRemoved,
+		selfNamed:           fsck.ActionRepaired,
+		unverifiable:        fsck.ActionQuarantined,
+		"cid-gone00000":     fsck.ActionRepaired,
+	}
+	if !reflect.DeepEqual(actions, want) {
+		t.Fatalf("findings = %+v, want %v", findings, want)
+	}
+	if has, _ := m.Has(context.Background(), unverifiable); has {
+		t.Fatal("unverifiable input still cached")
+	}
+	if _, err := m.checksums.Verify(selfNamed); err != nil {
+		t.Fatalf("Verify() error = %v after adopting the entry", err)
+	}
+
+	// A second pass finds nothing left to do
+	if findings, err := m.Fsck(nil); err != nil || len(findings) != 0 {
+		t.Fatalf("second Fsck() = %+v, %v", findings, err)
+	}
+}
+
+func TestLinkIntoWorkdir(t *testing.T) {
+	const content = "weights"
+	server, _ := newServer(t, content)
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	specs := []models.TaskInput{
+		{Name: "weights", Source: models.InputSource{URL: server.URL + "/w"}, TargetPath: "model/weights.bin"},
+		{Name: "scratch", Source: models.InputSource{URL: server.URL + "/s"}, TargetPath: "scratch.bin", Mode: models.InputModeReadWrite},
+	}
+	set, err := m.Prepare(context.Background(), specs, models.TaskTypeCommand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	workdir := t.TempDir()
+	if err := set.Link(workdir); err != nil {
+		t.Fatalf("Link() error = %v", err)
+	}
+	for _, target := range []string{"model/weights.bin", "scratch.bin"} {
+		data, err := os.ReadFile(filepath.Join(workdir, target))
+		if err != nil || string(data) != content {
+			t.Fatalf("%s = %q, %v", target, data, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(workdir, "scratch.bin"), []byte("changed"), 0o644); err != nil {
+		t.Fatalf("read-write input is not writable: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(workdir, "model/weights.bin"), []byte("changed"), 0o644); err == nil && os.Geteuid() != 0 {
+		t.Fatal("read-only input is writable")
+	}
+
+	// A second set may not replace what is already there
+	again, err := m.Prepare(context.Background(), specs[:1], models.TaskTypeCommand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	if err := again.Link(workdir); err == nil || !strings.Contains(err.Error(), "already exists") {
+		t.Fatalf("Link() error = %v over an existing file, want a collision", err)
+	}
+
+	set.Close()
+	if _, err := os.Lstat(filepath.Join(workdir, "model/weights.bin")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("link left behind after Close(): %v", err)
+	}
+}
+
+func TestCheckSpace(t *testing.T) {
+	server, _ := newServer(t, strings.Repeat("x", 100))
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.freeDisk = func(string) (uint64, error) { return 150, nil }
+
+	declared := models.TaskInput{Name: "declared", Source: models.InputSource{URL: server.URL}, Size: 100, TargetPath: "/a"}
+	undeclared := models.TaskInput{Name: "undeclared", Source: models.InputSource{URL: server.URL}, TargetPath: "/b"}
+
+	if err := m.CheckSpace(context.Background(), []models.TaskInput{declared}); err != nil {
+		t.Fatalf("CheckSpace() error = %v for an input that fits", err)
+	}
+	if err := m.CheckSpace(context.Background(), []models.TaskInput{declared, undeclared}); !errors.Is(err, ErrInsufficientDisk) {
+		t.Fatalf("CheckSpace() error = %v, want ErrInsufficientDisk with the size looked up", err)
+	}
+	declared.Mode = models.InputModeReadWrite
+	if err := m.CheckSpace(context.Background(), []models.TaskInput{declared}); !errors.Is(err, ErrInsufficientDisk) {
+		t.Fatalf("CheckSpace() error = %v, want the writable copy counted", err)
+	}
+}
+
+func TestObjectStoreInputs(t *testing.T) {
+	const content = "feature,label\n1,0\n"
+	creds := objectstore.Credentials{AccessKeyID: "runner", SecretAccessKey: "secret"}
+	server := objectstoretest.NewServer(t, "us-east-1", creds)
+	server.PutObject("datasets", "train.csv", []byte(content), http.Header{"X-Amz-Meta-Sha256": {digest(content)}})
+	server.PutObject("datasets", "tampered.csv", []byte("feature,label\n9,9\n"), http.Header{"X-Amz-Meta-Sha256": {digest(content)}})
+	server.PutObject("private", "train.csv", []byte(content), nil)
+
+	m, err := NewManager(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := func(uri string) []models.TaskInput {
+		return []models.TaskInput{{Name: "train", Source: models.InputSource{URL: uri}, TargetPath: "data/train.csv"}}
+	}
+	if _, err := m.Prepare(context.Background(), spec("s3://datasets/train.csv"), models.TaskTypeCommand); !errors.Is(err, objectstore.ErrBucketNotAllowed) {
+		t.Fatalf("Prepare() error = %v without object storage configured, want ErrBucketNotAllowed", err)
+	}
+
+	store, err := objectstore.New(objectstore.Config{
+		Endpoint:    server.URL,
+		Region:      "us-east-1",
+		PathStyle:   true,
+		Credentials: creds,
+		Buckets:     []string{"datasets"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetObjectStore(store)
+
+	set, err := m.Prepare(context.Background(), spec("s3://datasets/train.csv"), models.TaskTypeCommand)
+	if err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	if len(set.Resolved) != 1 || set.Resolved[0].SHA256 != digest(content) {
+		t.Fatalf("Resolved = %+v", set.Resolved)
+	}
+	set.Close()
+	if size := m.Required(context.Background(), spec("s3://datasets/train.csv")); size != int64(len(content)) {
+		t.Fatalf("Required() = %d, want the object's size counted", size)
+	}
+
+	if _, err := m.Prepare(context.Background(), spec("s3://datasets/tampered.csv"), models.TaskTypeCommand); !errors.Is(err, objectstore.ErrIntegrity) {
+		t.Fatalf("Prepare() error = %v for content not matching its metadata, want ErrIntegrity", err)
+	}
+
+	requests := len(server.Requests)
+	for _, uri := range []string{"s3://private/train.csv", "s3://AKIA:secret@datasets/train.csv", "s3://datasets/train.csv?X-Amz-Signature=x"} {
+		if _, err := m.Prepare(context.Background(), spec(uri), models.TaskTypeCommand); !errors.Is(err, objectstore.ErrBucketNotAllowed) && !errors.Is(err, objectstore.ErrInvalidURI) {
+			t.Errorf("Prepare(%s) error = %v, want the input rejected", uri, err)
+		}
+	}
+	if len(server.Requests) != requests {
+		t.Fatalf("rejected inputs sent requests %v", server.Requests[requests:])
+	}
+}
@@ -0,0 +1,552 @@
+package inputs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+	"github.com/theblitlabs/parity-runner/internal/fsck"
+	"github.com/theblitlabs/parity-runner/internal/health"
+	"github.com/theblitlabs/parity-runner/internal/ipfsfetch"
+	"github.com/theblitlabs/parity-runner/internal/objectstore"
+	"github.com/theblitlabs/parity-runner/internal/timeline"
+	"github.com/theblitlabs/parity-runner/internal/unixfs"
+)
+
+const (
+	// DefaultIPFSGateway is where inputs given by CID are fetched from
+	DefaultIPFSGateway = "https://ipfs.io/ipfs/"
+	// headTimeout bounds the size lookup of an input that does not
+	// declare its size
+	headTimeout = 10 * time.Second
+)
+
+// cacheKeyPattern matches the names of cached inputs. Inputs are cached by
+// their declared hash or, without one, by a CID safe to use as a file name;
+// other inputs are downloaded for each task.
+var (
+	cacheKeyPattern = regexp.MustCompile(`^(sha256-[0-9a-f]{64}|cid-[A-Za-z0-9]{8,128})$`)
+	cacheableCID    = regexp.MustCompile(`^[A-Za-z0-9]{8,128}$`)
+)
+
+// Manager downloads task inputs into a content-addressed cache on disk.
+// Cached files are read-only so that tasks given a link to one cannot
+// change what later tasks receive, and checked against the checksum
+// recorded when they were stored before every use.
+type Manager struct {
+	dir       string
+	checksums *caches.Checksums
+	client    *http.Client
+	gateway   string
+	freeDisk  func(path string) (uint64, error)
+	// store reads s3:// inputs with the runner's own credentials
+	store *objectstore.Client
+	// artifacts fetches inputs that name another task's artifact
+	artifacts ArtifactSource
+	// localArtifacts holds the artifacts of tasks this runner ran, served
+	// ahead of the server's copies
+	localArtifacts string
+	// ipfs fetches inputs given by CID in place of the gateway
+	ipfs *ipfsfetch.Fetcher
+
+	// fetching serializes fetches of each cache entry, so that inputs
+	// shared by tasks running at once are downloaded once
+	mu       sync.Mutex
+	fetching map[string]*fetchLock
+}
+
+type fetchLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// NewManager keeps downloaded inputs in dir, creating it when missing
+func NewManager(dir string) (*Manager, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create input cache directory: %w", err)
+	}
+	return &Manager{
+		dir:       dir,
+		checksums: caches.OpenChecksums(dir, caches.Inputs),
+		client:    dualstack.Client(0),
+		gateway:   DefaultIPFSGateway,
+		freeDisk:  health.FreeDiskBytes,
+		fetching:  make(map[string]*fetchLock),
+	}, nil
+}
+
+// SetQuarantine moves cached inputs that fail verification into q instead
+// of deleting them
+func (m *Manager) SetQuarantine(q *fsck.Quarantine) {
+	m.checksums.SetQuarantine(q)
+}
+
+// SetGateway replaces the IPFS gateway inputs given by CID are fetched from
+func (m *Manager) SetGateway(gateway string) {
+	m.gateway = gateway
+}
+
+// SetIPFSFetcher fetches inputs given by CID through f, verified against
+// their CID, instead of from the gateway
+func (m *Manager) SetIPFSFetcher(f *ipfsfetch.Fetcher) {
+	m.ipfs = f
+}
+
+// SetHTTPClient replaces the client inputs are downloaded with
+func (m *Manager) SetHTTPClient(client *http.Client) {
+	m.client = client
+}
+
+// SetObjectStore reads inputs given by s3:// URI from store. Without one
+// such inputs fail.
+func (m *Manager) SetObjectStore(store *objectstore.Client) {
+	m.store = store
+}
+
+// SetArtifactSource fetches inputs that name an artifact of another task
+// from source. Without one such inputs fail.
+func (m *Manager) SetArtifactSource(source ArtifactSource) {
+	m.artifacts = source
+}
+
+// lock holds off other fetches of the cache entry key until the returned
+// function is called
+func (m *Manager) lock(key string) func() {
+	m.mu.Lock()
+	l, ok := m.fetching[key]
+	if !ok {
+		l = &fetchLock{}
+		m.fetching[key] = l
+	}
+	l.waiters++
+	m.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.mu.Lock()
+		if l.waiters--; l.waiters == 0 {
+			delete(m.fetching, key)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// cacheKey names the cache entry of spec, or "" when it is not cached
+func cacheKey(spec *models.TaskInput) string {
+	switch {
+	case spec.SHA256 != "":
+		return "sha256-" + spec.SHA256
+	case spec.Source.CID != "" && cacheableCID.MatchString(spec.Source.CID):
+		return "cid-" + spec.Source.CID
+	}
+	return ""
+}
+
+func (m *Manager) path(key string) string {
+	return filepath.Join(m.dir, key)
+}
+
+func (m *Manager) url(spec *models.TaskInput) string {
+	if spec.Source.CID != "" {
+		return strings.TrimSuffix(m.gateway, "/") + "/" + spec.Source.CID
+	}
+	return spec.Source.URL
+}
+
+// cached returns the path of spec's cache entry if it is on disk
+func (m *Manager) cached(spec *models.TaskInput) (string, bool) {
+	key := cacheKey(spec)
+	if key == "" {
+		return "", false
+	}
+	path := m.path(key)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+// fetch returns a file holding spec's content, with its hash and size. It
+// comes from the cache when present and intact; otherwise it is downloaded
+// into the cache, or into scratch when spec is not cacheable. Artifacts of
+// other tasks are cached by the hash the server describes them with.
+func (m *Manager) fetch(ctx context.Context, spec *models.TaskInput, scratch string) (string, string, int64, error) {
+	if spec.Source.Task != nil {
+		resolved, err := m.resolveArtifact(ctx, spec)
+		if err != nil {
+			return "", "", 0, err
+		}
+		spec = resolved
+	}
+	key := cacheKey(spec)
+	if key != "" {
+		unlock := m.lock(key)
+		defer unlock()
+	}
+	if path, ok := m.cached(spec); ok {
+		sum, err := m.verifyCached(spec, key)
+		if err == nil {
+			now := time.Now()
+			_ = os.Chtimes(path, now, now)
+			return path, sum.SHA256, sum.Size, nil
+		}
+		// A damaged entry is never served; a fresh download replaces it
+		log := gologger.WithComponent("inputs")
+		log.Warn().Err(err).Str("input", spec.Name).Msg("Discarding cached input that failed verification")
+		if err := m.checksums.Discard(key); err != nil {
+			return "", "", 0, fmt.Errorf("failed to discard cached input %s: %w", spec.Name, err)
+		}
+	}
+
+	dir, dest := scratch, filepath.Join(scratch, spec.Name)
+	if key != "" {
+		dir, dest = m.dir, m.path(key)
+	}
+	if spec.Source.Task != nil {
+		if digest, size, ok := m.fetchLocal(ctx, spec, key, dir, dest); ok {
+			return dest, digest, size, nil
+		}
+	}
+	digest, size, err := m.download(ctx, spec, key, dir, dest)
+	if err != nil {
+		if spec.Source.Task != nil && errors.Is(err, ErrVerification) {
+			err = dependencyError(spec, err)
+		}
+		return "", "", 0, err
+	}
+	return dest, digest, size, nil
+}
+
+// Fetch returns the path of spec's content, verified as Prepare verifies
+// inputs. Cacheable inputs are kept in the cache, read-only; others are
+// downloaded into scratch, which the caller removes.
+func (m *Manager) Fetch(ctx context.Context, spec *models.TaskInput, scratch string) (string, error) {
+	path, _, _, err := m.fetch(ctx, spec, scratch)
+	return path, err
+}
+
+// verifyCached checks the cache entry key of spec against the checksum
+// recorded when it was stored, and against what spec declares
+func (m *Manager) verifyCached(spec *models.TaskInput, key string) (caches.Checksum, error) {
+	sum, err := m.checksums.Verify(key)
+	if errors.Is(err, caches.ErrCorrupt) && selfVerifying(key, sum) {
+		// An entry named by its own hash needs no record to be trusted
+		err = m.checksums.Record(key, sum)
+	}
+	if err != nil {
+		return sum, err
+	}
+	return sum, verify(spec, sum.SHA256, sum.Size)
+}
+
+// selfVerifying reports whether key names the content sum describes
+func selfVerifying(key string, sum caches.Checksum) bool {
+	return key == "sha256-"+sum.SHA256
+}
+
+// download writes spec's content from its source to dest
+func (m *Manager) download(ctx context.Context, spec *models.TaskInput, key, dir, dest string) (string, int64, error) {
+	content, err := m.open(ctx, spec)
+	if err != nil {
+		return "", 0, err
+	}
+	defer content.Close()
+	return m.write(ctx, spec, content, key, dir, dest)
+}
+
+// write copies content to dest through a temporary file in dir, which is
+// only renamed into place once verified against spec. A cached input, under
+// key, has its checksum recorded ahead of the rename.
+func (m *Manager) write(ctx context.Context, spec *models.TaskInput, content io.Reader, key, dir, dest string) (string, int64, error) {
+	tmp, err := os.CreateTemp(dir, "."+spec.Name+".*.partial")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create input file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	body := content
+	if spec.Size > 0 {
+		// One byte past the declared size is enough to reject the input
+		body = io.LimitReader(body, spec.Size+1)
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	timeline.From(ctx).AddBytes(size)
+	if errors.Is(err, unixfs.ErrMismatch) || errors.Is(err, ipfsfetch.ErrInvalidBlock) {
+		tmp.Close()
+		return "", 0, fmt.Errorf("%w: input %s: %w", ErrVerification, spec.Name, err)
+	}
+	if err != nil {
+		tmp.Close()
+		return "", 0, fmt.Errorf("failed to download input %s: %w", spec.Name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, fmt.Errorf("failed to write input %s: %w", spec.Name, err)
+	}
+
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if err := verify(spec, digest, size); err != nil {
+		return "", 0, err
+	}
+	if err := os.Chmod(tmp.Name(), 0o444); err != nil {
+		return "", 0, fmt.Errorf("failed to protect input %s: %w", spec.Name, err)
+	}
+	if key != "" {
+		if err := m.checksums.Record(key, caches.Checksum{SHA256: digest, Size: size}); err != nil {
+			return "", 0, fmt.Errorf("failed to index input %s: %w", spec.Name, err)
+		}
+	}
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		return "", 0, fmt.Errorf("failed to store input %s: %w", spec.Name, err)
+	}
+	return digest, size, nil
+}
+
+// open starts reading spec's content from its source
+func (m *Manager) open(ctx context.Context, spec *models.TaskInput) (io.ReadCloser, error) {
+	if ref := spec.Source.Task; ref != nil {
+		if m.artifacts == nil {
+			return nil, fmt.Errorf("input %s: task artifacts are not enabled on this runner", spec.Name)
+		}
+		body, err := m.artifacts.OpenArtifact(ctx, ref.TaskID, ref.Artifact)
+		if err != nil {
+			return nil, dependencyError(spec, err)
+		}
+		return body, nil
+	}
+	if objectstore.IsURI(spec.Source.URL) {
+		bucket, key, err := m.object(spec)
+		if err != nil {
+			return nil, err
+		}
+		body, _, err := m.store.Get(ctx, bucket, key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download input %s: %w", spec.Name, err)
+		}
+		return body, nil
+	}
+	if spec.Source.CID != "" && m.ipfs != nil {
+		body, err := m.ipfs.Open(ctx, spec.Source.CID)
+		if errors.Is(err, ipfsfetch.ErrUnavailable) {
+			return nil, fmt.Errorf("failed to download input %s: %w: %w", spec.Name, ErrUnavailable, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to download input %s: %w", spec.Name, err)
+		}
+		return body, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.url(spec), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for input %s: %w", spec.Name, err)
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download input %s: %w", spec.Name, err)
+	}
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download input %s: %w: status %d", spec.Name, ErrUnavailable, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download input %s: status %d", spec.Name, resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+// object locates spec's s3:// source in a bucket the runner holds
+// credentials for
+func (m *Manager) object(spec *models.TaskInput) (string, string, error) {
+	bucket, key, err := objectstore.ParseURI(spec.Source.URL)
+	if err != nil {
+		return "", "", fmt.Errorf("input %s: %w", spec.Name, err)
+	}
+	if m.store == nil {
+		return "", "", fmt.Errorf("input %s: %w: the runner has no object storage configured", spec.Name, objectstore.ErrBucketNotAllowed)
+	}
+	if !m.store.Allowed(bucket) {
+		return "", "", fmt.Errorf("input %s: %w: %s", spec.Name, objectstore.ErrBucketNotAllowed, bucket)
+	}
+	return bucket, key, nil
+}
+
+func verify(spec *models.TaskInput, digest string, size int64) error {
+	if spec.SHA256 != "" && digest != spec.SHA256 {
+		return fmt.Errorf("%w: input %s has sha256 %s, want %s", ErrVerification, spec.Name, digest, spec.SHA256)
+	}
+	if spec.Size > 0 && size != spec.Size {
+		return fmt.Errorf("%w: input %s is %d bytes, want %d", ErrVerification, spec.Name, size, spec.Size)
+	}
+	return nil
+}
+
+// Required estimates the disk space specs still need: the size of each
+// input not yet cached, plus the private copy of each read-write input.
+// Sizes not declared are looked up with a HEAD request, or from the server
+// for artifacts of other tasks; inputs whose size cannot be learned count
+// as empty.
+func (m *Manager) Required(ctx context.Context, specs []models.TaskInput) int64 {
+	var total int64
+	for i := range specs {
+		spec := &specs[i]
+		if spec.Source.Task != nil {
+			if resolved, err := m.resolveArtifact(ctx, spec); err == nil {
+				spec = resolved
+			}
+		}
+		size := spec.Size
+		if size == 0 {
+			size = m.contentLength(ctx, spec)
+		}
+		if _, ok := m.cached(spec); !ok {
+			total += size
+		}
+		if !spec.ReadOnly() {
+			total += size
+		}
+	}
+	return total
+}
+
+func (m *Manager) contentLength(ctx context.Context, spec *models.TaskInput) int64 {
+	if path, ok := m.cached(spec); ok {
+		if info, err := os.Stat(path); err == nil {
+			return info.Size()
+		}
+	}
+	if spec.Source.Task != nil {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, headTimeout)
+	defer cancel()
+	if objectstore.IsURI(spec.Source.URL) {
+		bucket, key, err := m.object(spec)
+		if err != nil {
+			return 0
+		}
+		obj, err := m.store.Head(ctx, bucket, key)
+		if err != nil || obj.Size < 0 {
+			return 0
+		}
+		return obj.Size
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, m.url(spec), nil)
+	if err != nil {
+		return 0
+	}
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.ContentLength < 0 {
+		return 0
+	}
+	return resp.ContentLength
+}
+
+// CheckSpace returns ErrInsufficientDisk when specs need more space than is
+// free on the cache's filesystem. A filesystem that cannot be measured
+// passes.
+func (m *Manager) CheckSpace(ctx context.Context, specs []models.TaskInput) error {
+	need := m.Required(ctx, specs)
+	if need == 0 {
+		return nil
+	}
+	free, err := m.freeDisk(m.dir)
+	if err != nil {
+		return nil
+	}
+	if uint64(need) > free {
+		return fmt.Errorf("%w: %d bytes needed, %d free", ErrInsufficientDisk, need, free)
+	}
+	return nil
+}
+
+// Entries lists the cached inputs, using modification time as last use
+func (m *Manager) Entries(ctx context.Context) ([]caches.Entry, error) {
+	dirEntries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input cache: %w", err)
+	}
+
+	var entries []caches.Entry
+	for _, de := range dirEntries {
+		if !de.Type().IsRegular() || !cacheKeyPattern.MatchString(de.Name()) {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, caches.Entry{
+			Key:      de.Name(),
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		})
+	}
+	return entries, nil
+}
+
+func (m *Manager) Has(ctx context.Context, key string) (bool, error) {
+	if !cacheKeyPattern.MatchString(key) {
+		return false, nil
+	}
+	_, err := os.Stat(m.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (m *Manager) Remove(ctx context.Context, key string) error {
+	if !cacheKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid input cache key %q", key)
+	}
+	if err := os.Remove(m.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove cached input: %w", err)
+	}
+	return m.checksums.Forget(key)
+}
+
+// Fsck removes partial downloads and cross-checks the cache against its
+// index. Entries named by their SHA-256 that the index lost are indexed
+// again; other entries it does not cover cannot be verified and are
+// quarantined like damaged ones.
+func (m *Manager) Fsck(q *fsck.Quarantine) ([]fsck.Finding, error) {
+	findings, err := fsck.RemoveLeftovers(caches.Inputs, m.dir, ".*.partial")
+	if err != nil {
+		return findings, err
+	}
+	found, err := m.checksums.Check(q, cacheKeyPattern.MatchString, selfVerifying)
+	return append(findings, found...), err
+}
+
+// CacheKeys names the cache entries specs use, for protecting them while a
+// task runs
+func CacheKeys(specs []models.TaskInput) []string {
+	var keys []string
+	for i := range specs {
+		if key := cacheKey(&specs[i]); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,128 @@
+package inputs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// Staged is a fetched input ready to be given to a task
+type Staged struct {
+	Input models.TaskInput
+	// HostPath holds the content: the cached file for read-only inputs, a
+	// private copy for read-write ones
+	HostPath string
+	// Target is the cleaned target path
+	Target string
+}
+
+// Set holds the inputs of one task until Close
+type Set struct {
+	Staged   []Staged
+	Resolved []models.ResolvedInput
+	scratch  string
+	links    []string
+}
+
+// Prepare validates specs for a task of taskType and fetches them. Inputs
+// not held in the cache, and private copies of read-write inputs, live in a
+// scratch directory removed by Close.
+func (m *Manager) Prepare(ctx context.Context, specs []models.TaskInput, taskType models.TaskType) (*Set, error) {
+	if err := Validate(specs, taskType); err != nil {
+		return nil, err
+	}
+	scratch, err := os.MkdirTemp(m.dir, ".staging-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create input staging directory: %w", err)
+	}
+	set := &Set{scratch: scratch}
+
+	for i := range specs {
+		spec := specs[i]
+		target, _ := TargetPath(&spec, taskType)
+		path, digest, size, err := m.fetch(ctx, &spec, scratch)
+		if err == nil && !spec.ReadOnly() {
+			path, err = copyWritable(path, filepath.Join(scratch, "rw-"+spec.Name))
+		}
+		if err != nil {
+			set.Close()
+			return nil, err
+		}
+		set.Staged = append(set.Staged, Staged{Input: spec, HostPath: path, Target: target})
+		set.Resolved = append(set.Resolved, models.ResolvedInput{Name: spec.Name, SHA256: digest, Size: size, Path: target})
+	}
+	return set, nil
+}
+
+func copyWritable(src, dest string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", fmt.Errorf("failed to open input: %w", err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("failed to create writable input copy: %w", err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return "", fmt.Errorf("failed to copy input: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return "", fmt.Errorf("failed to copy input: %w", err)
+	}
+	return dest, nil
+}
+
+// Size is the bytes of the set's inputs
+func (s *Set) Size() int64 {
+	var size int64
+	for _, resolved := range s.Resolved {
+		size += resolved.Size
+	}
+	return size
+}
+
+// Link places the inputs in workdir as symbolic links at their target
+// paths, creating parent directories as needed. A target that already
+// exists is a collision, since replacing it could destroy the operator's
+// files.
+func (s *Set) Link(workdir string) error {
+	for _, staged := range s.Staged {
+		link := filepath.Join(workdir, filepath.FromSlash(staged.Target))
+		if _, err := os.Lstat(link); err == nil {
+			return fmt.Errorf("input %s: %s already exists in the working directory", staged.Input.Name, staged.Target)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("input %s: %w", staged.Input.Name, err)
+		}
+		if err := os.MkdirAll(filepath.Dir(link), 0o755); err != nil {
+			return fmt.Errorf("failed to create directory for input %s: %w", staged.Input.Name, err)
+		}
+		if err := os.Symlink(staged.HostPath, link); err != nil {
+			return fmt.Errorf("failed to link input %s: %w", staged.Input.Name, err)
+		}
+		s.links = append(s.links, link)
+	}
+	return nil
+}
+
+// Unlink removes the links Link created, so that they are not mistaken for
+// files the task produced
+func (s *Set) Unlink() {
+	for _, link := range s.links {
+		_ = os.Remove(link)
+	}
+	s.links = nil
+}
+
+// Close removes the set's links and scratch files. Cached inputs stay in
+// the cache.
+func (s *Set) Close() error {
+	s.Unlink()
+	return os.RemoveAll(s.scratch)
+}
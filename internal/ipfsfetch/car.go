@@ -0,0 +1,79 @@
+package ipfsfetch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/theblitlabs/parity-runner/internal/unixfs"
+)
+
+const (
+	// maxCARHeader bounds the header of a CAR response, which names its
+	// roots
+	maxCARHeader = 64 << 10
+	// maxBlock bounds a block of a CAR response. UnixFS leaves are 256 KiB,
+	// and gateways refuse blocks over 2 MiB.
+	maxBlock = 2<<20 + 1<<10
+)
+
+// carReader reads the blocks of a CAR v1 stream as it arrives
+type carReader struct {
+	r *bufio.Reader
+}
+
+// newCARReader reads past the header of the CAR stream r
+func newCARReader(r io.Reader) (*carReader, error) {
+	c := &carReader{r: bufio.NewReader(r)}
+	header, err := c.section(maxCARHeader)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("invalid CAR header: %w", err)
+	}
+	// The header is a dag-cbor map holding the version, 1, and the roots,
+	// which the blocks themselves are checked against instead
+	if len(header) == 0 || header[0]&0xe0 != 0xa0 {
+		return nil, errors.New("invalid CAR header: not a map")
+	}
+	return c, nil
+}
+
+// next returns the next block with the CID it is sent under, unverified,
+// and io.EOF after the last
+func (c *carReader) next() (unixfs.CID, []byte, error) {
+	section, err := c.section(maxBlock)
+	if err != nil {
+		return "", nil, err
+	}
+	cid, n, err := unixfs.Cast(section)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid CAR block: %w", err)
+	}
+	return cid, section[n:], nil
+}
+
+// section reads a varint-prefixed section of at most limit bytes
+func (c *carReader) section(limit uint64) ([]byte, error) {
+	length, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		}
+		return nil, fmt.Errorf("failed to read CAR section: %w", err)
+	}
+	if length == 0 || length > limit {
+		return nil, fmt.Errorf("CAR section of %d bytes", length)
+	}
+	section := make([]byte, length)
+	if _, err := io.ReadFull(c.r, section); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("failed to read CAR section: %w", err)
+	}
+	return section, nil
+}
@@ -0,0 +1,625 @@
+// Package ipfsfetch fetches files from IPFS gateways without trusting them.
+// Gateways serving verifiable CAR responses have each block checked against
+// its CID as it streams, and large files are split into segments fetched
+// from several gateways at once, each segment going to the best-scoring
+// gateway free to take it. Gateways without CAR support serve the whole
+// file, which is hashed to its CID once read.
+package ipfsfetch
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+	"github.com/theblitlabs/parity-runner/internal/unixfs"
+)
+
+const (
+	// DefaultSegmentSize is how much of a file one gateway is asked for at
+	// a time
+	DefaultSegmentSize = 4 << 20
+	// DefaultParallel is how many segments are fetched at once
+	DefaultParallel = 4
+	// carAccept asks for the blocks of a response in the order a file's
+	// tree is walked, so they can be verified as they arrive
+	carAccept = "application/vnd.ipld.car; version=1; order=dfs; dups=y"
+	carType   = "application/vnd.ipld.car"
+	// demoteRatio is how far below the best gateway of a fetch one may
+	// rank and still be given segments
+	demoteRatio = 0.1
+	// probeTimeout bounds the wait for a gateway's root block, so that one
+	// gateway hanging does not hold up the fetch
+	probeTimeout = 15 * time.Second
+	// headerTimeout bounds the wait for a gateway to start answering
+	headerTimeout = 30 * time.Second
+	// segmentTimeout bounds fetching one segment, so that a gateway
+	// stalling partway through is given up on for another
+	segmentTimeout = 2 * time.Minute
+)
+
+// ErrUnavailable is returned when no gateway serves a CID
+var ErrUnavailable = errors.New("no gateway serves the content")
+
+// Fetcher fetches files by CID from a set of gateways
+type Fetcher struct {
+	gateways    []string
+	scores      *Scores
+	client      *http.Client
+	segmentSize uint64
+	parallel    int
+}
+
+// New fetches from gateways, ranking them with scores
+func New(gateways []string, scores *Scores) *Fetcher {
+	f := &Fetcher{
+		scores:      scores,
+		client:      dualstack.Client(0),
+		segmentSize: DefaultSegmentSize,
+		parallel:    DefaultParallel,
+	}
+	for _, gateway := range gateways {
+		if gateway = strings.TrimSpace(gateway); gateway != "" {
+			f.gateways = append(f.gateways, strings.TrimSuffix(gateway, "/"))
+		}
+	}
+	return f
+}
+
+// SetHTTPClient replaces the client gateways are requested with
+func (f *Fetcher) SetHTTPClient(client *http.Client) {
+	f.client = client
+}
+
+// SetSegmentSize replaces how much of a file one gateway is asked for at a
+// time. Segments are rounded up to whole chunks of the file.
+func (f *Fetcher) SetSegmentSize(size int64) {
+	if size > 0 {
+		f.segmentSize = uint64(size)
+	}
+}
+
+// SetParallel replaces how many segments are fetched at once
+func (f *Fetcher) SetParallel(n int) {
+	if n > 0 {
+		f.parallel = n
+	}
+}
+
+// Open starts reading the file cid names. Content read from it has been
+// verified against cid, except from a gateway without CAR support, whose
+// content is only checked once read to the end: the reader then fails with
+// an error wrapping unixfs.ErrMismatch instead of returning io.EOF.
+func (f *Fetcher) Open(ctx context.Context, cid string) (io.ReadCloser, error) {
+	root, err := unixfs.Parse(cid)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.gateways) == 0 {
+		return nil, errors.New("no IPFS gateways configured")
+	}
+	rootBlock, car, plain := f.probe(ctx, cid, root)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if len(car) == 0 {
+		return f.openPlain(ctx, cid, root, plain)
+	}
+
+	switch root.Codec() {
+	case unixfs.CodecRaw:
+		f.save()
+		return io.NopCloser(bytes.NewReader(rootBlock)), nil
+	case unixfs.CodecDagPB:
+	default:
+		return nil, fmt.Errorf("%s is not a file", cid)
+	}
+	node, err := unixfs.DecodeNode(rootBlock)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a file: %w", cid, err)
+	}
+	data, err := unixfs.DecodeFileData(node.Data)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a file: %w", cid, err)
+	}
+	if data.Type != unixfs.TypeFile && data.Type != unixfs.TypeRaw {
+		return nil, fmt.Errorf("%s is not a file", cid)
+	}
+	size := uint64(len(data.Data))
+	for _, blockSize := range data.BlockSizes {
+		size += blockSize
+	}
+	return f.openSegments(ctx, cid, root, size, car), nil
+}
+
+// probe asks every gateway for the root block of cid as CAR, returning the
+// root block and the gateways that served it verified, and the gateways
+// that answered with something other than CAR
+func (f *Fetcher) probe(ctx context.Context, cid string, root unixfs.CID) ([]byte, []string, []string) {
+	type answer struct {
+		gateway string
+		block   []byte
+		plain   bool
+	}
+	answers := make(chan answer, len(f.gateways))
+	log := gologger.WithComponent("ipfsfetch")
+	for _, gateway := range f.gateways {
+		go func() {
+			block, plain, err := f.probeGateway(ctx, gateway, cid, root)
+			if err != nil && ctx.Err() == nil {
+				log.Debug().Err(err).Str("gateway", gateway).Str("cid", cid).Msg("Gateway failed to serve root block")
+				f.scores.failed(gateway)
+			}
+			answers <- answer{gateway: gateway, block: block, plain: plain}
+		}()
+	}
+
+	var rootBlock []byte
+	var car, plain []string
+	for range f.gateways {
+		a := <-answers
+		switch {
+		case a.block != nil:
+			rootBlock = a.block
+			car = append(car, a.gateway)
+		case a.plain:
+			plain = append(plain, a.gateway)
+		}
+	}
+	return rootBlock, car, plain
+}
+
+// probeGateway fetches the root block from gateway, setting plain when the
+// gateway does not answer with CAR
+func (f *Fetcher) probeGateway(ctx context.Context, gateway, cid string, root unixfs.CID) ([]byte, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	resp, err := f.get(ctx, gateway, cid, "dag-scope=block")
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+	if !isCAR(resp) {
+		return nil, true, nil
+	}
+	car, err := newCARReader(resp.Body)
+	if err != nil {
+		return nil, false, err
+	}
+	got, block, err := car.next()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read root block: %w", err)
+	}
+	if got != root {
+		return nil, false, fmt.Errorf("%w: got block %s, want %s", ErrInvalidBlock, got, root)
+	}
+	if err := root.Verify(block); err != nil {
+		return nil, false, fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+	}
+	return block, false, nil
+}
+
+// get requests cid from gateway as CAR with the given query, failing
+// unless the gateway answers 200 within headerTimeout
+func (f *Fetcher) get(ctx context.Context, gateway, cid, query string) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	timer := time.AfterFunc(headerTimeout, cancel)
+	url := gateway + "/" + cid
+	if query != "" {
+		url += "?format=car&" + query
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		timer.Stop()
+		cancel()
+		return nil, fmt.Errorf("failed to create gateway request: %w", err)
+	}
+	if query != "" {
+		req.Header.Set("Accept", carAccept)
+	}
+	resp, err := f.client.Do(req)
+	if !timer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, fmt.Errorf("failed to fetch %s: gateway did not answer within %s", cid, headerTimeout)
+	}
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to fetch %s: %w", cid, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("failed to fetch %s: status code %d", cid, resp.StatusCode)
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a response's context once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+func isCAR(resp *http.Response) bool {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return err == nil && mediaType == carType
+}
+
+func (f *Fetcher) save() {
+	if err := f.scores.Save(); err != nil {
+		log := gologger.WithComponent("ipfsfetch")
+		log.Warn().Err(err).Msg("Failed to save gateway scores")
+	}
+}
+
+// segmentResult is a segment of a file fetched, or why it could not be
+type segmentResult struct {
+	index int
+	data  []byte
+	err   error
+}
+
+// openSegments fetches the size bytes of the file under root in segments
+// from the CAR gateways, writing them to the returned reader in order
+func (f *Fetcher) openSegments(ctx context.Context, cid string, root unixfs.CID, size uint64, gateways []string) io.ReadCloser {
+	// Segments end on chunk boundaries, so no leaf is fetched twice
+	segmentSize := (f.segmentSize + unixfs.ChunkSize - 1) / unixfs.ChunkSize * unixfs.ChunkSize
+	count := int((size + segmentSize - 1) / segmentSize)
+	parallel := min(f.parallel, count)
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	pool := newPool(gateways, f.scores)
+	todo := make(chan int)
+	// window bounds how far fetched segments may run ahead of the reader
+	window := make(chan struct{}, 2*parallel)
+	results := make(chan segmentResult)
+
+	go func() {
+		defer close(todo)
+		for i := 0; i < count; i++ {
+			select {
+			case window <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case todo <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	for w := 0; w < parallel; w++ {
+		go func() {
+			for i := range todo {
+				from := uint64(i) * segmentSize
+				data, err := f.fetchSegment(ctx, pool, cid, root, from, min(from+segmentSize, size))
+				select {
+				case results <- segmentResult{index: i, data: data, err: err}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		defer cancel()
+		fetched := make(map[int][]byte)
+		for next := 0; next < count; {
+			var r segmentResult
+			select {
+			case r = <-results:
+			case <-ctx.Done():
+				pw.CloseWithError(ctx.Err())
+				return
+			}
+			if r.err != nil {
+				f.save()
+				pw.CloseWithError(r.err)
+				return
+			}
+			fetched[r.index] = r.data
+			for data, ok := fetched[next]; ok; data, ok = fetched[next] {
+				delete(fetched, next)
+				if _, err := pw.Write(data); err != nil {
+					f.save()
+					return
+				}
+				next++
+				<-window
+			}
+		}
+		f.save()
+		pw.Close()
+	}()
+	return &segmentReader{PipeReader: pr, cancel: cancel}
+}
+
+// fetchSegment fetches bytes [from, to) of the file, from the best gateway
+// free to take them and then from others as gateways fail
+func (f *Fetcher) fetchSegment(ctx context.Context, pool *pool, cid string, root unixfs.CID, from, to uint64) ([]byte, error) {
+	log := gologger.WithComponent("ipfsfetch")
+	for {
+		gateway, err := pool.acquire(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch bytes %d-%d of %s: %w", from, to, cid, err)
+		}
+		start := time.Now()
+		data, err := f.fetchRangeWithin(ctx, gateway, cid, root, from, to)
+		if err == nil {
+			f.scores.succeeded(gateway, int64(len(data)), time.Since(start))
+			pool.release(gateway, false)
+			return data, nil
+		}
+		if ctx.Err() != nil {
+			pool.release(gateway, false)
+			return nil, ctx.Err()
+		}
+		// A gateway that failed once is not trusted with the rest of the
+		// file
+		log.Warn().Err(err).
+			Str("gateway", gateway).
+			Str("cid", cid).
+			Uint64("from", from).
+			Uint64("to", to).
+			Msg("Gateway failed to serve segment - fetching it from another")
+		f.scores.failed(gateway)
+		pool.release(gateway, true)
+	}
+}
+
+// fetchRangeWithin fetches bytes [from, to) as fetchRange does, giving up
+// after segmentTimeout
+func (f *Fetcher) fetchRangeWithin(ctx context.Context, gateway, cid string, root unixfs.CID, from, to uint64) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, segmentTimeout)
+	defer cancel()
+	return f.fetchRange(ctx, gateway, cid, root, from, to)
+}
+
+// fetchRange fetches bytes [from, to) of the file from gateway as CAR,
+// verifying each block as it arrives
+func (f *Fetcher) fetchRange(ctx context.Context, gateway, cid string, root unixfs.CID, from, to uint64) ([]byte, error) {
+	resp, err := f.get(ctx, gateway, cid, fmt.Sprintf("dag-scope=entity&entity-bytes=%d:%d", from, to-1))
+	if err != nil {
+		return nil, err
+	}
+	// Returning before the end closes the connection, so a gateway caught
+	// serving a bad block stops sending the rest
+	defer resp.Body.Close()
+	if !isCAR(resp) {
+		return nil, errors.New("gateway stopped answering with CAR")
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, to-from))
+	if err := newRangeWalk(root, from, to, buf).run(resp.Body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// segmentReader stops the fetch when closed
+type segmentReader struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *segmentReader) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}
+
+// openPlain reads the whole file from the best of gateways that answers,
+// checking it against root once read
+func (f *Fetcher) openPlain(ctx context.Context, cid string, root unixfs.CID, gateways []string) (io.ReadCloser, error) {
+	if len(gateways) == 0 {
+		f.save()
+		return nil, fmt.Errorf("%w: %s", ErrUnavailable, cid)
+	}
+	slices.SortStableFunc(gateways, func(a, b string) int {
+		return -compareFloat(f.scores.rank(a), f.scores.rank(b))
+	})
+	var errs []error
+	for _, gateway := range gateways {
+		resp, err := f.get(ctx, gateway, cid, "")
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			f.scores.failed(gateway)
+			errs = append(errs, fmt.Errorf("%s: %w", gateway, err))
+			continue
+		}
+		return newVerifyingReader(f, gateway, root, resp.Body), nil
+	}
+	f.save()
+	return nil, fmt.Errorf("%w: %w", ErrUnavailable, errors.Join(errs...))
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// verifyingReader hashes a whole file as it is read, failing at its end
+// when it does not match the CID it was asked for
+type verifyingReader struct {
+	f       *Fetcher
+	gateway string
+	root    unixfs.CID
+	body    io.ReadCloser
+	start   time.Time
+	read    int64
+
+	hash   *io.PipeWriter
+	result chan hashResult
+	done   bool
+}
+
+type hashResult struct {
+	cid string
+	err error
+}
+
+func newVerifyingReader(f *Fetcher, gateway string, root unixfs.CID, body io.ReadCloser) *verifyingReader {
+	pr, pw := io.Pipe()
+	r := &verifyingReader{
+		f:       f,
+		gateway: gateway,
+		root:    root,
+		body:    body,
+		start:   time.Now(),
+		hash:    pw,
+		result:  make(chan hashResult, 1),
+	}
+	go func() {
+		cid, err := unixfs.FileCID(pr)
+		pr.CloseWithError(err)
+		r.result <- hashResult{cid: cid, err: err}
+	}()
+	return r
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	n, err := r.body.Read(p)
+	if n > 0 {
+		r.read += int64(n)
+		if _, werr := r.hash.Write(p[:n]); werr != nil {
+			return n, werr
+		}
+	}
+	if err != io.EOF {
+		return n, err
+	}
+	r.done = true
+	r.hash.Close()
+	if err := r.verify(<-r.result); err != nil {
+		r.f.scores.failed(r.gateway)
+		r.f.save()
+		return n, err
+	}
+	r.f.scores.succeeded(r.gateway, r.read, time.Since(r.start))
+	r.f.save()
+	return n, io.EOF
+}
+
+func (r *verifyingReader) verify(result hashResult) error {
+	if result.err != nil {
+		return result.err
+	}
+	got, err := unixfs.Parse(result.cid)
+	if err != nil {
+		return err
+	}
+	if !got.Equal(r.root) {
+		return fmt.Errorf("%w: gateway %s served content of %s", unixfs.ErrMismatch, r.gateway, result.cid)
+	}
+	return nil
+}
+
+func (r *verifyingReader) Close() error {
+	r.hash.CloseWithError(io.ErrClosedPipe)
+	return r.body.Close()
+}
+
+// pool hands out the gateways of one fetch, each to one segment at a time
+type pool struct {
+	scores *Scores
+
+	mu       sync.Mutex
+	gateways []string
+	busy     map[string]bool
+	excluded map[string]bool
+	// changed is closed when a gateway is released
+	changed chan struct{}
+}
+
+func newPool(gateways []string, scores *Scores) *pool {
+	return &pool{
+		scores:   scores,
+		gateways: gateways,
+		busy:     make(map[string]bool),
+		excluded: make(map[string]bool),
+		changed:  make(chan struct{}),
+	}
+}
+
+// acquire waits for the best-ranking gateway free to take a segment.
+// Gateways ranking far below the best of the fetch are left idle rather
+// than given segments the best would serve sooner.
+func (p *pool) acquire(ctx context.Context) (string, error) {
+	for {
+		p.mu.Lock()
+		var best, bestIdle float64
+		var pick string
+		usable := false
+		for _, gateway := range p.gateways {
+			if p.excluded[gateway] {
+				continue
+			}
+			usable = true
+			rank := p.scores.rank(gateway)
+			best = max(best, rank)
+			if !p.busy[gateway] && (pick == "" || rank > bestIdle) {
+				pick, bestIdle = gateway, rank
+			}
+		}
+		if !usable {
+			p.mu.Unlock()
+			return "", fmt.Errorf("%w: every gateway failed", ErrUnavailable)
+		}
+		if pick != "" && bestIdle >= best*demoteRatio {
+			p.busy[pick] = true
+			p.mu.Unlock()
+			return pick, nil
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// release frees gateway for other segments, or for none once it failed
+func (p *pool) release(gateway string, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.busy, gateway)
+	if failed {
+		p.excluded[gateway] = true
+	}
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
@@ -0,0 +1,285 @@
+package ipfsfetch
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/unixfs"
+)
+
+// file is content imported as IPFS stores it
+type file struct {
+	content []byte
+	root    unixfs.CID
+	blocks  map[unixfs.CID][]byte
+}
+
+func importFile(t *testing.T, size int) *file {
+	t.Helper()
+	f := &file{content: make([]byte, size), blocks: make(map[unixfs.CID][]byte)}
+	rand.New(rand.NewSource(1)).Read(f.content)
+	root, err := unixfs.Import(bytes.NewReader(f.content), func(cid unixfs.CID, block []byte) error {
+		f.blocks[cid] = bytes.Clone(block)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.root = root
+	return f
+}
+
+// gateway is a mock gateway serving one file, as CAR unless plain is set.
+// A gateway with corrupt set serves a leaf with one byte flipped, then
+// holds the response open until the client hangs up.
+type gateway struct {
+	*httptest.Server
+	file    *file
+	plain   bool
+	corrupt bool
+	served  atomic.Int32
+	aborted atomic.Bool
+}
+
+func newGateway(t *testing.T, f *file, configure func(g *gateway)) *gateway {
+	t.Helper()
+	g := &gateway{file: f}
+	if configure != nil {
+		configure(g)
+	}
+	g.Server = httptest.NewServer(http.HandlerFunc(g.serve))
+	t.Cleanup(g.Close)
+	return g
+}
+
+func (g *gateway) serve(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/ipfs/"+g.file.root.String() {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	if g.plain || query.Get("format") != "car" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(g.file.content)
+		return
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "order=dfs") {
+		http.Error(w, "unordered CAR requested", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/vnd.ipld.car; version=1")
+	writeSection(w, carHeader(g.file.root))
+	if query.Get("dag-scope") == "block" {
+		writeSection(w, []byte(string(g.file.root)), g.file.blocks[g.file.root])
+		return
+	}
+	var from, to uint64
+	if _, err := fmt.Sscanf(query.Get("entity-bytes"), "%d:%d", &from, &to); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.served.Add(1)
+	g.walk(w, r, g.file.root, 0, from, to+1)
+}
+
+// walk writes the blocks covering bytes [from, to) under cid in DFS order,
+// reporting false once the response was cut short
+func (g *gateway) walk(w http.ResponseWriter, r *http.Request, cid unixfs.CID, offset, from, to uint64) bool {
+	block := g.file.blocks[cid]
+	if cid.Codec() == unixfs.CodecRaw && g.corrupt {
+		block = bytes.Clone(block)
+		block[0] ^= 1
+		writeSection(w, []byte(string(cid)), block)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			g.aborted.Store(true)
+		case <-time.After(5 * time.Second):
+		}
+		return false
+	}
+	writeSection(w, []byte(string(cid)), block)
+	if cid.Codec() != unixfs.CodecDagPB {
+		return true
+	}
+	node, _ := unixfs.DecodeNode(block)
+	data, _ := unixfs.DecodeFileData(node.Data)
+	for i, link := range node.Links {
+		size := data.BlockSizes[i]
+		if offset < to && offset+size > from && !g.walk(w, r, link.CID, offset, from, to) {
+			return false
+		}
+		offset += size
+	}
+	return true
+}
+
+func carHeader(root unixfs.CID) []byte {
+	// {"roots": [root], "version": 1} in dag-cbor
+	header := []byte{0xa2, 0x65}
+	header = append(header, "roots"...)
+	header = append(header, 0x81, 0xd8, 0x2a, 0x58, byte(len(root)+1), 0x00)
+	header = append(header, root...)
+	header = append(header, 0x67)
+	header = append(header, "version"...)
+	return append(header, 0x01)
+}
+
+func writeSection(w io.Writer, parts ...[]byte) {
+	var section []byte
+	for _, part := range parts {
+		section = append(section, part...)
+	}
+	w.Write(binary.AppendUvarint(nil, uint64(len(section))))
+	w.Write(section)
+}
+
+func TestFetchSplitsVerifiedCARAcrossGateways(t *testing.T) {
+	f := importFile(t, 3*unixfs.ChunkSize*4+1000)
+	// The corrupt gateway comes first, so that ties send it a segment
+	corrupt := newGateway(t, f, func(g *gateway) { g.corrupt = true })
+	good := []*gateway{newGateway(t, f, nil), newGateway(t, f, nil)}
+
+	scoresPath := filepath.Join(t.TempDir(), "gateways.json")
+	scores, err := OpenScores(scoresPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetcher := New([]string{corrupt.URL + "/ipfs/", good[0].URL + "/ipfs/", good[1].URL + "/ipfs"}, scores)
+	fetcher.SetSegmentSize(unixfs.ChunkSize)
+	fetcher.SetParallel(3)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	body, err := fetcher.Open(ctx, f.root.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("read error = %v, want the segments the corrupt gateway failed fetched elsewhere", err)
+	}
+	if !bytes.Equal(got, f.content) {
+		t.Fatalf("read %d bytes, want the %d of the file", len(got), len(f.content))
+	}
+	// The server notices the hang-up after the fetch moved on
+	deadline := time.Now().Add(2 * time.Second)
+	for !corrupt.aborted.Load() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !corrupt.aborted.Load() {
+		t.Error("the corrupt gateway's response was read to its end, want it dropped at the bad block")
+	}
+	if served := good[0].served.Load() + good[1].served.Load(); served != 13 {
+		t.Errorf("good gateways served %d segments, want all 13", served)
+	}
+
+	// The scores outlive the fetch
+	reopened, err := OpenScores(scoresPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if score, ok := reopened.Get(corrupt.URL + "/ipfs"); !ok || score.ErrorRate == 0 {
+		t.Errorf("corrupt gateway score = %+v, want its failure recorded", score)
+	}
+	for _, g := range good {
+		if score, ok := reopened.Get(g.URL + "/ipfs"); ok && (score.ErrorRate != 0 || score.Throughput == 0) {
+			t.Errorf("good gateway score = %+v, want its throughput and no errors", score)
+		}
+	}
+}
+
+func TestFetchSingleBlockFile(t *testing.T) {
+	f := importFile(t, 1000)
+	scores, _ := OpenScores("")
+	fetcher := New([]string{newGateway(t, f, nil).URL + "/ipfs/"}, scores)
+	body, err := fetcher.Open(context.Background(), f.root.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if got, err := io.ReadAll(body); err != nil || !bytes.Equal(got, f.content) {
+		t.Fatalf("read %d bytes, %v, want the file", len(got), err)
+	}
+}
+
+func TestFetchFallsBackToFullFileCheck(t *testing.T) {
+	f := importFile(t, 2*unixfs.ChunkSize+10)
+	scores, _ := OpenScores("")
+	plain := newGateway(t, f, func(g *gateway) { g.plain = true })
+
+	body, err := New([]string{plain.URL + "/ipfs/"}, scores).Open(context.Background(), f.root.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || !bytes.Equal(got, f.content) {
+		t.Fatalf("read %d bytes, %v, want the file", len(got), err)
+	}
+
+	// A gateway serving other content under the CID fails at the end
+	other := importFile(t, 10)
+	other.root = f.root
+	lying := newGateway(t, other, func(g *gateway) { g.plain = true })
+	body, err = New([]string{lying.URL + "/ipfs/"}, scores).Open(context.Background(), f.root.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if _, err := io.ReadAll(body); !errors.Is(err, unixfs.ErrMismatch) {
+		t.Fatalf("read error = %v, want ErrMismatch", err)
+	}
+	if score, _ := scores.Get(lying.URL + "/ipfs"); score.ErrorRate == 0 {
+		t.Errorf("lying gateway score = %+v, want its failure recorded", score)
+	}
+}
+
+func TestSlowGatewaysAreDemoted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gateways.json")
+	scores, err := OpenScores(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		scores.succeeded("fast", 100<<20, time.Second)
+		scores.succeeded("slow", 1<<20, time.Second)
+	}
+	if err := scores.Save(); err != nil {
+		t.Fatal(err)
+	}
+	scores, err = OpenScores(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := newPool([]string{"slow", "fast"}, scores)
+	if gateway, err := p.acquire(context.Background()); err != nil || gateway != "fast" {
+		t.Fatalf("acquire() = %s, %v, want the fast gateway", gateway, err)
+	}
+	// The slow gateway is left idle while the fast one is busy
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if gateway, err := p.acquire(ctx); err == nil {
+		t.Fatalf("acquire() = %s, want to wait for the fast gateway", gateway)
+	}
+	// Until the fast one fails
+	p.release("fast", true)
+	if gateway, err := p.acquire(context.Background()); err != nil || gateway != "slow" {
+		t.Fatalf("acquire() = %s, %v, want the slow gateway once the fast one failed", gateway, err)
+	}
+}
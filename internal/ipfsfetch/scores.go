@@ -0,0 +1,150 @@
+package ipfsfetch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+)
+
+const (
+	// scoreWeight is how much the latest transfer moves a gateway's
+	// throughput and error rate
+	scoreWeight = 0.3
+	// untriedThroughput is the throughput assumed of a gateway never tried
+	// while no other completed a transfer either
+	untriedThroughput = 1 << 20
+)
+
+// GatewayScore is what fetches taught about a gateway: its throughput in
+// bytes per second and the share of its transfers that failed, both moving
+// averages weighted toward recent transfers
+type GatewayScore struct {
+	Throughput float64   `json:"throughput"`
+	ErrorRate  float64   `json:"error_rate"`
+	Transfers  int       `json:"transfers"`
+	Updated    time.Time `json:"updated"`
+}
+
+// Scores ranks gateways by their past transfers. Scores saved to a file
+// outlive the fetch and the runner, so gateways that are consistently slow
+// or serve bad blocks stay demoted.
+type Scores struct {
+	path string
+
+	mu       sync.Mutex
+	gateways map[string]*GatewayScore
+}
+
+// OpenScores loads the scores kept in the file at path, starting afresh
+// when it does not exist yet. An empty path keeps scores in memory only.
+func OpenScores(path string) (*Scores, error) {
+	s := &Scores{path: path, gateways: make(map[string]*GatewayScore)}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gateway scores: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.gateways); err != nil {
+		return nil, fmt.Errorf("invalid gateway scores file: %w", err)
+	}
+	if s.gateways == nil {
+		s.gateways = make(map[string]*GatewayScore)
+	}
+	return s, nil
+}
+
+// Get returns the score of gateway, and false when it was never tried
+func (s *Scores) Get(gateway string) (GatewayScore, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	score, ok := s.gateways[gateway]
+	if !ok {
+		return GatewayScore{}, false
+	}
+	return *score, true
+}
+
+// rank orders gateways: the throughput expected of one, discounted by how
+// often it fails. A gateway that never completed a transfer is expected to
+// be as fast as the best that did, so that it gets a chance.
+func (s *Scores) rank(gateway string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	score, ok := s.gateways[gateway]
+	if !ok {
+		score = &GatewayScore{}
+	}
+	throughput := score.Throughput
+	if throughput == 0 {
+		throughput = untriedThroughput
+		for _, other := range s.gateways {
+			throughput = max(throughput, other.Throughput)
+		}
+	}
+	success := 1 - score.ErrorRate
+	return throughput * success * success
+}
+
+// succeeded records that gateway served n bytes in elapsed
+func (s *Scores) succeeded(gateway string, n int64, elapsed time.Duration) {
+	throughput := float64(n) / max(elapsed.Seconds(), 1e-3)
+	s.update(gateway, func(score *GatewayScore, first bool) {
+		if first {
+			score.Throughput = throughput
+		} else {
+			score.Throughput += scoreWeight * (throughput - score.Throughput)
+		}
+		score.ErrorRate -= scoreWeight * score.ErrorRate
+	})
+}
+
+// failed records that a transfer from gateway failed
+func (s *Scores) failed(gateway string) {
+	s.update(gateway, func(score *GatewayScore, first bool) {
+		score.ErrorRate += scoreWeight * (1 - score.ErrorRate)
+	})
+}
+
+func (s *Scores) update(gateway string, apply func(score *GatewayScore, first bool)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	score, ok := s.gateways[gateway]
+	if !ok {
+		score = &GatewayScore{}
+		s.gateways[gateway] = score
+	}
+	apply(score, score.Throughput == 0)
+	score.Transfers++
+	score.Updated = time.Now()
+}
+
+// Save writes the scores to their file
+func (s *Scores) Save() error {
+	if s.path == "" {
+		return nil
+	}
+	s.mu.Lock()
+	data, err := json.Marshal(s.gateways)
+	s.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal gateway scores: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create gateway scores directory: %w", err)
+	}
+	if err := atrest.WriteFileAtomic(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write gateway scores: %w", err)
+	}
+	return nil
+}
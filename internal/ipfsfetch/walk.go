@@ -0,0 +1,169 @@
+package ipfsfetch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/theblitlabs/parity-runner/internal/unixfs"
+)
+
+// ErrInvalidBlock is returned when a gateway serves a block that does not
+// hash to its CID or is not the block the file's tree calls for next
+var ErrInvalidBlock = errors.New("gateway served an invalid block")
+
+// pending is a block the walk expects: the part of the file from offset on,
+// size bytes long when its parent says so
+type pending struct {
+	cid    unixfs.CID
+	offset uint64
+	size   uint64
+}
+
+// rangeWalk verifies the blocks a gateway streams for bytes [from, to) of a
+// file, in the depth-first order of the file's tree, writing the range's
+// bytes to w as each leaf is verified. Only the blocks covering the range
+// are expected: the nodes above it and the leaves overlapping it.
+type rangeWalk struct {
+	from, to uint64
+	w        io.Writer
+	stack    []pending
+	// seen keeps the blocks verified so far, for gateways that send a
+	// block needed twice only once
+	seen    map[unixfs.CID][]byte
+	written uint64
+}
+
+func newRangeWalk(root unixfs.CID, from, to uint64, w io.Writer) *rangeWalk {
+	return &rangeWalk{
+		from:  from,
+		to:    to,
+		w:     w,
+		stack: []pending{{cid: root}},
+		seen:  make(map[unixfs.CID][]byte),
+	}
+}
+
+// run reads the CAR stream r to its end, failing at the first block that
+// is not the one expected next
+func (rw *rangeWalk) run(r io.Reader) error {
+	car, err := newCARReader(r)
+	if err != nil {
+		return err
+	}
+	for {
+		if err := rw.drainSeen(); err != nil {
+			return err
+		}
+		cid, block, err := car.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(rw.stack) == 0 {
+			return fmt.Errorf("%w: block %s past the end of the range", ErrInvalidBlock, cid)
+		}
+		next := rw.stack[len(rw.stack)-1]
+		if cid != next.cid {
+			if _, ok := rw.seen[cid]; ok {
+				// A duplicate of a block already verified
+				continue
+			}
+			return fmt.Errorf("%w: got block %s, want %s", ErrInvalidBlock, cid, next.cid)
+		}
+		if err := cid.Verify(block); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+		}
+		rw.stack = rw.stack[:len(rw.stack)-1]
+		rw.seen[cid] = block
+		if err := rw.visit(next, block); err != nil {
+			return err
+		}
+	}
+	if len(rw.stack) > 0 {
+		return fmt.Errorf("response ended %d blocks short of the range", len(rw.stack))
+	}
+	if want := rw.to - rw.from; rw.written != want {
+		return fmt.Errorf("%w: range holds %d bytes, want %d", ErrInvalidBlock, rw.written, want)
+	}
+	return nil
+}
+
+// drainSeen visits the blocks expected next that were already verified
+func (rw *rangeWalk) drainSeen() error {
+	for len(rw.stack) > 0 {
+		next := rw.stack[len(rw.stack)-1]
+		block, ok := rw.seen[next.cid]
+		if !ok {
+			return nil
+		}
+		rw.stack = rw.stack[:len(rw.stack)-1]
+		if err := rw.visit(next, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visit writes the part of the range a verified block holds and expects
+// the children of it that overlap the range
+func (rw *rangeWalk) visit(p pending, block []byte) error {
+	switch p.cid.Codec() {
+	case unixfs.CodecRaw:
+		if p.size != 0 && uint64(len(block)) != p.size {
+			return fmt.Errorf("%w: leaf %s holds %d bytes, want %d", ErrInvalidBlock, p.cid, len(block), p.size)
+		}
+		return rw.write(p.offset, block)
+	case unixfs.CodecDagPB:
+	default:
+		return fmt.Errorf("%w: block %s is not part of a file", ErrInvalidBlock, p.cid)
+	}
+
+	node, err := unixfs.DecodeNode(block)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+	}
+	data, err := unixfs.DecodeFileData(node.Data)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidBlock, err)
+	}
+	if data.Type != unixfs.TypeFile && data.Type != unixfs.TypeRaw || len(data.BlockSizes) != len(node.Links) {
+		return fmt.Errorf("%w: node %s is not part of a file", ErrInvalidBlock, p.cid)
+	}
+	size := uint64(len(data.Data))
+	for _, blockSize := range data.BlockSizes {
+		size += blockSize
+	}
+	if p.size != 0 && size != p.size {
+		return fmt.Errorf("%w: node %s holds %d bytes, want %d", ErrInvalidBlock, p.cid, size, p.size)
+	}
+	if err := rw.write(p.offset, data.Data); err != nil {
+		return err
+	}
+
+	// Children go on the stack last first, so the first is visited next
+	offset := p.offset + size
+	for i := len(node.Links) - 1; i >= 0; i-- {
+		offset -= data.BlockSizes[i]
+		if offset < rw.to && offset+data.BlockSizes[i] > rw.from && data.BlockSizes[i] > 0 {
+			rw.stack = append(rw.stack, pending{cid: node.Links[i].CID, offset: offset, size: data.BlockSizes[i]})
+		}
+	}
+	return nil
+}
+
+// write writes the part of the range content at offset of the file holds
+func (rw *rangeWalk) write(offset uint64, content []byte) error {
+	start, end := max(offset, rw.from), min(offset+uint64(len(content)), rw.to)
+	if start >= end {
+		return nil
+	}
+	if start != rw.from+rw.written {
+		return fmt.Errorf("%w: block at offset %d out of order", ErrInvalidBlock, offset)
+	}
+	n, err := rw.w.Write(content[start-offset : end-offset])
+	rw.written += uint64(n)
+	return err
+}
@@ -0,0 +1,322 @@
+// Package materialize delivers the results of tasks straight into systems
+// their creators own, such as a Postgres table or a webhook, through
+// plugins the runner's operator configures and tasks name
+package materialize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+const (
+	TypePostgres = "postgres"
+	TypeWebhook  = "webhook"
+
+	defaultTimeout     = 10 * time.Second
+	defaultMaxAttempts = 3
+	defaultBackoff     = time.Second
+)
+
+var (
+	// ErrUnknownPlugin is returned for a plugin name the runner has not
+	// configured
+	ErrUnknownPlugin = errors.New("unknown materialization plugin")
+
+	pluginName = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+)
+
+// Delivery is what a plugin delivers: the successful result of a task
+type Delivery struct {
+	TaskID         string            `json:"task_id"`
+	CreatorAddress string            `json:"creator_address,omitempty"`
+	RunnerAddress  string            `json:"runner_address,omitempty"`
+	Status         models.TaskStatus `json:"status"`
+	ExitCode       int               `json:"exit_code"`
+	Output         string            `json:"output"`
+	// JSON is Output parsed as JSON, null when it is not
+	JSON             json.RawMessage          `json:"json"`
+	ResultHash       string                   `json:"result_hash,omitempty"`
+	Metadata         map[string]interface{}   `json:"metadata,omitempty"`
+	ArtifactCIDs     []string                 `json:"artifact_cids,omitempty"`
+	ChunkedArtifacts []models.ChunkedArtifact `json:"chunked_artifacts,omitempty"`
+	// Artifacts describes the files the task produced
+	Artifacts   []models.ArtifactDescription `json:"artifacts,omitempty"`
+	CompletedAt time.Time                    `json:"completed_at"`
+}
+
+// NewDelivery builds the delivery of a saved task result
+func NewDelivery(task *models.Task, result *models.TaskResult, status models.TaskStatus) *Delivery {
+	parsed := json.RawMessage("null")
+	if output := strings.TrimSpace(result.Output); output != "" && json.Valid([]byte(output)) {
+		parsed = json.RawMessage(output)
+	}
+	return &Delivery{
+		TaskID:           task.ID.String(),
+		CreatorAddress:   task.CreatorAddress,
+		RunnerAddress:    result.RunnerAddress,
+		Status:           status,
+		ExitCode:         result.ExitCode,
+		Output:           result.Output,
+		JSON:             parsed,
+		ResultHash:       result.ResultHash,
+		Metadata:         result.Metadata,
+		ArtifactCIDs:     result.ArtifactCIDs,
+		ChunkedArtifacts: result.ChunkedArtifacts,
+		Artifacts:        result.Artifacts,
+		CompletedAt:      result.CreatedAt,
+	}
+}
+
+// Plugin delivers results to one destination. Deliver is retried while it
+// fails, unless it marks its failure Permanent, and must honor ctx, which
+// bounds each attempt.
+type Plugin interface {
+	Deliver(ctx context.Context, d *Delivery) error
+}
+
+type permanentError struct{ err error }
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as a failure retrying would not cure, such as a
+// destination rejecting the delivery
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// IsPermanent reports whether err was marked Permanent
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+// Spec configures a plugin. Name is what tasks refer to it by and Type
+// which reference plugin it is; the fields of the other type are ignored.
+type Spec struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Timeout bounds each delivery attempt, such as 10s
+	Timeout string `json:"timeout,omitempty"`
+	// MaxAttempts is how many times a failing delivery is tried
+	MaxAttempts int `json:"max_attempts,omitempty"`
+	// Backoff is the wait before the first retry, doubling for each next
+	Backoff string `json:"backoff,omitempty"`
+
+	// DSN is the connection string of a postgres plugin's database, which
+	// psql, or Command when set, connects to. Keep passwords out of it, in
+	// a password file, as it is passed on psql's command line.
+	DSN     string `json:"dsn,omitempty"`
+	Table   string `json:"table,omitempty"`
+	Command string `json:"command,omitempty"`
+
+	// URL is where a webhook plugin POSTs deliveries, with Headers
+	URL     string            `json:"url,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// Options are the retry settings of a plugin
+type Options struct {
+	Timeout     time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+}
+
+func (o Options) withDefaults() Options {
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = defaultMaxAttempts
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = defaultBackoff
+	}
+	return o
+}
+
+// LoadSpecs reads a JSON list of plugin specs
+func LoadSpecs(path string) ([]Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read materialization plugins: %w", err)
+	}
+	var specs []Spec
+	if err := json.Unmarshal(data, &specs); err != nil {
+		return nil, fmt.Errorf("failed to parse materialization plugins %s: %w", path, err)
+	}
+	return specs, nil
+}
+
+type registered struct {
+	plugin Plugin
+	opts   Options
+}
+
+// Materializer runs the plugins tasks name on their results
+type Materializer struct {
+	plugins map[string]registered
+	clock   clock.Clock
+}
+
+// New returns a materializer with the reference plugins specs configure
+func New(specs []Spec) (*Materializer, error) {
+	m := &Materializer{plugins: make(map[string]registered), clock: clock.Real()}
+	for _, spec := range specs {
+		plugin, err := newPlugin(spec)
+		if err != nil {
+			return nil, fmt.Errorf("materialization plugin %q: %w", spec.Name, err)
+		}
+		opts, err := spec.options()
+		if err != nil {
+			return nil, fmt.Errorf("materialization plugin %q: %w", spec.Name, err)
+		}
+		if err := m.Register(spec.Name, plugin, opts); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func newPlugin(spec Spec) (Plugin, error) {
+	switch spec.Type {
+	case TypePostgres:
+		return NewPostgres(spec.Command, spec.DSN, spec.Table)
+	case TypeWebhook:
+		return NewWebhook(spec.URL, spec.Headers)
+	default:
+		return nil, fmt.Errorf("unsupported plugin type %q", spec.Type)
+	}
+}
+
+func (s Spec) options() (Options, error) {
+	var opts Options
+	var err error
+	if s.Timeout != "" {
+		if opts.Timeout, err = time.ParseDuration(s.Timeout); err != nil {
+			return opts, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	if s.Backoff != "" {
+		if opts.Backoff, err = time.ParseDuration(s.Backoff); err != nil {
+			return opts, fmt.Errorf("invalid backoff: %w", err)
+		}
+	}
+	opts.MaxAttempts = s.MaxAttempts
+	return opts, nil
+}
+
+// Register adds plugin under name, for plugins other than the reference
+// ones
+func (m *Materializer) Register(name string, plugin Plugin, opts Options) error {
+	if !pluginName.MatchString(name) {
+		return fmt.Errorf("invalid materialization plugin name %q", name)
+	}
+	if _, ok := m.plugins[name]; ok {
+		return fmt.Errorf("materialization plugin %q configured twice", name)
+	}
+	m.plugins[name] = registered{plugin: plugin, opts: opts.withDefaults()}
+	return nil
+}
+
+// SetClock replaces the clock used for retry backoff and timestamps
+func (m *Materializer) SetClock(c clock.Clock) {
+	m.clock = c
+}
+
+// Names returns the names of the configured plugins, sorted
+func (m *Materializer) Names() []string {
+	names := make([]string, 0, len(m.plugins))
+	for name := range m.plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Check returns ErrUnknownPlugin if any of names is not configured
+func (m *Materializer) Check(names []string) error {
+	for _, name := range names {
+		if _, ok := m.plugins[name]; !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownPlugin, name)
+		}
+	}
+	return nil
+}
+
+// Materialize delivers d through the named plugins at once and returns how
+// each delivery went, in the order of names. A plugin failing or panicking
+// affects neither the others nor the caller.
+func (m *Materializer) Materialize(ctx context.Context, names []string, d *Delivery) []models.MaterializationOutcome {
+	outcomes := make([]models.MaterializationOutcome, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			outcomes[i] = m.materialize(ctx, name, d)
+		}()
+	}
+	wg.Wait()
+	return outcomes
+}
+
+func (m *Materializer) materialize(ctx context.Context, name string, d *Delivery) models.MaterializationOutcome {
+	outcome := models.MaterializationOutcome{Plugin: name}
+	r, ok := m.plugins[name]
+	if !ok {
+		outcome.Error = fmt.Sprintf("%v: %q", ErrUnknownPlugin, name)
+		outcome.At = m.clock.Now().UTC()
+		return outcome
+	}
+
+	backoff := r.opts.Backoff
+	var err error
+	for {
+		outcome.Attempts++
+		err = deliver(ctx, r, d)
+		if err == nil || IsPermanent(err) || outcome.Attempts >= r.opts.MaxAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			err = fmt.Errorf("%w after %v", ctx.Err(), err)
+		case <-m.clock.After(backoff):
+			backoff *= 2
+			continue
+		}
+		break
+	}
+	outcome.Delivered = err == nil
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+	outcome.At = m.clock.Now().UTC()
+	return outcome
+}
+
+// deliver makes one attempt, turning a panic of the plugin into an error
+func deliver(ctx context.Context, r registered, d *Delivery) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = Permanent(fmt.Errorf("plugin panicked: %v", p))
+		}
+	}()
+	ctx, cancel := context.WithTimeout(ctx, r.opts.Timeout)
+	defer cancel()
+	return r.plugin.Deliver(ctx, d)
+}
@@ -0,0 +1,202 @@
+package materialize
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var fast = Options{Timeout: time.Second, MaxAttempts: 3, Backoff: time.Millisecond}
+
+func newDelivery() *Delivery {
+	task := &models.Task{ID: uuid.New(), CreatorAddress: "0xabc"}
+	result := &models.TaskResult{
+		RunnerAddress: "0xdef",
+		Output:        `{"accuracy": 0.93, "note": "it's $$ done"}`,
+		ResultHash:    "hash",
+		ArtifactCIDs:  []string{"bafyartifact"},
+		CreatedAt:     time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+	return NewDelivery(task, result, models.TaskStatusCompleted)
+}
+
+type pluginFunc func(ctx context.Context, d *Delivery) error
+
+func (f pluginFunc) Deliver(ctx context.Context, d *Delivery) error { return f(ctx, d) }
+
+func TestNewDeliveryParsesJSONOutput(t *testing.T) {
+	d := newDelivery()
+	var parsed struct{ Accuracy float64 }
+	if err := json.Unmarshal(d.JSON, &parsed); err != nil || parsed.Accuracy != 0.93 {
+		t.Fatalf("JSON = %s, want the parsed output", d.JSON)
+	}
+	text := NewDelivery(&models.Task{ID: uuid.New()}, &models.TaskResult{Output: "plain text"}, models.TaskStatusCompleted)
+	if string(text.JSON) != "null" {
+		t.Errorf("JSON of a non-JSON output = %s, want null", text.JSON)
+	}
+}
+
+func TestCheckRejectsUnknownNames(t *testing.T) {
+	m, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Register("warehouse", pluginFunc(func(context.Context, *Delivery) error { return nil }), fast); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Check([]string{"warehouse"}); err != nil {
+		t.Errorf("Check() of a configured plugin = %v", err)
+	}
+	if err := m.Check([]string{"warehouse", "elsewhere"}); !errors.Is(err, ErrUnknownPlugin) {
+		t.Errorf("Check() of an unconfigured plugin = %v, want ErrUnknownPlugin", err)
+	}
+	if err := m.Register("warehouse", pluginFunc(nil), fast); err == nil {
+		t.Error("Register() accepted a name twice")
+	}
+}
+
+func TestWebhookDeliversAndRetries(t *testing.T) {
+	var calls atomic.Int32
+	var got Delivery
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get(TaskHeader) == "" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+	}))
+	defer server.Close()
+
+	webhook, err := NewWebhook(server.URL, map[string]string{"Authorization": "Bearer token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, _ := New(nil)
+	m.Register("hook", webhook, fast)
+	d := newDelivery()
+	outcomes := m.Materialize(context.Background(), []string{"hook"}, d)
+	if !outcomes[0].Delivered || outcomes[0].Attempts != 2 {
+		t.Fatalf("outcome = %+v, want delivered on the second attempt", outcomes[0])
+	}
+	if got.TaskID != d.TaskID || !strings.Contains(string(got.JSON), "accuracy") {
+		t.Errorf("webhook received %+v", got)
+	}
+}
+
+func TestWebhookRejectionIsNotRetried(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.Error(w, "bad", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	m, err := New([]Spec{{Name: "hook", Type: TypeWebhook, URL: server.URL, Backoff: "1ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcomes := m.Materialize(context.Background(), []string{"hook"}, newDelivery())
+	if outcomes[0].Delivered || outcomes[0].Attempts != 1 || calls.Load() != 1 {
+		t.Fatalf("outcome = %+v after %d calls, want one failed attempt", outcomes[0], calls.Load())
+	}
+}
+
+// fakePsql writes a psql stand-in that saves its arguments and script, and
+// exits with the code in the file exit beside it
+func fakePsql(t *testing.T) (command, dir string) {
+	t.Helper()
+	dir = t.TempDir()
+	command = filepath.Join(dir, "psql")
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" > " + filepath.Join(dir, "args") + "\n" +
+		"cat > " + filepath.Join(dir, "script.sql") + "\n" +
+		"code=$(cat " + filepath.Join(dir, "exit") + " 2>/dev/null || echo 0)\n" +
+		"[ \"$code\" = 0 ] || echo 'ERROR: relation does not exist' >&2\n" +
+		"exit $code\n"
+	if err := os.WriteFile(command, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return command, dir
+}
+
+func TestPostgresInsertsRow(t *testing.T) {
+	command, dir := fakePsql(t)
+	m, err := New([]Spec{{Name: "warehouse", Type: TypePostgres, Command: command, DSN: "postgres://db/results", Table: "public.task_results"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := newDelivery()
+	outcomes := m.Materialize(context.Background(), []string{"warehouse"}, d)
+	if !outcomes[0].Delivered {
+		t.Fatalf("outcome = %+v, want delivered", outcomes[0])
+	}
+
+	args, _ := os.ReadFile(filepath.Join(dir, "args"))
+	if !strings.Contains(string(args), "ON_ERROR_STOP=1") || !strings.Contains(string(args), "postgres://db/results") {
+		t.Errorf("psql args = %s", args)
+	}
+	sql, _ := os.ReadFile(filepath.Join(dir, "script.sql"))
+	for _, want := range []string{"INSERT INTO public.task_results", d.TaskID, "it's $$ done", "::jsonb", "ON CONFLICT (task_id) DO NOTHING"} {
+		if !strings.Contains(string(sql), want) {
+			t.Errorf("statement lacks %q:\n%s", want, sql)
+		}
+	}
+}
+
+func TestPostgresScriptErrorIsPermanent(t *testing.T) {
+	command, dir := fakePsql(t)
+	os.WriteFile(filepath.Join(dir, "exit"), []byte("3"), 0o644)
+	m, err := New([]Spec{{Name: "warehouse", Type: TypePostgres, Command: command, DSN: "db", Table: "results", Backoff: "1ms"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcomes := m.Materialize(context.Background(), []string{"warehouse"}, newDelivery())
+	if outcomes[0].Delivered || outcomes[0].Attempts != 1 || !strings.Contains(outcomes[0].Error, "relation does not exist") {
+		t.Fatalf("outcome = %+v, want one failed attempt", outcomes[0])
+	}
+
+	if _, err := New([]Spec{{Name: "bad", Type: TypePostgres, Command: command, DSN: "db", Table: "results; DROP TABLE x"}}); err == nil {
+		t.Error("New() accepted an invalid table name")
+	}
+}
+
+func TestFailingPluginsAreIsolated(t *testing.T) {
+	m, _ := New(nil)
+	var delivered atomic.Bool
+	m.Register("panics", pluginFunc(func(context.Context, *Delivery) error { panic("boom") }), fast)
+	m.Register("hangs", pluginFunc(func(ctx context.Context, _ *Delivery) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}), Options{Timeout: 10 * time.Millisecond, MaxAttempts: 2, Backoff: time.Millisecond})
+	m.Register("works", pluginFunc(func(context.Context, *Delivery) error {
+		delivered.Store(true)
+		return nil
+	}), fast)
+
+	outcomes := m.Materialize(context.Background(), []string{"panics", "hangs", "works"}, newDelivery())
+	if outcomes[0].Delivered || !strings.Contains(outcomes[0].Error, "panicked") {
+		t.Errorf("panicking plugin outcome = %+v", outcomes[0])
+	}
+	if outcomes[1].Delivered || outcomes[1].Attempts != 2 {
+		t.Errorf("hanging plugin outcome = %+v, want two timed out attempts", outcomes[1])
+	}
+	if !outcomes[2].Delivered || !delivered.Load() {
+		t.Errorf("working plugin outcome = %+v, want delivered", outcomes[2])
+	}
+}
@@ -0,0 +1,130 @@
+package materialize
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// psqlScriptError is psql's exit code for an error in its script with
+// ON_ERROR_STOP set, such as a missing table or a constraint violation
+const psqlScriptError = 3
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}(\.[A-Za-z_][A-Za-z0-9_]{0,62})?$`)
+
+// Postgres inserts deliveries as rows of a table through psql, so the
+// runner needs no database driver. The table needs the columns
+//
+//	task_id text primary key, creator_address text, runner_address text,
+//	status text, exit_code integer, output text, result jsonb,
+//	result_hash text, metadata jsonb, artifacts jsonb,
+//	completed_at timestamptz
+//
+// and a delivery already inserted is left alone, so retries insert one row.
+type Postgres struct {
+	command string
+	dsn     string
+	table   string
+}
+
+func NewPostgres(command, dsn, table string) (*Postgres, error) {
+	if command == "" {
+		command = "psql"
+	}
+	path, err := exec.LookPath(command)
+	if err != nil {
+		return nil, fmt.Errorf("psql not found: %w", err)
+	}
+	if dsn == "" {
+		return nil, errors.New("no DSN")
+	}
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	return &Postgres{command: path, dsn: dsn, table: table}, nil
+}
+
+func (p *Postgres) Deliver(ctx context.Context, d *Delivery) error {
+	statement, err := p.insert(d)
+	if err != nil {
+		return Permanent(err)
+	}
+
+	cmd := exec.CommandContext(ctx, p.command, "--no-psqlrc", "--quiet", "-v", "ON_ERROR_STOP=1", "-d", p.dsn, "-f", "-")
+	cmd.Stdin = strings.NewReader(statement)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("psql: %w", ctx.Err())
+	}
+	err = fmt.Errorf("psql failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == psqlScriptError {
+		return Permanent(err)
+	}
+	return err
+}
+
+// insert returns the statement inserting d into the table
+func (p *Postgres) insert(d *Delivery) (string, error) {
+	metadata, err := json.Marshal(d.Metadata)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+	artifacts, err := json.Marshal(struct {
+		ArtifactCIDs     interface{} `json:"artifact_cids"`
+		ChunkedArtifacts interface{} `json:"chunked_artifacts"`
+		Artifacts        interface{} `json:"artifacts"`
+	}{d.ArtifactCIDs, d.ChunkedArtifacts, d.Artifacts})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal artifacts: %w", err)
+	}
+	result := d.JSON
+	if len(result) == 0 {
+		result = json.RawMessage("null")
+	}
+
+	values := []string{
+		quote(d.TaskID),
+		quote(d.CreatorAddress),
+		quote(d.RunnerAddress),
+		quote(string(d.Status)),
+		strconv.Itoa(d.ExitCode),
+		quote(strings.ReplaceAll(d.Output, "\x00", "")),
+		quote(string(result)) + "::jsonb",
+		quote(d.ResultHash),
+		quote(string(metadata)) + "::jsonb",
+		quote(string(artifacts)) + "::jsonb",
+		quote(d.CompletedAt.UTC().Format(time.RFC3339Nano)) + "::timestamptz",
+	}
+	return fmt.Sprintf(
+		"INSERT INTO %s (task_id, creator_address, runner_address, status, exit_code, output, result, result_hash, metadata, artifacts, completed_at)\nVALUES (%s)\nON CONFLICT (task_id) DO NOTHING;\n",
+		p.table, strings.Join(values, ", "),
+	), nil
+}
+
+// quote dollar-quotes s with a random tag that does not occur in it, so no
+// content of s can end the literal
+func quote(s string) string {
+	for {
+		var b [6]byte
+		rand.Read(b[:])
+		tag := "$m" + hex.EncodeToString(b[:]) + "$"
+		if !strings.Contains(s, tag) {
+			return tag + s + tag
+		}
+	}
+}
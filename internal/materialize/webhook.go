@@ -0,0 +1,72 @@
+package materialize
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// TaskHeader carries the ID of the task a webhook delivery is for, which
+// receivers can deduplicate retried deliveries by
+const TaskHeader = "X-Parity-Task"
+
+// Webhook POSTs deliveries as JSON to a URL the operator configured.
+// Connection failures, 429 and 5xx answers are retried; other answers
+// outside 2xx are permanent failures, including redirects.
+type Webhook struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func NewWebhook(rawURL string, headers map[string]string) (*Webhook, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid webhook URL %q", rawURL)
+	}
+	return &Webhook{
+		url:     u.String(),
+		headers: headers,
+		client: &http.Client{
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}, nil
+}
+
+func (w *Webhook) Deliver(ctx context.Context, d *Delivery) error {
+	body, err := json.Marshal(d)
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to marshal delivery: %w", err))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return Permanent(fmt.Errorf("failed to create request: %w", err))
+	}
+	for name, value := range w.headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TaskHeader, d.TaskID)
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	default:
+		return Permanent(fmt.Errorf("webhook answered %s", resp.Status))
+	}
+}
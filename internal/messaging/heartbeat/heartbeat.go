@@ -13,8 +13,14 @@ import (
 	"github.com/go-co-op/gocron"
 	"github.com/theblitlabs/gologger"
 
+	"github.com/theblitlabs/parity-runner/internal/clock"
 	"github.com/theblitlabs/parity-runner/internal/core/models"
 	"github.com/theblitlabs/parity-runner/internal/core/ports"
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+	"github.com/theblitlabs/parity-runner/internal/errreport"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/provenance"
 	"github.com/theblitlabs/parity-runner/internal/utils"
 )
 
@@ -38,6 +44,47 @@ type HeartbeatService struct {
 	metricsProvider     ports.MetricsProvider
 	job                 *gocron.Job
 	consecutiveFailures int
+	lastSuccess         time.Time
+	network             *hardware.NetworkProfile
+	llmStats            func() []models.LLMModelStats
+	gpu                 func() *models.GPUCapacity
+	power               func() *models.PowerState
+	executionGuard      func() *models.ExecutionGuardStats
+	fleetHints          func() *models.FleetHintStats
+	budget              func() *models.ComputeBudget
+	instance            func() *models.InstanceStats
+	unsupported         func() map[models.TaskType]uint64
+	overlays            OverlayHandler
+	audits              AuditHandler
+	deletions           DeletionHandler
+	reporter            *errreport.Reporter
+	events              *events.Bus
+	clock               clock.Clock
+}
+
+// OverlayHandler applies the fleet configuration overlays heartbeat
+// responses carry and reports which one is in effect
+type OverlayHandler interface {
+	Apply(overlay *models.ConfigOverlay) error
+	AppliedVersion() int64
+	Expire()
+}
+
+// AuditHandler publishes the runner's commitment to its completed tasks and
+// answers the audit challenges heartbeat responses carry
+type AuditHandler interface {
+	AuditCommitment() *models.AuditCommitment
+	HandleAuditChallenge(challenge *models.AuditChallenge)
+}
+
+// DeletionHandler carries out the task data deletions heartbeat responses
+// carry and acknowledges them in the heartbeats that follow
+type DeletionHandler interface {
+	DeleteTaskData(request *models.TaskDataDeletion)
+	DeletionAcks() []models.TaskDataDeletionAck
+	// DeletionAcksSent drops the first n acknowledgments, which the server
+	// accepted
+	DeletionAcksSent(n int)
 }
 
 func NewHeartbeatService(config HeartbeatConfig, statusProvider ports.TaskHandler, metricsProvider ports.MetricsProvider) *HeartbeatService {
@@ -48,9 +95,19 @@ func NewHeartbeatService(config HeartbeatConfig, statusProvider ports.TaskHandle
 		statusProvider:      statusProvider,
 		metricsProvider:     metricsProvider,
 		consecutiveFailures: 0,
+		clock:               clock.Real(),
 	}
 }
 
+// SetClock replaces the clock used for retry delays, uptime and timestamps.
+// The periodic schedule itself is driven by gocron.
+func (h *HeartbeatService) SetClock(c clock.Clock) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clock = c
+	h.startTime = c.Now()
+}
+
 func (h *HeartbeatService) Start() error {
 	h.mu.Lock()
 	if h.started {
@@ -97,14 +154,22 @@ func (h *HeartbeatService) heartbeatTask() {
 		if backoff > h.config.MaxBackoff {
 			backoff = h.config.MaxBackoff
 		}
+		failures := h.consecutiveFailures
+		reporter, bus := h.reporter, h.events
 		h.mu.Unlock()
 
 		log.Warn().
 			Err(err).
-			Int("consecutive_failures", h.consecutiveFailures).
+			Int("consecutive_failures", failures).
 			Dur("next_retry", backoff).
 			Bool("is_processing", isProcessing).
 			Msg("Heartbeat failed, will retry with backoff")
+		reporter.Report(errreport.Event{
+			Code:     "heartbeat_failed",
+			Category: errreport.CategoryHeartbeat,
+			Err:      err,
+		})
+		bus.Publish(events.ServerUnreachable{Error: err.Error(), ConsecutiveFailures: failures})
 
 		h.mu.Lock()
 		if h.job != nil && backoff != h.config.BaseInterval {
@@ -147,7 +212,7 @@ func (h *HeartbeatService) sendHeartbeatWithRetry() error {
 		if err := h.sendHeartbeat(); err != nil {
 			lastErr = err
 			if attempt < h.config.MaxRetries {
-				time.Sleep(time.Duration(attempt) * time.Second)
+				h.clock.Sleep(time.Duration(attempt) * time.Second)
 				continue
 			}
 		} else {
@@ -161,13 +226,45 @@ func (h *HeartbeatService) sendHeartbeat() error {
 	log := gologger.WithComponent("heartbeat")
 
 	type HeartbeatPayload struct {
-		WalletAddress string              `json:"wallet_address"`
-		Status        models.RunnerStatus `json:"status"`
-		Timestamp     int64               `json:"timestamp"`
-		Uptime        int64               `json:"uptime"`
-		Memory        int64               `json:"memory_usage"`
-		CPU           float64             `json:"cpu_usage"`
-		PublicIP      string              `json:"public_ip,omitempty"`
+		WalletAddress string                       `json:"wallet_address"`
+		Status        models.RunnerStatus          `json:"status"`
+		Timestamp     int64                        `json:"timestamp"`
+		Uptime        int64                        `json:"uptime"`
+		Memory        int64                        `json:"memory_usage"`
+		CPU           float64                      `json:"cpu_usage"`
+		PublicIP      string                       `json:"public_ip,omitempty"`
+		Network       *hardware.NetworkProfile     `json:"network,omitempty"`
+		LLMStats      []models.LLMModelStats       `json:"llm_stats,omitempty"`
+		ConfigVersion int64                        `json:"config_overlay_version,omitempty"`
+		GPU           *models.GPUCapacity          `json:"gpu,omitempty"`
+		Power         *models.PowerState           `json:"power,omitempty"`
+		Audit         *models.AuditCommitment      `json:"audit_commitment,omitempty"`
+		Guard         *models.ExecutionGuardStats  `json:"execution_guard,omitempty"`
+		Hints         *models.FleetHintStats       `json:"fleet_hints,omitempty"`
+		Budget        *models.ComputeBudget        `json:"compute_budget,omitempty"`
+		Instance      *models.InstanceStats        `json:"instance,omitempty"`
+		Unsupported   map[models.TaskType]uint64   `json:"unsupported_tasks,omitempty"`
+		Deletions     []models.TaskDataDeletionAck `json:"deleted_task_data,omitempty"`
+		Build         string                       `json:"build_fingerprint,omitempty"`
+	}
+
+	h.mu.Lock()
+	overlays := h.overlays
+	audits := h.audits
+	deletions := h.deletions
+	gpuSource := h.gpu
+	powerSource := h.power
+	guardSource := h.executionGuard
+	hintSource := h.fleetHints
+	budgetSource := h.budget
+	instanceSource := h.instance
+	unsupportedSource := h.unsupported
+	h.mu.Unlock()
+
+	var configVersion int64
+	if overlays != nil {
+		overlays.Expire()
+		configVersion = overlays.AppliedVersion()
 	}
 
 	status := models.RunnerStatusOnline
@@ -180,11 +277,42 @@ func (h *HeartbeatService) sendHeartbeat() error {
 	payload := HeartbeatPayload{
 		WalletAddress: h.config.WalletAddress,
 		Status:        status,
-		Timestamp:     time.Now().Unix(),
-		Uptime:        int64(time.Since(h.startTime).Seconds()),
+		Timestamp:     h.clock.Now().Unix(),
+		Uptime:        int64(clock.Since(h.clock, h.startTime).Seconds()),
 		Memory:        memory,
 		CPU:           cpu,
 		PublicIP:      utils.GetWebhookURL(),
+		Network:       h.NetworkProfile(),
+		LLMStats:      h.llmStatsSnapshot(),
+		ConfigVersion: configVersion,
+		Build:         provenance.Current().Fingerprint,
+	}
+	if gpuSource != nil {
+		payload.GPU = gpuSource()
+	}
+	if powerSource != nil {
+		payload.Power = powerSource()
+	}
+	if audits != nil {
+		payload.Audit = audits.AuditCommitment()
+	}
+	if guardSource != nil {
+		payload.Guard = guardSource()
+	}
+	if hintSource != nil {
+		payload.Hints = hintSource()
+	}
+	if budgetSource != nil {
+		payload.Budget = budgetSource()
+	}
+	if instanceSource != nil {
+		payload.Instance = instanceSource()
+	}
+	if unsupportedSource != nil {
+		payload.Unsupported = unsupportedSource()
+	}
+	if deletions != nil {
+		payload.Deletions = deletions.DeletionAcks()
 	}
 
 	payloadBytes, err := json.Marshal(payload)
@@ -202,13 +330,11 @@ func (h *HeartbeatService) sendHeartbeat() error {
 	req.Header.Set("User-Agent", "ParityRunner/1.0")
 	req.Header.Set("X-Device-ID", h.config.DeviceID)
 
+	transport := dualstack.Transport()
+	transport.DisableCompression = true
 	client := &http.Client{
-		Timeout: 5 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:       100,
-			IdleConnTimeout:    90 * time.Second,
-			DisableCompression: true,
-		},
+		Timeout:   5 * time.Second,
+		Transport: transport,
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -226,6 +352,17 @@ func (h *HeartbeatService) sendHeartbeat() error {
 		return fmt.Errorf("heartbeat request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
+	h.mu.Lock()
+	h.lastSuccess = h.clock.Now()
+	h.mu.Unlock()
+
+	if deletions != nil && len(payload.Deletions) > 0 {
+		deletions.DeletionAcksSent(len(payload.Deletions))
+	}
+	if overlays != nil || audits != nil || deletions != nil {
+		h.handleResponse(overlays, audits, deletions, resp.Body)
+	}
+
 	log.Debug().
 		Str("device_id", h.config.DeviceID).
 		Str("status", string(status)).
@@ -236,6 +373,36 @@ func (h *HeartbeatService) sendHeartbeat() error {
 	return nil
 }
 
+// handleResponse applies the configuration overlay, starts answering the
+// audit challenge and carries out the task data deletions in a heartbeat
+// response body, if it carries any
+func (h *HeartbeatService) handleResponse(overlays OverlayHandler, audits AuditHandler, deletions DeletionHandler, body io.Reader) {
+	log := gologger.WithComponent("heartbeat")
+
+	var response struct {
+		ConfigOverlay  *models.ConfigOverlay     `json:"config_overlay"`
+		AuditChallenge *models.AuditChallenge    `json:"audit_challenge"`
+		DeleteTaskData []models.TaskDataDeletion `json:"delete_task_data"`
+	}
+	if err := json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&response); err != nil {
+		return
+	}
+	if overlays != nil && response.ConfigOverlay != nil {
+		if err := overlays.Apply(response.ConfigOverlay); err != nil {
+			log.Warn().Err(err).Msg("Rejected fleet configuration overlay")
+		}
+	}
+	// Re-executing a task takes far longer than a heartbeat
+	if audits != nil && response.AuditChallenge != nil {
+		go audits.HandleAuditChallenge(response.AuditChallenge)
+	}
+	if deletions != nil {
+		for i := range response.DeleteTaskData {
+			deletions.DeleteTaskData(&response.DeleteTaskData[i])
+		}
+	}
+}
+
 func (h *HeartbeatService) Stop() {
 	h.mu.Lock()
 	if !h.started {
@@ -254,6 +421,151 @@ func (h *HeartbeatService) Stop() {
 	log.Info().Msg("Heartbeat service stopped successfully")
 }
 
+// LastSuccess returns when a heartbeat was last accepted by the server
+func (h *HeartbeatService) LastSuccess() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastSuccess
+}
+
+// ConsecutiveFailures returns the number of heartbeats that have failed
+// since one was last accepted
+func (h *HeartbeatService) ConsecutiveFailures() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.consecutiveFailures
+}
+
+// SetNetworkProfile includes the latest bandwidth measurement in heartbeats
+func (h *HeartbeatService) SetNetworkProfile(profile *hardware.NetworkProfile) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.network = profile
+}
+
+// SetLLMStatsSource includes per-model serving statistics from source in heartbeats
+func (h *HeartbeatService) SetLLMStatsSource(source func() []models.LLMModelStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.llmStats = source
+}
+
+// SetGPUSource includes GPU memory and the reservations on it from source
+// in heartbeats
+func (h *HeartbeatService) SetGPUSource(source func() *models.GPUCapacity) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.gpu = source
+}
+
+// SetPowerSource includes the power source, thermal state and the scheduling
+// mode they put the runner in from source in heartbeats
+func (h *HeartbeatService) SetPowerSource(source func() *models.PowerState) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.power = source
+}
+
+// SetExecutionGuardSource includes the decisions of the fleet execution
+// guard from source in heartbeats
+func (h *HeartbeatService) SetExecutionGuardSource(source func() *models.ExecutionGuardStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.executionGuard = source
+}
+
+// SetFleetHintSource includes the fleet hints published and heeded from
+// source in heartbeats
+func (h *HeartbeatService) SetFleetHintSource(source func() *models.FleetHintStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.fleetHints = source
+}
+
+// SetBudgetSource includes compute budget consumption from source in
+// heartbeats
+func (h *HeartbeatService) SetBudgetSource(source func() *models.ComputeBudget) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.budget = source
+}
+
+// SetInstanceSource labels heartbeats with the logical runner they are for,
+// and its task counts, from source
+func (h *HeartbeatService) SetInstanceSource(source func() *models.InstanceStats) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.instance = source
+}
+
+// SetUnsupportedSource includes, from source, how many tasks of types the
+// runner does not run it was offered, by type
+func (h *HeartbeatService) SetUnsupportedSource(source func() map[models.TaskType]uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.unsupported = source
+}
+
+// SetOverlayHandler applies configuration overlays from heartbeat responses
+// with handler and reports the version in effect in each heartbeat
+func (h *HeartbeatService) SetOverlayHandler(handler OverlayHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.overlays = handler
+}
+
+// SetAuditHandler publishes the commitment to completed tasks from handler
+// in heartbeats and passes it the audit challenges responses carry
+func (h *HeartbeatService) SetAuditHandler(handler AuditHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.audits = handler
+}
+
+// SetDeletionHandler carries out the task data deletions heartbeat
+// responses carry with handler, and acknowledges them in heartbeats
+func (h *HeartbeatService) SetDeletionHandler(handler DeletionHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.deletions = handler
+}
+
+// SetErrorReporter shares failed heartbeats through reporter
+func (h *HeartbeatService) SetErrorReporter(reporter *errreport.Reporter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.reporter = reporter
+}
+
+// SetEventBus publishes ServerUnreachable on bus for each failed heartbeat
+func (h *HeartbeatService) SetEventBus(bus *events.Bus) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.events = bus
+}
+
+func (h *HeartbeatService) llmStatsSnapshot() []models.LLMModelStats {
+	h.mu.Lock()
+	source := h.llmStats
+	h.mu.Unlock()
+	if source == nil {
+		return nil
+	}
+	return source()
+}
+
+func (h *HeartbeatService) NetworkProfile() *hardware.NetworkProfile {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.network
+}
+
+func (h *HeartbeatService) Interval() time.Duration {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.config.BaseInterval
+}
+
 func (h *HeartbeatService) SetInterval(interval time.Duration) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -283,8 +595,8 @@ func (h *HeartbeatService) SendOfflineHeartbeat(ctx context.Context) error {
 	payload := HeartbeatPayload{
 		WalletAddress: h.config.WalletAddress,
 		Status:        models.RunnerStatusOffline,
-		Timestamp:     time.Now().Unix(),
-		Uptime:        int64(time.Since(h.startTime).Seconds()),
+		Timestamp:     h.clock.Now().Unix(),
+		Uptime:        int64(clock.Since(h.clock, h.startTime).Seconds()),
 		Memory:        memory,
 		CPU:           cpu,
 	}
@@ -317,13 +629,11 @@ func (h *HeartbeatService) SendOfflineHeartbeat(ctx context.Context) error {
 	req.Header.Set("User-Agent", "ParityRunner/1.0")
 	req.Header.Set("X-Device-ID", h.config.DeviceID)
 
+	transport := dualstack.Transport()
+	transport.DisableCompression = true
 	client := &http.Client{
-		Timeout: 5 * time.Second,
-		Transport: &http.Transport{
-			MaxIdleConns:       100,
-			IdleConnTimeout:    90 * time.Second,
-			DisableCompression: true,
-		},
+		Timeout:   5 * time.Second,
+		Transport: transport,
 	}
 
 	req = req.WithContext(ctx)
@@ -8,14 +8,24 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/theblitlabs/gologger"
 
+	"github.com/theblitlabs/parity-runner/internal/blobcache"
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/clock"
 	"github.com/theblitlabs/parity-runner/internal/core/models"
 	"github.com/theblitlabs/parity-runner/internal/core/ports"
+	"github.com/theblitlabs/parity-runner/internal/errreport"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/health"
 	"github.com/theblitlabs/parity-runner/internal/messaging/heartbeat"
+	"github.com/theblitlabs/parity-runner/internal/provenance"
+	"github.com/theblitlabs/parity-runner/internal/status"
 	"github.com/theblitlabs/parity-runner/internal/utils"
 )
 
@@ -40,14 +50,33 @@ type WebhookClient struct {
 	completedTasks     map[string]time.Time
 	lastCleanupTime    time.Time
 	completedTasksLock sync.RWMutex
+	clock              clock.Clock
 	heartbeat          *heartbeat.HeartbeatService
 	modelCapabilities  []ModelCapabilityInfo
+	hardwareProfile    *hardware.Profile
+	networkProfile     *hardware.NetworkProfile
+	healthChecker      *health.Checker
+	llmStats           func() []models.LLMModelStats
+	budget             func() *models.ComputeBudget
+	gpu                func() *models.GPUCapacity
+	caches             *caches.Registry
+	blobs              *blobcache.Fetcher
+	status             status.Controller
+	attestation        func() (*models.AttestationEvidence, error)
+	capabilities       func() *models.RunnerCapabilities
+	listener           net.Listener
+	// profileVersion is the capability profile version the server holds,
+	// and profile the profile it holds at that version
+	profileVersion int64
+	profile        models.CapabilityProfile
 }
 
 type ModelCapabilityInfo struct {
-	ModelName string `json:"model_name"`
-	IsLoaded  bool   `json:"is_loaded"`
-	MaxTokens int    `json:"max_tokens"`
+	ModelName  string `json:"model_name"`
+	IsLoaded   bool   `json:"is_loaded"`
+	MaxTokens  int    `json:"max_tokens"`
+	SizeBytes  int64  `json:"size_bytes,omitempty"`
+	FitsMemory bool   `json:"fits_memory"`
 }
 
 func NewWebhookClient(serverURL string, serverPort int, handler ports.TaskHandler, runnerID, deviceID, walletAddress string) *WebhookClient {
@@ -62,6 +91,7 @@ func NewWebhookClient(serverURL string, serverPort int, handler ports.TaskHandle
 		serverPort:      serverPort,
 		completedTasks:  make(map[string]time.Time),
 		lastCleanupTime: time.Now(),
+		clock:           clock.Real(),
 	}
 
 	heartbeatConfig := heartbeat.HeartbeatConfig{
@@ -78,6 +108,18 @@ func NewWebhookClient(serverURL string, serverPort int, handler ports.TaskHandle
 	return client
 }
 
+// SetClock replaces the clock used for completed-task cleanup and heartbeats
+func (w *WebhookClient) SetClock(c clock.Clock) {
+	w.completedTasksLock.Lock()
+	w.clock = c
+	w.lastCleanupTime = c.Now()
+	w.completedTasksLock.Unlock()
+
+	if w.heartbeat != nil {
+		w.heartbeat.SetClock(c)
+	}
+}
+
 func (w *WebhookClient) SetHeartbeatInterval(interval time.Duration) {
 	if w.heartbeat != nil {
 		w.heartbeat.SetInterval(interval)
@@ -95,19 +137,50 @@ func (w *WebhookClient) Start() error {
 
 	log := gologger.WithComponent("webhook")
 
-	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", w.serverPort))
-	if err != nil {
-		return fmt.Errorf("webhook port %d is not available: %w", w.serverPort, err)
+	w.mu.Lock()
+	ln := w.listener
+	w.mu.Unlock()
+	if ln == nil {
+		var err error
+		ln, err = net.Listen("tcp", fmt.Sprintf(":%d", w.serverPort))
+		if err != nil {
+			w.mu.Lock()
+			w.started = false
+			w.mu.Unlock()
+			return fmt.Errorf("webhook port %d is not available: %w", w.serverPort, err)
+		}
 	}
-	ln.Close()
 
 	if err := w.Register(); err != nil {
+		ln.Close()
+		w.mu.Lock()
+		w.started = false
+		w.listener = nil
+		w.mu.Unlock()
 		log.Error().Err(err).Msg("Webhook registration failed")
 		return fmt.Errorf("webhook registration failed: %w", err)
 	}
 
+	w.mu.Lock()
+	w.listener = ln
+	w.mu.Unlock()
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/webhook", w.handleWebhook)
+	mux.HandleFunc("/runner/llm-stats", w.handleLLMStats)
+	mux.HandleFunc("/runner/budget", w.handleBudget)
+	if w.healthChecker != nil {
+		w.healthChecker.RegisterHandlers(mux)
+	}
+	if w.caches != nil {
+		w.caches.RegisterHandlers(mux)
+	}
+	if w.blobs != nil {
+		w.blobs.RegisterHandlers(mux)
+	}
+	if w.status != nil {
+		status.RegisterHandlers(mux, w.status)
+	}
 
 	w.server = &http.Server{
 		Addr:    fmt.Sprintf(":%d", w.serverPort),
@@ -123,7 +196,7 @@ func (w *WebhookClient) Start() error {
 	}
 
 	go func() {
-		if err := w.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		if err := w.server.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Error().Err(err).Msg("Webhook server error")
 		}
 	}()
@@ -138,6 +211,7 @@ func (w *WebhookClient) Stop() error {
 		return nil
 	}
 	w.started = false
+	w.listener = nil
 	w.mu.Unlock()
 
 	log := gologger.WithComponent("webhook")
@@ -171,6 +245,53 @@ func (w *WebhookClient) Stop() error {
 	return nil
 }
 
+// SetListener serves the webhook on ln, typically a socket inherited from
+// the runner process this one replaced, instead of listening on the port
+func (w *WebhookClient) SetListener(ln net.Listener) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.listener = ln
+}
+
+// Handoff stops serving and heartbeats without going offline or
+// unregistering, for a runner process that takes over the registration. It
+// returns a duplicate of the listening socket for that process to serve on;
+// connections arriving meanwhile wait in the socket backlog.
+func (w *WebhookClient) Handoff(ctx context.Context) (*os.File, error) {
+	w.mu.Lock()
+	if !w.started {
+		w.mu.Unlock()
+		return nil, fmt.Errorf("webhook client not started")
+	}
+	tcpListener, ok := w.listener.(*net.TCPListener)
+	if !ok {
+		w.mu.Unlock()
+		return nil, fmt.Errorf("webhook listener cannot be handed off")
+	}
+	w.started = false
+	w.listener = nil
+	w.mu.Unlock()
+
+	log := gologger.WithComponent("webhook")
+
+	file, err := tcpListener.File()
+	if err != nil {
+		return nil, fmt.Errorf("failed to duplicate webhook listener: %w", err)
+	}
+
+	if w.heartbeat != nil {
+		w.heartbeat.Stop()
+	}
+	if w.server != nil {
+		if err := w.server.Shutdown(ctx); err != nil {
+			log.Warn().Err(err).Msg("Webhook server did not finish in-flight requests before handoff")
+		}
+	}
+
+	log.Info().Msg("Webhook listener handed off")
+	return file, nil
+}
+
 func (w *WebhookClient) UnregisterWithContext(ctx context.Context) error {
 	log := gologger.WithComponent("webhook")
 	if w.webhookID == "" {
@@ -204,12 +325,13 @@ func (w *WebhookClient) cleanupCompletedTasks() {
 	w.completedTasksLock.Lock()
 	defer w.completedTasksLock.Unlock()
 
-	if time.Since(w.lastCleanupTime) < time.Hour {
+	now := w.clock.Now()
+	if now.Sub(w.lastCleanupTime) < time.Hour {
 		return
 	}
 
-	cutoff := time.Now().Add(-24 * time.Hour)
-	inProgressCutoff := time.Now().Add(-1 * time.Hour)
+	cutoff := now.Add(-24 * time.Hour)
+	inProgressCutoff := now.Add(-1 * time.Hour)
 
 	for taskID, completedAt := range w.completedTasks {
 		if !completedAt.IsZero() {
@@ -222,7 +344,7 @@ func (w *WebhookClient) cleanupCompletedTasks() {
 			}
 		}
 	}
-	w.lastCleanupTime = time.Now()
+	w.lastCleanupTime = now
 }
 
 func (w *WebhookClient) isTaskCompleted(taskID string) bool {
@@ -235,7 +357,7 @@ func (w *WebhookClient) isTaskCompleted(taskID string) bool {
 func (w *WebhookClient) markTaskCompleted(taskID string) {
 	w.completedTasksLock.Lock()
 	defer w.completedTasksLock.Unlock()
-	w.completedTasks[taskID] = time.Now()
+	w.completedTasks[taskID] = w.clock.Now()
 }
 
 func (w *WebhookClient) markTaskStarted(taskID string) bool {
@@ -369,29 +491,350 @@ func (w *WebhookClient) SetModelCapabilities(capabilities []ModelCapabilityInfo)
 	w.modelCapabilities = capabilities
 }
 
-func (w *WebhookClient) Register() error {
-	log := gologger.WithComponent("webhook")
+// SetHealthChecker exposes /healthz and /readyz alongside the webhook endpoint
+func (w *WebhookClient) SetHealthChecker(checker *health.Checker) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.healthChecker = checker
+}
 
-	w.webhookURL = utils.GetWebhookURL()
-	log.Debug().Str("webhook_url", w.webhookURL).Msg("Generated webhook URL")
+// SetCacheRegistry exposes cache administration under /runner/caches
+func (w *WebhookClient) SetCacheRegistry(registry *caches.Registry) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.caches = registry
+}
+
+// SetBlobServer serves cached image tarballs to fleet peers under
+// /runner/blobs
+func (w *WebhookClient) SetBlobServer(fetcher *blobcache.Fetcher) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.blobs = fetcher
+}
+
+// SetStatusController exposes the runner's status and operator controls
+// under /runner
+func (w *WebhookClient) SetStatusController(controller status.Controller) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.status = controller
+}
+
+// SetAttestationSource includes evidence from source in registrations. A
+// failing source is logged and the runner registers without evidence.
+func (w *WebhookClient) SetAttestationSource(source func() (*models.AttestationEvidence, error)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.attestation = source
+}
+
+// SetLLMStatsSource publishes per-model serving statistics from source in
+// heartbeats and on /runner/llm-stats
+func (w *WebhookClient) SetLLMStatsSource(source func() []models.LLMModelStats) {
+	w.mu.Lock()
+	w.llmStats = source
+	w.mu.Unlock()
+
+	if w.heartbeat != nil {
+		w.heartbeat.SetLLMStatsSource(source)
+	}
+}
+
+func (w *WebhookClient) handleLLMStats(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(resp, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.mu.Lock()
+	source := w.llmStats
+	w.mu.Unlock()
+
+	stats := []models.LLMModelStats{}
+	if source != nil {
+		stats = source()
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(resp).Encode(map[string]interface{}{"models": stats}); err != nil {
+		log := gologger.WithComponent("webhook")
+		log.Error().Err(err).Msg("Failed to write LLM stats")
+	}
+}
+
+// SetBudgetSource publishes compute budget consumption from source in
+// heartbeats and on /runner/budget
+func (w *WebhookClient) SetBudgetSource(source func() *models.ComputeBudget) {
+	w.mu.Lock()
+	w.budget = source
+	w.mu.Unlock()
+
+	if w.heartbeat != nil {
+		w.heartbeat.SetBudgetSource(source)
+	}
+}
+
+func (w *WebhookClient) handleBudget(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(resp, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.mu.Lock()
+	source := w.budget
+	w.mu.Unlock()
+
+	if source == nil {
+		http.Error(resp, "no compute budget configured", http.StatusNotFound)
+		return
+	}
+
+	resp.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(resp).Encode(source()); err != nil {
+		log := gologger.WithComponent("webhook")
+		log.Error().Err(err).Msg("Failed to write compute budget")
+	}
+}
+
+// SetGPUSource publishes GPU memory and the VRAM reserved by running tasks
+// from source at registration and in heartbeats
+func (w *WebhookClient) SetGPUSource(source func() *models.GPUCapacity) {
+	w.mu.Lock()
+	w.gpu = source
+	w.mu.Unlock()
+
+	if w.heartbeat != nil {
+		w.heartbeat.SetGPUSource(source)
+	}
+}
+
+// SetPowerSource publishes the power and thermal state from source in
+// heartbeats
+func (w *WebhookClient) SetPowerSource(source func() *models.PowerState) {
+	if w.heartbeat != nil {
+		w.heartbeat.SetPowerSource(source)
+	}
+}
+
+// SetExecutionGuardSource publishes the fleet execution guard's decisions
+// from source in heartbeats
+func (w *WebhookClient) SetExecutionGuardSource(source func() *models.ExecutionGuardStats) {
+	if w.heartbeat != nil {
+		w.heartbeat.SetExecutionGuardSource(source)
+	}
+}
+
+// SetFleetHintSource publishes the fleet hints published and heeded from
+// source in heartbeats
+func (w *WebhookClient) SetFleetHintSource(source func() *models.FleetHintStats) {
+	if w.heartbeat != nil {
+		w.heartbeat.SetFleetHintSource(source)
+	}
+}
+
+// SetCapabilitiesSource registers with the task types, schema versions and
+// features source reports
+func (w *WebhookClient) SetCapabilitiesSource(source func() *models.RunnerCapabilities) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.capabilities = source
+}
+
+// SetUnsupportedSource publishes, from source, how many tasks of types the
+// runner does not run it was offered, by type, in heartbeats
+func (w *WebhookClient) SetUnsupportedSource(source func() map[models.TaskType]uint64) {
+	if w.heartbeat != nil {
+		w.heartbeat.SetUnsupportedSource(source)
+	}
+}
+
+// SetInstanceSource publishes the logical runner's name and task counts from
+// source in heartbeats
+func (w *WebhookClient) SetInstanceSource(source func() *models.InstanceStats) {
+	if w.heartbeat != nil {
+		w.heartbeat.SetInstanceSource(source)
+	}
+}
+
+// SetDeletionHandler carries out the task data deletions the server sends
+// in heartbeat responses with handler, which acknowledges them in heartbeats
+func (w *WebhookClient) SetDeletionHandler(handler heartbeat.DeletionHandler) {
+	if w.heartbeat != nil {
+		w.heartbeat.SetDeletionHandler(handler)
+	}
+}
+
+// SetAuditHandler publishes audit commitments in heartbeats and answers
+// audit challenges with handler
+func (w *WebhookClient) SetAuditHandler(handler heartbeat.AuditHandler) {
+	if w.heartbeat != nil {
+		w.heartbeat.SetAuditHandler(handler)
+	}
+}
+
+// SetErrorReporter shares failed heartbeats through reporter
+func (w *WebhookClient) SetErrorReporter(reporter *errreport.Reporter) {
+	if w.heartbeat != nil {
+		w.heartbeat.SetErrorReporter(reporter)
+	}
+}
+
+// SetEventBus publishes ServerUnreachable on bus for each failed heartbeat
+func (w *WebhookClient) SetEventBus(bus *events.Bus) {
+	if w.heartbeat != nil {
+		w.heartbeat.SetEventBus(bus)
+	}
+}
 
-	type RegisterPayload struct {
-		WalletAddress     string                `json:"wallet_address"`
-		Status            models.RunnerStatus   `json:"status"`
-		Webhook           string                `json:"webhook"`
-		ModelCapabilities []ModelCapabilityInfo `json:"model_capabilities,omitempty"`
+// SetOverlayHandler applies fleet configuration overlays delivered in
+// heartbeat responses
+func (w *WebhookClient) SetOverlayHandler(handler heartbeat.OverlayHandler) {
+	if w.heartbeat != nil {
+		w.heartbeat.SetOverlayHandler(handler)
 	}
+}
 
+// Heartbeat returns the heartbeat service used to judge server reachability
+func (w *WebhookClient) Heartbeat() *heartbeat.HeartbeatService {
+	return w.heartbeat
+}
+
+func (w *WebhookClient) SetHardwareProfile(profile *hardware.Profile) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.hardwareProfile = profile
+}
+
+// SetNetworkProfile records measured bandwidth for registration and heartbeats
+func (w *WebhookClient) SetNetworkProfile(profile *hardware.NetworkProfile) {
+	w.mu.Lock()
+	w.networkProfile = profile
+	w.mu.Unlock()
+
+	if w.heartbeat != nil {
+		w.heartbeat.SetNetworkProfile(profile)
+	}
+}
+
+type registerPayload struct {
+	WalletAddress     string                      `json:"wallet_address"`
+	Status            models.RunnerStatus         `json:"status"`
+	Webhook           string                      `json:"webhook"`
+	ModelCapabilities []ModelCapabilityInfo       `json:"model_capabilities,omitempty"`
+	Accelerator       string                      `json:"accelerator,omitempty"`
+	Hardware          *hardware.Profile           `json:"hardware,omitempty"`
+	Network           *hardware.NetworkProfile    `json:"network,omitempty"`
+	Attestation       *models.AttestationEvidence `json:"attestation,omitempty"`
+	GPU               *models.GPUCapacity         `json:"gpu,omitempty"`
+	Capabilities      *models.RunnerCapabilities  `json:"capabilities,omitempty"`
+	// CapabilitiesVersion is the version of the capability profile the
+	// registration carries, which later capability patches start from
+	CapabilitiesVersion int64 `json:"capabilities_version"`
+	// Build describes the runner binary, whose fingerprint tells official
+	// builds from modified ones
+	Build *models.BuildProvenance `json:"build,omitempty"`
+}
+
+// registration returns what the runner registers with, but for its
+// attestation evidence
+func (w *WebhookClient) registration() *registerPayload {
 	w.mu.Lock()
 	capabilities := make([]ModelCapabilityInfo, len(w.modelCapabilities))
 	copy(capabilities, w.modelCapabilities)
+	hardwareProfile := w.hardwareProfile
+	networkProfile := w.networkProfile
+	gpuSource := w.gpu
+	capabilitiesSource := w.capabilities
 	w.mu.Unlock()
 
-	payload := RegisterPayload{
+	payload := &registerPayload{
 		WalletAddress:     w.walletAddress,
 		Status:            models.RunnerStatusOnline,
 		Webhook:           w.webhookURL,
 		ModelCapabilities: capabilities,
+		Hardware:          hardwareProfile,
+		Network:           networkProfile,
+	}
+	build := provenance.Current()
+	payload.Build = &build
+	if hardwareProfile != nil {
+		payload.Accelerator = string(hardwareProfile.Accelerator)
+	}
+	if gpuSource != nil {
+		payload.GPU = gpuSource()
+	}
+	if capabilitiesSource != nil {
+		payload.Capabilities = capabilitiesSource()
+	}
+	return payload
+}
+
+// profile returns the fields of the registration that change while the
+// runner runs, leaving out those it registered without
+func (p *registerPayload) profile() models.CapabilityProfile {
+	fields := map[string]interface{}{
+		"model_capabilities": p.ModelCapabilities,
+		"network":            p.Network,
+		"gpu":                p.GPU,
+		"capabilities":       p.Capabilities,
+	}
+	profile := make(models.CapabilityProfile, len(fields))
+	for name, value := range fields {
+		data, err := json.Marshal(value)
+		if err != nil || string(data) == "null" {
+			continue
+		}
+		profile[name] = data
+	}
+	return profile
+}
+
+// CapabilityProfile returns the runner's capability profile as it is now
+func (w *WebhookClient) CapabilityProfile() models.CapabilityProfile {
+	return w.registration().profile()
+}
+
+// AcknowledgedProfile returns the capability profile version the server
+// holds and the profile at that version, which is nil until the runner
+// registered
+func (w *WebhookClient) AcknowledgedProfile() (int64, models.CapabilityProfile) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.profileVersion, w.profile
+}
+
+// AcknowledgeProfile records that the server holds profile at version
+func (w *WebhookClient) AcknowledgeProfile(version int64, profile models.CapabilityProfile) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.profileVersion = version
+	w.profile = profile
+}
+
+// Register registers the runner in full, with a capability profile
+// version past the last one the server acknowledged
+func (w *WebhookClient) Register() error {
+	log := gologger.WithComponent("webhook")
+
+	w.webhookURL = utils.GetWebhookURL()
+	log.Debug().Str("webhook_url", w.webhookURL).Msg("Generated webhook URL")
+
+	w.mu.Lock()
+	attestationSource := w.attestation
+	version := w.profileVersion + 1
+	w.mu.Unlock()
+
+	payload := w.registration()
+	payload.CapabilitiesVersion = version
+	capabilities := payload.ModelCapabilities
+	if attestationSource != nil {
+		evidence, err := attestationSource()
+		if err != nil {
+			log.Warn().Err(err).Msg("Registering without attestation evidence")
+		} else {
+			payload.Attestation = evidence
+		}
 	}
 
 	registerURL := fmt.Sprintf("%s/api/v1/runners", w.serverURL)
@@ -440,6 +883,7 @@ func (w *WebhookClient) Register() error {
 	}
 
 	w.webhookID = response.WebhookID
+	w.AcknowledgeProfile(version, payload.profile())
 
 	log.Debug().
 		Str("device_id", w.deviceID).
@@ -0,0 +1,370 @@
+// Package metricspush pushes aggregate runner metrics to a Prometheus
+// remote-write endpoint or pushgateway, for runners behind NAT that cannot
+// be scraped. Only allowlisted series and labels leave the runner, so that
+// nothing identifying a task reaches a third-party aggregator.
+package metricspush
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+)
+
+// Series the runner exports
+const (
+	RunnerUp        = "parity_runner_up"
+	TasksClaimed    = "parity_runner_tasks_claimed_total"
+	TasksDeclined   = "parity_runner_tasks_declined_total"
+	TasksCompleted  = "parity_runner_tasks_completed_total"
+	TasksFailed     = "parity_runner_tasks_failed_total"
+	TasksInProgress = "parity_runner_tasks_in_progress"
+	LLMQueueDepth   = "parity_runner_llm_queue_depth"
+	LLMModelsLoaded = "parity_runner_llm_models_loaded"
+	EventsDropped   = "parity_runner_events_dropped_total"
+	// ConcurrencyLimit is how many tasks the runner runs at once, and the
+	// totals count the times it raised and cut the limit
+	ConcurrencyLimit     = "parity_runner_concurrency_limit"
+	ConcurrencyIncreases = "parity_runner_concurrency_increases_total"
+	ConcurrencyDecreases = "parity_runner_concurrency_decreases_total"
+	// RegistryMirrorHits counts image pulls a registry mirror served and
+	// RegistryMirrorFallbacks those that went to the registry instead
+	RegistryMirrorHits      = "parity_runner_registry_mirror_hits_total"
+	RegistryMirrorFallbacks = "parity_runner_registry_mirror_fallbacks_total"
+	RegistryMirrorsHealthy  = "parity_runner_registry_mirrors_healthy"
+	// ImageBlobPeerHits counts image tarballs fetched from fleet peers and
+	// ImageBlobOriginFetches those downloaded from their URL
+	ImageBlobPeerHits      = "parity_runner_image_blob_peer_hits_total"
+	ImageBlobOriginFetches = "parity_runner_image_blob_origin_fetches_total"
+)
+
+// Labels of pushed series
+const (
+	MetricNameLabel = "__name__"
+	InstanceLabel   = "instance"
+	JobLabel        = "job"
+	// RunnerLabel names the logical runner when a process runs several
+	RunnerLabel = "runner"
+	// ModelLabel names the LLM model, dropped unless allowed
+	ModelLabel = "model"
+	// SubscriberLabel names the event subscriber, dropped unless allowed
+	SubscriberLabel = "subscriber"
+)
+
+const defaultBatchSize = 500
+
+// DefaultSeries are exported when no series are configured
+var DefaultSeries = []string{
+	RunnerUp,
+	TasksClaimed,
+	TasksDeclined,
+	TasksCompleted,
+	TasksFailed,
+	TasksInProgress,
+	LLMQueueDepth,
+	LLMModelsLoaded,
+	EventsDropped,
+	ConcurrencyLimit,
+	ConcurrencyIncreases,
+	ConcurrencyDecreases,
+	RegistryMirrorHits,
+	RegistryMirrorFallbacks,
+	RegistryMirrorsHealthy,
+	ImageBlobPeerHits,
+	ImageBlobOriginFetches,
+}
+
+// DefaultLabels are the labels samples keep when no labels are configured,
+// besides the instance and job every pushed series carries
+var DefaultLabels = []string{RunnerLabel}
+
+// Sample is one value of a series, as collected
+type Sample struct {
+	Name   string
+	Labels map[string]string
+	Value  float64
+}
+
+// Source collects the current samples. It is called from the pusher's own
+// goroutine and must not block.
+type Source func() []Sample
+
+// Label is a label of a pushed series
+type Label struct {
+	Name  string
+	Value string
+}
+
+// TimeSeries is one sample of a series as pushed, its labels sorted by name
+// and including the metric name
+type TimeSeries struct {
+	Labels    []Label
+	Value     float64
+	Timestamp time.Time
+}
+
+// Name returns the series' metric name
+func (ts TimeSeries) Name() string {
+	for _, l := range ts.Labels {
+		if l.Name == MetricNameLabel {
+			return l.Value
+		}
+	}
+	return ""
+}
+
+// InstanceID derives the instance label from a device ID. It is stable per
+// device but does not reveal the device ID the server knows the runner by.
+func InstanceID(deviceID string) string {
+	sum := sha256.Sum256([]byte("parity-runner-metrics:" + deviceID))
+	return "runner-" + hex.EncodeToString(sum[:8])
+}
+
+// Allowlist decides which series and labels are exported
+type Allowlist struct {
+	series map[string]bool
+	labels map[string]bool
+}
+
+// NewAllowlist exports the series and keeps the labels named, or
+// DefaultSeries and DefaultLabels when none are
+func NewAllowlist(series, labels []string) *Allowlist {
+	if len(series) == 0 {
+		series = DefaultSeries
+	}
+	if len(labels) == 0 {
+		labels = DefaultLabels
+	}
+	a := &Allowlist{series: make(map[string]bool), labels: make(map[string]bool)}
+	for _, name := range series {
+		if name = strings.TrimSpace(name); name != "" {
+			a.series[name] = true
+		}
+	}
+	for _, name := range labels {
+		if name = strings.TrimSpace(name); name != "" {
+			a.labels[name] = true
+		}
+	}
+	return a
+}
+
+// Filter drops the samples of series not allowed and the labels not
+// allowed. Samples left with the same labels are summed, which keeps counts
+// and queue depths meaningful once a label such as the model is dropped.
+func (a *Allowlist) Filter(samples []Sample) []Sample {
+	merged := make(map[string]*Sample)
+	var keys []string
+	for _, s := range samples {
+		if !a.series[s.Name] {
+			continue
+		}
+		labels := make(map[string]string)
+		for name, value := range s.Labels {
+			if a.labels[name] && name != InstanceLabel && name != JobLabel && value != "" {
+				labels[name] = value
+			}
+		}
+		key := seriesKey(s.Name, labels)
+		if m, ok := merged[key]; ok {
+			m.Value += s.Value
+			continue
+		}
+		merged[key] = &Sample{Name: s.Name, Labels: labels, Value: s.Value}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	filtered := make([]Sample, 0, len(keys))
+	for _, key := range keys {
+		filtered = append(filtered, *merged[key])
+	}
+	return filtered
+}
+
+func seriesKey(name string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	b.WriteString(name)
+	for _, label := range names {
+		b.WriteString("\x00" + label + "\x00" + labels[label])
+	}
+	return b.String()
+}
+
+// Target is where series are pushed
+type Target interface {
+	// Push sends series, returning an error wrapping ErrRejected when the
+	// target will never accept them
+	Push(ctx context.Context, series []TimeSeries) error
+	// KeepsHistory reports whether the target stores every sample pushed,
+	// making samples that failed to push worth sending later; otherwise
+	// only the latest samples are pushed
+	KeepsHistory() bool
+}
+
+// ErrRejected is returned by targets for series they will not accept
+// however often they are sent, such as a malformed request
+var ErrRejected = errors.New("metrics rejected")
+
+// Config tunes a Pusher
+type Config struct {
+	// Interval is how often samples are collected and pushed; after failed
+	// pushes it doubles up to MaxInterval
+	Interval    time.Duration
+	MaxInterval time.Duration
+	// Timeout bounds each push request, when set
+	Timeout time.Duration
+	// BatchSize is the most series sent in one request to targets that
+	// keep history
+	BatchSize int
+	// BufferSize is the most series kept for targets that keep history
+	// while pushes fail; the oldest are dropped first
+	BufferSize int
+}
+
+// Pusher periodically collects samples and pushes them to a target
+type Pusher struct {
+	target  Target
+	source  Source
+	allow   *Allowlist
+	labels  []Label
+	config  Config
+	clock   clock.Clock
+	pending []TimeSeries
+	backoff time.Duration
+}
+
+// NewPusher pushes the allowed samples of source to target, labelled with
+// instance and job
+func NewPusher(target Target, source Source, allow *Allowlist, instance, job string, config Config) *Pusher {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.MaxInterval < config.Interval {
+		config.MaxInterval = config.Interval
+	}
+	return &Pusher{
+		target: target,
+		source: source,
+		allow:  allow,
+		labels: []Label{{Name: InstanceLabel, Value: instance}, {Name: JobLabel, Value: job}},
+		config: config,
+		clock:  clock.Real(),
+	}
+}
+
+// SetClock replaces the clock samples are timestamped and pushes timed with
+func (p *Pusher) SetClock(c clock.Clock) {
+	p.clock = c
+}
+
+// Run pushes until ctx ends, backing off while pushes fail
+func (p *Pusher) Run(ctx context.Context) {
+	for {
+		wait := p.config.Interval
+		if err := p.Push(ctx); err != nil {
+			wait = p.backoff
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.clock.After(wait):
+		}
+	}
+}
+
+// Push collects the current samples and pushes them with any still pending
+// from failed pushes
+func (p *Pusher) Push(ctx context.Context) error {
+	log := gologger.WithComponent("metrics_push")
+
+	series := p.collect()
+	if p.target.KeepsHistory() {
+		p.pending = append(p.pending, series...)
+		if p.config.BufferSize > 0 && len(p.pending) > p.config.BufferSize {
+			dropped := len(p.pending) - p.config.BufferSize
+			p.pending = append([]TimeSeries(nil), p.pending[dropped:]...)
+			log.Warn().Int("dropped", dropped).Msg("Metrics buffer full, dropping oldest samples")
+		}
+	} else {
+		p.pending = series
+	}
+
+	for len(p.pending) > 0 {
+		n := len(p.pending)
+		if p.target.KeepsHistory() {
+			// Targets without history replace what they hold on every
+			// push, so their series go in one request
+			n = min(n, p.config.BatchSize)
+		}
+		err := p.pushBatch(ctx, p.pending[:n])
+		if errors.Is(err, ErrRejected) {
+			log.Warn().Err(err).Int("series", n).Msg("Metrics push rejected, dropping batch")
+			p.pending = p.pending[n:]
+			continue
+		}
+		if err != nil {
+			p.backoff = min(max(p.backoff*2, p.config.Interval*2), p.config.MaxInterval)
+			log.Debug().Err(err).Int("pending", len(p.pending)).Dur("retry_in", p.backoff).Msg("Metrics push failed")
+			return err
+		}
+		p.pending = p.pending[n:]
+	}
+	p.pending = nil
+	p.backoff = 0
+	return nil
+}
+
+func (p *Pusher) pushBatch(ctx context.Context, batch []TimeSeries) error {
+	if p.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.config.Timeout)
+		defer cancel()
+	}
+	return p.target.Push(ctx, batch)
+}
+
+// collect returns the allowed samples of the source as series
+func (p *Pusher) collect() []TimeSeries {
+	now := p.clock.Now()
+	samples := p.allow.Filter(p.source())
+	series := make([]TimeSeries, 0, len(samples))
+	for _, s := range samples {
+		labels := append([]Label{{Name: MetricNameLabel, Value: s.Name}}, p.labels...)
+		for name, value := range s.Labels {
+			labels = append(labels, Label{Name: name, Value: value})
+		}
+		sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+		series = append(series, TimeSeries{Labels: labels, Value: s.Value, Timestamp: now})
+	}
+	return series
+}
+
+// Auth authenticates push requests with HTTP basic auth or a bearer token
+type Auth struct {
+	Username    string
+	Password    string
+	BearerToken string
+}
+
+// statusError describes a failed push response, rejecting the series for
+// client errors other than rate limiting
+func statusError(status int, body string) error {
+	err := fmt.Errorf("push failed with status %d: %s", status, strings.TrimSpace(body))
+	if status >= 400 && status < 500 && status != 429 {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	return err
+}
@@ -0,0 +1,354 @@
+package metricspush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+)
+
+// receiver is an in-process remote-write endpoint that decodes every
+// request it accepts
+type receiver struct {
+	t      *testing.T
+	mu     sync.Mutex
+	status int
+	writes [][]TimeSeries
+	auth   []string
+}
+
+func newReceiver(t *testing.T) (*receiver, *httptest.Server) {
+	r := &receiver{t: t, status: http.StatusNoContent}
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return r, srv
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.auth = append(r.auth, req.Header.Get("Authorization"))
+	if r.status != http.StatusNoContent {
+		w.WriteHeader(r.status)
+		return
+	}
+	if req.Method != http.MethodPost ||
+		req.Header.Get("Content-Type") != "application/x-protobuf" ||
+		req.Header.Get("Content-Encoding") != "snappy" ||
+		req.Header.Get("X-Prometheus-Remote-Write-Version") != "0.1.0" {
+		r.t.Errorf("unexpected request %s with headers %v", req.Method, req.Header)
+	}
+	body, _ := io.ReadAll(req.Body)
+	raw, err := snappy.Decode(nil, body)
+	if err != nil {
+		r.t.Errorf("body is not snappy-compressed: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	series, err := decodeWriteRequest(raw)
+	if err != nil {
+		r.t.Errorf("failed to decode write request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	r.writes = append(r.writes, series)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *receiver) setStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+func (r *receiver) received() [][]TimeSeries {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([][]TimeSeries(nil), r.writes...)
+}
+
+// decodeWriteRequest is the inverse of EncodeWriteRequest
+func decodeWriteRequest(b []byte) ([]TimeSeries, error) {
+	var series []TimeSeries
+	err := eachField(b, func(num protowire.Number, v []byte, _ uint64) error {
+		if num != 1 {
+			return fmt.Errorf("unexpected write request field %d", num)
+		}
+		var ts TimeSeries
+		err := eachField(v, func(num protowire.Number, v []byte, _ uint64) error {
+			switch num {
+			case 1:
+				var l Label
+				err := eachField(v, func(num protowire.Number, v []byte, _ uint64) error {
+					if num == 1 {
+						l.Name = string(v)
+					} else {
+						l.Value = string(v)
+					}
+					return nil
+				})
+				ts.Labels = append(ts.Labels, l)
+				return err
+			case 2:
+				return eachField(v, func(num protowire.Number, _ []byte, n uint64) error {
+					if num == 1 {
+						ts.Value = math.Float64frombits(n)
+					} else {
+						ts.Timestamp = time.UnixMilli(int64(n))
+					}
+					return nil
+				})
+			}
+			return fmt.Errorf("unexpected time series field %d", num)
+		})
+		series = append(series, ts)
+		return err
+	})
+	return series, err
+}
+
+func eachField(b []byte, fn func(num protowire.Number, v []byte, n uint64) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var bytesValue []byte
+		var numValue uint64
+		switch typ {
+		case protowire.BytesType:
+			bytesValue, n = protowire.ConsumeBytes(b)
+		case protowire.Fixed64Type:
+			numValue, n = protowire.ConsumeFixed64(b)
+		case protowire.VarintType:
+			numValue, n = protowire.ConsumeVarint(b)
+		default:
+			return fmt.Errorf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := fn(num, bytesValue, numValue); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func labelsOf(ts TimeSeries) map[string]string {
+	labels := make(map[string]string)
+	for _, l := range ts.Labels {
+		labels[l.Name] = l.Value
+	}
+	return labels
+}
+
+var start = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestRemoteWritePayload(t *testing.T) {
+	recv, srv := newReceiver(t)
+	clk := clocktest.NewFake(start)
+	source := func() []Sample {
+		return []Sample{
+			{Name: RunnerUp, Value: 1},
+			{Name: TasksCompleted, Labels: map[string]string{RunnerLabel: "gpu-0"}, Value: 7},
+		}
+	}
+	p := NewPusher(NewRemoteWrite(srv.URL, Auth{BearerToken: "secret"}), source, NewAllowlist(nil, nil), "runner-abc", "parity-runner", Config{Interval: time.Minute})
+	p.SetClock(clk)
+
+	if err := p.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	writes := recv.received()
+	if len(writes) != 1 || len(writes[0]) != 2 {
+		t.Fatalf("received %v, want one write of two series", writes)
+	}
+	if recv.auth[0] != "Bearer secret" {
+		t.Errorf("Authorization = %q", recv.auth[0])
+	}
+	for _, ts := range writes[0] {
+		if !ts.Timestamp.Equal(start) {
+			t.Errorf("%s timestamp = %v, want %v", ts.Name(), ts.Timestamp, start)
+		}
+		for i := 1; i < len(ts.Labels); i++ {
+			if ts.Labels[i-1].Name >= ts.Labels[i].Name {
+				t.Errorf("%s labels are not sorted: %v", ts.Name(), ts.Labels)
+			}
+		}
+	}
+	completed := writes[0][0]
+	want := map[string]string{MetricNameLabel: TasksCompleted, InstanceLabel: "runner-abc", JobLabel: "parity-runner", RunnerLabel: "gpu-0"}
+	if got := labelsOf(completed); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("labels = %v, want %v", got, want)
+	}
+	if completed.Value != 7 {
+		t.Errorf("value = %v, want 7", completed.Value)
+	}
+}
+
+func TestAllowlistFiltersSeriesAndLabels(t *testing.T) {
+	recv, srv := newReceiver(t)
+	source := func() []Sample {
+		return []Sample{
+			{Name: LLMQueueDepth, Labels: map[string]string{ModelLabel: "llama3", "task_id": "t-1"}, Value: 2},
+			{Name: LLMQueueDepth, Labels: map[string]string{ModelLabel: "mistral", "task_id": "t-2"}, Value: 3},
+			{Name: "parity_task_duration_seconds", Labels: map[string]string{"task_id": "t-1"}, Value: 12},
+			{Name: TasksClaimed, Labels: map[string]string{InstanceLabel: "spoofed", RunnerLabel: ""}, Value: 4},
+		}
+	}
+	p := NewPusher(NewRemoteWrite(srv.URL, Auth{Username: "user", Password: "pass"}), source, NewAllowlist(nil, nil), "runner-abc", "parity-runner", Config{Interval: time.Minute})
+	p.SetClock(clocktest.NewFake(start))
+
+	if err := p.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	writes := recv.received()
+	if len(writes) != 1 {
+		t.Fatalf("received %d writes, want 1", len(writes))
+	}
+	got := make(map[string]float64)
+	for _, ts := range writes[0] {
+		labels := labelsOf(ts)
+		if len(labels) != 3 {
+			t.Errorf("%s has labels %v, want only name, instance and job", ts.Name(), labels)
+		}
+		if labels[InstanceLabel] != "runner-abc" {
+			t.Errorf("%s instance = %q", ts.Name(), labels[InstanceLabel])
+		}
+		got[ts.Name()] = ts.Value
+	}
+	want := map[string]float64{LLMQueueDepth: 5, TasksClaimed: 4}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("series = %v, want %v", got, want)
+	}
+	if !strings.HasPrefix(recv.auth[0], "Basic ") {
+		t.Errorf("Authorization = %q, want basic auth", recv.auth[0])
+	}
+}
+
+func TestFailedPushesBackOffAndBuffer(t *testing.T) {
+	recv, srv := newReceiver(t)
+	recv.setStatus(http.StatusServiceUnavailable)
+	clk := clocktest.NewFake(start)
+	source := func() []Sample { return []Sample{{Name: RunnerUp, Value: 1}} }
+	p := NewPusher(NewRemoteWrite(srv.URL, Auth{}), source, NewAllowlist(nil, nil), "runner-abc", "parity-runner", Config{
+		Interval:    time.Minute,
+		MaxInterval: 5 * time.Minute,
+		BatchSize:   2,
+		BufferSize:  3,
+	})
+	p.SetClock(clk)
+
+	var backoffs []time.Duration
+	for i := 0; i < 4; i++ {
+		if err := p.Push(context.Background()); err == nil {
+			t.Fatalf("push %d succeeded against a failing endpoint", i)
+		}
+		backoffs = append(backoffs, p.backoff)
+		clk.Advance(p.backoff)
+	}
+	if want := []time.Duration{2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute}; fmt.Sprint(backoffs) != fmt.Sprint(want) {
+		t.Errorf("backoffs = %v, want %v", backoffs, want)
+	}
+	if len(p.pending) != 3 {
+		t.Errorf("buffered %d series, want the newest 3", len(p.pending))
+	}
+
+	recv.setStatus(http.StatusNoContent)
+	if err := p.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	writes := recv.received()
+	if len(writes) != 2 || len(writes[0]) != 2 || len(writes[1]) != 1 {
+		t.Fatalf("received %v, want the 3 buffered series in batches of 2", writes)
+	}
+	if oldest := writes[0][0].Timestamp; !oldest.Equal(start.Add(6 * time.Minute)) {
+		t.Errorf("oldest series sent is from %v, want the dropped ones gone", oldest)
+	}
+	if p.backoff != 0 || len(p.pending) != 0 {
+		t.Errorf("backoff = %v and %d pending after a successful push", p.backoff, len(p.pending))
+	}
+}
+
+func TestRejectedPushIsDropped(t *testing.T) {
+	recv, srv := newReceiver(t)
+	recv.setStatus(http.StatusBadRequest)
+	source := func() []Sample { return []Sample{{Name: RunnerUp, Value: 1}} }
+	p := NewPusher(NewRemoteWrite(srv.URL, Auth{}), source, NewAllowlist(nil, nil), "runner-abc", "parity-runner", Config{Interval: time.Minute})
+	p.SetClock(clocktest.NewFake(start))
+
+	if err := p.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if len(p.pending) != 0 || p.backoff != 0 {
+		t.Errorf("rejected series kept: %d pending, backoff %v", len(p.pending), p.backoff)
+	}
+	if err := statusError(http.StatusTooManyRequests, ""); errors.Is(err, ErrRejected) {
+		t.Errorf("rate limiting rejects series: %v", err)
+	}
+}
+
+func TestPushGatewayReplacesGroup(t *testing.T) {
+	var gotPath, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			t.Errorf("method = %s, want PUT", r.Method)
+		}
+		body, _ := io.ReadAll(r.Body)
+		gotPath, gotBody = r.URL.Path, string(body)
+	}))
+	defer srv.Close()
+
+	source := func() []Sample {
+		return []Sample{
+			{Name: RunnerUp, Value: 1},
+			{Name: TasksFailed, Labels: map[string]string{RunnerLabel: `a"b`}, Value: 2},
+		}
+	}
+	p := NewPusher(NewPushGateway(srv.URL+"/", Auth{}), source, NewAllowlist(nil, nil), "runner-abc", "parity-runner", Config{Interval: time.Minute, BatchSize: 1})
+	p.SetClock(clocktest.NewFake(start))
+
+	if err := p.Push(context.Background()); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+	if gotPath != "/metrics/job/parity-runner/instance/runner-abc" {
+		t.Errorf("path = %q", gotPath)
+	}
+	for _, line := range []string{
+		"# TYPE " + RunnerUp + " gauge",
+		RunnerUp + " 1",
+		"# TYPE " + TasksFailed + " counter",
+		TasksFailed + `{runner="a\"b"} 2`,
+	} {
+		if !strings.Contains(gotBody, line+"\n") {
+			t.Errorf("body is missing %q:\n%s", line, gotBody)
+		}
+	}
+	if strings.Contains(gotBody, "instance=") || strings.Contains(gotBody, "job=") {
+		t.Errorf("body repeats the grouping labels:\n%s", gotBody)
+	}
+}
+
+func TestInstanceIDHidesDeviceID(t *testing.T) {
+	id := InstanceID("device-1234")
+	if strings.Contains(id, "device-1234") || id != InstanceID("device-1234") || id == InstanceID("device-5678") {
+		t.Errorf("InstanceID = %q is not a stable hash of the device ID", id)
+	}
+}
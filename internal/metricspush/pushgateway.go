@@ -0,0 +1,114 @@
+package metricspush
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+)
+
+// PushGateway pushes series to a Prometheus pushgateway, replacing the
+// group of the runner's job and instance on every push
+type PushGateway struct {
+	url    string
+	auth   Auth
+	client *http.Client
+}
+
+// NewPushGateway pushes to the pushgateway at baseURL
+func NewPushGateway(baseURL string, auth Auth) *PushGateway {
+	return &PushGateway{url: strings.TrimSuffix(baseURL, "/"), auth: auth, client: dualstack.Client(0)}
+}
+
+// SetHTTPClient replaces the client series are pushed with
+func (g *PushGateway) SetHTTPClient(client *http.Client) {
+	g.client = client
+}
+
+// KeepsHistory is false: a pushgateway holds only the latest push, so
+// samples that failed to push are superseded by the next ones
+func (g *PushGateway) KeepsHistory() bool {
+	return false
+}
+
+// Push PUTs series in the text exposition format to the group named by
+// their job and instance labels
+func (g *PushGateway) Push(ctx context.Context, series []TimeSeries) error {
+	if len(series) == 0 {
+		return nil
+	}
+	job, instance := groupingKey(series[0])
+	target := fmt.Sprintf("%s/metrics/job/%s/instance/%s", g.url, url.PathEscape(job), url.PathEscape(instance))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(EncodeText(series)))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	g.auth.apply(req)
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return statusError(resp.StatusCode, string(msg))
+	}
+	return nil
+}
+
+func groupingKey(ts TimeSeries) (job, instance string) {
+	for _, l := range ts.Labels {
+		switch l.Name {
+		case JobLabel:
+			job = l.Value
+		case InstanceLabel:
+			instance = l.Value
+		}
+	}
+	return job, instance
+}
+
+// EncodeText encodes series in the Prometheus text exposition format,
+// leaving out the job and instance labels the pushgateway groups by.
+// Series whose names end in _total are typed as counters, others as gauges.
+func EncodeText(series []TimeSeries) []byte {
+	var b bytes.Buffer
+	typed := make(map[string]bool)
+	for _, ts := range series {
+		name := ts.Name()
+		if !typed[name] {
+			kind := "gauge"
+			if strings.HasSuffix(name, "_total") {
+				kind = "counter"
+			}
+			fmt.Fprintf(&b, "# TYPE %s %s\n", name, kind)
+			typed[name] = true
+		}
+		b.WriteString(name)
+		var labels []string
+		for _, l := range ts.Labels {
+			if l.Name == MetricNameLabel || l.Name == JobLabel || l.Name == InstanceLabel {
+				continue
+			}
+			labels = append(labels, l.Name+`="`+escapeLabel(l.Value)+`"`)
+		}
+		if len(labels) > 0 {
+			b.WriteString("{" + strings.Join(labels, ",") + "}")
+		}
+		b.WriteString(" " + strconv.FormatFloat(ts.Value, 'g', -1, 64) + "\n")
+	}
+	return b.Bytes()
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,104 @@
+package metricspush
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+)
+
+// RemoteWrite pushes series to a Prometheus remote-write endpoint
+type RemoteWrite struct {
+	url    string
+	auth   Auth
+	client *http.Client
+}
+
+// NewRemoteWrite pushes to the remote-write endpoint at url
+func NewRemoteWrite(url string, auth Auth) *RemoteWrite {
+	return &RemoteWrite{url: url, auth: auth, client: dualstack.Client(0)}
+}
+
+// SetHTTPClient replaces the client series are pushed with
+func (r *RemoteWrite) SetHTTPClient(client *http.Client) {
+	r.client = client
+}
+
+// KeepsHistory is true: remote-write stores every sample, so samples that
+// failed to push are sent later with their original timestamps
+func (r *RemoteWrite) KeepsHistory() bool {
+	return true
+}
+
+// Push sends series as a snappy-compressed protobuf WriteRequest
+func (r *RemoteWrite) Push(ctx context.Context, series []TimeSeries) error {
+	body := snappy.Encode(nil, EncodeWriteRequest(series))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrRejected, err)
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	r.auth.apply(req)
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return statusError(resp.StatusCode, string(msg))
+	}
+	return nil
+}
+
+// EncodeWriteRequest encodes series as a remote-write WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; }
+//	message TimeSeries { repeated Label labels = 1; repeated Sample samples = 2; }
+//	message Label { string name = 1; string value = 2; }
+//	message Sample { double value = 1; int64 timestamp = 2; }
+func EncodeWriteRequest(series []TimeSeries) []byte {
+	var out []byte
+	for _, ts := range series {
+		var msg []byte
+		for _, l := range ts.Labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l.Name)
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l.Value)
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendBytes(msg, label)
+		}
+		var sample []byte
+		sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(ts.Value))
+		sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(ts.Timestamp.UnixMilli()))
+		msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+		msg = protowire.AppendBytes(msg, sample)
+
+		out = protowire.AppendTag(out, 1, protowire.BytesType)
+		out = protowire.AppendBytes(out, msg)
+	}
+	return out
+}
+
+func (a Auth) apply(req *http.Request) {
+	switch {
+	case a.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+a.BearerToken)
+	case a.Username != "":
+		req.SetBasicAuth(a.Username, a.Password)
+	}
+}
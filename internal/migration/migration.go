@@ -0,0 +1,156 @@
+// Package migration moves a running task to another runner when its runner
+// shuts down as planned. The runner giving the task up uploads a bundle of
+// the task's latest state, the weights of a federated learning round after
+// its last completed epoch or the latest checkpoint a task reported, and
+// the runner the server reassigns the task to resumes from the bundle
+// instead of starting over.
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/objectstore"
+)
+
+// Version is the bundle format this runner writes and reads
+const Version = 1
+
+// ErrInvalidBundle is returned for a bundle that cannot be resumed from
+var ErrInvalidBundle = errors.New("invalid migration bundle")
+
+// Bundle is the state of a task a runner hands to the next runner
+type Bundle struct {
+	Version int    `json:"version"`
+	TaskID  string `json:"task_id"`
+	// Training is set for a federated learning round
+	Training *Training `json:"training,omitempty"`
+	// Checkpoint is the latest checkpoint a task reported through the
+	// checkpoint contract
+	Checkpoint *models.TaskCheckpoint `json:"checkpoint,omitempty"`
+}
+
+// Training is how far a federated learning round got
+type Training struct {
+	EpochsCompleted int `json:"epochs_completed"`
+	// Epochs is how many epochs the round trains for
+	Epochs int `json:"epochs"`
+	// SpecHash identifies the model trained when the session describes it
+	// as a spec
+	SpecHash string               `json:"spec_hash,omitempty"`
+	Weights  map[string][]float64 `json:"weights"`
+}
+
+// Validate checks that the bundle is one this runner can resume taskID
+// from
+func (b *Bundle) Validate(taskID string) error {
+	switch {
+	case b.Version != Version:
+		return fmt.Errorf("%w: version %d, want %d", ErrInvalidBundle, b.Version, Version)
+	case b.TaskID != taskID:
+		return fmt.Errorf("%w: bundle of task %s", ErrInvalidBundle, b.TaskID)
+	case b.Training == nil && b.Checkpoint == nil:
+		return fmt.Errorf("%w: no state to resume from", ErrInvalidBundle)
+	}
+	if t := b.Training; t != nil {
+		// A round that trained every epoch finishes rather than migrating
+		if t.EpochsCompleted < 1 || t.EpochsCompleted >= t.Epochs || len(t.Weights) == 0 {
+			return fmt.Errorf("%w: %d of %d epochs with %d weight layers", ErrInvalidBundle, t.EpochsCompleted, t.Epochs, len(t.Weights))
+		}
+	}
+	if c := b.Checkpoint; c != nil && (c.Name == "" || c.CID == "") {
+		return fmt.Errorf("%w: checkpoint without a name or location", ErrInvalidBundle)
+	}
+	return nil
+}
+
+// Source locates content uploaded to ref, a CID or the URI an uploader
+// returned
+func Source(ref string) models.InputSource {
+	if objectstore.IsURI(ref) || strings.HasPrefix(ref, "https://") || strings.HasPrefix(ref, "http://") {
+		return models.InputSource{URL: ref}
+	}
+	return models.InputSource{CID: ref}
+}
+
+// Tracker keeps the latest state of each running task that could be
+// migrated
+type Tracker struct {
+	mu    sync.Mutex
+	tasks map[string]*Bundle
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{tasks: make(map[string]*Bundle)}
+}
+
+func (t *Tracker) bundle(taskID string) *Bundle {
+	b, ok := t.tasks[taskID]
+	if !ok {
+		b = &Bundle{Version: Version, TaskID: taskID}
+		t.tasks[taskID] = b
+	}
+	return b
+}
+
+// RecordTraining records how far taskID's training got. The weights are
+// copied, as the trainer goes on updating its own.
+func (t *Tracker) RecordTraining(taskID string, training Training) {
+	weights := make(map[string][]float64, len(training.Weights))
+	for key, values := range training.Weights {
+		weights[key] = slices.Clone(values)
+	}
+	training.Weights = weights
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bundle(taskID).Training = &training
+}
+
+// RecordCheckpoint records the latest checkpoint taskID reported
+func (t *Tracker) RecordCheckpoint(taskID string, checkpoint models.TaskCheckpoint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.bundle(taskID).Checkpoint = &checkpoint
+}
+
+// Bundle returns the state taskID could be resumed from, or nil when it has
+// recorded none
+func (t *Tracker) Bundle(taskID string) *Bundle {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	b, ok := t.tasks[taskID]
+	if !ok {
+		return nil
+	}
+	// What is recorded is replaced, never changed, so it can be shared
+	bundle := *b
+	return &bundle
+}
+
+// Forget drops what was recorded of taskID
+func (t *Tracker) Forget(taskID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.tasks, taskID)
+}
+
+type resumeKey struct{}
+
+// WithResume returns ctx carrying the bundle the task run under it resumes
+// from
+func WithResume(ctx context.Context, bundle *Bundle) context.Context {
+	return context.WithValue(ctx, resumeKey{}, bundle)
+}
+
+// Resume returns the bundle the task run under ctx resumes from, or nil
+// when it starts from the beginning
+func Resume(ctx context.Context) *Bundle {
+	bundle, _ := ctx.Value(resumeKey{}).(*Bundle)
+	return bundle
+}
@@ -0,0 +1,146 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+)
+
+// bundleServer serves what is uploaded through it at its URL
+type bundleServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	uploads map[string][]byte
+}
+
+func newBundleServer(t *testing.T) *bundleServer {
+	s := &bundleServer{uploads: make(map[string][]byte)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		data, ok := s.uploads[r.URL.Path]
+		s.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *bundleServer) ForTask(taskID string) artifacts.Uploader {
+	return uploaderFunc(func(ctx context.Context, name string, r io.Reader) (string, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		path := "/" + taskID + "/" + name
+		s.mu.Lock()
+		s.uploads[path] = data
+		s.mu.Unlock()
+		return s.URL + path, nil
+	})
+}
+
+type uploaderFunc func(ctx context.Context, name string, r io.Reader) (string, error)
+
+func (f uploaderFunc) Add(ctx context.Context, name string, r io.Reader) (string, error) {
+	return f(ctx, name, r)
+}
+
+func newStore(t *testing.T, server *bundleServer) *Store {
+	t.Helper()
+	manager, err := inputs.NewManager(filepath.Join(t.TempDir(), "inputs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStore(server, manager)
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	server := newBundleServer(t)
+	tracker := NewTracker()
+	tracker.RecordTraining("task-1", Training{EpochsCompleted: 3, Epochs: 5, SpecHash: "abc", Weights: map[string][]float64{"w": {0.5, -1}, "b": {2}}})
+	tracker.RecordCheckpoint("task-1", models.TaskCheckpoint{Name: "step-3.pt", CID: "QmCheckpoint", Size: 42})
+	bundle := tracker.Bundle("task-1")
+
+	migration, err := newStore(t, server).Put(context.Background(), bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migration.EpochsCompleted != 3 || migration.Size == 0 || len(migration.SHA256) != 64 {
+		t.Errorf("migration = %+v", migration)
+	}
+
+	got, err := newStore(t, server).Get(context.Background(), "task-1", migration)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, bundle) {
+		t.Errorf("Get() = %+v, want %+v", got, bundle)
+	}
+
+	if _, err := newStore(t, server).Get(context.Background(), "task-2", migration); !errors.Is(err, ErrInvalidBundle) {
+		t.Errorf("Get() for another task error = %v, want ErrInvalidBundle", err)
+	}
+
+	// A bundle changed after upload fails its hash
+	server.mu.Lock()
+	for path, data := range server.uploads {
+		server.uploads[path] = bytes.Replace(data, []byte(`"epochs_completed":3`), []byte(`"epochs_completed":4`), 1)
+	}
+	server.mu.Unlock()
+	if _, err := newStore(t, server).Get(context.Background(), "task-1", migration); err == nil {
+		t.Error("Get() accepted a bundle that does not match its hash")
+	}
+}
+
+func TestTrackerKeepsWhatWasRecorded(t *testing.T) {
+	tracker := NewTracker()
+	if tracker.Bundle("task-1") != nil {
+		t.Fatal("bundle of a task that recorded nothing")
+	}
+	weights := map[string][]float64{"w": {1, 2}}
+	tracker.RecordTraining("task-1", Training{EpochsCompleted: 1, Epochs: 2, Weights: weights})
+	// The trainer goes on updating its weights
+	weights["w"][0] = 9
+
+	bundle := tracker.Bundle("task-1")
+	if bundle.Training.Weights["w"][0] != 1 {
+		t.Error("recorded weights changed with the trainer's")
+	}
+	if err := bundle.Validate("task-1"); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	tracker.Forget("task-1")
+	if tracker.Bundle("task-1") != nil {
+		t.Error("bundle kept after Forget")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for name, bundle := range map[string]Bundle{
+		"other version":  {Version: Version + 1, TaskID: "t", Checkpoint: &models.TaskCheckpoint{Name: "c", CID: "x"}},
+		"other task":     {Version: Version, TaskID: "u", Checkpoint: &models.TaskCheckpoint{Name: "c", CID: "x"}},
+		"no state":       {Version: Version, TaskID: "t"},
+		"every epoch":    {Version: Version, TaskID: "t", Training: &Training{EpochsCompleted: 2, Epochs: 2, Weights: map[string][]float64{"w": {1}}}},
+		"no weights":     {Version: Version, TaskID: "t", Training: &Training{EpochsCompleted: 1, Epochs: 2}},
+		"unnamed upload": {Version: Version, TaskID: "t", Checkpoint: &models.TaskCheckpoint{CID: "x"}},
+	} {
+		if err := bundle.Validate("t"); !errors.Is(err, ErrInvalidBundle) {
+			t.Errorf("%s: Validate() error = %v, want ErrInvalidBundle", name, err)
+		}
+	}
+}
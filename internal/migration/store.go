@@ -0,0 +1,99 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+)
+
+// bundleName is the artifact name bundles are uploaded as
+const bundleName = "migration.json"
+
+// Store uploads bundles for the runner a task migrates to, and downloads
+// those of tasks migrated to this runner
+type Store struct {
+	uploaders artifacts.UploaderSource
+	inputs    *inputs.Manager
+	clock     clock.Clock
+}
+
+// NewStore uploads bundles through uploaders and downloads them through
+// manager. Either may be nil: without uploaders no task is migrated away,
+// and without a manager migrated tasks start over.
+func NewStore(uploaders artifacts.UploaderSource, manager *inputs.Manager) *Store {
+	return &Store{uploaders: uploaders, inputs: manager, clock: clock.Real()}
+}
+
+func (s *Store) SetClock(clk clock.Clock) {
+	s.clock = clk
+}
+
+// Put uploads bundle and returns the pointer the server passes to the
+// runner the task is reassigned to
+func (s *Store) Put(ctx context.Context, bundle *Bundle) (*models.TaskMigration, error) {
+	if s.uploaders == nil {
+		return nil, errors.New("migration bundle uploads are not enabled on this runner")
+	}
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode migration bundle: %w", err)
+	}
+	ref, err := s.uploaders.ForTask(bundle.TaskID).Add(ctx, bundleName, bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload migration bundle: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	migration := &models.TaskMigration{
+		Bundle:    ref,
+		SHA256:    hex.EncodeToString(sum[:]),
+		Size:      int64(len(data)),
+		CreatedAt: s.clock.Now(),
+	}
+	if bundle.Training != nil {
+		migration.EpochsCompleted = bundle.Training.EpochsCompleted
+	}
+	return migration, nil
+}
+
+// Get downloads the bundle migration points at, verified against its hash,
+// and checks that taskID can be resumed from it
+func (s *Store) Get(ctx context.Context, taskID string, migration *models.TaskMigration) (*Bundle, error) {
+	if s.inputs == nil {
+		return nil, errors.New("migration bundle downloads are not enabled on this runner")
+	}
+	scratch, err := os.MkdirTemp("", retention.ScratchPattern("migration", taskID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create download directory: %w", err)
+	}
+	defer os.RemoveAll(scratch)
+
+	spec := &models.TaskInput{Name: bundleName, Source: Source(migration.Bundle), SHA256: migration.SHA256, Size: migration.Size}
+	path, err := s.inputs.Fetch(ctx, spec, scratch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download migration bundle: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migration bundle: %w", err)
+	}
+	var bundle Bundle
+	// Weights outgrow safejson's limits; the bundle's shape is fixed
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidBundle, err)
+	}
+	if err := bundle.Validate(taskID); err != nil {
+		return nil, err
+	}
+	return &bundle, nil
+}
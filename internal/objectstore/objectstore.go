@@ -0,0 +1,549 @@
+// Package objectstore is a small client for S3-compatible object storage.
+// Objects are stored with the SHA-256 of their content in their metadata,
+// and reads check the content against it. Credentials only ever come from
+// the runner's configuration: object URIs naming credentials are rejected,
+// and a client only signs requests for the buckets it was configured with.
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+)
+
+const (
+	// MinPartSize is the smallest part S3 accepts in a multipart upload
+	// other than the last
+	MinPartSize = 5 << 20
+	// DefaultPartSize is the part size used when none is configured.
+	// Objects up to this size are uploaded with a single request.
+	DefaultPartSize = 16 << 20
+	// maxParts is the most parts one multipart upload may have
+	maxParts = 10000
+
+	// SHA256Metadata is the user metadata key objects' content hash is
+	// stored under, sent as the x-amz-meta-sha256 header
+	SHA256Metadata = "sha256"
+	sha256Header   = "X-Amz-Meta-" + SHA256Metadata
+)
+
+// Server-side encryption modes
+const (
+	SSENone   = ""
+	SSEAES256 = "AES256"
+	SSEKMS    = "aws:kms"
+)
+
+var (
+	// ErrIntegrity is returned when an object's content does not match the
+	// hash or size stored with it
+	ErrIntegrity = errors.New("object integrity check failed")
+	// ErrNotFound is returned for objects that do not exist
+	ErrNotFound = errors.New("object not found")
+	// ErrBucketNotAllowed is returned for buckets the client holds no
+	// credentials for
+	ErrBucketNotAllowed = errors.New("bucket not allowed")
+	// ErrInvalidURI is returned for object URIs that are not plain
+	// s3://bucket/key references
+	ErrInvalidURI = errors.New("invalid object URI")
+)
+
+// Credentials sign requests to the object store
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// Encryption is the server-side encryption objects are stored with
+type Encryption struct {
+	// Mode is SSENone, SSEAES256 or SSEKMS
+	Mode string
+	// KMSKeyID names the KMS key for SSEKMS; empty uses the bucket's
+	// default key
+	KMSKeyID string
+}
+
+// Config describes an S3-compatible service
+type Config struct {
+	// Endpoint is the service's base URL, such as
+	// https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Endpoint string
+	Region   string
+	// PathStyle addresses buckets in the path rather than the host name,
+	// as most self-hosted services require
+	PathStyle   bool
+	Credentials Credentials
+	Encryption  Encryption
+	// PartSize is the size of multipart upload parts; larger objects are
+	// uploaded in parts of this size
+	PartSize int64
+	// Buckets lists the buckets the credentials may be used with
+	Buckets []string
+}
+
+// Object describes a stored object
+type Object struct {
+	Bucket string
+	Key    string
+	// SHA256 is the hex hash of the content stored in its metadata, empty
+	// for objects stored without one
+	SHA256 string
+	Size   int64
+	ETag   string
+}
+
+// URI returns the s3:// URI of o
+func (o *Object) URI() string {
+	return "s3://" + o.Bucket + "/" + o.Key
+}
+
+// ParseURI splits an s3://bucket/key URI. URIs carrying user information,
+// a query or a fragment are rejected so that a URI can never supply
+// credentials or request parameters of its own.
+func ParseURI(raw string) (string, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidURI, err)
+	}
+	switch {
+	case u.Scheme != "s3":
+		return "", "", fmt.Errorf("%w: scheme must be s3", ErrInvalidURI)
+	case u.User != nil:
+		return "", "", fmt.Errorf("%w: credentials are not accepted in object URIs", ErrInvalidURI)
+	case u.RawQuery != "" || u.ForceQuery || u.Fragment != "":
+		return "", "", fmt.Errorf("%w: object URIs take no query or fragment", ErrInvalidURI)
+	case u.Host == "" || u.Port() != "":
+		return "", "", fmt.Errorf("%w: a bucket name is required", ErrInvalidURI)
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if key == "" {
+		return "", "", fmt.Errorf("%w: an object key is required", ErrInvalidURI)
+	}
+	return u.Host, key, nil
+}
+
+// IsURI reports whether raw is meant as an s3:// URI
+func IsURI(raw string) bool {
+	return strings.HasPrefix(strings.ToLower(raw), "s3://")
+}
+
+// Client reads and writes objects in an S3-compatible service
+type Client struct {
+	cfg      Config
+	endpoint *url.URL
+	client   *http.Client
+	clock    clock.Clock
+}
+
+// New creates a client for cfg
+func New(cfg Config) (*Client, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(cfg.Endpoint, "/"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("object store endpoint must be an http(s) URL, got %q", cfg.Endpoint)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("object store region is required")
+	}
+	if cfg.Credentials.AccessKeyID == "" || cfg.Credentials.SecretAccessKey == "" {
+		return nil, fmt.Errorf("object store access key id and secret access key are required")
+	}
+	switch cfg.Encryption.Mode {
+	case SSENone, SSEAES256:
+		if cfg.Encryption.KMSKeyID != "" {
+			return nil, fmt.Errorf("a KMS key id requires %s encryption", SSEKMS)
+		}
+	case SSEKMS:
+	default:
+		return nil, fmt.Errorf("unknown server-side encryption %q (supported: %s, %s)", cfg.Encryption.Mode, SSEAES256, SSEKMS)
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = DefaultPartSize
+	}
+	if cfg.PartSize < MinPartSize {
+		return nil, fmt.Errorf("object store part size must be at least %d bytes", MinPartSize)
+	}
+	if len(cfg.Buckets) == 0 {
+		return nil, fmt.Errorf("object store needs at least one bucket")
+	}
+	return &Client{
+		cfg:      cfg,
+		endpoint: endpoint,
+		client:   dualstack.Client(0),
+		clock:    clock.Real(),
+	}, nil
+}
+
+// SetHTTPClient replaces the client requests are sent with
+func (c *Client) SetHTTPClient(client *http.Client) {
+	c.client = client
+}
+
+// SetClock replaces the clock requests are signed with
+func (c *Client) SetClock(clk clock.Clock) {
+	c.clock = clk
+}
+
+// Allowed reports whether the client's credentials may be used for bucket
+func (c *Client) Allowed(bucket string) bool {
+	return slices.Contains(c.cfg.Buckets, bucket)
+}
+
+// objectURL addresses key in bucket, with the path encoded the way
+// requests are signed
+func (c *Client) objectURL(bucket, key string, query url.Values) *url.URL {
+	u := *c.endpoint
+	path := "/" + key
+	if c.cfg.PathStyle {
+		path = "/" + bucket + path
+	} else {
+		u.Host = bucket + "." + u.Host
+	}
+	u.Path = strings.TrimSuffix(c.endpoint.Path, "/") + path
+	u.RawPath = canonicalPath(&u)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// do signs and sends a request for key in bucket
+func (c *Client) do(ctx context.Context, method, bucket, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	if !c.Allowed(bucket) {
+		return nil, fmt.Errorf("%w: %s", ErrBucketNotAllowed, bucket)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(bucket, key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create object store request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	Sign(req, c.cfg.Credentials, c.cfg.Region, hashHex(body), c.clock.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("object store request failed: %w", err)
+	}
+	return resp, nil
+}
+
+// s3Error is the error document S3 returns
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// responseError describes a failed response, consuming its body
+func responseError(op, bucket, key string, resp *http.Response) error {
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: s3://%s/%s", ErrNotFound, bucket, key)
+	}
+	var doc s3Error
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(raw, &doc) == nil && doc.Code != "" {
+		return fmt.Errorf("failed to %s s3://%s/%s: status %d: %s: %s", op, bucket, key, resp.StatusCode, doc.Code, doc.Message)
+	}
+	return fmt.Errorf("failed to %s s3://%s/%s: status %d", op, bucket, key, resp.StatusCode)
+}
+
+// encryptionHeaders returns the headers requesting the client's
+// server-side encryption
+func (c *Client) encryptionHeaders() http.Header {
+	header := http.Header{}
+	if c.cfg.Encryption.Mode != SSENone {
+		header.Set("X-Amz-Server-Side-Encryption", c.cfg.Encryption.Mode)
+	}
+	if c.cfg.Encryption.KMSKeyID != "" {
+		header.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", c.cfg.Encryption.KMSKeyID)
+	}
+	return header
+}
+
+// Put stores r as key in bucket with its SHA-256 in the object's metadata.
+// Content up to the part size is uploaded with a single request, larger
+// content as a multipart upload. Since the hash is stored when the upload
+// starts, content that cannot be read twice is spooled to a temporary file
+// first.
+func (c *Client) Put(ctx context.Context, bucket, key string, r io.Reader) (*Object, error) {
+	if !c.Allowed(bucket) {
+		return nil, fmt.Errorf("%w: %s", ErrBucketNotAllowed, bucket)
+	}
+	content, size, digest, cleanup, err := c.rewindable(r)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
+	obj := &Object{Bucket: bucket, Key: key, SHA256: digest, Size: size}
+	if size <= c.cfg.PartSize {
+		body, err := io.ReadAll(content)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object content: %w", err)
+		}
+		header := c.encryptionHeaders()
+		header.Set(sha256Header, digest)
+		header.Set("Content-Type", "application/octet-stream")
+		resp, err := c.do(ctx, http.MethodPut, bucket, key, nil, header, body)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, responseError("upload", bucket, key, resp)
+		}
+		resp.Body.Close()
+		obj.ETag = resp.Header.Get("ETag")
+		return obj, nil
+	}
+
+	if parts := (size + c.cfg.PartSize - 1) / c.cfg.PartSize; parts > maxParts {
+		return nil, fmt.Errorf("object of %d bytes needs %d parts, more than the %d allowed; raise the part size", size, parts, maxParts)
+	}
+	if obj.ETag, err = c.multipart(ctx, bucket, key, digest, content); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// rewindable returns r's content as a reader positioned at its start, with
+// its size and hash. Seekable content is hashed in place; other content is
+// kept in memory when it fits in one part and spooled to a temporary file
+// otherwise.
+func (c *Client) rewindable(r io.Reader) (io.Reader, int64, string, func(), error) {
+	hash := sha256.New()
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		start, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			size, err := io.Copy(hash, seeker)
+			if err != nil {
+				return nil, 0, "", nil, fmt.Errorf("failed to hash object content: %w", err)
+			}
+			if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+				return nil, 0, "", nil, fmt.Errorf("failed to rewind object content: %w", err)
+			}
+			return seeker, size, hex.EncodeToString(hash.Sum(nil)), func() {}, nil
+		}
+	}
+
+	head, err := io.ReadAll(io.LimitReader(r, c.cfg.PartSize+1))
+	if err != nil {
+		return nil, 0, "", nil, fmt.Errorf("failed to read object content: %w", err)
+	}
+	hash.Write(head)
+	if int64(len(head)) <= c.cfg.PartSize {
+		return bytes.NewReader(head), int64(len(head)), hex.EncodeToString(hash.Sum(nil)), func() {}, nil
+	}
+	return spool(head, r, hash)
+}
+
+// spool writes head and the rest of r to a temporary file, hashing the rest
+// into hash
+func spool(head []byte, r io.Reader, hash hash.Hash) (io.Reader, int64, string, func(), error) {
+	f, err := os.CreateTemp("", "objectstore-*.spool")
+	if err != nil {
+		return nil, 0, "", nil, fmt.Errorf("failed to spool object content: %w", err)
+	}
+	cleanup := func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if _, err := f.Write(head); err != nil {
+		cleanup()
+		return nil, 0, "", nil, fmt.Errorf("failed to spool object content: %w", err)
+	}
+	rest, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err != nil {
+		cleanup()
+		return nil, 0, "", nil, fmt.Errorf("failed to spool object content: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, 0, "", nil, fmt.Errorf("failed to rewind spooled content: %w", err)
+	}
+	return f, int64(len(head)) + rest, hex.EncodeToString(hash.Sum(nil)), cleanup, nil
+}
+
+type initiateMultipartUploadResult struct {
+	UploadID string `xml:"UploadId"`
+}
+
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type completeMultipartUpload struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []completedPart `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	ETag string `xml:"ETag"`
+}
+
+// multipart uploads content as key in parts of the configured size,
+// aborting the upload when any part fails
+func (c *Client) multipart(ctx context.Context, bucket, key, digest string, content io.Reader) (string, error) {
+	header := c.encryptionHeaders()
+	header.Set(sha256Header, digest)
+	header.Set("Content-Type", "application/octet-stream")
+	resp, err := c.do(ctx, http.MethodPost, bucket, key, url.Values{"uploads": {""}}, header, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError("start multipart upload of", bucket, key, resp)
+	}
+	var initiated initiateMultipartUploadResult
+	err = xml.NewDecoder(resp.Body).Decode(&initiated)
+	resp.Body.Close()
+	if err != nil || initiated.UploadID == "" {
+		return "", fmt.Errorf("invalid multipart upload response for s3://%s/%s: %v", bucket, key, err)
+	}
+
+	etag, err := c.uploadParts(ctx, bucket, key, initiated.UploadID, content)
+	if err != nil {
+		// The parts stored so far are billed until the upload is aborted
+		if resp, abortErr := c.do(context.WithoutCancel(ctx), http.MethodDelete, bucket, key, url.Values{"uploadId": {initiated.UploadID}}, nil, nil); abortErr == nil {
+			resp.Body.Close()
+		}
+		return "", err
+	}
+	return etag, nil
+}
+
+func (c *Client) uploadParts(ctx context.Context, bucket, key, uploadID string, content io.Reader) (string, error) {
+	var complete completeMultipartUpload
+	part := make([]byte, c.cfg.PartSize)
+	for number := 1; ; number++ {
+		n, err := io.ReadFull(content, part)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return "", fmt.Errorf("failed to read object content: %w", err)
+		}
+
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		resp, err := c.do(ctx, http.MethodPut, bucket, key, query, nil, part[:n])
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", responseError(fmt.Sprintf("upload part %d of", number), bucket, key, resp)
+		}
+		resp.Body.Close()
+		complete.Parts = append(complete.Parts, completedPart{PartNumber: number, ETag: resp.Header.Get("ETag")})
+		if n < len(part) {
+			break
+		}
+	}
+
+	body, err := xml.Marshal(complete)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode multipart completion: %w", err)
+	}
+	resp, err := c.do(ctx, http.MethodPost, bucket, key, url.Values{"uploadId": {uploadID}}, http.Header{"Content-Type": {"application/xml"}}, body)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", responseError("complete multipart upload of", bucket, key, resp)
+	}
+	// S3 reports some completion failures with status 200 and an error
+	// document
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return "", fmt.Errorf("failed to read multipart completion of s3://%s/%s: %w", bucket, key, err)
+	}
+	var failure s3Error
+	if xml.Unmarshal(raw, &failure) == nil && failure.Code != "" {
+		return "", fmt.Errorf("failed to complete multipart upload of s3://%s/%s: %s: %s", bucket, key, failure.Code, failure.Message)
+	}
+	var result completeMultipartUploadResult
+	if err := xml.Unmarshal(raw, &result); err != nil {
+		return "", fmt.Errorf("invalid multipart completion of s3://%s/%s: %w", bucket, key, err)
+	}
+	return result.ETag, nil
+}
+
+// objectFrom describes the object resp is for
+func objectFrom(bucket, key string, resp *http.Response) *Object {
+	return &Object{
+		Bucket: bucket,
+		Key:    key,
+		SHA256: resp.Header.Get(sha256Header),
+		Size:   resp.ContentLength,
+		ETag:   resp.Header.Get("ETag"),
+	}
+}
+
+// Head describes key in bucket without reading it
+func (c *Client) Head(ctx context.Context, bucket, key string) (*Object, error) {
+	resp, err := c.do(ctx, http.MethodHead, bucket, key, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError("describe", bucket, key, resp)
+	}
+	resp.Body.Close()
+	return objectFrom(bucket, key, resp), nil
+}
+
+// Get reads key in bucket. Reading the content to its end fails with
+// ErrIntegrity when it does not match the size and hash stored with it.
+func (c *Client) Get(ctx context.Context, bucket, key string) (io.ReadCloser, *Object, error) {
+	resp, err := c.do(ctx, http.MethodGet, bucket, key, nil, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, responseError("download", bucket, key, resp)
+	}
+	obj := objectFrom(bucket, key, resp)
+	return &verifyingReader{body: resp.Body, obj: obj, hash: sha256.New()}, obj, nil
+}
+
+// verifyingReader checks an object's content against its metadata once it
+// has been read in full
+type verifyingReader struct {
+	body io.ReadCloser
+	obj  *Object
+	hash hash.Hash
+	read int64
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.body.Read(p)
+	v.hash.Write(p[:n])
+	v.read += int64(n)
+	if err == io.EOF {
+		if v.obj.Size >= 0 && v.read != v.obj.Size {
+			return n, fmt.Errorf("%w: %s is %d bytes, want %d", ErrIntegrity, v.obj.URI(), v.read, v.obj.Size)
+		}
+		if digest := hex.EncodeToString(v.hash.Sum(nil)); v.obj.SHA256 != "" && digest != v.obj.SHA256 {
+			return n, fmt.Errorf("%w: %s has sha256 %s, want %s", ErrIntegrity, v.obj.URI(), digest, v.obj.SHA256)
+		}
+	}
+	return n, err
+}
+
+func (v *verifyingReader) Close() error {
+	return v.body.Close()
+}
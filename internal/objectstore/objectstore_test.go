@@ -0,0 +1,184 @@
+package objectstore_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/objectstore"
+	"github.com/theblitlabs/parity-runner/internal/objectstore/objectstoretest"
+)
+
+var creds = objectstore.Credentials{AccessKeyID: "runner", SecretAccessKey: "runner-secret"}
+
+func newClient(t *testing.T, server *objectstoretest.Server, mutate func(*objectstore.Config)) *objectstore.Client {
+	t.Helper()
+	cfg := objectstore.Config{
+		Endpoint:    server.URL,
+		Region:      server.Region,
+		PathStyle:   true,
+		Credentials: creds,
+		PartSize:    objectstore.MinPartSize,
+		Buckets:     []string{"artifacts"},
+	}
+	if mutate != nil {
+		mutate(&cfg)
+	}
+	client, err := objectstore.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func sha(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// stream hides any Seek method so content has to be spooled
+type stream struct{ io.Reader }
+
+func TestPutAndGet(t *testing.T) {
+	server := objectstoretest.NewServer(t, "eu-west-1", creds)
+	client := newClient(t, server, func(cfg *objectstore.Config) {
+		cfg.Encryption = objectstore.Encryption{Mode: objectstore.SSEKMS, KMSKeyID: "key-1"}
+	})
+
+	for _, tt := range []struct {
+		name      string
+		size      int
+		seekable  bool
+		wantParts int
+	}{
+		{"single request", 1 << 10, true, 0},
+		{"multipart from a file", 2*objectstore.MinPartSize + 1, true, 3},
+		{"multipart from a stream", 2*objectstore.MinPartSize + 1, false, 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := bytes.Repeat([]byte("0123456789abcdef"), tt.size/16+1)[:tt.size]
+			var r io.Reader = bytes.NewReader(data)
+			if !tt.seekable {
+				r = stream{r}
+			}
+			before := server.Parts
+			key := "tasks/t-1/" + strings.ReplaceAll(tt.name, " ", "-") + ".bin"
+
+			obj, err := client.Put(context.Background(), "artifacts", key, r)
+			if err != nil {
+				t.Fatalf("Put() error = %v", err)
+			}
+			if obj.URI() != "s3://artifacts/"+key || obj.SHA256 != sha(data) || obj.Size != int64(tt.size) {
+				t.Errorf("Put() = %+v", obj)
+			}
+			if parts := server.Parts - before; parts != tt.wantParts {
+				t.Errorf("uploaded %d parts, want %d", parts, tt.wantParts)
+			}
+			stored, ok := server.Object("artifacts", key)
+			if !ok || !bytes.Equal(stored.Data, data) {
+				t.Fatal("stored object differs from the upload")
+			}
+			if stored.Header.Get("X-Amz-Meta-Sha256") != sha(data) ||
+				stored.Header.Get("X-Amz-Server-Side-Encryption") != objectstore.SSEKMS ||
+				stored.Header.Get("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id") != "key-1" {
+				t.Errorf("stored headers = %v", stored.Header)
+			}
+
+			body, head, err := client.Get(context.Background(), "artifacts", key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer body.Close()
+			got, err := io.ReadAll(body)
+			if err != nil || !bytes.Equal(got, data) || head.SHA256 != sha(data) {
+				t.Fatalf("Get() = %d bytes, %v", len(got), err)
+			}
+		})
+	}
+	if server.PendingUploads() != 0 {
+		t.Error("multipart upload left pending")
+	}
+}
+
+func TestGetDetectsIntegrityMismatch(t *testing.T) {
+	server := objectstoretest.NewServer(t, "eu-west-1", creds)
+	client := newClient(t, server, nil)
+	server.PutObject("artifacts", "weights.bin", []byte("tampered"), http.Header{"X-Amz-Meta-Sha256": {sha([]byte("original"))}})
+
+	body, _, err := client.Get(context.Background(), "artifacts", "weights.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if _, err := io.ReadAll(body); !errors.Is(err, objectstore.ErrIntegrity) {
+		t.Fatalf("ReadAll() error = %v, want ErrIntegrity", err)
+	}
+
+	if _, _, err := client.Get(context.Background(), "artifacts", "missing.bin"); !errors.Is(err, objectstore.ErrNotFound) {
+		t.Fatalf("Get() error = %v for a missing object, want ErrNotFound", err)
+	}
+}
+
+func TestCredentialIsolation(t *testing.T) {
+	server := objectstoretest.NewServer(t, "eu-west-1", creds)
+	client := newClient(t, server, nil)
+
+	if _, err := client.Put(context.Background(), "someone-elses", "x", strings.NewReader("x")); !errors.Is(err, objectstore.ErrBucketNotAllowed) {
+		t.Fatalf("Put() error = %v for an unlisted bucket, want ErrBucketNotAllowed", err)
+	}
+	if _, _, err := client.Get(context.Background(), "someone-elses", "x"); !errors.Is(err, objectstore.ErrBucketNotAllowed) {
+		t.Fatalf("Get() error = %v for an unlisted bucket, want ErrBucketNotAllowed", err)
+	}
+	if len(server.Requests) != 0 {
+		t.Fatalf("requests %v were sent for an unlisted bucket", server.Requests)
+	}
+
+	forged := newClient(t, server, func(cfg *objectstore.Config) {
+		cfg.Credentials.SecretAccessKey = "guessed"
+	})
+	if _, err := forged.Head(context.Background(), "artifacts", "x"); err == nil || errors.Is(err, objectstore.ErrNotFound) {
+		t.Fatalf("Head() error = %v with a wrong secret, want the signature rejected", err)
+	}
+
+	for _, uri := range []string{
+		"s3://key:secret@artifacts/x",
+		"s3://artifacts/x?X-Amz-Credential=other",
+		"s3://artifacts/",
+		"https://artifacts/x",
+	} {
+		if _, _, err := objectstore.ParseURI(uri); !errors.Is(err, objectstore.ErrInvalidURI) {
+			t.Errorf("ParseURI(%q) error = %v, want ErrInvalidURI", uri, err)
+		}
+	}
+	if bucket, key, err := objectstore.ParseURI("s3://artifacts/data/train.csv"); err != nil || bucket != "artifacts" || key != "data/train.csv" {
+		t.Errorf("ParseURI() = %s, %s, %v", bucket, key, err)
+	}
+}
+
+func TestNewValidatesConfig(t *testing.T) {
+	valid := objectstore.Config{Endpoint: "https://s3.example.com", Region: "us-east-1", Credentials: creds, Buckets: []string{"b"}}
+	for name, mutate := range map[string]func(*objectstore.Config){
+		"endpoint":       func(c *objectstore.Config) { c.Endpoint = "s3.example.com" },
+		"region":         func(c *objectstore.Config) { c.Region = "" },
+		"secret":         func(c *objectstore.Config) { c.Credentials.SecretAccessKey = "" },
+		"encryption":     func(c *objectstore.Config) { c.Encryption.Mode = "rot13" },
+		"kms key":        func(c *objectstore.Config) { c.Encryption.KMSKeyID = "k" },
+		"part size":      func(c *objectstore.Config) { c.PartSize = 1 << 20 },
+		"bucket missing": func(c *objectstore.Config) { c.Buckets = nil },
+	} {
+		cfg := valid
+		mutate(&cfg)
+		if _, err := objectstore.New(cfg); err == nil {
+			t.Errorf("%s: New() accepted an invalid config", name)
+		}
+	}
+	if _, err := objectstore.New(valid); err != nil {
+		t.Errorf("New() error = %v", err)
+	}
+}
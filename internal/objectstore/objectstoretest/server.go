@@ -0,0 +1,279 @@
+// Package objectstoretest runs an in-process S3-compatible server for
+// tests. It checks request signatures against the credentials it was given
+// and supports the single-request and multipart uploads, reads and
+// metadata lookups the objectstore client makes.
+package objectstoretest
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/objectstore"
+)
+
+// Object is a stored object as the server keeps it
+type Object struct {
+	Data   []byte
+	Header http.Header
+}
+
+type upload struct {
+	bucket, key string
+	header      http.Header
+	parts       map[int][]byte
+}
+
+// Server is a path-style S3 endpoint holding objects in memory
+type Server struct {
+	*httptest.Server
+	Region string
+
+	mu      sync.Mutex
+	secrets map[string]string
+	objects map[string]*Object
+	uploads map[string]*upload
+	nextID  int
+	// Parts counts the multipart parts received
+	Parts int
+	// Requests records each request's method and access key id
+	Requests []string
+}
+
+// NewServer starts a server accepting requests signed with creds in region,
+// closed when t ends
+func NewServer(t testing.TB, region string, creds objectstore.Credentials) *Server {
+	s := &Server{
+		Region:  region,
+		secrets: map[string]string{creds.AccessKeyID: creds.SecretAccessKey},
+		objects: make(map[string]*Object),
+		uploads: make(map[string]*upload),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Object returns the object stored as key in bucket
+func (s *Server) Object(bucket, key string) (*Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[bucket+"/"+key]
+	return obj, ok
+}
+
+// PutObject stores data as key in bucket with header, bypassing the API
+func (s *Server) PutObject(bucket, key string, data []byte, header http.Header) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if header == nil {
+		header = http.Header{}
+	}
+	s.objects[bucket+"/"+key] = &Object{Data: data, Header: header}
+}
+
+// PendingUploads counts multipart uploads neither completed nor aborted
+func (s *Server) PendingUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, message)
+}
+
+var authPattern = regexp.MustCompile(`^AWS4-HMAC-SHA256 Credential=([^/]+)/(\d{8})/([^/]+)/s3/aws4_request, SignedHeaders=([a-z0-9;-]+), Signature=([0-9a-f]{64})$`)
+
+// authenticate checks r's signature, returning the access key id it was
+// signed with
+func (s *Server) authenticate(r *http.Request, body []byte) (string, int, string) {
+	m := authPattern.FindStringSubmatch(r.Header.Get("Authorization"))
+	if m == nil {
+		return "", http.StatusForbidden, "AccessDenied"
+	}
+	accessKey, region, signedHeaders, signature := m[1], m[3], m[4], m[5]
+	secret, ok := s.secrets[accessKey]
+	if !ok {
+		return accessKey, http.StatusForbidden, "InvalidAccessKeyId"
+	}
+	if region != s.Region {
+		return accessKey, http.StatusBadRequest, "AuthorizationHeaderMalformed"
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if sum := sha256.Sum256(body); payloadHash != hex.EncodeToString(sum[:]) {
+		return accessKey, http.StatusBadRequest, "XAmzContentSHA256Mismatch"
+	}
+	signedAt, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return accessKey, http.StatusForbidden, "AccessDenied"
+	}
+
+	// Sign the same request again with only its signed headers
+	check, _ := http.NewRequest(r.Method, r.URL.String(), nil)
+	check.Host = r.Host
+	check.URL.Host = r.Host
+	for _, name := range strings.Split(signedHeaders, ";") {
+		if name != "host" {
+			check.Header[http.CanonicalHeaderKey(name)] = r.Header.Values(name)
+		}
+	}
+	objectstore.Sign(check, objectstore.Credentials{
+		AccessKeyID:     accessKey,
+		SecretAccessKey: secret,
+		SessionToken:    r.Header.Get("X-Amz-Security-Token"),
+	}, region, payloadHash, signedAt)
+	if !strings.HasSuffix(check.Header.Get("Authorization"), "Signature="+signature) {
+		return accessKey, http.StatusForbidden, "SignatureDoesNotMatch"
+	}
+	return accessKey, 0, ""
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	accessKey, status, code := s.authenticate(r, body)
+	s.Requests = append(s.Requests, r.Method+" "+accessKey)
+	if status != 0 {
+		writeError(w, status, code, "request rejected")
+		return
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket == "" || key == "" {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "path-style object requests only")
+		return
+	}
+	name := bucket + "/" + key
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.nextID++
+		id := strconv.Itoa(s.nextID)
+		s.uploads[id] = &upload{bucket: bucket, key: key, header: storedHeaders(r.Header), parts: make(map[int][]byte)}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", bucket, key, id)
+
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		up, ok := s.uploads[query.Get("uploadId")]
+		number, err := strconv.Atoi(query.Get("partNumber"))
+		if !ok || up.bucket != bucket || up.key != key || err != nil || number < 1 {
+			writeError(w, http.StatusNotFound, "NoSuchUpload", "unknown upload")
+			return
+		}
+		up.parts[number] = body
+		s.Parts++
+		w.Header().Set("ETag", etag(body))
+
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.complete(w, bucket, key, query.Get("uploadId"), body)
+
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+
+	case r.Method == http.MethodPut:
+		s.objects[name] = &Object{Data: body, Header: storedHeaders(r.Header)}
+		w.Header().Set("ETag", etag(body))
+
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		obj, ok := s.objects[name]
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "no such key")
+			return
+		}
+		for header, values := range obj.Header {
+			w.Header()[header] = values
+		}
+		w.Header().Set("ETag", etag(obj.Data))
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.Data)))
+		if r.Method == http.MethodGet {
+			w.Write(obj.Data)
+		}
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+// complete assembles upload id from the parts its completion lists
+func (s *Server) complete(w http.ResponseWriter, bucket, key, id string, body []byte) {
+	up, ok := s.uploads[id]
+	if !ok || up.bucket != bucket || up.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "unknown upload")
+		return
+	}
+	var request struct {
+		Parts []struct {
+			PartNumber int
+			ETag       string
+		} `xml:"Part"`
+	}
+	if err := xml.Unmarshal(body, &request); err != nil || len(request.Parts) == 0 {
+		writeError(w, http.StatusBadRequest, "MalformedXML", "invalid part list")
+		return
+	}
+	numbers := make([]int, len(request.Parts))
+	for i, part := range request.Parts {
+		numbers[i] = part.PartNumber
+	}
+	if !sort.IntsAreSorted(numbers) {
+		writeError(w, http.StatusBadRequest, "InvalidPartOrder", "parts out of order")
+		return
+	}
+
+	var data bytes.Buffer
+	for i, part := range request.Parts {
+		content, ok := up.parts[part.PartNumber]
+		if !ok || part.ETag != etag(content) {
+			writeError(w, http.StatusBadRequest, "InvalidPart", "unknown part")
+			return
+		}
+		if i < len(request.Parts)-1 && len(content) < objectstore.MinPartSize {
+			writeError(w, http.StatusBadRequest, "EntityTooSmall", "part too small")
+			return
+		}
+		data.Write(content)
+	}
+	delete(s.uploads, id)
+	s.objects[bucket+"/"+key] = &Object{Data: data.Bytes(), Header: up.header}
+	fmt.Fprintf(w, "<CompleteMultipartUploadResult><Bucket>%s</Bucket><Key>%s</Key><ETag>%s</ETag></CompleteMultipartUploadResult>", bucket, key, etag(data.Bytes()))
+}
+
+// storedHeaders keeps the metadata and encryption headers of an upload
+func storedHeaders(header http.Header) http.Header {
+	stored := http.Header{}
+	for name, values := range header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-meta-") || strings.HasPrefix(lower, "x-amz-server-side-encryption") {
+			stored[name] = values
+		}
+	}
+	return stored
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
@@ -0,0 +1,316 @@
+// Package overlay applies fleet configuration overlays: signed sets of
+// runner settings the server delivers with heartbeat responses, which take
+// precedence over local configuration until they expire
+package overlay
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var (
+	ErrNoFleetKey   = errors.New("no fleet public key configured")
+	ErrBadSignature = errors.New("overlay signature does not verify")
+	ErrStale        = errors.New("overlay is not newer than one already applied")
+	ErrWrongRunner  = errors.New("overlay was issued to other runners")
+	ErrExpired      = errors.New("overlay has expired")
+)
+
+// protected names settings that only local configuration may set. They are
+// never registered, so an overlay naming them is always rejected.
+var protected = map[string]bool{
+	"wallet_address":   true,
+	"private_key":      true,
+	"keystore":         true,
+	"server_url":       true,
+	"policy_file":      true,
+	"fleet_public_key": true,
+	"pinning_key":      true,
+	"tunnel_secret":    true,
+	"attestation_mode": true,
+}
+
+// IsProtected reports whether name is a security-sensitive setting that
+// overlays may not change
+func IsProtected(name string) bool {
+	return protected[name]
+}
+
+// Setting is a runner setting an overlay may change
+type Setting struct {
+	// Apply validates a value from an overlay and puts it into effect
+	Apply func(raw json.RawMessage) error
+	// Restore reinstates the locally configured value
+	Restore func()
+}
+
+// ParsePublicKey decodes a base64 Ed25519 public key
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode fleet public key: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("fleet public key is %d bytes, want %d", len(key), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// Manager verifies overlays, applies the registered settings they carry and
+// reverts them when the overlay expires
+type Manager struct {
+	publicKey ed25519.PublicKey
+	runnerID  string
+	maxTTL    time.Duration
+	clock     clock.Clock
+
+	mu          sync.Mutex
+	settings    map[string]Setting
+	version     int64
+	expiresAt   time.Time
+	overridden  map[string]bool
+	lastPayload string
+	// newest is the highest version ever applied, kept in statePath so that
+	// a restart does not let an older overlay back in
+	newest    int64
+	statePath string
+	// resumable is the payload of the newest overlay a previous run applied
+	resumable string
+}
+
+// NewManager verifies overlays with publicKey, accepting only those issued
+// to runnerID or to the whole fleet, and keeps each in effect for at most
+// maxTTL, which is also the lifetime of overlays that set no expiry
+func NewManager(publicKey ed25519.PublicKey, runnerID string, maxTTL time.Duration) *Manager {
+	if maxTTL <= 0 {
+		maxTTL = time.Hour
+	}
+	return &Manager{
+		publicKey:  publicKey,
+		runnerID:   runnerID,
+		maxTTL:     maxTTL,
+		clock:      clock.Real(),
+		settings:   make(map[string]Setting),
+		overridden: make(map[string]bool),
+	}
+}
+
+func (m *Manager) SetClock(clk clock.Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = clk
+}
+
+// overlayState is what the manager keeps on disk between runs
+type overlayState struct {
+	// Newest is the highest version ever applied
+	Newest int64 `json:"newest"`
+	// Payload is the overlay of that version, which may be applied again
+	// once after a restart
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// SetStatePath keeps the highest applied version in path, reading the one a
+// previous run left there. Overlays older than it are rejected from then on,
+// and the overlay of that version is taken again once, as the server resends
+// the overlay in effect after a restart.
+func (m *Manager) SetStatePath(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create overlay state directory: %w", err)
+	}
+	m.statePath = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read overlay state: %w", err)
+	}
+	var state overlayState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to decode overlay state: %w", err)
+	}
+	if state.Newest > m.newest {
+		m.newest = state.Newest
+		m.resumable = string(state.Payload)
+	}
+	return nil
+}
+
+// saveState records the overlay of payload as the newest applied; the
+// caller holds mu
+func (m *Manager) saveState(version int64, payload json.RawMessage) error {
+	if m.statePath == "" {
+		return nil
+	}
+	data, err := json.Marshal(overlayState{Newest: version, Payload: payload})
+	if err != nil {
+		return fmt.Errorf("failed to marshal overlay state: %w", err)
+	}
+	if err := atrest.WriteFileAtomic(m.statePath, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write overlay state: %w", err)
+	}
+	return nil
+}
+
+// targets returns the runners payload is issued to, none for the whole fleet
+func targets(payload *models.ConfigOverlayPayload) []string {
+	runners := slices.Clone(payload.RunnerIDs)
+	if payload.RunnerID != "" {
+		runners = append(runners, payload.RunnerID)
+	}
+	if slices.Contains(runners, models.AllRunners) {
+		return nil
+	}
+	return runners
+}
+
+// Register makes name remotely configurable
+func (m *Manager) Register(name string, setting Setting) error {
+	if IsProtected(name) {
+		return fmt.Errorf("setting %s is security-sensitive and cannot be remotely configured", name)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settings[name] = setting
+	return nil
+}
+
+// AppliedVersion returns the version of the overlay in effect, or 0 when
+// the runner runs on local configuration alone
+func (m *Manager) AppliedVersion() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.version
+}
+
+// Apply verifies overlay and puts its settings into effect. Settings an
+// earlier overlay changed but this one omits revert to local configuration.
+// Resending the overlay in effect only extends its expiry; any other overlay
+// must be newer than every one applied before, even one since expired.
+func (m *Manager) Apply(overlay *models.ConfigOverlay) error {
+	log := gologger.WithComponent("overlay")
+
+	if len(m.publicKey) == 0 {
+		return ErrNoFleetKey
+	}
+	signature, err := base64.StdEncoding.DecodeString(overlay.Signature)
+	if err != nil || !ed25519.Verify(m.publicKey, overlay.Payload, signature) {
+		return ErrBadSignature
+	}
+	var payload models.ConfigOverlayPayload
+	if err := json.Unmarshal(overlay.Payload, &payload); err != nil {
+		return fmt.Errorf("failed to decode overlay: %w", err)
+	}
+	if payload.Version <= 0 {
+		return fmt.Errorf("overlay has no version")
+	}
+	if runners := targets(&payload); len(runners) > 0 && !slices.Contains(runners, m.runnerID) {
+		return fmt.Errorf("%w: %q", ErrWrongRunner, runners)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.clock.Now()
+	expiresAt := now.Add(m.maxTTL)
+	if !payload.ExpiresAt.IsZero() && payload.ExpiresAt.Before(expiresAt) {
+		expiresAt = payload.ExpiresAt
+	}
+	if !expiresAt.After(now) {
+		return ErrExpired
+	}
+	if m.version != 0 && payload.Version == m.version && string(overlay.Payload) == m.lastPayload {
+		m.expiresAt = expiresAt
+		return nil
+	}
+	resumed := payload.Version == m.newest && string(overlay.Payload) == m.resumable
+	if payload.Version <= m.newest && !resumed {
+		return ErrStale
+	}
+	// The version is recorded before any setting changes, so that no
+	// restart lets an older overlay in
+	if err := m.saveState(payload.Version, overlay.Payload); err != nil {
+		return err
+	}
+
+	applied := make(map[string]bool)
+	names := make([]string, 0, len(payload.Settings))
+	for name := range payload.Settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		setting, ok := m.settings[name]
+		switch {
+		case IsProtected(name):
+			log.Warn().Str("setting", name).Int64("version", payload.Version).Msg("Ignoring overlay setting - local configuration always wins for security-sensitive settings")
+			continue
+		case !ok:
+			log.Warn().Str("setting", name).Int64("version", payload.Version).Msg("Ignoring overlay setting - not remotely configurable")
+			continue
+		}
+		if err := setting.Apply(payload.Settings[name]); err != nil {
+			log.Warn().Err(err).Str("setting", name).Int64("version", payload.Version).Msg("Ignoring invalid overlay setting")
+			continue
+		}
+		applied[name] = true
+	}
+	for name := range m.overridden {
+		if !applied[name] {
+			m.settings[name].Restore()
+		}
+	}
+
+	m.overridden = applied
+	m.version = payload.Version
+	m.newest = payload.Version
+	m.resumable = ""
+	m.expiresAt = expiresAt
+	m.lastPayload = string(overlay.Payload)
+
+	log.Info().
+		Int64("version", payload.Version).
+		Int("settings", len(applied)).
+		Time("expires_at", expiresAt).
+		Msg("Applied fleet configuration overlay")
+	return nil
+}
+
+// Expire reverts to local configuration once the overlay in effect expires
+func (m *Manager) Expire() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.version == 0 || m.clock.Now().Before(m.expiresAt) {
+		return
+	}
+	for name := range m.overridden {
+		m.settings[name].Restore()
+	}
+
+	log := gologger.WithComponent("overlay")
+	log.Info().Int64("version", m.version).Msg("Fleet configuration overlay expired - reverted to local configuration")
+
+	m.overridden = make(map[string]bool)
+	m.version = 0
+	m.lastPayload = ""
+}
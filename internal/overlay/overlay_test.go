@@ -0,0 +1,293 @@
+package overlay
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var start = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+const testRunnerID = "device-1"
+
+// signOverlay signs payload, issued to the test runner unless it names
+// another
+func signOverlay(t *testing.T, key ed25519.PrivateKey, payload models.ConfigOverlayPayload) *models.ConfigOverlay {
+	t.Helper()
+	if payload.RunnerID == "" {
+		payload.RunnerID = testRunnerID
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.ConfigOverlay{
+		Payload:   data,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, data)),
+	}
+}
+
+func settings(values map[string]string) map[string]json.RawMessage {
+	raw := make(map[string]json.RawMessage, len(values))
+	for name, value := range values {
+		raw[name], _ = json.Marshal(value)
+	}
+	return raw
+}
+
+type testRunner struct {
+	heartbeat time.Duration
+	preflight string
+}
+
+func newTestManager(t *testing.T) (*Manager, ed25519.PrivateKey, *testRunner, *clocktest.Fake) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := &testRunner{heartbeat: 30 * time.Second, preflight: "warn"}
+	clk := clocktest.NewFake(start)
+
+	m := NewManager(pub, testRunnerID, time.Hour)
+	m.SetClock(clk)
+	if err := m.Register("heartbeat_interval", DurationSetting(5*time.Second, time.Hour, runner.heartbeat, func(d time.Duration) { runner.heartbeat = d })); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Register("docker_preflight", ChoiceSetting([]string{"off", "warn", "abandon"}, runner.preflight, func(s string) { runner.preflight = s })); err != nil {
+		t.Fatal(err)
+	}
+	return m, priv, runner, clk
+}
+
+func TestApplyVerifiesSignature(t *testing.T) {
+	m, _, runner, _ := newTestManager(t)
+	_, other, _ := ed25519.GenerateKey(nil)
+
+	forged := signOverlay(t, other, models.ConfigOverlayPayload{Version: 1, Settings: settings(map[string]string{"heartbeat_interval": "10s"})})
+	if err := m.Apply(forged); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Apply() error = %v, want ErrBadSignature", err)
+	}
+
+	m2, key, _, _ := newTestManager(t)
+	tampered := signOverlay(t, key, models.ConfigOverlayPayload{Version: 1, Settings: settings(map[string]string{"heartbeat_interval": "10s"})})
+	tampered.Payload = json.RawMessage(`{"runner_id":"device-1","version":1,"settings":{"heartbeat_interval":"5s"}}`)
+	if err := m2.Apply(tampered); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Apply() error = %v, want ErrBadSignature for a tampered payload", err)
+	}
+
+	if runner.heartbeat != 30*time.Second || m.AppliedVersion() != 0 {
+		t.Fatalf("rejected overlay took effect: heartbeat=%s version=%d", runner.heartbeat, m.AppliedVersion())
+	}
+}
+
+func TestApplyOnlyWhitelistedSettings(t *testing.T) {
+	m, key, runner, _ := newTestManager(t)
+
+	overlay := signOverlay(t, key, models.ConfigOverlayPayload{Version: 3, Settings: settings(map[string]string{
+		"heartbeat_interval": "10s",
+		"docker_preflight":   "sometimes",
+		"server_url":         "http://attacker.example",
+		"max_concurrency":    "64",
+	})})
+	if err := m.Apply(overlay); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if runner.heartbeat != 10*time.Second {
+		t.Fatalf("heartbeat = %s, want 10s", runner.heartbeat)
+	}
+	if runner.preflight != "warn" {
+		t.Fatalf("invalid preflight value applied: %q", runner.preflight)
+	}
+	if m.AppliedVersion() != 3 {
+		t.Fatalf("AppliedVersion() = %d, want 3", m.AppliedVersion())
+	}
+}
+
+func TestProtectedSettingsCannotBeRegistered(t *testing.T) {
+	m, _, _, _ := newTestManager(t)
+	for _, name := range []string{"server_url", "private_key", "fleet_public_key"} {
+		if err := m.Register(name, ChoiceSetting([]string{"x"}, "", func(string) {})); err == nil {
+			t.Fatalf("Register(%q) succeeded, want local configuration to win", name)
+		}
+	}
+}
+
+func TestApplyRejectsStaleOverlays(t *testing.T) {
+	m, key, runner, _ := newTestManager(t)
+
+	if err := m.Apply(signOverlay(t, key, models.ConfigOverlayPayload{Version: 5, Settings: settings(map[string]string{"heartbeat_interval": "10s"})})); err != nil {
+		t.Fatal(err)
+	}
+	err := m.Apply(signOverlay(t, key, models.ConfigOverlayPayload{Version: 4, Settings: settings(map[string]string{"heartbeat_interval": "20s"})}))
+	if !errors.Is(err, ErrStale) {
+		t.Fatalf("Apply() error = %v, want ErrStale", err)
+	}
+	if runner.heartbeat != 10*time.Second {
+		t.Fatalf("stale overlay took effect: heartbeat=%s", runner.heartbeat)
+	}
+}
+
+func TestApplyRejectsReplayedOverlays(t *testing.T) {
+	m, key, runner, clk := newTestManager(t)
+
+	captured := signOverlay(t, key, models.ConfigOverlayPayload{Version: 5, Settings: settings(map[string]string{"heartbeat_interval": "10s"})})
+	if err := m.Apply(captured); err != nil {
+		t.Fatal(err)
+	}
+	// Another overlay of the same version is no newer
+	if err := m.Apply(signOverlay(t, key, models.ConfigOverlayPayload{Version: 5, Settings: settings(map[string]string{"heartbeat_interval": "20s"})})); !errors.Is(err, ErrStale) {
+		t.Fatalf("Apply() error = %v, want ErrStale for a second overlay of version 5", err)
+	}
+
+	clk.Advance(time.Hour)
+	m.Expire()
+	if err := m.Apply(captured); !errors.Is(err, ErrStale) {
+		t.Fatalf("Apply() error = %v, want ErrStale for an expired overlay sent again", err)
+	}
+	if runner.heartbeat != 30*time.Second || m.AppliedVersion() != 0 {
+		t.Fatalf("replayed overlay took effect: heartbeat=%s version=%d", runner.heartbeat, m.AppliedVersion())
+	}
+}
+
+func TestApplyRejectsOverlaysForOtherRunners(t *testing.T) {
+	m, key, runner, _ := newTestManager(t)
+
+	err := m.Apply(signOverlay(t, key, models.ConfigOverlayPayload{RunnerID: "device-2", Version: 1, Settings: settings(map[string]string{"heartbeat_interval": "10s"})}))
+	if !errors.Is(err, ErrWrongRunner) {
+		t.Fatalf("Apply() error = %v, want ErrWrongRunner", err)
+	}
+	if runner.heartbeat != 30*time.Second {
+		t.Fatalf("overlay for another runner took effect: heartbeat=%s", runner.heartbeat)
+	}
+}
+
+func TestOmittedSettingsRevert(t *testing.T) {
+	m, key, runner, _ := newTestManager(t)
+
+	if err := m.Apply(signOverlay(t, key, models.ConfigOverlayPayload{Version: 1, Settings: settings(map[string]string{
+		"heartbeat_interval": "10s",
+		"docker_preflight":   "abandon",
+	})})); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(signOverlay(t, key, models.ConfigOverlayPayload{Version: 2, Settings: settings(map[string]string{
+		"docker_preflight": "off",
+	})})); err != nil {
+		t.Fatal(err)
+	}
+	if runner.heartbeat != 30*time.Second || runner.preflight != "off" {
+		t.Fatalf("got heartbeat=%s preflight=%s, want 30s and off", runner.heartbeat, runner.preflight)
+	}
+}
+
+func TestExpiryRevertsToLocalConfiguration(t *testing.T) {
+	m, key, runner, clk := newTestManager(t)
+
+	if err := m.Apply(signOverlay(t, key, models.ConfigOverlayPayload{
+		Version:   7,
+		ExpiresAt: start.Add(10 * time.Minute),
+		Settings:  settings(map[string]string{"heartbeat_interval": "10s", "docker_preflight": "abandon"}),
+	})); err != nil {
+		t.Fatal(err)
+	}
+
+	clk.Advance(9 * time.Minute)
+	m.Expire()
+	if m.AppliedVersion() != 7 || runner.heartbeat != 10*time.Second {
+		t.Fatalf("overlay reverted before it expired")
+	}
+
+	clk.Advance(time.Minute)
+	m.Expire()
+	if m.AppliedVersion() != 0 {
+		t.Fatalf("AppliedVersion() = %d after expiry, want 0", m.AppliedVersion())
+	}
+	if runner.heartbeat != 30*time.Second || runner.preflight != "warn" {
+		t.Fatalf("got heartbeat=%s preflight=%s after expiry, want local 30s and warn", runner.heartbeat, runner.preflight)
+	}
+
+	// An overlay that expired in transit never takes effect
+	err := m.Apply(signOverlay(t, key, models.ConfigOverlayPayload{
+		Version:   8,
+		ExpiresAt: start.Add(5 * time.Minute),
+		Settings:  settings(map[string]string{"heartbeat_interval": "10s"}),
+	}))
+	if !errors.Is(err, ErrExpired) {
+		t.Fatalf("Apply() error = %v, want ErrExpired", err)
+	}
+}
+
+func TestMaxTTLCapsOverlayLifetime(t *testing.T) {
+	m, key, runner, clk := newTestManager(t)
+
+	if err := m.Apply(signOverlay(t, key, models.ConfigOverlayPayload{
+		Version:  1,
+		Settings: settings(map[string]string{"heartbeat_interval": "10s"}),
+	})); err != nil {
+		t.Fatal(err)
+	}
+	clk.Advance(time.Hour)
+	m.Expire()
+	if runner.heartbeat != 30*time.Second {
+		t.Fatalf("overlay without an expiry outlived the maximum TTL")
+	}
+}
+
+func TestApplyAcceptsFleetWideOverlays(t *testing.T) {
+	m, key, runner, _ := newTestManager(t)
+
+	if err := m.Apply(signOverlay(t, key, models.ConfigOverlayPayload{RunnerID: models.AllRunners, Version: 1, Settings: settings(map[string]string{"heartbeat_interval": "10s"})})); err != nil {
+		t.Fatalf("Apply() wildcard error = %v", err)
+	}
+	if err := m.Apply(signOverlay(t, key, models.ConfigOverlayPayload{RunnerIDs: []string{"device-2", testRunnerID}, RunnerID: "device-3", Version: 2, Settings: settings(map[string]string{"heartbeat_interval": "20s"})})); err != nil {
+		t.Fatalf("Apply() listed error = %v", err)
+	}
+	if runner.heartbeat != 20*time.Second {
+		t.Fatalf("heartbeat = %s, want the listed overlay's 20s", runner.heartbeat)
+	}
+	err := m.Apply(signOverlay(t, key, models.ConfigOverlayPayload{RunnerIDs: []string{"device-2", "device-3"}, RunnerID: "device-4", Version: 3, Settings: settings(map[string]string{"heartbeat_interval": "30s"})}))
+	if !errors.Is(err, ErrWrongRunner) {
+		t.Fatalf("Apply() error = %v, want ErrWrongRunner for a list without this runner", err)
+	}
+}
+
+func TestNewestVersionSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "overlay.json")
+	m, key, _, _ := newTestManager(t)
+	if err := m.SetStatePath(path); err != nil {
+		t.Fatal(err)
+	}
+	older := signOverlay(t, key, models.ConfigOverlayPayload{Version: 4, Settings: settings(map[string]string{"heartbeat_interval": "20s"})})
+	if err := m.Apply(older); err != nil {
+		t.Fatal(err)
+	}
+	current := signOverlay(t, key, models.ConfigOverlayPayload{Version: 5, Settings: settings(map[string]string{"heartbeat_interval": "10s"})})
+	if err := m.Apply(current); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted runner takes the overlay in effect again once, but
+	// nothing older
+	restarted, _, runner, _ := newTestManager(t)
+	restarted.publicKey = m.publicKey
+	if err := restarted.SetStatePath(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := restarted.Apply(older); !errors.Is(err, ErrStale) {
+		t.Fatalf("Apply() error = %v, want ErrStale for an overlay older than one applied before the restart", err)
+	}
+	if err := restarted.Apply(current); err != nil {
+		t.Fatalf("Apply() error = %v for the overlay in effect before the restart", err)
+	}
+	if runner.heartbeat != 10*time.Second || restarted.AppliedVersion() != 5 {
+		t.Fatalf("heartbeat=%s version=%d, want the resumed overlay", runner.heartbeat, restarted.AppliedVersion())
+	}
+}
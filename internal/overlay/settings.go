@@ -0,0 +1,51 @@
+package overlay
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DurationSetting accepts a duration string such as "30s" within [min, max]
+// and passes it to set, restoring local when the overlay ends
+func DurationSetting(min, max, local time.Duration, set func(time.Duration)) Setting {
+	return Setting{
+		Apply: func(raw json.RawMessage) error {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return fmt.Errorf("want a duration string: %w", err)
+			}
+			d, err := time.ParseDuration(s)
+			if err != nil {
+				return err
+			}
+			if d < min || d > max {
+				return fmt.Errorf("%s is outside [%s, %s]", d, min, max)
+			}
+			set(d)
+			return nil
+		},
+		Restore: func() { set(local) },
+	}
+}
+
+// ChoiceSetting accepts one of choices and passes it to set, restoring
+// local when the overlay ends
+func ChoiceSetting(choices []string, local string, set func(string)) Setting {
+	return Setting{
+		Apply: func(raw json.RawMessage) error {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return fmt.Errorf("want a string: %w", err)
+			}
+			for _, choice := range choices {
+				if s == choice {
+					set(s)
+					return nil
+				}
+			}
+			return fmt.Errorf("%q is not one of %v", s, choices)
+		},
+		Restore: func() { set(local) },
+	}
+}
@@ -0,0 +1,75 @@
+package poison
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// FileStore keeps failures in a directory the fleet shares, such as an NFS
+// mount, as a file of JSON lines per task. Each attempt is appended with a
+// single write, so that concurrent runners never interleave their lines.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create poison directory: %w", err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+// Append implements Store
+func (s *FileStore) Append(ctx context.Context, taskID string, attempt models.PoisonAttempt) ([]models.PoisonAttempt, error) {
+	if taskID == "" || strings.ContainsAny(taskID, `/\`) || taskID == "." || taskID == ".." {
+		return nil, fmt.Errorf("invalid task ID %q", taskID)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	line, err := json.Marshal(attempt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal attempt: %w", err)
+	}
+	path := filepath.Join(s.dir, taskID+".jsonl")
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open task failures: %w", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to append task failure: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to append task failure: %w", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task failures: %w", err)
+	}
+	var attempts []models.PoisonAttempt
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var a models.PoisonAttempt
+		if err := json.Unmarshal(scanner.Bytes(), &a); err != nil {
+			// A line torn by a runner that crashed mid-write
+			continue
+		}
+		attempts = append(attempts, a)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read task failures: %w", err)
+	}
+	return attempts, nil
+}
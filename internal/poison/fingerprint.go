@@ -0,0 +1,104 @@
+package poison
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// tailLines is how many of a failure's last output lines its fingerprint
+// covers; crashes print their cause last, after output that varies with
+// the input
+const tailLines = 20
+
+// maxSummaryLength bounds an attempt's summary
+const maxSummaryLength = 200
+
+// Failure is what a failed execution left behind
+type Failure struct {
+	TaskType models.TaskType
+	ExitCode int
+	Error    string
+	Output   string
+}
+
+// noise is replaced in failures before they are fingerprinted, most
+// specific first, so that one crash fingerprints alike on every host and
+// run: whatever its paths, timestamps, container IDs, addresses or sizes
+var noise = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)\b([a-z_-]*(?:key|secret|token|passw(?:or)?d?|auth|credential|mnemonic|signature)[a-z_-]*)(["']?\s*[=:]\s*["']?)[^\s"',;&]+`), "$1$2<redacted>"},
+	{regexp.MustCompile(`\b\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:?\d{2})?`), "<time>"},
+	{regexp.MustCompile(`\b\d{4}[-/]\d{2}[-/]\d{2}\b`), "<time>"},
+	{regexp.MustCompile(`\b\d{1,2}:\d{2}:\d{2}(\.\d+)?\b`), "<time>"},
+	{regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://\S+`), "<url>"},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`), "<hex>"},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{12,}\b`), "<hex>"},
+	{regexp.MustCompile(`\b[A-Za-z]:\\[^\s"':]*`), "<path>"},
+	{regexp.MustCompile(`(^|[\s"'=(\[,])~?(/[^\s"'(),:\]]+)+`), "$1<path>"},
+	{regexp.MustCompile(`\d+`), "<n>"},
+	{regexp.MustCompile(`[ \t]+`), " "},
+}
+
+// normalize strips the noise from each line of s, dropping empty lines
+func normalize(s string) []string {
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		for _, n := range noise {
+			line = n.pattern.ReplaceAllString(line, n.replacement)
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Fingerprint identifies how f failed apart from the host and run it failed
+// on: its task type, exit code, error and the last lines of its output
+func Fingerprint(f Failure) string {
+	output := normalize(f.Output)
+	if len(output) > tailLines {
+		output = output[len(output)-tailLines:]
+	}
+	h := sha256.New()
+	for _, part := range []string{
+		string(f.TaskType),
+		strconv.Itoa(f.ExitCode),
+		strings.Join(normalize(f.Error), "\n"),
+		strings.Join(output, "\n"),
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// summary returns the last line of f's error, or else of its output, with
+// the noise stripped
+func summary(f Failure) string {
+	lines := normalize(f.Error)
+	if len(lines) == 0 {
+		lines = normalize(f.Output)
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	s := lines[len(lines)-1]
+	if len(s) <= maxSummaryLength {
+		return s
+	}
+	cut := maxSummaryLength
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut] + "…"
+}
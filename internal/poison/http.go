@@ -0,0 +1,61 @@
+package poison
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// HTTPStore keeps failures in an HTTP service.
+//
+// POST {url}/failures/{taskID} with an attempt records it, answering with
+// every attempt recorded for the task as a JSON array, oldest first.
+type HTTPStore struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPStore(baseURL string) *HTTPStore {
+	return &HTTPStore{
+		url:    strings.TrimSuffix(baseURL, "/"),
+		client: &http.Client{},
+	}
+}
+
+// Append implements Store
+func (s *HTTPStore) Append(ctx context.Context, taskID string, attempt models.PoisonAttempt) ([]models.PoisonAttempt, error) {
+	body, err := json.Marshal(attempt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal attempt: %w", err)
+	}
+	endpoint := fmt.Sprintf("%s/failures/%s", s.url, url.PathEscape(taskID))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create failure request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP POST failed for %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failure store unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+	var attempts []models.PoisonAttempt
+	if err := json.NewDecoder(resp.Body).Decode(&attempts); err != nil {
+		return nil, fmt.Errorf("failed to decode task failures: %w", err)
+	}
+	return attempts, nil
+}
@@ -0,0 +1,189 @@
+// Package poison detects tasks that keep failing the same way. Each failed
+// execution is recorded under a fingerprint of how it failed, locally and,
+// for fleets, in a store the fleet shares; once a task has failed Threshold
+// times with one fingerprint it is poisoned. A poisoned task is abandoned
+// with the failures that poisoned it, so the server can quarantine it, and
+// never claimed again by the runner, across restarts. The shared store fails
+// open: when it is unreachable, failures are counted locally.
+package poison
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// forgetAfter is how long the failures of a task that was not poisoned are
+// kept after its last one; poisoned tasks are kept for good
+const forgetAfter = 7 * 24 * time.Hour
+
+// Store keeps the failures of tasks for the fleet
+type Store interface {
+	// Append records attempt for the task and returns every attempt the
+	// fleet recorded for it, oldest first
+	Append(ctx context.Context, taskID string, attempt models.PoisonAttempt) ([]models.PoisonAttempt, error)
+}
+
+type record struct {
+	Attempts []models.PoisonAttempt `json:"attempts"`
+	Poisoned *models.PoisonReport   `json:"poisoned,omitempty"`
+}
+
+// Tracker records the failures of tasks, persisting them to a file
+type Tracker struct {
+	path      string
+	runner    string
+	threshold int
+	clock     clock.Clock
+	store     Store
+	timeout   time.Duration
+
+	mu    sync.Mutex
+	tasks map[string]*record
+}
+
+// Open returns a tracker recording failures as runner in the file at path,
+// reading the failures recorded there before. A task is poisoned once it
+// failed threshold times the same way.
+func Open(path, runner string, threshold int) (*Tracker, error) {
+	if threshold < 1 {
+		return nil, fmt.Errorf("poison threshold must be at least 1, got %d", threshold)
+	}
+	t := &Tracker{
+		path:      path,
+		runner:    runner,
+		threshold: threshold,
+		clock:     clock.Real(),
+		tasks:     make(map[string]*record),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read poisoned tasks: %w", err)
+	}
+	if err := json.Unmarshal(data, &t.tasks); err != nil {
+		return nil, fmt.Errorf("invalid poisoned tasks file %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// SetClock replaces the clock failures are timed by
+func (t *Tracker) SetClock(c clock.Clock) {
+	t.clock = c
+}
+
+// SetStore counts failures across the fleet through store, bounding each
+// call by timeout
+func (t *Tracker) SetStore(store Store, timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	t.store = store
+	t.timeout = timeout
+}
+
+// Poisoned returns the report a task was poisoned with, or nil when it was
+// not
+func (t *Tracker) Poisoned(taskID string) *models.PoisonReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if rec := t.tasks[taskID]; rec != nil {
+		return rec.Poisoned
+	}
+	return nil
+}
+
+// Record records a failed execution of a task. It returns the task's
+// report when the task is poisoned, by this failure or before. The report
+// is returned even when it could not be persisted, with the error.
+func (t *Tracker) Record(taskID string, failure Failure) (*models.PoisonReport, error) {
+	attempt := models.PoisonAttempt{
+		Fingerprint: Fingerprint(failure),
+		Runner:      t.runner,
+		ExitCode:    failure.ExitCode,
+		Summary:     summary(failure),
+		FailedAt:    t.clock.Now().UTC(),
+	}
+	shared := t.share(taskID, attempt)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rec := t.tasks[taskID]
+	if rec == nil {
+		rec = &record{}
+		t.tasks[taskID] = rec
+	}
+	if shared != nil {
+		rec.Attempts = shared
+	} else {
+		rec.Attempts = append(rec.Attempts, attempt)
+	}
+	if rec.Poisoned == nil {
+		count := 0
+		for _, a := range rec.Attempts {
+			if a.Fingerprint == attempt.Fingerprint {
+				count++
+			}
+		}
+		if count >= t.threshold {
+			rec.Poisoned = &models.PoisonReport{
+				Fingerprint: attempt.Fingerprint,
+				Attempts:    append([]models.PoisonAttempt(nil), rec.Attempts...),
+			}
+		}
+	}
+	return rec.Poisoned, t.save()
+}
+
+// share appends attempt to the fleet's store, returning the fleet's attempts
+// for the task, or nil when there is no store or it is unreachable
+func (t *Tracker) share(taskID string, attempt models.PoisonAttempt) []models.PoisonAttempt {
+	if t.store == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	attempts, err := t.store.Append(ctx, taskID, attempt)
+	if err != nil {
+		log := gologger.WithComponent("poison")
+		log.Warn().Err(err).Str("id", taskID).Msg("Poison store unreachable, counting failures locally")
+		return nil
+	}
+	return attempts
+}
+
+// save persists the records, forgetting the stale failures of tasks that
+// were not poisoned. Callers hold mu.
+func (t *Tracker) save() error {
+	now := t.clock.Now()
+	for taskID, rec := range t.tasks {
+		if rec.Poisoned != nil || len(rec.Attempts) == 0 {
+			continue
+		}
+		if now.Sub(rec.Attempts[len(rec.Attempts)-1].FailedAt) > forgetAfter {
+			delete(t.tasks, taskID)
+		}
+	}
+	data, err := json.Marshal(t.tasks)
+	if err != nil {
+		return fmt.Errorf("failed to marshal poisoned tasks: %w", err)
+	}
+	if err := atrest.WriteFileAtomic(t.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to persist poisoned tasks: %w", err)
+	}
+	return nil
+}
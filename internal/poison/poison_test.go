@@ -0,0 +1,238 @@
+package poison
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func TestFingerprintIgnoresHostNoise(t *testing.T) {
+	crash := func(dir, when, container string, addr string) Failure {
+		return Failure{
+			TaskType: models.TaskTypeDocker,
+			ExitCode: 2,
+			Error:    "container " + container + " exited with code 2",
+			Output: "loading dataset from " + dir + "/data/train.csv\n" +
+				when + " INFO epoch 1 loss=0.4312\n" +
+				"panic: runtime error: index out of range [7] with length 7\n\n" +
+				"goroutine 1 [running]:\n" +
+				"main.parse(" + addr + ")\n" +
+				"\t" + dir + "/src/parse.go:42 +0x1d\n",
+		}
+	}
+	base := Fingerprint(crash("/tmp/parity-task-1234", "2026-10-16T08:12:44Z", "3f2a9c81d4e5b6a7", "0xc000012345"))
+
+	same := []struct {
+		name    string
+		failure Failure
+	}{
+		{"other host", crash("/var/lib/parity/tasks/5678", "2026-10-16T08:12:44Z", "3f2a9c81d4e5b6a7", "0xc000012345")},
+		{"other time", crash("/tmp/parity-task-1234", "2026-11-02 23:01:09.551+02:00", "3f2a9c81d4e5b6a7", "0xc000012345")},
+		{"other container", crash("/tmp/parity-task-1234", "2026-10-16T08:12:44Z", "9b8e7d6c5f4a3b2c1d0e", "0xc000012345")},
+		{"other address", crash("/tmp/parity-task-1234", "2026-10-16T08:12:44Z", "3f2a9c81d4e5b6a7", "0xc0000fffff")},
+		{"windows paths", crash(`C:\Users\runner\tasks\1234`, "2026-10-16T08:12:44Z", "3f2a9c81d4e5b6a7", "0xc000012345")},
+		{"other whitespace", func() Failure {
+			f := crash("/tmp/parity-task-1234", "2026-10-16T08:12:44Z", "3f2a9c81d4e5b6a7", "0xc000012345")
+			f.Output = "\n\n" + f.Output + "\n   \n"
+			return f
+		}()},
+	}
+	for _, tt := range same {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Fingerprint(tt.failure); got != base {
+				t.Errorf("Fingerprint() = %s, want %s as for the same crash elsewhere", got, base)
+			}
+		})
+	}
+
+	different := []struct {
+		name    string
+		failure Failure
+	}{
+		{"other error", Failure{TaskType: models.TaskTypeDocker, ExitCode: 2, Error: "container exited with code 2", Output: "panic: runtime error: invalid memory address or nil pointer dereference"}},
+		{"other exit code", func() Failure {
+			f := crash("/tmp/parity-task-1234", "2026-10-16T08:12:44Z", "3f2a9c81d4e5b6a7", "0xc000012345")
+			f.ExitCode = 137
+			return f
+		}()},
+		{"other task type", func() Failure {
+			f := crash("/tmp/parity-task-1234", "2026-10-16T08:12:44Z", "3f2a9c81d4e5b6a7", "0xc000012345")
+			f.TaskType = models.TaskTypeCommand
+			return f
+		}()},
+	}
+	for _, tt := range different {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Fingerprint(tt.failure); got == base {
+				t.Errorf("Fingerprint() = %s for a different failure", got)
+			}
+		})
+	}
+}
+
+func TestSummaryRedactsSecrets(t *testing.T) {
+	got := summary(Failure{Error: "auth failed\nGET https://user:pw@example.com/x?token=abc failed: api_key=s3cr3t at /home/me/run.sh:12"})
+	want := "GET <url> failed: api_key=<redacted> at <path>:<n>"
+	if got != want {
+		t.Errorf("summary() = %q, want %q", got, want)
+	}
+}
+
+func TestTrackerPoisonsRepeatedFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "poisoned.json")
+	tracker, err := Open(path, "runner-1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.SetClock(clocktest.NewFake(time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)))
+
+	oom := Failure{TaskType: models.TaskTypeDocker, ExitCode: 137, Output: "Killed after 1843 MiB"}
+	crash := Failure{TaskType: models.TaskTypeDocker, ExitCode: 2, Output: "panic: index out of range [3] with length 3"}
+
+	for i, f := range []Failure{oom, crash, crash} {
+		report, err := tracker.Record("task-1", f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report != nil {
+			t.Fatalf("poisoned after %d failures, two of them alike", i+1)
+		}
+	}
+	report, err := tracker.Record("task-1", Failure{TaskType: models.TaskTypeDocker, ExitCode: 2, Output: "panic: index out of range [9] with length 9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report == nil {
+		t.Fatal("not poisoned after three alike failures")
+	}
+	if report.Fingerprint != Fingerprint(crash) || len(report.Attempts) != 4 {
+		t.Errorf("report = %+v, want the crash's fingerprint and all four attempts", report)
+	}
+	if a := report.Attempts[0]; a.Runner != "runner-1" || a.ExitCode != 137 || a.Summary != "Killed after <n> MiB" {
+		t.Errorf("first attempt = %+v", a)
+	}
+	if tracker.Poisoned("task-2") != nil {
+		t.Error("another task is poisoned")
+	}
+
+	// The guarantee survives a restart
+	reopened, err := Open(path, "runner-1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reopened.Poisoned("task-1"); got == nil || got.Fingerprint != report.Fingerprint {
+		t.Fatalf("Poisoned() after restart = %+v, want %+v", got, report)
+	}
+}
+
+func TestTrackerForgetsStaleFailures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "poisoned.json")
+	clk := clocktest.NewFake(time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC))
+	tracker, err := Open(path, "runner-1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.SetClock(clk)
+	crash := Failure{TaskType: models.TaskTypeDocker, ExitCode: 2, Output: "panic"}
+
+	if _, err := tracker.Record("stale", crash); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		if _, err := tracker.Record("poisoned", crash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clk.Advance(forgetAfter + time.Hour)
+	if _, err := tracker.Record("fresh", crash); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(path, "runner-1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := reopened.tasks["stale"]; ok {
+		t.Error("stale failures kept")
+	}
+	if reopened.Poisoned("poisoned") == nil {
+		t.Error("poisoned task forgotten")
+	}
+	if report, _ := reopened.Record("stale", crash); report != nil {
+		t.Error("forgotten failure still counted")
+	}
+}
+
+func TestTrackerCountsFleetFailures(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash := Failure{TaskType: models.TaskTypeDocker, ExitCode: 2, Output: "segmentation fault at /opt/a/model.bin"}
+
+	var trackers []*Tracker
+	for _, runner := range []string{"runner-1", "runner-2", "runner-3"} {
+		tracker, err := Open(filepath.Join(t.TempDir(), "poisoned.json"), runner, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracker.SetStore(store, time.Second)
+		trackers = append(trackers, tracker)
+	}
+
+	// The task bounces between the fleet's runners, failing once on each
+	for i, tracker := range trackers {
+		crash.Output = "segmentation fault at /opt/" + string(rune('a'+i)) + "/model.bin"
+		report, err := tracker.Record("task-1", crash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 && report != nil {
+			t.Fatalf("poisoned after %d failures", i+1)
+		}
+		if i == 2 {
+			if report == nil {
+				t.Fatal("not poisoned after failing on three runners")
+			}
+			runners := map[string]bool{}
+			for _, a := range report.Attempts {
+				runners[a.Runner] = true
+			}
+			if len(runners) != 3 {
+				t.Errorf("report attempts from %v, want every runner", runners)
+			}
+		}
+	}
+}
+
+type unreachableStore struct{}
+
+func (unreachableStore) Append(context.Context, string, models.PoisonAttempt) ([]models.PoisonAttempt, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestTrackerFailsOpenWithoutStore(t *testing.T) {
+	tracker, err := Open(filepath.Join(t.TempDir(), "poisoned.json"), "runner-1", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracker.SetStore(unreachableStore{}, time.Second)
+	crash := Failure{TaskType: models.TaskTypeCommand, ExitCode: 1, Error: "boom"}
+	if report, err := tracker.Record("task-1", crash); err != nil || report != nil {
+		t.Fatalf("Record() = %+v, %v", report, err)
+	}
+	if report, err := tracker.Record("task-1", crash); err != nil || report == nil {
+		t.Fatalf("Record() = %+v, %v, want poisoned by local failures", report, err)
+	}
+}
+
+func TestOpenRejectsInvalidThreshold(t *testing.T) {
+	if _, err := Open(filepath.Join(t.TempDir(), "poisoned.json"), "runner-1", 0); err == nil {
+		t.Error("Open() accepted a zero threshold")
+	}
+}
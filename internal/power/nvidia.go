@@ -0,0 +1,52 @@
+package power
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// nvidiaThermal returns the hottest NVIDIA GPU's temperature and any thermal
+// slowdown in effect, read with nvidia-smi
+func nvidiaThermal(ctx context.Context) (float64, []string, error) {
+	path, err := exec.LookPath("nvidia-smi")
+	if err != nil {
+		return 0, nil, err
+	}
+	out, err := exec.CommandContext(ctx, path,
+		"--query-gpu=temperature.gpu,clocks_throttle_reasons.hw_thermal_slowdown,clocks_throttle_reasons.sw_thermal_slowdown",
+		"--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return 0, nil, fmt.Errorf("nvidia-smi failed: %w", err)
+	}
+	temp, reasons := parseNvidiaThermal(string(out))
+	return temp, reasons, nil
+}
+
+// parseNvidiaThermal parses temperature.gpu, hw_thermal_slowdown and
+// sw_thermal_slowdown rows
+func parseNvidiaThermal(out string) (float64, []string) {
+	var hottest float64
+	var reasons []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Split(line, ",")
+		if len(fields) != 3 {
+			continue
+		}
+		for i := range fields {
+			fields[i] = strings.TrimSpace(fields[i])
+		}
+		if temp, err := strconv.ParseFloat(fields[0], 64); err == nil {
+			hottest = max(hottest, temp)
+		}
+		if fields[1] == "Active" {
+			reasons = append(reasons, "gpu hardware thermal slowdown")
+		}
+		if fields[2] == "Active" {
+			reasons = append(reasons, "gpu software thermal slowdown")
+		}
+	}
+	return hottest, reasons
+}
@@ -0,0 +1,65 @@
+package power
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// PmsetProbe reads the power source and CPU speed limit macOS reports
+// through pmset. macOS does not expose temperatures without private APIs,
+// so throttling is judged by the speed limit alone.
+type PmsetProbe struct{}
+
+func (PmsetProbe) Sample(ctx context.Context) (models.PowerState, error) {
+	var state models.PowerState
+
+	batt, err := exec.CommandContext(ctx, "pmset", "-g", "batt").Output()
+	if err != nil {
+		return state, fmt.Errorf("pmset -g batt failed: %w", err)
+	}
+	parsePmsetBatt(string(batt), &state)
+
+	// Thermal readings are best effort; the power source alone is useful
+	if therm, err := exec.CommandContext(ctx, "pmset", "-g", "therm").Output(); err == nil {
+		parsePmsetTherm(string(therm), &state)
+	}
+	return state, nil
+}
+
+var (
+	batteryPercent = regexp.MustCompile(`InternalBattery.*?(\d+)%`)
+	speedLimit     = regexp.MustCompile(`CPU_Speed_Limit\s*=\s*(\d+)`)
+	warningLevel   = regexp.MustCompile(`(?i)(thermal|performance) warning level (?:has been )?set to (\d+)`)
+)
+
+// parsePmsetBatt parses pmset -g batt
+func parsePmsetBatt(out string, state *models.PowerState) {
+	if m := batteryPercent.FindStringSubmatch(out); m != nil {
+		percent, _ := strconv.ParseFloat(m[1], 64)
+		state.HasBattery = true
+		state.BatteryPercent = percent
+	}
+	state.OnBattery = state.HasBattery && strings.Contains(out, "'Battery Power'")
+}
+
+// parsePmsetTherm parses pmset -g therm
+func parsePmsetTherm(out string, state *models.PowerState) {
+	if m := speedLimit.FindStringSubmatch(out); m != nil {
+		if limit, _ := strconv.Atoi(m[1]); limit < 100 {
+			state.Throttled = true
+			state.ThrottleReasons = append(state.ThrottleReasons, fmt.Sprintf("cpu speed limited to %d%%", limit))
+		}
+	}
+	for _, m := range warningLevel.FindAllStringSubmatch(out, -1) {
+		if level, _ := strconv.Atoi(m[2]); level > 0 {
+			state.Throttled = true
+			state.ThrottleReasons = append(state.ThrottleReasons, fmt.Sprintf("%s warning level %d", strings.ToLower(m[1]), level))
+		}
+	}
+}
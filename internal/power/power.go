@@ -0,0 +1,165 @@
+// Package power reads the power source and thermal condition of the runner
+// host and decides which tasks it should take on, so runners on laptops and
+// embedded boards stop claiming heavy work on battery or while throttled.
+package power
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ErrConstrained is the cause given to tasks stopped because the host's
+// power or thermal condition no longer allows them
+var ErrConstrained = errors.New("runner is power constrained")
+
+// Probe samples the power source and thermal condition of the host. Fields
+// a platform cannot report are left zero.
+type Probe interface {
+	Sample(ctx context.Context) (models.PowerState, error)
+}
+
+// InFlightAction is what happens to running tasks the current mode would
+// not admit
+type InFlightAction string
+
+const (
+	// InFlightContinue lets running tasks finish
+	InFlightContinue InFlightAction = "continue"
+	// InFlightCheckpoint suspends tasks that can be frozen in place until
+	// conditions recover and abandons the rest
+	InFlightCheckpoint InFlightAction = "checkpoint"
+	// InFlightAbandon stops running tasks and returns them to the queue
+	InFlightAbandon InFlightAction = "abandon"
+)
+
+// ParseInFlightAction validates a configured in-flight action
+func ParseInFlightAction(s string) (InFlightAction, error) {
+	switch action := InFlightAction(s); action {
+	case "":
+		return InFlightContinue, nil
+	case InFlightContinue, InFlightCheckpoint, InFlightAbandon:
+		return action, nil
+	default:
+		return "", fmt.Errorf("unknown in-flight action %q: want continue, checkpoint or abandon", s)
+	}
+}
+
+// Policy maps a power state to a scheduling mode. On battery below
+// BatteryThreshold percent only tasks of BatteryTaskTypes expected to take
+// at most BatteryMaxDuration are claimed; below PauseBelow percent, or when
+// throttled and PauseWhenThrottled is set, nothing is. A CPU or GPU above
+// MaxTemperature degrees Celsius counts as throttled.
+type Policy struct {
+	BatteryThreshold   float64
+	BatteryTaskTypes   []models.TaskType
+	BatteryMaxDuration time.Duration
+	PauseBelow         float64
+	PauseWhenThrottled bool
+	MaxTemperature     float64
+}
+
+// Evaluate sets the mode of state, and the reason for any mode but normal
+func (p Policy) Evaluate(state *models.PowerState) {
+	if p.MaxTemperature > 0 {
+		if state.CPUTempC > p.MaxTemperature {
+			state.Throttled = true
+			state.ThrottleReasons = append(state.ThrottleReasons, fmt.Sprintf("cpu at %.0f°C", state.CPUTempC))
+		}
+		if state.GPUTempC > p.MaxTemperature {
+			state.Throttled = true
+			state.ThrottleReasons = append(state.ThrottleReasons, fmt.Sprintf("gpu at %.0f°C", state.GPUTempC))
+		}
+	}
+
+	state.Mode, state.Reason = models.PowerModeNormal, ""
+	switch {
+	case state.Throttled && p.PauseWhenThrottled:
+		state.Mode, state.Reason = models.PowerModePaused, "thermally throttled"
+	case state.OnBattery && state.BatteryPercent < p.PauseBelow:
+		state.Mode = models.PowerModePaused
+		state.Reason = fmt.Sprintf("on battery at %.0f%%, below %.0f%%", state.BatteryPercent, p.PauseBelow)
+	case state.OnBattery && state.BatteryPercent < p.BatteryThreshold:
+		state.Mode = models.PowerModeRestricted
+		state.Reason = fmt.Sprintf("on battery at %.0f%%, below %.0f%%", state.BatteryPercent, p.BatteryThreshold)
+	}
+}
+
+// Admits reports why a task of taskType expected to take up to duration
+// may not run in state, or nil if it may
+func (p Policy) Admits(state models.PowerState, taskType models.TaskType, duration time.Duration) error {
+	switch state.Mode {
+	case models.PowerModePaused:
+		return fmt.Errorf("%w: %s", ErrConstrained, state.Reason)
+	case models.PowerModeRestricted:
+		if !slices.Contains(p.BatteryTaskTypes, taskType) {
+			return fmt.Errorf("%w: %s, only %v tasks run", ErrConstrained, state.Reason, p.BatteryTaskTypes)
+		}
+		if p.BatteryMaxDuration > 0 && duration > p.BatteryMaxDuration {
+			return fmt.Errorf("%w: %s, only tasks up to %s run", ErrConstrained, state.Reason, p.BatteryMaxDuration)
+		}
+	}
+	return nil
+}
+
+// Monitor keeps the latest power state of the host under a policy
+type Monitor struct {
+	probe  Probe
+	policy Policy
+	clock  clock.Clock
+
+	mu    sync.Mutex
+	state *models.PowerState
+}
+
+func NewMonitor(probe Probe, policy Policy) *Monitor {
+	return &Monitor{probe: probe, policy: policy, clock: clock.Real()}
+}
+
+// SetClock replaces the clock used to timestamp samples
+func (m *Monitor) SetClock(c clock.Clock) {
+	m.clock = c
+}
+
+// Refresh samples the host and returns the state before and after. Before
+// the first sample the previous state is nil.
+func (m *Monitor) Refresh(ctx context.Context) (prev *models.PowerState, next models.PowerState, err error) {
+	next, err = m.probe.Sample(ctx)
+	if err != nil {
+		return nil, models.PowerState{}, fmt.Errorf("failed to sample power state: %w", err)
+	}
+	next.SampledAt = m.clock.Now()
+	m.policy.Evaluate(&next)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prev, m.state = m.state, &next
+	return prev, next, nil
+}
+
+// State returns the latest sample, or nil before the first
+func (m *Monitor) State() *models.PowerState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state == nil {
+		return nil
+	}
+	state := *m.state
+	return &state
+}
+
+// Admit reports why a task may not run in the latest state. Tasks are
+// admitted until the host has been sampled.
+func (m *Monitor) Admit(taskType models.TaskType, duration time.Duration) error {
+	state := m.State()
+	if state == nil {
+		return nil
+	}
+	return m.policy.Admits(*state, taskType, duration)
+}
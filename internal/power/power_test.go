@@ -0,0 +1,143 @@
+package power
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var policy = Policy{
+	BatteryThreshold:   60,
+	BatteryTaskTypes:   []models.TaskType{models.TaskTypeCommand},
+	BatteryMaxDuration: 5 * time.Minute,
+	PauseBelow:         20,
+	PauseWhenThrottled: true,
+	MaxTemperature:     95,
+}
+
+func TestPolicyModes(t *testing.T) {
+	tests := []struct {
+		name  string
+		state models.PowerState
+		mode  models.PowerMode
+	}{
+		{"mains", models.PowerState{HasBattery: true, BatteryPercent: 30}, models.PowerModeNormal},
+		{"charged battery", models.PowerState{HasBattery: true, OnBattery: true, BatteryPercent: 80}, models.PowerModeNormal},
+		{"low battery", models.PowerState{HasBattery: true, OnBattery: true, BatteryPercent: 59}, models.PowerModeRestricted},
+		{"empty battery", models.PowerState{HasBattery: true, OnBattery: true, BatteryPercent: 19}, models.PowerModePaused},
+		{"throttled", models.PowerState{Throttled: true}, models.PowerModePaused},
+		{"hot gpu", models.PowerState{GPUTempC: 97}, models.PowerModePaused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := tt.state
+			policy.Evaluate(&state)
+			if state.Mode != tt.mode {
+				t.Fatalf("mode = %s (%s), want %s", state.Mode, state.Reason, tt.mode)
+			}
+		})
+	}
+
+	ignoring := policy
+	ignoring.PauseWhenThrottled = false
+	state := models.PowerState{Throttled: true}
+	ignoring.Evaluate(&state)
+	if state.Mode != models.PowerModeNormal {
+		t.Fatalf("mode = %s, want throttling ignored", state.Mode)
+	}
+}
+
+func TestPolicyAdmitsShortLightTasksWhenRestricted(t *testing.T) {
+	restricted := models.PowerState{Mode: models.PowerModeRestricted, Reason: "on battery at 45%, below 60%"}
+	if err := policy.Admits(restricted, models.TaskTypeCommand, 2*time.Minute); err != nil {
+		t.Fatalf("Admits() = %v for a short command task", err)
+	}
+	if err := policy.Admits(restricted, models.TaskTypeCommand, 20*time.Minute); err == nil {
+		t.Fatal("Admits() accepted a long command task")
+	}
+	if err := policy.Admits(restricted, models.TaskTypeDocker, time.Minute); err == nil {
+		t.Fatal("Admits() accepted a docker task")
+	}
+	if err := policy.Admits(models.PowerState{Mode: models.PowerModePaused}, models.TaskTypeCommand, time.Second); err == nil {
+		t.Fatal("Admits() accepted a task while paused")
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSysfsProbe(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "class/power_supply/AC/type"), "Mains")
+	writeFile(t, filepath.Join(root, "class/power_supply/AC/online"), "0")
+	writeFile(t, filepath.Join(root, "class/power_supply/BAT0/type"), "Battery")
+	writeFile(t, filepath.Join(root, "class/power_supply/BAT0/capacity"), "42")
+	writeFile(t, filepath.Join(root, "class/power_supply/BAT0/status"), "Discharging")
+	writeFile(t, filepath.Join(root, "class/thermal/thermal_zone0/type"), "x86_pkg_temp")
+	writeFile(t, filepath.Join(root, "class/thermal/thermal_zone0/temp"), "71000")
+	writeFile(t, filepath.Join(root, "class/thermal/thermal_zone1/type"), "GPU-therm")
+	writeFile(t, filepath.Join(root, "class/thermal/thermal_zone1/temp"), "64500")
+	counter := filepath.Join(root, "devices/system/cpu/cpu0/thermal_throttle/core_throttle_count")
+	writeFile(t, counter, "7")
+
+	probe := NewSysfsProbe(root)
+	probe.gpu = nil
+	state, err := probe.Sample(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.HasBattery || !state.OnBattery || state.BatteryPercent != 42 {
+		t.Fatalf("battery = %+v, want on battery at 42%%", state)
+	}
+	if state.CPUTempC != 71 || state.GPUTempC != 64.5 {
+		t.Fatalf("temperatures = %v/%v, want 71/64.5", state.CPUTempC, state.GPUTempC)
+	}
+	if state.Throttled {
+		t.Fatal("first sample reported throttling from a counter it had no baseline for")
+	}
+
+	writeFile(t, filepath.Join(root, "class/power_supply/AC/online"), "1")
+	writeFile(t, filepath.Join(root, "class/power_supply/BAT0/status"), "Charging")
+	writeFile(t, counter, "9")
+	state, _ = probe.Sample(context.Background())
+	if state.OnBattery {
+		t.Fatal("reported on battery with mains online")
+	}
+	if !state.Throttled {
+		t.Fatal("throttle events since the last sample were not reported")
+	}
+}
+
+func TestParsePlatformOutput(t *testing.T) {
+	var state models.PowerState
+	parsePmsetBatt("Now drawing from 'Battery Power'\n -InternalBattery-0 (id=4653155)\t38%; discharging; 2:41 remaining present: true\n", &state)
+	if !state.OnBattery || state.BatteryPercent != 38 {
+		t.Fatalf("pmset batt = %+v", state)
+	}
+	parsePmsetTherm("Note: No thermal warning level has been recorded\nCPU Power notify\n\tCPU_Scheduler_Limit \t= 100\n\tCPU_Speed_Limit \t= 70\n", &state)
+	if !state.Throttled {
+		t.Fatal("pmset therm speed limit was not reported as throttling")
+	}
+
+	var desktop models.PowerState
+	parsePmsetBatt("Now drawing from 'AC Power'\n", &desktop)
+	if desktop.HasBattery || desktop.OnBattery {
+		t.Fatalf("desktop Mac reported a battery: %+v", desktop)
+	}
+
+	temp, reasons := parseNvidiaThermal("61, Not Active, Not Active\n88, Active, Not Active\n")
+	if temp != 88 || len(reasons) != 1 {
+		t.Fatalf("parseNvidiaThermal() = %v, %v", temp, reasons)
+	}
+}
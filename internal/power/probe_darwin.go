@@ -0,0 +1,8 @@
+//go:build darwin
+
+package power
+
+// NewProbe returns the probe for this platform
+func NewProbe() Probe {
+	return PmsetProbe{}
+}
@@ -0,0 +1,9 @@
+//go:build !darwin
+
+package power
+
+// NewProbe returns the probe for this platform. Where there is no sysfs it
+// reports a host on mains power without thermal readings.
+func NewProbe() Probe {
+	return NewSysfsProbe("/sys")
+}
@@ -0,0 +1,133 @@
+package power
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// SysfsProbe reads Linux power supplies, thermal zones and CPU throttle
+// counters, which covers laptops and Jetson boards alike. GPU temperature
+// comes from a GPU thermal zone where the board has one, as on Jetson, or
+// from nvidia-smi otherwise.
+type SysfsProbe struct {
+	root string
+	gpu  func(ctx context.Context) (float64, []string, error)
+
+	mu        sync.Mutex
+	throttles uint64
+	sampled   bool
+}
+
+// NewSysfsProbe reads the sysfs tree mounted at root, normally /sys
+func NewSysfsProbe(root string) *SysfsProbe {
+	return &SysfsProbe{root: root, gpu: nvidiaThermal}
+}
+
+func readTrimmed(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (p *SysfsProbe) Sample(ctx context.Context) (models.PowerState, error) {
+	var state models.PowerState
+	p.readSupplies(&state)
+	p.readThermalZones(&state)
+
+	if state.GPUTempC == 0 && p.gpu != nil {
+		if temp, reasons, err := p.gpu(ctx); err == nil {
+			state.GPUTempC = temp
+			if len(reasons) > 0 {
+				state.Throttled = true
+				state.ThrottleReasons = append(state.ThrottleReasons, reasons...)
+			}
+		}
+	}
+
+	// The kernel counts thermal throttling events; any since the last
+	// sample means the CPU is being held back
+	throttles := p.readThrottleCount()
+	p.mu.Lock()
+	if p.sampled && throttles > p.throttles {
+		state.Throttled = true
+		state.ThrottleReasons = append(state.ThrottleReasons, "cpu thermal throttling")
+	}
+	p.throttles, p.sampled = throttles, true
+	p.mu.Unlock()
+
+	return state, nil
+}
+
+// readSupplies sets the battery fields. The host is on battery when it has
+// one and no mains or USB supply is online, or, where the board exposes no
+// such supply, when the battery reports discharging.
+func (p *SysfsProbe) readSupplies(state *models.PowerState) {
+	supplies, _ := filepath.Glob(filepath.Join(p.root, "class", "power_supply", "*"))
+	hasExternal, externalOnline, discharging := false, false, false
+	for _, supply := range supplies {
+		switch readTrimmed(filepath.Join(supply, "type")) {
+		case "Mains", "USB":
+			hasExternal = true
+			if readTrimmed(filepath.Join(supply, "online")) == "1" {
+				externalOnline = true
+			}
+		case "Battery":
+			if readTrimmed(filepath.Join(supply, "present")) == "0" {
+				continue
+			}
+			capacity, err := strconv.ParseFloat(readTrimmed(filepath.Join(supply, "capacity")), 64)
+			if err != nil {
+				continue
+			}
+			state.HasBattery = true
+			state.BatteryPercent = capacity
+			if readTrimmed(filepath.Join(supply, "status")) == "Discharging" {
+				discharging = true
+			}
+		}
+	}
+	if state.HasBattery {
+		state.OnBattery = discharging || (hasExternal && !externalOnline)
+	}
+}
+
+// readThermalZones sets the hottest CPU and GPU zone temperatures
+func (p *SysfsProbe) readThermalZones(state *models.PowerState) {
+	zones, _ := filepath.Glob(filepath.Join(p.root, "class", "thermal", "thermal_zone*"))
+	for _, zone := range zones {
+		millis, err := strconv.ParseFloat(readTrimmed(filepath.Join(zone, "temp")), 64)
+		if err != nil {
+			continue
+		}
+		temp := millis / 1000
+
+		kind := strings.ToLower(readTrimmed(filepath.Join(zone, "type")))
+		switch {
+		case strings.Contains(kind, "gpu"):
+			state.GPUTempC = max(state.GPUTempC, temp)
+		case strings.Contains(kind, "cpu"), strings.Contains(kind, "pkg_temp"),
+			strings.Contains(kind, "soc"), kind == "acpitz":
+			state.CPUTempC = max(state.CPUTempC, temp)
+		}
+	}
+}
+
+// readThrottleCount sums the thermal throttling events of every CPU
+func (p *SysfsProbe) readThrottleCount() uint64 {
+	counters, _ := filepath.Glob(filepath.Join(p.root, "devices", "system", "cpu", "cpu*", "thermal_throttle", "*_throttle_count"))
+	var total uint64
+	for _, counter := range counters {
+		if n, err := strconv.ParseUint(readTrimmed(counter), 10, 64); err == nil {
+			total += n
+		}
+	}
+	return total
+}
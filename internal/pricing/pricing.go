@@ -0,0 +1,121 @@
+// Package pricing sets a floor on the reward of each task from what the
+// operator charges per hour for the resources it holds. A task's floor is
+// the cost of its resources for as long as it is expected to run; tasks
+// paying less are skipped, and what was skipped is counted so operators can
+// see what their floors cost them.
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+// ErrBelowFloor is returned for tasks paying less than their floor
+var ErrBelowFloor = errors.New("reward below price floor")
+
+// cpuSharesPerCore are the Docker CPU shares of one core
+const cpuSharesPerCore = 1024
+
+// Usage is the resources a task holds while it runs
+type Usage struct {
+	CPUs     float64
+	GPUs     float64
+	MemoryGB float64
+}
+
+// UsageOf returns the resources a task configured with resources holds. CPU
+// shares count 1024 to the core and tasks that set none hold one core.
+// Tasks asking for an accelerator or VRAM hold one GPU. Memory is counted in
+// binary gigabytes.
+func UsageOf(resources models.ResourceConfig) (Usage, error) {
+	usage := Usage{CPUs: 1}
+	if resources.CPUShares > 0 {
+		usage.CPUs = float64(resources.CPUShares) / cpuSharesPerCore
+	}
+	if (resources.Accelerator != "" && hardware.AcceleratorClass(resources.Accelerator) != hardware.AcceleratorNone) || resources.GPUMemory != "" {
+		usage.GPUs = 1
+	}
+	memory, err := gpu.ParseBytes(resources.Memory)
+	if err != nil {
+		return Usage{}, fmt.Errorf("invalid memory limit: %w", err)
+	}
+	usage.MemoryGB = float64(memory) / (1 << 30)
+	return usage, nil
+}
+
+// Validate checks that rates are usable: none negative or not a number
+func Validate(rates models.PricingRates) error {
+	for name, rate := range map[string]float64{
+		"cpu_hour":    rates.CPUHour,
+		"gpu_hour":    rates.GPUHour,
+		"gb_ram_hour": rates.GBRAMHour,
+	} {
+		if rate < 0 || math.IsNaN(rate) || math.IsInf(rate, 0) {
+			return fmt.Errorf("invalid %s rate %v", name, rate)
+		}
+	}
+	return nil
+}
+
+// Floor returns the least a task holding usage for duration must pay at
+// rates
+func Floor(rates models.PricingRates, usage Usage, duration time.Duration) float64 {
+	perHour := usage.CPUs*rates.CPUHour + usage.GPUs*rates.GPUHour + usage.MemoryGB*rates.GBRAMHour
+	return perHour * duration.Hours()
+}
+
+// Gate skips tasks paying below their floor and counts what it skipped.
+// Its rates may be replaced while tasks are admitted.
+type Gate struct {
+	mu     sync.Mutex
+	status models.PricingStatus
+}
+
+// NewGate returns a gate pricing tasks at rates
+func NewGate(rates models.PricingRates) (*Gate, error) {
+	if err := Validate(rates); err != nil {
+		return nil, err
+	}
+	return &Gate{status: models.PricingStatus{Rates: rates}}, nil
+}
+
+// SetRates prices the tasks admitted from now on at rates. The counts of
+// tasks skipped so far are kept.
+func (g *Gate) SetRates(rates models.PricingRates) error {
+	if err := Validate(rates); err != nil {
+		return err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.status.Rates = rates
+	return nil
+}
+
+// Admit returns ErrBelowFloor, counting the task as skipped, when reward is
+// below the floor of a task holding usage for duration
+func (g *Gate) Admit(reward float64, usage Usage, duration time.Duration) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	floor := Floor(g.status.Rates, usage, duration)
+	if reward >= floor {
+		return nil
+	}
+	g.status.Skipped++
+	g.status.SkippedReward += reward
+	g.status.SkippedFloor += floor
+	return fmt.Errorf("%w: pays %g, floor is %g for %s", ErrBelowFloor, reward, floor, duration)
+}
+
+// Status returns the rates in effect and what was skipped so far
+func (g *Gate) Status() models.PricingStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.status
+}
@@ -0,0 +1,102 @@
+package pricing
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func TestFloor(t *testing.T) {
+	rates := models.PricingRates{CPUHour: 0.02, GPUHour: 0.5, GBRAMHour: 0.005}
+	tests := []struct {
+		name      string
+		resources models.ResourceConfig
+		duration  time.Duration
+		want      float64
+	}{
+		{"defaults to one core", models.ResourceConfig{}, time.Hour, 0.02},
+		{"cpu shares", models.ResourceConfig{CPUShares: 2048}, time.Hour, 0.04},
+		{"fractional core", models.ResourceConfig{CPUShares: 512}, 2 * time.Hour, 0.02},
+		{"memory", models.ResourceConfig{Memory: "4g"}, time.Hour, 0.02 + 4*0.005},
+		{"memory in mebibytes", models.ResourceConfig{Memory: "512m"}, time.Hour, 0.02 + 0.5*0.005},
+		{"accelerator", models.ResourceConfig{Accelerator: "cuda"}, time.Hour, 0.02 + 0.5},
+		{"gpu memory", models.ResourceConfig{GPUMemory: "8g"}, 30 * time.Minute, (0.02 + 0.5) / 2},
+		{"no accelerator", models.ResourceConfig{Accelerator: "none"}, time.Hour, 0.02},
+		{
+			"everything",
+			models.ResourceConfig{CPUShares: 4096, Memory: "16g", Accelerator: "cuda"},
+			90 * time.Minute,
+			1.5 * (4*0.02 + 0.5 + 16*0.005),
+		},
+		{"no runtime", models.ResourceConfig{CPUShares: 4096, Accelerator: "cuda"}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usage, err := UsageOf(tt.resources)
+			if err != nil {
+				t.Fatalf("UsageOf() error = %v", err)
+			}
+			if got := Floor(rates, usage, tt.duration); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Floor() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := UsageOf(models.ResourceConfig{Memory: "lots"}); err == nil {
+		t.Error("UsageOf() accepted an invalid memory limit")
+	}
+	usage, _ := UsageOf(models.ResourceConfig{CPUShares: 4096, Memory: "16g", Accelerator: "cuda"})
+	if got := Floor(models.PricingRates{GPUHour: 0.5}, usage, time.Hour); got != 0.5 {
+		t.Errorf("Floor() with only GPUs priced = %v, want 0.5", got)
+	}
+}
+
+func TestGateCountsSkippedTasks(t *testing.T) {
+	gate, err := NewGate(models.PricingRates{CPUHour: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	oneCore := Usage{CPUs: 1}
+
+	if err := gate.Admit(2, oneCore, time.Hour); err != nil {
+		t.Fatalf("Admit() above the floor = %v", err)
+	}
+	if err := gate.Admit(1, oneCore, time.Hour); err != nil {
+		t.Fatalf("Admit() at the floor = %v", err)
+	}
+	if err := gate.Admit(0.5, oneCore, time.Hour); !errors.Is(err, ErrBelowFloor) {
+		t.Fatalf("Admit() below the floor = %v, want ErrBelowFloor", err)
+	}
+	if err := gate.Admit(1, oneCore, 3*time.Hour); !errors.Is(err, ErrBelowFloor) {
+		t.Fatalf("Admit() of a longer task = %v, want ErrBelowFloor", err)
+	}
+
+	want := models.PricingStatus{Rates: models.PricingRates{CPUHour: 1}, Skipped: 2, SkippedReward: 1.5, SkippedFloor: 4}
+	if got := gate.Status(); got != want {
+		t.Fatalf("Status() = %+v, want %+v", got, want)
+	}
+
+	// Lowering the floor admits the task it skipped and keeps the counts
+	if err := gate.SetRates(models.PricingRates{CPUHour: 0.25}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gate.Admit(0.5, oneCore, time.Hour); err != nil {
+		t.Fatalf("Admit() after lowering the floor = %v", err)
+	}
+	if got := gate.Status(); got.Skipped != 2 || got.SkippedReward != 1.5 || got.Rates.CPUHour != 0.25 {
+		t.Fatalf("Status() = %+v after changing rates", got)
+	}
+
+	if err := gate.SetRates(models.PricingRates{GPUHour: -1}); err == nil {
+		t.Fatal("SetRates() accepted a negative rate")
+	}
+	if err := gate.SetRates(models.PricingRates{GBRAMHour: math.NaN()}); err == nil {
+		t.Fatal("SetRates() accepted NaN")
+	}
+	if got := gate.Status().Rates; got.CPUHour != 0.25 {
+		t.Fatalf("rates = %+v after rejected changes", got)
+	}
+}
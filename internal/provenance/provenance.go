@@ -0,0 +1,137 @@
+// Package provenance describes how the runner binary was built and
+// fingerprints the running binary, so that the network can tell official
+// builds from modified ones without attestation hardware. Official builds
+// are stamped at link time:
+//
+//	go build -trimpath -ldflags "-X github.com/theblitlabs/parity-runner/internal/provenance.version=v1.4.0 \
+//	    -X github.com/theblitlabs/parity-runner/internal/provenance.commit=<sha> \
+//	    -X github.com/theblitlabs/parity-runner/internal/provenance.builder=<identity>"
+//
+// Other builds fall back on the build information the Go toolchain embeds,
+// which stripping a binary leaves in place.
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/attestation"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// Stamped at link time by official builds
+var (
+	version string
+	commit  string
+	builder string
+)
+
+// fingerprintDomain separates build fingerprints from other digests
+const fingerprintDomain = "parity-runner-build-v1"
+
+// devVersion is the version of a build that records none
+const devVersion = "dev"
+
+var (
+	currentOnce sync.Once
+	current     models.BuildProvenance
+)
+
+// stamp is what was stamped into a binary at link time
+type stamp struct {
+	version string
+	commit  string
+	builder string
+}
+
+// Version returns the runner's version: the one stamped at link time, else
+// the module version the Go toolchain recorded, else "dev"
+func Version() string {
+	info, _ := debug.ReadBuildInfo()
+	return describe(stamp{version, commit, builder}, info).Version
+}
+
+// Current returns the provenance of the running binary. The binary is
+// hashed on the first call, which the runner makes at startup, so that a
+// binary replaced on disk by an upgrade does not change it.
+func Current() models.BuildProvenance {
+	currentOnce.Do(func() {
+		info, _ := debug.ReadBuildInfo()
+		current = describe(stamp{version, commit, builder}, info)
+
+		log := gologger.WithComponent("provenance")
+		if path, err := os.Executable(); err == nil {
+			current.Ephemeral = ephemeral(path)
+		}
+		hash, err := attestation.BinaryHash()
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to hash runner binary, fingerprinting its provenance alone")
+		}
+		current.BinaryHash = hash
+		current.Fingerprint = Fingerprint(current)
+	})
+	return current
+}
+
+// describe returns the provenance of a binary stamped with s and built as
+// info describes; info is nil for binaries without build information
+func describe(s stamp, info *debug.BuildInfo) models.BuildProvenance {
+	p := models.BuildProvenance{
+		Version: s.version,
+		Commit:  s.commit,
+		Builder: s.builder,
+		Stamped: s.version != "" && s.commit != "" && s.builder != "",
+	}
+	if info != nil {
+		p.Module = info.Main.Path
+		p.GoVersion = info.GoVersion
+		if p.Version == "" && info.Main.Version != "(devel)" {
+			p.Version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if p.Commit == "" {
+					p.Commit = setting.Value
+				}
+			case "vcs.time":
+				p.CommitTime = setting.Value
+			case "vcs.modified":
+				p.Modified, _ = strconv.ParseBool(setting.Value)
+			}
+		}
+	}
+	if p.Version == "" {
+		p.Version = devVersion
+	}
+	return p
+}
+
+// ephemeral reports whether the executable at path is a temporary build of
+// go run or go test
+func ephemeral(path string) bool {
+	return strings.Contains(filepath.ToSlash(path), "/go-build") || strings.HasSuffix(path, ".test")
+}
+
+// Fingerprint digests the binary hash and claimed provenance of p. Fields
+// are length-prefixed so distinct provenances never share a fingerprint.
+func Fingerprint(p models.BuildProvenance) string {
+	h := sha256.New()
+	h.Write([]byte(fingerprintDomain))
+	for _, field := range []string{p.BinaryHash, p.Module, p.Version, p.Commit, strconv.FormatBool(p.Modified), p.Builder} {
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(field)))
+		h.Write(size[:])
+		h.Write([]byte(field))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,80 @@
+package provenance
+
+import (
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/attestation"
+)
+
+func TestDescribe(t *testing.T) {
+	info := &debug.BuildInfo{
+		GoVersion: "go1.23.4",
+		Main:      debug.Module{Path: "github.com/theblitlabs/parity-runner", Version: "v1.3.0"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "abc123"},
+			{Key: "vcs.time", Value: "2026-10-01T12:00:00Z"},
+			{Key: "vcs.modified", Value: "true"},
+		},
+	}
+
+	// Stamped values take precedence over the toolchain's
+	stamped := describe(stamp{version: "v1.4.0", commit: "def456", builder: "release-ci"}, info)
+	if !stamped.Stamped || stamped.Version != "v1.4.0" || stamped.Commit != "def456" || stamped.Builder != "release-ci" {
+		t.Errorf("stamped provenance = %+v, want the stamped version, commit and builder", stamped)
+	}
+	if stamped.Module != info.Main.Path || stamped.GoVersion != "go1.23.4" || !stamped.Modified {
+		t.Errorf("stamped provenance = %+v, want the module, Go version and modified flag of the build", stamped)
+	}
+
+	unstamped := describe(stamp{}, info)
+	if unstamped.Stamped || unstamped.Version != "v1.3.0" || unstamped.Commit != "abc123" || unstamped.CommitTime != "2026-10-01T12:00:00Z" {
+		t.Errorf("unstamped provenance = %+v, want the toolchain's build information", unstamped)
+	}
+
+	// go run records no module version, and binaries built without module
+	// support no build information at all
+	info.Main.Version = "(devel)"
+	if got := describe(stamp{}, info).Version; got != devVersion {
+		t.Errorf("go run version = %q, want %q", got, devVersion)
+	}
+	if got := describe(stamp{}, nil); got.Version != devVersion || got.Module != "" {
+		t.Errorf("provenance without build information = %+v, want a dev version", got)
+	}
+
+	if !ephemeral("/tmp/go-build1234/b001/exe/cmd") || !ephemeral("/tmp/runner.test") || ephemeral("/usr/local/bin/parity-runner") {
+		t.Error("ephemeral() misclassified a binary")
+	}
+}
+
+func TestFingerprintStable(t *testing.T) {
+	first := Current()
+	if first.BinaryHash == "" || first.Fingerprint == "" {
+		t.Fatalf("Current() = %+v, want the test binary hashed and fingerprinted", first)
+	}
+	if !first.Ephemeral {
+		t.Error("test binary not marked ephemeral")
+	}
+
+	// Hashing the binary again, as another run of it would, gives the same
+	// fingerprint
+	hash, err := attestation.BinaryHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := first
+	again.BinaryHash = hash
+	if Fingerprint(again) != first.Fingerprint || Current() != first {
+		t.Fatal("fingerprint changed between runs of the same binary")
+	}
+
+	// A modified binary, or one claiming another build, fingerprints apart
+	modified := first
+	modified.BinaryHash = strings.Repeat("0", 64)
+	claimed := first
+	claimed.Commit = "forged"
+	if Fingerprint(modified) == first.Fingerprint || Fingerprint(claimed) == first.Fingerprint {
+		t.Fatal("fingerprint ignores the binary or its claimed provenance")
+	}
+}
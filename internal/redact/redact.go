@@ -0,0 +1,309 @@
+// Package redact scrubs sensitive text, such as email addresses, keys and
+// IP addresses, from what tasks produce before it leaves the runner: their
+// results, streamed output, progress messages and text artifacts.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// DefaultWindow is how many bytes a Writer holds back, so that a match split
+// across two writes is still found. Matches longer than the window may be
+// missed when they are written in pieces.
+const DefaultWindow = 4096
+
+// Detectors names the built-in detectors, in the order they are applied
+var Detectors = []string{"key", "email", "ipv6", "ipv4"}
+
+// NoDetectors turns every built-in detector off
+const NoDetectors = "none"
+
+// defaultReplacement replaces what a rule without a replacement matches
+const defaultReplacement = "<redacted>"
+
+// Rule redacts what Pattern matches, replacing it with Replacement, in
+// which $1 or ${name} stand for what the pattern's groups matched
+type Rule struct {
+	Name        string `json:"name"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement,omitempty"`
+}
+
+type rule struct {
+	name        string
+	re          *regexp.Regexp
+	replacement []byte
+	// valid rejects candidates the pattern cannot rule out by itself
+	valid func(src []byte, start, end int) bool
+}
+
+var builtins = map[string]rule{
+	"key": {
+		re: regexp.MustCompile(strings.Join([]string{
+			`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`,
+			`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+			`\bgh[pousr]_[A-Za-z0-9]{36,255}\b`,
+			`\bgithub_pat_[A-Za-z0-9_]{22,255}\b`,
+			`\bxox[abprs]-[A-Za-z0-9-]{10,}`,
+			`\b[prs]k_(?:live|test)_[A-Za-z0-9]{16,}\b`,
+			`\bsk-[A-Za-z0-9_-]{20,}`,
+			`\bAIza[0-9A-Za-z_-]{35}\b`,
+			`\beyJ[A-Za-z0-9_-]{8,}\.eyJ[A-Za-z0-9_-]{8,}\.[A-Za-z0-9_-]{8,}`,
+		}, "|")),
+		replacement: []byte("<key>"),
+	},
+	"email": {
+		re:          regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+		replacement: []byte("<email>"),
+	},
+	"ipv6": {
+		re:          regexp.MustCompile(`(?i)[0-9a-f]{0,4}(?::[0-9a-f]{0,4}){2,7}(?:(?:\.\d{1,3}){3})?`),
+		replacement: []byte("<ipv6>"),
+		valid:       validIPv6,
+	},
+	"ipv4": {
+		re:          regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\.){3}(?:25[0-5]|2[0-4]\d|1\d\d|[1-9]?\d)\b`),
+		replacement: []byte("<ipv4>"),
+	},
+}
+
+// validIPv6 accepts candidates that parse as IPv6 addresses and stand
+// apart from the text around them, so that C++ scopes such as std::map or
+// times such as 12:30:45 are left alone
+func validIPv6(src []byte, start, end int) bool {
+	if start > 0 && addressByte(src[start-1]) || end < len(src) && addressByte(src[end]) {
+		return false
+	}
+	candidate := string(src[start:end])
+	if strings.Trim(candidate, ":") == "" {
+		return false
+	}
+	addr, err := netip.ParseAddr(candidate)
+	return err == nil && addr.Is6()
+}
+
+func addressByte(b byte) bool {
+	return b == ':' || b == '.' || b == '_' || b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// LoadRules reads a JSON array of rules from the file at path
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read redaction rules: %w", err)
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse redaction rules %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Redactor redacts text with the built-in detectors and rules it was
+// created with, tallying what it redacted for each task until the task's
+// result takes the tally
+type Redactor struct {
+	rules  []rule
+	window int
+
+	mu      sync.Mutex
+	reports map[string]*models.RedactionReport
+}
+
+// New returns a redactor applying the named built-in detectors and the
+// rules. The rules are tried before the detectors, so that where they
+// overlap a rule's replacement wins.
+func New(detectors []string, rules []Rule) (*Redactor, error) {
+	r := &Redactor{window: DefaultWindow, reports: make(map[string]*models.RedactionReport)}
+	names := make(map[string]bool)
+	for i, rl := range rules {
+		if rl.Name == "" {
+			return nil, fmt.Errorf("redaction rule %d has no name", i+1)
+		}
+		if names[rl.Name] || builtins[rl.Name].re != nil {
+			return nil, fmt.Errorf("redaction rule %q is defined twice", rl.Name)
+		}
+		names[rl.Name] = true
+		re, err := regexp.Compile(rl.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern in redaction rule %q: %w", rl.Name, err)
+		}
+		if re.MatchString("") {
+			return nil, fmt.Errorf("redaction rule %q matches empty text", rl.Name)
+		}
+		replacement := rl.Replacement
+		if replacement == "" {
+			replacement = defaultReplacement
+		}
+		r.rules = append(r.rules, rule{name: rl.Name, re: re, replacement: []byte(replacement)})
+	}
+
+	enabled := make(map[string]bool)
+	for _, name := range detectors {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == NoDetectors {
+			continue
+		}
+		if builtins[name].re == nil {
+			return nil, fmt.Errorf("unknown redaction detector %q, want one of %s", name, strings.Join(Detectors, ", "))
+		}
+		enabled[name] = true
+	}
+	for _, name := range Detectors {
+		if enabled[name] {
+			builtin := builtins[name]
+			builtin.name = name
+			r.rules = append(r.rules, builtin)
+		}
+	}
+	return r, nil
+}
+
+// SetWindow replaces how many bytes the redactor's writers hold back
+func (r *Redactor) SetWindow(window int) {
+	if window > 0 {
+		r.window = window
+	}
+}
+
+// match is where a rule matched, as returned by FindSubmatchIndex
+type match struct {
+	rule *rule
+	loc  []int
+}
+
+// matches finds where the rules match src. Where matches overlap the one
+// starting first wins, or when they start together the earlier rule's.
+func (r *Redactor) matches(src []byte) []match {
+	var found []match
+	for i := range r.rules {
+		rl := &r.rules[i]
+		for _, loc := range rl.re.FindAllSubmatchIndex(src, -1) {
+			if loc[0] == loc[1] || rl.valid != nil && !rl.valid(src, loc[0], loc[1]) {
+				continue
+			}
+			found = append(found, match{rule: rl, loc: loc})
+		}
+	}
+	// Stable, so that matches starting together stay in rule order
+	sort.SliceStable(found, func(i, j int) bool { return found[i].loc[0] < found[j].loc[0] })
+	kept := found[:0]
+	end := 0
+	for _, m := range found {
+		if m.loc[0] >= end {
+			kept = append(kept, m)
+			end = m.loc[1]
+		}
+	}
+	return kept
+}
+
+// replace appends src to dst with the matches replaced, counting them
+func replace(dst, src []byte, matches []match, counts map[string]int) []byte {
+	last := 0
+	for _, m := range matches {
+		dst = append(dst, src[last:m.loc[0]]...)
+		dst = m.rule.re.Expand(dst, m.rule.replacement, src, m.loc)
+		counts[m.rule.name]++
+		last = m.loc[1]
+	}
+	return append(dst, src[last:]...)
+}
+
+// String returns s redacted and how many matches of each rule it held
+func (r *Redactor) String(s string) (string, map[string]int) {
+	var out strings.Builder
+	w := r.NewWriter(&out)
+	// Writes to a strings.Builder cannot fail
+	_, _ = w.Write([]byte(s))
+	_ = w.Close()
+	return out.String(), w.Counts()
+}
+
+// Task redacts s for the task with taskID, adding what it redacted to the
+// task's tally
+func (r *Redactor) Task(taskID, s string) string {
+	redacted, counts := r.String(s)
+	r.tally(taskID, counts)
+	return redacted
+}
+
+func (r *Redactor) tally(taskID string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.report(taskID)
+	for name, n := range counts {
+		report.Counts[name] += n
+	}
+}
+
+// Flag records that an artifact of the task with taskID left the runner
+// without being redacted
+func (r *Redactor) Flag(taskID, artifact string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := r.report(taskID)
+	report.Unredacted = append(report.Unredacted, artifact)
+}
+
+func (r *Redactor) report(taskID string) *models.RedactionReport {
+	report, ok := r.reports[taskID]
+	if !ok {
+		report = &models.RedactionReport{Counts: make(map[string]int)}
+		r.reports[taskID] = report
+	}
+	return report
+}
+
+// Result redacts the output and error of a task's result, flags its
+// exported image, which is binary, and takes the task's tally into the
+// result's redaction report
+func (r *Redactor) Result(taskID string, result *models.TaskResult) {
+	result.Output = r.Task(taskID, result.Output)
+	result.Error = r.Task(taskID, result.Error)
+	for i := range result.Artifacts {
+		if preview := result.Artifacts[i].Preview; preview != nil {
+			for j, line := range preview.Lines {
+				preview.Lines[j] = r.Task(taskID, line)
+			}
+		}
+	}
+	if result.ExportedImage != nil {
+		r.Flag(taskID, "exported_image:"+result.ExportedImage.Digest)
+	}
+
+	r.mu.Lock()
+	report := r.report(taskID)
+	delete(r.reports, taskID)
+	r.mu.Unlock()
+	result.Redaction = report
+}
+
+// Forget drops the tally of a task whose result will not be reported
+func (r *Redactor) Forget(taskID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.reports, taskID)
+}
+
+// Progress returns progress with its message redacted
+func (r *Redactor) Progress(taskID string, progress *models.TaskProgress) *models.TaskProgress {
+	if progress == nil || progress.Message == "" {
+		return progress
+	}
+	redacted := *progress
+	redacted.Message = r.Task(taskID, progress.Message)
+	return &redacted
+}
@@ -0,0 +1,264 @@
+package redact
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"maps"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+var fixtureCounts = map[string]int{"email": 2, "ipv4": 2, "ipv6": 4, "key": 6}
+
+func TestDetectors(t *testing.T) {
+	r, err := New(Detectors, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, counts := r.String(readFixture(t, "detections.txt"))
+	if want := readFixture(t, "detections.golden"); got != want {
+		t.Errorf("String() =\n%s\nwant\n%s", got, want)
+	}
+	if !maps.Equal(counts, fixtureCounts) {
+		t.Errorf("counts = %v, want %v", counts, fixtureCounts)
+	}
+}
+
+func TestDetectorsCanBeTurnedOff(t *testing.T) {
+	r, err := New([]string{"email"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := "mail ops@example.com from 203.0.113.42"
+	if got, _ := r.String(in); got != "mail <email> from 203.0.113.42" {
+		t.Errorf("String() = %q", got)
+	}
+
+	r, err = New([]string{NoDetectors}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, counts := r.String(in); got != in || len(counts) != 0 {
+		t.Errorf("String() = %q, %v with every detector off", got, counts)
+	}
+}
+
+func TestWriterMatchesAcrossChunkBoundaries(t *testing.T) {
+	in := strings.Repeat(readFixture(t, "detections.txt"), 40)
+	want := strings.Repeat(readFixture(t, "detections.golden"), 40)
+	r, err := New(Detectors, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetWindow(256)
+
+	for _, chunk := range []int{1, 2, 7, 61, 255, 256, 257, 1000, 4096} {
+		var out bytes.Buffer
+		w := r.NewWriter(&out)
+		for rest := []byte(in); len(rest) > 0; {
+			n := min(chunk, len(rest))
+			if _, err := w.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("chunks of %d: output differs from redacting the text whole", chunk)
+		}
+		counts := w.Counts()
+		for name, n := range fixtureCounts {
+			if counts[name] != 40*n {
+				t.Errorf("chunks of %d: %d %s matches, want %d", chunk, counts[name], name, 40*n)
+			}
+		}
+	}
+}
+
+func TestWriterHoldsBackOnlyTheWindow(t *testing.T) {
+	r, err := New(Detectors, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetWindow(64)
+	var out bytes.Buffer
+	w := r.NewWriter(&out)
+	line := "contact ops@example.com about 203.0.113.42\n"
+	for range 10 {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if held := len(w.pending); held >= 2*64 || out.Len() == 0 {
+		t.Errorf("writer passed on %d bytes, holding back %d", out.Len(), held)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Repeat("contact <email> about <ipv4>\n", 10); out.String() != want {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestRules(t *testing.T) {
+	r, err := New(Detectors, []Rule{
+		{Name: "customer", Pattern: `\b(cust)-\d{6}\b`, Replacement: "${1}-<id>"},
+		{Name: "hostname", Pattern: `\b[a-z0-9-]+\.corp\.example\b`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, counts := r.String("cust-123456 on build-07.corp.example mailed cust-654321@corp.example.com")
+	want := "cust-<id> on <redacted> mailed cust-<id>@corp.example.com"
+	if got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if want := map[string]int{"customer": 2, "hostname": 1}; !maps.Equal(counts, want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	for name, tt := range map[string]struct {
+		detectors []string
+		rules     []Rule
+	}{
+		"unknown detector": {[]string{"phone"}, nil},
+		"unnamed rule":     {nil, []Rule{{Pattern: `x`}}},
+		"invalid pattern":  {nil, []Rule{{Name: "bad", Pattern: `(`}}},
+		"empty match":      {nil, []Rule{{Name: "any", Pattern: `a*`}}},
+		"duplicate rule":   {nil, []Rule{{Name: "x", Pattern: `x`}, {Name: "x", Pattern: `y`}}},
+		"shadows detector": {nil, []Rule{{Name: "email", Pattern: `x`}}},
+	} {
+		if _, err := New(tt.detectors, tt.rules); err == nil {
+			t.Errorf("%s: New() accepted", name)
+		}
+	}
+}
+
+func TestLoadRules(t *testing.T) {
+	path := t.TempDir() + "/rules.json"
+	if err := os.WriteFile(path, []byte(`[{"name": "ticket", "pattern": "TICKET-[0-9]+", "replacement": "<ticket>"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rules, err := LoadRules(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 1 || rules[0].Name != "ticket" || rules[0].Replacement != "<ticket>" {
+		t.Errorf("LoadRules() = %+v", rules)
+	}
+	if err := os.WriteFile(path, []byte(`{"name": "ticket"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRules(path); err == nil {
+		t.Error("LoadRules() accepted an object")
+	}
+}
+
+func TestIsText(t *testing.T) {
+	for _, tt := range []struct {
+		head []byte
+		want bool
+	}{
+		{[]byte("epoch,loss\n1,0.43\n"), true},
+		{[]byte("naïve"), true},
+		// A rune cut short by the sniffed size
+		{[]byte("na\xc3"), true},
+		{[]byte("PK\x03\x04\x00\x00"), false},
+		{[]byte("\x89PNG\r\n\x1a\n"), false},
+		{[]byte("ab\xffcd"), false},
+	} {
+		if got := IsText(tt.head); got != tt.want {
+			t.Errorf("IsText(%q) = %v, want %v", tt.head, got, tt.want)
+		}
+	}
+}
+
+// recordingUploaders keeps what is uploaded through them by name
+type recordingUploaders struct {
+	uploads map[string][]byte
+}
+
+func (s *recordingUploaders) ForTask(taskID string) artifacts.Uploader { return s }
+
+func (s *recordingUploaders) Add(ctx context.Context, name string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	s.uploads[name] = data
+	return "cid-" + name, nil
+}
+
+func TestUploadersAndResultReport(t *testing.T) {
+	r, err := New(Detectors, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &recordingUploaders{uploads: make(map[string][]byte)}
+	uploader := r.Uploaders(source).ForTask("task-1")
+
+	text := strings.Repeat("step 1 synced with 10.0.0.7\n", 2000)
+	if _, err := uploader.Add(context.Background(), "train.log", strings.NewReader(text)); err != nil {
+		t.Fatal(err)
+	}
+	binary := append([]byte("\x00\x01weights from 10.0.0.7"), bytes.Repeat([]byte{0}, 100)...)
+	if _, err := uploader.Add(context.Background(), "model.bin", bytes.NewReader(binary)); err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.Repeat("step 1 synced with <ipv4>\n", 2000); string(source.uploads["train.log"]) != want {
+		t.Error("text artifact uploaded unredacted")
+	}
+	if !bytes.Equal(source.uploads["model.bin"], binary) {
+		t.Error("binary artifact altered")
+	}
+
+	progress := r.Progress("task-1", &models.TaskProgress{Percent: 50, Message: "mailing ops@example.com"})
+	if progress.Message != "mailing <email>" {
+		t.Errorf("progress message = %q", progress.Message)
+	}
+
+	result := &models.TaskResult{
+		Output: "done, results at 203.0.113.9",
+		Error:  "warning for ops@example.com",
+		Artifacts: []models.ArtifactDescription{{
+			Path:    "train.log",
+			Preview: &models.ArtifactPreview{Kind: models.ArtifactPreviewText, Lines: []string{"step 1 synced with 10.0.0.7"}},
+		}},
+	}
+	r.Result("task-1", result)
+	if result.Output != "done, results at <ipv4>" || result.Error != "warning for <email>" {
+		t.Errorf("result = %q, %q", result.Output, result.Error)
+	}
+	if line := result.Artifacts[0].Preview.Lines[0]; line != "step 1 synced with <ipv4>" {
+		t.Errorf("preview line = %q", line)
+	}
+	want := &models.RedactionReport{Counts: map[string]int{"ipv4": 2002, "email": 2}, Unredacted: []string{"model.bin"}}
+	if got := result.Redaction; got == nil || !maps.Equal(got.Counts, want.Counts) || len(got.Unredacted) != 1 || got.Unredacted[0] != "model.bin" {
+		t.Errorf("report = %+v, want %+v", got, want)
+	}
+
+	// The result took the tally
+	other := &models.TaskResult{Output: "clean"}
+	r.Result("task-1", other)
+	if len(other.Redaction.Counts) != 0 || len(other.Redaction.Unredacted) != 0 {
+		t.Errorf("second report = %+v, want it empty", other.Redaction)
+	}
+}
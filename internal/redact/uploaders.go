@@ -0,0 +1,109 @@
+package redact
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"unicode/utf8"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// sniffSize is how much of an artifact is looked at to tell text from
+// binary
+const sniffSize = 8 << 10
+
+// IsText reports whether head, the start of an artifact, looks like text:
+// valid UTF-8 without NUL bytes. A rune cut short at the end is allowed.
+func IsText(head []byte) bool {
+	if bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+	for len(head) > 0 {
+		r, size := utf8.DecodeRune(head)
+		if r == utf8.RuneError && size <= 1 {
+			return len(head) < utf8.UTFMax && !utf8.FullRune(head)
+		}
+		head = head[size:]
+	}
+	return true
+}
+
+// Uploaders returns source with the text artifacts it uploads redacted.
+// Binary artifacts are uploaded as they are and flagged in the task's
+// redaction report.
+func (r *Redactor) Uploaders(source artifacts.UploaderSource) artifacts.UploaderSource {
+	return &uploaderSource{r: r, source: source}
+}
+
+type uploaderSource struct {
+	r      *Redactor
+	source artifacts.UploaderSource
+}
+
+func (s *uploaderSource) ForTask(taskID string) artifacts.Uploader {
+	return &uploader{r: s.r, taskID: taskID, uploader: s.source.ForTask(taskID)}
+}
+
+type uploader struct {
+	r        *Redactor
+	taskID   string
+	uploader artifacts.Uploader
+}
+
+func (u *uploader) Add(ctx context.Context, name string, content io.Reader) (string, error) {
+	var ref string
+	err := u.redact(name, content, func(r io.Reader) (err error) {
+		ref, err = u.uploader.Add(ctx, name, r)
+		return err
+	})
+	return ref, err
+}
+
+// AddChunked redacts content as Add does and uploads it as chunks when the
+// wrapped uploader does, so that chunks are cut from the redacted artifact
+func (u *uploader) AddChunked(ctx context.Context, name string, content io.Reader) (string, *models.ChunkedArtifact, error) {
+	var (
+		ref     string
+		chunked *models.ChunkedArtifact
+	)
+	err := u.redact(name, content, func(r io.Reader) (err error) {
+		ref, chunked, err = artifacts.Upload(ctx, u.uploader, name, r)
+		return err
+	})
+	return ref, chunked, err
+}
+
+// redact hands upload content with its text redacted, or as it is when it
+// is binary
+func (u *uploader) redact(name string, content io.Reader, upload func(io.Reader) error) error {
+	buffered := bufio.NewReaderSize(content, sniffSize)
+	head, err := buffered.Peek(sniffSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if !IsText(head) {
+		u.r.Flag(u.taskID, name)
+		return upload(buffered)
+	}
+
+	pr, pw := io.Pipe()
+	w := u.r.NewWriter(pw)
+	go func() {
+		_, err := io.Copy(w, buffered)
+		if err == nil {
+			err = w.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	err = upload(pr)
+	// Unblocks the copy when the upload stopped reading
+	pr.Close()
+	if err != nil {
+		return err
+	}
+	u.r.tally(u.taskID, w.Counts())
+	return nil
+}
@@ -0,0 +1,111 @@
+package redact
+
+import (
+	"bytes"
+	"io"
+	"maps"
+	"sync"
+)
+
+// maxScan bounds how much of a write a Writer scans at once
+const maxScan = 64 << 10
+
+// maxWord bounds how far a Writer looks back for whitespace to cut at
+const maxWord = 256
+
+// Writer redacts what is written to it before passing it on. It holds
+// back the last window bytes, so that a match split across writes is still
+// found, and passes on the rest once twice the window is pending, so that
+// each byte is scanned about twice. Close passes on what is held back.
+type Writer struct {
+	r       *Redactor
+	w       io.Writer
+	mu      sync.Mutex
+	pending []byte
+	out     []byte
+	counts  map[string]int
+	err     error
+}
+
+// NewWriter returns a writer redacting what is written to it into w
+func (r *Redactor) NewWriter(w io.Writer) *Writer {
+	return &Writer{r: r, w: w, counts: make(map[string]int)}
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		take := min(len(p), maxScan)
+		w.pending = append(w.pending, p[:take]...)
+		p = p[take:]
+		if len(w.pending) >= 2*w.r.window {
+			if err := w.flush(false); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Close passes on what the writer holds back. It does not close the
+// underlying writer.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	return w.flush(true)
+}
+
+// Counts returns how many matches of each rule the writer redacted
+func (w *Writer) Counts() map[string]int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return maps.Clone(w.counts)
+}
+
+// flush redacts and passes on the pending bytes, all of them when final
+// and otherwise all but the window. The cut is moved back to the last
+// whitespace shortly before it, so that words are scanned whole, and to
+// the start of any match it would split.
+func (w *Writer) flush(final bool) error {
+	matches := w.r.matches(w.pending)
+	cut := len(w.pending)
+	if !final {
+		cut -= w.r.window
+		from := max(0, cut-maxWord)
+		if i := bytes.LastIndexAny(w.pending[from:cut], " \t\r\n"); i >= 0 {
+			cut = from + i + 1
+		}
+		limit := cut
+		for i, m := range matches {
+			if m.loc[1] > cut {
+				cut = min(cut, m.loc[0])
+				matches = matches[:i]
+				break
+			}
+		}
+		if cut == 0 {
+			// A match longer than the window is split rather than held
+			// back for good
+			cut = limit
+		}
+	}
+
+	w.out = replace(w.out[:0], w.pending[:cut], matches, w.counts)
+	w.pending = append(w.pending[:0], w.pending[cut:]...)
+	if len(w.out) == 0 {
+		return nil
+	}
+	if _, err := w.w.Write(w.out); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+// Package registrymirror pulls task images through pull-through registry
+// mirrors, such as one registry cache shared by a LAN of runners, without
+// reconfiguring the Docker daemon. Each registry maps to mirrors tried in
+// order; mirrors are health-checked and skipped while down, and a pull that
+// no mirror completes falls back to the registry itself. Images are only
+// pulled from a mirror by digest, either the one the task pinned or the
+// one the registry resolves the tag to, so the daemon verifies everything
+// a mirror serves against what the registry published.
+package registrymirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+)
+
+// checkTimeout bounds a mirror's health check and a digest resolution
+const checkTimeout = 5 * time.Second
+
+// manifestTypes are the manifests a tag may resolve to, of one platform
+// or of several
+var manifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// Mirror serves the images of Registry from URL
+type Mirror struct {
+	Registry string
+	URL      *url.URL
+}
+
+// Reference returns the reference the daemon pulls repository at digest
+// from the mirror by
+func (m Mirror) Reference(repository, digest string) string {
+	return m.URL.Host + "/" + repository + "@" + digest
+}
+
+// ParseMirrors parses mirror mappings such as
+// docker.io=http://mirror.lan:5000, in the order they are tried
+func ParseMirrors(specs []string) ([]Mirror, error) {
+	mirrors := make([]Mirror, 0, len(specs))
+	for _, spec := range specs {
+		registry, raw, ok := strings.Cut(strings.TrimSpace(spec), "=")
+		if !ok || registry == "" || raw == "" {
+			return nil, fmt.Errorf("invalid registry mirror %q, want registry=url", spec)
+		}
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid registry mirror URL %q", raw)
+		}
+		if registry == "index.docker.io" || registry == "registry-1.docker.io" {
+			registry = DockerHub
+		}
+		mirrors = append(mirrors, Mirror{Registry: registry, URL: u})
+	}
+	return mirrors, nil
+}
+
+// Stats counts the pulls of images from registries with mirrors
+type Stats struct {
+	// Hits are pulls a mirror served, and Fallbacks pulls that went to the
+	// registry because no mirror could serve them
+	Hits      uint64
+	Fallbacks uint64
+	Mirrors   int
+	Healthy   int
+}
+
+type mirrorState struct {
+	Mirror
+	healthy bool
+	lastErr error
+}
+
+// Pool tracks the health of the mirrors of each registry
+type Pool struct {
+	mirrors []*mirrorState
+	client  *http.Client
+	clock   clock.Clock
+
+	mu sync.Mutex
+
+	hits      atomic.Uint64
+	fallbacks atomic.Uint64
+}
+
+// NewPool returns a pool of mirrors, each healthy until checked
+func NewPool(mirrors []Mirror) *Pool {
+	p := &Pool{
+		client: dualstack.Client(checkTimeout),
+		clock:  clock.Real(),
+	}
+	for _, m := range mirrors {
+		p.mirrors = append(p.mirrors, &mirrorState{Mirror: m, healthy: true})
+	}
+	return p
+}
+
+// SetHTTPClient replaces the client mirrors and registries are asked with
+func (p *Pool) SetHTTPClient(client *http.Client) {
+	p.client = client
+}
+
+// SetClock replaces the clock health checks are scheduled by
+func (p *Pool) SetClock(c clock.Clock) {
+	p.clock = c
+}
+
+// For returns the healthy mirrors of registry, in the order to try them
+func (p *Pool) For(registry string) []Mirror {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var mirrors []Mirror
+	for _, m := range p.mirrors {
+		if m.Registry == registry && m.healthy {
+			mirrors = append(mirrors, m.Mirror)
+		}
+	}
+	return mirrors
+}
+
+// Mirrored reports whether registry has mirrors, healthy or not
+func (p *Pool) Mirrored(registry string) bool {
+	for _, m := range p.mirrors {
+		if m.Registry == registry {
+			return true
+		}
+	}
+	return false
+}
+
+// Failed takes mirror out of use until its next health check passes
+func (p *Pool) Failed(mirror Mirror, err error) {
+	p.setHealth(mirror, err)
+}
+
+// Hit counts a pull a mirror served
+func (p *Pool) Hit() {
+	p.hits.Add(1)
+}
+
+// Fallback counts a pull that went to the registry instead of a mirror
+func (p *Pool) Fallback() {
+	p.fallbacks.Add(1)
+}
+
+// Stats returns the pulls counted so far and how many mirrors are healthy
+func (p *Pool) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := Stats{Hits: p.hits.Load(), Fallbacks: p.fallbacks.Load(), Mirrors: len(p.mirrors)}
+	for _, m := range p.mirrors {
+		if m.healthy {
+			stats.Healthy++
+		}
+	}
+	return stats
+}
+
+func (p *Pool) setHealth(mirror Mirror, err error) {
+	log := gologger.WithComponent("registry_mirror")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range p.mirrors {
+		if m.Registry != mirror.Registry || m.URL.String() != mirror.URL.String() {
+			continue
+		}
+		healthy := err == nil
+		if healthy != m.healthy {
+			if healthy {
+				log.Info().Str("registry", m.Registry).Str("mirror", m.URL.String()).Msg("Registry mirror is back")
+			} else {
+				log.Warn().Err(err).Str("registry", m.Registry).Str("mirror", m.URL.String()).Msg("Registry mirror is down, pulling from the registry")
+			}
+		}
+		m.healthy, m.lastErr = healthy, err
+	}
+}
+
+// Check probes every mirror's registry API. A mirror answering at all,
+// even asking for credentials, is healthy.
+func (p *Pool) Check(ctx context.Context) {
+	for _, m := range p.mirrors {
+		p.setHealth(m.Mirror, p.probe(ctx, m.Mirror))
+	}
+}
+
+func (p *Pool) probe(ctx context.Context, m Mirror) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.URL.JoinPath("/v2/").String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("registry API answered %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Run checks the mirrors every interval until ctx ends
+func (p *Pool) Run(ctx context.Context, interval time.Duration) {
+	p.Check(ctx)
+	ticker := p.clock.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			p.Check(ctx)
+		}
+	}
+}
+
+// ResolveDigest returns the digest of ref, asking its registry what its
+// tag refers to unless ref pins a digest. Only anonymous access is tried,
+// so images of private registries resolve to an error.
+func (p *Pool) ResolveDigest(ctx context.Context, ref Reference) (string, error) {
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	manifest := registryURL(ref.Registry) + "/v2/" + ref.Repository + "/manifests/" + url.PathEscape(ref.Tag)
+	resp, err := p.headManifest(ctx, manifest, "")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		token, err := p.token(ctx, resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return "", err
+		}
+		if resp, err = p.headManifest(ctx, manifest, token); err != nil {
+			return "", err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry answered %d for %s:%s", resp.StatusCode, ref.Repository, ref.Tag)
+	}
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if !ValidDigest(digest) {
+		return "", fmt.Errorf("registry gave no valid digest for %s:%s", ref.Repository, ref.Tag)
+	}
+	return digest, nil
+}
+
+func (p *Pool) headManifest(ctx context.Context, manifest, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, manifest, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve image digest: %w", err)
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// token gets an anonymous pull token from the realm a registry's bearer
+// challenge names
+func (p *Pool) token(ctx context.Context, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", errors.New("registry requires credentials")
+	}
+	fields := make(map[string]string)
+	for _, param := range splitChallenge(params) {
+		key, value, _ := strings.Cut(param, "=")
+		fields[strings.ToLower(strings.TrimSpace(key))] = strings.Trim(value, `"`)
+	}
+	realm, err := url.Parse(fields["realm"])
+	if err != nil || realm.Scheme == "" {
+		return "", fmt.Errorf("invalid token realm %q", fields["realm"])
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if fields[key] != "" {
+			query.Set(key, fields[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get registry token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token endpoint answered %d", resp.StatusCode)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid registry token: %w", err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// splitChallenge splits the parameters of an authentication challenge on
+// the commas outside quotes
+func splitChallenge(params string) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i, r := range params {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ',' && !quoted:
+			parts = append(parts, params[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, params[start:])
+}
+
+// registryURL returns the base URL of registry's API. Registries on the
+// loopback interface are spoken to over plain HTTP, as the daemon does.
+func registryURL(registry string) string {
+	if registry == DockerHub {
+		return "https://registry-1.docker.io"
+	}
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return "http://" + registry
+	}
+	return "https://" + registry
+}
@@ -0,0 +1,180 @@
+package registrymirror
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Reference
+	}{
+		{"alpine", Reference{Registry: DockerHub, Repository: "library/alpine", Tag: "latest"}},
+		{"alpine:3.20", Reference{Registry: DockerHub, Repository: "library/alpine", Tag: "3.20"}},
+		{"org/app", Reference{Registry: DockerHub, Repository: "org/app", Tag: "latest"}},
+		{"index.docker.io/org/app:1", Reference{Registry: DockerHub, Repository: "org/app", Tag: "1"}},
+		{"ghcr.io/org/app:1.2@" + testDigest, Reference{Registry: "ghcr.io", Repository: "org/app", Tag: "1.2", Digest: testDigest}},
+		{"localhost:5000/app", Reference{Registry: "localhost:5000", Repository: "app", Tag: "latest"}},
+		{"python@" + testDigest, Reference{Registry: DockerHub, Repository: "library/python", Digest: testDigest}},
+	}
+	for _, tt := range tests {
+		got, err := ParseReference(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "alpine:", "Alpine", "alpine@sha256:abc", ":tag"} {
+		if _, err := ParseReference(in); err == nil {
+			t.Errorf("ParseReference(%q) succeeded", in)
+		}
+	}
+}
+
+func TestParseMirrors(t *testing.T) {
+	mirrors, err := ParseMirrors([]string{"docker.io=http://mirror.lan:5000", "registry-1.docker.io=https://backup.lan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mirrors) != 2 || mirrors[1].Registry != DockerHub || mirrors[0].Reference("library/alpine", testDigest) != "mirror.lan:5000/library/alpine@"+testDigest {
+		t.Errorf("ParseMirrors() = %+v", mirrors)
+	}
+	for _, spec := range []string{"docker.io", "docker.io=", "docker.io=ftp://mirror", "=http://mirror"} {
+		if _, err := ParseMirrors([]string{spec}); err == nil {
+			t.Errorf("ParseMirrors(%q) succeeded", spec)
+		}
+	}
+}
+
+func mirrorOf(t *testing.T, registry, raw string) Mirror {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Mirror{Registry: registry, URL: u}
+}
+
+func TestPoolHealth(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A mirror asking for credentials is up
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer up.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	first, second := mirrorOf(t, DockerHub, down.URL), mirrorOf(t, DockerHub, up.URL)
+	pool := NewPool([]Mirror{first, second, mirrorOf(t, "ghcr.io", up.URL)})
+	if got := pool.For(DockerHub); len(got) != 2 {
+		t.Fatalf("For() = %v before any check, want both mirrors", got)
+	}
+
+	pool.Check(context.Background())
+	if got := pool.For(DockerHub); len(got) != 1 || got[0].URL.String() != up.URL {
+		t.Errorf("For() = %v, want only the healthy mirror", got)
+	}
+	if stats := pool.Stats(); stats.Mirrors != 3 || stats.Healthy != 2 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+
+	pool.Failed(second, context.DeadlineExceeded)
+	if got := pool.For(DockerHub); len(got) != 0 {
+		t.Errorf("For() = %v after the last mirror failed", got)
+	}
+	if !pool.Mirrored(DockerHub) || pool.Mirrored("quay.io") {
+		t.Error("Mirrored() does not tell mirrored registries apart")
+	}
+	pool.Check(context.Background())
+	if got := pool.For(DockerHub); len(got) != 1 {
+		t.Errorf("For() = %v, want the failed mirror back once it answers", got)
+	}
+}
+
+func TestResolveDigestWithToken(t *testing.T) {
+	var registry *httptest.Server
+	registry = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if r.URL.Query().Get("scope") != "repository:org/app:pull" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"token":"secret"}`))
+		case "/v2/org/app/manifests/1.0":
+			if r.Method != http.MethodHead || !strings.Contains(r.Header.Get("Accept"), "manifest.list") {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			if r.Header.Get("Authorization") != "Bearer secret" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+registry.URL+`/token",service="registry",scope="repository:org/app:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", testDigest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+
+	// Registries on the loopback interface are spoken to over plain HTTP
+	ref, err := ParseReference(strings.TrimPrefix(registry.URL, "http://") + "/org/app:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(nil)
+	digest, err := pool.ResolveDigest(context.Background(), ref)
+	if err != nil || digest != testDigest {
+		t.Errorf("ResolveDigest() = %q, %v, want %q", digest, err, testDigest)
+	}
+
+	ref.Tag = "missing"
+	if _, err := pool.ResolveDigest(context.Background(), ref); err == nil {
+		t.Error("ResolveDigest() of a missing tag succeeded")
+	}
+	ref.Digest = testDigest
+	if digest, err := pool.ResolveDigest(context.Background(), ref); err != nil || digest != testDigest {
+		t.Errorf("ResolveDigest() = %q, %v for a pinned digest", digest, err)
+	}
+}
+
+func TestImageSizeFromIndex(t *testing.T) {
+	index := fmt.Sprintf(`{"manifests":[
+		{"digest":"sha256:%064d","platform":{"os":"plan9","architecture":"mips"}},
+		{"digest":%q,"platform":{"os":%q,"architecture":%q}}]}`, 1, testDigest, runtime.GOOS, runtime.GOARCH)
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/org/app/manifests/1.0":
+			w.Write([]byte(index))
+		case "/v2/org/app/manifests/" + testDigest:
+			w.Write([]byte(`{"config":{"size":100},"layers":[{"size":1000},{"size":2000}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer registry.Close()
+
+	ref, err := ParseReference(strings.TrimPrefix(registry.URL, "http://") + "/org/app:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := NewPool(nil)
+	if size, err := pool.ImageSize(context.Background(), ref); err != nil || size != 3100 {
+		t.Errorf("ImageSize() = %d, %v, want the 3100 bytes of this platform's image", size, err)
+	}
+	ref.Tag = "missing"
+	if _, err := pool.ImageSize(context.Background(), ref); err == nil {
+		t.Error("ImageSize() of a missing tag succeeded")
+	}
+}
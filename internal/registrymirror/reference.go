@@ -0,0 +1,73 @@
+package registrymirror
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DockerHub is the registry references without one resolve to
+const DockerHub = "docker.io"
+
+var digestPattern = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// Reference is an image reference split the way the Docker daemon resolves
+// it: alpine is docker.io/library/alpine:latest
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference splits an image reference such as
+// ghcr.io/org/app:1.2@sha256:... into its parts
+func ParseReference(s string) (Reference, error) {
+	var ref Reference
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		ref.Digest = name[i+1:]
+		name = name[:i]
+		if !digestPattern.MatchString(ref.Digest) {
+			return Reference{}, fmt.Errorf("invalid digest in image reference %q", s)
+		}
+	}
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i:], "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+		if ref.Tag == "" {
+			return Reference{}, fmt.Errorf("invalid tag in image reference %q", s)
+		}
+	}
+	if name == "" {
+		return Reference{}, fmt.Errorf("invalid image reference %q", s)
+	}
+
+	ref.Registry = DockerHub
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			ref.Registry = first
+			name = name[i+1:]
+		}
+	}
+	if ref.Registry == "index.docker.io" || ref.Registry == "registry-1.docker.io" {
+		ref.Registry = DockerHub
+	}
+	if ref.Registry == DockerHub && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if name == "" || name != strings.ToLower(name) {
+		return Reference{}, fmt.Errorf("invalid repository in image reference %q", s)
+	}
+	ref.Repository = name
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+	return ref, nil
+}
+
+// ValidDigest reports whether digest is a sha256 content digest
+func ValidDigest(digest string) bool {
+	return digestPattern.MatchString(digest)
+}
@@ -0,0 +1,119 @@
+package registrymirror
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+)
+
+// maxManifestSize bounds a manifest read from a registry
+const maxManifestSize = 4 << 20
+
+// manifest is what sizing an image needs of an image index or manifest
+type manifest struct {
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+		} `json:"platform"`
+	} `json:"manifests"`
+	Config struct {
+		Size int64 `json:"size"`
+	} `json:"config"`
+	Layers []struct {
+		Size int64 `json:"size"`
+	} `json:"layers"`
+}
+
+// ImageSize returns the compressed size of ref's image for this host's
+// platform, its config and layers as the registry lists them, without
+// pulling it
+func (p *Pool) ImageSize(ctx context.Context, ref Reference) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	base := registryURL(ref.Registry) + "/v2/" + ref.Repository + "/manifests/"
+	reference := ref.Digest
+	if reference == "" {
+		reference = url.PathEscape(ref.Tag)
+	}
+	var token string
+	m, err := p.getManifest(ctx, base+reference, &token)
+	if err != nil {
+		return 0, err
+	}
+	if len(m.Manifests) > 0 {
+		digest := ""
+		for _, entry := range m.Manifests {
+			if entry.Platform.OS == runtime.GOOS && entry.Platform.Architecture == runtime.GOARCH {
+				digest = entry.Digest
+				break
+			}
+		}
+		if !ValidDigest(digest) {
+			return 0, fmt.Errorf("%s has no image for %s/%s", ref.Repository, runtime.GOOS, runtime.GOARCH)
+		}
+		if m, err = p.getManifest(ctx, base+digest, &token); err != nil {
+			return 0, err
+		}
+	}
+
+	size := m.Config.Size
+	for _, layer := range m.Layers {
+		size += layer.Size
+	}
+	if size <= 0 {
+		return 0, fmt.Errorf("manifest of %s lists no layers", ref.Repository)
+	}
+	return size, nil
+}
+
+// getManifest downloads the manifest at manifestURL, getting an anonymous
+// token when the registry asks for one and keeping it in token for the
+// next request
+func (p *Pool) getManifest(ctx context.Context, manifestURL string, token *string) (*manifest, error) {
+	resp, err := p.requestManifest(ctx, manifestURL, *token)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		if *token, err = p.token(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+			return nil, err
+		}
+		if resp, err = p.requestManifest(ctx, manifestURL, *token); err != nil {
+			return nil, err
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registry answered %d for manifest %s", resp.StatusCode, manifestURL)
+	}
+	var m manifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	return &m, nil
+}
+
+func (p *Pool) requestManifest(ctx context.Context, manifestURL, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, manifestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch image manifest: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,278 @@
+// Package relocation packs a runner's identity and state into an archive
+// encrypted with a passphrase, so that they can be moved to new hardware.
+// The archive is a gzipped tar of the files, led by a manifest, sealed with
+// AES-256-GCM under a key derived from the passphrase with scrypt.
+package relocation
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Version is the archive format written by Seal
+const Version = 1
+
+const (
+	manifestName = "manifest.json"
+
+	saltSize = 16
+	keySize  = 32
+	scryptN  = 1 << 15
+	scryptR  = 8
+	scryptP  = 1
+
+	// maxArchiveSize bounds what Open reads, well above any runner's state
+	// without its caches' content
+	maxArchiveSize = 1 << 30
+)
+
+// magic leads every archive and is authenticated along with its content
+var magic = []byte("PARITYMV1\n")
+
+var (
+	// ErrPassphrase is returned for an archive that does not open with the
+	// passphrase given, which is also what a damaged archive looks like
+	ErrPassphrase = errors.New("wrong passphrase or damaged archive")
+	// ErrFormat is returned for data that is not an archive Open reads
+	ErrFormat = errors.New("not a runner migration archive")
+)
+
+// Manifest describes what an archive holds
+type Manifest struct {
+	Version int `json:"version"`
+	// ArchiveID identifies the export, so the server can refuse to bind the
+	// same archive twice
+	ArchiveID string `json:"archive_id"`
+	DeviceID  string `json:"device_id"`
+	// WalletAddress references the runner's wallet; its key is only in the
+	// archive when Keys is set
+	WalletAddress string `json:"wallet_address,omitempty"`
+	// Machine is the MachineID of the machine the archive was exported from
+	Machine    string    `json:"machine"`
+	ExportedAt time.Time `json:"exported_at"`
+	Keys       bool      `json:"keys,omitempty"`
+	Caches     bool      `json:"caches,omitempty"`
+}
+
+// Archive is a manifest and the files it describes, by slash-separated
+// path relative to the runner's data directory
+type Archive struct {
+	Manifest Manifest
+	Files    map[string][]byte
+}
+
+// NewArchiveID returns a random archive ID
+func NewArchiveID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate archive ID: %w", err)
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// Seal writes archive to w, encrypted with passphrase
+func Seal(w io.Writer, archive *Archive, passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return errors.New("a passphrase is required")
+	}
+	plaintext, err := pack(archive)
+	if err != nil {
+		return err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+	aead, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	header := make([]byte, 0, len(magic)+len(salt)+len(nonce))
+	header = append(append(append(header, magic...), salt...), nonce...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(aead.Seal(nil, nonce, plaintext, header))
+	return err
+}
+
+// Open reads an archive sealed with passphrase from r
+func Open(r io.Reader, passphrase []byte) (*Archive, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxArchiveSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxArchiveSize {
+		return nil, fmt.Errorf("archive exceeds %d bytes", maxArchiveSize)
+	}
+	if !bytes.HasPrefix(data, magic) || len(data) < len(magic)+saltSize {
+		return nil, ErrFormat
+	}
+	salt := data[len(magic) : len(magic)+saltSize]
+	aead, err := deriveKey(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	headerSize := len(magic) + saltSize + aead.NonceSize()
+	if len(data) < headerSize {
+		return nil, ErrFormat
+	}
+	nonce := data[len(magic)+saltSize : headerSize]
+	plaintext, err := aead.Open(nil, nonce, data[headerSize:], data[:headerSize])
+	if err != nil {
+		return nil, ErrPassphrase
+	}
+	return unpack(plaintext)
+}
+
+func deriveKey(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, keySize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive archive key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// pack writes the manifest and then the files, in path order
+func pack(archive *Archive) ([]byte, error) {
+	manifest, err := json.Marshal(archive.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(archive.Files))
+	for name := range archive.Files {
+		if err := checkName(name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	write := func(name string, data []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o600, Size: int64(len(data)), Typeflag: tar.TypeReg}); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := write(manifestName, manifest); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := write(name, archive.Files[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func unpack(data []byte) (*Archive, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	tr := tar.NewReader(gz)
+	archive := &Archive{Files: make(map[string][]byte)}
+	sawManifest := false
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("%w: %s is not a regular file", ErrFormat, header.Name)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+		}
+		if !sawManifest {
+			if header.Name != manifestName {
+				return nil, fmt.Errorf("%w: manifest missing", ErrFormat)
+			}
+			if err := json.Unmarshal(content, &archive.Manifest); err != nil {
+				return nil, fmt.Errorf("%w: invalid manifest: %v", ErrFormat, err)
+			}
+			sawManifest = true
+			continue
+		}
+		if err := checkName(header.Name); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+		}
+		archive.Files[header.Name] = content
+	}
+	if !sawManifest {
+		return nil, fmt.Errorf("%w: manifest missing", ErrFormat)
+	}
+	if archive.Manifest.Version != Version {
+		return nil, fmt.Errorf("%w: version %d, want %d", ErrFormat, archive.Manifest.Version, Version)
+	}
+	if archive.Manifest.DeviceID == "" {
+		return nil, fmt.Errorf("%w: no device ID", ErrFormat)
+	}
+	return archive, nil
+}
+
+// checkName refuses paths that would be written outside the data directory
+func checkName(name string) error {
+	if name == "" || name == manifestName || path.IsAbs(name) || path.Clean(name) != name ||
+		name == ".." || strings.HasPrefix(name, "../") || strings.Contains(name, "\\") {
+		return fmt.Errorf("invalid archive path %q", name)
+	}
+	return nil
+}
+
+// MachineID identifies the machine the runner runs on, hashing the OS's
+// machine ID, or its hostname where there is none, so that the archive does
+// not carry either
+func MachineID() (string, error) {
+	id, err := os.ReadFile("/etc/machine-id")
+	if err != nil || len(bytes.TrimSpace(id)) == 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("failed to identify machine: %w", err)
+		}
+		id = []byte(hostname)
+	}
+	sum := sha256.Sum256(append([]byte("parity-runner machine:"), bytes.TrimSpace(id)...))
+	return hex.EncodeToString(sum[:16]), nil
+}
@@ -0,0 +1,82 @@
+package relocation
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testArchive() *Archive {
+	return &Archive{
+		Manifest: Manifest{
+			Version:       Version,
+			ArchiveID:     "a1",
+			DeviceID:      "device-1",
+			WalletAddress: "0x0000000000000000000000000000000000000001",
+			Machine:       "old",
+			ExportedAt:    time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		},
+		Files: map[string][]byte{
+			"history.jsonl":           []byte("{\"task_id\":\"t1\"}\n"),
+			"accounting/ledger.jsonl": []byte("{}\n"),
+			"outbox/t1.json":          {},
+		},
+	}
+}
+
+func TestSealOpenRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Seal(&buf, testArchive(), []byte("correct horse")); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("device-1")) || bytes.Contains(buf.Bytes(), []byte("task_id")) {
+		t.Error("archive holds its content in plaintext")
+	}
+
+	got, err := Open(bytes.NewReader(buf.Bytes()), []byte("correct horse"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	want := testArchive()
+	if got.Manifest != want.Manifest {
+		t.Errorf("manifest = %+v, want %+v", got.Manifest, want.Manifest)
+	}
+	if len(got.Files) != len(want.Files) {
+		t.Errorf("%d files, want %d", len(got.Files), len(want.Files))
+	}
+	for name, content := range want.Files {
+		if !bytes.Equal(got.Files[name], content) {
+			t.Errorf("%s = %q, want %q", name, got.Files[name], content)
+		}
+	}
+}
+
+func TestOpenWrongPassphrase(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Seal(&buf, testArchive(), []byte("correct horse")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(bytes.NewReader(buf.Bytes()), []byte("battery staple")); !errors.Is(err, ErrPassphrase) {
+		t.Errorf("Open() error = %v, want ErrPassphrase", err)
+	}
+
+	tampered := append([]byte(nil), buf.Bytes()...)
+	tampered[len(magic)] ^= 1
+	if _, err := Open(bytes.NewReader(tampered), []byte("correct horse")); !errors.Is(err, ErrPassphrase) {
+		t.Errorf("Open() of a tampered archive error = %v, want ErrPassphrase", err)
+	}
+	if _, err := Open(bytes.NewReader([]byte("not an archive")), []byte("correct horse")); !errors.Is(err, ErrFormat) {
+		t.Errorf("Open() of other data error = %v, want ErrFormat", err)
+	}
+}
+
+func TestSealRejectsEscapingPaths(t *testing.T) {
+	for _, name := range []string{"../keystore.json", "/etc/passwd", "a/../../b", manifestName, `a\b`} {
+		archive := testArchive()
+		archive.Files[name] = nil
+		if err := Seal(&bytes.Buffer{}, archive, []byte("p")); err == nil {
+			t.Errorf("Seal() accepted path %q", name)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package reputation
+
+import (
+	"math"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// Reconcile compares the runner's own score in report with the score the
+// server reported in info, fetched at fetchedAt. The scores diverge when
+// they differ by more than tolerance points, or the server scored with
+// another version of the formula. It returns nil while the server has not
+// scored the runner.
+func Reconcile(report *models.ReputationReport, info *models.RunnerInfo, fetchedAt time.Time, tolerance float64) *models.ServerReputation {
+	if info == nil || info.ReputationScore == nil {
+		return nil
+	}
+	server := &models.ServerReputation{
+		Score:          *info.ReputationScore,
+		FormulaVersion: info.ReputationFormulaVersion,
+		FetchedAt:      fetchedAt,
+		Divergence:     report.Score - *info.ReputationScore,
+	}
+	versionDiffers := server.FormulaVersion != 0 && server.FormulaVersion != report.FormulaVersion
+	server.Diverged = versionDiffers || math.Abs(server.Divergence) > tolerance
+	return server
+}
@@ -0,0 +1,251 @@
+// Package reputation computes the reputation score the server ranks runners
+// by, with the formula the server publishes, from the runner's own history,
+// so operators can see why their runner ranks where it does and what their
+// decisions would do to it.
+//
+// Version 1 of the formula scores the executions that finished within a
+// window, each weighted by half for every half-life since it finished, on
+// four factors:
+//
+//   - completion: the share of executions that completed
+//   - reliability: one less the share the runner abandoned, each weighted
+//     by a penalty for why it was abandoned
+//   - latency: for completed executions of a workload with enough earlier
+//     completions to expect a duration of, the expected duration times a
+//     tolerance over the actual one, capped at 1
+//   - verification: the share of results whose output manifest or
+//     post-processing verdict passed, of those that had one
+//
+// Each factor starts from a prior of executions that scored 1, so a runner
+// with little history is neither rewarded nor punished for it much. The
+// score is the weighted sum of the factors, out of 100.
+package reputation
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/history"
+)
+
+// FormulaVersion is the version of the server's formula this package
+// implements
+const FormulaVersion = 1
+
+// latencyHistory bounds the earlier completions a workload's expected
+// duration is the median of
+const latencyHistory = 20
+
+// Weights weigh the factors of the score; they sum to 1
+type Weights struct {
+	Completion   float64 `json:"completion"`
+	Reliability  float64 `json:"reliability"`
+	Latency      float64 `json:"latency"`
+	Verification float64 `json:"verification"`
+}
+
+// Policy holds the parameters of the formula
+type Policy struct {
+	// Window is how long executions count for
+	Window time.Duration `json:"window"`
+	// HalfLife is how long it takes an execution to count half as much
+	HalfLife time.Duration `json:"half_life"`
+	// Prior is how many executions scoring 1 each factor starts from
+	Prior float64 `json:"prior"`
+	// LatencyTolerance is how many times its expected duration an
+	// execution may take before it costs latency
+	LatencyTolerance float64 `json:"latency_tolerance"`
+	// LatencySamples is the fewest earlier completions of a workload that
+	// give it an expected duration
+	LatencySamples int     `json:"latency_samples"`
+	Weights        Weights `json:"weights"`
+}
+
+// DefaultPolicy is the server's published formula
+var DefaultPolicy = Policy{
+	Window:           30 * 24 * time.Hour,
+	HalfLife:         7 * 24 * time.Hour,
+	Prior:            5,
+	LatencyTolerance: 1.5,
+	LatencySamples:   3,
+	Weights: Weights{
+		Completion:   0.35,
+		Reliability:  0.30,
+		Latency:      0.15,
+		Verification: 0.20,
+	},
+}
+
+// Outcome is a finished execution as the formula sees it
+type Outcome struct {
+	Type     models.TaskType    `json:"type"`
+	Workload string             `json:"workload,omitempty"`
+	Status   models.TaskStatus  `json:"status"`
+	Code     models.FailureCode `json:"failure_code,omitempty"`
+	// FinishedAt is when the execution ended
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   time.Duration `json:"duration"`
+	// Verified is whether the result passed its verification, nil when it
+	// had none
+	Verified *bool `json:"verified,omitempty"`
+}
+
+// Penalty is how much an execution that failed with code counts against
+// reliability. Failures the runner caused count in full and tasks it gave
+// up on for stalling nearly so; tasks that ran out their time, and failures
+// not classified, count half. Failures the task caused do not count.
+func Penalty(code models.FailureCode) float64 {
+	switch {
+	case code.RunnerCaused():
+		return 1
+	case code == models.FailureStalled:
+		return 0.75
+	case code == models.FailureTimeout, code == models.FailureUnknown, code == "":
+		return 0.5
+	}
+	return 0
+}
+
+// FromHistory returns the outcomes of the executions recorded in records by
+// the logical runner instance, in the order they finished. Annotations,
+// canaries and revoked tasks, which the network rather than the runner
+// ended, are not outcomes.
+func FromHistory(records []history.Record, instance string) []Outcome {
+	var outcomes []Outcome
+	for _, record := range records {
+		if record.Event != "" || record.Instance != instance {
+			continue
+		}
+		if record.Status == models.TaskStatusRevoked || record.FailureCode == models.FailureRevoked {
+			continue
+		}
+		if record.Status != models.TaskStatusCompleted && record.Status != models.TaskStatusFailed {
+			continue
+		}
+		outcomes = append(outcomes, Outcome{
+			Type:       record.Type,
+			Workload:   record.Workload,
+			Status:     record.Status,
+			Code:       record.FailureCode,
+			FinishedAt: record.StartedAt.Add(record.Duration()),
+			Duration:   record.Duration(),
+			Verified:   record.Verified,
+		})
+	}
+	sort.SliceStable(outcomes, func(i, j int) bool { return outcomes[i].FinishedAt.Before(outcomes[j].FinishedAt) })
+	return outcomes
+}
+
+// tally accumulates the weighted samples of a factor
+type tally struct {
+	weight, sum float64
+	samples     int
+}
+
+func (t *tally) add(weight, value float64) {
+	t.weight += weight
+	t.sum += weight * value
+	t.samples++
+}
+
+// value is the factor the samples give, starting from prior samples of 1
+func (t *tally) value(prior float64) float64 {
+	if t.weight+prior == 0 {
+		return 1
+	}
+	return (t.sum + prior) / (t.weight + prior)
+}
+
+// Score computes the score of outcomes, in the order they finished, at now
+func Score(outcomes []Outcome, now time.Time, p Policy) *models.ReputationReport {
+	var completion, reliability, latency, verification tally
+	// durations holds each workload's completed durations so far
+	durations := make(map[string][]time.Duration)
+	executions := 0
+
+	for _, o := range outcomes {
+		key := string(o.Type) + "/" + o.Workload
+		expected, known := expectedDuration(durations[key], p.LatencySamples)
+		if o.Status == models.TaskStatusCompleted {
+			durations[key] = append(durations[key], o.Duration)
+		}
+
+		age := max(now.Sub(o.FinishedAt), 0)
+		if age > p.Window {
+			continue
+		}
+		weight := math.Exp2(-age.Hours() / p.HalfLife.Hours())
+		executions++
+
+		completed := o.Status == models.TaskStatusCompleted
+		completion.add(weight, boolValue(completed))
+		abandoned := 0.0
+		if !completed {
+			abandoned = Penalty(o.Code)
+		}
+		reliability.add(weight, 1-abandoned)
+		if completed && known {
+			onTime := 1.0
+			if o.Duration > 0 {
+				onTime = math.Min(1, p.LatencyTolerance*expected.Seconds()/o.Duration.Seconds())
+			}
+			latency.add(weight, onTime)
+		}
+		if o.Verified != nil {
+			verification.add(weight, boolValue(*o.Verified))
+		}
+	}
+
+	report := &models.ReputationReport{
+		FormulaVersion: FormulaVersion,
+		Executions:     executions,
+		ComputedAt:     now,
+	}
+	for _, f := range []struct {
+		name   string
+		weight float64
+		tally  tally
+	}{
+		{models.ReputationCompletion, p.Weights.Completion, completion},
+		{models.ReputationReliability, p.Weights.Reliability, reliability},
+		{models.ReputationLatency, p.Weights.Latency, latency},
+		{models.ReputationVerification, p.Weights.Verification, verification},
+	} {
+		value := f.tally.value(p.Prior)
+		contribution := 100 * f.weight * value
+		report.Factors = append(report.Factors, models.ReputationFactor{
+			Name:         f.name,
+			Value:        value,
+			Weight:       f.weight,
+			Contribution: contribution,
+			Lost:         100*f.weight - contribution,
+			Samples:      f.tally.samples,
+		})
+		report.Score += contribution
+	}
+	return report
+}
+
+// expectedDuration is the median of the last completions of a workload,
+// known once there are at least samples of them
+func expectedDuration(durations []time.Duration, samples int) (time.Duration, bool) {
+	if len(durations) == 0 || len(durations) < samples {
+		return 0, false
+	}
+	recent := append([]time.Duration(nil), durations[max(0, len(durations)-latencyHistory):]...)
+	sort.Slice(recent, func(i, j int) bool { return recent[i] < recent[j] })
+	mid := len(recent) / 2
+	if len(recent)%2 == 0 {
+		return (recent[mid-1] + recent[mid]) / 2, true
+	}
+	return recent[mid], true
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,219 @@
+package reputation
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/history"
+)
+
+var testNow = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// vector is a fixture of the published formula: outcomes given by how long
+// before now they finished, and the score and factor values they give
+type vector struct {
+	Name     string `json:"name"`
+	Outcomes []struct {
+		Type     models.TaskType    `json:"type"`
+		Workload string             `json:"workload"`
+		Status   models.TaskStatus  `json:"status"`
+		Code     models.FailureCode `json:"failure_code"`
+		Age      string             `json:"age"`
+		Duration string             `json:"duration"`
+		Verified *bool              `json:"verified"`
+	} `json:"outcomes"`
+	Score   float64            `json:"score"`
+	Factors map[string]float64 `json:"factors"`
+}
+
+func loadVectors(t *testing.T) []vector {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "vectors.json"))
+	if err != nil {
+		t.Fatalf("Failed to read vectors: %v", err)
+	}
+	var vectors []vector
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		t.Fatalf("Failed to parse vectors: %v", err)
+	}
+	return vectors
+}
+
+func (v vector) outcomes(t *testing.T) []Outcome {
+	t.Helper()
+	var outcomes []Outcome
+	for _, o := range v.Outcomes {
+		age, err := time.ParseDuration(o.Age)
+		if err != nil {
+			t.Fatalf("%s: bad age %q", v.Name, o.Age)
+		}
+		duration, err := time.ParseDuration(o.Duration)
+		if err != nil {
+			t.Fatalf("%s: bad duration %q", v.Name, o.Duration)
+		}
+		outcomes = append(outcomes, Outcome{
+			Type:       o.Type,
+			Workload:   o.Workload,
+			Status:     o.Status,
+			Code:       o.Code,
+			FinishedAt: testNow.Add(-age),
+			Duration:   duration,
+			Verified:   o.Verified,
+		})
+	}
+	return outcomes
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-5
+}
+
+func TestScoreVectors(t *testing.T) {
+	for _, v := range loadVectors(t) {
+		t.Run(v.Name, func(t *testing.T) {
+			report := Score(v.outcomes(t), testNow, DefaultPolicy)
+			if !near(report.Score, v.Score) {
+				t.Errorf("score = %f, want %f", report.Score, v.Score)
+			}
+			if len(report.Factors) != len(v.Factors) {
+				t.Fatalf("factors = %+v, want %v", report.Factors, v.Factors)
+			}
+			sum := 0.0
+			for _, factor := range report.Factors {
+				if want, ok := v.Factors[factor.Name]; !ok || !near(factor.Value, want) {
+					t.Errorf("%s = %f, want %f", factor.Name, factor.Value, want)
+				}
+				if !near(factor.Contribution+factor.Lost, 100*factor.Weight) {
+					t.Errorf("%s contributes %f and loses %f of %f", factor.Name, factor.Contribution, factor.Lost, 100*factor.Weight)
+				}
+				sum += factor.Contribution
+			}
+			if !near(sum, report.Score) {
+				t.Errorf("contributions sum to %f, score is %f", sum, report.Score)
+			}
+		})
+	}
+}
+
+func TestFromHistory(t *testing.T) {
+	start := testNow.Add(-time.Hour)
+	verified := true
+	records := []history.Record{
+		{TaskID: "late", Instance: "a", Type: models.TaskTypeDocker, Status: models.TaskStatusCompleted, StartedAt: start.Add(10 * time.Minute), DurationMs: 1000, Verified: &verified},
+		{TaskID: "early", Instance: "a", Type: models.TaskTypeDocker, Status: models.TaskStatusFailed, FailureCode: models.FailureStalled, StartedAt: start, DurationMs: 60000},
+		{TaskID: "other", Instance: "b", Type: models.TaskTypeDocker, Status: models.TaskStatusCompleted, StartedAt: start},
+		{TaskID: "revoked", Instance: "a", Type: models.TaskTypeDocker, Status: models.TaskStatusFailed, FailureCode: models.FailureRevoked, StartedAt: start},
+		{TaskID: "late", Instance: "a", Event: "annotated", StartedAt: start},
+	}
+
+	outcomes := FromHistory(records, "a")
+	if len(outcomes) != 2 {
+		t.Fatalf("outcomes = %+v, want the two executions of instance a", outcomes)
+	}
+	if outcomes[0].Code != models.FailureStalled || !outcomes[0].FinishedAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("first outcome = %+v, want the stalled task ending a minute in", outcomes[0])
+	}
+	if outcomes[1].Verified == nil || !*outcomes[1].Verified || outcomes[1].Duration != time.Second {
+		t.Errorf("second outcome = %+v, want the verified completion", outcomes[1])
+	}
+}
+
+func TestPenalty(t *testing.T) {
+	tests := []struct {
+		code models.FailureCode
+		want float64
+	}{
+		{models.FailureContainerRuntime, 1},
+		{models.FailureStalled, 0.75},
+		{models.FailureTimeout, 0.5},
+		{"", 0.5},
+		{models.FailureTaskExit, 0},
+	}
+	for _, tt := range tests {
+		if got := Penalty(tt.code); got != tt.want {
+			t.Errorf("Penalty(%q) = %f, want %f", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestTaskScenarios(t *testing.T) {
+	var outcomes []Outcome
+	for i := 0; i < 5; i++ {
+		outcomes = append(outcomes, Outcome{
+			Type:       models.TaskTypeDocker,
+			Workload:   "trainer:1",
+			Status:     models.TaskStatusCompleted,
+			FinishedAt: testNow.Add(-time.Duration(10-i) * time.Hour),
+			Duration:   10 * time.Minute,
+		})
+	}
+	task := models.RunningTask{
+		TaskID:    "stuck",
+		Type:      models.TaskTypeDocker,
+		Workload:  "trainer:1",
+		StartedAt: testNow.Add(-40 * time.Minute),
+		Timeout:   2 * time.Hour,
+	}
+
+	results := Simulate(outcomes, testNow, DefaultPolicy, TaskScenarios(task, testNow))
+	scores := make(map[string]models.ReputationScenario)
+	for _, result := range results {
+		scores[result.Name] = result
+	}
+	if len(scores) != 4 {
+		t.Fatalf("scenarios = %+v, want four", results)
+	}
+
+	abandon, timeout := scores["abandon_now"], scores["time_out"]
+	if abandon.Delta >= 0 || timeout.Delta >= 0 {
+		t.Errorf("abandoning (%f) and timing out (%f) should cost score", abandon.Delta, timeout.Delta)
+	}
+	// A timeout costs reliability less than abandoning, both fail completion
+	if timeout.Score <= abandon.Score {
+		t.Errorf("time_out = %f, abandon_now = %f; a timeout should cost less", timeout.Score, abandon.Score)
+	}
+	// Completing 40 minutes into a 10 minute workload is late; completing at
+	// the timeout is later still
+	now, late := scores["complete_now"], scores["complete_at_timeout"]
+	if now.Delta >= 0 || late.Score >= now.Score {
+		t.Errorf("complete_now = %+v, complete_at_timeout = %+v, want late completions to cost latency", now, late)
+	}
+	if now.Score <= abandon.Score {
+		t.Errorf("complete_now = %f should beat abandon_now = %f", now.Score, abandon.Score)
+	}
+
+	// A task past its timeout can only be abandoned or complete now
+	task.Timeout = 30 * time.Minute
+	if scenarios := TaskScenarios(task, testNow); len(scenarios) != 2 {
+		t.Errorf("scenarios past timeout = %+v, want two", scenarios)
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	report := &models.ReputationReport{Score: 90, FormulaVersion: FormulaVersion}
+	score := func(s float64) *float64 { return &s }
+
+	if got := Reconcile(report, &models.RunnerInfo{}, testNow, 5); got != nil {
+		t.Errorf("Reconcile() without a server score = %+v, want nil", got)
+	}
+
+	got := Reconcile(report, &models.RunnerInfo{ReputationScore: score(87), ReputationFormulaVersion: FormulaVersion}, testNow, 5)
+	if got == nil || got.Diverged || !near(got.Divergence, 3) || !got.FetchedAt.Equal(testNow) {
+		t.Errorf("Reconcile() within tolerance = %+v", got)
+	}
+
+	got = Reconcile(report, &models.RunnerInfo{ReputationScore: score(80)}, testNow, 5)
+	if got == nil || !got.Diverged || !near(got.Divergence, 10) {
+		t.Errorf("Reconcile() past tolerance = %+v, want diverged", got)
+	}
+
+	got = Reconcile(report, &models.RunnerInfo{ReputationScore: score(90), ReputationFormulaVersion: FormulaVersion + 1}, testNow, 5)
+	if got == nil || !got.Diverged {
+		t.Errorf("Reconcile() across formula versions = %+v, want diverged", got)
+	}
+}
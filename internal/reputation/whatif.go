@@ -0,0 +1,81 @@
+package reputation
+
+import (
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// Scenario is a way things could turn out: the outcomes it adds to the
+// runner's history
+type Scenario struct {
+	Name        string
+	Description string
+	Outcomes    []Outcome
+}
+
+// Simulate returns the score the runner would have at now if each scenario
+// came about on top of outcomes, and its change from the current score
+func Simulate(outcomes []Outcome, now time.Time, p Policy, scenarios []Scenario) []models.ReputationScenario {
+	current := Score(outcomes, now, p).Score
+	results := make([]models.ReputationScenario, 0, len(scenarios))
+	for _, scenario := range scenarios {
+		simulated := append(append([]Outcome(nil), outcomes...), scenario.Outcomes...)
+		report := Score(simulated, now, p)
+		results = append(results, models.ReputationScenario{
+			Name:        scenario.Name,
+			Description: scenario.Description,
+			Score:       report.Score,
+			Delta:       report.Score - current,
+			Factors:     report.Factors,
+		})
+	}
+	return results
+}
+
+// TaskScenarios are the ways the running task could end, as seen at now:
+// abandoned now, completed now, and, for a task with a timeout, completed
+// only as it runs out or left to time out. Scenarios ending later are
+// scored as if they ended now, so they differ from the others only in how
+// the task ends.
+func TaskScenarios(task models.RunningTask, now time.Time) []Scenario {
+	elapsed := max(now.Sub(task.StartedAt), 0)
+	outcome := func(status models.TaskStatus, code models.FailureCode, duration time.Duration) []Outcome {
+		return []Outcome{{
+			Type:       task.Type,
+			Workload:   task.Workload,
+			Status:     status,
+			Code:       code,
+			FinishedAt: now,
+			Duration:   duration,
+		}}
+	}
+
+	scenarios := []Scenario{
+		{
+			Name:        "abandon_now",
+			Description: "Abandon the task now as stalled",
+			Outcomes:    outcome(models.TaskStatusFailed, models.FailureStalled, elapsed),
+		},
+		{
+			Name:        "complete_now",
+			Description: "The task completes now",
+			Outcomes:    outcome(models.TaskStatusCompleted, "", elapsed),
+		},
+	}
+	if task.Timeout > elapsed {
+		scenarios = append(scenarios,
+			Scenario{
+				Name:        "complete_at_timeout",
+				Description: "The task completes just before its timeout",
+				Outcomes:    outcome(models.TaskStatusCompleted, "", task.Timeout),
+			},
+			Scenario{
+				Name:        "time_out",
+				Description: "Leave the task to run out its timeout",
+				Outcomes:    outcome(models.TaskStatusFailed, models.FailureTimeout, task.Timeout),
+			},
+		)
+	}
+	return scenarios
+}
@@ -0,0 +1,231 @@
+package retention
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ErrNotKept is returned for tasks the outbox holds no entry of
+var ErrNotKept = errors.New("task result not kept")
+
+// Entry is the local copy of a task's result. It is pending until the
+// server confirms the upload, and kept for the task's retention after.
+type Entry struct {
+	TaskID         string             `json:"task_id"`
+	CreatorAddress string             `json:"creator_address,omitempty"`
+	Status         models.TaskStatus  `json:"status"`
+	Result         *models.TaskResult `json:"result,omitempty"`
+	// Retention is the task's retention directive
+	Retention   string     `json:"retention,omitempty"`
+	StoredAt    time.Time  `json:"stored_at"`
+	ConfirmedAt *time.Time `json:"confirmed_at,omitempty"`
+	// DeletionRequested is set when the creator asked for the task's data
+	// to be deleted before the upload was confirmed
+	DeletionRequested bool `json:"deletion_requested,omitempty"`
+}
+
+// Confirmed reports whether the server has confirmed the result upload
+func (e *Entry) Confirmed() bool {
+	return e.ConfirmedAt != nil
+}
+
+// Outbox keeps one entry per task result as a JSON file, encrypted when a
+// codec is set
+type Outbox struct {
+	dir   string
+	codec atrest.Codec
+	clock clock.Clock
+	mu    sync.Mutex
+}
+
+func NewOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	return &Outbox{dir: dir, clock: clock.Real()}, nil
+}
+
+// SetClock replaces the clock used to timestamp entries
+func (o *Outbox) SetClock(c clock.Clock) {
+	o.clock = c
+}
+
+// SetCodec encrypts entries written from now on
+func (o *Outbox) SetCodec(codec atrest.Codec) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.codec = codec
+}
+
+func (o *Outbox) path(taskID string) string {
+	return filepath.Join(o.dir, taskID+".json")
+}
+
+// Put keeps the result of task before it is uploaded
+func (o *Outbox) Put(task *models.Task, status models.TaskStatus, result *models.TaskResult) error {
+	var config struct {
+		Retention string `json:"retention"`
+	}
+	// The config was validated before the task ran
+	_ = json.Unmarshal(task.Config, &config)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.write(&Entry{
+		TaskID:         task.ID.String(),
+		CreatorAddress: task.CreatorAddress,
+		Status:         status,
+		Result:         result,
+		Retention:      config.Retention,
+		StoredAt:       o.clock.Now(),
+	})
+}
+
+// Confirm records that the server has confirmed the upload of taskID's
+// result, which starts its retention
+func (o *Outbox) Confirm(taskID string) error {
+	return o.update(taskID, func(entry *Entry) {
+		if entry.ConfirmedAt == nil {
+			now := o.clock.Now()
+			entry.ConfirmedAt = &now
+		}
+	})
+}
+
+// RequestDeletion marks taskID's entry for removal as soon as its upload is
+// confirmed
+func (o *Outbox) RequestDeletion(taskID string) error {
+	return o.update(taskID, func(entry *Entry) {
+		entry.DeletionRequested = true
+	})
+}
+
+func (o *Outbox) update(taskID string, change func(entry *Entry)) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	entry, err := o.read(o.path(taskID))
+	if err != nil {
+		return err
+	}
+	change(entry)
+	return o.write(entry)
+}
+
+// Get returns the entry of taskID, or ErrNotKept
+func (o *Outbox) Get(taskID string) (*Entry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.read(o.path(taskID))
+}
+
+// Entries returns every entry. Unreadable ones are skipped.
+func (o *Outbox) Entries() ([]*Entry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	files, err := o.files()
+	if err != nil {
+		return nil, err
+	}
+	var entries []*Entry
+	for _, path := range files {
+		entry, err := o.read(path)
+		if err != nil {
+			log := gologger.WithComponent("retention")
+			log.Warn().Err(err).Str("file", filepath.Base(path)).Msg("Ignoring unreadable outbox entry")
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Remove securely deletes the entry of taskID
+func (o *Outbox) Remove(taskID string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if err := SecureRemove(o.path(taskID)); err != nil {
+		return fmt.Errorf("failed to remove outbox entry: %w", err)
+	}
+	return nil
+}
+
+// Reseal rewrites entries that are plaintext or sealed with an older key
+// under the active key. It returns the number rewritten.
+func (o *Outbox) Reseal() (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.codec == nil {
+		return 0, nil
+	}
+	files, err := o.files()
+	if err != nil {
+		return 0, err
+	}
+	resealed := 0
+	for _, path := range files {
+		rewritten, err := atrest.ResealFile(o.codec, path)
+		if err != nil {
+			return resealed, fmt.Errorf("failed to reseal outbox entry %s: %w", filepath.Base(path), err)
+		}
+		if rewritten {
+			resealed++
+		}
+	}
+	return resealed, nil
+}
+
+func (o *Outbox) files() ([]string, error) {
+	dirEntries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox directory: %w", err)
+	}
+	var files []string
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !strings.HasSuffix(dirEntry.Name(), ".json") {
+			continue
+		}
+		files = append(files, filepath.Join(o.dir, dirEntry.Name()))
+	}
+	return files, nil
+}
+
+func (o *Outbox) read(path string) (*Entry, error) {
+	data, err := atrest.ReadFile(o.codec, path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w for task %s", ErrNotKept, strings.TrimSuffix(filepath.Base(path), ".json"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox entry: %w", err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.TaskID == "" {
+		return nil, fmt.Errorf("outbox entry %s is unreadable", filepath.Base(path))
+	}
+	return &entry, nil
+}
+
+func (o *Outbox) write(entry *Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal outbox entry: %w", err)
+	}
+	if err := atrest.WriteFile(o.codec, o.path(entry.TaskID), data, 0o600); err != nil {
+		return fmt.Errorf("failed to save outbox entry: %w", err)
+	}
+	return nil
+}
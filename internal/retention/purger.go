@@ -0,0 +1,271 @@
+// Package retention bounds how long the runner keeps what tasks leave on
+// disk. A task's result is held in the outbox until the server confirms its
+// upload, whatever the task's retention; from then on the result, the
+// task's replay bundle and its scratch remnants are kept only for as long
+// as the task's creator allows, and securely deleted by the purger after.
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/callback"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// resendDelay is how long a pending entry waits before the purger uploads
+// it again, so that it does not race the upload that stored it
+const resendDelay = time.Minute
+
+// DataSource holds data of tasks the purger deletes with their results
+type DataSource interface {
+	PurgeTask(taskID string) error
+}
+
+// ResendFunc uploads a pending result again
+type ResendFunc func(taskID string, status models.TaskStatus, result *models.TaskResult) error
+
+type source struct {
+	DataSource
+	// directed sources keep their data under their own policy unless the
+	// task's creator sets a retention or asks for deletion
+	directed bool
+}
+
+// Purger deletes the data of tasks whose retention has passed and carries
+// out creator-requested deletions
+type Purger struct {
+	outbox *Outbox
+	// keep is how long results of tasks without a retention directive are
+	// kept after upload
+	keep    time.Duration
+	sources []source
+	resend  ResendFunc
+	clock   clock.Clock
+	mu      sync.Mutex
+	acks    []models.TaskDataDeletionAck
+}
+
+// NewPurger returns a purger of outbox's entries that keeps the results of
+// tasks without a retention directive for keep after upload
+func NewPurger(outbox *Outbox, keep time.Duration) *Purger {
+	return &Purger{outbox: outbox, keep: keep, clock: clock.Real()}
+}
+
+// SetClock replaces the clock retention is measured with
+func (p *Purger) SetClock(c clock.Clock) {
+	p.clock = c
+}
+
+// AddSource deletes the task data source holds along with the task's result
+func (p *Purger) AddSource(s DataSource) {
+	p.sources = append(p.sources, source{DataSource: s})
+}
+
+// AddDirectedSource deletes the task data source holds only when the task
+// sets a retention or its creator asks for deletion; otherwise source
+// keeps it under its own policy
+func (p *Purger) AddDirectedSource(s DataSource) {
+	p.sources = append(p.sources, source{DataSource: s, directed: true})
+}
+
+// SetResender uploads pending results again with resend
+func (p *Purger) SetResender(resend ResendFunc) {
+	p.resend = resend
+}
+
+// Run sweeps now and every interval until ctx ends
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	ticker := p.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.Sweep()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// Sweep uploads pending results again and purges the tasks whose retention
+// has passed since their upload was confirmed
+func (p *Purger) Sweep() {
+	log := gologger.WithComponent("retention")
+
+	entries, err := p.outbox.Entries()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read outbox")
+		return
+	}
+	now := p.clock.Now()
+	for _, entry := range entries {
+		if !entry.Confirmed() {
+			p.resendEntry(entry, now)
+			continue
+		}
+		keep, directed := p.retention(entry)
+		if now.Sub(*entry.ConfirmedAt) < keep {
+			continue
+		}
+		if err := p.purge(entry.TaskID, directed); err != nil {
+			log.Warn().Err(err).Str("task_id", entry.TaskID).Msg("Failed to purge task data")
+			continue
+		}
+		if entry.DeletionRequested {
+			p.acknowledge(entry.TaskID, models.TaskDataDeleted, nil)
+		}
+		log.Debug().Str("task_id", entry.TaskID).Msg("Purged task data past its retention")
+	}
+}
+
+// retention returns how long after upload entry's task data is kept, and
+// whether the task's creator decided it
+func (p *Purger) retention(entry *Entry) (time.Duration, bool) {
+	if entry.DeletionRequested {
+		return 0, true
+	}
+	keep, set, err := models.ParseRetention(entry.Retention)
+	if err != nil || !set {
+		return p.keep, false
+	}
+	return keep, true
+}
+
+func (p *Purger) resendEntry(entry *Entry, now time.Time) {
+	if p.resend == nil || now.Sub(entry.StoredAt) < resendDelay {
+		return
+	}
+	log := gologger.WithComponent("retention")
+	if err := p.resend(entry.TaskID, entry.Status, entry.Result); err != nil {
+		log.Debug().Err(err).Str("task_id", entry.TaskID).Msg("Failed to upload pending task result")
+		return
+	}
+	if err := p.outbox.Confirm(entry.TaskID); err != nil {
+		log.Warn().Err(err).Str("task_id", entry.TaskID).Msg("Failed to confirm task result upload")
+		return
+	}
+	log.Info().Str("task_id", entry.TaskID).Msg("Uploaded pending task result")
+}
+
+// purge deletes taskID's data from the sources, including directed ones
+// when directed, and then its outbox entry
+func (p *Purger) purge(taskID string, directed bool) error {
+	if err := p.purgeSources(taskID, directed); err != nil {
+		return err
+	}
+	return p.outbox.Remove(taskID)
+}
+
+func (p *Purger) purgeSources(taskID string, directed bool) error {
+	var errs []error
+	for _, s := range p.sources {
+		if s.directed && !directed {
+			continue
+		}
+		if err := s.PurgeTask(taskID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// DeleteTaskData carries out a deletion the server relays from a task's
+// creator, once the request's signature shows it is the creator's. A result
+// still awaiting upload is kept until the upload is confirmed. The outcome
+// is acknowledged in the next heartbeat.
+func (p *Purger) DeleteTaskData(request *models.TaskDataDeletion) {
+	log := gologger.WithComponent("retention")
+
+	entry, err := p.outbox.Get(request.TaskID)
+	if errors.Is(err, ErrNotKept) {
+		p.acknowledge(request.TaskID, models.TaskDataAbsent, nil)
+		return
+	}
+	if err != nil {
+		p.acknowledge(request.TaskID, models.TaskDataDeletionFailed, err)
+		return
+	}
+	if err := verifyCreator(request, entry.CreatorAddress); err != nil {
+		log.Warn().Err(err).Str("task_id", request.TaskID).Msg("Rejected task data deletion")
+		p.acknowledge(request.TaskID, models.TaskDataDeletionRejected, err)
+		return
+	}
+
+	// Marked first, so that the sweep retries a deletion that fails here
+	// and removes a result awaiting upload once the upload is confirmed
+	if err := p.outbox.RequestDeletion(request.TaskID); err != nil {
+		log.Warn().Err(err).Str("task_id", request.TaskID).Msg("Failed to delete task data")
+		p.acknowledge(request.TaskID, models.TaskDataDeletionFailed, err)
+		return
+	}
+	if !entry.Confirmed() {
+		err := p.purgeSources(request.TaskID, true)
+		if err != nil {
+			log.Warn().Err(err).Str("task_id", request.TaskID).Msg("Failed to delete task data")
+		}
+		p.acknowledge(request.TaskID, models.TaskDataDeletionPending, err)
+		return
+	}
+	if err := p.purge(request.TaskID, true); err != nil {
+		log.Warn().Err(err).Str("task_id", request.TaskID).Msg("Failed to delete task data")
+		p.acknowledge(request.TaskID, models.TaskDataDeletionPending, err)
+		return
+	}
+	log.Info().Str("task_id", request.TaskID).Msg("Deleted task data at its creator's request")
+	p.acknowledge(request.TaskID, models.TaskDataDeleted, nil)
+}
+
+// verifyCreator checks request was signed by the task's creator
+func verifyCreator(request *models.TaskDataDeletion, creator string) error {
+	if creator == "" {
+		return fmt.Errorf("task %s has no creator to verify against", request.TaskID)
+	}
+	signer, err := callback.VerifySignature(request.Message(), request.Signature)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(signer.Hex(), creator) {
+		return fmt.Errorf("deletion of task %s not signed by its creator", request.TaskID)
+	}
+	return nil
+}
+
+func (p *Purger) acknowledge(taskID string, status models.TaskDataDeletionStatus, err error) {
+	ack := models.TaskDataDeletionAck{TaskID: taskID, Status: status, At: p.clock.Now()}
+	if err != nil {
+		ack.Error = err.Error()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.acks = append(p.acks, ack)
+}
+
+// DeletionAcks returns the acknowledgments the next heartbeat carries
+func (p *Purger) DeletionAcks() []models.TaskDataDeletionAck {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.acks) == 0 {
+		return nil
+	}
+	return append([]models.TaskDataDeletionAck(nil), p.acks...)
+}
+
+// DeletionAcksSent drops the first n acknowledgments, which a heartbeat
+// has delivered
+func (p *Purger) DeletionAcksSent(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n > len(p.acks) {
+		n = len(p.acks)
+	}
+	p.acks = append([]models.TaskDataDeletionAck(nil), p.acks[n:]...)
+}
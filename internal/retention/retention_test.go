@@ -0,0 +1,274 @@
+package retention
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/callback"
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+// recordingSource records the tasks it was asked to purge
+type recordingSource struct {
+	purged []string
+}
+
+func (s *recordingSource) PurgeTask(taskID string) error {
+	s.purged = append(s.purged, taskID)
+	return nil
+}
+
+type fixture struct {
+	clock    *clocktest.Fake
+	outbox   *Outbox
+	purger   *Purger
+	scratch  string
+	bundles  *recordingSource
+	resendOK bool
+	resent   int
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := &fixture{clock: clocktest.NewFake(time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC))}
+	outbox, err := NewOutbox(filepath.Join(t.TempDir(), "outbox"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox.SetClock(f.clock)
+	f.outbox = outbox
+	f.scratch = t.TempDir()
+	f.bundles = &recordingSource{}
+
+	f.purger = NewPurger(outbox, 24*time.Hour)
+	f.purger.SetClock(f.clock)
+	f.purger.AddSource(Scratch{Dir: f.scratch})
+	f.purger.AddDirectedSource(f.bundles)
+	f.purger.SetResender(func(taskID string, status models.TaskStatus, result *models.TaskResult) error {
+		f.resent++
+		if !f.resendOK {
+			return errors.New("server unavailable")
+		}
+		return nil
+	})
+	return f
+}
+
+// run keeps the result of a task with retention directive, and a scratch
+// directory it left behind
+func (f *fixture) run(t *testing.T, directive, creator string) *models.Task {
+	t.Helper()
+	config, err := json.Marshal(models.TaskConfig{ImageName: "alpine", Retention: directive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker, Config: config, CreatorAddress: creator}
+	if err := f.outbox.Put(task, models.TaskStatusCompleted, &models.TaskResult{TaskID: task.ID, Output: "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := os.MkdirTemp(f.scratch, ScratchPattern("output", task.ID.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "result.bin"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return task
+}
+
+func (f *fixture) kept(t *testing.T, task *models.Task) bool {
+	t.Helper()
+	_, err := f.outbox.Get(task.ID.String())
+	if err != nil && !errors.Is(err, ErrNotKept) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func (f *fixture) scratchLeft(t *testing.T, task *models.Task) bool {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(f.scratch, "parity-*-"+task.ID.String()+"-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(matches) > 0
+}
+
+func TestPurgeFollowsRetentionAfterUpload(t *testing.T) {
+	f := newFixture(t)
+	directed := f.run(t, "2h", "")
+	undirected := f.run(t, "", "")
+	for _, task := range []*models.Task{directed, undirected} {
+		if err := f.outbox.Confirm(task.ID.String()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.clock.Advance(time.Hour)
+	f.purger.Sweep()
+	if !f.kept(t, directed) || !f.scratchLeft(t, directed) {
+		t.Fatal("task data purged before its retention passed")
+	}
+
+	f.clock.Advance(time.Hour)
+	f.purger.Sweep()
+	if f.kept(t, directed) || f.scratchLeft(t, directed) {
+		t.Fatal("task data kept past its 2h retention")
+	}
+	if len(f.bundles.purged) != 1 || f.bundles.purged[0] != directed.ID.String() {
+		t.Fatalf("replay bundles purged = %v, want the directed task's", f.bundles.purged)
+	}
+	if !f.kept(t, undirected) {
+		t.Fatal("task without a retention purged before the runner's default")
+	}
+
+	f.clock.Advance(22 * time.Hour)
+	f.purger.Sweep()
+	if f.kept(t, undirected) || f.scratchLeft(t, undirected) {
+		t.Fatal("task without a retention kept past the runner's default")
+	}
+	// Its replay bundle follows the audit retention instead
+	if len(f.bundles.purged) != 1 {
+		t.Errorf("replay bundles purged = %v, want only the directed task's", f.bundles.purged)
+	}
+}
+
+func TestPendingResultSurvivesRetention(t *testing.T) {
+	f := newFixture(t)
+	task := f.run(t, models.RetentionNone, "")
+
+	f.clock.Advance(30 * 24 * time.Hour)
+	f.purger.Sweep()
+	if !f.kept(t, task) {
+		t.Fatal("result purged before its upload was confirmed")
+	}
+	if f.resent != 1 {
+		t.Fatalf("resent %d times, want 1", f.resent)
+	}
+
+	f.resendOK = true
+	f.purger.Sweep()
+	entry, err := f.outbox.Get(task.ID.String())
+	if err != nil || !entry.Confirmed() || entry.Result.Output != "secret" {
+		t.Fatalf("entry after upload = %+v, %v, want it confirmed with its result", entry, err)
+	}
+
+	// A retention of none purges at the first sweep after the upload
+	f.purger.Sweep()
+	if f.kept(t, task) || f.scratchLeft(t, task) {
+		t.Fatal("task data kept after upload despite a retention of none")
+	}
+}
+
+func TestSecureRemoveOverwritesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "result.json")
+	original := bytes.Repeat([]byte("secret"), 50000)
+	if err := os.WriteFile(path, original, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// The link shares the file's blocks, so it shows what was left in them
+	link := filepath.Join(dir, "link")
+	if err := os.Link(path, link); err != nil {
+		t.Skipf("hard links unsupported: %v", err)
+	}
+
+	if err := SecureRemove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("file still exists: %v", err)
+	}
+	left, err := os.ReadFile(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != len(original) || bytes.Contains(left, []byte("secretsecret")) {
+		t.Error("file contents were not overwritten before removal")
+	}
+
+	if err := SecureRemove(path); err != nil {
+		t.Errorf("SecureRemove() of a missing file error = %v", err)
+	}
+}
+
+func TestDeleteTaskDataRequiresCreatorSignature(t *testing.T) {
+	f := newFixture(t)
+	creatorKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	creator := crypto.PubkeyToAddress(creatorKey.PublicKey).Hex()
+	task := f.run(t, "7d", creator)
+
+	sign := func(key *ecdsa.PrivateKey) *models.TaskDataDeletion {
+		request := &models.TaskDataDeletion{TaskID: task.ID.String()}
+		s, err := signer.FromECDSA(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		signature, err := callback.Sign(request.Message(), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		request.Signature = signature
+		return request
+	}
+
+	f.purger.DeleteTaskData(sign(otherKey))
+	if !f.kept(t, task) || !f.scratchLeft(t, task) {
+		t.Fatal("task data deleted on a request not signed by its creator")
+	}
+
+	// The result awaiting upload is kept until the upload is confirmed
+	f.purger.DeleteTaskData(sign(creatorKey))
+	if !f.kept(t, task) || f.scratchLeft(t, task) {
+		t.Fatal("want the pending result kept and everything else deleted")
+	}
+	if err := f.outbox.Confirm(task.ID.String()); err != nil {
+		t.Fatal(err)
+	}
+	f.purger.Sweep()
+	if f.kept(t, task) {
+		t.Fatal("result kept after upload despite the deletion request")
+	}
+
+	f.purger.DeleteTaskData(&models.TaskDataDeletion{TaskID: uuid.NewString()})
+
+	acks := f.purger.DeletionAcks()
+	want := []models.TaskDataDeletionStatus{
+		models.TaskDataDeletionRejected,
+		models.TaskDataDeletionPending,
+		models.TaskDataDeleted,
+		models.TaskDataAbsent,
+	}
+	if len(acks) != len(want) {
+		t.Fatalf("acks = %+v, want %v", acks, want)
+	}
+	for i, ack := range acks {
+		if ack.Status != want[i] {
+			t.Errorf("ack %d status = %s, want %s", i, ack.Status, want[i])
+		}
+	}
+
+	f.purger.DeletionAcksSent(3)
+	if acks := f.purger.DeletionAcks(); len(acks) != 1 || acks[0].Status != models.TaskDataAbsent {
+		t.Errorf("acks after a heartbeat delivered 3 = %+v, want the last one", acks)
+	}
+}
@@ -0,0 +1,117 @@
+package retention
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// overwriteChunk is how much of a file is overwritten per write
+const overwriteChunk = 64 << 10
+
+// SecureRemove overwrites the file at path with random bytes, flushes it to
+// disk and removes it. Copy-on-write filesystems and SSD wear levelling can
+// keep earlier blocks, so this removes the data from the file, not
+// necessarily from the device. A missing file is not an error.
+func SecureRemove(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if info.Mode().IsRegular() && info.Size() > 0 {
+		if err := overwrite(path, info.Size()); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	return nil
+}
+
+func overwrite(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s for overwriting: %w", path, err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, overwriteChunk)
+	for written := int64(0); written < size; {
+		n := int64(len(buf))
+		if size-written < n {
+			n = size - written
+		}
+		if _, err := io.ReadFull(rand.Reader, buf[:n]); err != nil {
+			return fmt.Errorf("failed to generate overwrite data: %w", err)
+		}
+		if _, err := f.Write(buf[:n]); err != nil {
+			return fmt.Errorf("failed to overwrite %s: %w", path, err)
+		}
+		written += n
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to flush %s: %w", path, err)
+	}
+	return nil
+}
+
+// SecureRemoveAll securely removes every file under dir, then dir itself
+func SecureRemoveAll(dir string) error {
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		return SecureRemove(path)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove %s: %w", dir, err)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", dir, err)
+	}
+	return nil
+}
+
+// ScratchPattern is the os.MkdirTemp pattern of a task's scratch directory
+// of kind, such as output or work, which names the task so that remnants a
+// crash leaves behind can be purged with it
+func ScratchPattern(kind, taskID string) string {
+	return "parity-" + kind + "-" + taskID + "-*"
+}
+
+// Scratch finds the scratch directories of tasks under Dir, the system's
+// temporary directory when empty
+type Scratch struct {
+	Dir string
+}
+
+// PurgeTask securely removes the scratch directories of taskID
+func (s Scratch) PurgeTask(taskID string) error {
+	dir := s.Dir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "parity-*-"+taskID+"-*"))
+	if err != nil {
+		return fmt.Errorf("failed to find scratch of task %s: %w", taskID, err)
+	}
+	for _, match := range matches {
+		if err := SecureRemoveAll(match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package revocation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// AuditLog appends every action taken on a revocation to a JSON Lines file,
+// synced before Record returns
+type AuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create revocation audit log directory: %w", err)
+	}
+	return &AuditLog{path: path}, nil
+}
+
+// Record appends entry to the log
+func (a *AuditLog) Record(entry models.RevocationAuditEntry) error {
+	if a == nil {
+		return nil
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal revocation audit entry: %w", err)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open revocation audit log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write revocation audit log: %w", err)
+	}
+	return f.Sync()
+}
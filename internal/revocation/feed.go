@@ -0,0 +1,84 @@
+package revocation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+)
+
+// maxFeedSize bounds the feeds read
+const maxFeedSize = 4 << 20
+
+// Feed fetches the revocation feed from the first of its URLs that serves
+// it. Mirrors, such as a static copy on a CDN, keep revocations reaching
+// runners while the server's API is degraded; as the feed is signed, any
+// of them may serve it.
+type Feed struct {
+	urls   []string
+	client *http.Client
+}
+
+// NewFeed fetches from urls in order, bounding each request by timeout
+func NewFeed(urls []string, timeout time.Duration) (*Feed, error) {
+	f := &Feed{client: dualstack.Client(timeout)}
+	for _, url := range urls {
+		if url = strings.TrimSpace(url); url != "" {
+			f.urls = append(f.urls, url)
+		}
+	}
+	if len(f.urls) == 0 {
+		return nil, errors.New("no revocation feed URLs configured")
+	}
+	return f, nil
+}
+
+// SetHTTPClient replaces the client feeds are fetched with
+func (f *Feed) SetHTTPClient(client *http.Client) {
+	f.client = client
+}
+
+// Fetch returns the feed served by the first URL that answers
+func (f *Feed) Fetch(ctx context.Context) (*models.RevocationFeed, error) {
+	var errs []error
+	for _, url := range f.urls {
+		feed, err := f.fetch(ctx, url)
+		if err == nil {
+			return feed, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (f *Feed) fetch(ctx context.Context, url string) (*models.RevocationFeed, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create revocation feed request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch revocation feed from %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("revocation feed %s answered status code %d", url, resp.StatusCode)
+	}
+	var feed models.RevocationFeed
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxFeedSize)).Decode(&feed); err != nil {
+		return nil, fmt.Errorf("failed to decode revocation feed from %s: %w", url, err)
+	}
+	return &feed, nil
+}
@@ -0,0 +1,180 @@
+// Package revocation honors the network's emergency revocations: a signed
+// feed, polled apart from the server's task API, that lists tasks to kill
+// fleet-wide by ID, config hash or creator. A runner verifies the feed,
+// kills the running tasks a revocation matches and declines to claim them
+// until it expires. Revocations are persisted, so a restart does not lift
+// them.
+package revocation
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var (
+	// ErrRevoked is the cause a task killed by a revocation is stopped with
+	ErrRevoked = errors.New("task revoked by the network")
+	// ErrBadSignature is returned for feeds the network key did not sign
+	ErrBadSignature = errors.New("revocation feed signature does not verify")
+	// ErrStale is returned for feeds older than one already applied
+	ErrStale = errors.New("revocation feed is older than one already applied")
+)
+
+// ConfigHash returns the hex SHA-256 of task's config as delivered, which
+// revocations match tasks by
+func ConfigHash(task *models.Task) string {
+	sum := sha256.Sum256(task.Config)
+	return hex.EncodeToString(sum[:])
+}
+
+// Matches reports whether revocation matches task. A revocation that sets
+// nothing to match by matches no task.
+func Matches(revocation *models.Revocation, task *models.Task) bool {
+	if revocation.TaskID == "" && revocation.ConfigHash == "" && revocation.CreatorAddress == "" {
+		return false
+	}
+	if revocation.TaskID != "" && revocation.TaskID != task.ID.String() {
+		return false
+	}
+	if revocation.ConfigHash != "" && !strings.EqualFold(revocation.ConfigHash, ConfigHash(task)) {
+		return false
+	}
+	if revocation.CreatorAddress != "" && !strings.EqualFold(revocation.CreatorAddress, task.CreatorAddress) {
+		return false
+	}
+	return true
+}
+
+type state struct {
+	Sequence    int64               `json:"sequence"`
+	Revocations []models.Revocation `json:"revocations"`
+}
+
+// List holds the revocations in force, verified with the network's key
+type List struct {
+	path      string
+	publicKey ed25519.PublicKey
+	clock     clock.Clock
+
+	mu    sync.Mutex
+	state state
+}
+
+// Open returns the list persisted at path, verifying feeds with publicKey.
+// It starts empty when path does not exist.
+func Open(path string, publicKey ed25519.PublicKey) (*List, error) {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("no revocation public key configured")
+	}
+	l := &List{path: path, publicKey: publicKey, clock: clock.Real()}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read revocations: %w", err)
+	}
+	if err := json.Unmarshal(data, &l.state); err != nil {
+		return nil, fmt.Errorf("invalid revocations file %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// SetClock replaces the clock revocations expire by
+func (l *List) SetClock(c clock.Clock) {
+	l.clock = c
+}
+
+// Apply verifies feed and puts the revocations it lists into force,
+// returning those that were not already. Revocations in force stay so
+// until they expire, even when a later feed omits them.
+func (l *List) Apply(feed *models.RevocationFeed) ([]models.Revocation, error) {
+	signature, err := base64.StdEncoding.DecodeString(feed.Signature)
+	if err != nil || !ed25519.Verify(l.publicKey, feed.Payload, signature) {
+		return nil, ErrBadSignature
+	}
+	var payload models.RevocationFeedPayload
+	if err := json.Unmarshal(feed.Payload, &payload); err != nil {
+		return nil, fmt.Errorf("failed to decode revocation feed: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if payload.Sequence < l.state.Sequence {
+		return nil, ErrStale
+	}
+	now := l.clock.Now()
+	l.prune()
+	var added []models.Revocation
+	for _, revocation := range payload.Revocations {
+		if revocation.ID == "" || !revocation.ExpiresAt.After(now) {
+			continue
+		}
+		known := slices.ContainsFunc(l.state.Revocations, func(r models.Revocation) bool { return r.ID == revocation.ID })
+		if known {
+			continue
+		}
+		l.state.Revocations = append(l.state.Revocations, revocation)
+		added = append(added, revocation)
+	}
+	if payload.Sequence == l.state.Sequence && len(added) == 0 {
+		return nil, nil
+	}
+	l.state.Sequence = payload.Sequence
+	return added, l.save()
+}
+
+// Match returns the revocation in force that matches task, or nil
+func (l *List) Match(task *models.Task) *models.Revocation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune()
+	for i := range l.state.Revocations {
+		if Matches(&l.state.Revocations[i], task) {
+			revocation := l.state.Revocations[i]
+			return &revocation
+		}
+	}
+	return nil
+}
+
+// Active returns the revocations in force
+func (l *List) Active() []models.Revocation {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune()
+	return slices.Clone(l.state.Revocations)
+}
+
+// prune drops the revocations that expired
+func (l *List) prune() {
+	now := l.clock.Now()
+	l.state.Revocations = slices.DeleteFunc(l.state.Revocations, func(r models.Revocation) bool {
+		return !r.ExpiresAt.After(now)
+	})
+}
+
+func (l *List) save() error {
+	data, err := json.Marshal(l.state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal revocations: %w", err)
+	}
+	if err := atrest.WriteFileAtomic(l.path, data, 0o600); err != nil {
+		return fmt.Errorf("failed to persist revocations: %w", err)
+	}
+	return nil
+}
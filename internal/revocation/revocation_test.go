@@ -0,0 +1,170 @@
+package revocation
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var start = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func signFeed(t *testing.T, key ed25519.PrivateKey, sequence int64, revocations ...models.Revocation) *models.RevocationFeed {
+	t.Helper()
+	payload, err := json.Marshal(models.RevocationFeedPayload{Sequence: sequence, IssuedAt: start, Revocations: revocations})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.RevocationFeed{Payload: payload, Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))}
+}
+
+func newList(t *testing.T) (*List, ed25519.PrivateKey, *clocktest.Fake, string) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "revocations.json")
+	l, err := Open(path, public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clocktest.NewFake(start)
+	l.SetClock(clk)
+	return l, private, clk, path
+}
+
+func newTask(creator, config string) *models.Task {
+	return &models.Task{ID: uuid.New(), CreatorAddress: creator, Config: json.RawMessage(config)}
+}
+
+func TestMatch(t *testing.T) {
+	task := newTask("0xAbC", `{"image_name":"alpine"}`)
+	other := newTask("0xdef", `{"image_name":"busybox"}`)
+	for _, tt := range []struct {
+		name       string
+		revocation models.Revocation
+		want       bool
+	}{
+		{"by ID", models.Revocation{TaskID: task.ID.String()}, true},
+		{"by config hash", models.Revocation{ConfigHash: ConfigHash(task)}, true},
+		{"by config hash in upper case", models.Revocation{ConfigHash: strings.ToUpper(ConfigHash(task))}, true},
+		{"by creator", models.Revocation{CreatorAddress: "0xabc"}, true},
+		{"by creator and hash", models.Revocation{CreatorAddress: "0xabc", ConfigHash: ConfigHash(other)}, false},
+		{"by nothing", models.Revocation{}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			l, key, _, _ := newList(t)
+			tt.revocation.ID = "rev-1"
+			tt.revocation.ExpiresAt = start.Add(time.Hour)
+			if _, err := l.Apply(signFeed(t, key, 1, tt.revocation)); err != nil {
+				t.Fatal(err)
+			}
+			if got := l.Match(task) != nil; got != tt.want {
+				t.Errorf("Match() = %v, want %v", got, tt.want)
+			}
+			if l.Match(other) != nil {
+				t.Error("revocation matched an unrelated task")
+			}
+		})
+	}
+}
+
+func TestApplyRejectsBadSignature(t *testing.T) {
+	l, _, _, _ := newList(t)
+	_, forger, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := signFeed(t, forger, 1, models.Revocation{ID: "rev-1", CreatorAddress: "0xabc", ExpiresAt: start.Add(time.Hour)})
+	if _, err := l.Apply(forged); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Apply() of a forged feed error = %v, want ErrBadSignature", err)
+	}
+
+	l, key, _, _ := newList(t)
+	tampered := signFeed(t, key, 1, models.Revocation{ID: "rev-1", CreatorAddress: "0xabc", ExpiresAt: start.Add(time.Hour)})
+	tampered.Payload = json.RawMessage(`{"sequence":1,"revocations":[{"id":"rev-1","creator_address":"0xdef"}]}`)
+	if _, err := l.Apply(tampered); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Apply() of a tampered feed error = %v, want ErrBadSignature", err)
+	}
+	if len(l.Active()) != 0 {
+		t.Error("unverified revocations were put into force")
+	}
+}
+
+func TestRevocationsExpireAndPersist(t *testing.T) {
+	l, key, clk, path := newList(t)
+	task := newTask("0xabc", `{}`)
+	added, err := l.Apply(signFeed(t, key, 2,
+		models.Revocation{ID: "rev-1", CreatorAddress: "0xabc", ExpiresAt: start.Add(time.Hour)},
+		models.Revocation{ID: "rev-0", CreatorAddress: "0xabc", ExpiresAt: start.Add(-time.Minute)},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added) != 1 || added[0].ID != "rev-1" {
+		t.Fatalf("Apply() = %+v, want only the unexpired revocation", added)
+	}
+	if _, err := l.Apply(signFeed(t, key, 1)); !errors.Is(err, ErrStale) {
+		t.Fatalf("Apply() of an older feed error = %v, want ErrStale", err)
+	}
+	if added, err := l.Apply(signFeed(t, key, 3)); err != nil || len(added) != 0 || l.Match(task) == nil {
+		t.Fatalf("a later feed omitting the revocation lifted it: %+v, %v", added, err)
+	}
+
+	reopened, err := Open(path, key.Public().(ed25519.PublicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.SetClock(clk)
+	if reopened.Match(task) == nil {
+		t.Fatal("revocation lifted by a restart")
+	}
+	clk.Advance(time.Hour)
+	if reopened.Match(task) != nil {
+		t.Fatal("expired revocation still in force")
+	}
+}
+
+func TestFeedFallsBackToMirror(t *testing.T) {
+	_, key, _, _ := newList(t)
+	want := signFeed(t, key, 1, models.Revocation{ID: "rev-1", TaskID: uuid.NewString(), ExpiresAt: start.Add(time.Hour)})
+	degraded := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer degraded.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(want)
+	}))
+	defer mirror.Close()
+
+	feed, err := NewFeed([]string{degraded.URL, mirror.URL}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := feed.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got.Payload) != string(want.Payload) || got.Signature != want.Signature {
+		t.Fatalf("Fetch() = %+v, want the mirror's feed", got)
+	}
+
+	mirror.Close()
+	if _, err := feed.Fetch(context.Background()); err == nil {
+		t.Fatal("Fetch() with no URL answering succeeded")
+	}
+}
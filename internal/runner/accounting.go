@@ -0,0 +1,118 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/accounting"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/core/ports"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+)
+
+const accountingDirName = "accounting"
+
+// SetAccounting ledgers every task the handler reports through journal
+func (h *DefaultTaskHandler) SetAccounting(journal *accounting.Journal) {
+	h.journal = journal
+}
+
+// account moves task through the accounting pipeline with transition.
+// Failures are logged: accounting never holds a task up, and the reconciler
+// finishes whatever a failed transition left.
+func (h *DefaultTaskHandler) account(task *models.Task, transition func(taskID string) error) {
+	if h.journal == nil {
+		return
+	}
+	if err := transition(task.ID.String()); err != nil {
+		log := gologger.WithComponent("task_handler")
+		log.Warn().Err(err).Str("id", task.ID.String()).Msg("Failed to account for task")
+	}
+}
+
+// accountBegin records task as claimed
+func (h *DefaultTaskHandler) accountBegin(task *models.Task) {
+	h.account(task, func(taskID string) error {
+		return h.journal.Begin(taskID, task.Type)
+	})
+}
+
+// accountExecuting records that task started executing, beginning its
+// accounting when it was not claimed by this process
+func (h *DefaultTaskHandler) accountExecuting(task *models.Task) {
+	h.accountBegin(task)
+	h.account(task, h.journal.Executing)
+}
+
+// accountResult records how task's execution ended, ahead of its report
+func (h *DefaultTaskHandler) accountResult(task *models.Task, run clock.Stopwatch, status models.TaskStatus, result *models.TaskResult) {
+	h.account(task, func(taskID string) error {
+		return h.journal.ResultPending(taskID, accounting.Outcome{
+			Status:   status,
+			Reward:   taskReward(task, result),
+			Duration: run.Elapsed(),
+			Donated:  h.donated(task),
+		})
+	})
+}
+
+// taskReward is what task earns: the reward its result settled on, or else
+// the reward it was offered with
+func taskReward(task *models.Task, result *models.TaskResult) float64 {
+	if result != nil && result.Reward > 0 {
+		return result.Reward
+	}
+	if task != nil {
+		return task.Reward
+	}
+	return 0
+}
+
+// reportPending returns the reconciler's report of a pending result: the
+// copy kept in outbox is sent through taskClient unless the server already
+// confirmed it
+func reportPending(outbox *retention.Outbox, taskClient ports.TaskClient) accounting.ReportFunc {
+	return func(record accounting.Record) error {
+		if outbox == nil {
+			return accounting.ErrResultLost
+		}
+		entry, err := outbox.Get(record.TaskID)
+		if errors.Is(err, retention.ErrNotKept) {
+			return fmt.Errorf("%w: %w", accounting.ErrResultLost, err)
+		}
+		if err != nil {
+			return err
+		}
+		if entry.Confirmed() {
+			return nil
+		}
+		if err := taskClient.UpdateTaskStatus(record.TaskID, entry.Status, entry.Result); err != nil {
+			return fmt.Errorf("failed to report task result: %w", err)
+		}
+		return outbox.Confirm(record.TaskID)
+	}
+}
+
+// reconcileAccounting finishes the accounting of the tasks the previous
+// runner process left part way, before any new task is claimed
+func (s *Service) reconcileAccounting() {
+	if s.journal == nil {
+		return
+	}
+	log := gologger.WithComponent("runner")
+	summary, err := s.journal.Reconcile(reportPending(s.outbox, s.taskClient))
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to reconcile task accounting")
+		return
+	}
+	if summary != (accounting.Reconciliation{}) {
+		log.Info().
+			Int("completed", summary.Completed).
+			Int("rolled_back", summary.RolledBack).
+			Int("pending", summary.Pending).
+			Msg("Reconciled task accounting")
+	}
+}
@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/accounting"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+)
+
+func TestHandlerLedgersReportedTask(t *testing.T) {
+	journal, err := accounting.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &fakeFLClient{}
+	h := NewTaskHandler(&countingExecutor{}, client)
+	h.SetAccounting(journal)
+
+	task := newDockerTask(t)
+	task.Reward = 3
+	if err := h.HandleTask(task); err != nil {
+		t.Fatalf("HandleTask() error = %v", err)
+	}
+
+	entries, err := journal.Ledger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].TaskID != task.ID.String() || entries[0].Status != models.TaskStatusCompleted || entries[0].Reward != 3 {
+		t.Fatalf("ledger = %+v, want the completed task once", entries)
+	}
+}
+
+func TestReconcileReportsPendingResults(t *testing.T) {
+	journal, err := accounting.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := retention.NewOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// kept stopped with its result pending in the outbox, lost with none
+	kept, lost := newDockerTask(t), newDockerTask(t)
+	for _, task := range []*models.Task{kept, lost} {
+		taskID := task.ID.String()
+		if err := journal.Begin(taskID, task.Type); err != nil {
+			t.Fatal(err)
+		}
+		if err := journal.Executing(taskID); err != nil {
+			t.Fatal(err)
+		}
+		if err := journal.ResultPending(taskID, accounting.Outcome{Status: models.TaskStatusCompleted}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := outbox.Put(kept, models.TaskStatusCompleted, &models.TaskResult{TaskID: kept.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeFLClient{}
+	summary, err := journal.Reconcile(reportPending(outbox, client))
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if summary != (accounting.Reconciliation{Completed: 1, RolledBack: 1}) {
+		t.Fatalf("Reconcile() = %+v, want one completed and one rolled back", summary)
+	}
+	if len(client.statuses) != 1 {
+		t.Fatalf("server got %d reports, want the kept result once", len(client.statuses))
+	}
+	entry, err := outbox.Get(kept.ID.String())
+	if err != nil || !entry.Confirmed() {
+		t.Fatalf("outbox entry = %+v, %v, want it confirmed", entry, err)
+	}
+
+	entries, err := journal.Ledger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].TaskID != kept.ID.String() {
+		t.Fatalf("ledger = %+v, want only the kept task", entries)
+	}
+}
+
+func TestReplayedRetainedResultsAreNotOffloaded(t *testing.T) {
+	journal, err := accounting.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := retention.NewOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, client := newContractServer(t)
+	uploader := &stubUploader{}
+	client.offload = &outputOffload{uploader: uploader, threshold: 64, preview: 8}
+	client.SetOutbox(outbox)
+
+	// The runner stopped with the result of a task setting its own
+	// retention pending
+	task := newDockerTask(t)
+	task.Config = []byte(`{"retention":"7d"}`)
+	taskID := task.ID.String()
+	if err := journal.Begin(taskID, task.Type); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.Executing(taskID); err != nil {
+		t.Fatal(err)
+	}
+	if err := journal.ResultPending(taskID, accounting.Outcome{Status: models.TaskStatusCompleted}); err != nil {
+		t.Fatal(err)
+	}
+	output := strings.Repeat("x", 100)
+	if err := outbox.Put(task, models.TaskStatusCompleted, &models.TaskResult{TaskID: task.ID, Output: output}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := journal.Reconcile(reportPending(outbox, client)); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	var sent models.TaskResult
+	if err := json.Unmarshal(server.bodies["saveTaskResult"], &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.Output != output || sent.OutputCID != "" || uploader.content != "" {
+		t.Fatalf("sent %d bytes with CID %q, want the retained output sent whole", len(sent.Output), sent.OutputCID)
+	}
+}
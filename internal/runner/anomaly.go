@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/anomaly"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+	"github.com/theblitlabs/parity-runner/internal/status"
+)
+
+// errResultRejected fails a result the operator rejected as anomalous
+var errResultRejected = errors.New("result rejected by the operator as anomalous")
+
+// SetAnomalyDetector reviews the results of tasks through detector before
+// they are submitted
+func (h *DefaultTaskHandler) SetAnomalyDetector(detector *anomaly.Detector) {
+	h.anomalies = detector
+}
+
+// newAnomalyDetector returns the detector cfg sets up, alerting through bus
+// and, with an alert URL, a webhook signed by s; nil when it is disabled
+func newAnomalyDetector(cfg config.AnomalyConfig, bus *events.Bus, s signer.Signer) (*anomaly.Detector, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	detector, err := anomaly.New(anomaly.Policy{
+		Rule:          anomaly.Rule(cfg.Rule),
+		Threshold:     cfg.Threshold,
+		Quantile:      cfg.Quantile,
+		Window:        cfg.Window,
+		MinSamples:    cfg.MinSamples,
+		Mode:          anomaly.Mode(cfg.Mode),
+		CriticalScore: cfg.CriticalScore,
+		HoldTimeout:   cfg.HoldTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	var webhook *anomaly.Webhook
+	if cfg.AlertURL != "" {
+		if webhook, err = anomaly.NewWebhook(cfg.AlertURL, s); err != nil {
+			return nil, err
+		}
+	}
+	detector.OnAlert(func(alert anomaly.Alert) {
+		log := gologger.WithComponent("anomaly")
+		log.Warn().
+			Str("task_id", alert.TaskID).
+			Str("type", string(alert.Type)).
+			Str("severity", alert.Anomaly.Severity).
+			Str("reason", alert.Anomaly.Findings[0].Reason).
+			Bool("held", alert.Held).
+			Msg("Task result is anomalous")
+		bus.Publish(events.ResultAnomalous{
+			TaskID:   alert.TaskID,
+			Type:     alert.Type,
+			Instance: alert.Instance,
+			Held:     alert.Held,
+			Anomaly:  alert.Anomaly,
+		})
+		if webhook != nil {
+			webhook.Alert(alert)
+		}
+	})
+	return detector, nil
+}
+
+// reviewResult checks result against the earlier results of task's type,
+// noting an anomaly on it, and returns the status to report it with: a
+// result the operator rejected is reported failed
+func (h *DefaultTaskHandler) reviewResult(ctx context.Context, task *models.Task, status models.TaskStatus, result *models.TaskResult) models.TaskStatus {
+	if h.anomalies == nil {
+		return status
+	}
+	duration := result.Duration
+	if duration == 0 {
+		duration = time.Duration(result.InferenceTime) * time.Millisecond
+	}
+	result.Anomaly = h.anomalies.Review(ctx, task.ID.String(), h.instanceName(), anomaly.Sample{
+		Type:           task.Type,
+		ExitCode:       result.ExitCode,
+		OutputBytes:    int64(len(result.Output)),
+		Duration:       duration,
+		ResponseTokens: result.ResponseTokens,
+	})
+	if result.Anomaly == nil || result.Anomaly.Decision != models.AnomalyRejected {
+		return status
+	}
+	result.FailureCode = models.FailureAnomalous
+	result.Error = errResultRejected.Error()
+	return models.TaskStatusFailed
+}
+
+// ReviewResult approves or rejects the result of taskID held as anomalous
+func (s *Service) ReviewResult(taskID string, approve bool) error {
+	handler, ok := s.taskHandler.(*DefaultTaskHandler)
+	if !ok || handler.anomalies == nil {
+		return fmt.Errorf("%w: anomaly detection is disabled", status.ErrNotFound)
+	}
+	if err := handler.anomalies.Decide(taskID, approve); err != nil {
+		if errors.Is(err, anomaly.ErrNotHeld) {
+			return fmt.Errorf("%w: %v", status.ErrNotFound, err)
+		}
+		return err
+	}
+	log := gologger.WithComponent("runner")
+	log.Info().Str("task_id", taskID).Bool("approved", approve).Msg("Operator reviewed anomalous result")
+	return nil
+}
@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/status"
+)
+
+func TestRejectedAnomalousResultFails(t *testing.T) {
+	detector, err := newAnomalyDetector(config.AnomalyConfig{
+		Enabled:       true,
+		Rule:          "zscore",
+		Threshold:     4,
+		Window:        50,
+		MinSamples:    20,
+		Mode:          "hold",
+		CriticalScore: 2,
+		HoldTimeout:   time.Minute,
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewTaskHandler(&shardExecutor{}, &fakeFLClient{})
+	h.SetAnomalyDetector(detector)
+	svc := &Service{taskHandler: h}
+
+	task := &models.Task{Type: models.TaskTypeLLM}
+	task.ID = newDockerTask(t).ID
+	done := make(chan models.TaskStatus, 1)
+	result := &models.TaskResult{TaskID: task.ID, Output: "", InferenceTime: 200}
+	go func() {
+		done <- h.reviewResult(context.Background(), task, models.TaskStatusCompleted, result)
+	}()
+	for len(detector.Status().Held) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := svc.ReviewResult(task.ID.String(), false); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-done; got != models.TaskStatusFailed || result.FailureCode != models.FailureAnomalous || result.Anomaly.Decision != models.AnomalyRejected {
+		t.Fatalf("reviewResult() = %s with %+v, want the rejected result failed", got, result)
+	}
+	if err := svc.ReviewResult(task.ID.String(), true); !errors.Is(err, status.ErrNotFound) {
+		t.Errorf("ReviewResult() of a result no longer held = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,121 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/attestation"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/provenance"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// AttestationClient is implemented by task clients that can issue nonces for
+// attestation evidence
+type AttestationClient interface {
+	GetAttestationChallenge(taskID string) (*models.AttestationChallenge, error)
+}
+
+// SetAttester enables claiming tasks that require attestation and attaches
+// evidence from attester to their results
+func (h *DefaultTaskHandler) SetAttester(attester *attestation.Attester) {
+	h.attester = attester
+}
+
+// SetBuildFingerprint records fingerprint, that of the runner binary, in
+// every result, where attestation evidence and signed callbacks cover it
+func (h *DefaultTaskHandler) SetBuildFingerprint(fingerprint string) {
+	h.buildFingerprint = fingerprint
+}
+
+// requiresAttestation reports whether the creator asked for attested results
+func requiresAttestation(task *models.Task) bool {
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return false
+	}
+	return config.RequireAttestation
+}
+
+// canAttest reports whether this runner can produce per-result evidence
+func (h *DefaultTaskHandler) canAttest() bool {
+	_, ok := h.taskClient.(AttestationClient)
+	return ok && h.attester.Supported()
+}
+
+// attestResult binds evidence of the runner environment to result
+func (h *DefaultTaskHandler) attestResult(ctx context.Context, task *models.Task, result *models.TaskResult) error {
+	client, ok := h.taskClient.(AttestationClient)
+	if !ok || !h.attester.Supported() {
+		return errors.New("runner cannot attest results")
+	}
+
+	challenge, err := client.GetAttestationChallenge(task.ID.String())
+	if err != nil {
+		return fmt.Errorf("failed to get attestation challenge: %w", err)
+	}
+
+	evidence, err := h.attester.AttestResult(ctx, challenge.Nonce, task.ID.String(), attestation.ResultHash(result))
+	if err != nil {
+		return err
+	}
+	result.Attestation = evidence
+	return nil
+}
+
+// newAttester returns the attester cfg selects, or nil when attestation is
+// disabled or the host cannot provide it
+func newAttester(cfg *config.Config, clk clock.Clock) *attestation.Attester {
+	log := gologger.WithComponent("attestation")
+
+	provider, err := attestation.NewProvider(attestation.Options{
+		Mode:           cfg.Runner.Attestation.Mode,
+		TPMAKContext:   cfg.Runner.Attestation.TPMAKContext,
+		TPMAKPublicKey: cfg.Runner.Attestation.TPMAKPublicKey,
+	})
+	if err != nil {
+		if cfg.Runner.Attestation.Mode != attestation.ModeOff {
+			log.Warn().Err(err).Msg("Attestation unavailable - tasks requiring it will be declined")
+		}
+		return nil
+	}
+
+	binaryHash := provenance.Current().BinaryHash
+	if binaryHash == "" {
+		log.Warn().Msg("Attestation unavailable, runner binary could not be hashed - tasks requiring it will be declined")
+		return nil
+	}
+	configHash, err := attestation.ConfigHash(cfg.Runner)
+	if err != nil {
+		log.Warn().Err(err).Msg("Attestation unavailable - tasks requiring it will be declined")
+		return nil
+	}
+
+	attester := attestation.NewAttester(provider, binaryHash, configHash)
+	attester.SetClock(clk)
+	if attester.Type() == models.AttestationSimulated {
+		log.Warn().Msg("Using simulated attestation - evidence proves nothing about this host")
+	}
+	log.Info().
+		Str("type", string(attester.Type())).
+		Str("binary_hash", binaryHash).
+		Str("config_hash", configHash).
+		Msg("Attestation enabled")
+	return attester
+}
+
+// registrationEvidence returns a source of fresh evidence for registration
+func registrationEvidence(client AttestationClient, attester *attestation.Attester) func() (*models.AttestationEvidence, error) {
+	return func() (*models.AttestationEvidence, error) {
+		challenge, err := client.GetAttestationChallenge("")
+		if err != nil {
+			return nil, fmt.Errorf("failed to get attestation challenge: %w", err)
+		}
+		return attester.Attest(context.Background(), challenge.Nonce)
+	}
+}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/attestation"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+type attestingClient struct {
+	fakeFLClient
+	results []*models.TaskResult
+}
+
+func (c *attestingClient) UpdateTaskStatus(taskID string, status models.TaskStatus, result *models.TaskResult) error {
+	c.mu.Lock()
+	c.results = append(c.results, result)
+	c.mu.Unlock()
+	return c.fakeFLClient.UpdateTaskStatus(taskID, status, result)
+}
+
+func (c *attestingClient) GetAttestationChallenge(taskID string) (*models.AttestationChallenge, error) {
+	return &models.AttestationChallenge{Nonce: "nonce-" + taskID}, nil
+}
+
+func newAttestedTask(t *testing.T) *models.Task {
+	t.Helper()
+	raw, err := json.Marshal(models.TaskConfig{ImageName: "alpine", RequireAttestation: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker, Nonce: "abcdef", Config: raw}
+}
+
+func TestAttestationRequiredTaskDeclinedWithoutSupport(t *testing.T) {
+	client := &attestingClient{}
+	executor := &countingExecutor{}
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+
+	err := h.HandleTask(newAttestedTask(t))
+	var admission *admissionError
+	if !errors.As(err, &admission) || admission.reason != models.FLDeclineAttestationRequired {
+		t.Fatalf("HandleTask() error = %v, want attestation_required decline", err)
+	}
+	if executor.calls != 0 || len(client.statuses) != 0 {
+		t.Fatalf("declined task was claimed or executed: calls=%d statuses=%v", executor.calls, client.statuses)
+	}
+}
+
+func TestAttestationRequiredTaskCarriesEvidence(t *testing.T) {
+	provider, err := attestation.NewSimulatedProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &attestingClient{}
+	h := NewTaskHandler(&countingExecutor{}, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	h.SetAttester(attestation.NewAttester(provider, "binhash", "cfghash"))
+	h.SetBuildFingerprint("official-build")
+
+	task := newAttestedTask(t)
+	if err := h.HandleTask(task); err != nil {
+		t.Fatalf("HandleTask() error = %v", err)
+	}
+
+	if len(client.statuses) != 2 || client.statuses[1] != models.TaskStatusCompleted {
+		t.Fatalf("expected the task to complete, got statuses %v", client.statuses)
+	}
+	result := client.results[1]
+	err = attestation.Verify(result.Attestation, attestation.Expectation{
+		Nonce:          "nonce-" + task.ID.String(),
+		BinaryHashes:   []string{"binhash"},
+		TaskID:         task.ID.String(),
+		ResultHash:     attestation.ResultHash(result),
+		AllowSimulated: true,
+	})
+	if err != nil {
+		t.Fatalf("result evidence does not verify: %v", err)
+	}
+
+	// The evidence covers the build that produced the result
+	if result.BuildFingerprint != "official-build" {
+		t.Fatalf("result build fingerprint = %q, want official-build", result.BuildFingerprint)
+	}
+	relabelled := *result
+	relabelled.BuildFingerprint = "modified-build"
+	err = attestation.Verify(result.Attestation, attestation.Expectation{
+		Nonce:          "nonce-" + task.ID.String(),
+		BinaryHashes:   []string{"binhash"},
+		TaskID:         task.ID.String(),
+		ResultHash:     attestation.ResultHash(&relabelled),
+		AllowSimulated: true,
+	})
+	if !errors.Is(err, attestation.ErrMismatch) {
+		t.Fatalf("Verify() error = %v for a result relabelled with another build, want a mismatch", err)
+	}
+}
@@ -0,0 +1,251 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/attestation"
+	"github.com/theblitlabs/parity-runner/internal/audit"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/history"
+)
+
+const (
+	auditDirName         = "audit"
+	auditKeyFileName     = "vrf_key.pem"
+	auditCommitmentsName = "commitments.json"
+	auditBundlesDirName  = "bundles"
+)
+
+// AuditClient submits answers to audit challenges
+type AuditClient interface {
+	SubmitAuditResponse(response *models.AuditResponse) error
+}
+
+// auditState remembers challenges being or already answered, since the
+// server repeats a challenge in heartbeat responses until it is answered
+type auditState struct {
+	mu       sync.Mutex
+	answered map[string]bool
+}
+
+func (a *auditState) start(challengeID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.answered[challengeID] {
+		return false
+	}
+	if a.answered == nil {
+		a.answered = make(map[string]bool)
+	}
+	a.answered[challengeID] = true
+	return true
+}
+
+func (a *auditState) forget(challengeID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.answered, challengeID)
+}
+
+// SetAuditor keeps replay bundles of completed tasks in auditor's store
+// and answers audit challenges against its commitments
+func (h *DefaultTaskHandler) SetAuditor(auditor *audit.Auditor) {
+	h.auditor = auditor
+}
+
+// AuditCommitment returns the latest commitment to completed tasks, or nil
+// when audits are disabled
+func (h *DefaultTaskHandler) AuditCommitment() *models.AuditCommitment {
+	if h.auditor == nil {
+		return nil
+	}
+	return h.auditor.Latest()
+}
+
+// resultDigest digests the parts of a task's result its creator relies on,
+// whether or not the executor set the result's task ID. The build
+// fingerprint is left out, so that a task replayed after an upgrade still
+// matches, as are the attempts a hermetic task made to leave its closure,
+// which are timed.
+func resultDigest(task *models.Task, result *models.TaskResult) string {
+	digested := *result
+	digested.TaskID = task.ID
+	digested.BuildFingerprint = ""
+	if result.Hermetic != nil {
+		hermetic := *result.Hermetic
+		hermetic.Violations = nil
+		digested.Hermetic = &hermetic
+	}
+	return attestation.ResultHash(&digested)
+}
+
+// auditDigest returns the digest of a completed task's result recorded for
+// audits, or "" when audits are disabled
+func (h *DefaultTaskHandler) auditDigest(task *models.Task, result *models.TaskResult) string {
+	if h.auditor == nil || result == nil {
+		return ""
+	}
+	return resultDigest(task, result)
+}
+
+// keepReplay saves what is needed to run a task whose result was digested
+// for audits again
+func (h *DefaultTaskHandler) keepReplay(e events.Event) {
+	completed, ok := e.(events.TaskCompleted)
+	if !ok || completed.ResultDigest == "" || h.auditor == nil {
+		return
+	}
+	if err := h.auditor.Bundles().Save(completed.Task, completed.ResultDigest); err != nil {
+		log := gologger.WithComponent("task_handler")
+		log.Warn().Err(err).Str("id", completed.TaskID).Msg("Failed to keep replay bundle")
+	}
+}
+
+// WatchAudit commits to the tasks completed so far now and every interval
+// until ctx ends
+func (h *DefaultTaskHandler) WatchAudit(ctx context.Context, interval time.Duration) {
+	if h.auditor == nil {
+		return
+	}
+
+	ticker := h.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.commitAudit()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func (h *DefaultTaskHandler) commitAudit() {
+	log := gologger.WithComponent("task_handler")
+	commitment, created, err := h.auditor.Commit()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to commit to completed tasks")
+		return
+	}
+	if created {
+		log.Info().
+			Uint64("epoch", commitment.Epoch).
+			Int("tasks", commitment.Count).
+			Str("root", commitment.Root).
+			Msg("Committed to completed tasks for audit")
+	}
+}
+
+// HandleAuditChallenge runs the task challenge selects again and submits
+// the result with the proof of selection. Challenges already answered are
+// ignored.
+func (h *DefaultTaskHandler) HandleAuditChallenge(challenge *models.AuditChallenge) {
+	log := gologger.WithComponent("task_handler")
+	if h.auditor == nil || !h.audits.start(challenge.ID) {
+		return
+	}
+
+	response := h.answerAudit(challenge)
+	logEvent := log.Info()
+	if response.Status != models.AuditStatusReexecuted || !response.Match {
+		logEvent = log.Warn()
+	}
+	logEvent.
+		Str("challenge_id", challenge.ID).
+		Uint64("epoch", response.Epoch).
+		Str("task_id", response.TaskID).
+		Str("status", string(response.Status)).
+		Bool("match", response.Match).
+		Str("error", response.Error).
+		Msg("Answered audit challenge")
+
+	client, ok := h.taskClient.(AuditClient)
+	if !ok {
+		return
+	}
+	if err := client.SubmitAuditResponse(response); err != nil {
+		log.Error().Err(err).Str("challenge_id", challenge.ID).Msg("Failed to submit audit response")
+		// Answer again when the server repeats the challenge
+		h.audits.forget(challenge.ID)
+	}
+}
+
+func (h *DefaultTaskHandler) answerAudit(challenge *models.AuditChallenge) *models.AuditResponse {
+	selection, err := h.auditor.Select(challenge)
+	if err != nil {
+		return &models.AuditResponse{
+			ChallengeID: challenge.ID,
+			Epoch:       challenge.Epoch,
+			Status:      models.AuditStatusFailed,
+			Error:       err.Error(),
+		}
+	}
+	response := selection.Response(challenge)
+
+	bundles := h.auditor.Bundles()
+	bundle, err := bundles.Load(selection.TaskID)
+	if err == nil && bundle.ResultDigest != selection.ResultDigest {
+		err = fmt.Errorf("%w for task %s: kept for a different result", audit.ErrBundleMissing, selection.TaskID)
+	}
+	if err != nil {
+		response.Status = models.AuditStatusFailed
+		response.Error = err.Error()
+		if errors.Is(err, audit.ErrBundleMissing) {
+			response.Status = models.AuditStatusBundleMissing
+			response.Retention = bundles.Retention().Model()
+		}
+		return response
+	}
+
+	result, err := h.replay(bundle.Task)
+	if err != nil {
+		response.Status = models.AuditStatusFailed
+		response.Error = fmt.Sprintf("re-execution failed: %v", err)
+		return response
+	}
+	response.Status = models.AuditStatusReexecuted
+	response.ResultDigest = resultDigest(bundle.Task, result)
+	response.Match = response.ResultDigest == response.OriginalDigest
+	return response
+}
+
+// replay executes a completed task again without reporting it to the
+// server as a task
+func (h *DefaultTaskHandler) replay(task *models.Task) (*models.TaskResult, error) {
+	h.begin()
+	defer h.end()
+
+	timeout, _ := h.timeouts.Resolve(task)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return h.executor.ExecuteTask(ctx, task)
+}
+
+// newAuditor returns the auditor the audit config describes, keeping its
+// key, commitments and replay bundles under dir
+func newAuditor(dir string, cfg config.AuditConfig, historyStore *history.Store) (*audit.Auditor, error) {
+	dir = filepath.Join(dir, auditDirName)
+	key, err := audit.LoadOrCreateKey(filepath.Join(dir, auditKeyFileName))
+	if err != nil {
+		return nil, err
+	}
+	bundles, err := audit.NewBundleStore(filepath.Join(dir, auditBundlesDirName), audit.Retention{
+		MaxAge:     cfg.Retention,
+		MaxBundles: cfg.MaxBundles,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return audit.NewAuditor(key, historyStore, bundles, filepath.Join(dir, auditCommitmentsName))
+}
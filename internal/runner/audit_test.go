@@ -0,0 +1,138 @@
+package runner
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/audit"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/history"
+)
+
+// auditingClient records the audit responses submitted
+type auditingClient struct {
+	fakeFLClient
+	responses []*models.AuditResponse
+}
+
+func (c *auditingClient) SubmitAuditResponse(response *models.AuditResponse) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responses = append(c.responses, response)
+	return nil
+}
+
+// replayExecutor answers with the task ID, and with drift set also how
+// often it ran, as a nondeterministic task would
+type replayExecutor struct {
+	mu    sync.Mutex
+	runs  int
+	drift bool
+}
+
+func (e *replayExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.runs++
+	output := task.ID.String()
+	if e.drift {
+		output += fmt.Sprint(e.runs)
+	}
+	return &models.TaskResult{TaskID: task.ID, Output: output}, nil
+}
+
+func TestAuditChallengeReexecution(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		drift         bool
+		dropBundles   bool
+		epoch         uint64
+		status        models.AuditStatus
+		match         bool
+		withRetention bool
+	}{
+		{"deterministic task matches", false, false, 1, models.AuditStatusReexecuted, true, false},
+		{"nondeterministic task differs", true, false, 1, models.AuditStatusReexecuted, false, false},
+		{"pruned bundle is reported", false, true, 1, models.AuditStatusBundleMissing, false, true},
+		{"unknown epoch fails", false, false, 7, models.AuditStatusFailed, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			store, err := history.NewStore(filepath.Join(dir, historyFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bundles, err := audit.NewBundleStore(filepath.Join(dir, "bundles"), audit.Retention{MaxBundles: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+			auditor, err := audit.NewAuditor(key, store, bundles, filepath.Join(dir, "commitments.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			executor := &replayExecutor{drift: tt.drift}
+			client := &auditingClient{}
+			h := NewTaskHandler(executor, client)
+			h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+			h.SetHistory(store, NewTimeoutPolicy(TimeoutPolicyConfig{}, nil))
+			h.SetAuditor(auditor)
+			h.SetEventBus(events.NewBus(0))
+
+			for i := 0; i < 3; i++ {
+				if err := h.HandleTask(newCommandTask(60 + i)); err != nil {
+					t.Fatalf("HandleTask() error = %v", err)
+				}
+			}
+			h.events.Sync()
+			h.commitAudit()
+			commitment := h.AuditCommitment()
+			if commitment == nil || commitment.Count != 3 {
+				t.Fatalf("AuditCommitment() = %+v, want 3 committed tasks", commitment)
+			}
+
+			if tt.dropBundles {
+				if err := os.RemoveAll(filepath.Join(dir, "bundles")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			challenge := &models.AuditChallenge{ID: "audit-1", Seed: "5eed", Epoch: tt.epoch}
+			h.HandleAuditChallenge(challenge)
+			h.HandleAuditChallenge(challenge)
+
+			client.mu.Lock()
+			defer client.mu.Unlock()
+			if len(client.responses) != 1 {
+				t.Fatalf("submitted %d responses, want one per challenge", len(client.responses))
+			}
+			response := client.responses[0]
+			if response.Status != tt.status || response.Match != tt.match {
+				t.Fatalf("response status %s match %v, want %s match %v (%s)", response.Status, response.Match, tt.status, tt.match, response.Error)
+			}
+			if (response.Retention != nil) != tt.withRetention {
+				t.Fatalf("response retention = %+v, want it attached: %v", response.Retention, tt.withRetention)
+			}
+			if tt.status == models.AuditStatusFailed {
+				return
+			}
+			if err := audit.VerifyResponse(commitment, challenge, response); err != nil {
+				t.Fatalf("VerifyResponse() error = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/budget"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+const budgetFileName = "budget.json"
+
+// SetBudget declines tasks once tracker's compute budget is used up and
+// charges the tasks run against it
+func (h *DefaultTaskHandler) SetBudget(tracker *budget.Tracker) {
+	h.budget = tracker
+}
+
+// admitBudget declines tasks the remaining compute budget does not allow
+func (h *DefaultTaskHandler) admitBudget(task *models.Task) *admissionError {
+	if h.budget == nil || h.force {
+		return nil
+	}
+	if err := h.budget.Admit(h.usesGPU(task)); err != nil {
+		return &admissionError{reason: models.FLDeclineBudgetExhausted, err: err}
+	}
+	return nil
+}
+
+// chargeBudget charges the compute task used since run started. CPU time
+// comes from the resource samples in result; tasks without them count as
+// one CPU for their run. GPU tasks use one GPU for their run.
+func (h *DefaultTaskHandler) chargeBudget(task *models.Task, run clock.Stopwatch, result *models.TaskResult) {
+	if h.budget == nil {
+		return
+	}
+	startedAt := run.StartedAt()
+	elapsed := run.Elapsed()
+	end := startedAt.Add(elapsed)
+	runtime := elapsed.Seconds()
+
+	usage := budget.Usage{CPUSeconds: runtime}
+	if result != nil && result.CPUSeconds > 0 {
+		usage.CPUSeconds = result.CPUSeconds
+	}
+	if h.usesGPU(task) {
+		usage.GPUSeconds = runtime
+	}
+	h.budget.Charge(startedAt, end, usage)
+}
+
+// usesGPU reports whether task runs on a GPU: it requests an accelerator or
+// VRAM, or is an LLM task on a runner with one
+func (h *DefaultTaskHandler) usesGPU(task *models.Task) bool {
+	if task.Type == models.TaskTypeLLM {
+		return h.hardware != nil && h.hardware.Accelerator != "" && h.hardware.Accelerator != hardware.AcceleratorNone
+	}
+	var config models.TaskConfig
+	if len(task.Config) == 0 || safejson.Unmarshal(task.Config, &config) != nil {
+		return false
+	}
+	accelerator := hardware.AcceleratorClass(config.Resources.Accelerator)
+	return (accelerator != "" && accelerator != hardware.AcceleratorNone) || config.Resources.GPUMemory != ""
+}
+
+// newComputeBudget returns the tracker the budget config describes, keeping
+// usage under dataDir, or nil when no budget is capped
+func newComputeBudget(dataDir string, cfg config.BudgetConfig) (*budget.Tracker, error) {
+	limits := budget.Config{
+		Daily:  budget.Limits{CPUHours: cfg.DailyCPUHours, GPUHours: cfg.DailyGPUHours},
+		Weekly: budget.Limits{CPUHours: cfg.WeeklyCPUHours, GPUHours: cfg.WeeklyGPUHours},
+	}
+	if limits.Daily == (budget.Limits{}) && limits.Weekly == (budget.Limits{}) {
+		return nil, nil
+	}
+	if cfg.Timezone != "" {
+		location, err := time.LoadLocation(cfg.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid budget timezone: %w", err)
+		}
+		limits.Location = location
+	}
+	return budget.New(limits, filepath.Join(dataDir, budgetFileName))
+}
@@ -0,0 +1,159 @@
+package runner
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/blobcache"
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+	"github.com/theblitlabs/parity-runner/internal/execution/onnx"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/execution/training"
+	"github.com/theblitlabs/parity-runner/internal/fsck"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+const (
+	// cacheStateFileName holds cache pins and usage times
+	cacheStateFileName  = "caches/state.json"
+	datasetCacheDirName = "caches/datasets"
+	inputCacheDirName   = "caches/inputs"
+)
+
+// localCaches are the runner's disk caches and the registry managing them
+type localCaches struct {
+	registry  *caches.Registry
+	datasets  *training.DatasetCache
+	artifacts *artifacts.Cache
+	inputs    *inputs.Manager
+	blobs     *blobcache.Store
+}
+
+// openLocalCaches registers the image, model, dataset, artifact, input and image blob caches
+// kept under dataDir. Caches that cannot be opened are left unregistered.
+func openLocalCaches(dataDir string) (*localCaches, error) {
+	log := gologger.WithComponent("caches")
+
+	registry, err := caches.OpenRegistry(filepath.Join(dataDir, cacheStateFileName))
+	if err != nil {
+		return nil, err
+	}
+	lc := &localCaches{registry: registry}
+	quarantine := fsck.NewQuarantine(filepath.Join(dataDir, quarantineDirName))
+
+	// Only images pulled for tasks are managed; the operator's own images
+	// on the same daemon are never listed or evicted
+	registry.Register(caches.Images, docker.NewImageStore(), caches.StoreOptions{TrackedOnly: true})
+	registry.Register(caches.Models, llm.NewModelStore(""), caches.StoreOptions{})
+
+	if lc.datasets, err = training.OpenDatasetCache(filepath.Join(dataDir, datasetCacheDirName)); err != nil {
+		log.Warn().Err(err).Msg("Dataset cache unavailable - datasets will be fetched for every round")
+	} else {
+		lc.datasets.SetQuarantine(quarantine)
+		registry.Register(caches.Datasets, lc.datasets, caches.StoreOptions{})
+	}
+
+	if lc.artifacts, err = artifacts.OpenCache(filepath.Join(dataDir, artifactCacheFileName)); err != nil {
+		log.Warn().Err(err).Msg("Artifact cache unavailable - exported layers will always be uploaded")
+	} else {
+		registry.Register(caches.Artifacts, lc.artifacts, caches.StoreOptions{})
+	}
+
+	if lc.inputs, err = inputs.NewManager(filepath.Join(dataDir, inputCacheDirName)); err != nil {
+		log.Warn().Err(err).Msg("Input cache unavailable - tasks that declare inputs will fail")
+	} else {
+		lc.inputs.SetQuarantine(quarantine)
+		registry.Register(caches.Inputs, lc.inputs, caches.StoreOptions{})
+	}
+
+	if lc.blobs, err = blobcache.Open(filepath.Join(dataDir, blobCacheDirName)); err != nil {
+		log.Warn().Err(err).Msg("Image blob cache unavailable - image tarballs will be downloaded for every task")
+	} else {
+		registry.Register(caches.ImageBlobs, lc.blobs, caches.StoreOptions{})
+	}
+	return lc, nil
+}
+
+// OpenCacheRegistry opens the runner's caches for administration while the
+// runner itself is not running
+func OpenCacheRegistry() (*caches.Registry, error) {
+	dir, err := parityDir()
+	if err != nil {
+		return nil, err
+	}
+	lc, err := openLocalCaches(dir)
+	if err != nil {
+		return nil, err
+	}
+	return lc.registry, nil
+}
+
+// cacheRefs names the cached content task depends on while it runs
+func cacheRefs(task *models.Task) []caches.Ref {
+	switch task.Type {
+	case models.TaskTypeDocker, models.TaskTypeCommand, models.TaskTypeService:
+		var config models.TaskConfig
+		if err := safejson.Unmarshal(task.Config, &config); err != nil {
+			return nil
+		}
+		var refs []caches.Ref
+		if task.Type != models.TaskTypeCommand && config.ImageName != "" {
+			refs = append(refs, caches.Ref{Cache: caches.Images, Key: docker.CanonicalImageName(config.ImageName)})
+		}
+		if task.Type != models.TaskTypeCommand && config.DockerImageDigest != "" {
+			refs = append(refs, caches.Ref{Cache: caches.ImageBlobs, Key: config.DockerImageDigest})
+		}
+		for _, key := range inputs.CacheKeys(config.Inputs) {
+			refs = append(refs, caches.Ref{Cache: caches.Inputs, Key: key})
+		}
+		return refs
+	case models.TaskTypeONNX:
+		var config onnx.Config
+		if err := safejson.Unmarshal(task.Config, &config); err != nil {
+			return nil
+		}
+		var refs []caches.Ref
+		for _, key := range inputs.CacheKeys(config.Files()) {
+			refs = append(refs, caches.Ref{Cache: caches.Inputs, Key: key})
+		}
+		return refs
+	case models.TaskTypeLLM, models.TaskTypeEmbedding:
+		refs := []caches.Ref{{Cache: caches.Models, Key: llm.CanonicalModelName(llm.TaskModel(task.Config))}}
+		var config struct {
+			ModelArtifact *llm.ModelArtifact `json:"model_artifact"`
+		}
+		if task.Type == models.TaskTypeLLM && safejson.Unmarshal(task.Config, &config) == nil && config.ModelArtifact != nil {
+			for _, key := range inputs.CacheKeys([]models.TaskInput{*config.ModelArtifact.Input()}) {
+				refs = append(refs, caches.Ref{Cache: caches.Inputs, Key: key})
+			}
+		}
+		return refs
+	case models.TaskTypeFederatedLearning:
+		var config flRoundConfig
+		if err := safejson.Unmarshal(task.Config, &config); err == nil && config.DatasetCID != "" {
+			return []caches.Ref{{Cache: caches.Datasets, Key: config.DatasetCID}}
+		}
+	}
+	return nil
+}
+
+// SetCaches protects the content of in-flight tasks in registry from purges
+// and budget eviction
+func (h *DefaultTaskHandler) SetCaches(registry *caches.Registry) {
+	h.caches = registry
+}
+
+// holdCaches marks the cached content task depends on as in use until the
+// returned release is called
+func (h *DefaultTaskHandler) holdCaches(ctx context.Context, task *models.Task) func() {
+	if h.caches == nil {
+		return func() {}
+	}
+	return h.caches.Acquire(ctx, cacheRefs(task)...)
+}
@@ -0,0 +1,161 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/canary"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+	"github.com/theblitlabs/parity-runner/internal/history"
+)
+
+// canaryGates lists the task types that need each capability a canary
+// checks. Every task needs scratch space, and LLM tasks need the model they
+// ask for.
+var canaryGates = map[string][]models.TaskType{
+	canary.Container: {models.TaskTypeDocker, models.TaskTypeService},
+	canary.Network:   {models.TaskTypeDocker, models.TaskTypeONNX, models.TaskTypeService},
+}
+
+// ContainerCanary runs a minimal container, as the Docker executor does
+type ContainerCanary interface {
+	RunCanary(ctx context.Context, image string) error
+}
+
+// ModelProber generates a token with a model, as the Ollama executor does
+type ModelProber interface {
+	Probe(ctx context.Context, model string) error
+}
+
+// canaryChecks returns the checks of what the runner offers: scratch space
+// and the network, containers when it has an executor for them, and each
+// model served returns
+func canaryChecks(cfg config.CanaryConfig, client *http.Client, containers ContainerCanary, prober ModelProber, served func() []string) func() []canary.Check {
+	return func() []canary.Check {
+		checks := []canary.Check{canary.ScratchCheck("")}
+		if cfg.NetworkURL != "" {
+			checks = append(checks, canary.NetworkCheck(client, cfg.NetworkURL))
+		}
+		if containers != nil {
+			checks = append(checks, canary.Check{
+				Capability: canary.Container,
+				Run: func(ctx context.Context) error {
+					return containers.RunCanary(ctx, cfg.Image)
+				},
+			})
+		}
+		if prober != nil {
+			for _, model := range served() {
+				model := model
+				checks = append(checks, canary.Check{
+					Capability: canary.Model(llm.CanonicalModelName(model)),
+					Run: func(ctx context.Context) error {
+						return prober.Probe(ctx, model)
+					},
+				})
+			}
+		}
+		return checks
+	}
+}
+
+// newCanaryMonitor returns the monitor running checks as cfg schedules,
+// keeping every result in store when set
+func newCanaryMonitor(cfg config.CanaryConfig, checks func() []canary.Check, store *history.Store) (*canary.Monitor, error) {
+	monitor, err := canary.NewMonitor(checks, cfg.Threshold, cfg.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure canaries: %w", err)
+	}
+	if store != nil {
+		monitor.OnResult(func(result canary.Result) {
+			record := history.Record{
+				Workload:   result.Capability,
+				Status:     models.TaskStatusCompleted,
+				StartedAt:  result.StartedAt,
+				DurationMs: result.Duration.Milliseconds(),
+				Event:      history.EventCanary,
+			}
+			if result.Err != nil {
+				record.Status = models.TaskStatusFailed
+				record.Error = result.Err.Error()
+			}
+			if err := store.Append(record); err != nil {
+				log := gologger.WithComponent("canary")
+				log.Warn().Err(err).Str("capability", result.Capability).Msg("Failed to record canary result")
+			}
+		})
+	}
+	return monitor, nil
+}
+
+// canaryChanged tells every instance a capability was degraded or
+// restored: models are advertised again without those degraded, and the
+// change is published so the capability profile is pushed and hooks run
+func (r *sharedResources) canaryChanged(status models.CanaryStatus) {
+	for _, svc := range r.services {
+		if _, ok := canary.ModelOf(status.Capability); ok {
+			svc.advertiseModels()
+		}
+		if status.Degraded {
+			svc.events.Publish(events.CapabilityDegraded{
+				Capability:          status.Capability,
+				ConsecutiveFailures: status.ConsecutiveFailures,
+				Error:               status.LastError,
+			})
+		} else {
+			svc.events.Publish(events.CapabilityRestored{Capability: status.Capability})
+		}
+	}
+}
+
+// canaryNeeds reports whether tasks of taskType need capability
+func canaryNeeds(capability string, taskType models.TaskType) bool {
+	return capability == canary.Scratch || containsType(canaryGates[capability], taskType)
+}
+
+// healthyTypes returns types without those needing a capability monitor
+// has degraded
+func healthyTypes(monitor *canary.Monitor, types []models.TaskType) []models.TaskType {
+	statuses := monitor.Status()
+	healthy := make([]models.TaskType, 0, len(types))
+	for _, taskType := range types {
+		degraded := false
+		for _, status := range statuses {
+			degraded = degraded || (status.Degraded && canaryNeeds(status.Capability, taskType))
+		}
+		if !degraded {
+			healthy = append(healthy, taskType)
+		}
+	}
+	return healthy
+}
+
+// SetCanaries skips tasks needing a capability monitor has degraded until
+// its canary passes again
+func (h *DefaultTaskHandler) SetCanaries(monitor *canary.Monitor) {
+	h.canaries = monitor
+}
+
+// admitCanary declines tasks needing a degraded capability, including LLM
+// tasks asking for a model whose canary keeps failing
+func (h *DefaultTaskHandler) admitCanary(task *models.Task) *admissionError {
+	for _, status := range h.canaries.Status() {
+		if !status.Degraded {
+			continue
+		}
+		needed := canaryNeeds(status.Capability, task.Type)
+		if model, ok := canary.ModelOf(status.Capability); ok && task.Type == models.TaskTypeLLM {
+			needed = llm.CanonicalModelName(llm.TaskModel(task.Config)) == model
+		}
+		if needed {
+			return &admissionError{reason: models.FLDeclineDegraded, err: fmt.Errorf("capability %s is degraded: %s", status.Capability, status.LastError)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/canary"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/messaging/webhook"
+	"github.com/theblitlabs/parity-runner/internal/power"
+)
+
+// brokenCapabilities fails the canaries of the capabilities set in it, as a
+// runner whose container runtime or model backend broke would
+type brokenCapabilities struct {
+	mu     sync.Mutex
+	broken map[string]bool
+}
+
+func (b *brokenCapabilities) set(capability string, broken bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.broken[capability] = broken
+}
+
+func (b *brokenCapabilities) check(capability string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.broken[capability] {
+		return errors.New(capability + " is broken")
+	}
+	return nil
+}
+
+func (b *brokenCapabilities) RunCanary(ctx context.Context, image string) error {
+	return b.check(canary.Container)
+}
+
+func (b *brokenCapabilities) Probe(ctx context.Context, model string) error {
+	return b.check(canary.Model(model))
+}
+
+func TestCanaryDegradesCapabilities(t *testing.T) {
+	store, err := history.NewStore(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	broken := &brokenCapabilities{broken: map[string]bool{}}
+	cfg := config.CanaryConfig{Threshold: 2, Image: "hello-world"}
+	served := func() []string { return []string{"llama3:latest", "mistral:latest"} }
+	monitor, err := newCanaryMonitor(cfg, canaryChecks(cfg, nil, broken, broken, served), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := events.NewBus(16)
+	defer bus.Close()
+	var mu sync.Mutex
+	var published []events.Event
+	bus.Subscribe("test", func(e events.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		published = append(published, e)
+	})
+	svc := &Service{
+		events:        bus,
+		webhookClient: webhook.NewWebhookClient("http://localhost", 0, nil, "", "", ""),
+		canaries:      monitor,
+		servedModels:  []webhook.ModelCapabilityInfo{{ModelName: "llama3"}, {ModelName: "mistral"}},
+	}
+	shared := &sharedResources{services: []*Service{svc}}
+	monitor.OnChange(shared.canaryChanged)
+
+	executor := &countingExecutor{}
+	h, _, _ := newPowerHandler(executor, power.InFlightContinue)
+	h.SetCanaries(monitor)
+	dockerTask := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker, Nonce: "abcdef", Config: []byte(`{"image_name": "alpine"}`)}
+	llamaTask := &models.Task{ID: uuid.New(), Type: models.TaskTypeLLM, Nonce: "abcdef", Config: []byte(`{"prompt": "hi", "model": "llama3"}`)}
+	mistralTask := &models.Task{ID: uuid.New(), Type: models.TaskTypeLLM, Nonce: "abcdef", Config: []byte(`{"prompt": "hi", "model": "mistral"}`)}
+	types := []models.TaskType{models.TaskTypeDocker, models.TaskTypeCommand, models.TaskTypeLLM}
+
+	broken.set(canary.Container, true)
+	broken.set(canary.Model("llama3:latest"), true)
+	for i := 0; i < cfg.Threshold; i++ {
+		monitor.RunOnce(context.Background())
+	}
+	bus.Sync()
+
+	// Only what the broken capabilities serve stops being claimed
+	var admission *admissionError
+	for _, task := range []*models.Task{dockerTask, llamaTask} {
+		if err := h.HandleTask(task); !errors.As(err, &admission) || admission.reason != models.FLDeclineDegraded {
+			t.Fatalf("HandleTask(%s) error = %v, want a degraded skip", task.Type, err)
+		}
+	}
+	if admission := h.admit(mistralTask); admission != nil {
+		t.Fatalf("admit(mistral) = %v, want the task admitted", admission)
+	}
+	if err := h.HandleTask(newCommandTask(0)); err != nil || executor.calls != 1 {
+		t.Fatalf("HandleTask(command) error = %v, want the task run", err)
+	}
+
+	// and stops being advertised
+	capabilities := deriveCapabilities(healthyTypes(monitor, types), nil, nil, false)
+	if len(capabilities.TaskTypes) != 2 || capabilities.TaskTypes[0].Type != models.TaskTypeCommand || len(capabilities.Features) != 0 {
+		t.Errorf("capabilities = %+v, want docker no longer advertised", capabilities)
+	}
+	if advertised := svc.advertisedModels(); len(advertised) != 1 || advertised[0].ModelName != "mistral" {
+		t.Errorf("advertised models = %+v, want only mistral", advertised)
+	}
+	mu.Lock()
+	degraded := map[string]bool{}
+	for _, e := range published {
+		if e, ok := e.(events.CapabilityDegraded); ok && e.ConsecutiveFailures == cfg.Threshold {
+			degraded[e.Capability] = true
+		}
+	}
+	mu.Unlock()
+	if len(degraded) != 2 || !degraded[canary.Container] || !degraded[canary.Model("llama3:latest")] {
+		t.Errorf("degraded events for %v, want container and llama3", degraded)
+	}
+
+	// A passing canary restores the capability
+	broken.set(canary.Container, false)
+	broken.set(canary.Model("llama3:latest"), false)
+	monitor.RunOnce(context.Background())
+	bus.Sync()
+	if capabilities := deriveCapabilities(healthyTypes(monitor, types), nil, nil, false); len(capabilities.TaskTypes) != 3 {
+		t.Errorf("capabilities = %+v, want docker advertised again", capabilities)
+	}
+	if advertised := svc.advertisedModels(); len(advertised) != 2 {
+		t.Errorf("advertised models = %+v, want both", advertised)
+	}
+	if err := h.HandleTask(dockerTask); err != nil || executor.calls != 2 {
+		t.Fatalf("HandleTask(docker) error = %v, want the task run once restored", err)
+	}
+	if admission := h.admit(llamaTask); admission != nil {
+		t.Fatalf("admit(llama3) = %v, want the task admitted once restored", admission)
+	}
+	mu.Lock()
+	restored := 0
+	for _, e := range published {
+		if _, ok := e.(events.CapabilityRestored); ok {
+			restored++
+		}
+	}
+	mu.Unlock()
+	if restored != 2 {
+		t.Errorf("%d restored events, want 2", restored)
+	}
+
+	// Every canary run is kept in the history apart from task executions
+	records, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := 0
+	for _, record := range records {
+		if record.Event != history.EventCanary {
+			continue
+		}
+		if record.Status == models.TaskStatusFailed {
+			failed++
+		}
+	}
+	if failed != 2*cfg.Threshold {
+		t.Errorf("%d failed canary records, want %d", failed, 2*cfg.Threshold)
+	}
+	if durations, _ := store.Durations("", canary.Scratch, 0); len(durations) != 0 {
+		t.Errorf("canary runs counted as %d task executions", len(durations))
+	}
+}
@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/taskschema"
+)
+
+// TaskTypeRegistry is an executor that reports the task types it runs
+type TaskTypeRegistry interface {
+	TaskTypes() []models.TaskType
+}
+
+// deriveCapabilities builds what the runner advertises from the task types
+// its executor runs, narrowed to allowed unless that is empty, with the
+// config schema versions it knows for each
+func deriveCapabilities(types, allowed []models.TaskType, profile *hardware.Profile, attests bool) *models.RunnerCapabilities {
+	capabilities := &models.RunnerCapabilities{TaskTypes: []models.TaskTypeSupport{}}
+	for _, taskType := range types {
+		if len(allowed) > 0 && !containsType(allowed, taskType) {
+			continue
+		}
+		capabilities.TaskTypes = append(capabilities.TaskTypes, models.TaskTypeSupport{
+			Type:           taskType,
+			SchemaVersions: taskschema.Versions(taskType),
+		})
+		if taskType == models.TaskTypeDocker {
+			capabilities.Features = append(capabilities.Features, models.RunnerFeatureNetworkIsolated)
+		}
+	}
+	if profile != nil && profile.Accelerator != "" && profile.Accelerator != hardware.AcceleratorNone {
+		capabilities.Features = append(capabilities.Features, models.RunnerFeatureGPU)
+	}
+	if attests {
+		capabilities.Features = append(capabilities.Features, models.RunnerFeatureAttestation)
+	}
+	return capabilities
+}
+
+func containsType(types []models.TaskType, taskType models.TaskType) bool {
+	for _, t := range types {
+		if t == taskType {
+			return true
+		}
+	}
+	return false
+}
+
+// disableTaskTypes stops executor running the task types named in names
+func disableTaskTypes(executor interface{ Disable(...models.TaskType) }, names []string) error {
+	types := make([]models.TaskType, 0, len(names))
+	for _, name := range names {
+		taskType := models.TaskType(strings.TrimSpace(name))
+		if taskType == "" {
+			continue
+		}
+		if len(taskschema.Versions(taskType)) == 0 {
+			return fmt.Errorf("unknown task type %q", name)
+		}
+		types = append(types, taskType)
+	}
+	executor.Disable(types...)
+	return nil
+}
+
+// supportsType reports whether the handler's executor runs tasks of
+// taskType. Executors that do not report their task types are taken to run
+// every type.
+func (h *DefaultTaskHandler) supportsType(taskType models.TaskType) bool {
+	registry, ok := h.executor.(TaskTypeRegistry)
+	if !ok {
+		return true
+	}
+	return containsType(registry.TaskTypes(), taskType)
+}
+
+// admitType declines tasks of types the executor does not run, which the
+// server should not have offered, counting them so heartbeats can tell the
+// server its routing is off
+func (h *DefaultTaskHandler) admitType(task *models.Task) *admissionError {
+	if h.supportsType(task.Type) {
+		return nil
+	}
+	h.unsupportedMu.Lock()
+	if h.unsupported == nil {
+		h.unsupported = make(map[models.TaskType]uint64)
+	}
+	h.unsupported[task.Type]++
+	h.unsupportedMu.Unlock()
+	return &admissionError{reason: models.FLDeclineUnsupportedType, err: fmt.Errorf("runner does not run %s tasks", task.Type)}
+}
+
+// UnsupportedTasks returns how many tasks of each type the executor does not
+// run were offered since the runner started, or nil when none were
+func (h *DefaultTaskHandler) UnsupportedTasks() map[models.TaskType]uint64 {
+	h.unsupportedMu.Lock()
+	defer h.unsupportedMu.Unlock()
+	if len(h.unsupported) == 0 {
+		return nil
+	}
+	counts := make(map[models.TaskType]uint64, len(h.unsupported))
+	for taskType, count := range h.unsupported {
+		counts[taskType] = count
+	}
+	return counts
+}
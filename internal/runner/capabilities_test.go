@@ -0,0 +1,100 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/taskschema"
+)
+
+// registryExecutor counts the tasks it runs, of the types it lists
+type registryExecutor struct {
+	countingExecutor
+	types []models.TaskType
+}
+
+func (e *registryExecutor) TaskTypes() []models.TaskType {
+	return e.types
+}
+
+func TestDeriveCapabilities(t *testing.T) {
+	types := []models.TaskType{models.TaskTypeCommand, models.TaskTypeDocker, models.TaskTypeLLM}
+	capabilities := deriveCapabilities(types, nil, &hardware.Profile{Accelerator: hardware.AcceleratorCUDA}, true)
+
+	if len(capabilities.TaskTypes) != 3 {
+		t.Fatalf("task types = %+v, want the executor's 3", capabilities.TaskTypes)
+	}
+	for _, support := range capabilities.TaskTypes {
+		versions := taskschema.Versions(support.Type)
+		if len(versions) == 0 || len(support.SchemaVersions) != len(versions) {
+			t.Errorf("%s schema versions = %v, want %v", support.Type, support.SchemaVersions, versions)
+		}
+	}
+	if capabilities.Supports(models.TaskTypeFederatedLearning) {
+		t.Error("capabilities include FL, which the executor does not run")
+	}
+	features := map[models.RunnerFeature]bool{}
+	for _, feature := range capabilities.Features {
+		features[feature] = true
+	}
+	if !features[models.RunnerFeatureGPU] || !features[models.RunnerFeatureNetworkIsolated] || !features[models.RunnerFeatureAttestation] {
+		t.Errorf("features = %v, want gpu, network-isolated and attestation", capabilities.Features)
+	}
+
+	// An instance's filters narrow what it advertises
+	narrowed := deriveCapabilities(types, []models.TaskType{models.TaskTypeCommand}, &hardware.Profile{Accelerator: hardware.AcceleratorNone}, false)
+	if len(narrowed.TaskTypes) != 1 || !narrowed.Supports(models.TaskTypeCommand) || len(narrowed.Features) != 0 {
+		t.Errorf("narrowed capabilities = %+v, want only command without features", narrowed)
+	}
+}
+
+func TestDisableTaskTypesRejectsUnknownType(t *testing.T) {
+	var disabled []models.TaskType
+	disable := disablerFunc(func(types ...models.TaskType) { disabled = append(disabled, types...) })
+	if err := disableTaskTypes(disable, []string{" federated_learning ", ""}); err != nil || len(disabled) != 1 || disabled[0] != models.TaskTypeFederatedLearning {
+		t.Fatalf("disableTaskTypes() = %v, disabled %v", err, disabled)
+	}
+	if err := disableTaskTypes(disable, []string{"quantum"}); err == nil {
+		t.Error("disableTaskTypes() accepted an unknown task type")
+	}
+}
+
+type disablerFunc func(...models.TaskType)
+
+func (f disablerFunc) Disable(types ...models.TaskType) { f(types...) }
+
+func TestUnsupportedTaskTypeIsSkippedAndCounted(t *testing.T) {
+	executor := &registryExecutor{types: []models.TaskType{models.TaskTypeDocker, models.TaskTypeCommand}}
+	client := &fakeFLClient{}
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+
+	if h.UnsupportedTasks() != nil {
+		t.Fatal("unsupported tasks counted before any was offered")
+	}
+	// The FL round is declined to the coordinator rather than trained
+	for i := 0; i < 2; i++ {
+		if err := h.HandleTask(newFLTask(t, flConfig())); !errors.Is(err, ErrRoundDeclined) {
+			t.Fatalf("HandleTask(FL task) error = %v, want ErrRoundDeclined", err)
+		}
+	}
+	if len(client.acks) != 2 || client.acks[0].Accepted || client.acks[0].Reason != models.FLDeclineUnsupportedType {
+		t.Fatalf("acks = %+v, want declines with reason unsupported_type", client.acks)
+	}
+	if executor.calls != 0 || len(client.statuses) != 0 {
+		t.Fatal("unsupported task claimed or run")
+	}
+
+	if err := h.HandleTask(newDockerTask(t)); err != nil {
+		t.Fatalf("HandleTask(docker task) error = %v", err)
+	}
+	if executor.calls != 1 {
+		t.Fatalf("executor ran %d tasks, want the supported one", executor.calls)
+	}
+	counts := h.UnsupportedTasks()
+	if len(counts) != 1 || counts[models.TaskTypeFederatedLearning] != 2 {
+		t.Errorf("UnsupportedTasks() = %v, want 2 federated_learning", counts)
+	}
+}
@@ -0,0 +1,175 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+)
+
+// capabilityRetryMax bounds how long a capability patch the server could not
+// be reached for waits before it is tried again
+const capabilityRetryMax = time.Minute
+
+// capabilityRegistrar is the registration whose capability profile the
+// syncer keeps current, as the webhook client implements it
+type capabilityRegistrar interface {
+	CapabilityProfile() models.CapabilityProfile
+	AcknowledgedProfile() (int64, models.CapabilityProfile)
+	AcknowledgeProfile(version int64, profile models.CapabilityProfile)
+	Register() error
+}
+
+type capabilityPatcher interface {
+	PatchRunnerCapabilities(patch *models.CapabilityPatch) (*models.CapabilityAck, error)
+}
+
+// capabilitySyncer tells the server about capability changes as they happen
+// instead of at the next full registration. Changes arriving within debounce
+// of the first are sent together, as the fields that differ from the
+// profile at the version the server last acknowledged; the next patch is
+// only made from the version the server acknowledged for this one. When the
+// server holds another version, or takes no patches, the runner registers in
+// full instead.
+type capabilitySyncer struct {
+	registrar capabilityRegistrar
+	patcher   capabilityPatcher
+	debounce  time.Duration
+	clock     clock.Clock
+
+	changes chan struct{}
+	// unsupported is set once the server refused a patch as unknown, after
+	// which changes are sent by registering in full
+	unsupported bool
+}
+
+func newCapabilitySyncer(registrar capabilityRegistrar, patcher capabilityPatcher, debounce time.Duration, clk clock.Clock) *capabilitySyncer {
+	return &capabilitySyncer{
+		registrar: registrar,
+		patcher:   patcher,
+		debounce:  debounce,
+		clock:     clk,
+		changes:   make(chan struct{}, 1),
+	}
+}
+
+// Subscribe marks the profile changed for the events on bus that may change
+// it: claimed and completed tasks reserve and free GPUs, canaries degrade
+// and restore capabilities, error budgets pause and resume task types, and
+// CapabilitiesChanged covers everything else
+func (s *capabilitySyncer) Subscribe(bus *events.Bus) {
+	bus.Subscribe("capabilities", func(e events.Event) {
+		switch e.(type) {
+		case events.TaskClaimed, events.TaskCompleted, events.CapabilitiesChanged,
+			events.CapabilityDegraded, events.CapabilityRestored,
+			events.TaskTypePaused, events.TaskTypeResumed:
+			s.Changed()
+		}
+	})
+}
+
+// Changed marks the profile changed, to be synced once the debounce passed
+func (s *capabilitySyncer) Changed() {
+	select {
+	case s.changes <- struct{}{}:
+	default:
+	}
+}
+
+// Run syncs the profile after each change until ctx is done
+func (s *capabilitySyncer) Run(ctx context.Context) {
+	log := gologger.WithComponent("capabilities")
+	retry := s.debounce
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.changes:
+		}
+
+		timer := s.clock.NewTimer(s.debounce)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+		// Changes made while waiting are part of this sync
+		select {
+		case <-s.changes:
+		default:
+		}
+
+		if err := s.sync(); err != nil {
+			log.Warn().Err(err).Dur("retry_in", retry).Msg("Failed to sync capability profile")
+			timer := s.clock.NewTimer(retry)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C():
+			}
+			retry = min(2*retry, capabilityRetryMax)
+			s.Changed()
+			continue
+		}
+		retry = s.debounce
+	}
+}
+
+// sync sends the server what changed in the profile since the version it
+// acknowledged
+func (s *capabilitySyncer) sync() error {
+	log := gologger.WithComponent("capabilities")
+
+	version, acknowledged := s.registrar.AcknowledgedProfile()
+	if acknowledged == nil {
+		// Not registered yet; registering sends the whole profile
+		return nil
+	}
+	profile := s.registrar.CapabilityProfile()
+	fields := acknowledged.Diff(profile)
+	if len(fields) == 0 {
+		return nil
+	}
+	if s.unsupported {
+		return s.register()
+	}
+
+	patch := &models.CapabilityPatch{BaseVersion: version, Version: version + 1, Fields: fields}
+	ack, err := s.patcher.PatchRunnerCapabilities(patch)
+	switch {
+	case errors.Is(err, ErrCapabilityConflict):
+		log.Info().Int64("base_version", version).Msg("Server holds another capability profile version, registering again")
+		return s.register()
+	case errors.Is(err, ErrCapabilityPatchUnsupported):
+		log.Info().Msg("Server takes no capability patches, registering again for each change")
+		s.unsupported = true
+		return s.register()
+	case err != nil:
+		return err
+	case ack.Version != patch.Version:
+		log.Info().
+			Int64("version", patch.Version).
+			Int64("acknowledged", ack.Version).
+			Msg("Server acknowledged another capability profile version, registering again")
+		return s.register()
+	}
+
+	s.registrar.AcknowledgeProfile(ack.Version, profile)
+	log.Debug().Int64("version", ack.Version).Int("fields", len(fields)).Msg("Capability profile patched")
+	return nil
+}
+
+func (s *capabilitySyncer) register() error {
+	if err := s.registrar.Register(); err != nil {
+		return fmt.Errorf("failed to register again: %w", err)
+	}
+	return nil
+}
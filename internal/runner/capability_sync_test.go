@@ -0,0 +1,340 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+)
+
+// fakeRegistrar holds a capability profile the way the webhook client does,
+// counting full registrations
+type fakeRegistrar struct {
+	mu            sync.Mutex
+	current       models.CapabilityProfile
+	version       int64
+	acknowledged  models.CapabilityProfile
+	registrations int
+}
+
+func (r *fakeRegistrar) set(field, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	next := make(models.CapabilityProfile, len(r.current))
+	for k, v := range r.current {
+		next[k] = v
+	}
+	if value == "" {
+		delete(next, field)
+	} else {
+		next[field] = json.RawMessage(value)
+	}
+	r.current = next
+}
+
+func (r *fakeRegistrar) CapabilityProfile() models.CapabilityProfile {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+func (r *fakeRegistrar) AcknowledgedProfile() (int64, models.CapabilityProfile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.version, r.acknowledged
+}
+
+func (r *fakeRegistrar) AcknowledgeProfile(version int64, profile models.CapabilityProfile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.version = version
+	r.acknowledged = profile
+}
+
+func (r *fakeRegistrar) Register() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.version++
+	r.acknowledged = r.current
+	r.registrations++
+	return nil
+}
+
+// fakePatcher acknowledges patches as a server holding serverVersion would
+type fakePatcher struct {
+	mu            sync.Mutex
+	serverVersion int64
+	err           error
+	patches       []*models.CapabilityPatch
+	done          chan struct{}
+}
+
+func (p *fakePatcher) PatchRunnerCapabilities(patch *models.CapabilityPatch) (*models.CapabilityAck, error) {
+	p.mu.Lock()
+	defer func() {
+		p.mu.Unlock()
+		p.done <- struct{}{}
+	}()
+	p.patches = append(p.patches, patch)
+	if p.err != nil {
+		return nil, p.err
+	}
+	if patch.BaseVersion != p.serverVersion {
+		return nil, fmt.Errorf("%w: server holds %d", ErrCapabilityConflict, p.serverVersion)
+	}
+	p.serverVersion = patch.Version
+	return &models.CapabilityAck{Version: patch.Version}, nil
+}
+
+func TestCapabilityProfileDiff(t *testing.T) {
+	base := models.CapabilityProfile{
+		"gpu":          json.RawMessage(`{"free":1}`),
+		"network":      json.RawMessage(`{"down_mbps":100}`),
+		"capabilities": json.RawMessage(`{"task_types":[{"type":"docker"}]}`),
+	}
+	tests := []struct {
+		name string
+		next models.CapabilityProfile
+		want map[string]json.RawMessage
+	}{
+		{"unchanged", base, map[string]json.RawMessage{}},
+		{"changed", models.CapabilityProfile{
+			"gpu":          json.RawMessage(`{"free":0}`),
+			"network":      json.RawMessage(`{"down_mbps":100}`),
+			"capabilities": json.RawMessage(`{"task_types":[{"type":"docker"}]}`),
+		}, map[string]json.RawMessage{"gpu": json.RawMessage(`{"free":0}`)}},
+		{"added and removed", models.CapabilityProfile{
+			"gpu":                json.RawMessage(`{"free":1}`),
+			"capabilities":       json.RawMessage(`{"task_types":[{"type":"docker"}]}`),
+			"model_capabilities": json.RawMessage(`[{"model_name":"llama3"}]`),
+		}, map[string]json.RawMessage{
+			"network":            json.RawMessage("null"),
+			"model_capabilities": json.RawMessage(`[{"model_name":"llama3"}]`),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := base.Diff(tt.next); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func startSyncer(t *testing.T, registrar *fakeRegistrar, patcher *fakePatcher) (*capabilitySyncer, *clocktest.Fake) {
+	t.Helper()
+	clk := clocktest.NewFake(time.Unix(0, 0))
+	syncer := newCapabilitySyncer(registrar, patcher, time.Second, clk)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		syncer.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return syncer, clk
+}
+
+func waitPatch(t *testing.T, patcher *fakePatcher) {
+	t.Helper()
+	select {
+	case <-patcher.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no capability patch sent")
+	}
+}
+
+func TestCapabilitySyncerDebouncesChanges(t *testing.T) {
+	registrar := &fakeRegistrar{}
+	registrar.set("gpu", `{"free":2}`)
+	registrar.set("network", `{"down_mbps":100}`)
+	if err := registrar.Register(); err != nil {
+		t.Fatal(err)
+	}
+	patcher := &fakePatcher{serverVersion: 1, done: make(chan struct{}, 4)}
+	syncer, clk := startSyncer(t, registrar, patcher)
+
+	bus := events.NewBus(16)
+	defer bus.Close()
+	syncer.Subscribe(bus)
+
+	registrar.set("gpu", `{"free":1}`)
+	bus.Publish(events.TaskClaimed{TaskID: "task-1"})
+	bus.Sync()
+	clk.BlockUntil(1)
+	clk.Advance(500 * time.Millisecond)
+
+	registrar.set("model_capabilities", `[{"model_name":"llama3"}]`)
+	bus.Publish(events.CapabilitiesChanged{Reason: "models"})
+	registrar.set("network", "")
+	bus.Publish(events.CapabilitiesChanged{Reason: "network"})
+	// Progress changes nothing the runner registered with
+	bus.Publish(events.TaskProgress{TaskID: "task-1"})
+	bus.Sync()
+
+	patcher.mu.Lock()
+	sent := len(patcher.patches)
+	patcher.mu.Unlock()
+	if sent != 0 {
+		t.Fatalf("%d patches sent within the debounce, want none", sent)
+	}
+
+	clk.Advance(500 * time.Millisecond)
+	waitPatch(t, patcher)
+
+	patcher.mu.Lock()
+	patch := patcher.patches[0]
+	patcher.mu.Unlock()
+	want := &models.CapabilityPatch{BaseVersion: 1, Version: 2, Fields: map[string]json.RawMessage{
+		"gpu":                json.RawMessage(`{"free":1}`),
+		"model_capabilities": json.RawMessage(`[{"model_name":"llama3"}]`),
+		"network":            json.RawMessage("null"),
+	}}
+	if !reflect.DeepEqual(patch, want) {
+		t.Fatalf("patch = %+v, want %+v", patch, want)
+	}
+
+	// The changes made within the debounce were sent together
+	clk.Advance(time.Minute)
+	select {
+	case <-patcher.done:
+		t.Fatal("changes sent twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The next patch starts from the version the server acknowledged, with
+	// only what changed since
+	registrar.set("gpu", `{"free":2}`)
+	syncer.Changed()
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	waitPatch(t, patcher)
+
+	patcher.mu.Lock()
+	patch = patcher.patches[1]
+	patcher.mu.Unlock()
+	want = &models.CapabilityPatch{BaseVersion: 2, Version: 3, Fields: map[string]json.RawMessage{
+		"gpu": json.RawMessage(`{"free":2}`),
+	}}
+	if !reflect.DeepEqual(patch, want) {
+		t.Fatalf("second patch = %+v, want %+v", patch, want)
+	}
+	if registrar.registrations != 1 {
+		t.Errorf("registered %d times, want only the first registration", registrar.registrations)
+	}
+}
+
+func TestCapabilitySyncerRegistersOnConflict(t *testing.T) {
+	registrar := &fakeRegistrar{}
+	registrar.set("gpu", `{"free":2}`)
+	if err := registrar.Register(); err != nil {
+		t.Fatal(err)
+	}
+	// The server lost the runner's profile and holds another version
+	patcher := &fakePatcher{serverVersion: 7, done: make(chan struct{}, 4)}
+	syncer, clk := startSyncer(t, registrar, patcher)
+
+	registrar.set("gpu", `{"free":0}`)
+	syncer.Changed()
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	waitPatch(t, patcher)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		version, acknowledged := registrar.AcknowledgedProfile()
+		if version == 2 {
+			if string(acknowledged["gpu"]) != `{"free":0}` {
+				t.Fatalf("registered with gpu %s, want the changed profile", acknowledged["gpu"])
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("runner did not register again after the conflict")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Patches then start from the version registered with
+	patcher.mu.Lock()
+	patcher.serverVersion = 2
+	patcher.mu.Unlock()
+	registrar.set("gpu", `{"free":1}`)
+	syncer.Changed()
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	waitPatch(t, patcher)
+
+	patcher.mu.Lock()
+	patch := patcher.patches[1]
+	patcher.mu.Unlock()
+	if patch.BaseVersion != 2 || patch.Version != 3 {
+		t.Errorf("patch after resync from %d to %d, want from 2 to 3", patch.BaseVersion, patch.Version)
+	}
+}
+
+func TestCapabilitySyncerRegistersWhenPatchesUnsupported(t *testing.T) {
+	registrar := &fakeRegistrar{}
+	registrar.set("gpu", `{"free":2}`)
+	if err := registrar.Register(); err != nil {
+		t.Fatal(err)
+	}
+	patcher := &fakePatcher{err: ErrCapabilityPatchUnsupported, done: make(chan struct{}, 4)}
+	syncer := newCapabilitySyncer(registrar, patcher, time.Second, clocktest.NewFake(time.Unix(0, 0)))
+
+	registrar.set("gpu", `{"free":1}`)
+	if err := syncer.sync(); err != nil {
+		t.Fatal(err)
+	}
+	registrar.set("gpu", `{"free":0}`)
+	if err := syncer.sync(); err != nil {
+		t.Fatal(err)
+	}
+	if len(patcher.patches) != 1 {
+		t.Errorf("%d patches sent, want none after the server refused the first", len(patcher.patches))
+	}
+	if registrar.registrations != 3 {
+		t.Errorf("registered %d times, want 3: at first and once per change", registrar.registrations)
+	}
+}
+
+func TestCapabilitySyncerRetriesFailedPatches(t *testing.T) {
+	registrar := &fakeRegistrar{}
+	registrar.set("gpu", `{"free":2}`)
+	if err := registrar.Register(); err != nil {
+		t.Fatal(err)
+	}
+	patcher := &fakePatcher{serverVersion: 1, err: errors.New("connection refused"), done: make(chan struct{}, 4)}
+	syncer, clk := startSyncer(t, registrar, patcher)
+
+	registrar.set("gpu", `{"free":1}`)
+	syncer.Changed()
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	waitPatch(t, patcher)
+
+	patcher.mu.Lock()
+	patcher.err = nil
+	patcher.mu.Unlock()
+	// Retried after the backoff, then the debounce
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	clk.BlockUntil(1)
+	clk.Advance(time.Second)
+	waitPatch(t, patcher)
+
+	if version, _ := registrar.AcknowledgedProfile(); version != 2 {
+		t.Errorf("acknowledged version %d after retry, want 2", version)
+	}
+}
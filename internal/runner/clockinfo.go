@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// clockReporter describes the host clock in task results: its timezone and
+// the offset from an NTP server last measured
+type clockReporter struct {
+	server   string
+	interval time.Duration
+	clock    clock.Clock
+	measure  func(ctx context.Context, server string) (time.Duration, error)
+
+	mu         sync.Mutex
+	offset     *time.Duration
+	measuredAt time.Time
+}
+
+// newClockReporter returns a reporter measuring the offset from server every
+// interval. With server "none" results carry the timezone alone.
+func newClockReporter(server string, interval time.Duration, clk clock.Clock) *clockReporter {
+	if server == "none" {
+		server = ""
+	} else if server == "" {
+		server = clock.DefaultNTPServer
+	}
+	return &clockReporter{
+		server:   server,
+		interval: interval,
+		clock:    clk,
+		measure:  clock.NTPOffset,
+	}
+}
+
+// Run measures the NTP offset now and then every interval until ctx is done
+func (r *clockReporter) Run(ctx context.Context) {
+	if r.server == "" {
+		return
+	}
+	r.measureOnce(ctx)
+	if r.interval <= 0 {
+		return
+	}
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			r.measureOnce(ctx)
+		}
+	}
+}
+
+func (r *clockReporter) measureOnce(ctx context.Context) {
+	offset, err := r.measure(ctx, r.server)
+	if err != nil {
+		log := gologger.WithComponent("clock")
+		log.Debug().Err(err).Str("server", r.server).Msg("Failed to measure NTP offset")
+		return
+	}
+	r.mu.Lock()
+	r.offset = &offset
+	r.measuredAt = r.clock.Now()
+	r.mu.Unlock()
+}
+
+// info describes the clock as it reads at now. A nil reporter describes
+// the timezone alone.
+func (r *clockReporter) info(now time.Time) *models.ClockInfo {
+	zone, offset := now.Local().Zone()
+	info := &models.ClockInfo{Timezone: zone, UTCOffsetSeconds: offset}
+	if r == nil {
+		return info
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.offset != nil {
+		ms := r.offset.Milliseconds()
+		info.NTPOffsetMs = &ms
+		info.NTPServer = r.server
+		info.NTPMeasuredAt = r.measuredAt
+	}
+	return info
+}
+
+// SetClockReporter reports the host clock as reporter describes it with
+// every result
+func (h *DefaultTaskHandler) SetClockReporter(reporter *clockReporter) {
+	h.clockInfo = reporter
+}
+
+// stampResult records when and for how long result's execution ran: its
+// duration on the monotonic clock and its completion as the start plus that
+// duration, so a wall clock stepped mid-task cannot make a task complete
+// before it started. It also records the build that ran it.
+func (h *DefaultTaskHandler) stampResult(result *models.TaskResult, run clock.Stopwatch) {
+	result.Duration = run.Elapsed()
+	result.CreatedAt = run.StartedAt().Add(result.Duration)
+	result.Clock = h.clockInfo.info(result.CreatedAt)
+	result.BuildFingerprint = h.buildFingerprint
+}
@@ -0,0 +1,113 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/history"
+)
+
+// resultClient keeps the results reported to it
+type resultClient struct {
+	fakeFLClient
+	resultsMu sync.Mutex
+	results   []*models.TaskResult
+}
+
+func (c *resultClient) UpdateTaskStatus(taskID string, status models.TaskStatus, result *models.TaskResult) error {
+	c.resultsMu.Lock()
+	c.results = append(c.results, result)
+	c.resultsMu.Unlock()
+	return c.fakeFLClient.UpdateTaskStatus(taskID, status, result)
+}
+
+// steppingExecutor runs for a minute of fake time during which the wall
+// clock is stepped back an hour, as an NTP correction or a DST change
+// handled badly would
+type steppingExecutor struct {
+	clk *clocktest.Fake
+}
+
+func (e *steppingExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	e.clk.Advance(40 * time.Second)
+	e.clk.Step(-time.Hour)
+	e.clk.Advance(20 * time.Second)
+	return &models.TaskResult{TaskID: task.ID, Output: "{}", CreatedAt: e.clk.Now()}, nil
+}
+
+func TestResultDurationSurvivesWallClockSteps(t *testing.T) {
+	start := time.Date(2026, 10, 25, 2, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+	clk := clocktest.NewFake(start)
+	store, err := history.NewStore(filepath.Join(t.TempDir(), historyFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &resultClient{}
+	h := NewTaskHandler(&steppingExecutor{clk: clk}, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	h.SetClock(clk)
+	h.SetHistory(store, nil)
+	reporter := newClockReporter("ntp.example.com", time.Hour, clk)
+	reporter.measure = func(context.Context, string) (time.Duration, error) { return 250 * time.Millisecond, nil }
+	reporter.measureOnce(context.Background())
+	h.SetClockReporter(reporter)
+
+	task := newDockerTask(t)
+	if err := h.HandleTask(task); err != nil {
+		t.Fatalf("HandleTask() error = %v", err)
+	}
+	result := client.results[len(client.results)-1]
+	if result == nil || client.statuses[len(client.statuses)-1] != models.TaskStatusCompleted {
+		t.Fatalf("reported %v with %+v, want a completed result", client.statuses, result)
+	}
+	if result.Duration != time.Minute {
+		t.Errorf("Duration = %s, want 1m despite the wall clock stepping back an hour", result.Duration)
+	}
+	if !result.CreatedAt.Equal(start.Add(time.Minute)) {
+		t.Errorf("CreatedAt = %s, want a minute after the start at %s", result.CreatedAt, start)
+	}
+	if result.Clock == nil || result.Clock.NTPOffsetMs == nil || *result.Clock.NTPOffsetMs != 250 || result.Clock.NTPServer != "ntp.example.com" {
+		t.Errorf("Clock = %+v, want the measured NTP offset", result.Clock)
+	}
+
+	records, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var executions []history.Record
+	for _, record := range records {
+		if record.Event == "" {
+			executions = append(executions, record)
+		}
+	}
+	if len(executions) != 1 || executions[0].DurationMs != time.Minute.Milliseconds() {
+		t.Fatalf("history = %+v, want one execution of 60000ms", records)
+	}
+
+	raw, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wire struct {
+		CreatedAt  string `json:"created_at"`
+		DurationNs int64  `json:"duration_ns"`
+	}
+	if err := json.Unmarshal(raw, &wire); err != nil {
+		t.Fatal(err)
+	}
+	if wire.CreatedAt != "2026-10-25T00:31:00Z" || wire.DurationNs != int64(time.Minute) {
+		t.Errorf("serialized created_at %s and duration_ns %d, want UTC and 1m", wire.CreatedAt, wire.DurationNs)
+	}
+	if raw, _ := json.Marshal(task); !strings.Contains(string(raw), `"completed_at":null`) {
+		t.Errorf("task serialized as %s", raw)
+	}
+}
@@ -0,0 +1,139 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/concurrency"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+// pressureRoot holds the host's pressure stall information
+const pressureRoot = "/proc/pressure"
+
+// newConcurrency returns the controller cfg describes, never letting more
+// tasks run than profile can reserve docker's limits for
+func newConcurrency(cfg config.ConcurrencyConfig, docker config.DockerConfig, profile *hardware.Profile) (*concurrency.Controller, error) {
+	ceiling, err := reservationCeiling(docker, profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure concurrency: %w", err)
+	}
+	limits := concurrency.Config{
+		Initial:   min(cfg.Initial, ceiling),
+		Min:       min(cfg.Min, ceiling),
+		Max:       ceiling,
+		Pinned:    cfg.Pinned,
+		Backoff:   cfg.Backoff,
+		SlowRatio: cfg.SlowRatio,
+		Pressure:  cfg.Pressure,
+		Cooldown:  cfg.Cooldown,
+	}
+	if cfg.Max > 0 {
+		limits.Max = min(cfg.Max, ceiling)
+	}
+	controller, err := concurrency.New(limits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure concurrency: %w", err)
+	}
+	return controller, nil
+}
+
+// reservationCeiling returns how many tasks the host can reserve docker's
+// memory and CPU limits for at once. Without a CPU limit each task is
+// counted as a core; at least one task always runs.
+func reservationCeiling(docker config.DockerConfig, profile *hardware.Profile) (int, error) {
+	ceiling := profile.CPUCores
+	if docker.CPULimit != "" {
+		cpus, err := strconv.ParseFloat(docker.CPULimit, 64)
+		if err != nil || cpus <= 0 {
+			return 0, fmt.Errorf("invalid docker CPU limit %q", docker.CPULimit)
+		}
+		ceiling = int(float64(profile.CPUCores) / cpus)
+	}
+	if docker.MemoryLimit != "" && profile.TotalMemoryBytes > 0 {
+		memory, err := gpu.ParseBytes(docker.MemoryLimit)
+		if err != nil {
+			return 0, fmt.Errorf("invalid docker memory limit: %w", err)
+		}
+		if memory > 0 {
+			ceiling = min(ceiling, int(profile.TotalMemoryBytes/memory))
+		}
+	}
+	return max(ceiling, 1), nil
+}
+
+// SetConcurrency lets controller decide how many tasks run at once.
+// Without one a task runs alone unless it shares a GPU.
+func (h *DefaultTaskHandler) SetConcurrency(controller *concurrency.Controller) {
+	h.concurrency = controller
+}
+
+// observeConcurrency tells the controller how long task ran against how
+// long tasks like it usually run, while it still counts as in flight
+func (h *DefaultTaskHandler) observeConcurrency(task *models.Task, started time.Time, duration time.Duration, code models.FailureCode) {
+	if h.concurrency == nil {
+		return
+	}
+	expected, _ := h.timeouts.Typical(task)
+	h.concurrency.Observe(concurrency.Sample{
+		Started:  started,
+		Duration: duration,
+		Expected: expected,
+		InFlight: h.tasksInFlight(),
+		TimedOut: code == models.FailureTimeout,
+	})
+}
+
+// WatchConcurrency tells the controller how saturated the host is every
+// interval until ctx ends: the pressure stall information of its CPU,
+// memory and IO and, with power-aware scheduling, whether it is throttled
+func (h *DefaultTaskHandler) WatchConcurrency(ctx context.Context, interval time.Duration) {
+	if h.concurrency == nil {
+		return
+	}
+	ticker := h.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		var signals concurrency.Signals
+		if pressure, err := concurrency.ReadPressure(pressureRoot); err == nil {
+			signals.Pressure = pressure
+		}
+		if h.power != nil {
+			if state := h.power.State(); state != nil {
+				signals.Throttled = state.Throttled
+			}
+		}
+		h.concurrency.Sense(signals)
+	}
+}
+
+// Drain stops the handler from accepting new tasks and waits for those in
+// flight to be reported, until ctx ends. It returns how many are still
+// running then; they are left to the lease they hold, to be resumed or
+// abandoned by the next run.
+func (h *DefaultTaskHandler) Drain(ctx context.Context) int {
+	h.SetDraining(true)
+
+	ticker := h.clock.NewTicker(handoffPollInterval)
+	defer ticker.Stop()
+
+	for h.isProcessing.Load() {
+		select {
+		case <-ctx.Done():
+			return h.tasksInFlight()
+		case <-ticker.C():
+		}
+	}
+	return 0
+}
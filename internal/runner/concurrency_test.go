@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/concurrency"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+func TestConcurrencyAdmitsUpToLimit(t *testing.T) {
+	executor := newGPUExecutor()
+	h := NewTaskHandler(executor, &fakeFLClient{})
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	controller, err := concurrency.New(concurrency.Config{Initial: 2, Min: 1, Max: 4, Backoff: 0.5, SlowRatio: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetConcurrency(controller)
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- h.HandleTask(newDockerTask(t)) }()
+		<-executor.started
+	}
+	var admission *admissionError
+	if err := h.HandleTask(newDockerTask(t)); !errors.As(err, &admission) || admission.skip != models.SkipTaskInProgress {
+		t.Fatalf("HandleTask() error = %v, want task_in_progress beyond the limit", err)
+	}
+
+	close(executor.release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("HandleTask() error = %v", err)
+		}
+	}
+	// Both tasks ran at the limit without slowing down, so it was raised
+	if got := controller.Limit(); got != 3 {
+		t.Errorf("Limit() = %d after a healthy round at the limit, want 3", got)
+	}
+}
+
+func TestReservationCeiling(t *testing.T) {
+	profile := &hardware.Profile{CPUCores: 16, TotalMemoryBytes: 32 * gib}
+	tests := []struct {
+		name   string
+		docker config.DockerConfig
+		want   int
+	}{
+		{"a core per task without limits", config.DockerConfig{}, 16},
+		{"cpu bound", config.DockerConfig{CPULimit: "4"}, 4},
+		{"memory bound", config.DockerConfig{CPULimit: "1", MemoryLimit: "6g"}, 5},
+		{"fractional cpus", config.DockerConfig{CPULimit: "0.5", MemoryLimit: "1g"}, 32},
+		{"at least one", config.DockerConfig{MemoryLimit: "64g"}, 1},
+	}
+	for _, tt := range tests {
+		if got, err := reservationCeiling(tt.docker, profile); err != nil || got != tt.want {
+			t.Errorf("%s: reservationCeiling() = %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := reservationCeiling(config.DockerConfig{CPULimit: "lots"}, profile); err == nil {
+		t.Error("reservationCeiling() accepted an invalid CPU limit")
+	}
+
+	// The configured bounds never let more tasks run than the host reserves
+	controller, err := newConcurrency(config.ConcurrencyConfig{Initial: 8, Min: 1, Max: 12, Backoff: 0.7, SlowRatio: 1.5}, config.DockerConfig{CPULimit: "4"}, profile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := controller.Status(); status.Limit != 4 || status.Max != 4 {
+		t.Errorf("status = %+v, want the limit and maximum held to 4", status)
+	}
+}
+
+func TestDrainWaitsForTasksInFlight(t *testing.T) {
+	executor := newGPUExecutor()
+	h := NewTaskHandler(executor, &fakeFLClient{})
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+
+	done := make(chan error, 1)
+	go func() { done <- h.HandleTask(newDockerTask(t)) }()
+	<-executor.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if running := h.Drain(ctx); running != 1 {
+		t.Fatalf("Drain() past its deadline = %d, want the running task left", running)
+	}
+	var admission *admissionError
+	if err := h.HandleTask(newDockerTask(t)); !errors.As(err, &admission) {
+		t.Fatalf("HandleTask() while draining = %v, want it declined", err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(executor.release)
+	}()
+	if running := h.Drain(context.Background()); running != 0 {
+		t.Fatalf("Drain() = %d, want every task finished", running)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("HandleTask() error = %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/confirm"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// newConfirmGate returns the gate interactive mode describes, or nil when
+// the runner is not interactive
+func newConfirmGate(cfg config.InteractiveConfig, clk clock.Clock) (*confirm.Gate, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	maxDisk, err := gpu.ParseBytes(cfg.MaxDisk)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interactive disk threshold %q: %w", cfg.MaxDisk, err)
+	}
+
+	var prompter confirm.Prompter
+	switch cfg.Prompt {
+	case "terminal":
+		prompter = confirm.NewTerminal(os.Stdin, os.Stdout)
+	case "hook":
+		if prompter, err = confirm.NewHook(strings.Fields(cfg.HookCommand)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown confirmation prompt %q (supported: terminal, hook)", cfg.Prompt)
+	}
+
+	gate := confirm.NewGate(prompter, confirm.Thresholds{
+		Duration:  cfg.MaxDuration,
+		DiskBytes: int64(min(maxDisk, 1<<62)),
+		GPU:       cfg.ConfirmGPU,
+	}, cfg.Timeout)
+	gate.SetClock(clk)
+	return gate, nil
+}
+
+// SetConfirmation asks gate's operator before claiming tasks above its
+// thresholds. Only the task asked about waits for the answer.
+func (h *DefaultTaskHandler) SetConfirmation(gate *confirm.Gate) {
+	h.confirm = gate
+}
+
+// confirmTask declines tasks above the confirmation thresholds that the
+// operator does not approve. Forcing, draining and pausing skip the
+// question.
+func (h *DefaultTaskHandler) confirmTask(task *models.Task) *admissionError {
+	if h.confirm == nil || h.force || h.draining.Load() || h.paused.Load() {
+		return nil
+	}
+	estimate := h.estimateCost(task)
+	reasons := h.confirm.Needs(estimate)
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	log := gologger.WithComponent("task_handler")
+	log.Info().
+		Str("id", task.ID.String()).
+		Str("type", string(task.Type)).
+		Strs("reasons", reasons).
+		Msg("Waiting for operator confirmation")
+
+	askedAt := h.clock.Now()
+	result, err := h.confirm.Confirm(context.Background(), confirm.Request{
+		TaskID:   task.ID.String(),
+		Type:     task.Type,
+		Title:    task.Title,
+		Reward:   task.Reward,
+		Estimate: estimate,
+		Reasons:  reasons,
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("id", task.ID.String()).Msg("Confirmation prompt failed, denying task")
+	}
+	h.recordConfirmation(task, askedAt, result, err)
+
+	if result.Decision == confirm.Approved {
+		return nil
+	}
+	return &admissionError{reason: models.FLDeclineNotConfirmed, err: fmt.Errorf("%w: %s after %s", confirm.ErrNotConfirmed, result.Decision, result.Latency.Round(time.Millisecond))}
+}
+
+// recordConfirmation keeps the operator's decision on task in the history
+func (h *DefaultTaskHandler) recordConfirmation(task *models.Task, askedAt time.Time, result confirm.Result, promptErr error) {
+	if h.history == nil {
+		return
+	}
+	record := history.Record{
+		TaskID:     task.ID.String(),
+		Type:       task.Type,
+		Workload:   workloadKey(task),
+		StartedAt:  askedAt,
+		DurationMs: result.Latency.Milliseconds(),
+		Event:      history.EventConfirmation,
+		Decision:   string(result.Decision),
+		Instance:   h.instanceName(),
+	}
+	if promptErr != nil {
+		record.Error = promptErr.Error()
+	}
+	if err := h.history.Append(record); err != nil {
+		log := gologger.WithComponent("task_handler")
+		log.Warn().Err(err).Str("id", task.ID.String()).Msg("Failed to record confirmation decision")
+	}
+}
+
+// estimateCost estimates what task will take: its duration from the median
+// of past runs of its workload or else its declared timeout, the disk its
+// declared inputs need, and whether it uses a GPU
+func (h *DefaultTaskHandler) estimateCost(task *models.Task) confirm.Estimate {
+	estimate := confirm.Estimate{GPU: h.usesGPU(task)}
+
+	var config models.TaskConfig
+	if len(task.Config) > 0 && safejson.Unmarshal(task.Config, &config) != nil {
+		return estimate
+	}
+	for _, input := range config.Inputs {
+		size := max(input.Size, 0)
+		if !input.ReadOnly() {
+			// The task's private copy
+			size = min(size, math.MaxInt64/2) * 2
+		}
+		estimate.DiskBytes = min(estimate.DiskBytes, math.MaxInt64-size) + size
+	}
+
+	if h.history != nil {
+		durations, err := h.history.Durations(task.Type, workloadKey(task), timeoutHistoryWindow)
+		if err == nil && len(durations) > 0 {
+			estimate.Duration = percentile(durations, 50)
+			return estimate
+		}
+	}
+	if timeout, err := time.ParseDuration(config.Resources.Timeout); err == nil && timeout > 0 {
+		estimate.Duration = h.timeouts.ceil(timeout)
+	}
+	return estimate
+}
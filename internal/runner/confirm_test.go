@@ -0,0 +1,181 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/confirm"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/history"
+)
+
+// scriptedPrompt answers every prompt with approve once release is closed,
+// or never when release is nil
+type scriptedPrompt struct {
+	approve bool
+	release chan struct{}
+	asked   chan confirm.Request
+}
+
+func (p *scriptedPrompt) Prompt(ctx context.Context, req confirm.Request) (bool, error) {
+	p.asked <- req
+	select {
+	case <-p.release:
+		return p.approve, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// newConfirmHandler returns a handler asking prompt about tasks needing more
+// than 1 MiB of disk
+func newConfirmHandler(t *testing.T, prompt *scriptedPrompt) (*DefaultTaskHandler, *fakeFLClient, *countingExecutor, *history.Store, *clocktest.Fake) {
+	t.Helper()
+	store, err := history.NewStore(filepath.Join(t.TempDir(), historyFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clk := clocktest.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	gate := confirm.NewGate(prompt, confirm.Thresholds{DiskBytes: 1 << 20}, 2*time.Minute)
+	gate.SetClock(clk)
+
+	client := &fakeFLClient{}
+	executor := &countingExecutor{}
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	h.SetHistory(store, nil)
+	h.SetConfirmation(gate)
+	return h, client, executor, store, clk
+}
+
+// newLargeInputTask returns a Docker task whose input needs size bytes
+func newLargeInputTask(t *testing.T, size int64) *models.Task {
+	t.Helper()
+	raw, err := json.Marshal(models.TaskConfig{
+		ImageName: "alpine",
+		Inputs: []models.TaskInput{{
+			Name:       "weights",
+			Source:     models.InputSource{URL: "https://example.com/weights.bin"},
+			Size:       size,
+			TargetPath: "/data/weights.bin",
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker, Nonce: "abcdef", Config: raw}
+}
+
+func confirmations(t *testing.T, store *history.Store) []history.Record {
+	t.Helper()
+	records, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found []history.Record
+	for _, record := range records {
+		if record.Event == history.EventConfirmation {
+			found = append(found, record)
+		}
+	}
+	return found
+}
+
+func TestConfirmationDecisions(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		approve  bool
+		decision confirm.Decision
+	}{
+		{"approve", true, confirm.Approved},
+		{"deny", false, confirm.Denied},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			prompt := &scriptedPrompt{approve: tt.approve, release: make(chan struct{}), asked: make(chan confirm.Request, 1)}
+			h, client, executor, store, clk := newConfirmHandler(t, prompt)
+
+			task := newLargeInputTask(t, 10<<20)
+			done := make(chan error, 1)
+			go func() { done <- h.HandleTask(task) }()
+			req := <-prompt.asked
+			if req.TaskID != task.ID.String() || req.Estimate.DiskBytes != 10<<20 || len(req.Reasons) != 1 {
+				t.Errorf("asked %+v", req)
+			}
+			clk.BlockUntil(1)
+			clk.Advance(15 * time.Second)
+			close(prompt.release)
+
+			err := <-done
+			var admission *admissionError
+			if tt.approve {
+				if err != nil || executor.calls != 1 || len(client.statuses) == 0 {
+					t.Fatalf("HandleTask() = %v with %d executions, want the approved task run", err, executor.calls)
+				}
+			} else if !errors.As(err, &admission) || admission.reason != models.FLDeclineNotConfirmed || executor.calls != 0 || len(client.statuses) != 0 {
+				t.Fatalf("HandleTask() = %v with %d executions, want the denied task skipped", err, executor.calls)
+			}
+
+			records := confirmations(t, store)
+			if len(records) != 1 || records[0].Decision != string(tt.decision) || records[0].Duration() != 15*time.Second || records[0].TaskID != task.ID.String() {
+				t.Fatalf("confirmation records = %+v, want %s after 15s", records, tt.decision)
+			}
+		})
+	}
+}
+
+func TestConfirmationTimesOut(t *testing.T) {
+	prompt := &scriptedPrompt{asked: make(chan confirm.Request, 1)}
+	h, client, executor, store, clk := newConfirmHandler(t, prompt)
+
+	flConfig := flConfig()
+	flConfig["inputs"] = []map[string]interface{}{{"name": "data", "source": map[string]string{"url": "https://example.com/data.csv"}, "size": 10 << 20, "target_path": "/data/data.csv"}}
+	done := make(chan error, 1)
+	go func() { done <- h.HandleTask(newFLTask(t, flConfig)) }()
+	<-prompt.asked
+	clk.BlockUntil(1)
+	clk.Advance(2 * time.Minute)
+
+	if err := <-done; !errors.Is(err, ErrRoundDeclined) {
+		t.Fatalf("HandleTask() error = %v, want ErrRoundDeclined", err)
+	}
+	if len(client.acks) != 1 || client.acks[0].Accepted || client.acks[0].Reason != models.FLDeclineNotConfirmed {
+		t.Fatalf("acks = %+v, want a not_confirmed decline", client.acks)
+	}
+	if executor.calls != 0 {
+		t.Fatal("unanswered round was trained")
+	}
+	if records := confirmations(t, store); len(records) != 1 || records[0].Decision != string(confirm.TimedOut) {
+		t.Fatalf("confirmation records = %+v, want a timeout", records)
+	}
+}
+
+func TestConfirmationDoesNotBlockCheapTasks(t *testing.T) {
+	prompt := &scriptedPrompt{asked: make(chan confirm.Request, 1)}
+	h, _, executor, store, clk := newConfirmHandler(t, prompt)
+
+	pending := make(chan error, 1)
+	go func() { pending <- h.HandleTask(newLargeInputTask(t, 10<<20)) }()
+	<-prompt.asked
+
+	if err := h.HandleTask(newLargeInputTask(t, 1<<10)); err != nil {
+		t.Fatalf("HandleTask() error = %v for a task below the thresholds", err)
+	}
+	if executor.calls != 1 {
+		t.Fatalf("cheap task ran %d times while another awaited confirmation", executor.calls)
+	}
+	if records := confirmations(t, store); len(records) != 0 {
+		t.Fatalf("cheap task recorded confirmations %+v", records)
+	}
+
+	clk.BlockUntil(1)
+	clk.Advance(2 * time.Minute)
+	<-pending
+}
@@ -0,0 +1,53 @@
+package runner
+
+import (
+	"errors"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+// CustomModelChecker is implemented by executors that load models LLM
+// tasks supply and can tell before a task is claimed whether its model can
+// be loaded
+type CustomModelChecker interface {
+	CheckCustomModel(task *models.Task) error
+}
+
+// admitCustomModel skips LLM tasks supplying a model the runner does not
+// load or that does not fit in its model memory
+func (h *DefaultTaskHandler) admitCustomModel(task *models.Task) *admissionError {
+	checker, ok := h.executor.(CustomModelChecker)
+	if !ok {
+		return nil
+	}
+	err := checker.CheckCustomModel(task)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, llm.ErrCustomModelsDisabled):
+		return &admissionError{reason: models.FLDeclineUnsupportedType, err: err}
+	case errors.Is(err, llm.ErrModelTooLarge):
+		return &admissionError{reason: models.FLDeclineInsufficientResources, err: err}
+	default:
+		return &admissionError{reason: models.FLDeclineInvalidConfig, err: err}
+	}
+}
+
+// modelMemoryBudget returns the memory available for model weights: the
+// host's, or the most VRAM free on one of allocator's GPUs when that is
+// more
+func modelMemoryBudget(allocator *gpu.Allocator) func() uint64 {
+	return func() uint64 {
+		budget := hardware.Detect().ModelMemoryBudget()
+		for _, device := range allocator.Snapshot().Devices {
+			taken := device.UsedBytes + device.ReservedBytes
+			if taken < device.TotalBytes {
+				budget = max(budget, device.TotalBytes-taken)
+			}
+		}
+		return budget
+	}
+}
@@ -0,0 +1,72 @@
+package runner
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+	"github.com/theblitlabs/parity-runner/internal/power"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// modelExecutor checks the models LLM tasks supply against loader, as the
+// task executor does; without a loader it loads none
+type modelExecutor struct {
+	countingExecutor
+	loader *llm.ModelLoader
+}
+
+func (e *modelExecutor) CheckCustomModel(task *models.Task) error {
+	var config struct {
+		ModelArtifact *llm.ModelArtifact `json:"model_artifact"`
+	}
+	if err := safejson.Unmarshal(task.Config, &config); err != nil || config.ModelArtifact == nil {
+		return err
+	}
+	if e.loader == nil {
+		return llm.ErrCustomModelsDisabled
+	}
+	return e.loader.Fits(config.ModelArtifact.Size)
+}
+
+func TestCustomModelAdmission(t *testing.T) {
+	executor := &modelExecutor{}
+	h, _, client := newPowerHandler(executor, power.InFlightContinue)
+	sha := strings.Repeat("ab", 32)
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeLLM, Nonce: "abcdef", Config: []byte(`{"prompt": "hi", "model_artifact": {
+		"source": {"url": "https://models.example.com/m.gguf"}, "sha256": "` + sha + `", "size": 4000000000, "quantization": "Q4_K_M"}}`)}
+
+	var admission *admissionError
+	if err := h.HandleTask(task); !errors.As(err, &admission) || admission.reason != models.FLDeclineUnsupportedType {
+		t.Fatalf("HandleTask() error = %v, want an unsupported_type skip without custom models", err)
+	}
+
+	executor.loader = llm.NewModelLoader("", nil)
+	executor.loader.SetMemoryBudget(func() uint64 { return 2 << 30 })
+	if err := h.HandleTask(task); !errors.As(err, &admission) || admission.reason != models.FLDeclineInsufficientResources || !errors.Is(err, llm.ErrModelTooLarge) {
+		t.Fatalf("HandleTask() error = %v, want an insufficient_resources skip for a model larger than memory", err)
+	}
+	if executor.calls != 0 || len(client.statuses) != 0 {
+		t.Fatal("task supplying a model the runner cannot load was claimed")
+	}
+
+	executor.loader.SetMemoryBudget(func() uint64 { return 8 << 30 })
+	if err := h.HandleTask(task); errors.As(err, &admission) || executor.calls != 1 {
+		t.Fatalf("HandleTask() error = %v, want the task run", err)
+	}
+
+	// While it runs the task holds both the loaded model and its download
+	refs := cacheRefs(task)
+	want := []caches.Ref{
+		{Cache: caches.Models, Key: "parity-gguf-" + sha[:16] + ":latest"},
+		{Cache: caches.Inputs, Key: "sha256-" + sha},
+	}
+	if len(refs) != len(want) || refs[0] != want[0] || refs[1] != want[1] {
+		t.Errorf("cacheRefs() = %v, want %v", refs, want)
+	}
+}
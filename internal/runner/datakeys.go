@@ -0,0 +1,191 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/accounting"
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/audit"
+	"github.com/theblitlabs/parity-runner/internal/escrow"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+const (
+	dataKeyFileName = "datakeys.json"
+	historyFileName = "history.jsonl"
+	leaseDirName    = "leases"
+	// artifactDirName holds the artifacts of tasks the runner ran, by task
+	// ID, which dependent tasks are served from
+	artifactDirName = "artifacts"
+	// artifactCacheFileName maps uploaded blob digests to their CIDs
+	artifactCacheFileName = "artifacts/blobs.json"
+)
+
+func parityDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(homeDir, utils.KeystoreDirName), nil
+}
+
+// openDataKeyring unlocks the data keys protecting runner-local stores. It
+// returns nil without error when no secret is available and no data was ever
+// encrypted, in which case stores remain plaintext. Once a key file exists a
+// missing secret is an error, since the stores can no longer be read.
+func openDataKeyring(dir string) (*atrest.Keyring, error) {
+	log := gologger.WithComponent("runner")
+
+	path := filepath.Join(dir, dataKeyFileName)
+	_, statErr := os.Stat(path)
+	exists := statErr == nil
+
+	secret, source, err := atrest.ResolveSecret(!exists)
+	if err != nil {
+		if !exists {
+			log.Warn().
+				Err(err).
+				Msg("Runner-local data is stored unencrypted - set " + atrest.PassphraseEnv + " to enable encryption")
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to obtain data key secret: %w", err)
+	}
+
+	keyring, err := atrest.OpenKeyring(path, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock data keys in %s: %w", path, err)
+	}
+
+	log.Info().
+		Str("secret_source", source).
+		Str("active_key", keyring.ActiveKeyID()).
+		Msg("Runner-local data is encrypted at rest")
+	return keyring, nil
+}
+
+// sealStores attaches keyring to the stores and reseals any plaintext or
+// stale-key contents, which migrates stores written before encryption
+func sealStores(keyring *atrest.Keyring, historyStore *history.Store, leaseStore *LeaseStore, bundles *audit.BundleStore, outbox *retention.Outbox, journal *accounting.Journal, escrows *escrow.Store) error {
+	if historyStore != nil {
+		historyStore.SetCodec(keyring)
+		if _, err := historyStore.Reseal(); err != nil {
+			return fmt.Errorf("failed to encrypt task history: %w", err)
+		}
+	}
+	if leaseStore != nil {
+		leaseStore.SetCodec(keyring)
+		if _, err := leaseStore.Reseal(); err != nil {
+			return fmt.Errorf("failed to encrypt task leases: %w", err)
+		}
+	}
+	if bundles != nil {
+		bundles.SetCodec(keyring)
+		if _, err := bundles.Reseal(); err != nil {
+			return fmt.Errorf("failed to encrypt replay bundles: %w", err)
+		}
+	}
+	if outbox != nil {
+		outbox.SetCodec(keyring)
+		if _, err := outbox.Reseal(); err != nil {
+			return fmt.Errorf("failed to encrypt task results: %w", err)
+		}
+	}
+	if journal != nil {
+		journal.SetCodec(keyring)
+		if _, err := journal.Reseal(); err != nil {
+			return fmt.Errorf("failed to encrypt task ledger: %w", err)
+		}
+	}
+	if escrows != nil {
+		escrows.SetCodec(keyring)
+		if _, err := escrows.Reseal(); err != nil {
+			return fmt.Errorf("failed to encrypt escrow records: %w", err)
+		}
+	}
+	return nil
+}
+
+// finishMigration records that every store of the process has been sealed,
+// after which plaintext is rejected. Instances seal their own stores, so it
+// runs once all of them are built.
+func finishMigration(keyring *atrest.Keyring) error {
+	if keyring == nil {
+		return nil
+	}
+	if err := keyring.SetMigrated(true); err != nil {
+		return fmt.Errorf("failed to record data key migration: %w", err)
+	}
+	return nil
+}
+
+// RotateDataKey generates a new data key, re-encrypts every runner-local
+// store with it and then discards the previous keys. The runner must be
+// stopped while rotating.
+func RotateDataKey() (string, error) {
+	dir, err := parityDir()
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(dir, dataKeyFileName)); err != nil {
+		return "", fmt.Errorf("no data keys found in %s: %w", dir, err)
+	}
+
+	keyring, err := openDataKeyring(dir)
+	if err != nil {
+		return "", err
+	}
+
+	historyStore, err := history.NewStore(filepath.Join(dir, historyFileName))
+	if err != nil {
+		return "", err
+	}
+	leaseStore, err := NewLeaseStore(filepath.Join(dir, leaseDirName))
+	if err != nil {
+		return "", err
+	}
+	bundles, err := audit.NewBundleStore(filepath.Join(dir, auditDirName, auditBundlesDirName), audit.Retention{})
+	if err != nil {
+		return "", err
+	}
+	outbox, err := retention.NewOutbox(filepath.Join(dir, outboxDirName))
+	if err != nil {
+		return "", err
+	}
+	journals, err := accounting.OpenAll(filepath.Join(dir, accountingDirName))
+	if err != nil {
+		return "", err
+	}
+	escrows, err := openEscrowStore(dir)
+	if err != nil {
+		return "", err
+	}
+
+	keyID, err := keyring.Rotate()
+	if err != nil {
+		return "", fmt.Errorf("failed to rotate data key: %w", err)
+	}
+	// Previous keys are only discarded once every store has been re-encrypted,
+	// so a failed rotation leaves all data readable
+	if err := sealStores(keyring, historyStore, leaseStore, bundles, outbox, journals[0], escrows); err != nil {
+		return "", err
+	}
+	// Each instance keeps its own ledger below the primary's
+	for _, journal := range journals[1:] {
+		if err := sealStores(keyring, nil, nil, nil, nil, journal, nil); err != nil {
+			return "", err
+		}
+	}
+	if err := finishMigration(keyring); err != nil {
+		return "", err
+	}
+	if err := keyring.PruneInactive(); err != nil {
+		return "", fmt.Errorf("failed to discard previous data keys: %w", err)
+	}
+	return keyID, nil
+}
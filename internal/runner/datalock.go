@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/datalock"
+	"github.com/theblitlabs/parity-runner/internal/provenance"
+	"github.com/theblitlabs/parity-runner/internal/status"
+)
+
+const (
+	// takeoverTimeout bounds how long a runner taking over waits for the
+	// one in its way to drain and exit
+	takeoverTimeout = 30 * time.Minute
+	// takeoverPollInterval is how often it checks whether that one exited
+	takeoverPollInterval = time.Second
+)
+
+// SetDataLock hands the service the lock on its data directory, which it
+// releases when stopped and passes on when upgraded
+func (s *Service) SetDataLock(lock *datalock.Lock) {
+	s.dataLock = lock
+}
+
+// LockDataDir locks the runner data directory for this process, which
+// serves its status API at statusURL. A runner started by an upgrade adopts
+// the lock handoff carries. When another runner holds the directory it is an
+// error naming that runner, unless takeover is set, in which case that
+// runner is asked to drain and exit and this one waits for the directory.
+func LockDataDir(handoff *Handoff, statusURL string, takeover bool) (*datalock.Lock, error) {
+	log := gologger.WithComponent("runner")
+
+	dir, err := parityDir()
+	if err != nil {
+		return nil, err
+	}
+	self := datalock.Holder{Version: provenance.Version(), StatusURL: statusURL}
+
+	if handoff != nil && handoff.Lock != nil {
+		return datalock.Adopt(dir, handoff.Lock, self)
+	}
+
+	var lock *datalock.Lock
+	if takeover {
+		ctx, cancel := context.WithTimeout(context.Background(), takeoverTimeout)
+		defer cancel()
+		lock, err = datalock.Takeover(ctx, dir, self, takeoverPollInterval, func(ctx context.Context, holder datalock.Holder) error {
+			if holder.StatusURL == "" {
+				return errors.New("it serves no status API")
+			}
+			log.Info().Int("pid", holder.PID).Str("status_url", holder.StatusURL).Msg("Asking the running runner to drain and exit")
+			return status.NewClient(holder.StatusURL).Shutdown(ctx)
+		})
+	} else {
+		lock, err = datalock.Acquire(dir, self)
+	}
+	var locked *datalock.LockedError
+	if errors.As(err, &locked) {
+		return nil, fmt.Errorf("%w - stop it, or start with --takeover to have it drain and exit", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if stale := lock.Stale(); stale != nil {
+		log.Warn().Int("pid", stale.PID).Time("started_at", stale.StartedAt).Msg("Recovered data directory from a runner that did not exit cleanly")
+	}
+	if !lock.Advisory() {
+		log.Warn().Str("dir", dir).Msg("Filesystem does not support file locks - only the lock record guards the data directory")
+	}
+	return lock, nil
+}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/diskreserve"
+)
+
+// diskReserveTimeout bounds estimating a task's disk demand, which looks up
+// image manifests and input sizes, and evicting caches to fit it
+const diskReserveTimeout = time.Minute
+
+// DiskEstimator is implemented by executors that can tell before a task is
+// claimed what it will write to disk
+type DiskEstimator interface {
+	EstimateDisk(ctx context.Context, task *models.Task) (diskreserve.Demand, error)
+}
+
+// SetDiskLedger reserves the disk space of admitted tasks on ledger
+func (h *DefaultTaskHandler) SetDiskLedger(ledger *diskreserve.Ledger) {
+	h.disk = ledger
+}
+
+// newDiskLedger returns the ledger tasks reserve disk space on, nil when
+// reservations are disabled
+func newDiskLedger(cfg config.DiskConfig, dataDir string) (*diskreserve.Ledger, error) {
+	if !cfg.Reserve {
+		return nil, nil
+	}
+	path := cfg.Path
+	if path == "" {
+		path = dataDir
+	}
+	return diskreserve.NewLedger(filepath.Clean(path), cfg.MinFreeMB<<20, cfg.HeadroomFactor)
+}
+
+// reserveDisk reserves the disk space task is estimated to write, skipping
+// it as lacking resources when it does not fit beside the tasks running.
+// Forcing skips the reservation, as it does the hardware requirements.
+func (h *DefaultTaskHandler) reserveDisk(task *models.Task) *admissionError {
+	if h.disk == nil || h.force {
+		return nil
+	}
+	estimator, ok := h.executor.(DiskEstimator)
+	if !ok {
+		return nil
+	}
+	log := gologger.WithComponent("task_handler")
+
+	ctx, cancel := context.WithTimeout(context.Background(), diskReserveTimeout)
+	defer cancel()
+
+	demand, err := estimator.EstimateDisk(ctx, task)
+	if err != nil {
+		// What could be sized is still reserved
+		log.Debug().Err(err).Str("id", task.ID.String()).Msg("Could not size all of a task's disk demand")
+	}
+	reservation, err := h.disk.Reserve(ctx, task.ID.String(), demand)
+	if err != nil {
+		return &admissionError{reason: models.FLDeclineInsufficientResources, err: err}
+	}
+	log.Debug().
+		Str("id", task.ID.String()).
+		Int64("reserved_bytes", reservation.Outstanding()).
+		Msg("Reserved disk space for task")
+	return nil
+}
+
+// releaseDisk drops the disk reservation of task, if any
+func (h *DefaultTaskHandler) releaseDisk(task *models.Task) {
+	h.disk.Release(task.ID.String())
+}
+
+// withDisk returns ctx carrying task's disk reservation, for the executor to
+// settle its phases as they complete
+func (h *DefaultTaskHandler) withDisk(ctx context.Context, task *models.Task) context.Context {
+	return diskreserve.With(ctx, h.disk.Get(task.ID.String()))
+}
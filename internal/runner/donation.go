@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"context"
+	"errors"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/idlework"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// SetDonor donates the runner's idle time to the community federated
+// learning sessions donor names: their rounds run only while no paid task
+// does, and yield to paid tasks the moment one is claimable
+func (h *DefaultTaskHandler) SetDonor(donor *idlework.Donor) {
+	h.donor = donor
+}
+
+// donated reports whether task is a round of a community session
+func (h *DefaultTaskHandler) donated(task *models.Task) bool {
+	if h.donor == nil || task.Type != models.TaskTypeFederatedLearning {
+		return false
+	}
+	var config flRoundConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return false
+	}
+	return h.donor.Donates(config.SessionID)
+}
+
+// admitDonation skips donated rounds while paid tasks run or another
+// donated round trains
+func (h *DefaultTaskHandler) admitDonation(task *models.Task) *admissionError {
+	if !h.donated(task) {
+		return nil
+	}
+	if h.tasksInFlight() > 0 || !h.donor.Idle() {
+		return &admissionError{skip: models.SkipTaskInProgress, err: errors.New("runner is busy with paid tasks")}
+	}
+	if active := h.donor.Active(); active != "" {
+		return &admissionError{skip: models.SkipDonationActive, err: errors.New("runner already trains donated round " + active)}
+	}
+	return nil
+}
+
+// preemptDonation makes donated training yield while the paid task task
+// runs, returning what resumes it
+func (h *DefaultTaskHandler) preemptDonation(task *models.Task) func() {
+	if h.donor == nil || h.donated(task) {
+		return func() {}
+	}
+	return h.donor.Preempt()
+}
+
+// withDonation returns ctx making the round task donated work, when it is
+func (h *DefaultTaskHandler) withDonation(ctx context.Context, task *models.Task) context.Context {
+	if !h.donated(task) {
+		return ctx
+	}
+	return idlework.WithDonor(ctx, h.donor)
+}
@@ -0,0 +1,163 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/canary"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/errbudget"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/profiles"
+	"github.com/theblitlabs/parity-runner/internal/health"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+)
+
+// errorBudgetResumeInterval is how often paused task types are checked for
+// a cool-down that has passed
+const errorBudgetResumeInterval = time.Minute
+
+// failureCode classifies err, a task's failed execution, by the failure
+// code the executor gave it or the errors it wraps
+func failureCode(err error) models.FailureCode {
+	var dependency *inputs.DependencyError
+	var preflight *docker.PreflightError
+	switch {
+	case errors.As(err, &dependency):
+		return models.FailureDependency
+	case errors.Is(err, inputs.ErrInsufficientDisk):
+		return models.FailureScratch
+	case errors.Is(err, inputs.ErrVerification), errors.Is(err, inputs.ErrUnavailable):
+		return models.FailureInputs
+	case errors.Is(err, docker.ErrContainerLost):
+		return models.FailureContainerRuntime
+	case errors.Is(err, docker.ErrRootForbidden), errors.Is(err, docker.ErrNetworkPolicy),
+		errors.Is(err, profiles.ErrNotPermitted):
+		return models.FailurePolicy
+	case errors.As(err, &preflight), errors.Is(err, docker.ErrImageNotFound):
+		return models.FailureInvalidConfig
+	}
+	if code := models.FailureCodeOf(err); code != models.FailureUnknown {
+		return code
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return models.FailureTimeout
+	}
+	return models.FailureUnknown
+}
+
+// newErrorBudget returns the guard cfg describes, whose paused types must
+// pass the canaries of monitor to resume
+func newErrorBudget(cfg config.ErrorBudgetConfig, monitor *canary.Monitor) (*errbudget.Guard, error) {
+	guard, err := errbudget.New(errbudget.Policy{
+		Window:         cfg.Window,
+		MinFailures:    cfg.MinFailures,
+		MaxFailureRate: cfg.MaxFailureRate,
+		Cooldown:       cfg.Cooldown,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure error budget: %w", err)
+	}
+	guard.SetProbe(typeCanary(monitor))
+	return guard, nil
+}
+
+// typeCanary returns a probe running the canaries of what tasks of a type
+// need, which fails unless every one passes
+func typeCanary(monitor *canary.Monitor) func(ctx context.Context, taskType models.TaskType) error {
+	return func(ctx context.Context, taskType models.TaskType) error {
+		results := monitor.RunMatching(ctx, func(capability string) bool {
+			_, model := canary.ModelOf(capability)
+			return canaryNeeds(capability, taskType) || (model && taskType == models.TaskTypeLLM)
+		})
+		if len(results) == 0 {
+			return fmt.Errorf("no canary checks what %s tasks need", taskType)
+		}
+		for _, result := range results {
+			if result.Err != nil {
+				return fmt.Errorf("%s canary failed: %w", result.Capability, result.Err)
+			}
+		}
+		return nil
+	}
+}
+
+// diagnoseReadiness returns the failures of checker's readiness checks, as
+// the diagnosis of a paused task type
+func diagnoseReadiness(checker func() *health.Checker) func(ctx context.Context, taskType models.TaskType) []string {
+	return func(ctx context.Context, taskType models.TaskType) []string {
+		c := checker()
+		if c == nil {
+			return nil
+		}
+		var diagnosis []string
+		for _, failure := range c.Readiness(ctx).Failures {
+			if failure.Check != "draining" {
+				diagnosis = append(diagnosis, failure.Check+": "+failure.Reason)
+			}
+		}
+		return diagnosis
+	}
+}
+
+// subscribeErrorBudget counts the outcome of every task execution on bus
+// against its type's error budget
+func subscribeErrorBudget(bus *events.Bus, guard *errbudget.Guard) {
+	bus.Subscribe("error_budget", func(e events.Event) {
+		completed, ok := e.(events.TaskCompleted)
+		if !ok {
+			return
+		}
+		if completed.Status == models.TaskStatusCompleted {
+			guard.Succeeded(completed.Type)
+		} else {
+			guard.Failed(completed.Type, completed.FailureCode)
+		}
+	})
+}
+
+// errorBudgetChanged tells every instance a task type was paused or
+// resumed, so the capability profile is pushed and hooks run
+func (r *sharedResources) errorBudgetChanged(status models.ErrorBudgetStatus) {
+	for _, svc := range r.services {
+		if status.Paused {
+			svc.events.Publish(events.TaskTypePaused{
+				Type:           status.Type,
+				RunnerFailures: status.RunnerFailures,
+				FailureRate:    status.FailureRate,
+				LastCode:       status.LastCode,
+				Diagnosis:      status.Diagnosis,
+			})
+		} else {
+			svc.events.Publish(events.TaskTypeResumed{Type: status.Type})
+		}
+	}
+}
+
+// unpausedTypes returns types without those guard paused
+func unpausedTypes(guard *errbudget.Guard, types []models.TaskType) []models.TaskType {
+	unpaused := make([]models.TaskType, 0, len(types))
+	for _, taskType := range types {
+		if !guard.Paused(taskType) {
+			unpaused = append(unpaused, taskType)
+		}
+	}
+	return unpaused
+}
+
+// SetErrorBudget skips tasks of the types guard paused until they resume
+func (h *DefaultTaskHandler) SetErrorBudget(guard *errbudget.Guard) {
+	h.errorBudget = guard
+}
+
+// admitErrorBudget declines tasks of a type whose error budget is used up
+func (h *DefaultTaskHandler) admitErrorBudget(task *models.Task) *admissionError {
+	if !h.errorBudget.Paused(task.Type) {
+		return nil
+	}
+	return &admissionError{reason: models.FLDeclineTypePaused, err: fmt.Errorf("%s tasks are paused after the runner used up their error budget", task.Type)}
+}
@@ -0,0 +1,146 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/errbudget"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+	"github.com/theblitlabs/parity-runner/internal/power"
+)
+
+// outcomeExecutor fails each task with the next of its outcomes: an error,
+// or nil for a task exiting with code 1
+type outcomeExecutor struct {
+	outcomes []error
+}
+
+func (e *outcomeExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	err := e.outcomes[0]
+	e.outcomes = e.outcomes[1:]
+	if err != nil {
+		return nil, err
+	}
+	return &models.TaskResult{TaskID: task.ID, ExitCode: 1, Error: "exit status 1"}, nil
+}
+
+func TestFailureCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want models.FailureCode
+	}{
+		{models.Failure(models.FailureContainerRuntime, errors.New("container creation failed")), models.FailureContainerRuntime},
+		{fmt.Errorf("wrapped: %w", docker.ErrContainerLost), models.FailureContainerRuntime},
+		{fmt.Errorf("input preparation failed: %w", inputs.ErrInsufficientDisk), models.FailureScratch},
+		{fmt.Errorf("input preparation failed: %w", inputs.ErrVerification), models.FailureInputs},
+		{&inputs.DependencyError{TaskID: "parent"}, models.FailureDependency},
+		{docker.ErrNetworkPolicy, models.FailurePolicy},
+		{&docker.PreflightError{Image: "alpine"}, models.FailureInvalidConfig},
+		{fmt.Errorf("run: %w", context.DeadlineExceeded), models.FailureTimeout},
+		{errors.New("something else"), models.FailureUnknown},
+	}
+	for _, tt := range tests {
+		if got := failureCode(tt.err); got != tt.want {
+			t.Errorf("failureCode(%v) = %s, want %s", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestErrorBudgetPausesTaskType(t *testing.T) {
+	runtimeErr := models.Failure(models.FailureContainerRuntime, errors.New("container creation failed: no such runtime"))
+	executor := &outcomeExecutor{outcomes: []error{
+		nil, nil, nil, nil, // task exits
+		runtimeErr, nil, runtimeErr, runtimeErr,
+	}}
+	h, _, _ := newPowerHandler(executor, power.InFlightContinue)
+	bus := events.NewBus(16)
+	defer bus.Close()
+	h.SetEventBus(bus)
+
+	guard, err := newErrorBudget(config.ErrorBudgetConfig{Window: time.Hour, MinFailures: 3, MaxFailureRate: 0.35, Cooldown: time.Minute}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	var paused []events.TaskTypePaused
+	bus.Subscribe("test", func(e events.Event) {
+		if e, ok := e.(events.TaskTypePaused); ok {
+			mu.Lock()
+			paused = append(paused, e)
+			mu.Unlock()
+		}
+	})
+	shared := &sharedResources{services: []*Service{{events: bus}}}
+	guard.OnChange(shared.errorBudgetChanged)
+	subscribeErrorBudget(bus, guard)
+	h.SetErrorBudget(guard)
+
+	run := func() {
+		t.Helper()
+		h.HandleTask(newCommandTask(0))
+		bus.Sync()
+	}
+	// Tasks exiting with errors and a single runtime failure leave the
+	// budget unspent
+	for i := 0; i < 6; i++ {
+		run()
+	}
+	if guard.Paused(models.TaskTypeCommand) {
+		t.Fatalf("status = %+v, want task failures not to use the budget", guard.Status())
+	}
+	run()
+	run()
+	if !guard.Paused(models.TaskTypeCommand) {
+		t.Fatalf("status = %+v, want the type paused after 3 runtime failures", guard.Status())
+	}
+	bus.Sync()
+
+	var admission *admissionError
+	if err := h.HandleTask(newCommandTask(0)); !errors.As(err, &admission) || admission.reason != models.FLDeclineTypePaused {
+		t.Fatalf("HandleTask() error = %v, want a type_paused skip", err)
+	}
+	types := unpausedTypes(guard, []models.TaskType{models.TaskTypeCommand, models.TaskTypeDocker})
+	if len(types) != 1 || types[0] != models.TaskTypeDocker {
+		t.Errorf("advertised types = %v, want command no longer advertised", types)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(paused) != 1 || paused[0].Type != models.TaskTypeCommand || paused[0].LastCode != models.FailureContainerRuntime || paused[0].RunnerFailures != 3 {
+		t.Errorf("paused events = %+v, want command paused for its runtime failures", paused)
+	}
+}
+
+func TestErrorBudgetResumesThroughCanary(t *testing.T) {
+	broken := &brokenCapabilities{broken: map[string]bool{}}
+	cfg := config.CanaryConfig{Threshold: 2}
+	monitor, err := newCanaryMonitor(cfg, canaryChecks(cfg, nil, broken, nil, nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := typeCanary(monitor)
+
+	broken.set("container", true)
+	if err := probe(context.Background(), models.TaskTypeDocker); err == nil {
+		t.Fatal("docker canary passed with a broken container runtime")
+	}
+	if err := probe(context.Background(), models.TaskTypeCommand); err != nil {
+		t.Fatalf("command canary error = %v, want it to pass without containers", err)
+	}
+	broken.set("container", false)
+	if err := probe(context.Background(), models.TaskTypeDocker); err != nil {
+		t.Fatalf("docker canary error = %v, want it passed once fixed", err)
+	}
+
+	var guard *errbudget.Guard
+	if guard.Paused(models.TaskTypeDocker) || guard.Status() != nil {
+		t.Error("nil guard paused a type")
+	}
+}
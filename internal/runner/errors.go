@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/errreport"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+const errorReportFileName = "errors.jsonl"
+
+// SetErrorReporter shares the errors the handler hits through reporter
+func (h *DefaultTaskHandler) SetErrorReporter(reporter *errreport.Reporter) {
+	h.reporter = reporter
+}
+
+// reportError shares err, scrubbing every string of task's payload from it
+func (h *DefaultTaskHandler) reportError(task *models.Task, category, code string, err error) {
+	if h.reporter == nil || err == nil {
+		return
+	}
+	h.reporter.Report(errreport.Event{
+		Code:      code,
+		Category:  category,
+		TaskType:  task.Type,
+		Err:       err,
+		Sensitive: payloadStrings(task),
+	})
+}
+
+// payloadStrings returns the strings in task's config and environment, and
+// its nonce, so that error reports never quote them
+func payloadStrings(task *models.Task) []string {
+	var values []string
+	var collect func(v interface{})
+	collect = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			values = append(values, v)
+		case map[string]interface{}:
+			for _, value := range v {
+				collect(value)
+			}
+		case []interface{}:
+			for _, value := range v {
+				collect(value)
+			}
+		}
+	}
+
+	var config interface{}
+	if safejson.Unmarshal(task.Config, &config) == nil {
+		collect(config)
+	}
+	if task.Environment != nil {
+		collect(task.Environment.Config)
+	}
+	if task.Nonce != "" {
+		values = append(values, task.Nonce)
+	}
+	return values
+}
+
+// newErrorReporter returns the reporter the error reporting config
+// describes, sending to client or, in local mode, to a file under dataDir
+func newErrorReporter(dataDir string, cfg config.ErrorReportingConfig, client errreport.Sink) (*errreport.Reporter, error) {
+	var sink errreport.Sink
+	switch cfg.Mode {
+	case "server":
+		sink = client
+	case "local":
+		path := cfg.File
+		if path == "" {
+			path = filepath.Join(dataDir, errorReportFileName)
+		}
+		sink = errreport.NewFileSink(path)
+	default:
+		return nil, fmt.Errorf("unknown error reporting mode %q", cfg.Mode)
+	}
+	return errreport.NewReporter(errreport.Config{
+		Categories:    cfg.Categories,
+		DedupWindow:   cfg.DedupWindow,
+		RateLimit:     cfg.RateLimit,
+		BatchSize:     cfg.BatchSize,
+		FlushInterval: cfg.FlushInterval,
+	}, sink), nil
+}
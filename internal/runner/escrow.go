@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"path/filepath"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+	"github.com/theblitlabs/parity-runner/internal/escrow"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// escrowDirName holds what the runner keeps of escrowed results
+const escrowDirName = "escrow"
+
+// escrowUploadTimeout bounds sealing and uploading a result's escrow
+const escrowUploadTimeout = 5 * time.Minute
+
+// SetEscrow escrows the results of tasks asking for it through escrower
+func (h *DefaultTaskHandler) SetEscrow(escrower *escrow.Escrower) {
+	h.escrower = escrower
+}
+
+// escrowConfig returns the escrow task asks for, nil when it asks for none
+func escrowConfig(task *models.Task) *models.EscrowConfig {
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return nil
+	}
+	return config.Escrow
+}
+
+// escrowResult escrows result when task asks for it, noting in the result
+// why it was not when it cannot be
+func (h *DefaultTaskHandler) escrowResult(ctx context.Context, task *models.Task, result *models.TaskResult) {
+	cfg := escrowConfig(task)
+	if cfg == nil {
+		return
+	}
+	if h.escrower == nil {
+		result.Escrow = &models.EscrowReport{Skipped: "escrow is not enabled on this runner"}
+		return
+	}
+
+	log := gologger.WithComponent("escrow")
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), escrowUploadTimeout)
+	defer cancel()
+	result.Escrow = h.escrower.Escrow(ctx, task, cfg, result)
+	if result.Escrow.Skipped != "" {
+		log.Warn().Str("id", task.ID.String()).Str("reason", result.Escrow.Skipped).Msg("Task result not escrowed")
+		return
+	}
+	log.Info().Str("id", task.ID.String()).Str("cid", result.Escrow.CID).Msg("Escrowed task result")
+}
+
+// openEscrowStore opens the escrow records under the runner's data dir
+func openEscrowStore(dataDir string) (*escrow.Store, error) {
+	return escrow.OpenStore(filepath.Join(dataDir, escrowDirName))
+}
+
+// ProveEscrow proves the escrow of taskID this runner recorded, downloading
+// its envelope from gateway. With the key of a recipient the bundle is also
+// decrypted.
+func ProveEscrow(ctx context.Context, taskID, gateway string, key *ecdsa.PrivateKey) (*escrow.Proof, error) {
+	dir, err := parityDir()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := openDataKeyring(dir)
+	if err != nil {
+		return nil, err
+	}
+	store, err := openEscrowStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	if keyring != nil {
+		store.SetCodec(keyring)
+	}
+	record, err := store.Get(taskID)
+	if err != nil {
+		return nil, err
+	}
+	return escrow.Prove(ctx, dualstack.Client(0), gateway, record, key)
+}
@@ -0,0 +1,33 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func TestEscrowResultNotesSkippedEscrow(t *testing.T) {
+	h := NewTaskHandler(&shardExecutor{}, &fakeFLClient{})
+
+	plain := newDockerTask(t)
+	result := &models.TaskResult{TaskID: plain.ID}
+	h.escrowResult(context.Background(), plain, result)
+	if result.Escrow != nil {
+		t.Errorf("escrowResult() = %+v for a task not asking for escrow", result.Escrow)
+	}
+
+	raw, err := json.Marshal(models.TaskConfig{ImageName: "alpine", Escrow: &models.EscrowConfig{CreatorKey: "02ab"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker, Nonce: "abcdef", Config: raw}
+	result = &models.TaskResult{TaskID: task.ID}
+	h.escrowResult(context.Background(), task, result)
+	if result.Escrow == nil || result.Escrow.Skipped == "" || result.Escrow.CID != "" {
+		t.Errorf("escrowResult() = %+v, want the omission noted", result.Escrow)
+	}
+}
@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"context"
+	"strings"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/migration"
+	"github.com/theblitlabs/parity-runner/internal/redact"
+)
+
+// SetEventBus publishes what happens to the handler's tasks on bus, and
+// subscribes the handler's metrics, audit bundles, creator callbacks and
+// materialization to it. Without a bus none of them are kept.
+func (h *DefaultTaskHandler) SetEventBus(bus *events.Bus) {
+	h.events = bus
+	if h.leases != nil {
+		h.leases.events = bus
+	}
+	bus.Subscribe("metrics", h.countEvent)
+	bus.Subscribe("audit", h.keepReplay)
+	bus.Subscribe("callbacks", h.notifyCallback)
+	bus.Subscribe("materialize", h.materializeResult)
+}
+
+// publish publishes e on the handler's bus, if it has one
+func (h *DefaultTaskHandler) publish(e events.Event) {
+	h.events.Publish(e)
+}
+
+// countEvent counts claimed, declined and finished tasks in the handler's
+// metrics
+func (h *DefaultTaskHandler) countEvent(e events.Event) {
+	if h.metrics == nil {
+		return
+	}
+	switch e := e.(type) {
+	case events.TaskClaimed:
+		h.metrics.Claimed(e.Instance)
+	case events.TaskDeclined:
+		h.metrics.Declined(e.Instance)
+	case events.TaskCompleted:
+		h.metrics.Finished(e.Instance, e.Status)
+	}
+}
+
+// newEventHook returns the hook the events configuration describes, or nil
+// when none is configured
+func newEventHook(cfg config.EventsConfig) (*events.Hook, error) {
+	if cfg.HookCommand == "" {
+		return nil, nil
+	}
+	return events.NewHook(strings.Fields(cfg.HookCommand), cfg.HookEvents, cfg.HookTimeout)
+}
+
+// subscribeHook runs hook for the events on bus it wants until ctx ends
+func subscribeHook(ctx context.Context, bus *events.Bus, hook *events.Hook) {
+	bus.Subscribe("hook", func(e events.Event) {
+		if !hook.Wants(e.Name()) || ctx.Err() != nil {
+			return
+		}
+		if err := hook.Run(ctx, e); err != nil {
+			log := gologger.WithComponent("events")
+			log.Warn().Err(err).Str("event", e.Name()).Msg("Event hook failed")
+		}
+	})
+}
+
+// progressPublisher publishes the progress running tasks report before
+// relaying it to the server, redacting its messages when redactor is set
+type progressPublisher struct {
+	sink     docker.ProgressSink
+	bus      *events.Bus
+	redactor *redact.Redactor
+	// groups adds the progress of their groups to the reports of shards
+	groups *groupTracker
+	// migrations records the checkpoints tasks report, to migrate them from
+	migrations *migration.Tracker
+	// taps relays the progress to the executions of the tasks
+	taps *executionTaps
+}
+
+func (p *progressPublisher) ReportProgress(taskID string, progress *models.TaskProgress) error {
+	if p.redactor != nil {
+		progress = p.redactor.Progress(taskID, progress)
+	}
+	if p.groups != nil && progress != nil {
+		if group := p.groups.progress(taskID, progress.Percent); group != nil {
+			shard := *progress
+			shard.Group = group
+			progress = &shard
+		}
+	}
+	if p.migrations != nil && progress != nil && progress.Checkpoint != nil {
+		p.migrations.RecordCheckpoint(taskID, *progress.Checkpoint)
+	}
+	p.bus.Publish(events.TaskProgress{TaskID: taskID, Progress: progress})
+	p.taps.report(taskID, progress)
+	return p.sink.ReportProgress(taskID, progress)
+}
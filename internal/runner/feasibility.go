@@ -0,0 +1,38 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// checkTaskRequirements verifies that this runner can satisfy the hardware and
+// network requirements declared in the task's resource configuration before it is claimed
+func checkTaskRequirements(task *models.Task, profile *hardware.Profile, network *hardware.NetworkProfile) error {
+	if len(task.Config) == 0 {
+		return nil
+	}
+
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		// Malformed configs are reported by the executor with full context
+		return nil
+	}
+
+	if !profile.SupportsAccelerator(config.Resources.Accelerator) {
+		available := hardware.AcceleratorNone
+		if profile != nil {
+			available = profile.Accelerator
+		}
+		return fmt.Errorf("task requires accelerator %q, runner provides %q", config.Resources.Accelerator, available)
+	}
+
+	if !network.SupportsBandwidth(config.Resources.MinBandwidth) {
+		return fmt.Errorf("task requires %.0f Mbps, runner measured %.0f down / %.0f up",
+			config.Resources.MinBandwidth, network.DownloadMbps, network.UploadMbps)
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/flcompress"
+)
+
+// newFLCodec returns the codec FL model updates are compressed with, or nil
+// when they are sent uncompressed
+func newFLCodec(cfg config.FLCompressionConfig) (*flcompress.Codec, error) {
+	codecConfig := flcompress.Config{
+		TrainAfter:         cfg.TrainAfter,
+		MaxDictionaryBytes: cfg.MaxDictionaryKB << 10,
+		RetrainRatio:       cfg.RetrainRatio,
+	}
+	switch cfg.Mode {
+	case "none":
+		return nil, nil
+	case flcompress.EncodingZstd:
+		codecConfig.DisableDictionaries = true
+	case flcompress.EncodingZstdDict:
+	default:
+		return nil, fmt.Errorf("unknown FL compression mode %q", cfg.Mode)
+	}
+	return flcompress.New(codecConfig)
+}
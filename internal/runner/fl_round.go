@@ -0,0 +1,225 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+	"github.com/theblitlabs/parity-runner/internal/taskschema"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// ErrRoundDeclined is returned when the runner opts out of an FL round
+var ErrRoundDeclined = errors.New("federated learning round declined")
+
+// FLRoundClient is implemented by task clients that can acknowledge FL round assignments
+type FLRoundClient interface {
+	AcknowledgeFLRound(ack *models.FLRoundAck) error
+}
+
+// admissionError carries the reason a task failed the pre-claim checks:
+// a decline reason when the runner will not run it, or a skip reason when
+// it only is too busy to run it now
+type admissionError struct {
+	reason models.FLDeclineReason
+	skip   models.SkipReason
+	err    error
+}
+
+func (e *admissionError) Error() string { return e.err.Error() }
+func (e *admissionError) Unwrap() error { return e.err }
+
+// admitTask runs the capability and scheduling checks that gate claiming a
+// task, so that task claiming and FL round acknowledgment decide alike. An
+// admitted task holds the memory and CPU it declared until releaseHost,
+// the GPU memory it declared until releaseGPU and the disk space it is
+// estimated to write until releaseDisk. Forcing
+// skips the hardware and bandwidth requirement filters. Tasks needing the
+// operator's confirmation are asked about first, so that the checks see the
+// runner as it is once the operator answers.
+func (h *DefaultTaskHandler) admitTask(task *models.Task) *admissionError {
+	// Revoked tasks are declined before the operator is asked about them
+	admission := h.admitRevocation(task)
+	if admission == nil {
+		admission = h.confirmTask(task)
+	}
+	if admission == nil {
+		admission = h.admit(task)
+	}
+	switch {
+	case admission == nil:
+	case admission.skip != "":
+		h.publish(events.TaskSkipped{
+			TaskID:   task.ID.String(),
+			Type:     task.Type,
+			Instance: h.instanceName(),
+			Reason:   admission.skip,
+			Detail:   admission.Error(),
+		})
+	default:
+		h.publish(events.TaskDeclined{
+			TaskID:   task.ID.String(),
+			Type:     task.Type,
+			Instance: h.instanceName(),
+			Reason:   admission.reason,
+			Detail:   admission.Error(),
+		})
+	}
+	return admission
+}
+
+// admit decides admitTask's answer
+func (h *DefaultTaskHandler) admit(task *models.Task) *admissionError {
+	if h.draining.Load() {
+		return &admissionError{reason: models.FLDeclineDraining, err: errors.New("runner is draining")}
+	}
+	if h.paused.Load() {
+		return &admissionError{reason: models.FLDeclinePaused, err: errors.New("runner is paused")}
+	}
+	if admission := h.admitType(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitCanary(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitErrorBudget(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitPoison(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitHints(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitGroup(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitInstance(task); admission != nil {
+		return admission
+	}
+	if err := taskschema.Validate(task.Type, task.Config); err != nil && !errors.Is(err, taskschema.ErrUnknownType) {
+		h.hintInvalid(task, err)
+		return &admissionError{reason: models.FLDeclineInvalidConfig, err: err}
+	}
+	if admission := h.admitNetwork(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitUser(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitSecurityProfile(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitHermetic(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitMaterialize(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitCustomModel(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitToolUse(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitPower(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitBudget(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitPricing(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitDonation(task); admission != nil {
+		return admission
+	}
+	if h.isProcessing.Load() && !h.sharesGPU(task) && !h.concurrency.Admits(h.tasksInFlight()) {
+		return &admissionError{skip: models.SkipTaskInProgress, err: errors.New("task already in progress")}
+	}
+	if requiresAttestation(task) && !h.canAttest() {
+		return &admissionError{reason: models.FLDeclineAttestationRequired, err: errors.New("task requires attestation, which this runner cannot provide")}
+	}
+	if err := checkTaskRequirements(task, h.hardware, h.network.Load()); err != nil && !h.force {
+		return &admissionError{reason: models.FLDeclineInsufficientResources, err: err}
+	}
+	if admission := h.admitTraining(task); admission != nil {
+		return admission
+	}
+	if admission := h.admitInputs(task); admission != nil {
+		return admission
+	}
+	if admission := h.reserveHost(task); admission != nil {
+		return admission
+	}
+	if admission := h.reserveDisk(task); admission != nil {
+		return admission
+	}
+	return h.reserveGPU(task)
+}
+
+type flRoundConfig struct {
+	SessionID  string `json:"session_id"`
+	RoundID    string `json:"round_id"`
+	DatasetCID string `json:"dataset_cid"`
+}
+
+// acknowledgeFLRound accepts or declines the FL round carried by task, as
+// feasibility found it, and reports the decision to the coordinator. It
+// returns ErrRoundDeclined, wrapping the decline reason, when local
+// training must be skipped.
+func (h *DefaultTaskHandler) acknowledgeFLRound(task *models.Task, feasibility *Feasibility) error {
+	log := gologger.WithComponent("task_handler")
+
+	var config flRoundConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return fmt.Errorf("failed to parse federated learning config: %w", err)
+	}
+
+	ack := &models.FLRoundAck{
+		SessionID: config.SessionID,
+		RoundID:   config.RoundID,
+		RunnerID:  flRunnerID(task),
+		Accepted:  feasibility.Feasible(),
+	}
+	if !ack.Accepted {
+		ack.Reason = feasibility.Reason
+		ack.Skipped = feasibility.Skip
+		ack.Detail = feasibility.Err.Error()
+	}
+
+	if client, ok := h.taskClient.(FLRoundClient); ok {
+		if err := client.AcknowledgeFLRound(ack); err != nil {
+			log.Warn().
+				Err(err).
+				Str("session_id", ack.SessionID).
+				Str("round_id", ack.RoundID).
+				Msg("Failed to acknowledge FL round")
+		}
+	}
+
+	if !ack.Accepted {
+		log.Info().
+			Str("session_id", ack.SessionID).
+			Str("round_id", ack.RoundID).
+			Str("reason", feasibility.why()).
+			Str("detail", ack.Detail).
+			Msg("Declined FL round")
+		return fmt.Errorf("%w: %s", ErrRoundDeclined, feasibility.why())
+	}
+	return nil
+}
+
+// flRunnerID identifies this runner to the FL coordinator, matching the ID
+// used when submitting model updates
+func flRunnerID(task *models.Task) string {
+	runnerID, err := utils.GetDeviceID()
+	if err != nil {
+		return task.RunnerID
+	}
+	return runnerID
+}
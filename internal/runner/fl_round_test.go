@@ -0,0 +1,264 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/flmodel"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+type fakeFLClient struct {
+	mu       sync.Mutex
+	acks     []*models.FLRoundAck
+	statuses []models.TaskStatus
+	results  []*models.TaskResult
+}
+
+func (c *fakeFLClient) FetchTask() (*models.Task, error) { return nil, nil }
+
+func (c *fakeFLClient) UpdateTaskStatus(taskID string, status models.TaskStatus, result *models.TaskResult) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statuses = append(c.statuses, status)
+	c.results = append(c.results, result)
+	return nil
+}
+
+func (c *fakeFLClient) AcknowledgeFLRound(ack *models.FLRoundAck) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.acks = append(c.acks, ack)
+	return nil
+}
+
+type countingExecutor struct {
+	calls int
+}
+
+func (e *countingExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	e.calls++
+	return &models.TaskResult{TaskID: task.ID, Output: "{}"}, nil
+}
+
+func newFLTask(t *testing.T, config map[string]interface{}) *models.Task {
+	t.Helper()
+	raw, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Task{
+		ID:     uuid.New(),
+		Type:   models.TaskTypeFederatedLearning,
+		Nonce:  "abcdef",
+		Config: raw,
+	}
+}
+
+func flConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"session_id":  "session-1",
+		"round_id":    "round-1",
+		"model_type":  "linear_regression",
+		"dataset_cid": "bafydataset",
+		"data_format": "csv",
+	}
+}
+
+// batchNormSpec takes 1168 bytes for its weights and 136 per sample to
+// train
+const batchNormSpec = `{"family":"mlp","input_size":4,"layers":[{"type":"dense","units":8},{"type":"batch_norm"},{"type":"dense","units":1}]}`
+
+func newFLHandler() (*DefaultTaskHandler, *fakeFLClient, *countingExecutor) {
+	client := &fakeFLClient{}
+	executor := &countingExecutor{}
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	return h, client, executor
+}
+
+func TestFLRoundDeclineReasons(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(h *DefaultTaskHandler, config map[string]interface{})
+		reason models.FLDeclineReason
+	}{
+		{
+			name:   "no data",
+			setup:  func(h *DefaultTaskHandler, config map[string]interface{}) { delete(config, "dataset_cid") },
+			reason: models.FLDeclineNoData,
+		},
+		{
+			name:   "draining",
+			setup:  func(h *DefaultTaskHandler, config map[string]interface{}) { h.SetDraining(true) },
+			reason: models.FLDeclineDraining,
+		},
+		{
+			name: "insufficient resources",
+			setup: func(h *DefaultTaskHandler, config map[string]interface{}) {
+				config["resources"] = map[string]string{"accelerator": string(hardware.AcceleratorCUDA)}
+			},
+			reason: models.FLDeclineInsufficientResources,
+		},
+		{
+			name:   "invalid config",
+			setup:  func(h *DefaultTaskHandler, config map[string]interface{}) { config["model_type"] = "svm" },
+			reason: models.FLDeclineInvalidConfig,
+		},
+		{
+			name: "model does not fit training memory",
+			setup: func(h *DefaultTaskHandler, config map[string]interface{}) {
+				h.SetTrainingMemoryBudget(1000)
+				config["model_spec"] = json.RawMessage(batchNormSpec)
+				config["train_config"] = map[string]interface{}{"batch_size": 32}
+			},
+			reason: models.FLDeclineInsufficientResources,
+		},
+		{
+			name: "batch normalization needs accumulation",
+			setup: func(h *DefaultTaskHandler, config map[string]interface{}) {
+				h.SetTrainingMemoryBudget(1712)
+				config["model_spec"] = json.RawMessage(batchNormSpec)
+				config["train_config"] = map[string]interface{}{"batch_size": 32}
+			},
+			reason: models.FLDeclineBatchNormAccumulation,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, client, executor := newFLHandler()
+			config := flConfig()
+			tt.setup(h, config)
+
+			err := h.HandleTask(newFLTask(t, config))
+			if !errors.Is(err, ErrRoundDeclined) {
+				t.Fatalf("HandleTask() error = %v, want ErrRoundDeclined", err)
+			}
+			if len(client.acks) != 1 || client.acks[0].Accepted || client.acks[0].Reason != tt.reason {
+				t.Fatalf("expected decline with reason %s, got %+v", tt.reason, client.acks)
+			}
+			if client.acks[0].RoundID != "round-1" || client.acks[0].SessionID != "session-1" {
+				t.Fatalf("acknowledgment does not identify the round: %+v", client.acks[0])
+			}
+			if executor.calls != 0 || len(client.statuses) != 0 {
+				t.Fatal("declined round must skip local training and claiming")
+			}
+		})
+	}
+}
+
+func TestFLRoundSkippedWhileBusy(t *testing.T) {
+	h, client, executor := newFLHandler()
+	h.begin()
+
+	if err := h.HandleTask(newFLTask(t, flConfig())); !errors.Is(err, ErrRoundDeclined) {
+		t.Fatalf("HandleTask() error = %v, want ErrRoundDeclined", err)
+	}
+	// Being busy is no decline the coordinator should hold against the runner
+	if len(client.acks) != 1 || client.acks[0].Accepted || client.acks[0].Reason != "" || client.acks[0].Skipped != models.SkipTaskInProgress {
+		t.Fatalf("expected a skip while busy, got %+v", client.acks)
+	}
+	if executor.calls != 0 {
+		t.Fatal("skipped round must not train")
+	}
+}
+
+func TestFLRoundAcceptThenTrain(t *testing.T) {
+	h, client, executor := newFLHandler()
+
+	if err := h.HandleTask(newFLTask(t, flConfig())); err != nil {
+		t.Fatalf("HandleTask() error = %v", err)
+	}
+
+	if len(client.acks) != 1 || !client.acks[0].Accepted || client.acks[0].Reason != "" {
+		t.Fatalf("expected a single accept, got %+v", client.acks)
+	}
+	if executor.calls != 1 {
+		t.Fatalf("expected local training to run once, got %d", executor.calls)
+	}
+	want := []models.TaskStatus{models.TaskStatusRunning, models.TaskStatusCompleted}
+	if len(client.statuses) != len(want) || client.statuses[0] != want[0] || client.statuses[1] != want[1] {
+		t.Fatalf("expected statuses %v, got %v", want, client.statuses)
+	}
+}
+
+func TestFLRoundAcceptsAccumulationWithoutBatchNorm(t *testing.T) {
+	h, client, _ := newFLHandler()
+	h.SetTrainingMemoryBudget(1712)
+	config := flConfig()
+	config["model_spec"] = json.RawMessage(`{"family":"mlp","input_size":4,"layers":[{"type":"dense","units":8},{"type":"dense","units":1}]}`)
+	config["train_config"] = map[string]interface{}{"batch_size": 32}
+
+	if err := h.HandleTask(newFLTask(t, config)); err != nil {
+		t.Fatalf("HandleTask() error = %v", err)
+	}
+	if len(client.acks) != 1 || !client.acks[0].Accepted {
+		t.Fatalf("expected a dense model to train on micro-batches, got %+v", client.acks)
+	}
+}
+
+// fetchingExecutor fails to fetch any round's global model with err
+type fetchingExecutor struct {
+	countingExecutor
+	err error
+}
+
+func (e *fetchingExecutor) FetchGlobalModel(ctx context.Context, task *models.Task) error {
+	return e.err
+}
+
+func TestFLRoundDeclinesUnverifiedGlobalModel(t *testing.T) {
+	for _, tt := range []struct {
+		err    error
+		reason models.FLDeclineReason
+	}{
+		{fmt.Errorf("%w: model has sha256 00, want 11", flmodel.ErrIntegrity), models.FLDeclineModelIntegrity},
+		{errors.New("failed to download input global-model: status 503"), models.FLDeclineModelUnavailable},
+	} {
+		client := &fakeFLClient{}
+		executor := &fetchingExecutor{err: tt.err}
+		h := NewTaskHandler(executor, client)
+		h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+
+		if err := h.HandleTask(newFLTask(t, flConfig())); !errors.Is(err, ErrRoundDeclined) {
+			t.Fatalf("HandleTask() error = %v, want ErrRoundDeclined", err)
+		}
+		if len(client.acks) != 1 || client.acks[0].Accepted || client.acks[0].Reason != tt.reason {
+			t.Fatalf("expected decline with reason %s, got %+v", tt.reason, client.acks)
+		}
+		if executor.calls != 0 {
+			t.Fatal("round with an unverified global model must not train")
+		}
+	}
+}
+
+func TestTaskWithInvalidConfigSkippedBeforeClaim(t *testing.T) {
+	client := &attestingClient{}
+	executor := &countingExecutor{}
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+
+	task := newDockerTask(t)
+	task.Config = json.RawMessage(`{"image_name": "alpine", "resources": {"memory": "lots"}}`)
+
+	err := h.HandleTask(task)
+	var admission *admissionError
+	if !errors.As(err, &admission) || admission.reason != models.FLDeclineInvalidConfig {
+		t.Fatalf("HandleTask() error = %v, want invalid_config", err)
+	}
+	if !strings.Contains(err.Error(), "/resources/memory") {
+		t.Fatalf("error %q does not point at the offending field", err)
+	}
+	if executor.calls != 0 || len(client.statuses) != 0 {
+		t.Fatal("task with an invalid config must not be claimed or run")
+	}
+}
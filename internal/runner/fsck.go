@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/accounting"
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/execution/training"
+	"github.com/theblitlabs/parity-runner/internal/fsck"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+)
+
+const (
+	// quarantineDirName keeps content fsck took out of service
+	quarantineDirName = "quarantine"
+	// quarantineRetention is how long quarantined content is kept for
+	// inspection before it is pruned
+	quarantineRetention = 7 * 24 * time.Hour
+)
+
+// checkLocalStores cross-checks the caches and stores under dataDir against
+// their indexes, repairing indexes and quarantining damaged content. It runs
+// before any of them are opened, with codec opening encrypted stores.
+func checkLocalStores(dataDir string, codec atrest.Codec) *fsck.Report {
+	q := fsck.NewQuarantine(filepath.Join(dataDir, quarantineDirName))
+	var inputManager *inputs.Manager
+	var datasets *training.DatasetCache
+
+	report := fsck.Run(q,
+		fsck.Check{Store: caches.Inputs, Run: func(q *fsck.Quarantine) ([]fsck.Finding, error) {
+			var err error
+			if inputManager, err = inputs.NewManager(filepath.Join(dataDir, inputCacheDirName)); err != nil {
+				return nil, err
+			}
+			return inputManager.Fsck(q)
+		}},
+		fsck.Check{Store: caches.Datasets, Run: func(q *fsck.Quarantine) ([]fsck.Finding, error) {
+			var err error
+			if datasets, err = training.OpenDatasetCache(filepath.Join(dataDir, datasetCacheDirName)); err != nil {
+				return nil, err
+			}
+			return datasets.Fsck(q)
+		}},
+		fsck.Check{Store: caches.StateStore, Run: func(q *fsck.Quarantine) ([]fsck.Finding, error) {
+			// Only caches that checked out vouch for which entries exist
+			stores := make(map[string]caches.Store)
+			if inputManager != nil {
+				stores[caches.Inputs] = inputManager
+			}
+			if datasets != nil {
+				stores[caches.Datasets] = datasets
+			}
+			return caches.CheckState(context.Background(), filepath.Join(dataDir, cacheStateFileName), q, stores)
+		}},
+		fsck.Check{Store: caches.Artifacts, Run: func(q *fsck.Quarantine) ([]fsck.Finding, error) {
+			return fsck.CheckJSONFile(caches.Artifacts, filepath.Join(dataDir, artifactCacheFileName), codec, q)
+		}},
+		fsck.Check{Store: "outbox", Run: func(q *fsck.Quarantine) ([]fsck.Finding, error) {
+			return fsck.CheckJSONDir("outbox", filepath.Join(dataDir, outboxDirName), codec, q, true)
+		}},
+		fsck.Check{Store: "leases", Run: func(q *fsck.Quarantine) ([]fsck.Finding, error) {
+			return fsck.CheckJSONDir("leases", filepath.Join(dataDir, leaseDirName), codec, q, true)
+		}},
+		fsck.Check{Store: "history", Run: func(q *fsck.Quarantine) ([]fsck.Finding, error) {
+			return history.Fsck(filepath.Join(dataDir, historyFileName), codec, q)
+		}},
+		fsck.Check{Store: "escrow", Run: func(q *fsck.Quarantine) ([]fsck.Finding, error) {
+			return fsck.CheckJSONDir("escrow", filepath.Join(dataDir, escrowDirName), codec, q, false)
+		}},
+		fsck.Check{Store: "accounting", Run: func(q *fsck.Quarantine) ([]fsck.Finding, error) {
+			return accounting.Fsck(filepath.Join(dataDir, accountingDirName), codec, q)
+		}},
+	)
+
+	pruned, err := q.Prune(quarantineRetention)
+	if err != nil {
+		report.Failures = append(report.Failures, fsck.Failure{Store: quarantineDirName, Error: err.Error()})
+	}
+	report.Pruned = pruned
+	return report
+}
+
+// keyringCodec returns keyring as a codec, nil when there is none
+func keyringCodec(keyring *atrest.Keyring) atrest.Codec {
+	if keyring == nil {
+		return nil
+	}
+	return keyring
+}
+
+// Fsck checks the runner's caches and stores while the runner itself is not
+// running
+func Fsck() (*fsck.Report, error) {
+	dir, err := parityDir()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := openDataKeyring(dir)
+	if err != nil {
+		return nil, err
+	}
+	return checkLocalStores(dir, keyringCodec(keyring)), nil
+}
+
+// logFsckReport logs what the startup check found
+func logFsckReport(report *fsck.Report) {
+	log := gologger.WithComponent("fsck")
+	for _, f := range report.Findings {
+		log.Warn().
+			Str("store", f.Store).
+			Str("item", f.Item).
+			Str("problem", f.Problem).
+			Str("action", string(f.Action)).
+			Msg("Local store damage repaired")
+	}
+	for _, f := range report.Failures {
+		log.Warn().Str("store", f.Store).Str("error", f.Error).Msg("Local store could not be checked")
+	}
+	if report.Pruned > 0 {
+		log.Info().Int("pruned", report.Pruned).Msg("Pruned expired quarantine")
+	}
+}
@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/flmodel"
+)
+
+const (
+	// globalModelDirName holds the latest global model of recent FL sessions
+	globalModelDirName = "caches/global-models"
+	// globalModelFetchTimeout bounds obtaining a round's global model
+	// before the round is acknowledged
+	globalModelFetchTimeout = 10 * time.Minute
+)
+
+// GlobalModelFetcher is implemented by executors that obtain the global
+// model an FL round trains from and can verify it before the round is
+// accepted
+type GlobalModelFetcher interface {
+	FetchGlobalModel(ctx context.Context, task *models.Task) error
+}
+
+// admitGlobalModel declines FL rounds whose global model does not match
+// the hash the coordinator published, or cannot be obtained at all
+func (h *DefaultTaskHandler) admitGlobalModel(task *models.Task) *admissionError {
+	fetcher, ok := h.executor.(GlobalModelFetcher)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), globalModelFetchTimeout)
+	defer cancel()
+
+	err := fetcher.FetchGlobalModel(ctx, task)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, flmodel.ErrIntegrity):
+		return &admissionError{reason: models.FLDeclineModelIntegrity, err: err}
+	default:
+		return &admissionError{reason: models.FLDeclineModelUnavailable, err: err}
+	}
+}
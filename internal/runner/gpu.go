@@ -0,0 +1,262 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// gpuReserveTimeout bounds the GPU queries made when admitting a task
+const gpuReserveTimeout = 30 * time.Second
+
+// gpuTask is the GPU reservation of an admitted task and, once it runs, how
+// to evict it
+type gpuTask struct {
+	reservation models.GPUReservation
+	evict       context.CancelCauseFunc
+	// owner is the handler that admitted the task; logical runners of one
+	// process share the table of their GPU tasks
+	owner *DefaultTaskHandler
+}
+
+type gpuTasks struct {
+	mu    sync.Mutex
+	tasks map[string]*gpuTask
+}
+
+func (g *gpuTasks) get(taskID string) *gpuTask {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.tasks[taskID]
+}
+
+func (g *gpuTasks) put(taskID string, task *gpuTask) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.tasks == nil {
+		g.tasks = make(map[string]*gpuTask)
+	}
+	g.tasks[taskID] = task
+}
+
+func (g *gpuTasks) remove(taskID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.tasks, taskID)
+}
+
+// owned returns the number of tasks owner holds reservations for
+func (g *gpuTasks) owned(owner *DefaultTaskHandler) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	n := 0
+	for _, task := range g.tasks {
+		if task.owner == owner {
+			n++
+		}
+	}
+	return n
+}
+
+// SetGPUAllocator lets tasks that declare their VRAM share GPUs through
+// allocator
+func (h *DefaultTaskHandler) SetGPUAllocator(allocator *gpu.Allocator) {
+	h.gpu = allocator
+}
+
+// gpuRequest returns the VRAM a task declared and its eviction priority
+func gpuRequest(task *models.Task) (uint64, int, error) {
+	if len(task.Config) == 0 {
+		return 0, 0, nil
+	}
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return 0, 0, nil
+	}
+	bytes, err := gpu.ParseBytes(config.Resources.GPUMemory)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid gpu_memory: %w", err)
+	}
+	return bytes, config.Resources.Priority, nil
+}
+
+// sharesGPU reports whether task may run beside the tasks in flight, which
+// it may when it and each of them holds a GPU reservation
+func (h *DefaultTaskHandler) sharesGPU(task *models.Task) bool {
+	if h.gpu == nil {
+		return false
+	}
+	if bytes, _, err := gpuRequest(task); err != nil || bytes == 0 {
+		return false
+	}
+	return h.gpuTasks.owned(h) == h.tasksInFlight()
+}
+
+// reserveGPU sets aside the VRAM task declared. A task that only fits once
+// others finish is skipped as the GPU being busy, so the server can queue it.
+func (h *DefaultTaskHandler) reserveGPU(task *models.Task) *admissionError {
+	if h.gpu == nil {
+		return nil
+	}
+	bytes, priority, err := gpuRequest(task)
+	if err != nil {
+		return &admissionError{reason: models.FLDeclineInsufficientResources, err: err}
+	}
+	if bytes == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), gpuReserveTimeout)
+	defer cancel()
+
+	reservation, err := h.gpu.ReserveOn(ctx, task.ID.String(), bytes, priority, h.gpuDevices())
+	switch {
+	case err == nil:
+	case errors.Is(err, gpu.ErrExceedsGPU):
+		return &admissionError{reason: models.FLDeclineInsufficientResources, err: err}
+	case errors.Is(err, gpu.ErrGPUBusy), h.tasksInFlight() > 0:
+		return &admissionError{skip: models.SkipGPUBusy, err: err}
+	default:
+		// Without GPU figures the task runs alone, as it would have before
+		log := gologger.WithComponent("task_handler")
+		log.Debug().Err(err).Str("id", task.ID.String()).Msg("Running task without a GPU reservation")
+		return nil
+	}
+
+	log := gologger.WithComponent("task_handler")
+	log.Debug().
+		Str("id", task.ID.String()).
+		Str("device", reservation.Device).
+		Bool("partition", reservation.Partition).
+		Uint64("declared_bytes", reservation.DeclaredBytes).
+		Msg("Reserved GPU memory for task")
+
+	h.gpuTasks.put(task.ID.String(), &gpuTask{reservation: reservation, owner: h})
+	return nil
+}
+
+// releaseGPU frees the VRAM reserved for task, if any
+func (h *DefaultTaskHandler) releaseGPU(task *models.Task) {
+	if h.gpu == nil {
+		return
+	}
+	h.gpuTasks.remove(task.ID.String())
+	h.gpu.Release(task.ID.String())
+}
+
+// withGPU returns ctx carrying the device reserved for task, or the GPUs
+// pinned to the handler's instance, and cancelled with gpu.ErrEvicted if the
+// task is evicted
+func (h *DefaultTaskHandler) withGPU(ctx context.Context, task *models.Task) (context.Context, context.CancelFunc) {
+	entry := h.gpuTasks.get(task.ID.String())
+	if entry == nil {
+		if devices := h.gpuDevices(); len(devices) > 0 && h.usesGPU(task) {
+			// Tasks that reserve nothing still run only on the
+			// instance's GPUs
+			ctx = gpu.WithDevice(ctx, strings.Join(devices, ","))
+		}
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(gpu.WithDevice(ctx, entry.reservation.Device))
+	h.gpuTasks.mu.Lock()
+	entry.evict = cancel
+	h.gpuTasks.mu.Unlock()
+	return ctx, func() { cancel(nil) }
+}
+
+// evicted reports whether the task running under ctx was evicted from its GPU
+func evicted(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), gpu.ErrEvicted)
+}
+
+// WatchGPU compares observed VRAM usage with the reservations every interval
+// until ctx ends, evicting the lowest priority task from a GPU that tasks
+// using more than they declared have overcommitted
+func (h *DefaultTaskHandler) WatchGPU(ctx context.Context, interval time.Duration) {
+	if h.gpu == nil {
+		return
+	}
+	log := gologger.WithComponent("task_handler")
+
+	ticker := h.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+		if h.gpu.Len() == 0 {
+			continue
+		}
+
+		evictions, err := h.gpu.Observe(ctx)
+		if err != nil {
+			log.Debug().Err(err).Msg("Failed to observe GPU usage")
+			continue
+		}
+		for _, reservation := range evictions {
+			entry := h.gpuTasks.get(reservation.TaskID)
+			if entry == nil {
+				continue
+			}
+			h.gpuTasks.mu.Lock()
+			evict := entry.evict
+			h.gpuTasks.mu.Unlock()
+			if evict == nil {
+				continue
+			}
+			log.Warn().
+				Str("id", reservation.TaskID).
+				Str("device", reservation.Device).
+				Int("priority", reservation.Priority).
+				Msg("Evicting task from overcommitted GPU")
+			evict(gpu.ErrEvicted)
+		}
+	}
+}
+
+// requeue returns a task stopped for cause, such as eviction from its GPU,
+// to the server's queue
+func (h *DefaultTaskHandler) requeue(task *models.Task, cause error) error {
+	log := gologger.WithComponent("task_handler")
+	if err := h.taskClient.UpdateTaskStatus(task.ID.String(), models.TaskStatusPending, &models.TaskResult{
+		TaskID: task.ID,
+		Error:  cause.Error(),
+	}); err != nil {
+		log.Error().Err(err).Str("id", task.ID.String()).Msg("Failed to requeue task")
+	}
+	return cause
+}
+
+// newGPUAllocator returns the allocator GPU sharing uses, or nil when the
+// host has no NVIDIA GPU to share
+func newGPUAllocator(ctx context.Context, cfg config.GPUConfig) (*gpu.Allocator, error) {
+	margin, err := gpu.ParseBytes(cfg.SafetyMargin)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GPU safety margin: %w", err)
+	}
+	stats := gpu.NewNvidiaSMI()
+	if stats == nil {
+		return nil, nil
+	}
+
+	allocator := gpu.NewAllocator(stats, margin)
+	allocator.SetAttributor(gpu.NewContainerAttributor(docker.TaskContainers))
+	if err := allocator.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	return allocator, nil
+}
@@ -0,0 +1,204 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+const gib = 1 << 30
+
+// fakeGPU is a single 24GiB GPU whose per-task usage the test sets
+type fakeGPU struct {
+	mu    sync.Mutex
+	usage map[string]uint64
+	pids  map[int]string
+}
+
+func newFakeGPU() *fakeGPU {
+	return &fakeGPU{usage: make(map[string]uint64), pids: make(map[int]string)}
+}
+
+func (f *fakeGPU) use(taskID string, bytes uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.usage[taskID]; !ok {
+		f.pids[len(f.pids)+1] = taskID
+	}
+	f.usage[taskID] = bytes
+}
+
+func (f *fakeGPU) Devices(ctx context.Context) ([]models.GPUDevice, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var used uint64
+	for _, bytes := range f.usage {
+		used += bytes
+	}
+	return []models.GPUDevice{{UUID: "GPU-0", TotalBytes: 24 * gib, UsedBytes: used}}, nil
+}
+
+func (f *fakeGPU) Processes(ctx context.Context) ([]gpu.Process, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var processes []gpu.Process
+	for pid, task := range f.pids {
+		processes = append(processes, gpu.Process{PID: pid, Device: "GPU-0", UsedBytes: f.usage[task]})
+	}
+	return processes, nil
+}
+
+func (f *fakeGPU) Attribute(ctx context.Context, pids []int) (map[int]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	owners := make(map[int]string)
+	for pid, task := range f.pids {
+		owners[pid] = task
+	}
+	return owners, nil
+}
+
+// gpuExecutor runs each task until it is released or its context ends,
+// recording the GPU it was given
+type gpuExecutor struct {
+	mu      sync.Mutex
+	devices map[string]string
+	started chan string
+	release chan struct{}
+}
+
+func newGPUExecutor() *gpuExecutor {
+	return &gpuExecutor{devices: make(map[string]string), started: make(chan string, 4), release: make(chan struct{})}
+}
+
+func (e *gpuExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	device, _ := gpu.DeviceFromContext(ctx)
+	e.mu.Lock()
+	e.devices[task.ID.String()] = device
+	e.mu.Unlock()
+	e.started <- task.ID.String()
+
+	select {
+	case <-e.release:
+		return &models.TaskResult{TaskID: task.ID}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func newGPUTask(t *testing.T, memory string, priority int) *models.Task {
+	t.Helper()
+	raw, err := json.Marshal(models.TaskConfig{
+		ImageName: "pytorch/pytorch",
+		Resources: models.ResourceConfig{GPUMemory: memory, Priority: priority},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker, Nonce: "abcdef", Config: raw}
+}
+
+func newGPUHandler(t *testing.T) (*DefaultTaskHandler, *gpuExecutor, *fakeFLClient, *fakeGPU) {
+	t.Helper()
+	executor := newGPUExecutor()
+	client := &fakeFLClient{}
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+
+	stats := newFakeGPU()
+	allocator := gpu.NewAllocator(stats, 1*gib)
+	allocator.SetAttributor(stats)
+	h.SetGPUAllocator(allocator)
+	return h, executor, client, stats
+}
+
+func TestGPUTasksShareWhileTheyFit(t *testing.T) {
+	h, executor, _, _ := newGPUHandler(t)
+
+	done := make(chan error, 2)
+	for _, task := range []*models.Task{newGPUTask(t, "14g", 0), newGPUTask(t, "8g", 0)} {
+		go func() { done <- h.HandleTask(task) }()
+		<-executor.started
+	}
+
+	var admission *admissionError
+	if err := h.HandleTask(newGPUTask(t, "4g", 0)); !errors.As(err, &admission) || admission.skip != models.SkipGPUBusy {
+		t.Fatalf("HandleTask() error = %v, want gpu_busy once the GPU is full", err)
+	}
+	if err := h.HandleTask(newDockerTask(t)); !errors.As(err, &admission) || admission.skip != models.SkipTaskInProgress {
+		t.Fatalf("HandleTask() error = %v, want a task without a VRAM declaration kept out", err)
+	}
+
+	executor.mu.Lock()
+	for id, device := range executor.devices {
+		if device != "GPU-0" {
+			t.Fatalf("task %s ran on %q, want GPU-0", id, device)
+		}
+	}
+	executor.mu.Unlock()
+
+	close(executor.release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("HandleTask() error = %v", err)
+		}
+	}
+	if h.IsProcessing() || h.gpu.Len() != 0 {
+		t.Fatalf("handler still busy after both tasks finished: processing=%v reservations=%d", h.IsProcessing(), h.gpu.Len())
+	}
+
+	if err := h.HandleTask(newGPUTask(t, "30g", 0)); !errors.As(err, &admission) || admission.reason != models.FLDeclineInsufficientResources {
+		t.Fatalf("HandleTask() error = %v, want insufficient_resources for a task no GPU fits", err)
+	}
+}
+
+func TestOvercommittedGPUEvictsLowerPriorityTask(t *testing.T) {
+	h, executor, client, stats := newGPUHandler(t)
+	clk := clocktest.NewFake(time.Now())
+	h.SetClock(clk)
+
+	llm := newGPUTask(t, "14g", 10)
+	fl := newGPUTask(t, "6g", 1)
+	results := make(map[string]chan error)
+	for _, task := range []*models.Task{llm, fl} {
+		result := make(chan error, 1)
+		results[task.ID.String()] = result
+		go func() { result <- h.HandleTask(task) }()
+		<-executor.started
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go h.WatchGPU(ctx, time.Second)
+	clk.BlockUntil(1)
+
+	// The LLM task grows past its declaration until the GPU is overcommitted
+	stats.use(llm.ID.String(), 18*gib)
+	stats.use(fl.ID.String(), 5*gib)
+	clk.Advance(time.Second)
+
+	if err := <-results[fl.ID.String()]; !errors.Is(err, gpu.ErrEvicted) {
+		t.Fatalf("FL task error = %v, want ErrEvicted", err)
+	}
+	client.mu.Lock()
+	statuses := append([]models.TaskStatus(nil), client.statuses...)
+	client.mu.Unlock()
+	if len(statuses) == 0 || statuses[len(statuses)-1] != models.TaskStatusPending {
+		t.Fatalf("statuses = %v, want the evicted task returned to pending", statuses)
+	}
+
+	close(executor.release)
+	if err := <-results[llm.ID.String()]; err != nil {
+		t.Fatalf("LLM task error = %v, want it to keep running", err)
+	}
+}
@@ -0,0 +1,383 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// ErrGroupCancelled is the cause a shard is stopped with once a sibling
+// failed in a group with shared fate
+var ErrGroupCancelled = errors.New("task group cancelled")
+
+// AvailableTaskLister is implemented by task clients that can list the
+// tasks waiting to be claimed
+type AvailableTaskLister interface {
+	GetAvailableTasks() ([]*models.Task, error)
+}
+
+// GroupSummarySubmitter is implemented by task clients that can submit the
+// combined result of a task group's shards
+type GroupSummarySubmitter interface {
+	SubmitGroupSummary(summary *models.GroupSummary) error
+}
+
+// shardRun is a shard of a group the runner ran or is running
+type shardRun struct {
+	taskID  string
+	shard   int
+	percent float64
+	// stop cancels the shard while it runs
+	stop context.CancelCauseFunc
+	// result is set once the shard finished
+	result *models.ShardSummary
+}
+
+// groupRun is what the runner knows of a group it runs shards of
+type groupRun struct {
+	id          string
+	shards      int
+	sharedFate  bool
+	runs        map[string]*shardRun
+	startedAt   time.Time
+	finishedAt  time.Time
+	cancelledBy string
+}
+
+// groupTracker follows the groups the runner runs shards of, until their
+// summaries are taken
+type groupTracker struct {
+	mu     sync.Mutex
+	groups map[string]*groupRun
+	// byTask maps the task ID of a shard to its group
+	byTask map[string]string
+	// cancelled maps the groups cancelled by a failed shard to its task ID,
+	// kept after their summaries are taken so that their shards are still
+	// declined
+	cancelled map[string]string
+}
+
+func newGroupTracker() *groupTracker {
+	return &groupTracker{
+		groups:    make(map[string]*groupRun),
+		byTask:    make(map[string]string),
+		cancelled: make(map[string]string),
+	}
+}
+
+// start records that the shard task started at now, stopped with stop
+// should its group be cancelled
+func (t *groupTracker) start(task *models.Task, now time.Time, stop context.CancelCauseFunc) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	group, ok := t.groups[task.Group.ID]
+	if !ok {
+		group = &groupRun{
+			id:         task.Group.ID,
+			shards:     task.Group.Shards,
+			sharedFate: task.Group.SharedFate,
+			runs:       make(map[string]*shardRun),
+			startedAt:  now,
+		}
+		t.groups[task.Group.ID] = group
+	}
+	id := task.ID.String()
+	group.runs[id] = &shardRun{taskID: id, shard: task.Group.Shard, stop: stop}
+	t.byTask[id] = group.id
+}
+
+// stop forgets the shard with taskID unless it finished, as when it was
+// returned to the queue
+func (t *groupTracker) stop(taskID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	group, ok := t.groups[t.byTask[taskID]]
+	if !ok {
+		return
+	}
+	if run, ok := group.runs[taskID]; ok && run.result == nil {
+		delete(group.runs, taskID)
+		delete(t.byTask, taskID)
+	}
+}
+
+// finish records the outcome of the shard with taskID. When it failed in
+// a group with shared fate, the group is cancelled and its other running
+// shards are stopped. It returns whether the shard cancelled the group.
+func (t *groupTracker) finish(taskID string, result models.ShardSummary, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	group, ok := t.groups[t.byTask[taskID]]
+	if !ok {
+		return false
+	}
+	run, ok := group.runs[taskID]
+	if !ok {
+		return false
+	}
+	result.TaskID = taskID
+	result.Shard = run.shard
+	run.result = &result
+	group.finishedAt = now
+
+	if result.Status != models.TaskStatusFailed || result.Cancelled || !group.sharedFate || group.cancelledBy != "" {
+		return false
+	}
+	group.cancelledBy = taskID
+	t.cancelled[group.id] = taskID
+	cause := fmt.Errorf("%w: shard %d failed", ErrGroupCancelled, run.shard)
+	for _, sibling := range group.runs {
+		if sibling.result == nil {
+			sibling.stop(cause)
+		}
+	}
+	return true
+}
+
+// cancelledBy returns the task ID of the shard whose failure cancelled the
+// group with groupID, or "" while it is not cancelled
+func (t *groupTracker) cancelledBy(groupID string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cancelled[groupID]
+}
+
+// ran reports whether the runner ran or runs the shard with taskID
+func (t *groupTracker) ran(taskID string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.byTask[taskID]
+	return ok
+}
+
+// progress records that the shard with taskID reported percent, returning
+// the progress of its group or nil when it is not a shard
+func (t *groupTracker) progress(taskID string, percent float64) *models.GroupProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	group, ok := t.groups[t.byTask[taskID]]
+	if !ok {
+		return nil
+	}
+	if run, ok := group.runs[taskID]; ok && run.result == nil {
+		run.percent = percent
+	}
+
+	progress := &models.GroupProgress{GroupID: group.id, Shards: group.shards}
+	done := 0.0
+	for _, run := range group.runs {
+		switch {
+		case run.result == nil:
+			progress.Running++
+			done += run.percent / 100
+		case run.result.Status == models.TaskStatusCompleted:
+			progress.Completed++
+			done++
+		default:
+			progress.Failed++
+			done++
+		}
+	}
+	progress.Percent = min(100, 100*done/float64(group.shards))
+	return progress
+}
+
+// summary takes the summary of the group with groupID once none of its
+// shards is running, returning nil while one is or when the runner ran
+// none
+func (t *groupTracker) summary(groupID string) *models.GroupSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	group, ok := t.groups[groupID]
+	if !ok {
+		return nil
+	}
+	for _, run := range group.runs {
+		if run.result == nil {
+			return nil
+		}
+	}
+	delete(t.groups, groupID)
+	for taskID := range group.runs {
+		delete(t.byTask, taskID)
+	}
+	if len(group.runs) == 0 {
+		return nil
+	}
+
+	summary := &models.GroupSummary{
+		GroupID:     group.id,
+		Shards:      group.shards,
+		CancelledBy: group.cancelledBy,
+		StartedAt:   group.startedAt,
+		FinishedAt:  group.finishedAt,
+	}
+	for _, run := range group.runs {
+		summary.ShardResults = append(summary.ShardResults, *run.result)
+		if run.result.Status == models.TaskStatusCompleted {
+			summary.Completed++
+		} else {
+			summary.Failed++
+		}
+		summary.TotalDurationMs += run.result.DurationMs
+		summary.MaxDurationMs = max(summary.MaxDurationMs, run.result.DurationMs)
+	}
+	sort.Slice(summary.ShardResults, func(i, j int) bool {
+		return summary.ShardResults[i].Shard < summary.ShardResults[j].Shard
+	})
+	summary.MeanDurationMs = summary.TotalDurationMs / int64(len(summary.ShardResults))
+	return summary
+}
+
+// admitGroup declines the shards of a group with shared fate once one of
+// them failed
+func (h *DefaultTaskHandler) admitGroup(task *models.Task) *admissionError {
+	if task.Group == nil {
+		return nil
+	}
+	if failed := h.groups.cancelledBy(task.Group.ID); failed != "" {
+		return &admissionError{reason: models.FLDeclineGroupCancelled, err: fmt.Errorf("shard %s of group %s failed", failed, task.Group.ID)}
+	}
+	return nil
+}
+
+// withGroup records a shard of a group as running until the returned
+// func is called, returning a context cancelled with ErrGroupCancelled
+// should a sibling fail in a group with shared fate
+func (h *DefaultTaskHandler) withGroup(ctx context.Context, task *models.Task) (context.Context, func()) {
+	if task.Group == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	h.groups.start(task, h.clock.Now(), cancel)
+	return ctx, func() {
+		h.groups.stop(task.ID.String())
+		cancel(nil)
+	}
+}
+
+// groupCancelled returns why the shard running under ctx was cancelled
+// with its group, or nil
+func groupCancelled(ctx context.Context) error {
+	if cause := context.Cause(ctx); errors.Is(cause, ErrGroupCancelled) {
+		return cause
+	}
+	return nil
+}
+
+// finishShard records the outcome of a shard of a group
+func (h *DefaultTaskHandler) finishShard(task *models.Task, run clock.Stopwatch, status models.TaskStatus, result *models.TaskResult, cancelled bool) {
+	if task.Group == nil {
+		return
+	}
+	shard := models.ShardSummary{
+		Status:     status,
+		DurationMs: run.Elapsed().Milliseconds(),
+		Cancelled:  cancelled,
+	}
+	if result != nil {
+		shard.ExitCode = result.ExitCode
+	}
+	if h.groups.finish(task.ID.String(), shard, h.clock.Now()) {
+		log := gologger.WithComponent("task_handler")
+		log.Warn().
+			Str("id", task.ID.String()).
+			Str("group", task.Group.ID).
+			Int("shard", task.Group.Shard).
+			Msg("Cancelling task group - a shard failed and the group shares its fate")
+	}
+}
+
+// reportCancelledShard reports a shard stopped because its group was
+// cancelled as failed with cause
+func (h *DefaultTaskHandler) reportCancelledShard(task *models.Task, run clock.Stopwatch, appliedTimeout *models.AppliedTimeout, cause error) (models.TaskStatus, *models.TaskResult, error) {
+	log := gologger.WithComponent("task_handler")
+	log.Info().
+		Str("id", task.ID.String()).
+		Str("group", task.Group.ID).
+		Msg("Task cancelled with its group")
+
+	failure := &models.TaskResult{
+		TaskID:         task.ID,
+		Error:          cause.Error(),
+		AppliedTimeout: appliedTimeout,
+	}
+	h.stampResult(failure, run)
+	h.finishShard(task, run, models.TaskStatusFailed, failure, true)
+	h.accountResult(task, run, models.TaskStatusFailed, failure)
+	failure.Timeline = h.TaskTimeline(task.ID.String())
+	if err := h.taskClient.UpdateTaskStatus(task.ID.String(), models.TaskStatusFailed, failure); err != nil {
+		log.Error().Err(err).Str("id", task.ID.String()).Msg("Failed to update task status")
+	} else {
+		h.account(task, h.journal.Reported)
+		h.finishTimeline(task, models.AttemptReported)
+	}
+	return models.TaskStatusFailed, failure, cause
+}
+
+// nextShard returns the shard of group waiting to be claimed with the
+// lowest index that the runner has neither run nor tried, or nil when
+// there is none or the group was cancelled
+func (h *DefaultTaskHandler) nextShard(group *models.TaskGroup, tried map[string]bool) *models.Task {
+	if h.groups.cancelledBy(group.ID) != "" {
+		return nil
+	}
+	lister, ok := h.taskClient.(AvailableTaskLister)
+	if !ok {
+		return nil
+	}
+	tasks, err := lister.GetAvailableTasks()
+	if err != nil {
+		log := gologger.WithComponent("task_handler")
+		log.Debug().Err(err).Str("group", group.ID).Msg("Failed to list shards of task group")
+		return nil
+	}
+	var next *models.Task
+	for _, task := range tasks {
+		if task.Group == nil || task.Group.ID != group.ID || tried[task.ID.String()] || h.groups.ran(task.ID.String()) {
+			continue
+		}
+		if task.Status != "" && task.Status != models.TaskStatusPending {
+			continue
+		}
+		if next == nil || task.Group.Shard < next.Group.Shard {
+			next = task
+		}
+	}
+	return next
+}
+
+// submitGroupSummary submits the summary of group once the runner has no
+// more of its shards to run
+func (h *DefaultTaskHandler) submitGroupSummary(group *models.TaskGroup) {
+	summary := h.groups.summary(group.ID)
+	if summary == nil {
+		return
+	}
+	log := gologger.WithComponent("task_handler")
+	submitter, ok := h.taskClient.(GroupSummarySubmitter)
+	if !ok {
+		return
+	}
+	err := submitter.SubmitGroupSummary(summary)
+	switch {
+	case errors.Is(err, ErrGroupSummaryUnsupported):
+		log.Debug().Str("group", group.ID).Msg("Server does not take group summaries")
+	case err != nil:
+		log.Warn().Err(err).Str("group", group.ID).Msg("Failed to submit task group summary")
+	default:
+		log.Info().
+			Str("group", group.ID).
+			Int("completed", summary.Completed).
+			Int("failed", summary.Failed).
+			Msg("Submitted task group summary")
+	}
+}
@@ -0,0 +1,240 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+// groupClient offers the available tasks until they are claimed and
+// records group summaries
+type groupClient struct {
+	releasingClient
+	available []*models.Task
+	claimed   []string
+	summaries []*models.GroupSummary
+}
+
+func (c *groupClient) UpdateTaskStatus(taskID string, status models.TaskStatus, result *models.TaskResult) error {
+	if status == models.TaskStatusRunning {
+		c.mu.Lock()
+		c.claimed = append(c.claimed, taskID)
+		for i, task := range c.available {
+			if task.ID.String() == taskID {
+				c.available = append(c.available[:i], c.available[i+1:]...)
+				break
+			}
+		}
+		c.mu.Unlock()
+	}
+	return c.releasingClient.UpdateTaskStatus(taskID, status, result)
+}
+
+func (c *groupClient) GetAvailableTasks() ([]*models.Task, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*models.Task(nil), c.available...), nil
+}
+
+func (c *groupClient) SubmitGroupSummary(summary *models.GroupSummary) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.summaries = append(c.summaries, summary)
+	return nil
+}
+
+// shardExecutor records the order it runs shards in, failing those listed
+type shardExecutor struct {
+	ran  []int
+	fail map[int]bool
+}
+
+func (e *shardExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	e.ran = append(e.ran, task.Group.Shard)
+	result := &models.TaskResult{TaskID: task.ID, Output: "shard done"}
+	if e.fail[task.Group.Shard] {
+		result.ExitCode = 1
+	}
+	return result, nil
+}
+
+func newShard(t *testing.T, group string, shard, shards int, sharedFate bool) *models.Task {
+	t.Helper()
+	task := newDockerTask(t)
+	task.Status = models.TaskStatusPending
+	task.Group = &models.TaskGroup{ID: group, Shard: shard, Shards: shards, SharedFate: sharedFate}
+	return task
+}
+
+func newGroupHandler(executor *shardExecutor, client *groupClient) *DefaultTaskHandler {
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	return h
+}
+
+func TestGroupShardsAreClaimedTogether(t *testing.T) {
+	first := newShard(t, "sweep-1", 0, 3, false)
+	other := newDockerTask(t)
+	other.Status = models.TaskStatusPending
+	client := &groupClient{available: []*models.Task{
+		other,
+		newShard(t, "sweep-1", 2, 3, false),
+		newShard(t, "sweep-2", 0, 2, false),
+		newShard(t, "sweep-1", 1, 3, false),
+	}}
+	executor := &shardExecutor{fail: map[int]bool{1: true}}
+	h := newGroupHandler(executor, client)
+
+	if err := h.HandleTask(first); err != nil {
+		t.Fatalf("HandleTask() error = %v", err)
+	}
+	// A failed shard does not stop its siblings without shared fate
+	if len(executor.ran) != 3 || executor.ran[0] != 0 || executor.ran[1] != 1 || executor.ran[2] != 2 {
+		t.Fatalf("ran shards %v, want the group's three in order", executor.ran)
+	}
+	if len(client.available) != 2 {
+		t.Errorf("claimed %v, want only the group's shards", client.claimed)
+	}
+
+	if len(client.summaries) != 1 {
+		t.Fatalf("submitted %d summaries, want one", len(client.summaries))
+	}
+	summary := client.summaries[0]
+	if summary.GroupID != "sweep-1" || summary.Shards != 3 || summary.Completed != 2 || summary.Failed != 1 || summary.CancelledBy != "" {
+		t.Errorf("summary = %+v", summary)
+	}
+	if len(summary.ShardResults) != 3 || summary.ShardResults[1].Status != models.TaskStatusFailed || summary.ShardResults[1].ExitCode != 1 {
+		t.Errorf("shard results = %+v", summary.ShardResults)
+	}
+}
+
+func TestSharedFateCancelsGroup(t *testing.T) {
+	first := newShard(t, "sweep-1", 0, 4, true)
+	later := newShard(t, "sweep-1", 3, 4, true)
+	client := &groupClient{available: []*models.Task{
+		newShard(t, "sweep-1", 2, 4, true),
+		newShard(t, "sweep-1", 1, 4, true),
+	}}
+	executor := &shardExecutor{fail: map[int]bool{1: true}}
+	h := newGroupHandler(executor, client)
+
+	if err := h.HandleTask(first); err != nil {
+		t.Fatalf("HandleTask() error = %v", err)
+	}
+	if len(executor.ran) != 2 || executor.ran[1] != 1 {
+		t.Fatalf("ran shards %v, want the group cancelled after shard 1 failed", executor.ran)
+	}
+	if len(client.summaries) != 1 || client.summaries[0].CancelledBy == "" || client.summaries[0].Failed != 1 {
+		t.Fatalf("summaries = %+v, want the group cancelled by shard 1", client.summaries)
+	}
+
+	err := h.HandleTask(later)
+	var admission *admissionError
+	if !errors.As(err, &admission) || admission.reason != models.FLDeclineGroupCancelled {
+		t.Fatalf("HandleTask() error = %v, want the cancelled group's shard declined", err)
+	}
+	if len(executor.ran) != 2 {
+		t.Errorf("ran shards %v after the group was cancelled", executor.ran)
+	}
+}
+
+func TestSharedFateStopsRunningShard(t *testing.T) {
+	client := &groupClient{}
+	h := newGroupHandler(&shardExecutor{fail: map[int]bool{0: true}}, client)
+	running := newShard(t, "sweep-1", 1, 2, true)
+
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- h.runTask(running, nil, func(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+			close(started)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+	}()
+	<-started
+
+	if err := h.HandleTask(newShard(t, "sweep-1", 0, 2, true)); err != nil {
+		t.Fatalf("HandleTask() error = %v", err)
+	}
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrGroupCancelled) {
+			t.Fatalf("running shard error = %v, want ErrGroupCancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("running shard was not stopped")
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	failed := 0
+	for _, status := range client.statuses {
+		if status == models.TaskStatusFailed {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Errorf("statuses = %v, want both shards reported failed", client.statuses)
+	}
+}
+
+func TestGroupTrackerSummaryAndProgress(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC))
+	tracker := newGroupTracker()
+	shards := make([]*models.Task, 3)
+	for i := range shards {
+		shards[i] = &models.Task{ID: uuid.New(), Group: &models.TaskGroup{ID: "sweep-1", Shard: 2 - i, Shards: 4}}
+	}
+	var mu sync.Mutex
+	stopped := 0
+	stop := func(error) { mu.Lock(); stopped++; mu.Unlock() }
+
+	start := clk.Now()
+	tracker.start(shards[0], clk.Now(), stop)
+	tracker.start(shards[1], clk.Now(), stop)
+	if p := tracker.progress(shards[0].ID.String(), 50); p == nil || p.Running != 2 || p.Percent != 12.5 {
+		t.Errorf("progress = %+v, want half of one shard in four done", p)
+	}
+
+	clk.Advance(3 * time.Second)
+	tracker.finish(shards[0].ID.String(), models.ShardSummary{Status: models.TaskStatusCompleted, DurationMs: 3000}, clk.Now())
+	if tracker.summary("sweep-1") != nil {
+		t.Fatal("summary taken while a shard is running")
+	}
+	tracker.start(shards[2], clk.Now(), stop)
+	clk.Advance(time.Second)
+	tracker.finish(shards[1].ID.String(), models.ShardSummary{Status: models.TaskStatusFailed, ExitCode: 2, DurationMs: 4000}, clk.Now())
+	if p := tracker.progress(shards[2].ID.String(), 50); p.Completed != 1 || p.Failed != 1 || p.Running != 1 || p.Percent != 62.5 {
+		t.Errorf("progress = %+v", p)
+	}
+	// A shard returned to the queue is left out
+	tracker.stop(shards[2].ID.String())
+
+	summary := tracker.summary("sweep-1")
+	if summary == nil {
+		t.Fatal("no summary once every shard finished")
+	}
+	if len(summary.ShardResults) != 2 || summary.ShardResults[0].Shard != 1 || summary.ShardResults[1].Shard != 2 {
+		t.Errorf("shard results = %+v, want shards 1 and 2 in order", summary.ShardResults)
+	}
+	if summary.Completed != 1 || summary.Failed != 1 || summary.TotalDurationMs != 7000 || summary.MeanDurationMs != 3500 || summary.MaxDurationMs != 4000 {
+		t.Errorf("summary = %+v", summary)
+	}
+	if !summary.StartedAt.Equal(start) || !summary.FinishedAt.Equal(start.Add(4*time.Second)) {
+		t.Errorf("summary ran from %v to %v", summary.StartedAt, summary.FinishedAt)
+	}
+	if stopped != 0 || tracker.cancelledBy("sweep-1") != "" {
+		t.Error("group without shared fate was cancelled")
+	}
+	if tracker.summary("sweep-1") != nil {
+		t.Error("summary taken twice")
+	}
+}
@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/fleetguard"
+)
+
+// ErrDuplicateExecution is returned for a task another runner of the fleet
+// is already executing; this runner's claim on it has been released
+var ErrDuplicateExecution = errors.New("task already executing on another runner")
+
+// executionGuardMargin keeps an intent past the task's timeout, covering
+// result submission
+const executionGuardMargin = time.Minute
+
+// ClaimReleaser is implemented by task clients that can give up a claim
+// without returning the task to the queue
+type ClaimReleaser interface {
+	ReleaseTask(taskID string, lease *models.TaskLease) error
+}
+
+// SetExecutionGuard records an intent with guard before executing each task,
+// standing down from tasks another runner of the fleet already executes
+func (h *DefaultTaskHandler) SetExecutionGuard(guard *fleetguard.Guard) {
+	h.guard = guard
+}
+
+// guardExecution records the intent to execute task for up to timeout. When
+// another runner holds the task, the claim on it is released and an error
+// wrapping ErrDuplicateExecution is returned.
+func (h *DefaultTaskHandler) guardExecution(ctx context.Context, task *models.Task, lease *models.TaskLease, timeout time.Duration) (*fleetguard.Intent, error) {
+	if h.guard == nil {
+		return nil, nil
+	}
+	intent, err := h.guard.Acquire(ctx, task, timeout+executionGuardMargin)
+	if err == nil {
+		return intent, nil
+	}
+
+	log := gologger.WithComponent("task_handler")
+	if releaser, ok := h.taskClient.(ClaimReleaser); ok {
+		if releaseErr := releaser.ReleaseTask(task.ID.String(), lease); releaseErr != nil {
+			log.Error().Err(releaseErr).Str("id", task.ID.String()).Msg("Failed to release task claim")
+		}
+	}
+	log.Warn().Str("id", task.ID.String()).Msg("Released task claim - task already executing on another runner")
+	return nil, fmt.Errorf("%w: %v", ErrDuplicateExecution, err)
+}
+
+// releaseExecution gives up intent once this runner stops executing its
+// task. A task handed off keeps its intent for the upgraded runner, which
+// holds it under the same name.
+func (h *DefaultTaskHandler) releaseExecution(intent *fleetguard.Intent) {
+	if h.guard == nil || h.handoff.active.Load() {
+		return
+	}
+	h.guard.Release(intent)
+}
+
+// newExecutionGuard returns the guard the execution guard config describes,
+// recording intents as holder
+func newExecutionGuard(cfg config.ExecutionGuardConfig, holder string) (*fleetguard.Guard, error) {
+	var store fleetguard.Store
+	switch cfg.Mode {
+	case "http":
+		if cfg.URL == "" {
+			return nil, errors.New("execution guard URL is required in http mode")
+		}
+		store = fleetguard.NewHTTPStore(cfg.URL)
+	case "file":
+		if cfg.Dir == "" {
+			return nil, errors.New("execution guard directory is required in file mode")
+		}
+		fileStore, err := fleetguard.NewFileStore(cfg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	default:
+		return nil, fmt.Errorf("unknown execution guard mode %q", cfg.Mode)
+	}
+	return fleetguard.New(store, holder, cfg.Timeout), nil
+}
@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/fleetguard"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+// releasingClient records the statuses and claim releases of one runner
+type releasingClient struct {
+	mu       sync.Mutex
+	statuses []models.TaskStatus
+	released []string
+}
+
+func (c *releasingClient) FetchTask() (*models.Task, error) { return nil, nil }
+
+func (c *releasingClient) UpdateTaskStatus(taskID string, status models.TaskStatus, result *models.TaskResult) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.statuses = append(c.statuses, status)
+	return nil
+}
+
+func (c *releasingClient) ReleaseTask(taskID string, lease *models.TaskLease) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.released = append(c.released, taskID)
+	return nil
+}
+
+// gatedExecutor counts executions, each of which waits for the gate
+type gatedExecutor struct {
+	calls *atomic.Int32
+	gate  chan struct{}
+}
+
+func (e *gatedExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	e.calls.Add(1)
+	<-e.gate
+	return &models.TaskResult{TaskID: task.ID}, nil
+}
+
+func TestExecutionGuardDedupesRacingRunners(t *testing.T) {
+	store, err := fleetguard.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var calls atomic.Int32
+	gate := make(chan struct{})
+	task := newDockerTask(t)
+
+	// Both runners believe they hold the lease on the same task
+	var handlers []*DefaultTaskHandler
+	var clients []*releasingClient
+	for _, holder := range []string{"device:8090", "device:8091"} {
+		client := &releasingClient{}
+		h := NewTaskHandler(&gatedExecutor{calls: &calls, gate: gate}, client)
+		h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+		h.SetExecutionGuard(fleetguard.New(store, holder, time.Second))
+		handlers = append(handlers, h)
+		clients = append(clients, client)
+	}
+
+	errs := make([]error, len(handlers))
+	var wg sync.WaitGroup
+	for i, h := range handlers {
+		wg.Add(1)
+		go func(i int, h *DefaultTaskHandler) {
+			defer wg.Done()
+			_, _, errs[i] = h.runTaskOutcome(task, nil, h.executor.ExecuteTask)
+		}(i, h)
+	}
+
+	// The runner that lost the race stands down without executing
+	deadline := time.After(5 * time.Second)
+	for duplicates := 0; duplicates == 0; {
+		select {
+		case <-deadline:
+			t.Fatal("neither runner stood down")
+		case <-time.After(10 * time.Millisecond):
+		}
+		for _, h := range handlers {
+			duplicates += int(h.guard.Stats().Duplicates)
+		}
+	}
+	close(gate)
+	wg.Wait()
+
+	if got := calls.Load(); got != 1 {
+		t.Fatalf("task executed %d times, want 1", got)
+	}
+	var executed, stoodDown int
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			executed = i
+		case errors.Is(err, ErrDuplicateExecution):
+			stoodDown = i
+		default:
+			t.Fatalf("runner %d error = %v", i, err)
+		}
+	}
+	if executed == stoodDown {
+		t.Fatalf("runner errors = %v, want one execution and one duplicate", errs)
+	}
+	if got := clients[stoodDown].released; len(got) != 1 || got[0] != task.ID.String() {
+		t.Errorf("duplicate runner released %v, want its claim on the task", got)
+	}
+	if got := clients[stoodDown].statuses; len(got) != 0 {
+		t.Errorf("duplicate runner reported statuses %v, want none", got)
+	}
+	if got := clients[executed].statuses; len(got) != 1 || got[0] != models.TaskStatusCompleted {
+		t.Errorf("executing runner reported statuses %v, want completed", got)
+	}
+
+	// The intent is released with the execution, so a retry can run
+	if _, err := fleetguard.New(store, "device:8092", time.Second).Acquire(context.Background(), task, time.Minute); err != nil {
+		t.Errorf("Acquire() after execution error = %v", err)
+	}
+}
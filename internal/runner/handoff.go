@@ -0,0 +1,318 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+)
+
+// ErrHandedOff is returned for a task passed to an upgraded runner process
+var ErrHandedOff = errors.New("task handed off to upgraded runner")
+
+const (
+	// HandoffFDEnv names the inherited socket an upgraded runner process
+	// reads its handoff from
+	HandoffFDEnv = "PARITY_HANDOFF_FD"
+
+	handoffVersion = 1
+	// handoffPollInterval paces the wait for the in-flight task to let go
+	handoffPollInterval = 100 * time.Millisecond
+	// handoffAckTimeout bounds how long the new process may take to start
+	// and take over before the old one resumes its work
+	handoffAckTimeout = 2 * time.Minute
+)
+
+// HandoffExecutor is implemented by executors that can leave task containers
+// running for another runner process to adopt
+type HandoffExecutor interface {
+	Detach()
+	Attach()
+	ReattachTask(ctx context.Context, task *models.Task, detached *docker.DetachedContainer) (*models.TaskResult, error)
+	DiscardDetached(ctx context.Context, detached *docker.DetachedContainer) error
+}
+
+// HandoffTask is a claimed task passed between runner processes. Tasks with
+// a container are adopted in place; the rest are resumed from the start.
+type HandoffTask struct {
+	Task      *models.Task              `json:"task"`
+	Lease     *models.TaskLease         `json:"lease,omitempty"`
+	Container *docker.DetachedContainer `json:"container,omitempty"`
+}
+
+// HandoffState is everything a runner process passes to its replacement
+type HandoffState struct {
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"created_at"`
+	Tasks     []HandoffTask `json:"tasks"`
+}
+
+// Handoff is what a runner process inherited from the process it replaced
+type Handoff struct {
+	State    HandoffState
+	Listener net.Listener
+	// Lock is the locked data directory lock file, nil when the previous
+	// process held no lock
+	Lock *os.File
+	ack  func(err error) error
+}
+
+// Ack tells the previous process whether this one took over. On error the
+// previous process resumes its tasks and keeps serving.
+func (h *Handoff) Ack(err error) error {
+	if h == nil || h.ack == nil {
+		return nil
+	}
+	ack := h.ack
+	h.ack = nil
+	return ack(err)
+}
+
+// handoffState tracks the running tasks so a handoff can release them, and
+// the tasks released so far
+type handoffState struct {
+	active  atomic.Bool
+	mu      sync.Mutex
+	running map[*models.Task]context.CancelFunc
+	tasks   []HandoffTask
+}
+
+func (s *handoffState) track(task *models.Task, cancel context.CancelFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running == nil {
+		s.running = make(map[*models.Task]context.CancelFunc)
+	}
+	s.running[task] = cancel
+}
+
+func (s *handoffState) untrack(task *models.Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.running, task)
+}
+
+// abort cancels the running tasks, or only those that cannot be detached
+func (s *handoffState) abort(detachable bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for task, cancel := range s.running {
+		if detachable && task.Type == models.TaskTypeDocker {
+			continue
+		}
+		cancel()
+	}
+}
+
+func (s *handoffState) record(task *models.Task, lease *models.TaskLease, err error) {
+	handoff := HandoffTask{Task: task, Lease: lease}
+	var detached *docker.DetachedError
+	if errors.As(err, &detached) {
+		handoff.Container = detached.Container
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tasks = append(s.tasks, handoff)
+}
+
+// Handoff stops the handler taking tasks and releases those in flight for
+// another runner process. Docker task containers are detached and left
+// running; other tasks, and containers still being prepared when ctx ends,
+// are cancelled and will be resumed from the start.
+func (h *DefaultTaskHandler) Handoff(ctx context.Context) []HandoffTask {
+	h.SetDraining(true)
+	h.handoff.active.Store(true)
+
+	executor, detachable := h.executor.(HandoffExecutor)
+	if detachable {
+		executor.Detach()
+	}
+	h.handoff.abort(detachable)
+
+	ticker := h.clock.NewTicker(handoffPollInterval)
+	defer ticker.Stop()
+
+	done := ctx.Done()
+	for h.isProcessing.Load() {
+		select {
+		case <-done:
+			h.handoff.abort(false)
+			done = nil
+		case <-ticker.C():
+		}
+	}
+
+	h.handoff.mu.Lock()
+	defer h.handoff.mu.Unlock()
+	tasks := h.handoff.tasks
+	h.handoff.tasks = nil
+	return tasks
+}
+
+// CancelHandoff returns the handler to normal operation after a handoff the
+// new process did not take over
+func (h *DefaultTaskHandler) CancelHandoff() {
+	if executor, ok := h.executor.(HandoffExecutor); ok {
+		executor.Attach()
+	}
+	h.handoff.active.Store(false)
+	h.SetDraining(false)
+}
+
+// AdoptTask continues a task handed over by another runner process, waiting
+// on its container when it was left running and executing it again
+// otherwise
+func (h *DefaultTaskHandler) AdoptTask(handoff HandoffTask) error {
+	if handoff.Task.Type == models.TaskTypeLLM {
+		return fmt.Errorf("LLM tasks cannot be resumed")
+	}
+
+	execute := h.executor.ExecuteTask
+	if handoff.Container != nil {
+		executor, ok := h.executor.(HandoffExecutor)
+		if !ok {
+			return fmt.Errorf("executor cannot adopt running containers")
+		}
+		execute = func(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+			return executor.ReattachTask(ctx, task, handoff.Container)
+		}
+	}
+
+	if !h.beginExclusive() {
+		return fmt.Errorf("task already in progress")
+	}
+	defer h.end()
+
+	log := gologger.WithComponent("task_handler")
+	event := log.Info().Str("id", handoff.Task.ID.String())
+	if handoff.Container != nil {
+		event = event.Str("container_id", handoff.Container.ContainerID)
+	}
+	event.Msg("Adopting task from previous runner process")
+
+	return h.runTask(handoff.Task, handoff.Lease, execute)
+}
+
+// discardHandoff removes what is left of a handed off task this process
+// will not continue
+func (h *DefaultTaskHandler) discardHandoff(handoff HandoffTask) {
+	if handoff.Container == nil {
+		return
+	}
+	executor, ok := h.executor.(HandoffExecutor)
+	if !ok {
+		return
+	}
+	if err := executor.DiscardDetached(context.Background(), handoff.Container); err != nil {
+		log := gologger.WithComponent("task_handler")
+		log.Warn().Err(err).Str("id", handoff.Task.ID.String()).Msg("Failed to remove abandoned task container")
+	}
+}
+
+// UpgradeBinary returns the path the running binary was started from, which
+// after an in-place upgrade holds the new build
+func UpgradeBinary() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate runner binary: %w", err)
+	}
+	return strings.TrimSuffix(path, " (deleted)"), nil
+}
+
+// Upgrade hands the runner over to a new process started from binary with
+// args. Running task containers, leases, the webhook socket and the data
+// directory lock move to the new process; ctx bounds the wait for the in-flight task to be released.
+// On success the caller should exit without stopping the service. On error
+// this process resumes where it left off.
+func (s *Service) Upgrade(ctx context.Context, binary string, args []string) error {
+	log := gologger.WithComponent("runner")
+
+	handler, ok := s.taskHandler.(*DefaultTaskHandler)
+	if !ok || s.webhookClient == nil {
+		return fmt.Errorf("runner cannot hand off its tasks")
+	}
+
+	log.Info().Str("binary", binary).Msg("Handing off to upgraded runner")
+
+	s.healthChecker.SetDraining(true)
+	tasks := handler.Handoff(ctx)
+
+	listener, err := s.webhookClient.Handoff(ctx)
+	if err != nil {
+		s.cancelUpgrade(handler, tasks, nil)
+		return fmt.Errorf("failed to hand off webhook listener: %w", err)
+	}
+	defer listener.Close()
+
+	state := HandoffState{Version: handoffVersion, CreatedAt: s.clock.Now(), Tasks: tasks}
+	files := []*os.File{listener}
+	if s.dataLock != nil {
+		// The new process shares the lock, keeping the directory locked
+		// across the handoff
+		files = append(files, s.dataLock.File())
+	}
+	pid, err := startHandoffProcess(binary, args, state, files)
+	if err != nil {
+		s.cancelUpgrade(handler, tasks, listener)
+		return err
+	}
+
+	if s.healthCancel != nil {
+		s.healthCancel()
+	}
+	if s.tunnelClient != nil {
+		if err := s.tunnelClient.Stop(); err != nil {
+			log.Warn().Err(err).Msg("Failed to stop tunnel client")
+		}
+	}
+	if err := s.notifier.Notify(fmt.Sprintf("MAINPID=%d", pid)); err != nil {
+		log.Warn().Err(err).Msg("Failed to notify systemd of the new main process")
+	}
+	if s.dockerClient != nil {
+		s.dockerClient.Close()
+	}
+
+	log.Info().Int("pid", pid).Int("tasks", len(tasks)).Msg("Upgraded runner took over")
+	return nil
+}
+
+// cancelUpgrade resumes serving and the handed off tasks after an upgrade
+// the new process did not take over
+func (s *Service) cancelUpgrade(handler *DefaultTaskHandler, tasks []HandoffTask, listener *os.File) {
+	log := gologger.WithComponent("runner")
+
+	if listener != nil {
+		ln, err := net.FileListener(listener)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to restore webhook listener")
+		} else {
+			s.webhookClient.SetListener(ln)
+		}
+		if err := s.webhookClient.Start(); err != nil {
+			log.Error().Err(err).Msg("Failed to restart webhook client after failed upgrade")
+		}
+	}
+
+	handler.CancelHandoff()
+	s.healthChecker.SetDraining(false)
+
+	go func() {
+		for _, task := range tasks {
+			if err := handler.AdoptTask(task); err != nil {
+				log.Error().Err(err).Str("task_id", task.Task.ID.String()).Msg("Failed to resume task after failed upgrade")
+				handler.discardHandoff(task)
+			}
+		}
+	}()
+}
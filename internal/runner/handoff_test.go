@@ -0,0 +1,134 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+// detachingExecutor runs tasks until it is detached, then reports a running
+// container the way the docker executor does
+type detachingExecutor struct {
+	mu         sync.Mutex
+	started    chan struct{}
+	detach     chan struct{}
+	reattached *docker.DetachedContainer
+}
+
+func newDetachingExecutor() *detachingExecutor {
+	return &detachingExecutor{started: make(chan struct{}), detach: make(chan struct{})}
+}
+
+func (e *detachingExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	close(e.started)
+	select {
+	case <-e.detach:
+		return nil, &docker.DetachedError{Container: &docker.DetachedContainer{ContainerID: "c1"}}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (e *detachingExecutor) Detach() { close(e.detach) }
+
+func (e *detachingExecutor) Attach() { e.detach = make(chan struct{}) }
+
+func (e *detachingExecutor) ReattachTask(ctx context.Context, task *models.Task, detached *docker.DetachedContainer) (*models.TaskResult, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reattached = detached
+	return &models.TaskResult{TaskID: task.ID, Output: "done"}, nil
+}
+
+func (e *detachingExecutor) DiscardDetached(ctx context.Context, detached *docker.DetachedContainer) error {
+	return nil
+}
+
+// blockingExecutor runs tasks until they are cancelled
+type blockingExecutor struct {
+	started chan struct{}
+}
+
+func (e *blockingExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	close(e.started)
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func newDockerTask(t *testing.T) *models.Task {
+	t.Helper()
+	raw, err := json.Marshal(models.TaskConfig{ImageName: "alpine"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker, Nonce: "abcdef", Config: raw}
+}
+
+func TestHandoffDetachesAndAdoptsRunningTask(t *testing.T) {
+	executor := newDetachingExecutor()
+	client := &fakeFLClient{}
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+
+	task := newDockerTask(t)
+	done := make(chan error, 1)
+	go func() { done <- h.HandleTask(task) }()
+	<-executor.started
+
+	tasks := h.Handoff(context.Background())
+	if err := <-done; !errors.Is(err, ErrHandedOff) {
+		t.Fatalf("HandleTask() error = %v, want ErrHandedOff", err)
+	}
+	if len(tasks) != 1 || tasks[0].Task.ID != task.ID || tasks[0].Container == nil || tasks[0].Container.ContainerID != "c1" {
+		t.Fatalf("Handoff() = %+v, want the task with its container", tasks)
+	}
+	if len(client.statuses) != 1 || client.statuses[0] != models.TaskStatusRunning {
+		t.Fatalf("handed off task was reported as %v", client.statuses)
+	}
+	if !h.draining.Load() {
+		t.Fatal("handler takes new tasks after a handoff")
+	}
+
+	next := NewTaskHandler(executor, client)
+	if err := next.AdoptTask(tasks[0]); err != nil {
+		t.Fatalf("AdoptTask() error = %v", err)
+	}
+	if executor.reattached != tasks[0].Container {
+		t.Fatal("adopted task was not reattached to its container")
+	}
+	if last := client.statuses[len(client.statuses)-1]; last != models.TaskStatusCompleted {
+		t.Fatalf("adopted task finished as %s, want completed", last)
+	}
+}
+
+func TestHandoffCancelsUndetachableTask(t *testing.T) {
+	executor := &blockingExecutor{started: make(chan struct{})}
+	h := NewTaskHandler(executor, &fakeFLClient{})
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+
+	task := newDockerTask(t)
+	done := make(chan error, 1)
+	go func() { done <- h.HandleTask(task) }()
+	<-executor.started
+
+	tasks := h.Handoff(context.Background())
+	if err := <-done; !errors.Is(err, ErrHandedOff) {
+		t.Fatalf("HandleTask() error = %v, want ErrHandedOff", err)
+	}
+	if len(tasks) != 1 || tasks[0].Container != nil {
+		t.Fatalf("Handoff() = %+v, want the task to be resumed from the start", tasks)
+	}
+
+	h.CancelHandoff()
+	if h.draining.Load() {
+		t.Fatal("handler still draining after the handoff was cancelled")
+	}
+}
@@ -0,0 +1,265 @@
+//go:build !windows
+
+package runner
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxHandoffFiles bounds the descriptors accepted with a handoff
+const maxHandoffFiles = 8
+
+// maxHandoffSize bounds the serialized handoff state
+const maxHandoffSize = 64 << 20
+
+type handoffAck struct {
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+}
+
+// sendHandoff writes state to conn, passing files alongside it as
+// SCM_RIGHTS ancillary data
+func sendHandoff(conn *net.UnixConn, state HandoffState, files []*os.File) error {
+	if len(files) > maxHandoffFiles {
+		return fmt.Errorf("too many files to hand off: %d", len(files))
+	}
+	body, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode handoff state: %w", err)
+	}
+
+	fds := make([]int, len(files))
+	for i, f := range files {
+		fds[i] = int(f.Fd())
+	}
+
+	header := make([]byte, 8)
+	binary.BigEndian.PutUint64(header, uint64(len(body)))
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	if _, _, err := conn.WriteMsgUnix(header, oob, nil); err != nil {
+		return fmt.Errorf("failed to send handoff header: %w", err)
+	}
+	if _, err := conn.Write(body); err != nil {
+		return fmt.Errorf("failed to send handoff state: %w", err)
+	}
+	return nil
+}
+
+// receiveHandoff reads a handoff written by sendHandoff
+func receiveHandoff(conn *net.UnixConn) (*HandoffState, []*os.File, error) {
+	header := make([]byte, 8)
+	oob := make([]byte, syscall.CmsgSpace(4*maxHandoffFiles))
+	n, oobn, _, _, err := conn.ReadMsgUnix(header, oob)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read handoff header: %w", err)
+	}
+
+	var files []*os.File
+	if oobn > 0 {
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to parse handoff control message: %w", err)
+		}
+		for _, msg := range msgs {
+			fds, err := syscall.ParseUnixRights(&msg)
+			if err != nil {
+				continue
+			}
+			for _, fd := range fds {
+				syscall.CloseOnExec(fd)
+				files = append(files, os.NewFile(uintptr(fd), "handoff"))
+			}
+		}
+	}
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}
+
+	if _, err := io.ReadFull(conn, header[n:]); err != nil {
+		closeFiles()
+		return nil, nil, fmt.Errorf("failed to read handoff header: %w", err)
+	}
+	size := binary.BigEndian.Uint64(header)
+	if size > maxHandoffSize {
+		closeFiles()
+		return nil, nil, fmt.Errorf("handoff state too large: %d bytes", size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		closeFiles()
+		return nil, nil, fmt.Errorf("failed to read handoff state: %w", err)
+	}
+
+	var state HandoffState
+	if err := json.Unmarshal(body, &state); err != nil {
+		closeFiles()
+		return nil, nil, fmt.Errorf("failed to decode handoff state: %w", err)
+	}
+	if state.Version != handoffVersion {
+		closeFiles()
+		return nil, nil, fmt.Errorf("unsupported handoff version %d", state.Version)
+	}
+	return &state, files, nil
+}
+
+// unixConn wraps an inherited or freshly created socket descriptor
+func unixConn(f *os.File) (*net.UnixConn, error) {
+	defer f.Close()
+	conn, err := net.FileConn(f)
+	if err != nil {
+		return nil, err
+	}
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		conn.Close()
+		return nil, fmt.Errorf("handoff descriptor is not a unix socket")
+	}
+	return uc, nil
+}
+
+// handoffEnv is the environment of the process taking over. The handoff
+// socket is always its fd 3, and WATCHDOG_PID is dropped so the new process
+// keeps the systemd watchdog alive once it becomes the main PID.
+func handoffEnv(environ []string) []string {
+	env := make([]string, 0, len(environ)+1)
+	for _, kv := range environ {
+		if strings.HasPrefix(kv, HandoffFDEnv+"=") || strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return append(env, HandoffFDEnv+"=3")
+}
+
+// startHandoffProcess starts binary with args, hands it state and files and
+// waits until it reports that it took over. On success the process is left
+// running on its own and its PID returned.
+func startHandoffProcess(binary string, args []string, state HandoffState, files []*os.File) (int, error) {
+	syscall.ForkLock.RLock()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err == nil {
+		syscall.CloseOnExec(fds[0])
+		syscall.CloseOnExec(fds[1])
+	}
+	syscall.ForkLock.RUnlock()
+	if err != nil {
+		return 0, fmt.Errorf("failed to create handoff socket: %w", err)
+	}
+
+	child := os.NewFile(uintptr(fds[1]), "handoff-child")
+	conn, err := unixConn(os.NewFile(uintptr(fds[0]), "handoff-parent"))
+	if err != nil {
+		child.Close()
+		return 0, fmt.Errorf("failed to open handoff socket: %w", err)
+	}
+	defer conn.Close()
+
+	cmd := exec.Command(binary, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = handoffEnv(os.Environ())
+	cmd.ExtraFiles = []*os.File{child}
+
+	err = cmd.Start()
+	child.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start upgraded runner: %w", err)
+	}
+
+	fail := func(err error) (int, error) {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+
+	if err := sendHandoff(conn, state, files); err != nil {
+		return fail(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(handoffAckTimeout))
+	var ack handoffAck
+	if err := json.NewDecoder(conn).Decode(&ack); err != nil {
+		return fail(fmt.Errorf("upgraded runner did not acknowledge handoff: %w", err))
+	}
+	if !ack.Ready {
+		return fail(fmt.Errorf("upgraded runner failed to take over: %s", ack.Error))
+	}
+
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
+
+// InheritedHandoff returns the handoff passed by the runner process this one
+// replaces, or nil when the process was started normally
+func InheritedHandoff() (*Handoff, error) {
+	value := os.Getenv(HandoffFDEnv)
+	if value == "" {
+		return nil, nil
+	}
+	os.Unsetenv(HandoffFDEnv)
+
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", HandoffFDEnv, err)
+	}
+	conn, err := unixConn(os.NewFile(uintptr(fd), "handoff"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open handoff socket: %w", err)
+	}
+
+	handoff := &Handoff{ack: func(err error) error {
+		defer conn.Close()
+		ack := handoffAck{Ready: err == nil}
+		if err != nil {
+			ack.Error = err.Error()
+		}
+		return json.NewEncoder(conn).Encode(ack)
+	}}
+
+	state, files, err := receiveHandoff(conn)
+	if err != nil {
+		handoff.Ack(err)
+		return nil, err
+	}
+	handoff.State = *state
+
+	for i, f := range files {
+		switch i {
+		case 0:
+			handoff.Listener, err = net.FileListener(f)
+		case 1:
+			handoff.Lock = f
+			continue
+		}
+		f.Close()
+	}
+	if err != nil {
+		err = fmt.Errorf("failed to restore webhook listener: %w", err)
+		handoff.Ack(err)
+		return nil, err
+	}
+	if handoff.Listener == nil {
+		err := errors.New("handoff did not include the webhook listener")
+		handoff.Ack(err)
+		return nil, err
+	}
+	return handoff, nil
+}
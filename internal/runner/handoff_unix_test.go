@@ -0,0 +1,311 @@
+//go:build !windows
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/datalock"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+func TestInheritedHandoffReceivesStateAndListener(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := unixConn(os.NewFile(uintptr(fds[0]), "parent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	t.Setenv(HandoffFDEnv, strconv.Itoa(fds[1]))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	file, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	taskID := uuid.New()
+	state := HandoffState{Version: handoffVersion, Tasks: []HandoffTask{{
+		Task:      &models.Task{ID: taskID, Type: models.TaskTypeDocker},
+		Lease:     &models.TaskLease{TaskID: taskID.String(), TTL: time.Minute},
+		Container: &docker.DetachedContainer{ContainerID: "c1"},
+	}}}
+	sent := make(chan error, 1)
+	go func() { sent <- sendHandoff(parent, state, []*os.File{file}) }()
+
+	handoff, err := InheritedHandoff()
+	if err != nil {
+		t.Fatalf("InheritedHandoff() error = %v", err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("sendHandoff() error = %v", err)
+	}
+	if os.Getenv(HandoffFDEnv) != "" {
+		t.Fatalf("%s still set after the handoff was read", HandoffFDEnv)
+	}
+	tasks := handoff.State.Tasks
+	if len(tasks) != 1 || tasks[0].Task.ID != taskID || tasks[0].Lease.TTL != time.Minute || tasks[0].Container.ContainerID != "c1" {
+		t.Fatalf("received tasks = %+v", tasks)
+	}
+
+	// Only the inherited listener is left to accept connections
+	ln.Close()
+	defer handoff.Listener.Close()
+	accepted := make(chan error, 1)
+	go func() {
+		conn, err := handoff.Listener.Accept()
+		if err == nil {
+			conn.Close()
+		}
+		accepted <- err
+	}()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial inherited listener: %v", err)
+	}
+	conn.Close()
+	if err := <-accepted; err != nil {
+		t.Fatalf("inherited listener Accept() error = %v", err)
+	}
+
+	if err := handoff.Ack(nil); err != nil {
+		t.Fatalf("Ack() error = %v", err)
+	}
+	var ack handoffAck
+	if err := json.NewDecoder(parent).Decode(&ack); err != nil || !ack.Ready {
+		t.Fatalf("ack = %+v, %v, want ready", ack, err)
+	}
+}
+
+func TestInheritedHandoffCarriesDataLock(t *testing.T) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parent, err := unixConn(os.NewFile(uintptr(fds[0]), "parent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer parent.Close()
+	t.Setenv(HandoffFDEnv, strconv.Itoa(fds[1]))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	file, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	dir := t.TempDir()
+	lock, err := datalock.Acquire(dir, datalock.Holder{Version: "1.4.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- sendHandoff(parent, HandoffState{Version: handoffVersion}, []*os.File{file, lock.File()})
+	}()
+
+	handoff, err := InheritedHandoff()
+	if err != nil {
+		t.Fatalf("InheritedHandoff() error = %v", err)
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("sendHandoff() error = %v", err)
+	}
+	defer handoff.Listener.Close()
+	if handoff.Lock == nil {
+		t.Fatal("handoff did not carry the data directory lock")
+	}
+	adopted, err := datalock.Adopt(dir, handoff.Lock, datalock.Holder{Version: "1.5.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer adopted.Release()
+
+	// The previous process exits, closing its copy of the lock file
+	lock.File().Close()
+	_, err = datalock.Acquire(dir, datalock.Holder{})
+	var locked *datalock.LockedError
+	if !errors.As(err, &locked) || locked.Holder.Version != "1.5.0" {
+		t.Fatalf("Acquire() after the handoff = %v, want the upgraded runner holding the directory", err)
+	}
+}
+
+func TestInheritedHandoffWithoutEnvironment(t *testing.T) {
+	t.Setenv(HandoffFDEnv, "")
+	handoff, err := InheritedHandoff()
+	if handoff != nil || err != nil {
+		t.Fatalf("InheritedHandoff() = %v, %v, want nil", handoff, err)
+	}
+}
+
+const handoffChildEnv = "PARITY_TEST_HANDOFF_CHILD"
+
+// TestLiveHandoffToNewProcess hands a running docker task and the listening
+// socket to a second runner process, here the test binary re-executed, which
+// reattaches to the container and reports its result over the socket
+func TestLiveHandoffToNewProcess(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping live handoff in short mode")
+	}
+	if _, err := exec.LookPath("docker"); err != nil {
+		t.Skip("docker not available")
+	}
+	if err := exec.Command("docker", "image", "inspect", "busybox:latest").Run(); err != nil {
+		t.Skip("busybox image not available locally")
+	}
+
+	image := "parity-handoff-test:latest"
+	dir := t.TempDir()
+	dockerfile := "FROM busybox:latest\nCMD [\"sh\", \"-c\", \"sleep 4; echo done $TASK_NONCE\"]\n"
+	if err := os.WriteFile(filepath.Join(dir, "Dockerfile"), []byte(dockerfile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("docker", "build", "-t", image, dir).CombinedOutput(); err != nil {
+		t.Fatalf("docker build: %v\n%s", err, out)
+	}
+	defer exec.Command("docker", "image", "rm", "-f", image).Run()
+
+	executor, err := newHandoffTestExecutor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewTaskHandler(executor, &fakeFLClient{})
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+
+	raw, _ := json.Marshal(models.TaskConfig{ImageName: image})
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker, Nonce: "abcdef", Config: raw}
+	done := make(chan error, 1)
+	go func() { done <- h.HandleTask(task) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	for {
+		if _, err := executor.FindTaskContainer(ctx, task.ID.String()); err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			t.Fatal("task container did not start")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	tasks := h.Handoff(ctx)
+	if err := <-done; err != ErrHandedOff {
+		t.Fatalf("HandleTask() error = %v, want ErrHandedOff", err)
+	}
+	if len(tasks) != 1 || tasks[0].Container == nil {
+		t.Fatalf("Handoff() = %+v, want the running container", tasks)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	file, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	defer file.Close()
+
+	t.Setenv(handoffChildEnv, "1")
+	state := HandoffState{Version: handoffVersion, CreatedAt: time.Now(), Tasks: tasks}
+	if _, err := startHandoffProcess(os.Args[0], []string{"-test.run=^TestHandoffChildProcess$"}, state, []*os.File{file}); err != nil {
+		t.Fatalf("startHandoffProcess() error = %v", err)
+	}
+	file.Close()
+
+	client := &http.Client{Timeout: time.Minute}
+	resp, err := client.Get("http://" + addr + "/result")
+	if err != nil {
+		t.Fatalf("fetching result from new process: %v", err)
+	}
+	defer resp.Body.Close()
+	var result models.TaskResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 0 || !strings.Contains(result.Output, "done abcdef") {
+		t.Fatalf("adopted task result = %+v, want the container output", result)
+	}
+}
+
+// TestHandoffChildProcess is the new runner process of TestLiveHandoffToNewProcess
+func TestHandoffChildProcess(t *testing.T) {
+	if os.Getenv(handoffChildEnv) != "1" {
+		t.Skip("only run as the new process of a live handoff")
+	}
+
+	handoff, err := InheritedHandoff()
+	if err != nil || handoff == nil {
+		t.Fatalf("InheritedHandoff() = %v, %v", handoff, err)
+	}
+	executor, err := newHandoffTestExecutor()
+	if err != nil {
+		handoff.Ack(err)
+		t.Fatal(err)
+	}
+	handoff.Ack(nil)
+
+	client := &attestingClient{}
+	h := NewTaskHandler(executor, client)
+	for _, task := range handoff.State.Tasks {
+		if err := h.AdoptTask(task); err != nil {
+			t.Errorf("AdoptTask() error = %v", err)
+		}
+	}
+
+	served := make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(served)
+		if len(client.results) == 0 {
+			http.Error(w, "no result", http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(client.results[len(client.results)-1])
+	})}
+	go server.Serve(handoff.Listener)
+	<-served
+	server.Shutdown(context.Background())
+}
+
+func newHandoffTestExecutor() (*docker.DockerExecutor, error) {
+	return docker.NewDockerExecutor(&docker.ExecutorConfig{
+		MemoryLimit:      "256m",
+		CPULimit:         "1",
+		Timeout:          time.Minute,
+		ExecutionTimeout: time.Minute,
+	})
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package runner
+
+import (
+	"errors"
+	"os"
+)
+
+func startHandoffProcess(binary string, args []string, state HandoffState, files []*os.File) (int, error) {
+	return 0, errors.New("runner upgrades with handoff are not supported on windows")
+}
+
+// InheritedHandoff always returns nil on windows, where runner processes
+// cannot hand off to each other
+func InheritedHandoff() (*Handoff, error) {
+	return nil, nil
+}
@@ -0,0 +1,66 @@
+package runner
+
+import (
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+)
+
+// SetTaskHeartbeat has tasks running without a lease report to sink that
+// they are still running every interval in which they reported nothing
+// themselves, so the server can tell a long task from a runner that is
+// gone. Tasks holding a lease show as much by renewing it.
+func (h *DefaultTaskHandler) SetTaskHeartbeat(sink docker.ProgressSink, interval time.Duration) {
+	h.heartbeats = sink
+	h.heartbeatInterval = interval
+}
+
+// heartbeat reports x's task running until it stops
+func (h *DefaultTaskHandler) heartbeat(x *Execution) {
+	if h.heartbeats == nil || h.heartbeatInterval <= 0 || x.watch != nil {
+		return
+	}
+	log := gologger.WithComponent("task_handler")
+	ticker := h.clock.NewTicker(h.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-x.done:
+			return
+		case now := <-ticker.C():
+			beat := x.nextHeartbeat(now)
+			if beat == nil {
+				continue
+			}
+			if err := h.heartbeats.ReportProgress(x.Task.ID.String(), beat); err != nil {
+				log.Debug().Err(err).Str("id", x.Task.ID.String()).Msg("Failed to send task heartbeat")
+			}
+		}
+	}
+}
+
+// nextHeartbeat returns the heartbeat to send at now, with the last
+// percentage the task reported, or nil when it reported since the last
+// heartbeat was due
+func (x *Execution) nextHeartbeat(now time.Time) *models.TaskProgress {
+	last := x.lastProgress.Load()
+	if last != x.heartbeatSeen {
+		x.heartbeatSeen = last
+		return nil
+	}
+	beat := &models.TaskProgress{
+		Time:      now,
+		Heartbeat: true,
+		ElapsedMs: x.run.Elapsed().Milliseconds(),
+	}
+	if last != nil {
+		beat.Percent = last.Percent
+	}
+	if deadline, ok := x.ctx.Deadline(); ok {
+		beat.Deadline = &deadline
+	}
+	return beat
+}
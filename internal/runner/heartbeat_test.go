@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// beatRecorder records the heartbeats sent
+type beatRecorder chan *models.TaskProgress
+
+func (r beatRecorder) ReportProgress(_ string, progress *models.TaskProgress) error {
+	r <- progress
+	return nil
+}
+
+func newHeartbeatExecution(t *testing.T, clk *clocktest.Fake) *Execution {
+	t.Helper()
+	ctx, cancel := context.WithDeadline(context.Background(), clk.Now().Add(time.Hour))
+	t.Cleanup(cancel)
+	return &Execution{
+		Task: &models.Task{ID: uuid.New(), Type: models.TaskTypeCommand},
+		ctx:  ctx,
+		run:  clock.Start(clk),
+		done: make(chan struct{}),
+	}
+}
+
+func TestHeartbeatOfSilentTask(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	beats := make(beatRecorder, 1)
+	h := &DefaultTaskHandler{clock: clk}
+	h.SetTaskHeartbeat(beats, time.Minute)
+	x := newHeartbeatExecution(t, clk)
+	deadline, _ := x.ctx.Deadline()
+
+	stopped := make(chan struct{})
+	go func() {
+		h.heartbeat(x)
+		close(stopped)
+	}()
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute)
+
+	beat := <-beats
+	if !beat.Heartbeat || beat.ElapsedMs != time.Minute.Milliseconds() || beat.Deadline == nil || !beat.Deadline.Equal(deadline) {
+		t.Errorf("heartbeat = %+v, want one minute elapsed and the task's deadline", beat)
+	}
+
+	close(x.done)
+	<-stopped
+}
+
+func TestHeartbeatSkipsReportingTask(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	x := newHeartbeatExecution(t, clk)
+
+	x.lastProgress.Store(&models.TaskProgress{Percent: 40})
+	if beat := x.nextHeartbeat(clk.Now()); beat != nil {
+		t.Fatalf("heartbeat = %+v, want none for a task that just reported", beat)
+	}
+	clk.Advance(time.Minute)
+	beat := x.nextHeartbeat(clk.Now())
+	if beat == nil || beat.Percent != 40 {
+		t.Fatalf("heartbeat = %+v, want one with the last percentage reported", beat)
+	}
+}
+
+func TestNoHeartbeatOfLeasedTask(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC))
+	h := &DefaultTaskHandler{clock: clk}
+	h.SetTaskHeartbeat(make(beatRecorder), time.Minute)
+	x := newHeartbeatExecution(t, clk)
+	x.watch = &leaseWatch{}
+
+	// Returns at once rather than waiting for the task to stop
+	h.heartbeat(x)
+	if clk.Waiters() != 0 {
+		t.Error("heartbeat started for a task renewing its lease")
+	}
+}
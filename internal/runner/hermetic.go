@@ -0,0 +1,31 @@
+package runner
+
+import (
+	"errors"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// HermeticChecker is implemented by executors that run tasks hermetically
+// and can tell before a task is claimed whether they can enforce it
+type HermeticChecker interface {
+	CheckHermetic(task *models.Task) error
+}
+
+// admitHermetic skips hermetic tasks the executor cannot run
+// hermetically, naming the requirements it cannot enforce
+func (h *DefaultTaskHandler) admitHermetic(task *models.Task) *admissionError {
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil || !config.Hermetic {
+		return nil
+	}
+	checker, ok := h.executor.(HermeticChecker)
+	if !ok {
+		return &admissionError{reason: models.FLDeclineNotHermetic, err: errors.New("executor cannot run tasks hermetically")}
+	}
+	if err := checker.CheckHermetic(task); err != nil {
+		return &admissionError{reason: models.FLDeclineNotHermetic, err: err}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/power"
+)
+
+// hermeticExecutor runs on a platform that cannot count egress
+type hermeticExecutor struct {
+	countingExecutor
+}
+
+func (e *hermeticExecutor) CheckHermetic(*models.Task) error {
+	return &docker.HermeticError{Unmet: map[string]string{models.HermeticEgressAudit: "egress cannot be counted on darwin"}}
+}
+
+func TestUnenforceableHermeticTaskSkipped(t *testing.T) {
+	executor := &hermeticExecutor{}
+	h, _, client := newPowerHandler(executor, power.InFlightContinue)
+
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker, Nonce: "abcdef", Config: []byte(`{"image_name": "python@sha256:ab12", "hermetic": true}`)}
+	err := h.HandleTask(task)
+	var admission *admissionError
+	if !errors.As(err, &admission) || admission.reason != models.FLDeclineNotHermetic || !errors.Is(err, docker.ErrNotHermetic) {
+		t.Fatalf("HandleTask() error = %v, want a not_hermetic skip", err)
+	}
+	if executor.calls != 0 || len(client.statuses) != 0 {
+		t.Fatal("hermetic task the runner cannot enforce was claimed")
+	}
+
+	task.Config = []byte(`{"image_name": "python:3.11"}`)
+	if err := h.HandleTask(task); err != nil || executor.calls != 1 {
+		t.Fatalf("HandleTask() error = %v, want the task that is not hermetic run", err)
+	}
+}
+
+func TestExecutorWithoutHermeticSupportSkipsTask(t *testing.T) {
+	executor := &countingExecutor{}
+	h, _, _ := newPowerHandler(executor, power.InFlightContinue)
+
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker, Nonce: "abcdef", Config: []byte(`{"image_name": "python@sha256:ab12", "hermetic": true}`)}
+	var admission *admissionError
+	if err := h.HandleTask(task); !errors.As(err, &admission) || admission.reason != models.FLDeclineNotHermetic {
+		t.Fatalf("HandleTask() error = %v, want a not_hermetic skip", err)
+	}
+	if executor.calls != 0 {
+		t.Fatal("hermetic task was run by an executor that cannot enforce it")
+	}
+}
@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/hints"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+// SetHintBoard skips tasks the fleet hinted on board as failing for every
+// runner, and hints tasks this runner finds failing that way
+func (h *DefaultTaskHandler) SetHintBoard(board *hints.Board) {
+	h.hints = board
+}
+
+// admitHints declines tasks a fleet member hinted as failing for every
+// runner
+func (h *DefaultTaskHandler) admitHints(task *models.Task) *admissionError {
+	if h.hints == nil {
+		return nil
+	}
+	hint := h.hints.Check(context.Background(), task)
+	if hint == nil {
+		return nil
+	}
+	return &admissionError{reason: models.FLDeclineHinted, err: fmt.Errorf("runner %s hinted the task fails with %s: %s", hint.Runner, hint.Reason, hint.Detail)}
+}
+
+// hintFailure tells the fleet when err, a failure of task, fails it for
+// every runner
+func (h *DefaultTaskHandler) hintFailure(task *models.Task, err error) {
+	if reason, ok := hintReason(err); ok {
+		h.publishHint(task, reason, err)
+	}
+}
+
+// hintInvalid tells the fleet that task's config does not match its schema
+func (h *DefaultTaskHandler) hintInvalid(task *models.Task, err error) {
+	h.publishHint(task, hints.ReasonInvalidConfig, err)
+}
+
+// publishHint publishes a hint for task in the background
+func (h *DefaultTaskHandler) publishHint(task *models.Task, reason hints.Reason, err error) {
+	if h.hints == nil {
+		return
+	}
+	board := h.hints
+	go func() {
+		if err := board.Publish(task, reason, err.Error()); err != nil {
+			log := gologger.WithComponent("task_handler")
+			log.Warn().Err(err).Str("id", task.ID.String()).Msg("Failed to publish fleet hint")
+		}
+	}()
+}
+
+// hintReason returns the hint reason for err, and false when err may not
+// fail the task on other runners, such as running out of memory or disk
+// or a dependency that has yet to be uploaded
+func hintReason(err error) (hints.Reason, bool) {
+	var dependency *inputs.DependencyError
+	var preflight *docker.PreflightError
+	switch {
+	case errors.As(err, &dependency), failureCode(err).RunnerCaused():
+		return "", false
+	case errors.Is(err, docker.ErrImageNotFound):
+		return hints.ReasonImageNotFound, true
+	case errors.Is(err, inputs.ErrUnavailable), errors.Is(err, inputs.ErrVerification):
+		return hints.ReasonInputUnavailable, true
+	case errors.As(err, &preflight) && preflight.Fatal():
+		return hints.ReasonInvalidConfig, true
+	}
+	return "", false
+}
+
+// newHintBoard returns the board the fleet hints config describes,
+// publishing hints signed by s
+func newHintBoard(cfg config.FleetHintsConfig, s signer.Signer) (*hints.Board, error) {
+	var store hints.Store
+	switch cfg.Mode {
+	case "http":
+		if cfg.URL == "" {
+			return nil, errors.New("fleet hints URL is required in http mode")
+		}
+		store = hints.NewHTTPStore(cfg.URL)
+	case "file":
+		if cfg.Dir == "" {
+			return nil, errors.New("fleet hints directory is required in file mode")
+		}
+		fileStore, err := hints.NewFileStore(cfg.Dir)
+		if err != nil {
+			return nil, err
+		}
+		store = fileStore
+	default:
+		return nil, fmt.Errorf("unknown fleet hints mode %q", cfg.Mode)
+	}
+	members := make([]common.Address, 0, len(cfg.Members))
+	for _, member := range cfg.Members {
+		if !common.IsHexAddress(member) {
+			return nil, fmt.Errorf("invalid fleet member address %q", member)
+		}
+		members = append(members, common.HexToAddress(member))
+	}
+	return hints.New(store, s, members, cfg.TTL, cfg.Timeout), nil
+}
@@ -0,0 +1,153 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/hints"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+func newHintSigner(t *testing.T) *signer.Local {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := signer.FromECDSA(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func newHintHandler(t *testing.T, board *hints.Board, executor *failingExecutor) *DefaultTaskHandler {
+	t.Helper()
+	h := NewTaskHandler(executor, &fakeFLClient{})
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	h.SetHintBoard(board)
+	return h
+}
+
+// waitPublished waits for board to have published n hints
+func waitPublished(t *testing.T, board *hints.Board, n uint64) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for board.Stats().Published < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("board published %d hints, want %d", board.Stats().Published, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestFleetHintSkipsTaskBrokenForEveryRunner(t *testing.T) {
+	store, err := hints.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := newHintSigner(t), newHintSigner(t)
+	members := []common.Address{first.Address(), second.Address()}
+	firstBoard := hints.New(store, first, members, time.Hour, time.Second)
+	secondBoard := hints.New(store, second, members, time.Hour, time.Second)
+	task := newDockerTask(t)
+
+	broken := &failingExecutor{err: fmt.Errorf("failed to pull image: %w", docker.ErrImageNotFound)}
+	if err := newHintHandler(t, firstBoard, broken).HandleTask(task); err == nil {
+		t.Fatal("HandleTask() succeeded with a missing image")
+	}
+	waitPublished(t, firstBoard, 1)
+
+	executor := &failingExecutor{err: errors.New("should not run")}
+	err = newHintHandler(t, secondBoard, executor).HandleTask(task)
+	var admission *admissionError
+	if !errors.As(err, &admission) || admission.reason != models.FLDeclineHinted {
+		t.Fatalf("HandleTask() error = %v, want a hinted decline", err)
+	}
+	if executor.calls != 0 {
+		t.Errorf("hinted task ran %d times on the second runner", executor.calls)
+	}
+
+	// Running out of disk fails the task on this runner alone
+	scratch := &failingExecutor{err: fmt.Errorf("input preparation failed: %w", inputs.ErrInsufficientDisk)}
+	other := newDockerTask(t)
+	newHintHandler(t, firstBoard, scratch).HandleTask(other)
+	if hint := secondBoard.Check(context.Background(), other); hint != nil {
+		t.Errorf("runner-specific failure hinted: %+v", hint)
+	}
+}
+
+func TestFleetHintFromOutsiderIsIgnored(t *testing.T) {
+	store, err := hints.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	member := newHintSigner(t)
+	outsider := hints.New(store, newHintSigner(t), nil, time.Hour, time.Second)
+	task := newDockerTask(t)
+	if err := outsider.Publish(task, hints.ReasonInvalidConfig, "forged"); err != nil {
+		t.Fatal(err)
+	}
+
+	board := hints.New(store, member, nil, time.Hour, time.Second)
+	executor := &failingExecutor{err: errors.New("exit status 1")}
+	err = newHintHandler(t, board, executor).HandleTask(task)
+	var admission *admissionError
+	if errors.As(err, &admission) {
+		t.Fatalf("HandleTask() declined with %s on a forged hint", admission.reason)
+	}
+	if executor.calls != 1 {
+		t.Errorf("task ran %d times, want once despite the forged hint", executor.calls)
+	}
+	if stats := board.Stats(); stats.Forged != 1 || stats.Skipped != 0 {
+		t.Errorf("Stats() = %+v, want one forged hint", stats)
+	}
+}
+
+func TestHintReason(t *testing.T) {
+	tests := []struct {
+		err  error
+		want hints.Reason
+	}{
+		{fmt.Errorf("pull: %w", docker.ErrImageNotFound), hints.ReasonImageNotFound},
+		{fmt.Errorf("input weights: %w", inputs.ErrUnavailable), hints.ReasonInputUnavailable},
+		{&docker.PreflightError{Image: "alpine", Mismatches: []docker.Mismatch{{Reason: "no such file", Fatal: true}}}, hints.ReasonInvalidConfig},
+		{&docker.PreflightError{Image: "alpine", Mismatches: []docker.Mismatch{{Reason: "may not exist"}}}, ""},
+		{&inputs.DependencyError{TaskID: "parent"}, ""},
+		{fmt.Errorf("input preparation failed: %w", inputs.ErrInsufficientDisk), ""},
+		{models.Failure(models.FailureContainerRuntime, errors.New("OOM killed")), ""},
+		{errors.New("exit status 1"), ""},
+	}
+	for _, tt := range tests {
+		got, ok := hintReason(tt.err)
+		if got != tt.want || ok != (tt.want != "") {
+			t.Errorf("hintReason(%v) = %q, %v; want %q", tt.err, got, ok, tt.want)
+		}
+	}
+}
+
+func TestNewHintBoardRejectsBadConfig(t *testing.T) {
+	s := newHintSigner(t)
+	for _, cfg := range []config.FleetHintsConfig{
+		{Mode: "http"},
+		{Mode: "file"},
+		{Mode: "redis"},
+		{Mode: "file", Dir: t.TempDir(), Members: []string{"not-an-address"}},
+	} {
+		if _, err := newHintBoard(cfg, s); err == nil {
+			t.Errorf("newHintBoard(%+v) accepted", cfg)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/hostreserve"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// SetHostLedger reserves the memory and CPU of admitted tasks on ledger.
+// Tasks declaring no resources reserve fallback, the limits their
+// containers get.
+func (h *DefaultTaskHandler) SetHostLedger(ledger *hostreserve.Ledger, fallback hostreserve.Demand) {
+	h.host = ledger
+	h.hostDefault = fallback
+}
+
+// newHostLedger returns the ledger of profile's memory and CPU, and what a
+// task declaring no resources reserves of them: docker's limits, or a core
+// without a CPU limit
+func newHostLedger(docker config.DockerConfig, profile *hardware.Profile) (*hostreserve.Ledger, hostreserve.Demand, error) {
+	fallback := hostreserve.Demand{CPUShares: hostreserve.CPUSharesPerCore}
+	if docker.CPULimit != "" {
+		cpus, err := strconv.ParseFloat(docker.CPULimit, 64)
+		if err != nil || cpus <= 0 {
+			return nil, fallback, fmt.Errorf("invalid docker CPU limit %q", docker.CPULimit)
+		}
+		fallback.CPUShares = int64(cpus * hostreserve.CPUSharesPerCore)
+	}
+	if docker.MemoryLimit != "" {
+		memory, err := gpu.ParseBytes(docker.MemoryLimit)
+		if err != nil {
+			return nil, fallback, fmt.Errorf("invalid docker memory limit: %w", err)
+		}
+		fallback.MemoryBytes = memory
+	}
+	ledger, err := hostreserve.NewLedger(hostreserve.Demand{
+		MemoryBytes: profile.TotalMemoryBytes,
+		CPUShares:   int64(profile.CPUCores) * hostreserve.CPUSharesPerCore,
+	})
+	if err != nil {
+		return nil, fallback, err
+	}
+	return ledger, fallback, nil
+}
+
+// hostDemand returns the memory and CPU task declared, taking the
+// handler's fallback for what it left out
+func (h *DefaultTaskHandler) hostDemand(task *models.Task) (hostreserve.Demand, error) {
+	demand := h.hostDefault
+	if len(task.Config) == 0 {
+		return demand, nil
+	}
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return demand, nil
+	}
+	if config.Resources.Memory != "" {
+		memory, err := gpu.ParseBytes(config.Resources.Memory)
+		if err != nil {
+			return demand, fmt.Errorf("invalid memory: %w", err)
+		}
+		demand.MemoryBytes = memory
+	}
+	if config.Resources.CPUShares > 0 {
+		demand.CPUShares = config.Resources.CPUShares
+	}
+	return demand, nil
+}
+
+// reserveHost commits the memory and CPU task declared. A task that only
+// fits once others finish is skipped as the host being busy, so the server
+// can offer it again; one the host could never fit lacks resources. Forcing
+// skips the reservation, as it does the hardware requirements.
+func (h *DefaultTaskHandler) reserveHost(task *models.Task) *admissionError {
+	if h.host == nil || h.force {
+		return nil
+	}
+	demand, err := h.hostDemand(task)
+	if err != nil {
+		return &admissionError{reason: models.FLDeclineInvalidConfig, err: err}
+	}
+	err = h.host.Reserve(task.ID.String(), demand)
+	switch {
+	case errors.Is(err, hostreserve.ErrHostBusy):
+		return &admissionError{skip: models.SkipHostBusy, err: err}
+	case err != nil:
+		return &admissionError{reason: models.FLDeclineInsufficientResources, err: err}
+	}
+
+	log := gologger.WithComponent("task_handler")
+	log.Debug().
+		Str("id", task.ID.String()).
+		Uint64("memory_bytes", demand.MemoryBytes).
+		Int64("cpu_shares", demand.CPUShares).
+		Msg("Reserved host memory and CPU for task")
+	return nil
+}
+
+// releaseHost frees the memory and CPU reserved for task, if any
+func (h *DefaultTaskHandler) releaseHost(task *models.Task) {
+	h.host.Release(task.ID.String())
+}
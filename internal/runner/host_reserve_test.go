@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/concurrency"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/hostreserve"
+)
+
+func TestHostReservationsBoundConcurrentTasks(t *testing.T) {
+	executor := newGPUExecutor()
+	h := NewTaskHandler(executor, &fakeFLClient{})
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	controller, err := concurrency.New(concurrency.Config{Initial: 4, Min: 1, Max: 4, Backoff: 0.5, SlowRatio: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetConcurrency(controller)
+	ledger, fallback, err := newHostLedger(config.DockerConfig{CPULimit: "1", MemoryLimit: "1g"}, &hardware.Profile{CPUCores: 4, TotalMemoryBytes: 8 * gib})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetHostLedger(ledger, fallback)
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- h.HandleTask(newPendingTask(t, models.ResourceConfig{Memory: "3g"})) }()
+		<-executor.started
+	}
+
+	// The limit allows a third task, but the host's memory does not
+	blocked := newPendingTask(t, models.ResourceConfig{Memory: "3g"})
+	var admission *admissionError
+	if err := h.HandleTask(blocked); !errors.As(err, &admission) || admission.skip != models.SkipHostBusy || !errors.Is(err, hostreserve.ErrHostBusy) {
+		t.Fatalf("HandleTask() = %v, want host_busy while memory is committed", err)
+	}
+	if err := h.HandleTask(newPendingTask(t, models.ResourceConfig{Memory: "16g"})); !errors.As(err, &admission) || admission.reason != models.FLDeclineInsufficientResources {
+		t.Fatalf("HandleTask() = %v, want insufficient_resources beyond the host's memory", err)
+	}
+	// A task declaring nothing reserves the container limits, which fit
+	small := h.EvaluateFeasibility(newDockerTask(t))
+	if !small.Feasible() {
+		t.Fatalf("EvaluateFeasibility() of a task within the Docker limits = %v", small.Err)
+	}
+	small.Release()
+
+	close(executor.release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("HandleTask() error = %v", err)
+		}
+	}
+	if stats := ledger.Stats(); stats.Committed != (hostreserve.Demand{}) || stats.Reservations != 0 {
+		t.Fatalf("Stats() once every task finished = %+v, want nothing committed", stats)
+	}
+	feasibility := h.EvaluateFeasibility(blocked)
+	defer feasibility.Release()
+	if !feasibility.Feasible() {
+		t.Errorf("EvaluateFeasibility() once memory was released = %v", feasibility.Err)
+	}
+}
+
+func TestClaimConflictMovesOn(t *testing.T) {
+	task := newPendingTask(t, models.ResourceConfig{})
+	server, client := newMockServer(t, task)
+	server.claimCode = http.StatusConflict
+	h, executor, _ := newRunTaskHandler(client, 0)
+
+	if err := h.HandleTask(task); err != nil {
+		t.Fatalf("HandleTask() of a task another runner claimed = %v, want it passed over", err)
+	}
+	if executor.calls != 0 || h.IsProcessing() {
+		t.Errorf("task ran %d times after losing the claim", executor.calls)
+	}
+}
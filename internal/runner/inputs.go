@@ -0,0 +1,42 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+)
+
+// inputCheckTimeout bounds the size lookups of inputs that do not declare
+// their size
+const inputCheckTimeout = 30 * time.Second
+
+// InputSpaceChecker is implemented by executors that download task inputs
+// and can tell before a task is claimed whether they fit on disk
+type InputSpaceChecker interface {
+	CheckInputSpace(ctx context.Context, task *models.Task) error
+}
+
+// admitInputs skips tasks whose inputs do not fit on disk as lacking
+// resources, and tasks whose inputs are invalid for their type as invalid
+func (h *DefaultTaskHandler) admitInputs(task *models.Task) *admissionError {
+	checker, ok := h.executor.(InputSpaceChecker)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), inputCheckTimeout)
+	defer cancel()
+
+	err := checker.CheckInputSpace(ctx, task)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, inputs.ErrInsufficientDisk):
+		return &admissionError{reason: models.FLDeclineInsufficientResources, err: err}
+	default:
+		return &admissionError{reason: models.FLDeclineInvalidConfig, err: err}
+	}
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+)
+
+// failingExecutor fails every task with err before running it, counting
+// the tasks it was given
+type failingExecutor struct {
+	err   error
+	calls int
+}
+
+func (e *failingExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	e.calls++
+	return nil, e.err
+}
+
+func TestHandlerReportsDependencyFailure(t *testing.T) {
+	parent := "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+	tests := []struct {
+		name string
+		err  error
+		want *models.DependencyFailure
+	}{
+		{
+			"expired parent artifact",
+			fmt.Errorf("input preparation failed: %w", &inputs.DependencyError{
+				Input: "features", TaskID: parent, Artifact: "features.bin",
+				Reason: models.DependencyExpired, Err: inputs.ErrArtifactExpired,
+			}),
+			&models.DependencyFailure{TaskID: parent, Artifact: "features.bin", Input: "features", Reason: models.DependencyExpired},
+		},
+		{"own failure", fmt.Errorf("container exited"), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeFLClient{}
+			h := NewTaskHandler(&failingExecutor{err: tt.err}, client)
+
+			if err := h.HandleTask(newDockerTask(t)); err == nil {
+				t.Fatal("HandleTask() succeeded")
+			}
+			last := len(client.statuses) - 1
+			if last < 0 || client.statuses[last] != models.TaskStatusFailed {
+				t.Fatalf("server got %v, want the task failed", client.statuses)
+			}
+			got := client.results[last].DependencyFailure
+			if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+				t.Fatalf("dependency failure = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/tenancy"
+)
+
+// SetInstance makes the handler one of several logical runners of the
+// process. It admits only the tasks instance's filters allow, places GPU
+// tasks on the GPUs pinned to instance, advertises only its slice of the
+// hardware, and labels its history and counts in metrics with its name.
+func (h *DefaultTaskHandler) SetInstance(instance *tenancy.Instance, metrics *tenancy.Metrics) {
+	h.instance = instance
+	h.metrics = metrics
+	h.hardware = instance.Profile(h.hardware)
+}
+
+// SetMetrics counts the tasks of the process's only runner in metrics
+func (h *DefaultTaskHandler) SetMetrics(metrics *tenancy.Metrics) {
+	h.metrics = metrics
+}
+
+// instanceName returns the name of the handler's instance, or "" when the
+// process runs a single runner
+func (h *DefaultTaskHandler) instanceName() string {
+	if h.instance == nil {
+		return ""
+	}
+	return h.instance.Name
+}
+
+// admitInstance declines tasks the instance's filters do not allow
+func (h *DefaultTaskHandler) admitInstance(task *models.Task) *admissionError {
+	if h.instance == nil {
+		return nil
+	}
+	if err := h.instance.Accepts(task.Type, h.usesGPU(task)); err != nil {
+		return &admissionError{reason: models.FLDeclineFiltered, err: err}
+	}
+	return nil
+}
+
+// gpuDevices returns the GPUs the handler may place tasks on, or nil for
+// every GPU of the host
+func (h *DefaultTaskHandler) gpuDevices() []string {
+	if h.instance == nil {
+		return nil
+	}
+	return append([]string{}, h.instance.GPUs...)
+}
+
+// shareGPUTasks makes handlers, the logical runners of one process, keep
+// their GPU tasks in one table, so that any of them can evict a task of
+// another from a GPU the shared allocator finds overcommitted
+func shareGPUTasks(handlers []*DefaultTaskHandler) {
+	shared := &gpuTasks{}
+	for _, h := range handlers {
+		h.gpuTasks = shared
+	}
+}
+
+// InstanceStats returns the task counts of the handler's instance, or nil
+// when the process runs a single runner
+func (h *DefaultTaskHandler) InstanceStats() *models.InstanceStats {
+	if h.instance == nil || h.metrics == nil {
+		return nil
+	}
+	return h.metrics.Instance(h.instance.Name)
+}
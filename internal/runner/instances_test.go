@@ -0,0 +1,169 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/tenancy"
+)
+
+// twoGPUs is a host with an idle 24GiB and an idle 48GiB GPU
+type twoGPUs struct{}
+
+func (twoGPUs) Devices(ctx context.Context) ([]models.GPUDevice, error) {
+	return []models.GPUDevice{
+		{Index: 0, UUID: "GPU-0", TotalBytes: 24 * gib},
+		{Index: 1, UUID: "GPU-1", TotalBytes: 48 * gib},
+	}, nil
+}
+
+func (twoGPUs) Processes(ctx context.Context) ([]gpu.Process, error) {
+	return nil, nil
+}
+
+// newInstanceHandlers returns handlers for two instances of one process
+// sharing the host's GPUs, task history and metrics
+func newInstanceHandlers(t *testing.T, instances []tenancy.Instance) ([]*DefaultTaskHandler, []*gpuExecutor, *gpu.Allocator, *history.Store, *tenancy.Metrics) {
+	t.Helper()
+	store, err := history.NewStore(filepath.Join(t.TempDir(), historyFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocator := gpu.NewAllocator(twoGPUs{}, 1*gib)
+	metrics := tenancy.NewMetrics(instances)
+
+	var handlers []*DefaultTaskHandler
+	var executors []*gpuExecutor
+	for i := range instances {
+		executor := newGPUExecutor()
+		h := NewTaskHandler(executor, &fakeFLClient{})
+		h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorCUDA, CPUCores: 8}
+		h.SetGPUAllocator(allocator)
+		h.SetHistory(store, nil)
+		h.SetInstance(&instances[i], metrics)
+		h.SetEventBus(events.NewBus(0))
+		handlers = append(handlers, h)
+		executors = append(executors, executor)
+	}
+	shareGPUTasks(handlers)
+	return handlers, executors, allocator, store, metrics
+}
+
+func TestInstancesPartitionResources(t *testing.T) {
+	handlers, executors, allocator, store, metrics := newInstanceHandlers(t, []tenancy.Instance{
+		{Name: "gpu", WebhookPort: 8081, GPUOnly: true, GPUs: []string{"1"}},
+		{Name: "cpu", WebhookPort: 8082, TaskTypes: []models.TaskType{models.TaskTypeDocker}, CPUs: []int{0, 1}},
+	})
+	gpuRunner, cpuRunner := handlers[0], handlers[1]
+	gpuExec, cpuExec := executors[0], executors[1]
+
+	if gpuRunner.hardware.Accelerator != hardware.AcceleratorCUDA || gpuRunner.hardware.CPUCores != 8 {
+		t.Errorf("GPU instance profile = %+v, want the host's", gpuRunner.hardware)
+	}
+	if cpuRunner.hardware.Accelerator != hardware.AcceleratorNone || cpuRunner.hardware.CPUCores != 2 {
+		t.Errorf("CPU instance profile = %+v, want its 2 cores and no GPU", cpuRunner.hardware)
+	}
+
+	// Each instance declines what the other is for
+	var admission *admissionError
+	if err := cpuRunner.HandleTask(newGPUTask(t, "14g", 0)); !errors.As(err, &admission) || admission.reason != models.FLDeclineFiltered {
+		t.Fatalf("CPU instance HandleTask(GPU task) error = %v, want filtered", err)
+	}
+	if err := gpuRunner.HandleTask(newDockerTask(t)); !errors.As(err, &admission) || admission.reason != models.FLDeclineFiltered {
+		t.Fatalf("GPU instance HandleTask(CPU task) error = %v, want filtered", err)
+	}
+
+	gpuTask := newGPUTask(t, "14g", 0)
+	cpuTask := newDockerTask(t)
+	done := make(chan error, 2)
+	go func() { done <- gpuRunner.HandleTask(gpuTask) }()
+	<-gpuExec.started
+	go func() { done <- cpuRunner.HandleTask(cpuTask) }()
+	<-cpuExec.started
+
+	// The GPU task is placed on the pinned GPU though the other fits it
+	// more tightly, and the CPU task holds no GPU
+	snapshot := allocator.Snapshot()
+	if len(snapshot.Reservations) != 1 || snapshot.Reservations[0].TaskID != gpuTask.ID.String() || snapshot.Reservations[0].Device != "GPU-1" {
+		t.Fatalf("reservations = %+v, want only the GPU task on GPU-1", snapshot.Reservations)
+	}
+	gpuExec.mu.Lock()
+	gpuDevice := gpuExec.devices[gpuTask.ID.String()]
+	gpuExec.mu.Unlock()
+	cpuExec.mu.Lock()
+	cpuDevice := cpuExec.devices[cpuTask.ID.String()]
+	cpuExec.mu.Unlock()
+	if gpuDevice != "GPU-1" || cpuDevice != "" {
+		t.Fatalf("GPU task ran on %q and CPU task on %q, want GPU-1 and none", gpuDevice, cpuDevice)
+	}
+	if gpuRunner.gpuTasks.owned(gpuRunner) != 1 || gpuRunner.gpuTasks.owned(cpuRunner) != 0 {
+		t.Error("GPU task not owned by the GPU instance alone")
+	}
+
+	close(gpuExec.release)
+	close(cpuExec.release)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("HandleTask() error = %v", err)
+		}
+	}
+	if allocator.Len() != 0 {
+		t.Fatalf("%d reservations left after both tasks finished", allocator.Len())
+	}
+
+	// A GPU task that reserves no VRAM still runs only on the pinned GPU
+	raw, err := json.Marshal(models.TaskConfig{ImageName: "pytorch/pytorch", Resources: models.ResourceConfig{Accelerator: "cuda"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	undeclared := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker, Nonce: "abcdef", Config: raw}
+	if err := gpuRunner.HandleTask(undeclared); err != nil {
+		t.Fatalf("HandleTask() error = %v", err)
+	}
+	gpuExec.mu.Lock()
+	device := gpuExec.devices[undeclared.ID.String()]
+	gpuExec.mu.Unlock()
+	if device != "1" {
+		t.Errorf("undeclared GPU task ran on %q, want the pinned GPU 1", device)
+	}
+
+	gpuRunner.events.Sync()
+	cpuRunner.events.Sync()
+	for _, tt := range []struct {
+		instance                     string
+		claimed, completed, declined uint64
+	}{
+		{"gpu", 2, 2, 1},
+		{"cpu", 1, 1, 1},
+	} {
+		stats := metrics.Instance(tt.instance)
+		if stats.Claimed != tt.claimed || stats.Completed != tt.completed || stats.Declined != tt.declined || stats.Failed != 0 {
+			t.Errorf("%s stats = %+v, want %d claimed, %d completed, %d declined", tt.instance, stats, tt.claimed, tt.completed, tt.declined)
+		}
+	}
+	if stats := gpuRunner.InstanceStats(); stats.Instance != "gpu" || stats.Claimed != 2 {
+		t.Errorf("InstanceStats() = %+v, want the GPU instance's", stats)
+	}
+
+	records, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := make(map[string]string)
+	for _, record := range records {
+		labels[record.TaskID] = record.Instance
+	}
+	if labels[gpuTask.ID.String()] != "gpu" || labels[undeclared.ID.String()] != "gpu" || labels[cpuTask.ID.String()] != "cpu" || len(labels) != 3 {
+		t.Errorf("history labels = %v, want each task labelled with the instance that ran it", labels)
+	}
+}
@@ -0,0 +1,38 @@
+package runner
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/ipfsfetch"
+)
+
+// ipfsGatewayScoresFileName keeps what fetches taught about each gateway,
+// so that slow or failing gateways stay demoted across tasks and restarts
+const ipfsGatewayScoresFileName = "ipfs_gateways.json"
+
+// newIPFSFetcher returns the fetcher of inputs and datasets given by CID
+// cfg describes, requesting gateways with client, nil when it is off
+func newIPFSFetcher(cfg config.IPFSFetchConfig, dataDir string, client *http.Client) (*ipfsfetch.Fetcher, error) {
+	switch cfg.Mode {
+	case "off":
+		return nil, nil
+	case "on":
+	default:
+		return nil, fmt.Errorf("unknown IPFS fetch mode %q", cfg.Mode)
+	}
+	if cfg.SegmentKB < 0 || cfg.Parallel < 0 {
+		return nil, fmt.Errorf("IPFS fetch needs a positive segment size and parallelism")
+	}
+	scores, err := ipfsfetch.OpenScores(filepath.Join(dataDir, ipfsGatewayScoresFileName))
+	if err != nil {
+		return nil, err
+	}
+	fetcher := ipfsfetch.New(cfg.Gateways, scores)
+	fetcher.SetHTTPClient(client)
+	fetcher.SetSegmentSize(int64(cfg.SegmentKB) << 10)
+	fetcher.SetParallel(cfg.Parallel)
+	return fetcher, nil
+}
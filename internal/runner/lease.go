@@ -0,0 +1,322 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+)
+
+// ErrLeaseLost is returned when the server has reassigned a task this runner was executing
+var ErrLeaseLost = errors.New("task lease lost")
+
+const (
+	// leaseMaxRenewFailures is the number of consecutive transient renewal
+	// failures tolerated before the lease is treated as lost
+	leaseMaxRenewFailures = 3
+	// leaseJitterFraction spreads renewals of concurrent runners by up to ±10%
+	leaseJitterFraction = 0.1
+	// leaseResumeMargin is the minimum remaining lease time a restarted runner
+	// needs before it may try to resume a task instead of abandoning it
+	leaseResumeMargin = 5 * time.Second
+)
+
+// LeaseClient is implemented by task clients that understand server-issued task leases
+type LeaseClient interface {
+	StartTaskWithLease(taskID string) (*models.TaskLease, error)
+	RenewLease(lease *models.TaskLease) (*models.TaskLease, error)
+}
+
+// HintedLeaseClient is implemented by task clients that can tell the server,
+// while claiming, how soon this runner expects to produce a first token
+type HintedLeaseClient interface {
+	StartTaskWithHint(taskID string, hint *models.ClaimHint) (*models.TaskLease, error)
+}
+
+type leaseRecord struct {
+	Lease *models.TaskLease `json:"lease"`
+	Task  *models.Task      `json:"task"`
+}
+
+// LeaseStore persists active leases so a restarted runner can decide whether
+// it still owns the tasks it was executing
+type LeaseStore struct {
+	dir   string
+	codec atrest.Codec
+	mu    sync.Mutex
+}
+
+func NewLeaseStore(dir string) (*LeaseStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create lease directory: %w", err)
+	}
+	return &LeaseStore{dir: dir}, nil
+}
+
+// SetCodec encrypts lease records at rest
+func (s *LeaseStore) SetCodec(codec atrest.Codec) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.codec = codec
+}
+
+func (s *LeaseStore) path(taskID string) string {
+	return filepath.Join(s.dir, taskID+".json")
+}
+
+func (s *LeaseStore) Save(task *models.Task, lease *models.TaskLease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := json.Marshal(leaseRecord{Lease: lease, Task: task})
+	if err != nil {
+		return fmt.Errorf("failed to marshal lease: %w", err)
+	}
+
+	if err := atrest.WriteFile(s.codec, s.path(lease.TaskID), data, 0o600); err != nil {
+		return fmt.Errorf("failed to persist lease: %w", err)
+	}
+	return nil
+}
+
+func (s *LeaseStore) Delete(taskID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.Remove(s.path(taskID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete lease: %w", err)
+	}
+	return nil
+}
+
+func (s *LeaseStore) Load() ([]leaseRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lease directory: %w", err)
+	}
+
+	var records []leaseRecord
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := atrest.ReadFile(s.codec, filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read lease %s: %w", entry.Name(), err)
+		}
+		var record leaseRecord
+		if err := json.Unmarshal(data, &record); err != nil || record.Lease == nil || record.Task == nil {
+			log := gologger.WithComponent("lease")
+			log.Warn().
+				Str("file", entry.Name()).
+				Msg("Ignoring unreadable lease record")
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Reseal rewrites every lease record under the active data key, encrypting
+// plaintext records left from before encryption was enabled
+func (s *LeaseStore) Reseal() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.codec == nil {
+		return 0, nil
+	}
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read lease directory: %w", err)
+	}
+
+	resealed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		rewritten, err := atrest.ResealFile(s.codec, filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return resealed, fmt.Errorf("failed to reseal lease %s: %w", entry.Name(), err)
+		}
+		if rewritten {
+			resealed++
+		}
+	}
+	return resealed, nil
+}
+
+// canResumeLease reports whether a persisted lease leaves enough time to
+// renew it after a restart; otherwise the server will already have
+// reassigned, or is about to reassign, the task
+func canResumeLease(lease *models.TaskLease, now time.Time) bool {
+	if lease == nil || lease.TTL <= 0 {
+		return false
+	}
+	return lease.ExpiresAt.Sub(now) > leaseResumeMargin
+}
+
+// renewalInterval returns the wait before the next renewal attempt. Healthy
+// leases renew at half their TTL; after a failed attempt the runner retries
+// more aggressively so several attempts fit before expiry. r in [0,1) selects
+// the jitter.
+func renewalInterval(ttl time.Duration, failures int, r float64) time.Duration {
+	base := ttl / 2
+	if failures > 0 {
+		base = ttl / 8
+	}
+	jitter := time.Duration((r*2 - 1) * leaseJitterFraction * float64(base))
+	return base + jitter
+}
+
+type leaseKeeper struct {
+	client      LeaseClient
+	store       *LeaseStore
+	clock       clock.Clock
+	maxFailures int
+	random      func() float64
+	// events receives LeaseLost for each lease the keeper gives up on
+	events *events.Bus
+}
+
+func newLeaseKeeper(client LeaseClient, store *LeaseStore) *leaseKeeper {
+	return &leaseKeeper{
+		client:      client,
+		store:       store,
+		clock:       clock.Real(),
+		maxFailures: leaseMaxRenewFailures,
+		random:      rand.Float64,
+	}
+}
+
+// leaseWatch tracks the renewal goroutine for a single task
+type leaseWatch struct {
+	lost    atomic.Bool
+	current atomic.Pointer[models.TaskLease]
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// Lost reports whether the lease was lost while the task was executing
+func (w *leaseWatch) Lost() bool {
+	return w != nil && w.lost.Load()
+}
+
+// Current returns the most recently renewed lease, or lease when w is nil
+func (w *leaseWatch) Current(lease *models.TaskLease) *models.TaskLease {
+	if w == nil {
+		return lease
+	}
+	return w.current.Load()
+}
+
+// Stop ends renewal and waits for the renewal goroutine to exit
+func (w *leaseWatch) Stop() {
+	if w == nil {
+		return
+	}
+	w.cancel()
+	<-w.done
+}
+
+// watch renews lease in the background until Stop is called. When the lease
+// is lost, abort is invoked so the caller can cancel local execution.
+func (k *leaseKeeper) watch(ctx context.Context, task *models.Task, lease *models.TaskLease, abort context.CancelFunc) *leaseWatch {
+	if k == nil || lease == nil || lease.TTL <= 0 {
+		return nil
+	}
+
+	log := gologger.WithComponent("lease")
+
+	if k.store != nil {
+		if err := k.store.Save(task, lease); err != nil {
+			log.Warn().Err(err).Str("task_id", lease.TaskID).Msg("Failed to persist task lease")
+		}
+	}
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	w := &leaseWatch{cancel: cancel, done: make(chan struct{})}
+	w.current.Store(lease)
+
+	go func() {
+		defer close(w.done)
+		defer func() {
+			if k.store != nil {
+				if err := k.store.Delete(lease.TaskID); err != nil {
+					log.Warn().Err(err).Str("task_id", lease.TaskID).Msg("Failed to remove task lease")
+				}
+			}
+		}()
+
+		current := lease
+		failures := 0
+		for {
+			timer := k.clock.NewTimer(renewalInterval(current.TTL, failures, k.random()))
+			select {
+			case <-watchCtx.Done():
+				timer.Stop()
+				return
+			case <-timer.C():
+			}
+
+			renewed, err := k.client.RenewLease(current)
+			if err == nil {
+				current = renewed
+				w.current.Store(renewed)
+				failures = 0
+				if k.store != nil {
+					if err := k.store.Save(task, current); err != nil {
+						log.Warn().Err(err).Str("task_id", current.TaskID).Msg("Failed to persist renewed lease")
+					}
+				}
+				continue
+			}
+
+			if !errors.Is(err, ErrLeaseLost) {
+				failures++
+				log.Warn().
+					Err(err).
+					Str("task_id", current.TaskID).
+					Int("failures", failures).
+					Msg("Failed to renew task lease")
+				if failures < k.maxFailures && !current.Expired(k.clock.Now()) {
+					continue
+				}
+			}
+
+			log.Error().
+				Err(err).
+				Str("task_id", current.TaskID).
+				Msg("Task lease lost - cancelling execution")
+			lost := events.LeaseLost{TaskID: current.TaskID}
+			if err != nil {
+				lost.Error = err.Error()
+			}
+			k.events.Publish(lost)
+			w.lost.Store(true)
+			abort()
+			return
+		}
+	}()
+
+	return w
+}
@@ -0,0 +1,307 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+type fakeLeaseClient struct {
+	mu      sync.Mutex
+	clock   clock.Clock
+	renewed []time.Time
+	renewFn func(calls int) error
+}
+
+func (c *fakeLeaseClient) StartTaskWithLease(taskID string) (*models.TaskLease, error) {
+	return nil, nil
+}
+
+func (c *fakeLeaseClient) RenewLease(lease *models.TaskLease) (*models.TaskLease, error) {
+	c.mu.Lock()
+	c.renewed = append(c.renewed, c.clock.Now())
+	calls := len(c.renewed)
+	c.mu.Unlock()
+
+	if c.renewFn != nil {
+		if err := c.renewFn(calls); err != nil {
+			return nil, err
+		}
+	}
+	renewed := *lease
+	renewed.ExpiresAt = c.clock.Now().Add(lease.TTL)
+	return &renewed, nil
+}
+
+func (c *fakeLeaseClient) calls() []time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]time.Time(nil), c.renewed...)
+}
+
+// newFakeKeeper returns a lease keeper without jitter driven by a fake clock
+func newFakeKeeper(renewFn func(calls int) error) (*leaseKeeper, *fakeLeaseClient, *clocktest.Fake) {
+	fake := clocktest.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC))
+	client := &fakeLeaseClient{clock: fake, renewFn: renewFn}
+	keeper := newLeaseKeeper(client, nil)
+	keeper.clock = fake
+	keeper.random = func() float64 { return 0.5 }
+	return keeper, client, fake
+}
+
+// advanceToNextRenewal waits for the keeper to arm its renewal timer, then
+// moves the fake clock forward by d
+func advanceToNextRenewal(fake *clocktest.Fake, d time.Duration) {
+	fake.BlockUntil(1)
+	fake.Advance(d)
+}
+
+func newTestLease(ttl time.Duration) (*models.Task, *models.TaskLease) {
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker}
+	return task, &models.TaskLease{
+		TaskID:    task.ID.String(),
+		LeaseID:   "lease-1",
+		TTL:       ttl,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+}
+
+func TestRenewalInterval(t *testing.T) {
+	ttl := 60 * time.Second
+
+	if got := renewalInterval(ttl, 0, 0.5); got != 30*time.Second {
+		t.Fatalf("expected half-TTL without jitter, got %s", got)
+	}
+	if got := renewalInterval(ttl, 0, 0); got != 27*time.Second {
+		t.Fatalf("expected lower jitter bound 27s, got %s", got)
+	}
+	if got := renewalInterval(ttl, 0, 0.999999); got >= 33*time.Second || got < 32*time.Second {
+		t.Fatalf("expected upper jitter bound just below 33s, got %s", got)
+	}
+	if got := renewalInterval(ttl, 1, 0.5); got != 7500*time.Millisecond {
+		t.Fatalf("expected faster retry after failure, got %s", got)
+	}
+}
+
+func TestLeaseWatchRenewsAtHalfTTL(t *testing.T) {
+	keeper, client, fake := newFakeKeeper(nil)
+	start := fake.Now()
+
+	task, lease := newTestLease(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watch := keeper.watch(ctx, task, lease, cancel)
+
+	advanceToNextRenewal(fake, 30*time.Second-time.Millisecond)
+	if got := len(client.calls()); got != 0 {
+		t.Fatalf("expected no renewal before half-TTL, got %d", got)
+	}
+	fake.Advance(time.Millisecond)
+	advanceToNextRenewal(fake, 30*time.Second)
+	advanceToNextRenewal(fake, 30*time.Second)
+	fake.BlockUntil(1)
+	watch.Stop()
+
+	calls := client.calls()
+	want := []time.Duration{30 * time.Second, 60 * time.Second, 90 * time.Second}
+	if len(calls) != len(want) {
+		t.Fatalf("expected %d renewals, got %d", len(want), len(calls))
+	}
+	for i, at := range calls {
+		if got := at.Sub(start); got != want[i] {
+			t.Fatalf("renewal %d: expected at %s, got %s", i+1, want[i], got)
+		}
+	}
+	if watch.Lost() {
+		t.Fatal("lease should not be lost")
+	}
+	if ctx.Err() != nil {
+		t.Fatal("execution should not be cancelled while the lease is healthy")
+	}
+}
+
+func TestLeaseWatchCancelsOnLostLease(t *testing.T) {
+	keeper, client, fake := newFakeKeeper(func(int) error { return ErrLeaseLost })
+
+	task, lease := newTestLease(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watch := keeper.watch(ctx, task, lease, cancel)
+	advanceToNextRenewal(fake, 30*time.Second)
+
+	<-ctx.Done()
+	watch.Stop()
+
+	if !watch.Lost() {
+		t.Fatal("expected lease to be reported lost")
+	}
+	if len(client.calls()) != 1 {
+		t.Fatalf("expected no renewals after the lease was lost, got %d", len(client.calls()))
+	}
+	if fake.Waiters() != 0 {
+		t.Fatal("expected no renewal to be scheduled after the lease was lost")
+	}
+}
+
+func TestLeaseWatchCancelsAfterRepeatedFailures(t *testing.T) {
+	keeper, client, fake := newFakeKeeper(func(int) error { return errors.New("connection refused") })
+	start := fake.Now()
+
+	task, lease := newTestLease(80 * time.Second)
+	lease.ExpiresAt = start.Add(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watch := keeper.watch(ctx, task, lease, cancel)
+
+	// The first attempt waits half the TTL; retries then come every TTL/8
+	advanceToNextRenewal(fake, 40*time.Second)
+	advanceToNextRenewal(fake, 10*time.Second)
+	advanceToNextRenewal(fake, 10*time.Second)
+
+	<-ctx.Done()
+	watch.Stop()
+
+	if !watch.Lost() {
+		t.Fatal("expected lease to be reported lost")
+	}
+	calls := client.calls()
+	if len(calls) != leaseMaxRenewFailures {
+		t.Fatalf("expected %d renewal attempts, got %d", leaseMaxRenewFailures, len(calls))
+	}
+	want := []time.Duration{40 * time.Second, 50 * time.Second, 60 * time.Second}
+	for i, at := range calls {
+		if got := at.Sub(start); got != want[i] {
+			t.Fatalf("attempt %d: expected at %s, got %s", i+1, want[i], got)
+		}
+	}
+}
+
+func TestLeaseWatchGivesUpOnceLeaseExpires(t *testing.T) {
+	keeper, client, fake := newFakeKeeper(func(int) error { return errors.New("connection refused") })
+
+	task, lease := newTestLease(80 * time.Second)
+	lease.ExpiresAt = fake.Now().Add(45 * time.Second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watch := keeper.watch(ctx, task, lease, cancel)
+
+	advanceToNextRenewal(fake, 40*time.Second)
+	advanceToNextRenewal(fake, 10*time.Second)
+
+	<-ctx.Done()
+	watch.Stop()
+
+	if got := len(client.calls()); got != 2 {
+		t.Fatalf("expected to stop retrying once the lease expired, got %d attempts", got)
+	}
+}
+
+func TestLeaseStorePersistsUntilStopped(t *testing.T) {
+	store, err := NewLeaseStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	keeper := newLeaseKeeper(&fakeLeaseClient{clock: clock.Real()}, store)
+
+	task, lease := newTestLease(time.Minute)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	watch := keeper.watch(ctx, task, lease, cancel)
+
+	records, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Lease.LeaseID != "lease-1" || records[0].Task.ID != task.ID {
+		t.Fatalf("expected persisted lease for running task, got %+v", records)
+	}
+
+	watch.Stop()
+
+	records, err = store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expected lease to be removed after the task finished, got %d", len(records))
+	}
+}
+
+func TestCanResumeLease(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name  string
+		lease *models.TaskLease
+		want  bool
+	}{
+		{"nil lease", nil, false},
+		{"no ttl", &models.TaskLease{ExpiresAt: now.Add(time.Minute)}, false},
+		{"expired", &models.TaskLease{TTL: time.Minute, ExpiresAt: now.Add(-time.Second)}, false},
+		{"inside margin", &models.TaskLease{TTL: time.Minute, ExpiresAt: now.Add(leaseResumeMargin / 2)}, false},
+		{"valid", &models.TaskLease{TTL: time.Minute, ExpiresAt: now.Add(30 * time.Second)}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canResumeLease(tt.lease, now); got != tt.want {
+				t.Fatalf("canResumeLease() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeaseStoreMigratesPlaintextRecords(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewLeaseStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, lease := newTestLease(time.Minute)
+	if err := store.Save(task, lease); err != nil {
+		t.Fatal(err)
+	}
+
+	keyring, err := atrest.OpenKeyring(filepath.Join(t.TempDir(), "datakeys.json"), []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SetCodec(keyring)
+
+	resealed, err := store.Reseal()
+	if err != nil || resealed != 1 {
+		t.Fatalf("Reseal() = %d, %v, want 1 record", resealed, err)
+	}
+
+	raw, err := os.ReadFile(store.path(lease.TaskID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !atrest.IsEncrypted(raw) {
+		t.Fatal("expected lease record to be encrypted after migration")
+	}
+
+	records, err := store.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Task.ID != task.ID {
+		t.Fatalf("expected migrated lease to load, got %+v", records)
+	}
+}
@@ -0,0 +1,87 @@
+package runner
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+)
+
+type hintRecordingClient struct {
+	fakeFLClient
+	mu    sync.Mutex
+	hints []*models.ClaimHint
+	plain int
+}
+
+func (c *hintRecordingClient) StartTaskWithLease(taskID string) (*models.TaskLease, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.plain++
+	return nil, nil
+}
+
+func (c *hintRecordingClient) StartTaskWithHint(taskID string, hint *models.ClaimHint) (*models.TaskLease, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hints = append(c.hints, hint)
+	return nil, nil
+}
+
+func (c *hintRecordingClient) RenewLease(lease *models.TaskLease) (*models.TaskLease, error) {
+	return lease, nil
+}
+
+func TestClaimAttachesExpectedTTFT(t *testing.T) {
+	client := &hintRecordingClient{}
+	h := NewTaskHandler(&countingExecutor{}, client)
+
+	stats := llm.NewStats(clocktest.NewFake(time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)))
+	stats.Observe("mistral", llm.Generation{Total: 2 * time.Second, TTFT: 400 * time.Millisecond, EvalTokens: 100})
+	stats.Enqueue("mistral")
+	h.SetLLMStats(stats)
+
+	config, _ := json.Marshal(map[string]string{"model": "mistral", "prompt": "hello"})
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeLLM, Config: config}
+	if _, err := h.claimTask(task); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(client.hints) != 1 || client.plain != 0 {
+		t.Fatalf("expected one hinted claim, got %d hinted and %d plain", len(client.hints), client.plain)
+	}
+	hint := client.hints[0]
+	if hint.Model != "mistral" || hint.QueueDepth != 1 || hint.ExpectedTTFTMs != 2400 {
+		t.Fatalf("unexpected claim hint %+v", hint)
+	}
+
+	docker := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker}
+	if _, err := h.claimTask(docker); err != nil {
+		t.Fatal(err)
+	}
+	if client.plain != 1 || len(client.hints) != 1 {
+		t.Fatal("expected non-LLM tasks to be claimed without a hint")
+	}
+}
+
+func TestClaimHintOmitsUnknownTTFT(t *testing.T) {
+	client := &hintRecordingClient{}
+	h := NewTaskHandler(&countingExecutor{}, client)
+	h.SetLLMStats(llm.NewStats(clocktest.NewFake(time.Now())))
+
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeLLM, Config: json.RawMessage(`{"prompt":"hello"}`)}
+	if _, err := h.claimTask(task); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := json.Marshal(client.hints[0])
+	if string(data) != `{"model":"`+llm.DefaultModel+`","queue_depth":0}` {
+		t.Fatalf("unexpected hint encoding %s", data)
+	}
+}
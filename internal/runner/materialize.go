@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"context"
+	"errors"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/materialize"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// ResultPatcher is implemented by task clients that can add metadata to a
+// task's saved result
+type ResultPatcher interface {
+	PatchResultMetadata(taskID string, metadata map[string]interface{}) error
+}
+
+// SetMaterializer delivers the successful results of tasks naming
+// materialization plugins through them. Without one, tasks naming any are
+// skipped.
+func (h *DefaultTaskHandler) SetMaterializer(m *materialize.Materializer) {
+	h.materializer = m
+}
+
+// newMaterializer returns the materializer the materialization config
+// describes, or nil when it configures no plugins
+func newMaterializer(cfg config.MaterializeConfig) (*materialize.Materializer, error) {
+	if cfg.PluginsFile == "" {
+		return nil, nil
+	}
+	specs, err := materialize.LoadSpecs(cfg.PluginsFile)
+	if err != nil {
+		return nil, err
+	}
+	return materialize.New(specs)
+}
+
+// taskMaterializers returns the materialization plugins task names
+func taskMaterializers(task *models.Task) []string {
+	var config models.TaskConfig
+	if len(task.Config) == 0 || safejson.Unmarshal(task.Config, &config) != nil {
+		return nil
+	}
+	return config.Materialize
+}
+
+// admitMaterialize skips tasks naming a materialization plugin the runner
+// has not configured, as their results could not be delivered
+func (h *DefaultTaskHandler) admitMaterialize(task *models.Task) *admissionError {
+	names := taskMaterializers(task)
+	if len(names) == 0 {
+		return nil
+	}
+	if h.materializer == nil {
+		return &admissionError{reason: models.FLDeclineUnknownMaterializer, err: materialize.ErrUnknownPlugin}
+	}
+	if err := h.materializer.Check(names); err != nil {
+		return &admissionError{reason: models.FLDeclineUnknownMaterializer, err: err}
+	}
+	return nil
+}
+
+// materializeResult delivers a completed task's result through the plugins
+// it names once the server has accepted the result, then patches how each
+// delivery went into the result's metadata. It runs in the background, and
+// failures are logged but never affect the task status.
+func (h *DefaultTaskHandler) materializeResult(e events.Event) {
+	uploaded, ok := e.(events.ResultUploaded)
+	if !ok || h.materializer == nil || uploaded.Status != models.TaskStatusCompleted {
+		return
+	}
+	names := taskMaterializers(uploaded.Task)
+	if len(names) == 0 {
+		return
+	}
+	delivery := materialize.NewDelivery(uploaded.Task, uploaded.Result, uploaded.Status)
+
+	go func() {
+		log := gologger.WithComponent("materialize")
+		outcomes := h.materializer.Materialize(context.Background(), names, delivery)
+		for _, outcome := range outcomes {
+			if !outcome.Delivered {
+				log.Warn().
+					Str("id", delivery.TaskID).
+					Str("plugin", outcome.Plugin).
+					Int("attempts", outcome.Attempts).
+					Str("error", outcome.Error).
+					Msg("Failed to materialize task result")
+			}
+		}
+
+		patcher, ok := h.taskClient.(ResultPatcher)
+		if !ok {
+			return
+		}
+		err := patcher.PatchResultMetadata(delivery.TaskID, map[string]interface{}{models.MaterializationMetadataKey: outcomes})
+		switch {
+		case errors.Is(err, ErrResultPatchUnsupported):
+			log.Debug().Str("id", delivery.TaskID).Msg("Server does not take result patches")
+		case err != nil:
+			log.Warn().Err(err).Str("id", delivery.TaskID).Msg("Failed to record materialization outcomes")
+		}
+	}()
+}
@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/materialize"
+)
+
+type pluginFunc func(ctx context.Context, d *materialize.Delivery) error
+
+func (f pluginFunc) Deliver(ctx context.Context, d *materialize.Delivery) error { return f(ctx, d) }
+
+func materializingTask(t *testing.T, names ...string) *models.Task {
+	t.Helper()
+	task := newPendingTask(t, models.ResourceConfig{})
+	raw, err := json.Marshal(models.TaskConfig{ImageName: "alpine", Materialize: names})
+	if err != nil {
+		t.Fatal(err)
+	}
+	task.Config = raw
+	return task
+}
+
+func TestAdmitMaterializeRequiresConfiguredPlugins(t *testing.T) {
+	m, err := materialize.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.Register("warehouse", pluginFunc(func(context.Context, *materialize.Delivery) error { return nil }), materialize.Options{})
+
+	for _, tt := range []struct {
+		name         string
+		materializer *materialize.Materializer
+		names        []string
+		want         bool
+	}{
+		{"no plugins named", nil, nil, true},
+		{"configured plugin", m, []string{"warehouse"}, true},
+		{"unconfigured plugin", m, []string{"warehouse", "lake"}, false},
+		{"no materializer", nil, []string{"warehouse"}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			task := materializingTask(t, tt.names...)
+			_, client := newMockServer(t, task)
+			h, _, _ := newRunTaskHandler(client, 0)
+			if tt.materializer != nil {
+				h.SetMaterializer(tt.materializer)
+			}
+			admission := h.admitTask(task)
+			if tt.want && admission != nil {
+				t.Fatalf("admitTask() = %v, want the task admitted", admission)
+			}
+			if !tt.want && (admission == nil || admission.reason != models.FLDeclineUnknownMaterializer || !errors.Is(admission, materialize.ErrUnknownPlugin)) {
+				t.Fatalf("admitTask() = %v, want it declined for an unknown plugin", admission)
+			}
+		})
+	}
+}
+
+func TestMaterializationFailuresDoNotAffectTask(t *testing.T) {
+	delivered := make(chan *materialize.Delivery, 1)
+	m, _ := materialize.New(nil)
+	opts := materialize.Options{Timeout: time.Second, MaxAttempts: 2, Backoff: time.Millisecond}
+	m.Register("broken", pluginFunc(func(context.Context, *materialize.Delivery) error { panic("boom") }), opts)
+	m.Register("down", pluginFunc(func(context.Context, *materialize.Delivery) error { return errors.New("connection refused") }), opts)
+	m.Register("warehouse", pluginFunc(func(_ context.Context, d *materialize.Delivery) error {
+		delivered <- d
+		return nil
+	}), opts)
+
+	task := materializingTask(t, "broken", "down", "warehouse")
+	server, client := newMockServer(t, task)
+	server.patchCode = http.StatusOK
+	h, _, _ := newRunTaskHandler(client, 0)
+	h.SetMaterializer(m)
+	bus := events.NewBus(16)
+	defer bus.Close()
+	h.SetEventBus(bus)
+
+	status, _, err := h.RunTaskByID(task.ID.String())
+	if err != nil || status != models.TaskStatusCompleted {
+		t.Fatalf("RunTaskByID() = %s, %v, want the task completed", status, err)
+	}
+	select {
+	case d := <-delivered:
+		if d.TaskID != task.ID.String() || d.Output != "training epoch 1" {
+			t.Errorf("delivery = %+v", d)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("result never delivered to the working plugin")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		server.mu.Lock()
+		patches := server.patches
+		server.mu.Unlock()
+		if len(patches) > 0 {
+			raw, _ := json.Marshal(patches[0].Metadata[models.MaterializationMetadataKey])
+			var outcomes []models.MaterializationOutcome
+			json.Unmarshal(raw, &outcomes)
+			if len(outcomes) != 3 || outcomes[0].Delivered || outcomes[1].Delivered || outcomes[1].Attempts != 2 || !outcomes[2].Delivered {
+				t.Fatalf("patched outcomes = %+v", outcomes)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("materialization outcomes never patched into the result")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if server.result == nil || server.result.ExitCode != 0 {
+		t.Errorf("submitted result = %+v, want it untouched by materialization", server.result)
+	}
+}
@@ -0,0 +1,130 @@
+package runner
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/metricspush"
+)
+
+// newMetricsPusher builds the pusher of cfg, labelled with an instance ID
+// derived from deviceID, or nil when pushing is disabled
+func newMetricsPusher(cfg config.MetricsPushConfig, deviceID string, shared *sharedResources, httpClient *http.Client) (*metricspush.Pusher, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("metrics push URL is required")
+	}
+
+	auth := metricspush.Auth{Username: cfg.Username, Password: cfg.Password, BearerToken: cfg.BearerToken}
+	var target metricspush.Target
+	switch cfg.Mode {
+	case "remote-write":
+		rw := metricspush.NewRemoteWrite(cfg.URL, auth)
+		rw.SetHTTPClient(httpClient)
+		target = rw
+	case "pushgateway":
+		gw := metricspush.NewPushGateway(cfg.URL, auth)
+		gw.SetHTTPClient(httpClient)
+		target = gw
+	default:
+		return nil, fmt.Errorf("unknown metrics push mode %q, want remote-write or pushgateway", cfg.Mode)
+	}
+
+	return metricspush.NewPusher(
+		target,
+		shared.metricSamples,
+		metricspush.NewAllowlist(cfg.Series, cfg.Labels),
+		metricspush.InstanceID(deviceID),
+		cfg.Job,
+		metricspush.Config{
+			Interval:    cfg.Interval,
+			MaxInterval: cfg.MaxInterval,
+			Timeout:     cfg.Timeout,
+			BatchSize:   cfg.BatchSize,
+			BufferSize:  cfg.BufferSize,
+		},
+	), nil
+}
+
+// metricSamples collects the metrics of every runner of the process. Each
+// source is an in-memory snapshot, so collecting never waits on a task.
+func (s *sharedResources) metricSamples() []metricspush.Sample {
+	samples := []metricspush.Sample{{Name: metricspush.RunnerUp, Value: 1}}
+
+	if s.metrics != nil {
+		for _, stats := range s.metrics.Snapshot() {
+			labels := map[string]string{metricspush.RunnerLabel: stats.Instance}
+			samples = append(samples,
+				metricspush.Sample{Name: metricspush.TasksClaimed, Labels: labels, Value: float64(stats.Claimed)},
+				metricspush.Sample{Name: metricspush.TasksDeclined, Labels: labels, Value: float64(stats.Declined)},
+				metricspush.Sample{Name: metricspush.TasksCompleted, Labels: labels, Value: float64(stats.Completed)},
+				metricspush.Sample{Name: metricspush.TasksFailed, Labels: labels, Value: float64(stats.Failed)},
+			)
+		}
+	}
+
+	if s.mirrors != nil {
+		stats := s.mirrors.Stats()
+		samples = append(samples,
+			metricspush.Sample{Name: metricspush.RegistryMirrorHits, Value: float64(stats.Hits)},
+			metricspush.Sample{Name: metricspush.RegistryMirrorFallbacks, Value: float64(stats.Fallbacks)},
+			metricspush.Sample{Name: metricspush.RegistryMirrorsHealthy, Value: float64(stats.Healthy)},
+		)
+	}
+	if s.blobs != nil {
+		stats := s.blobs.Stats()
+		samples = append(samples,
+			metricspush.Sample{Name: metricspush.ImageBlobPeerHits, Value: float64(stats.PeerHits)},
+			metricspush.Sample{Name: metricspush.ImageBlobOriginFetches, Value: float64(stats.OriginFetches)},
+		)
+	}
+
+	for _, svc := range s.services {
+		labels := map[string]string{}
+		if svc.instance != nil {
+			labels[metricspush.RunnerLabel] = svc.instance.Name
+		}
+		if handler, ok := svc.taskHandler.(*DefaultTaskHandler); ok {
+			inProgress := 0.0
+			if handler.IsProcessing() {
+				inProgress = 1
+			}
+			samples = append(samples, metricspush.Sample{Name: metricspush.TasksInProgress, Labels: labels, Value: inProgress})
+		}
+		if status := svc.concurrency.Status(); status != nil {
+			samples = append(samples,
+				metricspush.Sample{Name: metricspush.ConcurrencyLimit, Labels: labels, Value: float64(status.Limit)},
+				metricspush.Sample{Name: metricspush.ConcurrencyIncreases, Labels: labels, Value: float64(status.Increases)},
+				metricspush.Sample{Name: metricspush.ConcurrencyDecreases, Labels: labels, Value: float64(status.Decreases)},
+			)
+		}
+		for _, stats := range svc.events.Stats() {
+			subscriberLabels := map[string]string{metricspush.SubscriberLabel: stats.Name}
+			for name, value := range labels {
+				subscriberLabels[name] = value
+			}
+			samples = append(samples, metricspush.Sample{Name: metricspush.EventsDropped, Labels: subscriberLabels, Value: float64(stats.Dropped)})
+		}
+		if svc.llmStats == nil {
+			continue
+		}
+		for _, model := range svc.llmStats.Snapshot() {
+			modelLabels := map[string]string{metricspush.ModelLabel: model.Model}
+			for name, value := range labels {
+				modelLabels[name] = value
+			}
+			loaded := 0.0
+			if model.Loaded {
+				loaded = 1
+			}
+			samples = append(samples,
+				metricspush.Sample{Name: metricspush.LLMQueueDepth, Labels: modelLabels, Value: float64(model.QueueDepth)},
+				metricspush.Sample{Name: metricspush.LLMModelsLoaded, Labels: modelLabels, Value: loaded},
+			)
+		}
+	}
+	return samples
+}
@@ -0,0 +1,204 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/migration"
+)
+
+// ErrMigrated is returned for a task stopped on a planned shutdown whose
+// latest state was handed to the server for another runner to resume from
+var ErrMigrated = errors.New("task migrated to another runner")
+
+var (
+	// errMigrating is the cause a task with state to resume from is
+	// stopped with on a planned shutdown
+	errMigrating = errors.New("runner shutting down: migrating task")
+	// errDrainTimedOut is the cause a task without such state is stopped
+	// with once the shutdown's drain timeout passed
+	errDrainTimedOut = errors.New("runner shutting down: drain timed out")
+)
+
+// migrationUploadTimeout bounds uploading a task's bundle and asking the
+// server to reassign it
+const migrationUploadTimeout = time.Minute
+
+// TaskMigrator is implemented by task clients that can ask the server to
+// reassign a task to another runner with the state it resumes from
+type TaskMigrator interface {
+	RequestTaskMigration(taskID string, lease *models.TaskLease, migration *models.TaskMigration) error
+}
+
+// migrationTasks holds how to stop each running task on a planned shutdown
+type migrationTasks struct {
+	mu    sync.Mutex
+	stops map[string]context.CancelCauseFunc
+}
+
+func (m *migrationTasks) put(taskID string, stop context.CancelCauseFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stops == nil {
+		m.stops = make(map[string]context.CancelCauseFunc)
+	}
+	m.stops[taskID] = stop
+}
+
+func (m *migrationTasks) remove(taskID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.stops, taskID)
+}
+
+// stop stops the running tasks for which match holds with cause
+func (m *migrationTasks) stop(match func(taskID string) bool, cause error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for taskID, stop := range m.stops {
+		if match(taskID) {
+			stop(cause)
+		}
+	}
+}
+
+// SetMigration migrates running tasks to other runners on a planned
+// shutdown, recording their state in tracker and uploading it through
+// store, which also downloads the state of tasks migrated to this runner.
+// With a nil tracker, tasks migrated here still resume but the runner's own
+// tasks are not migrated away.
+func (h *DefaultTaskHandler) SetMigration(tracker *migration.Tracker, store *migration.Store) {
+	h.migrations = tracker
+	h.migrationStore = store
+}
+
+// withMigration returns ctx carrying the bundle a migrated task resumes
+// from, and records the task as running until the returned func is called,
+// so that Migrate can stop it
+func (h *DefaultTaskHandler) withMigration(ctx context.Context, task *models.Task) (context.Context, func()) {
+	taskID := task.ID.String()
+	if task.Migration != nil && h.migrationStore != nil {
+		bundle, err := h.migrationStore.Get(ctx, taskID, task.Migration)
+		if err != nil {
+			log := gologger.WithComponent("task_handler")
+			log.Warn().Err(err).Str("id", taskID).Msg("Cannot resume migrated task - starting over")
+		} else {
+			ctx = migration.WithResume(ctx, bundle)
+			// Should the task migrate again before it gets further, it
+			// carries on from the same state
+			if h.migrations != nil && bundle.Training != nil {
+				h.migrations.RecordTraining(taskID, *bundle.Training)
+			}
+			if h.migrations != nil && bundle.Checkpoint != nil {
+				h.migrations.RecordCheckpoint(taskID, *bundle.Checkpoint)
+			}
+		}
+	}
+	if h.migrations == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	h.migrationTasks.put(taskID, cancel)
+	return ctx, func() {
+		h.migrationTasks.remove(taskID)
+		h.migrations.Forget(taskID)
+		cancel(nil)
+	}
+}
+
+// migrating returns why the task running under ctx was stopped on a
+// planned shutdown, or nil
+func migrating(ctx context.Context) error {
+	if cause := context.Cause(ctx); errors.Is(cause, errMigrating) || errors.Is(cause, errDrainTimedOut) {
+		return cause
+	}
+	return nil
+}
+
+// Migrate drains the handler for a planned shutdown. Running tasks with
+// state to resume from, recorded already or while draining, are stopped
+// and handed to the server for another runner to resume; the rest are
+// left to finish until ctx ends, when they are stopped and returned to the
+// queue. It returns once no task is running.
+func (h *DefaultTaskHandler) Migrate(ctx context.Context) {
+	if h.migrations == nil {
+		return
+	}
+	h.SetDraining(true)
+
+	resumable := func(taskID string) bool { return h.migrations.Bundle(taskID) != nil }
+	h.migrationTasks.stop(resumable, errMigrating)
+
+	ticker := h.clock.NewTicker(handoffPollInterval)
+	defer ticker.Stop()
+
+	done := ctx.Done()
+	for h.isProcessing.Load() {
+		select {
+		case <-done:
+			h.migrationTasks.stop(func(string) bool { return true }, errDrainTimedOut)
+			done = nil
+		case <-ticker.C():
+			if done != nil {
+				h.migrationTasks.stop(resumable, errMigrating)
+			}
+		}
+	}
+}
+
+// migrateTask hands a task stopped on a planned shutdown to the server
+// with its latest state, returning it to the queue instead when it has no
+// state or the server does not take it
+func (h *DefaultTaskHandler) migrateTask(task *models.Task, lease *models.TaskLease, cause error) error {
+	log := gologger.WithComponent("task_handler")
+	h.account(task, h.journal.Abandon)
+	if !errors.Is(cause, errMigrating) {
+		log.Warn().Str("id", task.ID.String()).Msg("Returning task to the queue - runner is shutting down")
+		return h.requeue(task, cause)
+	}
+
+	m, err := h.requestMigration(task, lease)
+	if err != nil {
+		log.Warn().Err(err).Str("id", task.ID.String()).Msg("Failed to migrate task - returning it to the queue")
+		return h.requeue(task, cause)
+	}
+	log.Info().
+		Str("id", task.ID.String()).
+		Str("bundle", m.Bundle).
+		Int("epochs_completed", m.EpochsCompleted).
+		Msg("Task migrated to another runner")
+	return ErrMigrated
+}
+
+// requestMigration uploads the task's bundle and asks the server to
+// reassign the task with it
+func (h *DefaultTaskHandler) requestMigration(task *models.Task, lease *models.TaskLease) (*models.TaskMigration, error) {
+	migrator, ok := h.taskClient.(TaskMigrator)
+	if !ok {
+		return nil, ErrMigrationUnsupported
+	}
+	if h.migrationStore == nil {
+		return nil, errors.New("migration bundle uploads are not enabled on this runner")
+	}
+	bundle := h.migrations.Bundle(task.ID.String())
+	if bundle == nil {
+		return nil, errors.New("no state to resume from")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), migrationUploadTimeout)
+	defer cancel()
+	m, err := h.migrationStore.Put(ctx, bundle)
+	if err != nil {
+		return nil, err
+	}
+	if err := migrator.RequestTaskMigration(task.ID.String(), lease, m); err != nil {
+		return nil, fmt.Errorf("server did not reassign the task: %w", err)
+	}
+	return m, nil
+}
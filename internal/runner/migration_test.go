@@ -0,0 +1,331 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/core/ports"
+	"github.com/theblitlabs/parity-runner/internal/execution/task"
+	"github.com/theblitlabs/parity-runner/internal/execution/training"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+	"github.com/theblitlabs/parity-runner/internal/migration"
+)
+
+// migratingClient records the migrations it is asked for
+type migratingClient struct {
+	releasingClient
+	migrations map[string]*models.TaskMigration
+}
+
+func (c *migratingClient) RequestTaskMigration(taskID string, lease *models.TaskLease, migration *models.TaskMigration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.migrations[taskID] = migration
+	return nil
+}
+
+// bundleGateway serves what is uploaded through it by CID
+type bundleGateway struct {
+	*httptest.Server
+	mu      sync.Mutex
+	uploads map[string][]byte
+}
+
+func newBundleGateway(t *testing.T) *bundleGateway {
+	t.Helper()
+	g := &bundleGateway{uploads: make(map[string][]byte)}
+	g.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g.mu.Lock()
+		data, ok := g.uploads[strings.TrimPrefix(r.URL.Path, "/")]
+		g.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(g.Close)
+	return g
+}
+
+func (g *bundleGateway) ForTask(taskID string) artifacts.Uploader { return g }
+
+func (g *bundleGateway) Add(ctx context.Context, name string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	cid := fmt.Sprintf("QmBundle%d", len(g.uploads)+1)
+	g.uploads[cid] = data
+	return cid, nil
+}
+
+func TestMigrateHandsOverResumableTasks(t *testing.T) {
+	client := &migratingClient{migrations: make(map[string]*models.TaskMigration)}
+	h := NewTaskHandler(&shardExecutor{}, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	tracker := migration.NewTracker()
+	gateway := newBundleGateway(t)
+	h.SetMigration(tracker, migration.NewStore(gateway, nil))
+
+	resumable, stateless := newDockerTask(t), newDockerTask(t)
+	var started sync.WaitGroup
+	run := func(task *models.Task, epochs int) <-chan error {
+		done := make(chan error, 1)
+		started.Add(1)
+		h.begin()
+		go func() {
+			defer h.end()
+			done <- h.runTask(task, nil, func(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+				if epochs > 0 {
+					tracker.RecordTraining(task.ID.String(), migration.Training{EpochsCompleted: epochs, Epochs: 10, Weights: map[string][]float64{"w": {1}}})
+				}
+				started.Done()
+				<-ctx.Done()
+				return nil, ctx.Err()
+			})
+		}()
+		return done
+	}
+	resumableDone := run(resumable, 3)
+	statelessDone := run(stateless, 0)
+	started.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	h.Migrate(ctx)
+
+	if err := <-resumableDone; !errors.Is(err, ErrMigrated) {
+		t.Errorf("resumable task error = %v, want ErrMigrated", err)
+	}
+	if err := <-statelessDone; !errors.Is(err, errDrainTimedOut) {
+		t.Errorf("stateless task error = %v, want it stopped once the drain timed out", err)
+	}
+	if !h.draining.Load() {
+		t.Error("handler still claims tasks")
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	m := client.migrations[resumable.ID.String()]
+	if len(client.migrations) != 1 || m == nil || m.Bundle != "QmBundle1" || m.EpochsCompleted != 3 {
+		t.Fatalf("migrations = %+v, want the resumable task's after three epochs", client.migrations)
+	}
+	if uploaded := len(gateway.uploads[m.Bundle]); int64(uploaded) != m.Size {
+		t.Errorf("uploaded %d bytes, migration says %d", uploaded, m.Size)
+	}
+	// Only the stateless task went back to the queue
+	if len(client.statuses) != 1 || client.statuses[0] != models.TaskStatusPending {
+		t.Errorf("statuses = %v, want the stateless task requeued", client.statuses)
+	}
+	if tracker.Bundle(resumable.ID.String()) != nil {
+		t.Error("tracker kept the migrated task's state")
+	}
+}
+
+func TestMigrationFallsBackToQueue(t *testing.T) {
+	// The client cannot migrate tasks
+	client := &releasingClient{}
+	h := NewTaskHandler(&shardExecutor{}, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	tracker := migration.NewTracker()
+	h.SetMigration(tracker, migration.NewStore(newBundleGateway(t), nil))
+
+	task := newDockerTask(t)
+	started := make(chan struct{})
+	done := make(chan error, 1)
+	h.begin()
+	go func() {
+		defer h.end()
+		done <- h.runTask(task, nil, func(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+			tracker.RecordCheckpoint(task.ID.String(), models.TaskCheckpoint{Name: "step-1", CID: "QmCheckpoint"})
+			close(started)
+			<-ctx.Done()
+			return &models.TaskResult{TaskID: task.ID, ExitCode: 143}, nil
+		})
+	}()
+	<-started
+	h.Migrate(context.Background())
+
+	if err := <-done; !errors.Is(err, errMigrating) {
+		t.Errorf("task error = %v, want it requeued as migrating", err)
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if len(client.statuses) != 1 || client.statuses[0] != models.TaskStatusPending {
+		t.Errorf("statuses = %v, want the task requeued", client.statuses)
+	}
+}
+
+// epochSink records the epochs a round reports, calling onEpoch with each
+// and moving the clock past the report interval
+type epochSink struct {
+	clock   *clocktest.Fake
+	reports []string
+	onEpoch func(message string)
+}
+
+func (s *epochSink) ReportProgress(taskID string, progress *models.TaskProgress) error {
+	s.reports = append(s.reports, progress.Message)
+	s.clock.Advance(time.Second)
+	if s.onEpoch != nil {
+		s.onEpoch(progress.Message)
+	}
+	return nil
+}
+
+// newRoundRunner returns a handler running FL rounds with a real executor
+// over cache, reporting epochs to sink
+func newRoundRunner(t *testing.T, client ports.TaskClient, cache *training.DatasetCache, sink *epochSink) (*DefaultTaskHandler, *task.Executor) {
+	t.Helper()
+	executor := task.NewExecutor()
+	executor.SetDatasetCache(cache)
+	executor.SetClock(sink.clock)
+	executor.SetProgressSink(sink)
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	return h, executor
+}
+
+func newRoundTask(t *testing.T, cache *training.DatasetCache) *models.Task {
+	t.Helper()
+	var dataset strings.Builder
+	dataset.WriteString("x1,x2,y\n")
+	for i := range 16 {
+		x := float64(i) / 16
+		fmt.Fprintf(&dataset, "%g,%g,%g\n", x, 1-x, 2*x)
+	}
+	const cid = "QmMigrationDataset1"
+	body, err := cache.Open(context.Background(), cid, func(context.Context, string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(dataset.String())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body.Close()
+
+	config, err := json.Marshal(map[string]interface{}{
+		"session_id":    "session-1",
+		"round_id":      "round-1",
+		"dataset_cid":   cid,
+		"data_format":   "csv",
+		"output_format": "json",
+		"model_spec": map[string]interface{}{
+			"family": "mlp",
+			"layers": []map[string]interface{}{{"type": "dense", "units": 4}, {"type": "dense", "units": 1}},
+		},
+		"train_config": map[string]interface{}{"epochs": 5, "batch_size": 4, "learning_rate": 0.01},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Task{ID: uuid.New(), Type: models.TaskTypeFederatedLearning, Nonce: "abcdef", Config: config}
+}
+
+func TestRoundMigratesBetweenRunners(t *testing.T) {
+	cache, err := training.OpenDatasetCache(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	gateway := newBundleGateway(t)
+	round := newRoundTask(t, cache)
+	start := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+
+	// The first runner shuts down after the round's second epoch
+	handlerClock := clocktest.NewFake(start)
+	leavingClient := &migratingClient{migrations: make(map[string]*models.TaskMigration)}
+	leavingSink := &epochSink{clock: clocktest.NewFake(start)}
+	leaving, leavingExecutor := newRoundRunner(t, leavingClient, cache, leavingSink)
+	leaving.clock = handlerClock
+	tracker := migration.NewTracker()
+	leavingExecutor.SetMigrationTracker(tracker)
+	leaving.SetMigration(tracker, migration.NewStore(gateway, nil))
+
+	migrated := make(chan struct{})
+	leavingSink.onEpoch = func(message string) {
+		if message != "epoch 2/5" {
+			return
+		}
+		go func() {
+			leaving.Migrate(context.Background())
+			close(migrated)
+		}()
+		// Migrate has stopped the round once it waits on its ticker
+		handlerClock.BlockUntil(1)
+	}
+	leaving.begin()
+	err = leaving.runTask(round, nil, leavingExecutor.ExecuteTask)
+	leaving.end()
+	if !errors.Is(err, ErrMigrated) {
+		t.Fatalf("leaving runner error = %v, want ErrMigrated", err)
+	}
+	for done := false; !done; {
+		handlerClock.Advance(handoffPollInterval)
+		select {
+		case <-migrated:
+			done = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	leavingClient.mu.Lock()
+	pointer := leavingClient.migrations[round.ID.String()]
+	statuses := leavingClient.statuses
+	leavingClient.mu.Unlock()
+	if pointer == nil || pointer.EpochsCompleted != 2 {
+		t.Fatalf("migration = %+v, want the round handed over after two epochs", pointer)
+	}
+	if len(statuses) != 0 {
+		t.Errorf("leaving runner reported statuses %v", statuses)
+	}
+
+	// The server reassigns the round with the pointer to its bundle
+	reassigned := *round
+	reassigned.Migration = pointer
+	manager, err := inputs.NewManager(filepath.Join(t.TempDir(), "inputs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.SetGateway(gateway.URL)
+	arrivingClient := &resultClient{}
+	arrivingSink := &epochSink{clock: clocktest.NewFake(start)}
+	arriving, arrivingExecutor := newRoundRunner(t, arrivingClient, cache, arrivingSink)
+	arriving.SetMigration(nil, migration.NewStore(nil, manager))
+
+	if err := arriving.runTask(&reassigned, nil, arrivingExecutor.ExecuteTask); err != nil {
+		t.Fatalf("arriving runner error = %v", err)
+	}
+	if want := "epoch 3/5,epoch 4/5,epoch 5/5"; strings.Join(arrivingSink.reports, ",") != want {
+		t.Errorf("arriving runner trained %v, want the last three epochs", arrivingSink.reports)
+	}
+	if len(arrivingClient.results) == 0 || arrivingClient.results[len(arrivingClient.results)-1] == nil {
+		t.Fatal("arriving runner reported no result")
+	}
+	result := arrivingClient.results[len(arrivingClient.results)-1]
+	var output struct {
+		Metadata map[string]interface{} `json:"metadata"`
+	}
+	if err := json.Unmarshal([]byte(result.Output), &output); err != nil {
+		t.Fatal(err)
+	}
+	if output.Metadata["resumed_from_epoch"] != 2.0 {
+		t.Errorf("resumed_from_epoch = %v, want 2", output.Metadata["resumed_from_epoch"])
+	}
+}
@@ -0,0 +1,223 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+
+	"github.com/theblitlabs/gologger"
+	"github.com/theblitlabs/keystore"
+
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/blobcache"
+	"github.com/theblitlabs/parity-runner/internal/budget"
+	"github.com/theblitlabs/parity-runner/internal/canary"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/confirm"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/diskreserve"
+	"github.com/theblitlabs/parity-runner/internal/errbudget"
+	"github.com/theblitlabs/parity-runner/internal/errreport"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+	"github.com/theblitlabs/parity-runner/internal/flmodel"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/ipfsfetch"
+	"github.com/theblitlabs/parity-runner/internal/metricspush"
+	"github.com/theblitlabs/parity-runner/internal/objectstore"
+	"github.com/theblitlabs/parity-runner/internal/poison"
+	"github.com/theblitlabs/parity-runner/internal/registrymirror"
+	"github.com/theblitlabs/parity-runner/internal/revocation"
+	"github.com/theblitlabs/parity-runner/internal/tenancy"
+)
+
+// sharedResources are what the runners of one process share: a single
+// runner, or the instances of a MultiService. Each is created once, by the
+// first service built.
+type sharedResources struct {
+	dockerClient *client.Client
+	// httpClient pools the connections of every instance's API requests
+	httpClient    *http.Client
+	keyring       *atrest.Keyring
+	history       *history.Store
+	caches        *localCaches
+	errorReporter *errreport.Reporter
+	metricsPusher *metricspush.Pusher
+	budget        *budget.Tracker
+	poison        *poison.Tracker
+	// revocations and revocationAudit are nil unless revocations are
+	// enabled
+	revocations     *revocation.List
+	revocationAudit *revocation.AuditLog
+	confirm         *confirm.Gate
+	objectStore     *objectstore.Client
+	globalModels    *flmodel.Fetcher
+	clockInfo       *clockReporter
+	gpu             *gpu.Allocator
+	canaries        *canary.Monitor
+	errorBudget     *errbudget.Guard
+	mirrors         *registrymirror.Pool
+	blobs           *blobcache.Fetcher
+	disk            *diskreserve.Ledger
+	warmModels      *llm.WarmModels
+	// ipfs fetches files by CID, nil when verified IPFS fetching is off
+	ipfs *ipfsfetch.Fetcher
+	// pinsGPUs is set when an instance is pinned to GPUs, which needs the
+	// allocator even without GPU sharing
+	pinsGPUs bool
+	metrics  *tenancy.Metrics
+	services []*Service
+}
+
+// keystoreConfig returns where the wallet key of instance is kept
+func keystoreConfig(homeDir string, instance *tenancy.Instance) keystore.Config {
+	if instance == nil || instance.Keystore == "" {
+		return keystore.Config{
+			DirPath:  filepath.Join(homeDir, ".parity"),
+			FileName: "keystore.json",
+		}
+	}
+	return keystore.Config{
+		DirPath:  filepath.Dir(instance.Keystore),
+		FileName: filepath.Base(instance.Keystore),
+	}
+}
+
+// MultiService runs several logical runners, instances, in one process. Each
+// registers with its own device ID, wallet and webhook port and runs the
+// tasks its filters accept on the GPUs and cores pinned to it, while the
+// instances share the Docker client, download manager, caches, task history,
+// compute budget and HTTP connection pool.
+type MultiService struct {
+	services []*Service
+	metrics  *tenancy.Metrics
+}
+
+// NewMultiService builds a runner for each of instances
+func NewMultiService(cfg *config.Config, instances []tenancy.Instance) (*MultiService, error) {
+	return NewMultiServiceWithClock(cfg, instances, clock.Real())
+}
+
+// NewMultiServiceWithClock builds a runner for each of instances, driven by
+// clk
+func NewMultiServiceWithClock(cfg *config.Config, instances []tenancy.Instance, clk clock.Clock) (*MultiService, error) {
+	if err := tenancy.Validate(instances); err != nil {
+		return nil, fmt.Errorf("invalid instances: %w", err)
+	}
+	// Each of these binds the process to a single identity or port
+	if cfg.Runner.Tunnel.Enabled {
+		return nil, fmt.Errorf("tunnels are not supported with several instances")
+	}
+	if cfg.Runner.Audit.Enabled {
+		return nil, fmt.Errorf("audit mode is not supported with several instances")
+	}
+
+	shared := &sharedResources{metrics: tenancy.NewMetrics(instances)}
+	for _, instance := range instances {
+		if len(instance.GPUs) > 0 {
+			shared.pinsGPUs = true
+		}
+	}
+
+	m := &MultiService{metrics: shared.metrics}
+	handlers := make([]*DefaultTaskHandler, 0, len(instances))
+	for i := range instances {
+		svc, err := newService(cfg, clk, shared, &instances[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create instance %q: %w", instances[i].Name, err)
+		}
+		m.services = append(m.services, svc)
+		if handler, ok := svc.taskHandler.(*DefaultTaskHandler); ok {
+			handlers = append(handlers, handler)
+		}
+	}
+	if err := finishMigration(shared.keyring); err != nil {
+		return nil, err
+	}
+	shareGPUTasks(handlers)
+	return m, nil
+}
+
+// Metrics returns the task counts of every instance
+func (m *MultiService) Metrics() *tenancy.Metrics {
+	return m.metrics
+}
+
+// SetHeartbeatInterval sets how often every instance heartbeats
+func (m *MultiService) SetHeartbeatInterval(interval time.Duration) {
+	for _, svc := range m.services {
+		svc.SetHeartbeatInterval(interval)
+	}
+}
+
+// SetModelCapabilities advertises models from every instance
+func (m *MultiService) SetModelCapabilities(models []llm.ModelInfo) error {
+	for _, svc := range m.services {
+		if err := svc.SetModelCapabilities(models); err != nil {
+			return fmt.Errorf("instance %q: %w", svc.instance.Name, err)
+		}
+	}
+	return nil
+}
+
+// Start starts the instances, the primary, which runs the loops over the
+// shared resources and notifies systemd, last. Instances already started are
+// stopped if one fails to start.
+func (m *MultiService) Start() error {
+	log := gologger.WithComponent("runner")
+
+	var started []*Service
+	for i := len(m.services) - 1; i >= 0; i-- {
+		svc := m.services[i]
+		if err := svc.Start(); err != nil {
+			for _, s := range started {
+				if stopErr := s.Stop(context.Background()); stopErr != nil {
+					log.Warn().Err(stopErr).Str("instance", s.instance.Name).Msg("Failed to stop instance")
+				}
+			}
+			return fmt.Errorf("failed to start instance %q: %w", svc.instance.Name, err)
+		}
+		started = append(started, svc)
+		log.Info().
+			Str("instance", svc.instance.Name).
+			Int("webhook_port", svc.instance.WebhookPort).
+			Msg("Runner instance started")
+	}
+	return nil
+}
+
+// Stop stops the instances, the primary, which releases the shared
+// resources, last
+func (m *MultiService) Stop(ctx context.Context) error {
+	// Instances are drained at once, so each has the whole drain timeout
+	// for its tasks
+	var wg sync.WaitGroup
+	for _, svc := range m.services {
+		handler, ok := svc.taskHandler.(*DefaultTaskHandler)
+		if !ok || svc.cfg.Runner.Migration.Enabled {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			drainCtx, cancel := context.WithTimeout(ctx, svc.cfg.Runner.Concurrency.DrainTimeout)
+			defer cancel()
+			handler.Drain(drainCtx)
+		}()
+	}
+	wg.Wait()
+
+	var firstErr error
+	for i := len(m.services) - 1; i >= 0; i-- {
+		svc := m.services[i]
+		if err := svc.stop(ctx, false); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to stop instance %q: %w", svc.instance.Name, err)
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,25 @@
+package runner
+
+import (
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// NetworkOverrideChecker is implemented by executors that apply tasks'
+// network overrides and can tell before a task is claimed whether they are
+// allowed
+type NetworkOverrideChecker interface {
+	CheckNetworkOverrides(task *models.Task) error
+}
+
+// admitNetwork skips tasks whose network overrides conflict or are not
+// allowed by the runner's network policy as invalid
+func (h *DefaultTaskHandler) admitNetwork(task *models.Task) *admissionError {
+	checker, ok := h.executor.(NetworkOverrideChecker)
+	if !ok {
+		return nil
+	}
+	if err := checker.CheckNetworkOverrides(task); err != nil {
+		return &admissionError{reason: models.FLDeclineInvalidConfig, err: err}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/messaging/webhook"
+	"github.com/theblitlabs/parity-runner/internal/overlay"
+	"github.com/theblitlabs/parity-runner/internal/tenancy"
+)
+
+// The newest overlay a runner applied is kept in overlayStateFileName, and
+// an instance's in overlayDirName under the instance's name
+const (
+	overlayDirName       = "overlays"
+	overlayStateFileName = "overlay.json"
+)
+
+// overlayStatePath returns where the runner instance is keeps its newest
+// overlay, the process's only runner when instance is nil
+func overlayStatePath(dataDir string, instance *tenancy.Instance) string {
+	if instance == nil {
+		return filepath.Join(dataDir, overlayStateFileName)
+	}
+	return filepath.Join(dataDir, overlayDirName, instance.Name+".json")
+}
+
+// newOverlayManager returns the manager for fleet configuration overlays
+// and registers the settings they may change, or nil when no fleet public
+// key is configured. The newest overlay applied is kept in statePath.
+func newOverlayManager(cfg *config.Config, deviceID, statePath string, webhookClient *webhook.WebhookClient, taskHandler *DefaultTaskHandler, clk clock.Clock) (*overlay.Manager, error) {
+	if cfg.Runner.Fleet.PublicKey == "" {
+		return nil, nil
+	}
+	key, err := overlay.ParsePublicKey(cfg.Runner.Fleet.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	mgr := overlay.NewManager(key, deviceID, cfg.Runner.Fleet.OverlayTTL)
+	mgr.SetClock(clk)
+
+	localLevel := zerolog.GlobalLevel()
+	settings := map[string]overlay.Setting{
+		"heartbeat_interval": overlay.DurationSetting(5*time.Second, time.Hour, cfg.Runner.HeartbeatInterval, webhookClient.SetHeartbeatInterval),
+		"log_level": overlay.ChoiceSetting(
+			logLevels,
+			localLevel.String(),
+			func(level string) {
+				parsed, err := zerolog.ParseLevel(level)
+				if err != nil {
+					parsed = localLevel
+				}
+				zerolog.SetGlobalLevel(parsed)
+			},
+		),
+		"docker_preflight": overlay.ChoiceSetting(
+			[]string{string(docker.PreflightOff), string(docker.PreflightWarn), string(docker.PreflightAbandon)},
+			cfg.Runner.Docker.Preflight,
+			func(mode string) { taskHandler.SetPreflightMode(docker.PreflightMode(mode)) },
+		),
+	}
+	for name, setting := range settings {
+		if err := mgr.Register(name, setting); err != nil {
+			return nil, fmt.Errorf("failed to register overlay setting: %w", err)
+		}
+	}
+	if err := mgr.SetStatePath(statePath); err != nil {
+		return nil, err
+	}
+	return mgr, nil
+}
@@ -0,0 +1,399 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+	"github.com/theblitlabs/parity-runner/internal/timeline"
+)
+
+// The built-in poll loop is one scheduler of the runner's tasks. Others
+// drive the same pipeline a step at a time:
+//
+//	feasibility := h.EvaluateFeasibility(task)
+//	claimed, err := h.Claim(ctx, feasibility)
+//	execution, err := h.Execute(ctx, claimed)
+//	status, result, err := h.Report(ctx, execution)
+//
+// Each step takes what the one before returned, so a task cannot run
+// before it is claimed nor be claimed before it is admitted. Each of these
+// states is used once: a second use fails with ErrStateUsed.
+
+var (
+	// ErrNotFeasible is returned when a task the runner cannot run is
+	// claimed
+	ErrNotFeasible = errors.New("task is not feasible on this runner")
+	// ErrStateUsed is returned when a feasibility, claim or execution is
+	// used a second time
+	ErrStateUsed = errors.New("task state already used")
+	// ErrReleased is the error a claimed task released unexecuted is
+	// returned to the queue with
+	ErrReleased = errors.New("task released by its scheduler")
+)
+
+// Feasibility is whether this runner can run a task. A feasible task holds
+// the memory, CPU, GPU memory and disk space it was admitted with until it
+// is claimed and reported, or released.
+type Feasibility struct {
+	Task *models.Task
+	// Reason is why the runner cannot run the task, empty when it can
+	Reason models.FLDeclineReason
+	// Skip is why the runner is too busy to run the task now, set instead
+	// of Reason
+	Skip models.SkipReason
+	// Err describes Reason or Skip
+	Err error
+
+	h    *DefaultTaskHandler
+	used atomic.Bool
+}
+
+// Feasible reports whether the runner can run the task
+func (f *Feasibility) Feasible() bool {
+	return f.Reason == "" && f.Skip == ""
+}
+
+// why returns the decline or skip reason, empty for a feasible task
+func (f *Feasibility) why() string {
+	if f.Skip != "" {
+		return string(f.Skip)
+	}
+	return string(f.Reason)
+}
+
+// Release gives up a task that will not be claimed, freeing what it was
+// admitted with. It does nothing once the task is claimed.
+func (f *Feasibility) Release() {
+	if f.used.CompareAndSwap(false, true) {
+		f.h.releaseReservations(f.Task)
+	}
+}
+
+// admission returns the admission error the decline amounts to, nil for a
+// feasible task
+func (f *Feasibility) admission() *admissionError {
+	if f.Feasible() {
+		return nil
+	}
+	return &admissionError{reason: f.Reason, skip: f.Skip, err: f.Err}
+}
+
+// EvaluateFeasibility runs the checks that gate claiming task, as the poll
+// loop does before claiming it: capabilities, scheduling policies, the
+// operator's confirmation and resource reservations, then the image
+// preflight of Docker tasks and the dataset and global model of FL rounds.
+// The decline is published as the poll loop's are; FL rounds are not
+// acknowledged to their coordinator.
+func (h *DefaultTaskHandler) EvaluateFeasibility(task *models.Task) *Feasibility {
+	f := &Feasibility{Task: task, h: h}
+	admission := h.admitTask(task)
+	if admission == nil && task.Type == models.TaskTypeFederatedLearning {
+		admission = h.admitRound(task)
+	}
+	if admission == nil {
+		admission = h.preflightTask(task)
+	}
+	if admission != nil {
+		f.Reason, f.Skip, f.Err = admission.reason, admission.skip, admission.err
+		f.Release()
+	}
+	return f
+}
+
+// admitRound declines FL rounds without a dataset, or whose global model
+// cannot be had
+func (h *DefaultTaskHandler) admitRound(task *models.Task) *admissionError {
+	var config flRoundConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return &admissionError{reason: models.FLDeclineInvalidConfig, err: fmt.Errorf("failed to parse federated learning config: %w", err)}
+	}
+	if config.DatasetCID == "" {
+		return &admissionError{reason: models.FLDeclineNoData, err: errors.New("round assignment does not reference a dataset")}
+	}
+	return h.admitGlobalModel(task)
+}
+
+// releaseReservations frees the memory, CPU, GPU memory and disk space
+// task was admitted with
+func (h *DefaultTaskHandler) releaseReservations(task *models.Task) {
+	h.releaseHost(task)
+	h.releaseDisk(task)
+	h.releaseGPU(task)
+}
+
+// Claimed is a task the runner claimed from the server and has yet to
+// execute
+type Claimed struct {
+	Task *models.Task
+	// Lease is the lease the server granted, nil when it grants none
+	Lease *models.TaskLease
+
+	h    *DefaultTaskHandler
+	used atomic.Bool
+	// finish ends the claim once the task is reported, given the status it
+	// finished with
+	finish func(status models.TaskStatus)
+}
+
+// Claim claims the feasible task f from the server. Rounds of the
+// community sessions the runner donates to are claimed as donated work;
+// other tasks count as in flight and preempt donated training until they
+// are reported. A task that cannot be claimed is released.
+func (h *DefaultTaskHandler) Claim(ctx context.Context, f *Feasibility) (*Claimed, error) {
+	if !f.Feasible() {
+		return nil, fmt.Errorf("%w: %s: %v", ErrNotFeasible, f.why(), f.Err)
+	}
+	if !f.used.CompareAndSwap(false, true) {
+		return nil, ErrStateUsed
+	}
+	task := f.Task
+	c := &Claimed{Task: task, h: h}
+	if err := ctx.Err(); err != nil {
+		h.releaseReservations(task)
+		return nil, err
+	}
+
+	if h.donated(task) {
+		if !h.donor.Begin(task.ID.String()) {
+			h.releaseReservations(task)
+			return nil, &admissionError{skip: models.SkipDonationActive, err: errors.New("runner already trains a donated round")}
+		}
+		c.finish = func(status models.TaskStatus) {
+			h.donor.Finish(task.ID.String(), status == models.TaskStatusCompleted)
+			h.releaseReservations(task)
+		}
+	} else {
+		resumeDonation := h.preemptDonation(task)
+		h.begin()
+		c.finish = func(models.TaskStatus) {
+			h.end()
+			resumeDonation()
+			h.releaseReservations(task)
+		}
+	}
+
+	lease, err := h.claimTask(task)
+	if err != nil {
+		c.finish("")
+		return nil, err
+	}
+	c.Lease = lease
+	return c, nil
+}
+
+// Release returns the claimed task to the server's queue without executing
+// it
+func (c *Claimed) Release() error {
+	if !c.used.CompareAndSwap(false, true) {
+		return ErrStateUsed
+	}
+	c.h.requeue(c.Task, ErrReleased)
+	c.h.account(c.Task, c.h.journal.Abandon)
+	c.finish("")
+	return nil
+}
+
+// progressBuffer and logBuffer are the reports and chunks of output an
+// execution's channels hold before further ones are dropped
+const (
+	progressBuffer = 16
+	logBuffer      = 64
+)
+
+// Execution is a claimed task running. Its progress and output are
+// streamed on channels, closed once the task stops. Reports and output
+// that find a channel full are dropped; the result carries the full
+// output. Every execution must be reported, which frees what the task
+// holds.
+type Execution struct {
+	Task *models.Task
+
+	h      *DefaultTaskHandler
+	claim  *Claimed
+	lease  *models.TaskLease
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	timeout        time.Duration
+	appliedTimeout *models.AppliedTimeout
+	tl             *timeline.Recorder
+	watch          *leaseWatch
+	run            clock.Stopwatch
+	// outcome is the attempt outcome the timeline is finished with
+	outcome string
+	// cleanups run in reverse once the execution is reported
+	cleanups []func()
+
+	progress chan *models.TaskProgress
+	logs     *logStream
+	// lastProgress is the last progress the task reported, and
+	// heartbeatSeen the last the heartbeat saw
+	lastProgress  atomic.Pointer[models.TaskProgress]
+	heartbeatSeen *models.TaskProgress
+
+	done   chan struct{}
+	result *models.TaskResult
+	err    error
+	// ended is set for executions that ended before the task ran, with
+	// the status they were reported with
+	ended  bool
+	status models.TaskStatus
+
+	used atomic.Bool
+}
+
+// Execute starts running the claimed task under ctx and its timeout
+func (h *DefaultTaskHandler) Execute(ctx context.Context, c *Claimed) (*Execution, error) {
+	if !c.used.CompareAndSwap(false, true) {
+		return nil, ErrStateUsed
+	}
+	x := h.prepareExecution(ctx, c.Task, c.Lease)
+	x.claim = c
+	h.startExecution(x, h.executor.ExecuteTask)
+	return x, nil
+}
+
+// Progress streams the progress the task reports
+func (x *Execution) Progress() <-chan *models.TaskProgress {
+	return x.progress
+}
+
+// Logs streams the task's output as it is produced
+func (x *Execution) Logs() <-chan []byte {
+	return x.logs.ch
+}
+
+// Done is closed once the task stops
+func (x *Execution) Done() <-chan struct{} {
+	return x.done
+}
+
+// Cancel stops the task, which is reported failed
+func (x *Execution) Cancel() {
+	x.cancel()
+}
+
+// Result returns what the task produced once Done is closed
+func (x *Execution) Result() (*models.TaskResult, error) {
+	select {
+	case <-x.done:
+		return x.result, x.err
+	default:
+		return nil, errors.New("task is still running")
+	}
+}
+
+// onReport adds f to what runs once the execution is reported
+func (x *Execution) onReport(f func()) {
+	x.cleanups = append(x.cleanups, f)
+}
+
+// close runs the execution's cleanups, latest first
+func (x *Execution) close() {
+	for i := len(x.cleanups) - 1; i >= 0; i-- {
+		x.cleanups[i]()
+	}
+	x.cleanups = nil
+}
+
+// Report waits for the task to stop, then submits its result and records
+// it in the runner's accounting, history and events, as the poll loop
+// does. It returns the status the task finished with, empty when it did
+// not finish here, such as a task returned to the queue. ctx bounds only
+// the wait; once the task stopped its result is reported in full.
+func (h *DefaultTaskHandler) Report(ctx context.Context, x *Execution) (models.TaskStatus, *models.TaskResult, error) {
+	select {
+	case <-x.done:
+	case <-ctx.Done():
+		return "", nil, ctx.Err()
+	}
+	if !x.used.CompareAndSwap(false, true) {
+		return "", nil, ErrStateUsed
+	}
+	status, result, err := h.reportExecution(x)
+	if x.claim != nil {
+		x.claim.finish(status)
+	}
+	return status, result, err
+}
+
+// executionTaps relays the progress running tasks report to their
+// executions
+type executionTaps struct {
+	mu     sync.Mutex
+	byTask map[string]*Execution
+}
+
+func (t *executionTaps) add(x *Execution) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byTask == nil {
+		t.byTask = make(map[string]*Execution)
+	}
+	t.byTask[x.Task.ID.String()] = x
+}
+
+// remove stops relaying to x and closes its progress channel
+func (t *executionTaps) remove(x *Execution) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.byTask[x.Task.ID.String()] == x {
+		delete(t.byTask, x.Task.ID.String())
+	}
+	close(x.progress)
+}
+
+// report offers progress to the execution of taskID, if it is running
+func (t *executionTaps) report(taskID string, progress *models.TaskProgress) {
+	if t == nil || progress == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if x, ok := t.byTask[taskID]; ok {
+		x.lastProgress.Store(progress)
+		select {
+		case x.progress <- progress:
+		default:
+		}
+	}
+}
+
+// logStream copies the output written to it onto a channel until closed
+type logStream struct {
+	mu     sync.Mutex
+	ch     chan []byte
+	closed bool
+}
+
+func newLogStream() *logStream {
+	return &logStream{ch: make(chan []byte, logBuffer)}
+}
+
+func (s *logStream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		select {
+		case s.ch <- append([]byte(nil), p...):
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+func (s *logStream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
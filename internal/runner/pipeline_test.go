@@ -0,0 +1,230 @@
+package runner
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/execution/tasklog"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+// reportingExecutor reports two steps of progress and writes a line of
+// output for each, then waits for release or the task's context
+type reportingExecutor struct {
+	progress docker.ProgressSink
+	release  chan struct{}
+}
+
+func (e *reportingExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	for step := 1; step <= 2; step++ {
+		e.progress.ReportProgress(task.ID.String(), &models.TaskProgress{Percent: float64(50 * step)})
+		if w, ok := tasklog.Output(ctx); ok {
+			w.Write([]byte("step\n"))
+		}
+	}
+	select {
+	case <-e.release:
+		return &models.TaskResult{TaskID: task.ID, Output: "step\nstep\n"}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// nopProgress drops the progress relayed to the server
+type nopProgress struct{}
+
+func (nopProgress) ReportProgress(string, *models.TaskProgress) error { return nil }
+
+func newPipelineHandler(t *testing.T, task *models.Task) (*DefaultTaskHandler, *mockServer, *reportingExecutor) {
+	t.Helper()
+	server, client := newMockServer(t, task)
+	executor := &reportingExecutor{release: make(chan struct{})}
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	executor.progress = &progressPublisher{sink: nopProgress{}, taps: &h.executionTaps}
+	return h, server, executor
+}
+
+// toyScheduler runs the feasible tasks it is offered, highest reward
+// first, a step at a time
+type toyScheduler struct {
+	h *DefaultTaskHandler
+	// skipped records why tasks were not run
+	skipped map[string]models.FLDeclineReason
+}
+
+func (s *toyScheduler) run(ctx context.Context, tasks []*models.Task, onStart func(*Execution)) ([]models.TaskStatus, error) {
+	var feasible []*Feasibility
+	for _, task := range tasks {
+		f := s.h.EvaluateFeasibility(task)
+		if !f.Feasible() {
+			s.skipped[task.ID.String()] = f.Reason
+			continue
+		}
+		feasible = append(feasible, f)
+	}
+	slices.SortFunc(feasible, func(a, b *Feasibility) int {
+		return cmp.Compare(b.Task.Reward, a.Task.Reward)
+	})
+
+	var statuses []models.TaskStatus
+	for _, f := range feasible {
+		claimed, err := s.h.Claim(ctx, f)
+		if err != nil {
+			return statuses, err
+		}
+		execution, err := s.h.Execute(ctx, claimed)
+		if err != nil {
+			return statuses, err
+		}
+		onStart(execution)
+		status, _, err := s.h.Report(ctx, execution)
+		if err != nil {
+			return statuses, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func TestToySchedulerDrivesPipeline(t *testing.T) {
+	task := newPendingTask(t, models.ResourceConfig{})
+	gpuTask := newPendingTask(t, models.ResourceConfig{Accelerator: "cuda"})
+	h, server, executor := newPipelineHandler(t, task)
+	scheduler := &toyScheduler{h: h, skipped: make(map[string]models.FLDeclineReason)}
+
+	var (
+		progress []float64
+		logs     []string
+	)
+	statuses, err := scheduler.run(context.Background(), []*models.Task{gpuTask, task}, func(x *Execution) {
+		if !h.IsProcessing() {
+			t.Error("executing task not counted in flight")
+		}
+		for range 2 {
+			progress = append(progress, (<-x.Progress()).Percent)
+			logs = append(logs, string(<-x.Logs()))
+		}
+		if _, err := x.Result(); err == nil {
+			t.Error("Result() returned before the task stopped")
+		}
+		close(executor.release)
+		<-x.Done()
+		if result, err := x.Result(); err != nil || result.Output != "step\nstep\n" {
+			t.Errorf("Result() = %+v, %v", result, err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(statuses, []models.TaskStatus{models.TaskStatusCompleted}) {
+		t.Errorf("statuses = %v, want the one feasible task completed", statuses)
+	}
+	if reason := scheduler.skipped[gpuTask.ID.String()]; reason != models.FLDeclineInsufficientResources {
+		t.Errorf("GPU task skipped as %q, want %q", reason, models.FLDeclineInsufficientResources)
+	}
+	if !slices.Equal(progress, []float64{50, 100}) || !slices.Equal(logs, []string{"step\n", "step\n"}) {
+		t.Errorf("streamed progress %v and output %q", progress, logs)
+	}
+	if !server.claimed || !server.finished || server.result == nil || server.result.Output != "step\nstep\n" {
+		t.Errorf("server saw claimed=%v finished=%v result=%+v, want the result submitted", server.claimed, server.finished, server.result)
+	}
+	if h.IsProcessing() {
+		t.Error("reported task still counted in flight")
+	}
+}
+
+func TestPipelineStatesAreUsedOnce(t *testing.T) {
+	task := newPendingTask(t, models.ResourceConfig{})
+	h, server, executor := newPipelineHandler(t, task)
+	close(executor.release)
+	ctx := context.Background()
+
+	infeasible := h.EvaluateFeasibility(newPendingTask(t, models.ResourceConfig{Accelerator: "cuda"}))
+	if _, err := h.Claim(ctx, infeasible); !errors.Is(err, ErrNotFeasible) {
+		t.Fatalf("Claim() of an infeasible task error = %v, want ErrNotFeasible", err)
+	}
+
+	feasibility := h.EvaluateFeasibility(task)
+	claimed, err := h.Claim(ctx, feasibility)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Claim(ctx, feasibility); !errors.Is(err, ErrStateUsed) {
+		t.Errorf("second Claim() error = %v, want ErrStateUsed", err)
+	}
+	execution, err := h.Execute(ctx, claimed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Execute(ctx, claimed); !errors.Is(err, ErrStateUsed) {
+		t.Errorf("second Execute() error = %v, want ErrStateUsed", err)
+	}
+	if err := claimed.Release(); !errors.Is(err, ErrStateUsed) {
+		t.Errorf("Release() of an executed task error = %v, want ErrStateUsed", err)
+	}
+	if status, _, err := h.Report(ctx, execution); err != nil || status != models.TaskStatusCompleted {
+		t.Fatalf("Report() = %s, %v", status, err)
+	}
+	if _, _, err := h.Report(ctx, execution); !errors.Is(err, ErrStateUsed) {
+		t.Errorf("second Report() error = %v, want ErrStateUsed", err)
+	}
+	if !server.finished {
+		t.Error("result not submitted")
+	}
+}
+
+func TestPipelineCancelAndRelease(t *testing.T) {
+	t.Run("cancel", func(t *testing.T) {
+		task := newPendingTask(t, models.ResourceConfig{})
+		h, server, _ := newPipelineHandler(t, task)
+		ctx := context.Background()
+		claimed, err := h.Claim(ctx, h.EvaluateFeasibility(task))
+		if err != nil {
+			t.Fatal(err)
+		}
+		execution, err := h.Execute(ctx, claimed)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		if _, _, err := h.Report(waitCtx, execution); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Report() of a running task error = %v, want the wait to time out", err)
+		}
+		execution.Cancel()
+		status, _, err := h.Report(ctx, execution)
+		if !errors.Is(err, context.Canceled) || status != models.TaskStatusFailed {
+			t.Fatalf("Report() of a cancelled task = %s, %v", status, err)
+		}
+		if !server.finished || server.result == nil || server.result.Error == "" {
+			t.Errorf("server result = %+v, want the failure submitted", server.result)
+		}
+	})
+
+	t.Run("release", func(t *testing.T) {
+		task := newPendingTask(t, models.ResourceConfig{})
+		h, server, _ := newPipelineHandler(t, task)
+		claimed, err := h.Claim(context.Background(), h.EvaluateFeasibility(task))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := claimed.Release(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := h.Execute(context.Background(), claimed); !errors.Is(err, ErrStateUsed) {
+			t.Fatalf("Execute() of a released task error = %v, want ErrStateUsed", err)
+		}
+		if h.IsProcessing() || server.finished {
+			t.Error("released task still held or reported")
+		}
+	})
+}
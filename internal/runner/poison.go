@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/poison"
+)
+
+// ErrTaskPoisoned is returned for a task abandoned because it kept failing
+// the same way
+var ErrTaskPoisoned = errors.New("task poisoned")
+
+const poisonFileName = "poisoned_tasks.json"
+
+// PoisonReleaser is implemented by task clients that can abandon a task as
+// poisoned
+type PoisonReleaser interface {
+	ReleasePoisoned(taskID string, lease *models.TaskLease, report *models.PoisonReport) error
+}
+
+// SetPoisonTracker records failed executions with tracker, abandoning tasks
+// that keep failing the same way and declining them from then on
+func (h *DefaultTaskHandler) SetPoisonTracker(tracker *poison.Tracker) {
+	h.poison = tracker
+}
+
+// admitPoison declines tasks this runner found poisoned
+func (h *DefaultTaskHandler) admitPoison(task *models.Task) *admissionError {
+	if h.poison == nil {
+		return nil
+	}
+	report := h.poison.Poisoned(task.ID.String())
+	if report == nil {
+		return nil
+	}
+	return &admissionError{reason: models.FLDeclinePoisoned, err: fmt.Errorf("task failed %d times with fingerprint %s", len(report.Attempts), report.Fingerprint)}
+}
+
+// abandonPoisoned records a failed execution of task, and when that
+// poisons the task abandons it with its report instead of reporting the
+// failure. It returns whether the task was abandoned.
+func (h *DefaultTaskHandler) abandonPoisoned(task *models.Task, lease *models.TaskLease, failure poison.Failure) bool {
+	if h.poison == nil {
+		return false
+	}
+	log := gologger.WithComponent("task_handler")
+	report, err := h.poison.Record(task.ID.String(), failure)
+	if err != nil {
+		log.Error().Err(err).Str("id", task.ID.String()).Msg("Failed to record task failure")
+	}
+	if report == nil {
+		return false
+	}
+	releaser, ok := h.taskClient.(PoisonReleaser)
+	if !ok {
+		return false
+	}
+	if err := releaser.ReleasePoisoned(task.ID.String(), lease, report); err != nil {
+		log.Error().Err(err).Str("id", task.ID.String()).Msg("Failed to abandon poisoned task")
+		return false
+	}
+	log.Warn().
+		Str("id", task.ID.String()).
+		Str("fingerprint", report.Fingerprint).
+		Int("attempts", len(report.Attempts)).
+		Msg("Abandoned poisoned task - it keeps failing the same way")
+	return true
+}
+
+// newPoisonTracker returns the tracker the poison config describes,
+// keeping its records in dataDir and recording failures as runner
+func newPoisonTracker(cfg config.PoisonConfig, dataDir, runner string) (*poison.Tracker, error) {
+	tracker, err := poison.Open(filepath.Join(dataDir, poisonFileName), runner, cfg.Threshold)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.StoreMode {
+	case "":
+	case "http":
+		if cfg.StoreURL == "" {
+			return nil, errors.New("poison store URL is required in http mode")
+		}
+		tracker.SetStore(poison.NewHTTPStore(cfg.StoreURL), cfg.StoreTimeout)
+	case "file":
+		if cfg.StoreDir == "" {
+			return nil, errors.New("poison store directory is required in file mode")
+		}
+		store, err := poison.NewFileStore(cfg.StoreDir)
+		if err != nil {
+			return nil, err
+		}
+		tracker.SetStore(store, cfg.StoreTimeout)
+	default:
+		return nil, fmt.Errorf("unknown poison store mode %q", cfg.StoreMode)
+	}
+	return tracker, nil
+}
@@ -0,0 +1,112 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+// poisonClient records the tasks abandoned as poisoned
+type poisonClient struct {
+	releasingClient
+	reports []*models.PoisonReport
+}
+
+func (c *poisonClient) ReleasePoisoned(taskID string, lease *models.TaskLease, report *models.PoisonReport) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reports = append(c.reports, report)
+	return nil
+}
+
+// crashingExecutor fails every task the same way, from a different working
+// directory each time
+type crashingExecutor struct {
+	calls int
+}
+
+func (e *crashingExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	e.calls++
+	return &models.TaskResult{
+		TaskID:   task.ID,
+		ExitCode: 1,
+		Output:   fmt.Sprintf("Traceback (most recent call last):\n  File \"/tmp/task-%d/train.py\", line 12\nKeyError: 'label'", e.calls),
+	}, nil
+}
+
+func newPoisonHandler(t *testing.T, dataDir string, executor *crashingExecutor) (*DefaultTaskHandler, *poisonClient) {
+	t.Helper()
+	tracker, err := newPoisonTracker(config.PoisonConfig{Threshold: 2}, dataDir, "runner-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &poisonClient{}
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	h.SetPoisonTracker(tracker)
+	return h, client
+}
+
+func TestPoisonedTaskIsAbandonedAndNeverClaimedAgain(t *testing.T) {
+	dataDir := t.TempDir()
+	executor := &crashingExecutor{}
+	h, client := newPoisonHandler(t, dataDir, executor)
+	task := newDockerTask(t)
+
+	if err := h.HandleTask(task); err != nil {
+		t.Fatalf("HandleTask() error = %v", err)
+	}
+	if len(client.reports) != 0 || len(client.statuses) == 0 || client.statuses[len(client.statuses)-1] != models.TaskStatusFailed {
+		t.Fatalf("first failure: statuses %v, reports %v, want it reported as failed", client.statuses, client.reports)
+	}
+
+	reported := len(client.statuses)
+	if err := h.HandleTask(task); !errors.Is(err, ErrTaskPoisoned) {
+		t.Fatalf("HandleTask() error = %v, want ErrTaskPoisoned", err)
+	}
+	if len(client.reports) != 1 || len(client.reports[0].Attempts) != 2 || client.reports[0].Fingerprint == "" {
+		t.Fatalf("reports = %+v, want the task abandoned with both attempts", client.reports)
+	}
+	for _, status := range client.statuses[reported:] {
+		if status == models.TaskStatusFailed {
+			t.Fatal("poisoned task also reported as failed")
+		}
+	}
+
+	// After a restart the task is declined before it runs
+	executor = &crashingExecutor{}
+	h, client = newPoisonHandler(t, dataDir, executor)
+	err := h.HandleTask(task)
+	var admission *admissionError
+	if !errors.As(err, &admission) || admission.reason != models.FLDeclinePoisoned {
+		t.Fatalf("HandleTask() error = %v, want a poisoned decline", err)
+	}
+	if executor.calls != 0 || len(client.statuses) != 0 {
+		t.Fatalf("poisoned task ran %d times after a restart", executor.calls)
+	}
+	if other := newDockerTask(t); h.HandleTask(other) != nil || executor.calls != 1 {
+		t.Fatal("other tasks are declined too")
+	}
+}
+
+func TestNewPoisonTrackerRejectsBadStore(t *testing.T) {
+	for _, cfg := range []config.PoisonConfig{
+		{Threshold: 3, StoreMode: "http"},
+		{Threshold: 3, StoreMode: "file"},
+		{Threshold: 3, StoreMode: "redis"},
+		{Threshold: 0},
+	} {
+		if _, err := newPoisonTracker(cfg, t.TempDir(), "runner-1"); err == nil {
+			t.Errorf("newPoisonTracker(%+v) accepted", cfg)
+		}
+	}
+	if _, err := newPoisonTracker(config.PoisonConfig{Threshold: 3, StoreMode: "file", StoreDir: filepath.Join(t.TempDir(), "fleet")}, t.TempDir(), "runner-1"); err != nil {
+		t.Errorf("newPoisonTracker() error = %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/expr"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+const (
+	// postProcessBudget is the evaluation cost the post-processing of one
+	// result may spend, over all its expressions
+	postProcessBudget = 100_000
+	// maxDerivedFieldSize bounds a derived field encoded as JSON, so fields
+	// cannot copy the output into the result's metadata
+	maxDerivedFieldSize = 4 << 10
+)
+
+// postProcessConfig returns the post-processing task asks for, nil when it
+// asks for none
+func postProcessConfig(task *models.Task) *models.PostProcessConfig {
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return nil
+	}
+	return config.PostProcess
+}
+
+// postProcess evaluates the post-processing task asks for over result and
+// returns the status to report it with, as its verdict decides
+func (h *DefaultTaskHandler) postProcess(task *models.Task, status models.TaskStatus, result *models.TaskResult) models.TaskStatus {
+	cfg := postProcessConfig(task)
+	if cfg == nil {
+		return status
+	}
+	status = postProcessResult(cfg, status, result)
+
+	log := gologger.WithComponent("task_handler")
+	if len(result.PostProcess.Errors) > 0 {
+		log.Debug().
+			Str("id", task.ID.String()).
+			Interface("errors", result.PostProcess.Errors).
+			Msg("Task post-processing expressions failed")
+	}
+	if result.FailureCode == models.FailureVerdict {
+		log.Info().Str("id", task.ID.String()).Str("reason", result.Error).Msg("Task failed its post-processing verdict")
+	}
+	return status
+}
+
+// postProcessResult derives cfg's fields from result into its metadata and
+// applies cfg's verdict. A field that fails is reported without failing the
+// task; a verdict that fails, or names a failed field, fails it. The verdict
+// decides a task that ran to completion or exited with an error, never one
+// failed for another reason.
+func postProcessResult(cfg *models.PostProcessConfig, status models.TaskStatus, result *models.TaskResult) models.TaskStatus {
+	report := &models.PostProcessReport{}
+	result.PostProcess = report
+	budget := expr.NewBudget(postProcessBudget)
+	vars := postProcessVars(result)
+	fail := func(name string, err error) {
+		if report.Errors == nil {
+			report.Errors = make(map[string]string)
+		}
+		report.Errors[name] = err.Error()
+	}
+
+	names := make([]string, 0, len(cfg.Fields))
+	for name := range cfg.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fields := make(map[string]interface{}, len(names))
+	for _, name := range names {
+		value, err := evalExpression(cfg.Fields[name], vars, budget)
+		if err == nil {
+			err = checkDerivedField(value)
+		}
+		if err != nil {
+			fail(name, err)
+			continue
+		}
+		fields[name] = value
+	}
+	if len(fields) > 0 {
+		if result.Metadata == nil {
+			result.Metadata = make(map[string]interface{}, len(fields))
+		}
+		for name, value := range fields {
+			result.Metadata[name] = value
+		}
+	}
+
+	defer func() { report.Cost = budget.Spent }()
+	if cfg.Verdict == "" {
+		return status
+	}
+	decidable := status == models.TaskStatusCompleted || result.FailureCode == models.FailureTaskExit
+	vars["fields"] = fields
+	value, err := evalExpression(cfg.Verdict, vars, budget)
+	passed, ok := value.(bool)
+	if err == nil && !ok {
+		err = fmt.Errorf("verdict is a %T, not a bool", value)
+	}
+	if err != nil {
+		fail(models.PostProcessVerdictKey, err)
+		if !decidable {
+			return status
+		}
+		result.FailureCode = models.FailureVerdict
+		result.Error = fmt.Sprintf("post-processing verdict failed: %v", err)
+		return models.TaskStatusFailed
+	}
+
+	report.Verdict = &passed
+	switch {
+	case !decidable:
+		return status
+	case passed:
+		result.FailureCode = ""
+		return models.TaskStatusCompleted
+	}
+	result.FailureCode = models.FailureVerdict
+	if result.Error == "" {
+		result.Error = "post-processing verdict failed the task"
+	}
+	return models.TaskStatusFailed
+}
+
+func evalExpression(src string, vars map[string]interface{}, budget *expr.Budget) (interface{}, error) {
+	program, err := expr.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return program.Eval(vars, budget)
+}
+
+// checkDerivedField fails a value too large for the result's metadata, or
+// one JSON cannot hold, such as the infinity of a division
+func checkDerivedField(value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("value cannot be encoded: %w", err)
+	}
+	if len(data) > maxDerivedFieldSize {
+		return fmt.Errorf("value is %d bytes, more than the %d allowed", len(data), maxDerivedFieldSize)
+	}
+	return nil
+}
+
+// postProcessVars are the variables post-processing expressions see
+func postProcessVars(result *models.TaskResult) map[string]interface{} {
+	var parsed interface{}
+	if safejson.Unmarshal([]byte(result.Output), &parsed) != nil {
+		parsed = nil
+	}
+	return map[string]interface{}{
+		"exit_code": float64(result.ExitCode),
+		"output":    result.Output,
+		"error":     result.Error,
+		"json":      parsed,
+		"usage": map[string]interface{}{
+			"duration_seconds":  result.Duration.Seconds(),
+			"cpu_seconds":       result.CPUSeconds,
+			"memory_gb_hours":   result.MemoryGBHours,
+			"peak_memory_bytes": float64(result.PeakMemoryBytes),
+			"storage_gb":        result.StorageGB,
+			"network_data_gb":   result.NetworkDataGB,
+		},
+	}
+}
@@ -0,0 +1,112 @@
+package runner
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func TestPostProcessExtractsFields(t *testing.T) {
+	cfg := &models.PostProcessConfig{Fields: map[string]string{
+		"accuracy": `number(extract(output, "accuracy=([0-9.]+)"))`,
+		"passed":   `json.ok`,
+		"slow":     `usage.duration_seconds > 60`,
+	}}
+	result := &models.TaskResult{Output: "epoch 3\naccuracy=0.91\n", Duration: 90 * time.Second}
+
+	if status := postProcessResult(cfg, models.TaskStatusCompleted, result); status != models.TaskStatusCompleted {
+		t.Fatalf("status = %s, want completed", status)
+	}
+	if result.Metadata["accuracy"] != 0.91 || result.Metadata["slow"] != true {
+		t.Errorf("metadata = %v", result.Metadata)
+	}
+	// A field that fails is reported and left out, without failing the task
+	if _, ok := result.Metadata["passed"]; ok || !strings.Contains(result.PostProcess.Errors["passed"], "missing field") {
+		t.Errorf("failed field: metadata = %v, errors = %v", result.Metadata, result.PostProcess.Errors)
+	}
+	if result.PostProcess.Verdict != nil || result.PostProcess.Cost == 0 {
+		t.Errorf("report = %+v", result.PostProcess)
+	}
+}
+
+func TestPostProcessThresholdVerdict(t *testing.T) {
+	cfg := &models.PostProcessConfig{
+		Fields:  map[string]string{"accuracy": `json.metrics.accuracy`},
+		Verdict: `fields.accuracy >= 0.9`,
+	}
+	tests := []struct {
+		name     string
+		output   string
+		exitCode int
+		code     models.FailureCode
+		want     models.TaskStatus
+		verdict  bool
+	}{
+		{"above", `{"metrics": {"accuracy": 0.95}}`, 0, "", models.TaskStatusCompleted, true},
+		{"below", `{"metrics": {"accuracy": 0.5}}`, 0, "", models.TaskStatusFailed, false},
+		// The verdict overrides the exit code of a task that ran
+		{"passed despite exit", `{"metrics": {"accuracy": 0.95}}`, 3, models.FailureTaskExit, models.TaskStatusCompleted, true},
+	}
+	for _, tt := range tests {
+		result := &models.TaskResult{Output: tt.output, ExitCode: tt.exitCode, FailureCode: tt.code}
+		status := models.TaskStatusCompleted
+		if tt.exitCode != 0 {
+			status = models.TaskStatusFailed
+		}
+		status = postProcessResult(cfg, status, result)
+		if status != tt.want || result.PostProcess.Verdict == nil || *result.PostProcess.Verdict != tt.verdict {
+			t.Errorf("%s: status = %s, report = %+v, want %s", tt.name, status, result.PostProcess, tt.want)
+		}
+		if tt.want == models.TaskStatusFailed && result.FailureCode != models.FailureVerdict {
+			t.Errorf("%s: failure code = %q, want verdict", tt.name, result.FailureCode)
+		}
+		if tt.want == models.TaskStatusCompleted && result.FailureCode != "" {
+			t.Errorf("%s: failure code = %q after passing", tt.name, result.FailureCode)
+		}
+	}
+
+	// A task failed for its outputs stays failed whatever the verdict
+	result := &models.TaskResult{Output: `{"metrics": {"accuracy": 0.95}}`, FailureCode: models.FailureOutputs}
+	if status := postProcessResult(cfg, models.TaskStatusFailed, result); status != models.TaskStatusFailed || result.FailureCode != models.FailureOutputs {
+		t.Errorf("status = %s, code = %s, want the outputs failure kept", status, result.FailureCode)
+	}
+}
+
+func TestPostProcessVerdictErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *models.PostProcessConfig
+		want string
+	}{
+		{
+			"depends on a failed field",
+			&models.PostProcessConfig{Fields: map[string]string{"score": `json.score`}, Verdict: `fields.score > 1`},
+			`missing field "score"`,
+		},
+		{"not a bool", &models.PostProcessConfig{Verdict: `exit_code`}, "not a bool"},
+		{"over the cost limit", &models.PostProcessConfig{Verdict: strings.Repeat(`matches(output, "x") || `, 20) + `false`}, "cost limit"},
+		{"malformed", &models.PostProcessConfig{Verdict: `exit_code ==`}, "unexpected end"},
+	}
+	output := strings.Repeat("not json ", 200_000)
+	for _, tt := range tests {
+		result := &models.TaskResult{Output: output}
+		status := postProcessResult(tt.cfg, models.TaskStatusCompleted, result)
+		if status != models.TaskStatusFailed || result.FailureCode != models.FailureVerdict {
+			t.Errorf("%s: status = %s, code = %q, want failed on the verdict", tt.name, status, result.FailureCode)
+		}
+		if got := result.PostProcess.Errors[models.PostProcessVerdictKey]; !strings.Contains(got, tt.want) || !strings.Contains(result.Error, tt.want) {
+			t.Errorf("%s: verdict error = %q, result error = %q, want %q", tt.name, got, result.Error, tt.want)
+		}
+	}
+}
+
+func TestPostProcessBoundsDerivedFields(t *testing.T) {
+	cfg := &models.PostProcessConfig{Fields: map[string]string{"copy": `output`, "ratio": `1 / 0.0 * 0`}}
+	result := &models.TaskResult{Output: strings.Repeat("x", 10_000)}
+	postProcessResult(cfg, models.TaskStatusCompleted, result)
+	if len(result.Metadata) != 0 || !strings.Contains(result.PostProcess.Errors["copy"], "more than the") {
+		t.Fatalf("metadata = %d fields, errors = %v, want the copy refused", len(result.Metadata), result.PostProcess.Errors)
+	}
+}
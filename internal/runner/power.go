@@ -0,0 +1,253 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/power"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// defaultCommandTimeout is the limit the command executor applies to
+// command tasks that do not set timeout_seconds
+const defaultCommandTimeout = 5 * time.Minute
+
+// powerSampleTimeout bounds a single power and thermal sample
+const powerSampleTimeout = 10 * time.Second
+
+// TaskSuspender is implemented by executors that can freeze a running task
+// in place and continue it later
+type TaskSuspender interface {
+	SuspendTask(ctx context.Context, task *models.Task) error
+	ResumeSuspendedTask(ctx context.Context, task *models.Task) error
+}
+
+// powerTask is a running task and how to stop it when power conditions no
+// longer allow it. Only WatchPower touches suspended.
+type powerTask struct {
+	task      *models.Task
+	runtime   time.Duration
+	stop      context.CancelCauseFunc
+	suspended bool
+}
+
+type powerTasks struct {
+	mu    sync.Mutex
+	tasks map[string]*powerTask
+}
+
+func (p *powerTasks) put(task *powerTask) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.tasks == nil {
+		p.tasks = make(map[string]*powerTask)
+	}
+	p.tasks[task.task.ID.String()] = task
+}
+
+func (p *powerTasks) remove(taskID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.tasks, taskID)
+}
+
+func (p *powerTasks) list() []*powerTask {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	tasks := make([]*powerTask, 0, len(p.tasks))
+	for _, task := range p.tasks {
+		tasks = append(tasks, task)
+	}
+	return tasks
+}
+
+// SetPowerMonitor gates claiming on the power and thermal state monitor
+// reports, and applies action to running tasks the state no longer allows
+func (h *DefaultTaskHandler) SetPowerMonitor(monitor *power.Monitor, action power.InFlightAction) {
+	h.power = monitor
+	h.powerAction = action
+}
+
+// runtimeBound returns the longest task may run: the timeout it declares,
+// up to the runner's ceiling, or else the one the runner applies
+func (h *DefaultTaskHandler) runtimeBound(task *models.Task) time.Duration {
+	var config struct {
+		Resources struct {
+			Timeout string `json:"timeout"`
+		} `json:"resources"`
+		TimeoutSeconds int `json:"timeout_seconds"`
+	}
+	if err := safejson.Unmarshal(task.Config, &config); err == nil {
+		if timeout, err := time.ParseDuration(config.Resources.Timeout); err == nil && timeout > 0 {
+			return h.timeouts.ceil(timeout)
+		}
+		if task.Type == models.TaskTypeCommand {
+			if config.TimeoutSeconds > 0 {
+				return time.Duration(config.TimeoutSeconds) * time.Second
+			}
+			return defaultCommandTimeout
+		}
+	}
+	timeout, _ := h.timeouts.Resolve(task)
+	return timeout
+}
+
+// admitPower declines tasks the host's power or thermal state does not allow
+func (h *DefaultTaskHandler) admitPower(task *models.Task) *admissionError {
+	if h.power == nil {
+		return nil
+	}
+	if err := h.power.Admit(task.Type, h.runtimeBound(task)); err != nil {
+		return &admissionError{reason: models.FLDeclinePowerConstrained, err: err}
+	}
+	return nil
+}
+
+// withPower returns ctx cancelled with power.ErrConstrained if the task is
+// abandoned because of the host's power or thermal state
+func (h *DefaultTaskHandler) withPower(ctx context.Context, task *models.Task) (context.Context, context.CancelFunc) {
+	if h.power == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	h.powerTasks.put(&powerTask{task: task, runtime: h.runtimeBound(task), stop: cancel})
+	return ctx, func() {
+		h.powerTasks.remove(task.ID.String())
+		cancel(nil)
+	}
+}
+
+// constrained reports whether the task running under ctx was abandoned
+// because of the host's power or thermal state
+func constrained(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), power.ErrConstrained)
+}
+
+// WatchPower samples the host's power and thermal state now and every
+// interval until ctx ends, applying the in-flight action to running tasks
+// the state no longer allows and resuming suspended tasks once it does
+func (h *DefaultTaskHandler) WatchPower(ctx context.Context, interval time.Duration) {
+	if h.power == nil {
+		return
+	}
+
+	ticker := h.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		h.checkPower(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+func (h *DefaultTaskHandler) checkPower(ctx context.Context) {
+	log := gologger.WithComponent("task_handler")
+
+	sampleCtx, cancel := context.WithTimeout(ctx, powerSampleTimeout)
+	defer cancel()
+
+	prev, state, err := h.power.Refresh(sampleCtx)
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to sample power state")
+		return
+	}
+	if prev == nil || prev.Mode != state.Mode {
+		event := log.Info()
+		if state.Mode != models.PowerModeNormal {
+			event = log.Warn()
+		}
+		event.
+			Str("mode", string(state.Mode)).
+			Str("reason", state.Reason).
+			Bool("on_battery", state.OnBattery).
+			Float64("battery_percent", state.BatteryPercent).
+			Bool("throttled", state.Throttled).
+			Msg("Power mode changed")
+	}
+
+	for _, running := range h.powerTasks.list() {
+		err := h.power.Admit(running.task.Type, running.runtime)
+		switch {
+		case err == nil && running.suspended:
+			h.resumeSuspended(ctx, running)
+		case err != nil && !running.suspended:
+			h.constrainTask(ctx, running, err)
+		}
+	}
+}
+
+// powerPause is the reason timelines give for the time a task spent
+// suspended for power conditions
+const powerPause = "power"
+
+// constrainTask applies the in-flight action to a running task the power
+// state no longer allows
+func (h *DefaultTaskHandler) constrainTask(ctx context.Context, running *powerTask, reason error) {
+	log := gologger.WithComponent("task_handler")
+	taskID := running.task.ID.String()
+
+	switch h.powerAction {
+	case power.InFlightCheckpoint:
+		suspender, ok := h.executor.(TaskSuspender)
+		if ok {
+			err := suspender.SuspendTask(ctx, running.task)
+			if err == nil {
+				running.suspended = true
+				h.timelines.Get(taskID).Pause(powerPause)
+				log.Info().Err(reason).Str("id", taskID).Msg("Suspended task until power conditions recover")
+				return
+			}
+			log.Debug().Err(err).Str("id", taskID).Msg("Failed to suspend task")
+		}
+		fallthrough
+	case power.InFlightAbandon:
+		log.Warn().Err(reason).Str("id", taskID).Msg("Abandoning task - power conditions no longer allow it")
+		running.stop(power.ErrConstrained)
+	}
+}
+
+func (h *DefaultTaskHandler) resumeSuspended(ctx context.Context, running *powerTask) {
+	log := gologger.WithComponent("task_handler")
+	suspender, ok := h.executor.(TaskSuspender)
+	if !ok {
+		return
+	}
+	if err := suspender.ResumeSuspendedTask(ctx, running.task); err != nil {
+		log.Warn().Err(err).Str("id", running.task.ID.String()).Msg("Failed to resume suspended task")
+		return
+	}
+	running.suspended = false
+	h.timelines.Get(running.task.ID.String()).Resume()
+	log.Info().Str("id", running.task.ID.String()).Msg("Resumed suspended task")
+}
+
+// newPowerMonitor returns the monitor the power config describes
+func newPowerMonitor(cfg config.PowerConfig) (*power.Monitor, power.InFlightAction, error) {
+	action, err := power.ParseInFlightAction(cfg.InFlight)
+	if err != nil {
+		return nil, "", err
+	}
+	taskTypes := make([]models.TaskType, len(cfg.BatteryTaskTypes))
+	for i, taskType := range cfg.BatteryTaskTypes {
+		taskTypes[i] = models.TaskType(taskType)
+	}
+	policy := power.Policy{
+		BatteryThreshold:   cfg.BatteryThreshold,
+		BatteryTaskTypes:   taskTypes,
+		BatteryMaxDuration: cfg.BatteryMaxDuration,
+		PauseBelow:         cfg.PauseBelow,
+		PauseWhenThrottled: cfg.OnThrottle == "pause",
+		MaxTemperature:     cfg.MaxTemperature,
+	}
+	return power.NewMonitor(power.NewProbe(), policy), action, nil
+}
@@ -0,0 +1,195 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/core/ports"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/power"
+)
+
+// fakePowerProbe reports whatever state the test last set
+type fakePowerProbe struct {
+	mu    sync.Mutex
+	state models.PowerState
+}
+
+func (p *fakePowerProbe) set(state models.PowerState) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state = state
+}
+
+func (p *fakePowerProbe) Sample(ctx context.Context) (models.PowerState, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state, nil
+}
+
+var (
+	onMains      = models.PowerState{}
+	lowBattery   = models.PowerState{HasBattery: true, OnBattery: true, BatteryPercent: 45}
+	emptyBattery = models.PowerState{HasBattery: true, OnBattery: true, BatteryPercent: 12}
+	throttled    = models.PowerState{Throttled: true, ThrottleReasons: []string{"cpu thermal throttling"}}
+)
+
+func newPowerHandler(executor ports.TaskExecutor, action power.InFlightAction) (*DefaultTaskHandler, *fakePowerProbe, *fakeFLClient) {
+	client := &fakeFLClient{}
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+
+	probe := &fakePowerProbe{}
+	h.SetPowerMonitor(power.NewMonitor(probe, power.Policy{
+		BatteryThreshold:   60,
+		BatteryTaskTypes:   []models.TaskType{models.TaskTypeCommand},
+		BatteryMaxDuration: 5 * time.Minute,
+		PauseBelow:         20,
+		PauseWhenThrottled: true,
+	}), action)
+	return h, probe, client
+}
+
+func newCommandTask(timeoutSeconds int) *models.Task {
+	config := []byte(`{"command": "echo hi"}`)
+	if timeoutSeconds > 0 {
+		config = []byte(fmt.Sprintf(`{"command": "echo hi", "timeout_seconds": %d}`, timeoutSeconds))
+	}
+	return &models.Task{ID: uuid.New(), Type: models.TaskTypeCommand, Nonce: "abcdef", Config: config}
+}
+
+func TestPowerStateGatesClaiming(t *testing.T) {
+	h, probe, _ := newPowerHandler(&countingExecutor{}, power.InFlightContinue)
+
+	steps := []struct {
+		name  string
+		state models.PowerState
+		task  func() *models.Task
+		admit bool
+	}{
+		{"docker on mains", onMains, func() *models.Task { return newDockerTask(t) }, true},
+		{"docker on low battery", lowBattery, func() *models.Task { return newDockerTask(t) }, false},
+		{"short command on low battery", lowBattery, func() *models.Task { return newCommandTask(120) }, true},
+		{"command at the default timeout on low battery", lowBattery, func() *models.Task { return newCommandTask(0) }, true},
+		{"long command on low battery", lowBattery, func() *models.Task { return newCommandTask(1800) }, false},
+		{"short command on an empty battery", emptyBattery, func() *models.Task { return newCommandTask(120) }, false},
+		{"short command while throttled", throttled, func() *models.Task { return newCommandTask(120) }, false},
+		{"docker once cooled down on mains", onMains, func() *models.Task { return newDockerTask(t) }, true},
+	}
+	for _, step := range steps {
+		probe.set(step.state)
+		h.checkPower(context.Background())
+
+		err := h.HandleTask(step.task())
+		var admission *admissionError
+		declined := errors.As(err, &admission) && admission.reason == models.FLDeclinePowerConstrained
+		if step.admit && declined {
+			t.Fatalf("%s: HandleTask() error = %v, want the task admitted", step.name, err)
+		}
+		if !step.admit && !declined {
+			t.Fatalf("%s: HandleTask() error = %v, want power_constrained", step.name, err)
+		}
+	}
+}
+
+// suspendableExecutor runs each task until released or its context ends and
+// records suspensions
+type suspendableExecutor struct {
+	canSuspend bool
+	started    chan struct{}
+	release    chan struct{}
+
+	mu        sync.Mutex
+	suspended int
+	resumed   int
+}
+
+func (e *suspendableExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	e.started <- struct{}{}
+	select {
+	case <-e.release:
+		return &models.TaskResult{TaskID: task.ID}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (e *suspendableExecutor) SuspendTask(ctx context.Context, task *models.Task) error {
+	if !e.canSuspend {
+		return errors.New("cannot suspend")
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.suspended++
+	return nil
+}
+
+func (e *suspendableExecutor) ResumeSuspendedTask(ctx context.Context, task *models.Task) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.resumed++
+	return nil
+}
+
+func TestPowerInFlightActions(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     power.InFlightAction
+		canSuspend bool
+		suspended  int
+		requeued   bool
+	}{
+		{"continue lets the task finish", power.InFlightContinue, true, 0, false},
+		{"checkpoint suspends until conditions recover", power.InFlightCheckpoint, true, 1, false},
+		{"checkpoint abandons tasks it cannot suspend", power.InFlightCheckpoint, false, 0, true},
+		{"abandon requeues the task", power.InFlightAbandon, true, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := &suspendableExecutor{canSuspend: tt.canSuspend, started: make(chan struct{}, 1), release: make(chan struct{})}
+			h, probe, client := newPowerHandler(executor, tt.action)
+			h.checkPower(context.Background())
+
+			done := make(chan error, 1)
+			go func() { done <- h.HandleTask(newDockerTask(t)) }()
+			<-executor.started
+
+			probe.set(throttled)
+			h.checkPower(context.Background())
+
+			probe.set(onMains)
+			h.checkPower(context.Background())
+
+			executor.mu.Lock()
+			suspended, resumed := executor.suspended, executor.resumed
+			executor.mu.Unlock()
+			if suspended != tt.suspended || resumed != tt.suspended {
+				t.Fatalf("suspended %d and resumed %d times, want %d", suspended, resumed, tt.suspended)
+			}
+
+			if !tt.requeued {
+				close(executor.release)
+				if err := <-done; err != nil {
+					t.Fatalf("HandleTask() error = %v, want the task to finish", err)
+				}
+				return
+			}
+
+			if err := <-done; !errors.Is(err, power.ErrConstrained) {
+				t.Fatalf("HandleTask() error = %v, want ErrConstrained", err)
+			}
+			client.mu.Lock()
+			defer client.mu.Unlock()
+			if last := client.statuses[len(client.statuses)-1]; last != models.TaskStatusPending {
+				t.Fatalf("last status %s, want the task returned to pending", last)
+			}
+		})
+	}
+}
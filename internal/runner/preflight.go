@@ -0,0 +1,70 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+)
+
+// preflightTimeout bounds the pre-claim check, including any image pull
+const preflightTimeout = 15 * time.Minute
+
+// TaskPreflighter is implemented by executors that can check a task will
+// run before it is claimed
+type TaskPreflighter interface {
+	PreflightTask(ctx context.Context, task *models.Task) error
+}
+
+// SetPreflightMode sets whether docker tasks are checked before they are
+// claimed and whether a failed check abandons them
+func (h *DefaultTaskHandler) SetPreflightMode(mode docker.PreflightMode) {
+	h.preflight.Store(mode)
+}
+
+// preflightTask checks that a docker task's command can run in its image.
+// It returns an admission error only in abandon mode and only for a
+// mismatch certain to fail the task; anything else is logged and the task
+// runs, reporting its own failure if it has one.
+func (h *DefaultTaskHandler) preflightTask(task *models.Task) *admissionError {
+	mode, _ := h.preflight.Load().(docker.PreflightMode)
+	if mode == "" || mode == docker.PreflightOff || task.Type != models.TaskTypeDocker || h.force {
+		return nil
+	}
+	preflighter, ok := h.executor.(TaskPreflighter)
+	if !ok {
+		return nil
+	}
+
+	log := gologger.WithComponent("task_handler")
+
+	ctx, cancel := context.WithTimeout(context.Background(), preflightTimeout)
+	defer cancel()
+
+	err := preflighter.PreflightTask(ctx, task)
+	if err == nil {
+		return nil
+	}
+
+	var mismatch *docker.PreflightError
+	if !errors.As(err, &mismatch) {
+		log.Debug().Err(err).Str("id", task.ID.String()).Msg("Task preflight check could not complete")
+		return nil
+	}
+
+	for _, m := range mismatch.Mismatches {
+		log.Warn().
+			Str("id", task.ID.String()).
+			Str("image", mismatch.Image).
+			Bool("fatal", m.Fatal).
+			Msg("Task command mismatch - " + m.Reason)
+	}
+	if mode == docker.PreflightAbandon && mismatch.Fatal() {
+		return &admissionError{reason: models.FLDeclineIncompatibleImage, err: mismatch}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+type preflightingExecutor struct {
+	countingExecutor
+	err error
+}
+
+func (e *preflightingExecutor) PreflightTask(ctx context.Context, task *models.Task) error {
+	return e.err
+}
+
+func TestPreflightMismatchHandling(t *testing.T) {
+	missing := &docker.PreflightError{Image: "alpine", Mismatches: []docker.Mismatch{
+		{Reason: `executable "pyhton" not found in the image's PATH (/usr/bin)`, Fatal: true},
+	}}
+	advisory := &docker.PreflightError{Image: "alpine", Mismatches: []docker.Mismatch{
+		{Reason: "working directory /workspace does not exist in the image"},
+	}}
+
+	tests := []struct {
+		name    string
+		mode    docker.PreflightMode
+		err     error
+		abandon bool
+	}{
+		{"abandon skips a fatal mismatch", docker.PreflightAbandon, missing, true},
+		{"abandon runs despite an advisory mismatch", docker.PreflightAbandon, advisory, false},
+		{"warn runs despite a fatal mismatch", docker.PreflightWarn, missing, false},
+		{"off never checks", docker.PreflightOff, missing, false},
+		{"inspection failures do not abandon", docker.PreflightAbandon, errors.New("docker unavailable"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &attestingClient{}
+			executor := &preflightingExecutor{err: tt.err}
+			h := NewTaskHandler(executor, client)
+			h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+			h.SetPreflightMode(tt.mode)
+
+			err := h.HandleTask(newDockerTask(t))
+			if !tt.abandon {
+				if err != nil || executor.calls != 1 {
+					t.Fatalf("HandleTask() error = %v after %d executions, want the task run", err, executor.calls)
+				}
+				return
+			}
+
+			var admission *admissionError
+			if !errors.As(err, &admission) || admission.reason != models.FLDeclineIncompatibleImage {
+				t.Fatalf("HandleTask() error = %v, want incompatible_image", err)
+			}
+			if !strings.Contains(err.Error(), `"pyhton" not found`) {
+				t.Fatalf("abandon reason %q does not name the mismatch", err)
+			}
+			if executor.calls != 0 || len(client.statuses) != 0 {
+				t.Fatalf("abandoned task was claimed or executed: calls=%d statuses=%v", executor.calls, client.statuses)
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/execution/outputs"
+)
+
+// artifactPreviews returns the bounds task artifacts are previewed within,
+// or nil when they are not described
+func artifactPreviews(cfg config.ArtifactPreviewConfig) (*outputs.PreviewOptions, error) {
+	switch cfg.Mode {
+	case "off":
+		return nil, nil
+	case "on":
+	default:
+		return nil, fmt.Errorf("unknown artifact preview mode %q", cfg.Mode)
+	}
+	if cfg.MaxKB < 0 || cfg.Lines < 0 || cfg.ThumbnailPx < 0 || cfg.MaxArtifacts < 0 {
+		return nil, fmt.Errorf("artifact preview bounds must not be negative")
+	}
+	return &outputs.PreviewOptions{
+		MaxBytes:      cfg.MaxKB << 10,
+		Lines:         cfg.Lines,
+		ThumbnailSize: cfg.ThumbnailPx,
+		MaxArtifacts:  cfg.MaxArtifacts,
+	}, nil
+}
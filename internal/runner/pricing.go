@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/pricing"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+	"github.com/theblitlabs/parity-runner/internal/status"
+)
+
+// SetPricing skips tasks paying less than gate's floor for them
+func (h *DefaultTaskHandler) SetPricing(gate *pricing.Gate) {
+	h.pricing = gate
+}
+
+// admitPricing declines tasks paying below the floor of the resources they
+// hold for as long as they are expected to run
+func (h *DefaultTaskHandler) admitPricing(task *models.Task) *admissionError {
+	// Donated rounds earn nothing by design
+	if h.pricing == nil || h.force || h.donated(task) {
+		return nil
+	}
+	var config struct {
+		Resources models.ResourceConfig `json:"resources"`
+	}
+	if len(task.Config) > 0 {
+		if err := safejson.Unmarshal(task.Config, &config); err != nil {
+			// Invalid configs are declined by the schema check
+			return nil
+		}
+	}
+	usage, err := pricing.UsageOf(config.Resources)
+	if err != nil {
+		return &admissionError{reason: models.FLDeclineInvalidConfig, err: err}
+	}
+	if usage.GPUs == 0 && h.usesGPU(task) {
+		usage.GPUs = 1
+	}
+	if err := h.pricing.Admit(task.Reward, usage, h.expectedRuntime(task)); err != nil {
+		return &admissionError{reason: models.FLDeclineBelowPriceFloor, err: err}
+	}
+	return nil
+}
+
+// expectedRuntime returns how long task is expected to run: as long as its
+// workload usually does, or else the longest it may run
+func (h *DefaultTaskHandler) expectedRuntime(task *models.Task) time.Duration {
+	if typical, ok := h.timeouts.Typical(task); ok {
+		return typical
+	}
+	return h.runtimeBound(task)
+}
+
+// pricingRates returns the rates cfg sets
+func pricingRates(cfg config.PricingConfig) models.PricingRates {
+	return models.PricingRates{CPUHour: cfg.CPUHour, GPUHour: cfg.GPUHour, GBRAMHour: cfg.GBRAMHour}
+}
+
+// SetPricing replaces the rates tasks are priced at, taking effect for the
+// next task offered
+func (s *Service) SetPricing(rates models.PricingRates) error {
+	if err := s.pricing.SetRates(rates); err != nil {
+		return fmt.Errorf("%w: %v", status.ErrInvalidPricing, err)
+	}
+	log := gologger.WithComponent("runner")
+	log.Info().
+		Float64("cpu_hour", rates.CPUHour).
+		Float64("gpu_hour", rates.GPUHour).
+		Float64("gb_ram_hour", rates.GBRAMHour).
+		Msg("Operator changed pricing")
+	return nil
+}
@@ -0,0 +1,35 @@
+package runner
+
+import (
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/pricing"
+)
+
+func TestHandlerSkipsTasksBelowPriceFloor(t *testing.T) {
+	gate, err := pricing.NewGate(models.PricingRates{CPUHour: 3600})
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor := &countingExecutor{}
+	h := NewTaskHandler(executor, &fakeFLClient{})
+	h.SetPricing(gate)
+
+	// A command task runs for up to its timeout, one second here, on one core
+	cheap := newCommandTask(1)
+	cheap.Reward = 0.5
+	admission := h.admitTask(cheap)
+	if admission == nil || admission.reason != models.FLDeclineBelowPriceFloor {
+		t.Fatalf("admitTask() = %v, want declined below the price floor", admission)
+	}
+	if got := gate.Status(); got.Skipped != 1 || got.SkippedReward != 0.5 || got.SkippedFloor != 1 {
+		t.Fatalf("pricing status = %+v, want the task counted as skipped", got)
+	}
+
+	paying := newCommandTask(1)
+	paying.Reward = 1
+	if admission := h.admitTask(paying); admission != nil && admission.reason == models.FLDeclineBelowPriceFloor {
+		t.Fatalf("admitTask() = %v for a task paying its floor", admission)
+	}
+}
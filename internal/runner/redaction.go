@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"io"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/redact"
+)
+
+// SetRedactor redacts the results and streamed output of tasks with r
+func (h *DefaultTaskHandler) SetRedactor(r *redact.Redactor) {
+	h.redactor = r
+}
+
+// redactResult redacts the result of task and reports what was redacted
+// in it
+func (h *DefaultTaskHandler) redactResult(task *models.Task, result *models.TaskResult) {
+	if h.redactor != nil {
+		h.redactor.Result(task.ID.String(), result)
+	}
+}
+
+// forgetRedactions drops what was redacted for task unless its result
+// took it
+func (h *DefaultTaskHandler) forgetRedactions(task *models.Task) {
+	if h.redactor != nil {
+		h.redactor.Forget(task.ID.String())
+	}
+}
+
+// redactOutput returns a writer redacting what is copied to out, and a
+// func passing on what it holds back once the task is done
+func (h *DefaultTaskHandler) redactOutput(out io.Writer) (io.Writer, func()) {
+	if h.redactor == nil {
+		return out, func() {}
+	}
+	w := h.redactor.NewWriter(out)
+	return w, func() { _ = w.Close() }
+}
+
+// newRedactor returns the redactor the redaction config describes, or nil
+// when redaction is disabled
+func newRedactor(cfg config.RedactionConfig) (*redact.Redactor, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	var rules []redact.Rule
+	if cfg.RulesFile != "" {
+		var err error
+		if rules, err = redact.LoadRules(cfg.RulesFile); err != nil {
+			return nil, err
+		}
+	}
+	r, err := redact.New(cfg.Detectors, rules)
+	if err != nil {
+		return nil, err
+	}
+	r.SetWindow(cfg.Window)
+	return r, nil
+}
@@ -0,0 +1,61 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/tasklog"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+// leakyExecutor prints an address and an email to the live task log and
+// its output
+type leakyExecutor struct{}
+
+func (leakyExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	if w, ok := tasklog.Output(ctx); ok {
+		w.Write([]byte("connecting to 203.0.113.7\n"))
+	}
+	return &models.TaskResult{TaskID: task.ID, Output: "sent report to ops@example.com from 203.0.113.7"}, nil
+}
+
+func TestTaskResultAndOutputAreRedacted(t *testing.T) {
+	task := newPendingTask(t, models.ResourceConfig{})
+	server, client := newMockServer(t, task)
+	h := NewTaskHandler(leakyExecutor{}, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	var out bytes.Buffer
+	h.SetLogOutput(&out)
+	redactor, err := newRedactor(config.RedactionConfig{Enabled: true, Detectors: []string{"email", "ipv4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetRedactor(redactor)
+
+	if _, _, err := h.RunTaskByID(task.ID.String()); err != nil {
+		t.Fatalf("RunTaskByID() error = %v", err)
+	}
+	if out.String() != "connecting to <ipv4>\n" {
+		t.Errorf("live output = %q", out.String())
+	}
+	result := server.result
+	if result == nil || result.Output != "sent report to <email> from <ipv4>" {
+		t.Fatalf("submitted result = %+v", result)
+	}
+	if r := result.Redaction; r == nil || r.Counts["email"] != 1 || r.Counts["ipv4"] != 1 {
+		t.Errorf("redaction report = %+v, want one email and one address", r)
+	}
+}
+
+func TestNewRedactorDisabled(t *testing.T) {
+	redactor, err := newRedactor(config.RedactionConfig{Detectors: []string{"phone"}})
+	if err != nil || redactor != nil {
+		t.Errorf("newRedactor() = %v, %v, want nil when disabled", redactor, err)
+	}
+	if _, err := newRedactor(config.RedactionConfig{Enabled: true, RulesFile: t.TempDir() + "/missing.json"}); err == nil {
+		t.Error("newRedactor() accepted a missing rules file")
+	}
+}
@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/theblitlabs/parity-runner/internal/blobcache"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/registrymirror"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+)
+
+// blobCacheDirName holds the image tarballs tasks downloaded, by digest
+const blobCacheDirName = "caches/image_blobs"
+
+// newRegistryMirrors returns the pool of the mirrors cfg maps registries
+// to, nil without any
+func newRegistryMirrors(cfg config.RegistryConfig) (*registrymirror.Pool, error) {
+	if len(cfg.Mirrors) == 0 {
+		return nil, nil
+	}
+	mirrors, err := registrymirror.ParseMirrors(cfg.Mirrors)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure registry mirrors: %w", err)
+	}
+	return registrymirror.NewPool(mirrors), nil
+}
+
+// newBlobFetcher returns the fetcher of image tarballs into store from the
+// peers cfg names, serving them to its members and s
+func newBlobFetcher(cfg config.RegistryConfig, store *blobcache.Store, s signer.Signer) (*blobcache.Fetcher, error) {
+	members := make([]common.Address, 0, len(cfg.Members))
+	for _, member := range cfg.Members {
+		if !common.IsHexAddress(member) {
+			return nil, fmt.Errorf("invalid fleet member address %q", member)
+		}
+		members = append(members, common.HexToAddress(member))
+	}
+	return blobcache.NewFetcher(store, s, cfg.BlobPeers, members), nil
+}
@@ -0,0 +1,415 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/theblitlabs/gologger"
+	"github.com/theblitlabs/keystore"
+
+	"github.com/theblitlabs/parity-runner/internal/accounting"
+	"github.com/theblitlabs/parity-runner/internal/apiclient"
+	"github.com/theblitlabs/parity-runner/internal/atrest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/relocation"
+	"github.com/theblitlabs/parity-runner/internal/utils"
+)
+
+// ErrIdentityConflict is returned when importing a runner onto a machine
+// where another runner identity is active
+var ErrIdentityConflict = errors.New("a different runner identity is active on this machine")
+
+// RunnerRebinder is implemented by task clients that can bind the runner's
+// identity to the machine it was migrated to
+type RunnerRebinder interface {
+	RebindRunner(rebind *models.RunnerRebind) (*models.RunnerRebindAck, error)
+}
+
+// ExportOptions selects what an export carries besides the runner's
+// identity, history, ledger and outbox
+type ExportOptions struct {
+	// IncludeKeys adds the wallet keystore; otherwise only the wallet's
+	// address is exported and the new machine authenticates anew
+	IncludeKeys bool
+	// IncludeCaches adds the cache indexes, which fsck prunes of entries
+	// whose content did not move
+	IncludeCaches bool
+}
+
+// relocator moves the runner's state under dir in and out of migration
+// archives
+type relocator struct {
+	dir     string
+	keyring *atrest.Keyring
+	codec   atrest.Codec
+	// deviceID returns the device ID the runner runs as on this machine
+	deviceID func() (string, error)
+	// machine identifies this machine
+	machine func() (string, error)
+	// wallet returns the address of the keystore under dir, empty when
+	// there is none
+	wallet func(dir string) (string, error)
+	now    func() time.Time
+}
+
+// newRelocator returns the relocator of the runner's data directory. The
+// data keys are only unlocked where they exist, so that neither direction
+// creates them.
+func newRelocator() (*relocator, error) {
+	dir, err := parityDir()
+	if err != nil {
+		return nil, err
+	}
+	r := &relocator{
+		dir:      dir,
+		deviceID: utils.GetDeviceID,
+		machine:  relocation.MachineID,
+		wallet:   keystoreAddress,
+		now:      time.Now,
+	}
+	if _, err := os.Stat(filepath.Join(dir, dataKeyFileName)); err == nil {
+		keyring, err := openDataKeyring(dir)
+		if err != nil {
+			return nil, err
+		}
+		r.keyring = keyring
+		r.codec = keyringCodec(keyring)
+	}
+	return r, nil
+}
+
+// keystoreAddress returns the wallet address of the keystore under dir
+func keystoreAddress(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, utils.KeystoreFileName)); errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	ks, err := keystore.NewKeystore(keystore.Config{DirPath: dir, FileName: utils.KeystoreFileName})
+	if err != nil {
+		return "", fmt.Errorf("failed to open keystore: %w", err)
+	}
+	key, err := ks.LoadPrivateKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to load wallet key: %w", err)
+	}
+	return crypto.PubkeyToAddress(key.PublicKey).Hex(), nil
+}
+
+// ExportState writes the runner's identity and state to w as an archive
+// encrypted with passphrase. The runner must be stopped while exporting.
+func ExportState(w io.Writer, passphrase []byte, opts ExportOptions) (*relocation.Manifest, error) {
+	r, err := newRelocator()
+	if err != nil {
+		return nil, err
+	}
+	return r.export(w, passphrase, opts)
+}
+
+// ImportState restores the runner exported to the archive read from r onto
+// this machine once the server at serverURL bound its identity here, after
+// which it refuses the machine the archive was exported from. Importing onto
+// a machine where another identity is active fails with ErrIdentityConflict.
+func ImportState(r io.Reader, passphrase []byte, serverURL string) (*relocation.Manifest, error) {
+	rel, err := newRelocator()
+	if err != nil {
+		return nil, err
+	}
+	archive, err := relocation.Open(r, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	// The server only knows the identity being moved, not the one derived
+	// for this machine
+	client := NewHTTPTaskClient(serverURL, apiclient.DefaultRetryPolicy)
+	client.SetDeviceID(archive.Manifest.DeviceID)
+	return rel.importArchive(archive, client)
+}
+
+func (r *relocator) export(w io.Writer, passphrase []byte, opts ExportOptions) (*relocation.Manifest, error) {
+	deviceID, err := r.deviceID()
+	if err != nil {
+		return nil, err
+	}
+	machine, err := r.machine()
+	if err != nil {
+		return nil, err
+	}
+	wallet, err := r.wallet(r.dir)
+	if err != nil {
+		return nil, err
+	}
+	archiveID, err := relocation.NewArchiveID()
+	if err != nil {
+		return nil, err
+	}
+	archive := &relocation.Archive{
+		Manifest: relocation.Manifest{
+			Version:       relocation.Version,
+			ArchiveID:     archiveID,
+			DeviceID:      deviceID,
+			WalletAddress: wallet,
+			Machine:       machine,
+			ExportedAt:    r.now().UTC(),
+			Keys:          opts.IncludeKeys,
+			Caches:        opts.IncludeCaches,
+		},
+		Files: make(map[string][]byte),
+	}
+
+	if err := r.addFile(archive, path.Join(auditDirName, auditKeyFileName), nil, false); err != nil {
+		return nil, err
+	}
+	if opts.IncludeKeys {
+		if wallet == "" {
+			return nil, fmt.Errorf("no keystore in %s to include", r.dir)
+		}
+		if err := r.addFile(archive, utils.KeystoreFileName, nil, true); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.addHistory(archive); err != nil {
+		return nil, err
+	}
+	if err := r.addAccounting(archive); err != nil {
+		return nil, err
+	}
+	if err := r.addTree(archive, outboxDirName, r.codec); err != nil {
+		return nil, err
+	}
+	if opts.IncludeCaches {
+		if err := r.addFile(archive, cacheStateFileName, nil, false); err != nil {
+			return nil, err
+		}
+		if err := r.addFile(archive, artifactCacheFileName, r.codec, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := relocation.Seal(w, archive, passphrase); err != nil {
+		return nil, err
+	}
+	return &archive.Manifest, nil
+}
+
+// addFile adds the file at the slash-separated name under the data
+// directory, decrypted with codec. Missing files are skipped unless
+// required.
+func (r *relocator) addFile(archive *relocation.Archive, name string, codec atrest.Codec, required bool) error {
+	data, err := atrest.ReadFile(codec, filepath.Join(r.dir, filepath.FromSlash(name)))
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to export %s: %w", name, err)
+	}
+	archive.Files[name] = data
+	return nil
+}
+
+// addTree adds the files under the directory root, skipping the leftovers
+// of interrupted writes and the files named skip
+func (r *relocator) addTree(archive *relocation.Archive, root string, codec atrest.Codec, skip ...string) error {
+	base := filepath.Join(r.dir, root)
+	err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || strings.HasSuffix(p, ".tmp") || slices.Contains(skip, d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(r.dir, p)
+		if err != nil {
+			return err
+		}
+		return r.addFile(archive, filepath.ToSlash(rel), codec, true)
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// addHistory adds the task history as plaintext records, which the new
+// machine seals under its own data key
+func (r *relocator) addHistory(archive *relocation.Archive) error {
+	store, err := history.NewStore(filepath.Join(r.dir, historyFileName))
+	if err != nil {
+		return err
+	}
+	store.SetCodec(r.codec)
+	records, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("failed to export task history: %w", err)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return err
+		}
+	}
+	archive.Files[historyFileName] = buf.Bytes()
+	return nil
+}
+
+// addAccounting adds the accounting records and ledgers as plaintext, which
+// the new machine seals under its own data key
+func (r *relocator) addAccounting(archive *relocation.Archive) error {
+	if err := r.addTree(archive, accountingDirName, r.codec, accounting.LedgerFileName); err != nil {
+		return err
+	}
+	base := filepath.Join(r.dir, accountingDirName)
+	err := filepath.WalkDir(base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.Name() != accounting.LedgerFileName || !d.Type().IsRegular() {
+			return err
+		}
+		entries, err := accounting.ReadLedger(p, r.codec)
+		if err != nil {
+			return fmt.Errorf("failed to export task ledger: %w", err)
+		}
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, entry := range entries {
+			if err := enc.Encode(entry); err != nil {
+				return err
+			}
+		}
+		rel, err := filepath.Rel(r.dir, p)
+		if err != nil {
+			return err
+		}
+		archive.Files[filepath.ToSlash(rel)] = buf.Bytes()
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (r *relocator) importArchive(archive *relocation.Archive, client RunnerRebinder) (*relocation.Manifest, error) {
+	log := gologger.WithComponent("runner")
+
+	manifest := &archive.Manifest
+	if err := r.checkConflict(manifest); err != nil {
+		return nil, err
+	}
+	machine, err := r.machine()
+	if err != nil {
+		return nil, err
+	}
+
+	// Nothing is written until the server moved the identity here, so that
+	// a refused import leaves this machine as it was
+	ack, err := client.RebindRunner(&models.RunnerRebind{
+		DeviceID:    manifest.DeviceID,
+		FromMachine: manifest.Machine,
+		ToMachine:   machine,
+		ArchiveID:   manifest.ArchiveID,
+		ExportedAt:  manifest.ExportedAt,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind device %s to this machine: %w", manifest.DeviceID, err)
+	}
+
+	if err := os.MkdirAll(r.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create runner directory: %w", err)
+	}
+	// The device ID is pinned first: a re-run of an import cut short then
+	// finds its own identity active rather than a conflicting one
+	if err := atrest.WriteFileAtomic(filepath.Join(r.dir, utils.DeviceIDFileName), []byte(manifest.DeviceID+"\n"), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to pin device ID: %w", err)
+	}
+	// The imported stores are plaintext until the runner next starts and
+	// seals them
+	if r.keyring != nil {
+		if err := r.keyring.SetMigrated(false); err != nil {
+			return nil, err
+		}
+	}
+	for name, data := range archive.Files {
+		target := filepath.Join(r.dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to import %s: %w", name, err)
+		}
+		if err := atrest.WriteFileAtomic(target, data, 0o600); err != nil {
+			return nil, fmt.Errorf("failed to import %s: %w", name, err)
+		}
+	}
+
+	log.Info().
+		Str("device_id", manifest.DeviceID).
+		Str("archive_id", manifest.ArchiveID).
+		Int64("generation", ack.Generation).
+		Int("files", len(archive.Files)).
+		Msg("Runner imported and bound to this machine")
+	return manifest, nil
+}
+
+// checkConflict refuses an import onto a machine where another identity
+// is active: one pinned here, the identity of the runner whose state is
+// here, or another wallet
+func (r *relocator) checkConflict(manifest *relocation.Manifest) error {
+	pinned, err := os.ReadFile(filepath.Join(r.dir, utils.DeviceIDFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read pinned device ID: %w", err)
+	}
+	if active := strings.TrimSpace(string(pinned)); active != "" {
+		if active != manifest.DeviceID {
+			return fmt.Errorf("%w: device %s is pinned here", ErrIdentityConflict, active)
+		}
+	} else if r.hasState() {
+		active, err := r.deviceID()
+		if err != nil {
+			return err
+		}
+		if active != manifest.DeviceID {
+			return fmt.Errorf("%w: device %s has task history or results here", ErrIdentityConflict, active)
+		}
+	}
+
+	wallet, err := r.wallet(r.dir)
+	if err != nil {
+		return err
+	}
+	if wallet != "" && manifest.WalletAddress != "" && !strings.EqualFold(wallet, manifest.WalletAddress) {
+		return fmt.Errorf("%w: wallet %s is configured here", ErrIdentityConflict, wallet)
+	}
+	return nil
+}
+
+// hasState reports whether a runner ran here: it has task history, ledger
+// entries or results waiting to be reported
+func (r *relocator) hasState() bool {
+	if info, err := os.Stat(filepath.Join(r.dir, historyFileName)); err == nil && info.Size() > 0 {
+		return true
+	}
+	for _, root := range []string{accountingDirName, outboxDirName} {
+		found := false
+		filepath.WalkDir(filepath.Join(r.dir, root), func(p string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				found = true
+				return filepath.SkipAll
+			}
+			return nil
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
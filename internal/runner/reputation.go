@@ -0,0 +1,219 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/reputation"
+	"github.com/theblitlabs/parity-runner/internal/status"
+)
+
+// reputationCacheTTL bounds how stale the outcomes read from the history
+// may be, so polling the status does not read the history each time
+const reputationCacheTTL = time.Minute
+
+type runnerInfoFetcher interface {
+	GetRunnerInfo() (*models.RunnerInfo, error)
+}
+
+// reputationTracker scores the runner with the server's reputation formula
+// from its history, and reconciles its score with the one the server
+// reports
+type reputationTracker struct {
+	history   *history.Store
+	instance  string
+	policy    reputation.Policy
+	fetcher   runnerInfoFetcher
+	interval  time.Duration
+	tolerance float64
+	clock     clock.Clock
+
+	mu       sync.Mutex
+	outcomes []reputation.Outcome
+	loadedAt time.Time
+	server   *models.ServerReputation
+	// unsupported is set once the server refused to report the runner's
+	// info, after which it is not asked again
+	unsupported bool
+}
+
+func newReputationTracker(store *history.Store, instance string, fetcher runnerInfoFetcher, interval time.Duration, tolerance float64, clk clock.Clock) *reputationTracker {
+	return &reputationTracker{
+		history:   store,
+		instance:  instance,
+		policy:    reputation.DefaultPolicy,
+		fetcher:   fetcher,
+		interval:  interval,
+		tolerance: tolerance,
+		clock:     clk,
+	}
+}
+
+// load returns the runner's outcomes, read again from the history once the
+// ones read last are older than reputationCacheTTL
+func (r *reputationTracker) load(now time.Time) ([]reputation.Outcome, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.loadedAt.IsZero() && now.Sub(r.loadedAt) < reputationCacheTTL {
+		return r.outcomes, nil
+	}
+	records, err := r.history.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task history: %w", err)
+	}
+	r.outcomes = reputation.FromHistory(records, r.instance)
+	r.loadedAt = now
+	return r.outcomes, nil
+}
+
+// Report scores the runner now, with the server's score last fetched. A
+// nil tracker, for a runner without history, reports nothing.
+func (r *reputationTracker) Report() (*models.ReputationReport, error) {
+	if r == nil {
+		return nil, nil
+	}
+	now := r.clock.Now()
+	outcomes, err := r.load(now)
+	if err != nil {
+		return nil, err
+	}
+	report := reputation.Score(outcomes, now, r.policy)
+	r.mu.Lock()
+	if r.server != nil {
+		server := *r.server
+		report.Server = &server
+	}
+	r.mu.Unlock()
+	return report, nil
+}
+
+// WhatIf scores each way task could end against the runner's score now
+func (r *reputationTracker) WhatIf(task models.RunningTask) (*models.ReputationWhatIf, error) {
+	now := r.clock.Now()
+	outcomes, err := r.load(now)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ReputationWhatIf{
+		TaskID:    task.TaskID,
+		Current:   reputation.Score(outcomes, now, r.policy).Score,
+		Scenarios: reputation.Simulate(outcomes, now, r.policy, reputation.TaskScenarios(task, now)),
+	}, nil
+}
+
+// Run reconciles the runner's score with the server's now and then every
+// interval until ctx is done
+func (r *reputationTracker) Run(ctx context.Context) {
+	if r.fetcher == nil {
+		return
+	}
+	r.reconcile()
+	if r.interval <= 0 {
+		return
+	}
+	ticker := r.clock.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+			r.mu.Lock()
+			unsupported := r.unsupported
+			r.mu.Unlock()
+			if unsupported {
+				return
+			}
+			r.reconcile()
+		}
+	}
+}
+
+// reconcile fetches the server's score and warns when the runner's own
+// diverges from it
+func (r *reputationTracker) reconcile() {
+	log := gologger.WithComponent("reputation")
+	info, err := r.fetcher.GetRunnerInfo()
+	if err != nil {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if errors.Is(err, ErrRunnerInfoUnsupported) {
+			r.unsupported = true
+			log.Info().Msg("Server does not report runner reputation - not reconciling")
+			return
+		}
+		if r.server != nil {
+			r.server.Error = err.Error()
+		}
+		log.Debug().Err(err).Msg("Failed to fetch runner reputation")
+		return
+	}
+
+	// Compare with the score as the history reads now, not as cached
+	r.mu.Lock()
+	r.loadedAt = time.Time{}
+	r.mu.Unlock()
+	report, err := r.Report()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to compute runner reputation")
+		return
+	}
+	server := reputation.Reconcile(report, info, r.clock.Now(), r.tolerance)
+	if server == nil {
+		return
+	}
+	r.mu.Lock()
+	r.server = server
+	r.mu.Unlock()
+	if server.Diverged {
+		log.Warn().
+			Float64("local", report.Score).
+			Float64("server", server.Score).
+			Float64("divergence", server.Divergence).
+			Int("local_formula", report.FormulaVersion).
+			Int("server_formula", server.FormulaVersion).
+			Msg("Reputation score diverges from the server's")
+	}
+}
+
+// ReputationWhatIf scores each way the running task with taskID could end,
+// or returns status.ErrNotFound when the runner is not running it
+func (s *Service) ReputationWhatIf(ctx context.Context, taskID string) (*models.ReputationWhatIf, error) {
+	if s.reputation == nil {
+		return nil, fmt.Errorf("%w: task history is disabled", status.ErrNotFound)
+	}
+	for _, task := range s.status.snapshot().Tasks {
+		if task.TaskID == taskID {
+			return s.reputation.WhatIf(task)
+		}
+	}
+	return nil, fmt.Errorf("%w: task %s is not running", status.ErrNotFound, taskID)
+}
+
+// Reputation scores the runner from its history while the runner itself
+// is not running; the server's score is not fetched
+func Reputation(instance string) (*models.ReputationReport, error) {
+	dir, err := parityDir()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := openDataKeyring(dir)
+	if err != nil {
+		return nil, err
+	}
+	store, err := history.NewStore(filepath.Join(dir, historyFileName))
+	if err != nil {
+		return nil, err
+	}
+	store.SetCodec(keyringCodec(keyring))
+	return newReputationTracker(store, instance, nil, 0, 0, clock.Real()).Report()
+}
@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/history"
+)
+
+// fakeInfoFetcher reports score as the server's, or fails with err
+type fakeInfoFetcher struct {
+	score float64
+	err   error
+	calls int
+}
+
+func (f *fakeInfoFetcher) GetRunnerInfo() (*models.RunnerInfo, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &models.RunnerInfo{DeviceID: "device-1", ReputationScore: &f.score, ReputationFormulaVersion: 1}, nil
+}
+
+func newReputationHistory(t *testing.T, now time.Time) *history.Store {
+	t.Helper()
+	store, err := history.NewStore(filepath.Join(t.TempDir(), historyFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	passed := true
+	records := []history.Record{
+		{TaskID: "t1", Type: models.TaskTypeDocker, Status: models.TaskStatusCompleted, StartedAt: now.Add(-3 * time.Hour), DurationMs: 60000, Verified: &passed},
+		{TaskID: "t2", Type: models.TaskTypeDocker, Status: models.TaskStatusFailed, FailureCode: models.FailureContainerRuntime, StartedAt: now.Add(-2 * time.Hour), DurationMs: 1000},
+		{TaskID: "t3", Type: models.TaskTypeDocker, Status: models.TaskStatusCompleted, StartedAt: now.Add(-time.Hour), DurationMs: 60000},
+		{TaskID: "t4", Type: models.TaskTypeDocker, Status: models.TaskStatusCompleted, StartedAt: now.Add(-time.Hour), DurationMs: 60000, Instance: "other"},
+	}
+	for _, record := range records {
+		if err := store.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+func TestReputationTrackerReconciles(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clocktest.NewFake(now)
+	fetcher := &fakeInfoFetcher{score: 60}
+	tracker := newReputationTracker(newReputationHistory(t, now), "", fetcher, time.Minute, 5, clk)
+
+	report, err := tracker.Report()
+	if err != nil {
+		t.Fatalf("Report() error = %v", err)
+	}
+	if report.Executions != 3 || report.Score >= 100 || report.Server != nil {
+		t.Fatalf("report = %+v, want the three executions of this runner and no server score yet", report)
+	}
+
+	tracker.reconcile()
+	report, _ = tracker.Report()
+	if report.Server == nil || !report.Server.Diverged || report.Server.Divergence != report.Score-60 {
+		t.Fatalf("server = %+v, want the server's score flagged as diverging", report.Server)
+	}
+
+	// A failed fetch keeps the last score and says why it is stale
+	fetcher.err = errors.New("connection refused")
+	tracker.reconcile()
+	report, _ = tracker.Report()
+	if report.Server == nil || report.Server.Score != 60 || report.Server.Error == "" {
+		t.Errorf("server after a failed fetch = %+v, want the last score and the error", report.Server)
+	}
+
+	fetcher.err = ErrRunnerInfoUnsupported
+	tracker.reconcile()
+	if !tracker.unsupported {
+		t.Error("tracker keeps asking a server that does not report runner info")
+	}
+}
+
+func TestReputationWhatIfOfRunningTask(t *testing.T) {
+	now := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newReputationTracker(newReputationHistory(t, now), "", nil, 0, 5, clocktest.NewFake(now))
+
+	whatIf, err := tracker.WhatIf(models.RunningTask{
+		TaskID:    "stuck",
+		Type:      models.TaskTypeDocker,
+		StartedAt: now.Add(-10 * time.Minute),
+		Timeout:   time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("WhatIf() error = %v", err)
+	}
+	if len(whatIf.Scenarios) != 4 {
+		t.Fatalf("scenarios = %+v, want four", whatIf.Scenarios)
+	}
+	for _, scenario := range whatIf.Scenarios {
+		if math.Abs(scenario.Score-(whatIf.Current+scenario.Delta)) > 1e-9 {
+			t.Errorf("%s scores %f, want %f plus its delta %f", scenario.Name, scenario.Score, whatIf.Current, scenario.Delta)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+)
+
+const (
+	// maxFetchedOutput bounds an offloaded output fetched back whose size
+	// the server did not keep
+	maxFetchedOutput = 1 << 30
+	// outputHeaderTimeout bounds the wait for a gateway to start serving
+	// an offloaded output, and outputFetchTimeout the whole download
+	outputHeaderTimeout = 30 * time.Second
+	outputFetchTimeout  = 10 * time.Minute
+)
+
+// outputOffload moves outputs larger than threshold bytes out of the
+// results sent to the server, leaving their first preview bytes inline.
+// Escrowed outputs, and those of tasks whose outbox entry carries their own
+// retention, never leave the runner but for the server.
+type outputOffload struct {
+	uploader  artifacts.Uploader
+	threshold int
+	preview   int
+	outbox    *retention.Outbox
+}
+
+// SetOutputOffload has results whose output is larger than cfg's
+// threshold sent with the output uploaded to IPFS, keeping a preview of it
+// inline, unless cfg turns offloading off
+func (c *HTTPTaskClient) SetOutputOffload(cfg config.ResultOffloadConfig) error {
+	switch cfg.Mode {
+	case "off":
+		c.offload = nil
+		return nil
+	case "on":
+	default:
+		return fmt.Errorf("unknown result offload mode %q", cfg.Mode)
+	}
+	if cfg.ThresholdKB <= 0 || cfg.PreviewKB < 0 || cfg.PreviewKB > cfg.ThresholdKB {
+		return fmt.Errorf("result offload needs a positive threshold and a preview no larger than it")
+	}
+	c.offload = &outputOffload{
+		uploader:  artifacts.NewIPFSEndpoints(cfg.IPFSAPIURLs),
+		threshold: cfg.ThresholdKB << 10,
+		preview:   cfg.PreviewKB << 10,
+		outbox:    c.outbox,
+	}
+	return nil
+}
+
+// SetOutbox looks up the retention of the results saved in outbox, whose
+// outputs are then never offloaded
+func (c *HTTPTaskClient) SetOutbox(outbox *retention.Outbox) {
+	c.outbox = outbox
+	if c.offload != nil {
+		c.offload.outbox = outbox
+	}
+}
+
+// apply returns result as it is to be sent: result itself when its output
+// is small enough or must stay with the server, and otherwise a copy
+// holding the CID and a preview of the output, or the output cut short when
+// it could not be uploaded. The result's hash, and its attestation, still
+// cover the whole output.
+func (o *outputOffload) apply(ctx context.Context, taskID string, result *models.TaskResult) *models.TaskResult {
+	if len(result.Output) <= o.threshold || result.OutputCID != "" || result.Escrow != nil || o.retained(taskID) {
+		return result
+	}
+	sent := *result
+	sent.OutputSize = int64(len(result.Output))
+
+	log := gologger.WithComponent("task_client")
+	cid, err := o.uploader.Add(ctx, "output-"+taskID, strings.NewReader(result.Output))
+	if err != nil {
+		log.Warn().Err(err).
+			Str("task_id", taskID).
+			Int64("size", sent.OutputSize).
+			Msg("Failed to upload task output to IPFS - sending it truncated")
+		sent.Output = cutOutput(result.Output, o.threshold)
+		sent.OutputTruncated = true
+		return &sent
+	}
+	log.Info().Str("task_id", taskID).Str("cid", cid).Int64("size", sent.OutputSize).Msg("Uploaded task output to IPFS")
+	sent.OutputCID = cid
+	sent.Output = cutOutput(result.Output, o.preview)
+	return &sent
+}
+
+// retained reports whether taskID's result is kept under the task's own
+// retention, which content added to IPFS could not be deleted by
+func (o *outputOffload) retained(taskID string) bool {
+	if o.outbox == nil {
+		return false
+	}
+	entry, err := o.outbox.Get(taskID)
+	return err == nil && entry.Retention != ""
+}
+
+// outputFetchClient returns the client offloaded outputs are fetched from
+// gateways with
+func outputFetchClient() *http.Client {
+	transport := dualstack.Transport()
+	transport.ResponseHeaderTimeout = outputHeaderTimeout
+	return &http.Client{Transport: transport, Timeout: outputFetchTimeout}
+}
+
+// cutOutput returns at most n bytes of s, cut before a character rather
+// than inside one
+func cutOutput(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// FetchResultOutput returns the whole output of taskID's result: fetched
+// from the first of cfg's gateways serving content that matches its CID
+// when it was offloaded, and as the server holds it otherwise. truncated is set
+// when the output was cut short with nowhere to fetch the rest from.
+func FetchResultOutput(ctx context.Context, cfg *config.Config, taskID string) (output []byte, truncated bool, err error) {
+	client := NewHTTPTaskClient(cfg.Runner.ServerURL, apiRetryPolicy(cfg.Runner.APIRetry))
+	client.SetContext(ctx)
+	page, err := client.GetTaskResult(taskID, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	result := page.Result
+	if result == nil || result.OutputCID == "" {
+		return []byte(page.Output), result != nil && result.OutputTruncated, nil
+	}
+
+	limit := result.OutputSize
+	if limit <= 0 {
+		limit = maxFetchedOutput
+	}
+	output, err = artifacts.FetchVerified(ctx, outputFetchClient(), cfg.Runner.ResultOffload.Gateways, result.OutputCID, limit)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to fetch output %s: %w", result.OutputCID, err)
+	}
+	return output, false, nil
+}
@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+)
+
+// stubUploader stores content under a fixed CID, or fails with err
+type stubUploader struct {
+	err     error
+	content string
+}
+
+func (u *stubUploader) Add(_ context.Context, _ string, r io.Reader) (string, error) {
+	if u.err != nil {
+		return "", u.err
+	}
+	data, _ := io.ReadAll(r)
+	u.content = string(data)
+	return "bafybeistub", nil
+}
+
+func TestLargeOutputSavedByCID(t *testing.T) {
+	server, client := newContractServer(t)
+	uploader := &stubUploader{}
+	client.offload = &outputOffload{uploader: uploader, threshold: 64, preview: 8}
+
+	output := strings.Repeat("é", 50)
+	result := &models.TaskResult{TaskID: uuid.New(), Output: output, ResultHash: "abc"}
+	if err := client.SaveTaskResult(result.TaskID.String(), result); err != nil {
+		t.Fatal(err)
+	}
+	var sent models.TaskResult
+	if err := json.Unmarshal(server.bodies["saveTaskResult"], &sent); err != nil {
+		t.Fatal(err)
+	}
+	if sent.OutputCID != "bafybeistub" || sent.Output != strings.Repeat("é", 4) || sent.OutputSize != 100 || sent.OutputTruncated {
+		t.Fatalf("sent %q with CID %s of %d bytes, want the CID and the first whole characters of the output", sent.Output, sent.OutputCID, sent.OutputSize)
+	}
+	if uploader.content != output || result.Output != output || result.OutputCID != "" {
+		t.Error("the whole output was not uploaded, or the caller's result was changed")
+	}
+}
+
+func TestOutputTruncatedWhenUploadFails(t *testing.T) {
+	offload := &outputOffload{uploader: &stubUploader{err: errors.New("no IPFS endpoint took it")}, threshold: 64, preview: 8}
+	result := &models.TaskResult{Output: strings.Repeat("x", 100)}
+
+	sent := offload.apply(context.Background(), uuid.NewString(), result)
+	if sent.OutputCID != "" || len(sent.Output) != 64 || !sent.OutputTruncated || sent.OutputSize != 100 {
+		t.Fatalf("sent %d bytes of %d, truncated %v, want the output cut to the threshold and flagged", len(sent.Output), sent.OutputSize, sent.OutputTruncated)
+	}
+
+	small := &models.TaskResult{Output: "done"}
+	if offload.apply(context.Background(), uuid.NewString(), small) != small {
+		t.Error("an output within the threshold was not sent as it is")
+	}
+}
+
+func TestEscrowedAndRetainedOutputsAreNotOffloaded(t *testing.T) {
+	outbox, err := retention.NewOutbox(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploader := &stubUploader{}
+	offload := &outputOffload{uploader: uploader, threshold: 64, preview: 8, outbox: outbox}
+	output := strings.Repeat("x", 100)
+
+	escrowed := &models.TaskResult{Output: output, Escrow: &models.EscrowReport{CID: "bafybeisealed"}}
+	if sent := offload.apply(context.Background(), uuid.NewString(), escrowed); sent != escrowed {
+		t.Error("an escrowed output was offloaded")
+	}
+
+	task := &models.Task{ID: uuid.New(), Config: []byte(`{"retention":"7d"}`)}
+	retained := &models.TaskResult{TaskID: task.ID, Output: output}
+	if err := outbox.Put(task, models.TaskStatusCompleted, retained); err != nil {
+		t.Fatal(err)
+	}
+	if sent := offload.apply(context.Background(), task.ID.String(), retained); sent != retained {
+		t.Error("the output of a task setting its own retention was offloaded")
+	}
+	if uploader.content != "" {
+		t.Errorf("uploaded %d bytes, want nothing uploaded", len(uploader.content))
+	}
+}
@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/audit"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/core/ports"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+)
+
+const outboxDirName = "outbox"
+
+// SetOutbox keeps a copy of every task result in outbox until the server
+// confirms its upload
+func (h *DefaultTaskHandler) SetOutbox(outbox *retention.Outbox) {
+	h.outbox = outbox
+}
+
+// keepResult copies task's result to the outbox ahead of its upload
+func (h *DefaultTaskHandler) keepResult(task *models.Task, status models.TaskStatus, result *models.TaskResult) {
+	if h.outbox == nil {
+		return
+	}
+	if err := h.outbox.Put(task, status, result); err != nil {
+		log := gologger.WithComponent("task_handler")
+		log.Warn().Err(err).Str("id", task.ID.String()).Msg("Failed to keep task result for upload")
+	}
+}
+
+// confirmResult starts the retention of task's result, whose upload the
+// server confirmed
+func (h *DefaultTaskHandler) confirmResult(task *models.Task) {
+	if h.outbox == nil {
+		return
+	}
+	if err := h.outbox.Confirm(task.ID.String()); err != nil {
+		log := gologger.WithComponent("task_handler")
+		log.Warn().Err(err).Str("id", task.ID.String()).Msg("Failed to confirm task result upload")
+	}
+}
+
+// newPurger returns the purger of the task data the runner keeps: the
+// results in the outbox at outboxDir, which pending uploads are retried from
+// through taskClient, the replay bundles in bundles and the tasks' scratch
+// directories
+func newPurger(outboxDir string, cfg config.RetentionConfig, taskClient ports.TaskClient, bundles *audit.BundleStore) (*retention.Purger, *retention.Outbox, error) {
+	outbox, err := retention.NewOutbox(outboxDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	purger := retention.NewPurger(outbox, cfg.Default)
+	purger.AddSource(retention.Scratch{})
+	if bundles != nil {
+		purger.AddDirectedSource(bundles)
+	}
+	purger.SetResender(func(taskID string, status models.TaskStatus, result *models.TaskResult) error {
+		return taskClient.UpdateTaskStatus(taskID, status, result)
+	})
+	return purger, outbox, nil
+}
@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+// flakyUploadClient fails to upload results while down
+type flakyUploadClient struct {
+	fakeFLClient
+	down bool
+}
+
+func (c *flakyUploadClient) UpdateTaskStatus(taskID string, status models.TaskStatus, result *models.TaskResult) error {
+	if status != models.TaskStatusRunning && c.down {
+		return errors.New("server unavailable")
+	}
+	return c.fakeFLClient.UpdateTaskStatus(taskID, status, result)
+}
+
+func TestResultKeptUntilUploadConfirmed(t *testing.T) {
+	client := &flakyUploadClient{down: true}
+	h := NewTaskHandler(&countingExecutor{}, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	purger, outbox, err := newPurger(filepath.Join(t.TempDir(), outboxDirName), config.RetentionConfig{}, client, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetOutbox(outbox)
+
+	task := newDockerTask(t)
+	if err := h.HandleTask(task); err == nil {
+		t.Fatal("HandleTask() succeeded though the result upload failed")
+	}
+	entry, err := outbox.Get(task.ID.String())
+	if err != nil || entry.Confirmed() || entry.Status != models.TaskStatusCompleted {
+		t.Fatalf("outbox entry = %+v, %v, want the pending completed result", entry, err)
+	}
+
+	// With no retention at all, the result stays until it is uploaded
+	purger.Sweep()
+	if _, err := outbox.Get(task.ID.String()); err != nil {
+		t.Fatalf("pending result purged: %v", err)
+	}
+
+	client.down = false
+	done := newDockerTask(t)
+	if err := h.HandleTask(done); err != nil {
+		t.Fatal(err)
+	}
+	if entry, err := outbox.Get(done.ID.String()); err != nil || !entry.Confirmed() {
+		t.Fatalf("outbox entry = %+v, %v, want the uploaded result confirmed", entry, err)
+	}
+}
@@ -0,0 +1,303 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/overlay"
+	"github.com/theblitlabs/parity-runner/internal/revocation"
+)
+
+const (
+	revocationDirName   = "revocations"
+	revocationFileName  = "revocations.json"
+	revocationAuditName = "audit.jsonl"
+	// revocationFeedPath is where the server serves the feed when no feed
+	// URLs are configured
+	revocationFeedPath = "/api/v1/revocations"
+)
+
+// revocableTask is a running task and how to kill it
+type revocableTask struct {
+	task *models.Task
+	stop context.CancelCauseFunc
+	// revokedBy is the revocation that killed the task, once one did
+	revokedBy string
+}
+
+type revocableTasks struct {
+	mu    sync.Mutex
+	tasks map[string]*revocableTask
+}
+
+func (r *revocableTasks) put(task *revocableTask) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tasks == nil {
+		r.tasks = make(map[string]*revocableTask)
+	}
+	r.tasks[task.task.ID.String()] = task
+}
+
+func (r *revocableTasks) remove(taskID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.tasks, taskID)
+}
+
+// revoke marks the running task with taskID killed by revocationID,
+// returning it unless it was already
+func (r *revocableTasks) revoke(taskID, revocationID string) *revocableTask {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	running, ok := r.tasks[taskID]
+	if !ok || running.revokedBy != "" {
+		return nil
+	}
+	running.revokedBy = revocationID
+	return running
+}
+
+func (r *revocableTasks) list() []*models.Task {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tasks := make([]*models.Task, 0, len(r.tasks))
+	for _, running := range r.tasks {
+		tasks = append(tasks, running.task)
+	}
+	return tasks
+}
+
+// SetRevocations kills the running tasks the revocations in list match and
+// declines to claim them, recording every action in audit
+func (h *DefaultTaskHandler) SetRevocations(list *revocation.List, audit *revocation.AuditLog) {
+	h.revocations = list
+	h.revocationAudit = audit
+}
+
+// auditRevocation records an action taken on a revocation
+func (h *DefaultTaskHandler) auditRevocation(action, revocationID, taskID, detail string) {
+	err := h.revocationAudit.Record(models.RevocationAuditEntry{
+		Time:         h.clock.Now().UTC(),
+		Action:       action,
+		RevocationID: revocationID,
+		TaskID:       taskID,
+		Detail:       detail,
+	})
+	if err != nil {
+		log := gologger.WithComponent("task_handler")
+		log.Error().Err(err).Str("action", action).Str("revocation", revocationID).Msg("Failed to record revocation action")
+	}
+}
+
+// admitRevocation declines tasks a revocation in force matches
+func (h *DefaultTaskHandler) admitRevocation(task *models.Task) *admissionError {
+	if h.revocations == nil {
+		return nil
+	}
+	revoked := h.revocations.Match(task)
+	if revoked == nil {
+		return nil
+	}
+	h.auditRevocation(models.RevocationBlocked, revoked.ID, task.ID.String(), "")
+	return &admissionError{reason: models.FLDeclineRevoked, err: fmt.Errorf("revocation %s: %s", revoked.ID, revoked.Reason)}
+}
+
+// withRevocation returns ctx cancelled with revocation.ErrRevoked should a
+// revocation match the task while it runs. Once the task stopped, the
+// returned func purges the cached inputs of a revoked task.
+func (h *DefaultTaskHandler) withRevocation(ctx context.Context, task *models.Task) (context.Context, func()) {
+	if h.revocations == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	h.revocable.put(&revocableTask{task: task, stop: cancel})
+	// A revocation may have arrived since the task was admitted
+	if revoked := h.revocations.Match(task); revoked != nil {
+		h.revokeTask(task.ID.String(), revoked)
+	}
+	return ctx, func() {
+		h.revocable.remove(task.ID.String())
+		if cause := revokedCause(ctx); cause != nil {
+			h.purgeRevoked(task)
+		}
+		cancel(nil)
+	}
+}
+
+// revokedCause returns why the task running under ctx was killed by a
+// revocation, or nil
+func revokedCause(ctx context.Context) error {
+	if cause := context.Cause(ctx); errors.Is(cause, revocation.ErrRevoked) {
+		return cause
+	}
+	return nil
+}
+
+// revokeTask kills the running task with taskID
+func (h *DefaultTaskHandler) revokeTask(taskID string, revoked *models.Revocation) {
+	running := h.revocable.revoke(taskID, revoked.ID)
+	if running == nil {
+		return
+	}
+	running.stop(fmt.Errorf("%w: revocation %s: %s", revocation.ErrRevoked, revoked.ID, revoked.Reason))
+	h.auditRevocation(models.RevocationTerminated, revoked.ID, taskID, revoked.Reason)
+
+	log := gologger.WithComponent("task_handler")
+	log.Warn().
+		Str("id", taskID).
+		Str("revocation", revoked.ID).
+		Str("reason", revoked.Reason).
+		Msg("Killing task - revoked by the network")
+}
+
+// purgeRevoked removes the cached inputs of a revoked task that no other
+// task holds
+func (h *DefaultTaskHandler) purgeRevoked(task *models.Task) {
+	if h.caches == nil {
+		return
+	}
+	keys := make(map[string][]string)
+	for _, ref := range cacheRefs(task) {
+		keys[ref.Cache] = append(keys[ref.Cache], ref.Key)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for name, cacheKeys := range keys {
+		result, err := h.caches.Purge(ctx, name, caches.PurgeRequest{Keys: cacheKeys})
+		if err != nil {
+			log := gologger.WithComponent("task_handler")
+			log.Error().Err(err).Str("id", task.ID.String()).Str("cache", name).Msg("Failed to purge inputs of revoked task")
+			continue
+		}
+		removed := make([]string, 0, len(result.Removed))
+		for _, entry := range result.Removed {
+			removed = append(removed, entry.Key)
+		}
+		h.auditRevocation(models.RevocationPurged, "", task.ID.String(), fmt.Sprintf("%s: %s", name, strings.Join(removed, ",")))
+	}
+}
+
+// reportRevoked reports a task killed by a revocation
+func (h *DefaultTaskHandler) reportRevoked(task *models.Task, run clock.Stopwatch, appliedTimeout *models.AppliedTimeout, cause error) (models.TaskStatus, *models.TaskResult, error) {
+	failure := &models.TaskResult{
+		TaskID:         task.ID,
+		Error:          cause.Error(),
+		AppliedTimeout: appliedTimeout,
+		FailureCode:    models.FailureRevoked,
+	}
+	h.recordHistory(task, run, models.TaskStatusFailed, nil, models.FailureRevoked)
+	h.stampResult(failure, run)
+	h.finishShard(task, run, models.TaskStatusFailed, failure, true)
+	h.accountResult(task, run, models.TaskStatusFailed, failure)
+	failure.Timeline = h.TaskTimeline(task.ID.String())
+	if err := h.taskClient.UpdateTaskStatus(task.ID.String(), models.TaskStatusRevoked, failure); err != nil {
+		log := gologger.WithComponent("task_handler")
+		log.Error().Err(err).Str("id", task.ID.String()).Msg("Failed to report revoked task")
+	} else {
+		h.account(task, h.journal.Reported)
+		h.finishTimeline(task, models.AttemptReported)
+	}
+	return models.TaskStatusRevoked, failure, cause
+}
+
+// ApplyRevocations verifies feed, puts the revocations it lists into
+// force and kills the running tasks they match
+func (h *DefaultTaskHandler) ApplyRevocations(feed *models.RevocationFeed) error {
+	if h.revocations == nil {
+		return errors.New("revocations are not enabled on this runner")
+	}
+	added, err := h.revocations.Apply(feed)
+	if err != nil {
+		if errors.Is(err, revocation.ErrBadSignature) {
+			h.auditRevocation(models.RevocationRejected, "", "", err.Error())
+		}
+		return err
+	}
+	for _, revoked := range added {
+		h.auditRevocation(models.RevocationReceived, revoked.ID, revoked.TaskID, revoked.Reason)
+	}
+	h.enforceRevocations()
+	return nil
+}
+
+// enforceRevocations kills the running tasks a revocation in force matches
+func (h *DefaultTaskHandler) enforceRevocations() {
+	for _, task := range h.revocable.list() {
+		if revoked := h.revocations.Match(task); revoked != nil {
+			h.revokeTask(task.ID.String(), revoked)
+		}
+	}
+}
+
+// WatchRevocations fetches the revocation feed now and every interval
+// until ctx ends, applying it. It runs apart from the task poll loop and
+// its client, so revocations are honored while the server's API is
+// degraded. Without a feed it only enforces the revocations another
+// instance of the process fetched.
+func (h *DefaultTaskHandler) WatchRevocations(ctx context.Context, feed *revocation.Feed, interval time.Duration) {
+	if h.revocations == nil {
+		return
+	}
+	log := gologger.WithComponent("task_handler")
+	ticker := h.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if feed != nil {
+			if fetched, err := feed.Fetch(ctx); err != nil {
+				log.Debug().Err(err).Msg("Failed to fetch revocation feed")
+			} else if err := h.ApplyRevocations(fetched); err != nil && !errors.Is(err, revocation.ErrStale) {
+				log.Warn().Err(err).Msg("Rejected revocation feed")
+			}
+		}
+		h.enforceRevocations()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C():
+		}
+	}
+}
+
+// newRevocations returns the revocation list, its audit log and the feed
+// the revocation config describes, keeping them in dataDir, or nils when
+// no network key is configured
+func newRevocations(cfg config.RevocationConfig, serverURL, dataDir string) (*revocation.List, *revocation.AuditLog, *revocation.Feed, error) {
+	if cfg.PublicKey == "" {
+		return nil, nil, nil, nil
+	}
+	key, err := overlay.ParsePublicKey(cfg.PublicKey)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid revocation public key: %w", err)
+	}
+	dir := filepath.Join(dataDir, revocationDirName)
+	audit, err := revocation.NewAuditLog(filepath.Join(dir, revocationAuditName))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	list, err := revocation.Open(filepath.Join(dir, revocationFileName), key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	urls := cfg.FeedURLs
+	if len(urls) == 0 {
+		urls = []string{strings.TrimSuffix(serverURL, "/") + revocationFeedPath}
+	}
+	feed, err := revocation.NewFeed(urls, cfg.Timeout)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return list, audit, feed, nil
+}
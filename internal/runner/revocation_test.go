@@ -0,0 +1,99 @@
+package runner
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/revocation"
+)
+
+func TestRevocationKillsRunningTaskWhileAPIDegraded(t *testing.T) {
+	public, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := newPendingTask(t, models.ResourceConfig{})
+	task.CreatorAddress = "0xabc"
+	h, server, _ := newPipelineHandler(t, task)
+
+	dir := t.TempDir()
+	list, err := revocation.Open(filepath.Join(dir, "revocations.json"), public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, err := revocation.NewAuditLog(filepath.Join(dir, "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetRevocations(list, audit)
+
+	ctx := context.Background()
+	claimed, err := h.Claim(ctx, h.EvaluateFeasibility(task))
+	if err != nil {
+		t.Fatal(err)
+	}
+	execution, err := h.Execute(ctx, claimed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server's API is overloaded; a mirror serves the feed
+	payload, _ := json.Marshal(models.RevocationFeedPayload{Sequence: 1, Revocations: []models.Revocation{{
+		ID:             "rev-1",
+		CreatorAddress: "0xABC",
+		Reason:         "malicious workload",
+		ExpiresAt:      time.Now().Add(time.Hour),
+	}}})
+	signed := models.RevocationFeed{Payload: payload, Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))}
+	degraded := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer degraded.Close()
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(signed)
+	}))
+	defer mirror.Close()
+	feed, err := revocation.NewFeed([]string{degraded.URL, mirror.URL}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watchCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	go h.WatchRevocations(watchCtx, feed, 10*time.Millisecond)
+
+	reportCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	status, result, err := h.Report(reportCtx, execution)
+	if !errors.Is(err, revocation.ErrRevoked) || status != models.TaskStatusRevoked {
+		t.Fatalf("Report() of a revoked task = %s, %v", status, err)
+	}
+	if result.FailureCode != models.FailureRevoked || server.result == nil || server.result.FailureCode != models.FailureRevoked {
+		t.Errorf("server result = %+v, want the task reported revoked", server.result)
+	}
+
+	if admission := h.admitTask(task); admission == nil || admission.reason != models.FLDeclineRevoked {
+		t.Fatalf("admitTask() of a revoked task = %v, want it declined", admission)
+	}
+
+	log, err := os.ReadFile(filepath.Join(dir, "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, action := range []string{models.RevocationReceived, models.RevocationTerminated, models.RevocationBlocked} {
+		if !strings.Contains(string(log), `"action":"`+action+`"`) {
+			t.Errorf("audit log lacks %s:\n%s", action, log)
+		}
+	}
+}
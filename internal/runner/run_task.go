@@ -0,0 +1,115 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+var (
+	// ErrTaskFinished is returned when a task asked to run has already
+	// completed or failed
+	ErrTaskFinished = errors.New("task already finished")
+	// ErrTaskClaimed is returned when a task asked to run is held by
+	// another runner
+	ErrTaskClaimed = errors.New("task already claimed by another runner")
+)
+
+// TaskGetter is implemented by task clients that can fetch a task by ID
+type TaskGetter interface {
+	GetTask(taskID string) (*models.Task, error)
+}
+
+// SetForce makes tasks bypass the runner's scheduling filters: hardware and
+// bandwidth requirements, the image preflight and the compute budget,
+// though forced tasks are still charged to it. Safety policies, namely
+// config validation, power and thermal limits, attestation requirements,
+// input disk space, nonce verification and container security, always
+// apply.
+func (h *DefaultTaskHandler) SetForce(force bool) {
+	h.force = force
+}
+
+// SetLogOutput copies the output of the tasks run to w as it is produced
+func (h *DefaultTaskHandler) SetLogOutput(w io.Writer) {
+	h.logOutput = w
+}
+
+// RunTaskByID claims the task with taskID and runs it now, rather than
+// waiting for the poller, submitting its result the usual way. It returns
+// the status the task finished with.
+func (h *DefaultTaskHandler) RunTaskByID(taskID string) (models.TaskStatus, *models.TaskResult, error) {
+	log := gologger.WithComponent("task_handler")
+
+	getter, ok := h.taskClient.(TaskGetter)
+	if !ok {
+		return "", nil, errors.New("task client cannot fetch tasks by ID")
+	}
+	task, err := getter.GetTask(taskID)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch task: %w", err)
+	}
+
+	switch task.Status {
+	case models.TaskStatusCompleted, models.TaskStatusFailed, models.TaskStatusRevoked:
+		return task.Status, nil, fmt.Errorf("%w: task %s is %s", ErrTaskFinished, task.ID, task.Status)
+	case models.TaskStatusRunning:
+		return "", nil, fmt.Errorf("%w: task %s is running", ErrTaskClaimed, task.ID)
+	}
+	switch task.Type {
+	case models.TaskTypeLLM:
+		return "", nil, errors.New("LLM tasks are answered as prompts and cannot be run on demand")
+	case models.TaskTypeFederatedLearning:
+		return "", nil, errors.New("federated learning tasks run as part of a round and cannot be run on demand")
+	}
+
+	feasibility := h.EvaluateFeasibility(task)
+	if !feasibility.Feasible() {
+		return "", nil, fmt.Errorf("task not admitted (%s): %w", feasibility.why(), feasibility.Err)
+	}
+	claimed, err := h.Claim(context.Background(), feasibility)
+	if errors.Is(err, ErrTaskUnavailable) {
+		return "", nil, fmt.Errorf("%w: %v", ErrTaskClaimed, err)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to claim task: %w", err)
+	}
+
+	log.Info().
+		Str("id", task.ID.String()).
+		Str("type", string(task.Type)).
+		Bool("force", h.force).
+		Msg("Running task on demand")
+
+	execution, err := h.Execute(context.Background(), claimed)
+	if err != nil {
+		return "", nil, err
+	}
+	return h.Report(context.Background(), execution)
+}
+
+// RunTask runs the task with taskID using the runner's full configuration,
+// without starting the poller, heartbeats or webhook server. The task's
+// output is copied to out as it runs.
+func (s *Service) RunTask(taskID string, force bool, out io.Writer) (models.TaskStatus, *models.TaskResult, error) {
+	handler, ok := s.taskHandler.(*DefaultTaskHandler)
+	if !ok {
+		return "", nil, errors.New("task handler cannot run tasks on demand")
+	}
+	handler.SetForce(force)
+	handler.SetLogOutput(out)
+
+	status, result, err := handler.RunTaskByID(taskID)
+	if s.errorReporter != nil {
+		if flushErr := s.errorReporter.Flush(); flushErr != nil {
+			log := gologger.WithComponent("runner")
+			log.Debug().Err(flushErr).Msg("Failed to send error reports")
+		}
+	}
+	return status, result, err
+}
@@ -0,0 +1,217 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/apiclient"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/tasklog"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+// mockServer serves one task through the runner task API, recording the
+// claim and result it receives
+type mockServer struct {
+	t         *testing.T
+	task      *models.Task
+	claimCode int
+
+	mu       sync.Mutex
+	claimed  bool
+	finished bool
+	result   *models.TaskResult
+	// patchCode is OK for a server taking patches of the result, which
+	// patches records
+	patchCode int
+	patches   []models.ResultMetadataPatch
+}
+
+func newMockServer(t *testing.T, task *models.Task) (*mockServer, *HTTPTaskClient) {
+	t.Helper()
+	m := &mockServer{t: t, task: task, claimCode: http.StatusOK}
+	server := httptest.NewServer(m)
+	t.Cleanup(server.Close)
+	return m, NewHTTPTaskClient(server.URL, apiclient.DefaultRetryPolicy)
+}
+
+func (m *mockServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	prefix := "/api/v1/runners/tasks/" + m.task.ID.String()
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == prefix:
+		json.NewEncoder(w).Encode(m.task)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/v1/runners/tasks/"):
+		http.NotFound(w, r)
+	case r.URL.Path == prefix+"/start":
+		if m.claimCode != http.StatusOK {
+			http.Error(w, "task is assigned to another runner", m.claimCode)
+			return
+		}
+		m.claimed = true
+	case r.URL.Path == prefix+"/complete":
+		m.finished = true
+	case r.Method == http.MethodPatch && r.URL.Path == prefix+"/result":
+		if m.patchCode != http.StatusOK {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var patch models.ResultMetadataPatch
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			m.t.Errorf("invalid result patch: %v", err)
+		}
+		m.patches = append(m.patches, patch)
+	case r.URL.Path == prefix+"/result":
+		var result models.TaskResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			m.t.Errorf("invalid result: %v", err)
+		}
+		m.result = &result
+	default:
+		m.t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+	}
+}
+
+// streamingExecutor writes its output to the live task log and exits with
+// exitCode
+type streamingExecutor struct {
+	exitCode int
+	calls    int
+}
+
+func (e *streamingExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	e.calls++
+	if w, ok := tasklog.Output(ctx); ok {
+		w.Write([]byte("training epoch 1\n"))
+	}
+	return &models.TaskResult{TaskID: task.ID, Output: "training epoch 1", ExitCode: e.exitCode}, nil
+}
+
+func newPendingTask(t *testing.T, resources models.ResourceConfig) *models.Task {
+	t.Helper()
+	raw, err := json.Marshal(models.TaskConfig{ImageName: "alpine", Resources: resources})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker, Status: models.TaskStatusPending, Nonce: "abcdef", Config: raw}
+}
+
+func newRunTaskHandler(client *HTTPTaskClient, exitCode int) (*DefaultTaskHandler, *streamingExecutor, *bytes.Buffer) {
+	executor := &streamingExecutor{exitCode: exitCode}
+	h := NewTaskHandler(executor, client)
+	h.hardware = &hardware.Profile{Accelerator: hardware.AcceleratorNone}
+	var out bytes.Buffer
+	h.SetLogOutput(&out)
+	return h, executor, &out
+}
+
+func TestRunTaskByIDOutcomes(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		exitCode int
+		want     models.TaskStatus
+	}{
+		{"completed", 0, models.TaskStatusCompleted},
+		{"failed", 3, models.TaskStatusFailed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			task := newPendingTask(t, models.ResourceConfig{})
+			server, client := newMockServer(t, task)
+			h, _, out := newRunTaskHandler(client, tt.exitCode)
+
+			status, result, err := h.RunTaskByID(task.ID.String())
+			if err != nil {
+				t.Fatalf("RunTaskByID() error = %v", err)
+			}
+			if status != tt.want || result.ExitCode != tt.exitCode {
+				t.Fatalf("RunTaskByID() = %s with exit code %d, want %s", status, result.ExitCode, tt.want)
+			}
+			if !server.claimed || !server.finished || server.result == nil || server.result.ExitCode != tt.exitCode {
+				t.Fatalf("server saw claimed=%v finished=%v result=%+v, want the result submitted", server.claimed, server.finished, server.result)
+			}
+			if out.String() != "training epoch 1\n" {
+				t.Fatalf("live output = %q", out.String())
+			}
+		})
+	}
+}
+
+func TestRunTaskByIDUnavailable(t *testing.T) {
+	running := newPendingTask(t, models.ResourceConfig{})
+	running.Status = models.TaskStatusRunning
+	completed := newPendingTask(t, models.ResourceConfig{})
+	completed.Status = models.TaskStatusCompleted
+
+	for _, tt := range []struct {
+		name      string
+		task      *models.Task
+		id        string
+		claimCode int
+		want      error
+	}{
+		{"running elsewhere", running, running.ID.String(), http.StatusOK, ErrTaskClaimed},
+		{"claimed concurrently", newPendingTask(t, models.ResourceConfig{}), "", http.StatusConflict, ErrTaskClaimed},
+		{"already completed", completed, completed.ID.String(), http.StatusOK, ErrTaskFinished},
+		{"unknown", completed, uuid.NewString(), http.StatusOK, ErrTaskNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := newMockServer(t, tt.task)
+			server.claimCode = tt.claimCode
+			h, executor, _ := newRunTaskHandler(client, 0)
+
+			id := tt.id
+			if id == "" {
+				id = tt.task.ID.String()
+			}
+			if _, _, err := h.RunTaskByID(id); !errors.Is(err, tt.want) {
+				t.Fatalf("RunTaskByID() error = %v, want %v", err, tt.want)
+			}
+			if executor.calls != 0 || server.finished {
+				t.Fatal("task ran although it could not be claimed")
+			}
+		})
+	}
+}
+
+func TestRunTaskByIDForce(t *testing.T) {
+	task := newPendingTask(t, models.ResourceConfig{Accelerator: "cuda"})
+	_, client := newMockServer(t, task)
+	h, executor, _ := newRunTaskHandler(client, 0)
+
+	if _, _, err := h.RunTaskByID(task.ID.String()); err == nil || !strings.Contains(err.Error(), string(models.FLDeclineInsufficientResources)) {
+		t.Fatalf("RunTaskByID() error = %v, want the hardware filter to decline", err)
+	}
+	if executor.calls != 0 {
+		t.Fatal("declined task ran")
+	}
+
+	h.SetForce(true)
+	if status, _, err := h.RunTaskByID(task.ID.String()); err != nil || status != models.TaskStatusCompleted {
+		t.Fatalf("forced RunTaskByID() = %s, %v", status, err)
+	}
+
+	// Safety policies are never bypassed
+	invalid := newPendingTask(t, models.ResourceConfig{})
+	invalid.Config = json.RawMessage(`{"image_name": 42}`)
+	_, client = newMockServer(t, invalid)
+	h, executor, _ = newRunTaskHandler(client, 0)
+	h.SetForce(true)
+	if _, _, err := h.RunTaskByID(invalid.ID.String()); err == nil || !strings.Contains(err.Error(), string(models.FLDeclineInvalidConfig)) {
+		t.Fatalf("forced RunTaskByID() error = %v for an invalid config, want it declined", err)
+	}
+	if executor.calls != 0 {
+		t.Fatal("invalid task ran")
+	}
+}
@@ -0,0 +1,33 @@
+package runner
+
+import (
+	"errors"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/profiles"
+)
+
+// SecurityProfileChecker is implemented by executors that confine tasks
+// under security profiles and can tell before a task is claimed whether
+// the profile it asks for is permitted
+type SecurityProfileChecker interface {
+	CheckSecurityProfile(task *models.Task) error
+}
+
+// admitSecurityProfile skips tasks asking for a security profile the
+// runner's policy does not permit, or that cannot be applied to them
+func (h *DefaultTaskHandler) admitSecurityProfile(task *models.Task) *admissionError {
+	checker, ok := h.executor.(SecurityProfileChecker)
+	if !ok {
+		return nil
+	}
+	err := checker.CheckSecurityProfile(task)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, profiles.ErrNotPermitted):
+		return &admissionError{reason: models.FLDeclineProfileForbidden, err: err}
+	default:
+		return &admissionError{reason: models.FLDeclineInvalidConfig, err: err}
+	}
+}
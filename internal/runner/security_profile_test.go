@@ -0,0 +1,53 @@
+package runner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/profiles"
+	"github.com/theblitlabs/parity-runner/internal/power"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// profileExecutor checks tasks' security profiles against policy, as the
+// task executor does
+type profileExecutor struct {
+	countingExecutor
+	policy profiles.Policy
+}
+
+func (e *profileExecutor) CheckSecurityProfile(task *models.Task) error {
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return err
+	}
+	_, err := e.policy.Resolve(config.SecurityProfile)
+	return err
+}
+
+func TestUnpermittedSecurityProfileSkipsTask(t *testing.T) {
+	policy, err := profiles.ParsePolicy([]string{profiles.NetworkClient}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	executor := &profileExecutor{policy: policy}
+	h, _, client := newPowerHandler(executor, power.InFlightContinue)
+
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeCommand, Nonce: "abcdef", Config: []byte(`{"command": "make test", "security_profile": "build"}`)}
+	err = h.HandleTask(task)
+	var admission *admissionError
+	if !errors.As(err, &admission) || admission.reason != models.FLDeclineProfileForbidden || !errors.Is(err, profiles.ErrNotPermitted) {
+		t.Fatalf("HandleTask() error = %v, want a profile_forbidden skip", err)
+	}
+	if executor.calls != 0 || len(client.statuses) != 0 {
+		t.Fatal("task asking for an unpermitted profile was claimed")
+	}
+
+	task.Config = []byte(`{"command": "curl example.com", "security_profile": "network-client"}`)
+	if err := h.HandleTask(task); err != nil || executor.calls != 1 {
+		t.Fatalf("HandleTask() error = %v, want the permitted profile run", err)
+	}
+}
@@ -5,21 +5,54 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/client"
 	"github.com/google/uuid"
 
-	"github.com/theblitlabs/deviceid"
 	"github.com/theblitlabs/gologger"
-	"github.com/theblitlabs/keystore"
 
+	"github.com/theblitlabs/parity-runner/internal/accounting"
+	"github.com/theblitlabs/parity-runner/internal/audit"
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/callback"
+	"github.com/theblitlabs/parity-runner/internal/canary"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/concurrency"
 	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
 	"github.com/theblitlabs/parity-runner/internal/core/ports"
+	"github.com/theblitlabs/parity-runner/internal/datalock"
+	"github.com/theblitlabs/parity-runner/internal/diskreserve"
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
+	"github.com/theblitlabs/parity-runner/internal/errbudget"
+	"github.com/theblitlabs/parity-runner/internal/errreport"
+	"github.com/theblitlabs/parity-runner/internal/escrow"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/execution/embedding"
 	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+	"github.com/theblitlabs/parity-runner/internal/execution/onnx"
 	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/profiles"
 	"github.com/theblitlabs/parity-runner/internal/execution/task"
+	"github.com/theblitlabs/parity-runner/internal/flmodel"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/health"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/hostreserve"
+	"github.com/theblitlabs/parity-runner/internal/idlework"
 	"github.com/theblitlabs/parity-runner/internal/messaging/webhook"
+	"github.com/theblitlabs/parity-runner/internal/metricspush"
+	"github.com/theblitlabs/parity-runner/internal/migration"
+	"github.com/theblitlabs/parity-runner/internal/pricing"
+	"github.com/theblitlabs/parity-runner/internal/provenance"
+	"github.com/theblitlabs/parity-runner/internal/registrymirror"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+	"github.com/theblitlabs/parity-runner/internal/revocation"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+	"github.com/theblitlabs/parity-runner/internal/tenancy"
 	"github.com/theblitlabs/parity-runner/internal/tunnel"
 	"github.com/theblitlabs/parity-runner/internal/utils"
 )
@@ -29,31 +62,142 @@ type Service struct {
 	webhookClient     *webhook.WebhookClient
 	tunnelClient      *tunnel.TunnelClient
 	taskHandler       ports.TaskHandler
+	leaseStore        *LeaseStore
+	healthChecker     *health.Checker
+	notifier          *health.Notifier
+	healthCancel      context.CancelFunc
+	bandwidthProbe    *hardware.BandwidthProbe
 	taskClient        ports.TaskClient
 	dockerExecutor    *docker.DockerExecutor
 	dockerClient      *client.Client
 	deviceID          string
 	heartbeatInterval time.Duration
+	llmStats          *llm.Stats
+	caches            *caches.Registry
+	releaseModels     func()
+	warmModels        *llm.WarmModels
+	clock             clock.Clock
+	handoff           *Handoff
+	errorReporter     *errreport.Reporter
+	metricsPusher     *metricspush.Pusher
+	clockInfo         *clockReporter
+	capabilities      *capabilitySyncer
+	reputation        *reputationTracker
+	purger            *retention.Purger
+	outbox            *retention.Outbox
+	events            *events.Bus
+	status            *statusTracker
+	// pricing skips tasks paying below the operator's price floor
+	pricing *pricing.Gate
+	// signer signs as the runner's wallet
+	signer signer.Signer
+	// journal ledgers the tasks the runner reported
+	journal *accounting.Journal
+	// dataDir holds the runner's local stores, whose free space the status
+	// API reports
+	dataDir string
+	// stopHook kills the event hook's commands at shutdown
+	stopHook context.CancelFunc
+	// instance is the logical runner the service is, nil when the process
+	// runs a single runner
+	instance *tenancy.Instance
+	shared   *sharedResources
+	// primary runs the loops over the resources it shares with the other
+	// instances of the process
+	primary bool
+	// servedModels are the models the runner serves, advertised but for
+	// those whose canary keeps failing
+	modelsMu     sync.Mutex
+	servedModels []webhook.ModelCapabilityInfo
+	canaries     *canary.Monitor
+	errorBudget  *errbudget.Guard
+	concurrency  *concurrency.Controller
+	mirrors      *registrymirror.Pool
+	disk         *diskreserve.Ledger
+	host         *hostreserve.Ledger
+	// stopRequests abandons the API requests still being made or retried
+	stopRequests context.CancelFunc
+	// revocationFeed fetches the network's emergency revocations, which
+	// the primary applies to the list the instances share
+	revocationFeed *revocation.Feed
+	// taskSocket receives the tasks the server pushes when the runner is
+	// configured for the WebSocket transport
+	taskSocket *WSTaskClient
+	// dataLock keeps other runner processes out of the data directory
+	dataLock *datalock.Lock
+	// shutdown is closed when the operator asks the runner to exit
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
 }
 
 func NewService(cfg *config.Config) (*Service, error) {
-	log := gologger.WithComponent("runner")
+	return NewServiceWithClock(cfg, clock.Real())
+}
 
-	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+// NewServiceWithClock builds the runner with every timing-dependent subsystem
+// driven by clk, so tests can control retries, polling and heartbeats
+func NewServiceWithClock(cfg *config.Config, clk clock.Clock) (*Service, error) {
+	shared := &sharedResources{metrics: tenancy.NewMetrics(nil)}
+	svc, err := newService(cfg, clk, shared, nil)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create Docker client")
-		return nil, fmt.Errorf("docker client creation failed: %w", err)
+		return nil, err
+	}
+	if err := finishMigration(shared.keyring); err != nil {
+		return nil, err
+	}
+	return svc, nil
+}
+
+// newService builds the runner instance is, or the process's only runner
+// when instance is nil. The first service built with shared creates the
+// resources the instances of a process share and runs their loops; the
+// others reuse them.
+func newService(cfg *config.Config, clk clock.Clock, shared *sharedResources, instance *tenancy.Instance) (*Service, error) {
+	log := gologger.WithComponent("runner")
+
+	if instance != nil {
+		instanceCfg := *cfg
+		instanceCfg.Runner.WebhookPort = instance.WebhookPort
+		cfg = &instanceCfg
 	}
 
-	if err := checkDockerAvailability(dockerClient); err != nil {
-		log.Error().Err(err).Msg("Docker is not available")
-		return nil, fmt.Errorf("docker is not available: %w", err)
+	primary := shared.dockerClient == nil
+	build := provenance.Current()
+	if primary {
+		log.Info().
+			Str("version", build.Version).
+			Str("commit", build.Commit).
+			Str("builder", build.Builder).
+			Bool("stamped", build.Stamped).
+			Bool("modified", build.Modified).
+			Bool("ephemeral", build.Ephemeral).
+			Str("fingerprint", build.Fingerprint).
+			Msg("Runner build")
+
+		dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to create Docker client")
+			return nil, fmt.Errorf("docker client creation failed: %w", err)
+		}
+
+		if err := checkDockerAvailability(dockerClient); err != nil {
+			log.Error().Err(err).Msg("Docker is not available")
+			return nil, fmt.Errorf("docker is not available: %w", err)
+		}
+		shared.dockerClient = dockerClient
+		shared.httpClient = dualstack.Client(0)
 	}
+	dockerClient := shared.dockerClient
 
 	svc := &Service{
 		cfg:               cfg,
 		dockerClient:      dockerClient,
 		heartbeatInterval: cfg.Runner.HeartbeatInterval,
+		clock:             clk,
+		instance:          instance,
+		shared:            shared,
+		primary:           primary,
+		shutdown:          make(chan struct{}),
 	}
 
 	homeDir, err := os.UserHomeDir()
@@ -62,18 +206,10 @@ func NewService(cfg *config.Config) (*Service, error) {
 		return nil, fmt.Errorf("failed to get user home directory: %w", err)
 	}
 
-	ks, err := keystore.NewKeystore(keystore.Config{
-		DirPath:  filepath.Join(homeDir, ".parity"),
-		FileName: "keystore.json",
-	})
+	svc.signer, err = newWalletSigner(cfg.Runner.Signer, homeDir, instance)
 	if err != nil {
-		log.Error().Err(err).Msg("Failed to create keystore")
-		return nil, fmt.Errorf("failed to create keystore: %w", err)
-	}
-
-	if _, err := ks.LoadPrivateKey(); err != nil {
-		log.Error().Err(err).Msg("No private key found - authentication required")
-		return nil, fmt.Errorf("no private key found - please authenticate first using 'parity auth': %w", err)
+		log.Error().Err(err).Msg("Failed to set up wallet signing")
+		return nil, err
 	}
 
 	dockerExecutor, err := docker.NewDockerExecutor(&docker.ExecutorConfig{
@@ -86,15 +222,516 @@ func NewService(cfg *config.Config) (*Service, error) {
 		log.Error().Err(err).Msg("Failed to create Docker executor")
 		return nil, fmt.Errorf("failed to create Docker executor: %w", err)
 	}
+	dockerExecutor.SetClock(clk)
 
 	// Create the enhanced task executor that supports LLM routing
 	executor := task.NewExecutor()
+	executor.SetClock(clk)
 
-	taskClient := NewHTTPTaskClient(cfg.Runner.ServerURL)
+	taskClient := NewHTTPTaskClient(cfg.Runner.ServerURL, apiRetryPolicy(cfg.Runner.APIRetry))
+	taskClient.SetClock(clk)
+	taskClient.SetHTTPClient(shared.httpClient)
+	var requestCtx context.Context
+	requestCtx, svc.stopRequests = context.WithCancel(context.Background())
+	taskClient.SetContext(requestCtx)
+	flCodec, err := newFLCodec(cfg.Runner.FLCompression)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure FL compression: %w", err)
+	}
+	if flCodec != nil {
+		taskClient.SetFLCompression(flCodec)
+		log.Info().Str("mode", cfg.Runner.FLCompression.Mode).Msg("FL update compression enabled")
+	}
+	if err := taskClient.SetOutputOffload(cfg.Runner.ResultOffload); err != nil {
+		return nil, fmt.Errorf("failed to configure result offload: %w", err)
+	}
+	switch cfg.Runner.Transport {
+	case "", TransportWebhook:
+	case TransportWebSocket:
+		svc.taskSocket, err = NewWSTaskClient(taskClient, cfg.Runner.ServerURL, cfg.Runner.TaskSocket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure task socket: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported transport %q", cfg.Runner.Transport)
+	}
 	taskHandler := NewTaskHandler(executor, taskClient)
+	taskHandler.SetClock(clk)
+	svc.events = events.NewBus(cfg.Runner.Events.QueueSize)
+	taskHandler.SetEventBus(svc.events)
+	svc.status = newStatusTracker(clk)
+	svc.events.Subscribe("status", svc.status.handle)
+	hook, err := newEventHook(cfg.Runner.Events)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure event hook: %w", err)
+	}
+	if hook != nil {
+		var hookCtx context.Context
+		hookCtx, svc.stopHook = context.WithCancel(context.Background())
+		subscribeHook(hookCtx, svc.events, hook)
+	}
+	svc.llmStats = executor.LLMStats()
+	taskHandler.SetLLMStats(svc.llmStats)
+
+	dataDir := filepath.Join(homeDir, utils.KeystoreDirName)
+	executor.SetUsageReconciler(llm.NewUsageReconciler(
+		llm.NewTokenizerStore("http://localhost:11434", filepath.Join(dataDir, "tokenizers")),
+		llm.UsagePolicy{Tolerance: cfg.Runner.TokenUsage.Tolerance, Slack: cfg.Runner.TokenUsage.Slack},
+	))
+	if cfg.Runner.PromptCache.Enabled {
+		prompts := llm.NewPromptCache(cfg.Runner.PromptCache.MaxMB<<20, cfg.Runner.PromptCache.TTL)
+		prompts.SetClock(clk)
+		executor.SetPromptCache(prompts)
+	}
+	if primary && cfg.Runner.WarmModels.Enabled {
+		shared.warmModels, err = newWarmModels(dataDir, cfg.Runner.WarmModels, clk)
+		if err != nil {
+			log.Warn().Err(err).Msg("Warm models disabled - Ollama is assumed cold on every start")
+		}
+	}
+	svc.warmModels = shared.warmModels
+	executor.SetWarmModels(shared.warmModels)
+	if primary {
+		shared.keyring, err = openDataKeyring(dataDir)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to unlock runner data keys")
+			return nil, err
+		}
+		// Stores are checked before any is opened, so that damage left by
+		// an unclean shutdown is never served
+		logFsckReport(checkLocalStores(dataDir, keyringCodec(shared.keyring)))
+
+		shared.history, err = history.NewStore(filepath.Join(dataDir, historyFileName))
+		if err != nil {
+			log.Warn().Err(err).Msg("Task history disabled - default timeouts will not adapt")
+		} else {
+			shared.history.SetClock(clk)
+			shared.history.SetRetention(historyRetention(cfg.Runner))
+		}
+	}
+	keyring := shared.keyring
+	historyStore := shared.history
+	// Without history timeouts do not adapt, but are still capped
+	taskHandler.SetHistory(historyStore, NewTimeoutPolicy(TimeoutPolicyConfig{
+		Factor:     cfg.Runner.AdaptiveTimeout.Factor,
+		Min:        cfg.Runner.AdaptiveTimeout.Min,
+		Max:        cfg.Runner.AdaptiveTimeout.Max,
+		MinSamples: cfg.Runner.AdaptiveTimeout.MinSamples,
+		Default:    cfg.Runner.TaskTimeout.Default,
+		Ceiling:    cfg.Runner.TaskTimeout.Max,
+	}, historyStore))
+	// Paused task types resume through canaries, so the error budget needs
+	// them even when they are not scheduled
+	if primary && (cfg.Runner.Canary.Enabled || cfg.Runner.ErrorBudget.Enabled) {
+		checks := canaryChecks(cfg.Runner.Canary, shared.httpClient, dockerExecutor, llm.NewOllamaExecutor(""), svc.loadedModels)
+		shared.canaries, err = newCanaryMonitor(cfg.Runner.Canary, checks, historyStore)
+		if err != nil {
+			return nil, err
+		}
+		shared.canaries.SetClock(clk)
+		shared.canaries.OnChange(shared.canaryChanged)
+	}
+	if primary && cfg.Runner.ErrorBudget.Enabled {
+		shared.errorBudget, err = newErrorBudget(cfg.Runner.ErrorBudget, shared.canaries)
+		if err != nil {
+			return nil, err
+		}
+		shared.errorBudget.SetClock(clk)
+		shared.errorBudget.OnChange(shared.errorBudgetChanged)
+		if cfg.Runner.ErrorBudget.Diagnose {
+			shared.errorBudget.SetDiagnose(diagnoseReadiness(func() *health.Checker { return svc.healthChecker }))
+		}
+	}
+	svc.canaries = shared.canaries
+	svc.errorBudget = shared.errorBudget
+	taskHandler.SetCanaries(shared.canaries)
+	taskHandler.SetErrorBudget(shared.errorBudget)
+	if shared.errorBudget != nil {
+		subscribeErrorBudget(svc.events, shared.errorBudget)
+	}
 
-	deviceIDManager := deviceid.NewManager(deviceid.Config{})
-	deviceID, err := deviceIDManager.VerifyDeviceID()
+	leaseDir := filepath.Join(dataDir, leaseDirName)
+	if instance != nil {
+		leaseDir = filepath.Join(leaseDir, instance.Name)
+	}
+	leaseStore, err := NewLeaseStore(leaseDir)
+	if err != nil {
+		log.Warn().Err(err).Msg("Task leases will not survive a restart")
+	} else {
+		taskHandler.SetLeaseStore(leaseStore)
+		svc.leaseStore = leaseStore
+	}
+
+	var auditor *audit.Auditor
+	var bundles *audit.BundleStore
+	if cfg.Runner.Audit.Enabled {
+		if historyStore == nil {
+			return nil, fmt.Errorf("audit mode requires task history")
+		}
+		auditor, err = newAuditor(dataDir, cfg.Runner.Audit, historyStore)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure audit mode: %w", err)
+		}
+		auditor.SetClock(clk)
+		bundles = auditor.Bundles()
+		bundles.SetClock(clk)
+		taskHandler.SetAuditor(auditor)
+	}
+
+	outboxDir := filepath.Join(dataDir, outboxDirName)
+	if instance != nil {
+		outboxDir = filepath.Join(outboxDir, instance.Name)
+	}
+	purger, outbox, err := newPurger(outboxDir, cfg.Runner.Retention, taskClient, bundles)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure result retention: %w", err)
+	}
+	purger.SetClock(clk)
+	outbox.SetClock(clk)
+	taskHandler.SetOutbox(outbox)
+	taskClient.SetOutbox(outbox)
+	svc.purger = purger
+	svc.outbox = outbox
+
+	accountingDir := filepath.Join(dataDir, accountingDirName)
+	if instance != nil {
+		accountingDir = filepath.Join(accountingDir, instance.Name)
+	}
+	journal, err := accounting.Open(accountingDir)
+	if err != nil {
+		log.Warn().Err(err).Msg("Task accounting disabled - reported tasks will not be ledgered")
+	} else {
+		journal.SetClock(clk)
+		taskHandler.SetAccounting(journal)
+		svc.journal = journal
+	}
+
+	if cfg.Runner.ErrorReporting.Enabled {
+		if primary {
+			shared.errorReporter, err = newErrorReporter(dataDir, cfg.Runner.ErrorReporting, taskClient)
+			if err != nil {
+				return nil, fmt.Errorf("failed to configure error reporting: %w", err)
+			}
+			shared.errorReporter.SetClock(clk)
+		}
+		reporter := shared.errorReporter
+		taskHandler.SetErrorReporter(reporter)
+		svc.errorReporter = reporter
+	}
+
+	callbackNotifier := callback.NewNotifier(callback.Config{
+		Policy: callback.Policy{
+			AllowedSchemes: cfg.Runner.Callback.AllowedSchemes,
+			AllowedPorts:   cfg.Runner.Callback.AllowedPorts,
+			AllowPrivate:   cfg.Runner.Callback.AllowPrivate,
+		},
+		Timeout:     cfg.Runner.Callback.Timeout,
+		MaxAttempts: cfg.Runner.Callback.MaxAttempts,
+	}, svc.signer)
+	callbackNotifier.SetClock(clk)
+	taskHandler.SetCallbackNotifier(callbackNotifier)
+
+	materializer, err := newMaterializer(cfg.Runner.Materialize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure materialization: %w", err)
+	}
+	if materializer != nil {
+		materializer.SetClock(clk)
+		taskHandler.SetMaterializer(materializer)
+	}
+
+	if primary {
+		shared.caches, err = openLocalCaches(dataDir)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to open cache registry")
+			return nil, err
+		}
+		shared.caches.registry.SetClock(clk)
+		shared.caches.registry.SetEventBus(svc.events)
+		shared.caches.registry.SetBudget(cfg.Runner.Cache.BudgetGB<<30, cfg.Runner.Cache.MinShare)
+
+		if shared.objectStore, err = newObjectStore(cfg.Runner.ObjectStorage); err != nil {
+			log.Error().Err(err).Msg("Invalid object storage configuration")
+			return nil, err
+		}
+		if shared.objectStore != nil {
+			shared.objectStore.SetClock(clk)
+			if shared.caches.inputs != nil {
+				shared.caches.inputs.SetObjectStore(shared.objectStore)
+			}
+		}
+		ipfsFetcher, err := newIPFSFetcher(cfg.Runner.IPFSFetch, dataDir, shared.httpClient)
+		if err != nil {
+			log.Error().Err(err).Msg("Invalid IPFS fetch configuration")
+			return nil, err
+		}
+		if ipfsFetcher != nil {
+			shared.ipfs = ipfsFetcher
+			if shared.caches.inputs != nil {
+				shared.caches.inputs.SetIPFSFetcher(ipfsFetcher)
+			}
+			if shared.caches.datasets != nil {
+				shared.caches.datasets.SetIPFSFetcher(ipfsFetcher)
+			}
+			log.Info().Int("gateways", len(cfg.Runner.IPFSFetch.Gateways)).Msg("Verified IPFS fetching enabled")
+		}
+		if shared.caches.inputs != nil {
+			shared.caches.inputs.SetHTTPClient(shared.httpClient)
+			shared.caches.inputs.SetArtifactSource(taskClient)
+			shared.caches.inputs.SetLocalArtifacts(filepath.Join(dataDir, artifactDirName))
+			fetcher, err := flmodel.NewFetcher(filepath.Join(dataDir, globalModelDirName), shared.caches.inputs)
+			if err != nil {
+				log.Warn().Err(err).Msg("Global model cache unavailable - FL rounds with a global model will be declined")
+			}
+			shared.globalModels = fetcher
+		}
+
+		if shared.mirrors, err = newRegistryMirrors(cfg.Runner.Registry); err != nil {
+			log.Error().Err(err).Msg("Invalid registry mirror configuration")
+			return nil, err
+		}
+		if shared.mirrors != nil {
+			shared.mirrors.SetClock(clk)
+			log.Info().Int("mirrors", len(cfg.Runner.Registry.Mirrors)).Msg("Registry mirrors enabled")
+		}
+		if cfg.Runner.Registry.BlobCache && shared.caches.blobs != nil {
+			if shared.blobs, err = newBlobFetcher(cfg.Runner.Registry, shared.caches.blobs, svc.signer); err != nil {
+				log.Error().Err(err).Msg("Invalid image blob cache configuration")
+				return nil, err
+			}
+			shared.blobs.SetClock(clk)
+			log.Info().
+				Int("peers", len(cfg.Runner.Registry.BlobPeers)).
+				Bool("serving", cfg.Runner.Registry.ServeBlobs).
+				Msg("Image blob cache enabled")
+		}
+
+		if shared.disk, err = newDiskLedger(cfg.Runner.Disk, dataDir); err != nil {
+			log.Error().Err(err).Msg("Invalid disk reservation configuration")
+			return nil, err
+		}
+		if shared.disk != nil {
+			shared.disk.SetEvictor(shared.caches.registry)
+			log.Info().
+				Str("path", shared.disk.Path()).
+				Int64("min_free_mb", cfg.Runner.Disk.MinFreeMB).
+				Msg("Disk reservations enabled")
+		}
+	}
+	localCaches := shared.caches
+	dockerExecutor.SetHTTPClient(shared.httpClient)
+	if shared.mirrors != nil {
+		dockerExecutor.SetRegistryMirrors(shared.mirrors)
+	}
+	if shared.blobs != nil {
+		dockerExecutor.SetBlobFetcher(shared.blobs)
+	}
+	svc.mirrors = shared.mirrors
+	if shared.disk != nil {
+		taskHandler.SetDiskLedger(shared.disk)
+		svc.disk = shared.disk
+	}
+	executor.SetDatasetCache(localCaches.datasets)
+	if localCaches.inputs != nil {
+		executor.SetInputManager(localCaches.inputs)
+	}
+	var customModels *llm.ModelLoader
+	if cfg.Runner.CustomModels.Enabled {
+		if localCaches.inputs == nil {
+			log.Warn().Msg("Custom models need the input cache - LLM tasks supplying a model will be declined")
+		} else {
+			customModels = llm.NewModelLoader("", localCaches.inputs)
+			customModels.SetKeep(!cfg.Runner.CustomModels.EvictAfterTask)
+			executor.SetCustomModels(customModels)
+		}
+	}
+	if cfg.Runner.ONNXLibrary != "" {
+		runtime := onnx.NewORTRuntime(cfg.Runner.ONNXLibrary)
+		if err := runtime.Init(); err != nil {
+			log.Warn().Err(err).Str("library", cfg.Runner.ONNXLibrary).Msg("ONNX Runtime unavailable - ONNX tasks will not be run")
+		} else {
+			executor.SetONNXRuntime(runtime)
+		}
+	}
+	if shared.globalModels != nil {
+		executor.SetGlobalModels(shared.globalModels)
+	}
+	embeddingSources := embedding.Sources{Client: shared.httpClient, MaxBytes: cfg.Runner.EmbeddingInputMaxMB << 20}
+	if shared.ipfs != nil {
+		embeddingSources.IPFS = shared.ipfs
+	}
+	executor.SetEmbeddingSources(embeddingSources)
+	taskHandler.SetCaches(localCaches.registry)
+	svc.caches = localCaches.registry
+
+	attester := newAttester(cfg, clk)
+	taskHandler.SetAttester(attester)
+	taskHandler.SetBuildFingerprint(build.Fingerprint)
+	taskHandler.SetPreflightMode(docker.PreflightMode(cfg.Runner.Docker.Preflight))
+	executor.SetMemoryPolicy(docker.MemoryPolicy{
+		SoftRatio: cfg.Runner.Docker.SoftMemoryRatio,
+		Sustain:   cfg.Runner.Docker.SoftMemorySustain,
+	})
+	executor.SetStallPolicy(docker.StallPolicy{
+		Memory:  cfg.Runner.Docker.StallMemory,
+		IO:      cfg.Runner.Docker.StallIO,
+		CPU:     cfg.Runner.Docker.StallCPU,
+		Sustain: cfg.Runner.Docker.StallSustain,
+		Abandon: cfg.Runner.Docker.StallAbandon,
+	})
+	executor.SetWarningSink(taskClient)
+
+	redactor, err := newRedactor(cfg.Runner.Redaction)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure redaction: %w", err)
+	}
+	if redactor != nil {
+		taskHandler.SetRedactor(redactor)
+	}
+
+	var migrations *migration.Tracker
+	if cfg.Runner.Migration.Enabled {
+		migrations = migration.NewTracker()
+		executor.SetMigrationTracker(migrations)
+	}
+	executor.SetProgressSink(&progressPublisher{sink: taskClient, bus: svc.events, redactor: redactor, groups: taskHandler.groups, migrations: migrations, taps: &taskHandler.executionTaps})
+	taskHandler.SetTaskHeartbeat(taskClient, cfg.Runner.TaskTimeout.HeartbeatInterval)
+	executor.SetStopGracePeriod(cfg.Runner.Docker.StopGracePeriod)
+	networkPolicy, err := docker.ParseNetworkPolicy(cfg.Runner.NetworkOverrides.AllowedNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure network overrides: %w", err)
+	}
+	if networkPolicy.PublishPorts, err = docker.ParsePortRanges(cfg.Runner.NetworkOverrides.PublishPorts); err != nil {
+		return nil, fmt.Errorf("failed to configure published ports: %w", err)
+	}
+	if networkPolicy.IPv6Prefix, err = docker.ParseIPv6Prefix(cfg.Runner.NetworkOverrides.IPv6Prefix); err != nil {
+		return nil, fmt.Errorf("failed to configure IPv6 task networks: %w", err)
+	}
+	executor.SetNetworkPolicy(networkPolicy)
+	if cfg.Runner.ToolUse.Enabled {
+		broker, limit := newToolBroker(cfg.Runner.ToolUse, networkPolicy)
+		broker.SetClock(clk)
+		executor.SetToolUse(broker, limit)
+	}
+	userPolicy, err := docker.ParseUserPolicy(cfg.Runner.Docker.RootPolicy, cfg.Runner.Docker.TaskUIDs, cfg.Runner.Docker.Capabilities)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure task users: %w", err)
+	}
+	executor.SetUserPolicy(userPolicy)
+	securityPolicy, err := profiles.ParsePolicy(cfg.Runner.SecurityProfiles.Allowed, cfg.Runner.SecurityProfiles.Default)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure security profiles: %w", err)
+	}
+	executor.SetSecurityPolicy(securityPolicy)
+	restartPolicy, err := docker.ParseRestartPolicy(cfg.Runner.Docker.RestartPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure task containers: %w", err)
+	}
+	executor.SetRestartPolicy(restartPolicy)
+
+	uploaders, err := newUploaderSource(cfg.Runner.ImageExport, taskClient, clk)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid artifact upload configuration")
+		return nil, err
+	}
+	checkpointUploaders, err := artifactUploaders(cfg.Runner.ObjectStorage.CheckpointBackend, uploaders, shared.objectStore, cfg.Runner.ObjectStorage)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid checkpoint upload configuration")
+		return nil, err
+	}
+	exportUploaders, err := artifactUploaders(cfg.Runner.ObjectStorage.ImageExportBackend, uploaders, shared.objectStore, cfg.Runner.ObjectStorage)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid image export upload configuration")
+		return nil, err
+	}
+	// Migration bundles are uploaded unredacted, as redacting them would
+	// break their hash
+	migrationStore := migration.NewStore(checkpointUploaders, localCaches.inputs)
+	migrationStore.SetClock(clk)
+	taskHandler.SetMigration(migrations, migrationStore)
+	// Escrow envelopes go to IPFS whatever the checkpoint backend, as their
+	// CID is what creators and the escrow holder look them up by
+	escrowStore, err := openEscrowStore(dataDir)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to open escrow records")
+		return nil, err
+	}
+	escrower, err := escrow.NewEscrower(uploaders, svc.signer, escrowStore, cfg.Runner.Escrow.NetworkKey)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid escrow configuration")
+		return nil, err
+	}
+	escrower.SetClock(clk)
+	taskHandler.SetEscrow(escrower)
+	anomalies, err := newAnomalyDetector(cfg.Runner.Anomaly, svc.events, svc.signer)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid anomaly detection configuration")
+		return nil, err
+	}
+	if anomalies != nil {
+		anomalies.SetClock(clk)
+		taskHandler.SetAnomalyDetector(anomalies)
+	}
+	if idle := cfg.Runner.IdleWork; len(idle.Sessions) > 0 {
+		donor, err := idlework.New(idle.Sessions, idlework.Priority{Nice: idle.Nice, Sched: idle.Sched, IO: idle.IOClass})
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure idle work: %w", err)
+		}
+		donor.SetClock(clk)
+		taskHandler.SetDonor(donor)
+		log.Info().Strs("sessions", idle.Sessions).Msg("Donating idle time to community FL sessions")
+	}
+	// Chunking wraps the checkpoint uploaders only once the migration store
+	// holds them, as migration bundles are fetched whole
+	checkpointUploaders, err = chunkedUploaders(cfg.Runner.ArtifactChunking, checkpointUploaders, localCaches.artifacts)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid artifact chunking configuration")
+		return nil, err
+	}
+	if redactor != nil {
+		checkpointUploaders = redactor.Uploaders(checkpointUploaders)
+	}
+	executor.SetCheckpointUploaders(checkpointUploaders)
+	if cfg.Runner.ImageExport.Enabled {
+		executor.SetImageExporter(docker.NewImageExporter(
+			exportUploaders,
+			localCaches.artifacts,
+			docker.ImageExportLimits{
+				MaxBytes:  cfg.Runner.ImageExport.MaxMB << 20,
+				MaxLayers: cfg.Runner.ImageExport.MaxLayers,
+			},
+		))
+	}
+	previews, err := artifactPreviews(cfg.Runner.ArtifactPreview)
+	if err != nil {
+		log.Error().Err(err).Msg("Invalid artifact preview configuration")
+		return nil, err
+	}
+	if previews != nil {
+		executor.SetArtifactPreviews(*previews)
+	}
+
+	if keyring != nil {
+		sealedHistory := historyStore
+		if !primary {
+			// The primary sealed the history the instances share
+			sealedHistory = nil
+		}
+		if err := sealStores(keyring, sealedHistory, leaseStore, bundles, outbox, svc.journal, escrowStore); err != nil {
+			log.Error().Err(err).Msg("Failed to encrypt runner-local stores")
+			return nil, err
+		}
+	}
+	// The history is compacted once it can be read, sealed or not
+	if primary && historyStore != nil {
+		if dropped, err := historyStore.Compact(); err != nil {
+			log.Warn().Err(err).Msg("Failed to compact task history")
+		} else if dropped > 0 {
+			log.Info().Int("dropped", dropped).Msg("Compacted task history")
+		}
+	}
+
+	deviceID, err := utils.GetDeviceID()
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get device ID")
 		return nil, fmt.Errorf("failed to get device ID: %w", err)
@@ -102,10 +739,28 @@ func NewService(cfg *config.Config) (*Service, error) {
 
 	runnerID := uuid.New().String()
 
-	walletAddress, err := utils.GetWalletAddress()
-	if err != nil {
-		log.Error().Err(err).Msg("Failed to get wallet address")
-		return nil, fmt.Errorf("failed to get wallet address: %w", err)
+	walletAddress := svc.signer.Address().Hex()
+
+	// Pushed metrics are labelled with the machine, not an instance
+	if primary {
+		shared.metricsPusher, err = newMetricsPusher(cfg.Runner.MetricsPush, deviceID, shared, shared.httpClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure metrics push: %w", err)
+		}
+		if shared.metricsPusher != nil {
+			shared.metricsPusher.SetClock(clk)
+			svc.metricsPusher = shared.metricsPusher
+		}
+	}
+
+	if instance != nil {
+		deviceID = instance.DeviceIDFor(deviceID)
+		taskClient.SetDeviceID(deviceID)
+		executor.SetCPUSet(instance.CPUSet())
+		dockerExecutor.SetCPUSet(instance.CPUSet())
+		taskHandler.SetInstance(instance, shared.metrics)
+	} else {
+		taskHandler.SetMetrics(shared.metrics)
 	}
 
 	webhookClient := webhook.NewWebhookClient(
@@ -116,6 +771,279 @@ func NewService(cfg *config.Config) (*Service, error) {
 		deviceID,
 		walletAddress,
 	)
+	webhookClient.SetClock(clk)
+	webhookClient.SetEventBus(svc.events)
+	webhookClient.SetLLMStatsSource(svc.llmStats.Snapshot)
+	if attester.Supported() {
+		webhookClient.SetAttestationSource(registrationEvidence(taskClient, attester))
+	}
+
+	hardwareProfile := hardware.Detect()
+	if instance != nil {
+		hardwareProfile = instance.Profile(hardwareProfile)
+		webhookClient.SetInstanceSource(taskHandler.InstanceStats)
+	}
+	webhookClient.SetHardwareProfile(hardwareProfile)
+
+	svc.concurrency, err = newConcurrency(cfg.Runner.Concurrency, cfg.Runner.Docker, hardwareProfile)
+	if err != nil {
+		return nil, err
+	}
+	svc.concurrency.SetClock(clk)
+	taskHandler.SetConcurrency(svc.concurrency)
+	var hostDefault hostreserve.Demand
+	svc.host, hostDefault, err = newHostLedger(cfg.Runner.Docker, hardwareProfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure host reservations: %w", err)
+	}
+	taskHandler.SetHostLedger(svc.host, hostDefault)
+
+	trainingMemory, err := trainingMemoryBudget(cfg.Runner.FLTrainingMemory, hardwareProfile)
+	if err != nil {
+		return nil, err
+	}
+	executor.SetTrainingMemoryBudget(trainingMemory)
+	taskHandler.SetTrainingMemoryBudget(trainingMemory)
+
+	if err := disableTaskTypes(executor, cfg.Runner.DisabledTaskTypes); err != nil {
+		return nil, fmt.Errorf("failed to configure task types: %w", err)
+	}
+	var allowedTypes []models.TaskType
+	if instance != nil {
+		allowedTypes = instance.TaskTypes
+	}
+	webhookClient.SetCapabilitiesSource(func() *models.RunnerCapabilities {
+		types := unpausedTypes(shared.errorBudget, healthyTypes(shared.canaries, executor.TaskTypes()))
+		return deriveCapabilities(types, allowedTypes, hardwareProfile, attester.Supported())
+	})
+	webhookClient.SetUnsupportedSource(taskHandler.UnsupportedTasks)
+	executor.OnTaskTypesChange(func() {
+		svc.events.Publish(events.CapabilitiesChanged{Reason: "task_types"})
+	})
+	svc.capabilities = newCapabilitySyncer(webhookClient, taskClient, cfg.Runner.CapabilitySyncDebounce, clk)
+	svc.capabilities.Subscribe(svc.events)
+	if historyStore != nil {
+		instanceName := ""
+		if instance != nil {
+			instanceName = instance.Name
+		}
+		svc.reputation = newReputationTracker(historyStore, instanceName, taskClient, cfg.Runner.Reputation.ReconcileInterval, cfg.Runner.Reputation.DivergenceTolerance, clk)
+	}
+
+	// Instances pinned to GPUs place their tasks on them through the
+	// allocator, sharing or not
+	if primary && (cfg.Runner.GPU.Sharing || shared.pinsGPUs) {
+		gpuCtx, gpuCancel := context.WithTimeout(context.Background(), gpuReserveTimeout)
+		allocator, err := newGPUAllocator(gpuCtx, cfg.Runner.GPU)
+		gpuCancel()
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure GPU sharing: %w", err)
+		}
+		if allocator != nil {
+			log.Info().Int("gpus", len(allocator.Snapshot().Devices)).Msg("GPU sharing enabled")
+		} else {
+			log.Warn().Msg("GPU sharing enabled but nvidia-smi is not available")
+		}
+		shared.gpu = allocator
+	}
+	if shared.gpu != nil {
+		taskHandler.SetGPUAllocator(shared.gpu)
+		webhookClient.SetGPUSource(shared.gpu.Snapshot)
+		if customModels != nil {
+			customModels.SetMemoryBudget(modelMemoryBudget(shared.gpu))
+		}
+	}
+
+	if cfg.Runner.Power.Enabled {
+		monitor, action, err := newPowerMonitor(cfg.Runner.Power)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure power-aware scheduling: %w", err)
+		}
+		monitor.SetClock(clk)
+		taskHandler.SetPowerMonitor(monitor, action)
+		webhookClient.SetPowerSource(monitor.State)
+		log.Info().Str("in_flight", string(action)).Msg("Power-aware scheduling enabled")
+	}
+
+	if svc.errorReporter != nil {
+		webhookClient.SetErrorReporter(svc.errorReporter)
+		log.Info().
+			Str("mode", cfg.Runner.ErrorReporting.Mode).
+			Strs("categories", cfg.Runner.ErrorReporting.Categories).
+			Msg("Error reporting enabled")
+	}
+
+	if primary {
+		shared.budget, err = newComputeBudget(dataDir, cfg.Runner.Budget)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure compute budget: %w", err)
+		}
+		if shared.budget != nil {
+			shared.budget.SetClock(clk)
+		}
+	}
+	if primary {
+		shared.clockInfo = newClockReporter(cfg.Runner.NTPServer, cfg.Runner.NTPInterval, clk)
+		svc.clockInfo = shared.clockInfo
+	}
+	taskHandler.SetClockReporter(shared.clockInfo)
+
+	// The instances share one record of poisoned tasks, so that none of
+	// them claims a task another abandoned
+	if primary {
+		shared.poison, err = newPoisonTracker(cfg.Runner.Poison, dataDir, deviceID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure poison detection: %w", err)
+		}
+		shared.poison.SetClock(clk)
+		if cfg.Runner.Poison.StoreMode != "" {
+			log.Info().Str("mode", cfg.Runner.Poison.StoreMode).Msg("Fleet poison detection enabled")
+		}
+	}
+	taskHandler.SetPoisonTracker(shared.poison)
+
+	// The instances share one list of revocations, which the primary
+	// fetches and each enforces on its own tasks
+	if primary {
+		shared.revocations, shared.revocationAudit, svc.revocationFeed, err = newRevocations(cfg.Runner.Revocation, cfg.Runner.ServerURL, dataDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure revocations: %w", err)
+		}
+		if shared.revocations != nil {
+			shared.revocations.SetClock(clk)
+			log.Info().Int("active", len(shared.revocations.Active())).Msg("Emergency revocations enabled")
+		}
+	}
+	taskHandler.SetRevocations(shared.revocations, shared.revocationAudit)
+
+	if primary {
+		shared.confirm, err = newConfirmGate(cfg.Runner.Interactive, clk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure interactive mode: %w", err)
+		}
+	}
+	// The instances share the operator, who is asked one task at a time
+	if shared.confirm != nil {
+		taskHandler.SetConfirmation(shared.confirm)
+		log.Info().
+			Str("prompt", cfg.Runner.Interactive.Prompt).
+			Dur("max_duration", cfg.Runner.Interactive.MaxDuration).
+			Str("max_disk", cfg.Runner.Interactive.MaxDisk).
+			Bool("confirm_gpu", cfg.Runner.Interactive.ConfirmGPU).
+			Msg("Interactive mode enabled")
+	}
+	svc.pricing, err = pricing.NewGate(pricingRates(cfg.Runner.Pricing))
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure pricing: %w", err)
+	}
+	taskHandler.SetPricing(svc.pricing)
+	if rates := svc.pricing.Status().Rates; rates != (models.PricingRates{}) {
+		log.Info().
+			Float64("cpu_hour", rates.CPUHour).
+			Float64("gpu_hour", rates.GPUHour).
+			Float64("gb_ram_hour", rates.GBRAMHour).
+			Msg("Price floor enabled")
+	}
+	// The instances' hours count against one budget for the host
+	if computeBudget := shared.budget; computeBudget != nil {
+		taskHandler.SetBudget(computeBudget)
+		webhookClient.SetBudgetSource(computeBudget.Status)
+		log.Info().
+			Float64("daily_cpu_hours", cfg.Runner.Budget.DailyCPUHours).
+			Float64("daily_gpu_hours", cfg.Runner.Budget.DailyGPUHours).
+			Float64("weekly_cpu_hours", cfg.Runner.Budget.WeeklyCPUHours).
+			Float64("weekly_gpu_hours", cfg.Runner.Budget.WeeklyGPUHours).
+			Msg("Compute budget enabled")
+	}
+
+	if cfg.Runner.ExecutionGuard.Enabled {
+		// Runners sharing a host share its device ID, so the webhook port
+		// tells them apart
+		holder := fmt.Sprintf("%s:%d", deviceID, cfg.Runner.WebhookPort)
+		guard, err := newExecutionGuard(cfg.Runner.ExecutionGuard, holder)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure execution guard: %w", err)
+		}
+		guard.SetClock(clk)
+		taskHandler.SetExecutionGuard(guard)
+		webhookClient.SetExecutionGuardSource(guard.Stats)
+		log.Info().Str("mode", cfg.Runner.ExecutionGuard.Mode).Msg("Execution guard enabled")
+	}
+
+	if cfg.Runner.FleetHints.Enabled {
+		board, err := newHintBoard(cfg.Runner.FleetHints, svc.signer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure fleet hints: %w", err)
+		}
+		board.SetClock(clk)
+		taskHandler.SetHintBoard(board)
+		webhookClient.SetFleetHintSource(board.Stats)
+		log.Info().
+			Str("mode", cfg.Runner.FleetHints.Mode).
+			Int("members", len(cfg.Runner.FleetHints.Members)).
+			Msg("Fleet hints enabled")
+	}
+
+	webhookClient.SetDeletionHandler(purger)
+
+	if auditor != nil {
+		webhookClient.SetAuditHandler(taskHandler)
+		log.Info().
+			Dur("retention", cfg.Runner.Audit.Retention).
+			Int("max_bundles", cfg.Runner.Audit.MaxBundles).
+			Msg("Audit mode enabled")
+	}
+
+	overlays, err := newOverlayManager(cfg, deviceID, overlayStatePath(dataDir, instance), webhookClient, taskHandler, clk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure fleet overlays: %w", err)
+	}
+	if overlays != nil {
+		webhookClient.SetOverlayHandler(overlays)
+		log.Info().Msg("Fleet configuration overlays enabled")
+	}
+	log.Info().
+		Str("accelerator", string(hardwareProfile.Accelerator)).
+		Str("cpu_model", hardwareProfile.CPUModel).
+		Bool("unified_memory", hardwareProfile.UnifiedMemory).
+		Uint64("total_memory_bytes", hardwareProfile.TotalMemoryBytes).
+		Msg("Detected hardware profile")
+
+	if primary && cfg.Runner.Bandwidth.Enabled {
+		svc.bandwidthProbe = hardware.NewBandwidthProbe(hardware.BandwidthProbeConfig{
+			Endpoint:      cfg.Runner.Bandwidth.Endpoint,
+			DownloadBytes: cfg.Runner.Bandwidth.ProbeMB << 20,
+		})
+		svc.bandwidthProbe.SetClock(clk)
+	}
+
+	svc.dataDir = filepath.Join(homeDir, utils.KeystoreDirName)
+	healthChecker := health.NewChecker(
+		health.Config{
+			ReadyHeartbeats:  cfg.Runner.Health.ReadyHeartbeats,
+			DiskPath:         svc.dataDir,
+			MinFreeDiskBytes: uint64(cfg.Runner.Health.MinFreeDiskMB) * 1024 * 1024,
+		},
+		webhookClient.Heartbeat(),
+		func() error {
+			_, err := utils.GetDeviceID()
+			return err
+		},
+		health.RuntimeCheck{
+			Name: "docker",
+			Check: func(ctx context.Context) error {
+				_, err := dockerClient.ServerVersion(ctx)
+				return err
+			},
+		},
+	)
+	healthChecker.SetClock(clk)
+	webhookClient.SetHealthChecker(healthChecker)
+	webhookClient.SetCacheRegistry(svc.caches)
+	if shared.blobs != nil && cfg.Runner.Registry.ServeBlobs {
+		webhookClient.SetBlobServer(shared.blobs)
+	}
+	webhookClient.SetStatusController(svc)
 
 	// Initialize tunnel client if enabled
 	var tunnelClient *tunnel.TunnelClient
@@ -157,6 +1085,10 @@ func NewService(cfg *config.Config) (*Service, error) {
 	svc.taskHandler = taskHandler
 	svc.taskClient = taskClient
 	svc.dockerExecutor = dockerExecutor
+	svc.healthChecker = healthChecker
+	svc.notifier = health.NewNotifierFromEnv()
+	svc.notifier.SetClock(clk)
+	shared.services = append(shared.services, svc)
 
 	log.Info().
 		Str("server_url", cfg.Runner.ServerURL).
@@ -177,20 +1109,91 @@ func (s *Service) SetModelCapabilities(models []llm.ModelInfo) error {
 		return fmt.Errorf("webhook client not initialized")
 	}
 
+	log := gologger.WithComponent("runner")
+	profile := hardware.Detect()
+
 	// Convert llm.ModelInfo to webhook.ModelCapabilityInfo
 	capabilities := make([]webhook.ModelCapabilityInfo, len(models))
 	for i, model := range models {
+		fits := profile.FitsModel(uint64(model.SizeBytes))
+		if !fits {
+			log.Warn().
+				Str("model", model.Name).
+				Int64("size_bytes", model.SizeBytes).
+				Uint64("memory_budget_bytes", profile.ModelMemoryBudget()).
+				Msg("Model exceeds available model memory budget")
+		}
+		if s.llmStats != nil {
+			s.llmStats.SetLoaded(model.Name, model.IsLoaded)
+		}
 		capabilities[i] = webhook.ModelCapabilityInfo{
-			ModelName: model.Name,
-			IsLoaded:  model.IsLoaded,
-			MaxTokens: model.MaxTokens,
+			ModelName:  model.Name,
+			IsLoaded:   model.IsLoaded,
+			MaxTokens:  model.MaxTokens,
+			SizeBytes:  model.SizeBytes,
+			FitsMemory: fits,
 		}
 	}
 
-	s.webhookClient.SetModelCapabilities(capabilities)
+	s.modelsMu.Lock()
+	s.servedModels = capabilities
+	s.modelsMu.Unlock()
+	s.advertiseModels()
+	s.events.Publish(events.CapabilitiesChanged{Reason: "models"})
+	s.holdServedModels(models)
+	s.warmServedModels(models)
 	return nil
 }
 
+// advertiseModels advertises the models the runner serves, leaving out
+// those whose canary keeps failing
+func (s *Service) advertiseModels() {
+	s.webhookClient.SetModelCapabilities(s.advertisedModels())
+}
+
+func (s *Service) advertisedModels() []webhook.ModelCapabilityInfo {
+	s.modelsMu.Lock()
+	defer s.modelsMu.Unlock()
+	advertised := make([]webhook.ModelCapabilityInfo, 0, len(s.servedModels))
+	for _, model := range s.servedModels {
+		if !s.canaries.Degraded(canary.Model(llm.CanonicalModelName(model.ModelName))) {
+			advertised = append(advertised, model)
+		}
+	}
+	return advertised
+}
+
+// loadedModels returns the names of the served models that are loaded
+func (s *Service) loadedModels() []string {
+	s.modelsMu.Lock()
+	defer s.modelsMu.Unlock()
+	var names []string
+	for _, model := range s.servedModels {
+		if model.IsLoaded {
+			names = append(names, model.ModelName)
+		}
+	}
+	return names
+}
+
+// holdServedModels keeps the models the runner serves from being purged or
+// evicted while it runs
+func (s *Service) holdServedModels(served []llm.ModelInfo) {
+	if s.caches == nil {
+		return
+	}
+	refs := make([]caches.Ref, 0, len(served))
+	for _, model := range served {
+		refs = append(refs, caches.Ref{Cache: caches.Models, Key: llm.CanonicalModelName(model.Name)})
+	}
+
+	release := s.caches.Acquire(context.Background(), refs...)
+	if s.releaseModels != nil {
+		s.releaseModels()
+	}
+	s.releaseModels = release
+}
+
 func (s *Service) SetupWithDeviceID(deviceID string) error {
 	log := gologger.WithComponent("runner")
 
@@ -203,9 +1206,44 @@ func (s *Service) SetupWithDeviceID(deviceID string) error {
 	return nil
 }
 
-func (s *Service) Start() error {
+// setNetworkProfile advertises the bandwidth the primary measured for every
+// instance sharing the host's network
+func (s *Service) setNetworkProfile(profile *hardware.NetworkProfile) {
+	for _, svc := range s.shared.services {
+		if handler, ok := svc.taskHandler.(*DefaultTaskHandler); ok {
+			handler.SetNetworkProfile(profile)
+		}
+		if svc.webhookClient != nil {
+			svc.webhookClient.SetNetworkProfile(profile)
+		}
+		svc.events.Publish(events.CapabilitiesChanged{Reason: "network"})
+	}
+}
+
+// AdoptHandoff makes Start take over the tasks and webhook socket handed
+// over by the runner process this one replaced
+func (s *Service) AdoptHandoff(handoff *Handoff) {
+	s.handoff = handoff
+	if s.webhookClient != nil && handoff.Listener != nil {
+		s.webhookClient.SetListener(handoff.Listener)
+	}
+}
+
+func (s *Service) Start() (err error) {
 	log := gologger.WithComponent("runner")
 
+	if s.handoff != nil {
+		defer func() {
+			if ackErr := s.handoff.Ack(err); ackErr != nil {
+				log.Warn().Err(ackErr).Msg("Failed to acknowledge handoff to previous runner process")
+			}
+		}()
+	}
+
+	healthCtx, healthCancel := context.WithCancel(context.Background())
+	s.healthCancel = healthCancel
+	go s.healthChecker.Run(healthCtx)
+
 	// Start tunnel if enabled and wait for it to be ready
 	log.Info().
 		Bool("tunnel_client_exists", s.tunnelClient != nil).
@@ -232,12 +1270,71 @@ func (s *Service) Start() error {
 				Msg("Tunnel established successfully")
 
 			// Small delay to ensure tunnel is fully stable
-			time.Sleep(2 * time.Second)
+			s.clock.Sleep(2 * time.Second)
 		}
 	} else {
 		log.Info().Msg("No tunnel client - proceeding with localhost webhook")
 	}
 
+	if s.bandwidthProbe != nil {
+		// Measure before registering so the first capability profile includes bandwidth
+		profile, err := s.bandwidthProbe.Measure(healthCtx)
+		if err != nil {
+			log.Warn().Err(err).Msg("Bandwidth measurement failed - registering without network figures")
+		} else {
+			hardware.LogNetworkProfile(profile)
+			s.setNetworkProfile(profile)
+		}
+		go s.bandwidthProbe.RunDaily(healthCtx, s.setNetworkProfile)
+	}
+
+	if s.primary && s.errorReporter != nil {
+		go s.errorReporter.Run(healthCtx)
+	}
+	if s.primary && s.metricsPusher != nil {
+		go s.metricsPusher.Run(healthCtx)
+	}
+	if s.primary && s.clockInfo != nil {
+		go s.clockInfo.Run(healthCtx)
+	}
+	if s.primary && s.canaries != nil && s.cfg.Runner.Canary.Enabled {
+		go s.canaries.Run(healthCtx, s.cfg.Runner.Canary.Interval)
+	}
+	if s.primary && s.errorBudget != nil {
+		go s.errorBudget.Run(healthCtx, errorBudgetResumeInterval)
+	}
+	if s.capabilities != nil {
+		go s.capabilities.Run(healthCtx)
+	}
+	if s.reputation != nil {
+		go s.reputation.Run(healthCtx)
+	}
+	if s.primary && s.mirrors != nil {
+		go s.mirrors.Run(healthCtx, s.cfg.Runner.Registry.CheckInterval)
+	}
+
+	if s.primary && s.caches != nil && s.cfg.Runner.Cache.BudgetGB > 0 {
+		go s.caches.Run(healthCtx, s.cfg.Runner.Cache.RebalanceInterval)
+	}
+
+	if handler, ok := s.taskHandler.(*DefaultTaskHandler); ok {
+		if s.primary {
+			// One watcher evicts from the shared GPUs whichever instance's
+			// task overcommits them
+			go handler.WatchGPU(healthCtx, s.cfg.Runner.GPU.PollInterval)
+		}
+		go handler.WatchPower(healthCtx, s.cfg.Runner.Power.PollInterval)
+		go handler.WatchRevocations(healthCtx, s.revocationFeed, s.cfg.Runner.Revocation.PollInterval)
+		if !s.cfg.Runner.Concurrency.Pinned {
+			go handler.WatchConcurrency(healthCtx, s.cfg.Runner.Concurrency.SampleInterval)
+		}
+		go handler.WatchAudit(healthCtx, s.cfg.Runner.Audit.CommitInterval)
+	}
+	// Pending results are reported from the outbox before the purger may
+	// drop them
+	s.reconcileAccounting()
+	go s.purger.Run(healthCtx, s.cfg.Runner.Retention.PurgeInterval)
+
 	if s.webhookClient != nil {
 		s.webhookClient.SetHeartbeatInterval(s.heartbeatInterval)
 
@@ -254,6 +1351,20 @@ func (s *Service) Start() error {
 			return err
 		}
 
+		s.resumeLeasedTasks()
+
+		if s.taskSocket != nil {
+			go s.taskSocket.Run(healthCtx)
+			go s.dispatchSocketTasks(healthCtx)
+		}
+
+		if s.primary && s.notifier.Enabled() {
+			if err := s.notifier.Notify(health.NotifyReady); err != nil {
+				log.Warn().Err(err).Msg("Failed to notify systemd of readiness")
+			}
+			go s.notifier.RunWatchdog(healthCtx, s.healthChecker)
+		}
+
 		finalWebhookURL := utils.GetWebhookURL()
 		log.Info().
 			Str("final_webhook_url", finalWebhookURL).
@@ -267,10 +1378,187 @@ func (s *Service) Start() error {
 	return nil
 }
 
+// dispatchSocketTasks hands each task offered over the task socket to the
+// task handler, as the webhook does with the tasks pushed to it. No more
+// tasks are handed out at once than the concurrency controller lets run;
+// the rest stay queued on the socket until one finishes.
+func (s *Service) dispatchSocketTasks(ctx context.Context) {
+	log := gologger.WithComponent("runner")
+	var running atomic.Int64
+	finished := make(chan struct{}, 1)
+	for {
+		for running.Load() >= int64(s.concurrency.Limit()) {
+			select {
+			case <-ctx.Done():
+				return
+			case <-finished:
+			}
+		}
+		task, err := s.taskSocket.NextTask(ctx)
+		if err != nil {
+			return
+		}
+		log.Info().
+			Str("id", task.ID.String()).
+			Str("type", string(task.Type)).
+			Float64("reward", task.Reward).
+			Msg("Processing task from task socket")
+		running.Add(1)
+		go func() {
+			defer func() {
+				running.Add(-1)
+				select {
+				case finished <- struct{}{}:
+				default:
+				}
+			}()
+			if err := s.taskHandler.HandleTask(task); err != nil {
+				log.Error().Err(err).
+					Str("id", task.ID.String()).
+					Str("type", string(task.Type)).
+					Msg("Task processing failed")
+			}
+		}()
+	}
+}
+
+// resumeLeasedTasks decides, for every task handed over by the previous
+// runner process and every lease persisted before the last shutdown, whether
+// the runner still owns the task. Tasks whose lease can be renewed are
+// resumed, re-attaching to their container when it was left running; all
+// others are abandoned to the server.
+func (s *Service) resumeLeasedTasks() {
+	log := gologger.WithComponent("runner")
+
+	handler, ok := s.taskHandler.(*DefaultTaskHandler)
+	if !ok {
+		return
+	}
+
+	var candidates []HandoffTask
+	handedOff := make(map[string]bool)
+	if s.handoff != nil {
+		for _, task := range s.handoff.State.Tasks {
+			candidates = append(candidates, task)
+			handedOff[task.Task.ID.String()] = true
+		}
+	}
+	if s.leaseStore != nil && handler.leases != nil {
+		records, err := s.leaseStore.Load()
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to load persisted task leases")
+		}
+		for _, record := range records {
+			if !handedOff[record.Lease.TaskID] {
+				candidates = append(candidates, HandoffTask{Task: record.Task, Lease: record.Lease})
+			}
+		}
+	}
+
+	abandon := func(candidate HandoffTask, reason string, err error) {
+		taskID := candidate.Task.ID.String()
+		log.Info().Err(err).Str("task_id", taskID).Msg("Abandoning task - " + reason)
+		handler.discardHandoff(candidate)
+		if s.leaseStore != nil {
+			if err := s.leaseStore.Delete(taskID); err != nil {
+				log.Warn().Err(err).Str("task_id", taskID).Msg("Failed to remove stale lease")
+			}
+		}
+	}
+
+	// The handler executes one task at a time, so resumable tasks run in sequence
+	var resumable []HandoffTask
+	for _, candidate := range candidates {
+		if candidate.Task.Type == models.TaskTypeLLM {
+			abandon(candidate, "LLM tasks cannot be resumed", nil)
+			continue
+		}
+		if candidate.Lease == nil || handler.leases == nil {
+			resumable = append(resumable, candidate)
+			continue
+		}
+		if !canResumeLease(candidate.Lease, s.clock.Now()) {
+			abandon(candidate, "lease expired while runner was offline", nil)
+			continue
+		}
+
+		renewed, err := handler.leases.client.RenewLease(candidate.Lease)
+		if err != nil {
+			abandon(candidate, "lease could not be renewed", err)
+			continue
+		}
+		candidate.Lease = renewed
+		resumable = append(resumable, candidate)
+	}
+
+	if len(resumable) == 0 {
+		return
+	}
+
+	go func() {
+		for _, candidate := range resumable {
+			if err := handler.AdoptTask(candidate); err != nil {
+				log.Error().Err(err).Str("task_id", candidate.Task.ID.String()).Msg("Failed to resume task")
+			}
+		}
+	}()
+}
+
+// ShutdownTimeout is how long stopping a runner configured by cfg may take:
+// the time tasks in flight are given to finish, then the default timeout
+// for the rest
+func ShutdownTimeout(cfg *config.Config) time.Duration {
+	drain := cfg.Runner.Concurrency.DrainTimeout
+	if cfg.Runner.Migration.Enabled {
+		drain = cfg.Runner.Migration.DrainTimeout
+	}
+	return drain + utils.DefaultTimeout
+}
+
 func (s *Service) Stop(ctx context.Context) error {
+	return s.stop(ctx, true)
+}
+
+// stop stops the service, first waiting for the tasks in flight to finish
+// when drain is set
+func (s *Service) stop(ctx context.Context, drain bool) error {
 	log := gologger.WithComponent("runner")
 	log.Info().Msg("Stopping runner service...")
 
+	// Requests still retrying when the shutdown deadline passes are
+	// abandoned; the results among them stay in the outbox to be reported
+	// by the next run
+	if s.stopRequests != nil {
+		defer context.AfterFunc(ctx, s.stopRequests)()
+	}
+
+	s.healthChecker.SetDraining(true)
+	if s.taskSocket != nil {
+		if err := s.taskSocket.Close(ctx); err != nil {
+			log.Warn().Err(err).Msg("Failed to close task socket")
+		}
+	}
+	if handler, ok := s.taskHandler.(*DefaultTaskHandler); ok {
+		handler.SetDraining(true)
+		if s.cfg != nil && s.cfg.Runner.Migration.Enabled {
+			drainCtx, cancel := context.WithTimeout(ctx, s.cfg.Runner.Migration.DrainTimeout)
+			handler.Migrate(drainCtx)
+			cancel()
+		} else if s.cfg != nil && drain && handler.IsProcessing() {
+			log.Info().Int("tasks", handler.tasksInFlight()).Msg("Waiting for tasks in flight to finish")
+			drainCtx, cancel := context.WithTimeout(ctx, s.cfg.Runner.Concurrency.DrainTimeout)
+			if running := handler.Drain(drainCtx); running > 0 {
+				log.Warn().Int("tasks", running).Msg("Drain timed out - leaving tasks to their leases")
+			}
+			cancel()
+		}
+	}
+	if s.primary {
+		if err := s.notifier.Notify(health.NotifyStopping); err != nil {
+			log.Warn().Err(err).Msg("Failed to notify systemd of shutdown")
+		}
+	}
+
 	done := make(chan error, 1)
 	go func() {
 		var err error
@@ -294,7 +1582,26 @@ func (s *Service) Stop(ctx context.Context) error {
 			}
 		}
 
-		if s.dockerClient != nil {
+		if s.healthCancel != nil {
+			s.healthCancel()
+		}
+
+		// Events already published are delivered before the runner exits,
+		// except to a hook still running
+		if s.stopHook != nil {
+			s.stopHook()
+		}
+		s.events.Close()
+
+		if s.signer != nil {
+			if closeErr := s.signer.Close(); closeErr != nil {
+				log.Warn().Err(closeErr).Msg("Failed to close wallet signer")
+			}
+		}
+
+		// The primary is stopped last, so it closes the client the
+		// instances share
+		if s.primary && s.dockerClient != nil {
 			if closeErr := s.dockerClient.Close(); closeErr != nil {
 				log.Error().Err(closeErr).Msg("Failed to close Docker client")
 				if err == nil {
@@ -305,6 +1612,11 @@ func (s *Service) Stop(ctx context.Context) error {
 			}
 		}
 
+		// Released last, once nothing more is written to the data directory
+		if releaseErr := s.dataLock.Release(); releaseErr != nil {
+			log.Warn().Err(releaseErr).Msg("Failed to release data directory lock")
+		}
+
 		done <- err
 	}()
 
@@ -0,0 +1,45 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/theblitlabs/gologger"
+	"github.com/theblitlabs/keystore"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/signer"
+	"github.com/theblitlabs/parity-runner/internal/tenancy"
+)
+
+// newWalletSigner returns the signer of the runner's wallet: the external
+// signer cfg names, or else the key in the keystore, sealed in locked
+// memory. Instances with a keystore of their own sign with its key.
+func newWalletSigner(cfg config.SignerConfig, homeDir string, instance *tenancy.Instance) (signer.Signer, error) {
+	log := gologger.WithComponent("runner")
+	if cfg.Command != "" && (instance == nil || instance.Keystore == "") {
+		remote, err := signer.StartRemote(strings.Fields(cfg.Command), cfg.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		log.Info().Str("address", remote.Address().Hex()).Msg("Signing with external signer")
+		return remote, nil
+	}
+
+	ks, err := keystore.NewKeystore(keystoreConfig(homeDir, instance))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create keystore: %w", err)
+	}
+	privateKey, err := ks.LoadPrivateKey()
+	if err != nil {
+		return nil, fmt.Errorf("no private key found - please authenticate first using 'parity auth': %w", err)
+	}
+	local, err := signer.FromECDSA(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to seal private key: %w", err)
+	}
+	if !local.Locked() {
+		log.Warn().Msg("Could not lock wallet key memory - it may be swapped to disk; raise the memlock limit (ulimit -l)")
+	}
+	return local, nil
+}
@@ -0,0 +1,300 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/health"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+	"github.com/theblitlabs/parity-runner/internal/status"
+)
+
+// recentTasks is the number of finished tasks the status API reports
+const recentTasks = 20
+
+// logLevels are the levels the operator and fleet overlays may set
+var logLevels = []string{"trace", "debug", "info", "warn", "error"}
+
+var _ status.Controller = (*Service)(nil)
+
+// statusTracker follows the events on a service's bus to report what its
+// tasks are doing
+type statusTracker struct {
+	clock clock.Clock
+
+	mu       sync.Mutex
+	running  map[string]*models.RunningTask
+	recent   []models.FinishedTask
+	counters models.TaskCounters
+	sessions map[string]*models.FLSessionStatus
+}
+
+func newStatusTracker(clk clock.Clock) *statusTracker {
+	return &statusTracker{
+		clock:    clk,
+		running:  make(map[string]*models.RunningTask),
+		sessions: make(map[string]*models.FLSessionStatus),
+		counters: models.TaskCounters{
+			DeclineReasons: make(map[models.FLDeclineReason]uint64),
+			SkipReasons:    make(map[models.SkipReason]uint64),
+		},
+	}
+}
+
+func (t *statusTracker) handle(e events.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch e := e.(type) {
+	case events.TaskClaimed:
+		t.counters.Claimed++
+		t.running[e.TaskID] = &models.RunningTask{
+			TaskID:    e.TaskID,
+			Type:      e.Type,
+			Instance:  e.Instance,
+			StartedAt: e.ClaimedAt,
+			Timeout:   e.Timeout,
+		}
+		if e.Task != nil {
+			t.running[e.TaskID].Workload = workloadKey(e.Task)
+		}
+		t.trackSession(e.Task, models.TaskStatusRunning, e.ClaimedAt)
+	case events.TaskProgress:
+		running, ok := t.running[e.TaskID]
+		if !ok {
+			// Tasks adopted from a previous runner process report progress
+			// without having been claimed by this one
+			running = &models.RunningTask{TaskID: e.TaskID, StartedAt: t.clock.Now()}
+			t.running[e.TaskID] = running
+		}
+		running.Progress = e.Progress
+	case events.TaskDeclined:
+		t.counters.Declined++
+		t.counters.DeclineReasons[e.Reason]++
+	case events.TaskSkipped:
+		t.counters.Skipped++
+		t.counters.SkipReasons[e.Reason]++
+	case events.TaskCompleted:
+		delete(t.running, e.TaskID)
+		if e.Status == models.TaskStatusCompleted {
+			t.counters.Completed++
+		} else {
+			t.counters.Failed++
+		}
+		now := t.clock.Now()
+		finished := models.FinishedTask{
+			TaskID:     e.TaskID,
+			Type:       e.Type,
+			Instance:   e.Instance,
+			Status:     e.Status,
+			FinishedAt: now,
+			Duration:   e.Duration,
+		}
+		finished.Reward = taskReward(e.Task, e.Result)
+		t.recent = append([]models.FinishedTask{finished}, t.recent...)
+		if len(t.recent) > recentTasks {
+			t.recent = t.recent[:recentTasks]
+		}
+		t.trackSession(e.Task, e.Status, now)
+	case events.LeaseLost:
+		delete(t.running, e.TaskID)
+	}
+}
+
+// trackSession records the round an FL task belongs to as its session's
+// latest
+func (t *statusTracker) trackSession(task *models.Task, taskStatus models.TaskStatus, at time.Time) {
+	if task == nil || task.Type != models.TaskTypeFederatedLearning {
+		return
+	}
+	var config flRoundConfig
+	if err := safejson.Unmarshal(task.Config, &config); err != nil || config.SessionID == "" {
+		return
+	}
+	t.sessions[config.SessionID] = &models.FLSessionStatus{
+		SessionID: config.SessionID,
+		RoundID:   config.RoundID,
+		TaskID:    task.ID.String(),
+		Status:    taskStatus,
+		UpdatedAt: at,
+	}
+}
+
+// snapshot returns the tasks, counters and FL sessions tracked so far
+func (t *statusTracker) snapshot() *models.LocalStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := &models.LocalStatus{
+		Tasks:  make([]models.RunningTask, 0, len(t.running)),
+		Recent: slices.Clone(t.recent),
+	}
+	for _, running := range t.running {
+		s.Tasks = append(s.Tasks, *running)
+	}
+	sort.Slice(s.Tasks, func(i, j int) bool { return s.Tasks[i].StartedAt.Before(s.Tasks[j].StartedAt) })
+	if s.Recent == nil {
+		s.Recent = []models.FinishedTask{}
+	}
+
+	s.Counters = t.counters
+	s.Counters.DeclineReasons = make(map[models.FLDeclineReason]uint64, len(t.counters.DeclineReasons))
+	for reason, count := range t.counters.DeclineReasons {
+		s.Counters.DeclineReasons[reason] = count
+	}
+	s.Counters.SkipReasons = make(map[models.SkipReason]uint64, len(t.counters.SkipReasons))
+	for reason, count := range t.counters.SkipReasons {
+		s.Counters.SkipReasons[reason] = count
+	}
+
+	for _, session := range t.sessions {
+		s.FLSessions = append(s.FLSessions, *session)
+	}
+	sort.Slice(s.FLSessions, func(i, j int) bool { return s.FLSessions[i].UpdatedAt.After(s.FLSessions[j].UpdatedAt) })
+	return s
+}
+
+// Status reports what the runner is doing, for the status API
+func (s *Service) Status(ctx context.Context) (*models.LocalStatus, error) {
+	st := s.status.snapshot()
+	st.DeviceID = s.deviceID
+	st.Time = s.clock.Now()
+	st.LogLevel = zerolog.GlobalLevel().String()
+	if s.instance != nil {
+		st.Instance = s.instance.Name
+	}
+	if handler, ok := s.taskHandler.(*DefaultTaskHandler); ok {
+		st.Draining = handler.draining.Load()
+		st.Paused = handler.paused.Load()
+		st.Anomalies = handler.anomalies.Status()
+		st.Donation = handler.donor.Status()
+	}
+	reputation, err := s.reputation.Report()
+	if err != nil {
+		log := gologger.WithComponent("reputation")
+		log.Warn().Err(err).Msg("Failed to compute runner reputation")
+	}
+	st.Reputation = reputation
+	st.Canaries = s.canaries.Status()
+	st.ErrorBudgets = s.errorBudget.Status()
+	st.Concurrency = s.concurrency.Status()
+	if st.Concurrency != nil && s.host != nil {
+		stats := s.host.Stats()
+		st.Concurrency.Reserved = &models.HostReservation{
+			MemoryBytes:         stats.Committed.MemoryBytes,
+			MemoryCapacityBytes: stats.Capacity.MemoryBytes,
+			CPUShares:           stats.Committed.CPUShares,
+			CPUCapacityShares:   stats.Capacity.CPUShares,
+			Reservations:        stats.Reservations,
+			Rejected:            stats.Rejected,
+		}
+	}
+	if s.pricing != nil {
+		pricing := s.pricing.Status()
+		st.Pricing = &pricing
+	}
+
+	if s.caches != nil {
+		summaries, err := s.caches.Summaries(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to summarize caches: %w", err)
+		}
+		for _, summary := range summaries {
+			st.Caches = append(st.Caches, models.CacheUsage{
+				Name:       summary.Name,
+				Entries:    summary.Entries,
+				UsageBytes: summary.UsageBytes,
+				QuotaBytes: summary.QuotaBytes,
+				Error:      summary.Error,
+			})
+		}
+	}
+	if s.disk != nil {
+		stats := s.disk.Stats()
+		st.Disk = &models.DiskUsage{
+			Path:          stats.Path,
+			FreeBytes:     stats.FreeBytes,
+			ReservedBytes: stats.ReservedBytes,
+			Reservations:  stats.Reservations,
+			Rejected:      stats.Rejected,
+		}
+	} else if s.dataDir != "" {
+		if free, err := health.FreeDiskBytes(s.dataDir); err == nil {
+			st.Disk = &models.DiskUsage{Path: s.dataDir, FreeBytes: free}
+		}
+	}
+
+	st.Server.Breaker = models.BreakerClosed
+	if s.webhookClient != nil {
+		if hb := s.webhookClient.Heartbeat(); hb != nil {
+			st.Server.LastHeartbeat = hb.LastSuccess()
+			st.Server.ConsecutiveFailures = hb.ConsecutiveFailures()
+			if st.Server.ConsecutiveFailures > 0 {
+				st.Server.Breaker = models.BreakerOpen
+			}
+		}
+	}
+	return st, nil
+}
+
+// SetDraining stops the runner claiming new tasks and reports it not ready,
+// so it can be stopped once its tasks finish, or undoes it
+func (s *Service) SetDraining(draining bool) {
+	log := gologger.WithComponent("runner")
+	s.healthChecker.SetDraining(draining)
+	if handler, ok := s.taskHandler.(*DefaultTaskHandler); ok {
+		handler.SetDraining(draining)
+	}
+	log.Info().Bool("draining", draining).Msg("Operator changed draining")
+}
+
+// SetPaused stops the runner claiming new tasks, or resumes claiming
+func (s *Service) SetPaused(paused bool) {
+	log := gologger.WithComponent("runner")
+	if handler, ok := s.taskHandler.(*DefaultTaskHandler); ok {
+		handler.SetPaused(paused)
+	}
+	log.Info().Bool("paused", paused).Msg("Operator changed pausing")
+}
+
+// Shutdown drains the runner and signals ShutdownRequested, on which the
+// process stops the runner as it would on SIGTERM
+func (s *Service) Shutdown() {
+	log := gologger.WithComponent("runner")
+	s.shutdownOnce.Do(func() {
+		log.Info().Msg("Operator asked the runner to exit")
+		s.SetDraining(true)
+		close(s.shutdown)
+	})
+}
+
+// ShutdownRequested is closed once the operator asked the runner to exit
+func (s *Service) ShutdownRequested() <-chan struct{} {
+	return s.shutdown
+}
+
+// SetLogLevel changes the level the runner logs at
+func (s *Service) SetLogLevel(level string) error {
+	level = strings.ToLower(strings.TrimSpace(level))
+	if !slices.Contains(logLevels, level) {
+		return fmt.Errorf("%w %q (supported: %s)", status.ErrInvalidLogLevel, level, strings.Join(logLevels, ", "))
+	}
+	parsed, err := zerolog.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("%w %q: %v", status.ErrInvalidLogLevel, level, err)
+	}
+	zerolog.SetGlobalLevel(parsed)
+	log := gologger.WithComponent("runner")
+	log.Info().Str("level", level).Msg("Operator changed log level")
+	return nil
+}
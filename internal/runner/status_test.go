@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/events"
+)
+
+func TestStatusTrackerFollowsTasks(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	clk := clocktest.NewFake(start)
+	tracker := newStatusTracker(clk)
+
+	flTask := newFLTask(t, map[string]interface{}{"session_id": "session-1", "round_id": "round-3"})
+	flTask.Reward = 2.5
+	docker := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker}
+
+	tracker.handle(events.TaskClaimed{Task: flTask, TaskID: flTask.ID.String(), Type: flTask.Type, ClaimedAt: start, Timeout: time.Hour})
+	tracker.handle(events.TaskClaimed{Task: docker, TaskID: docker.ID.String(), Type: docker.Type, ClaimedAt: start.Add(time.Minute)})
+	tracker.handle(events.TaskProgress{TaskID: flTask.ID.String(), Progress: &models.TaskProgress{Percent: 40}})
+	tracker.handle(events.TaskDeclined{TaskID: uuid.NewString(), Reason: models.FLDeclineAtCapacity})
+	tracker.handle(events.TaskDeclined{TaskID: uuid.NewString(), Reason: models.FLDeclinePaused})
+	tracker.handle(events.TaskDeclined{TaskID: uuid.NewString(), Reason: models.FLDeclineAtCapacity})
+	tracker.handle(events.TaskSkipped{TaskID: uuid.NewString(), Reason: models.SkipHostBusy})
+
+	status := tracker.snapshot()
+	if len(status.Tasks) != 2 || status.Tasks[0].TaskID != flTask.ID.String() {
+		t.Fatalf("Tasks = %+v, want both tasks, oldest first", status.Tasks)
+	}
+	if p := status.Tasks[0].Progress; p == nil || p.Percent != 40 || status.Tasks[0].Timeout != time.Hour {
+		t.Fatalf("FL task = %+v, want its progress and timeout", status.Tasks[0])
+	}
+	if status.FLSessions[0].Status != models.TaskStatusRunning || status.FLSessions[0].RoundID != "round-3" {
+		t.Fatalf("FLSessions = %+v, want round-3 running", status.FLSessions)
+	}
+
+	clk.Step(5 * time.Minute)
+	tracker.handle(events.TaskCompleted{Task: flTask, TaskID: flTask.ID.String(), Type: flTask.Type, Status: models.TaskStatusCompleted, Duration: 5 * time.Minute})
+	tracker.handle(events.LeaseLost{TaskID: docker.ID.String()})
+
+	status = tracker.snapshot()
+	if len(status.Tasks) != 0 {
+		t.Fatalf("Tasks = %+v, want none once finished or lost", status.Tasks)
+	}
+	if len(status.Recent) != 1 || status.Recent[0].Reward != 2.5 || !status.Recent[0].FinishedAt.Equal(start.Add(5*time.Minute)) {
+		t.Fatalf("Recent = %+v, want the FL task with its reward", status.Recent)
+	}
+	if status.FLSessions[0].Status != models.TaskStatusCompleted {
+		t.Fatalf("FLSessions = %+v, want the round completed", status.FLSessions)
+	}
+	want := models.TaskCounters{
+		Claimed:   2,
+		Completed: 1,
+		Declined:  3,
+		DeclineReasons: map[models.FLDeclineReason]uint64{
+			models.FLDeclineAtCapacity: 2,
+			models.FLDeclinePaused:     1,
+		},
+		Skipped:     1,
+		SkipReasons: map[models.SkipReason]uint64{models.SkipHostBusy: 1},
+	}
+	if !reflect.DeepEqual(status.Counters, want) {
+		t.Fatalf("Counters = %+v, want %+v", status.Counters, want)
+	}
+}
+
+func TestPausedHandlerDeclinesTasks(t *testing.T) {
+	h := NewTaskHandler(&countingExecutor{}, &fakeFLClient{})
+	h.SetPaused(true)
+	admission := h.admitTask(newCommandTask(0))
+	if admission == nil || admission.reason != models.FLDeclinePaused {
+		t.Fatalf("admitTask() = %v, want declined as paused", admission)
+	}
+
+	h.SetPaused(false)
+	if admission := h.admitTask(newCommandTask(0)); admission != nil && admission.reason == models.FLDeclinePaused {
+		t.Fatalf("admitTask() = %v after resuming, want no pause decline", admission)
+	}
+}
@@ -1,53 +1,231 @@
 package runner
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/theblitlabs/deviceid"
+	"github.com/theblitlabs/gologger"
 
+	"github.com/theblitlabs/parity-runner/internal/apiclient"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
 	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/flcanon"
+	"github.com/theblitlabs/parity-runner/internal/flcompress"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+	"github.com/theblitlabs/parity-runner/internal/utils"
 )
 
+var (
+	// ErrNoTasks is returned by FetchTask when no task can be claimed
+	ErrNoTasks = errors.New("no tasks available")
+	// ErrTaskNotFound is returned when the server does not know a task
+	ErrTaskNotFound = errors.New("task not found")
+	// ErrTaskUnavailable is returned when a task cannot be claimed, such as
+	// when another runner holds it
+	ErrTaskUnavailable = errors.New("task unavailable")
+	// ErrResultExpired is returned when the server no longer keeps a task's
+	// result
+	ErrResultExpired = errors.New("task result expired")
+	// ErrCapabilityConflict is returned when the server holds a capability
+	// profile version other than the one a patch was made from
+	ErrCapabilityConflict = errors.New("capability profile version conflict")
+	// ErrCapabilityPatchUnsupported is returned when the server takes no
+	// capability patches
+	ErrCapabilityPatchUnsupported = errors.New("capability patches not supported")
+	// ErrGroupSummaryUnsupported is returned when the server takes no
+	// group summaries
+	ErrGroupSummaryUnsupported = errors.New("group summaries not supported")
+	// ErrResultPatchUnsupported is returned when the server takes no
+	// patches of saved results
+	ErrResultPatchUnsupported = errors.New("result patches not supported")
+	// ErrMigrationUnsupported is returned when the server does not reassign
+	// tasks with a migration bundle
+	ErrMigrationUnsupported = errors.New("task migration not supported")
+	// ErrRebindRefused is returned when the server will not bind the
+	// runner's identity to the machine it was migrated to
+	ErrRebindRefused = errors.New("runner rebind refused")
+	// ErrRebindUnsupported is returned when the server takes no rebinds
+	ErrRebindUnsupported = errors.New("runner rebinds not supported")
+	// ErrRunnerInfoUnsupported is returned when the server does not report
+	// what it holds of the runner
+	ErrRunnerInfoUnsupported = errors.New("runner info not supported")
+)
+
+// HTTPTaskClient implements TaskClient over the server API, adapting the
+// generated apiclient operations to the runner's types and errors
 type HTTPTaskClient struct {
-	baseURL string
+	api   *apiclient.Client
+	clock clock.Clock
+	// ctx bounds every request and its retries
+	ctx      context.Context
+	deviceID func() (string, error)
+	// flCodec compresses FL model updates when set
+	flCodec *flcompress.Codec
+	// offload moves large outputs out of the results saved when set
+	offload *outputOffload
+	// outbox holds the retention of the results saved
+	outbox *retention.Outbox
+	// claims is the IDs of the tasks FetchTask claimed and that were
+	// neither reported finished nor released, so concurrent callers never
+	// claim the same task
+	claims sync.Map
+	// taskLocks serializes the status updates of each task, holding a lock
+	// per task while any update of it runs or waits
+	taskLocksMu sync.Mutex
+	taskLocks   map[string]*taskLock
+}
+
+// taskLock is the lock of one task's status updates, shared by the updates
+// running or waiting
+type taskLock struct {
+	mu    sync.Mutex
+	users int
+}
+
+// NewHTTPTaskClient returns a client of the server at baseURL that retries
+// requests that are safe to repeat under retries
+func NewHTTPTaskClient(baseURL string, retries apiclient.RetryPolicy) *HTTPTaskClient {
+	c := &HTTPTaskClient{
+		clock:     clock.Real(),
+		ctx:       context.Background(),
+		deviceID:  runnerDeviceID,
+		taskLocks: make(map[string]*taskLock),
+	}
+	c.api = apiclient.New(baseURL, func() (string, error) { return c.deviceID() })
+	c.api.SetRetryPolicy(retries)
+	return c
 }
 
-func NewHTTPTaskClient(baseURL string) *HTTPTaskClient {
-	return &HTTPTaskClient{
-		baseURL: baseURL,
+// apiRetryPolicy returns the retry policy cfg configures
+func apiRetryPolicy(cfg config.APIRetryConfig) apiclient.RetryPolicy {
+	return apiclient.RetryPolicy{
+		Attempts:  cfg.MaxAttempts,
+		BaseDelay: cfg.BaseDelay,
+		MaxDelay:  cfg.MaxDelay,
+		Jitter:    cfg.Jitter,
+	}
+}
+
+// runnerDeviceID returns the device ID every API request is authenticated
+// with
+func runnerDeviceID() (string, error) {
+	deviceID, err := utils.GetDeviceID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get device ID: %w", err)
 	}
+	return deviceID, nil
+}
+
+// SetClock replaces the clock used to compute lease expiry and timestamps
+// and to wait out retry backoff
+func (c *HTTPTaskClient) SetClock(clk clock.Clock) {
+	c.clock = clk
+	c.api.SetClock(clk)
+}
+
+// SetFLCompression compresses FL model updates with codec, offering the
+// server the dictionaries it trains
+func (c *HTTPTaskClient) SetFLCompression(codec *flcompress.Codec) {
+	c.flCodec = codec
+}
+
+// SetDeviceID authenticates requests as deviceID rather than the host's
+// device ID, as each logical runner of a multi-instance process does
+func (c *HTTPTaskClient) SetDeviceID(deviceID string) {
+	c.deviceID = func() (string, error) { return deviceID, nil }
+}
+
+// SetHTTPClient sends requests with client, so that runners sharing it
+// share its connection pool
+func (c *HTTPTaskClient) SetHTTPClient(client *http.Client) {
+	c.api.SetHTTPClient(client)
+}
+
+// SetContext bounds every request and its retries by ctx, so that
+// cancelling it abandons them rather than letting them hold shutdown up
+func (c *HTTPTaskClient) SetContext(ctx context.Context) {
+	c.ctx = ctx
 }
 
+// FetchTask claims the first available task, returning ErrNoTasks when
+// there is none. It is safe to call from concurrent workers: a task one of
+// them is claiming or claimed is passed over by the others, as is one
+// another runner claimed first.
 func (c *HTTPTaskClient) FetchTask() (*models.Task, error) {
 	tasks, err := c.GetAvailableTasks()
 	if err != nil {
 		return nil, err
 	}
+	return c.claimFirst(tasks)
+}
 
-	if len(tasks) == 0 {
-		return nil, fmt.Errorf("no tasks available")
+// claimFirst claims the first of tasks no concurrent caller holds and the
+// server still offers
+func (c *HTTPTaskClient) claimFirst(tasks []*models.Task) (*models.Task, error) {
+	for _, task := range tasks {
+		taskID := task.ID.String()
+		if _, held := c.claims.LoadOrStore(taskID, struct{}{}); held {
+			continue
+		}
+		err := c.StartTask(taskID)
+		if err == nil {
+			return task, nil
+		}
+		c.claims.Delete(taskID)
+		if !errors.Is(err, ErrTaskUnavailable) && !errors.Is(err, ErrTaskNotFound) {
+			return nil, err
+		}
 	}
+	return nil, ErrNoTasks
+}
 
-	task := tasks[0]
-	if err := c.StartTask(task.ID.String()); err != nil {
-		return nil, err
+// lockTask holds back other status updates of taskID until the returned
+// function is called, so that a task's completion and result are never
+// interleaved with another update of it. The lock is dropped once no update
+// of the task holds or waits for it.
+func (c *HTTPTaskClient) lockTask(taskID string) func() {
+	c.taskLocksMu.Lock()
+	lock := c.taskLocks[taskID]
+	if lock == nil {
+		lock = &taskLock{}
+		c.taskLocks[taskID] = lock
+	}
+	lock.users++
+	c.taskLocksMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+		c.taskLocksMu.Lock()
+		defer c.taskLocksMu.Unlock()
+		if lock.users--; lock.users == 0 {
+			delete(c.taskLocks, taskID)
+		}
 	}
-
-	return task, nil
 }
 
+// UpdateTaskStatus reports a task running, claiming it, or finished with
+// its result. Updates of one task are made one at a time, not necessarily in
+// the order they are asked for; those of different tasks go on concurrently
+// and never wait on each other.
 func (c *HTTPTaskClient) UpdateTaskStatus(taskID string, status models.TaskStatus, result *models.TaskResult) error {
+	unlock := c.lockTask(taskID)
+	defer unlock()
+
 	switch status {
 	case models.TaskStatusRunning:
 		return c.StartTask(taskID)
-	case models.TaskStatusCompleted, models.TaskStatusFailed:
+	case models.TaskStatusCompleted, models.TaskStatusFailed, models.TaskStatusRevoked:
+		c.claims.Delete(taskID)
 		if err := c.CompleteTask(taskID); err != nil {
 			return err
 		}
@@ -61,238 +239,483 @@ func (c *HTTPTaskClient) UpdateTaskStatus(taskID string, status models.TaskStatu
 }
 
 func (c *HTTPTaskClient) GetAvailableTasks() ([]*models.Task, error) {
-	baseURL := strings.TrimSuffix(c.baseURL, "/api")
-	url := fmt.Sprintf("%s/api/v1/runners/tasks/available", baseURL)
+	return c.api.ListAvailableTasks(c.ctx)
+}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("HTTP GET failed for %s: %w", url, err)
+// GetTask fetches a task by ID, whatever its status
+func (c *HTTPTaskClient) GetTask(taskID string) (*models.Task, error) {
+	task, err := c.api.GetTask(c.ctx, taskID)
+	if apiclient.StatusCode(err) == http.StatusNotFound {
+		return nil, ErrTaskNotFound
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	if err != nil {
+		return nil, err
 	}
-
-	var tasks []*models.Task
-	if err := json.NewDecoder(resp.Body).Decode(&tasks); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	if task == nil {
+		return nil, fmt.Errorf("server returned no task for %s", taskID)
 	}
-
-	return tasks, nil
+	return task, nil
 }
 
 func (c *HTTPTaskClient) StartTask(taskID string) error {
-	baseURL := strings.TrimSuffix(c.baseURL, "/api")
-	url := fmt.Sprintf("%s/api/v1/runners/tasks/%s/start", baseURL, taskID)
+	_, err := c.StartTaskWithLease(taskID)
+	return err
+}
 
-	deviceIDManager := deviceid.NewManager(deviceid.Config{})
-	deviceID, err := deviceIDManager.VerifyDeviceID()
-	if err != nil {
-		return fmt.Errorf("failed to get device ID: %w", err)
+func toLease(taskID string, lease *apiclient.Lease, now time.Time) *models.TaskLease {
+	ttl := time.Duration(lease.LeaseTTLSeconds) * time.Second
+	return &models.TaskLease{
+		TaskID:    taskID,
+		LeaseID:   lease.LeaseID,
+		TTL:       ttl,
+		ExpiresAt: now.Add(ttl),
 	}
+}
+
+// StartTaskWithLease claims a task and returns the lease granted by the server, if any
+func (c *HTTPTaskClient) StartTaskWithLease(taskID string) (*models.TaskLease, error) {
+	return c.StartTaskWithHint(taskID, nil)
+}
 
-	req, err := http.NewRequest("POST", url, nil)
+// StartTaskWithHint claims a task, sending hint in the request body so the
+// server can reroute the task if this runner is too slow
+func (c *HTTPTaskClient) StartTaskWithHint(taskID string, hint *models.ClaimHint) (*models.TaskLease, error) {
+	lease, err := c.api.StartTask(c.ctx, taskID, hint)
+	switch apiclient.StatusCode(err) {
+	case http.StatusConflict:
+		return nil, fmt.Errorf("%w: %v", ErrTaskUnavailable, err)
+	case http.StatusNotFound:
+		return nil, ErrTaskNotFound
+	}
+	// Servers that do not issue leases reply with an empty or lease-less body
+	if errors.Is(err, apiclient.ErrDecode) {
+		return nil, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
-
-	req.Header.Set("X-Device-ID", deviceID)
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	if lease == nil || lease.LeaseTTLSeconds <= 0 {
+		return nil, nil
 	}
+	return toLease(taskID, lease, c.clock.Now()), nil
+}
 
-	resp, err := client.Do(req)
+// RenewLease extends the lease on a running task. It returns ErrLeaseLost when
+// the server no longer considers this runner the owner of the task.
+func (c *HTTPTaskClient) RenewLease(lease *models.TaskLease) (*models.TaskLease, error) {
+	renewed, err := c.api.RenewLease(c.ctx, lease.TaskID, &apiclient.LeaseRequest{LeaseID: lease.LeaseID})
+	switch apiclient.StatusCode(err) {
+	case http.StatusNotFound, http.StatusConflict, http.StatusGone:
+		return nil, fmt.Errorf("%w: %v", ErrLeaseLost, err)
+	}
 	if err != nil {
-		return fmt.Errorf("HTTP POST failed for %s: %w", url, err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	body, _ := io.ReadAll(resp.Body)
+	// Fields the server leaves out keep their previous values
+	if renewed == nil {
+		renewed = &apiclient.Lease{}
+	}
+	if renewed.LeaseID == "" {
+		renewed.LeaseID = lease.LeaseID
+	}
+	if renewed.LeaseTTLSeconds <= 0 {
+		renewed.LeaseTTLSeconds = int64(lease.TTL / time.Second)
+	}
+	return toLease(lease.TaskID, renewed, c.clock.Now()), nil
+}
 
-	switch resp.StatusCode {
-	case http.StatusOK:
+// ReleaseTask gives up this runner's claim on a task it will not execute,
+// leaving the task with the runner executing it
+func (c *HTTPTaskClient) ReleaseTask(taskID string, lease *models.TaskLease) error {
+	request := &apiclient.LeaseRequest{}
+	if lease != nil {
+		request.LeaseID = lease.LeaseID
+	}
+	c.claims.Delete(taskID)
+	err := c.api.ReleaseTask(c.ctx, taskID, request)
+	switch apiclient.StatusCode(err) {
+	case http.StatusNotFound, http.StatusConflict, http.StatusGone:
+		// The claim was never this runner's to release
 		return nil
-	case http.StatusConflict:
-		return fmt.Errorf("task unavailable: %s", string(body))
-	case http.StatusBadRequest:
-		return fmt.Errorf("bad request: %s", string(body))
-	case http.StatusNotFound:
-		return fmt.Errorf("task not found")
-	default:
-		return fmt.Errorf("unexpected status code: %d, body: %s", resp.StatusCode, string(body))
 	}
+	return err
 }
 
-func (c *HTTPTaskClient) CompleteTask(taskID string) error {
-	baseURL := strings.TrimSuffix(c.baseURL, "/api")
-	url := fmt.Sprintf("%s/api/v1/runners/tasks/%s/complete", baseURL, taskID)
-
-	resp, err := http.Post(url, "application/json", nil)
-	if err != nil {
-		return fmt.Errorf("HTTP POST failed for %s: %w", url, err)
+// ReleasePoisoned gives up the claim on a task that keeps failing the same
+// way, with the failures that poisoned it for the server to quarantine it
+func (c *HTTPTaskClient) ReleasePoisoned(taskID string, lease *models.TaskLease, report *models.PoisonReport) error {
+	request := &apiclient.LeaseRequest{Reason: models.ReleaseReasonPoisoned, Poison: report}
+	if lease != nil {
+		request.LeaseID = lease.LeaseID
+	}
+	c.claims.Delete(taskID)
+	err := c.api.ReleaseTask(c.ctx, taskID, request)
+	switch apiclient.StatusCode(err) {
+	case http.StatusNotFound, http.StatusConflict, http.StatusGone:
+		return nil
 	}
-	defer resp.Body.Close()
+	return err
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
+// RequestTaskMigration gives up the claim on a running task for the server
+// to reassign it with migration, the pointer to the bundle the next runner
+// resumes it from
+func (c *HTTPTaskClient) RequestTaskMigration(taskID string, lease *models.TaskLease, migration *models.TaskMigration) error {
+	request := &apiclient.MigrationRequest{Migration: migration}
+	if lease != nil {
+		request.LeaseID = lease.LeaseID
+	}
+	err := c.api.RequestTaskMigration(c.ctx, taskID, request)
+	if err == nil {
+		c.claims.Delete(taskID)
+	}
+	switch apiclient.StatusCode(err) {
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return fmt.Errorf("%w: %v", ErrMigrationUnsupported, err)
+	case http.StatusConflict, http.StatusGone:
+		return fmt.Errorf("%w: %v", ErrLeaseLost, err)
+	}
+	return err
+}
 
-	return nil
+func (c *HTTPTaskClient) CompleteTask(taskID string) error {
+	return c.api.CompleteTask(c.ctx, taskID)
 }
 
 func (c *HTTPTaskClient) SaveTaskResult(taskID string, result *models.TaskResult) error {
-	baseURL := strings.TrimSuffix(c.baseURL, "/api")
-	url := fmt.Sprintf("%s/api/v1/runners/tasks/%s/result", baseURL, taskID)
-
-	deviceIDManager := deviceid.NewManager(deviceid.Config{})
-	deviceID, err := deviceIDManager.VerifyDeviceID()
-	if err != nil {
-		return fmt.Errorf("failed to get device ID: %w", err)
-	}
-
 	if result.TaskID == uuid.Nil {
-		result.TaskID = uuid.MustParse(taskID)
+		id, err := uuid.Parse(taskID)
+		if err != nil {
+			return fmt.Errorf("invalid task ID %q: %w", taskID, err)
+		}
+		result.TaskID = id
 	}
 	if result.CreatedAt.IsZero() {
-		result.CreatedAt = time.Now()
+		result.CreatedAt = c.clock.Now()
 	}
 	if result.RunnerAddress == "" {
+		deviceID, err := c.deviceID()
+		if err != nil {
+			return err
+		}
 		result.RunnerAddress = deviceID
 	}
-
-	body, err := json.Marshal(result)
-	if err != nil {
-		return fmt.Errorf("failed to marshal result: %w", err)
+	if c.offload != nil {
+		result = c.offload.apply(c.ctx, taskID, result)
 	}
+	return c.api.SaveTaskResult(c.ctx, taskID, result)
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+// CompletePrompt reports an LLM response. usage, when set, carries both the
+// backend's and the runner's token counts for reconciliation, and format how
+// a constrained response met its format. cachedPromptID names the identical
+// prompt a cached response was generated for, and is empty for responses
+// generated for this prompt.
+func (c *HTTPTaskClient) CompletePrompt(promptID uuid.UUID, response string, promptTokens, responseTokens int, inferenceTime int64, usage *models.TokenUsage, format *models.ResponseFormatReport, cachedPromptID string) error {
+	return c.api.CompletePrompt(c.ctx, promptID.String(), &apiclient.PromptCompletion{
+		Response:        response,
+		PromptTokens:    promptTokens,
+		ResponseTokens:  responseTokens,
+		InferenceTimeMs: inferenceTime,
+		TokenUsage:      usage,
+		ResponseFormat:  format,
+		ServedFromCache: cachedPromptID != "",
+		CachedPromptID:  cachedPromptID,
+	})
+}
+
+// SubmitFLModelUpdate submits federated learning model updates to the
+// server. specHash identifies the model trained when the session describes
+// it as a spec, and is empty otherwise. Updates are encoded canonically,
+// and one holding NaN or an infinity is refused before it is sent.
+func (c *HTTPTaskClient) SubmitFLModelUpdate(sessionID, roundID, runnerID, specHash string, gradients map[string][]float64, weights map[string][]float64, dataSize int, loss, accuracy float64, trainingTime int) error {
+	if err := flcanon.CheckFinite("gradients", gradients); err != nil {
+		return err
+	}
+	if err := flcanon.CheckFinite("weights", weights); err != nil {
+		return err
+	}
+	if err := flcanon.CheckFinite("metrics", map[string][]float64{"loss": {loss}, "accuracy": {accuracy}}); err != nil {
+		return err
+	}
+	update := &apiclient.ModelUpdate{
+		SessionID:     sessionID,
+		RoundID:       roundID,
+		RunnerID:      runnerID,
+		Gradients:     gradients,
+		Weights:       weights,
+		UpdateType:    "gradients",
+		DataSize:      dataSize,
+		Loss:          loss,
+		Accuracy:      accuracy,
+		TrainingTime:  trainingTime,
+		ModelSpecHash: specHash,
+		Metadata: map[string]interface{}{
+			"submission_time": c.clock.Now().Unix(),
+		},
+	}
+	if c.flCodec != nil {
+		if err := c.compressUpdate(update); err != nil {
+			return err
+		}
 	}
+	return c.api.SubmitModelUpdate(c.ctx, update)
+}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("X-Device-ID", deviceID)
+// compressUpdate moves an update's gradients and weights into a compressed
+// payload, first offering the server any dictionary trained for its session
+func (c *HTTPTaskClient) compressUpdate(update *apiclient.ModelUpdate) error {
+	if dictionary := c.flCodec.Pending(update.SessionID); dictionary != nil {
+		c.offerDictionary(update.SessionID, update.RunnerID, dictionary)
+	}
 
-	resp, err := http.DefaultClient.Do(req)
+	raw, err := flcanon.Marshal(struct {
+		Gradients map[string][]float64 `json:"gradients"`
+		Weights   map[string][]float64 `json:"weights"`
+	}{update.Gradients, update.Weights})
 	if err != nil {
-		return fmt.Errorf("HTTP POST failed for %s: %w", url, err)
+		return fmt.Errorf("failed to marshal model update: %w", err)
 	}
-	defer resp.Body.Close()
+	payload, err := c.flCodec.Encode(update.SessionID, raw)
+	if err != nil {
+		return fmt.Errorf("failed to compress model update: %w", err)
+	}
+	update.Gradients, update.Weights = nil, nil
+	update.PayloadEncoding = payload.Encoding
+	update.Payload = payload.Data
+	update.DictionaryHash = payload.DictionaryHash
+	return nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		var errResp struct {
-			Error string `json:"error"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil && errResp.Error != "" {
-			return fmt.Errorf("server error: %s", errResp.Error)
+// offerDictionary registers a trained dictionary with the server. Servers
+// that decline it, or do not support dictionaries, keep receiving updates
+// compressed without one; a dictionary that could not be offered is offered
+// again with the next update.
+func (c *HTTPTaskClient) offerDictionary(sessionID, runnerID string, dictionary *flcompress.Dictionary) {
+	log := gologger.WithComponent("fl_compress")
+	ack, err := c.api.OfferCompressionDictionary(c.ctx, sessionID, &apiclient.CompressionDictionary{
+		RunnerID:   runnerID,
+		ID:         int64(dictionary.ID),
+		Hash:       dictionary.Hash,
+		Dictionary: dictionary.Data,
+	})
+	switch {
+	case err == nil:
+		accepted := ack != nil && ack.Accepted
+		c.flCodec.Acknowledge(sessionID, dictionary.Hash, accepted)
+		if !accepted {
+			reason := ""
+			if ack != nil {
+				reason = ack.Reason
+			}
+			log.Info().Str("session_id", sessionID).Str("reason", reason).Msg("Server declined compression dictionary")
 		}
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	case apiclient.StatusCode(err) == http.StatusNotFound, apiclient.StatusCode(err) == http.StatusNotImplemented:
+		c.flCodec.Acknowledge(sessionID, dictionary.Hash, false)
+		log.Info().Str("session_id", sessionID).Msg("Server does not support compression dictionaries")
+	default:
+		c.flCodec.Withdraw(sessionID, dictionary.Hash)
+		log.Warn().Err(err).Str("session_id", sessionID).Msg("Failed to offer compression dictionary")
 	}
-
-	return nil
 }
 
-func (c *HTTPTaskClient) CompletePrompt(promptID uuid.UUID, response string, promptTokens, responseTokens int, inferenceTime int64) error {
-	baseURL := strings.TrimSuffix(c.baseURL, "/api")
-	url := fmt.Sprintf("%s/api/v1/llm/prompts/%s/complete", baseURL, promptID.String())
+// AcknowledgeFLRound tells the FL coordinator whether this runner will train in a round
+func (c *HTTPTaskClient) AcknowledgeFLRound(ack *models.FLRoundAck) error {
+	return c.api.AcknowledgeRound(c.ctx, ack.RoundID, ack)
+}
 
-	payload := map[string]interface{}{
-		"response":          response,
-		"prompt_tokens":     promptTokens,
-		"response_tokens":   responseTokens,
-		"inference_time_ms": inferenceTime,
+// PatchRunnerCapabilities sends the fields of the runner's capability
+// profile that changed since the version the server acknowledged, returning
+// the version it now holds
+func (c *HTTPTaskClient) PatchRunnerCapabilities(patch *models.CapabilityPatch) (*models.CapabilityAck, error) {
+	ack, err := c.api.PatchRunnerCapabilities(c.ctx, patch)
+	switch apiclient.StatusCode(err) {
+	case http.StatusConflict:
+		return nil, fmt.Errorf("%w: %v", ErrCapabilityConflict, err)
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return nil, fmt.Errorf("%w: %v", ErrCapabilityPatchUnsupported, err)
 	}
-
-	body, err := json.Marshal(payload)
 	if err != nil {
-		return fmt.Errorf("failed to marshal completion payload: %w", err)
+		return nil, err
 	}
+	if ack == nil {
+		return nil, fmt.Errorf("empty capability patch response")
+	}
+	return ack, nil
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+// GetRunnerInfo returns what the server holds of the runner, including the
+// reputation score it ranks the runner by
+func (c *HTTPTaskClient) GetRunnerInfo() (*models.RunnerInfo, error) {
+	info, err := c.api.GetRunnerInfo(c.ctx)
+	switch apiclient.StatusCode(err) {
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return nil, fmt.Errorf("%w: %v", ErrRunnerInfoUnsupported, err)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return nil, err
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
+	if info == nil {
+		return nil, fmt.Errorf("empty runner info response")
 	}
+	return info, nil
+}
 
-	resp, err := client.Do(req)
+// RebindRunner binds the runner's identity to the machine its state was
+// imported onto, after which the server refuses the machine it was exported
+// from
+func (c *HTTPTaskClient) RebindRunner(rebind *models.RunnerRebind) (*models.RunnerRebindAck, error) {
+	ack, err := c.api.RebindRunner(c.ctx, rebind)
+	switch apiclient.StatusCode(err) {
+	case http.StatusConflict:
+		return nil, fmt.Errorf("%w: %v", ErrRebindRefused, err)
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return nil, fmt.Errorf("%w: %v", ErrRebindUnsupported, err)
+	}
 	if err != nil {
-		return fmt.Errorf("HTTP POST failed for %s: %w", url, err)
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		var errResp struct {
-			Error string `json:"error"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&errResp); err == nil && errResp.Error != "" {
-			return fmt.Errorf("server error: %s", errResp.Error)
-		}
-		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	if ack == nil {
+		return nil, fmt.Errorf("empty rebind response")
+	}
+	if !ack.Accepted {
+		return ack, fmt.Errorf("%w: %s", ErrRebindRefused, ack.Reason)
 	}
+	return ack, nil
+}
 
-	return nil
+// SubmitAuditResponse answers an audit challenge
+func (c *HTTPTaskClient) SubmitAuditResponse(response *models.AuditResponse) error {
+	return c.api.SubmitAuditResponse(c.ctx, response.ChallengeID, response)
 }
 
-// SubmitFLModelUpdate submits federated learning model updates to the server
-func (c *HTTPTaskClient) SubmitFLModelUpdate(sessionID, roundID, runnerID string, gradients map[string][]float64, weights map[string][]float64, dataSize int, loss, accuracy float64, trainingTime int) error {
-	baseURL := strings.TrimSuffix(c.baseURL, "/api")
-	url := fmt.Sprintf("%s/api/v1/federated-learning/model-updates", baseURL)
-
-	payload := map[string]interface{}{
-		"session_id":    sessionID,
-		"round_id":      roundID,
-		"runner_id":     runnerID,
-		"gradients":     gradients,
-		"weights":       weights,
-		"update_type":   "gradients",
-		"data_size":     dataSize,
-		"loss":          loss,
-		"accuracy":      accuracy,
-		"training_time": trainingTime,
-		"metadata": map[string]interface{}{
-			"submission_time": time.Now().Unix(),
-		},
+// SendErrors shares a batch of redacted error events for fleet diagnostics
+func (c *HTTPTaskClient) SendErrors(report *models.ErrorReport) error {
+	return c.api.SendErrors(c.ctx, report)
+}
+
+// SendTaskWarning reports a condition of a running task, such as sustained
+// memory pressure, before its result
+func (c *HTTPTaskClient) SendTaskWarning(taskID string, warning *models.TaskWarning) error {
+	return c.api.SendTaskWarning(c.ctx, taskID, warning)
+}
+
+// ReportProgress relays a running task's report of its progress, or of a
+// checkpoint it wrote and the runner uploaded
+func (c *HTTPTaskClient) ReportProgress(taskID string, progress *models.TaskProgress) error {
+	return c.api.ReportProgress(c.ctx, taskID, progress)
+}
+
+// SubmitGroupSummary submits the combined result of the shards of a task
+// group this runner ran
+func (c *HTTPTaskClient) SubmitGroupSummary(summary *models.GroupSummary) error {
+	err := c.api.SubmitGroupSummary(c.ctx, summary.GroupID, summary)
+	switch apiclient.StatusCode(err) {
+	case http.StatusNotFound, http.StatusNotImplemented:
+		return fmt.Errorf("%w: %v", ErrGroupSummaryUnsupported, err)
 	}
+	return err
+}
 
-	body, err := json.Marshal(payload)
-	if err != nil {
-		return fmt.Errorf("failed to marshal FL model update: %w", err)
+// PatchResultMetadata adds metadata to the saved result of a task
+func (c *HTTPTaskClient) PatchResultMetadata(taskID string, metadata map[string]interface{}) error {
+	err := c.api.PatchTaskResult(c.ctx, taskID, &models.ResultMetadataPatch{Metadata: metadata})
+	switch apiclient.StatusCode(err) {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return fmt.Errorf("%w: %v", ErrResultPatchUnsupported, err)
 	}
+	return err
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+// GetAttestationChallenge fetches a fresh nonce to bind attestation evidence
+// to. A non-empty taskID scopes the nonce to that task's result.
+func (c *HTTPTaskClient) GetAttestationChallenge(taskID string) (*models.AttestationChallenge, error) {
+	challenge, err := c.api.GetAttestationChallenge(c.ctx, taskID)
 	if err != nil {
-		return fmt.Errorf("failed to create FL model update request: %w", err)
+		return nil, err
 	}
+	if challenge == nil || challenge.Nonce == "" {
+		return nil, fmt.Errorf("attestation challenge has no nonce")
+	}
+	return challenge, nil
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// GetTaskResult fetches the part of taskID's result query selects. A nil
+// query selects all of it.
+func (c *HTTPTaskClient) GetTaskResult(taskID string, query *models.ResultQuery) (*models.TaskResultPage, error) {
+	return c.getTaskResult(c.ctx, taskID, query)
+}
 
-	client := &http.Client{
-		Timeout: 30 * time.Second, // Longer timeout for FL operations
+func (c *HTTPTaskClient) getTaskResult(ctx context.Context, taskID string, query *models.ResultQuery) (*models.TaskResultPage, error) {
+	if query == nil {
+		query = &models.ResultQuery{}
+	}
+	if query.OutputOffset < 0 || query.OutputLength < 0 {
+		return nil, fmt.Errorf("invalid output range %d+%d", query.OutputOffset, query.OutputLength)
+	}
+	page, err := c.api.GetTaskResult(ctx, taskID,
+		strings.Join(query.Fields, ","), query.OutputOffset, query.OutputLength, strings.Join(query.Artifacts, ","))
+	switch apiclient.StatusCode(err) {
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %w", ErrTaskNotFound, err)
+	case http.StatusGone:
+		return nil, fmt.Errorf("%w: %w", ErrResultExpired, err)
 	}
-
-	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("HTTP POST failed for FL model update %s: %w", url, err)
+		return nil, err
+	}
+	if page == nil {
+		return nil, fmt.Errorf("server returned no result for %s", taskID)
 	}
-	defer resp.Body.Close()
+	return page, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		var errResp struct {
-			Error string `json:"error"`
-		}
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		if err := json.Unmarshal(bodyBytes, &errResp); err == nil && errResp.Error != "" {
-			return fmt.Errorf("FL model update server error: %s", errResp.Error)
+// DescribeArtifact looks up the hash and size of taskID's artifact name.
+// It implements inputs.ArtifactSource, reporting results the server no
+// longer knows as expired artifacts.
+func (c *HTTPTaskClient) DescribeArtifact(ctx context.Context, taskID, name string) (*models.ResultArtifact, error) {
+	page, err := c.getTaskResult(ctx, taskID, &models.ResultQuery{
+		Fields:    []string{models.ResultFieldArtifacts},
+		Artifacts: []string{name},
+	})
+	if errors.Is(err, ErrTaskNotFound) || errors.Is(err, ErrResultExpired) {
+		return nil, fmt.Errorf("%w: %w", inputs.ErrArtifactExpired, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range page.Artifacts {
+		if page.Artifacts[i].Name == name {
+			return &page.Artifacts[i], nil
 		}
-		return fmt.Errorf("FL model update unexpected status code: %d, body: %s", resp.StatusCode, string(bodyBytes))
 	}
+	return nil, fmt.Errorf("%w: task %s has no artifact %s", inputs.ErrArtifactExpired, taskID, name)
+}
 
-	return nil
+// OpenArtifact streams the content of taskID's artifact name, which the
+// caller reads and closes. It implements inputs.ArtifactSource.
+func (c *HTTPTaskClient) OpenArtifact(ctx context.Context, taskID, name string) (io.ReadCloser, error) {
+	body, err := c.api.GetTaskArtifact(ctx, taskID, name)
+	switch apiclient.StatusCode(err) {
+	case http.StatusNotFound, http.StatusGone:
+		return nil, fmt.Errorf("%w: %w", inputs.ErrArtifactExpired, err)
+	}
+	return body, err
+}
+
+// IssueUploadToken requests a short-lived token that authorises uploads of
+// taskID's artifacts only
+func (c *HTTPTaskClient) IssueUploadToken(taskID string) (*models.UploadToken, error) {
+	token, err := c.api.IssueUploadToken(c.ctx, taskID)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || token.Token == "" {
+		return nil, fmt.Errorf("upload token response has no token")
+	}
+	if token.TaskID == "" {
+		token.TaskID = taskID
+	}
+	return token, nil
 }
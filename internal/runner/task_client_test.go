@@ -0,0 +1,695 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/apiclient"
+	"github.com/theblitlabs/parity-runner/internal/apiclient/openapi"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/flcanon"
+	"github.com/theblitlabs/parity-runner/internal/flcompress"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+	"github.com/theblitlabs/parity-runner/internal/taskschema"
+)
+
+// contractServer serves the runner API as its OpenAPI spec describes it,
+// failing the test on requests the spec does not allow and answering with
+// canned responses that are themselves checked against the spec
+type contractServer struct {
+	t         *testing.T
+	doc       *openapi.Document
+	responses map[string]interface{}
+	statuses  map[string]int
+
+	mu    sync.Mutex
+	calls map[string]int
+	// bodies keeps the last request body of each operation
+	bodies map[string][]byte
+	// queries keeps the last request query of each operation
+	queries map[string]url.Values
+}
+
+func newContractServer(t *testing.T) (*contractServer, *HTTPTaskClient) {
+	t.Helper()
+	doc, err := openapi.Parse(apiclient.Spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &contractServer{
+		t:         t,
+		doc:       doc,
+		responses: make(map[string]interface{}),
+		statuses:  make(map[string]int),
+		calls:     make(map[string]int),
+		bodies:    make(map[string][]byte),
+		queries:   make(map[string]url.Values),
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	// Runner configs may name the API root rather than the server
+	client := NewHTTPTaskClient(server.URL+"/api", apiclient.DefaultRetryPolicy)
+	client.deviceID = func() (string, error) { return "device-1", nil }
+	return s, client
+}
+
+// schemaRef points at a schema inside an operation of the spec
+func schemaRef(endpoint *openapi.Endpoint, within string) string {
+	path := strings.NewReplacer("~", "~0", "/", "~1").Replace(endpoint.Path)
+	return "#/paths/" + path + "/" + strings.ToLower(endpoint.Method) + "/" + within + "/content/application~1json/schema"
+}
+
+func (s *contractServer) check(what, ref string, data []byte) {
+	violations, err := taskschema.ValidateAgainst(apiclient.Spec, ref, data)
+	if err != nil {
+		s.t.Errorf("%s: %v", what, err)
+	}
+	for _, violation := range violations {
+		s.t.Errorf("%s: %s", what, violation)
+	}
+}
+
+func (s *contractServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoint, params := s.doc.Match(r.Method, r.URL.Path)
+	if endpoint == nil {
+		s.t.Errorf("%s %s is not in the spec", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	op := endpoint.OperationID
+	s.calls[op]++
+
+	if r.Header.Get(s.doc.AuthHeader()) == "" {
+		s.t.Errorf("%s: request has no %s header", op, s.doc.AuthHeader())
+	}
+	declared := make(map[string]bool)
+	for _, p := range endpoint.Parameters {
+		switch p.In {
+		case "path":
+			if params[p.Name] == "" {
+				s.t.Errorf("%s: path parameter %s is empty", op, p.Name)
+			}
+		case "query":
+			declared[p.Name] = true
+			if p.Required && r.URL.Query().Get(p.Name) == "" {
+				s.t.Errorf("%s: query parameter %s is required", op, p.Name)
+			}
+		}
+	}
+	for name := range r.URL.Query() {
+		if !declared[name] {
+			s.t.Errorf("%s: query parameter %s is not in the spec", op, name)
+		}
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	s.bodies[op] = body
+	s.queries[op] = r.URL.Query()
+	switch {
+	case len(body) == 0:
+		if endpoint.RequestBody != nil && endpoint.RequestBody.Required {
+			s.t.Errorf("%s: request has no body", op)
+		}
+	case endpoint.JSONBody() == nil:
+		s.t.Errorf("%s: request has a body the spec does not declare", op)
+	default:
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			s.t.Errorf("%s: Content-Type = %q, want application/json", op, ct)
+		}
+		s.check(op+" request", schemaRef(endpoint, "requestBody"), body)
+	}
+
+	status := endpoint.SuccessCodes()[0]
+	if code, ok := s.statuses[op]; ok {
+		if !endpoint.Responds(code) {
+			s.t.Errorf("%s: the spec does not declare status %d", op, code)
+		}
+		status = code
+	}
+	response, ok := s.responses[op]
+	if !ok || status >= 300 {
+		w.WriteHeader(status)
+		return
+	}
+	if raw, ok := response.([]byte); ok && endpoint.Streams() {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.WriteHeader(status)
+		w.Write(raw)
+		return
+	}
+	data, err := json.Marshal(response)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.check(op+" response", schemaRef(endpoint, "responses/"+strconv.Itoa(status)), data)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(data)
+}
+
+func TestTaskClientMatchesSpec(t *testing.T) {
+	server, client := newContractServer(t)
+
+	task := &models.Task{
+		ID:        uuid.New(),
+		Title:     "contract",
+		Type:      models.TaskTypeDocker,
+		Status:    models.TaskStatusPending,
+		Config:    json.RawMessage(`{"image_name":"alpine"}`),
+		Nonce:     "abcdef",
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	taskID := task.ID.String()
+	server.responses["listAvailableTasks"] = []*models.Task{task}
+	server.responses["getTask"] = task
+	server.responses["startTask"] = apiclient.Lease{LeaseID: "lease-1", LeaseTTLSeconds: 60}
+	server.responses["renewLease"] = apiclient.Lease{LeaseTTLSeconds: 120}
+	server.responses["getAttestationChallenge"] = models.AttestationChallenge{Nonce: "nonce-1", ExpiresAt: time.Now().Add(time.Minute)}
+	server.responses["issueUploadToken"] = models.UploadToken{Token: "token-1", ExpiresAt: time.Now().Add(time.Hour)}
+	server.responses["getTaskResult"] = models.TaskResultPage{
+		TaskID:    taskID,
+		Artifacts: []models.ResultArtifact{{Name: "model.bin", SHA256: strings.Repeat("ab", 32), Size: 4}},
+	}
+	server.responses["getTaskArtifact"] = []byte("data")
+
+	tasks, err := client.GetAvailableTasks()
+	if err != nil || len(tasks) != 1 || tasks[0].ID != task.ID {
+		t.Errorf("GetAvailableTasks() = %v, %v", tasks, err)
+	}
+	if got, err := client.GetTask(taskID); err != nil || got.Nonce != task.Nonce {
+		t.Errorf("GetTask() = %+v, %v", got, err)
+	}
+
+	lease, err := client.StartTaskWithHint(taskID, &models.ClaimHint{Model: "llama3", ExpectedTTFTMs: 250, QueueDepth: 2})
+	if err != nil || lease == nil || lease.LeaseID != "lease-1" || lease.TTL != time.Minute {
+		t.Fatalf("StartTaskWithHint() = %+v, %v", lease, err)
+	}
+	if err := client.StartTask(taskID); err != nil {
+		t.Errorf("StartTask() error = %v", err)
+	}
+	renewed, err := client.RenewLease(lease)
+	if err != nil || renewed.LeaseID != "lease-1" || renewed.TTL != 2*time.Minute {
+		t.Errorf("RenewLease() = %+v, %v", renewed, err)
+	}
+	if err := client.ReleaseTask(taskID, lease); err != nil {
+		t.Errorf("ReleaseTask() error = %v", err)
+	}
+	report := &models.PoisonReport{Fingerprint: "0123456789abcdef", Attempts: []models.PoisonAttempt{{Fingerprint: "0123456789abcdef", Runner: "runner-1", ExitCode: 1, FailedAt: time.Now()}}}
+	if err := client.ReleasePoisoned(taskID, lease, report); err != nil {
+		t.Errorf("ReleasePoisoned() error = %v", err)
+	}
+	migration := &models.TaskMigration{Bundle: "bafybundle", SHA256: strings.Repeat("ab", 32), Size: 2048, EpochsCompleted: 3, CreatedAt: time.Now()}
+	if err := client.RequestTaskMigration(taskID, lease, migration); err != nil {
+		t.Errorf("RequestTaskMigration() error = %v", err)
+	}
+
+	warning := &models.TaskWarning{Code: models.TaskWarningMemorySoftLimit, Message: "above soft limit", Time: time.Now(), MemoryUsage: 1 << 30}
+	if err := client.SendTaskWarning(taskID, warning); err != nil {
+		t.Errorf("SendTaskWarning() error = %v", err)
+	}
+	progress := &models.TaskProgress{Percent: 40, Time: time.Now(), Checkpoint: &models.TaskCheckpoint{Name: "step-4.ckpt", CID: "bafy", Size: 512},
+		Group: &models.GroupProgress{GroupID: "sweep-1", Shards: 4, Completed: 1, Running: 1, Percent: 35}}
+	if err := client.ReportProgress(taskID, progress); err != nil {
+		t.Errorf("ReportProgress() error = %v", err)
+	}
+	if err := client.SubmitGroupSummary(groupSummary(taskID)); err != nil {
+		t.Errorf("SubmitGroupSummary() error = %v", err)
+	}
+	if token, err := client.IssueUploadToken(taskID); err != nil || token.Token != "token-1" || token.TaskID != taskID {
+		t.Errorf("IssueUploadToken() = %+v, %v", token, err)
+	}
+	if challenge, err := client.GetAttestationChallenge(taskID); err != nil || challenge.Nonce != "nonce-1" {
+		t.Errorf("GetAttestationChallenge() = %+v, %v", challenge, err)
+	}
+	if artifact, err := client.DescribeArtifact(context.Background(), taskID, "model.bin"); err != nil || artifact.Size != 4 {
+		t.Errorf("DescribeArtifact() = %+v, %v", artifact, err)
+	}
+	if body, err := client.OpenArtifact(context.Background(), taskID, "model.bin"); err != nil {
+		t.Errorf("OpenArtifact() error = %v", err)
+	} else {
+		data, err := io.ReadAll(body)
+		body.Close()
+		if err != nil || string(data) != "data" {
+			t.Errorf("OpenArtifact() read %q, %v", data, err)
+		}
+	}
+	result := &models.TaskResult{Output: "done", ExitCode: 0, CPUSeconds: 1.5}
+	if err := client.UpdateTaskStatus(taskID, models.TaskStatusCompleted, result); err != nil {
+		t.Errorf("UpdateTaskStatus() error = %v", err)
+	}
+	outcomes := []models.MaterializationOutcome{{Plugin: "warehouse", Delivered: true, Attempts: 1, At: time.Now()}}
+	if err := client.PatchResultMetadata(taskID, map[string]interface{}{models.MaterializationMetadataKey: outcomes}); err != nil {
+		t.Errorf("PatchResultMetadata() error = %v", err)
+	}
+
+	if err := client.SubmitAuditResponse(&models.AuditResponse{
+		ChallengeID: "challenge-1", Epoch: 3, Root: "root", Count: 4, Index: 1,
+		Match: true, Status: models.AuditStatusReexecuted,
+	}); err != nil {
+		t.Errorf("SubmitAuditResponse() error = %v", err)
+	}
+	if err := client.SendErrors(&models.ErrorReport{Events: []models.ErrorEvent{{
+		Fingerprint: "f1", Code: "docker_pull", Category: "docker", RunnerVersion: "dev",
+		OS: "linux", Arch: "amd64", Count: 2, FirstSeen: time.Now(), LastSeen: time.Now(),
+	}}}); err != nil {
+		t.Errorf("SendErrors() error = %v", err)
+	}
+	usage := &models.TokenUsage{BackendReported: true, BackendPromptTokens: 3, BackendResponseTokens: 5, CountedPromptTokens: 3, CountedResponseTokens: 5}
+	format := &models.ResponseFormatReport{Type: models.ResponseFormatJSON, Enforcement: models.FormatMetByRetry, Attempts: 2}
+	if err := client.CompletePrompt(uuid.New(), "{}", 3, 5, 120, usage, format, ""); err != nil {
+		t.Errorf("CompletePrompt() error = %v", err)
+	}
+	if err := client.SubmitFLModelUpdate("session-1", "round-1", "runner-1", "hash",
+		map[string][]float64{"w": {0.1}}, map[string][]float64{"w": {0.2}}, 10, 0.5, 0.9, 3); err != nil {
+		t.Errorf("SubmitFLModelUpdate() error = %v", err)
+	}
+	codec, err := flcompress.New(flcompress.Config{TrainAfter: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetFLCompression(codec)
+	server.responses["offerCompressionDictionary"] = apiclient.DictionaryAck{Accepted: true}
+	for round := 0; round < 3; round++ {
+		if err := client.SubmitFLModelUpdate("session-1", strconv.Itoa(round), "runner-1", "",
+			map[string][]float64{"w": {0.1, float64(round)}}, map[string][]float64{"w": {0.2, float64(round)}}, 10, 0.5, 0.9, 3); err != nil {
+			t.Errorf("SubmitFLModelUpdate() compressed error = %v", err)
+		}
+	}
+	if err := client.AcknowledgeFLRound(&models.FLRoundAck{SessionID: "session-1", RoundID: "round-1", RunnerID: "runner-1", Accepted: true}); err != nil {
+		t.Errorf("AcknowledgeFLRound() error = %v", err)
+	}
+
+	server.responses["patchRunnerCapabilities"] = models.CapabilityAck{Version: 2}
+	patch := &models.CapabilityPatch{BaseVersion: 1, Version: 2, Fields: map[string]json.RawMessage{
+		"model_capabilities": json.RawMessage(`[{"model_name":"llama3","is_loaded":true,"max_tokens":4096,"fits_memory":true}]`),
+		"gpu":                json.RawMessage("null"),
+	}}
+	if ack, err := client.PatchRunnerCapabilities(patch); err != nil || ack.Version != 2 {
+		t.Errorf("PatchRunnerCapabilities() = %+v, %v", ack, err)
+	}
+
+	server.responses["rebindRunner"] = models.RunnerRebindAck{Accepted: true, Generation: 2}
+	rebind := &models.RunnerRebind{DeviceID: "device-1", FromMachine: "old", ToMachine: "new", ArchiveID: "a1", ExportedAt: time.Now()}
+	if ack, err := client.RebindRunner(rebind); err != nil || ack.Generation != 2 {
+		t.Errorf("RebindRunner() = %+v, %v", ack, err)
+	}
+
+	score := 87.5
+	server.responses["getRunnerInfo"] = models.RunnerInfo{DeviceID: "device-1", ReputationScore: &score, ReputationFormulaVersion: 1, ReputationUpdatedAt: time.Now()}
+	if info, err := client.GetRunnerInfo(); err != nil || info.ReputationScore == nil || *info.ReputationScore != score {
+		t.Errorf("GetRunnerInfo() = %+v, %v", info, err)
+	}
+
+	for _, endpoint := range server.doc.Endpoints() {
+		if server.calls[endpoint.OperationID] == 0 {
+			t.Errorf("%s (%s %s) is not exercised by any task client method", endpoint.OperationID, endpoint.Method, endpoint.Path)
+		}
+	}
+}
+
+// groupSummary is a group summary with one shard, taskID
+func groupSummary(taskID string) *models.GroupSummary {
+	now := time.Now()
+	return &models.GroupSummary{
+		GroupID:         "sweep-1",
+		Shards:          4,
+		ShardResults:    []models.ShardSummary{{TaskID: taskID, Shard: 2, Status: models.TaskStatusCompleted, DurationMs: 1500}},
+		Completed:       1,
+		StartedAt:       now.Add(-2 * time.Second),
+		FinishedAt:      now,
+		TotalDurationMs: 1500,
+		MeanDurationMs:  1500,
+		MaxDurationMs:   1500,
+	}
+}
+
+func TestTaskClientMapsErrorStatuses(t *testing.T) {
+	taskID := uuid.New().String()
+	lease := &models.TaskLease{TaskID: taskID, LeaseID: "lease-1", TTL: time.Minute}
+	capabilityPatch := &models.CapabilityPatch{BaseVersion: 1, Version: 2, Fields: map[string]json.RawMessage{"gpu": json.RawMessage("null")}}
+	rebind := &models.RunnerRebind{DeviceID: "device-1", FromMachine: "old", ToMachine: "new", ArchiveID: "a1", ExportedAt: time.Now()}
+	poisonReport := &models.PoisonReport{Fingerprint: "0123456789abcdef", Attempts: []models.PoisonAttempt{{Fingerprint: "0123456789abcdef", Runner: "runner-1", ExitCode: -1, FailedAt: time.Now()}}}
+	migration := &models.TaskMigration{Bundle: "bafybundle", SHA256: strings.Repeat("ab", 32), Size: 2048, CreatedAt: time.Now()}
+
+	tests := []struct {
+		op     string
+		status int
+		call   func(c *HTTPTaskClient) error
+		want   error
+	}{
+		{"getTask", http.StatusNotFound, func(c *HTTPTaskClient) error { _, err := c.GetTask(taskID); return err }, ErrTaskNotFound},
+		{"startTask", http.StatusConflict, func(c *HTTPTaskClient) error { return c.StartTask(taskID) }, ErrTaskUnavailable},
+		{"startTask", http.StatusNotFound, func(c *HTTPTaskClient) error { return c.StartTask(taskID) }, ErrTaskNotFound},
+		{"renewLease", http.StatusGone, func(c *HTTPTaskClient) error { _, err := c.RenewLease(lease); return err }, ErrLeaseLost},
+		{"renewLease", http.StatusConflict, func(c *HTTPTaskClient) error { _, err := c.RenewLease(lease); return err }, ErrLeaseLost},
+		{"releaseTask", http.StatusConflict, func(c *HTTPTaskClient) error { return c.ReleaseTask(taskID, lease) }, nil},
+		{"releaseTask", http.StatusNotFound, func(c *HTTPTaskClient) error { return c.ReleaseTask(taskID, nil) }, nil},
+		{"releaseTask", http.StatusGone, func(c *HTTPTaskClient) error {
+			return c.ReleasePoisoned(taskID, lease, poisonReport)
+		}, nil},
+		{"getTaskResult", http.StatusNotFound, func(c *HTTPTaskClient) error { _, err := c.GetTaskResult(taskID, nil); return err }, ErrTaskNotFound},
+		{"getTaskResult", http.StatusGone, func(c *HTTPTaskClient) error { _, err := c.GetTaskResult(taskID, nil); return err }, ErrResultExpired},
+		{"getTaskResult", http.StatusGone, func(c *HTTPTaskClient) error {
+			_, err := c.DescribeArtifact(context.Background(), taskID, "model.bin")
+			return err
+		}, inputs.ErrArtifactExpired},
+		{"getTaskArtifact", http.StatusGone, func(c *HTTPTaskClient) error {
+			_, err := c.OpenArtifact(context.Background(), taskID, "model.bin")
+			return err
+		}, inputs.ErrArtifactExpired},
+		{"patchRunnerCapabilities", http.StatusConflict, func(c *HTTPTaskClient) error { _, err := c.PatchRunnerCapabilities(capabilityPatch); return err }, ErrCapabilityConflict},
+		{"patchRunnerCapabilities", http.StatusNotFound, func(c *HTTPTaskClient) error { _, err := c.PatchRunnerCapabilities(capabilityPatch); return err }, ErrCapabilityPatchUnsupported},
+		{"patchRunnerCapabilities", http.StatusNotImplemented, func(c *HTTPTaskClient) error { _, err := c.PatchRunnerCapabilities(capabilityPatch); return err }, ErrCapabilityPatchUnsupported},
+		{"submitGroupSummary", http.StatusNotFound, func(c *HTTPTaskClient) error { return c.SubmitGroupSummary(groupSummary(taskID)) }, ErrGroupSummaryUnsupported},
+		{"patchTaskResult", http.StatusNotFound, func(c *HTTPTaskClient) error { return c.PatchResultMetadata(taskID, map[string]interface{}{"k": 1}) }, ErrResultPatchUnsupported},
+		{"patchTaskResult", http.StatusMethodNotAllowed, func(c *HTTPTaskClient) error { return c.PatchResultMetadata(taskID, map[string]interface{}{"k": 1}) }, ErrResultPatchUnsupported},
+		{"requestTaskMigration", http.StatusNotFound, func(c *HTTPTaskClient) error { return c.RequestTaskMigration(taskID, lease, migration) }, ErrMigrationUnsupported},
+		{"requestTaskMigration", http.StatusNotImplemented, func(c *HTTPTaskClient) error { return c.RequestTaskMigration(taskID, nil, migration) }, ErrMigrationUnsupported},
+		{"requestTaskMigration", http.StatusGone, func(c *HTTPTaskClient) error { return c.RequestTaskMigration(taskID, lease, migration) }, ErrLeaseLost},
+		{"submitGroupSummary", http.StatusNotImplemented, func(c *HTTPTaskClient) error { return c.SubmitGroupSummary(groupSummary(taskID)) }, ErrGroupSummaryUnsupported},
+		{"rebindRunner", http.StatusConflict, func(c *HTTPTaskClient) error { _, err := c.RebindRunner(rebind); return err }, ErrRebindRefused},
+		{"rebindRunner", http.StatusNotImplemented, func(c *HTTPTaskClient) error { _, err := c.RebindRunner(rebind); return err }, ErrRebindUnsupported},
+		{"getRunnerInfo", http.StatusNotFound, func(c *HTTPTaskClient) error { _, err := c.GetRunnerInfo(); return err }, ErrRunnerInfoUnsupported},
+		{"getRunnerInfo", http.StatusNotImplemented, func(c *HTTPTaskClient) error { _, err := c.GetRunnerInfo(); return err }, ErrRunnerInfoUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.op+"/"+http.StatusText(tt.status), func(t *testing.T) {
+			server, client := newContractServer(t)
+			server.statuses[tt.op] = tt.status
+
+			err := tt.call(client)
+			if tt.want == nil && err != nil {
+				t.Fatalf("error = %v, want nil", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskClientFetchesResultRange(t *testing.T) {
+	server, client := newContractServer(t)
+	taskID := uuid.New().String()
+	output := "0123456789abcdef"
+	server.responses["getTaskResult"] = models.TaskResultPage{
+		TaskID:       taskID,
+		Output:       output[4:12],
+		OutputOffset: 4,
+		OutputSize:   int64(len(output)),
+	}
+
+	page, err := client.GetTaskResult(taskID, &models.ResultQuery{
+		Fields:       []string{models.ResultFieldOutput},
+		OutputOffset: 4,
+		OutputLength: 8,
+	})
+	if err != nil {
+		t.Fatalf("GetTaskResult() error = %v", err)
+	}
+	if page.Output != "456789ab" || page.OutputOffset != 4 || page.OutputSize != 16 || page.Result != nil {
+		t.Errorf("GetTaskResult() = %+v, want bytes 4-11 of the output alone", page)
+	}
+	query := server.queries["getTaskResult"]
+	want := url.Values{"fields": {"output"}, "output_offset": {"4"}, "output_length": {"8"}}
+	if query.Encode() != want.Encode() {
+		t.Errorf("query = %s, want %s", query.Encode(), want.Encode())
+	}
+
+	// The zero query asks for the whole result
+	if _, err := client.GetTaskResult(taskID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if query := server.queries["getTaskResult"]; len(query) != 0 {
+		t.Errorf("query = %s, want none", query.Encode())
+	}
+	if _, err := client.GetTaskResult(taskID, &models.ResultQuery{OutputOffset: -1}); err == nil {
+		t.Error("GetTaskResult() accepted a negative offset")
+	}
+}
+
+// submittedUpdate decodes the last model update the server received
+func submittedUpdate(t *testing.T, server *contractServer) *apiclient.ModelUpdate {
+	t.Helper()
+	var update apiclient.ModelUpdate
+	if err := json.Unmarshal(server.bodies["submitModelUpdate"], &update); err != nil {
+		t.Fatal(err)
+	}
+	return &update
+}
+
+func TestTaskClientSubmitsCanonicalFLUpdate(t *testing.T) {
+	server, client := newContractServer(t)
+	gradients := map[string][]float64{"b": {math.Copysign(0, -1), 1e-7}, "a": {1e21}}
+	if err := client.SubmitFLModelUpdate("session-1", "round-1", "runner-1", "", gradients, nil, 10, 0.5, 0.9, 3); err != nil {
+		t.Fatal(err)
+	}
+	body := server.bodies["submitModelUpdate"]
+	canonical, err := flcanon.Canonicalize(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, canonical) || !bytes.Contains(body, []byte(`"gradients":{"a":[1e+21],"b":[0,1e-7]}`)) {
+		t.Errorf("update sent as %s, want it canonical", body)
+	}
+
+	delete(server.bodies, "submitModelUpdate")
+	gradients["a"] = []float64{math.NaN()}
+	if err := client.SubmitFLModelUpdate("session-1", "round-2", "runner-1", "", gradients, nil, 10, 0.5, 0.9, 3); !errors.Is(err, flcanon.ErrNonFinite) {
+		t.Errorf("SubmitFLModelUpdate() error = %v, want ErrNonFinite", err)
+	}
+	if err := client.SubmitFLModelUpdate("session-1", "round-2", "runner-1", "", nil, nil, 10, math.Inf(1), 0.9, 3); !errors.Is(err, flcanon.ErrNonFinite) {
+		t.Errorf("SubmitFLModelUpdate() error = %v for an infinite loss, want ErrNonFinite", err)
+	}
+	if server.bodies["submitModelUpdate"] != nil {
+		t.Error("update with a non-finite value was sent")
+	}
+}
+
+func TestTaskClientNegotiatesFLDictionary(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		ack      *apiclient.DictionaryAck
+		accepted bool
+	}{
+		{"accepted", http.StatusOK, &apiclient.DictionaryAck{Accepted: true}, true},
+		{"declined", http.StatusOK, &apiclient.DictionaryAck{Reason: "dictionaries disabled"}, false},
+		{"not found", http.StatusNotFound, nil, false},
+		{"not implemented", http.StatusNotImplemented, nil, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := newContractServer(t)
+			server.statuses["offerCompressionDictionary"] = tt.status
+			if tt.ack != nil {
+				server.responses["offerCompressionDictionary"] = tt.ack
+			}
+			codec, err := flcompress.New(flcompress.Config{TrainAfter: 2})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.SetFLCompression(codec)
+
+			var dictionary *flcompress.Dictionary
+			for round := 0; round < 5; round++ {
+				gradients := map[string][]float64{"layer.weight": {0.01, -0.02, float64(round) / 100}}
+				weights := map[string][]float64{"layer.weight": {0.5, 0.25, float64(round) / 10}}
+				if err := client.SubmitFLModelUpdate("session-1", strconv.Itoa(round), "runner-1", "", gradients, weights, 10, 0.5, 0.9, 3); err != nil {
+					t.Fatalf("round %d: SubmitFLModelUpdate() error = %v", round, err)
+				}
+				update := submittedUpdate(t, server)
+				if update.Gradients != nil || update.Weights != nil {
+					t.Fatalf("round %d: update sent uncompressed", round)
+				}
+				if offer := server.bodies["offerCompressionDictionary"]; dictionary == nil && offer != nil {
+					var offered apiclient.CompressionDictionary
+					if err := json.Unmarshal(offer, &offered); err != nil {
+						t.Fatal(err)
+					}
+					dictionary = &flcompress.Dictionary{ID: uint32(offered.ID), Hash: offered.Hash, Data: offered.Dictionary}
+				}
+
+				want := flcompress.EncodingZstd
+				if tt.accepted && dictionary != nil {
+					want = flcompress.EncodingZstdDict
+				}
+				if update.PayloadEncoding != want {
+					t.Fatalf("round %d: payload_encoding = %s, want %s", round, update.PayloadEncoding, want)
+				}
+				raw, err := flcompress.Decode(&flcompress.Payload{
+					Encoding:       update.PayloadEncoding,
+					Data:           update.Payload,
+					DictionaryHash: update.DictionaryHash,
+				}, dictionary)
+				if err != nil {
+					t.Fatalf("round %d: %v", round, err)
+				}
+				var decoded struct {
+					Gradients map[string][]float64 `json:"gradients"`
+					Weights   map[string][]float64 `json:"weights"`
+				}
+				if err := json.Unmarshal(raw, &decoded); err != nil || decoded.Weights["layer.weight"][2] != weights["layer.weight"][2] {
+					t.Fatalf("round %d: payload decoded to %s, %v", round, raw, err)
+				}
+			}
+			if dictionary == nil {
+				t.Fatal("no dictionary offered")
+			}
+			if server.calls["offerCompressionDictionary"] != 1 {
+				t.Errorf("dictionary offered %d times, want once", server.calls["offerCompressionDictionary"])
+			}
+		})
+	}
+}
+
+func TestFetchTaskClaimsEachTaskOnce(t *testing.T) {
+	taken, first, second := uuid.New(), uuid.New(), uuid.New()
+	var (
+		mu     sync.Mutex
+		starts = make(map[string]int)
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/runners/tasks/available" {
+			json.NewEncoder(w).Encode([]*models.Task{{ID: taken}, {ID: first}, {ID: second}})
+			return
+		}
+		taskID := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/runners/tasks/"), "/start")
+		mu.Lock()
+		starts[taskID]++
+		mu.Unlock()
+		if taskID == taken.String() {
+			http.Error(w, "task is assigned to another runner", http.StatusConflict)
+		}
+	}))
+	defer server.Close()
+	client := NewHTTPTaskClient(server.URL, apiclient.DefaultRetryPolicy)
+	client.SetDeviceID("device-1")
+
+	// Two workers fetching at once claim different tasks, passing over
+	// the one another runner holds
+	fetched := make(chan *models.Task, 2)
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			task, err := client.FetchTask()
+			if err != nil {
+				t.Errorf("FetchTask() error = %v", err)
+				return
+			}
+			fetched <- task
+		}()
+	}
+	wg.Wait()
+	close(fetched)
+	claimed := make(map[uuid.UUID]bool)
+	for task := range fetched {
+		claimed[task.ID] = true
+	}
+	if len(claimed) != 2 || !claimed[first] || !claimed[second] {
+		t.Fatalf("workers claimed %v, want the two unclaimed tasks once each", claimed)
+	}
+	if _, err := client.FetchTask(); !errors.Is(err, ErrNoTasks) {
+		t.Fatalf("FetchTask() with every task held = %v, want ErrNoTasks", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if starts[first.String()] != 1 || starts[second.String()] != 1 {
+		t.Errorf("claims sent = %v, want one per unclaimed task", starts)
+	}
+}
+
+func TestReleasedTasksCanBeClaimedAgain(t *testing.T) {
+	taskID := uuid.New()
+	var (
+		mu     sync.Mutex
+		starts int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/runners/tasks/available":
+			json.NewEncoder(w).Encode([]*models.Task{{ID: taskID}})
+		case "/api/v1/runners/tasks/" + taskID.String() + "/start":
+			mu.Lock()
+			starts++
+			mu.Unlock()
+		}
+	}))
+	defer server.Close()
+	client := NewHTTPTaskClient(server.URL, apiclient.DefaultRetryPolicy)
+	client.SetDeviceID("device-1")
+
+	for _, release := range []func() error{
+		func() error { return client.ReleaseTask(taskID.String(), nil) },
+		func() error { return client.ReleasePoisoned(taskID.String(), nil, &models.PoisonReport{}) },
+	} {
+		task, err := client.FetchTask()
+		if err != nil || task.ID != taskID {
+			t.Fatalf("FetchTask() = %v, %v, want the available task", task, err)
+		}
+		if err := release(); err != nil {
+			t.Fatalf("release error = %v", err)
+		}
+	}
+	if task, err := client.FetchTask(); err != nil || task.ID != taskID {
+		t.Fatalf("FetchTask() after release = %v, %v, want the task claimed again", task, err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if starts != 3 {
+		t.Errorf("claims sent = %d, want 3", starts)
+	}
+}
+
+func TestStatusUpdatesLockEachTaskAlone(t *testing.T) {
+	slow, fast := uuid.New().String(), uuid.New().String()
+	held := make(chan struct{})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/runners/tasks/"+slow+"/complete" {
+			close(held)
+			<-release
+		}
+	}))
+	defer server.Close()
+	client := NewHTTPTaskClient(server.URL, apiclient.DefaultRetryPolicy)
+	client.SetDeviceID("device-1")
+
+	done := make(chan error, 1)
+	go func() { done <- client.UpdateTaskStatus(slow, models.TaskStatusCompleted, nil) }()
+	<-held
+	// Another task is never held back by the update in flight
+	if err := client.UpdateTaskStatus(fast, models.TaskStatusCompleted, nil); err != nil {
+		t.Fatalf("UpdateTaskStatus() error = %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("UpdateTaskStatus() error = %v", err)
+	}
+
+	client.taskLocksMu.Lock()
+	defer client.taskLocksMu.Unlock()
+	if len(client.taskLocks) != 0 {
+		t.Errorf("%d task locks kept after every update finished", len(client.taskLocks))
+	}
+}
@@ -3,51 +3,471 @@ package runner
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/theblitlabs/deviceid"
 	"github.com/theblitlabs/gologger"
 
+	"github.com/theblitlabs/parity-runner/internal/accounting"
+	"github.com/theblitlabs/parity-runner/internal/anomaly"
+	"github.com/theblitlabs/parity-runner/internal/attestation"
+	"github.com/theblitlabs/parity-runner/internal/audit"
+	"github.com/theblitlabs/parity-runner/internal/budget"
+	"github.com/theblitlabs/parity-runner/internal/caches"
+	"github.com/theblitlabs/parity-runner/internal/callback"
+	"github.com/theblitlabs/parity-runner/internal/canary"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/concurrency"
+	"github.com/theblitlabs/parity-runner/internal/confirm"
 	"github.com/theblitlabs/parity-runner/internal/core/models"
 	"github.com/theblitlabs/parity-runner/internal/core/ports"
+	"github.com/theblitlabs/parity-runner/internal/diskreserve"
+	"github.com/theblitlabs/parity-runner/internal/errbudget"
+	"github.com/theblitlabs/parity-runner/internal/errreport"
+	"github.com/theblitlabs/parity-runner/internal/escrow"
+	"github.com/theblitlabs/parity-runner/internal/events"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+	"github.com/theblitlabs/parity-runner/internal/execution/tasklog"
+	"github.com/theblitlabs/parity-runner/internal/fleetguard"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/hints"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/hostreserve"
+	"github.com/theblitlabs/parity-runner/internal/idlework"
+	"github.com/theblitlabs/parity-runner/internal/inputs"
+	"github.com/theblitlabs/parity-runner/internal/materialize"
+	"github.com/theblitlabs/parity-runner/internal/migration"
+	"github.com/theblitlabs/parity-runner/internal/poison"
+	"github.com/theblitlabs/parity-runner/internal/power"
+	"github.com/theblitlabs/parity-runner/internal/pricing"
+	"github.com/theblitlabs/parity-runner/internal/redact"
+	"github.com/theblitlabs/parity-runner/internal/retention"
+	"github.com/theblitlabs/parity-runner/internal/revocation"
+	"github.com/theblitlabs/parity-runner/internal/tenancy"
+	"github.com/theblitlabs/parity-runner/internal/timeline"
 	"github.com/theblitlabs/parity-runner/internal/utils"
 )
 
 type DefaultTaskHandler struct {
 	executor     ports.TaskExecutor
 	taskClient   ports.TaskClient
+	hardware     *hardware.Profile
+	network      atomic.Pointer[hardware.NetworkProfile]
+	leases       *leaseKeeper
+	timeouts     *TimeoutPolicy
+	history      *history.Store
+	callbacks    *callback.Notifier
+	llmStats     *llm.Stats
+	caches       *caches.Registry
+	attester     *attestation.Attester
+	preflight    atomic.Value // docker.PreflightMode
+	clock        clock.Clock
 	isProcessing atomic.Bool
+	flightMu     sync.Mutex
+	inFlight     int
+	gpu          *gpu.Allocator
+	gpuTasks     *gpuTasks
+	power        *power.Monitor
+	powerAction  power.InFlightAction
+	powerTasks   powerTasks
+	auditor      *audit.Auditor
+	audits       auditState
+	reporter     *errreport.Reporter
+	guard        *fleetguard.Guard
+	poison       *poison.Tracker
+	hints        *hints.Board
+	groups       *groupTracker
+	redactor     *redact.Redactor
+	budget       *budget.Tracker
+	pricing      *pricing.Gate
+	canaries     *canary.Monitor
+	errorBudget  *errbudget.Guard
+	concurrency  *concurrency.Controller
+	draining     atomic.Bool
+	paused       atomic.Bool
+	handoff      handoffState
+	outbox       *retention.Outbox
+	journal      *accounting.Journal
+	// instance is set when the handler is one of several logical runners
+	// of the process, whose tasks metrics counts
+	instance *tenancy.Instance
+	metrics  *tenancy.Metrics
+	// unsupported counts the tasks offered of types the executor does not
+	// run, by type
+	unsupportedMu sync.Mutex
+	unsupported   map[models.TaskType]uint64
+	// force bypasses the scheduling filters when running a task on demand
+	force     bool
+	logOutput io.Writer
+	// confirm asks the operator before claiming expensive tasks
+	confirm *confirm.Gate
+
+	// trainingMemory bounds the memory FL training holds at once; zero
+	// leaves it unbounded
+	trainingMemory uint64
+	// clockInfo describes the host clock in results
+	clockInfo *clockReporter
+	// events receives what happens to the handler's tasks
+	events *events.Bus
+	// migrations records the state running tasks could resume from on
+	// another runner, and migrationStore uploads and downloads it
+	migrations     *migration.Tracker
+	migrationStore *migration.Store
+	migrationTasks migrationTasks
+	// timelines records the phases of the tasks the handler runs
+	timelines *timeline.Tracker
+	// buildFingerprint fingerprints the runner binary in every result
+	buildFingerprint string
+	// escrower escrows the results of tasks asking for it
+	escrower *escrow.Escrower
+	// disk holds the disk space reserved for admitted tasks, shared by
+	// the runners of one process
+	disk *diskreserve.Ledger
+	// host holds the memory and CPU reserved for admitted tasks, which
+	// hostDefault is for those declaring none
+	host        *hostreserve.Ledger
+	hostDefault hostreserve.Demand
+	// handling is the IDs of the tasks being handled, so a task offered
+	// twice is not run twice
+	handling sync.Map
+	// anomalies reviews results for signs of the runner's own faults
+	// before they are submitted
+	anomalies *anomaly.Detector
+	// donor runs the rounds of community FL sessions while no paid task
+	// runs
+	donor *idlework.Donor
+	// executionTaps relays the progress of running tasks to their
+	// executions
+	executionTaps executionTaps
+	// revocations lists the tasks the network revoked, which the handler
+	// kills and declines; revocationAudit records what it did about them
+	revocations     *revocation.List
+	revocationAudit *revocation.AuditLog
+	revocable       revocableTasks
+	// materializer delivers successful results through the plugins tasks
+	// name
+	materializer *materialize.Materializer
+	// heartbeats receives a heartbeat of each task running without a
+	// lease every heartbeatInterval it reports nothing itself
+	heartbeats        docker.ProgressSink
+	heartbeatInterval time.Duration
 }
 
 type LLMTaskClient interface {
-	CompletePrompt(promptID string, response string, promptTokens, responseTokens int, inferenceTime int64) error
+	CompletePrompt(promptID string, response string, promptTokens, responseTokens int, inferenceTime int64, usage *models.TokenUsage, format *models.ResponseFormatReport, cachedPromptID string) error
 }
 
 func NewTaskHandler(executor ports.TaskExecutor, taskClient ports.TaskClient) *DefaultTaskHandler {
-	return &DefaultTaskHandler{
+	h := &DefaultTaskHandler{
 		executor:   executor,
 		taskClient: taskClient,
+		hardware:   hardware.Detect(),
+		timeouts:   NewTimeoutPolicy(TimeoutPolicyConfig{}, nil),
+		clock:      clock.Real(),
+		gpuTasks:   &gpuTasks{},
+		groups:     newGroupTracker(),
+		timelines:  timeline.NewTracker(clock.Real()),
+	}
+	if leaseClient, ok := taskClient.(LeaseClient); ok {
+		h.leases = newLeaseKeeper(leaseClient, nil)
+	}
+	return h
+}
+
+// SetClock replaces the clock used for retries, lease renewal and durations
+func (h *DefaultTaskHandler) SetClock(c clock.Clock) {
+	h.clock = c
+	h.timelines.SetClock(c)
+	if h.leases != nil {
+		h.leases.clock = c
+	}
+}
+
+// SetLeaseStore enables persistence of task leases across runner restarts
+func (h *DefaultTaskHandler) SetLeaseStore(store *LeaseStore) {
+	if h.leases != nil {
+		h.leases.store = store
+	}
+}
+
+// SetHistory records finished executions in store and derives default
+// timeouts from it using policy
+func (h *DefaultTaskHandler) SetHistory(store *history.Store, policy *TimeoutPolicy) {
+	h.history = store
+	h.timeouts = policy
+}
+
+// recordHistory records a finished execution. Completed executions are kept
+// for audit replay when audits are enabled.
+func (h *DefaultTaskHandler) recordHistory(task *models.Task, run clock.Stopwatch, status models.TaskStatus, result *models.TaskResult, code models.FailureCode) {
+	completed := events.TaskCompleted{
+		Task:        task,
+		Result:      result,
+		TaskID:      task.ID.String(),
+		Type:        task.Type,
+		Instance:    h.instanceName(),
+		Status:      status,
+		Duration:    run.Elapsed(),
+		FailureCode: code,
+	}
+	if status == models.TaskStatusCompleted {
+		completed.ResultDigest = h.auditDigest(task, result)
+	}
+	defer h.publish(completed)
+	h.observeConcurrency(task, run.StartedAt(), completed.Duration, code)
+
+	if h.history == nil {
+		return
+	}
+	record := history.Record{
+		TaskID:       task.ID.String(),
+		Type:         task.Type,
+		Workload:     workloadKey(task),
+		Status:       status,
+		StartedAt:    run.StartedAt(),
+		DurationMs:   completed.Duration.Milliseconds(),
+		ResultDigest: completed.ResultDigest,
+		Instance:     h.instanceName(),
+		FailureCode:  code,
+		Verified:     resultVerified(result),
+	}
+	if err := h.history.Append(record); err != nil {
+		log := gologger.WithComponent("task_handler")
+		log.Warn().Err(err).Str("id", task.ID.String()).Msg("Failed to record task history")
+	}
+}
+
+// resultVerified reports whether result passed its output manifest and
+// post-processing verdict, or nil when it had neither
+func resultVerified(result *models.TaskResult) *bool {
+	if result == nil {
+		return nil
+	}
+	var verified *bool
+	if result.OutputVerdict != nil {
+		passed := result.OutputVerdict.Passed
+		verified = &passed
+	}
+	if result.PostProcess != nil && result.PostProcess.Verdict != nil {
+		passed := *result.PostProcess.Verdict && (verified == nil || *verified)
+		verified = &passed
+	}
+	return verified
+}
+
+// SetCallbackNotifier enables delivery of result summaries to creator callback URLs
+func (h *DefaultTaskHandler) SetCallbackNotifier(notifier *callback.Notifier) {
+	h.callbacks = notifier
+}
+
+// notifyCallback pushes a result summary to the creator's callback URL once
+// the server has accepted a task's result. Failures are logged and recorded
+// in history but never affect the task status.
+func (h *DefaultTaskHandler) notifyCallback(e events.Event) {
+	uploaded, ok := e.(events.ResultUploaded)
+	if !ok || h.callbacks == nil || uploaded.Task.CallbackURL == "" {
+		return
+	}
+	task, result, status := uploaded.Task, uploaded.Result, uploaded.Status
+
+	h.callbacks.Notify(task.CallbackURL, callback.NewSummary(task, result, status), func(err error) {
+		if err == nil {
+			return
+		}
+		log := gologger.WithComponent("task_handler")
+		log.Warn().Err(err).Str("id", task.ID.String()).Msg("Failed to deliver creator callback")
+
+		if h.history != nil {
+			record := history.Record{
+				TaskID:    task.ID.String(),
+				Type:      task.Type,
+				Workload:  workloadKey(task),
+				Status:    status,
+				StartedAt: h.clock.Now(),
+				Event:     history.EventCallbackFailed,
+				Error:     err.Error(),
+				Instance:  h.instanceName(),
+			}
+			if err := h.history.Append(record); err != nil {
+				log.Warn().Err(err).Str("id", task.ID.String()).Msg("Failed to record callback failure")
+			}
+		}
+	})
+}
+
+// SetLLMStats attaches the expected time to first token from stats when
+// claiming LLM tasks
+func (h *DefaultTaskHandler) SetLLMStats(stats *llm.Stats) {
+	h.llmStats = stats
+}
+
+// claimHint describes how quickly this runner expects to start answering an
+// LLM task, or nil for other tasks
+func (h *DefaultTaskHandler) claimHint(task *models.Task) *models.ClaimHint {
+	if h.llmStats == nil || task.Type != models.TaskTypeLLM {
+		return nil
+	}
+	model := llm.TaskModel(task.Config)
+	hint := &models.ClaimHint{
+		Model:      model,
+		QueueDepth: h.llmStats.ModelStats(model).QueueDepth,
+	}
+	if ttft, ok := h.llmStats.ExpectedTTFT(model); ok {
+		hint.ExpectedTTFTMs = ttft.Milliseconds()
+	}
+	return hint
+}
+
+// claimTask marks the task as running and returns the lease granted by the server, if any
+func (h *DefaultTaskHandler) claimTask(task *models.Task) (*models.TaskLease, error) {
+	lease, err := h.startTask(task)
+	if err == nil {
+		h.accountBegin(task)
+		h.publish(events.TaskClaimed{
+			Task:      task,
+			TaskID:    task.ID.String(),
+			Type:      task.Type,
+			Instance:  h.instanceName(),
+			ClaimedAt: h.clock.Now(),
+			Timeout:   h.runtimeBound(task),
+		})
+	}
+	return lease, err
+}
+
+func (h *DefaultTaskHandler) startTask(task *models.Task) (*models.TaskLease, error) {
+	taskID := task.ID.String()
+	if h.leases != nil {
+		if hint := h.claimHint(task); hint != nil {
+			if hinted, ok := h.leases.client.(HintedLeaseClient); ok {
+				return hinted.StartTaskWithHint(taskID, hint)
+			}
+		}
+		return h.leases.client.StartTaskWithLease(taskID)
 	}
+	return nil, h.taskClient.UpdateTaskStatus(taskID, models.TaskStatusRunning, nil)
+}
+
+// SetNetworkProfile updates the measured bandwidth used to skip tasks this
+// runner would bottleneck
+func (h *DefaultTaskHandler) SetNetworkProfile(profile *hardware.NetworkProfile) {
+	h.network.Store(profile)
+}
+
+// SetDraining stops the handler from accepting new tasks and FL rounds
+func (h *DefaultTaskHandler) SetDraining(draining bool) {
+	h.draining.Store(draining)
+}
+
+// SetPaused stops the handler from accepting new tasks and FL rounds
+// until it is resumed, without reporting the runner as not ready
+func (h *DefaultTaskHandler) SetPaused(paused bool) {
+	h.paused.Store(paused)
 }
 
 func (h *DefaultTaskHandler) IsProcessing() bool {
 	return h.isProcessing.Load()
 }
 
+// begin marks a task in flight
+func (h *DefaultTaskHandler) begin() {
+	h.flightMu.Lock()
+	defer h.flightMu.Unlock()
+	h.inFlight++
+	h.isProcessing.Store(true)
+}
+
+// beginExclusive marks a task in flight only when no other is
+func (h *DefaultTaskHandler) beginExclusive() bool {
+	h.flightMu.Lock()
+	defer h.flightMu.Unlock()
+	if !h.isProcessing.CompareAndSwap(false, true) {
+		return false
+	}
+	h.inFlight++
+	return true
+}
+
+// end marks a task finished
+func (h *DefaultTaskHandler) end() {
+	h.flightMu.Lock()
+	defer h.flightMu.Unlock()
+	h.inFlight--
+	if h.inFlight <= 0 {
+		h.inFlight = 0
+		h.isProcessing.Store(false)
+	}
+}
+
+// tasksInFlight returns the number of tasks running
+func (h *DefaultTaskHandler) tasksInFlight() int {
+	h.flightMu.Lock()
+	defer h.flightMu.Unlock()
+	return h.inFlight
+}
+
 func (h *DefaultTaskHandler) verifyNonce(nonceStr string) error {
 	return utils.VerifyDrandNonce(nonceStr)
 }
 
+// HandleTask runs task. A shard of a group is followed by the group's
+// other shards waiting to be claimed, lowest index first, while the runner
+// has capacity for them, so that they reuse the image and inputs it
+// cached. The runner then submits the group's summary.
 func (h *DefaultTaskHandler) HandleTask(task *models.Task) error {
-	if h.isProcessing.Load() {
-		return fmt.Errorf("task already in progress")
+	if task.Group == nil {
+		return h.handleTask(task)
+	}
+	release := h.holdCaches(context.Background(), task)
+	defer release()
+
+	err := h.handleTask(task)
+	tried := map[string]bool{task.ID.String(): true}
+	for next := h.nextShard(task.Group, tried); next != nil; next = h.nextShard(task.Group, tried) {
+		tried[next.ID.String()] = true
+		var admission *admissionError
+		if errors.As(h.handleTask(next), &admission) {
+			break
+		}
 	}
+	h.submitGroupSummary(task.Group)
+	return err
+}
 
+func (h *DefaultTaskHandler) handleTask(task *models.Task) error {
 	log := gologger.WithComponent("task_handler")
-	// Only log federated learning task starts at info level due to their importance
+	// A task offered again while it is handled, such as by both the
+	// webhook and polling, is left to the worker handling it
+	if _, handling := h.handling.LoadOrStore(task.ID.String(), struct{}{}); handling {
+		log.Debug().Str("id", task.ID.String()).Msg("Task already being handled - skipping")
+		return nil
+	}
+	defer h.handling.Delete(task.ID.String())
+
+	feasibility := h.EvaluateFeasibility(task)
+	defer feasibility.Release()
+
+	// FL rounds are acknowledged either way so the coordinator never waits on
+	// a runner that will not train
 	if task.Type == models.TaskTypeFederatedLearning {
+		if err := h.acknowledgeFLRound(task, feasibility); err != nil {
+			return err
+		}
+	} else if admission := feasibility.admission(); admission != nil {
+		logSkipped(task, admission)
+		return admission
+	}
+
+	// Only log federated learning task starts at info level due to their importance
+	if h.donated(task) {
+		log.Info().Str("id", task.ID.String()).Msg("Starting donated FL training task")
+	} else if task.Type == models.TaskTypeFederatedLearning {
 		log.Info().
 			Str("id", task.ID.String()).
 			Str("type", string(task.Type)).
@@ -59,45 +479,396 @@ func (h *DefaultTaskHandler) HandleTask(task *models.Task) error {
 			Msg("Starting task execution")
 	}
 
-	h.isProcessing.Store(true)
-	defer h.isProcessing.Store(false)
-
 	if task.Type == models.TaskTypeLLM {
+		resumeDonation := h.preemptDonation(task)
+		defer resumeDonation()
+		h.begin()
+		defer h.end()
 		return h.handleLLMTask(task)
 	}
 
-	if err := h.taskClient.UpdateTaskStatus(task.ID.String(), models.TaskStatusRunning, nil); err != nil {
+	// The poll loop is a scheduler like any other
+	claimed, err := h.Claim(context.Background(), feasibility)
+	if err != nil {
+		var admission *admissionError
+		if errors.As(err, &admission) {
+			return admission
+		}
+		// Another runner claimed the task first; the next one offered is
+		// taken instead
+		if errors.Is(err, ErrTaskUnavailable) {
+			log.Debug().Err(err).Str("id", task.ID.String()).Msg("Task claimed by another runner - moving on")
+			return nil
+		}
 		log.Error().Err(err).Str("id", task.ID.String()).Msg("Failed to update task status to running")
+		h.reportError(task, errreport.CategoryClaim, "claim_failed", err)
+		return err
+	}
+	execution, err := h.Execute(context.Background(), claimed)
+	if err != nil {
+		return err
 	}
+	_, _, err = h.Report(context.Background(), execution)
+	return err
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Minute)
-	defer cancel()
+// logSkipped logs why the poll loop skips task
+func logSkipped(task *models.Task, admission *admissionError) {
+	log := gologger.WithComponent("task_handler")
+	switch admission.reason {
+	case models.FLDeclineInsufficientResources:
+		log.Info().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Str("type", string(task.Type)).
+			Msg("Skipping task - resource requirements not met")
+	case models.FLDeclineAttestationRequired:
+		log.Info().
+			Str("id", task.ID.String()).
+			Str("type", string(task.Type)).
+			Msg("Skipping task - attestation required but not supported")
+	case models.FLDeclineInvalidConfig:
+		log.Warn().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Str("type", string(task.Type)).
+			Msg("Skipping task - invalid config")
+	case models.FLDeclinePowerConstrained:
+		log.Info().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Str("type", string(task.Type)).
+			Msg("Skipping task - power conditions do not allow it")
+	case models.FLDeclineFiltered:
+		log.Debug().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Str("type", string(task.Type)).
+			Msg("Skipping task - not accepted by this instance")
+	case models.FLDeclineUnsupportedType:
+		log.Warn().
+			Str("id", task.ID.String()).
+			Str("type", string(task.Type)).
+			Msg("Skipping task - server offered a task type this runner does not run")
+	case models.FLDeclineNotConfirmed:
+		log.Info().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Str("type", string(task.Type)).
+			Msg("Skipping task - not confirmed by operator")
+	case models.FLDeclineBatchNormAccumulation:
+		log.Info().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Str("type", string(task.Type)).
+			Msg("Skipping task - batch normalization needs more memory than the training budget")
+	case models.FLDeclineBelowPriceFloor:
+		log.Info().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Str("type", string(task.Type)).
+			Float64("reward", task.Reward).
+			Msg("Skipping task - reward below price floor")
+	case models.FLDeclinePoisoned:
+		log.Info().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Str("type", string(task.Type)).
+			Msg("Skipping task - poisoned on this runner")
+	case models.FLDeclineGroupCancelled:
+		log.Info().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Str("group", task.Group.ID).
+			Msg("Skipping task - its group was cancelled")
+	case models.FLDeclineBudgetExhausted:
+		// The budget logs when it runs out and when it resets
+		log.Debug().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Str("type", string(task.Type)).
+			Msg("Skipping task - compute budget exhausted")
+	case models.FLDeclineIncompatibleImage:
+		log.Info().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Msg("Skipping task - command cannot run in its image")
+	case models.FLDeclineRevoked:
+		log.Warn().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Msg("Skipping task - revoked by the network")
+	case models.FLDeclineUnknownMaterializer:
+		log.Info().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Msg("Skipping task - materialization plugin not configured")
+	case models.FLDeclineNotHermetic:
+		log.Info().
+			Err(admission).
+			Str("id", task.ID.String()).
+			Msg("Skipping task - cannot run it hermetically")
+	}
+}
+
+// ResumeTask continues a task claimed before a runner restart whose lease was
+// successfully renewed, without claiming it again
+func (h *DefaultTaskHandler) ResumeTask(task *models.Task, lease *models.TaskLease) error {
+	if task.Type == models.TaskTypeLLM {
+		return fmt.Errorf("LLM tasks cannot be resumed")
+	}
+	if !h.beginExclusive() {
+		return fmt.Errorf("task already in progress")
+	}
+	defer h.end()
+
+	log := gologger.WithComponent("task_handler")
+	log.Info().
+		Str("id", task.ID.String()).
+		Time("lease_expires_at", lease.ExpiresAt).
+		Msg("Resuming task from persisted lease")
+
+	return h.runTask(task, lease, h.executor.ExecuteTask)
+}
+
+// executeFunc produces the result of a task, either by executing it or by
+// adopting an execution already in progress
+type executeFunc func(ctx context.Context, task *models.Task) (*models.TaskResult, error)
+
+func (h *DefaultTaskHandler) runTask(task *models.Task, lease *models.TaskLease, execute executeFunc) error {
+	_, _, err := h.runTaskOutcome(task, lease, execute)
+	return err
+}
+
+// runTaskOutcome runs a claimed task and reports its result, returning the
+// status it finished with, or an empty status if it did not finish here
+func (h *DefaultTaskHandler) runTaskOutcome(task *models.Task, lease *models.TaskLease, execute executeFunc) (models.TaskStatus, *models.TaskResult, error) {
+	x := h.prepareExecution(context.Background(), task, lease)
+	h.startExecution(x, execute)
+	<-x.done
+	return h.reportExecution(x)
+}
+
+// prepareExecution readies the context a claimed task runs under, bounded
+// by its timeout. An execution that cannot go ahead, such as one whose
+// nonce is invalid, ends then, its failure reported.
+func (h *DefaultTaskHandler) prepareExecution(parent context.Context, task *models.Task, lease *models.TaskLease) *Execution {
+	log := gologger.WithComponent("task_handler")
+	x := &Execution{
+		Task:     task,
+		h:        h,
+		lease:    lease,
+		outcome:  models.AttemptAbandoned,
+		progress: make(chan *models.TaskProgress, progressBuffer),
+		logs:     newLogStream(),
+		done:     make(chan struct{}),
+	}
+
+	timeout, appliedTimeout := h.timeouts.Resolve(task)
+	if appliedTimeout != nil {
+		log.Debug().
+			Str("id", task.ID.String()).
+			Str("source", string(appliedTimeout.Source)).
+			Dur("timeout", timeout).
+			Int("samples", appliedTimeout.Samples).
+			Msg("Applying default task timeout")
+	}
+	x.timeout, x.appliedTimeout = timeout, appliedTimeout
+
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	x.cancel = cancel
+	x.onReport(cancel)
+	ctx, x.tl = h.startTimeline(ctx, task)
+	x.onReport(func() { h.finishTimeline(task, x.outcome) })
+	out := io.Writer(x.logs)
+	if h.logOutput != nil {
+		out = io.MultiWriter(h.logOutput, x.logs)
+	}
+	out, flush := h.redactOutput(out)
+	x.onReport(flush)
+	ctx = tasklog.WithOutput(ctx, out)
+	ctx, stopGPU := h.withGPU(ctx, task)
+	x.onReport(stopGPU)
+	ctx = h.withDisk(ctx, task)
+	ctx = h.withDonation(ctx, task)
+	ctx, stopPower := h.withPower(ctx, task)
+	x.onReport(stopPower)
+	ctx, stopGroup := h.withGroup(ctx, task)
+	x.onReport(stopGroup)
+	ctx, stopMigration := h.withMigration(ctx, task)
+	x.onReport(stopMigration)
+	ctx, stopRevocation := h.withRevocation(ctx, task)
+	x.onReport(stopRevocation)
+	x.ctx = ctx
+
+	x.watch = h.leases.watch(ctx, task, lease, cancel)
+	x.onReport(x.watch.Stop)
+
+	h.handoff.track(task, cancel)
+	x.onReport(func() { h.handoff.untrack(task) })
 
 	if err := h.verifyNonce(task.Nonce); err != nil {
 		log.Error().Err(err).Str("id", task.ID.String()).Msg("Nonce verification failed")
+		h.reportError(task, errreport.CategoryExecution, "nonce_invalid", err)
+		h.account(task, h.journal.Abandon)
 		if updateErr := h.taskClient.UpdateTaskStatus(task.ID.String(), models.TaskStatusFailed, &models.TaskResult{
 			TaskID: task.ID,
 			Error:  err.Error(),
 		}); updateErr != nil {
 			log.Error().Err(updateErr).Str("id", task.ID.String()).Msg("Failed to update task status")
+		} else {
+			x.outcome = models.AttemptReported
 		}
-		return err
+		x.end(models.TaskStatusFailed, err)
+		return x
 	}
 
-	result, err := h.executor.ExecuteTask(ctx, task)
+	intent, err := h.guardExecution(ctx, task, lease, timeout)
 	if err != nil {
-		log.Error().Err(err).Str("id", task.ID.String()).Msg("Task execution failed")
-		if updateErr := h.taskClient.UpdateTaskStatus(task.ID.String(), models.TaskStatusFailed, &models.TaskResult{
-			TaskID: task.ID,
-			Error:  err.Error(),
-		}); updateErr != nil {
+		x.end("", err)
+		return x
+	}
+	x.onReport(func() { h.releaseExecution(intent) })
+
+	release := h.holdCaches(ctx, task)
+	x.onReport(release)
+
+	h.accountExecuting(task)
+	x.onReport(func() { h.forgetRedactions(task) })
+	return x
+}
+
+// end ends an execution before its task ran
+func (x *Execution) end(status models.TaskStatus, err error) {
+	x.ended, x.status, x.err = true, status, err
+	x.logs.close()
+	close(x.progress)
+	close(x.done)
+}
+
+// startExecution runs the prepared execution's task with execute,
+// streaming what it reports until it stops
+func (h *DefaultTaskHandler) startExecution(x *Execution, execute executeFunc) {
+	if x.ended {
+		return
+	}
+	h.executionTaps.add(x)
+	x.run = clock.Start(h.clock)
+	go h.heartbeat(x)
+	go func() {
+		defer close(x.done)
+		defer x.logs.close()
+		defer h.executionTaps.remove(x)
+		x.result, x.err = execute(x.ctx, x.Task)
+		h.chargeBudget(x.Task, x.run, x.result)
+	}()
+}
+
+// reportExecution submits the result of the execution whose task stopped,
+// returning the status it finished with, or an empty status if it did not
+// finish here
+func (h *DefaultTaskHandler) reportExecution(x *Execution) (models.TaskStatus, *models.TaskResult, error) {
+	defer x.close()
+	if x.ended {
+		return x.status, nil, x.err
+	}
+
+	log := gologger.WithComponent("task_handler")
+	task, lease, ctx, tl, run, watch := x.Task, x.lease, x.ctx, x.tl, x.run, x.watch
+	appliedTimeout := x.appliedTimeout
+	result, err := x.result, x.err
+	if watch.Lost() {
+		log.Warn().Str("id", task.ID.String()).Msg("Discarding task result - lease was lost")
+		h.account(task, h.journal.Abandon)
+		x.outcome = models.AttemptLeaseLost
+		return "", nil, ErrLeaseLost
+	}
+	if cause := revokedCause(ctx); cause != nil {
+		return h.reportRevoked(task, run, appliedTimeout, cause)
+	}
+	if err != nil && h.handoff.active.Load() {
+		h.handoff.record(task, watch.Current(lease), err)
+		log.Info().Str("id", task.ID.String()).Msg("Task handed off to upgraded runner")
+		x.outcome = models.AttemptHandedOff
+		return "", nil, ErrHandedOff
+	}
+	if evicted(ctx) {
+		log.Warn().Str("id", task.ID.String()).Msg("Returning task to the queue - evicted from its GPU")
+		h.account(task, h.journal.Abandon)
+		x.outcome = models.AttemptRequeued
+		return "", nil, h.requeue(task, gpu.ErrEvicted)
+	}
+	if constrained(ctx) {
+		log.Warn().Str("id", task.ID.String()).Msg("Returning task to the queue - stopped by power policy")
+		h.account(task, h.journal.Abandon)
+		x.outcome = models.AttemptRequeued
+		return "", nil, h.requeue(task, power.ErrConstrained)
+	}
+	// A task that finished despite being stopped reports its result
+	if cause := migrating(ctx); cause != nil && (err != nil || result == nil || result.ExitCode != 0) {
+		err := h.migrateTask(task, watch.Current(lease), cause)
+		x.outcome = migrationOutcome(err)
+		return "", nil, err
+	}
+	tl.Enter(models.PhaseUploading)
+	if cause := groupCancelled(ctx); cause != nil {
+		return h.reportCancelledShard(task, run, appliedTimeout, cause)
+	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = models.Failure(models.FailureTimeout, fmt.Errorf("timed out after %s: %w", x.timeout.Round(time.Second), err))
+	}
+	if err != nil {
+		code := failureCode(err)
+		h.recordHistory(task, run, models.TaskStatusFailed, nil, code)
+		failure := &models.TaskResult{
+			TaskID:         task.ID,
+			Error:          err.Error(),
+			AppliedTimeout: appliedTimeout,
+			FailureCode:    code,
+		}
+		h.redactResult(task, failure)
+		var dependency *inputs.DependencyError
+		if errors.As(err, &dependency) {
+			// Reported apart from the task's own failures, so that the
+			// server can tell an expired parent from a broken child
+			failure.DependencyFailure = dependency.Failure()
+			log.Error().Err(err).
+				Str("id", task.ID.String()).
+				Str("dependency", dependency.TaskID).
+				Str("reason", dependency.Reason).
+				Msg("Task dependency could not be fetched")
+			h.reportError(task, errreport.CategoryExecution, "dependency_failed", err)
+		} else {
+			log.Error().Err(err).Str("id", task.ID.String()).Msg("Task execution failed")
+			h.reportError(task, errreport.CategoryExecution, "execution_failed", err)
+		}
+		h.stampResult(failure, run)
+		h.finishShard(task, run, models.TaskStatusFailed, failure, false)
+		h.accountResult(task, run, models.TaskStatusFailed, failure)
+		h.hintFailure(task, err)
+		if dependency == nil && h.abandonPoisoned(task, watch.Current(lease), poison.Failure{
+			TaskType: task.Type,
+			ExitCode: -1,
+			Error:    err.Error(),
+		}) {
+			h.account(task, h.journal.Abandon)
+			return models.TaskStatusFailed, nil, fmt.Errorf("%w: %v", ErrTaskPoisoned, err)
+		}
+		failure.Timeline = tl.Snapshot()
+		if updateErr := h.taskClient.UpdateTaskStatus(task.ID.String(), models.TaskStatusFailed, failure); updateErr != nil {
 			log.Error().Err(updateErr).Str("id", task.ID.String()).Msg("Failed to update task status")
+		} else {
+			h.account(task, h.journal.Reported)
+			x.outcome = models.AttemptReported
 		}
-		return err
+		return models.TaskStatusFailed, nil, err
 	}
 
-	deviceIDManager := deviceid.NewManager(deviceid.Config{})
-	deviceID, err := deviceIDManager.VerifyDeviceID()
+	result.AppliedTimeout = appliedTimeout
+	h.redactResult(task, result)
+	h.stampResult(result, run)
+
+	deviceID, err := utils.GetDeviceID()
 	if err == nil {
 		result.DeviceID = deviceID
 	}
@@ -105,17 +876,76 @@ func (h *DefaultTaskHandler) HandleTask(task *models.Task) error {
 	status := models.TaskStatusCompleted
 	if result.ExitCode != 0 {
 		status = models.TaskStatusFailed
+		if result.FailureCode == "" {
+			result.FailureCode = models.FailureTaskExit
+		}
 	}
 
+	if result.OutputVerdict.FailsTask() {
+		log.Warn().
+			Str("id", task.ID.String()).
+			Int("extra_files", len(result.OutputVerdict.ExtraFiles)).
+			Msg("Task outputs do not satisfy the expected output manifest")
+		status = models.TaskStatusFailed
+		if result.FailureCode == "" {
+			result.FailureCode = models.FailureOutputs
+		}
+		if result.Error == "" {
+			result.Error = "output manifest verification failed"
+		}
+	}
+
+	status = h.postProcess(task, status, result)
+
+	if status == models.TaskStatusCompleted && requiresAttestation(task) {
+		if err := h.attestResult(ctx, task, result); err != nil {
+			log.Warn().Err(err).Str("id", task.ID.String()).Msg("Failed to attest task result")
+			h.reportError(task, errreport.CategoryAttestation, "attestation_failed", err)
+			status = models.TaskStatusFailed
+			result.Error = fmt.Sprintf("attestation failed: %v", err)
+		}
+	}
+
+	status = h.reviewResult(ctx, task, status, result)
+	h.recordHistory(task, run, status, result, result.FailureCode)
+
+	h.finishShard(task, run, status, result, false)
+	h.accountResult(task, run, status, result)
+	if status == models.TaskStatusFailed && result.ExitCode != 0 && h.abandonPoisoned(task, watch.Current(lease), poison.Failure{
+		TaskType: task.Type,
+		ExitCode: result.ExitCode,
+		Error:    result.Error,
+		Output:   result.Output,
+	}) {
+		h.account(task, h.journal.Abandon)
+		return status, result, ErrTaskPoisoned
+	}
+	tl.AddBytes(int64(len(result.Output)))
+	result.Timeline = tl.Snapshot()
+	h.escrowResult(ctx, task, result)
+	h.keepResult(task, status, result)
 	if err := h.taskClient.UpdateTaskStatus(task.ID.String(), status, result); err != nil {
 		log.Error().Err(err).Str("id", task.ID.String()).Msg("Failed to update task status")
-		return fmt.Errorf("failed to update task status: %w", err)
+		h.reportError(task, errreport.CategoryStatus, "status_update_failed", err)
+		return status, result, fmt.Errorf("failed to update task status: %w", err)
 	}
+	h.confirmResult(task)
+	h.account(task, h.journal.Reported)
+	h.finishTimeline(task, models.AttemptReported)
+
+	h.publish(events.ResultUploaded{
+		Task:     task,
+		Result:   result,
+		TaskID:   task.ID.String(),
+		Instance: h.instanceName(),
+		Status:   status,
+	})
 
 	// Handle federated learning task completion separately
 	if task.Type == models.TaskTypeFederatedLearning && result.ExitCode == 0 {
 		if err := h.handleFederatedLearningCompletion(task, result); err != nil {
 			log.Error().Err(err).Str("id", task.ID.String()).Msg("Failed to submit FL model update")
+			h.reportError(task, errreport.CategoryFederatedLearning, "model_update_failed", err)
 			// Continue anyway to complete the task, but log the error
 		}
 	}
@@ -134,24 +964,26 @@ func (h *DefaultTaskHandler) HandleTask(task *models.Task) error {
 			Msg("Task execution completed")
 	}
 
-	return nil
+	return status, result, nil
 }
 
 func (h *DefaultTaskHandler) handleLLMTask(task *models.Task) error {
 	log := gologger.WithComponent("task_handler")
 
 	// Add a small delay before first status update to ensure task is created
-	time.Sleep(500 * time.Millisecond)
+	h.clock.Sleep(500 * time.Millisecond)
 
 	// Add retry logic for updating task status
 	maxRetries := 3
 	retryDelay := time.Second
 	var lastErr error
+	var lease *models.TaskLease
 
 	// Update task status to running when we start processing
 	for i := 0; i < maxRetries; i++ {
-		err := h.taskClient.UpdateTaskStatus(task.ID.String(), models.TaskStatusRunning, nil)
+		claimed, err := h.claimTask(task)
 		if err == nil {
+			lease = claimed
 			break
 		}
 		lastErr = err
@@ -163,7 +995,7 @@ func (h *DefaultTaskHandler) handleLLMTask(task *models.Task) error {
 			Msg("Failed to update LLM task status to running, retrying...")
 
 		// Wait before retrying
-		time.Sleep(retryDelay)
+		h.clock.Sleep(retryDelay)
 		// Exponential backoff
 		retryDelay *= 2
 	}
@@ -176,41 +1008,125 @@ func (h *DefaultTaskHandler) handleLLMTask(task *models.Task) error {
 		// Continue execution despite status update failure
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	timeout, _ := h.timeouts.Resolve(task)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
+	ctx, tl := h.startTimeline(ctx, task)
+	outcome := models.AttemptAbandoned
+	defer func() { h.finishTimeline(task, outcome) }()
+	ctx, stopGPU := h.withGPU(ctx, task)
+	defer stopGPU()
+	ctx = h.withDisk(ctx, task)
+	ctx, stopPower := h.withPower(ctx, task)
+	defer stopPower()
+	ctx, stopRevocation := h.withRevocation(ctx, task)
+	defer stopRevocation()
+
+	watch := h.leases.watch(ctx, task, lease, cancel)
+	defer watch.Stop()
+
+	h.handoff.track(task, cancel)
+	defer h.handoff.untrack(task)
 
 	log.Info().
 		Str("id", task.ID.String()).
 		Str("type", string(task.Type)).
 		Msg("Executing LLM task")
 
+	intent, err := h.guardExecution(ctx, task, lease, timeout)
+	if err != nil {
+		return err
+	}
+	defer h.releaseExecution(intent)
+
+	release := h.holdCaches(ctx, task)
+	defer release()
+
+	h.accountExecuting(task)
+	run := clock.Start(h.clock)
 	result, err := h.executor.ExecuteTask(ctx, task)
+	h.chargeBudget(task, run, result)
+	if watch.Lost() {
+		log.Warn().Str("id", task.ID.String()).Msg("Discarding LLM task result - lease was lost")
+		h.account(task, h.journal.Abandon)
+		outcome = models.AttemptLeaseLost
+		return ErrLeaseLost
+	}
+	if cause := revokedCause(ctx); cause != nil {
+		_, _, err := h.reportRevoked(task, run, nil, cause)
+		outcome = models.AttemptReported
+		return err
+	}
+	if err != nil && h.handoff.active.Load() {
+		h.handoff.record(task, watch.Current(lease), err)
+		log.Info().Str("id", task.ID.String()).Msg("LLM task handed off to upgraded runner")
+		outcome = models.AttemptHandedOff
+		return ErrHandedOff
+	}
+	if evicted(ctx) {
+		log.Warn().Str("id", task.ID.String()).Msg("Returning LLM task to the queue - evicted from its GPU")
+		h.account(task, h.journal.Abandon)
+		outcome = models.AttemptRequeued
+		return h.requeue(task, gpu.ErrEvicted)
+	}
+	if constrained(ctx) {
+		log.Warn().Str("id", task.ID.String()).Msg("Returning LLM task to the queue - stopped by power policy")
+		h.account(task, h.journal.Abandon)
+		outcome = models.AttemptRequeued
+		return h.requeue(task, power.ErrConstrained)
+	}
+	tl.Enter(models.PhaseUploading)
 	if err != nil {
+		h.recordHistory(task, run, models.TaskStatusFailed, nil, failureCode(err))
 		log.Error().Err(err).Str("id", task.ID.String()).Msg("LLM task execution failed")
+		h.reportError(task, errreport.CategoryExecution, "execution_failed", err)
+		h.account(task, h.journal.Abandon)
 		return err
 	}
 
 	if result.ExitCode != 0 {
+		h.recordHistory(task, run, models.TaskStatusFailed, nil, models.FailureTaskExit)
 		log.Error().
 			Str("id", task.ID.String()).
 			Str("error", result.Error).
 			Msg("LLM task failed")
+		if result.ResponseFormat != nil && result.ResponseFormat.Enforcement == models.FormatUnmet {
+			h.accountResult(task, run, models.TaskStatusFailed, result)
+			result.Timeline = tl.Snapshot()
+			h.reportFormatFailure(task, result)
+		} else {
+			h.account(task, h.journal.Abandon)
+		}
 		return fmt.Errorf("LLM task failed: %s", result.Error)
 	}
 
+	if h.reviewResult(ctx, task, models.TaskStatusCompleted, result) == models.TaskStatusFailed {
+		h.recordHistory(task, run, models.TaskStatusFailed, result, models.FailureAnomalous)
+		log.Warn().Str("id", task.ID.String()).Msg("Not completing LLM prompt - its response was rejected as anomalous")
+		h.account(task, h.journal.Abandon)
+		return errResultRejected
+	}
+	h.recordHistory(task, run, models.TaskStatusCompleted, result, "")
+
 	// For LLM tasks, we call CompletePrompt instead of the regular task completion
 	if llmClient, ok := h.taskClient.(*HTTPTaskClient); ok {
+		h.accountResult(task, run, models.TaskStatusCompleted, result)
 		err = llmClient.CompletePrompt(
 			task.ID,
 			result.Output,
 			result.PromptTokens,
 			result.ResponseTokens,
 			result.InferenceTime,
+			result.TokenUsage,
+			result.ResponseFormat,
+			result.CachedPromptID,
 		)
 		if err != nil {
 			log.Error().Err(err).Str("id", task.ID.String()).Msg("Failed to complete LLM prompt")
 			return fmt.Errorf("failed to complete LLM prompt: %w", err)
 		}
+		h.account(task, h.journal.Reported)
+		h.finishTimeline(task, models.AttemptReported)
 
 		log.Debug().
 			Str("id", task.ID.String()).
@@ -223,9 +1139,24 @@ func (h *DefaultTaskHandler) handleLLMTask(task *models.Task) error {
 	}
 
 	log.Error().Str("id", task.ID.String()).Msg("Task client does not support LLM completion")
+	h.account(task, h.journal.Abandon)
 	return fmt.Errorf("task client does not support LLM completion")
 }
 
+// reportFormatFailure tells the server an LLM task failed because no
+// response met its format, with the report of the attempts in place of the
+// malformed output
+func (h *DefaultTaskHandler) reportFormatFailure(task *models.Task, result *models.TaskResult) {
+	log := gologger.WithComponent("task_handler")
+	if err := h.taskClient.UpdateTaskStatus(task.ID.String(), models.TaskStatusFailed, result); err != nil {
+		log.Error().Err(err).Str("id", task.ID.String()).Msg("Failed to report LLM response format failure")
+		h.reportError(task, errreport.CategoryStatus, "status_update_failed", err)
+		return
+	}
+	h.account(task, h.journal.Reported)
+	h.finishTimeline(task, models.AttemptReported)
+}
+
 func (h *DefaultTaskHandler) handleFederatedLearningCompletion(task *models.Task, result *models.TaskResult) error {
 	log := gologger.WithComponent("task_handler")
 
@@ -306,9 +1237,12 @@ func (h *DefaultTaskHandler) handleFederatedLearningCompletion(task *models.Task
 		trainingTime = int(tt)
 	}
 
+	// Sessions that describe their model as a spec identify it by hash, so
+	// the coordinator can reject updates for a different model
+	specHash, _ := trainingResult["model_spec_hash"].(string)
+
 	// Get the runner's device ID
-	deviceIDManager := deviceid.NewManager(deviceid.Config{})
-	runnerID, err := deviceIDManager.VerifyDeviceID()
+	runnerID, err := utils.GetDeviceID()
 	if err != nil {
 		log.Warn().Err(err).Msg("Failed to get device ID, using task runner ID")
 		runnerID = task.RunnerID
@@ -316,7 +1250,7 @@ func (h *DefaultTaskHandler) handleFederatedLearningCompletion(task *models.Task
 
 	// Submit model update to the federated learning service
 	if httpClient, ok := h.taskClient.(*HTTPTaskClient); ok {
-		if err := httpClient.SubmitFLModelUpdate(sessionID, roundID, runnerID, gradientsFloat, weightsFloat, dataSize, loss, accuracy, trainingTime); err != nil {
+		if err := httpClient.SubmitFLModelUpdate(sessionID, roundID, runnerID, specHash, gradientsFloat, weightsFloat, dataSize, loss, accuracy, trainingTime); err != nil {
 			return fmt.Errorf("failed to submit FL model update: %w", err)
 		}
 
@@ -0,0 +1,41 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+)
+
+// taskUserCheckTimeout bounds asking the Docker daemon whether it remaps
+// user namespaces
+const taskUserCheckTimeout = 10 * time.Second
+
+// TaskUserChecker is implemented by executors that run tasks as
+// unprivileged users and can tell before a task is claimed whether it may
+// run as root as it asks
+type TaskUserChecker interface {
+	CheckTaskUser(ctx context.Context, task *models.Task) error
+}
+
+// admitUser skips tasks that ask to run as root when the runner's policy
+// forbids it
+func (h *DefaultTaskHandler) admitUser(task *models.Task) *admissionError {
+	checker, ok := h.executor.(TaskUserChecker)
+	if !ok {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), taskUserCheckTimeout)
+	defer cancel()
+	err := checker.CheckTaskUser(ctx, task)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, docker.ErrRootForbidden):
+		return &admissionError{reason: models.FLDeclineRootForbidden, err: err}
+	default:
+		return &admissionError{reason: models.FLDeclineInvalidConfig, err: err}
+	}
+}
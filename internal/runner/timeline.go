@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/status"
+	"github.com/theblitlabs/parity-runner/internal/timeline"
+)
+
+// startTimeline begins an attempt of task on its timeline, returning ctx
+// carrying the recorder for the executor to mark its phases with
+func (h *DefaultTaskHandler) startTimeline(ctx context.Context, task *models.Task) (context.Context, *timeline.Recorder) {
+	tl := h.timelines.Start(task)
+	return timeline.With(ctx, tl), tl
+}
+
+// finishTimeline ends the running attempt of task with outcome and records
+// the task's timeline in the history. It does nothing once the attempt has
+// ended.
+func (h *DefaultTaskHandler) finishTimeline(task *models.Task, outcome string) {
+	tl := h.timelines.Finish(task.ID.String(), outcome)
+	if tl == nil || h.history == nil {
+		return
+	}
+	last := tl.Attempts[len(tl.Attempts)-1]
+	record := history.Record{
+		TaskID:     task.ID.String(),
+		Type:       task.Type,
+		Workload:   workloadKey(task),
+		StartedAt:  last.StartedAt,
+		DurationMs: last.DurationMs,
+		Event:      history.EventTimeline,
+		Instance:   h.instanceName(),
+		Timeline:   tl,
+	}
+	if err := h.history.Append(record); err != nil {
+		log := gologger.WithComponent("task_handler")
+		log.Warn().Err(err).Str("id", task.ID.String()).Msg("Failed to record task timeline")
+	}
+}
+
+// migrationOutcome is the outcome of an attempt migrateTask ended with err
+func migrationOutcome(err error) string {
+	if errors.Is(err, ErrMigrated) {
+		return models.AttemptMigrated
+	}
+	return models.AttemptRequeued
+}
+
+// TaskTimeline returns the timeline of the task with taskID while the
+// runner runs it or holds it for another attempt, or nil
+func (h *DefaultTaskHandler) TaskTimeline(taskID string) *models.TaskTimeline {
+	return h.timelines.Get(taskID).Snapshot()
+}
+
+// TaskTimeline returns the timeline of the task with taskID, live while the
+// runner runs it and otherwise as last recorded in the history
+func (s *Service) TaskTimeline(ctx context.Context, taskID string) (*models.TaskTimeline, error) {
+	if handler, ok := s.taskHandler.(*DefaultTaskHandler); ok {
+		if tl := handler.TaskTimeline(taskID); tl != nil {
+			return tl, nil
+		}
+	}
+	return latestTimeline(s.shared.history, taskID)
+}
+
+// latestTimeline returns the timeline of the task with taskID last recorded
+// in store, or status.ErrNotFound
+func latestTimeline(store *history.Store, taskID string) (*models.TaskTimeline, error) {
+	if store == nil {
+		return nil, fmt.Errorf("%w: task history is disabled", status.ErrNotFound)
+	}
+	tl, err := store.Timeline(taskID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read task history: %w", err)
+	}
+	if tl == nil {
+		return nil, fmt.Errorf("%w: no timeline recorded for task %s", status.ErrNotFound, taskID)
+	}
+	return tl, nil
+}
+
+// TaskTimeline returns the timeline of the task with taskID last recorded
+// in the history while the runner itself is not running
+func TaskTimeline(taskID string) (*models.TaskTimeline, error) {
+	dir, err := parityDir()
+	if err != nil {
+		return nil, err
+	}
+	keyring, err := openDataKeyring(dir)
+	if err != nil {
+		return nil, err
+	}
+	store, err := history.NewStore(filepath.Join(dir, historyFileName))
+	if err != nil {
+		return nil, err
+	}
+	store.SetCodec(keyringCodec(keyring))
+	return latestTimeline(store, taskID)
+}
@@ -0,0 +1,163 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/power"
+	"github.com/theblitlabs/parity-runner/internal/timeline"
+)
+
+// scriptedExecutor plays one script per attempt, which marks phases and
+// moves the fake clock as an executor would
+type scriptedExecutor struct {
+	scripts     []func(ctx context.Context, task *models.Task) (*models.TaskResult, error)
+	suspendable bool
+}
+
+func (e *scriptedExecutor) ExecuteTask(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+	script := e.scripts[0]
+	e.scripts = e.scripts[1:]
+	return script(ctx, task)
+}
+
+func (e *scriptedExecutor) SuspendTask(ctx context.Context, task *models.Task) error {
+	if !e.suspendable {
+		return errors.New("cannot suspend")
+	}
+	return nil
+}
+
+func (e *scriptedExecutor) ResumeSuspendedTask(ctx context.Context, task *models.Task) error {
+	return nil
+}
+
+type wantPhase struct {
+	phase    models.TaskPhase
+	duration time.Duration
+	paused   time.Duration
+	bytes    int64
+}
+
+func checkPhases(t *testing.T, attempt models.TimelineAttempt, want []wantPhase) {
+	t.Helper()
+	if len(attempt.Phases) != len(want) {
+		t.Fatalf("attempt %d phases = %+v, want %d", attempt.Attempt, attempt.Phases, len(want))
+	}
+	for i, w := range want {
+		got := attempt.Phases[i]
+		if got.Phase != w.phase || got.DurationMs != w.duration.Milliseconds() || got.PausedMs != w.paused.Milliseconds() || got.Bytes != w.bytes || got.Open {
+			t.Errorf("attempt %d phase %d = %+v, want %+v", attempt.Attempt, i, got, w)
+		}
+	}
+}
+
+func TestTimelineAcrossRetryAndPause(t *testing.T) {
+	start := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	clk := clocktest.NewFake(start)
+	executor := &scriptedExecutor{}
+	h, probe, client := newPowerHandler(executor, power.InFlightCheckpoint)
+	h.SetClock(clk)
+	store, err := history.NewStore(filepath.Join(t.TempDir(), historyFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.SetHistory(store, nil)
+	h.checkPower(context.Background())
+
+	task := newDockerTask(t)
+	task.CreatedAt = start.Add(-time.Minute)
+	executor.scripts = append(executor.scripts,
+		// The first attempt pulls its image and is stopped by a throttle
+		// it cannot be suspended through
+		func(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+			tl := timeline.From(ctx)
+			clk.Advance(500 * time.Millisecond)
+			tl.Enter(models.PhaseImagePulling)
+			tl.AddBytes(4096)
+			clk.Advance(2 * time.Second)
+			tl.Enter(models.PhaseExecuting)
+			clk.Advance(3 * time.Second)
+			probe.set(throttled)
+			h.checkPower(context.Background())
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+		// The second is suspended for half a minute while it runs
+		func(ctx context.Context, task *models.Task) (*models.TaskResult, error) {
+			tl := timeline.From(ctx)
+			tl.Enter(models.PhaseInputsFetching)
+			tl.AddBytes(1000)
+			clk.Advance(time.Second)
+			tl.Enter(models.PhaseInputsReady)
+			tl.Enter(models.PhaseExecuting)
+			clk.Advance(time.Second)
+			executor.suspendable = true
+			probe.set(throttled)
+			h.checkPower(context.Background())
+			clk.Advance(30 * time.Second)
+			probe.set(onMains)
+			h.checkPower(context.Background())
+			clk.Advance(4 * time.Second)
+			return &models.TaskResult{TaskID: task.ID, Output: "done"}, nil
+		},
+	)
+
+	if err := h.HandleTask(task); !errors.Is(err, power.ErrConstrained) {
+		t.Fatalf("first HandleTask() error = %v, want ErrConstrained", err)
+	}
+	if tl := h.TaskTimeline(task.ID.String()); tl == nil || tl.Attempts[0].Outcome != models.AttemptRequeued {
+		t.Fatalf("timeline after the first attempt = %+v, want it kept as requeued", tl)
+	}
+	clk.Advance(10 * time.Second)
+	probe.set(onMains)
+	h.checkPower(context.Background())
+	if err := h.HandleTask(task); err != nil {
+		t.Fatalf("second HandleTask() error = %v", err)
+	}
+
+	result := client.results[len(client.results)-1]
+	if result == nil || result.Timeline == nil || len(result.Timeline.Attempts) != 2 {
+		t.Fatalf("reported %+v, want a result with the timeline of both attempts", result)
+	}
+	if h.TaskTimeline(task.ID.String()) != nil {
+		t.Error("timeline kept once the task was reported")
+	}
+	tl, err := store.Timeline(task.ID.String())
+	if err != nil || tl == nil {
+		t.Fatalf("history timeline = %v, %v", tl, err)
+	}
+	if tl.Version != models.TimelineVersion || tl.TaskID != task.ID.String() || tl.QueueWaitMs != time.Minute.Milliseconds() {
+		t.Errorf("timeline = %+v", tl)
+	}
+
+	first, second := tl.Attempts[0], tl.Attempts[1]
+	if first.Outcome != models.AttemptRequeued || !first.StartedAt.Equal(start) || first.DurationMs != 5500 {
+		t.Errorf("first attempt = %+v", first)
+	}
+	checkPhases(t, first, []wantPhase{
+		{models.PhaseClaimed, 500 * time.Millisecond, 0, 0},
+		{models.PhaseImagePulling, 2 * time.Second, 0, 4096},
+		{models.PhaseExecuting, 3 * time.Second, 0, 0},
+	})
+	if second.Outcome != models.AttemptReported || !second.StartedAt.Equal(start.Add(15500*time.Millisecond)) || second.DurationMs != 36000 {
+		t.Errorf("second attempt = %+v", second)
+	}
+	checkPhases(t, second, []wantPhase{
+		{models.PhaseClaimed, 0, 0, 0},
+		{models.PhaseInputsFetching, time.Second, 0, 1000},
+		{models.PhaseInputsReady, 0, 0, 0},
+		{models.PhaseExecuting, 35 * time.Second, 30 * time.Second, 0},
+		{models.PhaseUploading, 0, 0, int64(len("done"))},
+		{models.PhaseReported, 0, 0, 0},
+	})
+	if pauses := second.Phases[3].Pauses; len(pauses) != 1 || pauses[0].Reason != powerPause || !pauses[0].StartedAt.Equal(start.Add(17500*time.Millisecond)) {
+		t.Errorf("pauses = %+v, want the power suspension", pauses)
+	}
+}
@@ -0,0 +1,231 @@
+package runner
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/service"
+	"github.com/theblitlabs/parity-runner/internal/history"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// timeoutHistoryWindow bounds how many recent executions feed the percentile
+const timeoutHistoryWindow = 100
+
+// staticTimeouts are the cold-start defaults used until enough history exists
+var staticTimeouts = map[models.TaskType]time.Duration{
+	models.TaskTypeDocker:            20 * time.Minute,
+	models.TaskTypeCommand:           20 * time.Minute,
+	models.TaskTypeLLM:               10 * time.Minute,
+	models.TaskTypeFederatedLearning: 20 * time.Minute,
+	models.TaskTypeEmbedding:         20 * time.Minute,
+	models.TaskTypeONNX:              10 * time.Minute,
+	// Service tasks declare their duration, which Resolve uses instead
+	models.TaskTypeService: 20 * time.Minute,
+}
+
+const fallbackStaticTimeout = 20 * time.Minute
+
+type TimeoutPolicyConfig struct {
+	Factor     float64
+	Min        time.Duration
+	Max        time.Duration
+	MinSamples int
+	// Default replaces the per-type defaults of tasks without history
+	// when set
+	Default time.Duration
+	// Ceiling caps every timeout, declared or not, when set
+	Ceiling time.Duration
+}
+
+// TimeoutPolicy chooses execution timeouts for tasks that do not declare
+// one, and caps the ones tasks declare
+type TimeoutPolicy struct {
+	config  TimeoutPolicyConfig
+	history *history.Store
+}
+
+func NewTimeoutPolicy(config TimeoutPolicyConfig, store *history.Store) *TimeoutPolicy {
+	return &TimeoutPolicy{config: config, history: store}
+}
+
+// Resolve returns the execution timeout for task: the ResourceConfig.Timeout
+// it declares, up to the ceiling, or else a default. The applied timeout is
+// returned for tasks given a default or capped, and is nil for tasks run
+// for as long as they declare.
+func (p *TimeoutPolicy) Resolve(task *models.Task) (time.Duration, *models.AppliedTimeout) {
+	static := p.staticTimeout(task.Type)
+	if task.Type == models.TaskTypeService {
+		// A service runs for as long as it declares, whatever its history
+		if config, err := service.ParseConfig(task.Config); err == nil {
+			return p.capDeclared(config.Timeout(), "")
+		}
+		return p.ceil(static), nil
+	}
+
+	var config models.TaskConfig
+	if err := safejson.Unmarshal(task.Config, &config); err == nil && config.Resources.Timeout != "" {
+		if declared, err := time.ParseDuration(config.Resources.Timeout); err == nil && declared > 0 {
+			return p.capDeclared(declared, workloadKey(task))
+		}
+	}
+
+	workload := workloadKey(task)
+	applied := &models.AppliedTimeout{
+		Source:   models.TimeoutSourceStatic,
+		Workload: workload,
+	}
+	timeout := static
+
+	if p != nil && p.history != nil {
+		durations, err := p.history.Durations(task.Type, workload, timeoutHistoryWindow)
+		if err != nil {
+			log := gologger.WithComponent("timeout_policy")
+			log.Warn().Err(err).Msg("Failed to read task history, using static timeout")
+		}
+		applied.Samples = len(durations)
+		if len(durations) >= p.config.MinSamples && len(durations) > 0 {
+			p95 := percentile(durations, 95)
+			applied.Source = models.TimeoutSourceAdaptive
+			applied.P95Ms = p95.Milliseconds()
+			timeout = p.bound(time.Duration(float64(p95) * p.config.Factor))
+		}
+	}
+
+	if p != nil && applied.Source == models.TimeoutSourceStatic {
+		timeout = p.bound(timeout)
+	}
+	timeout = p.ceil(timeout)
+
+	applied.TimeoutSeconds = int64(math.Ceil(timeout.Seconds()))
+	return timeout, applied
+}
+
+// Typical returns how long task's workload usually runs, the median of
+// its recorded executions, and false until enough have been recorded
+func (p *TimeoutPolicy) Typical(task *models.Task) (time.Duration, bool) {
+	if p == nil || p.history == nil {
+		return 0, false
+	}
+	durations, err := p.history.Durations(task.Type, workloadKey(task), timeoutHistoryWindow)
+	if err != nil || len(durations) == 0 || len(durations) < p.config.MinSamples {
+		return 0, false
+	}
+	return percentile(durations, 50), true
+}
+
+func (p *TimeoutPolicy) bound(timeout time.Duration) time.Duration {
+	if p.config.Min > 0 && timeout < p.config.Min {
+		return p.config.Min
+	}
+	if p.config.Max > 0 && timeout > p.config.Max {
+		return p.config.Max
+	}
+	return timeout
+}
+
+// capDeclared returns the timeout a task declared, or the ceiling and why
+// when it declared a longer one
+func (p *TimeoutPolicy) capDeclared(declared time.Duration, workload string) (time.Duration, *models.AppliedTimeout) {
+	timeout := p.ceil(declared)
+	if timeout == declared {
+		return declared, nil
+	}
+	return timeout, &models.AppliedTimeout{
+		Source:           models.TimeoutSourceCapped,
+		TimeoutSeconds:   int64(math.Ceil(timeout.Seconds())),
+		Workload:         workload,
+		RequestedSeconds: int64(math.Ceil(declared.Seconds())),
+	}
+}
+
+// ceil caps timeout at the ceiling
+func (p *TimeoutPolicy) ceil(timeout time.Duration) time.Duration {
+	if p != nil && p.config.Ceiling > 0 && timeout > p.config.Ceiling {
+		return p.config.Ceiling
+	}
+	return timeout
+}
+
+// staticTimeout is the default of tasks of taskType without history
+func (p *TimeoutPolicy) staticTimeout(taskType models.TaskType) time.Duration {
+	if p != nil && p.config.Default > 0 {
+		return p.config.Default
+	}
+	return staticTimeout(taskType)
+}
+
+func staticTimeout(taskType models.TaskType) time.Duration {
+	if timeout, ok := staticTimeouts[taskType]; ok {
+		return timeout
+	}
+	return fallbackStaticTimeout
+}
+
+// percentile returns the nearest-rank q-th percentile of durations
+func percentile(durations []time.Duration, q float64) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(math.Ceil(q / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// workloadKey identifies what a task runs so that durations are only compared
+// between like workloads: the image for docker tasks, the model for LLM,
+// embedding and FL tasks, and the executable for command tasks
+func workloadKey(task *models.Task) string {
+	var config struct {
+		ImageName string `json:"image_name"`
+		Model     string `json:"model"`
+		ModelType string `json:"model_type"`
+		Command   string `json:"command"`
+	}
+	if err := safejson.Unmarshal(task.Config, &config); err != nil {
+		return ""
+	}
+
+	switch task.Type {
+	case models.TaskTypeDocker:
+		if config.ImageName == "" && task.Environment != nil {
+			if image, ok := task.Environment.Config["image"].(string); ok {
+				return image
+			}
+		}
+		return config.ImageName
+	case models.TaskTypeCommand:
+		if fields := strings.Fields(config.Command); len(fields) > 0 {
+			return fields[0]
+		}
+		return ""
+	case models.TaskTypeFederatedLearning:
+		return config.ModelType
+	default:
+		return config.Model
+	}
+}
+
+// historyRetention bounds the task history to what cfg keeps, and to no less
+// than the records audit mode may still be challenged on
+func historyRetention(cfg config.RunnerConfig) history.Retention {
+	retention := history.Retention{MaxAge: cfg.History.Retention, MaxRecords: cfg.History.MaxRecords}
+	if cfg.Audit.Enabled && cfg.Audit.Retention > retention.MaxAge {
+		retention.MaxAge = cfg.Audit.Retention
+	}
+	return retention
+}
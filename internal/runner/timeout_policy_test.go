@@ -0,0 +1,169 @@
+package runner
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/history"
+)
+
+func TestPercentile(t *testing.T) {
+	var durations []time.Duration
+	for i := 20; i >= 1; i-- {
+		durations = append(durations, time.Duration(i)*10*time.Second)
+	}
+
+	tests := []struct {
+		q    float64
+		want time.Duration
+	}{
+		{95, 190 * time.Second},
+		{50, 100 * time.Second},
+		{100, 200 * time.Second},
+		{0, 10 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := percentile(durations, tt.q); got != tt.want {
+			t.Errorf("percentile(q=%v) = %s, want %s", tt.q, got, tt.want)
+		}
+	}
+
+	if got := percentile(nil, 95); got != 0 {
+		t.Errorf("percentile of empty history = %s, want 0", got)
+	}
+	if got := percentile([]time.Duration{time.Second}, 95); got != time.Second {
+		t.Errorf("percentile of single sample = %s, want 1s", got)
+	}
+}
+
+func newFixtureTask(t *testing.T, taskType models.TaskType, config map[string]interface{}) *models.Task {
+	t.Helper()
+	raw, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Task{Type: taskType, Config: raw}
+}
+
+func TestTimeoutPolicyResolve(t *testing.T) {
+	store, err := history.NewStore(filepath.Join("testdata", "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := NewTimeoutPolicy(TimeoutPolicyConfig{
+		Factor:     3,
+		Min:        time.Minute,
+		Max:        6 * time.Hour,
+		MinSamples: 5,
+	}, store)
+
+	tests := []struct {
+		name        string
+		task        *models.Task
+		wantTimeout time.Duration
+		wantSource  models.TimeoutSource
+		wantSamples int
+	}{
+		{
+			name:        "p95 times factor ignoring failed runs",
+			task:        newFixtureTask(t, models.TaskTypeDocker, map[string]interface{}{"image_name": "python:3.11"}),
+			wantTimeout: 570 * time.Second,
+			wantSource:  models.TimeoutSourceAdaptive,
+			wantSamples: 20,
+		},
+		{
+			name:        "bounded by minimum",
+			task:        newFixtureTask(t, models.TaskTypeCommand, map[string]interface{}{"command": "echo hello"}),
+			wantTimeout: time.Minute,
+			wantSource:  models.TimeoutSourceAdaptive,
+			wantSamples: 6,
+		},
+		{
+			name:        "bounded by maximum",
+			task:        newFixtureTask(t, models.TaskTypeFederatedLearning, map[string]interface{}{"model_type": "neural_network"}),
+			wantTimeout: 6 * time.Hour,
+			wantSource:  models.TimeoutSourceAdaptive,
+			wantSamples: 5,
+		},
+		{
+			name:        "cold start with too few samples",
+			task:        newFixtureTask(t, models.TaskTypeLLM, map[string]interface{}{"model": "llama3"}),
+			wantTimeout: 10 * time.Minute,
+			wantSource:  models.TimeoutSourceStatic,
+			wantSamples: 2,
+		},
+		{
+			name:        "cold start for unseen workload",
+			task:        newFixtureTask(t, models.TaskTypeDocker, map[string]interface{}{"image_name": "alpine:latest"}),
+			wantTimeout: 20 * time.Minute,
+			wantSource:  models.TimeoutSourceStatic,
+			wantSamples: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeout, applied := policy.Resolve(tt.task)
+			if timeout != tt.wantTimeout {
+				t.Fatalf("timeout = %s, want %s", timeout, tt.wantTimeout)
+			}
+			if applied == nil {
+				t.Fatal("expected applied timeout metadata")
+			}
+			if applied.Source != tt.wantSource || applied.Samples != tt.wantSamples {
+				t.Fatalf("applied = %+v, want source %s with %d samples", applied, tt.wantSource, tt.wantSamples)
+			}
+			if applied.TimeoutSeconds != int64(tt.wantTimeout.Seconds()) {
+				t.Fatalf("recorded timeout %ds, want %s", applied.TimeoutSeconds, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestTimeoutPolicyKeepsDeclaredTimeout(t *testing.T) {
+	policy := NewTimeoutPolicy(TimeoutPolicyConfig{Factor: 3, MinSamples: 5}, nil)
+	task := newFixtureTask(t, models.TaskTypeDocker, map[string]interface{}{
+		"image_name": "python:3.11",
+		"resources":  map[string]interface{}{"timeout": "45m"},
+	})
+
+	timeout, applied := policy.Resolve(task)
+	if timeout != 45*time.Minute || applied != nil {
+		t.Fatalf("expected the declared 45m and no default, got %s %+v", timeout, applied)
+	}
+}
+
+func TestTimeoutPolicyCapsDeclaredTimeout(t *testing.T) {
+	policy := NewTimeoutPolicy(TimeoutPolicyConfig{Factor: 3, MinSamples: 5, Ceiling: 30 * time.Minute}, nil)
+	task := newFixtureTask(t, models.TaskTypeDocker, map[string]interface{}{
+		"image_name": "python:3.11",
+		"resources":  map[string]interface{}{"timeout": "45m"},
+	})
+
+	timeout, applied := policy.Resolve(task)
+	if timeout != 30*time.Minute {
+		t.Fatalf("timeout = %s, want the 30m ceiling", timeout)
+	}
+	if applied == nil || applied.Source != models.TimeoutSourceCapped || applied.RequestedSeconds != 2700 || applied.TimeoutSeconds != 1800 {
+		t.Fatalf("applied = %+v, want the declared 45m recorded as capped to 30m", applied)
+	}
+}
+
+func TestTimeoutPolicyDefault(t *testing.T) {
+	policy := NewTimeoutPolicy(TimeoutPolicyConfig{Factor: 3, MinSamples: 5, Default: 2 * time.Hour, Ceiling: time.Hour}, nil)
+	timeout, applied := policy.Resolve(newFixtureTask(t, models.TaskTypeLLM, map[string]interface{}{"model": "llama3"}))
+	if timeout != time.Hour || applied == nil || applied.Source != models.TimeoutSourceStatic || applied.TimeoutSeconds != 3600 {
+		t.Fatalf("expected the default capped at the ceiling, got %s %+v", timeout, applied)
+	}
+}
+
+func TestTimeoutPolicyWithoutHistory(t *testing.T) {
+	var policy *TimeoutPolicy
+	timeout, applied := policy.Resolve(newFixtureTask(t, models.TaskTypeCommand, map[string]interface{}{"command": "ls"}))
+	if timeout != 20*time.Minute || applied.Source != models.TimeoutSourceStatic {
+		t.Fatalf("expected static default without a policy, got %s %+v", timeout, applied)
+	}
+}
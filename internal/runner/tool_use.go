@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"errors"
+
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+	"github.com/theblitlabs/parity-runner/internal/execution/sandbox/docker"
+)
+
+// ToolUseChecker is implemented by executors that call tools for LLM tasks'
+// models and can tell before a task is claimed whether they call the tools
+// it asks for
+type ToolUseChecker interface {
+	CheckToolUse(task *models.Task) error
+}
+
+// admitToolUse skips LLM tasks asking for tools the runner does not call
+func (h *DefaultTaskHandler) admitToolUse(task *models.Task) *admissionError {
+	checker, ok := h.executor.(ToolUseChecker)
+	if !ok {
+		return nil
+	}
+	err := checker.CheckToolUse(task)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, llm.ErrToolUseDisabled):
+		return &admissionError{reason: models.FLDeclineUnsupportedType, err: err}
+	default:
+		return &admissionError{reason: models.FLDeclineInvalidConfig, err: err}
+	}
+}
+
+// newToolBroker returns the broker calling tools for LLM tasks' models,
+// reaching only what policy allows, and the most each task may spend
+func newToolBroker(cfg config.ToolUseConfig, policy docker.NetworkPolicy) (*llm.ToolBroker, models.ToolBudget) {
+	limit := llm.DefaultToolBudget
+	if cfg.MaxCalls > 0 {
+		limit.MaxCalls = cfg.MaxCalls
+	}
+	if cfg.MaxFetchMB > 0 {
+		limit.MaxFetchBytes = cfg.MaxFetchMB << 20
+	}
+	if cfg.MaxToolTime > 0 {
+		limit.MaxToolTime = cfg.MaxToolTime
+	}
+	broker := llm.NewToolBroker(llm.NewWebFetch(policy.Allows), llm.NewCodeExec(cfg.CodeExecImage))
+	return broker, limit
+}
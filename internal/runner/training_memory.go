@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/execution/training"
+	"github.com/theblitlabs/parity-runner/internal/gpu"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// trainingMemoryBudget returns the bytes FL training may hold at once: the
+// configured size, or else a quarter of the host's memory, leaving the rest
+// to the runner and the tasks running beside it
+func trainingMemoryBudget(configured string, profile *hardware.Profile) (uint64, error) {
+	if configured != "" {
+		budget, err := gpu.ParseBytes(configured)
+		if err != nil {
+			return 0, fmt.Errorf("invalid FL training memory %q: %w", configured, err)
+		}
+		return budget, nil
+	}
+	if profile == nil {
+		return 0, nil
+	}
+	return profile.TotalMemoryBytes / 4, nil
+}
+
+// SetTrainingMemoryBudget declines FL rounds whose model does not fit in
+// bytes, even one sample at a time, and those that normalize over batches
+// that would have to be split into micro-batches
+func (h *DefaultTaskHandler) SetTrainingMemoryBudget(bytes uint64) {
+	h.trainingMemory = bytes
+}
+
+// admitTraining plans gradient accumulation for FL rounds whose model spec
+// gives its input size. Rounds without one are planned once their data is
+// loaded.
+func (h *DefaultTaskHandler) admitTraining(task *models.Task) *admissionError {
+	if task.Type != models.TaskTypeFederatedLearning || h.trainingMemory == 0 {
+		return nil
+	}
+	var config struct {
+		ModelSpec   *training.ModelSpec `json:"model_spec"`
+		TrainConfig struct {
+			BatchSize float64 `json:"batch_size"`
+		} `json:"train_config"`
+	}
+	if err := safejson.Unmarshal(task.Config, &config); err != nil || config.ModelSpec == nil || config.ModelSpec.InputSize <= 0 {
+		return nil
+	}
+	if config.TrainConfig.BatchSize < 1 || config.TrainConfig.BatchSize > 1<<20 {
+		return nil
+	}
+	batchSize := int(config.TrainConfig.BatchSize)
+
+	plan, err := training.PlanAccumulation(batchSize, config.ModelSpec.MemoryEstimate(config.ModelSpec.InputSize), h.trainingMemory)
+	if errors.Is(err, training.ErrInsufficientMemory) {
+		return &admissionError{reason: models.FLDeclineInsufficientResources, err: err}
+	}
+	if err != nil || !plan.Accumulates() || !config.ModelSpec.UsesBatchStatistics() {
+		return nil
+	}
+	return &admissionError{reason: models.FLDeclineBatchNormAccumulation, err: fmt.Errorf("%w: batches of %d samples would be split into %d micro-batches of %d", training.ErrBatchStatistics, batchSize, plan.Steps, plan.MicroBatchSize)}
+}
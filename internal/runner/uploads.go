@@ -0,0 +1,101 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/artifacts"
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/objectstore"
+)
+
+// newUploaderSource returns the source of per-task artifact uploaders for
+// cfg. With a pinning key configured, the key stays inside the issuer built
+// here; the executor only ever receives uploaders holding task-scoped keys.
+func newUploaderSource(cfg config.ImageExportConfig, server artifacts.UploadTokenIssuer, clk clock.Clock) (artifacts.UploaderSource, error) {
+	var issuer artifacts.UploadTokenIssuer
+	switch cfg.UploadAuth {
+	case "", "none":
+		return artifacts.NewIPFSUploader(cfg.IPFSAPIURL), nil
+	case "server":
+		issuer = server
+	case "scoped-key":
+		if cfg.PinningKey == "" {
+			return nil, fmt.Errorf("upload auth scoped-key requires a pinning key")
+		}
+		issuer = artifacts.NewPinningKeyIssuer(cfg.PinningKeyURL, cfg.PinningKey, cfg.UploadTokenTTL)
+	default:
+		return nil, fmt.Errorf("unknown upload auth %q", cfg.UploadAuth)
+	}
+
+	uploaders := artifacts.NewScopedUploaders(cfg.IPFSAPIURL, issuer)
+	uploaders.SetClock(clk)
+	return uploaders, nil
+}
+
+// newObjectStore returns the client for the configured S3-compatible object
+// storage, or nil when no endpoint is configured. Its credentials are only
+// usable with the artifact bucket and the listed input buckets.
+func newObjectStore(cfg config.ObjectStorageConfig) (*objectstore.Client, error) {
+	if cfg.Endpoint == "" {
+		return nil, nil
+	}
+	var buckets []string
+	for _, bucket := range append([]string{cfg.Bucket}, cfg.InputBuckets...) {
+		if bucket = strings.TrimSpace(bucket); bucket != "" {
+			buckets = append(buckets, bucket)
+		}
+	}
+	store, err := objectstore.New(objectstore.Config{
+		Endpoint:  cfg.Endpoint,
+		Region:    cfg.Region,
+		PathStyle: cfg.PathStyle,
+		Credentials: objectstore.Credentials{
+			AccessKeyID:     cfg.AccessKeyID,
+			SecretAccessKey: cfg.SecretAccessKey,
+			SessionToken:    cfg.SessionToken,
+		},
+		Encryption: objectstore.Encryption{Mode: cfg.SSE, KMSKeyID: cfg.SSEKMSKeyID},
+		PartSize:   cfg.PartSizeMB << 20,
+		Buckets:    buckets,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid object storage configuration: %w", err)
+	}
+	return store, nil
+}
+
+// artifactUploaders returns the uploaders of the artifact backend one
+// destination names: ipfs, or s3 for the object storage bucket
+func artifactUploaders(backend string, ipfs artifacts.UploaderSource, store *objectstore.Client, cfg config.ObjectStorageConfig) (artifacts.UploaderSource, error) {
+	switch backend {
+	case "", "ipfs":
+		return ipfs, nil
+	case "s3":
+		if store == nil || cfg.Bucket == "" {
+			return nil, fmt.Errorf("the s3 artifact backend needs an object storage endpoint and bucket")
+		}
+		return artifacts.NewS3Uploaders(store, cfg.Bucket, cfg.Prefix), nil
+	default:
+		return nil, fmt.Errorf("unknown artifact backend %q (supported: ipfs, s3)", backend)
+	}
+}
+
+// chunkedUploaders returns source uploading artifacts as chunks the way cfg
+// says, or source itself when chunking is off
+func chunkedUploaders(cfg config.ArtifactChunkingConfig, source artifacts.UploaderSource, cache *artifacts.Cache) (artifacts.UploaderSource, error) {
+	switch cfg.Mode {
+	case "", "off":
+		return source, nil
+	case models.ChunkingFixed, models.ChunkingContentDefined:
+		uploaders, err := artifacts.NewChunkedUploaders(source, cache, artifacts.Chunking{Mode: cfg.Mode, Size: cfg.ChunkKB << 10}, cfg.InlineKB<<10)
+		if err != nil {
+			return nil, fmt.Errorf("invalid artifact chunking: %w", err)
+		}
+		return uploaders, nil
+	default:
+		return nil, fmt.Errorf("unknown artifact chunking mode %q (supported: off, %s, %s)", cfg.Mode, models.ChunkingFixed, models.ChunkingContentDefined)
+	}
+}
@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"context"
+	"path/filepath"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/execution/llm"
+)
+
+const (
+	// warmModelsFileName records the models the runner loaded into Ollama
+	warmModelsFileName = "llm_backend.json"
+	// warmReconcileTimeout bounds the reconciliation on start, which may
+	// load models again
+	warmReconcileTimeout = 15 * time.Minute
+)
+
+// newWarmModels returns the record of the models the runner keeps warm in
+// the local Ollama, kept under dataDir
+func newWarmModels(dataDir string, cfg config.WarmModelsConfig, clk clock.Clock) (*llm.WarmModels, error) {
+	warm, err := llm.NewWarmModels("", filepath.Join(dataDir, warmModelsFileName), cfg.KeepAlive)
+	if err != nil {
+		return nil, err
+	}
+	warm.SetClock(clk)
+	return warm, nil
+}
+
+// OpenWarmModels opens the record of the models the runner keeps warm in
+// the Ollama at baseURL, for unloading them while the runner is stopped
+func OpenWarmModels(baseURL string) (*llm.WarmModels, error) {
+	dir, err := parityDir()
+	if err != nil {
+		return nil, err
+	}
+	return llm.NewWarmModels(baseURL, filepath.Join(dir, warmModelsFileName), 0)
+}
+
+// warmServedModels keeps served warm and reconciles the models the runner
+// loaded with those Ollama holds, in the background, since models the
+// backend lost are loaded again
+func (s *Service) warmServedModels(served []llm.ModelInfo) {
+	if s.warmModels == nil || !s.primary {
+		return
+	}
+	names := make([]string, 0, len(served))
+	for _, model := range served {
+		names = append(names, model.Name)
+	}
+	s.warmModels.SetServed(names)
+
+	go func() {
+		log := gologger.WithComponent("runner")
+		ctx, cancel := context.WithTimeout(context.Background(), warmReconcileTimeout)
+		defer cancel()
+
+		report, err := s.warmModels.Reconcile(ctx)
+		if err != nil {
+			log.Warn().Err(err).Msg("Failed to reconcile warm models with Ollama")
+			if report == nil {
+				return
+			}
+		}
+		log.Info().
+			Strs("renewed", report.Renewed).
+			Strs("reloaded", report.Reloaded).
+			Strs("dropped", report.Dropped).
+			Strs("foreign", report.Foreign).
+			Msg("Reconciled warm models with Ollama")
+	}()
+}
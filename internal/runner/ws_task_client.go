@@ -0,0 +1,452 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/apiclient"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/messaging/webhook"
+)
+
+const (
+	// taskSocketPath is where the server accepts task sockets
+	taskSocketPath = "/api/v1/runners/ws"
+	// maxSocketMessageBytes bounds a message read from the task socket
+	maxSocketMessageBytes = 4 << 20
+	// attemptedTTL is how long a task the runner attempted is not
+	// attempted again when it is offered anew
+	attemptedTTL = time.Hour
+
+	TransportWebhook   = "webhook"
+	TransportWebSocket = "websocket"
+
+	socketAvailableTasks = "available_tasks"
+	socketReleaseTasks   = "release_tasks"
+)
+
+// ErrTaskSocketClosed is returned once the task socket client is closed
+var ErrTaskSocketClosed = errors.New("task socket closed")
+
+// releasedTasks is what the runner sends on closing its task socket: the
+// tasks it was offered but never attempted, which the server may hand to
+// other runners at once
+type releasedTasks struct {
+	TaskIDs []string `json:"task_ids"`
+}
+
+// WSTaskClient learns of tasks from a websocket the server pushes
+// available tasks over, rather than by polling for them, and makes every
+// other request through the HTTPTaskClient it embeds. While the socket
+// cannot be established it polls, as the HTTPTaskClient does. A task it
+// handed out is not handed out again, however often it is offered.
+type WSTaskClient struct {
+	*HTTPTaskClient
+	url    string
+	cfg    config.TaskSocketConfig
+	dialer *websocket.Dialer
+	// backoff spaces redials after failed dials
+	backoff apiclient.RetryPolicy
+
+	mu sync.Mutex
+	// conn is the socket, nil while it is down; writeMu serializes writes
+	conn    *websocket.Conn
+	writeMu sync.Mutex
+	// served is closed once the current socket stops being read
+	served chan struct{}
+	// queue holds the tasks offered and not yet handed out
+	queue     []*models.Task
+	attempted map[string]time.Time
+	// polled is when available tasks were last polled for
+	polled time.Time
+	closed bool
+	// wake is signalled when tasks are queued or the socket goes up or
+	// down
+	wake chan struct{}
+}
+
+// NewWSTaskClient returns a client dialing the task socket of the server
+// client makes its requests to
+func NewWSTaskClient(client *HTTPTaskClient, serverURL string, cfg config.TaskSocketConfig) (*WSTaskClient, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return nil, fmt.Errorf("invalid server URL %q", serverURL)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + taskSocketPath
+
+	return &WSTaskClient{
+		HTTPTaskClient: client,
+		url:            u.String(),
+		cfg:            cfg,
+		dialer:         &websocket.Dialer{Proxy: http.ProxyFromEnvironment, HandshakeTimeout: cfg.WriteWait},
+		backoff:        apiclient.RetryPolicy{BaseDelay: time.Second, MaxDelay: cfg.ReconnectMaxDelay, Jitter: 0.2},
+		attempted:      make(map[string]time.Time),
+		wake:           make(chan struct{}, 1),
+	}, nil
+}
+
+// Connected reports whether the socket is up
+func (c *WSTaskClient) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// Run keeps the socket up until ctx ends or the client is closed,
+// redialing with backoff whenever it drops
+func (c *WSTaskClient) Run(ctx context.Context) {
+	log := gologger.WithComponent("task_socket")
+	failures := 0
+	for ctx.Err() == nil && !c.isClosed() {
+		conn, err := c.dial(ctx)
+		if err != nil {
+			failures++
+			delay := c.backoff.Delay(failures)
+			if failures == 1 {
+				log.Warn().Err(err).Str("url", c.url).Msg("Task socket unavailable, polling for tasks until it is up")
+			} else {
+				log.Debug().Err(err).Int("attempt", failures).Dur("backoff", delay).Msg("Task socket dial failed")
+			}
+			select {
+			case <-ctx.Done():
+			case <-c.clock.After(delay):
+			}
+			continue
+		}
+		if failures > 0 {
+			log.Info().Int("attempts", failures+1).Msg("Task socket established")
+		}
+		failures = 0
+		if err := c.serve(ctx, conn); err != nil && !c.isClosed() {
+			log.Warn().Err(err).Msg("Task socket lost, reconnecting")
+		}
+	}
+}
+
+// dial opens the socket, authenticated as the runner's device
+func (c *WSTaskClient) dial(ctx context.Context) (*websocket.Conn, error) {
+	deviceID, err := c.deviceID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device ID: %w", err)
+	}
+	header := http.Header{}
+	header.Set("X-Device-ID", deviceID)
+	conn, resp, err := c.dialer.DialContext(ctx, c.url, header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("%w (%s)", err, resp.Status)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// serve reads the socket until it fails, pinging the server meanwhile
+func (c *WSTaskClient) serve(ctx context.Context, conn *websocket.Conn) error {
+	served := make(chan struct{})
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		conn.Close()
+		return ErrTaskSocketClosed
+	}
+	c.conn, c.served = conn, served
+	c.mu.Unlock()
+	c.signal()
+	defer func() {
+		c.mu.Lock()
+		c.conn = nil
+		c.mu.Unlock()
+		conn.Close()
+		close(served)
+		c.signal()
+	}()
+
+	conn.SetReadLimit(maxSocketMessageBytes)
+	extend := func() error { return conn.SetReadDeadline(c.clock.Now().Add(c.cfg.PongWait)) }
+	extend()
+	conn.SetPongHandler(func(string) error { return extend() })
+	conn.SetPingHandler(func(data string) error {
+		extend()
+		return c.write(conn, websocket.PongMessage, []byte(data))
+	})
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.ping(conn, stop)
+	// Cancelling ctx ends the read below
+	defer context.AfterFunc(ctx, func() { conn.Close() })()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		extend()
+		c.receive(data)
+	}
+}
+
+// ping pings the server every PingInterval until stop is closed
+func (c *WSTaskClient) ping(conn *websocket.Conn, stop <-chan struct{}) {
+	ticker := c.clock.NewTicker(c.cfg.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C():
+			if err := c.write(conn, websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *WSTaskClient) write(conn *websocket.Conn, messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	deadline := c.clock.Now().Add(c.cfg.WriteWait)
+	if messageType == websocket.PingMessage || messageType == websocket.PongMessage || messageType == websocket.CloseMessage {
+		return conn.WriteControl(messageType, data, deadline)
+	}
+	conn.SetWriteDeadline(deadline)
+	return conn.WriteMessage(messageType, data)
+}
+
+// receive queues the tasks a message offers. Offers take the shape of the
+// webhook's, with one task or a list of them as the payload.
+func (c *WSTaskClient) receive(data []byte) {
+	log := gologger.WithComponent("task_socket")
+	var message webhook.WebhookMessage
+	if err := json.Unmarshal(data, &message); err != nil {
+		log.Warn().Err(err).Msg("Invalid task socket message")
+		return
+	}
+	if message.Type != socketAvailableTasks {
+		log.Debug().Str("type", message.Type).Msg("Ignoring task socket message")
+		return
+	}
+	var tasks []*models.Task
+	if err := json.Unmarshal(message.Payload, &tasks); err != nil {
+		var task *models.Task
+		if err := json.Unmarshal(message.Payload, &task); err != nil {
+			log.Warn().Err(err).Msg("Invalid tasks in task socket message")
+			return
+		}
+		tasks = []*models.Task{task}
+	}
+
+	c.mu.Lock()
+	for _, task := range tasks {
+		if task != nil && !c.offeredLocked(task.ID.String()) {
+			c.queue = append(c.queue, task)
+		}
+	}
+	c.mu.Unlock()
+	c.signal()
+}
+
+// offeredLocked reports whether the task was attempted or is queued
+func (c *WSTaskClient) offeredLocked(taskID string) bool {
+	if _, ok := c.attempted[taskID]; ok {
+		return true
+	}
+	for _, queued := range c.queue {
+		if queued.ID.String() == taskID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *WSTaskClient) signal() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (c *WSTaskClient) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// take hands out the first queued task, or while the socket is down the
+// first available task polled for, that was not attempted before. Polls
+// are at least PollInterval apart. It returns nil when there is none.
+func (c *WSTaskClient) take() (*models.Task, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrTaskSocketClosed
+	}
+	if len(c.queue) > 0 {
+		task := c.queue[0]
+		c.queue = c.queue[1:]
+		c.markAttemptedLocked(task.ID.String())
+		c.mu.Unlock()
+		return task, nil
+	}
+	polling := c.conn == nil && c.untilPollLocked() <= 0
+	if polling {
+		c.polled = c.clock.Now()
+	}
+	c.mu.Unlock()
+	if !polling {
+		return nil, nil
+	}
+
+	tasks, err := c.GetAvailableTasks()
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, task := range tasks {
+		if !c.offeredLocked(task.ID.String()) {
+			c.markAttemptedLocked(task.ID.String())
+			return task, nil
+		}
+	}
+	return nil, nil
+}
+
+// untilPollLocked returns how long until available tasks may be polled
+// for again
+func (c *WSTaskClient) untilPollLocked() time.Duration {
+	if c.polled.IsZero() {
+		return 0
+	}
+	return c.cfg.PollInterval - c.clock.Now().Sub(c.polled)
+}
+
+func (c *WSTaskClient) markAttemptedLocked(taskID string) {
+	now := c.clock.Now()
+	for id, at := range c.attempted {
+		if now.Sub(at) > attemptedTTL {
+			delete(c.attempted, id)
+		}
+	}
+	c.attempted[taskID] = now
+}
+
+// NextTask waits for a task to attempt: the next one the server offers
+// over the socket, or while it is down the next one polling finds. Tasks
+// are not claimed; ctx ending or the client closing returns an error.
+func (c *WSTaskClient) NextTask(ctx context.Context) (*models.Task, error) {
+	log := gologger.WithComponent("task_socket")
+	for {
+		task, err := c.take()
+		if errors.Is(err, ErrTaskSocketClosed) {
+			return nil, err
+		}
+		if err != nil {
+			log.Debug().Err(err).Msg("Failed to poll for available tasks")
+		}
+		if task != nil {
+			return task, nil
+		}
+
+		var poll <-chan time.Time
+		c.mu.Lock()
+		if c.conn == nil {
+			poll = c.clock.After(max(c.untilPollLocked(), 0))
+		}
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-c.wake:
+		case <-poll:
+		}
+	}
+}
+
+// FetchTask claims the next task offered over the socket, or polled for
+// while it is down, as HTTPTaskClient.FetchTask does, moving past tasks
+// another runner claimed first
+func (c *WSTaskClient) FetchTask() (*models.Task, error) {
+	for {
+		task, err := c.take()
+		if err != nil {
+			return nil, err
+		}
+		if task == nil {
+			return nil, ErrNoTasks
+		}
+		claimed, err := c.claimFirst([]*models.Task{task})
+		if errors.Is(err, ErrNoTasks) {
+			continue
+		}
+		return claimed, err
+	}
+}
+
+// Close releases the tasks offered but not yet handed out to the server,
+// closes the socket cleanly and stops redialing. It waits for the server
+// to acknowledge the close until ctx ends or WriteWait passes.
+func (c *WSTaskClient) Close(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	conn, served := c.conn, c.served
+	released := releasedTasks{TaskIDs: make([]string, 0, len(c.queue))}
+	for _, task := range c.queue {
+		released.TaskIDs = append(released.TaskIDs, task.ID.String())
+	}
+	c.queue = nil
+	c.mu.Unlock()
+	c.signal()
+	if conn == nil {
+		return nil
+	}
+
+	var errs []error
+	if len(released.TaskIDs) > 0 {
+		payload, _ := json.Marshal(released)
+		message, _ := json.Marshal(webhook.WebhookMessage{Type: socketReleaseTasks, Payload: payload})
+		if err := c.write(conn, websocket.TextMessage, message); err != nil {
+			errs = append(errs, fmt.Errorf("failed to release offered tasks: %w", err))
+		}
+	}
+	closing := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "runner shutting down")
+	if err := c.write(conn, websocket.CloseMessage, closing); err != nil {
+		errs = append(errs, fmt.Errorf("failed to close task socket: %w", err))
+		conn.Close()
+		return errors.Join(errs...)
+	}
+
+	// The server echoes the close, ending the read
+	select {
+	case <-served:
+	case <-ctx.Done():
+		conn.Close()
+	case <-c.clock.After(c.cfg.WriteWait):
+		conn.Close()
+	}
+	return errors.Join(errs...)
+}
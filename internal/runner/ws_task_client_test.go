@@ -0,0 +1,300 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+
+	"github.com/theblitlabs/parity-runner/internal/apiclient"
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/config"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/messaging/webhook"
+)
+
+var testSocketConfig = config.TaskSocketConfig{
+	PingInterval:      20 * time.Millisecond,
+	PongWait:          time.Second,
+	WriteWait:         time.Second,
+	ReconnectMaxDelay: 50 * time.Millisecond,
+	PollInterval:      20 * time.Millisecond,
+}
+
+// socketServer accepts task sockets, handing each to the next of serve
+type socketServer struct {
+	upgrader websocket.Upgrader
+	serve    chan func(conn *websocket.Conn)
+	pings    atomic.Int32
+	// available is served to runners polling for tasks
+	mu        sync.Mutex
+	available []*models.Task
+	polls     int
+}
+
+func newSocketServer(t *testing.T) (*socketServer, *WSTaskClient) {
+	t.Helper()
+	s := &socketServer{serve: make(chan func(*websocket.Conn), 4)}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	client, err := NewWSTaskClient(NewHTTPTaskClient(server.URL, apiclient.DefaultRetryPolicy), server.URL, testSocketConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetDeviceID("device-1")
+	return s, client
+}
+
+func (s *socketServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case taskSocketPath:
+		if r.Header.Get("X-Device-ID") != "device-1" {
+			http.Error(w, "missing device ID", http.StatusUnauthorized)
+			return
+		}
+		var serve func(*websocket.Conn)
+		select {
+		case serve = <-s.serve:
+		default:
+			http.Error(w, "no sockets", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := s.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetPingHandler(func(data string) error {
+			s.pings.Add(1)
+			return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+		})
+		serve(conn)
+	case "/api/v1/runners/tasks/available":
+		s.mu.Lock()
+		s.polls++
+		json.NewEncoder(w).Encode(s.available)
+		s.mu.Unlock()
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func offer(t *testing.T, conn *websocket.Conn, payload interface{}) {
+	t.Helper()
+	raw, _ := json.Marshal(payload)
+	if err := conn.WriteJSON(webhook.WebhookMessage{Type: socketAvailableTasks, Payload: raw}); err != nil {
+		t.Errorf("failed to offer tasks: %v", err)
+	}
+}
+
+// readUntilClosed reads conn, answering pings, until the runner closes it
+func readUntilClosed(conn *websocket.Conn) (messages []webhook.WebhookMessage, closeCode int) {
+	for {
+		var message webhook.WebhookMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			if closeErr, ok := err.(*websocket.CloseError); ok {
+				return messages, closeErr.Code
+			}
+			return messages, 0
+		}
+		messages = append(messages, message)
+	}
+}
+
+func nextTask(t *testing.T, client *WSTaskClient, timeout time.Duration) *models.Task {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	task, err := client.NextTask(ctx)
+	if err != nil {
+		return nil
+	}
+	return task
+}
+
+func waitConnected(t *testing.T, client *WSTaskClient) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !client.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("task socket never connected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestTaskSocketDeliversOfferedTasksOnce(t *testing.T) {
+	server, client := newSocketServer(t)
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker}
+	other := &models.Task{ID: uuid.New(), Type: models.TaskTypeCommand}
+	done := make(chan struct{})
+	server.serve <- func(conn *websocket.Conn) {
+		offer(t, conn, task)
+		offer(t, conn, []*models.Task{task, other})
+		readUntilClosed(conn)
+		close(done)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	go client.Run(ctx)
+	defer cancel()
+	waitConnected(t, client)
+
+	if got := nextTask(t, client, 5*time.Second); got == nil || got.ID != task.ID {
+		t.Fatalf("NextTask() = %+v, want the offered task", got)
+	}
+	if got := nextTask(t, client, 5*time.Second); got == nil || got.ID != other.ID {
+		t.Fatalf("NextTask() = %+v, want the second offered task, the first offered again skipped", got)
+	}
+	if got := nextTask(t, client, 100*time.Millisecond); got != nil {
+		t.Fatalf("NextTask() = %+v, want no task handed out twice", got)
+	}
+	if server.pings.Load() == 0 {
+		t.Error("runner never pinged the server")
+	}
+	server.mu.Lock()
+	polls := server.polls
+	server.mu.Unlock()
+	if polls != 0 {
+		t.Errorf("runner polled %d times with the socket up", polls)
+	}
+	cancel()
+	<-done
+}
+
+func TestTaskSocketFallsBackToPolling(t *testing.T) {
+	server, client := newSocketServer(t)
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker}
+	server.available = []*models.Task{task}
+
+	// The server takes no sockets, so the runner polls
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if got := nextTask(t, client, 5*time.Second); got == nil || got.ID != task.ID {
+		t.Fatalf("NextTask() = %+v, want the polled task", got)
+	}
+	if got := nextTask(t, client, 100*time.Millisecond); got != nil {
+		t.Fatalf("NextTask() = %+v, want the polled task not handed out twice", got)
+	}
+
+	// Once a socket is taken, tasks arrive over it
+	pushed := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker}
+	server.serve <- func(conn *websocket.Conn) {
+		offer(t, conn, pushed)
+		readUntilClosed(conn)
+	}
+	if got := nextTask(t, client, 5*time.Second); got == nil || got.ID != pushed.ID {
+		t.Fatalf("NextTask() after reconnecting = %+v, want the pushed task", got)
+	}
+}
+
+func TestTaskSocketPollsAtPollInterval(t *testing.T) {
+	server, client := newSocketServer(t)
+	clk := clocktest.NewFake(time.Now())
+	client.SetClock(clk)
+	client.cfg.PollInterval = 50 * time.Millisecond
+	for i := 0; i < 100; i++ {
+		server.available = append(server.available, &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker})
+	}
+	polls := func() int {
+		server.mu.Lock()
+		defer server.mu.Unlock()
+		return server.polls
+	}
+
+	// Tasks are taken as fast as they are asked for while the socket is
+	// down, but polled for once per poll interval
+	for _, step := range []struct {
+		advance time.Duration
+		polls   int
+	}{
+		{0, 1},
+		{49 * time.Millisecond, 1},
+		{time.Millisecond, 2},
+		{50 * time.Millisecond, 3},
+	} {
+		clk.Advance(step.advance)
+		for i := 0; i < 5; i++ {
+			if _, err := client.take(); err != nil {
+				t.Fatalf("take() error = %v", err)
+			}
+		}
+		if got := polls(); got != step.polls {
+			t.Fatalf("polls = %d after advancing %s, want %d", got, step.advance, step.polls)
+		}
+	}
+}
+
+func TestTaskSocketReconnects(t *testing.T) {
+	server, client := newSocketServer(t)
+	server.serve <- func(conn *websocket.Conn) {
+		// Dropped without a close, as by a network failure
+	}
+	task := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker}
+	server.serve <- func(conn *websocket.Conn) {
+		offer(t, conn, task)
+		readUntilClosed(conn)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if got := nextTask(t, client, 5*time.Second); got == nil || got.ID != task.ID {
+		t.Fatalf("NextTask() = %+v, want the task offered after reconnecting", got)
+	}
+}
+
+func TestTaskSocketCloseReleasesOfferedTasks(t *testing.T) {
+	server, client := newSocketServer(t)
+	taken := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker}
+	offered := &models.Task{ID: uuid.New(), Type: models.TaskTypeDocker}
+	type closing struct {
+		messages []webhook.WebhookMessage
+		code     int
+	}
+	closed := make(chan closing, 1)
+	server.serve <- func(conn *websocket.Conn) {
+		offer(t, conn, []*models.Task{taken, offered})
+		messages, code := readUntilClosed(conn)
+		closed <- closing{messages, code}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go client.Run(ctx)
+
+	if got := nextTask(t, client, 5*time.Second); got == nil || got.ID != taken.ID {
+		t.Fatalf("NextTask() = %+v, want the first offered task", got)
+	}
+	if err := client.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	var got closing
+	select {
+	case got = <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server never saw the socket closed")
+	}
+	if got.code != websocket.CloseNormalClosure {
+		t.Errorf("close code = %d, want a normal closure", got.code)
+	}
+	if len(got.messages) != 1 || got.messages[0].Type != socketReleaseTasks {
+		t.Fatalf("server received %+v, want the unattempted task released", got.messages)
+	}
+	var released releasedTasks
+	json.Unmarshal(got.messages[0].Payload, &released)
+	if len(released.TaskIDs) != 1 || released.TaskIDs[0] != offered.ID.String() {
+		t.Errorf("released %v, want only %s", released.TaskIDs, offered.ID)
+	}
+	if _, err := client.NextTask(context.Background()); err == nil {
+		t.Error("NextTask() after Close() succeeded")
+	}
+}
@@ -0,0 +1,150 @@
+// Package safejson decodes untrusted JSON, such as the task configs the
+// server relays from task creators. Documents are checked against size,
+// nesting and numeric bounds and for duplicate keys before anything is
+// unmarshalled, so a hostile document fails fast with an error instead of
+// exhausting the stack or memory of the code that walks it, and no two
+// decoders of the same document can disagree on which duplicate won.
+package safejson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// MaxSize is the largest document Unmarshal accepts, in bytes
+	MaxSize = 1 << 20
+	// MaxDepth is the deepest nesting of objects and arrays Unmarshal
+	// accepts
+	MaxDepth = 64
+)
+
+var (
+	ErrTooLarge     = errors.New("json document too large")
+	ErrTooDeep      = errors.New("json document nested too deeply")
+	ErrDuplicateKey = errors.New("duplicate object key")
+	ErrNumberRange  = errors.New("number out of range")
+)
+
+// Limits bounds the documents Check accepts
+type Limits struct {
+	MaxSize  int
+	MaxDepth int
+}
+
+// DefaultLimits are the limits of Check and Unmarshal
+var DefaultLimits = Limits{MaxSize: MaxSize, MaxDepth: MaxDepth}
+
+// frame is an object or array being scanned
+type frame struct {
+	object bool
+	// keys holds the keys of an object seen so far
+	keys map[string]bool
+	// wantKey is set when an object's next token is a key
+	wantKey bool
+}
+
+// Check reports whether data is a single JSON value within DefaultLimits
+// with no duplicate object keys and every number representable
+func Check(data []byte) error {
+	return DefaultLimits.Check(data)
+}
+
+// Check reports whether data is a single JSON value within l with no
+// duplicate object keys and every number representable. Integers must fit
+// an int64 and other numbers a finite float64. It scans the document token
+// by token, so its cost is linear in the size of data however data is
+// nested.
+func (l Limits) Check(data []byte) error {
+	if l.MaxSize > 0 && len(data) > l.MaxSize {
+		return fmt.Errorf("%w: %d bytes, at most %d allowed", ErrTooLarge, len(data), l.MaxSize)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var stack []*frame
+	values := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			if len(stack) > 0 {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if top.object && top.wantKey {
+				if key, ok := tok.(string); ok {
+					if top.keys[key] {
+						return fmt.Errorf("%w %q", ErrDuplicateKey, key)
+					}
+					top.keys[key] = true
+					top.wantKey = false
+					continue
+				}
+			} else if top.object {
+				// This token is the value of the last key
+				top.wantKey = true
+			}
+		} else {
+			// The decoder reads a stream of values, a document is one
+			if values++; values > 1 {
+				return errors.New("unexpected data after top-level value")
+			}
+		}
+
+		switch v := tok.(type) {
+		case json.Delim:
+			switch v {
+			case '{', '[':
+				if l.MaxDepth > 0 && len(stack) >= l.MaxDepth {
+					return fmt.Errorf("%w: at most %d levels allowed", ErrTooDeep, l.MaxDepth)
+				}
+				stack = append(stack, &frame{object: v == '{', keys: make(map[string]bool), wantKey: v == '{'})
+			case '}', ']':
+				stack = stack[:len(stack)-1]
+			}
+		case json.Number:
+			if err := checkNumber(v); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// checkNumber rejects integers that overflow an int64 and numbers that
+// overflow a float64
+func checkNumber(n json.Number) error {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			return fmt.Errorf("%w: %s", ErrNumberRange, s)
+		}
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(f, 0) {
+		return fmt.Errorf("%w: %s", ErrNumberRange, s)
+	}
+	return nil
+}
+
+// Unmarshal checks data against DefaultLimits and then unmarshals it into v
+// like json.Unmarshal
+func Unmarshal(data []byte, v interface{}) error {
+	if err := Check(data); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
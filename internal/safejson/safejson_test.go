@@ -0,0 +1,105 @@
+package safejson
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want error
+	}{
+		{"object", `{"image_name":"alpine","env":{"A":"1"},"inputs":[{"name":"x"}]}`, nil},
+		{"scalar", `42`, nil},
+		{"empty", ``, nil},
+		{"same key in sibling objects", `{"a":{"k":1},"b":{"k":2}}`, nil},
+		{"duplicate key", `{"image_name":"alpine","image_name":"evil"}`, ErrDuplicateKey},
+		{"nested duplicate key", `{"env":{"A":"1","A":"2"}}`, ErrDuplicateKey},
+		{"too deep", strings.Repeat("[", MaxDepth+1) + strings.Repeat("]", MaxDepth+1), ErrTooDeep},
+		{"too deep unterminated", strings.Repeat(`{"a":`, 100000), ErrTooDeep},
+		{"integer overflows int64", `{"cpu_shares":9223372036854775808}`, ErrNumberRange},
+		{"float overflows", `{"min_bandwidth_mbps":1e400}`, ErrNumberRange},
+		{"large int64", `{"cpu_shares":-9223372036854775808}`, nil},
+		{"small float", `{"alpha":1e-400}`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Check([]byte(tt.data))
+			if tt.want == nil && err != nil {
+				t.Fatalf("Check() error = %v, want nil", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Fatalf("Check() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDepthAtLimit(t *testing.T) {
+	data := strings.Repeat("[", MaxDepth) + strings.Repeat("]", MaxDepth)
+	if err := Check([]byte(data)); err != nil {
+		t.Fatalf("Check() error = %v at the depth limit", err)
+	}
+}
+
+func TestCheckRejectsMalformed(t *testing.T) {
+	for _, data := range []string{`{"a":1} {"b":2}`, `1 2`, `{"a":}`, `{"a":1`, `[1,]`, `{1:2}`} {
+		if err := Check([]byte(data)); err == nil {
+			t.Errorf("Check(%q) accepted malformed JSON", data)
+		}
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	data := `"` + strings.Repeat("a", MaxSize) + `"`
+	if err := Check([]byte(data)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("Check() error = %v, want ErrTooLarge", err)
+	}
+	if err := (Limits{}).Check([]byte(data)); err != nil {
+		t.Fatalf("unbounded Check() error = %v", err)
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	var v struct {
+		Image string `json:"image_name"`
+	}
+	if err := Unmarshal([]byte(`{"image_name":"alpine"}`), &v); err != nil || v.Image != "alpine" {
+		t.Fatalf("Unmarshal() = %+v, %v", v, err)
+	}
+	if err := Unmarshal([]byte(`{"image_name":"alpine","image_name":"evil"}`), &v); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("Unmarshal() error = %v, want ErrDuplicateKey", err)
+	}
+}
+
+func FuzzCheck(f *testing.F) {
+	for _, seed := range []string{
+		`{"image_name":"alpine","resources":{"memory":"1g","cpu_shares":512}}`,
+		`{"a":[1,2,{"b":null}],"c":true,"d":-1.5e3}`,
+		`{"a":1,"a":2}`,
+		strings.Repeat("[", 80) + strings.Repeat("]", 80),
+		`1e400`,
+		`"é"`,
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := Check(data); err != nil {
+			return
+		}
+		// Whatever Check accepts decodes without error
+		if len(data) > 0 && len(strings.TrimSpace(string(data))) > 0 {
+			if !json.Valid(data) {
+				t.Fatalf("Check accepted invalid JSON %q", data)
+			}
+			var v interface{}
+			if err := Unmarshal(data, &v); err != nil {
+				t.Fatalf("Unmarshal() error = %v after Check accepted %q", err, data)
+			}
+		}
+	})
+}
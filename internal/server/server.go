@@ -2,8 +2,9 @@ package server
 
 import (
 	"context"
-	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -49,7 +50,7 @@ func NewServer(cfg *config.Config) *Server {
 			Msg("Request")
 	})
 
-	addr := fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port)
+	addr := net.JoinHostPort(strings.Trim(cfg.Server.Host, "[]"), cfg.Server.Port)
 
 	return &Server{
 		router: router,
@@ -0,0 +1,143 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	secpecdsa "github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// keySize is the length of a secp256k1 private key
+const keySize = 32
+
+// Local signs with a key held in the runner's process. The key is kept
+// sealed, XORed with a random pad stored beside it, in memory locked against
+// swapping and left out of core dumps. Each signature unseals the key into
+// a scratch buffer in the same memory, wiped as soon as the signature is
+// made, so the plain key exists only while it is in use.
+//
+// Signatures are made one at a time; each takes well under a millisecond.
+type Local struct {
+	address common.Address
+
+	mu      sync.Mutex
+	mem     []byte
+	locked  bool
+	sealed  []byte
+	pad     []byte
+	scratch []byte
+	// wiped, when set, is called after each signature with the key and
+	// scratch buffer it wiped
+	wiped func(key *secp256k1.PrivateKey, scratch []byte)
+}
+
+// FromECDSA seals key into a Local signer, then wipes key's private scalar
+// so that the sealed copy is the only one left
+func FromECDSA(key *ecdsa.PrivateKey) (*Local, error) {
+	if key == nil || key.D == nil {
+		return nil, errors.New("no private key")
+	}
+	raw := make([]byte, keySize)
+	defer wipe(raw)
+	key.D.FillBytes(raw)
+
+	l, err := newLocal(raw, crypto.PubkeyToAddress(key.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+	words := key.D.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	key.D.SetInt64(0)
+	return l, nil
+}
+
+func newLocal(raw []byte, address common.Address) (*Local, error) {
+	mem, locked, err := lockedBuffer(3 * keySize)
+	if err != nil {
+		return nil, err
+	}
+	l := &Local{
+		address: address,
+		mem:     mem,
+		locked:  locked,
+		sealed:  mem[:keySize:keySize],
+		pad:     mem[keySize : 2*keySize : 2*keySize],
+		scratch: mem[2*keySize : 3*keySize : 3*keySize],
+	}
+	if _, err := rand.Read(l.pad); err != nil {
+		freeBuffer(mem, locked)
+		return nil, fmt.Errorf("failed to seal key: %w", err)
+	}
+	for i := range raw {
+		l.sealed[i] = raw[i] ^ l.pad[i]
+	}
+	return l, nil
+}
+
+// Locked reports whether the key's memory is locked against swapping
+func (l *Local) Locked() bool {
+	return l.locked
+}
+
+func (l *Local) Address() common.Address {
+	return l.address
+}
+
+func (l *Local) SignHash(hash []byte) ([]byte, error) {
+	if err := checkHash(hash); err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.mem == nil {
+		return nil, ErrClosed
+	}
+
+	for i := range l.scratch {
+		l.scratch[i] = l.sealed[i] ^ l.pad[i]
+	}
+	key := secp256k1.PrivKeyFromBytes(l.scratch)
+	wipe(l.scratch)
+	compact := secpecdsa.SignCompact(key, hash, false)
+	key.Zero()
+	if l.wiped != nil {
+		l.wiped(key, l.scratch)
+	}
+
+	// Compact signatures lead with the recovery code offset by 27, where
+	// Ethereum's end with it
+	sig := make([]byte, crypto.SignatureLength)
+	copy(sig, compact[1:])
+	sig[crypto.RecoveryIDOffset] = compact[0] - 27
+	return sig, nil
+}
+
+// Close wipes the sealed key and releases its memory
+func (l *Local) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.mem != nil {
+		freeBuffer(l.mem, l.locked)
+		l.mem, l.sealed, l.pad, l.scratch = nil, nil, nil, nil
+	}
+	return nil
+}
+
+// Format prints the signer's address alone, whatever the verb, so that
+// logging or panicking with a signer never prints its key material
+func (l *Local) Format(f fmt.State, verb rune) {
+	_, _ = io.WriteString(f, l.String())
+}
+
+func (l *Local) String() string {
+	return fmt.Sprintf("signer.Local(%s)", l.address.Hex())
+}
@@ -0,0 +1,132 @@
+package signer
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// newKey returns a key and a copy of it for a signer to take
+func newKey(t *testing.T) (*ecdsa.PrivateKey, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, &ecdsa.PrivateKey{PublicKey: key.PublicKey, D: new(big.Int).Set(key.D)}
+}
+
+func TestLocalSignsLikeCrypto(t *testing.T) {
+	key, taken := newKey(t)
+	s, err := FromECDSA(taken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if s.Address() != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("Address() = %s, want the key's", s.Address().Hex())
+	}
+
+	for i := range 50 {
+		hash := crypto.Keccak256([]byte(fmt.Sprint("result ", i)))
+		got, err := s.SignHash(hash)
+		if err != nil {
+			t.Fatalf("SignHash() error = %v", err)
+		}
+		// Both sign deterministically, as RFC 6979 has them
+		want, err := crypto.Sign(hash, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("SignHash() = %x, want %x", got, want)
+		}
+	}
+	if _, err := s.SignHash([]byte("short")); err == nil {
+		t.Fatal("SignHash() signed a value that is not a hash")
+	}
+}
+
+func TestLocalWipesKeyMaterial(t *testing.T) {
+	key, taken := newKey(t)
+	raw := crypto.FromECDSA(key)
+	s, err := FromECDSA(taken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if taken.D.Sign() != 0 {
+		t.Error("the key handed over still holds its private scalar")
+	}
+	if bytes.Equal(s.sealed, raw) || bytes.Contains(s.mem, raw) {
+		t.Error("the key is kept unsealed")
+	}
+
+	signatures := 0
+	s.wiped = func(key *secp256k1.PrivateKey, scratch []byte) {
+		signatures++
+		if !key.Key.IsZero() {
+			t.Error("the unsealed key was not zeroed after signing")
+		}
+		if !bytes.Equal(scratch, make([]byte, keySize)) {
+			t.Errorf("scratch buffer holds %x after signing, want zeros", scratch)
+		}
+	}
+	for range 3 {
+		if _, err := s.SignHash(crypto.Keccak256([]byte("result"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if signatures != 3 {
+		t.Fatalf("wiped after %d of 3 signatures", signatures)
+	}
+	if bytes.Contains(s.mem, raw) {
+		t.Error("the key is left unsealed after signing")
+	}
+
+	// Printing the signer, as a panic or log line might, shows its address
+	// alone
+	keyHex := hex.EncodeToString(raw)
+	for _, verb := range []string{"%v", "%+v", "%#v", "%s", "%x", "%d"} {
+		printed := fmt.Sprintf(verb, s)
+		if !strings.Contains(printed, s.Address().Hex()) || strings.Contains(printed, keyHex) ||
+			strings.Contains(printed, hex.EncodeToString(s.sealed)) || strings.Contains(printed, hex.EncodeToString(s.pad)) {
+			t.Errorf("Sprintf(%q) = %q, want the address alone", verb, printed)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.SignHash(crypto.Keccak256([]byte("result"))); !errors.Is(err, ErrClosed) {
+		t.Fatalf("SignHash() after Close = %v, want ErrClosed", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("second Close() = %v", err)
+	}
+}
+
+func BenchmarkLocalSignHash(b *testing.B) {
+	key, _ := crypto.GenerateKey()
+	s, err := FromECDSA(key)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	hash := crypto.Keccak256([]byte("result"))
+	b.ResetTimer()
+	for range b.N {
+		if _, err := s.SignHash(hash); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package signer
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockedBuffer returns size bytes of memory that is left out of core dumps
+// and, when locked is set, never swapped to disk. Locking fails when the
+// memory lock limit is too low, leaving ordinary memory.
+func lockedBuffer(size int) (buf []byte, locked bool, err error) {
+	buf, err = unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to map key memory: %w", err)
+	}
+	_ = unix.Madvise(buf, unix.MADV_DONTDUMP)
+	return buf, unix.Mlock(buf) == nil, nil
+}
+
+// freeBuffer wipes buf and releases it
+func freeBuffer(buf []byte, locked bool) {
+	wipe(buf)
+	if locked {
+		_ = unix.Munlock(buf)
+	}
+	_ = unix.Munmap(buf)
+}
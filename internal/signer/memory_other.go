@@ -0,0 +1,14 @@
+//go:build !linux
+
+package signer
+
+// lockedBuffer returns size bytes of ordinary memory: locking is only
+// supported on Linux
+func lockedBuffer(size int) (buf []byte, locked bool, err error) {
+	return make([]byte, size), false, nil
+}
+
+// freeBuffer wipes buf
+func freeBuffer(buf []byte, locked bool) {
+	wipe(buf)
+}
@@ -0,0 +1,252 @@
+package signer
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// defaultRemoteTimeout bounds each request to an external signer that sets
+// no timeout of its own
+const defaultRemoteTimeout = 10 * time.Second
+
+// maxResponseSize bounds one line the external signer writes
+const maxResponseSize = 64 << 10
+
+type request struct {
+	ID     uint64      `json:"id"`
+	Method string      `json:"method"`
+	Params *signParams `json:"params,omitempty"`
+}
+
+type signParams struct {
+	Hash hexutil.Bytes `json:"hash"`
+}
+
+type response struct {
+	ID     uint64        `json:"id"`
+	Result hexutil.Bytes `json:"result,omitempty"`
+	Error  string        `json:"error,omitempty"`
+}
+
+// Remote signs through an external signer, such as a bridge to a hardware
+// wallet, so the key never enters the runner. The runner writes one JSON
+// request per line and the signer answers each with one JSON line:
+//
+//	{"id": 1, "method": "address"}
+//	{"id": 1, "result": "0x<20 bytes>"}
+//	{"id": 2, "method": "sign_hash", "params": {"hash": "0x<32 bytes>"}}
+//	{"id": 2, "result": "0x<65 bytes>"}   or   {"id": 2, "error": "denied"}
+//
+// Signatures are [R || S || V] with V 0 or 1, or 27 or 28. Several requests
+// may be in flight at once and the signer may answer them in any order.
+// Every signature is checked against the signer's address before use.
+type Remote struct {
+	conn    io.ReadWriteCloser
+	timeout time.Duration
+	address common.Address
+
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan response
+	err     error
+}
+
+// NewRemote signs through the external signer at the other end of conn,
+// waiting up to timeout for each answer, and asks it for its address
+func NewRemote(conn io.ReadWriteCloser, timeout time.Duration) (*Remote, error) {
+	if timeout <= 0 {
+		timeout = defaultRemoteTimeout
+	}
+	r := &Remote{conn: conn, timeout: timeout, pending: make(map[uint64]chan response)}
+	go r.read()
+
+	address, err := r.call("address", nil)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to get external signer address: %w", err)
+	}
+	if len(address) != common.AddressLength {
+		conn.Close()
+		return nil, fmt.Errorf("external signer address is %d bytes, want %d", len(address), common.AddressLength)
+	}
+	r.address = common.BytesToAddress(address)
+	return r, nil
+}
+
+// StartRemote runs command, a program and its arguments, as the external
+// signer, speaking to it over its stdin and stdout. Its stderr is the
+// runner's.
+func StartRemote(command []string, timeout time.Duration) (*Remote, error) {
+	if len(command) == 0 || command[0] == "" {
+		return nil, errors.New("external signer command is required")
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to external signer: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to external signer: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start external signer: %w", err)
+	}
+	return NewRemote(&process{cmd: cmd, stdin: stdin, stdout: stdout}, timeout)
+}
+
+func (r *Remote) Address() common.Address {
+	return r.address
+}
+
+func (r *Remote) SignHash(hash []byte) ([]byte, error) {
+	if err := checkHash(hash); err != nil {
+		return nil, err
+	}
+	sig, err := r.call("sign_hash", &signParams{Hash: hash})
+	if err != nil {
+		return nil, fmt.Errorf("external signer failed: %w", err)
+	}
+	if len(sig) == crypto.SignatureLength && sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	if err := verify(hash, sig, r.address); err != nil {
+		return nil, fmt.Errorf("external signer returned a bad signature: %w", err)
+	}
+	return sig, nil
+}
+
+// Close disconnects from the external signer, stopping it when the runner
+// started it
+func (r *Remote) Close() error {
+	r.fail(ErrClosed)
+	return r.conn.Close()
+}
+
+func (r *Remote) call(method string, params *signParams) (hexutil.Bytes, error) {
+	r.mu.Lock()
+	if r.err != nil {
+		r.mu.Unlock()
+		return nil, r.err
+	}
+	r.nextID++
+	id := r.nextID
+	answer := make(chan response, 1)
+	r.pending[id] = answer
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, id)
+		r.mu.Unlock()
+	}()
+
+	line, err := json.Marshal(request{ID: id, Method: method, Params: params})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %w", err)
+	}
+	r.writeMu.Lock()
+	_, err = r.conn.Write(append(line, '\n'))
+	r.writeMu.Unlock()
+	if err != nil {
+		r.fail(fmt.Errorf("external signer unreachable: %w", err))
+		return nil, err
+	}
+
+	timer := time.NewTimer(r.timeout)
+	defer timer.Stop()
+	select {
+	case resp, ok := <-answer:
+		if !ok {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			return nil, r.err
+		}
+		if resp.Error != "" {
+			return nil, errors.New(resp.Error)
+		}
+		return resp.Result, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("no answer to %s within %s", method, r.timeout)
+	}
+}
+
+// read delivers the signer's answers to the requests waiting for them until
+// the connection ends
+func (r *Remote) read() {
+	scanner := bufio.NewScanner(r.conn)
+	scanner.Buffer(make([]byte, 4096), maxResponseSize)
+	for scanner.Scan() {
+		var resp response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			r.fail(fmt.Errorf("invalid answer from external signer: %w", err))
+			return
+		}
+		r.mu.Lock()
+		if answer, ok := r.pending[resp.ID]; ok {
+			answer <- resp
+			delete(r.pending, resp.ID)
+		}
+		r.mu.Unlock()
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	r.fail(fmt.Errorf("external signer disconnected: %w", err))
+}
+
+// fail fails every request waiting and every later one with err
+func (r *Remote) fail(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	r.err = err
+	for id, answer := range r.pending {
+		close(answer)
+		delete(r.pending, id)
+	}
+}
+
+// process is an external signer the runner started
+type process struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+}
+
+func (p *process) Read(b []byte) (int, error)  { return p.stdout.Read(b) }
+func (p *process) Write(b []byte) (int, error) { return p.stdin.Write(b) }
+
+// Close closes the signer's stdin, which tells it to exit, and kills it
+// if it has not exited shortly after
+func (p *process) Close() error {
+	p.stdin.Close()
+	exited := make(chan struct{})
+	go func() {
+		_ = p.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		_ = p.cmd.Process.Kill()
+		<-exited
+	}
+	return nil
+}
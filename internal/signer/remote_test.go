@@ -0,0 +1,213 @@
+package signer
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const helperKeyEnv = "PARITY_TEST_SIGNER_KEY"
+
+// fakeSigner answers an external signer's requests with key, holding
+// answers back until batch requests arrived and then sending them in
+// reverse order
+type fakeSigner struct {
+	key   *ecdsa.PrivateKey
+	batch int
+	// legacyV sends V as 27 or 28
+	legacyV bool
+	// refuse fails every signature with this error
+	refuse string
+	// impostor, when set, makes the signatures in place of key
+	impostor *ecdsa.PrivateKey
+}
+
+func (f *fakeSigner) serve(r io.Reader, w io.Writer) {
+	scanner := bufio.NewScanner(r)
+	var held []response
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			return
+		}
+		resp := response{ID: req.ID}
+		switch {
+		case req.Method == "address":
+			resp.Result = crypto.PubkeyToAddress(f.key.PublicKey).Bytes()
+		case req.Method != "sign_hash":
+			resp.Error = "unknown method " + req.Method
+		case f.refuse != "":
+			resp.Error = f.refuse
+		default:
+			signWith := f.key
+			if f.impostor != nil {
+				signWith = f.impostor
+			}
+			sig, err := crypto.Sign(req.Params.Hash, signWith)
+			if err != nil {
+				resp.Error = err.Error()
+			}
+			if f.legacyV {
+				sig[crypto.RecoveryIDOffset] += 27
+			}
+			resp.Result = sig
+		}
+
+		held = append(held, resp)
+		if req.Method == "sign_hash" && len(held) < f.batch {
+			continue
+		}
+		for i := len(held) - 1; i >= 0; i-- {
+			line, _ := json.Marshal(held[i])
+			if _, err := w.Write(append(line, '\n')); err != nil {
+				return
+			}
+		}
+		held = held[:0]
+	}
+}
+
+// connect returns a Remote speaking to f
+func connect(t *testing.T, f *fakeSigner) (*Remote, net.Conn) {
+	t.Helper()
+	local, peer := net.Pipe()
+	go f.serve(peer, peer)
+	r, err := NewRemote(local, time.Second)
+	if err != nil {
+		t.Fatalf("NewRemote() error = %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r, peer
+}
+
+func TestRemoteSignsConcurrently(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	const n = 16
+	r, _ := connect(t, &fakeSigner{key: key, batch: n})
+	if r.Address() != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("Address() = %s, want the signer's", r.Address().Hex())
+	}
+
+	// The answers come back in reverse order once all requests are in
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hash := crypto.Keccak256([]byte(fmt.Sprint("result ", i)))
+			sig, err := r.SignHash(hash)
+			if err == nil {
+				err = verify(hash, sig, r.Address())
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRemoteChecksSignatures(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	hash := crypto.Keccak256([]byte("result"))
+
+	legacy, _ := connect(t, &fakeSigner{key: key, legacyV: true})
+	if _, err := legacy.SignHash(hash); err != nil {
+		t.Fatalf("SignHash() with V of 27 or 28 error = %v", err)
+	}
+
+	refusing, _ := connect(t, &fakeSigner{key: key, refuse: "denied on device"})
+	if _, err := refusing.SignHash(hash); err == nil || !strings.Contains(err.Error(), "denied on device") {
+		t.Fatalf("SignHash() = %v, want the signer's refusal", err)
+	}
+
+	// A signer answering with another key is not trusted
+	other, _ := crypto.GenerateKey()
+	impostor, _ := connect(t, &fakeSigner{key: key, impostor: other})
+	if _, err := impostor.SignHash(hash); err == nil || !strings.Contains(err.Error(), "bad signature") {
+		t.Fatalf("SignHash() = %v, want a signature by another key rejected", err)
+	}
+}
+
+func TestRemoteFailsWhenSignerGoes(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	r, peer := connect(t, &fakeSigner{key: key, batch: 2})
+
+	// The first request waits for a second that never comes
+	failed := make(chan error, 1)
+	go func() {
+		_, err := r.SignHash(crypto.Keccak256([]byte("result")))
+		failed <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	peer.Close()
+
+	select {
+	case err := <-failed:
+		if err == nil {
+			t.Fatal("SignHash() succeeded after the signer disconnected")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SignHash() still waiting after the signer disconnected")
+	}
+	if _, err := r.SignHash(crypto.Keccak256([]byte("later"))); err == nil {
+		t.Fatal("SignHash() succeeded after the signer disconnected")
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStartRemoteProcess(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	t.Setenv(helperKeyEnv, hexutil.Encode(crypto.FromECDSA(key)))
+
+	r, err := StartRemote([]string{os.Args[0], "-test.run=^TestSignerHelperProcess$"}, 10*time.Second)
+	if err != nil {
+		t.Fatalf("StartRemote() error = %v", err)
+	}
+	if r.Address() != crypto.PubkeyToAddress(key.PublicKey) {
+		t.Fatalf("Address() = %s, want the signer's", r.Address().Hex())
+	}
+	hash := crypto.Keccak256([]byte("result"))
+	if _, err := r.SignHash(hash); err != nil {
+		t.Fatalf("SignHash() error = %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.SignHash(hash); !errors.Is(err, ErrClosed) {
+		t.Fatalf("SignHash() after Close = %v, want ErrClosed", err)
+	}
+}
+
+// TestSignerHelperProcess is the external signer TestStartRemoteProcess
+// starts
+func TestSignerHelperProcess(t *testing.T) {
+	encoded := os.Getenv(helperKeyEnv)
+	if encoded == "" {
+		t.Skip("only run as an external signer")
+	}
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(encoded, "0x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	(&fakeSigner{key: key}).serve(os.Stdin, os.Stdout)
+}
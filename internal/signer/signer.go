@@ -0,0 +1,59 @@
+// Package signer signs as the runner's wallet while keeping its private key
+// out of reach: sealed in locked memory between signatures, or held by
+// another process, such as a hardware wallet bridge, so that the key never
+// enters the runner at all.
+package signer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ErrClosed is returned by signers that were closed
+var ErrClosed = errors.New("signer closed")
+
+// hashSize is the length of the Keccak-256 digests signed
+const hashSize = 32
+
+// Signer signs Keccak-256 digests as the runner's wallet
+type Signer interface {
+	// Address is the wallet the signatures recover to
+	Address() common.Address
+	// SignHash returns the 65-byte [R || S || V] signature of hash, with V
+	// 0 or 1, as crypto.Sign does
+	SignHash(hash []byte) ([]byte, error)
+	// Close releases the key; the signer cannot sign afterwards
+	Close() error
+}
+
+// verify checks that sig is a signature of hash by address
+func verify(hash, sig []byte, address common.Address) error {
+	if len(sig) != crypto.SignatureLength {
+		return fmt.Errorf("signature is %d bytes, want %d", len(sig), crypto.SignatureLength)
+	}
+	pub, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if signer := crypto.PubkeyToAddress(*pub); signer != address {
+		return fmt.Errorf("signature recovers to %s, not %s", signer.Hex(), address.Hex())
+	}
+	return nil
+}
+
+func checkHash(hash []byte) error {
+	if len(hash) != hashSize {
+		return fmt.Errorf("hash is %d bytes, want %d", len(hash), hashSize)
+	}
+	return nil
+}
+
+// wipe zeroes b
+func wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
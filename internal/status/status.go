@@ -0,0 +1,300 @@
+// Package status serves a running runner's status and operator controls on
+// its local port, and reads them from there, for dashboards attached to a
+// runner on the same host or, by URL, a remote one
+package status
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// apiPrefix is where the status API is served on the runner's local port
+const apiPrefix = "/runner"
+
+// ErrInvalidLogLevel is returned for log levels the runner does not know
+var ErrInvalidLogLevel = errors.New("invalid log level")
+
+// ErrInvalidPricing is returned for pricing rates the runner cannot apply
+var ErrInvalidPricing = errors.New("invalid pricing")
+
+// ErrNotFound is returned for a task the runner knows nothing of, and by
+// Client for whatever the runner does not serve
+var ErrNotFound = errors.New("not found")
+
+// Controller reports the runner's status and carries out operator actions
+type Controller interface {
+	Status(ctx context.Context) (*models.LocalStatus, error)
+	// SetDraining stops claiming new tasks and reports the runner not
+	// ready, or undoes it
+	SetDraining(draining bool)
+	// SetPaused stops claiming new tasks, or resumes claiming
+	SetPaused(paused bool)
+	SetLogLevel(level string) error
+	// SetPricing replaces the rates that set the price floor of the tasks
+	// offered from now on
+	SetPricing(rates models.PricingRates) error
+	// TaskTimeline returns the lifecycle timeline of a task the runner ran
+	// or runs, or ErrNotFound
+	TaskTimeline(ctx context.Context, taskID string) (*models.TaskTimeline, error)
+	// ReputationWhatIf scores each way a running task could end against
+	// the runner's reputation, or returns ErrNotFound when it is not running
+	ReputationWhatIf(ctx context.Context, taskID string) (*models.ReputationWhatIf, error)
+	// ReviewResult approves or rejects a result held as anomalous, or
+	// returns ErrNotFound when it is not held
+	ReviewResult(taskID string, approve bool) error
+	// Shutdown drains the runner and stops it once its tasks are done, for
+	// another runner process to take over its data directory
+	Shutdown()
+}
+
+type toggleRequest struct {
+	Enabled bool `json:"enabled"`
+}
+
+type reviewRequest struct {
+	Approve bool `json:"approve"`
+}
+
+type logLevelRequest struct {
+	Level string `json:"level"`
+}
+
+// RegisterHandlers exposes c on mux:
+//
+//	GET  /runner/status      the runner's LocalStatus
+//	POST /runner/drain       {"enabled": true} to drain, false to undo it
+//	POST /runner/pause       {"enabled": true} to pause, false to resume
+//	POST /runner/log-level   {"level": "debug"}
+//	POST /runner/pricing     {"cpu_hour": 0.02, "gpu_hour": 0.5, "gb_ram_hour": 0.005}
+//	GET  /runner/tasks/{id}/timeline  the task's TaskTimeline
+//	GET  /runner/tasks/{id}/what-if   the ReputationWhatIf of a running task
+//	POST /runner/tasks/{id}/review    {"approve": true} to submit a result held as anomalous
+//	POST /runner/shutdown    drain and exit
+func RegisterHandlers(mux *http.ServeMux, c Controller) {
+	mux.HandleFunc("GET "+apiPrefix+"/status", func(w http.ResponseWriter, req *http.Request) {
+		status, err := c.Status(req.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, status)
+	})
+	mux.HandleFunc("POST "+apiPrefix+"/drain", func(w http.ResponseWriter, req *http.Request) {
+		var body toggleRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid drain request", http.StatusBadRequest)
+			return
+		}
+		c.SetDraining(body.Enabled)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST "+apiPrefix+"/pause", func(w http.ResponseWriter, req *http.Request) {
+		var body toggleRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid pause request", http.StatusBadRequest)
+			return
+		}
+		c.SetPaused(body.Enabled)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST "+apiPrefix+"/log-level", func(w http.ResponseWriter, req *http.Request) {
+		var body logLevelRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Level == "" {
+			http.Error(w, "request body must name a level", http.StatusBadRequest)
+			return
+		}
+		if err := c.SetLogLevel(body.Level); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrInvalidLogLevel) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST "+apiPrefix+"/pricing", func(w http.ResponseWriter, req *http.Request) {
+		var rates models.PricingRates
+		if err := json.NewDecoder(req.Body).Decode(&rates); err != nil {
+			http.Error(w, "invalid pricing request", http.StatusBadRequest)
+			return
+		}
+		if err := c.SetPricing(rates); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrInvalidPricing) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("GET "+apiPrefix+"/tasks/{id}/timeline", func(w http.ResponseWriter, req *http.Request) {
+		timeline, err := c.TaskTimeline(req.Context(), req.PathValue("id"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, timeline)
+	})
+	mux.HandleFunc("GET "+apiPrefix+"/tasks/{id}/what-if", func(w http.ResponseWriter, req *http.Request) {
+		whatIf, err := c.ReputationWhatIf(req.Context(), req.PathValue("id"))
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		writeJSON(w, whatIf)
+	})
+	mux.HandleFunc("POST "+apiPrefix+"/tasks/{id}/review", func(w http.ResponseWriter, req *http.Request) {
+		var body reviewRequest
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			http.Error(w, "invalid review request", http.StatusBadRequest)
+			return
+		}
+		if err := c.ReviewResult(req.PathValue("id"), body.Approve); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, ErrNotFound) {
+				status = http.StatusNotFound
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST "+apiPrefix+"/shutdown", func(w http.ResponseWriter, req *http.Request) {
+		c.Shutdown()
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log := gologger.WithComponent("status")
+		log.Error().Err(err).Msg("Failed to write status response")
+	}
+}
+
+// Client reads the status of a running runner and acts on it over the
+// runner's port
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/") + apiPrefix,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if resp.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: %s", ErrNotFound, strings.TrimSpace(string(msg)))
+		}
+		return fmt.Errorf("runner returned %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func (c *Client) Status(ctx context.Context) (*models.LocalStatus, error) {
+	var out models.LocalStatus
+	if err := c.do(ctx, http.MethodGet, "/status", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) SetDraining(ctx context.Context, draining bool) error {
+	return c.do(ctx, http.MethodPost, "/drain", toggleRequest{Enabled: draining}, nil)
+}
+
+func (c *Client) SetPaused(ctx context.Context, paused bool) error {
+	return c.do(ctx, http.MethodPost, "/pause", toggleRequest{Enabled: paused}, nil)
+}
+
+func (c *Client) SetLogLevel(ctx context.Context, level string) error {
+	return c.do(ctx, http.MethodPost, "/log-level", logLevelRequest{Level: level}, nil)
+}
+
+func (c *Client) SetPricing(ctx context.Context, rates models.PricingRates) error {
+	return c.do(ctx, http.MethodPost, "/pricing", rates, nil)
+}
+
+func (c *Client) TaskTimeline(ctx context.Context, taskID string) (*models.TaskTimeline, error) {
+	var out models.TaskTimeline
+	if err := c.do(ctx, http.MethodGet, "/tasks/"+url.PathEscape(taskID)+"/timeline", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) ReputationWhatIf(ctx context.Context, taskID string) (*models.ReputationWhatIf, error) {
+	var out models.ReputationWhatIf
+	if err := c.do(ctx, http.MethodGet, "/tasks/"+url.PathEscape(taskID)+"/what-if", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+func (c *Client) ReviewResult(ctx context.Context, taskID string, approve bool) error {
+	return c.do(ctx, http.MethodPost, "/tasks/"+url.PathEscape(taskID)+"/review", reviewRequest{Approve: approve}, nil)
+}
+
+// Shutdown asks the runner to drain and exit; it returns before the runner
+// has stopped
+func (c *Client) Shutdown(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/shutdown", nil, nil)
+}
@@ -0,0 +1,214 @@
+package status
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// fakeController is a runner whose state the API changes
+type fakeController struct {
+	status    models.LocalStatus
+	timelines map[string]*models.TaskTimeline
+	whatIfs   map[string]*models.ReputationWhatIf
+	// held maps the results held for review to their decision, nil until
+	// reviewed
+	held map[string]*bool
+	// shutdown is set once the runner was asked to exit
+	shutdown bool
+}
+
+func (c *fakeController) Status(ctx context.Context) (*models.LocalStatus, error) {
+	status := c.status
+	return &status, nil
+}
+
+func (c *fakeController) SetDraining(draining bool) { c.status.Draining = draining }
+func (c *fakeController) SetPaused(paused bool)     { c.status.Paused = paused }
+
+func (c *fakeController) SetLogLevel(level string) error {
+	if level != "debug" && level != "info" {
+		return fmt.Errorf("%w %q", ErrInvalidLogLevel, level)
+	}
+	c.status.LogLevel = level
+	return nil
+}
+
+func (c *fakeController) SetPricing(rates models.PricingRates) error {
+	if rates.CPUHour < 0 || rates.GPUHour < 0 || rates.GBRAMHour < 0 {
+		return fmt.Errorf("%w: negative rate", ErrInvalidPricing)
+	}
+	c.status.Pricing = &models.PricingStatus{Rates: rates}
+	return nil
+}
+
+func (c *fakeController) TaskTimeline(ctx context.Context, taskID string) (*models.TaskTimeline, error) {
+	if tl, ok := c.timelines[taskID]; ok {
+		return tl, nil
+	}
+	return nil, fmt.Errorf("%w: task %s", ErrNotFound, taskID)
+}
+
+func (c *fakeController) ReputationWhatIf(ctx context.Context, taskID string) (*models.ReputationWhatIf, error) {
+	if whatIf, ok := c.whatIfs[taskID]; ok {
+		return whatIf, nil
+	}
+	return nil, fmt.Errorf("%w: task %s is not running", ErrNotFound, taskID)
+}
+
+func (c *fakeController) ReviewResult(taskID string, approve bool) error {
+	decision, ok := c.held[taskID]
+	if !ok || decision != nil {
+		return fmt.Errorf("%w: task %s is not held", ErrNotFound, taskID)
+	}
+	c.held[taskID] = &approve
+	return nil
+}
+
+func (c *fakeController) Shutdown() { c.shutdown = true }
+
+func TestClientControlsRunner(t *testing.T) {
+	controller := &fakeController{status: models.LocalStatus{
+		DeviceID: "device-1",
+		LogLevel: "info",
+		Tasks:    []models.RunningTask{{TaskID: "task-1", Type: models.TaskTypeDocker}},
+	}}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, controller)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	ctx := context.Background()
+
+	status, err := client.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.DeviceID != "device-1" || len(status.Tasks) != 1 || status.Tasks[0].TaskID != "task-1" {
+		t.Fatalf("Status() = %+v, want the runner's status", status)
+	}
+
+	if err := client.SetDraining(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetPaused(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetLogLevel(ctx, "debug"); err != nil {
+		t.Fatal(err)
+	}
+	if status, err = client.Status(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !status.Draining || !status.Paused || status.LogLevel != "debug" {
+		t.Fatalf("Status() = %+v after the actions, want draining, paused and debug", status)
+	}
+
+	if err := client.SetPaused(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if controller.status.Paused {
+		t.Fatal("runner still paused after resuming")
+	}
+
+	if err := client.SetLogLevel(ctx, "chatty"); err == nil {
+		t.Fatal("SetLogLevel() accepted an unknown level")
+	}
+	if controller.status.LogLevel != "debug" {
+		t.Fatalf("log level = %q after a rejected change, want debug", controller.status.LogLevel)
+	}
+
+	rates := models.PricingRates{CPUHour: 0.02, GPUHour: 0.5}
+	if err := client.SetPricing(ctx, rates); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetPricing(ctx, models.PricingRates{GPUHour: -1}); err == nil {
+		t.Fatal("SetPricing() accepted a negative rate")
+	}
+	if controller.status.Pricing == nil || controller.status.Pricing.Rates != rates {
+		t.Fatalf("pricing = %+v, want %+v", controller.status.Pricing, rates)
+	}
+
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !controller.shutdown {
+		t.Fatal("runner not asked to shut down")
+	}
+}
+
+func TestClientReadsTaskTimeline(t *testing.T) {
+	controller := &fakeController{timelines: map[string]*models.TaskTimeline{
+		"task-1": {Version: models.TimelineVersion, TaskID: "task-1", Attempts: []models.TimelineAttempt{{
+			Attempt: 1,
+			Outcome: models.AttemptReported,
+			Phases:  []models.TimelinePhase{{Phase: models.PhaseExecuting, DurationMs: 1500}},
+		}}},
+	}}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, controller)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	tl, err := client.TaskTimeline(context.Background(), "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tl.TaskID != "task-1" || len(tl.Attempts) != 1 || tl.Attempts[0].Phases[0].DurationMs != 1500 {
+		t.Fatalf("TaskTimeline() = %+v", tl)
+	}
+	if _, err := client.TaskTimeline(context.Background(), "task-2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("TaskTimeline() of an unknown task error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestClientReadsReputationWhatIf(t *testing.T) {
+	controller := &fakeController{whatIfs: map[string]*models.ReputationWhatIf{
+		"task-1": {TaskID: "task-1", Current: 92.5, Scenarios: []models.ReputationScenario{
+			{Name: "abandon_now", Score: 88, Delta: -4.5},
+			{Name: "time_out", Score: 89.5, Delta: -3},
+		}},
+	}}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, controller)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	whatIf, err := client.ReputationWhatIf(context.Background(), "task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if whatIf.Current != 92.5 || len(whatIf.Scenarios) != 2 || whatIf.Scenarios[1].Delta != -3 {
+		t.Fatalf("ReputationWhatIf() = %+v", whatIf)
+	}
+	if _, err := client.ReputationWhatIf(context.Background(), "task-2"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ReputationWhatIf() of a task not running error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestClientReviewsHeldResult(t *testing.T) {
+	controller := &fakeController{held: map[string]*bool{"task-1": nil}}
+	mux := http.NewServeMux()
+	RegisterHandlers(mux, controller)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if err := client.ReviewResult(context.Background(), "task-1", false); err != nil {
+		t.Fatal(err)
+	}
+	if decision := controller.held["task-1"]; decision == nil || *decision {
+		t.Fatal("held result not rejected")
+	}
+	if err := client.ReviewResult(context.Background(), "task-1", true); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("ReviewResult() of a reviewed result error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,68 @@
+package taskschema
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+func TestValidateRejectsHostileJSON(t *testing.T) {
+	for name, config := range map[string]string{
+		"deeply nested":  `{"env":` + strings.Repeat(`{"a":`, 100000),
+		"duplicate key":  `{"image_name":"alpine","image_name":"evil:latest"}`,
+		"huge number":    `{"image_name":"alpine","resources":{"cpu_shares":1e999}}`,
+		"trailing value": `{"image_name":"alpine"} {"image_name":"evil"}`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var invalid *ValidationError
+			if err := Validate(models.TaskTypeDocker, []byte(config)); !errors.As(err, &invalid) {
+				t.Fatalf("Validate() error = %v, want a ValidationError", err)
+			}
+		})
+	}
+}
+
+// FuzzValidate checks that no config, however malformed, panics the schema
+// validator or TaskConfig.Validate, and that both accept only JSON the
+// hardened decoder accepts. Seeds are the fixtures of real task configs.
+func FuzzValidate(f *testing.F) {
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "*", "*.json"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, fixture := range fixtures {
+		config, err := os.ReadFile(fixture)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(filepath.Base(filepath.Dir(fixture)), config)
+	}
+
+	f.Fuzz(func(t *testing.T, taskType string, config []byte) {
+		err := Validate(models.TaskType(taskType), config)
+		var invalid *ValidationError
+		switch {
+		case err == nil:
+			if len(strings.TrimSpace(string(config))) > 0 && safejson.Check(config) != nil {
+				t.Fatalf("Validate accepted %q, which the hardened decoder rejects", config)
+			}
+		case errors.As(err, &invalid):
+			if len(invalid.Violations) == 0 {
+				t.Fatalf("Validate() returned a ValidationError without violations")
+			}
+		case errors.Is(err, ErrUnknownType), errors.Is(err, ErrUnsupportedVersion):
+		default:
+			t.Fatalf("Validate() error = %v, want nil, a ValidationError or a version error", err)
+		}
+
+		var taskConfig models.TaskConfig
+		if safejson.Unmarshal(config, &taskConfig) == nil {
+			_ = taskConfig.Validate(models.TaskType(taskType))
+		}
+	})
+}
@@ -0,0 +1,226 @@
+// Package taskschema validates task configs against the JSON Schemas
+// published for each task type, so malformed tasks are turned away before
+// they are claimed rather than failing deep in execution.
+package taskschema
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/safejson"
+)
+
+// DefaultVersion is the schema version of a config without schema_version
+const DefaultVersion = 1
+
+var (
+	ErrUnknownType        = errors.New("no schema for task type")
+	ErrUnsupportedVersion = errors.New("unsupported schema version")
+)
+
+//go:embed schemas
+var schemaFS embed.FS
+
+var (
+	documentsMu sync.Mutex
+	documents   = make(map[string]interface{})
+)
+
+// load returns the parsed embedded schema document at name, relative to the
+// schemas directory
+func load(name string) (interface{}, error) {
+	documentsMu.Lock()
+	defer documentsMu.Unlock()
+	if doc, ok := documents[name]; ok {
+		return doc, nil
+	}
+	data, err := schemaFS.ReadFile(path.Join("schemas", name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema %s: %w", name, err)
+	}
+	doc, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema %s: %w", name, err)
+	}
+	documents[name] = doc
+	return doc, nil
+}
+
+// Violation is a part of a config that does not match its schema
+type Violation struct {
+	// Pointer is the JSON pointer to the offending field, "" for the config itself
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+func (v Violation) String() string {
+	pointer := v.Pointer
+	if pointer == "" {
+		pointer = "/"
+	}
+	return pointer + " " + v.Message
+}
+
+// ValidationError lists every violation found in a task config
+type ValidationError struct {
+	Type       models.TaskType
+	Version    int
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		parts[i] = v.String()
+	}
+	return fmt.Sprintf("%s config does not match schema v%d: %s", e.Type, e.Version, strings.Join(parts, "; "))
+}
+
+// Versions returns the schema versions known for a task type, oldest first
+func Versions(taskType models.TaskType) []int {
+	entries, err := fs.ReadDir(schemaFS, path.Join("schemas", string(taskType)))
+	if err != nil {
+		return nil
+	}
+	var versions []int
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		if !strings.HasPrefix(name, "v") {
+			continue
+		}
+		if version, err := strconv.Atoi(name[1:]); err == nil {
+			versions = append(versions, version)
+		}
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// Schema returns the raw JSON Schema for a task type at a version
+func Schema(taskType models.TaskType, version int) ([]byte, error) {
+	versions := Versions(taskType)
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownType, taskType)
+	}
+	data, err := schemaFS.ReadFile(schemaPath(taskType, version))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s config v%d, this runner knows up to v%d",
+			ErrUnsupportedVersion, taskType, version, versions[len(versions)-1])
+	}
+	return data, nil
+}
+
+func schemaPath(taskType models.TaskType, version int) string {
+	return path.Join("schemas", string(taskType), fmt.Sprintf("v%d.json", version))
+}
+
+// configVersion reads the schema_version a config declares
+func configVersion(config interface{}) (int, error) {
+	object, ok := config.(map[string]interface{})
+	if !ok {
+		return DefaultVersion, nil
+	}
+	raw, ok := object["schema_version"]
+	if !ok {
+		return DefaultVersion, nil
+	}
+	number, ok := raw.(json.Number)
+	if !ok {
+		return 0, errors.New("schema_version must be an integer")
+	}
+	version, err := strconv.Atoi(number.String())
+	if err != nil || version < 1 {
+		return 0, errors.New("schema_version must be a positive integer")
+	}
+	return version, nil
+}
+
+// Validate checks a task config against the schema for its type and the
+// schema_version it declares. A config that does not match returns a
+// *ValidationError; one from a newer schema than this runner knows returns
+// ErrUnsupportedVersion.
+func Validate(taskType models.TaskType, config []byte) error {
+	if len(Versions(taskType)) == 0 {
+		return fmt.Errorf("%w: %s", ErrUnknownType, taskType)
+	}
+
+	if len(strings.TrimSpace(string(config))) == 0 {
+		config = []byte("{}")
+	}
+	if err := safejson.Check(config); err != nil {
+		return &ValidationError{
+			Type:       taskType,
+			Version:    DefaultVersion,
+			Violations: []Violation{{Message: fmt.Sprintf("is not acceptable JSON: %v", err)}},
+		}
+	}
+	value, err := decode(config)
+	if err != nil {
+		return &ValidationError{
+			Type:       taskType,
+			Version:    DefaultVersion,
+			Violations: []Violation{{Message: fmt.Sprintf("is not valid JSON: %v", err)}},
+		}
+	}
+
+	version, err := configVersion(value)
+	if err != nil {
+		return &ValidationError{
+			Type:       taskType,
+			Version:    DefaultVersion,
+			Violations: []Violation{{Pointer: "/schema_version", Message: strings.TrimPrefix(err.Error(), "schema_version ")}},
+		}
+	}
+	if _, err := Schema(taskType, version); err != nil {
+		return err
+	}
+
+	name := strings.TrimPrefix(schemaPath(taskType, version), "schemas/")
+	root, err := load(name)
+	if err != nil {
+		return err
+	}
+	schema, ok := root.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("schema %s is not an object", name)
+	}
+
+	v := &validator{load: load}
+	v.validate(name, schema, value, "")
+	if len(v.violations) > 0 {
+		return &ValidationError{Type: taskType, Version: version, Violations: v.violations}
+	}
+	return nil
+}
+
+// ValidateAgainst checks data against the schema that ref, such as
+// "#/components/schemas/Task", points to in document, a JSON document of
+// schemas like an OpenAPI spec. $refs resolve within document. It returns
+// the violations found; an error means the document or data is unusable.
+func ValidateAgainst(document []byte, ref string, data []byte) ([]Violation, error) {
+	root, err := decode(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse schema document: %w", err)
+	}
+	value, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	v := &validator{load: func(string) (interface{}, error) { return root, nil }}
+	name, schema, err := v.resolve("document", ref)
+	if err != nil {
+		return nil, err
+	}
+	v.validate(name, schema, value, "")
+	return v.violations, nil
+}
@@ -0,0 +1,113 @@
+package taskschema
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func TestValidateFixtures(t *testing.T) {
+	tests := []struct {
+		taskType models.TaskType
+		fixture  string
+		// pointers lists the fields reported, nil for a valid config
+		pointers []string
+	}{
+		{models.TaskTypeDocker, "valid_full.json", nil},
+		{models.TaskTypeDocker, "valid_minimal.json", nil},
+		{models.TaskTypeDocker, "invalid_fields.json", []string{
+			"/env/EPOCHS",
+			"/image_name",
+			"/imagename",
+			"/network/extra_hosts/0",
+			"/output_manifest/entries/0/path",
+			"/output_manifest/entries/0/sha256",
+			"/resources/accelerator",
+			"/resources/memory",
+			"/resources/timeout",
+		}},
+		{models.TaskTypeDocker, "invalid_missing_image.json", []string{"/image_name"}},
+		{models.TaskTypeCommand, "valid.json", nil},
+		{models.TaskTypeCommand, "invalid.json", []string{"/command", "/timeout_seconds"}},
+		{models.TaskTypeLLM, "valid.json", nil},
+		{models.TaskTypeLLM, "invalid.json", []string{"/model", "/prompt"}},
+		{models.TaskTypeLLM, "valid_response_format.json", nil},
+		{models.TaskTypeLLM, "invalid_response_format.json", []string{"/response_format"}},
+		{models.TaskTypeLLM, "valid_model_artifact.json", nil},
+		{models.TaskTypeLLM, "invalid_model_artifact.json", []string{"/model_artifact/quantization", "/model_artifact/sha256"}},
+		{models.TaskTypeLLM, "valid_tool_use.json", nil},
+		{models.TaskTypeLLM, "invalid_tool_use.json", []string{"/tool_use/max_calls", "/tool_use/tools/0"}},
+		{models.TaskTypeFederatedLearning, "valid.json", nil},
+		{models.TaskTypeFederatedLearning, "invalid.json", []string{"/data_format", "/model_type", "/train_config"}},
+		{models.TaskTypeEmbedding, "valid_texts.json", nil},
+		{models.TaskTypeEmbedding, "valid_cid.json", nil},
+		{models.TaskTypeEmbedding, "invalid_two_sources.json", []string{"/batch_size", "/format", "/input"}},
+		{models.TaskTypeEmbedding, "invalid_no_source.json", []string{"/model", "/input"}},
+		{models.TaskTypeONNX, "valid_inline.json", nil},
+		{models.TaskTypeONNX, "valid_files.json", nil},
+		{models.TaskTypeONNX, "invalid.json", []string{"/inline_limit", "/model/sha256", "/tensors/0/dtype", "/tensors/0/shape/0", "/tensors/0"}},
+		{models.TaskTypeService, "valid.json", nil},
+		{models.TaskTypeService, "invalid.json", []string{"/duration", "/max_restarts", "/ports/0/host_port", "/readiness/http_path"}},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.taskType)+"/"+tt.fixture, func(t *testing.T) {
+			config, err := os.ReadFile(filepath.Join("testdata", string(tt.taskType), tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Validate(tt.taskType, config)
+			if tt.pointers == nil {
+				if err != nil {
+					t.Fatalf("Validate() error = %v, want valid", err)
+				}
+				return
+			}
+
+			var invalid *ValidationError
+			if !errors.As(err, &invalid) {
+				t.Fatalf("Validate() error = %v, want a ValidationError", err)
+			}
+			var pointers []string
+			for _, v := range invalid.Violations {
+				pointers = append(pointers, v.Pointer)
+			}
+			if !reflect.DeepEqual(pointers, tt.pointers) {
+				t.Fatalf("violations at %q, want %q\n%v", pointers, tt.pointers, invalid)
+			}
+		})
+	}
+}
+
+func TestValidateSchemaVersion(t *testing.T) {
+	if err := Validate(models.TaskTypeLLM, []byte(`{"schema_version": 1, "prompt": "hi"}`)); err != nil {
+		t.Fatalf("Validate() error = %v for an explicit v1 config", err)
+	}
+	if err := Validate(models.TaskTypeLLM, []byte(`{"schema_version": 7, "prompt": "hi"}`)); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Fatalf("Validate() error = %v, want ErrUnsupportedVersion for a newer schema", err)
+	}
+
+	var invalid *ValidationError
+	err := Validate(models.TaskTypeLLM, []byte(`{"schema_version": "1", "prompt": "hi"}`))
+	if !errors.As(err, &invalid) || invalid.Violations[0].Pointer != "/schema_version" {
+		t.Fatalf("Validate() error = %v, want a violation at /schema_version", err)
+	}
+	if err := Validate("quantum", []byte(`{}`)); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("Validate() error = %v, want ErrUnknownType", err)
+	}
+	if got := Versions(models.TaskTypeDocker); !reflect.DeepEqual(got, []int{1}) {
+		t.Fatalf("Versions() = %v", got)
+	}
+}
+
+func TestViolationPointersAreEscaped(t *testing.T) {
+	err := Validate(models.TaskTypeDocker, []byte(`{"image_name": "alpine", "env": {"a/b~c": 1}}`))
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || invalid.Violations[0].Pointer != "/env/a~1b~0c" {
+		t.Fatalf("Validate() error = %v, want the pointer /env/a~1b~0c", err)
+	}
+}
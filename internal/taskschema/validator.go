@@ -0,0 +1,340 @@
+package taskschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// validator checks values against the subset of JSON Schema the embedded
+// schemas use: type, enum, const, properties, required,
+// additionalProperties, items, minItems, maxItems, minLength, maxLength,
+// pattern, minimum, maximum, oneOf, anyOf and $ref to this or another
+// embedded document
+type validator struct {
+	load       func(name string) (interface{}, error)
+	violations []Violation
+}
+
+var (
+	patternsMu sync.Mutex
+	patterns   = make(map[string]*regexp.Regexp)
+)
+
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+	if re, ok := patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	patterns[pattern] = re
+	return re, nil
+}
+
+// decode parses JSON keeping numbers exact
+func decode(data []byte) (interface{}, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// escapePointer escapes a property name for use in a JSON pointer
+func escapePointer(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+func (v *validator) fail(pointer, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{Pointer: pointer, Message: fmt.Sprintf(format, args...)})
+}
+
+// resolve follows a $ref of the form "[document]#/json/pointer", the
+// document named relative to doc
+func (v *validator) resolve(doc, ref string) (string, map[string]interface{}, error) {
+	name, fragment, _ := strings.Cut(ref, "#")
+	if name == "" {
+		name = doc
+	} else {
+		name = path.Join(path.Dir(doc), name)
+	}
+	root, err := v.load(name)
+	if err != nil {
+		return "", nil, err
+	}
+	node := root
+	for _, token := range strings.Split(strings.TrimPrefix(fragment, "/"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+		object, ok := node.(map[string]interface{})
+		if !ok {
+			return "", nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		if node, ok = object[token]; !ok {
+			return "", nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return "", nil, fmt.Errorf("$ref %q is not a schema", ref)
+	}
+	return name, schema, nil
+}
+
+func typeOf(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if _, ok := new(big.Int).SetString(value.String(), 10); ok {
+			return "integer"
+		}
+		if f, err := value.Float64(); err == nil && f == float64(int64(f)) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return "unknown"
+}
+
+func matchesType(value interface{}, want string) bool {
+	got := typeOf(value)
+	return got == want || (want == "number" && got == "integer")
+}
+
+func number(value interface{}) (float64, bool) {
+	n, ok := value.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+func stringList(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var list []string
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+func article(kind string) string {
+	if strings.ContainsAny(kind[:1], "aeiou") {
+		return "an " + kind
+	}
+	return "a " + kind
+}
+
+// validate checks value at pointer against schema, found in document doc
+func (v *validator) validate(doc string, schema map[string]interface{}, value interface{}, pointer string) {
+	if ref, ok := schema["$ref"].(string); ok {
+		refDoc, target, err := v.resolve(doc, ref)
+		if err != nil {
+			v.fail(pointer, "schema error: %v", err)
+			return
+		}
+		v.validate(refDoc, target, value, pointer)
+		return
+	}
+
+	if types := stringList(schema["type"]); len(types) > 0 {
+		matched := false
+		for _, t := range types {
+			if matchesType(value, t) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			kinds := make([]string, len(types))
+			for i, t := range types {
+				kinds[i] = article(t)
+			}
+			v.fail(pointer, "must be %s, got %s", strings.Join(kinds, " or "), typeOf(value))
+			return
+		}
+	}
+
+	if options, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, option := range options {
+			if reflect.DeepEqual(option, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			allowed := make([]string, len(options))
+			for i, option := range options {
+				encoded, _ := json.Marshal(option)
+				allowed[i] = string(encoded)
+			}
+			v.fail(pointer, "must be one of %s", strings.Join(allowed, ", "))
+		}
+	}
+	if want, ok := schema["const"]; ok && !reflect.DeepEqual(want, value) {
+		encoded, _ := json.Marshal(want)
+		v.fail(pointer, "must be %s", encoded)
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(doc, schema, value, pointer)
+	case []interface{}:
+		v.validateArray(doc, schema, value, pointer)
+	case string:
+		v.validateString(schema, value, pointer)
+	case json.Number:
+		v.validateNumber(schema, value, pointer)
+	}
+
+	if alternatives, ok := schema["oneOf"].([]interface{}); ok {
+		if matched := v.countMatches(doc, alternatives, value, pointer); matched != 1 {
+			v.fail(pointer, "must match exactly one of %d alternatives, matches %d%s", len(alternatives), matched, describe(schema))
+		}
+	}
+	if alternatives, ok := schema["anyOf"].([]interface{}); ok {
+		if matched := v.countMatches(doc, alternatives, value, pointer); matched == 0 {
+			v.fail(pointer, "must match at least one of %d alternatives%s", len(alternatives), describe(schema))
+		}
+	}
+}
+
+// describe appends a schema's description to a message about it
+func describe(schema map[string]interface{}) string {
+	if description, ok := schema["description"].(string); ok && description != "" {
+		return " (" + description + ")"
+	}
+	return ""
+}
+
+func (v *validator) countMatches(doc string, alternatives []interface{}, value interface{}, pointer string) int {
+	matched := 0
+	for _, alternative := range alternatives {
+		schema, ok := alternative.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		probe := &validator{load: v.load}
+		probe.validate(doc, schema, value, pointer)
+		if len(probe.violations) == 0 {
+			matched++
+		}
+	}
+	return matched
+}
+
+func (v *validator) validateObject(doc string, schema map[string]interface{}, value map[string]interface{}, pointer string) {
+	properties, _ := schema["properties"].(map[string]interface{})
+
+	for _, name := range stringList(schema["required"]) {
+		if _, ok := value[name]; !ok {
+			v.fail(pointer+"/"+escapePointer(name), "is required")
+		}
+	}
+
+	names := make([]string, 0, len(value))
+	for name := range value {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		child := pointer + "/" + escapePointer(name)
+		if property, ok := properties[name].(map[string]interface{}); ok {
+			v.validate(doc, property, value[name], child)
+			continue
+		}
+		switch additional := schema["additionalProperties"].(type) {
+		case bool:
+			if !additional {
+				v.fail(child, "is not allowed")
+			}
+		case map[string]interface{}:
+			v.validate(doc, additional, value[name], child)
+		}
+	}
+}
+
+func (v *validator) validateArray(doc string, schema map[string]interface{}, value []interface{}, pointer string) {
+	if min, ok := number(schema["minItems"]); ok && float64(len(value)) < min {
+		v.fail(pointer, "must have at least %v items", min)
+	}
+	if max, ok := number(schema["maxItems"]); ok && float64(len(value)) > max {
+		v.fail(pointer, "must have at most %v items", max)
+	}
+	if items, ok := schema["items"].(map[string]interface{}); ok {
+		for i, item := range value {
+			v.validate(doc, items, item, fmt.Sprintf("%s/%d", pointer, i))
+		}
+	}
+}
+
+func (v *validator) validateString(schema map[string]interface{}, value string, pointer string) {
+	length := float64(utf8.RuneCountInString(value))
+	if min, ok := number(schema["minLength"]); ok && length < min {
+		if min == 1 {
+			v.fail(pointer, "must not be empty")
+		} else {
+			v.fail(pointer, "must be at least %v characters", min)
+		}
+	}
+	if max, ok := number(schema["maxLength"]); ok && length > max {
+		v.fail(pointer, "must be at most %v characters", max)
+	}
+	if pattern, ok := schema["pattern"].(string); ok {
+		re, err := compilePattern(pattern)
+		if err != nil {
+			v.fail(pointer, "schema error: invalid pattern %q", pattern)
+		} else if !re.MatchString(value) {
+			v.fail(pointer, "must match %s%s", pattern, describe(schema))
+		}
+	}
+}
+
+func (v *validator) validateNumber(schema map[string]interface{}, value json.Number, pointer string) {
+	f, err := value.Float64()
+	if err != nil {
+		v.fail(pointer, "is not a valid number")
+		return
+	}
+	if min, ok := number(schema["minimum"]); ok && f < min {
+		v.fail(pointer, "must be at least %v", min)
+	}
+	if max, ok := number(schema["maximum"]); ok && f > max {
+		v.fail(pointer, "must be at most %v", max)
+	}
+}
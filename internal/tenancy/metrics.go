@@ -0,0 +1,83 @@
+package tenancy
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// Metrics counts the tasks of every instance of a process
+type Metrics struct {
+	mu    sync.Mutex
+	stats map[string]*models.InstanceStats
+}
+
+// NewMetrics returns metrics labelled with the names of instances
+func NewMetrics(instances []Instance) *Metrics {
+	m := &Metrics{stats: make(map[string]*models.InstanceStats)}
+	for _, instance := range instances {
+		m.stats[instance.Name] = &models.InstanceStats{
+			Instance: instance.Name,
+			GPUs:     append([]string(nil), instance.GPUs...),
+			CPUs:     append([]int(nil), instance.CPUs...),
+		}
+	}
+	return m
+}
+
+func (m *Metrics) count(instance string, counter func(*models.InstanceStats)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.stats[instance]
+	if !ok {
+		stats = &models.InstanceStats{Instance: instance}
+		m.stats[instance] = stats
+	}
+	counter(stats)
+}
+
+// Claimed counts a task instance claimed
+func (m *Metrics) Claimed(instance string) {
+	m.count(instance, func(s *models.InstanceStats) { s.Claimed++ })
+}
+
+// Declined counts a task instance declined
+func (m *Metrics) Declined(instance string) {
+	m.count(instance, func(s *models.InstanceStats) { s.Declined++ })
+}
+
+// Finished counts a task instance finished with status
+func (m *Metrics) Finished(instance string, status models.TaskStatus) {
+	m.count(instance, func(s *models.InstanceStats) {
+		if status == models.TaskStatusCompleted {
+			s.Completed++
+		} else {
+			s.Failed++
+		}
+	})
+}
+
+// Instance returns the counts of instance
+func (m *Metrics) Instance(instance string) *models.InstanceStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.stats[instance]
+	if !ok {
+		return &models.InstanceStats{Instance: instance}
+	}
+	snapshot := *stats
+	return &snapshot
+}
+
+// Snapshot returns the counts of every instance, ordered by name
+func (m *Metrics) Snapshot() []models.InstanceStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot := make([]models.InstanceStats, 0, len(m.stats))
+	for _, stats := range m.stats {
+		snapshot = append(snapshot, *stats)
+	}
+	sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].Instance < snapshot[j].Instance })
+	return snapshot
+}
@@ -0,0 +1,180 @@
+// Package tenancy runs several logical runners, instances, in one process.
+// Each instance registers with its own device ID and wallet, accepts the
+// tasks its filters allow and runs them on the slice of the host's GPUs and
+// cores pinned to it, while sharing the process's caches, downloads and
+// connections with the others.
+package tenancy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+// Instance is one logical runner
+type Instance struct {
+	// Name labels the instance's task history, metrics and logs
+	Name string `json:"name"`
+	// DeviceID defaults to one derived from the host's device ID and Name
+	DeviceID string `json:"device_id,omitempty"`
+	// Keystore is the path of the keystore holding the instance's wallet key;
+	// the runner's own keystore is used when empty
+	Keystore    string `json:"keystore,omitempty"`
+	WebhookPort int    `json:"webhook_port"`
+	// TaskTypes are the task types the instance accepts, all when empty
+	TaskTypes []models.TaskType `json:"task_types,omitempty"`
+	// GPUOnly accepts only tasks that need a GPU
+	GPUOnly bool `json:"gpu_only,omitempty"`
+	// GPUs are the indexes or UUIDs of the GPUs pinned to the instance. An
+	// instance without any runs no GPU tasks.
+	GPUs []string `json:"gpus,omitempty"`
+	// CPUs are the cores pinned to the instance, all when empty
+	CPUs []int `json:"cpus,omitempty"`
+}
+
+// Load reads the instances from the JSON array in the file at path
+func Load(path string) ([]Instance, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read instances: %w", err)
+	}
+	var instances []Instance
+	if err := json.Unmarshal(data, &instances); err != nil {
+		return nil, fmt.Errorf("failed to parse instances: %w", err)
+	}
+	if err := Validate(instances); err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+// Validate checks that instances can run side by side: each is named and
+// listens on its own port, and no two claim the same device ID, GPU or core
+func Validate(instances []Instance) error {
+	if len(instances) == 0 {
+		return fmt.Errorf("no instances configured")
+	}
+	names := make(map[string]bool)
+	ports := make(map[int]string)
+	deviceIDs := make(map[string]string)
+	gpus := make(map[string]string)
+	cpus := make(map[int]string)
+	for _, instance := range instances {
+		if instance.Name == "" {
+			return fmt.Errorf("instance has no name")
+		}
+		if names[instance.Name] {
+			return fmt.Errorf("instance %q is configured twice", instance.Name)
+		}
+		names[instance.Name] = true
+
+		if instance.WebhookPort <= 0 {
+			return fmt.Errorf("instance %q has no webhook port", instance.Name)
+		}
+		if other, ok := ports[instance.WebhookPort]; ok {
+			return fmt.Errorf("instances %q and %q share webhook port %d", other, instance.Name, instance.WebhookPort)
+		}
+		ports[instance.WebhookPort] = instance.Name
+
+		if instance.DeviceID != "" {
+			if other, ok := deviceIDs[instance.DeviceID]; ok {
+				return fmt.Errorf("instances %q and %q share a device ID", other, instance.Name)
+			}
+			deviceIDs[instance.DeviceID] = instance.Name
+		}
+		for _, taskType := range instance.TaskTypes {
+			if taskType == "" {
+				return fmt.Errorf("instance %q lists an empty task type", instance.Name)
+			}
+		}
+		if instance.GPUOnly && len(instance.GPUs) == 0 {
+			return fmt.Errorf("instance %q only accepts GPU tasks but has no GPUs", instance.Name)
+		}
+		for _, device := range instance.GPUs {
+			if other, ok := gpus[device]; ok {
+				return fmt.Errorf("instances %q and %q are both pinned to GPU %s", other, instance.Name, device)
+			}
+			gpus[device] = instance.Name
+		}
+		for _, core := range instance.CPUs {
+			if core < 0 {
+				return fmt.Errorf("instance %q lists invalid core %d", instance.Name, core)
+			}
+			if other, ok := cpus[core]; ok {
+				return fmt.Errorf("instances %q and %q are both pinned to core %d", other, instance.Name, core)
+			}
+			cpus[core] = instance.Name
+		}
+	}
+	return nil
+}
+
+// DeviceIDFor returns the device ID the instance registers with, derived
+// from the host's when the instance does not set one
+func (i *Instance) DeviceIDFor(hostDeviceID string) string {
+	if i.DeviceID != "" {
+		return i.DeviceID
+	}
+	sum := sha256.Sum256([]byte(hostDeviceID + "/" + i.Name))
+	return hex.EncodeToString(sum[:])
+}
+
+// CPUSet returns the instance's cores as a Docker --cpuset-cpus value, or ""
+// when it may use every core
+func (i *Instance) CPUSet() string {
+	cores := make([]string, len(i.CPUs))
+	for j, core := range i.CPUs {
+		cores[j] = strconv.Itoa(core)
+	}
+	return strings.Join(cores, ",")
+}
+
+// Accepts returns why the instance's filters keep it from running a task of
+// taskType, which needs a GPU when needsGPU, or nil when they let it
+func (i *Instance) Accepts(taskType models.TaskType, needsGPU bool) error {
+	if len(i.TaskTypes) > 0 {
+		accepted := false
+		for _, t := range i.TaskTypes {
+			if t == taskType {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return fmt.Errorf("instance %q does not accept %s tasks", i.Name, taskType)
+		}
+	}
+	if i.GPUOnly && !needsGPU {
+		return fmt.Errorf("instance %q only accepts GPU tasks", i.Name)
+	}
+	if needsGPU && len(i.GPUs) == 0 {
+		return fmt.Errorf("instance %q has no GPUs", i.Name)
+	}
+	return nil
+}
+
+// Profile returns the part of the host's hardware profile that is the
+// instance's, as it advertises it to the server: its own cores, and no GPU
+// when none is pinned to it
+func (i *Instance) Profile(host *hardware.Profile) *hardware.Profile {
+	if host == nil {
+		return nil
+	}
+	profile := *host
+	if len(i.CPUs) > 0 {
+		profile.CPUCores = len(i.CPUs)
+	}
+	if len(i.GPUs) == 0 {
+		profile.Accelerator = hardware.AcceleratorNone
+		profile.MetalSupported = false
+		profile.GPUCores = 0
+	}
+	return &profile
+}
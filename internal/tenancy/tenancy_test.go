@@ -0,0 +1,149 @@
+package tenancy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+	"github.com/theblitlabs/parity-runner/internal/hardware"
+)
+
+func TestValidateRejectsSharedResources(t *testing.T) {
+	gpuRunner := Instance{Name: "gpu", WebhookPort: 8081, GPUs: []string{"0"}, CPUs: []int{0, 1}}
+	for _, tt := range []struct {
+		name     string
+		other    Instance
+		contains string
+	}{
+		{"duplicate name", Instance{Name: "gpu", WebhookPort: 8082}, "configured twice"},
+		{"shared port", Instance{Name: "cpu", WebhookPort: 8081}, "share webhook port"},
+		{"no port", Instance{Name: "cpu"}, "no webhook port"},
+		{"shared GPU", Instance{Name: "cpu", WebhookPort: 8082, GPUs: []string{"0"}}, "GPU 0"},
+		{"shared core", Instance{Name: "cpu", WebhookPort: 8082, CPUs: []int{1, 2}}, "core 1"},
+		{"negative core", Instance{Name: "cpu", WebhookPort: 8082, CPUs: []int{-1}}, "invalid core"},
+		{"GPU only without GPUs", Instance{Name: "cpu", WebhookPort: 8082, GPUOnly: true}, "has no GPUs"},
+		{"unnamed", Instance{WebhookPort: 8082}, "no name"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate([]Instance{gpuRunner, tt.other})
+			if err == nil || !strings.Contains(err.Error(), tt.contains) {
+				t.Fatalf("Validate() error = %v, want it to mention %q", err, tt.contains)
+			}
+		})
+	}
+
+	if err := Validate(nil); err == nil {
+		t.Error("Validate() accepted no instances")
+	}
+	cpuRunner := Instance{Name: "cpu", WebhookPort: 8082, CPUs: []int{2, 3}}
+	if err := Validate([]Instance{gpuRunner, cpuRunner}); err != nil {
+		t.Errorf("Validate() error = %v for disjoint instances", err)
+	}
+}
+
+func TestLoadReadsInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "instances.json")
+	data := `[
+		{"name": "gpu", "webhook_port": 8081, "gpu_only": true, "gpus": ["0"]},
+		{"name": "cpu", "webhook_port": 8082, "task_types": ["docker"], "cpus": [4, 5]}
+	]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	instances, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances) != 2 || !instances[0].GPUOnly || instances[1].CPUSet() != "4,5" {
+		t.Fatalf("Load() = %+v", instances)
+	}
+
+	if err := os.WriteFile(path, []byte(`[{"name": "gpu"}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("Load() accepted an instance without a webhook port")
+	}
+}
+
+func TestDeviceIDFor(t *testing.T) {
+	a := Instance{Name: "a"}
+	b := Instance{Name: "b"}
+	if a.DeviceIDFor("host") == b.DeviceIDFor("host") {
+		t.Error("instances of one host derived the same device ID")
+	}
+	if a.DeviceIDFor("host") != a.DeviceIDFor("host") {
+		t.Error("derived device ID is not stable")
+	}
+	if a.DeviceIDFor("host") == a.DeviceIDFor("other") {
+		t.Error("instances of different hosts derived the same device ID")
+	}
+	explicit := Instance{Name: "c", DeviceID: "configured"}
+	if got := explicit.DeviceIDFor("host"); got != "configured" {
+		t.Errorf("DeviceIDFor() = %q, want the configured ID", got)
+	}
+}
+
+func TestAcceptsAppliesFilters(t *testing.T) {
+	gpuOnly := Instance{Name: "gpu", GPUOnly: true, GPUs: []string{"0"}}
+	cpuDocker := Instance{Name: "cpu", TaskTypes: []models.TaskType{models.TaskTypeDocker}}
+	for _, tt := range []struct {
+		instance Instance
+		taskType models.TaskType
+		needsGPU bool
+		accepted bool
+	}{
+		{gpuOnly, models.TaskTypeDocker, true, true},
+		{gpuOnly, models.TaskTypeDocker, false, false},
+		{cpuDocker, models.TaskTypeDocker, false, true},
+		{cpuDocker, models.TaskTypeDocker, true, false},
+		{cpuDocker, models.TaskTypeLLM, false, false},
+	} {
+		err := tt.instance.Accepts(tt.taskType, tt.needsGPU)
+		if (err == nil) != tt.accepted {
+			t.Errorf("%s.Accepts(%s, gpu=%v) error = %v, want accepted %v", tt.instance.Name, tt.taskType, tt.needsGPU, err, tt.accepted)
+		}
+	}
+}
+
+func TestProfileIsTheInstanceSlice(t *testing.T) {
+	host := &hardware.Profile{Accelerator: hardware.AcceleratorCUDA, CPUCores: 16, GPUCores: 80}
+	cpu := Instance{Name: "cpu", CPUs: []int{0, 1, 2, 3}}
+	profile := cpu.Profile(host)
+	if profile.CPUCores != 4 || profile.Accelerator != hardware.AcceleratorNone || profile.GPUCores != 0 {
+		t.Errorf("CPU instance profile = %+v, want 4 cores and no GPU", profile)
+	}
+	if host.CPUCores != 16 || host.Accelerator != hardware.AcceleratorCUDA {
+		t.Errorf("host profile changed to %+v", host)
+	}
+
+	gpu := Instance{Name: "gpu", GPUs: []string{"0"}}
+	profile = gpu.Profile(host)
+	if profile.CPUCores != 16 || profile.Accelerator != hardware.AcceleratorCUDA {
+		t.Errorf("GPU instance profile = %+v, want the host's cores and GPU", profile)
+	}
+}
+
+func TestMetricsCountPerInstance(t *testing.T) {
+	m := NewMetrics([]Instance{{Name: "gpu", GPUs: []string{"0"}}, {Name: "cpu", CPUs: []int{0, 1}}})
+	m.Claimed("gpu")
+	m.Finished("gpu", models.TaskStatusCompleted)
+	m.Declined("cpu")
+	m.Claimed("cpu")
+	m.Finished("cpu", models.TaskStatusFailed)
+
+	gpu := m.Instance("gpu")
+	if gpu.Claimed != 1 || gpu.Completed != 1 || gpu.Failed != 0 || gpu.Declined != 0 {
+		t.Errorf("gpu stats = %+v", gpu)
+	}
+	cpu := m.Instance("cpu")
+	if cpu.Claimed != 1 || cpu.Failed != 1 || cpu.Declined != 1 || len(cpu.CPUs) != 2 {
+		t.Errorf("cpu stats = %+v", cpu)
+	}
+	snapshot := m.Snapshot()
+	if len(snapshot) != 2 || snapshot[0].Instance != "cpu" || snapshot[1].Instance != "gpu" {
+		t.Errorf("Snapshot() = %+v, want both instances by name", snapshot)
+	}
+}
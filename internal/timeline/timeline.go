@@ -0,0 +1,357 @@
+// Package timeline records where a task's time goes on the runner: the
+// phases it passes through from its claim to its report, the bytes each
+// moves and the windows in which it was paused. The recorder of a running
+// task travels in its context, so that executors can mark the phases only
+// they see, such as pulling an image.
+package timeline
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/theblitlabs/parity-runner/internal/clock"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+// maxKept bounds the timelines of tasks returned to the queue kept for
+// when the runner claims them again
+const maxKept = 256
+
+// Marks are taken on a stopwatch started with the recorder, so that
+// durations hold across steps of the wall clock; a mark's wall time is the
+// recorder's start plus the mark. An end is only set once ended is.
+
+type pause struct {
+	reason     string
+	start, end time.Duration
+	ended      bool
+}
+
+type phase struct {
+	name       models.TaskPhase
+	start, end time.Duration
+	ended      bool
+	bytes      int64
+	pauses     []pause
+	// pausedBy is the reason of the pause open in the phase, if any
+	pausedBy string
+}
+
+type attempt struct {
+	start, end time.Duration
+	ended      bool
+	outcome    string
+	phases     []*phase
+}
+
+// Recorder records the timeline of a task. A nil Recorder records nothing.
+type Recorder struct {
+	watch  clock.Stopwatch
+	taskID string
+
+	mu        sync.Mutex
+	queueWait time.Duration
+	attempts  []*attempt
+}
+
+func (r *Recorder) now() time.Duration {
+	return r.watch.Elapsed()
+}
+
+// current returns the phase the running attempt is in, or nil once it
+// finished
+func (r *Recorder) current() *phase {
+	if len(r.attempts) == 0 {
+		return nil
+	}
+	a := r.attempts[len(r.attempts)-1]
+	if a.ended {
+		return nil
+	}
+	return a.phases[len(a.phases)-1]
+}
+
+// start begins an attempt in the claimed phase
+func (r *Recorder) start() {
+	now := r.now()
+	r.attempts = append(r.attempts, &attempt{
+		start:  now,
+		phases: []*phase{{name: models.PhaseClaimed, start: now}},
+	})
+}
+
+// Enter moves the running attempt to phase p. A pause open across the
+// transition carries on in p. Entering the phase the attempt is in does
+// nothing.
+func (r *Recorder) Enter(p models.TaskPhase) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur := r.current()
+	if cur == nil || cur.name == p {
+		return
+	}
+	now := r.now()
+	pausedBy := cur.close(now)
+	next := &phase{name: p, start: now}
+	if pausedBy != "" {
+		next.pausedBy = pausedBy
+		next.pauses = []pause{{reason: pausedBy, start: now}}
+	}
+	a := r.attempts[len(r.attempts)-1]
+	a.phases = append(a.phases, next)
+}
+
+// close ends the phase at now, returning the reason of the pause it ended
+func (p *phase) close(now time.Duration) string {
+	p.end, p.ended = now, true
+	pausedBy := p.pausedBy
+	if pausedBy != "" {
+		p.pauses[len(p.pauses)-1].end = now
+		p.pauses[len(p.pauses)-1].ended = true
+		p.pausedBy = ""
+	}
+	return pausedBy
+}
+
+// AddBytes counts n bytes moved in the current phase
+func (r *Recorder) AddBytes(n int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cur := r.current(); cur != nil {
+		cur.bytes += n
+	}
+}
+
+// Pause opens a window in which the task makes no progress for reason,
+// until Resume
+func (r *Recorder) Pause(reason string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur := r.current()
+	if cur == nil || cur.pausedBy != "" {
+		return
+	}
+	cur.pausedBy = reason
+	cur.pauses = append(cur.pauses, pause{reason: reason, start: r.now()})
+}
+
+// Resume closes the window Pause opened
+func (r *Recorder) Resume() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur := r.current()
+	if cur == nil || cur.pausedBy == "" {
+		return
+	}
+	cur.pauses[len(cur.pauses)-1].end = r.now()
+	cur.pauses[len(cur.pauses)-1].ended = true
+	cur.pausedBy = ""
+}
+
+// AddPause records a window of d ending now in which the task made no
+// progress for reason, as one only noticed once over. The window is cut
+// to the current phase.
+func (r *Recorder) AddPause(reason string, d time.Duration) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur := r.current()
+	if cur == nil || cur.pausedBy != "" {
+		return
+	}
+	now := r.now()
+	start := max(now-d, cur.start)
+	cur.pauses = append(cur.pauses, pause{reason: reason, start: start, end: now, ended: true})
+}
+
+// finish ends the running attempt with outcome, entering the reported
+// phase first for a reported attempt. It reports whether an attempt was
+// running.
+func (r *Recorder) finish(outcome string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur := r.current()
+	if cur == nil {
+		return false
+	}
+	now := r.now()
+	a := r.attempts[len(r.attempts)-1]
+	if outcome == models.AttemptReported && cur.name != models.PhaseReported {
+		cur.close(now)
+		cur = &phase{name: models.PhaseReported, start: now}
+		a.phases = append(a.phases, cur)
+	}
+	cur.close(now)
+	a.end, a.ended = now, true
+	a.outcome = outcome
+	return true
+}
+
+// Snapshot returns the timeline so far. The phase running, and a pause
+// open in it, last until now.
+func (r *Recorder) Snapshot() *models.TaskTimeline {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	wall := r.watch.StartedAt()
+	tl := &models.TaskTimeline{
+		Version:     models.TimelineVersion,
+		TaskID:      r.taskID,
+		QueueWaitMs: r.queueWait.Milliseconds(),
+		Attempts:    make([]models.TimelineAttempt, 0, len(r.attempts)),
+	}
+	for i, a := range r.attempts {
+		end := a.end
+		if !a.ended {
+			end = now
+		}
+		out := models.TimelineAttempt{
+			Attempt:    i + 1,
+			StartedAt:  wall.Add(a.start),
+			DurationMs: (end - a.start).Milliseconds(),
+			Outcome:    a.outcome,
+			Phases:     make([]models.TimelinePhase, 0, len(a.phases)),
+		}
+		for _, p := range a.phases {
+			out.Phases = append(out.Phases, p.snapshot(wall, now))
+		}
+		tl.Attempts = append(tl.Attempts, out)
+	}
+	return tl
+}
+
+func (p *phase) snapshot(wall time.Time, now time.Duration) models.TimelinePhase {
+	end := p.end
+	if !p.ended {
+		end = now
+	}
+	out := models.TimelinePhase{
+		Phase:      p.name,
+		StartedAt:  wall.Add(p.start),
+		DurationMs: (end - p.start).Milliseconds(),
+		Bytes:      p.bytes,
+		Open:       !p.ended,
+	}
+	for _, w := range p.pauses {
+		wEnd := w.end
+		if !w.ended {
+			wEnd = now
+		}
+		d := (wEnd - w.start).Milliseconds()
+		out.PausedMs += d
+		out.Pauses = append(out.Pauses, models.TimelinePause{Reason: w.reason, StartedAt: wall.Add(w.start), DurationMs: d})
+	}
+	return out
+}
+
+type contextKey struct{}
+
+// With returns ctx carrying r
+func With(ctx context.Context, r *Recorder) context.Context {
+	return context.WithValue(ctx, contextKey{}, r)
+}
+
+// From returns the recorder ctx carries, or nil
+func From(ctx context.Context) *Recorder {
+	r, _ := ctx.Value(contextKey{}).(*Recorder)
+	return r
+}
+
+// Tracker keeps the timelines of the tasks the runner runs, and of those it
+// returned to the queue so that a later claim is recorded as another attempt
+type Tracker struct {
+	mu    sync.Mutex
+	clock clock.Clock
+	tasks map[string]*Recorder
+	// kept lists the tasks returned to the queue, oldest first
+	kept []string
+}
+
+func NewTracker(clk clock.Clock) *Tracker {
+	return &Tracker{clock: clk, tasks: make(map[string]*Recorder)}
+}
+
+// SetClock replaces the clock timelines started from now on use
+func (t *Tracker) SetClock(c clock.Clock) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clock = c
+}
+
+// Start begins an attempt of task in the claimed phase, after those of
+// earlier claims the runner returned to the queue
+func (t *Tracker) Start(task *models.Task) *Recorder {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := task.ID.String()
+	r, ok := t.tasks[id]
+	if !ok {
+		r = &Recorder{watch: clock.Start(t.clock), taskID: id}
+		if !task.CreatedAt.IsZero() {
+			r.queueWait = max(0, clock.Since(t.clock, task.CreatedAt))
+		}
+		t.tasks[id] = r
+	}
+	t.unkeep(id)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.start()
+	return r
+}
+
+// Get returns the recorder of the task with taskID, or nil
+func (t *Tracker) Get(taskID string) *Recorder {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tasks[taskID]
+}
+
+// Finish ends the running attempt of the task with taskID with outcome and
+// returns its timeline, or nil when no attempt of it is running. Timelines
+// of tasks returned to the queue are kept for their next claim; others are
+// forgotten.
+func (t *Tracker) Finish(taskID, outcome string) *models.TaskTimeline {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r, ok := t.tasks[taskID]
+	if !ok || !r.finish(outcome) {
+		return nil
+	}
+	if outcome != models.AttemptRequeued {
+		delete(t.tasks, taskID)
+		return r.Snapshot()
+	}
+	t.kept = append(t.kept, taskID)
+	if len(t.kept) > maxKept {
+		delete(t.tasks, t.kept[0])
+		t.kept = t.kept[1:]
+	}
+	return r.Snapshot()
+}
+
+func (t *Tracker) unkeep(taskID string) {
+	for i, id := range t.kept {
+		if id == taskID {
+			t.kept = append(t.kept[:i], t.kept[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package timeline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/theblitlabs/parity-runner/internal/clock/clocktest"
+	"github.com/theblitlabs/parity-runner/internal/core/models"
+)
+
+func TestPausesNestInPhases(t *testing.T) {
+	start := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	clk := clocktest.NewFake(start)
+	tracker := NewTracker(clk)
+	task := &models.Task{ID: uuid.New()}
+	r := tracker.Start(task)
+
+	r.Enter(models.PhaseExecuting)
+	clk.Advance(2 * time.Second)
+	r.Pause("power")
+	clk.Advance(3 * time.Second)
+	// A pause open across a transition is split between the phases
+	r.Enter(models.PhaseUploading)
+	clk.Advance(time.Second)
+	r.Resume()
+	clk.Advance(time.Second)
+	r.AddPause("docker_daemon", time.Hour)
+	r.Enter(models.PhaseUploading)
+
+	snap := r.Snapshot()
+	phases := snap.Attempts[0].Phases
+	if len(phases) != 3 || snap.Attempts[0].Outcome != "" {
+		t.Fatalf("attempt = %+v, want three phases and no outcome", snap.Attempts[0])
+	}
+	executing, uploading := phases[1], phases[2]
+	if executing.DurationMs != 5000 || executing.PausedMs != 3000 || executing.Open {
+		t.Errorf("executing = %+v", executing)
+	}
+	if uploading.DurationMs != 2000 || uploading.PausedMs != 3000 || !uploading.Open || len(uploading.Pauses) != 2 {
+		t.Fatalf("uploading = %+v", uploading)
+	}
+	if p := uploading.Pauses[0]; p.Reason != "power" || p.DurationMs != 1000 || !p.StartedAt.Equal(start.Add(5*time.Second)) {
+		t.Errorf("split pause = %+v", p)
+	}
+	// A pause noticed once over is cut to the phase it ended in
+	if p := uploading.Pauses[1]; p.Reason != "docker_daemon" || p.DurationMs != 2000 {
+		t.Errorf("added pause = %+v", p)
+	}
+
+	tl := tracker.Finish(task.ID.String(), models.AttemptReported)
+	last := tl.Attempts[0].Phases[len(tl.Attempts[0].Phases)-1]
+	if last.Phase != models.PhaseReported || last.DurationMs != 0 || tl.Attempts[0].Outcome != models.AttemptReported {
+		t.Errorf("finished attempt = %+v", tl.Attempts[0])
+	}
+	if tracker.Finish(task.ID.String(), models.AttemptAbandoned) != nil || tracker.Get(task.ID.String()) != nil {
+		t.Error("reported task finished twice or kept")
+	}
+}
+
+func TestTrackerKeepsRequeuedTasks(t *testing.T) {
+	start := time.Date(2026, 10, 16, 8, 0, 0, 0, time.UTC)
+	clk := clocktest.NewFake(start)
+	tracker := NewTracker(clk)
+	task := &models.Task{ID: uuid.New(), CreatedAt: start.Add(-time.Minute)}
+
+	tracker.Start(task)
+	clk.Advance(time.Second)
+	tracker.Finish(task.ID.String(), models.AttemptRequeued)
+	clk.Advance(time.Second)
+	r := tracker.Start(task)
+	clk.Advance(time.Second)
+
+	tl := r.Snapshot()
+	if tl.QueueWaitMs != 60000 || len(tl.Attempts) != 2 || tl.Attempts[1].Attempt != 2 {
+		t.Fatalf("timeline = %+v, want both attempts after a minute queued", tl)
+	}
+	if a := tl.Attempts[1]; !a.StartedAt.Equal(start.Add(2*time.Second)) || a.DurationMs != 1000 || !a.Phases[0].Open {
+		t.Errorf("running attempt = %+v", a)
+	}
+
+	// Only so many requeued tasks are kept
+	for i := range maxKept {
+		other := &models.Task{ID: uuid.New()}
+		tracker.Start(other)
+		tracker.Finish(other.ID.String(), models.AttemptRequeued)
+		if i == 0 {
+			tracker.Finish(task.ID.String(), models.AttemptRequeued)
+		}
+	}
+	if tracker.Get(task.ID.String()) == nil {
+		t.Fatal("requeued task forgotten before the cap")
+	}
+	other := &models.Task{ID: uuid.New()}
+	tracker.Start(other)
+	tracker.Finish(other.ID.String(), models.AttemptRequeued)
+	if tracker.Get(task.ID.String()) != nil {
+		t.Error("oldest requeued task kept past the cap")
+	}
+}
+
+func TestDurationsSurviveWallClockSteps(t *testing.T) {
+	clk := clocktest.NewFake(time.Date(2026, 10, 25, 2, 30, 0, 0, time.UTC))
+	r := NewTracker(clk).Start(&models.Task{ID: uuid.New()})
+	clk.Advance(time.Second)
+	clk.Step(-time.Hour)
+	r.Enter(models.PhaseExecuting)
+	clk.Advance(time.Second)
+
+	phases := r.Snapshot().Attempts[0].Phases
+	if phases[0].DurationMs != 1000 || phases[1].DurationMs != 1000 {
+		t.Errorf("phases = %+v, want a second each", phases)
+	}
+}
+
+func TestNilRecorder(t *testing.T) {
+	r := From(context.Background())
+	r.Enter(models.PhaseExecuting)
+	r.AddBytes(1)
+	r.Pause("power")
+	r.Resume()
+	r.AddPause("power", time.Second)
+	if r.Snapshot() != nil {
+		t.Error("nil recorder took a snapshot")
+	}
+	ctx := With(context.Background(), NewTracker(clocktest.NewFake(time.Now())).Start(&models.Task{ID: uuid.New()}))
+	if From(ctx) == nil {
+		t.Error("recorder lost from its context")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"context"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
 	"regexp"
@@ -191,7 +192,7 @@ func (t *TunnelClient) installBoreCargo() error {
 func (t *TunnelClient) startBoreTunnel() (string, error) {
 	log := gologger.WithComponent("tunnel")
 
-	serverURL := t.config.ServerURL
+	serverURL := strings.Trim(t.config.ServerURL, "[]")
 	if serverURL == "" {
 		serverURL = "bore.pub"
 	}
@@ -257,10 +258,10 @@ func (t *TunnelClient) startBoreTunnel() (string, error) {
 					// Handle different match formats
 					if i == 3 || i == 4 { // remote_port patterns
 						if len(matches) >= 2 {
-							url = fmt.Sprintf("http://%s:%s", serverURL, matches[1])
+							url = "http://" + net.JoinHostPort(serverURL, matches[1])
 						}
 					} else if len(matches) >= 3 { // host:port patterns
-						url = fmt.Sprintf("http://%s:%s", matches[1], matches[2])
+						url = "http://" + net.JoinHostPort(matches[1], matches[2])
 					}
 
 					if url != "" {
@@ -284,7 +285,7 @@ func (t *TunnelClient) startBoreTunnel() (string, error) {
 				// More specific regex to extract port from remote_port=XXXX
 				remotePortRegex := regexp.MustCompile(`remote_port.*?=.*?(\d{4,5})`)
 				if matches := remotePortRegex.FindStringSubmatch(line); len(matches) > 1 {
-					url := fmt.Sprintf("http://%s:%s", serverURL, matches[1])
+					url := "http://" + net.JoinHostPort(serverURL, matches[1])
 					log.Info().
 						Str("detected_url", url).
 						Str("from_line", line).
@@ -301,7 +302,7 @@ func (t *TunnelClient) startBoreTunnel() (string, error) {
 				if !strings.Contains(strings.ToLower(line), "remote_port") {
 					portRegex := regexp.MustCompile(`(\d{4,5})`)
 					if matches := portRegex.FindStringSubmatch(line); len(matches) > 1 {
-						url := fmt.Sprintf("http://%s:%s", serverURL, matches[1])
+						url := "http://" + net.JoinHostPort(serverURL, matches[1])
 						log.Info().
 							Str("detected_url", url).
 							Str("from_line", line).
@@ -0,0 +1,167 @@
+// Package unixfs encodes and decodes the blocks IPFS stores files as: their
+// CIDs, the dag-pb nodes holding UnixFS file metadata, and the layout the
+// IPFS add endpoint chunks files into
+package unixfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrMismatch is returned when content does not hash to the CID it was
+// stored or served under
+var ErrMismatch = errors.New("content does not match its CID")
+
+// Codecs of the blocks files are stored as
+const (
+	CodecRaw   = 0x55
+	CodecDagPB = 0x70
+)
+
+const (
+	sha256Code = 0x12
+	cidV1      = 1
+)
+
+var base32Lower = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// CID is a content identifier in its binary form: a bare SHA-256 multihash
+// for CIDv0, and the version, codec and multihash for CIDv1
+type CID string
+
+// Sum returns the CIDv1 of block stored with codec
+func Sum(codec uint64, block []byte) CID {
+	digest := sha256.Sum256(block)
+	cid := binary.AppendUvarint(nil, cidV1)
+	cid = binary.AppendUvarint(cid, codec)
+	cid = binary.AppendUvarint(cid, sha256Code)
+	cid = binary.AppendUvarint(cid, sha256.Size)
+	return CID(append(cid, digest[:]...))
+}
+
+// Parse decodes a CIDv0 in base58 or a CIDv1 in base32, the encodings
+// gateways and the IPFS add endpoint use
+func Parse(s string) (CID, error) {
+	var raw []byte
+	var err error
+	switch {
+	case len(s) == 46 && strings.HasPrefix(s, "Qm"):
+		raw, err = base58Decode(s)
+	case strings.HasPrefix(s, "b"):
+		raw, err = base32Lower.DecodeString(strings.ToLower(s[1:]))
+	default:
+		return "", fmt.Errorf("CID %q is neither a base58 CIDv0 nor a base32 CIDv1", s)
+	}
+	if err != nil {
+		return "", fmt.Errorf("invalid CID %q: %w", s, err)
+	}
+	cid, n, err := Cast(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid CID %q: %w", s, err)
+	}
+	if n != len(raw) {
+		return "", fmt.Errorf("invalid CID %q: trailing bytes", s)
+	}
+	return cid, nil
+}
+
+// Cast reads the binary CID at the start of b, returning it and how many
+// bytes of b it took
+func Cast(b []byte) (CID, int, error) {
+	if len(b) >= 2 && b[0] == sha256Code && b[1] == sha256.Size {
+		if len(b) < 2+sha256.Size {
+			return "", 0, errors.New("truncated CIDv0")
+		}
+		return CID(b[:2+sha256.Size]), 2 + sha256.Size, nil
+	}
+	n := 0
+	next := func() (uint64, error) {
+		v, read := binary.Uvarint(b[n:])
+		if read <= 0 {
+			return 0, errors.New("truncated CID")
+		}
+		n += read
+		return v, nil
+	}
+	version, err := next()
+	if err != nil {
+		return "", 0, err
+	}
+	if version != cidV1 {
+		return "", 0, fmt.Errorf("unsupported CID version %d", version)
+	}
+	for i := 0; i < 2; i++ { // the codec and the hash function
+		if _, err := next(); err != nil {
+			return "", 0, err
+		}
+	}
+	length, err := next()
+	if err != nil {
+		return "", 0, err
+	}
+	if uint64(len(b)-n) < length {
+		return "", 0, errors.New("truncated CID digest")
+	}
+	n += int(length)
+	return CID(b[:n]), n, nil
+}
+
+// v1 returns c's codec and multihash, reading a CIDv0 as the dag-pb CIDv1
+// with the same multihash
+func (c CID) v1() (codec uint64, multihash []byte) {
+	b := []byte(c)
+	if len(b) == 2+sha256.Size && b[0] == sha256Code {
+		return CodecDagPB, b
+	}
+	_, n := binary.Uvarint(b)
+	codec, m := binary.Uvarint(b[n:])
+	return codec, b[n+m:]
+}
+
+// Codec returns the codec c's block is stored with
+func (c CID) Codec() uint64 {
+	codec, _ := c.v1()
+	return codec
+}
+
+// Equal reports whether c and o name the same block, taking a CIDv0 to
+// name what the dag-pb CIDv1 of its multihash does
+func (c CID) Equal(o CID) bool {
+	codec, multihash := c.v1()
+	otherCodec, otherMultihash := o.v1()
+	return codec == otherCodec && bytes.Equal(multihash, otherMultihash)
+}
+
+// Verify checks that block hashes to c
+func (c CID) Verify(block []byte) error {
+	_, multihash := c.v1()
+	code, n := binary.Uvarint(multihash)
+	if n <= 0 || code != sha256Code {
+		return fmt.Errorf("CID %s is not a SHA-256 hash", c)
+	}
+	length, m := binary.Uvarint(multihash[n:])
+	if m <= 0 || length != sha256.Size {
+		return fmt.Errorf("CID %s has a digest of %d bytes", c, length)
+	}
+	digest := sha256.Sum256(block)
+	if !bytes.Equal(digest[:], multihash[n+m:]) {
+		return fmt.Errorf("%w: block of %d bytes is not %s", ErrMismatch, len(block), c)
+	}
+	return nil
+}
+
+func (c CID) String() string {
+	if c.isV0() {
+		return base58Encode([]byte(c))
+	}
+	return "b" + base32Lower.EncodeToString([]byte(c))
+}
+
+func (c CID) isV0() bool {
+	return len(c) == 2+sha256.Size && c[0] == sha256Code
+}
@@ -0,0 +1,90 @@
+package unixfs
+
+import (
+	"fmt"
+	"io"
+)
+
+// The layout `ipfs add --cid-version=1` stores files in: 256 KiB chunks as
+// raw blocks under a balanced tree of dag-pb nodes with at most 174 links
+// each
+const (
+	ChunkSize = 256 << 10
+	MaxLinks  = 174
+)
+
+// part is a block of a file's tree as its parent links to it, with the
+// bytes of the file below it
+type part struct {
+	link Link
+	size uint64
+}
+
+// Import splits r into the blocks `ipfs add --cid-version=1` stores it as,
+// passing each with its CID to emit when set, leaves first, and returns the
+// file's CID
+func Import(r io.Reader, emit func(CID, []byte) error) (CID, error) {
+	if emit == nil {
+		emit = func(CID, []byte) error { return nil }
+	}
+	var leaves []part
+	chunk := make([]byte, ChunkSize)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 || len(leaves) == 0 && err == io.EOF {
+			cid := Sum(CodecRaw, chunk[:n])
+			if err := emit(cid, chunk[:n]); err != nil {
+				return "", err
+			}
+			leaves = append(leaves, part{link: Link{CID: cid, Tsize: uint64(n)}, size: uint64(n)})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read content: %w", err)
+		}
+	}
+
+	// A file of one chunk is that chunk's raw block
+	level := leaves
+	for len(level) > 1 {
+		var parents []part
+		for start := 0; start < len(level); start += MaxLinks {
+			parent, block := fileNode(level[start:min(start+MaxLinks, len(level))])
+			if err := emit(parent.link.CID, block); err != nil {
+				return "", err
+			}
+			parents = append(parents, parent)
+		}
+		level = parents
+	}
+	return level[0].link.CID, nil
+}
+
+// FileCID returns the CID Import gives r's content, by which content added
+// to IPFS or fetched from it is verified
+func FileCID(r io.Reader) (string, error) {
+	cid, err := Import(r, nil)
+	if err != nil {
+		return "", err
+	}
+	return cid.String(), nil
+}
+
+// fileNode returns the dag-pb node linking children as the parts of a file
+func fileNode(children []part) (part, []byte) {
+	data := &FileData{Type: TypeFile}
+	node := &Node{}
+	var tsize uint64
+	for _, child := range children {
+		data.FileSize += child.size
+		data.BlockSizes = append(data.BlockSizes, child.size)
+		node.Links = append(node.Links, child.link)
+		tsize += child.link.Tsize
+	}
+	node.Data = data.Encode()
+	block := node.Encode()
+	cid := Sum(CodecDagPB, block)
+	return part{link: Link{CID: cid, Tsize: tsize + uint64(len(block))}, size: data.FileSize}, block
+}
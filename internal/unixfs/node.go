@@ -0,0 +1,198 @@
+package unixfs
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// UnixFS data types of the nodes files are stored as
+const (
+	TypeRaw  = 0
+	TypeFile = 2
+)
+
+// Link is a dag-pb node's link to another block. Tsize is the size of the
+// block and everything below it.
+type Link struct {
+	CID   CID
+	Name  string
+	Tsize uint64
+}
+
+// Node is a dag-pb block
+type Node struct {
+	Links []Link
+	Data  []byte
+}
+
+// FileData is the UnixFS metadata in the data of a node storing a file or
+// part of one: the bytes it holds itself, and the size of the file and of
+// each part its links hold
+type FileData struct {
+	Type       uint64
+	Data       []byte
+	FileSize   uint64
+	BlockSizes []uint64
+}
+
+// Encode returns n as dag-pb writes it: links first, then data
+func (n *Node) Encode() []byte {
+	var b []byte
+	for _, link := range n.Links {
+		var l []byte
+		l = appendBytesField(l, 1, []byte(link.CID))
+		l = appendBytesField(l, 2, []byte(link.Name))
+		l = appendVarintField(l, 3, link.Tsize)
+		b = appendBytesField(b, 2, l)
+	}
+	if n.Data != nil {
+		b = appendBytesField(b, 1, n.Data)
+	}
+	return b
+}
+
+// DecodeNode decodes a dag-pb block
+func DecodeNode(block []byte) (*Node, error) {
+	n := &Node{}
+	err := eachField(block, func(field int, value uint64, bytes []byte) error {
+		switch field {
+		case 1:
+			n.Data = bytes
+		case 2:
+			link, err := decodeLink(bytes)
+			if err != nil {
+				return err
+			}
+			n.Links = append(n.Links, link)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid dag-pb node: %w", err)
+	}
+	return n, nil
+}
+
+func decodeLink(b []byte) (Link, error) {
+	var link Link
+	err := eachField(b, func(field int, value uint64, bytes []byte) error {
+		switch field {
+		case 1:
+			cid, n, err := Cast(bytes)
+			if err != nil {
+				return err
+			}
+			if n != len(bytes) {
+				return errors.New("link hash has trailing bytes")
+			}
+			link.CID = cid
+		case 2:
+			link.Name = string(bytes)
+		case 3:
+			link.Tsize = value
+		}
+		return nil
+	})
+	if err == nil && link.CID == "" {
+		err = errors.New("link has no hash")
+	}
+	return link, err
+}
+
+// Encode returns d as UnixFS writes it
+func (d *FileData) Encode() []byte {
+	var b []byte
+	b = appendVarintField(b, 1, d.Type)
+	if d.Data != nil {
+		b = appendBytesField(b, 2, d.Data)
+	}
+	b = appendVarintField(b, 3, d.FileSize)
+	for _, size := range d.BlockSizes {
+		b = appendVarintField(b, 4, size)
+	}
+	return b
+}
+
+// DecodeFileData decodes the UnixFS metadata of a node
+func DecodeFileData(b []byte) (*FileData, error) {
+	d := &FileData{}
+	err := eachField(b, func(field int, value uint64, bytes []byte) error {
+		switch field {
+		case 1:
+			d.Type = value
+		case 2:
+			d.Data = bytes
+		case 3:
+			d.FileSize = value
+		case 4:
+			if bytes == nil {
+				d.BlockSizes = append(d.BlockSizes, value)
+				return nil
+			}
+			// Packed, as some encoders write repeated numbers
+			for len(bytes) > 0 {
+				size, n := binary.Uvarint(bytes)
+				if n <= 0 {
+					return errors.New("truncated block sizes")
+				}
+				d.BlockSizes = append(d.BlockSizes, size)
+				bytes = bytes[n:]
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid UnixFS data: %w", err)
+	}
+	return d, nil
+}
+
+// eachField calls fn with each protobuf field of b: its number and its
+// value, as a number for varints and as bytes for length-delimited fields
+func eachField(b []byte, fn func(field int, value uint64, bytes []byte) error) error {
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errors.New("truncated field tag")
+		}
+		b = b[n:]
+		field := int(tag >> 3)
+		var value uint64
+		var bytes []byte
+		switch tag & 7 {
+		case 0:
+			if value, n = binary.Uvarint(b); n <= 0 {
+				return errors.New("truncated varint")
+			}
+			b = b[n:]
+		case 2:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < length {
+				return errors.New("truncated field")
+			}
+			bytes = b[n : n+int(length) : n+int(length)]
+			if bytes == nil {
+				bytes = []byte{}
+			}
+			b = b[n+int(length):]
+		default:
+			return fmt.Errorf("unsupported wire type %d", tag&7)
+		}
+		if err := fn(field, value, bytes); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendVarintField(b []byte, field int, value uint64) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3)
+	return binary.AppendUvarint(b, value)
+}
+
+func appendBytesField(b []byte, field int, value []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(field)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
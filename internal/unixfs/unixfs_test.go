@@ -0,0 +1,79 @@
+package unixfs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFileCIDOfSingleBlock(t *testing.T) {
+	for content, want := range map[string]string{
+		"":              "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku",
+		"hello world\n": "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4",
+	} {
+		got, err := FileCID(strings.NewReader(content))
+		if err != nil || got != want {
+			t.Errorf("FileCID(%q) = %s, %v, want %s", content, got, err, want)
+		}
+	}
+}
+
+func TestImportedTreeDecodes(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), ChunkSize/16*3+100)
+	blocks := make(map[CID][]byte)
+	root, err := Import(bytes.NewReader(content), func(cid CID, block []byte) error {
+		blocks[cid] = bytes.Clone(block)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The root of a chunked file is a dag-pb node
+	if s := root.String(); !strings.HasPrefix(s, "bafybei") {
+		t.Fatalf("Import() = %s, want a dag-pb CIDv1", s)
+	}
+	if parsed, err := Parse(root.String()); err != nil || parsed != root {
+		t.Fatalf("Parse(%s) = %x, %v, want the CID back", root, parsed, err)
+	}
+
+	node, err := DecodeNode(blocks[root])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := DecodeFileData(node.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.FileSize != uint64(len(content)) || len(node.Links) != 4 || len(data.BlockSizes) != 4 {
+		t.Fatalf("root holds %d bytes in %d links, want the file in 4 chunks", data.FileSize, len(node.Links))
+	}
+	var joined []byte
+	for _, link := range node.Links {
+		if err := link.CID.Verify(blocks[link.CID]); err != nil || link.CID.Codec() != CodecRaw {
+			t.Fatalf("leaf %s: %v", link.CID, err)
+		}
+		joined = append(joined, blocks[link.CID]...)
+	}
+	if !bytes.Equal(joined, content) {
+		t.Fatal("the leaves do not join into the content")
+	}
+
+	content[len(content)-1] ^= 1
+	if changed, _ := Import(bytes.NewReader(content), nil); changed == root {
+		t.Fatal("Import() ignored a change in the last chunk")
+	}
+}
+
+func TestParseCIDv0(t *testing.T) {
+	const v0 = "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+	cid, err := Parse(v0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cid.String() != v0 || cid.Codec() != CodecDagPB {
+		t.Fatalf("Parse(%s) = %s with codec %x, want the dag-pb CIDv0 back", v0, cid, cid.Codec())
+	}
+	if err := cid.Verify([]byte("not the block")); err == nil {
+		t.Fatal("Verify() accepted a block of other content")
+	}
+}
@@ -1,14 +1,34 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/theblitlabs/deviceid"
 )
 
-var manager *deviceid.Manager
+// DeviceIDFileName pins the device ID a runner was migrated to this host
+// with, which takes precedence over the one derived for the host
+const DeviceIDFileName = "device_id"
+
+var (
+	// managerMu serializes deriving the device ID, which concurrent tasks
+	// do as they report
+	managerMu sync.Mutex
+	manager   *deviceid.Manager
+)
 
 func GetDeviceID() (string, error) {
+	if pinned, err := PinnedDeviceID(); err != nil || pinned != "" {
+		return pinned, err
+	}
+
+	managerMu.Lock()
+	defer managerMu.Unlock()
 	if manager == nil {
 		manager = deviceid.NewManager(deviceid.Config{})
 	}
@@ -20,3 +40,20 @@ func GetDeviceID() (string, error) {
 
 	return deviceID, nil
 }
+
+// PinnedDeviceID returns the device ID pinned in the runner's data
+// directory, empty when none is
+func PinnedDeviceID() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	data, err := os.ReadFile(filepath.Join(homeDir, KeystoreDirName, DeviceIDFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read pinned device ID: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
@@ -10,6 +10,8 @@ import (
 	"time"
 
 	"github.com/theblitlabs/gologger"
+
+	"github.com/theblitlabs/parity-runner/internal/dualstack"
 )
 
 var (
@@ -19,21 +21,29 @@ var (
 )
 
 func init() {
+	transport := dualstack.Transport()
+	transport.DisableKeepAlives = true
+	transport.IdleConnTimeout = 1 * time.Second
 	httpClient = &http.Client{
-		Timeout: 2 * time.Second,
-		Transport: &http.Transport{
-			DisableKeepAlives: true,
-			IdleConnTimeout:   1 * time.Second,
-		},
+		Timeout:   2 * time.Second,
+		Transport: transport,
 	}
 }
 
 func GetPublicIP() (string, error) {
+	// The services answer with the address the request came from, so a
+	// host without IPv4 asks services reached over IPv6
 	services := []string{
 		"https://api.ipify.org",
 		"https://checkip.amazonaws.com",
 		"https://ipv4.icanhazip.com",
 	}
+	if !hasGlobalAddress(true) && hasGlobalAddress(false) {
+		services = []string{
+			"https://api6.ipify.org",
+			"https://ipv6.icanhazip.com",
+		}
+	}
 
 	resultChan := make(chan string, len(services))
 	errorChan := make(chan error, len(services))
@@ -156,6 +166,13 @@ func CheckIPChanged() (string, bool, error) {
 }
 
 func hasNetworkConnectivity() bool {
+	return hasGlobalAddress(true) || hasGlobalAddress(false)
+}
+
+// hasGlobalAddress reports whether an interface that is up has a routable
+// IPv4 address, or IPv6 address when ipv4 is false. Link-local IPv6
+// addresses, which every IPv6 interface has, do not count.
+func hasGlobalAddress(ipv4 bool) bool {
 	ifaces, err := net.Interfaces()
 	if err != nil {
 		return false
@@ -169,7 +186,11 @@ func hasNetworkConnectivity() bool {
 			}
 
 			for _, addr := range addrs {
-				if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+				ipnet, ok := addr.(*net.IPNet)
+				if !ok || (ipnet.IP.To4() != nil) != ipv4 {
+					continue
+				}
+				if ipv4 && !ipnet.IP.IsLoopback() || !ipv4 && ipnet.IP.IsGlobalUnicast() {
 					return true
 				}
 			}